@@ -0,0 +1,296 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// DiskCodec converts the keys and values of a DiskSpilloverCache to
+// and from bytes.
+type DiskCodec interface {
+	// EncodeKey returns the on-disk form of key.  Equal keys must
+	// have equal encodings.
+	EncodeKey(key Measurable) ([]byte, error)
+	// EncodeValue returns the on-disk form of data.
+	EncodeValue(data Measurable) ([]byte, error)
+	// DecodeValue is the inverse of EncodeValue.
+	DecodeValue(buf []byte) (Measurable, error)
+}
+
+// diskSpilloverSeqLen is the length of the sequence number stored
+// before each on-disk value.
+const diskSpilloverSeqLen = 8
+
+var diskSpilloverOptions = &opt.Options{
+	Compression:            opt.NoCompression,
+	OpenFilesCacheCapacity: 10,
+}
+
+type diskSpilloverEntry struct {
+	key  string
+	size int
+	seq  uint64
+}
+
+type diskSpilloverEntriesNewestFirst []*diskSpilloverEntry
+
+func (es diskSpilloverEntriesNewestFirst) Len() int {
+	return len(es)
+}
+
+func (es diskSpilloverEntriesNewestFirst) Less(i, j int) bool {
+	return es[i].seq > es[j].seq
+}
+
+func (es diskSpilloverEntriesNewestFirst) Swap(i, j int) {
+	es[i], es[j] = es[j], es[i]
+}
+
+// DiskSpilloverCache is a Cache with an LRU memory front and a
+// bounded LRU disk back.  Entries are written through to disk when
+// they're added, so entries that get evicted from memory can still be
+// found on disk, including after a restart.
+//
+// The disk back is a leveldb database, with each value prefixed by a
+// sequence number that records the order in which entries were
+// added.  There's no separate index file that could get out of sync
+// after a crash; instead, the in-memory index of the disk entries is
+// rebuilt from the database on open.  Disk hits only bump an entry's
+// recency in memory, so after a restart entries are evicted in the
+// order they were added.
+//
+// Since the Cache interface has no way to report errors, disk errors
+// on Add just leave the entry out of the disk back, and disk errors
+// on Get are treated as misses.
+type DiskSpilloverCache struct {
+	mem       Cache
+	codec     DiskCodec
+	diskBytes int
+
+	mu       sync.Mutex
+	db       *leveldb.DB // nil once closed
+	lru      *list.List  // of *diskSpilloverEntry, most recent first
+	index    map[string]*list.Element
+	usedSize int
+	nextSeq  uint64
+}
+
+var _ Cache = (*DiskSpilloverCache)(nil)
+
+// NewDiskSpilloverCache returns a DiskSpilloverCache that keeps up to
+// memBytes bytes of entries in memory (see NewLRUEvictedCache), and
+// up to diskBytes bytes of encoded keys and values in a leveldb
+// database in stor.  Any entries already in stor are loaded into the
+// disk index (but not into memory), and evicted right away if they
+// don't fit in diskBytes.
+func NewDiskSpilloverCache(memBytes, diskBytes int,
+	stor storage.Storage, codec DiskCodec) (*DiskSpilloverCache, error) {
+	db, err := leveldb.Open(stor, diskSpilloverOptions)
+	if err != nil {
+		return nil, err
+	}
+	c := &DiskSpilloverCache{
+		mem:       NewLRUEvictedCache(memBytes),
+		codec:     codec,
+		diskBytes: diskBytes,
+		db:        db,
+		lru:       list.New(),
+		index:     make(map[string]*list.Element),
+	}
+	err = c.loadIndex()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadIndex rebuilds the index of disk entries from the database.
+func (c *DiskSpilloverCache) loadIndex() error {
+	var entries []*diskSpilloverEntry
+	var corrupt [][]byte
+	iter := c.db.NewIterator(nil, nil)
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if len(value) < diskSpilloverSeqLen {
+			corrupt = append(corrupt, append([]byte(nil), key...))
+			continue
+		}
+		entries = append(entries, &diskSpilloverEntry{
+			key:  string(key),
+			size: len(key) + len(value),
+			seq:  binary.BigEndian.Uint64(value),
+		})
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	// Oldest last, so that the LRU list ends up most recent first.
+	sort.Sort(diskSpilloverEntriesNewestFirst(entries))
+	for _, e := range entries {
+		c.index[e.key] = c.lru.PushBack(e)
+		c.usedSize += e.size
+		if e.seq >= c.nextSeq {
+			c.nextSeq = e.seq + 1
+		}
+	}
+
+	batch := new(leveldb.Batch)
+	for _, key := range corrupt {
+		batch.Delete(key)
+	}
+	c.evictLocked(batch)
+	return c.db.Write(batch, nil)
+}
+
+// evictLocked adds deletions to batch for the least recently used
+// disk entries until the rest fit in c.diskBytes.
+func (c *DiskSpilloverCache) evictLocked(batch *leveldb.Batch) {
+	for c.usedSize > c.diskBytes {
+		elem := c.lru.Back()
+		e := elem.Value.(*diskSpilloverEntry)
+		c.lru.Remove(elem)
+		delete(c.index, e.key)
+		c.usedSize -= e.size
+		batch.Delete([]byte(e.key))
+	}
+}
+
+func (c *DiskSpilloverCache) getFromDisk(key Measurable) (
+	data Measurable, ok bool) {
+	keyBuf, err := c.codec.EncodeKey(key)
+	if err != nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return nil, false
+	}
+	elem, ok := c.index[string(keyBuf)]
+	if !ok {
+		return nil, false
+	}
+	value, err := c.db.Get(keyBuf, nil)
+	if err != nil {
+		return nil, false
+	}
+	data, err = c.codec.DecodeValue(value[diskSpilloverSeqLen:])
+	if err != nil {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return data, true
+}
+
+// Get implements the Cache interface for DiskSpilloverCache.  Entries
+// found only on disk are added back to memory.
+func (c *DiskSpilloverCache) Get(key Measurable) (data Measurable, ok bool) {
+	if data, ok := c.mem.Get(key); ok {
+		return data, true
+	}
+	data, ok = c.getFromDisk(key)
+	if !ok {
+		return nil, false
+	}
+	c.mem.Add(key, data)
+	return data, true
+}
+
+func (c *DiskSpilloverCache) addToDisk(key Measurable, data Measurable) {
+	keyBuf, err := c.codec.EncodeKey(key)
+	if err != nil {
+		return
+	}
+	valueBuf, err := c.codec.EncodeValue(data)
+	if err != nil {
+		return
+	}
+	keyStr := string(keyBuf)
+	size := len(keyBuf) + diskSpilloverSeqLen + len(valueBuf)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return
+	}
+
+	batch := new(leveldb.Batch)
+	if elem, ok := c.index[keyStr]; ok {
+		c.usedSize -= elem.Value.(*diskSpilloverEntry).size
+		c.lru.Remove(elem)
+		delete(c.index, keyStr)
+	}
+	if size <= c.diskBytes {
+		value := make([]byte, diskSpilloverSeqLen+len(valueBuf))
+		binary.BigEndian.PutUint64(value, c.nextSeq)
+		copy(value[diskSpilloverSeqLen:], valueBuf)
+		batch.Put(keyBuf, value)
+		c.index[keyStr] = c.lru.PushFront(&diskSpilloverEntry{
+			key:  keyStr,
+			size: size,
+			seq:  c.nextSeq,
+		})
+		c.usedSize += size
+		c.nextSeq++
+	} else {
+		// Too big to keep; just make sure no stale value is left.
+		batch.Delete(keyBuf)
+	}
+	c.evictLocked(batch)
+
+	err = c.db.Write(batch, nil)
+	if err != nil {
+		// We don't know which part of the batch made it, so drop
+		// the entry from the index and let a future load sort it
+		// out.
+		if elem, ok := c.index[keyStr]; ok {
+			c.usedSize -= elem.Value.(*diskSpilloverEntry).size
+			c.lru.Remove(elem)
+			delete(c.index, keyStr)
+		}
+	}
+}
+
+// Add implements the Cache interface for DiskSpilloverCache.
+func (c *DiskSpilloverCache) Add(key Measurable, data Measurable) {
+	c.mem.Add(key, data)
+	c.addToDisk(key, data)
+}
+
+// DiskUsage returns the number of bytes of encoded keys and values
+// (including per-entry overhead) currently in the disk back.
+func (c *DiskSpilloverCache) DiskUsage() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usedSize
+}
+
+// Close closes the disk back, but not the underlying storage.  After
+// Close, the cache keeps working from memory only.
+func (c *DiskSpilloverCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return nil
+	}
+	err := c.db.Close()
+	c.db = nil
+	c.lru.Init()
+	c.index = make(map[string]*list.Element)
+	c.usedSize = 0
+	return err
+}
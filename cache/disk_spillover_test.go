@@ -0,0 +1,150 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type testDiskValue string
+
+func (v testDiskValue) Size() int {
+	return len(v)
+}
+
+type testDiskCodec struct{}
+
+func (testDiskCodec) EncodeKey(key Measurable) ([]byte, error) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(key.(testKey)))
+	return buf[:], nil
+}
+
+func (testDiskCodec) EncodeValue(data Measurable) ([]byte, error) {
+	v, ok := data.(testDiskValue)
+	if !ok {
+		return nil, errors.New("unexpected value type")
+	}
+	return []byte(v), nil
+}
+
+func (testDiskCodec) DecodeValue(buf []byte) (Measurable, error) {
+	return testDiskValue(buf), nil
+}
+
+// Each test entry takes up 8 (key) + 8 (seq) + 10 (value) = 26 bytes
+// on disk, and 8 + 10 = 18 bytes in memory.
+const testDiskEntrySize = 26
+
+func testDiskValueFor(i int) testDiskValue {
+	return testDiskValue([]byte{
+		'v', 'a', 'l', 'u', 'e', '-',
+		byte('0' + i/100%10), byte('0' + i/10%10), byte('0' + i%10), '!'})
+}
+
+func TestDiskSpilloverCacheSpill(t *testing.T) {
+	stor := storage.NewMemStorage()
+	// Room for one entry in memory, and three on disk.
+	c, err := NewDiskSpilloverCache(
+		20, 3*testDiskEntrySize, stor, testDiskCodec{})
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 4; i++ {
+		c.Add(testKey(i), testDiskValueFor(i))
+	}
+	require.Equal(t, 3*testDiskEntrySize, c.DiskUsage())
+
+	// Key 0 was evicted from both memory and disk.
+	_, ok := c.Get(testKey(0))
+	require.False(t, ok)
+	// Keys 1 and 2 only made it through on disk.
+	for i := 1; i < 4; i++ {
+		data, ok := c.Get(testKey(i))
+		require.True(t, ok)
+		require.Equal(t, testDiskValueFor(i), data)
+	}
+
+	// Reading key 1 made it more recent than key 2, so key 2 gets
+	// evicted next.
+	_, ok = c.Get(testKey(1))
+	require.True(t, ok)
+	c.Add(testKey(4), testDiskValueFor(4))
+	_, ok = c.getFromDisk(testKey(2))
+	require.False(t, ok)
+	_, ok = c.getFromDisk(testKey(1))
+	require.True(t, ok)
+
+	// Replacing an entry doesn't double-count it.
+	c.Add(testKey(4), testDiskValueFor(40))
+	require.Equal(t, 3*testDiskEntrySize, c.DiskUsage())
+	data, ok := c.Get(testKey(4))
+	require.True(t, ok)
+	require.Equal(t, testDiskValueFor(40), data)
+}
+
+func TestDiskSpilloverCacheReopen(t *testing.T) {
+	// Memory storage can't be reopened, so use a real directory.
+	dir, err := ioutil.TempDir("", "disk_spillover")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stor, err := storage.OpenFile(dir, false)
+	require.NoError(t, err)
+	defer stor.Close()
+
+	c, err := NewDiskSpilloverCache(
+		100, 10*testDiskEntrySize, stor, testDiskCodec{})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		c.Add(testKey(i), testDiskValueFor(i))
+	}
+	err = c.Close()
+	require.NoError(t, err)
+
+	// Reopening with a smaller disk limit keeps only the newest
+	// entries.
+	c, err = NewDiskSpilloverCache(
+		100, 3*testDiskEntrySize, stor, testDiskCodec{})
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, 3*testDiskEntrySize, c.DiskUsage())
+	for i := 0; i < 5; i++ {
+		data, ok := c.Get(testKey(i))
+		if i < 2 {
+			require.False(t, ok)
+			continue
+		}
+		require.True(t, ok)
+		require.Equal(t, testDiskValueFor(i), data)
+	}
+
+	// New entries are ordered after the reloaded ones.
+	c.Add(testKey(5), testDiskValueFor(5))
+	_, ok := c.getFromDisk(testKey(5))
+	require.True(t, ok)
+	require.Equal(t, 3*testDiskEntrySize, c.DiskUsage())
+}
+
+func TestDiskSpilloverCacheTooBig(t *testing.T) {
+	stor := storage.NewMemStorage()
+	c, err := NewDiskSpilloverCache(
+		100, testDiskEntrySize-1, stor, testDiskCodec{})
+	require.NoError(t, err)
+	defer c.Close()
+
+	// Too big for the disk back, but it still fits in memory.
+	c.Add(testKey(1), testDiskValueFor(1))
+	require.Equal(t, 0, c.DiskUsage())
+	data, ok := c.Get(testKey(1))
+	require.True(t, ok)
+	require.Equal(t, testDiskValueFor(1), data)
+}
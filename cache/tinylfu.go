@@ -0,0 +1,259 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"math"
+	"sync"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+const (
+	// tinyLFUDepth is the number of rows in the count-min sketch.
+	tinyLFUDepth = 4
+	// tinyLFUMaxCount is the value at which counters saturate.  The
+	// original paper uses 4-bit counters.
+	tinyLFUMaxCount = 15
+	// tinyLFUCountersPerEntry is roughly how many counters each
+	// sketch row has per expected cache entry, to keep collisions
+	// with the hot entries rare.
+	tinyLFUCountersPerEntry = 4
+	// tinyLFUSampleFactor times the expected number of cache entries
+	// is the number of increments after which all counters are
+	// halved, so that old popularity fades away.
+	tinyLFUSampleFactor = 10
+)
+
+// TinyLFU is the TinyLFU cache admission policy described in "TinyLFU:
+// A Highly Efficient Cache Admission Policy" (Einziger, Friedman and
+// Manes).  It keeps an approximate, aging count of how often each key
+// has recently been accessed in a count-min sketch, and only admits a
+// new entry into a full cache if that entry has been accessed more
+// often than the entry it would evict.  This keeps one-off accesses,
+// like those from a long scan, from pushing out the hot working set.
+//
+// TinyLFU works on 64-bit key hashes, which should be uniformly
+// distributed.  It is goroutine-safe.
+type TinyLFU struct {
+	mu        sync.Mutex
+	rows      [tinyLFUDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// NewTinyLFU returns a TinyLFU sized for a cache that holds about
+// numEntries entries.
+func NewTinyLFU(numEntries int) *TinyLFU {
+	if numEntries < 1 {
+		numEntries = 1
+	}
+	width := 1
+	for width < tinyLFUCountersPerEntry*numEntries {
+		width <<= 1
+	}
+	t := &TinyLFU{
+		mask:    uint64(width - 1),
+		resetAt: tinyLFUSampleFactor * numEntries,
+	}
+	for i := range t.rows {
+		t.rows[i] = make([]uint8, width)
+	}
+	return t
+}
+
+// spreadHash mixes all the bits of h into its low bits (using the
+// murmur3 finalizer), so that callers with weak hashes still get
+// well-spread counters.
+func spreadHash(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (t *TinyLFU) index(h uint64, row int) uint64 {
+	// Derive the per-row indices from two halves of the hash
+	// (Kirsch-Mitzenmacher), making the step odd so the rows
+	// differ.
+	step := (h>>32 | h<<32) | 1
+	return (h + uint64(row)*step) & t.mask
+}
+
+// Increment records an access of the key with hash h.
+func (t *TinyLFU) Increment(h uint64) {
+	h = spreadHash(h)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.rows {
+		c := &t.rows[i][t.index(h, i)]
+		if *c < tinyLFUMaxCount {
+			*c++
+		}
+	}
+	t.additions++
+	if t.additions >= t.resetAt {
+		t.resetLocked()
+	}
+}
+
+func (t *TinyLFU) resetLocked() {
+	for i := range t.rows {
+		for j := range t.rows[i] {
+			t.rows[i][j] >>= 1
+		}
+	}
+	t.additions /= 2
+}
+
+// Estimate returns the approximate number of recent accesses of the
+// key with hash h.
+func (t *TinyLFU) Estimate(h uint64) int {
+	h = spreadHash(h)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	min := uint8(math.MaxUint8)
+	for i := range t.rows {
+		if c := t.rows[i][t.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return int(min)
+}
+
+// Admit returns whether the key with hash candidate should replace
+// the key with hash victim in a full cache.
+func (t *TinyLFU) Admit(candidate, victim uint64) bool {
+	return t.Estimate(candidate) > t.Estimate(victim)
+}
+
+// tinyLFUWindowPercent is the share of a windowed TinyLFU cache's
+// bytes given to the admission window.
+const tinyLFUWindowPercent = 1
+
+// tinyLFUEvictedCache implements the W-TinyLFU policy: new entries go
+// into a small LRU window, and entries evicted from the window only
+// make it into the main LRU if TinyLFU prefers them over the main
+// LRU's eviction victim.  The window lets bursts of new entries get a
+// chance to build up frequency before they have to compete.
+type tinyLFUEvictedCache struct {
+	windowMaxBytes int
+	mainMaxBytes   int
+	hashKey        func(Measurable) uint64
+
+	mu          sync.Mutex
+	window      *simplelru.LRU // not goroutine-safe; protected by mu
+	main        *simplelru.LRU // not goroutine-safe; protected by mu
+	windowBytes int
+	mainBytes   int
+	sketch      *TinyLFU
+}
+
+// NewWindowedTinyLFUCache returns a Cache that uses the W-TinyLFU
+// eviction strategy.  The cache will have a capacity of maxBytes
+// bytes, and expects to hold about numEntries entries. hashKey must
+// return a uniformly-distributed hash of a key.  A zero-byte capacity
+// cache is valid.
+//
+// Like the LRU cache, entry sizes are memoized once they're added.
+func NewWindowedTinyLFUCache(maxBytes int, numEntries int,
+	hashKey func(Measurable) uint64) Cache {
+	windowMaxBytes := maxBytes * tinyLFUWindowPercent / 100
+	// Entries are limited by bytes, not count.
+	window, _ := simplelru.NewLRU(math.MaxInt32, nil)
+	main, _ := simplelru.NewLRU(math.MaxInt32, nil)
+	return &tinyLFUEvictedCache{
+		windowMaxBytes: windowMaxBytes,
+		mainMaxBytes:   maxBytes - windowMaxBytes,
+		hashKey:        hashKey,
+		window:         window,
+		main:           main,
+		sketch:         NewTinyLFU(numEntries),
+	}
+}
+
+func entryBytes(key Measurable, value interface{}) int {
+	return key.Size() + value.(memoizedMeasurable).Size()
+}
+
+// Get implements the Cache interface.
+func (c *tinyLFUEvictedCache) Get(key Measurable) (data Measurable, ok bool) {
+	c.sketch.Increment(c.hashKey(key))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.window.Get(key)
+	if !ok {
+		v, ok = c.main.Get(key)
+		if !ok {
+			return nil, false
+		}
+	}
+	return v.(memoizedMeasurable).m, true
+}
+
+// Add implements the Cache interface.
+func (c *tinyLFUEvictedCache) Add(key Measurable, data Measurable) {
+	memoized := memoizedMeasurable{m: data}
+	size := key.Size() + memoized.Size()
+	if size > c.windowMaxBytes+c.mainMaxBytes {
+		return
+	}
+	c.sketch.Increment(c.hashKey(key))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Replace existing entries in place.
+	if v, ok := c.main.Peek(key); ok {
+		c.main.Remove(key)
+		c.mainBytes -= entryBytes(key, v)
+		c.admitToMainLocked(key, memoized, size)
+		return
+	}
+	if v, ok := c.window.Peek(key); ok {
+		c.window.Remove(key)
+		c.windowBytes -= entryBytes(key, v)
+	}
+
+	c.window.Add(key, memoized)
+	c.windowBytes += size
+	for c.windowBytes > c.windowMaxBytes {
+		k, v, ok := c.window.RemoveOldest()
+		if !ok {
+			break
+		}
+		candidate := k.(Measurable)
+		candidateSize := entryBytes(candidate, v)
+		c.windowBytes -= candidateSize
+		c.admitToMainLocked(candidate, v.(memoizedMeasurable), candidateSize)
+	}
+}
+
+// admitToMainLocked adds the given entry to the main LRU if TinyLFU
+// prefers it over every entry that would need to be evicted to make
+// room for it.
+func (c *tinyLFUEvictedCache) admitToMainLocked(
+	key Measurable, value memoizedMeasurable, size int) {
+	if size > c.mainMaxBytes {
+		return
+	}
+	candidate := c.hashKey(key)
+	for c.mainBytes+size > c.mainMaxBytes {
+		k, v, ok := c.main.GetOldest()
+		if !ok {
+			break
+		}
+		victim := k.(Measurable)
+		if !c.sketch.Admit(candidate, c.hashKey(victim)) {
+			return
+		}
+		c.main.Remove(victim)
+		c.mainBytes -= entryBytes(victim, v)
+	}
+	c.main.Add(key, value)
+	c.mainBytes += size
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testKey uint64
+
+func (k testKey) Size() int {
+	return 8
+}
+
+type testValue struct{}
+
+func (testValue) Size() int {
+	return 0
+}
+
+func hashTestKey(key Measurable) uint64 {
+	// Spread the small test keys out over the hash space.
+	return uint64(key.(testKey)) * 0x9e3779b97f4a7c15
+}
+
+func TestTinyLFUEstimate(t *testing.T) {
+	lfu := NewTinyLFU(100)
+	for i := 0; i < 5; i++ {
+		lfu.Increment(hashTestKey(testKey(1)))
+	}
+	lfu.Increment(hashTestKey(testKey(2)))
+
+	require.Equal(t, 5, lfu.Estimate(hashTestKey(testKey(1))))
+	require.Equal(t, 1, lfu.Estimate(hashTestKey(testKey(2))))
+	require.True(t, lfu.Admit(
+		hashTestKey(testKey(1)), hashTestKey(testKey(2))))
+	require.False(t, lfu.Admit(
+		hashTestKey(testKey(2)), hashTestKey(testKey(1))))
+}
+
+func TestTinyLFUAging(t *testing.T) {
+	lfu := NewTinyLFU(1)
+	h := hashTestKey(testKey(1))
+	for i := 0; i < tinyLFUSampleFactor; i++ {
+		lfu.Increment(h)
+	}
+	// The counters have been halved once by now.
+	require.Equal(t, tinyLFUSampleFactor/2, lfu.Estimate(h))
+}
+
+func TestWindowedTinyLFUCacheResistsScan(t *testing.T) {
+	// Room for 100 main entries and 1 window entry.
+	c := NewWindowedTinyLFUCache(808, 101, hashTestKey)
+
+	// Build up a hot working set.
+	for k := testKey(0); k < 50; k++ {
+		c.Add(k, testValue{})
+	}
+	for i := 0; i < 10; i++ {
+		for k := testKey(0); k < 50; k++ {
+			_, ok := c.Get(k)
+			require.True(t, ok)
+		}
+	}
+
+	// Scan through more keys than fit in the cache.
+	for k := testKey(1000); k < 1300; k++ {
+		c.Add(k, testValue{})
+	}
+
+	for k := testKey(0); k < 50; k++ {
+		_, ok := c.Get(k)
+		require.True(t, ok, "key %d was evicted", k)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package client lets Go programs use KBFS directly, without a mount.
+//
+// It's the supported way to embed KBFS: the API of this package
+// follows semantic versioning, unlike the libkbfs and libfs packages
+// it wraps, which change whenever KBFS needs them to.  A Client
+// talks to the local Keybase service for the logged-in user's keys,
+// and to the KBFS servers for data, just like the KBFS daemon does.
+//
+//	c, err := client.New(ctx, client.Options{})
+//	...
+//	defer c.Close(ctx)
+//	fs, err := c.Open(ctx, "/keybase/private/alice/notes")
+//	...
+//	err = util.WriteFile(fs, "todo.txt", data, 0600)
+//	...
+//	err = fs.Sync(ctx)
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/metricsutil"
+	"github.com/pkg/errors"
+)
+
+// Mode says how much of KBFS a Client runs.
+type Mode int
+
+const (
+	// ModeSingleOp runs just enough of KBFS to do operations on
+	// demand, writing straight to the servers on Sync.  It's safe
+	// to use while the Keybase app is running, but an open FS
+	// doesn't see changes that other devices make after it's opened.
+	ModeSingleOp Mode = iota
+	// ModeFull runs all of KBFS, including live updates and a
+	// local write journal.  Only one KBFS process per user should run
+	// this way, so it's for programs that run instead of the
+	// Keybase app's KBFS, e.g. on servers.
+	ModeFull
+)
+
+// Options configure a Client.  The zero value is usable.
+type Options struct {
+	Mode Mode
+	// StorageRoot is the directory that holds the journal and
+	// caches, which must not be shared with any other KBFS process.
+	// If empty, a temporary directory is used, and removed by Close.
+	StorageRoot string
+	// Debug turns on debug logging.
+	Debug bool
+	// LogFile, if set, is where logs are written instead of stderr.
+	LogFile string
+	// BackupTarget tunes KBFS for write-once workloads, like serving
+	// as a Time Machine or restic target.
+	BackupTarget bool
+	// Metrics, if set, is called periodically with the values of
+	// all KBFS metrics, keyed by metric name and then by field
+	// (e.g., "count" or "mean"), to feed them into other monitoring.
+	Metrics func(t time.Time, values map[string]map[string]interface{}) error
+}
+
+// ErrNotLoggedIn is returned by CurrentUser when nobody is logged in
+// to the local Keybase service.
+var ErrNotLoggedIn = errors.New("Not logged in to Keybase")
+
+// Client is a connection to KBFS for the user logged in to the local
+// Keybase service.  It's safe for concurrent use.
+type Client struct {
+	config libkbfs.Config
+	log    logger.Logger
+	// tempDir is removed on Close, if set.
+	tempDir string
+}
+
+// New starts KBFS in this process.  Close must be called when the
+// Client is no longer needed.
+func New(ctx context.Context, options Options) (c *Client, err error) {
+	kbCtx := env.NewContext()
+	params := libkbfs.DefaultInitParams(kbCtx)
+	params.Debug = options.Debug
+	params.LogFileConfig.Path = options.LogFile
+	params.BackupTarget = options.BackupTarget
+	if options.Metrics != nil {
+		params.MetricsSinks = append(params.MetricsSinks, metricsutil.SinkFunc(
+			func(t time.Time, snapshot metricsutil.Snapshot) error {
+				return options.Metrics(t, snapshot)
+			}))
+	}
+	switch options.Mode {
+	case ModeSingleOp:
+		params.Mode = libkbfs.InitSingleOpString
+		params.EnableJournal = false
+		params.DiskCacheMode = libkbfs.DiskCacheModeRemote
+	case ModeFull:
+		params.Mode = libkbfs.InitDefaultString
+	default:
+		return nil, errors.Errorf("Unknown mode %d", options.Mode)
+	}
+
+	var tempDir string
+	if options.StorageRoot != "" {
+		params.StorageRoot = options.StorageRoot
+	} else {
+		tempDir, err = ioutil.TempDir(kbCtx.GetDataDir(), "kbfsclient")
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				os.RemoveAll(tempDir)
+			}
+		}()
+		params.StorageRoot = tempDir
+	}
+
+	log, err := libkbfs.InitLogWithPrefix(params, kbCtx, "kbfsclient", "")
+	if err != nil {
+		return nil, err
+	}
+	config, err := libkbfs.InitWithoutSignalHandling(
+		ctx, kbCtx, params, nil, log, "kbfsclient")
+	if err != nil {
+		return nil, err
+	}
+	return newClient(config, tempDir), nil
+}
+
+func newClient(config libkbfs.Config, tempDir string) *Client {
+	return &Client{
+		config:  config,
+		log:     config.MakeLogger("CLIENT"),
+		tempDir: tempDir,
+	}
+}
+
+// Close shuts down KBFS, waiting for any writes that haven't been
+// synced to be flushed.
+func (c *Client) Close(ctx context.Context) error {
+	err := c.config.Shutdown(ctx)
+	if c.tempDir != "" {
+		if rmErr := os.RemoveAll(c.tempDir); err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// Config returns the libkbfs config behind the Client, for features
+// this package doesn't cover.  It isn't covered by this package's
+// compatibility promise.
+func (c *Client) Config() libkbfs.Config {
+	return c.config
+}
+
+// CurrentUser returns the username of the logged-in user, or
+// ErrNotLoggedIn.
+func (c *Client) CurrentUser(ctx context.Context) (string, error) {
+	session, err := c.config.KBPKI().GetCurrentSession(ctx)
+	if _, ok := errors.Cause(err).(libkbfs.NoCurrentSessionError); ok {
+		return "", ErrNotLoggedIn
+	} else if err != nil {
+		return "", err
+	}
+	return session.Name.String(), nil
+}
+
+// openError converts the errors from looking up a TLF into the
+// standard ones for files.
+func openError(p string, err error) error {
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError, libkbfs.NoSuchUserError,
+		libkbfs.NoSuchTeamError:
+		return &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	case libkbfs.ReadAccessError, libkbfs.TlfAccessError:
+		return &os.PathError{Op: "open", Path: p, Err: os.ErrPermission}
+	default:
+		return err
+	}
+}
+
+// Open returns the filesystem rooted at `p`, which is a TLF or a
+// directory within one, like "/keybase/private/alice/notes" or
+// "team/acme"; the leading "/keybase" is optional.  The directory
+// must exist, although the TLF itself is created if the user is
+// allowed to.
+func (c *Client) Open(ctx context.Context, p string) (*FS, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if clean == "keybase" || strings.HasPrefix(clean, "keybase/") {
+		clean = strings.TrimPrefix(strings.TrimPrefix(clean, "keybase"), "/")
+	}
+	parts := strings.SplitN(clean, "/", 3)
+	if len(parts) < 2 {
+		return nil, errors.Errorf("%q isn't in a TLF", p)
+	}
+	tlfType, tlfName, err := libfs.ParseTlfPath(parts[0] + "/" + parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var subdir string
+	if len(parts) == 3 {
+		subdir = parts[2]
+	}
+
+	// The FS outlives `ctx`, so its operations get their own.
+	fsCtx := libkbfs.CtxWithRandomIDReplayable(
+		context.Background(), ctxIDKey, ctxOpID, c.log)
+	h, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, c.config.KBPKI(), c.config.MDOps(), tlfName, tlfType)
+	if err != nil {
+		return nil, openError(p, err)
+	}
+	fs, err := libfs.NewFS(
+		fsCtx, c.config, h, subdir, "", keybase1.MDPriorityNormal)
+	if err != nil {
+		return nil, openError(p, err)
+	}
+	return &FS{Filesystem: fs, fs: fs, log: c.log}, nil
+}
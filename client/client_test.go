@@ -0,0 +1,54 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+func TestClient(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBustLoggedInWithMode(
+		t, 0, libkbfs.InitSingleOp, "alice", "bob")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	c := newClient(config, "")
+
+	user, err := c.CurrentUser(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "alice", user)
+
+	t.Log("Write through a subdirectory of a TLF")
+	fs, err := c.Open(ctx, "/keybase/private/alice,bob")
+	require.NoError(t, err)
+	err = fs.MkdirAll("notes", 0700)
+	require.NoError(t, err)
+	sub, err := c.Open(ctx, "private/alice,bob/notes")
+	require.NoError(t, err)
+	err = util.WriteFile(sub, "todo.txt", []byte("hello"), 0600)
+	require.NoError(t, err)
+	err = sub.Sync(ctx)
+	require.NoError(t, err)
+
+	f, err := fs.Open("notes/todo.txt")
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	t.Log("Bad paths")
+	_, err = c.Open(ctx, "/keybase/private")
+	require.Error(t, err)
+	_, err = c.Open(ctx, "/keybase/private/alice,bob/missing")
+	require.True(t, os.IsNotExist(err), "%+v", err)
+	_, err = c.Open(ctx, "/keybase/private/alice,nobody")
+	require.True(t, os.IsNotExist(err), "%+v", err)
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// Debug tag ID for the operations of an FS.
+const ctxOpID = "CLIENT"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+// FS is a directory in KBFS, as a billy.Filesystem.  It also
+// implements billy.Change, for setting modes and times.  Writes are
+// buffered until Sync is called, or the Client is closed.
+type FS struct {
+	billy.Filesystem
+	fs  *libfs.FS
+	log logger.Logger
+}
+
+var _ billy.Change = (*FS)(nil)
+
+// Chmod implements the billy.Change interface for FS.  Only the
+// executable bits of files are stored.
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	return f.fs.Chmod(name, mode)
+}
+
+// Lchown implements the billy.Change interface for FS.  KBFS doesn't
+// store owners, so this does nothing.
+func (f *FS) Lchown(name string, uid, gid int) error {
+	return f.fs.Lchown(name, uid, gid)
+}
+
+// Chown implements the billy.Change interface for FS.  KBFS doesn't
+// store owners, so this does nothing.
+func (f *FS) Chown(name string, uid, gid int) error {
+	return f.fs.Chown(name, uid, gid)
+}
+
+// Chtimes implements the billy.Change interface for FS.  Only
+// modification times are stored.
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return f.fs.Chtimes(name, atime, mtime)
+}
+
+// Sync writes everything written through the FS so far to the KBFS
+// servers, and waits for it to get there.
+func (f *FS) Sync(ctx context.Context) error {
+	if err := f.fs.WithContext(ctx).SyncAll(); err != nil {
+		return err
+	}
+	return libkbfs.WaitForTLFJournal(ctx, f.fs.Config(),
+		f.fs.RootNode().GetFolderBranch().Tlf, f.log)
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package fsrpc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// CompleteArg is the argument to FS.Complete.
+type CompleteArg struct {
+	SessionID int    `codec:"sessionID" json:"sessionID"`
+	Prefix    string `codec:"prefix" json:"prefix"`
+}
+
+// CompleteResult is the result of FS.Complete.  Each completion is a
+// full path starting with the given prefix; directories end with a
+// trailing slash.
+type CompleteResult struct {
+	Completions []string `codec:"completions" json:"completions"`
+}
+
+// completionCandidates returns the names (and whether each is a
+// directory) of all the children of the given path.
+func (f fs) completionCandidates(ctx context.Context, p Path) (
+	map[string]bool, error) {
+	switch p.PathType {
+	case RootPathType:
+		return map[string]bool{topName: true}, nil
+	case KeybasePathType:
+		return map[string]bool{publicName: true, privateName: true}, nil
+	case KeybaseChildPathType:
+		favs, err := f.config.KBFSOps().GetFavorites(ctx)
+		if err != nil {
+			return nil, err
+		}
+		candidates := make(map[string]bool)
+		for _, fav := range favs {
+			if fav.Type == p.TLFType {
+				candidates[fav.Name] = true
+			}
+		}
+		return candidates, nil
+	case TLFPathType:
+		node, de, err := p.GetNode(ctx, f.config)
+		if err != nil {
+			return nil, err
+		}
+		if de.Type != libkbfs.Dir {
+			return nil, nil
+		}
+		// The children's entry infos come with the directory
+		// listing, so no per-child lookups are needed.
+		children, err := f.config.KBFSOps().GetDirChildren(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		candidates := make(map[string]bool, len(children))
+		for name, ei := range children {
+			candidates[name] = ei.Type == libkbfs.Dir
+		}
+		return candidates, nil
+	}
+	return nil, fmt.Errorf("invalid KBFS path %s", p)
+}
+
+func (f fs) complete(ctx context.Context, prefix string) ([]string, error) {
+	i := strings.LastIndex(prefix, "/")
+	if i < 0 {
+		return nil, InvalidPathErr{prefix}
+	}
+	dirStr, partial := prefix[:i], prefix[i+1:]
+	if dirStr == "" {
+		dirStr = "/"
+	}
+
+	dir, err := NewPath(dirStr)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := f.completionCandidates(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	completions := []string{}
+	for name, isDir := range candidates {
+		if !strings.HasPrefix(name, partial) {
+			continue
+		}
+		// Like shells, only offer hidden entries when asked.
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(partial, ".") {
+			continue
+		}
+		completion := prefix + strings.TrimPrefix(name, partial)
+		if isDir {
+			completion += "/"
+		}
+		completions = append(completions, completion)
+	}
+	sort.Strings(completions)
+	return completions, nil
+}
+
+// Complete implements the FS interface for fs.
+func (f *fs) Complete(ctx context.Context, arg CompleteArg) (
+	CompleteResult, error) {
+	f.log.CDebugf(ctx, "Completing %q", arg.Prefix)
+
+	completions, err := f.complete(ctx, arg.Prefix)
+	if err != nil {
+		f.log.CErrorf(ctx, "Error completing %q: %s", arg.Prefix, err)
+		return CompleteResult{}, err
+	}
+	return CompleteResult{Completions: completions}, nil
+}
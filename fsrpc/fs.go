@@ -13,13 +13,32 @@ import (
 	"golang.org/x/net/context"
 )
 
+// FS is the file system protocol exposed to connected clients.  It
+// extends keybase1.FsInterface with KBFS-specific calls.
+type FS interface {
+	keybase1.FsInterface
+	// Complete returns the possible completions of the given path
+	// prefix, for tab-completion in CLIs and the GUI path bar.
+	Complete(ctx context.Context, arg CompleteArg) (CompleteResult, error)
+	// StatPaths returns the entry info for each of the given paths
+	// in one call.  A failure for one path is reported in its
+	// entry, and doesn't fail the whole call.
+	StatPaths(ctx context.Context, arg StatPathsArg) (StatPathsResult, error)
+	// ListDirents lists the given directory, including the entry
+	// info of each child.
+	ListDirents(ctx context.Context, arg ListDirentsArg) (
+		ListDirentsResult, error)
+}
+
 type fs struct {
 	config libkbfs.Config
 	log    logger.Logger
 }
 
+var _ FS = (*fs)(nil)
+
 // NewFS returns a new FS protocol implementation
-func NewFS(config libkbfs.Config, log logger.Logger) keybase1.FsInterface {
+func NewFS(config libkbfs.Config, log logger.Logger) FS {
 	return &fs{config: config, log: log}
 }
 
@@ -0,0 +1,155 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package fsrpc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// protocolClient is an rpc.GenericClient that calls straight into
+// the handlers of a protocol, without any transport in between.
+type protocolClient struct {
+	p rpc.Protocol
+}
+
+func (c protocolClient) Call(ctx context.Context, method string,
+	arg interface{}, res interface{}) error {
+	m, ok := c.p.Methods[strings.TrimPrefix(method, c.p.Name+".")]
+	if !ok {
+		return errors.Errorf("Unknown method %s", method)
+	}
+	args := m.MakeArg()
+	reflect.ValueOf(args).Elem().Index(0).Set(
+		reflect.ValueOf(arg.([]interface{})[0]))
+	ret, err := m.Handler(ctx, args)
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(res).Elem().Set(reflect.ValueOf(ret))
+	return nil
+}
+
+func (c protocolClient) Notify(
+	ctx context.Context, method string, arg interface{}) error {
+	return c.Call(ctx, method, arg, nil)
+}
+
+func makeTestClient(t *testing.T) (
+	context.Context, libkbfs.Config, Client) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "user1")
+	p := Protocol(NewFS(config, config.MakeLogger("")))
+	return ctx, config, Client{keybase1.FsClient{Cli: protocolClient{p}}}
+}
+
+func makeTestFiles(
+	ctx context.Context, t *testing.T, config libkbfs.Config) {
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "dir")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(
+		ctx, rootNode, "file", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(
+		ctx, rootNode, ".hidden", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+}
+
+func TestComplete(t *testing.T) {
+	ctx, config, c := makeTestClient(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	makeTestFiles(ctx, t, config)
+
+	for _, test := range []struct {
+		prefix      string
+		completions []string
+	}{
+		{"/k", []string{"/keybase/"}},
+		{"/keybase/pr", []string{"/keybase/private/"}},
+		{"/keybase/private/u", []string{"/keybase/private/user1/"}},
+		{"/keybase/private/user1/", []string{
+			"/keybase/private/user1/dir/",
+			"/keybase/private/user1/file",
+		}},
+		{"/keybase/private/user1/.", []string{
+			"/keybase/private/user1/.hidden",
+		}},
+		{"/keybase/private/user1/x", []string{}},
+	} {
+		res, err := c.Complete(ctx, CompleteArg{Prefix: test.prefix})
+		require.NoError(t, err, test.prefix)
+		require.Equal(t, test.completions, res.Completions, test.prefix)
+	}
+
+	_, err := c.Complete(ctx, CompleteArg{Prefix: "keybase"})
+	require.Error(t, err)
+}
+
+func TestStatPathsAndListDirents(t *testing.T) {
+	ctx, config, c := makeTestClient(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	makeTestFiles(ctx, t, config)
+
+	statRes, err := c.StatPaths(ctx, StatPathsArg{Paths: []string{
+		"/keybase/private/user1/file",
+		"/keybase/private/user1/missing",
+		"/keybase/private/user1/dir",
+		"bogus",
+	}})
+	require.NoError(t, err)
+	entries := statRes.Entries
+	require.Len(t, entries, 4)
+	require.Equal(t, "/keybase/private/user1/file", entries[0].Path)
+	require.Equal(t, libkbfs.File.String(), entries[0].Type)
+	require.Empty(t, entries[0].Error)
+	require.Equal(t, "/keybase/private/user1/missing", entries[1].Path)
+	require.NotEmpty(t, entries[1].Error)
+	require.Equal(t, libkbfs.Dir.String(), entries[2].Type)
+	require.Empty(t, entries[2].Error)
+	require.Equal(t, "bogus", entries[3].Path)
+	require.NotEmpty(t, entries[3].Error)
+
+	listRes, err := c.ListDirents(
+		ctx, ListDirentsArg{Path: "/keybase/private/user1"})
+	require.NoError(t, err)
+	var paths, types []string
+	for _, e := range listRes.Entries {
+		paths = append(paths, e.Path)
+		types = append(types, e.Type)
+	}
+	require.Equal(t, []string{
+		"/keybase/private/user1/.hidden",
+		"/keybase/private/user1/dir",
+		"/keybase/private/user1/file",
+	}, paths)
+	require.Equal(t, []string{
+		libkbfs.File.String(), libkbfs.Dir.String(), libkbfs.File.String(),
+	}, types)
+
+	listRes, err = c.ListDirents(ctx, ListDirentsArg{Path: "/keybase"})
+	require.NoError(t, err)
+	require.Equal(t, []Dirent{
+		{Path: "/keybase/private", Type: libkbfs.Dir.String()},
+		{Path: "/keybase/public", Type: libkbfs.Dir.String()},
+	}, listRes.Entries)
+}
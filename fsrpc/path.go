@@ -231,11 +231,15 @@ func (p Path) Join(childName string) (childPath Path, err error) {
 		return
 
 	case TLFPathType:
+		// Copy the components, so that paths joined from the
+		// same parent don't share (and overwrite) storage.
+		components := make([]string, len(p.TLFComponents), len(p.TLFComponents)+1)
+		copy(components, p.TLFComponents)
 		childPath = Path{
 			PathType:      TLFPathType,
 			TLFType:       p.TLFType,
 			TLFName:       p.TLFName,
-			TLFComponents: append(p.TLFComponents, childName),
+			TLFComponents: append(components, childName),
 		}
 		return
 	}
@@ -0,0 +1,93 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package fsrpc
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"golang.org/x/net/context"
+)
+
+// Protocol returns the RPC protocol serving `i`: the keybase1 fs
+// protocol, extended with the KBFS-specific calls of FS.
+func Protocol(i FS) rpc.Protocol {
+	p := keybase1.FsProtocol(i)
+	p.Methods["Complete"] = rpc.ServeHandlerDescription{
+		MakeArg: func() interface{} {
+			ret := make([]CompleteArg, 1)
+			return &ret
+		},
+		Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+			typedArgs, ok := args.(*[]CompleteArg)
+			if !ok {
+				err = rpc.NewTypeError((*[]CompleteArg)(nil), args)
+				return
+			}
+			ret, err = i.Complete(ctx, (*typedArgs)[0])
+			return
+		},
+		MethodType: rpc.MethodCall,
+	}
+	p.Methods["StatPaths"] = rpc.ServeHandlerDescription{
+		MakeArg: func() interface{} {
+			ret := make([]StatPathsArg, 1)
+			return &ret
+		},
+		Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+			typedArgs, ok := args.(*[]StatPathsArg)
+			if !ok {
+				err = rpc.NewTypeError((*[]StatPathsArg)(nil), args)
+				return
+			}
+			ret, err = i.StatPaths(ctx, (*typedArgs)[0])
+			return
+		},
+		MethodType: rpc.MethodCall,
+	}
+	p.Methods["ListDirents"] = rpc.ServeHandlerDescription{
+		MakeArg: func() interface{} {
+			ret := make([]ListDirentsArg, 1)
+			return &ret
+		},
+		Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+			typedArgs, ok := args.(*[]ListDirentsArg)
+			if !ok {
+				err = rpc.NewTypeError((*[]ListDirentsArg)(nil), args)
+				return
+			}
+			ret, err = i.ListDirents(ctx, (*typedArgs)[0])
+			return
+		},
+		MethodType: rpc.MethodCall,
+	}
+	return p
+}
+
+// Client calls the FS protocol served by Protocol.
+type Client struct {
+	keybase1.FsClient
+}
+
+// Complete calls FS.Complete.
+func (c Client) Complete(ctx context.Context, arg CompleteArg) (
+	res CompleteResult, err error) {
+	err = c.Cli.Call(ctx, "keybase.1.fs.Complete", []interface{}{arg}, &res)
+	return
+}
+
+// StatPaths calls FS.StatPaths.
+func (c Client) StatPaths(ctx context.Context, arg StatPathsArg) (
+	res StatPathsResult, err error) {
+	err = c.Cli.Call(ctx, "keybase.1.fs.StatPaths", []interface{}{arg}, &res)
+	return
+}
+
+// ListDirents calls FS.ListDirents.
+func (c Client) ListDirents(ctx context.Context, arg ListDirentsArg) (
+	res ListDirentsResult, err error) {
+	err = c.Cli.Call(
+		ctx, "keybase.1.fs.ListDirents", []interface{}{arg}, &res)
+	return
+}
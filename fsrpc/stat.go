@@ -0,0 +1,175 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package fsrpc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// Dirent describes a single file system entry, as returned by
+// FS.StatPaths and FS.ListDirents.
+type Dirent struct {
+	Path    string        `codec:"path" json:"path"`
+	Type    string        `codec:"type" json:"type"`
+	Size    uint64        `codec:"size" json:"size"`
+	Mtime   keybase1.Time `codec:"mtime" json:"mtime"`
+	Ctime   keybase1.Time `codec:"ctime" json:"ctime"`
+	SymPath string        `codec:"symPath,omitempty" json:"symPath,omitempty"`
+	// Error is set instead of the fields above if the entry
+	// couldn't be looked up.
+	Error string `codec:"error,omitempty" json:"error,omitempty"`
+}
+
+// StatPathsArg is the argument to FS.StatPaths.
+type StatPathsArg struct {
+	SessionID int      `codec:"sessionID" json:"sessionID"`
+	Paths     []string `codec:"paths" json:"paths"`
+}
+
+// StatPathsResult is the result of FS.StatPaths.
+type StatPathsResult struct {
+	// Entries has one entry for each requested path, in order.
+	Entries []Dirent `codec:"entries" json:"entries"`
+}
+
+// ListDirentsArg is the argument to FS.ListDirents.
+type ListDirentsArg struct {
+	SessionID int    `codec:"sessionID" json:"sessionID"`
+	Path      string `codec:"path" json:"path"`
+}
+
+// ListDirentsResult is the result of FS.ListDirents.
+type ListDirentsResult struct {
+	// Entries is sorted by path.
+	Entries []Dirent `codec:"entries" json:"entries"`
+}
+
+// NewDirent returns the Dirent for the entry at `p` with the given
+// info.
+func NewDirent(p Path, ei libkbfs.EntryInfo) Dirent {
+	return Dirent{
+		Path:    p.String(),
+		Type:    ei.Type.String(),
+		Size:    ei.Size,
+		Mtime:   keybase1.ToTime(time.Unix(0, ei.Mtime)),
+		Ctime:   keybase1.ToTime(time.Unix(0, ei.Ctime)),
+		SymPath: ei.SymPath,
+	}
+}
+
+// statPath looks up a single path, using (and filling) `dirNodes`
+// to avoid repeated lookups of the same parent directory.
+func (f fs) statPath(ctx context.Context, p Path,
+	dirNodes map[string]libkbfs.Node) (libkbfs.EntryInfo, error) {
+	if p.PathType != TLFPathType || len(p.TLFComponents) == 0 {
+		_, ei, err := p.GetNode(ctx, f.config)
+		return ei, err
+	}
+
+	dir, name, err := p.DirAndBasename()
+	if err != nil {
+		return libkbfs.EntryInfo{}, err
+	}
+	dirNode, ok := dirNodes[dir.String()]
+	if !ok {
+		dirNode, err = dir.GetDirNode(ctx, f.config)
+		if err != nil {
+			return libkbfs.EntryInfo{}, err
+		}
+		dirNodes[dir.String()] = dirNode
+	}
+	_, ei, err := f.config.KBFSOps().Lookup(ctx, dirNode, name)
+	return ei, err
+}
+
+// StatPaths implements the FS interface for fs.
+func (f *fs) StatPaths(ctx context.Context, arg StatPathsArg) (
+	StatPathsResult, error) {
+	f.log.CDebugf(ctx, "Statting %d paths", len(arg.Paths))
+
+	dirNodes := make(map[string]libkbfs.Node)
+	entries := make([]Dirent, 0, len(arg.Paths))
+	for _, pathStr := range arg.Paths {
+		p, err := NewPath(pathStr)
+		if err != nil {
+			entries = append(entries, Dirent{Path: pathStr, Error: err.Error()})
+			continue
+		}
+		ei, err := f.statPath(ctx, p, dirNodes)
+		if err != nil {
+			entries = append(entries, Dirent{Path: pathStr, Error: err.Error()})
+			continue
+		}
+		entries = append(entries, NewDirent(p, ei))
+	}
+	return StatPathsResult{Entries: entries}, nil
+}
+
+func (f fs) listDirents(ctx context.Context, p Path) ([]Dirent, error) {
+	if p.PathType != TLFPathType {
+		// Everything above the TLFs is a directory with no
+		// further info, so just reuse List.
+		result, err := f.List(ctx, keybase1.ListArg{Path: p.String()})
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]Dirent, 0, len(result.Files))
+		for _, file := range result.Files {
+			entries = append(entries, Dirent{
+				Path: file.Path,
+				Type: libkbfs.Dir.String(),
+			})
+		}
+		return entries, nil
+	}
+
+	node, de, err := p.GetNode(ctx, f.config)
+	if err != nil {
+		return nil, err
+	}
+	if de.Type != libkbfs.Dir {
+		return []Dirent{NewDirent(p, de)}, nil
+	}
+
+	children, err := f.config.KBFSOps().GetDirChildren(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Dirent, 0, len(children))
+	for name, ei := range children {
+		childPath, err := p.Join(name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, NewDirent(childPath, ei))
+	}
+	return entries, nil
+}
+
+// ListDirents implements the FS interface for fs.
+func (f *fs) ListDirents(ctx context.Context, arg ListDirentsArg) (
+	ListDirentsResult, error) {
+	f.log.CDebugf(ctx, "Listing dirents of %q", arg.Path)
+
+	p, err := NewPath(arg.Path)
+	if err != nil {
+		return ListDirentsResult{}, err
+	}
+
+	entries, err := f.listDirents(ctx, p)
+	if err != nil {
+		f.log.CErrorf(ctx, "Error listing dirents of %q: %s", arg.Path, err)
+		return ListDirentsResult{}, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return ListDirentsResult{Entries: entries}, nil
+}
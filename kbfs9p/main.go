@@ -0,0 +1,110 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// 9P2000.L server for the Keybase file system.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/lib9p"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var listenAddr = flag.String("listen", "127.0.0.1:5640",
+	"TCP address, or unix:/path/to/socket, to serve 9P on")
+var allowLAN = flag.Bool("lan", false,
+	"allow listening on non-loopback addresses; there is no "+
+		"authentication, so only do this on a trusted network")
+
+const usageFormatStr = `Usage:
+  kbfs9p -version
+
+To run against remote KBFS servers:
+  kbfs9p
+    [-listen=host:port|unix:path] [-lan]
+%s
+    <type>/<name> [<type>/<name>...]
+
+To run in a local testing environment:
+  kbfs9p
+    [-listen=host:port|unix:path] [-lan]
+%s
+    <type>/<name> [<type>/<name>...]
+
+Each TLF is given as e.g. private/alice,bob, public/alice or team/acme,
+and is chosen by clients with the same string as the attach name, e.g.:
+
+  mount -t 9p -o trans=tcp,port=5640,version=9p2000.L,aname=private/alice \
+    127.0.0.1 /mnt/alice
+
+or, for a Unix domain socket:
+
+  mount -t 9p -o trans=unix,version=9p2000.L,aname=private/alice \
+    /path/to/socket /mnt/alice
+
+Files appear to be owned by the user who mounted them.  Locks are
+accepted but not enforced.
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	if len(flag.Args()) < 1 {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("no TLFs specified")
+	}
+
+	for _, p := range flag.Args() {
+		if _, _, err := libfs.ParseTlfPath(p); err != nil {
+			fmt.Print(getUsageString(ctx))
+			return libfs.InitError(err.Error())
+		}
+	}
+
+	options := lib9p.StartOptions{
+		KbfsParams: *kbfsParams,
+		ListenAddr: *listenAddr,
+		AllowLAN:   *allowLAN,
+		TLFs:       flag.Args(),
+	}
+
+	return lib9p.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfs9p error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
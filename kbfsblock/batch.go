@@ -0,0 +1,237 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsblock
+
+import (
+	"context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// GetBlocksArg is the argument to the batched block get RPC.
+type GetBlocksArg struct {
+	Folder string                  `codec:"folder" json:"folder"`
+	Bids   []keybase1.BlockIdCombo `codec:"bids" json:"bids"`
+}
+
+// GetBlocksResEntry is the result for a single block in a
+// GetBlocksRes.  If Status has a non-zero code, Res is unset.
+type GetBlocksResEntry struct {
+	Res    keybase1.GetBlockRes `codec:"res" json:"res"`
+	Status keybase1.Status      `codec:"status" json:"status"`
+}
+
+// GetBlocksRes is the result of the batched block get RPC.
+type GetBlocksRes struct {
+	// Blocks has one entry per requested block, in order.
+	Blocks []GetBlocksResEntry `codec:"blocks" json:"blocks"`
+}
+
+// PutBlocksArg is the argument to the batched block put RPC.
+type PutBlocksArg struct {
+	Folder string                 `codec:"folder" json:"folder"`
+	Blocks []keybase1.PutBlockArg `codec:"blocks" json:"blocks"`
+}
+
+// PutBlocksRes is the result of the batched block put RPC.
+type PutBlocksRes struct {
+	// Statuses has one entry per block put, in order.
+	Statuses []keybase1.Status `codec:"statuses" json:"statuses"`
+}
+
+// HasBlocksArg is the argument to the batched block existence RPC.
+type HasBlocksArg struct {
+	Folder string                    `codec:"folder" json:"folder"`
+	Refs   []keybase1.BlockReference `codec:"refs" json:"refs"`
+}
+
+// HasBlocksRes is the result of the batched block existence RPC.
+type HasBlocksRes struct {
+	// Exists has one entry per requested reference, in order, and
+	// is true only if the block exists on the server with a live
+	// reference for that exact context.
+	Exists []bool `codec:"exists" json:"exists"`
+}
+
+// BatchInterface is the interface to the batched block server RPCs.
+type BatchInterface interface {
+	GetBlocks(context.Context, GetBlocksArg) (GetBlocksRes, error)
+	PutBlocks(context.Context, PutBlocksArg) (PutBlocksRes, error)
+	HasBlocks(context.Context, HasBlocksArg) (HasBlocksRes, error)
+}
+
+// BatchClient implements BatchInterface over an RPC connection to a
+// block server.
+type BatchClient struct {
+	Cli rpc.GenericClient
+}
+
+var _ BatchInterface = BatchClient{}
+
+// GetBlocks implements the BatchInterface for BatchClient.
+func (c BatchClient) GetBlocks(ctx context.Context, arg GetBlocksArg) (
+	res GetBlocksRes, err error) {
+	err = c.Cli.Call(ctx, "keybase.1.block.getBlocks",
+		[]interface{}{arg}, &res)
+	return res, err
+}
+
+// PutBlocks implements the BatchInterface for BatchClient.
+func (c BatchClient) PutBlocks(ctx context.Context, arg PutBlocksArg) (
+	res PutBlocksRes, err error) {
+	err = c.Cli.Call(ctx, "keybase.1.block.putBlocks",
+		[]interface{}{arg}, &res)
+	return res, err
+}
+
+// HasBlocks implements the BatchInterface for BatchClient.
+func (c BatchClient) HasBlocks(ctx context.Context, arg HasBlocksArg) (
+	res HasBlocksRes, err error) {
+	err = c.Cli.Call(ctx, "keybase.1.block.hasBlocks",
+		[]interface{}{arg}, &res)
+	return res, err
+}
+
+// BatchRef describes a single block reference to get or check as
+// part of a batch.
+type BatchRef struct {
+	ID      ID
+	Context Context
+}
+
+// BatchGetResult is the result of getting a single block as part of
+// a batch.  If Err is non-nil, the other fields are unset.
+type BatchGetResult struct {
+	Buf        []byte
+	ServerHalf kbfscrypto.BlockCryptKeyServerHalf
+	Err        error
+}
+
+// BatchPut describes a single block to put as part of a batch.  The
+// context should contain a RefNonce of zero.
+type BatchPut struct {
+	ID         ID
+	Context    Context
+	Buf        []byte
+	ServerHalf kbfscrypto.BlockCryptKeyServerHalf
+}
+
+// statusToError converts a per-block status from a batched RPC into
+// the same error the corresponding single-block RPC would return.
+func statusToError(s keybase1.Status) error {
+	appErr, dispatchErr := ServerErrorUnwrapper{}.UnwrapError(&s)
+	if dispatchErr != nil {
+		return dispatchErr
+	}
+	return appErr
+}
+
+// MakeGetBlocksArg builds a GetBlocksArg from the given params.
+func MakeGetBlocksArg(tlfID tlf.ID, gets []BatchRef) GetBlocksArg {
+	bids := make([]keybase1.BlockIdCombo, len(gets))
+	for i, get := range gets {
+		bids[i] = makeIDCombo(get.ID, get.Context)
+	}
+	return GetBlocksArg{
+		Folder: tlfID.String(),
+		Bids:   bids,
+	}
+}
+
+// ParseGetBlocksRes parses the given GetBlocksRes, for a request of
+// `n` blocks, into one result per block.
+func ParseGetBlocksRes(res GetBlocksRes, n int, resErr error) (
+	[]BatchGetResult, error) {
+	if resErr != nil {
+		return nil, resErr
+	}
+	if len(res.Blocks) != n {
+		return nil, errors.Errorf(
+			"expected %d blocks, got %d", n, len(res.Blocks))
+	}
+	results := make([]BatchGetResult, n)
+	for i, entry := range res.Blocks {
+		if err := statusToError(entry.Status); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Buf, results[i].ServerHalf, results[i].Err =
+			ParseGetBlockRes(entry.Res, nil)
+	}
+	return results, nil
+}
+
+// MakePutBlocksArg builds a PutBlocksArg from the given params.
+func MakePutBlocksArg(tlfID tlf.ID, puts []BatchPut) PutBlocksArg {
+	blocks := make([]keybase1.PutBlockArg, len(puts))
+	for i, put := range puts {
+		blocks[i] = MakePutBlockArg(
+			tlfID, put.ID, put.Context, put.Buf, put.ServerHalf)
+	}
+	return PutBlocksArg{
+		Folder: tlfID.String(),
+		Blocks: blocks,
+	}
+}
+
+// ParsePutBlocksRes parses the given PutBlocksRes, for a request of
+// `n` blocks, into one error per block.
+func ParsePutBlocksRes(res PutBlocksRes, n int, resErr error) (
+	[]error, error) {
+	if resErr != nil {
+		return nil, resErr
+	}
+	if len(res.Statuses) != n {
+		return nil, errors.Errorf(
+			"expected %d statuses, got %d", n, len(res.Statuses))
+	}
+	errs := make([]error, n)
+	for i, s := range res.Statuses {
+		errs[i] = statusToError(s)
+	}
+	return errs, nil
+}
+
+// MakeHasBlocksArg builds a HasBlocksArg from the given params.
+func MakeHasBlocksArg(tlfID tlf.ID, refs []BatchRef) HasBlocksArg {
+	blockRefs := make([]keybase1.BlockReference, len(refs))
+	for i, ref := range refs {
+		blockRefs[i] = makeReference(ref.ID, ref.Context)
+	}
+	return HasBlocksArg{
+		Folder: tlfID.String(),
+		Refs:   blockRefs,
+	}
+}
+
+// ParseHasBlocksRes parses the given HasBlocksRes, for a request of
+// `n` references, into whether each one exists.
+func ParseHasBlocksRes(res HasBlocksRes, n int, resErr error) (
+	[]bool, error) {
+	if resErr != nil {
+		return nil, resErr
+	}
+	if len(res.Exists) != n {
+		return nil, errors.Errorf(
+			"expected %d results, got %d", n, len(res.Exists))
+	}
+	return res.Exists, nil
+}
+
+// IsBatchUnsupportedError returns whether the given error means
+// that the block server doesn't know about the batched RPCs, in
+// which case the caller should fall back to single-block RPCs.
+func IsBatchUnsupportedError(err error) bool {
+	switch errors.Cause(err).(type) {
+	case rpc.MethodNotFoundError, rpc.ProtocolNotFoundError:
+		return true
+	default:
+		return false
+	}
+}
@@ -9,4 +9,12 @@ const (
 	ServerTokenServer = "kbfs_block"
 	// ServerTokenExpireIn is the TTL to use when constructing an authentication token.
 	ServerTokenExpireIn = 24 * 60 * 60 // 24 hours
+	// MaxBlocksPerBatch is the most blocks to send in a single
+	// batched get or put RPC.  With the default maximum block size
+	// of 512 KiB, this keeps each message under about 16 MiB.
+	MaxBlocksPerBatch = 32
+	// MaxRefsPerHasBlocks is the most block references to check in
+	// a single HasBlocks RPC.  No block data is sent, so this can be
+	// much bigger than MaxBlocksPerBatch.
+	MaxRefsPerHasBlocks = 1000
 )
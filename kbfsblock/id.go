@@ -60,6 +60,12 @@ func (id ID) IsValid() bool {
 	return id.h.IsValid()
 }
 
+// HashType returns the type of the hash used to compute the block
+// ID.
+func (id ID) HashType() kbfshash.HashType {
+	return id.h.GetHashType()
+}
+
 // Bytes returns the bytes of the block ID.
 func (id ID) Bytes() []byte {
 	return id.h.Bytes()
@@ -152,6 +158,17 @@ func MakePermanentID(encodedEncryptedData []byte) (ID, error) {
 	return ID{h}, nil
 }
 
+// MakePermanentIDWithHashType is like MakePermanentID, but uses the
+// given hash type instead of the default one.
+func MakePermanentIDWithHashType(encodedEncryptedData []byte,
+	hashType kbfshash.HashType) (ID, error) {
+	h, err := kbfshash.HashWithType(hashType, encodedEncryptedData)
+	if err != nil {
+		return ID{}, err
+	}
+	return ID{h}, nil
+}
+
 // VerifyID verifies that the given block ID is the permanent block ID
 // for the given encoded and encrypted data.
 func VerifyID(encodedEncryptedData []byte, id ID) error {
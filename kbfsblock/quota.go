@@ -83,6 +83,28 @@ func (u *UsageStat) Accum(another *UsageStat, accumF func(int64, int64) int64) {
 	}
 }
 
+// UsageBreakdown splits the written bytes of a UsageStat into the
+// bytes of blocks that still have live references, and the bytes of
+// blocks whose references have all been archived.  Archived blocks
+// still count against quota until quota reclamation deletes them,
+// which is why usage doesn't drop right away after a delete.
+type UsageBreakdown struct {
+	LiveBytes        int64
+	ArchivedBytes    int64
+	LiveGitBytes     int64
+	ArchivedGitBytes int64
+}
+
+// Breakdown returns the live vs. archived breakdown of u.
+func (u *UsageStat) Breakdown() UsageBreakdown {
+	return UsageBreakdown{
+		LiveBytes:        u.Bytes[UsageWrite] - u.Bytes[UsageArchive],
+		ArchivedBytes:    u.Bytes[UsageArchive],
+		LiveGitBytes:     u.Bytes[UsageGitWrite] - u.Bytes[UsageGitArchive],
+		ArchivedGitBytes: u.Bytes[UsageGitArchive],
+	}
+}
+
 // QuotaInfo contains a user's quota usage information
 type QuotaInfo struct {
 	Folders  map[string]*UsageStat
@@ -125,6 +147,17 @@ func (u *QuotaInfo) Accum(another *QuotaInfo, accumF func(int64, int64) int64) {
 	}
 }
 
+// FolderBreakdown returns the live vs. archived breakdown of the
+// given folder's usage, or false if there's no usage recorded for
+// it.
+func (u *QuotaInfo) FolderBreakdown(folder string) (UsageBreakdown, bool) {
+	stat, ok := u.Folders[folder]
+	if !ok || stat == nil {
+		return UsageBreakdown{}, false
+	}
+	return stat.Breakdown(), true
+}
+
 // ToBytes marshals this QuotaInfo
 func (u *QuotaInfo) ToBytes(codec kbfscodec.Codec) ([]byte, error) {
 	return codec.Encode(u)
@@ -0,0 +1,46 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsblock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaInfoFolderBreakdown(t *testing.T) {
+	info := NewQuotaInfo()
+	info.AccumOne(100, "folder", UsageWrite)
+	info.AccumOne(50, "folder", UsageWrite)
+	info.AccumOne(50, "folder", UsageArchive)
+	info.AccumOne(20, "folder", UsageGitWrite)
+	info.AccumOne(30, "other", UsageWrite)
+
+	breakdown, ok := info.FolderBreakdown("folder")
+	require.True(t, ok)
+	require.Equal(t, UsageBreakdown{
+		LiveBytes:     100,
+		ArchivedBytes: 50,
+		LiveGitBytes:  20,
+	}, breakdown)
+
+	// Deleting the archived block drops it from the archived bytes.
+	info.AccumOne(-50, "folder", UsageWrite)
+	info.AccumOne(-50, "folder", UsageArchive)
+	breakdown, ok = info.FolderBreakdown("folder")
+	require.True(t, ok)
+	require.Equal(t, UsageBreakdown{
+		LiveBytes:    100,
+		LiveGitBytes: 20,
+	}, breakdown)
+
+	require.Equal(t, UsageBreakdown{
+		LiveBytes:    130,
+		LiveGitBytes: 20,
+	}, info.Total.Breakdown())
+
+	_, ok = info.FolderBreakdown("missing")
+	require.False(t, ok)
+}
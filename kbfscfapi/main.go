@@ -0,0 +1,100 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Windows Cloud Filter API frontend for the Keybase file system.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libcfapi"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var syncRoot = flag.String("sync-root",
+	filepath.Join(os.Getenv("USERPROFILE"), "Keybase"),
+	"the local directory to show KBFS in")
+var unregister = flag.Bool("unregister", false,
+	"unregister the sync root and exit")
+
+const usageFormatStr = `Usage:
+  kbfscfapi -version
+
+To run against remote KBFS servers:
+  kbfscfapi
+    [-sync-root=path]
+%s
+
+To run in a local testing environment:
+  kbfscfapi
+    [-sync-root=path]
+%s
+
+To stop showing KBFS in the sync root:
+  kbfscfapi [-sync-root=path] -unregister
+
+Files are downloaded when they're first opened, and Explorer shows
+their state in its status column.  This needs Windows 10 1709 or
+later; use kbfsdokan on older versions.
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	if len(flag.Args()) > 0 {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("extra arguments specified (flags go before the first argument)")
+	}
+
+	if *unregister {
+		if err := libcfapi.Unregister(*syncRoot); err != nil {
+			return libfs.InitError(err.Error())
+		}
+		return nil
+	}
+
+	options := libcfapi.StartOptions{
+		KbfsParams: *kbfsParams,
+		SyncRoot:   *syncRoot,
+	}
+
+	return libcfapi.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfscfapi error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
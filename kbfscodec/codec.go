@@ -5,11 +5,15 @@
 package kbfscodec
 
 import (
+	"bufio"
 	"bytes"
+	"io"
+	"os"
 	"path/filepath"
 	"reflect"
 
 	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
 )
 
 // ExtCode is used to register codec extensions
@@ -28,6 +32,15 @@ type Codec interface {
 	Decode(buf []byte, obj interface{}) error
 	// Encode marshals the given object into a returned buffer.
 	Encode(obj interface{}) ([]byte, error)
+	// DecodeFrom is like Decode, but reads the encoded object from
+	// the given reader as it's decoded, instead of needing the whole
+	// encoding in memory first.
+	DecodeFrom(r io.Reader, obj interface{}) error
+	// EncodeTo is like Encode, but writes the encoding of the given
+	// object to the given writer as it's produced.  Callers should
+	// pass in a buffered writer, since the encoding is written in
+	// many small pieces.
+	EncodeTo(w io.Writer, obj interface{}) error
 	// RegisterType should be called for all types that are stored
 	// under ambiguous types (like interface{} or nil interface) in a
 	// struct that will be encoded/decoded by the codec.  Each must
@@ -110,16 +123,18 @@ func SerializeToFileIfNotExist(c Codec, obj interface{}, path string) error {
 // DeserializeFromFile deserializes the given file into the object
 // pointed to by objPtr. It may return an error for which
 // ioutil.IsNotExist() returns true.
-func DeserializeFromFile(c Codec, path string, objPtr interface{}) error {
-	data, err := ioutil.ReadFile(path)
+func DeserializeFromFile(c Codec, path string, objPtr interface{}) (
+	err error) {
+	f, err := ioutil.OpenFile(path, os.O_RDONLY, 0)
 	if err != nil {
 		return err
 	}
-
-	err = c.Decode(data, objPtr)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = errors.WithStack(closeErr)
+		}
+	}()
+
+	return c.DecodeFrom(bufio.NewReader(f), objPtr)
 }
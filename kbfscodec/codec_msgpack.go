@@ -6,6 +6,7 @@ package kbfscodec
 
 import (
 	"fmt"
+	"io"
 	"reflect"
 
 	"github.com/keybase/go-codec/codec"
@@ -175,6 +176,24 @@ func (c *CodecMsgpack) Encode(obj interface{}) (buf []byte, err error) {
 	return buf, nil
 }
 
+// DecodeFrom implements the Codec interface for CodecMsgpack
+func (c *CodecMsgpack) DecodeFrom(r io.Reader, obj interface{}) error {
+	err := codec.NewDecoder(r, c.h).Decode(obj)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode")
+	}
+	return nil
+}
+
+// EncodeTo implements the Codec interface for CodecMsgpack
+func (c *CodecMsgpack) EncodeTo(w io.Writer, obj interface{}) error {
+	err := codec.NewEncoder(w, c.h).Encode(obj)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode")
+	}
+	return nil
+}
+
 // RegisterType implements the Codec interface for CodecMsgpack
 func (c *CodecMsgpack) RegisterType(rt reflect.Type, code ExtCode) {
 	c.h.(*codec.MsgpackHandle).SetExt(rt, uint64(code), ext{c.ExtCodec})
@@ -5,6 +5,7 @@
 package kbfscodec
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -30,3 +31,27 @@ func TestCodecEncodeMap(t *testing.T) {
 
 	require.Equal(t, b1, b2)
 }
+
+// TestCodecEncodeDecodeStream tests that the streaming encode/decode
+// paths are consistent with the byte slice ones.
+func TestCodecEncodeDecodeStream(t *testing.T) {
+	m := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		m[string(rune('a'+i))] = bytes.Repeat([]byte{byte(i)}, 1000)
+	}
+
+	codec := NewMsgpack()
+
+	b, err := codec.Encode(m)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = codec.EncodeTo(&buf, m)
+	require.NoError(t, err)
+	require.Equal(t, b, buf.Bytes())
+
+	var m2 map[string][]byte
+	err = codec.DecodeFrom(&buf, &m2)
+	require.NoError(t, err)
+	require.Equal(t, m, m2)
+}
@@ -5,6 +5,7 @@ package kbfscodec
 
 import (
 	gomock "github.com/golang/mock/gomock"
+	io "io"
 	reflect "reflect"
 )
 
@@ -50,6 +51,26 @@ func (_mr *_MockCodecRecorder) Encode(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Encode", arg0)
 }
 
+func (_m *MockCodec) DecodeFrom(r io.Reader, obj interface{}) error {
+	ret := _m.ctrl.Call(_m, "DecodeFrom", r, obj)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockCodecRecorder) DecodeFrom(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DecodeFrom", arg0, arg1)
+}
+
+func (_m *MockCodec) EncodeTo(w io.Writer, obj interface{}) error {
+	ret := _m.ctrl.Call(_m, "EncodeTo", w, obj)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockCodecRecorder) EncodeTo(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "EncodeTo", arg0, arg1)
+}
+
 func (_m *MockCodec) RegisterType(rt reflect.Type, code ExtCode) {
 	_m.ctrl.Call(_m, "RegisterType", rt, code)
 }
@@ -0,0 +1,34 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfscrypto
+
+import "golang.org/x/net/context"
+
+// A Decryptor is something that can decrypt TLF crypt key client
+// halves using an internal private key.
+type Decryptor interface {
+	// DecryptTLFCryptKeyClientHalf decrypts a client half that was
+	// encrypted for the internal private key.
+	DecryptTLFCryptKeyClientHalf(ctx context.Context,
+		publicKey TLFEphemeralPublicKey,
+		encryptedClientHalf EncryptedTLFCryptKeyClientHalf) (
+		TLFCryptKeyClientHalf, error)
+}
+
+// CryptPrivateKeyDecryptor is a Decryptor wrapper around a
+// CryptPrivateKey.
+type CryptPrivateKeyDecryptor struct {
+	Key CryptPrivateKey
+}
+
+// DecryptTLFCryptKeyClientHalf implements Decryptor for
+// CryptPrivateKeyDecryptor.
+func (d CryptPrivateKeyDecryptor) DecryptTLFCryptKeyClientHalf(
+	ctx context.Context, publicKey TLFEphemeralPublicKey,
+	encryptedClientHalf EncryptedTLFCryptKeyClientHalf) (
+	TLFCryptKeyClientHalf, error) {
+	return DecryptTLFCryptKeyClientHalf(
+		d.Key, publicKey, encryptedClientHalf)
+}
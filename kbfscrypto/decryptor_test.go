@@ -0,0 +1,32 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfscrypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestCryptPrivateKeyDecryptor(t *testing.T) {
+	ephPublicKey, ephPrivateKey, err := MakeRandomTLFEphemeralKeys()
+	require.NoError(t, err)
+
+	privateKey := MakeFakeCryptPrivateKeyOrBust("fake key")
+	var clientHalfData [32]byte
+	clientHalfData[0] = 1
+	clientHalf := MakeTLFCryptKeyClientHalf(clientHalfData)
+
+	encryptedClientHalf, err := EncryptTLFCryptKeyClientHalf(
+		ephPrivateKey, privateKey.GetPublicKey(), clientHalf)
+	require.NoError(t, err)
+
+	var d Decryptor = CryptPrivateKeyDecryptor{privateKey}
+	decryptedClientHalf, err := d.DecryptTLFCryptKeyClientHalf(
+		context.Background(), ephPublicKey, encryptedClientHalf)
+	require.NoError(t, err)
+	require.Equal(t, clientHalf, decryptedClientHalf)
+}
@@ -24,12 +24,20 @@ const (
 	// EncryptionSecretbox is the encryption version that uses
 	// nacl/secretbox or nacl/box.
 	EncryptionSecretbox EncryptionVer = 1
+	// EncryptionHybridMLKEM768 is the encryption version for
+	// TLF crypt key client halves sealed with nacl/secretbox under
+	// a key derived from both an X25519 (nacl/box) shared secret
+	// and an ML-KEM-768 shared secret, so that recovering the
+	// client half requires breaking both.
+	EncryptionHybridMLKEM768 EncryptionVer = 2
 )
 
 func (v EncryptionVer) String() string {
 	switch v {
 	case EncryptionSecretbox:
 		return "EncryptionSecretbox"
+	case EncryptionHybridMLKEM768:
+		return "EncryptionHybridMLKEM768"
 	default:
 		return fmt.Sprintf("EncryptionVer(%d)", v)
 	}
@@ -104,6 +112,24 @@ func decryptData(encryptedData encryptedData, key [32]byte) ([]byte, error) {
 // TLFCryptKeyClientHalf object.
 type EncryptedTLFCryptKeyClientHalf struct {
 	encryptedData
+	// KEMCiphertext is the ML-KEM ciphertext, set only for
+	// EncryptionHybridMLKEM768.
+	KEMCiphertext []byte `codec:"k,omitempty"`
+}
+
+// getDHPublicKey returns the nacl/box public key for the given
+// device public key.
+func getDHPublicKey(publicKey CryptPublicKey) (*[32]byte, error) {
+	keypair, err := libkb.ImportKeypairFromKID(publicKey.KID())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dhKeyPair, ok := keypair.(libkb.NaclDHKeyPair)
+	if !ok {
+		return nil, errors.WithStack(libkb.KeyCannotEncryptError{})
+	}
+	return (*[32]byte)(&dhKeyPair.Public), nil
 }
 
 // EncryptTLFCryptKeyClientHalf encrypts a TLFCryptKeyClientHalf
@@ -118,23 +144,17 @@ func EncryptTLFCryptKeyClientHalf(
 		return EncryptedTLFCryptKeyClientHalf{}, err
 	}
 
-	keypair, err := libkb.ImportKeypairFromKID(publicKey.KID())
+	dhPublicKey, err := getDHPublicKey(publicKey)
 	if err != nil {
-		return EncryptedTLFCryptKeyClientHalf{}, errors.WithStack(err)
-	}
-
-	dhKeyPair, ok := keypair.(libkb.NaclDHKeyPair)
-	if !ok {
-		return EncryptedTLFCryptKeyClientHalf{}, errors.WithStack(
-			libkb.KeyCannotEncryptError{})
+		return EncryptedTLFCryptKeyClientHalf{}, err
 	}
 
 	clientHalfData := clientHalf.Data()
 	privateKeyData := privateKey.Data()
-	encryptedBytes := box.Seal(nil, clientHalfData[:], &nonce, (*[32]byte)(&dhKeyPair.Public), &privateKeyData)
+	encryptedBytes := box.Seal(nil, clientHalfData[:], &nonce, dhPublicKey, &privateKeyData)
 
 	return EncryptedTLFCryptKeyClientHalf{
-		encryptedData{
+		encryptedData: encryptedData{
 			Version:       EncryptionSecretbox,
 			EncryptedData: encryptedBytes,
 			Nonce:         nonce[:],
@@ -50,6 +50,15 @@ func (e UnknownEncryptionVer) Error() string {
 	return fmt.Sprintf("Unknown encryption version %d", int(e.Ver))
 }
 
+// KEMUnsupportedError indicates that this build can't make or open
+// hybrid client half boxes, because it was built with a Go version
+// that lacks ML-KEM support.
+type KEMUnsupportedError struct{}
+
+func (e KEMUnsupportedError) Error() string {
+	return "ML-KEM is not supported by this build"
+}
+
 // InvalidNonceError indicates that an invalid cryptographic nonce was
 // detected.
 type InvalidNonceError struct {
@@ -0,0 +1,179 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfscrypto
+
+import (
+	"crypto/sha256"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// KEMPublicKey is a device's ML-KEM-768 encapsulation key, used
+// along with its CryptPublicKey to make hybrid client half boxes.
+//
+// Copies of KEMPublicKey objects are deep copies.
+type KEMPublicKey struct {
+	data []byte
+}
+
+// MakeKEMPublicKey returns a KEMPublicKey containing a copy of the
+// given encoded encapsulation key.
+func MakeKEMPublicKey(data []byte) KEMPublicKey {
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	return KEMPublicKey{dataCopy}
+}
+
+// Data returns a copy of the encoded encapsulation key.
+func (k KEMPublicKey) Data() []byte {
+	dataCopy := make([]byte, len(k.data))
+	copy(dataCopy, k.data)
+	return dataCopy
+}
+
+// KEMPrivateKey is a device's ML-KEM-768 decapsulation key, stored
+// as its 64-byte seed.
+type KEMPrivateKey struct {
+	seed [64]byte
+}
+
+// MakeKEMPrivateKey returns a KEMPrivateKey for the given seed.
+func MakeKEMPrivateKey(seed [64]byte) KEMPrivateKey {
+	return KEMPrivateKey{seed}
+}
+
+// MakeRandomKEMPrivateKey generates a new KEMPrivateKey using a
+// CSPRNG.
+func MakeRandomKEMPrivateKey() (KEMPrivateKey, error) {
+	var seed [64]byte
+	err := RandRead(seed[:])
+	if err != nil {
+		return KEMPrivateKey{}, err
+	}
+	return MakeKEMPrivateKey(seed), nil
+}
+
+// GetPublicKey returns the encapsulation key corresponding to this
+// private key.
+func (k KEMPrivateKey) GetPublicKey() (KEMPublicKey, error) {
+	data, err := kemPublicKeyFromSeed(k.seed)
+	if err != nil {
+		return KEMPublicKey{}, err
+	}
+	return KEMPublicKey{data}, nil
+}
+
+const hybridClientHalfKeyContext = "Keybase-KBFS-Hybrid-Client-Half-1"
+
+// hybridClientHalfKey derives the secretbox key for a hybrid client
+// half box from both shared secrets.  The KEM ciphertext is mixed in
+// too, so that the key is bound to this particular encapsulation.
+func hybridClientHalfKey(
+	dhShared *[32]byte, kemShared, kemCiphertext []byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte(hybridClientHalfKeyContext))
+	h.Write(dhShared[:])
+	h.Write(kemShared)
+	h.Write(kemCiphertext)
+	var key [32]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// EncryptTLFCryptKeyClientHalfHybrid is like
+// EncryptTLFCryptKeyClientHalf, but also encapsulates a secret to the
+// given device KEM public key, and seals the client half under a key
+// derived from both secrets (EncryptionHybridMLKEM768).
+func EncryptTLFCryptKeyClientHalfHybrid(
+	privateKey TLFEphemeralPrivateKey, publicKey CryptPublicKey,
+	kemPublicKey KEMPublicKey, clientHalf TLFCryptKeyClientHalf) (
+	encryptedClientHalf EncryptedTLFCryptKeyClientHalf, err error) {
+	var nonce [24]byte
+	err = RandRead(nonce[:])
+	if err != nil {
+		return EncryptedTLFCryptKeyClientHalf{}, err
+	}
+
+	dhPublicKey, err := getDHPublicKey(publicKey)
+	if err != nil {
+		return EncryptedTLFCryptKeyClientHalf{}, err
+	}
+
+	kemShared, kemCiphertext, err := kemEncapsulate(kemPublicKey.data)
+	if err != nil {
+		return EncryptedTLFCryptKeyClientHalf{}, err
+	}
+
+	var dhShared [32]byte
+	privateKeyData := privateKey.Data()
+	box.Precompute(&dhShared, dhPublicKey, &privateKeyData)
+	key := hybridClientHalfKey(&dhShared, kemShared, kemCiphertext)
+
+	clientHalfData := clientHalf.Data()
+	encryptedBytes := secretbox.Seal(nil, clientHalfData[:], &nonce, &key)
+
+	return EncryptedTLFCryptKeyClientHalf{
+		encryptedData: encryptedData{
+			Version:       EncryptionHybridMLKEM768,
+			EncryptedData: encryptedBytes,
+			Nonce:         nonce[:],
+		},
+		KEMCiphertext: kemCiphertext,
+	}, nil
+}
+
+// DecryptTLFCryptKeyClientHalfHybrid decrypts a
+// TLFCryptKeyClientHalf encrypted with
+// EncryptTLFCryptKeyClientHalfHybrid, using the given device private
+// keys and the TLF's ephemeral public key.
+func DecryptTLFCryptKeyClientHalfHybrid(
+	privateKey CryptPrivateKey, kemPrivateKey KEMPrivateKey,
+	publicKey TLFEphemeralPublicKey,
+	encryptedClientHalf EncryptedTLFCryptKeyClientHalf) (
+	TLFCryptKeyClientHalf, error) {
+	if encryptedClientHalf.Version != EncryptionHybridMLKEM768 {
+		return TLFCryptKeyClientHalf{}, errors.WithStack(
+			UnknownEncryptionVer{Ver: encryptedClientHalf.Version})
+	}
+
+	var nonce [24]byte
+	if len(encryptedClientHalf.Nonce) != len(nonce) {
+		return TLFCryptKeyClientHalf{}, errors.WithStack(
+			InvalidNonceError{Nonce: encryptedClientHalf.Nonce})
+	}
+	copy(nonce[:], encryptedClientHalf.Nonce)
+
+	kemShared, err := kemDecapsulate(
+		kemPrivateKey.seed, encryptedClientHalf.KEMCiphertext)
+	if err != nil {
+		return TLFCryptKeyClientHalf{}, err
+	}
+
+	var dhShared [32]byte
+	publicKeyData := publicKey.Data()
+	privateKeyData := privateKey.Data()
+	box.Precompute(&dhShared, &publicKeyData, &privateKeyData)
+	key := hybridClientHalfKey(
+		&dhShared, kemShared, encryptedClientHalf.KEMCiphertext)
+
+	decryptedData, ok := secretbox.Open(
+		nil, encryptedClientHalf.EncryptedData, &nonce, &key)
+	if !ok {
+		return TLFCryptKeyClientHalf{},
+			errors.WithStack(libkb.DecryptionError{})
+	}
+
+	var clientHalfData [32]byte
+	if len(decryptedData) != len(clientHalfData) {
+		return TLFCryptKeyClientHalf{},
+			errors.WithStack(libkb.DecryptionError{})
+	}
+
+	copy(clientHalfData[:], decryptedData)
+	return MakeTLFCryptKeyClientHalf(clientHalfData), nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build go1.24
+// +build go1.24
+
+package kbfscrypto
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecryptTLFCryptKeyClientHalfHybrid(t *testing.T) {
+	ephPublicKey, ephPrivateKey, err := MakeRandomTLFEphemeralKeys()
+	require.NoError(t, err)
+
+	cryptKey, err := MakeRandomTLFCryptKey()
+	require.NoError(t, err)
+	serverHalf, err := MakeRandomTLFCryptKeyServerHalf()
+	require.NoError(t, err)
+	clientHalf := MaskTLFCryptKey(serverHalf, cryptKey)
+
+	privateKey := MakeFakeCryptPrivateKeyOrBust("fake key")
+	kemPrivateKey, err := MakeRandomKEMPrivateKey()
+	require.NoError(t, err)
+	kemPublicKey, err := kemPrivateKey.GetPublicKey()
+	require.NoError(t, err)
+
+	encryptedClientHalf, err := EncryptTLFCryptKeyClientHalfHybrid(
+		ephPrivateKey, privateKey.GetPublicKey(), kemPublicKey, clientHalf)
+	require.NoError(t, err)
+	require.Equal(t, EncryptionHybridMLKEM768, encryptedClientHalf.Version)
+	require.NotEmpty(t, encryptedClientHalf.KEMCiphertext)
+
+	clientHalf2, err := DecryptTLFCryptKeyClientHalfHybrid(
+		privateKey, kemPrivateKey, ephPublicKey, encryptedClientHalf)
+	require.NoError(t, err)
+	require.Equal(t, clientHalf, clientHalf2)
+
+	// The X25519-only decryption path must refuse hybrid boxes.
+	_, err = DecryptTLFCryptKeyClientHalf(
+		privateKey, ephPublicKey, encryptedClientHalf)
+	require.Equal(t, UnknownEncryptionVer{EncryptionHybridMLKEM768},
+		errors.Cause(err))
+
+	// Both private keys are needed.
+	otherKEMPrivateKey, err := MakeRandomKEMPrivateKey()
+	require.NoError(t, err)
+	_, err = DecryptTLFCryptKeyClientHalfHybrid(
+		privateKey, otherKEMPrivateKey, ephPublicKey, encryptedClientHalf)
+	require.Equal(t, libkb.DecryptionError{}, errors.Cause(err))
+
+	otherPrivateKey := MakeFakeCryptPrivateKeyOrBust("other fake key")
+	_, err = DecryptTLFCryptKeyClientHalfHybrid(
+		otherPrivateKey, kemPrivateKey, ephPublicKey, encryptedClientHalf)
+	require.Equal(t, libkb.DecryptionError{}, errors.Cause(err))
+}
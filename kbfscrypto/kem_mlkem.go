@@ -0,0 +1,43 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build go1.24
+// +build go1.24
+
+package kbfscrypto
+
+import (
+	"crypto/mlkem"
+
+	"github.com/pkg/errors"
+)
+
+func kemPublicKeyFromSeed(seed [64]byte) ([]byte, error) {
+	dk, err := mlkem.NewDecapsulationKey768(seed[:])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return dk.EncapsulationKey().Bytes(), nil
+}
+
+func kemEncapsulate(publicKey []byte) (shared, ciphertext []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(publicKey)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	shared, ciphertext = ek.Encapsulate()
+	return shared, ciphertext, nil
+}
+
+func kemDecapsulate(seed [64]byte, ciphertext []byte) ([]byte, error) {
+	dk, err := mlkem.NewDecapsulationKey768(seed[:])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	shared, err := dk.Decapsulate(ciphertext)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return shared, nil
+}
@@ -0,0 +1,22 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build !go1.24
+// +build !go1.24
+
+package kbfscrypto
+
+import "github.com/pkg/errors"
+
+func kemPublicKeyFromSeed(seed [64]byte) ([]byte, error) {
+	return nil, errors.WithStack(KEMUnsupportedError{})
+}
+
+func kemEncapsulate(publicKey []byte) (shared, ciphertext []byte, err error) {
+	return nil, nil, errors.WithStack(KEMUnsupportedError{})
+}
+
+func kemDecapsulate(seed [64]byte, ciphertext []byte) ([]byte, error) {
+	return nil, errors.WithStack(KEMUnsupportedError{})
+}
@@ -79,7 +79,7 @@ func MakeEncryptedTLFCryptKeyClientHalfForTest(
 	copy(clientHalfCopy, encodedClientHalf)
 	copy(nonceCopy, nonce)
 	return EncryptedTLFCryptKeyClientHalf{
-		encryptedData{
+		encryptedData: encryptedData{
 			Version:       version,
 			EncryptedData: clientHalfCopy,
 			Nonce:         nonceCopy,
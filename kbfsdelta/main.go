@@ -0,0 +1,143 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Delta-transfer server and client for the Keybase file system.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libdelta"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var listenAddr = flag.String("listen", "127.0.0.1:16803",
+	"loopback address to serve on")
+var tokenEnv = flag.String("token-env", "KBFS_DELTA_TOKEN",
+	"the environment variable holding the token clients must present")
+var serverURL = flag.String("url", "http://127.0.0.1:16803",
+	"the server to push to or pull from")
+var blockSize = flag.Int("block-size", libdelta.DefaultBlockSize,
+	"the block size used to find changed data when pushing or pulling")
+var deleteExtra = flag.Bool("delete", false,
+	"when pushing or pulling, delete files that aren't in the source")
+
+const usageFormatStr = `Usage:
+  kbfsdelta -version
+
+To serve against remote KBFS servers:
+  kbfsdelta
+    [-listen=host:port] [-token-env=VAR]
+%s
+
+To serve in a local testing environment:
+  kbfsdelta
+    [-listen=host:port] [-token-env=VAR]
+%s
+
+To sync a local directory with a server, which needs neither KBFS
+nor Keybase on this machine:
+  kbfsdelta [-url=URL] [-token-env=VAR] [-block-size=N] [-delete]
+    push <local dir> /keybase/<type>/<name>[/<path>]
+  kbfsdelta [-url=URL] [-token-env=VAR] [-block-size=N] [-delete]
+    pull /keybase/<type>/<name>[/<path>] <local dir>
+
+Only the parts of files that changed are sent, like rsync.  The
+server only listens on loopback addresses; to sync from another
+machine, forward a port to it, e.g.:
+
+  ssh -L 16803:127.0.0.1:16803 server-with-kbfs
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func sync(token string, args []string) *libfs.Error {
+	c := &libdelta.Client{
+		URL:       *serverURL,
+		Token:     token,
+		BlockSize: *blockSize,
+		Delete:    *deleteExtra,
+	}
+	ctx := context.Background()
+	var stats libdelta.SyncStats
+	var err error
+	switch args[0] {
+	case "push":
+		stats, err = c.Push(ctx, args[1], args[2])
+	case "pull":
+		stats, err = c.Pull(ctx, args[1], args[2])
+	}
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+	fmt.Printf("%d files sent, %d up to date; "+
+		"%d bytes sent, %d bytes already there\n",
+		stats.FilesSent, stats.FilesSkipped,
+		stats.LiteralBytes, stats.CopiedBytes)
+	return nil
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	args := flag.Args()
+	isSync := len(args) == 3 && (args[0] == "push" || args[0] == "pull")
+	if len(args) > 0 && !isSync {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("bad arguments")
+	}
+
+	token := os.Getenv(*tokenEnv)
+	if token == "" {
+		return libfs.InitError(fmt.Sprintf(
+			"no token given in $%s", *tokenEnv))
+	}
+
+	if isSync {
+		return sync(token, args)
+	}
+
+	options := libdelta.StartOptions{
+		KbfsParams: *kbfsParams,
+		ListenAddr: *listenAddr,
+		Token:      token,
+	}
+
+	return libdelta.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfsdelta error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
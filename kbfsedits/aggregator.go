@@ -0,0 +1,243 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsedits
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+)
+
+// AggregationThresholds controls how an Aggregator groups edit
+// notifications into activity summaries.
+type AggregationThresholds struct {
+	// Window is how long after a writer's first edit in a folder
+	// further edits by that writer are folded into the same summary.
+	Window time.Duration
+	// MinEdits is the number of edits a writer must make within a
+	// single window before that window is worth notifying about.  0
+	// is treated as 1.
+	MinEdits int
+}
+
+// DefaultAggregationThresholds notifies about each writer at most
+// once every ten minutes per folder.
+var DefaultAggregationThresholds = AggregationThresholds{
+	Window:   10 * time.Minute,
+	MinEdits: 1,
+}
+
+// WriterActivity summarizes the edits made by a single writer in a
+// single folder during a single aggregation window.
+type WriterActivity struct {
+	FolderID tlf.ID
+	UID      keybase1.UID
+	// Start is the time of the first edit in the window; the window
+	// covers edits up to (but not including) Start plus the
+	// aggregation window.
+	Start time.Time
+	// Last is the time of the latest edit in the window.
+	Last time.Time
+	// Count is the number of distinct edits in the window.
+	Count int
+	// Filenames holds the distinct paths edited in the window, in
+	// the order they were first edited.
+	Filenames []string
+	// LatestRevision is the highest revision seen in the window.
+	LatestRevision kbfsmd.Revision
+}
+
+type writerKey struct {
+	folderID tlf.ID
+	uid      keybase1.UID
+}
+
+type notificationKey struct {
+	revision kbfsmd.Revision
+	opType   NotificationOpType
+	filename string
+}
+
+type activityWindow struct {
+	WriterActivity
+	seen      map[notificationKey]bool
+	filenames map[string]bool
+	notified  bool
+}
+
+func (w *activityWindow) contains(t time.Time, window time.Duration) bool {
+	return !t.Before(w.Start) && t.Before(w.Start.Add(window))
+}
+
+func (w *activityWindow) summary() WriterActivity {
+	wa := w.WriterActivity
+	wa.Filenames = append([]string(nil), w.Filenames...)
+	return wa
+}
+
+type activityByStart []WriterActivity
+
+func (a activityByStart) Len() int {
+	return len(a)
+}
+
+func (a activityByStart) Less(i, j int) bool {
+	if !a[i].Start.Equal(a[j].Start) {
+		return a[i].Start.Before(a[j].Start)
+	}
+	return a[i].UID.Less(a[j].UID)
+}
+
+func (a activityByStart) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+// Aggregator groups edit notifications by writer and time window, so
+// that callers (e.g., chat or GUI notifications about folder
+// activity) can notify about a burst of edits once, instead of once
+// per edit.  Windows are based on the server-reported time of each
+// notification, not the local clock, so the grouping doesn't depend
+// on when notifications arrive.
+//
+// Aggregator is goroutine-safe.
+type Aggregator struct {
+	thresholds AggregationThresholds
+
+	lock     sync.Mutex
+	byWriter map[writerKey][]*activityWindow // sorted by start time
+}
+
+// NewAggregator returns a new, empty Aggregator using the given
+// thresholds.
+func NewAggregator(thresholds AggregationThresholds) *Aggregator {
+	if thresholds.MinEdits < 1 {
+		thresholds.MinEdits = 1
+	}
+	return &Aggregator{
+		thresholds: thresholds,
+		byWriter:   make(map[writerKey][]*activityWindow),
+	}
+}
+
+// windowForLocked returns the window that `n` belongs to, creating a
+// new one if needed.
+func (a *Aggregator) windowForLocked(n NotificationMessage) *activityWindow {
+	key := writerKey{n.FolderID, n.UID}
+	windows := a.byWriter[key]
+	i := sort.Search(len(windows), func(i int) bool {
+		return windows[i].Start.After(n.Time)
+	})
+	if i > 0 && windows[i-1].contains(n.Time, a.thresholds.Window) {
+		return windows[i-1]
+	}
+
+	w := &activityWindow{
+		WriterActivity: WriterActivity{
+			FolderID: n.FolderID,
+			UID:      n.UID,
+			Start:    n.Time,
+			Last:     n.Time,
+		},
+		seen:      make(map[notificationKey]bool),
+		filenames: make(map[string]bool),
+	}
+	windows = append(windows, nil)
+	copy(windows[i+1:], windows[i:])
+	windows[i] = w
+	a.byWriter[key] = windows
+	return w
+}
+
+// AddNotifications adds the given notifications to the aggregator,
+// ignoring any that it has already seen.  It returns a summary for
+// each window that has just reached the notification threshold;
+// each window is returned at most once, so callers can notify about
+// exactly the returned summaries.  Later edits that fall into an
+// already-returned window are still counted, and show up in the
+// results of Activity.
+func (a *Aggregator) AddNotifications(
+	edits []NotificationMessage) (due []WriterActivity) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var dueWindows []*activityWindow
+	for _, n := range edits {
+		w := a.windowForLocked(n)
+		nk := notificationKey{n.Revision, n.Type, n.Filename}
+		if w.seen[nk] {
+			continue
+		}
+		w.seen[nk] = true
+		w.Count++
+		if n.Time.After(w.Last) {
+			w.Last = n.Time
+		}
+		if n.Revision > w.LatestRevision {
+			w.LatestRevision = n.Revision
+		}
+		if n.Filename != "" && !w.filenames[n.Filename] {
+			w.filenames[n.Filename] = true
+			w.Filenames = append(w.Filenames, n.Filename)
+		}
+		if !w.notified && w.Count >= a.thresholds.MinEdits {
+			w.notified = true
+			dueWindows = append(dueWindows, w)
+		}
+	}
+
+	// Summarize only once all the edits are in, so the returned
+	// summaries are complete as of this call.
+	for _, w := range dueWindows {
+		due = append(due, w.summary())
+	}
+	sort.Stable(activityByStart(due))
+	return due
+}
+
+// Activity returns summaries of all windows in the given folder that
+// contain an edit at or after `since`, and that have reached the
+// notification threshold, sorted by start time.
+func (a *Aggregator) Activity(
+	folderID tlf.ID, since time.Time) []WriterActivity {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var ret []WriterActivity
+	for key, windows := range a.byWriter {
+		if key.folderID != folderID {
+			continue
+		}
+		for _, w := range windows {
+			if w.notified && !w.Last.Before(since) {
+				ret = append(ret, w.summary())
+			}
+		}
+	}
+	sort.Stable(activityByStart(ret))
+	return ret
+}
+
+// Prune forgets all windows whose latest edit is before `before`.
+// Notifications for those windows that arrive later will start new
+// windows.
+func (a *Aggregator) Prune(before time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for key, windows := range a.byWriter {
+		kept := windows[:0]
+		for _, w := range windows {
+			if !w.Last.Before(before) {
+				kept = append(kept, w)
+			}
+		}
+		if len(kept) == 0 {
+			delete(a.byWriter, key)
+			continue
+		}
+		a.byWriter[key] = kept
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsedits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregator(t *testing.T) {
+	alice := keybase1.MakeTestUID(1)
+	bob := keybase1.MakeTestUID(2)
+	tlfID := tlf.FakeID(1, tlf.Private)
+	a := NewAggregator(AggregationThresholds{
+		Window:   10 * time.Second,
+		MinEdits: 2,
+	})
+
+	makeN := func(filename string, rev int, uid keybase1.UID) NotificationMessage {
+		n := makeTestNotification(
+			filename, NotificationModify, EntryTypeFile, kbfsmd.Revision(rev), uid)
+		n.FolderID = tlfID
+		return n
+	}
+
+	// One edit isn't enough to notify about.
+	due := a.AddNotifications([]NotificationMessage{makeN("/a", 1, alice)})
+	require.Len(t, due, 0)
+
+	// A duplicate doesn't count towards the threshold.
+	due = a.AddNotifications([]NotificationMessage{makeN("/a", 1, alice)})
+	require.Len(t, due, 0)
+
+	// The second edit in the window triggers exactly one summary.
+	due = a.AddNotifications([]NotificationMessage{
+		makeN("/b", 2, alice),
+		makeN("/a", 3, alice),
+		makeN("/c", 4, bob),
+	})
+	require.Len(t, due, 1)
+	require.Equal(t, alice, due[0].UID)
+	require.Equal(t, 3, due[0].Count)
+	require.Equal(t, []string{"/a", "/b"}, due[0].Filenames)
+	require.Equal(t, kbfsmd.Revision(3), due[0].LatestRevision)
+
+	// More edits in the same window are folded in silently.
+	due = a.AddNotifications([]NotificationMessage{
+		makeN("/d", 5, alice),
+		makeN("/c", 6, bob),
+	})
+	require.Len(t, due, 1)
+	require.Equal(t, bob, due[0].UID)
+
+	// An edit outside the window starts a new one.
+	due = a.AddNotifications([]NotificationMessage{
+		makeN("/e", 11, alice),
+		makeN("/f", 12, alice),
+	})
+	require.Len(t, due, 1)
+	require.Equal(t, time.Unix(11, 0), due[0].Start)
+
+	activity := a.Activity(tlfID, time.Unix(0, 0))
+	require.Len(t, activity, 3)
+	require.Equal(t, alice, activity[0].UID)
+	require.Equal(t, 4, activity[0].Count)
+	require.Equal(t, bob, activity[1].UID)
+	require.Equal(t, time.Unix(11, 0), activity[2].Start)
+
+	require.Len(t, a.Activity(tlfID, time.Unix(7, 0)), 1)
+	require.Len(t, a.Activity(tlf.FakeID(2, tlf.Private), time.Unix(0, 0)), 0)
+
+	a.Prune(time.Unix(10, 0))
+	activity = a.Activity(tlfID, time.Unix(0, 0))
+	require.Len(t, activity, 1)
+	require.Equal(t, time.Unix(11, 0), activity[0].Start)
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsedits
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type notificationsByRevision []NotificationMessage
+
+func (n notificationsByRevision) Len() int {
+	return len(n)
+}
+
+func (n notificationsByRevision) Less(i, j int) bool {
+	return n[i].Revision < n[j].Revision
+}
+
+func (n notificationsByRevision) Swap(i, j int) {
+	n[i], n[j] = n[j], n[i]
+}
+
+// FileHistory indexes edit notifications by the path they affect, so
+// that the history of a single file can be looked up without
+// scanning every notification in the TLF.  Renames are followed: once
+// a file (or directory) is renamed, its earlier history is kept under
+// its new path.
+//
+// FileHistory is goroutine-safe.
+type FileHistory struct {
+	maxPerFile int
+
+	lock   sync.RWMutex
+	byPath map[string]notificationsByRevision
+}
+
+// NewFileHistory returns a new, empty FileHistory that keeps at most
+// `maxPerFile` notifications for each path (or all of them, if
+// `maxPerFile` is 0).
+func NewFileHistory(maxPerFile int) *FileHistory {
+	return &FileHistory{
+		maxPerFile: maxPerFile,
+		byPath:     make(map[string]notificationsByRevision),
+	}
+}
+
+func isSameNotification(a, b NotificationMessage) bool {
+	return a.Revision == b.Revision && a.Type == b.Type &&
+		a.Filename == b.Filename
+}
+
+// moveLocked moves the history of `oldPath` to `newPath`, along
+// with the history of everything under `oldPath` if it's a
+// directory.
+func (fh *FileHistory) moveLocked(oldPath, newPath string, isDir bool) {
+	move := func(from, to string) {
+		if len(fh.byPath[from]) == 0 {
+			return
+		}
+		moved := append(fh.byPath[to], fh.byPath[from]...)
+		sort.Stable(moved)
+		fh.byPath[to] = fh.trimLocked(moved)
+		delete(fh.byPath, from)
+	}
+	move(oldPath, newPath)
+	if !isDir {
+		return
+	}
+	prefix := oldPath + "/"
+	for p := range fh.byPath {
+		if strings.HasPrefix(p, prefix) {
+			move(p, path.Join(newPath, strings.TrimPrefix(p, prefix)))
+		}
+	}
+}
+
+// trimLocked drops the oldest notifications from `history` if it's
+// longer than the per-file limit.
+func (fh *FileHistory) trimLocked(
+	history notificationsByRevision) notificationsByRevision {
+	if fh.maxPerFile > 0 && len(history) > fh.maxPerFile {
+		return history[len(history)-fh.maxPerFile:]
+	}
+	return history
+}
+
+func (fh *FileHistory) addLocked(n NotificationMessage) {
+	if n.Filename == "" {
+		return
+	}
+	if n.Type == NotificationRename && n.Params != nil &&
+		n.Params.OldFilename != "" {
+		fh.moveLocked(n.Params.OldFilename, n.Filename,
+			n.FileType == EntryTypeDir)
+	}
+
+	history := fh.byPath[n.Filename]
+	for _, existing := range history {
+		if isSameNotification(existing, n) {
+			return
+		}
+	}
+	// Notifications usually arrive in order, but not always.
+	i := sort.Search(len(history), func(i int) bool {
+		return history[i].Revision > n.Revision
+	})
+	history = append(history, NotificationMessage{})
+	copy(history[i+1:], history[i:])
+	history[i] = n
+	fh.byPath[n.Filename] = fh.trimLocked(history)
+}
+
+// AddNotifications adds the given notifications to the index,
+// ignoring any that are already in it.
+func (fh *FileHistory) AddNotifications(edits []NotificationMessage) {
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+	for _, n := range edits {
+		fh.addLocked(n)
+	}
+}
+
+// History returns the notifications for the given path (including
+// those from before it was renamed to that path), most recent first.
+func (fh *FileHistory) History(filename string) []NotificationMessage {
+	fh.lock.RLock()
+	defer fh.lock.RUnlock()
+	history := fh.byPath[filename]
+	ret := make([]NotificationMessage, len(history))
+	for i, n := range history {
+		ret[len(history)-1-i] = n
+	}
+	return ret
+}
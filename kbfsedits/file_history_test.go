@@ -0,0 +1,119 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsedits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestNotification(filename string, t NotificationOpType,
+	fileType EntryType, rev kbfsmd.Revision,
+	uid keybase1.UID) NotificationMessage {
+	return NotificationMessage{
+		Version:  NotificationV2,
+		Filename: filename,
+		Type:     t,
+		Time:     time.Unix(int64(rev), 0),
+		FileType: fileType,
+		Revision: rev,
+		UID:      uid,
+		FolderID: tlf.FakeID(1, tlf.SingleTeam),
+	}
+}
+
+func historyRevisions(history []NotificationMessage) []kbfsmd.Revision {
+	revs := make([]kbfsmd.Revision, 0, len(history))
+	for _, n := range history {
+		revs = append(revs, n.Revision)
+	}
+	return revs
+}
+
+func TestFileHistory(t *testing.T) {
+	alice := keybase1.MakeTestUID(1)
+	bob := keybase1.MakeTestUID(2)
+	fh := NewFileHistory(0)
+
+	fh.AddNotifications([]NotificationMessage{
+		makeTestNotification(
+			"/keybase/team/t/a", NotificationCreate, EntryTypeFile, 1, alice),
+		makeTestNotification(
+			"/keybase/team/t/b", NotificationCreate, EntryTypeFile, 2, alice),
+		makeTestNotification(
+			"/keybase/team/t/a", NotificationModify, EntryTypeFile, 4, bob),
+	})
+	// Out of order, and a duplicate.
+	fh.AddNotifications([]NotificationMessage{
+		makeTestNotification(
+			"/keybase/team/t/a", NotificationModify, EntryTypeFile, 3, alice),
+		makeTestNotification(
+			"/keybase/team/t/a", NotificationModify, EntryTypeFile, 4, bob),
+	})
+
+	history := fh.History("/keybase/team/t/a")
+	require.Equal(t, []kbfsmd.Revision{4, 3, 1}, historyRevisions(history))
+	require.Equal(t, bob, history[0].UID)
+	require.Equal(t, NotificationCreate, history[2].Type)
+	require.Equal(t, []kbfsmd.Revision{2},
+		historyRevisions(fh.History("/keybase/team/t/b")))
+	require.Len(t, fh.History("/keybase/team/t/c"), 0)
+}
+
+func TestFileHistoryRename(t *testing.T) {
+	alice := keybase1.MakeTestUID(1)
+	fh := NewFileHistory(0)
+
+	rename := makeTestNotification(
+		"/keybase/team/t/e", NotificationRename, EntryTypeDir, 3, alice)
+	rename.Params = &NotificationParams{OldFilename: "/keybase/team/t/d"}
+	fh.AddNotifications([]NotificationMessage{
+		makeTestNotification(
+			"/keybase/team/t/d", NotificationCreate, EntryTypeDir, 1, alice),
+		makeTestNotification(
+			"/keybase/team/t/d/f", NotificationCreate, EntryTypeFile, 2, alice),
+		rename,
+		makeTestNotification(
+			"/keybase/team/t/e/f", NotificationModify, EntryTypeFile, 4, alice),
+	})
+
+	require.Equal(t, []kbfsmd.Revision{3, 1},
+		historyRevisions(fh.History("/keybase/team/t/e")))
+	require.Equal(t, []kbfsmd.Revision{4, 2},
+		historyRevisions(fh.History("/keybase/team/t/e/f")))
+	require.Len(t, fh.History("/keybase/team/t/d"), 0)
+	require.Len(t, fh.History("/keybase/team/t/d/f"), 0)
+}
+
+func TestFileHistoryMaxPerFile(t *testing.T) {
+	alice := keybase1.MakeTestUID(1)
+	fh := NewFileHistory(2)
+	for rev := kbfsmd.Revision(1); rev <= 5; rev++ {
+		fh.AddNotifications([]NotificationMessage{makeTestNotification(
+			"/keybase/team/t/a", NotificationModify, EntryTypeFile, rev,
+			alice)})
+	}
+	require.Equal(t, []kbfsmd.Revision{5, 4},
+		historyRevisions(fh.History("/keybase/team/t/a")))
+}
+
+func TestReadNotifications(t *testing.T) {
+	edits := []NotificationMessage{makeTestNotification(
+		"/keybase/team/t/a", NotificationCreate, EntryTypeFile, 1,
+		keybase1.MakeTestUID(1))}
+	body, err := Prepare(edits)
+	require.NoError(t, err)
+	read, err := ReadNotifications(body)
+	require.NoError(t, err)
+	require.Len(t, read, 1)
+	require.Equal(t, edits[0].Filename, read[0].Filename)
+	require.Equal(t, edits[0].Revision, read[0].Revision)
+	require.True(t, edits[0].Time.Equal(read[0].Time))
+}
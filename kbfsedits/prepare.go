@@ -15,3 +15,14 @@ func Prepare(edits []NotificationMessage) (string, error) {
 	}
 	return string(buf), nil
 }
+
+// ReadNotifications converts a string generated by Prepare back into
+// a slice of notifications.
+func ReadNotifications(body string) ([]NotificationMessage, error) {
+	var edits []NotificationMessage
+	err := json.Unmarshal([]byte(body), &edits)
+	if err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
@@ -24,6 +24,7 @@ var runtimeDir = flag.String("runtime-dir", os.Getenv("KEYBASE_RUNTIME_DIR"), "r
 var label = flag.String("label", os.Getenv("KEYBASE_LABEL"), "label to help identify if running as a service")
 var mountType = flag.String("mount-type", defaultMountType, "mount type: default, force, none")
 var version = flag.Bool("version", false, "Print version")
+var tlfToMount = flag.String("tlf", "", "only mount this TLF (e.g., team/acme) at the mountpoint, instead of the whole KBFS tree")
 
 const usageFormatStr = `Usage:
   kbfsfuse -version
@@ -32,13 +33,15 @@ To run against remote KBFS servers:
   kbfsfuse
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=default|force|required|none]
 %s
-    %s[/path/to/mountpoint]
+    %s%s[-tlf=type/name] [-tlf-mount=type/name=/path/to/dir ...]
+    [/path/to/mountpoint]
 
 To run in a local testing environment:
   kbfsfuse
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=default|force|required|none]
 %s
-    %s[/path/to/mountpoint]
+    %s%s[-tlf=type/name] [-tlf-mount=type/name=/path/to/dir ...]
+    [/path/to/mountpoint]
 
 Defaults:
 %s `
@@ -47,10 +50,12 @@ func getUsageString(ctx libkbfs.Context) string {
 	remoteUsageStr := libkbfs.GetRemoteUsageString()
 	localUsageStr := libkbfs.GetLocalUsageString()
 	platformUsageStr := libfuse.GetPlatformUsageString()
+	ownershipUsageStr := libfuse.GetOwnershipUsageString() +
+		libfuse.GetCacheUsageString()
 	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
 	return fmt.Sprintf(usageFormatStr,
-		remoteUsageStr, platformUsageStr,
-		localUsageStr, platformUsageStr, defaultUsageStr)
+		remoteUsageStr, platformUsageStr, ownershipUsageStr,
+		localUsageStr, platformUsageStr, ownershipUsageStr, defaultUsageStr)
 }
 
 func start() *libfs.Error {
@@ -58,6 +63,10 @@ func start() *libfs.Error {
 
 	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
 	platformParams := libfuse.AddPlatformFlags(flag.CommandLine)
+	ownershipParams := libfuse.AddOwnershipFlags(flag.CommandLine)
+	cacheParams := libfuse.AddCacheFlags(flag.CommandLine)
+	var tlfMounts libfuse.TLFMounts
+	flag.Var(&tlfMounts, "tlf-mount", "also mount a single TLF at its own directory, given as <type>/<name>=<dir> (may be repeated)")
 
 	flag.Parse()
 
@@ -81,6 +90,12 @@ func start() *libfs.Error {
 		mountDir = flag.Arg(0)
 	}
 
+	if *tlfToMount != "" {
+		if _, _, err := libfs.ParseTlfPath(*tlfToMount); err != nil {
+			return libfs.InitError(err.Error())
+		}
+	}
+
 	if len(flag.Args()) > 1 {
 		fmt.Print(getUsageString(ctx))
 		return libfs.InitError("extra arguments specified (flags go before the first argument)")
@@ -96,12 +111,16 @@ func start() *libfs.Error {
 	options := libfuse.StartOptions{
 		KbfsParams:        *kbfsParams,
 		PlatformParams:    *platformParams,
+		Ownership:         *ownershipParams,
+		Cache:             *cacheParams,
 		RuntimeDir:        *runtimeDir,
 		Label:             *label,
 		ForceMount:        *mountType == "force" || *mountType == "required",
 		MountErrorIsFatal: *mountType == "required",
 		SkipMount:         *mountType == "none",
 		MountPoint:        mountDir,
+		TLF:               *tlfToMount,
+		TLFMounts:         tlfMounts,
 	}
 
 	return libfuse.Start(options, ctx)
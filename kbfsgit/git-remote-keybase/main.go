@@ -31,6 +31,9 @@ const usageFormatStr = `Usage:
 To run against remote KBFS servers:
   git-remote-keybase %s <remote> [keybase://<repo>]
 
+To run as a git-lfs custom transfer agent:
+  git-remote-keybase %s lfs <remote> keybase://<repo>
+
 To run in a local testing environment:
   git-remote-keybase %s <remote> [keybase://<repo>]
 
@@ -42,8 +45,8 @@ func getUsageString(ctx libkbfs.Context) string {
 	remoteUsageStr := libkbfs.GetRemoteUsageString()
 	localUsageStr := libkbfs.GetLocalUsageString()
 	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
-	return fmt.Sprintf(
-		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+	return fmt.Sprintf(usageFormatStr, remoteUsageStr, remoteUsageStr,
+		localUsageStr, defaultUsageStr)
 }
 
 func getLocalGitDir() (gitDir string) {
@@ -145,13 +148,26 @@ func start() (startErr *libfs.Error) {
 		return libfs.InitError("no remote repo specified")
 	}
 
-	remote := flag.Arg(0)
+	args := flag.Args()
+	lfs := false
+	if len(args) == 3 && args[0] == "lfs" {
+		// git-lfs doesn't pass the remote to custom transfer agents,
+		// so it has to be configured in the agent's args, e.g.:
+		//   git config lfs.standalonetransferagent keybase
+		//   git config lfs.customtransfer.keybase.path git-remote-keybase
+		//   git config lfs.customtransfer.keybase.args \
+		//     "lfs origin keybase://private/user/reponame"
+		lfs = true
+		args = args[1:]
+	}
+
+	remote := args[0]
 	var repo string
-	if len(flag.Args()) > 1 {
-		repo = flag.Arg(1)
+	if len(args) > 1 {
+		repo = args[1]
 	}
 
-	if len(flag.Args()) > 2 {
+	if len(args) > 2 {
 		fmt.Print(getUsageString(kbCtx))
 		return libfs.InitError("extra arguments specified (flags go before the first argument)")
 	}
@@ -161,6 +177,7 @@ func start() (startErr *libfs.Error) {
 		Remote:     remote,
 		Repo:       repo,
 		GitDir:     getLocalGitDir(),
+		LFS:        lfs,
 	}
 
 	ctx := context.Background()
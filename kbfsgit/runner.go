@@ -27,12 +27,14 @@ import (
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 	billy "gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-billy.v4/osfs"
 	gogit "gopkg.in/src-d/go-git.v4"
 	gogitcfg "gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	gogitobj "gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
 	gogitstor "gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
@@ -48,6 +50,7 @@ const (
 	gitOptionVerbosity = "verbosity"
 	gitOptionProgress  = "progress"
 	gitOptionCloning   = "cloning"
+	gitOptionDepth     = "depth"
 	gitOptionPushcert  = "pushcert"
 	gitOptionIfAsked   = "if-asked"
 
@@ -91,8 +94,18 @@ const (
 
 	unlockPrintBytesStatusThreshold = time.Second / 2
 	gcPrintStatusThreshold          = time.Second
+	bytesPrintStatusInterval        = time.Second / 10
 
 	maxCommitsToVisitPerRef = 20
+
+	// defaultCopyWorkers is the number of files copied in parallel
+	// when transferring whole directories between the local repo and
+	// KBFS.  It can be overridden with the `copyWorkersEnv`
+	// environment variable.
+	defaultCopyWorkers = 10
+	copyWorkersEnv     = "KBFSGIT_COPY_WORKERS"
+	// copyBufferSize is the size of the buffer each copy worker uses.
+	copyBufferSize = 512 * 1024
 )
 
 type ctxCommandTagKey int
@@ -117,6 +130,12 @@ type runner struct {
 	verbosity int64
 	progress  bool
 	cloning   bool
+	// depth limits fetches to this many commits of history, if
+	// positive.
+	depth int
+	// copyWorkers is the number of files copied in parallel by
+	// `recursiveCopy`.
+	copyWorkers int
 
 	logSync     sync.Once
 	logSyncDone sync.Once
@@ -171,19 +190,30 @@ func newRunner(ctx context.Context, config libkbfs.Config,
 	}
 	uniqID := fmt.Sprintf("%s-%d", session.VerifyingKey.String(), os.Getpid())
 
+	copyWorkers := defaultCopyWorkers
+	if envWorkers := os.Getenv(copyWorkersEnv); envWorkers != "" {
+		n, err := strconv.Atoi(envWorkers)
+		if err != nil || n < 1 {
+			return nil, errors.Errorf(
+				"Invalid %s value: %q", copyWorkersEnv, envWorkers)
+		}
+		copyWorkers = n
+	}
+
 	return &runner{
-		config:    config,
-		log:       config.MakeLogger(""),
-		h:         h,
-		remote:    remote,
-		repo:      parts[2],
-		gitDir:    gitDir,
-		uniqID:    uniqID,
-		input:     input,
-		output:    output,
-		errput:    errput,
-		verbosity: 1,
-		progress:  true,
+		config:      config,
+		log:         config.MakeLogger(""),
+		h:           h,
+		remote:      remote,
+		repo:        parts[2],
+		gitDir:      gitDir,
+		uniqID:      uniqID,
+		input:       input,
+		output:      output,
+		errput:      errput,
+		verbosity:   1,
+		progress:    true,
+		copyWorkers: copyWorkers,
 	}, nil
 }
 
@@ -732,6 +762,33 @@ func (r *runner) printJournalStatusUntilFlushed(
 		ctx, jServer, rootNode.GetFolderBranch().Tlf, doneCh)
 }
 
+// gogitStatusString returns the progress text for `update`, along
+// with the number of bytes read from and written to KBFS so far
+// during the current stage.
+func gogitStatusString(
+	update plumbing.StatusUpdate, bytesRead, bytesWritten int64) string {
+	var str string
+	switch update.Stage {
+	case plumbing.StatusCount:
+		str = fmt.Sprintf(
+			"%s objects", humanizeObjects(update.ObjectsTotal, 1))
+	case plumbing.StatusSort:
+		return ""
+	default:
+		str = fmt.Sprintf(
+			"(%.2f%%) %s objects",
+			percent(int64(update.ObjectsDone), int64(update.ObjectsTotal)),
+			humanizeObjects(update.ObjectsDone, update.ObjectsTotal))
+	}
+	if bytesRead > 0 {
+		str += fmt.Sprintf(", %s read", humanizeBytes(bytesRead, 1))
+	}
+	if bytesWritten > 0 {
+		str += fmt.Sprintf(", %s written", humanizeBytes(bytesWritten, 1))
+	}
+	return str + "... "
+}
+
 func (r *runner) processGogitStatus(ctx context.Context,
 	statusChan <-chan plumbing.StatusUpdate, fsEvents <-chan libfs.FSEvent) {
 	if r.h.Type() == tlf.Public {
@@ -739,7 +796,26 @@ func (r *runner) processGogitStatus(ctx context.Context,
 	}
 
 	currStage := plumbing.StatusUnknown
+	var lastUpdate plumbing.StatusUpdate
+	var bytesRead, bytesWritten int64
+	// stageOpen is false once a stage's "done." has been printed, after
+	// which there's no status line left to update until the next stage.
+	stageOpen := false
 	lastByteCount := 0
+	var lastPrintTime time.Time
+	// needPrint is true when bytes have been read or written since
+	// the status line was last printed.
+	needPrint := false
+	printStatus := func() {
+		needPrint = false
+		eraseStr := strings.Repeat("\b", lastByteCount)
+		newStr := gogitStatusString(lastUpdate, bytesRead, bytesWritten)
+		lastByteCount = len(newStr)
+		lastPrintTime = r.config.Clock().Now()
+		if r.progress {
+			r.errput.Write([]byte(eraseStr + newStr))
+		}
+	}
 	for {
 		if statusChan == nil && fsEvents == nil {
 			// statusChan is never passed in as nil. So if it's nil, it's been
@@ -751,10 +827,17 @@ func (r *runner) processGogitStatus(ctx context.Context,
 		select {
 		case update, ok := <-statusChan:
 			if !ok {
+				// Nothing is left to report once the status channel
+				// is closed, so stop waiting for FS events too.
 				statusChan = nil
+				fsEvents = nil
 				continue
 			}
 			if update.Stage != currStage {
+				if stageOpen && needPrint {
+					// Show the final byte counts for the stage.
+					printStatus()
+				}
 				if currStage != plumbing.StatusUnknown {
 					r.printStageEndIfNeeded(ctx)
 				}
@@ -764,36 +847,23 @@ func (r *runner) processGogitStatus(ctx context.Context,
 					fmt.Sprintf("cpu.%d.prof", update.Stage),
 				)
 				lastByteCount = 0
+				bytesRead, bytesWritten = 0, 0
+				stageOpen = true
 				currStage = update.Stage
 				if stage, ok := gogitStagesToStatus[update.Stage]; ok {
 					r.log.CDebugf(ctx, "Entering stage: %s - %d total objects",
 						stage, update.ObjectsTotal)
 				}
 			}
-			eraseStr := strings.Repeat("\b", lastByteCount)
-			newStr := ""
 
-			switch update.Stage {
-			case plumbing.StatusDone:
+			if update.Stage == plumbing.StatusDone {
 				r.log.CDebugf(ctx, "Status processing done")
+				r.log.CDebugf(ctx, "Read %d bytes and wrote %d bytes "+
+					"in the last stage", bytesRead, bytesWritten)
 				return
-			case plumbing.StatusCount:
-				newStr = fmt.Sprintf(
-					"%s objects... ", humanizeObjects(update.ObjectsTotal, 1))
-			case plumbing.StatusSort:
-			default:
-				newStr = fmt.Sprintf(
-					"(%.2f%%) %s objects... ",
-					percent(int64(update.ObjectsDone), int64(update.ObjectsTotal)),
-					humanizeObjects(update.ObjectsDone, update.ObjectsTotal))
 			}
-
-			lastByteCount = len(newStr)
-			if r.progress {
-				r.errput.Write([]byte(eraseStr + newStr))
-			}
-
-			currStage = update.Stage
+			lastUpdate = update
+			printStatus()
 		case fsEvent, ok := <-fsEvents:
 			if !ok {
 				fsEvents = nil
@@ -802,6 +872,7 @@ func (r *runner) processGogitStatus(ctx context.Context,
 			switch fsEvent.EventType {
 			case libfs.FSEventLock, libfs.FSEventUnlock:
 				r.printStageEndIfNeeded(ctx)
+				stageOpen = false
 				// Since we flush all blocks in Lock, subsequent calls to
 				// Lock/Unlock normally don't take much time. So we only print
 				// journal status if it's been longer than
@@ -816,6 +887,19 @@ func (r *runner) processGogitStatus(ctx context.Context,
 				case <-ctx.Done():
 					timer.Stop()
 				}
+			case libfs.FSEventRead, libfs.FSEventWrite:
+				if fsEvent.EventType == libfs.FSEventRead {
+					bytesRead += fsEvent.Bytes
+				} else {
+					bytesWritten += fsEvent.Bytes
+				}
+				// Reads and writes come in small chunks, so limit how
+				// often they redraw the status line.
+				needPrint = true
+				if stageOpen && r.config.Clock().Now().Sub(lastPrintTime) >=
+					bytesPrintStatusInterval {
+					printStatus()
+				}
 			}
 		}
 	}
@@ -823,6 +907,23 @@ func (r *runner) processGogitStatus(ctx context.Context,
 	r.printStageEndIfNeeded(ctx)
 }
 
+// processGogitStatusWithEvents is like processGogitStatus, but it
+// also reports the bytes read from and written to `fs`, and the
+// journal flushes that happen when files in `fs` are locked.
+func (r *runner) processGogitStatusWithEvents(ctx context.Context,
+	statusChan <-chan plumbing.StatusUpdate, fs *libfs.FS) {
+	events := make(chan libfs.FSEvent)
+	fs.SubscribeToEvents(events)
+	r.processGogitStatus(ctx, statusChan, events)
+	// Keep draining events until the unsubscribe closes the channel,
+	// since senders block on it.
+	go func() {
+		for range events {
+		}
+	}()
+	fs.UnsubscribeToEvents(events)
+}
+
 // recursiveByteCount returns a sum of the size of all files under the
 // directory represented by `fs`.  It also returns the length of the
 // last string it printed to `r.errput` as `toErase`, to aid in
@@ -866,37 +967,53 @@ func (r *runner) recursiveByteCount(
 	return bytes, toErase, nil
 }
 
-// statusWriter is a simple io.Writer shim that logs to `r.errput` the
-// number of bytes written to `output`.
+// statusWriter tracks the number of bytes copied so far, and logs
+// the progress to `r.errput`.  It is safe to share between
+// goroutines copying files in parallel.
 type statusWriter struct {
-	r           *runner
-	output      io.Writer
+	r          *runner
+	totalBytes int64
+
+	lock        sync.Mutex
 	soFar       int64
-	totalBytes  int64
 	nextToErase int
 }
 
-var _ io.Writer = (*statusWriter)(nil)
-
-func (sw *statusWriter) Write(p []byte) (n int, err error) {
-	n, err = sw.output.Write(p)
-	if err != nil {
-		return n, err
-	}
-
-	sw.soFar += int64(len(p))
+func (sw *statusWriter) report(n int) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	sw.soFar += int64(n)
 	eraseStr := strings.Repeat("\b", sw.nextToErase)
 	newStr := fmt.Sprintf("(%.2f%%) %s... ",
 		percent(sw.soFar, sw.totalBytes),
 		humanizeBytes(sw.soFar, sw.totalBytes))
 	sw.r.errput.Write([]byte(eraseStr + newStr))
 	sw.nextToErase = len(newStr)
+}
+
+// statusFileWriter is a simple io.Writer shim that reports the
+// number of bytes written to `output` to a shared `statusWriter`.
+type statusFileWriter struct {
+	sw     *statusWriter
+	output io.Writer
+}
+
+var _ io.Writer = statusFileWriter{}
+
+func (sfw statusFileWriter) Write(p []byte) (n int, err error) {
+	n, err = sfw.output.Write(p)
+	if err != nil {
+		return n, err
+	}
+	sfw.sw.report(n)
 	return n, nil
 }
 
+// copyFile copies `name` from `from` to `to`, using `buf` as the
+// intermediate buffer (or a newly-allocated one if `buf` is nil).
 func (r *runner) copyFile(
 	ctx context.Context, from billy.Filesystem, to billy.Filesystem,
-	name string, sw *statusWriter) (err error) {
+	name string, sw *statusWriter, buf []byte) (err error) {
 	f, err := from.Open(name)
 	if err != nil {
 		return err
@@ -912,10 +1029,12 @@ func (r *runner) copyFile(
 	// Wrap the destination file in a status shim if we are supposed
 	// to report progress.
 	if sw != nil && r.progress {
-		sw.output = toF
-		w = sw
+		w = statusFileWriter{sw, toF}
 	}
-	_, err = io.Copy(w, f)
+	// Hide any `WriterTo`/`ReaderFrom` implementations, so the copy
+	// is guaranteed to go through `buf`.
+	_, err = io.CopyBuffer(
+		struct{ io.Writer }{w}, struct{ io.Reader }{f}, buf)
 	return err
 }
 
@@ -941,13 +1060,13 @@ func (r *runner) copyFileWithCount(
 			ctx, startTime, fmt.Sprintf("mem.%s.prof", countingProf), "")
 		r.errput.Write([]byte("done." + elapsedStr + "\n"))
 
-		sw = &statusWriter{r, nil, 0, fi.Size(), 0}
+		sw = &statusWriter{r: r, totalBytes: fi.Size()}
 		r.errput.Write([]byte(fmt.Sprintf("%s: ", copyingText)))
 	}
 
 	// Copy the file directly into the other file system.
 	startTime := r.config.Clock().Now()
-	err := r.copyFile(ctx, from, to, name, sw)
+	err := r.copyFile(ctx, from, to, name, sw, nil)
 	if err != nil {
 		return err
 	}
@@ -960,11 +1079,20 @@ func (r *runner) copyFileWithCount(
 	return nil
 }
 
-// recursiveCopy copies the entire subdirectory rooted at `fs` to
-// `localFS`.
-func (r *runner) recursiveCopy(
+// copyJob describes a single file to be copied by one of the
+// `recursiveCopy` workers.
+type copyJob struct {
+	from billy.Filesystem
+	to   billy.Filesystem
+	name string
+}
+
+// walkCopyJobs walks the entire subdirectory rooted at `from`,
+// creating any needed directories under `to`, and sends a job down
+// `jobs` for every file that needs to be copied.
+func (r *runner) walkCopyJobs(
 	ctx context.Context, from billy.Filesystem, to billy.Filesystem,
-	sw *statusWriter) (err error) {
+	jobs chan<- copyJob) (err error) {
 	fileInfos, err := from.ReadDir("")
 	if err != nil {
 		return err
@@ -987,18 +1115,58 @@ func (r *runner) recursiveCopy(
 			if err != nil {
 				return err
 			}
-			err = r.recursiveCopy(ctx, chrootFrom, chrootTo, sw)
+			err = r.walkCopyJobs(ctx, chrootFrom, chrootTo, jobs)
 			if err != nil {
 				return err
 			}
 		} else {
-			err := r.copyFile(ctx, from, to, fi.Name(), sw)
+			select {
+			case jobs <- copyJob{from, to, fi.Name()}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// recursiveCopy copies the entire subdirectory rooted at `from` to
+// `to`.  Files are copied in parallel by `r.copyWorkers` workers,
+// each with its own fixed-size copy buffer, so the memory used by
+// the copy itself doesn't grow with the size of the repo.
+func (r *runner) recursiveCopy(
+	ctx context.Context, from billy.Filesystem, to billy.Filesystem,
+	sw *statusWriter) (err error) {
+	numWorkers := r.copyWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	eg, groupCtx := errgroup.WithContext(ctx)
+	jobs := make(chan copyJob, numWorkers)
+	eg.Go(func() error {
+		defer close(jobs)
+		return r.walkCopyJobs(groupCtx, from, to, jobs)
+	})
+
+	worker := func() error {
+		buf := make([]byte, copyBufferSize)
+		for job := range jobs {
+			if err := groupCtx.Err(); err != nil {
+				return err
+			}
+			err := r.copyFile(
+				groupCtx, job.from, job.to, job.name, sw, buf)
 			if err != nil {
 				return err
 			}
 		}
+		return nil
 	}
-	return nil
+	for i := 0; i < numWorkers; i++ {
+		eg.Go(worker)
+	}
+	return eg.Wait()
 }
 
 func (r *runner) recursiveCopyWithCounts(
@@ -1018,7 +1186,7 @@ func (r *runner) recursiveCopyWithCounts(
 			ctx, startTime, fmt.Sprintf("mem.%s.prof", countingProf), "")
 		r.errput.Write([]byte("done." + elapsedStr + "\n"))
 
-		sw = &statusWriter{r, nil, 0, b, 0}
+		sw = &statusWriter{r: r, totalBytes: b}
 		r.errput.Write([]byte(fmt.Sprintf("%s: ", copyingText)))
 	}
 
@@ -1137,9 +1305,9 @@ func (r *runner) checkGC(ctx context.Context) (err error) {
 // handleClone copies all the object files of a KBFS repo directly
 // into the local git dir, instead of using go-git to calculate the
 // full set of objects that are to be transferred (which is slow and
-// memory inefficient).  If the user requested only a single branch of
-// cloning, this will copy more objects that necessary, but still only
-// a single ref will show up for the user.  TODO: Maybe we should run
+// memory inefficient).  It's only used when the clone fetches every
+// ref, since otherwise (e.g., for single-branch or shallow clones) it
+// would copy more objects than necessary.  TODO: Maybe we should run
 // `git gc` for the user on the local repo?
 func (r *runner) handleClone(ctx context.Context) (err error) {
 	_, _, err = r.initRepoIfNeeded(ctx, "clone")
@@ -1197,11 +1365,40 @@ func (r *runner) handleClone(ctx context.Context) (err error) {
 // suitably updated.
 func (r *runner) handleFetchBatch(ctx context.Context, args [][]string) (
 	err error) {
-	repo, _, err := r.initRepoIfNeeded(ctx, gitCmdFetch)
+	repo, fs, err := r.initRepoIfNeeded(ctx, gitCmdFetch)
 	if err != nil {
 		return err
 	}
 
+	if r.depth > 0 {
+		err = r.fetchShallow(ctx, repo, fs, args)
+	} else {
+		err = r.fetchFull(ctx, repo, fs, args)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.waitForJournal(ctx)
+	if err != nil {
+		return err
+	}
+	r.log.CDebugf(ctx, "Done waiting for journal")
+
+	err = r.checkGC(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.output.Write([]byte("\n"))
+	return err
+}
+
+// fetchFull fetches the complete history of each ref in `args` from
+// the KBFS repo into the local repo.
+func (r *runner) fetchFull(
+	ctx context.Context, repo *gogit.Repository, fs *libfs.FS,
+	args [][]string) error {
 	r.log.CDebugf(ctx, "Fetching %d refs into %s", len(args), r.gitDir)
 
 	remote, err := repo.CreateRemote(&gogitcfg.RemoteConfig{
@@ -1236,7 +1433,7 @@ func (r *runner) handleFetchBatch(ctx context.Context, args [][]string) (
 		s := make(chan plumbing.StatusUpdate)
 		defer close(s)
 		statusChan = plumbing.StatusChan(s)
-		go r.processGogitStatus(ctx, s, nil)
+		go r.processGogitStatusWithEvents(ctx, s, fs)
 	}
 
 	// Now "push" into the local repo to get it to store objects
@@ -1259,20 +1456,98 @@ func (r *runner) handleFetchBatch(ctx context.Context, args [][]string) (
 	if err != nil && err != gogit.NoErrAlreadyUpToDate {
 		return err
 	}
+	return nil
+}
 
-	err = r.waitForJournal(ctx)
+// fetchShallow fetches the last `r.depth` commits of each ref in
+// `args` from the KBFS repo into the local repo, and updates the
+// local repo's list of shallow commits to match.
+func (r *runner) fetchShallow(
+	ctx context.Context, repo *gogit.Repository, fs *libfs.FS,
+	args [][]string) error {
+	r.log.CDebugf(ctx, "Fetching %d refs into %s with depth %d",
+		len(args), r.gitDir, r.depth)
+
+	wants := make([]plumbing.Hash, 0, len(args))
+	for _, fetch := range args {
+		if len(fetch) != 2 {
+			return errors.Errorf("Bad fetch request: %v", fetch)
+		}
+		wants = append(wants, plumbing.NewHash(fetch[0]))
+	}
+
+	localStorer, err := filesystem.NewStorage(osfs.New(r.gitDir))
 	if err != nil {
 		return err
 	}
-	r.log.CDebugf(ctx, "Done waiting for journal")
+	plan, err := libgit.PlanShallowFetch(
+		repo.Storer, localStorer, wants, r.depth)
+	if err != nil {
+		return err
+	}
+	r.log.CDebugf(ctx, "Sending %d objects, with %d shallow commits",
+		len(plan.Objects), len(plan.Shallow))
 
-	err = r.checkGC(ctx)
+	var statusChan plumbing.StatusChan
+	if r.verbosity >= 1 {
+		s := make(chan plumbing.StatusUpdate)
+		defer close(s)
+		statusChan = plumbing.StatusChan(s)
+		go r.processGogitStatusWithEvents(ctx, s, fs)
+	}
+
+	err = libgit.SendObjects(
+		repo.Storer, localStorer, plan.Objects, statusChan)
 	if err != nil {
 		return err
 	}
+	if len(plan.Shallow) == 0 {
+		// Git treats even an empty shallow file as a shallow repo.
+		err = os.Remove(filepath.Join(r.gitDir, "shallow"))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return localStorer.SetShallow(plan.Shallow)
+}
 
-	_, err = r.output.Write([]byte("\n"))
-	return err
+// fetchesAllRefs returns true if `args` fetches every ref in the
+// KBFS repo, in which case a clone can just copy all the objects.
+// Otherwise, e.g. for a single-branch clone, copying everything
+// would transfer objects that aren't needed.
+func (r *runner) fetchesAllRefs(
+	ctx context.Context, args [][]string) (bool, error) {
+	repo, _, err := r.initRepoIfNeeded(ctx, "clone")
+	if err != nil {
+		return false, err
+	}
+	fetched := make(map[plumbing.ReferenceName]bool, len(args))
+	for _, fetch := range args {
+		if len(fetch) != 2 {
+			return false, errors.Errorf("Bad fetch request: %v", fetch)
+		}
+		fetched[plumbing.ReferenceName(fetch[1])] = true
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return false, err
+	}
+	defer refs.Close()
+	for {
+		ref, err := refs.Next()
+		if errors.Cause(err) == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		if ref.Type() == plumbing.HashReference && !fetched[ref.Name()] {
+			r.log.CDebugf(ctx, "Not fetching %s; skipping full clone",
+				ref.Name())
+			return false, nil
+		}
+	}
 }
 
 // canPushAll returns true if a) the KBFS repo is currently empty, and
@@ -1508,6 +1783,162 @@ func (r *runner) parentCommitsForRef(ctx context.Context,
 	return commitsByRef, nil
 }
 
+// forcePushedRefs returns the destination refs of the given push
+// that already exist in the KBFS repo, but whose new commits don't
+// descend from the existing ones.
+func (r *runner) forcePushedRefs(
+	ctx context.Context, localStorer gogitstor.Storer,
+	remoteStorer gogitstor.Storer, args [][]string) (
+	forced map[string]bool, err error) {
+	forced = make(map[string]bool)
+	for _, push := range args {
+		refspec := gogitcfg.RefSpec(push[0])
+		if refspec.IsDelete() || refspec.IsWildcard() {
+			continue
+		}
+		dst := refspec.Dst("")
+		remoteRef, err := remoteStorer.Reference(dst)
+		if errors.Cause(err) == plumbing.ErrReferenceNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if remoteRef.Type() != plumbing.HashReference {
+			continue
+		}
+		localRef, err := gogitstor.ResolveReference(
+			localStorer, plumbing.ReferenceName(refspec.Src()))
+		if err != nil {
+			// Let the push itself report the error.
+			r.log.CDebugf(ctx, "Can't resolve %s: %+v", refspec.Src(), err)
+			continue
+		}
+		ff, err := libgit.IsFastForward(
+			localStorer, remoteRef.Hash(), localRef.Hash())
+		if err != nil {
+			return nil, err
+		}
+		if !ff {
+			r.log.CDebugf(ctx, "Push to %s is not a fast-forward", dst)
+			forced[dst.String()] = true
+		}
+	}
+	return forced, nil
+}
+
+// rejectProtectedRefs returns an error for each push in `args` that
+// would delete or force-push a protected branch the current user
+// isn't allowed to rewrite, keyed by destination ref.  `forced` holds
+// the destinations that aren't fast-forwards, as returned by
+// `forcePushedRefs`.
+func (r *runner) rejectProtectedRefs(
+	ctx context.Context, fs *libfs.FS, remoteStorer gogitstor.Storer,
+	args [][]string, forced map[string]bool) (map[string]error, error) {
+	var updates []libgit.RefUpdate
+	for _, push := range args {
+		refspec := gogitcfg.RefSpec(push[0])
+		start := strings.Index(push[0], ":") + 1
+		dst := plumbing.ReferenceName(push[0][start:])
+		if refspec.IsDelete() {
+			_, err := remoteStorer.Reference(dst)
+			if errors.Cause(err) == plumbing.ErrReferenceNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			updates = append(updates, libgit.RefUpdate{
+				Name:     dst,
+				IsDelete: true,
+			})
+		} else if forced[dst.String()] {
+			updates = append(updates, libgit.RefUpdate{
+				Name:    dst,
+				IsForce: true,
+			})
+		}
+	}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	rejected, err := libgit.CheckProtectedBranches(
+		ctx, r.config, r.h, fs, updates)
+	if err != nil {
+		return nil, err
+	}
+	errs := make(map[string]error, len(rejected))
+	for ref, err := range rejected {
+		r.log.CDebugf(ctx, "Rejecting push: %+v", err)
+		errs[ref.String()] = err
+	}
+	return errs, nil
+}
+
+// pushSize returns the size of the objects the given push will add
+// to the KBFS repo.  A push of everything adds the whole local repo;
+// otherwise it's the objects reachable from the pushed refs that the
+// KBFS repo doesn't already have.  Those are counted at their
+// uncompressed sizes, so the number of bytes can be an overestimate.
+func (r *runner) pushSize(
+	ctx context.Context, localStorer gogitstor.Storer,
+	remoteStorer gogitstor.Storer, args [][]string, canPushAll bool) (
+	libgit.RepoSizeInfo, error) {
+	if canPushAll {
+		return libgit.SizeInfo(osfs.New(r.gitDir))
+	}
+
+	var wants []plumbing.Hash
+	for _, push := range args {
+		refspec := gogitcfg.RefSpec(push[0])
+		if refspec.IsDelete() {
+			continue
+		}
+		ref, err := gogitstor.ResolveReference(
+			localStorer, plumbing.ReferenceName(refspec.Src()))
+		if err != nil {
+			// Let the push itself report the error.
+			r.log.CDebugf(ctx, "Can't size push of %s: %+v", refspec, err)
+			continue
+		}
+		wants = append(wants, ref.Hash())
+	}
+	if len(wants) == 0 {
+		return libgit.RepoSizeInfo{}, nil
+	}
+
+	refs, err := remoteStorer.IterReferences()
+	if err != nil {
+		return libgit.RepoSizeInfo{}, err
+	}
+	var haves []plumbing.Hash
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if localStorer.HasEncodedObject(ref.Hash()) == nil {
+			haves = append(haves, ref.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return libgit.RepoSizeInfo{}, err
+	}
+
+	hashes, err := revlist.Objects(localStorer, wants, haves, nil)
+	if err != nil {
+		return libgit.RepoSizeInfo{}, err
+	}
+	info := libgit.RepoSizeInfo{NumObjects: int64(len(hashes))}
+	for _, h := range hashes {
+		obj, err := localStorer.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return libgit.RepoSizeInfo{}, err
+		}
+		info.Bytes += obj.Size()
+	}
+	return info, nil
+}
+
 func (r *runner) pushSome(
 	ctx context.Context, repo *gogit.Repository, fs *libfs.FS, args [][]string,
 	kbfsRepoEmpty bool) (map[string]error, error) {
@@ -1562,15 +1993,7 @@ func (r *runner) pushSome(
 			s := make(chan plumbing.StatusUpdate)
 			defer close(s)
 			statusChan = plumbing.StatusChan(s)
-			go func() {
-				events := make(chan libfs.FSEvent)
-				fs.SubscribeToEvents(events)
-				r.processGogitStatus(ctx, s, events)
-				fs.UnsubscribeToEvents(events)
-				// Drain any pending writes to the channel.
-				for range events {
-				}
-			}()
+			go r.processGogitStatusWithEvents(ctx, s, fs)
 		}
 
 		if kbfsRepoEmpty {
@@ -1659,21 +2082,80 @@ func (r *runner) handlePushBatch(ctx context.Context, args [][]string) (
 		return nil, err
 	}
 
+	forced, err := r.forcePushedRefs(ctx, localStorer, repo.Storer, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave out any pushes that would rewrite protected branches;
+	// they get their own errors, and the rest of the batch goes
+	// ahead.
+	rejected, err := r.rejectProtectedRefs(
+		ctx, fs, repo.Storer, args, forced)
+	if err != nil {
+		return nil, err
+	}
+	allowed := args
+	if len(rejected) > 0 {
+		allowed = nil
+		for _, push := range args {
+			start := strings.Index(push[0], ":") + 1
+			if rejected[push[0][start:]] == nil {
+				allowed = append(allowed, push)
+			}
+		}
+	}
+
+	// Check the quota before writing anything, since taking the
+	// packed-refs lock during the push flushes the journal.
+	quotaErr := libgit.CheckPushQuota(
+		ctx, r.config, r.h, fs, func() (libgit.RepoSizeInfo, error) {
+			return r.pushSize(
+				ctx, localStorer, repo.Storer, allowed, canPushAll)
+		})
+	switch errors.Cause(quotaErr).(type) {
+	case nil:
+	case libgit.QuotaExceededError:
+		r.log.CDebugf(ctx, "Rejecting push: %+v", quotaErr)
+	default:
+		return nil, quotaErr
+	}
+
 	var results map[string]error
 	// Ignore pushAll for commit collection, for now.
-	if canPushAll {
-		err = r.pushAll(ctx, fs)
+	if canPushAll || quotaErr != nil {
+		refErr := quotaErr
+		if refErr == nil {
+			err = r.pushAll(ctx, fs)
+			refErr = err
+		}
 		// All refs in the batch get the same error.
 		results = make(map[string]error, len(args))
-		for _, push := range args {
+		for _, push := range allowed {
 			// `canPushAll` already validates the push reference.
 			start := strings.Index(push[0], ":") + 1
 			dst := push[0][start:]
-			results[dst] = err
+			results[dst] = refErr
 		}
+	} else if len(allowed) > 0 {
+		results, err = r.pushSome(ctx, repo, fs, allowed, kbfsRepoEmpty)
 	} else {
-		results, err = r.pushSome(ctx, repo, fs, args, kbfsRepoEmpty)
+		results = make(map[string]error, len(args))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for dst, e := range rejected {
+		results[dst] = e
+	}
+
+	var forcePushes []plumbing.ReferenceName
+	for dst := range forced {
+		if results[dst] == nil {
+			forcePushes = append(forcePushes, plumbing.ReferenceName(dst))
+		}
 	}
+	err = libgit.RecordForcePushes(fs, forcePushes, r.config.Clock().Now())
 	if err != nil {
 		return nil, err
 	}
@@ -1727,6 +2209,12 @@ func (r *runner) handlePushBatch(ctx context.Context, args [][]string) (
 	if err != nil {
 		return nil, err
 	}
+
+	// Only run the hooks once git knows the push is done, so slow
+	// hooks can't hold it up.
+	if len(commits) > 0 {
+		libgit.RunPostReceiveHooks(ctx, r.config, r.h, fs, commits)
+	}
 	return commits, nil
 }
 
@@ -1777,6 +2265,17 @@ func (r *runner) handleOption(ctx context.Context, args []string) (err error) {
 		r.cloning = b
 		r.log.CDebugf(ctx, "Setting cloning to %t", b)
 		result = "ok"
+	case gitOptionDepth:
+		d, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.Errorf("Invalid depth: %d", d)
+		}
+		r.depth = d
+		r.log.CDebugf(ctx, "Setting depth to %d", d)
+		result = "ok"
 	case gitOptionPushcert:
 		if args[1] == gitOptionIfAsked {
 			// "if-asked" means we should sign only if the server
@@ -1816,7 +2315,14 @@ func (r *runner) processCommand(
 			cmdParts := strings.Fields(cmd)
 			if len(cmdParts) == 0 {
 				if len(fetchBatch) > 0 {
-					if r.cloning {
+					useClone := false
+					if r.cloning && r.depth == 0 {
+						useClone, err = r.fetchesAllRefs(ctx, fetchBatch)
+						if err != nil {
+							return err
+						}
+					}
+					if useClone {
 						r.log.CDebugf(ctx, "Processing clone")
 						err = r.handleClone(ctx)
 						if err != nil {
@@ -1924,3 +2430,48 @@ func (r *runner) processCommands(ctx context.Context) (err error) {
 		}
 	}
 }
+
+// processLFS acts as a git-lfs custom transfer agent, storing the
+// repo's LFS objects inside its bare repo in KBFS.  Any uploaded
+// objects are flushed to the server before it returns.
+func (r *runner) processLFS(ctx context.Context) (err error) {
+	r.log.CDebugf(ctx, "Ready to process LFS transfers")
+	// Allow the creation of .kbfs_git within KBFS.
+	ctx = context.WithValue(ctx, libkbfs.CtxAllowNameKey, kbfsRepoDir)
+
+	uploaded := false
+	openStore := func(
+		ctx context.Context, upload bool) (*libgit.LFSStore, error) {
+		forCmd := gitCmdFetch
+		if upload {
+			forCmd = gitCmdPush
+		}
+		_, fs, err := r.initRepoIfNeeded(ctx, forCmd)
+		if err != nil {
+			return nil, err
+		}
+		uploaded = uploaded || upload
+		return libgit.NewLFSStore(fs), nil
+	}
+
+	// Put downloaded objects next to git-lfs's own object store if
+	// possible, so it can just rename them into place.
+	tmpDir := ""
+	if r.gitDir != "" {
+		tmpDir = filepath.Join(r.gitDir, "lfs", "tmp")
+		err = os.MkdirAll(tmpDir, 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = libgit.ServeLFSTransfer(
+		ctx, r.log, r.input, r.output, openStore, tmpDir)
+	if err != nil {
+		return err
+	}
+	if uploaded {
+		return r.waitForJournal(ctx)
+	}
+	return nil
+}
@@ -22,7 +22,9 @@ import (
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
 	gogitcfg "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 type testErrput struct {
@@ -55,7 +57,7 @@ func TestRunnerCapabilities(t *testing.T) {
 	require.Equal(t, "fetch\npush\noption\n\n", output.String())
 }
 
-func initConfigForRunner(t *testing.T) (
+func initConfigForRunner(t testing.TB) (
 	ctx context.Context, config *libkbfs.ConfigLocal, tempdir string) {
 	ctx = libkbfs.BackgroundContextWithCancellationDelayer()
 	config = libkbfs.MakeTestConfigOrBustLoggedInWithMode(
@@ -361,6 +363,121 @@ func TestRunnerPushFetchWithBranch(t *testing.T) {
 	testRunnerPushFetch(t, false, true)
 }
 
+func testRunnerFetchWithOptions(t *testing.T, ctx context.Context,
+	config libkbfs.Config, gitDir, options, ref, head string) {
+	inputReader, inputWriter := io.Pipe()
+	defer inputWriter.Close()
+	go func() {
+		inputWriter.Write([]byte(fmt.Sprintf(
+			"%sfetch %s %s\n\n\n", options, head, ref)))
+	}()
+
+	var output bytes.Buffer
+	r, err := newRunner(ctx, config, "origin", "keybase://private/user1/test",
+		filepath.Join(gitDir, ".git"), inputReader, &output, testErrput{t})
+	require.NoError(t, err)
+	err = r.processCommands(ctx)
+	require.NoError(t, err)
+	okStr := strings.Repeat("ok\n", strings.Count(options, "\n"))
+	require.Equal(t, okStr+"\n", output.String())
+}
+
+func gitOutput(t *testing.T, gitDir string, command ...string) string {
+	cmd := exec.Command("git",
+		append([]string{"--git-dir", filepath.Join(gitDir, ".git")},
+			command...)...)
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	return strings.TrimSpace(string(out))
+}
+
+func TestRunnerShallowFetch(t *testing.T) {
+	ctx, config, tempdir := initConfigForRunner(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	git1, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git1)
+
+	makeLocalRepoWithOneFile(t, git1, "foo", "hello", "")
+	addOneFileToRepo(t, git1, "foo2", "hello2")
+	addOneFileToRepo(t, git1, "foo3", "hello3")
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	_, err = libgit.CreateRepoAndID(ctx, config, h, "test")
+	require.NoError(t, err)
+	testPush(t, ctx, config, git1, "refs/heads/master:refs/heads/master")
+
+	git2, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git2)
+	dotgit2 := filepath.Join(git2, ".git")
+	gitExec(t, dotgit2, git2, "init")
+	heads := testListAndGetHeads(t, ctx, config, git2,
+		[]string{"refs/heads/master", "HEAD"})
+
+	t.Log("A shallow clone only gets the last commit")
+	testRunnerFetchWithOptions(t, ctx, config, git2,
+		"option cloning true\noption depth 1\n", "refs/heads/master",
+		heads[0])
+	shallow, err := ioutil.ReadFile(filepath.Join(dotgit2, "shallow"))
+	require.NoError(t, err)
+	require.Equal(t, heads[0], strings.TrimSpace(string(shallow)))
+	require.Equal(t, "1", gitOutput(t, git2, "rev-list", "--count", heads[0]))
+	gitExec(t, dotgit2, git2, "checkout", heads[0])
+	data, err := ioutil.ReadFile(filepath.Join(git2, "foo3"))
+	require.NoError(t, err)
+	require.Equal(t, "hello3", string(data))
+
+	t.Log("Deepen the clone")
+	testRunnerFetchWithOptions(t, ctx, config, git2,
+		"option depth 3\n", "refs/heads/master", heads[0])
+	require.Equal(t, "3", gitOutput(t, git2, "rev-list", "--count", heads[0]))
+	_, err = os.Stat(filepath.Join(dotgit2, "shallow"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRunnerSingleBranchClone(t *testing.T) {
+	ctx, config, tempdir := initConfigForRunner(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	git1, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git1)
+
+	makeLocalRepoWithOneFile(t, git1, "foo", "hello", "")
+	dotgit1 := filepath.Join(git1, ".git")
+	gitExec(t, dotgit1, git1, "checkout", "-b", "b")
+	addOneFileToRepo(t, git1, "foo2", "hello2")
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	_, err = libgit.CreateRepoAndID(ctx, config, h, "test")
+	require.NoError(t, err)
+	testPush(t, ctx, config, git1, "refs/heads/master:refs/heads/master")
+	testPush(t, ctx, config, git1, "refs/heads/b:refs/heads/b")
+
+	git2, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git2)
+	dotgit2 := filepath.Join(git2, ".git")
+	gitExec(t, dotgit2, git2, "init")
+	heads := testListAndGetHeads(t, ctx, config, git2,
+		[]string{"refs/heads/master", "refs/heads/b", "HEAD"})
+
+	// Cloning just master shouldn't bring along the commit on b.
+	testRunnerFetchWithOptions(t, ctx, config, git2,
+		"option cloning true\n", "refs/heads/master", heads[0])
+	gitExec(t, dotgit2, git2, "cat-file", "-e", heads[0])
+	cmd := exec.Command("git", "--git-dir", dotgit2, "cat-file", "-e", heads[1])
+	require.Error(t, cmd.Run())
+}
+
 func TestRunnerDeleteBranch(t *testing.T) {
 	ctx, config, tempdir := initConfigForRunner(t)
 	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
@@ -391,6 +508,84 @@ func TestRunnerDeleteBranch(t *testing.T) {
 		[]string{"refs/heads/master", "HEAD"})
 }
 
+func TestRunnerPushQuota(t *testing.T) {
+	ctx, config, tempdir := initConfigForRunner(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	git, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git)
+
+	makeLocalRepoWithOneFile(t, git, "foo", "hello", "")
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	_, err = libgit.CreateRepoAndID(ctx, config, h, "test")
+	require.NoError(t, err)
+	err = libgit.SetRepoQuota(
+		ctx, config, h, "test", &libgit.RepoQuota{MaxObjects: 3})
+	require.NoError(t, err)
+
+	// The first commit fits exactly.
+	testPush(t, ctx, config, git, "refs/heads/master:refs/heads/master")
+
+	// The next one doesn't, and the branch stays where it was.
+	heads := testListAndGetHeads(t, ctx, config, git,
+		[]string{"refs/heads/master", "HEAD"})
+	addOneFileToRepo(t, git, "foo2", "hello2")
+	testPushWithTemplate(t, ctx, config, git,
+		[]string{"refs/heads/master:refs/heads/master"},
+		"error %s push exceeds the repo git quota: "+
+			"3 objects used + 3 objects pushed > 3 objects\n\n", "user1")
+	newHeads := testListAndGetHeads(t, ctx, config, git,
+		[]string{"refs/heads/master", "HEAD"})
+	require.Equal(t, heads, newHeads)
+
+	err = libgit.SetRepoQuota(
+		ctx, config, h, "test", &libgit.RepoQuota{MaxObjects: 6})
+	require.NoError(t, err)
+	testPush(t, ctx, config, git, "refs/heads/master:refs/heads/master")
+}
+
+func TestRunnerPushProtectedBranch(t *testing.T) {
+	ctx, config, tempdir := initConfigForRunner(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	git, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git)
+
+	makeLocalRepoWithOneFile(t, git, "foo", "hello", "")
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	_, err = libgit.CreateRepoAndID(ctx, config, h, "test")
+	require.NoError(t, err)
+	testPush(t, ctx, config, git, "refs/heads/master:refs/heads/master")
+	testPush(t, ctx, config, git, "refs/heads/master:refs/heads/b1")
+	err = libgit.SetProtectedBranches(
+		ctx, config, h, "test", []string{"master"})
+	require.NoError(t, err)
+
+	// Deleting the protected branch fails, but the rest of the batch
+	// goes through.
+	testPushWithTemplate(t, ctx, config, git,
+		[]string{":refs/heads/master", ":refs/heads/b1"},
+		"error %s branch master is protected and can only be deleted "+
+			"by a team admin\nok %s\n\n", "user1")
+	heads := testListAndGetHeads(t, ctx, config, git,
+		[]string{"refs/heads/master", "HEAD"})
+	require.Len(t, heads, 2)
+
+	// Fast-forwards are still fine.
+	addOneFileToRepo(t, git, "foo2", "hello2")
+	testPush(t, ctx, config, git, "refs/heads/master:refs/heads/master")
+}
+
 func TestRunnerExitEarlyOnEOF(t *testing.T) {
 	ctx, config, tempdir := initConfigForRunner(t)
 	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
@@ -452,6 +647,13 @@ func TestForcePush(t *testing.T) {
 	// Push a second file.
 	addOneFileToRepo(t, git, "foo2", "hello2")
 	testPush(t, ctx, config, git, "refs/heads/master:refs/heads/master")
+	checkForcePushed := func(expected bool) {
+		listing, err := libgit.ListRefs(ctx, config, h, "test")
+		require.NoError(t, err)
+		require.Len(t, listing.Refs, 1)
+		require.Equal(t, expected, listing.Refs[0].RecentlyForcePushed)
+	}
+	checkForcePushed(false)
 
 	// Now revert to the old commit and add a different file.
 	dotgit := filepath.Join(git, ".git")
@@ -462,8 +664,10 @@ func TestForcePush(t *testing.T) {
 	testPushWithTemplate(
 		t, ctx, config, git, []string{"refs/heads/master:refs/heads/master"},
 		"error %s some refs were not updated\n\n", "user1")
+	checkForcePushed(false)
 	// But a force push should work
 	testPush(t, ctx, config, git, "+refs/heads/master:refs/heads/master")
+	checkForcePushed(true)
 }
 
 func TestPushAllWithPackedRefs(t *testing.T) {
@@ -1116,3 +1320,154 @@ func TestRunnerHandlePushBatch(t *testing.T) {
 	require.True(t, master.IsDelete)
 	require.Len(t, master.Commits, 0)
 }
+
+func TestGogitStatusString(t *testing.T) {
+	update := plumbing.StatusUpdate{
+		Stage:        plumbing.StatusFetch,
+		ObjectsTotal: 4,
+		ObjectsDone:  1,
+	}
+	require.Equal(t, "(25.00%) 1/4 objects... ",
+		gogitStatusString(update, 0, 0))
+	require.Equal(t, "(25.00%) 1/4 objects, 2.00 KB written... ",
+		gogitStatusString(update, 0, 2048))
+	require.Equal(t, "(25.00%) 1/4 objects, 10 bytes read, "+
+		"2.00 MB written... ",
+		gogitStatusString(update, 10, 2*1024*1024))
+
+	update.Stage = plumbing.StatusCount
+	require.Equal(t, "4 objects, 3 bytes read... ",
+		gogitStatusString(update, 3, 0))
+	update.Stage = plumbing.StatusSort
+	require.Equal(t, "", gogitStatusString(update, 3, 0))
+}
+
+// makeLocalCopyTree fills `dir` with `numFiles` files of `fileSize`
+// bytes each, spread across a few nested subdirectories, and returns
+// the total number of bytes written.
+func makeLocalCopyTree(
+	t testing.TB, dir string, numFiles, fileSize int) (total int64) {
+	for i := 0; i < numFiles; i++ {
+		subdir := filepath.Join(
+			dir, fmt.Sprintf("%02x", i%16), fmt.Sprintf("%02x", i%3))
+		err := os.MkdirAll(subdir, 0775)
+		require.NoError(t, err)
+		data := bytes.Repeat([]byte{byte(i)}, fileSize+i)
+		err = ioutil.WriteFile(
+			filepath.Join(subdir, fmt.Sprintf("file%d", i)), data, 0600)
+		require.NoError(t, err)
+		total += int64(len(data))
+	}
+	return total
+}
+
+func TestRunnerRecursiveCopyParallel(t *testing.T) {
+	ctx, config, tempdir := initConfigForRunner(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	local, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(local)
+	total := makeLocalCopyTree(t, local, 50, 1024)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	fs, _, err := libgit.GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+
+	var input, output bytes.Buffer
+	r, err := newRunner(ctx, config, "origin", "keybase://private/user1/test",
+		"", &input, &output, testErrput{t})
+	require.NoError(t, err)
+	require.Equal(t, defaultCopyWorkers, r.copyWorkers)
+	r.copyWorkers = 4
+
+	t.Log("Copy the local tree into KBFS with multiple workers")
+	sw := &statusWriter{r: r, totalBytes: total}
+	err = r.recursiveCopy(ctx, osfs.New(local), fs, sw)
+	require.NoError(t, err)
+	require.Equal(t, total, sw.soFar)
+	err = r.waitForJournal(ctx)
+	require.NoError(t, err)
+
+	t.Log("Copy it back out, and make sure everything matches")
+	local2, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(local2)
+	err = r.recursiveCopy(ctx, fs, osfs.New(local2), nil)
+	require.NoError(t, err)
+	err = filepath.Walk(local, func(
+		path string, fi os.FileInfo, err error) error {
+		require.NoError(t, err)
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(local, path)
+		require.NoError(t, err)
+		expected, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		data, err := ioutil.ReadFile(filepath.Join(local2, rel))
+		require.NoError(t, err)
+		require.Equal(t, expected, data, rel)
+		return nil
+	})
+	require.NoError(t, err)
+
+	t.Log("An invalid worker count in the environment is an error")
+	os.Setenv(copyWorkersEnv, "zero")
+	defer os.Unsetenv(copyWorkersEnv)
+	_, err = newRunner(ctx, config, "origin", "keybase://private/user1/test",
+		"", &input, &output, testErrput{t})
+	require.Error(t, err)
+}
+
+func benchmarkRecursiveCopy(b *testing.B, numWorkers int) {
+	ctx, config, tempdir := initConfigForRunner(b)
+	defer libkbfs.CheckConfigAndShutdown(ctx, b, config)
+	defer os.RemoveAll(tempdir)
+
+	local, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(b, err)
+	defer os.RemoveAll(local)
+	total := makeLocalCopyTree(b, local, 200, 64*1024)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(b, err)
+	fs, _, err := libgit.GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(b, err)
+
+	var input, output bytes.Buffer
+	r, err := newRunner(ctx, config, "origin", "keybase://private/user1/test",
+		"", &input, &output, ioutil.Discard)
+	require.NoError(b, err)
+	r.copyWorkers = numWorkers
+
+	b.SetBytes(total)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dir := fmt.Sprintf("copy%d", i)
+		err := fs.MkdirAll(dir, 0775)
+		require.NoError(b, err)
+		to, err := fs.Chroot(dir)
+		require.NoError(b, err)
+		err = r.recursiveCopy(ctx, osfs.New(local), to, nil)
+		require.NoError(b, err)
+		err = r.waitForJournal(ctx)
+		require.NoError(b, err)
+	}
+}
+
+func BenchmarkRecursiveCopy1Worker(b *testing.B) {
+	benchmarkRecursiveCopy(b, 1)
+}
+
+func BenchmarkRecursiveCopy4Workers(b *testing.B) {
+	benchmarkRecursiveCopy(b, 4)
+}
+
+func BenchmarkRecursiveCopy10Workers(b *testing.B) {
+	benchmarkRecursiveCopy(b, 10)
+}
@@ -25,6 +25,10 @@ type StartOptions struct {
 	// GitDir is the filepath leading to the .git directory of the
 	// caller's local on-disk repo.
 	GitDir string
+	// LFS is true if the caller is git-lfs, expecting a custom
+	// transfer agent for the repo's large objects, rather than git
+	// expecting a remote helper.
+	LFS bool
 }
 
 // Start starts the kbfsgit logic, and begins listening for git
@@ -57,6 +61,10 @@ func Start(ctx context.Context, options StartOptions,
 
 	errCh := make(chan error, 1)
 	go func() {
+		if options.LFS {
+			errCh <- r.processLFS(ctx)
+			return
+		}
 		errCh <- r.processCommands(ctx)
 	}()
 
@@ -0,0 +1,99 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// gRPC file API server for the Keybase file system.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libgrpc"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var listenAddr = flag.String("listen", "127.0.0.1:16802",
+	"loopback address to serve gRPC on")
+var tokenEnv = flag.String("token-env", "KBFS_GRPC_TOKEN",
+	"the environment variable holding the token clients must present")
+
+const usageFormatStr = `Usage:
+  kbfsgrpc -version
+
+To run against remote KBFS servers:
+  kbfsgrpc
+    [-listen=host:port] [-token-env=VAR]
+%s
+
+To run in a local testing environment:
+  kbfsgrpc
+    [-listen=host:port] [-token-env=VAR]
+%s
+
+Serves the kbfs.v1.KBFS service defined in libgrpc/kbfs.proto over
+plaintext HTTP/2.  Clients must send the token as an
+"authorization: Bearer <token>" header, e.g.:
+
+  grpcurl -plaintext -proto libgrpc/kbfs.proto \
+    -H "authorization: Bearer $KBFS_GRPC_TOKEN" \
+    -d '{"path": "/private/alice"}' 127.0.0.1:16802 kbfs.v1.KBFS/List
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	if len(flag.Args()) > 0 {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("extra arguments specified")
+	}
+
+	token := os.Getenv(*tokenEnv)
+	if token == "" {
+		return libfs.InitError(fmt.Sprintf(
+			"no token given in $%s", *tokenEnv))
+	}
+
+	options := libgrpc.StartOptions{
+		KbfsParams: *kbfsParams,
+		ListenAddr: *listenAddr,
+		Token:      token,
+	}
+
+	return libgrpc.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfsgrpc error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
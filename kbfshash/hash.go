@@ -13,6 +13,7 @@ import (
 
 	"github.com/keybase/kbfs/cache"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
 )
 
 // See https://keybase.io/admin-docs/hash-format for the design doc
@@ -53,6 +54,10 @@ const (
 	InvalidHash HashType = 0
 	// SHA256Hash is the type of a SHA256 hash.
 	SHA256Hash HashType = 1
+	// BLAKE2b256Hash is the type of an unkeyed BLAKE2b hash with a
+	// 256-bit digest.  It's cheaper to compute than SHA256Hash on
+	// CPUs without SHA extensions.
+	BLAKE2b256Hash HashType = 2
 )
 
 // MaxDefaultHash is the maximum value of RawDefaultHash
@@ -69,6 +74,8 @@ func (t HashType) String() string {
 		return "InvalidHash"
 	case SHA256Hash:
 		return "SHA256Hash"
+	case BLAKE2b256Hash:
+		return "BLAKE2b256Hash"
 	default:
 		return fmt.Sprintf("HashType(%d)", t)
 	}
@@ -91,6 +98,21 @@ func DoRawDefaultHash(p []byte) (HashType, RawDefaultHash) {
 	return DefaultHashType, RawDefaultHash(sha256.Sum256(p))
 }
 
+// DoRawHash computes the keybase hash of the given data with the
+// given hash type, and returns the raw hash bytes.  Only hash types
+// with the same size as the default one are supported.
+func DoRawHash(hashType HashType, p []byte) (RawDefaultHash, error) {
+	switch hashType {
+	case SHA256Hash:
+		return RawDefaultHash(sha256.Sum256(p)), nil
+	case BLAKE2b256Hash:
+		return RawDefaultHash(blake2b.Sum256(p)), nil
+	default:
+		return RawDefaultHash{}, errors.WithStack(
+			UnknownHashTypeError{hashType})
+	}
+}
+
 // Copy returns a copied RawDefaultHash
 func (rdh *RawDefaultHash) Copy() *RawDefaultHash {
 	if rdh == nil {
@@ -146,6 +168,25 @@ func DefaultHash(buf []byte) (Hash, error) {
 	return HashFromRaw(hashType, rawHash[:])
 }
 
+// HashWithType computes the hash of the given data with the given
+// hash type.
+func HashWithType(hashType HashType, buf []byte) (Hash, error) {
+	rawHash, err := DoRawHash(hashType, buf)
+	if err != nil {
+		return Hash{}, err
+	}
+	return HashFromRaw(hashType, rawHash[:])
+}
+
+// GetHashType returns the type of the hash, or InvalidHash for the
+// zero hash.
+func (h Hash) GetHashType() HashType {
+	if len(h.h) == 0 {
+		return InvalidHash
+	}
+	return h.hashType()
+}
+
 func (h Hash) hashType() HashType {
 	return HashType(h.h[0])
 }
@@ -221,13 +262,7 @@ func (h Hash) Verify(buf []byte) error {
 		return errors.WithStack(InvalidHashError{h})
 	}
 
-	// Once we have multiple hash types we'll need to expand this.
-	t := h.hashType()
-	if t != DefaultHashType {
-		return errors.WithStack(UnknownHashTypeError{t})
-	}
-
-	expectedH, err := DefaultHash(buf)
+	expectedH, err := HashWithType(h.hashType(), buf)
 	if err != nil {
 		return err
 	}
@@ -5,6 +5,7 @@
 package kbfshash
 
 import (
+	"encoding/hex"
 	"testing"
 
 	"github.com/keybase/kbfs/kbfscodec"
@@ -115,7 +116,7 @@ func TestHashVerify(t *testing.T) {
 	err = invalidH.Verify(data)
 	require.Equal(t, InvalidHashError{invalidH}, errors.Cause(err))
 
-	unknownType := validH.hashType() + 1
+	unknownType := BLAKE2b256Hash + 1
 	unknownH := hashFromRawNoCheck(unknownType, validH.hashData())
 	err = unknownH.Verify(data)
 	require.Equal(t, UnknownHashTypeError{unknownType}, errors.Cause(err))
@@ -127,6 +128,36 @@ func TestHashVerify(t *testing.T) {
 	require.IsType(t, HashMismatchError{}, errors.Cause(err))
 }
 
+// Make sure that a BLAKE2b hash is valid, verifies, and has the same
+// length as the default hash.
+func TestBLAKE2bHash(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5}
+	h, err := HashWithType(BLAKE2b256Hash, data)
+	require.NoError(t, err)
+	require.True(t, h.IsValid())
+	require.Equal(t, BLAKE2b256Hash, h.GetHashType())
+	require.Len(t, h.Bytes(), DefaultHashByteLength)
+
+	err = h.Verify(data)
+	require.NoError(t, err)
+
+	// The well-known BLAKE2b-256 digest of "abc".
+	abcH, err := HashWithType(BLAKE2b256Hash, []byte("abc"))
+	require.NoError(t, err)
+	require.Equal(t, "bddd813c634239723171ef3fee98579b"+
+		"94964e3bb1cb3e427262c8c068d52319", hex.EncodeToString(abcH.hashData()))
+
+	defaultH, err := DefaultHash(data)
+	require.NoError(t, err)
+	require.NotEqual(t, defaultH.hashData(), h.hashData())
+
+	corruptData := make([]byte, len(data))
+	copy(corruptData, data)
+	corruptData[0] ^= 1
+	err = h.Verify(corruptData)
+	require.IsType(t, HashMismatchError{}, errors.Cause(err))
+}
+
 // Make sure HMAC encodes and decodes properly with minimal overhead.
 func TestHMACEncodeDecode(t *testing.T) {
 	codec := kbfscodec.NewMsgpack()
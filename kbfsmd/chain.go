@@ -0,0 +1,85 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsmd
+
+import (
+	"context"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
+)
+
+// getChainExtra returns the key bundles for the given MD from
+// kbCache, or nil if the MD embeds its key bundles (i.e., it's pre-v3
+// or non-private).
+func getChainExtra(kbCache KeyBundleCache, md RootMetadata) (
+	ExtraMetadata, error) {
+	wkbID, rkbID := md.GetTLFWriterKeyBundleID(), md.GetTLFReaderKeyBundleID()
+	if (wkbID == TLFWriterKeyBundleID{}) || (rkbID == TLFReaderKeyBundleID{}) {
+		return nil, nil
+	}
+	wkb, err := kbCache.GetTLFWriterKeyBundle(wkbID)
+	if err != nil {
+		return nil, err
+	}
+	if wkb == nil {
+		return nil, errors.Errorf("Missing writer key bundle %s", wkbID)
+	}
+	rkb, err := kbCache.GetTLFReaderKeyBundle(rkbID)
+	if err != nil {
+		return nil, err
+	}
+	if rkb == nil {
+		return nil, errors.Errorf("Missing reader key bundle %s", rkbID)
+	}
+	return NewExtraMetadataV3(*wkb, *rkb, false, false), nil
+}
+
+// VerifyChain checks, without contacting any server, that each of
+// the given signed MD objects is valid and correctly signed, and that
+// each one is a valid successor of the one before it (i.e., that its
+// PrevRoot is the ID of its predecessor, and so on).  rmdses must be
+// sorted in increasing revision order.  The key bundles of v3
+// private TLFs are looked up in kbCache, and teamMemChecker is only
+// used for team TLFs.
+//
+// This only checks that the signatures were made by the keys
+// recorded in each MD object; it's up to the caller to check that
+// those keys belonged to the recorded writers and users at the time.
+// Also, the first MD object in the list is taken as given, unless it
+// is the initial revision.  If any check fails, the returned error
+// names the revision at which the chain breaks.
+func VerifyChain(ctx context.Context, codec kbfscodec.Codec,
+	teamMemChecker TeamMembershipChecker, kbCache KeyBundleCache,
+	rmdses []*RootMetadataSigned) error {
+	var prevMD RootMetadata
+	var prevID ID
+	for _, rmds := range rmdses {
+		rev := rmds.MD.RevisionNumber()
+		extra, err := getChainExtra(kbCache, rmds.MD)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't verify revision %d", rev)
+		}
+		err = rmds.IsValidAndSigned(ctx, codec, teamMemChecker, extra)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't verify revision %d", rev)
+		}
+		if prevMD != nil {
+			err = prevMD.CheckValidSuccessor(prevID, rmds.MD)
+			if err != nil {
+				return errors.Wrapf(err,
+					"Revision %d isn't a valid successor of revision %d",
+					rev, prevMD.RevisionNumber())
+			}
+		}
+
+		prevID, err = MakeID(codec, rmds.MD)
+		if err != nil {
+			return err
+		}
+		prevMD = rmds.MD
+	}
+	return nil
+}
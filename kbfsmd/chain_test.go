@@ -0,0 +1,99 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func testVerifyChain(t *testing.T, ver MetadataVer) {
+	tlfID := tlf.FakeID(1, tlf.Private)
+
+	uid := keybase1.MakeTestUID(1)
+	bh, err := tlf.MakeHandle(
+		[]keybase1.UserOrTeamID{uid.AsUserOrTeam()}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	brmd, err := MakeInitialRootMetadata(ver, tlfID, bh)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	codec := kbfscodec.NewMsgpack()
+	signer := kbfscrypto.SigningKeySigner{
+		Key: kbfscrypto.MakeFakeSigningKeyOrBust("key"),
+	}
+
+	extra := FakeInitialRekey(brmd, bh, kbfscrypto.TLFPublicKey{})
+	kbCache := NewKeyBundleCacheLRU(1 << 20)
+	if extraV3, ok := extra.(*ExtraMetadataV3); ok {
+		kbCache.PutTLFWriterKeyBundle(
+			brmd.GetTLFWriterKeyBundleID(), extraV3.wkb)
+		kbCache.PutTLFReaderKeyBundle(
+			brmd.GetTLFReaderKeyBundleID(), extraV3.rkb)
+	}
+
+	brmd.SetLastModifyingWriter(uid)
+	brmd.SetLastModifyingUser(uid)
+
+	var rmdses []*RootMetadataSigned
+	var prevID ID
+	for i := 0; i < 3; i++ {
+		md, err := brmd.DeepCopy(codec)
+		require.NoError(t, err)
+		md.SetRevision(RevisionInitial + Revision(i))
+		md.SetPrevRoot(prevID)
+		md.SetSerializedPrivateMetadata([]byte{byte(i + 1)})
+		err = md.SignWriterMetadataInternally(ctx, codec, signer)
+		require.NoError(t, err)
+
+		rmds, err := SignRootMetadata(ctx, codec, signer, signer, md)
+		require.NoError(t, err)
+		rmdses = append(rmdses, rmds)
+
+		prevID, err = MakeID(codec, md)
+		require.NoError(t, err)
+	}
+
+	err = VerifyChain(ctx, codec, nil, kbCache, rmdses)
+	require.NoError(t, err)
+
+	// A gap in the chain should be caught.
+	err = VerifyChain(ctx, codec, nil, kbCache,
+		[]*RootMetadataSigned{rmdses[0], rmdses[2]})
+	require.Error(t, err)
+
+	// So should a successor that doesn't point to its predecessor.
+	md, err := rmdses[2].MD.DeepCopy(codec)
+	require.NoError(t, err)
+	md.SetPrevRoot(FakeID(1))
+	err = md.SignWriterMetadataInternally(ctx, codec, signer)
+	require.NoError(t, err)
+	badRMDS, err := SignRootMetadata(ctx, codec, signer, signer, md)
+	require.NoError(t, err)
+	err = VerifyChain(ctx, codec, nil, kbCache,
+		[]*RootMetadataSigned{rmdses[0], rmdses[1], badRMDS})
+	require.Error(t, err)
+
+	// And so should a bad signature.
+	badSigRMDS := *rmdses[1]
+	badSigRMDS.SigInfo = rmdses[0].SigInfo
+	err = VerifyChain(ctx, codec, nil, kbCache,
+		[]*RootMetadataSigned{rmdses[0], &badSigRMDS, rmdses[2]})
+	require.Error(t, err)
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []func(*testing.T, MetadataVer){
+		testVerifyChain,
+	}
+	runTestsOverMetadataVers(t, "testVerifyChain", tests)
+}
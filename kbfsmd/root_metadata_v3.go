@@ -1122,6 +1122,10 @@ func (md *RootMetadataV3) RevisionNumber() Revision {
 // MerkleRoot implements the RootMetadata interface for
 // RootMetadataV3.
 func (md *RootMetadataV3) MerkleRoot() keybase1.MerkleRootV2 {
+	if md.KBMerkleRoot == nil {
+		// Initial revisions don't have this field set.
+		return keybase1.MerkleRootV2{}
+	}
 	return *md.KBMerkleRoot
 }
 
@@ -0,0 +1,111 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// NFSv3 server for the Keybase file system.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/libnfs"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var listenAddr = flag.String("listen", "127.0.0.1:2049",
+	"address to serve NFS on; e.g. use :2049 to allow remote machines")
+var exportsFile = flag.String("exports", "",
+	"path to a JSON file configuring the TLFs to export")
+
+const usageFormatStr = `Usage:
+  kbfsnfs -version
+
+To run against remote KBFS servers:
+  kbfsnfs
+    [-listen=host:port] -exports=path/to/exports.json
+%s
+
+To run in a local testing environment:
+  kbfsnfs
+    [-listen=host:port] -exports=path/to/exports.json
+%s
+
+The exports file looks like:
+
+  {
+    "exports": [
+      {
+        "tlf": "private/alice",
+        "read_only": false,
+        "clients": ["10.0.0.0/8"],
+        "attr_cache_ttl": "1s"
+      }
+    ],
+    "owner_uid": 1000,
+    "owner_gid": 1000
+  }
+
+Each TLF is given as e.g. private/alice,bob, public/alice or team/acme,
+and is mounted by clients at /<type>/<name>.  Only NFSv3 over TCP is
+supported, with MOUNT on the same port and no portmapper or lock
+manager, so mount with e.g.:
+
+  mount -t nfs -o vers=3,proto=tcp,port=2049,mountport=2049,mountproto=tcp,nolock \
+    server:/private/alice /mnt/alice
+
+Add actimeo=N to bound how long clients cache attributes.  File handles
+don't survive a restart of this server, so clients must remount.
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	if len(flag.Args()) > 0 || *exportsFile == "" {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("no exports file specified")
+	}
+
+	options := libnfs.StartOptions{
+		KbfsParams:  *kbfsParams,
+		ListenAddr:  *listenAddr,
+		ExportsFile: *exportsFile,
+	}
+
+	return libnfs.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfsnfs error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// S3-compatible gateway for the Keybase file system.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/libs3"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var listenAddr = flag.String("listen", "127.0.0.1:16801",
+	"loopback address to serve the S3 API on")
+var accessKeyID = flag.String("access-key-id", "kbfs",
+	"the access key ID clients must use")
+var secretKeyEnv = flag.String("secret-key-env", "KBFS_S3_SECRET_ACCESS_KEY",
+	"the environment variable holding the secret access key clients "+
+		"must use")
+
+const usageFormatStr = `Usage:
+  kbfss3 -version
+
+To run against remote KBFS servers:
+  kbfss3
+    [-listen=host:port] [-access-key-id=id] [-secret-key-env=VAR]
+%s
+    <bucket>=<type>/<name>[/<subdir>] [...]
+
+To run in a local testing environment:
+  kbfss3
+    [-listen=host:port] [-access-key-id=id] [-secret-key-env=VAR]
+%s
+    <bucket>=<type>/<name>[/<subdir>] [...]
+
+Each bucket maps to a directory in a TLF, e.g.
+backups=private/alice/backups; the directory is created if needed.
+Clients must use path-style addressing and AWS Signature Version 4,
+e.g.:
+
+  aws --endpoint-url http://127.0.0.1:16801 s3 ls s3://backups/
+
+Object metadata isn't stored, and ETags aren't MD5 sums of the
+content.
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	if len(flag.Args()) < 1 {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("no buckets specified")
+	}
+
+	var buckets []libs3.BucketConfig
+	for _, spec := range flag.Args() {
+		bc, err := libs3.ParseBucketSpec(spec)
+		if err != nil {
+			fmt.Print(getUsageString(ctx))
+			return libfs.InitError(err.Error())
+		}
+		buckets = append(buckets, bc)
+	}
+
+	secretKey := os.Getenv(*secretKeyEnv)
+	if secretKey == "" {
+		return libfs.InitError(fmt.Sprintf(
+			"no secret access key given in $%s", *secretKeyEnv))
+	}
+
+	options := libs3.StartOptions{
+		KbfsParams: *kbfsParams,
+		ListenAddr: *listenAddr,
+		Credentials: libs3.Credentials{
+			AccessKeyID:     *accessKeyID,
+			SecretAccessKey: secretKey,
+		},
+		Buckets: buckets,
+	}
+
+	return libs3.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfss3 error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// SFTP server for the Keybase file system.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/libsftp"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var listenAddr = flag.String("listen", "127.0.0.1:2222",
+	"address to serve SFTP on; e.g. use :2222 to allow remote machines")
+var authorizedKeys = flag.String("authorized-keys", "",
+	"path to an OpenSSH authorized_keys file listing the client keys "+
+		"allowed to connect")
+
+const usageFormatStr = `Usage:
+  kbfssftp -version
+
+To run against remote KBFS servers:
+  kbfssftp
+    [-listen=host:port] -authorized-keys=path/to/authorized_keys
+%s
+    <type>/<name> [<type>/<name>...]
+
+To run in a local testing environment:
+  kbfssftp
+    [-listen=host:port] -authorized-keys=path/to/authorized_keys
+%s
+    <type>/<name> [<type>/<name>...]
+
+Each TLF is given as e.g. private/alice,bob, public/alice or team/acme,
+and appears to clients at /<type>/<name>; no other TLFs are visible.
+Only the sftp subsystem is supported, so use sftp, or scp -s with a
+recent OpenSSH.  The host key is derived from the device key, so it
+stays the same across restarts of this device; its fingerprint is
+logged on startup.
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	if len(flag.Args()) < 1 || *authorizedKeys == "" {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("no TLFs or authorized keys specified")
+	}
+
+	for _, p := range flag.Args() {
+		if _, _, err := libfs.ParseTlfPath(p); err != nil {
+			fmt.Print(getUsageString(ctx))
+			return libfs.InitError(err.Error())
+		}
+	}
+
+	options := libsftp.StartOptions{
+		KbfsParams:         *kbfsParams,
+		ListenAddr:         *listenAddr,
+		AuthorizedKeysFile: *authorizedKeys,
+		TLFs:               flag.Args(),
+	}
+
+	return libsftp.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfssftp error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfssync
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// SemaphorePriority is the priority class of a PrioritySemaphore
+// acquirer.  Higher values are served first.
+type SemaphorePriority int
+
+const (
+	// SemaphorePriorityBackground is for work that nobody is
+	// actively waiting on, like flushing journals.
+	SemaphorePriorityBackground SemaphorePriority = iota
+	// SemaphorePriorityInteractive is for work that a user is
+	// waiting on.
+	SemaphorePriorityInteractive
+
+	numSemaphorePriorities = iota
+)
+
+func (p SemaphorePriority) String() string {
+	switch p {
+	case SemaphorePriorityBackground:
+		return "background"
+	case SemaphorePriorityInteractive:
+		return "interactive"
+	default:
+		return fmt.Sprintf("SemaphorePriority(%d)", int(p))
+	}
+}
+
+type prioritySemaphoreWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// PrioritySemaphore is a weighted semaphore with a fixed size, whose
+// waiting acquirers are served in priority order, and in FIFO order
+// within a priority class.  As long as any acquirer of a given class
+// is waiting, no acquirer of a lower class succeeds, so a steady
+// stream of background work can't starve interactive work.  (The
+// reverse starvation is possible, and intended.)
+type PrioritySemaphore struct {
+	size int64
+
+	lock    sync.Mutex
+	used    int64
+	waiters [numSemaphorePriorities]list.List
+}
+
+// NewPrioritySemaphore returns a new PrioritySemaphore with the given
+// size (which must be positive), with all of it available.
+func NewPrioritySemaphore(size int64) *PrioritySemaphore {
+	if size <= 0 {
+		panic(fmt.Sprintf("size=%d must be positive", size))
+	}
+	return &PrioritySemaphore{size: size}
+}
+
+// Size returns the size of the semaphore.
+func (s *PrioritySemaphore) Size() int64 {
+	return s.size
+}
+
+// Count returns the amount currently available to acquire.
+func (s *PrioritySemaphore) Count() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size - s.used
+}
+
+func checkPriority(priority SemaphorePriority) {
+	if priority < 0 || priority >= numSemaphorePriorities {
+		panic(fmt.Sprintf("invalid priority %s", priority))
+	}
+}
+
+// hasWaitersLocked returns whether anyone at the given priority or
+// above is waiting.
+func (s *PrioritySemaphore) hasWaitersLocked(
+	priority SemaphorePriority) bool {
+	for p := int(numSemaphorePriorities) - 1; p >= int(priority); p-- {
+		if s.waiters[p].Len() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyLocked hands out resources to waiters, highest priority
+// first, until the next waiter in line doesn't fit.
+func (s *PrioritySemaphore) notifyLocked() {
+	for p := int(numSemaphorePriorities) - 1; p >= 0; p-- {
+		for {
+			e := s.waiters[p].Front()
+			if e == nil {
+				break
+			}
+			w := e.Value.(*prioritySemaphoreWaiter)
+			if s.size-s.used < w.n {
+				// Don't let anyone jump the queue.
+				return
+			}
+			s.used += w.n
+			s.waiters[p].Remove(e)
+			close(w.ready)
+		}
+	}
+}
+
+// Acquire blocks until n (which must be positive) can be acquired,
+// and no other acquirer of the same or a higher priority is waiting
+// ahead of it, and then acquires it and returns nil.  If the given
+// context is canceled or times out first, it acquires nothing and
+// returns a wrapped ctx.Err().  It is an error to try to acquire
+// more than the semaphore's size.
+func (s *PrioritySemaphore) Acquire(
+	ctx context.Context, n int64, priority SemaphorePriority) error {
+	if n <= 0 {
+		panic(fmt.Sprintf("n=%d must be positive", n))
+	}
+	checkPriority(priority)
+	if n > s.size {
+		return errors.Errorf(
+			"n=%d is bigger than the semaphore size %d", n, s.size)
+	}
+
+	s.lock.Lock()
+	if s.size-s.used >= n && !s.hasWaitersLocked(priority) {
+		s.used += n
+		s.lock.Unlock()
+		return nil
+	}
+	w := &prioritySemaphoreWaiter{n: n, ready: make(chan struct{})}
+	e := s.waiters[priority].PushBack(w)
+	s.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		select {
+		case <-w.ready:
+			// Acquired just as we were canceled, so give it
+			// back.
+			s.used -= n
+		default:
+			s.waiters[priority].Remove(e)
+		}
+		// Either way, waiters behind this one may now be able to
+		// go.
+		s.notifyLocked()
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// TryAcquire acquires n (which must be positive) if that's possible
+// without waiting, and returns whether it did.
+func (s *PrioritySemaphore) TryAcquire(
+	n int64, priority SemaphorePriority) bool {
+	if n <= 0 {
+		panic(fmt.Sprintf("n=%d must be positive", n))
+	}
+	checkPriority(priority)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.size-s.used < n || s.hasWaitersLocked(priority) {
+		return false
+	}
+	s.used += n
+	return true
+}
+
+// Release gives back n (which must be positive), which must have
+// been previously acquired, and wakes up any waiters that can now
+// proceed.
+func (s *PrioritySemaphore) Release(n int64) {
+	if n <= 0 {
+		panic(fmt.Sprintf("n=%d must be positive", n))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n > s.used {
+		panic(fmt.Sprintf("released n=%d but only %d is acquired",
+			n, s.used))
+	}
+	s.used -= n
+	s.notifyLocked()
+}
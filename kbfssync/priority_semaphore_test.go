@@ -0,0 +1,152 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfssync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForWaiters blocks until n acquirers of the given priority are
+// waiting on s.
+func waitForWaiters(ctx context.Context, t *testing.T,
+	s *PrioritySemaphore, priority SemaphorePriority, n int) {
+	for {
+		s.lock.Lock()
+		numWaiters := s.waiters[priority].Len()
+		s.lock.Unlock()
+		if numWaiters == n {
+			return
+		}
+		select {
+		case <-time.After(time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+}
+
+func requireAcquired(ctx context.Context, t *testing.T, errCh <-chan error) {
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
+
+func requireNotAcquired(t *testing.T, errCh <-chan error) {
+	select {
+	case err := <-errCh:
+		t.Fatalf("Unexpected acquire: %+v", err)
+	default:
+	}
+}
+
+// TestPrioritySemaphoreSimple tests that Acquire and Release work in
+// a simple two-goroutine scenario.
+func TestPrioritySemaphoreSimple(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	s := NewPrioritySemaphore(10)
+	require.NoError(t, s.Acquire(ctx, 8, SemaphorePriorityInteractive))
+	require.Equal(t, int64(2), s.Count())
+	require.False(t, s.TryAcquire(3, SemaphorePriorityInteractive))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Acquire(ctx, 3, SemaphorePriorityInteractive)
+	}()
+	waitForWaiters(ctx, t, s, SemaphorePriorityInteractive, 1)
+	requireNotAcquired(t, errCh)
+
+	s.Release(8)
+	requireAcquired(ctx, t, errCh)
+	require.Equal(t, int64(7), s.Count())
+}
+
+// TestPrioritySemaphoreOrder tests that waiting interactive acquirers
+// are served before background ones, and that neither class lets
+// later acquirers jump ahead.
+func TestPrioritySemaphoreOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	s := NewPrioritySemaphore(10)
+	require.NoError(t, s.Acquire(ctx, 10, SemaphorePriorityBackground))
+
+	bgCh := make(chan error, 1)
+	go func() {
+		bgCh <- s.Acquire(ctx, 5, SemaphorePriorityBackground)
+	}()
+	waitForWaiters(ctx, t, s, SemaphorePriorityBackground, 1)
+
+	interactiveCh := make(chan error, 1)
+	go func() {
+		interactiveCh <- s.Acquire(ctx, 8, SemaphorePriorityInteractive)
+	}()
+	waitForWaiters(ctx, t, s, SemaphorePriorityInteractive, 1)
+
+	// Even a small background acquire can't get in ahead of the
+	// waiting interactive one.
+	require.False(t, s.TryAcquire(1, SemaphorePriorityBackground))
+
+	s.Release(10)
+	requireAcquired(ctx, t, interactiveCh)
+	requireNotAcquired(t, bgCh)
+
+	s.Release(8)
+	requireAcquired(ctx, t, bgCh)
+	require.Equal(t, int64(5), s.Count())
+}
+
+// TestPrioritySemaphoreCancel tests that canceling a waiting acquirer
+// returns an error, and lets the acquirers behind it proceed.
+func TestPrioritySemaphoreCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	s := NewPrioritySemaphore(10)
+	require.NoError(t, s.Acquire(ctx, 5, SemaphorePriorityBackground))
+
+	ctx2, cancel2 := context.WithCancel(ctx)
+	defer cancel2()
+	bigCh := make(chan error, 1)
+	go func() {
+		bigCh <- s.Acquire(ctx2, 10, SemaphorePriorityBackground)
+	}()
+	waitForWaiters(ctx, t, s, SemaphorePriorityBackground, 1)
+
+	smallCh := make(chan error, 1)
+	go func() {
+		smallCh <- s.Acquire(ctx, 5, SemaphorePriorityBackground)
+	}()
+	waitForWaiters(ctx, t, s, SemaphorePriorityBackground, 2)
+	requireNotAcquired(t, smallCh)
+
+	cancel2()
+	select {
+	case err := <-bigCh:
+		require.Equal(t, context.Canceled, errors.Cause(err))
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	requireAcquired(ctx, t, smallCh)
+	require.Equal(t, int64(0), s.Count())
+}
+
+// TestPrioritySemaphoreTooBig tests that acquiring more than the
+// semaphore's size fails right away.
+func TestPrioritySemaphoreTooBig(t *testing.T) {
+	s := NewPrioritySemaphore(10)
+	err := s.Acquire(context.Background(), 11, SemaphorePriorityInteractive)
+	require.Error(t, err)
+	require.Equal(t, int64(10), s.Count())
+}
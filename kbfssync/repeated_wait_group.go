@@ -5,8 +5,11 @@
 package kbfssync
 
 import (
+	"fmt"
+	"sort"
 	"sync"
 
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
@@ -26,6 +29,25 @@ type RepeatedWaitGroup struct {
 	// an initializer for the channel.
 	paused  bool
 	pauseCh chan struct{} // leave as nil when initializing
+
+	// labels counts the outstanding tasks that were added with
+	// AddLabeled, by label.
+	labels map[string]int
+}
+
+// RepeatedWaitGroupTimeoutError is returned by WaitWithContext when
+// the context is done before the wait group's tasks finish.
+type RepeatedWaitGroupTimeoutError struct {
+	Err     error
+	Count   int
+	Pending []string
+}
+
+// Error implements the error interface for
+// RepeatedWaitGroupTimeoutError.
+func (e RepeatedWaitGroupTimeoutError) Error() string {
+	return fmt.Sprintf("%v while waiting for %d task(s); labeled: %v",
+		e.Err, e.Count, e.Pending)
 }
 
 // Add indicates that a number of tasks have begun.
@@ -45,6 +67,59 @@ func (rwg *RepeatedWaitGroup) Add(delta int) {
 	}
 }
 
+// AddLabeled indicates that a single task, described by `label`, has
+// begun.  It must be finished with DoneLabeled, using the same label.
+// Labels don't need to be unique.
+func (rwg *RepeatedWaitGroup) AddLabeled(label string) {
+	rwg.Add(1)
+	rwg.lock.Lock()
+	defer rwg.lock.Unlock()
+	if rwg.labels == nil {
+		rwg.labels = make(map[string]int)
+	}
+	rwg.labels[label]++
+}
+
+// DoneLabeled indicates that a task added with AddLabeled, with the
+// same label, has completed.
+func (rwg *RepeatedWaitGroup) DoneLabeled(label string) {
+	func() {
+		rwg.lock.Lock()
+		defer rwg.lock.Unlock()
+		if rwg.labels[label] <= 0 {
+			panic(fmt.Sprintf(
+				"RepeatedWaitGroup has no pending task labeled %q", label))
+		}
+		rwg.labels[label]--
+		if rwg.labels[label] == 0 {
+			delete(rwg.labels, label)
+		}
+	}()
+	rwg.Done()
+}
+
+// Count returns the current number of outstanding tasks.
+func (rwg *RepeatedWaitGroup) Count() int {
+	rwg.lock.Lock()
+	defer rwg.lock.Unlock()
+	return rwg.num
+}
+
+// PendingLabels returns the sorted labels of the outstanding tasks
+// that were added with AddLabeled, with one entry per task.
+func (rwg *RepeatedWaitGroup) PendingLabels() []string {
+	rwg.lock.Lock()
+	defer rwg.lock.Unlock()
+	var labels []string
+	for label, n := range rwg.labels {
+		for i := 0; i < n; i++ {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
 // Wait blocks until either the underlying task count goes to 0, or
 // the given context is canceled.
 func (rwg *RepeatedWaitGroup) Wait(ctx context.Context) error {
@@ -66,6 +141,22 @@ func (rwg *RepeatedWaitGroup) Wait(ctx context.Context) error {
 	}
 }
 
+// WaitWithContext works like Wait, except that if the given context
+// is canceled or times out first, it returns a
+// RepeatedWaitGroupTimeoutError describing the tasks that are still
+// outstanding.
+func (rwg *RepeatedWaitGroup) WaitWithContext(ctx context.Context) error {
+	err := rwg.Wait(ctx)
+	if err == nil {
+		return nil
+	}
+	return errors.WithStack(RepeatedWaitGroupTimeoutError{
+		Err:     err,
+		Count:   rwg.Count(),
+		Pending: rwg.PendingLabels(),
+	})
+}
+
 // WaitUnlessPaused works like Wait, except it can return early if the
 // wait group is paused.  It returns whether it was paused with
 // outstanding work still left in the group.
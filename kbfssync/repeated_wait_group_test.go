@@ -5,9 +5,11 @@
 package kbfssync
 
 import (
+	"reflect"
 	"sync"
 	"testing"
 
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
@@ -70,3 +72,43 @@ func TestRepeatedWaitGroupMultiWait(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestRepeatedWaitGroupWaitWithContextLabels(t *testing.T) {
+	var rwg RepeatedWaitGroup
+	rwg.Add(1)
+	rwg.AddLabeled("b")
+	rwg.AddLabeled("a")
+	rwg.AddLabeled("b")
+	if c := rwg.Count(); c != 4 {
+		t.Fatalf("Unexpected count: %d", c)
+	}
+	rwg.DoneLabeled("b")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := rwg.WaitWithContext(ctx)
+	timeoutErr, ok := errors.Cause(err).(RepeatedWaitGroupTimeoutError)
+	if !ok {
+		t.Fatalf("Unexpected error on wait: %+v", err)
+	}
+	if timeoutErr.Err != context.Canceled {
+		t.Fatalf("Unexpected underlying error: %v", timeoutErr.Err)
+	}
+	if timeoutErr.Count != 3 {
+		t.Fatalf("Unexpected count: %d", timeoutErr.Count)
+	}
+	if !reflect.DeepEqual(timeoutErr.Pending, []string{"a", "b"}) {
+		t.Fatalf("Unexpected pending labels: %v", timeoutErr.Pending)
+	}
+
+	rwg.DoneLabeled("a")
+	rwg.DoneLabeled("b")
+	rwg.Done()
+	err = rwg.WaitWithContext(context.Background())
+	if err != nil {
+		t.Fatalf("Error on wait: %v", err)
+	}
+	if labels := rwg.PendingLabels(); len(labels) != 0 {
+		t.Fatalf("Unexpected pending labels: %v", labels)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const auditUsageStr = `Usage:
+  kbfstool -audit-log audit /keybase/team/name
+  kbfstool audit -verify file.json
+
+Brings the signed audit log of the given team TLF up to date with
+its history, and exports it to stdout as JSON. The log records the
+files created, modified, renamed, and deleted by each writer, along
+with rekeys, key rotations, and changes to the TLF's name.
+
+With -verify, checks that an exported log is complete and unchanged
+instead, and prints how many entries it has.
+
+`
+
+func auditHelper(ctx context.Context, config libkbfs.Config,
+	args []string) error {
+	flags := flag.NewFlagSet("kbfs audit", flag.ContinueOnError)
+	verify := flags.Bool("verify", false,
+		"Verify the given exported log instead.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Print(auditUsageStr)
+		return errExactlyOnePath
+	}
+
+	if *verify {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		entries, err := libkbfs.ReadAuditLogExport(config.Codec(), f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d entries verified\n", len(entries))
+		return nil
+	}
+
+	al, err := libkbfs.GetAuditLogger(config)
+	if err != nil {
+		return err
+	}
+
+	p, err := fsrpc.NewPath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) > 0 {
+		return fmt.Errorf("%s is not the root of a TLF", p)
+	}
+
+	rootNode, err := p.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	return al.Export(ctx, rootNode.GetFolderBranch().Tlf, os.Stdout)
+}
+
+func audit(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := auditHelper(ctx, config, args)
+	if err != nil {
+		printError("audit", err)
+		return 1
+	}
+	return 0
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -30,3 +31,11 @@ func byteCountStr(n int) string {
 func printError(prefix string, err error) {
 	fmt.Fprintf(os.Stderr, "%s: %s\n", prefix, err)
 }
+
+// printJSON prints `v` to stdout as indented JSON, for commands run
+// with -json.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
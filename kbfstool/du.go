@@ -0,0 +1,215 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const duUsageStr = `Usage:
+  kbfstool du [-d depth] /keybase/path [paths...]
+
+Computes the recursive logical size (the sum of the file sizes) and
+the encrypted size (the sum of the sizes of all blocks stored on the
+server, including directory and indirect blocks) of each given path,
+printing a line for every directory up to the given depth.
+
+`
+
+// duStats holds the usage totals for a single directory tree.
+type duStats struct {
+	Path          string `json:"path"`
+	LogicalSize   uint64 `json:"logicalSize"`
+	EncryptedSize uint64 `json:"encryptedSize"`
+	Files         int    `json:"files"`
+	Dirs          int    `json:"dirs"`
+}
+
+func (s *duStats) add(other duStats) {
+	s.LogicalSize += other.LogicalSize
+	s.EncryptedSize += other.EncryptedSize
+	s.Files += other.Files
+	s.Dirs += other.Dirs
+}
+
+// duDirEntries adds the totals for all the entries stored in the
+// given directory block (and its indirect blocks, if any) to
+// `stats`.
+func duDirEntries(ctx context.Context, config libkbfs.Config,
+	kmd libkbfs.KeyMetadata, name string, info libkbfs.BlockInfo,
+	depth, maxDepth int, report func(duStats), stats *duStats) error {
+	stats.EncryptedSize += uint64(info.EncodedSize)
+
+	var dirBlock libkbfs.DirBlock
+	err := config.BlockOps().Get(
+		ctx, kmd, info.BlockPointer, &dirBlock, libkbfs.NoCacheEntry)
+	if err != nil {
+		return err
+	}
+
+	if dirBlock.IsInd {
+		for _, iptr := range dirBlock.IPtrs {
+			err := duDirEntries(
+				ctx, config, kmd, name, iptr.BlockInfo, depth, maxDepth,
+				report, stats)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for entryName, entry := range dirBlock.Children {
+		switch entry.Type {
+		case libkbfs.File, libkbfs.Exec:
+			encSize, err := libfs.FileEncryptedSize(
+				ctx, config, kmd, entry.BlockInfo)
+			if err != nil {
+				return err
+			}
+			stats.LogicalSize += entry.Size
+			stats.EncryptedSize += encSize
+			stats.Files++
+		case libkbfs.Dir:
+			childStats, err := duDir(
+				ctx, config, kmd, path.Join(name, entryName),
+				entry.BlockInfo, depth+1, maxDepth, report)
+			if err != nil {
+				return err
+			}
+			stats.add(childStats)
+		case libkbfs.Sym:
+			// Symlinks are stored entirely in their parent's block.
+		default:
+			return fmt.Errorf("entry %s has unknown type %s",
+				path.Join(name, entryName), entry.Type)
+		}
+	}
+	return nil
+}
+
+// duDir returns the totals for the directory rooted at the given
+// block, calling `report` for every directory no deeper than
+// `maxDepth` (or for all of them, if `maxDepth` is negative), after
+// its subdirectories have been reported.
+func duDir(ctx context.Context, config libkbfs.Config,
+	kmd libkbfs.KeyMetadata, name string, info libkbfs.BlockInfo,
+	depth, maxDepth int, report func(duStats)) (duStats, error) {
+	stats := duStats{Path: name, Dirs: 1}
+	err := duDirEntries(
+		ctx, config, kmd, name, info, depth, maxDepth, report, &stats)
+	if err != nil {
+		return duStats{}, err
+	}
+	if maxDepth < 0 || depth <= maxDepth {
+		report(stats)
+	}
+	return stats, nil
+}
+
+func duOne(ctx context.Context, config libkbfs.Config, p fsrpc.Path,
+	maxDepth int, report func(duStats)) error {
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("%s is not a path within a TLF", p)
+	}
+
+	n, ei, err := p.GetNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	irmd, err := config.MDOps().GetForTLF(
+		ctx, n.GetFolderBranch().Tlf, nil)
+	if err != nil {
+		return err
+	}
+
+	md, err := config.KBFSOps().GetNodeMetadata(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	switch ei.Type {
+	case libkbfs.Dir:
+		_, err = duDir(
+			ctx, config, irmd, p.String(), md.BlockInfo, 0, maxDepth, report)
+		return err
+	case libkbfs.File, libkbfs.Exec:
+		encSize, err := libfs.FileEncryptedSize(
+			ctx, config, irmd, md.BlockInfo)
+		if err != nil {
+			return err
+		}
+		report(duStats{
+			Path:          p.String(),
+			LogicalSize:   ei.Size,
+			EncryptedSize: encSize,
+			Files:         1,
+		})
+		return nil
+	default:
+		report(duStats{Path: p.String()})
+		return nil
+	}
+}
+
+func du(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs du", flag.ContinueOnError)
+	maxDepth := flags.Int("d", -1,
+		"Only print directories at most this many levels below each "+
+			"path (-1 for no limit).")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("du", err)
+		return 1
+	}
+
+	nodePathStrs := flags.Args()
+	if len(nodePathStrs) == 0 {
+		fmt.Print(duUsageStr)
+		return 1
+	}
+
+	var results []duStats
+	report := func(stats duStats) {
+		if *jsonOutput {
+			results = append(results, stats)
+			return
+		}
+		fmt.Printf("%d\t%d\t%s\n",
+			stats.LogicalSize, stats.EncryptedSize, stats.Path)
+	}
+
+	for _, nodePathStr := range nodePathStrs {
+		p, err := fsrpc.NewPath(nodePathStr)
+		if err != nil {
+			printError("du", err)
+			exitStatus = 1
+			continue
+		}
+
+		err = duOne(ctx, config, p, *maxDepth, report)
+		if err != nil {
+			printError("du", err)
+			exitStatus = 1
+		}
+	}
+
+	if *jsonOutput {
+		err := printJSON(results)
+		if err != nil {
+			printError("du", err)
+			return 1
+		}
+	}
+	return exitStatus
+}
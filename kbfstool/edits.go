@@ -0,0 +1,112 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const editsUsageStr = `Usage:
+  kbfstool edits [-since T] [-until T] [-format json|csv] /keybase/tlf
+
+Exports the file edit history of the given TLF, one record per file
+created, modified, or deleted by each writer, for the revisions made
+between the given times (in RFC 3339 format, e.g.
+2018-01-02T15:04:05Z). By default the whole history up to now is
+exported. A file edited more than once in the range is only listed
+with its latest edit, and files both created and deleted within the
+range aren't listed at all.
+
+The output is CSV with a header row, unless -format json (or the
+global -json flag) is given.
+
+`
+
+func editsParseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func editsHelper(ctx context.Context, config libkbfs.Config,
+	args []string) error {
+	flags := flag.NewFlagSet("kbfs edits", flag.ContinueOnError)
+	sinceStr := flags.String("since", "",
+		"Only export edits made at or after this time.")
+	untilStr := flags.String("until", "",
+		"Only export edits made at or before this time.")
+	formatStr := flags.String("format", "", "The output format (json or csv).")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Print(editsUsageStr)
+		return errExactlyOnePath
+	}
+
+	since, err := editsParseTime(*sinceStr, time.Time{})
+	if err != nil {
+		return err
+	}
+	until, err := editsParseTime(*untilStr, config.Clock().Now())
+	if err != nil {
+		return err
+	}
+
+	format := libkbfs.TlfEditExportCSV
+	if *jsonOutput {
+		format = libkbfs.TlfEditExportJSON
+	}
+	if *formatStr != "" {
+		format, err = libkbfs.ParseTlfEditExportFormat(*formatStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	p, err := fsrpc.NewPath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) > 0 {
+		return fmt.Errorf("%s is not the root of a TLF", p)
+	}
+
+	rootNode, err := p.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	edits, err := config.KBFSOps().GetEditHistoryRange(
+		ctx, rootNode.GetFolderBranch(), since, until)
+	if err != nil {
+		return err
+	}
+
+	records, err := libkbfs.MakeTlfEditRecords(ctx, config.KBPKI(), edits)
+	if err != nil {
+		return err
+	}
+	return libkbfs.ExportTlfEditRecords(os.Stdout, records, format)
+}
+
+func edits(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := editsHelper(ctx, config, args)
+	if err != nil {
+		printError("edits", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,221 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const getUsageStr = `Usage:
+  kbfstool get [-r] [-c] [-j n] [-progress] /keybase/path localpath
+
+Copies a file (or, with -r, a directory tree) out of KBFS. If
+localpath is an existing directory, the copy is placed inside it.
+
+With -c, files that already exist locally and are shorter than the
+KBFS copy are resumed from where they left off, and files with the
+same size are skipped, so an interrupted get can be re-run.
+
+`
+
+// getCollect creates the local directories and symlinks under `dst`
+// corresponding to `src`, and appends a job for each file to copy.
+func getCollect(ctx context.Context, config libkbfs.Config, src fsrpc.Path,
+	dst string, recursive bool, jobs []transferJob) ([]transferJob, error) {
+	n, de, err := src.GetNode(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch de.Type {
+	case libkbfs.File, libkbfs.Exec:
+		return append(jobs, transferJob{
+			src:    src.String(),
+			dst:    dst,
+			size:   int64(de.Size),
+			isExec: de.Type == libkbfs.Exec,
+			mtime:  time.Unix(0, de.Mtime),
+		}), nil
+	case libkbfs.Sym:
+		err := os.Symlink(de.SymPath, dst)
+		if err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		return jobs, nil
+	case libkbfs.Dir:
+	default:
+		return nil, fmt.Errorf("%s has unknown type %s", src, de.Type)
+	}
+
+	if !recursive {
+		return nil, fmt.Errorf("%s is a directory (not copied)", src)
+	}
+	if n == nil {
+		return nil, fmt.Errorf("%s is not within a TLF", src)
+	}
+
+	err = os.MkdirAll(dst, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := config.KBFSOps().GetDirChildren(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath, err := src.Join(name)
+		if err != nil {
+			return nil, err
+		}
+		jobs, err = getCollect(ctx, config, childPath,
+			filepath.Join(dst, name), recursive, jobs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+func getOne(ctx context.Context, config libkbfs.Config, job transferJob,
+	resume bool, tp *transferProgress) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cannot get %s: %v", job.src, err)
+		}
+	}()
+
+	p, err := fsrpc.NewPath(job.src)
+	if err != nil {
+		return err
+	}
+	fileNode, err := p.GetFileNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	var off int64
+	if resume {
+		fi, err := os.Stat(job.dst)
+		switch {
+		case err == nil && fi.Size() == job.size:
+			tp.addBytes(job.size)
+			return nil
+		case err == nil && fi.Size() < job.size:
+			off = fi.Size()
+		case err != nil && !os.IsNotExist(err):
+			return err
+		}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if off == 0 {
+		flags |= os.O_TRUNC
+	}
+	var perm os.FileMode = 0644
+	if job.isExec {
+		perm = 0755
+	}
+	f, err := os.OpenFile(job.dst, flags, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	_, err = f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	tp.addBytes(off)
+
+	nr := nodeReader{
+		ctx:     ctx,
+		kbfsOps: config.KBFSOps(),
+		node:    fileNode,
+		off:     off,
+	}
+	_, err = io.Copy(progressWriter{f, tp}, &nr)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(job.dst, job.mtime, job.mtime)
+}
+
+func get(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs get", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "Copy directories recursively.")
+	resume := flags.Bool("c", false, "Resume partially-copied files.")
+	parallelism := flags.Int("j", 4, "Copy this many files at once.")
+	showProgress := flags.Bool("progress", false, "Show a progress bar.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("get", err)
+		return 1
+	}
+
+	if flags.NArg() != 2 {
+		fmt.Print(getUsageStr)
+		return 1
+	}
+
+	src, err := fsrpc.NewPath(flags.Arg(0))
+	if err != nil {
+		printError("get", err)
+		return 1
+	}
+	if src.PathType != fsrpc.TLFPathType {
+		printError("get", fmt.Errorf("%s is not a path within a TLF", src))
+		return 1
+	}
+
+	dst := flags.Arg(1)
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		_, basename, err := src.DirAndBasename()
+		if err != nil {
+			printError("get", err)
+			return 1
+		}
+		dst = filepath.Join(dst, basename)
+	}
+
+	jobs, err := getCollect(ctx, config, src, dst, *recursive, nil)
+	if err != nil {
+		printError("get", err)
+		return 1
+	}
+
+	tp := newTransferProgress(jobs, *showProgress)
+	runTransferJobs(ctx, jobs, *parallelism, tp,
+		func(ctx context.Context, job transferJob) error {
+			return getOne(ctx, config, job, *resume, tp)
+		}, func(err error) {
+			printError("get", err)
+			exitStatus = 1
+		})
+	tp.stop()
+	return exitStatus
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const gitArchiveUsageStr = `Usage:
+  kbfstool git archive [flags] /keybase/tlf/path repoName ref outFile
+
+Writes an archive of the tree at the given branch, tag, ref or commit
+hash.  Unless given with -format, the archive is a zip file if
+outFile ends in .zip, and a tar.gz otherwise.  Use "-" as outFile to
+write to stdout.
+
+`
+
+func doGitArchive(ctx context.Context, rpcHandler *libgit.RPCHandler,
+	tlfStr, repoName, ref, outFile string, format libgit.ArchiveFormat) (
+	err error) {
+	folder, err := folderFromTLFPath(tlfStr)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if outFile != "-" {
+		out, err = os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			closeErr := out.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	return rpcHandler.ArchiveRepo(ctx, folder, repoName, ref, format, out)
+}
+
+func gitArchive(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs git archive", flag.ContinueOnError)
+	formatStr := flags.String("format", "",
+		"The archive format: tar.gz or zip")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("git archive", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 4 {
+		fmt.Print(gitArchiveUsageStr)
+		flags.PrintDefaults()
+		return 1
+	}
+	outFile := inputs[3]
+
+	if *formatStr == "" {
+		*formatStr = "tar.gz"
+		if strings.HasSuffix(strings.ToLower(outFile), ".zip") {
+			*formatStr = "zip"
+		}
+	}
+	format, err := libgit.ParseArchiveFormat(*formatStr)
+	if err != nil {
+		printError("git archive", err)
+		return 1
+	}
+
+	kbfsCtx := env.NewContext()
+	rpcHandler, shutdown := libgit.NewRPCHandlerWithCtx(kbfsCtx, config, nil)
+	defer shutdown()
+
+	err = doGitArchive(
+		ctx, rpcHandler, inputs[0], inputs[1], inputs[2], outFile, format)
+	if err != nil {
+		printError("git archive", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const gitGCUsageStr = `Usage:
+  kbfstool git gc [flags] /keybase/tlf/path [repoName]
+
+Garbage-collects the given repo, or every repo in the TLF if no repo
+is given.  Without any threshold flags, the thresholds are picked
+based on the size of each repo, and recently-collected repos are
+skipped.
+
+`
+
+func doGitGC(ctx context.Context, rpcHandler *libgit.RPCHandler,
+	tlfStr, repoName string, options *libgit.GCOptions) error {
+	p, err := fsrpc.NewPath(tlfStr)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("%q is not a TLF path", tlfStr)
+	}
+	if len(p.TLFComponents) > 0 {
+		return fmt.Errorf("%q is not the root path of a TLF", tlfStr)
+	}
+	folder := keybase1.Folder{
+		Name:       p.TLFName,
+		FolderType: p.TLFType.FolderType(),
+	}
+
+	return rpcHandler.GCRepos(ctx, folder, repoName, options)
+}
+
+func gitGC(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs git gc", flag.ContinueOnError)
+	maxLooseRefs := flags.Int("max-loose-refs", -1,
+		"Pack refs if there are more loose refs than this")
+	pruneMinLooseObjects := flags.Int("prune-min-loose-objects", -1,
+		"Prune unreachable objects if at least this many loose objects "+
+			"are old enough (-1 to never prune)")
+	pruneExpire := flags.Duration("prune-expire", 14*24*time.Hour,
+		"Only prune unreachable objects older than this")
+	maxObjectPacks := flags.Int("max-object-packs", -1,
+		"Re-pack objects if there are more packs than this (-1 to never "+
+			"re-pack)")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("git gc", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) < 1 || len(inputs) > 2 {
+		fmt.Print(gitGCUsageStr)
+		flags.PrintDefaults()
+		return 1
+	}
+	repoName := ""
+	if len(inputs) == 2 {
+		repoName = inputs[1]
+	}
+
+	var options *libgit.GCOptions
+	if *maxLooseRefs >= 0 || *pruneMinLooseObjects >= 0 ||
+		*maxObjectPacks >= 0 {
+		options = &libgit.GCOptions{
+			MaxLooseRefs:         *maxLooseRefs,
+			PruneMinLooseObjects: *pruneMinLooseObjects,
+			PruneExpireTime:      config.Clock().Now().Add(-*pruneExpire),
+			MaxObjectPacks:       *maxObjectPacks,
+		}
+		if options.MaxLooseRefs < 0 {
+			// Never pack refs.
+			options.MaxLooseRefs = math.MaxInt32
+		}
+	}
+
+	kbfsCtx := env.NewContext()
+	rpcHandler, shutdown := libgit.NewRPCHandlerWithCtx(kbfsCtx, config, nil)
+	defer shutdown()
+
+	err = doGitGC(ctx, rpcHandler, inputs[0], repoName, options)
+	if err != nil {
+		printError("git gc", err)
+		return 1
+	}
+
+	return 0
+}
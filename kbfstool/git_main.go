@@ -12,6 +12,9 @@ const gitUsageStr = `Usage:
 
 The possible subcommands are:
   rename	Rename a git repository
+  move		Move a git repository into a team folder
+  archive	Export a tar.gz or zip snapshot of a git repository
+  gc		Garbage-collect git repositories
 `
 
 func gitMain(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
@@ -26,6 +29,12 @@ func gitMain(ctx context.Context, config libkbfs.Config, args []string) (exitSta
 	switch cmd {
 	case "rename":
 		return gitRename(ctx, config, args)
+	case "move":
+		return gitMove(ctx, config, args)
+	case "archive":
+		return gitArchive(ctx, config, args)
+	case "gc":
+		return gitGC(ctx, config, args)
 	default:
 		printError("git", fmt.Errorf("unknown command %q", cmd))
 		return 1
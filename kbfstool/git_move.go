@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const gitMoveUsageStr = `Usage:
+  kbfstool git move /keybase/tlf/path oldName /keybase/team/name newName
+`
+
+func folderFromTLFPath(tlfStr string) (keybase1.Folder, error) {
+	p, err := fsrpc.NewPath(tlfStr)
+	if err != nil {
+		return keybase1.Folder{}, err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return keybase1.Folder{}, fmt.Errorf("%q is not a TLF path", tlfStr)
+	}
+	if len(p.TLFComponents) > 0 {
+		return keybase1.Folder{}, fmt.Errorf(
+			"%q is not the root path of a TLF", tlfStr)
+	}
+	return keybase1.Folder{
+		Name:       p.TLFName,
+		FolderType: p.TLFType.FolderType(),
+	}, nil
+}
+
+func doGitMove(ctx context.Context, rpcHandler *libgit.RPCHandler,
+	tlfStr, oldName, newTlfStr, newName string) error {
+	folder, err := folderFromTLFPath(tlfStr)
+	if err != nil {
+		return err
+	}
+	newFolder, err := folderFromTLFPath(newTlfStr)
+	if err != nil {
+		return err
+	}
+
+	return rpcHandler.MoveRepo(ctx, folder, oldName, newFolder, newName)
+}
+
+func gitMove(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs git move", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		printError("git move", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 4 {
+		fmt.Print(gitMoveUsageStr)
+		return 1
+	}
+
+	kbfsCtx := env.NewContext()
+	rpcHandler, shutdown := libgit.NewRPCHandlerWithCtx(kbfsCtx, config, nil)
+	defer shutdown()
+
+	err = doGitMove(
+		ctx, rpcHandler, inputs[0], inputs[1], inputs[2], inputs[3])
+	if err != nil {
+		printError("git move", err)
+		return 1
+	}
+
+	return 0
+}
@@ -7,9 +7,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
 )
@@ -53,6 +57,30 @@ func computeModeStr(entryType libkbfs.EntryType) string {
 	return fmt.Sprintf("%s%s%s%s", typeStr, modeStr, modeStr, "---")
 }
 
+// lastWriter returns the last writer of the given node, or "" if
+// there is none.  Top-level directories and symlinks have no node,
+// and so no writer.
+func lastWriter(ctx context.Context, config libkbfs.Config, n libkbfs.Node) string {
+	if n == nil {
+		return ""
+	}
+	md, err := config.KBFSOps().GetNodeMetadata(ctx, n)
+	if err != nil {
+		printError("ls", err)
+		return ""
+	}
+	return string(md.LastWriterUnverified)
+}
+
+// lsJSONEntry is a single entry printed by ls -json.  Writer and
+// Revision (the TLF revision the listing reflects) are only filled
+// in with -l.
+type lsJSONEntry struct {
+	fsrpc.Dirent
+	Writer   string          `json:"writer,omitempty"`
+	Revision kbfsmd.Revision `json:"revision,omitempty"`
+}
+
 func printEntry(ctx context.Context, config libkbfs.Config, dir fsrpc.Path, name string, entryType libkbfs.EntryType, longFormat, useSigil bool) {
 	var sigil string
 	if useSigil {
@@ -73,24 +101,29 @@ func printEntry(ctx context.Context, config libkbfs.Config, dir fsrpc.Path, name
 		if err != nil {
 			printError("ls", err)
 		}
-		_, de, err := p.GetNode(ctx, config)
+		n, de, err := p.GetNode(ctx, config)
 		if err != nil {
 			printError("ls", err)
 		}
 
+		writerStr := lastWriter(ctx, config, n)
+		if writerStr == "" {
+			writerStr = "-"
+		}
+
 		modeStr := computeModeStr(entryType)
 		mtimeStr := time.Unix(0, de.Mtime).Format("Jan 02 15:04")
 		var symPathStr string
 		if entryType == libkbfs.Sym {
 			symPathStr = fmt.Sprintf(" -> %s", de.SymPath)
 		}
-		fmt.Printf("%s\t%d\t%s\t%s%s%s\n", modeStr, de.Size, mtimeStr, name, sigil, symPathStr)
+		fmt.Printf("%s\t%d\t%s\t%s\t%s%s%s\n", modeStr, de.Size, writerStr, mtimeStr, name, sigil, symPathStr)
 	} else {
 		fmt.Printf("%s%s\n", name, sigil)
 	}
 }
 
-func lsHelper(ctx context.Context, config libkbfs.Config, p fsrpc.Path, hasMultiple bool, handleEntry func(string, libkbfs.EntryType)) error {
+func lsHelper(ctx context.Context, config libkbfs.Config, p fsrpc.Path, hasMultiple bool, handleDir func(libkbfs.Node), handleEntry func(fsrpc.Path, string, libkbfs.EntryInfo)) error {
 	kbfsOps := config.KBFSOps()
 
 	switch p.PathType {
@@ -98,15 +131,15 @@ func lsHelper(ctx context.Context, config libkbfs.Config, p fsrpc.Path, hasMulti
 		if hasMultiple {
 			printHeader(p)
 		}
-		handleEntry(topName, libkbfs.Dir)
+		handleEntry(p, topName, libkbfs.EntryInfo{Type: libkbfs.Dir})
 		return nil
 
 	case fsrpc.KeybasePathType:
 		if hasMultiple {
 			printHeader(p)
 		}
-		handleEntry(publicName, libkbfs.Dir)
-		handleEntry(privateName, libkbfs.Dir)
+		handleEntry(p, publicName, libkbfs.EntryInfo{Type: libkbfs.Dir})
+		handleEntry(p, privateName, libkbfs.EntryInfo{Type: libkbfs.Dir})
 		return nil
 
 	case fsrpc.KeybaseChildPathType:
@@ -120,7 +153,7 @@ func lsHelper(ctx context.Context, config libkbfs.Config, p fsrpc.Path, hasMulti
 		}
 		for _, fav := range favs {
 			if p.TLFType == fav.Type {
-				handleEntry(fav.Name, libkbfs.Dir)
+				handleEntry(p, fav.Name, libkbfs.EntryInfo{Type: libkbfs.Dir})
 			}
 		}
 		return nil
@@ -146,15 +179,16 @@ func lsHelper(ctx context.Context, config libkbfs.Config, p fsrpc.Path, hasMulti
 			if hasMultiple {
 				printHeader(p)
 			}
+			handleDir(n)
 			for name, entryInfo := range children {
-				handleEntry(name, entryInfo.Type)
+				handleEntry(p, name, entryInfo)
 			}
 		} else {
-			_, name, err := p.DirAndBasename()
+			dir, name, err := p.DirAndBasename()
 			if err != nil {
 				return err
 			}
-			handleEntry(name, de.Type)
+			handleEntry(dir, name, de)
 		}
 		return nil
 
@@ -165,15 +199,55 @@ func lsHelper(ctx context.Context, config libkbfs.Config, p fsrpc.Path, hasMulti
 	return fmt.Errorf("invalid KBFS path %s", p)
 }
 
-func lsOne(ctx context.Context, config libkbfs.Config, p fsrpc.Path, longFormat, useSigil, recursive, hasMultiple bool, errorFn func(error)) {
+// lsOne lists `p`.  If `jsonEntries` is non-nil, the entries are
+// appended to it instead of being printed.
+func lsOne(ctx context.Context, config libkbfs.Config, p fsrpc.Path, longFormat, useSigil, recursive, hasMultiple bool, jsonEntries *[]lsJSONEntry, errorFn func(error)) {
+	var revision kbfsmd.Revision
+	handleDir := func(n libkbfs.Node) {
+		if !longFormat {
+			return
+		}
+		// Like the "total" line of ls -l, but with the TLF
+		// revision the listing reflects.
+		status, _, err := config.KBFSOps().FolderStatus(
+			ctx, n.GetFolderBranch())
+		if err != nil {
+			errorFn(err)
+			return
+		}
+		revision = status.Revision
+		if jsonEntries == nil {
+			fmt.Printf("revision %d\n", status.Revision)
+		}
+	}
 	var children []string
-	handleEntry := func(name string, entryType libkbfs.EntryType) {
-		if recursive && entryType == libkbfs.Dir {
+	handleEntry := func(dir fsrpc.Path, name string, ei libkbfs.EntryInfo) {
+		if recursive && ei.Type == libkbfs.Dir {
 			children = append(children, name)
 		}
-		printEntry(ctx, config, p, name, entryType, longFormat, useSigil)
+		if jsonEntries == nil {
+			printEntry(ctx, config, dir, name, ei.Type, longFormat, useSigil)
+			return
+		}
+
+		entryPath, err := dir.Join(name)
+		if err != nil {
+			errorFn(err)
+			return
+		}
+		entry := lsJSONEntry{Dirent: fsrpc.NewDirent(entryPath, ei)}
+		if longFormat {
+			n, _, err := entryPath.GetNode(ctx, config)
+			if err != nil {
+				errorFn(err)
+			}
+			entry.Writer = lastWriter(ctx, config, n)
+			entry.Revision = revision
+		}
+		*jsonEntries = append(*jsonEntries, entry)
 	}
-	err := lsHelper(ctx, config, p, hasMultiple || recursive, handleEntry)
+	printHeaders := (hasMultiple || recursive) && jsonEntries == nil
+	err := lsHelper(ctx, config, p, printHeaders, handleDir, handleEntry)
 	if err != nil {
 		errorFn(err)
 		// Fall-through.
@@ -187,12 +261,81 @@ func lsOne(ctx context.Context, config libkbfs.Config, p fsrpc.Path, longFormat,
 				continue
 			}
 
-			fmt.Print("\n")
-			lsOne(ctx, config, childPath, longFormat, useSigil, true, true, errorFn)
+			if jsonEntries == nil {
+				fmt.Print("\n")
+			}
+			lsOne(ctx, config, childPath, longFormat, useSigil, true, true, jsonEntries, errorFn)
 		}
 	}
 }
 
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[\\")
+}
+
+// lsExpandGlob returns the paths matching `p`, whose TLF components
+// may contain path.Match patterns, in sorted order.
+func lsExpandGlob(ctx context.Context, config libkbfs.Config, p fsrpc.Path) ([]fsrpc.Path, error) {
+	if hasGlobMeta(p.TLFName) {
+		return nil, fmt.Errorf("patterns in TLF names are not supported: %s", p)
+	}
+
+	matches := []fsrpc.Path{{
+		PathType: p.PathType,
+		TLFType:  p.TLFType,
+		TLFName:  p.TLFName,
+	}}
+	for _, component := range p.TLFComponents {
+		var nextMatches []fsrpc.Path
+		for _, match := range matches {
+			if !hasGlobMeta(component) {
+				childPath, err := match.Join(component)
+				if err != nil {
+					return nil, err
+				}
+				nextMatches = append(nextMatches, childPath)
+				continue
+			}
+
+			n, de, err := match.GetNode(ctx, config)
+			if err != nil {
+				return nil, err
+			}
+			if de.Type != libkbfs.Dir {
+				continue
+			}
+			children, err := config.KBFSOps().GetDirChildren(ctx, n)
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(children))
+			for name := range children {
+				ok, err := path.Match(component, name)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				childPath, err := match.Join(name)
+				if err != nil {
+					return nil, err
+				}
+				nextMatches = append(nextMatches, childPath)
+			}
+		}
+		matches = nextMatches
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no matches found for %s", p)
+	}
+	return matches, nil
+}
+
 func ls(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
 	flags := flag.NewFlagSet("kbfs ls", flag.ContinueOnError)
 	longFormat := flags.Bool("l", false, "List in long format.")
@@ -212,8 +355,8 @@ func ls(ctx context.Context, config libkbfs.Config, args []string) (exitStatus i
 		return
 	}
 
-	hasMultiple := len(nodePathStrs) > 1
-	for i, nodePathStr := range nodePathStrs {
+	var paths []fsrpc.Path
+	for _, nodePathStr := range nodePathStrs {
 		p, err := fsrpc.NewPath(nodePathStr)
 		if err != nil {
 			printError("ls", err)
@@ -221,14 +364,43 @@ func ls(ctx context.Context, config libkbfs.Config, args []string) (exitStatus i
 			continue
 		}
 
-		if i > 0 {
+		if p.PathType != fsrpc.TLFPathType || !hasGlobMeta(nodePathStr) {
+			paths = append(paths, p)
+			continue
+		}
+
+		matches, err := lsExpandGlob(ctx, config, p)
+		if err != nil {
+			printError("ls", err)
+			exitStatus = 1
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+
+	var jsonEntries *[]lsJSONEntry
+	if *jsonOutput {
+		jsonEntries = &[]lsJSONEntry{}
+	}
+
+	hasMultiple := len(paths) > 1
+	for i, p := range paths {
+		if i > 0 && jsonEntries == nil {
 			fmt.Print("\n")
 		}
 
-		lsOne(ctx, config, p, *longFormat, *useSigil, *recursive, hasMultiple, func(err error) {
+		lsOne(ctx, config, p, *longFormat, *useSigil, *recursive, hasMultiple, jsonEntries, func(err error) {
 			printError("ls", err)
 			exitStatus = 1
 		})
 	}
+
+	if jsonEntries != nil {
+		err := printJSON(*jsonEntries)
+		if err != nil {
+			printError("ls", err)
+			exitStatus = 1
+		}
+	}
 	return
 }
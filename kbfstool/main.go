@@ -18,18 +18,21 @@ import (
 
 var version = flag.Bool("version", false, "Print version")
 
+var jsonOutput = flag.Bool("json", false,
+	"Print command output as JSON, where supported")
+
 const usageFormatStr = `Usage:
   kbfstool -version
 
 To run against remote KBFS servers:
   kbfstool
 %s
-    <command> [<args>]
+    [-json] <command> [<args>]
 
 To run in a local testing environment:
   kbfstool
 %s
-    <command> [<args>]
+    [-json] <command> [<args>]
 
 Defaults:
 %s
@@ -40,6 +43,16 @@ The possible commands are:
   mkdir		Make directories
   read		Dump file to stdout
   write		Write stdin to file
+  get		Copy files out of KBFS
+  put		Copy files into KBFS
+  restore	Restore files from an earlier revision
+  rekey		Rekey folders and show their device key status
+  sync		Wait for a folder's pending writes to reach the server
+  watch		Print changes to a directory as they happen
+  mirror	One-way sync a local directory into KBFS
+  du		Display disk usage
+  edits		Export a folder's file edit history
+  audit		Export or verify a team folder's audit log
   md            Operate on metadata objects
   git           Operate on git repositories
 
@@ -103,6 +116,26 @@ func realMain() (exitStatus int) {
 		return read(ctx, config, args)
 	case "write":
 		return write(ctx, config, args)
+	case "get":
+		return get(ctx, config, args)
+	case "put":
+		return put(ctx, config, args)
+	case "restore":
+		return restore(ctx, config, args)
+	case "rekey":
+		return rekey(ctx, config, args)
+	case "sync":
+		return syncCmd(ctx, config, args)
+	case "watch":
+		return watch(ctx, config, args)
+	case "mirror":
+		return mirror(ctx, config, args)
+	case "du":
+		return du(ctx, config, args)
+	case "edits":
+		return edits(ctx, config, args)
+	case "audit":
+		return audit(ctx, config, args)
 	case "md":
 		return mdMain(ctx, config, args)
 	case "git":
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// mdHistoryWriterCache maps writer UIDs to their user info, so that
+// each writer is only looked up once per invocation.
+type mdHistoryWriterCache map[keybase1.UID]libkbfs.UserInfo
+
+func (c mdHistoryWriterCache) writerAndDevice(ctx context.Context,
+	config libkbfs.Config, irmd libkbfs.ImmutableRootMetadata) (
+	writer, device string) {
+	uid := irmd.LastModifyingWriter()
+	ui, ok := c[uid]
+	if !ok {
+		var err error
+		ui, err = config.KeybaseService().LoadUserPlusKeys(ctx, uid, "")
+		if err != nil {
+			printError("md history", err)
+			return uid.String(), "unknown"
+		}
+		c[uid] = ui
+	}
+
+	device, ok = ui.KIDNames[irmd.LastModifyingWriterVerifyingKey().KID()]
+	if !ok {
+		device = irmd.LastModifyingWriterVerifyingKey().String()
+	}
+	return string(ui.Name), device
+}
+
+// mdHistoryEntry summarizes a single MD revision.
+type mdHistoryEntry struct {
+	Revision  kbfsmd.Revision `json:"revision"`
+	Writer    string          `json:"writer"`
+	Device    string          `json:"device"`
+	Timestamp time.Time       `json:"timestamp"`
+	// Ops is nil if the revision isn't readable.
+	Ops []string `json:"ops"`
+}
+
+func mdHistoryMakeEntry(ctx context.Context, config libkbfs.Config,
+	writers mdHistoryWriterCache,
+	irmd libkbfs.ImmutableRootMetadata) mdHistoryEntry {
+	writer, device := writers.writerAndDevice(ctx, config, irmd)
+	entry := mdHistoryEntry{
+		Revision:  irmd.Revision(),
+		Writer:    writer,
+		Device:    device,
+		Timestamp: irmd.LocalTimestamp(),
+	}
+	if irmd.IsReadable() {
+		entry.Ops = []string{}
+		for _, op := range irmd.Data().Changes.Ops {
+			entry.Ops = append(entry.Ops, op.String())
+		}
+	}
+	return entry
+}
+
+func mdHistoryPrintEntry(entry mdHistoryEntry) {
+	fmt.Printf("Revision %s\n", entry.Revision)
+	fmt.Printf("  Writer: %s (device %s)\n", entry.Writer, entry.Device)
+	fmt.Printf("  Timestamp: %s\n", entry.Timestamp.Format(time.RFC3339))
+
+	if entry.Ops == nil {
+		fmt.Print("  Ops: <unreadable>\n")
+		return
+	}
+	fmt.Printf("  Ops (%d):\n", len(entry.Ops))
+	for _, op := range entry.Ops {
+		fmt.Printf("    %s\n", op)
+	}
+}
+
+func mdHistoryChunk(ctx context.Context, config libkbfs.Config,
+	writers mdHistoryWriterCache, tlfID tlf.ID, branchID kbfsmd.BranchID,
+	start, stop kbfsmd.Revision, handleEntry func(mdHistoryEntry)) error {
+	min := start
+	max := stop
+	reversed := false
+	if start > stop {
+		min = stop
+		max = start
+		reversed = true
+	}
+
+	irmds, err := mdGet(ctx, config, tlfID, branchID, min, max)
+	if err != nil {
+		return err
+	}
+
+	if reversed {
+		irmds = reverseIRMDList(irmds)
+	}
+
+	for _, irmd := range irmds {
+		handleEntry(mdHistoryMakeEntry(ctx, config, writers, irmd))
+	}
+
+	return nil
+}
+
+const mdHistoryUsageStr = `Usage:
+  kbfstool md history TLF[:Branch] [RevisionRange]
+
+Prints a summary of each revision of the given TLF in RevisionRange,
+including the revision number, the writer and device that made it,
+its timestamp, and the operations it contains.
+
+TLF, Branch, and RevisionRange are in the same format as for
+"kbfstool md dump". If RevisionRange is omitted, the full history of
+the branch is printed, starting with the latest revision.
+`
+
+func mdHistory(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs md history", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		printError("md history", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) < 1 || len(inputs) > 2 {
+		fmt.Print(mdHistoryUsageStr)
+		return 1
+	}
+
+	tlfStr, branchStr, startStr, stopStr, err := mdSplitInput(inputs[0])
+	if err != nil {
+		printError("md history", err)
+		return 1
+	}
+	if startStr != "" || stopStr != "" {
+		printError("md history", fmt.Errorf(
+			"revision range must be given as a separate argument"))
+		return 1
+	}
+
+	if len(inputs) == 2 {
+		// Re-use the md input parser to split up the range.
+		_, _, startStr, stopStr, err = mdSplitInput("_^" + inputs[1])
+		if err != nil {
+			printError("md history", err)
+			return 1
+		}
+	} else {
+		startStr, stopStr = "latest", "1"
+	}
+
+	tlfID, branchID, start, stop, err :=
+		mdParseInput(ctx, config, tlfStr, branchStr, startStr, stopStr)
+	if err != nil {
+		printError("md history", err)
+		return 1
+	}
+
+	const maxChunkSize = 100
+
+	entries := []mdHistoryEntry{}
+	handleEntry := func(entry mdHistoryEntry) {
+		if *jsonOutput {
+			entries = append(entries, entry)
+			return
+		}
+		mdHistoryPrintEntry(entry)
+		fmt.Print("\n")
+	}
+
+	writers := make(mdHistoryWriterCache)
+	if start <= stop {
+		for chunkStart := start; chunkStart <= stop; chunkStart += maxChunkSize {
+			chunkStop := chunkStart + maxChunkSize - 1
+			if chunkStop > stop {
+				chunkStop = stop
+			}
+			err = mdHistoryChunk(ctx, config, writers, tlfID, branchID,
+				chunkStart, chunkStop, handleEntry)
+			if err != nil {
+				printError("md history", err)
+				return 1
+			}
+		}
+	} else {
+		for chunkStart := start; chunkStart >= stop; chunkStart -= maxChunkSize {
+			chunkStop := chunkStart - maxChunkSize + 1
+			if chunkStop < stop {
+				chunkStop = stop
+			}
+			err = mdHistoryChunk(ctx, config, writers, tlfID, branchID,
+				chunkStart, chunkStop, handleEntry)
+			if err != nil {
+				printError("md history", err)
+				return 1
+			}
+		}
+	}
+
+	if *jsonOutput {
+		err := printJSON(entries)
+		if err != nil {
+			printError("md history", err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -12,7 +12,9 @@ const mdUsageStr = `Usage:
 
 The possible subcommands are:
   dump	      Dump metadata objects
+  history     Summarize the revision history of a folder
   check	      Check metadata objects and their associated blocks for errors
+  verify      Verify the signature chain of metadata objects offline
   reset	      Reset a broken top-level folder
   force-qr    Append a fake quota reclamation record to the folder history
 `
@@ -29,8 +31,12 @@ func mdMain(ctx context.Context, config libkbfs.Config, args []string) (exitStat
 	switch cmd {
 	case "dump":
 		return mdDump(ctx, config, args)
+	case "history":
+		return mdHistory(ctx, config, args)
 	case "check":
 		return mdCheck(ctx, config, args)
+	case "verify":
+		return mdVerify(ctx, config, args)
 	case "reset":
 		return mdReset(ctx, config, args)
 	case "force-qr":
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const mdVerifyUsageStr = `Usage:
+  kbfstool md verify [-v] input [inputs...]
+
+Each input must be in the same format as in md dump. The signed
+metadata objects in each range, along with their key bundles, are
+first downloaded as-is from the metadata server, and then the chain
+of signatures and previous-revision pointers between them is
+verified offline, without trusting the client's own checks.
+
+Only the signatures themselves are checked, not that the signing keys
+belonged to the recorded writers (or that they were team members) at
+the time; use -v to print the signers of each revision so that they
+can be checked independently.
+
+`
+
+// mdVerifyAnyTeamMember lets any user act as a member of any team,
+// since team membership can't be checked offline.
+type mdVerifyAnyTeamMember struct{}
+
+func (mdVerifyAnyTeamMember) IsTeamWriter(
+	_ context.Context, _ keybase1.TeamID, _ keybase1.UID,
+	_ kbfscrypto.VerifyingKey) (bool, error) {
+	return true, nil
+}
+
+func (mdVerifyAnyTeamMember) IsTeamReader(
+	_ context.Context, _ keybase1.TeamID, _ keybase1.UID) (bool, error) {
+	return true, nil
+}
+
+// mdVerifyKeyBundles holds all the key bundles for a range of MD
+// objects.  Unlike kbfsmd.KeyBundleCacheStandard, it never evicts
+// anything.
+type mdVerifyKeyBundles struct {
+	wkbs map[kbfsmd.TLFWriterKeyBundleID]kbfsmd.TLFWriterKeyBundleV3
+	rkbs map[kbfsmd.TLFReaderKeyBundleID]kbfsmd.TLFReaderKeyBundleV3
+}
+
+var _ kbfsmd.KeyBundleCache = mdVerifyKeyBundles{}
+
+func (kbs mdVerifyKeyBundles) GetTLFReaderKeyBundle(
+	bundleID kbfsmd.TLFReaderKeyBundleID) (
+	*kbfsmd.TLFReaderKeyBundleV3, error) {
+	if rkb, ok := kbs.rkbs[bundleID]; ok {
+		return &rkb, nil
+	}
+	return nil, nil
+}
+
+func (kbs mdVerifyKeyBundles) GetTLFWriterKeyBundle(
+	bundleID kbfsmd.TLFWriterKeyBundleID) (
+	*kbfsmd.TLFWriterKeyBundleV3, error) {
+	if wkb, ok := kbs.wkbs[bundleID]; ok {
+		return &wkb, nil
+	}
+	return nil, nil
+}
+
+func (kbs mdVerifyKeyBundles) PutTLFReaderKeyBundle(
+	bundleID kbfsmd.TLFReaderKeyBundleID, rkb kbfsmd.TLFReaderKeyBundleV3) {
+	kbs.rkbs[bundleID] = rkb
+}
+
+func (kbs mdVerifyKeyBundles) PutTLFWriterKeyBundle(
+	bundleID kbfsmd.TLFWriterKeyBundleID, wkb kbfsmd.TLFWriterKeyBundleV3) {
+	kbs.wkbs[bundleID] = wkb
+}
+
+// mdVerifyGetRange fetches the given range of signed MD objects from
+// the server without verifying them, and caches all the key bundles
+// they refer to in the returned cache.
+func mdVerifyGetRange(ctx context.Context, config libkbfs.Config,
+	tlfID tlf.ID, branchID kbfsmd.BranchID, start, stop kbfsmd.Revision) (
+	[]*kbfsmd.RootMetadataSigned, kbfsmd.KeyBundleCache, error) {
+	mStatus := kbfsmd.Merged
+	if branchID != kbfsmd.NullBranchID {
+		mStatus = kbfsmd.Unmerged
+	}
+	mdserver := config.MDServer()
+	rmdses, err := mdserver.GetRange(
+		ctx, tlfID, branchID, mStatus, start, stop, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kbCache := mdVerifyKeyBundles{
+		make(map[kbfsmd.TLFWriterKeyBundleID]kbfsmd.TLFWriterKeyBundleV3),
+		make(map[kbfsmd.TLFReaderKeyBundleID]kbfsmd.TLFReaderKeyBundleV3),
+	}
+	bareRMDSes := make([]*kbfsmd.RootMetadataSigned, 0, len(rmdses))
+	for _, rmds := range rmdses {
+		bareRMDSes = append(bareRMDSes, &rmds.RootMetadataSigned)
+
+		wkbID := rmds.MD.GetTLFWriterKeyBundleID()
+		rkbID := rmds.MD.GetTLFReaderKeyBundleID()
+		if (wkbID == kbfsmd.TLFWriterKeyBundleID{}) ||
+			(rkbID == kbfsmd.TLFReaderKeyBundleID{}) {
+			continue
+		}
+		_, haveWKB := kbCache.wkbs[wkbID]
+		_, haveRKB := kbCache.rkbs[rkbID]
+		if haveWKB && haveRKB {
+			continue
+		}
+		wkb, rkb, err := mdserver.GetKeyBundles(ctx, tlfID, wkbID, rkbID)
+		if err != nil {
+			return nil, nil, err
+		}
+		kbCache.PutTLFWriterKeyBundle(wkbID, *wkb)
+		kbCache.PutTLFReaderKeyBundle(rkbID, *rkb)
+	}
+	return bareRMDSes, kbCache, nil
+}
+
+func mdVerify(ctx context.Context, config libkbfs.Config, args []string) (
+	exitStatus int) {
+	flags := flag.NewFlagSet("kbfs md verify", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "Print the signers of each revision.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("md verify", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) < 1 {
+		fmt.Print(mdVerifyUsageStr)
+		return 1
+	}
+
+	for _, input := range inputs {
+		tlfStr, branchStr, startStr, stopStr, err := mdSplitInput(input)
+		if err != nil {
+			printError("md verify", err)
+			return 1
+		}
+
+		tlfID, branchID, start, stop, err :=
+			mdParseInput(ctx, config, tlfStr, branchStr, startStr, stopStr)
+		if err != nil {
+			printError("md verify", err)
+			return 1
+		}
+
+		min := start
+		max := stop
+		if start > stop {
+			min = stop
+			max = start
+		}
+
+		rmdses, kbCache, err := mdVerifyGetRange(
+			ctx, config, tlfID, branchID, min, max)
+		if err != nil {
+			printError("md verify", err)
+			return 1
+		}
+
+		if len(rmdses) == 0 {
+			fmt.Printf("No result found for %q\n\n", input)
+			continue
+		}
+
+		if *verbose {
+			for _, rmds := range rmdses {
+				fmt.Printf("Revision %d: writer %s (key %s), "+
+					"user %s (key %s)\n",
+					rmds.MD.RevisionNumber(),
+					rmds.MD.LastModifyingWriter(),
+					rmds.WriterSigInfo.VerifyingKey,
+					rmds.MD.GetLastModifyingUser(),
+					rmds.SigInfo.VerifyingKey)
+			}
+		}
+
+		err = kbfsmd.VerifyChain(ctx, config.Codec(),
+			mdVerifyAnyTeamMember{}, kbCache, rmdses)
+		if err != nil {
+			printError("md verify", err)
+			return 1
+		}
+
+		fmt.Printf("Verified chain from rev %d to %d\n\n",
+			rmdses[0].MD.RevisionNumber(),
+			rmdses[len(rmdses)-1].MD.RevisionNumber())
+	}
+
+	return 0
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/libmirror"
+	"golang.org/x/net/context"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const mirrorUsageStr = `Usage:
+  kbfstool mirror [-ignore pattern]... [-dry-run] [-once]
+    [-interval duration] /path/to/local/dir /keybase/path
+
+One-way syncs the given local directory into the given KBFS
+directory, repeating every interval until interrupted (or just
+once, if -once or -dry-run is given).  Anything in the KBFS
+directory that isn't in the local directory is removed, unless it
+matches an ignore pattern.
+
+Ignore patterns use gitignore-like syntax: a pattern ending in "/"
+only matches directories, and a pattern containing a "/" is matched
+against the whole path relative to the local directory, rather than
+against each name.  "!" and "**" are not supported.
+
+With -dry-run, the actions that would be taken are printed, but
+nothing is changed.
+
+`
+
+// ignoreFlag collects the values of a repeated -ignore flag; each
+// value may also be a comma-separated list of patterns.
+type ignoreFlag []string
+
+var _ flag.Value = (*ignoreFlag)(nil)
+
+func (f *ignoreFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *ignoreFlag) Set(s string) error {
+	for _, pattern := range strings.Split(s, ",") {
+		if pattern != "" {
+			*f = append(*f, pattern)
+		}
+	}
+	return nil
+}
+
+func printMirrorResult(res libmirror.Result) error {
+	if *jsonOutput {
+		return printJSON(res)
+	}
+	for _, a := range res.Actions {
+		prefix := ""
+		if res.DryRun {
+			prefix = "(dry run) "
+		}
+		switch a.Type {
+		case libmirror.ActionRename:
+			fmt.Printf("%s%s %s -> %s\n", prefix, a.Type, a.OldPath, a.Path)
+		default:
+			fmt.Printf("%s%s %s\n", prefix, a.Type, a.Path)
+		}
+	}
+	return nil
+}
+
+func mirrorHelper(ctx context.Context, config libkbfs.Config,
+	args []string) error {
+	flags := flag.NewFlagSet("kbfs mirror", flag.ContinueOnError)
+	var ignore ignoreFlag
+	flags.Var(&ignore, "ignore",
+		"Pattern of paths to ignore; may be given more than once.")
+	dryRun := flags.Bool("dry-run", false,
+		"Print what would change, without changing anything.")
+	once := flags.Bool("once", false, "Sync once, and then exit.")
+	interval := flags.Duration("interval", libmirror.DefaultInterval,
+		"How often to rescan the local directory.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		fmt.Print(mirrorUsageStr)
+		return fmt.Errorf("a local directory and a KBFS path " +
+			"must be specified")
+	}
+
+	p, err := fsrpc.NewPath(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("%q is not a path within a TLF", flags.Arg(1))
+	}
+	h, err := fsrpc.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), p.TLFName, p.TLFType)
+	if err != nil {
+		return err
+	}
+	fs, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	if err != nil {
+		return err
+	}
+	var dest billy.Filesystem = fs
+	if len(p.TLFComponents) > 0 {
+		dir := path.Join(p.TLFComponents...)
+		err = fs.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+		dest, err = fs.Chroot(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	engine, err := libmirror.NewEngine(flags.Arg(0), dest, libmirror.Options{
+		Ignore:   append([]string{libmirror.KBFSReservedPattern}, ignore...),
+		DryRun:   *dryRun,
+		Interval: *interval,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *once || *dryRun {
+		res, err := engine.Sync(ctx)
+		if err != nil {
+			return err
+		}
+		return printMirrorResult(res)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var printErr error
+	err = engine.Run(ctx, func(res libmirror.Result, err error) {
+		if err != nil {
+			printError("mirror", err)
+			return
+		}
+		if printErr == nil {
+			printErr = printMirrorResult(res)
+		}
+	})
+	if err == context.Canceled {
+		return printErr
+	}
+	return err
+}
+
+func mirror(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	err := mirrorHelper(ctx, config, args)
+	if err != nil {
+		printError("mirror", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,243 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const putUsageStr = `Usage:
+  kbfstool put [-r] [-c] [-j n] [-progress] localpath /keybase/path
+
+Copies a file (or, with -r, a directory tree) into KBFS. If
+/keybase/path is an existing directory, the copy is placed inside it.
+Each file is synced to the server as soon as it has been copied.
+
+With -c, files that already exist in KBFS and are shorter than the
+local copy are resumed from where they left off, and files with the
+same size are skipped, so an interrupted put can be re-run.
+
+`
+
+// putCollect creates the KBFS directories and symlinks under `dst`
+// corresponding to `src`, and appends a job for each file to copy.
+func putCollect(ctx context.Context, config libkbfs.Config, src string,
+	dst fsrpc.Path, recursive bool, jobs []transferJob) ([]transferJob, error) {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case fi.Mode().IsRegular():
+		return append(jobs, transferJob{
+			src:    src,
+			dst:    dst.String(),
+			size:   fi.Size(),
+			isExec: fi.Mode()&0100 != 0,
+			mtime:  fi.ModTime(),
+		}), nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return nil, err
+		}
+		parentDir, name, err := dst.DirAndBasename()
+		if err != nil {
+			return nil, err
+		}
+		parentNode, err := parentDir.GetDirNode(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		_, err = config.KBFSOps().CreateLink(ctx, parentNode, name, target)
+		if err != nil && err != (libkbfs.NameExistsError{Name: name}) {
+			return nil, err
+		}
+		return jobs, nil
+	case fi.IsDir():
+	default:
+		return nil, fmt.Errorf("%s is not a regular file, directory, or "+
+			"symlink", src)
+	}
+
+	if !recursive {
+		return nil, fmt.Errorf("%s is a directory (not copied)", src)
+	}
+
+	parentDir, name, err := dst.DirAndBasename()
+	if err != nil {
+		return nil, err
+	}
+	if parentDir.PathType == fsrpc.TLFPathType {
+		parentNode, err := parentDir.GetDirNode(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		_, _, err = config.KBFSOps().CreateDir(ctx, parentNode, name)
+		if err != nil && err != (libkbfs.NameExistsError{Name: name}) {
+			return nil, err
+		}
+	}
+
+	children, err := ioutil.ReadDir(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		childPath, err := dst.Join(child.Name())
+		if err != nil {
+			return nil, err
+		}
+		jobs, err = putCollect(ctx, config,
+			filepath.Join(src, child.Name()), childPath, recursive, jobs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+func putOne(ctx context.Context, config libkbfs.Config, job transferJob,
+	resume bool, tp *transferProgress) (err error) {
+	defer func() {
+		if err != nil {
+			err = cannotWriteErr{job.dst, err}
+		}
+	}()
+
+	p, err := fsrpc.NewPath(job.dst)
+	if err != nil {
+		return err
+	}
+	dir, filename, err := p.DirAndBasename()
+	if err != nil {
+		return err
+	}
+	parentNode, err := dir.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	kbfsOps := config.KBFSOps()
+	var off int64
+	fileNode, de, err := kbfsOps.Lookup(ctx, parentNode, filename)
+	switch {
+	case err == (libkbfs.NoSuchNameError{Name: filename}):
+		fileNode, _, err = kbfsOps.CreateFile(
+			ctx, parentNode, filename, job.isExec, libkbfs.NoExcl)
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case resume && int64(de.Size) == job.size:
+		tp.addBytes(job.size)
+		return nil
+	case resume && int64(de.Size) < job.size:
+		off = int64(de.Size)
+	default:
+		err = kbfsOps.Truncate(ctx, fileNode, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(job.src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	tp.addBytes(off)
+
+	nw := nodeWriter{
+		ctx:     ctx,
+		kbfsOps: kbfsOps,
+		node:    fileNode,
+		off:     off,
+	}
+	_, err = io.Copy(progressWriter{&nw, tp}, f)
+	if err != nil {
+		return err
+	}
+
+	err = kbfsOps.SetMtime(ctx, fileNode, &job.mtime)
+	if err != nil {
+		return err
+	}
+
+	// Sync each file as it's done, so that an interrupted put
+	// can be resumed.
+	return kbfsOps.SyncAll(ctx, fileNode.GetFolderBranch())
+}
+
+func put(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs put", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "Copy directories recursively.")
+	resume := flags.Bool("c", false, "Resume partially-copied files.")
+	parallelism := flags.Int("j", 4, "Copy this many files at once.")
+	showProgress := flags.Bool("progress", false, "Show a progress bar.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("put", err)
+		return 1
+	}
+
+	if flags.NArg() != 2 {
+		fmt.Print(putUsageStr)
+		return 1
+	}
+
+	src := flags.Arg(0)
+	dst, err := fsrpc.NewPath(flags.Arg(1))
+	if err != nil {
+		printError("put", err)
+		return 1
+	}
+	if dst.PathType != fsrpc.TLFPathType {
+		printError("put", cannotWriteErr{dst.String(), nil})
+		return 1
+	}
+
+	if _, de, err := dst.GetNode(ctx, config); err == nil &&
+		de.Type == libkbfs.Dir {
+		dst, err = dst.Join(filepath.Base(src))
+		if err != nil {
+			printError("put", err)
+			return 1
+		}
+	}
+
+	jobs, err := putCollect(ctx, config, src, dst, *recursive, nil)
+	if err != nil {
+		printError("put", err)
+		return 1
+	}
+
+	tp := newTransferProgress(jobs, *showProgress)
+	runTransferJobs(ctx, jobs, *parallelism, tp,
+		func(ctx context.Context, job transferJob) error {
+			return putOne(ctx, config, job, *resume, tp)
+		}, func(err error) {
+			printError("put", err)
+			exitStatus = 1
+		})
+	tp.stop()
+	return exitStatus
+}
@@ -0,0 +1,238 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const rekeyUsageStr = `Usage:
+  kbfstool rekey [-status] [/keybase/private/tlf...]
+
+Rekeys each given private TLF (or, if none are given, every private
+TLF in the current user's favorites), waiting for each rekey to
+finish. Afterwards, prints the key status of every device of every
+user in the TLF:
+
+  keyed            the device can read the TLF
+  missing          the device still needs to be rekeyed by another
+                   device of one of the TLF's writers
+  revoked, keyed   the device has been revoked, but the TLF has not
+                   been rekeyed to remove it yet
+
+With -status, only prints the key status, without rekeying.
+
+`
+
+type rekeyDeviceStatus struct {
+	Device string `json:"device"`
+	Status string `json:"status"`
+}
+
+type rekeyUserStatus struct {
+	User    string              `json:"user"`
+	Devices []rekeyDeviceStatus `json:"devices"`
+}
+
+// rekeyTLFStatus is the outcome of rekeying a single TLF.
+type rekeyTLFStatus struct {
+	TLF string `json:"tlf"`
+	// Note explains why there's no key status, if there isn't
+	// any.
+	Note     string            `json:"note,omitempty"`
+	Result   string            `json:"result,omitempty"`
+	Revision kbfsmd.Revision   `json:"revision,omitempty"`
+	KeyGen   kbfsmd.KeyGen     `json:"keyGen,omitempty"`
+	Users    []rekeyUserStatus `json:"users,omitempty"`
+}
+
+func (s rekeyTLFStatus) print() {
+	fmt.Printf("%s:\n", s.TLF)
+	if s.Result != "" {
+		fmt.Printf("  %s\n", s.Result)
+	}
+	if s.Note != "" {
+		fmt.Printf("  (%s)\n", s.Note)
+		return
+	}
+	fmt.Printf("  Revision %s, key generation %d\n", s.Revision, s.KeyGen)
+	for _, u := range s.Users {
+		fmt.Printf("  %s:\n", u.User)
+		for _, d := range u.Devices {
+			fmt.Printf("    %s\t%s\n", d.Device, d.Status)
+		}
+	}
+}
+
+func rekeyGetDeviceStatus(ctx context.Context, config libkbfs.Config,
+	handle *libkbfs.TlfHandle, tlfID tlf.ID, status *rekeyTLFStatus) error {
+	irmd, err := config.MDOps().GetForTLF(ctx, tlfID, nil)
+	if err != nil {
+		return err
+	}
+	if irmd == (libkbfs.ImmutableRootMetadata{}) {
+		status.Note = "no metadata yet"
+		return nil
+	}
+
+	writers, readers, err :=
+		irmd.GetBareRootMetadata().GetUserDevicePublicKeys(irmd.Extra())
+	if err != nil {
+		return err
+	}
+	status.Revision = irmd.Revision()
+	status.KeyGen = irmd.LatestKeyGeneration()
+
+	users := append(handle.ResolvedWriters(), handle.ResolvedReaders()...)
+	for _, id := range users {
+		uid := id.AsUserOrBust()
+		ui, err := config.KeybaseService().LoadUserPlusKeys(ctx, uid, "")
+		if err != nil {
+			return err
+		}
+
+		keyed := writers[uid]
+		if keyed == nil {
+			keyed = readers[uid]
+		}
+
+		deviceName := func(kid keybase1.KID) string {
+			if name, ok := ui.KIDNames[kid]; ok {
+				return name
+			}
+			return kid.String()
+		}
+		devices := []rekeyDeviceStatus{}
+		for _, key := range ui.CryptPublicKeys {
+			s := "missing"
+			if keyed[key] {
+				s = "keyed"
+			}
+			devices = append(devices,
+				rekeyDeviceStatus{deviceName(key.KID()), s})
+		}
+		for key := range ui.RevokedCryptPublicKeys {
+			if keyed[key] {
+				devices = append(devices,
+					rekeyDeviceStatus{deviceName(key.KID()), "revoked, keyed"})
+			}
+		}
+		sort.Slice(devices, func(i, j int) bool {
+			return devices[i].Device < devices[j].Device
+		})
+
+		status.Users = append(status.Users,
+			rekeyUserStatus{User: string(ui.Name), Devices: devices})
+	}
+	return nil
+}
+
+func rekeyOne(ctx context.Context, config libkbfs.Config, tlfStr string,
+	statusOnly bool) (rekeyTLFStatus, error) {
+	handle, err := parseTLFPath(ctx, config.KBPKI(), config.MDOps(), tlfStr)
+	if err != nil {
+		return rekeyTLFStatus{}, err
+	}
+
+	status := rekeyTLFStatus{TLF: string(handle.GetCanonicalPath())}
+	if handle.Type() != tlf.Private ||
+		handle.TypeForKeying() == tlf.TeamKeying {
+		status.Note = "keys are not managed by KBFS"
+		return status, nil
+	}
+
+	tlfID, err := config.MDOps().GetIDForHandle(ctx, handle)
+	if err != nil {
+		return rekeyTLFStatus{}, err
+	}
+	if tlfID == tlf.NullID {
+		status.Note = "no metadata yet"
+		return status, nil
+	}
+
+	if !statusOnly {
+		res, err := libkbfs.RequestRekeyAndWaitForOneFinishEvent(
+			ctx, config.KBFSOps(), tlfID)
+		if err != nil {
+			return rekeyTLFStatus{}, err
+		}
+		switch {
+		case res.NeedsPaperKey:
+			status.Result = "Rekey needs a paper key or another device"
+		case res.DidRekey:
+			status.Result = "Rekeyed"
+		default:
+			status.Result = "No rekey needed"
+		}
+	}
+
+	err = rekeyGetDeviceStatus(ctx, config, handle, tlfID, &status)
+	if err != nil {
+		return rekeyTLFStatus{}, err
+	}
+	return status, nil
+}
+
+func rekey(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs rekey", flag.ContinueOnError)
+	statusOnly := flags.Bool("status", false,
+		"Only print the key status of each device.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("rekey", err)
+		return 1
+	}
+
+	tlfStrs := flags.Args()
+	if len(tlfStrs) == 0 {
+		favs, err := config.KBFSOps().GetFavorites(ctx)
+		if err != nil {
+			printError("rekey", err)
+			return 1
+		}
+		for _, fav := range favs {
+			if fav.Type == tlf.Private {
+				tlfStrs = append(tlfStrs, fmt.Sprintf(
+					"/%s/%s/%s", topName, privateName, fav.Name))
+			}
+		}
+		if len(tlfStrs) == 0 {
+			fmt.Print(rekeyUsageStr)
+			return 1
+		}
+	}
+
+	statuses := []rekeyTLFStatus{}
+	for _, tlfStr := range tlfStrs {
+		status, err := rekeyOne(ctx, config, tlfStr, *statusOnly)
+		if err != nil {
+			printError("rekey", fmt.Errorf("%s: %v", tlfStr, err))
+			exitStatus = 1
+			continue
+		}
+		if *jsonOutput {
+			statuses = append(statuses, status)
+		} else {
+			status.print()
+		}
+	}
+
+	if *jsonOutput {
+		err := printJSON(statuses)
+		if err != nil {
+			printError("rekey", err)
+			return 1
+		}
+	}
+	return exitStatus
+}
@@ -0,0 +1,363 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const restoreUsageStr = `Usage:
+  kbfstool restore [-rev N | -time T] [-v] /keybase/path
+
+Restores a file or directory to the state it had as of the given TLF
+revision, or as of the latest revision made no later than the given
+time (in RFC 3339 format, e.g. 2018-01-02T15:04:05Z).
+
+Restoring a directory restores each file and subdirectory it had at
+that revision, overwriting the current versions; entries created
+since then are left alone.
+
+This only works as long as the old blocks haven't been reclaimed by
+the server.
+
+`
+
+// restoreGetIRMD returns the merged MD for the given revision.
+func restoreGetIRMD(ctx context.Context, config libkbfs.Config,
+	tlfID tlf.ID, rev kbfsmd.Revision) (
+	libkbfs.ImmutableRootMetadata, error) {
+	irmds, err := mdGet(ctx, config, tlfID, kbfsmd.NullBranchID, rev, rev)
+	if err != nil {
+		return libkbfs.ImmutableRootMetadata{}, err
+	}
+	if len(irmds) != 1 {
+		return libkbfs.ImmutableRootMetadata{},
+			fmt.Errorf("no metadata found for revision %s", rev)
+	}
+	return irmds[0], nil
+}
+
+// restoreRevisionForTime returns the latest merged revision of the
+// TLF made no later than `t`.
+func restoreRevisionForTime(ctx context.Context, config libkbfs.Config,
+	tlfID tlf.ID, t time.Time) (kbfsmd.Revision, error) {
+	head, err := config.MDOps().GetForTLF(ctx, tlfID, nil)
+	if err != nil {
+		return kbfsmd.RevisionUninitialized, err
+	}
+	if head == (libkbfs.ImmutableRootMetadata{}) {
+		return kbfsmd.RevisionUninitialized,
+			fmt.Errorf("TLF %s has no history", tlfID)
+	}
+
+	// Binary search for the last revision not after `t`.
+	lo, hi := kbfsmd.RevisionInitial, head.Revision()
+	found := kbfsmd.RevisionUninitialized
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		irmd, err := restoreGetIRMD(ctx, config, tlfID, mid)
+		if err != nil {
+			return kbfsmd.RevisionUninitialized, err
+		}
+		if irmd.LocalTimestamp().After(t) {
+			hi = mid - 1
+		} else {
+			found = mid
+			lo = mid + 1
+		}
+	}
+
+	if found == kbfsmd.RevisionUninitialized {
+		return kbfsmd.RevisionUninitialized,
+			fmt.Errorf("TLF %s didn't exist yet at %s", tlfID, t)
+	}
+	return found, nil
+}
+
+// restoreGetChildren returns all the entries of the directory rooted
+// at the given block, as of the revision described by `kmd`.
+func restoreGetChildren(ctx context.Context, config libkbfs.Config,
+	kmd libkbfs.KeyMetadata, info libkbfs.BlockInfo) (
+	map[string]libkbfs.DirEntry, error) {
+	var dirBlock libkbfs.DirBlock
+	err := config.BlockOps().Get(
+		ctx, kmd, info.BlockPointer, &dirBlock, libkbfs.NoCacheEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dirBlock.IsInd {
+		return dirBlock.Children, nil
+	}
+
+	children := make(map[string]libkbfs.DirEntry)
+	for _, iptr := range dirBlock.IPtrs {
+		iptrChildren, err := restoreGetChildren(
+			ctx, config, kmd, iptr.BlockInfo)
+		if err != nil {
+			return nil, err
+		}
+		for name, de := range iptrChildren {
+			children[name] = de
+		}
+	}
+	return children, nil
+}
+
+// restoreWriteBlocks writes the contents of the old file block
+// rooted at `info`, which starts at offset `off`, into `node`.
+func restoreWriteBlocks(ctx context.Context, config libkbfs.Config,
+	kmd libkbfs.KeyMetadata, info libkbfs.BlockInfo, off int64,
+	node libkbfs.Node) error {
+	var fileBlock libkbfs.FileBlock
+	err := config.BlockOps().Get(
+		ctx, kmd, info.BlockPointer, &fileBlock, libkbfs.NoCacheEntry)
+	if err != nil {
+		return err
+	}
+
+	if !fileBlock.IsInd {
+		return config.KBFSOps().Write(ctx, node, fileBlock.Contents, off)
+	}
+
+	for _, iptr := range fileBlock.IPtrs {
+		err := restoreWriteBlocks(
+			ctx, config, kmd, iptr.BlockInfo, iptr.Off, node)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restorer restores entries from an old revision of a TLF into its
+// current version.
+type restorer struct {
+	config  libkbfs.Config
+	kmd     libkbfs.KeyMetadata
+	verbose bool
+}
+
+func (r restorer) restoreFile(ctx context.Context, parentNode libkbfs.Node,
+	p fsrpc.Path, name string, de libkbfs.DirEntry) error {
+	if r.verbose {
+		fmt.Fprintf(os.Stderr, "Restoring %s\n", p)
+	}
+
+	kbfsOps := r.config.KBFSOps()
+	isExec := de.Type == libkbfs.Exec
+	node, ei, err := kbfsOps.Lookup(ctx, parentNode, name)
+	switch {
+	case err == (libkbfs.NoSuchNameError{Name: name}):
+		node, _, err = kbfsOps.CreateFile(
+			ctx, parentNode, name, isExec, libkbfs.NoExcl)
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case ei.Type != libkbfs.File && ei.Type != libkbfs.Exec:
+		return fmt.Errorf("%s is now a %s; not overwriting it", p, ei.Type)
+	default:
+		err = kbfsOps.Truncate(ctx, node, 0)
+		if err != nil {
+			return err
+		}
+		if (ei.Type == libkbfs.Exec) != isExec {
+			err = kbfsOps.SetEx(ctx, node, isExec)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = restoreWriteBlocks(ctx, r.config, r.kmd, de.BlockInfo, 0, node)
+	if err != nil {
+		return err
+	}
+	// Take care of any trailing hole.
+	return kbfsOps.Truncate(ctx, node, de.Size)
+}
+
+func (r restorer) restoreDir(ctx context.Context, node libkbfs.Node,
+	p fsrpc.Path, de libkbfs.DirEntry) error {
+	children, err := restoreGetChildren(ctx, r.config, r.kmd, de.BlockInfo)
+	if err != nil {
+		return err
+	}
+
+	for name, childDE := range children {
+		childPath, err := p.Join(name)
+		if err != nil {
+			return err
+		}
+		err = r.restoreEntry(ctx, node, childPath, name, childDE)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r restorer) restoreEntry(ctx context.Context, parentNode libkbfs.Node,
+	p fsrpc.Path, name string, de libkbfs.DirEntry) error {
+	kbfsOps := r.config.KBFSOps()
+	switch de.Type {
+	case libkbfs.File, libkbfs.Exec:
+		return r.restoreFile(ctx, parentNode, p, name, de)
+	case libkbfs.Sym:
+		if r.verbose {
+			fmt.Fprintf(os.Stderr, "Restoring %s\n", p)
+		}
+		_, err := kbfsOps.CreateLink(ctx, parentNode, name, de.SymPath)
+		if err == (libkbfs.NameExistsError{Name: name}) {
+			return nil
+		}
+		return err
+	case libkbfs.Dir:
+		node, _, err := kbfsOps.CreateDir(ctx, parentNode, name)
+		if err == (libkbfs.NameExistsError{Name: name}) {
+			node, _, err = kbfsOps.Lookup(ctx, parentNode, name)
+		}
+		if err != nil {
+			return err
+		}
+		return r.restoreDir(ctx, node, p, de)
+	default:
+		return fmt.Errorf("%s has unknown type %s", p, de.Type)
+	}
+}
+
+func restoreHelper(ctx context.Context, config libkbfs.Config,
+	args []string) error {
+	flags := flag.NewFlagSet("kbfs restore", flag.ContinueOnError)
+	rev := flags.Int64("rev", 0, "The TLF revision to restore from.")
+	timeStr := flags.String("time", "",
+		"Restore from the latest revision no later than this time.")
+	verbose := flags.Bool("v", false, "Print extra status output.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 || (*rev == 0) == (*timeStr == "") {
+		fmt.Print(restoreUsageStr)
+		return fmt.Errorf(
+			"exactly one path and one of -rev or -time must be specified")
+	}
+
+	p, err := fsrpc.NewPath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("%s is not a path within a TLF", p)
+	}
+
+	tlfRoot := fsrpc.Path{
+		PathType: fsrpc.TLFPathType,
+		TLFType:  p.TLFType,
+		TLFName:  p.TLFName,
+	}
+	tlfID, err := getTlfID(ctx, config, tlfRoot.String())
+	if err != nil {
+		return err
+	}
+
+	revision := kbfsmd.Revision(*rev)
+	if *timeStr != "" {
+		t, err := time.Parse(time.RFC3339, *timeStr)
+		if err != nil {
+			return err
+		}
+		revision, err = restoreRevisionForTime(ctx, config, tlfID, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	irmd, err := restoreGetIRMD(ctx, config, tlfID, revision)
+	if err != nil {
+		return err
+	}
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "Restoring from revision %s (%s)\n",
+			irmd.Revision(), irmd.LocalTimestamp().Format(time.RFC3339))
+	}
+
+	r := restorer{config: config, kmd: irmd, verbose: *verbose}
+
+	// Find the old entry by walking down from the old root.
+	de := irmd.Data().Dir
+	for i, name := range p.TLFComponents {
+		if de.Type != libkbfs.Dir {
+			return fmt.Errorf("%s was not a directory at revision %s",
+				fsrpc.Path{
+					PathType:      fsrpc.TLFPathType,
+					TLFType:       p.TLFType,
+					TLFName:       p.TLFName,
+					TLFComponents: p.TLFComponents[:i],
+				}, revision)
+		}
+		children, err := restoreGetChildren(ctx, config, irmd, de.BlockInfo)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		de, ok = children[name]
+		if !ok {
+			return fmt.Errorf("%s did not exist at revision %s", p, revision)
+		}
+	}
+
+	if len(p.TLFComponents) == 0 {
+		rootNode, err := p.GetDirNode(ctx, config)
+		if err != nil {
+			return err
+		}
+		err = r.restoreDir(ctx, rootNode, p, de)
+		if err != nil {
+			return err
+		}
+		return config.KBFSOps().SyncAll(ctx, rootNode.GetFolderBranch())
+	}
+
+	dir, name, err := p.DirAndBasename()
+	if err != nil {
+		return err
+	}
+	// Recreate any parents that have been removed since.
+	err = mkdirOne(ctx, config, dir.String(), true, *verbose)
+	if err != nil {
+		return err
+	}
+	parentNode, err := dir.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	err = r.restoreEntry(ctx, parentNode, p, name, de)
+	if err != nil {
+		return err
+	}
+	return config.KBFSOps().SyncAll(ctx, parentNode.GetFolderBranch())
+}
+
+func restore(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := restoreHelper(ctx, config, args)
+	if err != nil {
+		printError("restore", err)
+		return 1
+	}
+	return 0
+}
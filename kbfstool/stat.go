@@ -14,15 +14,15 @@ import (
 	"golang.org/x/net/context"
 )
 
-func statNode(ctx context.Context, config libkbfs.Config, nodePathStr string) error {
+func statNode(ctx context.Context, config libkbfs.Config, nodePathStr string) (fsrpc.Path, libkbfs.EntryInfo, error) {
 	p, err := fsrpc.NewPath(nodePathStr)
 	if err != nil {
-		return err
+		return fsrpc.Path{}, libkbfs.EntryInfo{}, err
 	}
 
 	n, ei, err := p.GetNode(ctx, config)
 	if err != nil {
-		return err
+		return fsrpc.Path{}, libkbfs.EntryInfo{}, err
 	}
 
 	// If n is non-nil, ignore the EntryInfo returned by
@@ -32,10 +32,14 @@ func statNode(ctx context.Context, config libkbfs.Config, nodePathStr string) er
 	if n != nil {
 		ei, err = config.KBFSOps().Stat(ctx, n)
 		if err != nil {
-			return err
+			return fsrpc.Path{}, libkbfs.EntryInfo{}, err
 		}
 	}
 
+	return p, ei, nil
+}
+
+func printStat(ei libkbfs.EntryInfo) {
 	var symPathStr string
 	if ei.Type == libkbfs.Sym {
 		symPathStr = fmt.Sprintf("SymPath: %s, ", ei.SymPath)
@@ -45,8 +49,6 @@ func statNode(ctx context.Context, config libkbfs.Config, nodePathStr string) er
 	ctimeStr := time.Unix(0, ei.Ctime).String()
 
 	fmt.Printf("{Type: %s, Size: %d, %sMtime: %s, Ctime: %s}\n", ei.Type, ei.Size, symPathStr, mtimeStr, ctimeStr)
-
-	return nil
 }
 
 func stat(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
@@ -63,8 +65,22 @@ func stat(ctx context.Context, config libkbfs.Config, args []string) (exitStatus
 		return 1
 	}
 
+	var dirents []fsrpc.Dirent
 	for _, nodePath := range nodePaths {
-		err := statNode(ctx, config, nodePath)
+		p, ei, err := statNode(ctx, config, nodePath)
+		if err != nil {
+			printError("stat", err)
+			return 1
+		}
+		if *jsonOutput {
+			dirents = append(dirents, fsrpc.NewDirent(p, ei))
+		} else {
+			printStat(ei)
+		}
+	}
+
+	if *jsonOutput {
+		err := printJSON(dirents)
 		if err != nil {
 			printError("stat", err)
 			return 1
@@ -0,0 +1,165 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const syncUsageStr = `Usage:
+  kbfstool sync [-m mountpoint] [-t timeout] /keybase/path
+
+Flushes the write journal of the running KBFS mount for the TLF
+containing the given path, and waits until the server has
+acknowledged everything that was in it. This is useful for scripts
+that need to know that files they wrote via the mount are durable.
+
+`
+
+// syncPollInterval is how often the TLF status is re-checked while
+// waiting for the journal to drain.
+const syncPollInterval = 1 * time.Second
+
+// syncFolderStatus holds the parts of the folder status (as served
+// by libfs.StatusFileName) that sync cares about.
+type syncFolderStatus struct {
+	Journal *libkbfs.TLFJournalStatus
+}
+
+func syncJournalIsEmpty(tlfDir string) (bool, error) {
+	data, err := ioutil.ReadFile(
+		filepath.Join(tlfDir, libfs.StatusFileName))
+	if err != nil {
+		return false, err
+	}
+
+	var status syncFolderStatus
+	err = json.Unmarshal(data, &status)
+	if err != nil {
+		return false, err
+	}
+
+	jStatus := status.Journal
+	if jStatus == nil {
+		// No journal, so all writes go straight to the server.
+		return true, nil
+	}
+	if jStatus.LastFlushErr != "" {
+		return false, errors.New(jStatus.LastFlushErr)
+	}
+	return jStatus.RevisionStart == kbfsmd.RevisionUninitialized &&
+		jStatus.UnflushedBytes == 0, nil
+}
+
+func syncFlushJournal(tlfDir string) error {
+	f, err := os.OpenFile(
+		filepath.Join(tlfDir, libfs.FlushJournalFileName), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	// The write blocks until the flush is done.
+	_, err = f.Write([]byte("1"))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncTLF(ctx context.Context, tlfDir string) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- syncFlushJournal(tlfDir)
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// Any writes that came in during the flush will still be in
+	// the journal, so wait for them as well.
+	for {
+		empty, err := syncJournalIsEmpty(tlfDir)
+		if err != nil {
+			return err
+		}
+		if empty {
+			return nil
+		}
+
+		select {
+		case <-time.After(syncPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func syncHelper(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("kbfs sync", flag.ContinueOnError)
+	mountpoint := flags.String("m", "/keybase",
+		"The mountpoint of the running KBFS instance.")
+	timeout := flags.Duration("t", 1*time.Minute,
+		"How long to wait for the server to acknowledge all writes.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Print(syncUsageStr)
+		return errExactlyOnePath
+	}
+
+	p, err := fsrpc.NewPath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("%s is not a path within a TLF", p)
+	}
+
+	listName := privateName
+	if p.TLFType == tlf.Public {
+		listName = publicName
+	}
+	tlfDir := filepath.Join(*mountpoint, listName, p.TLFName)
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	err = syncTLF(ctx, tlfDir)
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf(
+			"timed out after %s waiting for %s to sync", *timeout, p)
+	}
+	return err
+}
+
+func syncCmd(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := syncHelper(ctx, args)
+	if err != nil {
+		printError("sync", err)
+		return 1
+	}
+	return 0
+}
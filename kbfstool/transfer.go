@@ -0,0 +1,173 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// transferJob describes a single file to be copied by get or put.
+type transferJob struct {
+	src    string
+	dst    string
+	size   int64
+	isExec bool
+	mtime  time.Time
+}
+
+// transferProgress tracks how far along a get or put is, and
+// optionally draws a progress bar on stderr.
+type transferProgress struct {
+	// Accessed atomically.
+	doneBytes int64
+	doneFiles int64
+
+	totalBytes int64
+	totalFiles int64
+	show       bool
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+const transferProgressInterval = 200 * time.Millisecond
+
+const transferProgressBarWidth = 30
+
+func newTransferProgress(jobs []transferJob, show bool) *transferProgress {
+	tp := &transferProgress{
+		totalFiles: int64(len(jobs)),
+		show:       show,
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	for _, job := range jobs {
+		tp.totalBytes += job.size
+	}
+	go tp.loop()
+	return tp
+}
+
+func (tp *transferProgress) addBytes(n int64) {
+	atomic.AddInt64(&tp.doneBytes, n)
+}
+
+func (tp *transferProgress) fileDone() {
+	atomic.AddInt64(&tp.doneFiles, 1)
+}
+
+func (tp *transferProgress) draw() {
+	doneBytes := atomic.LoadInt64(&tp.doneBytes)
+	doneFiles := atomic.LoadInt64(&tp.doneFiles)
+	fraction := 1.0
+	if tp.totalBytes > 0 {
+		fraction = float64(doneBytes) / float64(tp.totalBytes)
+	}
+	filled := int(fraction * transferProgressBarWidth)
+	if filled > transferProgressBarWidth {
+		filled = transferProgressBarWidth
+	}
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3d%% %d/%d bytes, %d/%d files",
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", transferProgressBarWidth-filled),
+		int(fraction*100), doneBytes, tp.totalBytes,
+		doneFiles, tp.totalFiles)
+}
+
+func (tp *transferProgress) loop() {
+	defer close(tp.doneCh)
+	if !tp.show {
+		<-tp.stopCh
+		return
+	}
+
+	ticker := time.NewTicker(transferProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			tp.draw()
+		case <-tp.stopCh:
+			tp.draw()
+			fmt.Fprint(os.Stderr, "\n")
+			return
+		}
+	}
+}
+
+func (tp *transferProgress) stop() {
+	close(tp.stopCh)
+	<-tp.doneCh
+}
+
+// progressWriter counts the bytes written through it towards a
+// transferProgress.
+type progressWriter struct {
+	w  io.Writer
+	tp *transferProgress
+}
+
+var _ io.Writer = progressWriter{}
+
+func (pw progressWriter) Write(p []byte) (n int, err error) {
+	n, err = pw.w.Write(p)
+	pw.tp.addBytes(int64(n))
+	return n, err
+}
+
+// runTransferJobs runs `fn` on each job, using at most `parallelism`
+// goroutines at once.  Errors are passed to `errorFn` (one at a
+// time), and don't stop the other jobs.
+func runTransferJobs(ctx context.Context, jobs []transferJob,
+	parallelism int, tp *transferProgress,
+	fn func(context.Context, transferJob) error, errorFn func(error)) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var errLock sync.Mutex
+	handleErr := func(err error) {
+		errLock.Lock()
+		defer errLock.Unlock()
+		errorFn(err)
+	}
+
+	jobCh := make(chan transferJob)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				err := fn(ctx, job)
+				if err != nil {
+					handleErr(err)
+					continue
+				}
+				tp.fileDone()
+			}
+		}()
+	}
+
+loop:
+	for _, job := range jobs {
+		select {
+		case jobCh <- job:
+		case <-ctx.Done():
+			handleErr(ctx.Err())
+			break loop
+		}
+	}
+	close(jobCh)
+	wg.Wait()
+}
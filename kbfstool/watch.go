@@ -0,0 +1,371 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const watchUsageStr = `Usage:
+  kbfstool watch [-r] [-n count] /keybase/path
+
+Watches the given directory for changes, and prints a JSON object
+for each change on its own line, until interrupted (or until count
+events have been printed, if count is positive).
+
+Each event has a "time", a "path", and an "event" type, which is one
+of "create", "change", "delete", "write", or "tlf-rename". Write
+events also include the "off" and "len" of the written range; a
+zero "len" indicates a truncate.
+
+`
+
+// watchEvent is a single change printed by the watch command.
+type watchEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	Path  string    `json:"path"`
+	Type  string    `json:"type,omitempty"`
+	Off   uint64    `json:"off,omitempty"`
+	Len   uint64    `json:"len,omitempty"`
+}
+
+// watchChange is a copy of the parts of a libkbfs.NodeChange that
+// can be kept around after the notification callback returns.
+type watchChange struct {
+	id          libkbfs.NodeID
+	dirUpdated  []string
+	fileUpdated []libkbfs.WriteRange
+}
+
+// watchObserver queues up changes reported by KBFS, so they can be
+// processed without blocking the notification callbacks.
+type watchObserver struct {
+	notifyCh chan struct{}
+
+	lock       sync.Mutex
+	changes    []watchChange
+	newHandles []*libkbfs.TlfHandle
+}
+
+var _ libkbfs.Observer = (*watchObserver)(nil)
+
+func (wo *watchObserver) notify() {
+	select {
+	case wo.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// LocalChange implements the libkbfs.Observer interface for
+// watchObserver.
+func (wo *watchObserver) LocalChange(
+	ctx context.Context, node libkbfs.Node, write libkbfs.WriteRange) {
+	// Unsynced local changes aren't reported.
+}
+
+// BatchChanges implements the libkbfs.Observer interface for
+// watchObserver.
+func (wo *watchObserver) BatchChanges(
+	ctx context.Context, changes []libkbfs.NodeChange,
+	_ []libkbfs.NodeID) {
+	wo.lock.Lock()
+	defer wo.lock.Unlock()
+	for _, change := range changes {
+		wo.changes = append(wo.changes, watchChange{
+			id:          change.Node.GetID(),
+			dirUpdated:  change.DirUpdated,
+			fileUpdated: change.FileUpdated,
+		})
+	}
+	wo.notify()
+}
+
+// TlfHandleChange implements the libkbfs.Observer interface for
+// watchObserver.
+func (wo *watchObserver) TlfHandleChange(
+	ctx context.Context, newHandle *libkbfs.TlfHandle) {
+	wo.lock.Lock()
+	defer wo.lock.Unlock()
+	wo.newHandles = append(wo.newHandles, newHandle)
+	wo.notify()
+}
+
+func (wo *watchObserver) takeQueued() (
+	changes []watchChange, newHandles []*libkbfs.TlfHandle) {
+	wo.lock.Lock()
+	defer wo.lock.Unlock()
+	changes, newHandles = wo.changes, wo.newHandles
+	wo.changes, wo.newHandles = nil, nil
+	return changes, newHandles
+}
+
+// watchedNode is a node being watched.  Holding on to `node` keeps
+// it (and its ID) alive in the node cache.
+type watchedNode struct {
+	node     libkbfs.Node
+	p        fsrpc.Path
+	children map[string]libkbfs.NodeID // only set for directories
+}
+
+// watcher tracks the set of watched nodes and turns the changes
+// reported for them into events.
+type watcher struct {
+	config    libkbfs.Config
+	recursive bool
+	emit      func(watchEvent)
+
+	nodes map[libkbfs.NodeID]*watchedNode
+}
+
+// addDir starts watching the given directory and its children
+// (recursively, if requested), without emitting any events.
+func (w *watcher) addDir(ctx context.Context, n libkbfs.Node,
+	p fsrpc.Path) error {
+	wn := &watchedNode{
+		node:     n,
+		p:        p,
+		children: make(map[string]libkbfs.NodeID),
+	}
+	w.nodes[n.GetID()] = wn
+
+	children, err := w.config.KBFSOps().GetDirChildren(ctx, n)
+	if err != nil {
+		return err
+	}
+	for name := range children {
+		_, _, err := w.addChild(ctx, wn, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addChild looks up the given child of a watched directory and
+// starts watching it.  It returns false if the child doesn't exist.
+func (w *watcher) addChild(ctx context.Context, parent *watchedNode,
+	name string) (libkbfs.EntryInfo, bool, error) {
+	n, ei, err := w.config.KBFSOps().Lookup(ctx, parent.node, name)
+	if _, ok := err.(libkbfs.NoSuchNameError); ok {
+		return libkbfs.EntryInfo{}, false, nil
+	} else if err != nil {
+		return libkbfs.EntryInfo{}, false, err
+	}
+
+	childPath, err := parent.p.Join(name)
+	if err != nil {
+		return libkbfs.EntryInfo{}, false, err
+	}
+
+	if n == nil {
+		// Symlinks don't have nodes.
+		parent.children[name] = nil
+		return ei, true, nil
+	}
+
+	parent.children[name] = n.GetID()
+	if ei.Type == libkbfs.Dir && w.recursive {
+		err = w.addDir(ctx, n, childPath)
+		if err != nil {
+			return libkbfs.EntryInfo{}, false, err
+		}
+	} else {
+		w.nodes[n.GetID()] = &watchedNode{node: n, p: childPath}
+	}
+	return ei, true, nil
+}
+
+func (w *watcher) remove(id libkbfs.NodeID) {
+	if id == nil {
+		return
+	}
+	wn, ok := w.nodes[id]
+	if !ok {
+		return
+	}
+	delete(w.nodes, id)
+	for _, childID := range wn.children {
+		w.remove(childID)
+	}
+}
+
+func (w *watcher) processChange(
+	ctx context.Context, change watchChange) error {
+	wn, ok := w.nodes[change.id]
+	if !ok {
+		return nil
+	}
+
+	now := w.config.Clock().Now()
+	for _, wr := range change.fileUpdated {
+		w.emit(watchEvent{
+			Time:  now,
+			Event: "write",
+			Path:  wn.p.String(),
+			Off:   wr.Off,
+			Len:   wr.Len,
+		})
+	}
+
+	if wn.children == nil {
+		return nil
+	}
+
+	for _, name := range change.dirUpdated {
+		childPath, err := wn.p.Join(name)
+		if err != nil {
+			return err
+		}
+
+		oldID, existed := wn.children[name]
+		w.remove(oldID)
+		delete(wn.children, name)
+
+		ei, exists, err := w.addChild(ctx, wn, name)
+		if err != nil {
+			return err
+		}
+
+		event := watchEvent{Time: now, Path: childPath.String()}
+		switch {
+		case exists && existed:
+			event.Event = "change"
+		case exists:
+			event.Event = "create"
+		case existed:
+			event.Event = "delete"
+		default:
+			// Created and removed again before we noticed.
+			continue
+		}
+		if exists {
+			event.Type = ei.Type.String()
+		}
+		w.emit(event)
+	}
+	return nil
+}
+
+func watchHelper(ctx context.Context, config libkbfs.Config,
+	args []string) error {
+	flags := flag.NewFlagSet("kbfs watch", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "Also watch all subdirectories.")
+	count := flags.Int("n", 0,
+		"Exit after this many events (0 for no limit).")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Print(watchUsageStr)
+		return errExactlyOnePath
+	}
+
+	p, err := fsrpc.NewPath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	n, err := p.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return fmt.Errorf("%s is not a directory within a TLF", p)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	var emitted int
+	var encodeErr error
+	w := &watcher{
+		config:    config,
+		recursive: *recursive,
+		emit: func(event watchEvent) {
+			if encodeErr != nil || (*count > 0 && emitted >= *count) {
+				return
+			}
+			encodeErr = enc.Encode(event)
+			emitted++
+			if *count > 0 && emitted >= *count {
+				cancel()
+			}
+		},
+		nodes: make(map[libkbfs.NodeID]*watchedNode),
+	}
+
+	wo := &watchObserver{notifyCh: make(chan struct{}, 1)}
+	fbs := []libkbfs.FolderBranch{n.GetFolderBranch()}
+	err = config.Notifier().RegisterForChanges(fbs, wo)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = config.Notifier().UnregisterFromChanges(fbs, wo)
+	}()
+
+	err = w.addDir(ctx, n, p)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-wo.notifyCh:
+		case <-ctx.Done():
+			return encodeErr
+		}
+
+		changes, newHandles := wo.takeQueued()
+		for _, h := range newHandles {
+			w.emit(watchEvent{
+				Time:  config.Clock().Now(),
+				Event: "tlf-rename",
+				Path:  h.GetCanonicalPath(),
+			})
+		}
+		for _, change := range changes {
+			err := w.processChange(ctx, change)
+			if err != nil {
+				return err
+			}
+		}
+		if encodeErr != nil {
+			return encodeErr
+		}
+	}
+}
+
+func watch(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := watchHelper(ctx, config, args)
+	if err != nil {
+		printError("watch", err)
+		return 1
+	}
+	return 0
+}
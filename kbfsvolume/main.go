@@ -0,0 +1,106 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Volume provider for the Keybase file system, to back container
+// volume plugins.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/libvolume"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var mountPoint = flag.String("mount", "/var/lib/kbfsvolume/kbfs",
+	"where to mount KBFS privately, for volumes to be bound from")
+var socketPath = flag.String("socket", "/run/kbfsvolume/kbfsvolume.sock",
+	"the unix socket to serve the control API on")
+var statePath = flag.String("state", "",
+	"the file to keep volumes in (default: kbfs_volumes.json in the storage root)")
+
+const usageFormatStr = `Usage:
+  kbfsvolume -version
+
+To serve volumes from remote KBFS servers:
+  kbfsvolume
+    [-mount=path/to/dir] [-socket=path/to/socket] [-state=path/to/file]
+%s
+
+To serve volumes in a local testing environment:
+  kbfsvolume
+    [-mount=path/to/dir] [-socket=path/to/socket] [-state=path/to/file]
+%s
+
+Volumes are subpaths of folders, like /keybase/team/acme/data, that
+are bind mounted into place on request, so kbfsvolume needs to be able
+to mount filesystems.  The control API on the socket is:
+
+  GET    /health
+  GET    /volumes
+  POST   /volumes                     {"name": ..., "path": ...}
+  GET    /volumes/<name>
+  DELETE /volumes/<name>
+  POST   /volumes/<name>/mounts       {"id": ..., "target": ...}
+  DELETE /volumes/<name>/mounts/<id>
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	if len(flag.Args()) > 0 {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("extra arguments specified (flags go before the first argument)")
+	}
+
+	options := libvolume.StartOptions{
+		KbfsParams: *kbfsParams,
+		MountPoint: *mountPoint,
+		SocketPath: *socketPath,
+		StatePath:  *statePath,
+	}
+	if options.StatePath == "" {
+		options.StatePath = filepath.Join(
+			kbfsParams.StorageRoot, "kbfs_volumes.json")
+	}
+
+	return libvolume.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfsvolume error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
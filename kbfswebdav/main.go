@@ -0,0 +1,113 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// WebDAV gateway for the Keybase file system.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/libwebdav"
+)
+
+var version = flag.Bool("version", false, "Print version")
+var listenAddr = flag.String("listen", "127.0.0.1:16800",
+	"address to serve WebDAV on")
+var allowLAN = flag.Bool("lan", false,
+	"allow -listen to be a non-loopback address (requires a token)")
+var tokenEnvVar = flag.String("token-env", "KBFS_WEBDAV_TOKEN",
+	"environment variable holding the token clients must present; "+
+		"empty or unset means no token")
+
+const usageFormatStr = `Usage:
+  kbfswebdav -version
+
+To run against remote KBFS servers:
+  kbfswebdav
+    [-listen=host:port] [-lan] [-token-env=VAR]
+%s
+    <type>/<name> [<type>/<name>...]
+
+To run in a local testing environment:
+  kbfswebdav
+    [-listen=host:port] [-lan] [-token-env=VAR]
+%s
+    <type>/<name> [<type>/<name>...]
+
+Each TLF is given as e.g. private/alice,bob, public/alice or team/acme,
+and is served under http://<listen>/<type>/<name>/.
+
+If the token environment variable is set, clients must present its
+value as the password of HTTP basic auth (with any user name), or as a
+bearer token.  The token is read from the environment rather than the
+command line so that it doesn't show up in the process list.
+
+Defaults:
+%s
+`
+
+func getUsageString(ctx libkbfs.Context) string {
+	remoteUsageStr := libkbfs.GetRemoteUsageString()
+	localUsageStr := libkbfs.GetLocalUsageString()
+	defaultUsageStr := libkbfs.GetDefaultsUsageString(ctx)
+	return fmt.Sprintf(
+		usageFormatStr, remoteUsageStr, localUsageStr, defaultUsageStr)
+}
+
+func start() *libfs.Error {
+	ctx := env.NewContext()
+
+	kbfsParams := libkbfs.AddFlags(flag.CommandLine, ctx)
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", libkbfs.VersionString())
+		return nil
+	}
+
+	if len(flag.Args()) < 1 {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError("no TLFs specified")
+	}
+
+	for _, p := range flag.Args() {
+		if _, _, err := libfs.ParseTlfPath(p); err != nil {
+			fmt.Print(getUsageString(ctx))
+			return libfs.InitError(err.Error())
+		}
+	}
+
+	var token string
+	if *tokenEnvVar != "" {
+		token = strings.TrimSpace(os.Getenv(*tokenEnvVar))
+	}
+
+	options := libwebdav.StartOptions{
+		KbfsParams: *kbfsParams,
+		ListenAddr: *listenAddr,
+		AllowLAN:   *allowLAN,
+		Token:      token,
+		TLFs:       flag.Args(),
+	}
+
+	return libwebdav.Start(options, ctx)
+}
+
+func main() {
+	err := start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kbfswebdav error: (%d) %s\n", err.Code, err.Message)
+
+		os.Exit(err.Code)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package lib9p
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the wire format of the 9P2000.L protocol
+// (https://github.com/chaos/diod/blob/master/protocol.md), as spoken
+// by the Linux v9fs client.
+
+const protocolVersion = "9P2000.L"
+
+// maxMsize bounds the size of a message, including its header.
+const maxMsize = 256 * 1024
+
+// ioHeaderLen is the size of the largest header (Twrite's) in front
+// of read or write data, which is subtracted from msize to get the
+// iounit.
+const ioHeaderLen = 4 + 1 + 2 + 4 + 8 + 4
+
+const (
+	noTag = 0xffff
+	noFid = 0xffffffff
+	// noUname is sent as n_uname when the client has no numeric uid.
+	noUname = 0xffffffff
+)
+
+type msgType uint8
+
+// The "T" message types; each reply ("R") type is one greater.
+const (
+	msgRlerror     msgType = 7
+	msgTstatfs     msgType = 8
+	msgTlopen      msgType = 12
+	msgTlcreate    msgType = 14
+	msgTsymlink    msgType = 16
+	msgTmknod      msgType = 18
+	msgTrename     msgType = 20
+	msgTreadlink   msgType = 22
+	msgTgetattr    msgType = 24
+	msgTsetattr    msgType = 26
+	msgTxattrwalk  msgType = 30
+	msgTxattrcreat msgType = 32
+	msgTreaddir    msgType = 40
+	msgTfsync      msgType = 50
+	msgTlock       msgType = 52
+	msgTgetlock    msgType = 54
+	msgTlink       msgType = 70
+	msgTmkdir      msgType = 72
+	msgTrenameat   msgType = 74
+	msgTunlinkat   msgType = 76
+	msgTversion    msgType = 100
+	msgTauth       msgType = 102
+	msgTattach     msgType = 104
+	msgTflush      msgType = 108
+	msgTwalk       msgType = 110
+	msgTread       msgType = 116
+	msgTwrite      msgType = 118
+	msgTclunk      msgType = 120
+	msgTremove     msgType = 122
+)
+
+// errno is a Linux error number, as sent in Rlerror.
+type errno uint32
+
+const (
+	errnoPerm        errno = 1
+	errnoNoEnt       errno = 2
+	errnoIO          errno = 5
+	errnoBadF        errno = 9
+	errnoAcces       errno = 13
+	errnoExist       errno = 17
+	errnoNotDir      errno = 20
+	errnoIsDir       errno = 21
+	errnoInval       errno = 22
+	errnoROFS        errno = 30
+	errnoNameTooLong errno = 36
+	errnoNoSys       errno = 38
+	errnoNotEmpty    errno = 39
+	errnoProto       errno = 71
+	errnoOpNotSupp   errno = 95
+)
+
+// Qid types.
+const (
+	qidTypeDir     = 0x80
+	qidTypeSymlink = 0x02
+	qidTypeFile    = 0x00
+)
+
+// qid identifies a file to the client.
+type qid struct {
+	qidType uint8
+	version uint32
+	path    uint64
+}
+
+// Flags for Tlopen and Tlcreate, which are Linux open(2) flags.
+const (
+	linuxOAccMode = 0x3
+	linuxOCreat   = 0x40
+	linuxOExcl    = 0x80
+	linuxOTrunc   = 0x200
+	linuxOAppend  = 0x400
+)
+
+// Mode bits for Tgetattr.
+const (
+	linuxSIFReg = 0100000
+	linuxSIFDir = 0040000
+	linuxSIFLnk = 0120000
+)
+
+// Dirent types for Treaddir.
+const (
+	linuxDTDir = 4
+	linuxDTReg = 8
+	linuxDTLnk = 10
+)
+
+// getattrBasic is P9_GETATTR_BASIC, the set of fields we fill in for
+// Tgetattr.
+const getattrBasic = 0x7ff
+
+// Bits for Tsetattr.
+const (
+	setattrMode     = 0x1
+	setattrSize     = 0x8
+	setattrAtime    = 0x10
+	setattrMtime    = 0x20
+	setattrAtimeSet = 0x80
+	setattrMtimeSet = 0x100
+)
+
+// atRemoveDir is the Tunlinkat flag for removing directories.
+const atRemoveDir = 0x200
+
+// v9fsMagic is the filesystem type reported by Tstatfs.
+const v9fsMagic = 0x01021997
+
+// readMsg reads a single message from r.
+func readMsg(r io.Reader, msize uint32) (
+	t msgType, tag uint16, body []byte, err error) {
+	var header [7]byte
+	_, err = io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[:4])
+	if size < uint32(len(header)) || size > msize {
+		return 0, 0, nil, errors.Errorf("Bad 9P message size %d", size)
+	}
+	body = make([]byte, size-uint32(len(header)))
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return msgType(header[4]), binary.LittleEndian.Uint16(header[5:]),
+		body, nil
+}
+
+// msgBuilder builds an outgoing message.
+type msgBuilder struct {
+	buf []byte
+}
+
+func newMsgBuilder(t msgType, tag uint16) *msgBuilder {
+	b := &msgBuilder{buf: make([]byte, 4, 64)}
+	return b.uint8(uint8(t)).uint16(tag)
+}
+
+func (b *msgBuilder) uint8(v uint8) *msgBuilder {
+	b.buf = append(b.buf, v)
+	return b
+}
+
+func (b *msgBuilder) uint16(v uint16) *msgBuilder {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], v)
+	b.buf = append(b.buf, buf[:]...)
+	return b
+}
+
+func (b *msgBuilder) uint32(v uint32) *msgBuilder {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	b.buf = append(b.buf, buf[:]...)
+	return b
+}
+
+func (b *msgBuilder) uint64(v uint64) *msgBuilder {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	b.buf = append(b.buf, buf[:]...)
+	return b
+}
+
+func (b *msgBuilder) string(s string) *msgBuilder {
+	b.uint16(uint16(len(s)))
+	b.buf = append(b.buf, s...)
+	return b
+}
+
+func (b *msgBuilder) qid(q qid) *msgBuilder {
+	return b.uint8(q.qidType).uint32(q.version).uint64(q.path)
+}
+
+// bytes returns the finished message, with its size filled in.
+func (b *msgBuilder) bytes() []byte {
+	binary.LittleEndian.PutUint32(b.buf, uint32(len(b.buf)))
+	return b.buf
+}
+
+var errShortMsg = errors.New("Short 9P message")
+
+// msgReader decodes the fields of a 9P message body in order.  After
+// the first short read it sets err and returns zeroes from then on, so
+// callers can check err once, after reading every field.
+type msgReader struct {
+	buf []byte
+	err error
+}
+
+func (r *msgReader) next(n int) []byte {
+	if r.err != nil || len(r.buf) < n {
+		r.err = errShortMsg
+		if n > 8 {
+			return nil
+		}
+		return make([]byte, n)
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *msgReader) uint8() uint8 {
+	return r.next(1)[0]
+}
+
+func (r *msgReader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.next(2))
+}
+
+func (r *msgReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.next(4))
+}
+
+func (r *msgReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.next(8))
+}
+
+func (r *msgReader) string() string {
+	n := r.uint16()
+	return string(r.next(int(n)))
+}
+
+func (r *msgReader) qid() qid {
+	return qid{r.uint8(), r.uint32(), r.uint64()}
+}
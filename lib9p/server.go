@@ -0,0 +1,105 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package lib9p
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// Debug tag ID for a 9P FS.
+const ctxOpID = "9P"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+type tlfToServe struct {
+	tlfType tlf.Type
+	name    string
+}
+
+// Server serves a fixed set of TLFs over 9P2000.L.  Clients pick a
+// TLF with the attach name, e.g. "private/alice"; there's no
+// authentication, so the server should only be reachable by trusted
+// clients.
+type Server struct {
+	config libkbfs.Config
+	log    logger.Logger
+	tlfs   map[string]tlfToServe
+	fs     *libfs.TlfFSCache
+}
+
+// NewServer returns a new Server for the given TLFs, each given as
+// "<type>/<name>".
+func NewServer(config libkbfs.Config, tlfPaths []string) (*Server, error) {
+	if len(tlfPaths) == 0 {
+		return nil, errors.New("No TLFs to serve")
+	}
+	tlfs := make(map[string]tlfToServe, len(tlfPaths))
+	for _, p := range tlfPaths {
+		tlfType, name, err := libfs.ParseTlfPath(p)
+		if err != nil {
+			return nil, err
+		}
+		tlfs[libfs.TlfTypePathString(tlfType)+"/"+name] =
+			tlfToServe{tlfType, name}
+	}
+	return &Server{
+		config: config,
+		log:    config.MakeLogger("9P"),
+		tlfs:   tlfs,
+		fs:     libfs.NewTlfFSCache(config),
+	}, nil
+}
+
+// attach returns the (possibly cached) FS for the TLF named by
+// `aname`.
+func (s *Server) attach(aname string) (fileSystem, error) {
+	tlfType, name, err := libfs.ParseTlfPath(aname)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	key := libfs.TlfTypePathString(tlfType) + "/" + name
+	toServe, ok := s.tlfs[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	ctx := libkbfs.CtxWithRandomIDReplayable(
+		context.Background(), ctxIDKey, ctxOpID, s.log)
+	return s.fs.Get(ctx, toServe.tlfType, toServe.name)
+}
+
+// Serve runs a 9P session for each connection accepted on `l`, until
+// `l` is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	s.log.Debug("9P connection from %s", conn.RemoteAddr())
+	err := newSession(s.attach, conn).serve()
+	if err != nil {
+		s.log.Debug("9P connection from %s failed: %+v",
+			conn.RemoteAddr(), err)
+	}
+}
@@ -0,0 +1,950 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package lib9p
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// fileSystem is the subset of billy.Filesystem that the server needs.
+// Tsetattr changes modes and times through billy.Change, so if a
+// fileSystem doesn't implement it, only size changes take effect.
+type fileSystem interface {
+	billy.Basic
+	billy.Dir
+	billy.Symlink
+}
+
+// attachFunc returns the filesystem for the given attach name.
+type attachFunc func(aname string) (fileSystem, error)
+
+const maxNameLen = 255
+
+// fid is the server state behind a client's fid.
+type fid struct {
+	fs    fileSystem
+	aname string
+	// uid is the numeric user the client attached as, which is
+	// reported as the owner of every file.
+	uid  uint32
+	path string
+
+	opened bool
+	file   billy.File
+	// dir holds the sorted entries of an opened directory, as of the
+	// last read from offset 0.
+	dir     []os.FileInfo
+	written bool
+}
+
+type fileInfosByName []os.FileInfo
+
+func (f fileInfosByName) Len() int {
+	return len(f)
+}
+
+func (f fileInfosByName) Less(i, j int) bool {
+	return f[i].Name() < f[j].Name()
+}
+
+func (f fileInfosByName) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+// session serves 9P2000.L on a single connection.  Messages are
+// handled one at a time, so there's never anything for Tflush to
+// cancel.
+type session struct {
+	attach attachFunc
+	rw     io.ReadWriter
+	msize  uint32
+	fids   map[uint32]*fid
+}
+
+func newSession(attach attachFunc, rw io.ReadWriter) *session {
+	return &session{
+		attach: attach,
+		rw:     rw,
+		msize:  maxMsize,
+		fids:   make(map[uint32]*fid),
+	}
+}
+
+func errToErrno(err error) errno {
+	cause := errors.Cause(err)
+	switch {
+	case os.IsNotExist(cause):
+		return errnoNoEnt
+	case os.IsPermission(cause):
+		return errnoAcces
+	case os.IsExist(cause):
+		return errnoExist
+	default:
+		return errnoIO
+	}
+}
+
+func checkName(name string) errno {
+	switch {
+	case len(name) > maxNameLen:
+		return errnoNameTooLong
+	case name == "" || name == "." || name == ".." ||
+		strings.Contains(name, "/"):
+		return errnoInval
+	}
+	return 0
+}
+
+func (f *fid) sync() error {
+	if s, ok := f.fs.(libfs.Syncer); ok {
+		return s.SyncAll()
+	}
+	return nil
+}
+
+// qid returns the qid for `p`.  Its path is a hash of the file's
+// location, so a renamed file looks like a new file to the client.
+func (f *fid) qid(p string, fi os.FileInfo) qid {
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, f.aname+"\x00"+p)
+	q := qid{qidType: qidTypeFile, path: h.Sum64()}
+	switch {
+	case fi.IsDir():
+		q.qidType = qidTypeDir
+	case fi.Mode()&os.ModeSymlink != 0:
+		q.qidType = qidTypeSymlink
+	}
+	return q
+}
+
+func (f *fid) statQid(p string) (qid, errno) {
+	fi, err := f.fs.Lstat(p)
+	if err != nil {
+		return qid{}, errToErrno(err)
+	}
+	return f.qid(p, fi), 0
+}
+
+func (f *fid) close() error {
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+	}
+	if f.written {
+		if syncErr := f.sync(); err == nil {
+			err = syncErr
+		}
+	}
+	f.opened, f.file, f.dir, f.written = false, nil, nil, false
+	return err
+}
+
+func (s *session) closeAll() {
+	for id, f := range s.fids {
+		_ = f.close()
+		delete(s.fids, id)
+	}
+}
+
+func (s *session) serve() error {
+	defer s.closeAll()
+	for {
+		t, tag, body, err := readMsg(s.rw, s.msize)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		reply := s.handle(t, tag, &msgReader{buf: body})
+		_, err = s.rw.Write(reply)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle returns the reply to the given message.
+func (s *session) handle(t msgType, tag uint16, r *msgReader) []byte {
+	b := newMsgBuilder(t+1, tag)
+	var e errno
+	switch t {
+	case msgTversion:
+		e = s.version(r, b)
+	case msgTattach:
+		e = s.attachFid(r, b)
+	case msgTwalk:
+		e = s.walk(r, b)
+	case msgTlopen:
+		e = s.lopen(r, b)
+	case msgTlcreate:
+		e = s.lcreate(r, b)
+	case msgTsymlink:
+		e = s.symlink(r, b)
+	case msgTrename:
+		e = s.rename(r, b)
+	case msgTreadlink:
+		e = s.readlink(r, b)
+	case msgTgetattr:
+		e = s.getattr(r, b)
+	case msgTsetattr:
+		e = s.setattr(r, b)
+	case msgTreaddir:
+		e = s.readdir(r, b)
+	case msgTfsync:
+		e = s.fsync(r, b)
+	case msgTlock:
+		e = s.lock(r, b)
+	case msgTgetlock:
+		e = s.getlock(r, b)
+	case msgTmkdir:
+		e = s.mkdir(r, b)
+	case msgTrenameat:
+		e = s.renameat(r, b)
+	case msgTunlinkat:
+		e = s.unlinkat(r, b)
+	case msgTstatfs:
+		e = s.statfs(r, b)
+	case msgTflush:
+		// Nothing is ever in flight.
+		r.uint16()
+	case msgTread:
+		e = s.read(r, b)
+	case msgTwrite:
+		e = s.write(r, b)
+	case msgTclunk:
+		e = s.clunk(r, b)
+	case msgTremove:
+		e = s.remove(r, b)
+	case msgTauth, msgTxattrwalk, msgTxattrcreat, msgTmknod, msgTlink:
+		// No authentication is needed, and extended attributes,
+		// device nodes and hard links aren't supported.
+		e = errnoOpNotSupp
+	default:
+		e = errnoNoSys
+	}
+	if e == 0 && r.err != nil {
+		e = errnoProto
+	}
+	if e != 0 {
+		return newMsgBuilder(msgRlerror, tag).uint32(uint32(e)).bytes()
+	}
+	return b.bytes()
+}
+
+// getFid returns the fid with the given ID, which must exist.
+func (s *session) getFid(id uint32) (*fid, errno) {
+	f, ok := s.fids[id]
+	if !ok {
+		return nil, errnoBadF
+	}
+	return f, 0
+}
+
+// readFid reads a fid ID from `r`, and returns that fid.
+func (s *session) readFid(r *msgReader) (*fid, errno) {
+	id := r.uint32()
+	if r.err != nil {
+		return nil, errnoProto
+	}
+	return s.getFid(id)
+}
+
+// getDirFid returns the fid with the given ID, which must be an
+// unopened directory, and the path of `name` within it.
+func (s *session) getDirFid(id uint32, name string) (*fid, string, errno) {
+	f, e := s.getFid(id)
+	if e != 0 {
+		return nil, "", e
+	}
+	if f.opened {
+		return nil, "", errnoBadF
+	}
+	if e := checkName(name); e != 0 {
+		return nil, "", e
+	}
+	return f, path.Join(f.path, name), 0
+}
+
+// renamed updates every fid under `oldPath` to follow a rename.
+func (s *session) renamed(fs fileSystem, oldPath, newPath string) {
+	prefix := oldPath + "/"
+	for _, f := range s.fids {
+		if f.fs != fs {
+			continue
+		}
+		if f.path == oldPath {
+			f.path = newPath
+		} else if strings.HasPrefix(f.path, prefix) {
+			f.path = newPath + "/" + strings.TrimPrefix(f.path, prefix)
+		}
+	}
+}
+
+func linuxToOSFlags(flags uint32) int {
+	var osFlags int
+	switch flags & linuxOAccMode {
+	case 0:
+		osFlags = os.O_RDONLY
+	case 1:
+		osFlags = os.O_WRONLY
+	default:
+		osFlags = os.O_RDWR
+	}
+	if flags&linuxOTrunc != 0 {
+		osFlags |= os.O_TRUNC
+	}
+	if flags&linuxOAppend != 0 {
+		osFlags |= os.O_APPEND
+	}
+	return osFlags
+}
+
+func (s *session) iounit() uint32 {
+	return s.msize - ioHeaderLen
+}
+
+func (s *session) version(r *msgReader, b *msgBuilder) errno {
+	msize := r.uint32()
+	version := r.string()
+	if r.err != nil {
+		return errnoProto
+	}
+	if msize < 4096 {
+		return errnoInval
+	}
+	if msize < s.msize {
+		s.msize = msize
+	}
+	s.closeAll()
+	if !strings.HasPrefix(version, protocolVersion) {
+		version = "unknown"
+	} else {
+		version = protocolVersion
+	}
+	b.uint32(s.msize).string(version)
+	return 0
+}
+
+func (s *session) attachFid(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	r.uint32() // afid
+	r.string() // uname
+	aname := r.string()
+	uid := r.uint32()
+	if r.err != nil {
+		return errnoProto
+	}
+	if _, ok := s.fids[id]; ok {
+		return errnoBadF
+	}
+	if uid == noUname {
+		uid = 0
+	}
+	fs, err := s.attach(aname)
+	if err != nil {
+		return errToErrno(err)
+	}
+	f := &fid{fs: fs, aname: aname, uid: uid, path: "/"}
+	q, e := f.statQid(f.path)
+	if e != 0 {
+		return e
+	}
+	s.fids[id] = f
+	b.qid(q)
+	return 0
+}
+
+func (s *session) walk(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	newID := r.uint32()
+	n := r.uint16()
+	names := make([]string, 0, n)
+	for i := uint16(0); i < n && r.err == nil; i++ {
+		names = append(names, r.string())
+	}
+	if r.err != nil {
+		return errnoProto
+	}
+	f, e := s.getFid(id)
+	if e != 0 {
+		return e
+	}
+	if f.opened {
+		return errnoBadF
+	}
+	if _, ok := s.fids[newID]; ok && newID != id {
+		return errnoBadF
+	}
+
+	p := f.path
+	var qids []qid
+	for i, name := range names {
+		next := path.Dir(p)
+		if name != ".." {
+			if e := checkName(name); e != 0 {
+				return e
+			}
+			next = path.Join(p, name)
+		}
+		q, e := f.statQid(next)
+		if e != 0 {
+			if i == 0 {
+				return e
+			}
+			break
+		}
+		qids = append(qids, q)
+		p = next
+	}
+	if len(qids) == len(names) {
+		newFid := *f
+		newFid.path = p
+		s.fids[newID] = &newFid
+	}
+	b.uint16(uint16(len(qids)))
+	for _, q := range qids {
+		b.qid(q)
+	}
+	return 0
+}
+
+func (s *session) lopen(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	flags := r.uint32()
+	if e != 0 || r.err != nil {
+		return e
+	}
+	if f.opened {
+		return errnoBadF
+	}
+	fi, err := f.fs.Lstat(f.path)
+	if err != nil {
+		return errToErrno(err)
+	}
+	if !fi.IsDir() {
+		file, err := f.fs.OpenFile(f.path, linuxToOSFlags(flags), 0)
+		if err != nil {
+			return errToErrno(err)
+		}
+		f.file = file
+		f.written = flags&linuxOTrunc != 0
+	}
+	f.opened = true
+	b.qid(f.qid(f.path, fi)).uint32(s.iounit())
+	return 0
+}
+
+func (s *session) lcreate(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	name := r.string()
+	flags := r.uint32()
+	mode := r.uint32()
+	r.uint32() // gid
+	if r.err != nil {
+		return errnoProto
+	}
+	f, p, e := s.getDirFid(id, name)
+	if e != 0 {
+		return e
+	}
+	osFlags := linuxToOSFlags(flags) | os.O_CREATE
+	if flags&linuxOExcl != 0 {
+		osFlags |= os.O_EXCL
+	}
+	file, err := f.fs.OpenFile(p, osFlags, os.FileMode(mode).Perm())
+	if err != nil {
+		return errToErrno(err)
+	}
+	f.path, f.opened, f.file, f.written = p, true, file, true
+	q, e := f.statQid(p)
+	if e != 0 {
+		return e
+	}
+	b.qid(q).uint32(s.iounit())
+	return 0
+}
+
+func (s *session) symlink(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	name := r.string()
+	target := r.string()
+	r.uint32() // gid
+	if r.err != nil {
+		return errnoProto
+	}
+	f, p, e := s.getDirFid(id, name)
+	if e != 0 {
+		return e
+	}
+	err := f.fs.Symlink(target, p)
+	if err == nil {
+		err = f.sync()
+	}
+	if err != nil {
+		return errToErrno(err)
+	}
+	q, e := f.statQid(p)
+	if e != 0 {
+		return e
+	}
+	b.qid(q)
+	return 0
+}
+
+func (s *session) doRename(fs fileSystem, oldPath, newPath string) errno {
+	if oldPath == "/" {
+		return errnoInval
+	}
+	err := fs.Rename(oldPath, newPath)
+	if err != nil {
+		return errToErrno(err)
+	}
+	s.renamed(fs, oldPath, newPath)
+	if s, ok := fs.(libfs.Syncer); ok {
+		if err := s.SyncAll(); err != nil {
+			return errToErrno(err)
+		}
+	}
+	return 0
+}
+
+func (s *session) rename(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	dirID := r.uint32()
+	name := r.string()
+	if r.err != nil {
+		return errnoProto
+	}
+	f, e := s.getFid(id)
+	if e != 0 {
+		return e
+	}
+	d, newPath, e := s.getDirFid(dirID, name)
+	if e != 0 {
+		return e
+	}
+	if d.fs != f.fs {
+		return errnoInval
+	}
+	return s.doRename(f.fs, f.path, newPath)
+}
+
+func (s *session) readlink(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	if e != 0 {
+		return e
+	}
+	target, err := f.fs.Readlink(f.path)
+	if err != nil {
+		return errToErrno(err)
+	}
+	b.string(target)
+	return 0
+}
+
+func writeTime(b *msgBuilder, t time.Time) {
+	if t.IsZero() {
+		b.uint64(0).uint64(0)
+		return
+	}
+	b.uint64(uint64(t.Unix())).uint64(uint64(t.Nanosecond()))
+}
+
+func (s *session) getattr(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	r.uint64() // request mask; we always return the basic fields
+	if e != 0 {
+		return e
+	}
+	fi, err := f.fs.Lstat(f.path)
+	if err != nil {
+		return errToErrno(err)
+	}
+	mode, nlink := uint32(linuxSIFReg), uint64(1)
+	switch {
+	case fi.IsDir():
+		mode, nlink = linuxSIFDir, 2
+	case fi.Mode()&os.ModeSymlink != 0:
+		mode = linuxSIFLnk
+	}
+	mode |= uint32(fi.Mode().Perm())
+	size := uint64(fi.Size())
+	b.uint64(getattrBasic).qid(f.qid(f.path, fi)).
+		uint32(mode).uint32(f.uid).uint32(f.uid).uint64(nlink).
+		uint64(0).uint64(size).uint64(4096).uint64((size + 511) / 512)
+	// Rgetattr carries atime, mtime and ctime, but KBFS keeps only
+	// the mtime, so it stands in for all three.
+	for i := 0; i < 3; i++ {
+		writeTime(b, fi.ModTime())
+	}
+	writeTime(b, time.Time{}) // btime
+	b.uint64(0).uint64(0)     // gen, data_version
+	return 0
+}
+
+func (s *session) setattr(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	valid := r.uint32()
+	mode := r.uint32()
+	r.uint32() // uid
+	r.uint32() // gid
+	size := r.uint64()
+	atime := time.Unix(int64(r.uint64()), int64(r.uint64()))
+	mtime := time.Unix(int64(r.uint64()), int64(r.uint64()))
+	if e != 0 || r.err != nil {
+		return e
+	}
+
+	if valid&setattrSize != 0 {
+		file := f.file
+		if file == nil {
+			var err error
+			file, err = f.fs.OpenFile(f.path, os.O_WRONLY, 0)
+			if err != nil {
+				return errToErrno(err)
+			}
+			defer file.Close()
+		}
+		if err := file.Truncate(int64(size)); err != nil {
+			return errToErrno(err)
+		}
+	}
+	if change, ok := f.fs.(billy.Change); ok {
+		if valid&setattrMode != 0 {
+			err := change.Chmod(f.path, os.FileMode(mode).Perm())
+			if err != nil {
+				return errToErrno(err)
+			}
+		}
+		if valid&(setattrAtime|setattrMtime) != 0 {
+			fi, err := f.fs.Stat(f.path)
+			if err != nil {
+				return errToErrno(err)
+			}
+			now := time.Now()
+			newAtime, newMtime := fi.ModTime(), fi.ModTime()
+			if valid&setattrAtimeSet != 0 {
+				newAtime = atime
+			} else if valid&setattrAtime != 0 {
+				newAtime = now
+			}
+			if valid&setattrMtimeSet != 0 {
+				newMtime = mtime
+			} else if valid&setattrMtime != 0 {
+				newMtime = now
+			}
+			err = change.Chtimes(f.path, newAtime, newMtime)
+			if err != nil {
+				return errToErrno(err)
+			}
+		}
+	}
+	if err := f.sync(); err != nil {
+		return errToErrno(err)
+	}
+	return 0
+}
+
+// readdir returns entries starting at `offset`, which is just a
+// position in the sorted listing, so entries may be skipped or
+// repeated if the directory changes while it's being read.
+func (s *session) readdir(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	offset := r.uint64()
+	count := r.uint32()
+	if e != 0 || r.err != nil {
+		return e
+	}
+	if !f.opened || f.file != nil {
+		return errnoBadF
+	}
+	if offset == 0 || f.dir == nil {
+		children, err := f.fs.ReadDir(f.path)
+		if err != nil {
+			return errToErrno(err)
+		}
+		sort.Sort(fileInfosByName(children))
+		f.dir = children
+	}
+	if count > s.iounit() {
+		count = s.iounit()
+	}
+
+	entries := &msgBuilder{}
+	for i := offset; i < uint64(len(f.dir)); i++ {
+		fi := f.dir[i]
+		p := path.Join(f.path, fi.Name())
+		entry := &msgBuilder{}
+		direntType := uint8(linuxDTReg)
+		switch {
+		case fi.IsDir():
+			direntType = linuxDTDir
+		case fi.Mode()&os.ModeSymlink != 0:
+			direntType = linuxDTLnk
+		}
+		entry.qid(f.qid(p, fi)).uint64(i + 1).uint8(direntType).
+			string(fi.Name())
+		if len(entries.buf)+len(entry.buf) > int(count) {
+			break
+		}
+		entries.buf = append(entries.buf, entry.buf...)
+	}
+	b.uint32(uint32(len(entries.buf)))
+	b.buf = append(b.buf, entries.buf...)
+	return 0
+}
+
+func (s *session) fsync(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	if e != 0 {
+		return e
+	}
+	if err := f.sync(); err != nil {
+		return errToErrno(err)
+	}
+	return 0
+}
+
+// lock always succeeds: locks are only advisory, and aren't shared
+// with other clients.
+func (s *session) lock(r *msgReader, b *msgBuilder) errno {
+	_, e := s.readFid(r)
+	if e != 0 {
+		return e
+	}
+	const lockSuccess = 0
+	b.uint8(lockSuccess)
+	return 0
+}
+
+// getlock always reports that nothing else holds a lock.
+func (s *session) getlock(r *msgReader, b *msgBuilder) errno {
+	_, e := s.readFid(r)
+	r.uint8() // type
+	start := r.uint64()
+	length := r.uint64()
+	procID := r.uint32()
+	clientID := r.string()
+	if e != 0 || r.err != nil {
+		return e
+	}
+	const lockTypeUnlck = 2
+	b.uint8(lockTypeUnlck).uint64(start).uint64(length).uint32(procID).
+		string(clientID)
+	return 0
+}
+
+func (s *session) mkdir(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	name := r.string()
+	mode := r.uint32()
+	r.uint32() // gid
+	if r.err != nil {
+		return errnoProto
+	}
+	f, p, e := s.getDirFid(id, name)
+	if e != 0 {
+		return e
+	}
+	if _, err := f.fs.Lstat(p); err == nil {
+		return errnoExist
+	}
+	err := f.fs.MkdirAll(p, os.FileMode(mode).Perm())
+	if err == nil {
+		err = f.sync()
+	}
+	if err != nil {
+		return errToErrno(err)
+	}
+	q, e := f.statQid(p)
+	if e != 0 {
+		return e
+	}
+	b.qid(q)
+	return 0
+}
+
+func (s *session) renameat(r *msgReader, b *msgBuilder) errno {
+	oldDirID := r.uint32()
+	oldName := r.string()
+	newDirID := r.uint32()
+	newName := r.string()
+	if r.err != nil {
+		return errnoProto
+	}
+	oldDir, oldPath, e := s.getDirFid(oldDirID, oldName)
+	if e != 0 {
+		return e
+	}
+	newDir, newPath, e := s.getDirFid(newDirID, newName)
+	if e != 0 {
+		return e
+	}
+	if oldDir.fs != newDir.fs {
+		return errnoInval
+	}
+	return s.doRename(oldDir.fs, oldPath, newPath)
+}
+
+func (s *session) doRemove(f *fid, p string, dirOnly bool) errno {
+	if p == "/" {
+		return errnoInval
+	}
+	fi, err := f.fs.Lstat(p)
+	if err != nil {
+		return errToErrno(err)
+	}
+	if fi.IsDir() {
+		children, err := f.fs.ReadDir(p)
+		if err != nil {
+			return errToErrno(err)
+		}
+		if len(children) > 0 {
+			return errnoNotEmpty
+		}
+	} else if dirOnly {
+		return errnoNotDir
+	}
+	err = f.fs.Remove(p)
+	if err == nil {
+		err = f.sync()
+	}
+	if err != nil {
+		return errToErrno(err)
+	}
+	return 0
+}
+
+func (s *session) unlinkat(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	name := r.string()
+	flags := r.uint32()
+	if r.err != nil {
+		return errnoProto
+	}
+	f, p, e := s.getDirFid(id, name)
+	if e != 0 {
+		return e
+	}
+	if flags&atRemoveDir == 0 {
+		fi, err := f.fs.Lstat(p)
+		if err != nil {
+			return errToErrno(err)
+		}
+		if fi.IsDir() {
+			return errnoIsDir
+		}
+	}
+	return s.doRemove(f, p, flags&atRemoveDir != 0)
+}
+
+func (s *session) statfs(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	if e != 0 {
+		return e
+	}
+	// KBFS quotas belong to users and teams rather than TLFs, so
+	// report a big fixed amount of space; a write over the real
+	// quota still fails.
+	const plenty = 1 << 40
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, f.aname)
+	b.uint32(v9fsMagic).uint32(4096).
+		uint64(plenty).uint64(plenty).uint64(plenty).
+		uint64(plenty).uint64(plenty).uint64(h.Sum64()).uint32(maxNameLen)
+	return 0
+}
+
+func (s *session) read(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	offset := r.uint64()
+	count := r.uint32()
+	if e != 0 || r.err != nil {
+		return e
+	}
+	if f.file == nil {
+		return errnoBadF
+	}
+	if count > s.iounit() {
+		count = s.iounit()
+	}
+	buf := make([]byte, count)
+	n, err := f.file.ReadAt(buf, int64(offset))
+	if err != nil && err != io.EOF {
+		return errToErrno(err)
+	}
+	b.uint32(uint32(n))
+	b.buf = append(b.buf, buf[:n]...)
+	return 0
+}
+
+func (s *session) write(r *msgReader, b *msgBuilder) errno {
+	f, e := s.readFid(r)
+	offset := r.uint64()
+	count := r.uint32()
+	if e != 0 || r.err != nil {
+		return e
+	}
+	if count > uint32(len(r.buf)) {
+		return errnoProto
+	}
+	data := r.next(int(count))
+	if f.file == nil {
+		return errnoBadF
+	}
+	f.written = true
+	_, err := f.file.Seek(int64(offset), io.SeekStart)
+	if err != nil {
+		return errToErrno(err)
+	}
+	n, err := f.file.Write(data)
+	if err != nil {
+		return errToErrno(err)
+	}
+	b.uint32(uint32(n))
+	return 0
+}
+
+func (s *session) clunk(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	f, e := s.getFid(id)
+	if e != 0 {
+		return e
+	}
+	delete(s.fids, id)
+	if err := f.close(); err != nil {
+		return errToErrno(err)
+	}
+	return 0
+}
+
+// remove removes the fid's file, and clunks the fid even if that
+// fails.
+func (s *session) remove(r *msgReader, b *msgBuilder) errno {
+	id := r.uint32()
+	f, e := s.getFid(id)
+	if e != 0 {
+		return e
+	}
+	delete(s.fids, id)
+	closeErr := f.close()
+	if e := s.doRemove(f, f.path, false); e != 0 {
+		return e
+	}
+	if closeErr != nil {
+		return errToErrno(closeErr)
+	}
+	return 0
+}
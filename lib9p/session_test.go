@@ -0,0 +1,283 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package lib9p
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	tag  uint16
+}
+
+// call sends a message built by `args`, and returns the reply body
+// if it has type t+1, or the error number if it's an Rlerror.
+func (c *testClient) call(t msgType, args func(b *msgBuilder)) (
+	*msgReader, errno) {
+	c.tag++
+	b := newMsgBuilder(t, c.tag)
+	args(b)
+	_, err := c.conn.Write(b.bytes())
+	require.NoError(c.t, err)
+	replyType, tag, body, err := readMsg(c.conn, maxMsize)
+	require.NoError(c.t, err)
+	require.Equal(c.t, c.tag, tag)
+	r := &msgReader{buf: body}
+	if replyType == msgRlerror {
+		return nil, errno(r.uint32())
+	}
+	require.Equal(c.t, t+1, replyType)
+	return r, 0
+}
+
+func (c *testClient) mustCall(t msgType, args func(b *msgBuilder)) *msgReader {
+	r, e := c.call(t, args)
+	require.Equal(c.t, errno(0), e)
+	return r
+}
+
+func (c *testClient) walk(fid, newFid uint32, names ...string) ([]qid, errno) {
+	r, e := c.call(msgTwalk, func(b *msgBuilder) {
+		b.uint32(fid).uint32(newFid).uint16(uint16(len(names)))
+		for _, name := range names {
+			b.string(name)
+		}
+	})
+	if e != 0 {
+		return nil, e
+	}
+	qids := make([]qid, r.uint16())
+	for i := range qids {
+		qids[i] = r.qid()
+	}
+	require.NoError(c.t, r.err)
+	return qids, 0
+}
+
+func (c *testClient) clunk(fid uint32) {
+	c.mustCall(msgTclunk, func(b *msgBuilder) { b.uint32(fid) })
+}
+
+func (c *testClient) readdir(fid uint32) (names []string) {
+	offset := uint64(0)
+	for {
+		// Read only a little at a time, so the listing takes several
+		// Treaddirs.
+		r := c.mustCall(msgTreaddir, func(b *msgBuilder) {
+			b.uint32(fid).uint64(offset).uint32(60)
+		})
+		n := r.uint32()
+		if n == 0 {
+			return names
+		}
+		entries := &msgReader{buf: r.next(int(n))}
+		for len(entries.buf) > 0 {
+			entries.qid()
+			offset = entries.uint64()
+			entries.uint8()
+			names = append(names, entries.string())
+		}
+		require.NoError(c.t, entries.err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lib9p")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	attach := func(aname string) (fileSystem, error) {
+		if aname != "private/jdoe" {
+			return nil, os.ErrNotExist
+		}
+		return osfs.New(dir), nil
+	}
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go newSession(attach, serverConn).serve()
+	c := &testClient{t: t, conn: clientConn}
+
+	t.Log("Negotiate the version and attach")
+	r := c.mustCall(msgTversion, func(b *msgBuilder) {
+		b.uint32(8192).string("9P2000.L")
+	})
+	require.Equal(t, uint32(8192), r.uint32())
+	require.Equal(t, protocolVersion, r.string())
+	_, e := c.call(msgTattach, func(b *msgBuilder) {
+		b.uint32(0).uint32(noFid).string("").string("private/other").
+			uint32(1000)
+	})
+	require.Equal(t, errnoNoEnt, e)
+	r = c.mustCall(msgTattach, func(b *msgBuilder) {
+		b.uint32(0).uint32(noFid).string("").string("private/jdoe").
+			uint32(1000)
+	})
+	require.Equal(t, uint8(qidTypeDir), r.qid().qidType)
+
+	t.Log("Create and write a file")
+	_, e = c.walk(0, 1)
+	require.Equal(t, errno(0), e)
+	r = c.mustCall(msgTlcreate, func(b *msgBuilder) {
+		b.uint32(1).string("a.txt").uint32(2 | linuxOCreat).uint32(0644).
+			uint32(0)
+	})
+	q := r.qid()
+	require.Equal(t, uint8(qidTypeFile), q.qidType)
+	require.Equal(t, uint32(8192-ioHeaderLen), r.uint32())
+	data := []byte("hello world")
+	r = c.mustCall(msgTwrite, func(b *msgBuilder) {
+		b.uint32(1).uint64(0).uint32(uint32(len(data)))
+		b.buf = append(b.buf, data...)
+	})
+	require.Equal(t, uint32(len(data)), r.uint32())
+	c.clunk(1)
+	buf, err := ioutil.ReadFile(dir + "/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+
+	t.Log("Walk to, stat and read the file")
+	qids, e := c.walk(0, 2, "a.txt")
+	require.Equal(t, errno(0), e)
+	require.Equal(t, []qid{q}, qids)
+	r = c.mustCall(msgTgetattr, func(b *msgBuilder) {
+		b.uint32(2).uint64(getattrBasic)
+	})
+	require.Equal(t, uint64(getattrBasic), r.uint64())
+	require.Equal(t, q, r.qid())
+	require.Equal(t, uint32(linuxSIFReg|0644), r.uint32()&^022)
+	require.Equal(t, uint32(1000), r.uint32())
+	r.uint32()
+	r.uint64()
+	r.uint64()
+	require.Equal(t, uint64(len(data)), r.uint64())
+	c.mustCall(msgTlopen, func(b *msgBuilder) { b.uint32(2).uint32(0) })
+	r = c.mustCall(msgTread, func(b *msgBuilder) {
+		b.uint32(2).uint64(6).uint32(100)
+	})
+	n := r.uint32()
+	require.Equal(t, data[6:], r.next(int(n)))
+	_, e = c.walk(2, 3)
+	require.Equal(t, errnoBadF, e)
+	c.clunk(2)
+	_, e = c.walk(0, 3, "missing")
+	require.Equal(t, errnoNoEnt, e)
+
+	t.Log("A partial walk returns the qids that were found")
+	qids, e = c.walk(0, 3, "a.txt", "..", "missing")
+	require.Equal(t, errno(0), e)
+	require.Len(t, qids, 2)
+	_, e = c.walk(3, 4)
+	require.Equal(t, errnoBadF, e)
+
+	t.Log("Truncate the file")
+	_, e = c.walk(0, 2, "a.txt")
+	require.Equal(t, errno(0), e)
+	c.mustCall(msgTsetattr, func(b *msgBuilder) {
+		b.uint32(2).uint32(setattrSize).uint32(0).uint32(0).uint32(0).
+			uint64(5).uint64(0).uint64(0).uint64(0).uint64(0)
+	})
+	fi, err := os.Stat(dir + "/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), fi.Size())
+
+	t.Log("Make a directory and move the file into it")
+	c.mustCall(msgTmkdir, func(b *msgBuilder) {
+		b.uint32(0).string("d").uint32(0755).uint32(0)
+	})
+	_, e = c.call(msgTmkdir, func(b *msgBuilder) {
+		b.uint32(0).string("d").uint32(0755).uint32(0)
+	})
+	require.Equal(t, errnoExist, e)
+	_, e = c.walk(0, 3, "d")
+	require.Equal(t, errno(0), e)
+	c.mustCall(msgTrenameat, func(b *msgBuilder) {
+		b.uint32(0).string("a.txt").uint32(3).string("b.txt")
+	})
+	// The fid for the file follows it.
+	r = c.mustCall(msgTgetattr, func(b *msgBuilder) {
+		b.uint32(2).uint64(getattrBasic)
+	})
+	r.uint64()
+	fileQid := r.qid()
+	qids, e = c.walk(3, 4, "b.txt")
+	require.Equal(t, errno(0), e)
+	require.Equal(t, []qid{fileQid}, qids)
+	c.clunk(4)
+	c.clunk(2)
+
+	t.Log("Symlinks")
+	c.mustCall(msgTsymlink, func(b *msgBuilder) {
+		b.uint32(3).string("link").string("b.txt").uint32(0)
+	})
+	_, e = c.walk(3, 4, "link")
+	require.Equal(t, errno(0), e)
+	r = c.mustCall(msgTreadlink, func(b *msgBuilder) { b.uint32(4) })
+	require.Equal(t, "b.txt", r.string())
+	c.clunk(4)
+
+	t.Log("List directories")
+	for _, name := range []string{"m", "n", "o"} {
+		_, e = c.walk(3, 4)
+		require.Equal(t, errno(0), e)
+		c.mustCall(msgTlcreate, func(b *msgBuilder) {
+			b.uint32(4).string(name).uint32(1).uint32(0644).uint32(0)
+		})
+		c.clunk(4)
+	}
+	_, e = c.walk(3, 4)
+	require.Equal(t, errno(0), e)
+	c.mustCall(msgTlopen, func(b *msgBuilder) { b.uint32(4).uint32(0) })
+	require.Equal(t, []string{"b.txt", "link", "m", "n", "o"},
+		c.readdir(4))
+	c.clunk(4)
+
+	t.Log("Remove things")
+	_, e = c.call(msgTunlinkat, func(b *msgBuilder) {
+		b.uint32(0).string("d").uint32(atRemoveDir)
+	})
+	require.Equal(t, errnoNotEmpty, e)
+	_, e = c.call(msgTunlinkat, func(b *msgBuilder) {
+		b.uint32(0).string("d").uint32(0)
+	})
+	require.Equal(t, errnoIsDir, e)
+	for _, name := range []string{"b.txt", "link", "m", "n"} {
+		c.mustCall(msgTunlinkat, func(b *msgBuilder) {
+			b.uint32(3).string(name).uint32(0)
+		})
+	}
+	_, e = c.walk(3, 4, "o")
+	require.Equal(t, errno(0), e)
+	c.mustCall(msgTremove, func(b *msgBuilder) { b.uint32(4) })
+	_, e = c.walk(4, 5)
+	require.Equal(t, errnoBadF, e)
+	c.clunk(3)
+	c.mustCall(msgTunlinkat, func(b *msgBuilder) {
+		b.uint32(0).string("d").uint32(atRemoveDir)
+	})
+	_, err = os.Stat(dir + "/d")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Bad names and unsupported messages are rejected")
+	_, e = c.call(msgTmkdir, func(b *msgBuilder) {
+		b.uint32(0).string("../x").uint32(0755).uint32(0)
+	})
+	require.Equal(t, errnoInval, e)
+	_, e = c.call(msgTxattrwalk, func(b *msgBuilder) {
+		b.uint32(0).uint32(5).string("user.foo")
+	})
+	require.Equal(t, errnoOpNotSupp, e)
+	_, e = c.call(msgTstatfs, func(b *msgBuilder) {})
+	require.Equal(t, errnoProto, e)
+	r = c.mustCall(msgTstatfs, func(b *msgBuilder) { b.uint32(0) })
+	require.Equal(t, uint32(v9fsMagic), r.uint32())
+}
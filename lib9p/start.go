@@ -0,0 +1,73 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package lib9p
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// StartOptions are options for starting up.
+type StartOptions struct {
+	KbfsParams libkbfs.InitParams
+	// ListenAddr is either a TCP address, or "unix:" followed by the
+	// path of a Unix domain socket.
+	ListenAddr string
+	// AllowLAN allows listening on non-loopback TCP addresses.
+	AllowLAN bool
+	// TLFs lists the TLFs to serve, each given as "<type>/<name>".
+	TLFs []string
+}
+
+const unixPrefix = "unix:"
+
+// listen listens on `addr`, as described by StartOptions.ListenAddr.
+// Unix domain sockets are only accessible by the current user.
+func listen(addr string, allowLAN bool) (net.Listener, error) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		socketPath := strings.TrimPrefix(addr, unixPrefix)
+		l, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(socketPath, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+
+	if !allowLAN {
+		err := libfs.CheckLoopbackAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s; use -lan to allow it", err)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Start starts KBFS and serves the given TLFs over 9P until
+// interrupted.
+func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
+	return libfs.StartServer(options.KbfsParams, kbCtx, func(
+		config libkbfs.Config, log logger.Logger) (libfs.Mounter, error) {
+		s, err := NewServer(config, options.TLFs)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := listen(options.ListenAddr, options.AllowLAN)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Serving 9P on %s", listener.Addr())
+		return libfs.NewListenerMounter(s, listener), nil
+	})
+}
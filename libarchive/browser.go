@@ -0,0 +1,133 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libarchive
+
+import (
+	"context"
+	"io"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+const (
+	// archivesDirName is the read-only directory, in each KBFS
+	// directory, that has a subdirectory with the contents of each
+	// .zip and .tar file in that directory.
+	archivesDirName = ".kbfs_archive"
+
+	// indexCacheSize is how many archive indexes are kept, so that
+	// browsing an archive doesn't read its headers over and over.
+	indexCacheSize = 32
+
+	// Debug tag ID for reads of archive data.
+	ctxOpID = "ARCHID"
+)
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+// indexKey identifies a version of an archive file.
+type indexKey struct {
+	id    libkbfs.NodeID
+	size  uint64
+	mtime int64
+}
+
+// nodeReaderAt reads a KBFS file, a block range at a time.
+type nodeReaderAt struct {
+	ctx  context.Context
+	ops  libkbfs.KBFSOps
+	node libkbfs.Node
+}
+
+func (r nodeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ops.Read(r.ctx, r.node, p, off)
+	if err != nil {
+		return int(n), err
+	}
+	if int(n) < len(p) {
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+// Browser exposes the contents of .zip and .tar files stored in KBFS
+// as read-only directories, e.g. the files in
+// /keybase/private/alice/src.zip can be read under
+// /keybase/private/alice/.kbfs_archive/src.zip/.  Nothing is
+// extracted into KBFS: archive headers are read once per version of
+// an archive, and file data is read from the archive's blocks as
+// it's needed.
+type Browser struct {
+	config  libkbfs.Config
+	log     logger.Logger
+	indexes *lru.Cache
+}
+
+// Enable registers a root node wrapper with `config` that makes
+// archives browsable, in TLFs that are first accessed after it's
+// called.
+func Enable(config libkbfs.Config) (*Browser, error) {
+	indexes, err := lru.New(indexCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	b := &Browser{
+		config:  config,
+		log:     config.MakeLogger("ARCH"),
+		indexes: indexes,
+	}
+	config.AddRootNodeWrapper(b.wrap)
+	return b, nil
+}
+
+func (b *Browser) wrap(root libkbfs.Node) libkbfs.Node {
+	return &dirNode{root, b}
+}
+
+// getIndex returns the index of the archive `name` in `dir`, reading
+// it if this version of the archive hasn't been read yet.
+func (b *Browser) getIndex(
+	ctx context.Context, dir libkbfs.Node, name string) (*index, error) {
+	format := formatForName(name)
+	if format == formatNone {
+		return nil, errors.Errorf("%s isn't an archive", name)
+	}
+	n, ei, err := b.config.KBFSOps().Lookup(ctx, dir, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ei.Type.IsFile() {
+		return nil, errors.Errorf("%s isn't a file", name)
+	}
+	key := indexKey{n.GetID(), ei.Size, ei.Mtime}
+	if idx, ok := b.indexes.Get(key); ok {
+		return idx.(*index), nil
+	}
+
+	b.log.CDebugf(ctx, "Reading the index of %s", name)
+	// The reader outlives this call, so it gets a context of its
+	// own.
+	ra := nodeReaderAt{
+		ctx: libkbfs.CtxWithRandomIDReplayable(
+			context.Background(), ctxIDKey, ctxOpID, b.log),
+		ops:  b.config.KBFSOps(),
+		node: n,
+	}
+	idx, err := readIndex(
+		format, ra, int64(ei.Size), time.Unix(0, ei.Mtime))
+	if err != nil {
+		return nil, err
+	}
+	b.indexes.Add(key, idx)
+	return idx, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libarchive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrowser(t *testing.T) {
+	ctx, cancel := context.WithTimeout(
+		libkbfs.BackgroundContextWithCancellationDelayer(), 60*time.Second)
+	defer cancel()
+	config := libkbfs.MakeTestConfigOrBust(t, "user1")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	_, err := Enable(config)
+	require.NoError(t, err)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	fs, err := libfs.NewFS(ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	err = fs.MkdirAll("d", 0755)
+	require.NoError(t, err)
+	for name, data := range map[string][]byte{
+		"d/test.zip": makeTestZip(t),
+		"d/test.tar": makeTestTar(t),
+		"d/notes":    []byte("not an archive"),
+	} {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	t.Log("Each directory lists its archives")
+	fis, err := fs.ReadDir("d/" + archivesDirName)
+	require.NoError(t, err)
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		require.True(t, fi.IsDir())
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{"test.tar", "test.zip"}, names)
+	fis, err = fs.ReadDir(archivesDirName)
+	require.NoError(t, err)
+	require.Len(t, fis, 0)
+	_, err = fs.Stat("d/" + archivesDirName + "/notes")
+	require.Error(t, err)
+
+	t.Log("Archive contents can be listed and read")
+	fis, err = fs.ReadDir("d/" + archivesDirName + "/test.zip/dir")
+	require.NoError(t, err)
+	require.Len(t, fis, 2)
+	f, err := fs.Open("d/" + archivesDirName + "/test.zip/dir/deflated.txt")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, strings.Repeat("deflated data ", 1000), string(data))
+	fi, err := fs.Stat("d/" + archivesDirName + "/test.tar/b/two.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), fi.Size())
+	f, err = fs.Open("d/" + archivesDirName + "/test.tar/a/one.txt")
+	require.NoError(t, err)
+	data, err = ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "one", string(data))
+	link, err := fs.Readlink("d/" + archivesDirName + "/test.tar/a/link")
+	require.NoError(t, err)
+	require.Equal(t, "one.txt", link)
+
+	t.Log("Archives can't be written to")
+	_, err = fs.Create("d/" + archivesDirName + "/test.zip/new")
+	require.Error(t, err)
+	err = fs.Remove("d/" + archivesDirName + "/test.zip/stored.txt")
+	require.Error(t, err)
+	f, err = fs.OpenFile(
+		"d/"+archivesDirName+"/test.zip/stored.txt", os.O_RDWR, 0)
+	if err == nil {
+		_, err = f.Write([]byte("x"))
+		f.Close()
+	}
+	require.Error(t, err)
+	_, err = fs.Stat("d/" + archivesDirName)
+	require.NoError(t, err)
+	fis, err = fs.ReadDir("d")
+	require.NoError(t, err)
+	require.Len(t, fis, 3)
+}
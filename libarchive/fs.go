@@ -0,0 +1,162 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libarchive
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// indexFS is a directory inside an archive.
+type indexFS struct {
+	idx *index
+	dir string
+}
+
+var _ libkbfs.NodeFSReadOnly = indexFS{}
+
+func (fs indexFS) lookup(name string) (*entry, error) {
+	return fs.idx.lookup(path.Join(fs.dir, name))
+}
+
+// Lstat implements the libkbfs.NodeFSReadOnly interface for indexFS.
+func (fs indexFS) Lstat(name string) (os.FileInfo, error) {
+	e, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return entryInfo{e}, nil
+}
+
+// ReadDir implements the libkbfs.NodeFSReadOnly interface for indexFS.
+func (fs indexFS) ReadDir(name string) ([]os.FileInfo, error) {
+	e, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrInvalid}
+	}
+	children := e.sortedChildren()
+	fis := make([]os.FileInfo, 0, len(children))
+	for _, child := range children {
+		fis = append(fis, entryInfo{child})
+	}
+	return fis, nil
+}
+
+// Readlink implements the libkbfs.NodeFSReadOnly interface for indexFS.
+func (fs indexFS) Readlink(name string) (string, error) {
+	e, err := fs.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if e.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+	}
+	return e.linkname, nil
+}
+
+// errFS is a directory that couldn't be read, e.g. because the
+// archive behind it is corrupt.  It looks empty, but its own stat
+// works, so that the error doesn't break directory listings.
+type errFS struct {
+	err   error
+	mtime time.Time
+}
+
+var _ libkbfs.NodeFSReadOnly = errFS{}
+
+// Lstat implements the libkbfs.NodeFSReadOnly interface for errFS.
+func (fs errFS) Lstat(name string) (os.FileInfo, error) {
+	if name != "" {
+		return nil, fs.err
+	}
+	return entryInfo{&entry{mode: os.ModeDir | 0555, mtime: fs.mtime}}, nil
+}
+
+// ReadDir implements the libkbfs.NodeFSReadOnly interface for errFS.
+func (fs errFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return nil, fs.err
+}
+
+// Readlink implements the libkbfs.NodeFSReadOnly interface for errFS.
+func (fs errFS) Readlink(name string) (string, error) {
+	return "", fs.err
+}
+
+// archivesFS is the .kbfs_archive directory of a KBFS directory,
+// which has a subdirectory for each archive in it.
+type archivesFS struct {
+	ctx    context.Context
+	config libkbfs.Config
+	dir    libkbfs.Node
+}
+
+var _ libkbfs.NodeFSReadOnly = archivesFS{}
+
+func archiveDirInfo(name string, ei libkbfs.EntryInfo) os.FileInfo {
+	return entryInfo{&entry{
+		name:  name,
+		mode:  os.ModeDir | 0555,
+		mtime: time.Unix(0, ei.Mtime),
+	}}
+}
+
+// Lstat implements the libkbfs.NodeFSReadOnly interface for archivesFS.
+func (fs archivesFS) Lstat(name string) (os.FileInfo, error) {
+	if name == "" {
+		ei, err := fs.config.KBFSOps().Stat(fs.ctx, fs.dir)
+		if err != nil {
+			return nil, err
+		}
+		return archiveDirInfo(archivesDirName, ei), nil
+	}
+	if formatForName(name) == formatNone {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+	_, ei, err := fs.config.KBFSOps().Lookup(fs.ctx, fs.dir, name)
+	if _, ok := errors.Cause(err).(libkbfs.NoSuchNameError); ok {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	} else if err != nil {
+		return nil, err
+	}
+	if !ei.Type.IsFile() {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+	return archiveDirInfo(name, ei), nil
+}
+
+// ReadDir implements the libkbfs.NodeFSReadOnly interface for archivesFS.
+func (fs archivesFS) ReadDir(name string) ([]os.FileInfo, error) {
+	if name != "" {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrInvalid}
+	}
+	children, err := fs.config.KBFSOps().GetDirChildren(fs.ctx, fs.dir)
+	if err != nil {
+		return nil, err
+	}
+	var fis []os.FileInfo
+	for name, ei := range children {
+		if ei.Type.IsFile() && formatForName(name) != formatNone {
+			fis = append(fis, archiveDirInfo(name, ei))
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
+
+// Readlink implements the libkbfs.NodeFSReadOnly interface for archivesFS.
+func (fs archivesFS) Readlink(name string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+}
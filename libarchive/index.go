@@ -0,0 +1,337 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libarchive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxLinkSize bounds the symlink targets read out of zip files, which
+// store them as file data.
+const maxLinkSize = 4096
+
+// archiveFormat is a kind of archive that can be browsed.
+type archiveFormat int
+
+const (
+	formatNone archiveFormat = iota
+	formatZip
+	formatTar
+)
+
+// formatForName returns the format of the archive called `name`,
+// going by its extension.
+func formatForName(name string) archiveFormat {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip":
+		return formatZip
+	case ".tar":
+		return formatTar
+	default:
+		return formatNone
+	}
+}
+
+// entry is a file, directory or symlink inside an archive.
+type entry struct {
+	name     string
+	mode     os.FileMode
+	size     int64
+	mtime    time.Time
+	linkname string
+	children map[string]*entry
+
+	// makeReader returns the data of a file, and is only called once.
+	makeReader func() io.ReaderAt
+	readerOnce sync.Once
+	reader     io.ReaderAt
+}
+
+func (e *entry) isDir() bool {
+	return e.mode.IsDir()
+}
+
+// readerAt returns the data of a file entry.  The same reader is
+// shared by all readers of the entry, so that compressed data can be
+// decoded incrementally.
+func (e *entry) readerAt() io.ReaderAt {
+	e.readerOnce.Do(func() {
+		e.reader = e.makeReader()
+	})
+	return e.reader
+}
+
+// entryInfo is the os.FileInfo for an entry.
+type entryInfo struct {
+	e *entry
+}
+
+var _ os.FileInfo = entryInfo{}
+
+func (ei entryInfo) Name() string       { return ei.e.name }
+func (ei entryInfo) Size() int64        { return ei.e.size }
+func (ei entryInfo) Mode() os.FileMode  { return ei.e.mode }
+func (ei entryInfo) ModTime() time.Time { return ei.e.mtime }
+func (ei entryInfo) IsDir() bool        { return ei.e.isDir() }
+func (ei entryInfo) Sys() interface{}   { return nil }
+
+// index is the tree of entries in an archive.
+type index struct {
+	root *entry
+}
+
+func newIndex(mtime time.Time) *index {
+	return &index{root: &entry{
+		mode:     os.ModeDir | 0555,
+		mtime:    mtime,
+		children: make(map[string]*entry),
+	}}
+}
+
+// add puts `e` at path `p` in the tree, making any missing parent
+// directories.  Entries are read-only, and entries with paths that
+// escape the archive are skipped.
+func (idx *index) add(p string, e *entry) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return
+	}
+	e.mode &^= 0222
+	parts := strings.Split(p, "/")
+	dir := idx.root
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := dir.children[part]
+		if !ok {
+			child = &entry{
+				name:     part,
+				mode:     os.ModeDir | 0555,
+				mtime:    e.mtime,
+				children: make(map[string]*entry),
+			}
+			dir.children[part] = child
+		} else if !child.isDir() {
+			// A file is in the way; skip the entry.
+			return
+		}
+		dir = child
+	}
+	e.name = parts[len(parts)-1]
+	if existing, ok := dir.children[e.name]; ok && existing.isDir() {
+		if !e.isDir() {
+			return
+		}
+		// Keep the children of directories that were made
+		// implicitly, or listed twice.
+		existing.mode = e.mode
+		existing.mtime = e.mtime
+		return
+	}
+	if e.isDir() {
+		e.children = make(map[string]*entry)
+	}
+	dir.children[e.name] = e
+}
+
+// lookup returns the entry at path `p`, where "" is the root.
+func (idx *index) lookup(p string) (*entry, error) {
+	e := idx.root
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		if !e.isDir() {
+			return nil, &os.PathError{Op: "lookup", Path: p, Err: os.ErrNotExist}
+		}
+		child, ok := e.children[part]
+		if !ok {
+			return nil, &os.PathError{Op: "lookup", Path: p, Err: os.ErrNotExist}
+		}
+		e = child
+	}
+	return e, nil
+}
+
+// sortedChildren returns the children of a directory entry, sorted
+// by name.
+func (e *entry) sortedChildren() []*entry {
+	children := make([]*entry, 0, len(e.children))
+	for _, child := range e.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].name < children[j].name
+	})
+	return children
+}
+
+// zipStreamReaderAt reads a compressed zip file entry, which can
+// only be decoded from its start.  Sequential reads, which is what
+// most readers do, continue decoding where the last one stopped; any
+// other read starts over.
+type zipStreamReaderAt struct {
+	f *zip.File
+
+	lock sync.Mutex
+	rc   io.ReadCloser
+	pos  int64
+}
+
+func (z *zipStreamReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if z.rc == nil || off < z.pos {
+		if z.rc != nil {
+			z.rc.Close()
+			z.rc = nil
+		}
+		rc, err := z.f.Open()
+		if err != nil {
+			return 0, err
+		}
+		z.rc = rc
+		z.pos = 0
+	}
+	if off > z.pos {
+		skipped, err := io.CopyN(ioutil.Discard, z.rc, off-z.pos)
+		z.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = io.ReadFull(z.rc, p)
+	z.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func readZipIndex(ra io.ReaderAt, size int64, mtime time.Time) (
+	*index, error) {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	idx := newIndex(mtime)
+	for _, f := range zr.File {
+		f := f
+		fi := f.FileInfo()
+		e := &entry{mode: fi.Mode(), mtime: fi.ModTime()}
+		switch {
+		case fi.IsDir():
+		case fi.Mode()&os.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			link, err := ioutil.ReadAll(io.LimitReader(rc, maxLinkSize))
+			rc.Close()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			e.linkname = string(link)
+			e.size = int64(len(link))
+		case fi.Mode().IsRegular():
+			e.size = int64(f.UncompressedSize64)
+			if f.Method == zip.Store {
+				off, err := f.DataOffset()
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				e.makeReader = func() io.ReaderAt {
+					return io.NewSectionReader(ra, off, e.size)
+				}
+			} else {
+				e.makeReader = func() io.ReaderAt {
+					return &zipStreamReaderAt{f: f}
+				}
+			}
+		default:
+			continue
+		}
+		idx.add(f.Name, e)
+	}
+	return idx, nil
+}
+
+// isSparse returns whether the data of a tar entry isn't stored
+// contiguously.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func readTarIndex(ra io.ReaderAt, size int64, mtime time.Time) (
+	*index, error) {
+	// tar.Reader seeks over file data, so only the headers are
+	// read.  Right after a header is read, the section reader is at
+	// the start of the file's data.
+	sr := io.NewSectionReader(ra, 0, size)
+	tr := tar.NewReader(sr)
+	idx := newIndex(mtime)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		fi := hdr.FileInfo()
+		e := &entry{mode: fi.Mode(), mtime: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeSymlink:
+			e.linkname = hdr.Linkname
+			e.size = int64(len(hdr.Linkname))
+		case tar.TypeReg, tar.TypeRegA:
+			if isSparse(hdr) {
+				continue
+			}
+			off, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			e.size = hdr.Size
+			e.makeReader = func() io.ReaderAt {
+				return io.NewSectionReader(ra, off, e.size)
+			}
+		default:
+			continue
+		}
+		idx.add(hdr.Name, e)
+	}
+	return idx, nil
+}
+
+// readIndex reads the index of an archive of the given format.
+func readIndex(format archiveFormat, ra io.ReaderAt, size int64,
+	mtime time.Time) (*index, error) {
+	switch format {
+	case formatZip:
+		return readZipIndex(ra, size, mtime)
+	case formatTar:
+		return readTarIndex(ra, size, mtime)
+	default:
+		return nil, errors.Errorf("Unknown archive format %d", format)
+	}
+}
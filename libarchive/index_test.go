@@ -0,0 +1,158 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libarchive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	add := func(name string, method uint16, mode os.FileMode, data string) {
+		h := &zip.FileHeader{Name: name, Method: method}
+		h.SetMode(mode)
+		f, err := w.CreateHeader(h)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	add("stored.txt", zip.Store, 0644, "stored data")
+	add("dir/deflated.txt", zip.Deflate, 0755,
+		strings.Repeat("deflated data ", 1000))
+	add("dir/link", zip.Store, os.ModeSymlink|0777, "deflated.txt")
+	add("empty/", zip.Store, os.ModeDir|0755, "")
+	add("../escape.txt", zip.Store, 0644, "escaped")
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func makeTestTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	add := func(hdr *tar.Header, data string) {
+		hdr.Size = int64(len(data))
+		hdr.ModTime = time.Unix(1500000000, 0)
+		err := w.WriteHeader(hdr)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	add(&tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755}, "")
+	add(&tar.Header{Name: "a/one.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		"one")
+	// A long name needs an extra header before the file's.
+	long := "a/" + strings.Repeat("x", 150)
+	add(&tar.Header{Name: long, Typeflag: tar.TypeReg, Mode: 0644}, "long")
+	add(&tar.Header{Name: "b/two.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		strings.Repeat("2", 1000))
+	add(&tar.Header{Name: "a/link", Typeflag: tar.TypeSymlink,
+		Linkname: "one.txt", Mode: 0777}, "")
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func readEntry(t *testing.T, idx *index, p string) string {
+	e, err := idx.lookup(p)
+	require.NoError(t, err)
+	buf := make([]byte, e.size)
+	n, err := e.readerAt().ReadAt(buf, 0)
+	if err != io.EOF {
+		require.NoError(t, err)
+	}
+	require.Equal(t, len(buf), n)
+	return string(buf)
+}
+
+func TestZipIndex(t *testing.T) {
+	data := makeTestZip(t)
+	idx, err := readIndex(formatZip, bytes.NewReader(data),
+		int64(len(data)), time.Now())
+	require.NoError(t, err)
+
+	fs := indexFS{idx, ""}
+	fis, err := fs.ReadDir("")
+	require.NoError(t, err)
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	// The escaping entry is put at the root.
+	require.Equal(t,
+		[]string{"dir", "empty", "escape.txt", "stored.txt"}, names)
+
+	require.Equal(t, "stored data", readEntry(t, idx, "stored.txt"))
+	deflated := strings.Repeat("deflated data ", 1000)
+	require.Equal(t, deflated, readEntry(t, idx, "dir/deflated.txt"))
+
+	t.Log("Compressed data can be read out of order")
+	e, err := idx.lookup("dir/deflated.txt")
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	for _, off := range []int64{5000, 100, 100, 13990} {
+		n, err := e.readerAt().ReadAt(buf, off)
+		end := off + 10
+		if end > int64(len(deflated)) {
+			end = int64(len(deflated))
+			require.Equal(t, io.EOF, err)
+		} else {
+			require.NoError(t, err)
+		}
+		require.Equal(t, deflated[off:end], string(buf[:n]))
+	}
+
+	t.Log("Everything is read-only")
+	fi, err := fs.Lstat("dir/deflated.txt")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0555), fi.Mode())
+	sub := indexFS{idx, "dir"}
+	link, err := sub.Readlink("link")
+	require.NoError(t, err)
+	require.Equal(t, "deflated.txt", link)
+	fi, err = fs.Lstat("empty")
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	_, err = fs.Lstat("nope")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestTarIndex(t *testing.T) {
+	data := makeTestTar(t)
+	idx, err := readIndex(formatTar, bytes.NewReader(data),
+		int64(len(data)), time.Now())
+	require.NoError(t, err)
+
+	fs := indexFS{idx, "a"}
+	fis, err := fs.ReadDir("")
+	require.NoError(t, err)
+	require.Len(t, fis, 3)
+	require.Equal(t, "one", readEntry(t, idx, "a/one.txt"))
+	require.Equal(t, "long",
+		readEntry(t, idx, "a/"+strings.Repeat("x", 150)))
+	require.Equal(t, strings.Repeat("2", 1000), readEntry(t, idx, "b/two.txt"))
+	link, err := fs.Readlink("link")
+	require.NoError(t, err)
+	require.Equal(t, "one.txt", link)
+	fi, err := fs.Lstat("one.txt")
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(1500000000, 0), fi.ModTime())
+
+	t.Log("Implicit parent directories are made")
+	fi, err = indexFS{idx, ""}.Lstat("b")
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+
+	_, err = readIndex(formatTar, bytes.NewReader(data[:700]), 700, time.Now())
+	require.Error(t, err)
+}
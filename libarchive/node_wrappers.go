@@ -0,0 +1,171 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libarchive
+
+import (
+	"context"
+	"io"
+	"path"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// This file contains libkbfs.Node wrappers for implementing the
+// .kbfs_archive directories.  It breaks down like this:
+//
+// * `Browser.wrap()` is installed as a root node wrapper, and wraps
+//   the root node for each TLF in a `dirNode` instance.
+// * `dirNode` wraps every node below it in another `dirNode`, and
+//   makes .kbfs_archive show up as a fake directory when it's looked
+//   up, wrapped as an `archivesNode`.
+// * `archivesNode` gets its contents from an `archivesFS`, which has
+//   a subdirectory for each archive in the parent directory, wrapped
+//   as an `archiveNode`.
+// * `archiveNode` is a directory or file inside an archive, whose
+//   contents come from the archive's index (via an `indexFS`) or
+//   from the archive's data, respectively.
+//
+// None of these directories are stored in the TLF; the nodes all use
+// fake block pointers, and all reads are satisfied by `GetFS` and
+// `GetFile`.
+
+// archiveNode is an entry inside an archive.
+type archiveNode struct {
+	libkbfs.Node
+	b *Browser
+	// dir is the directory that contains the archive.
+	dir     libkbfs.Node
+	archive string
+	// path is the path of the entry inside the archive, and is ""
+	// for the root of the archive.
+	path string
+}
+
+var _ libkbfs.Node = (*archiveNode)(nil)
+
+func (an archiveNode) getEntry(ctx context.Context) (*index, *entry, error) {
+	idx, err := an.b.getIndex(ctx, an.dir, an.archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := idx.lookup(an.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return idx, e, nil
+}
+
+// Readonly implements the Node interface for archiveNode.
+func (an archiveNode) Readonly(_ context.Context) bool {
+	return true
+}
+
+// GetFS implements the Node interface for archiveNode.
+func (an archiveNode) GetFS(ctx context.Context) libkbfs.NodeFSReadOnly {
+	idx, e, err := an.getEntry(ctx)
+	if err != nil {
+		an.b.log.CDebugf(ctx, "Couldn't read %s in %s: %+v",
+			an.path, an.archive, err)
+		return errFS{err: err, mtime: time.Now()}
+	}
+	if !e.isDir() {
+		return an.Node.GetFS(ctx)
+	}
+	return indexFS{idx, an.path}
+}
+
+// GetFile implements the Node interface for archiveNode.
+func (an archiveNode) GetFile(ctx context.Context) io.ReaderAt {
+	_, e, err := an.getEntry(ctx)
+	if err != nil {
+		an.b.log.CDebugf(ctx, "Couldn't read %s in %s: %+v",
+			an.path, an.archive, err)
+		return errReaderAt{err}
+	}
+	if !e.mode.IsRegular() {
+		return an.Node.GetFile(ctx)
+	}
+	return e.readerAt()
+}
+
+// WrapChild implements the Node interface for archiveNode.
+func (an archiveNode) WrapChild(child libkbfs.Node) libkbfs.Node {
+	child = an.Node.WrapChild(child)
+	return &archiveNode{
+		Node:    child,
+		b:       an.b,
+		dir:     an.dir,
+		archive: an.archive,
+		path:    path.Join(an.path, child.GetBasename()),
+	}
+}
+
+// errReaderAt fails every read, for files whose archive can't be
+// read anymore.
+type errReaderAt struct {
+	err error
+}
+
+func (r errReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return 0, r.err
+}
+
+// archivesNode is the .kbfs_archive directory in a KBFS directory.
+type archivesNode struct {
+	libkbfs.Node
+	b   *Browser
+	dir libkbfs.Node
+}
+
+var _ libkbfs.Node = (*archivesNode)(nil)
+
+// Readonly implements the Node interface for archivesNode.
+func (an archivesNode) Readonly(_ context.Context) bool {
+	return true
+}
+
+// GetFS implements the Node interface for archivesNode.
+func (an archivesNode) GetFS(ctx context.Context) libkbfs.NodeFSReadOnly {
+	return archivesFS{ctx, an.b.config, an.dir}
+}
+
+// WrapChild implements the Node interface for archivesNode.
+func (an archivesNode) WrapChild(child libkbfs.Node) libkbfs.Node {
+	child = an.Node.WrapChild(child)
+	return &archiveNode{
+		Node:    child,
+		b:       an.b,
+		dir:     an.dir,
+		archive: child.GetBasename(),
+	}
+}
+
+// dirNode is a KBFS node, which has a .kbfs_archive directory if it's
+// a directory.
+type dirNode struct {
+	libkbfs.Node
+	b *Browser
+}
+
+var _ libkbfs.Node = (*dirNode)(nil)
+
+// ShouldCreateMissedLookup implements the Node interface for dirNode.
+func (dn dirNode) ShouldCreateMissedLookup(ctx context.Context, name string) (
+	bool, context.Context, libkbfs.EntryType, string) {
+	if name == archivesDirName {
+		return true, ctx, libkbfs.FakeDir, ""
+	}
+	return dn.Node.ShouldCreateMissedLookup(ctx, name)
+}
+
+// WrapChild implements the Node interface for dirNode.
+func (dn dirNode) WrapChild(child libkbfs.Node) libkbfs.Node {
+	child = dn.Node.WrapChild(child)
+	if child.GetBasename() == archivesDirName {
+		return &archivesNode{child, dn.b, dn.Node}
+	}
+	return &dirNode{child, dn.b}
+}
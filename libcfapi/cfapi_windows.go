@@ -0,0 +1,233 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package libcfapi
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Bindings for the parts of cldapi.dll (Windows 10 1709 and later)
+// that are used here.  The structs mirror the layouts in cfapi.h on
+// 64-bit Windows.
+
+var (
+	modcldapi = syscall.NewLazyDLL("cldapi.dll")
+
+	procCfRegisterSyncRoot   = modcldapi.NewProc("CfRegisterSyncRoot")
+	procCfUnregisterSyncRoot = modcldapi.NewProc("CfUnregisterSyncRoot")
+	procCfConnectSyncRoot    = modcldapi.NewProc("CfConnectSyncRoot")
+	procCfDisconnectSyncRoot = modcldapi.NewProc("CfDisconnectSyncRoot")
+	procCfExecute            = modcldapi.NewProc("CfExecute")
+)
+
+const (
+	cfHydrationPolicyProgressive           = 1
+	cfHydrationPolicyModifierAutoDehydrate = 4
+	cfPopulationPolicyPartial              = 0
+	cfInSyncPolicyTrackAll                 = 0x00ffffff
+	cfHardLinkPolicyNone                   = 0
+
+	cfRegisterFlagUpdate              = 1
+	cfRegisterFlagMarkInSyncOnRoot    = 4
+	cfPlaceholderCreateFlagMarkInSync = 2
+
+	cfOperationTypeTransferData         = 0
+	cfOperationTypeTransferPlaceholders = 4
+
+	fileAttributeReadonly  = 0x1
+	fileAttributeDirectory = 0x10
+)
+
+// cfCallbackType is a CF_CALLBACK_TYPE.
+type cfCallbackType uint32
+
+const (
+	cfCallbackTypeFetchData               cfCallbackType = 0
+	cfCallbackTypeCancelFetchData         cfCallbackType = 2
+	cfCallbackTypeFetchPlaceholders       cfCallbackType = 3
+	cfCallbackTypeCancelFetchPlaceholders cfCallbackType = 4
+	cfCallbackTypeNone                    cfCallbackType = 0xffffffff
+)
+
+type cfSyncRegistration struct {
+	StructSize             uint32
+	ProviderName           *uint16
+	ProviderVersion        *uint16
+	SyncRootIdentity       unsafe.Pointer
+	SyncRootIdentityLength uint32
+	FileIdentity           unsafe.Pointer
+	FileIdentityLength     uint32
+	ProviderID             syscall.GUID
+}
+
+type cfSyncPolicies struct {
+	StructSize         uint32
+	HydrationPrimary   uint16
+	HydrationModifier  uint16
+	PopulationPrimary  uint16
+	PopulationModifier uint16
+	InSync             uint32
+	HardLink           uint32
+}
+
+type cfCallbackRegistration struct {
+	Type     cfCallbackType
+	Callback uintptr
+}
+
+// cfCallbackInfo is a CF_CALLBACK_INFO.
+type cfCallbackInfo struct {
+	StructSize             uint32
+	ConnectionKey          int64
+	CallbackContext        uintptr
+	VolumeGUIDName         *uint16
+	VolumeDosName          *uint16
+	VolumeSerialNumber     uint32
+	SyncRootFileID         int64
+	SyncRootIdentity       uintptr
+	SyncRootIdentityLength uint32
+	FileID                 int64
+	FileSize               int64
+	FileIdentity           unsafe.Pointer
+	FileIdentityLength     uint32
+	NormalizedPath         *uint16
+	TransferKey            int64
+	PriorityHint           uint8
+	CorrelationVector      uintptr
+	ProcessInfo            uintptr
+	RequestKey             int64
+}
+
+// cfFetchDataParams is a CF_CALLBACK_PARAMETERS holding FetchData.
+type cfFetchDataParams struct {
+	ParamSize             uint32
+	_                     uint32
+	Flags                 uint32
+	RequiredFileOffset    int64
+	RequiredLength        int64
+	OptionalFileOffset    int64
+	OptionalLength        int64
+	LastDehydrationTime   int64
+	LastDehydrationReason uint32
+}
+
+// cfTransferDataParams is a CF_OPERATION_PARAMETERS holding
+// TransferData.
+type cfTransferDataParams struct {
+	ParamSize        uint32
+	_                uint32
+	Flags            uint32
+	CompletionStatus uint32
+	Buffer           unsafe.Pointer
+	Offset           int64
+	Length           int64
+}
+
+// cfTransferPlaceholdersParams is a CF_OPERATION_PARAMETERS holding
+// TransferPlaceholders.
+type cfTransferPlaceholdersParams struct {
+	ParamSize             uint32
+	_                     uint32
+	Flags                 uint32
+	CompletionStatus      uint32
+	PlaceholderTotalCount int64
+	PlaceholderArray      unsafe.Pointer
+	PlaceholderCount      uint32
+	EntriesProcessed      uint32
+}
+
+type cfOperationInfo struct {
+	StructSize        uint32
+	Type              uint32
+	ConnectionKey     int64
+	TransferKey       int64
+	CorrelationVector uintptr
+	SyncStatus        uintptr
+	RequestKey        int64
+}
+
+// cfPlaceholderCreateInfo is a CF_PLACEHOLDER_CREATE_INFO, with its
+// CF_FS_METADATA inlined.
+type cfPlaceholderCreateInfo struct {
+	RelativeFileName   *uint16
+	CreationTime       int64
+	LastAccessTime     int64
+	LastWriteTime      int64
+	ChangeTime         int64
+	FileAttributes     uint32
+	FileSize           int64
+	FileIdentity       unsafe.Pointer
+	FileIdentityLength uint32
+	Flags              uint32
+	Result             int32
+	CreateUsn          int64
+}
+
+func hresultError(name string, r1 uintptr) error {
+	if int32(r1) >= 0 {
+		return nil
+	}
+	return fmt.Errorf("%s failed: HRESULT 0x%08x", name, uint32(r1))
+}
+
+// fileTime converts `t` into a Windows FILETIME, as a count of 100ns
+// intervals since 1601.
+func fileTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	ft := syscall.NsecToFiletime(t.UnixNano())
+	return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+}
+
+func cfRegisterSyncRoot(syncRoot string, reg *cfSyncRegistration,
+	policies *cfSyncPolicies, flags uint32) error {
+	p, err := syscall.UTF16PtrFromString(syncRoot)
+	if err != nil {
+		return err
+	}
+	r1, _, _ := procCfRegisterSyncRoot.Call(
+		uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(reg)),
+		uintptr(unsafe.Pointer(policies)), uintptr(flags))
+	return hresultError("CfRegisterSyncRoot", r1)
+}
+
+func cfUnregisterSyncRoot(syncRoot string) error {
+	p, err := syscall.UTF16PtrFromString(syncRoot)
+	if err != nil {
+		return err
+	}
+	r1, _, _ := procCfUnregisterSyncRoot.Call(uintptr(unsafe.Pointer(p)))
+	return hresultError("CfUnregisterSyncRoot", r1)
+}
+
+func cfConnectSyncRoot(syncRoot string, table []cfCallbackRegistration,
+	flags uint32) (key int64, err error) {
+	p, err := syscall.UTF16PtrFromString(syncRoot)
+	if err != nil {
+		return 0, err
+	}
+	r1, _, _ := procCfConnectSyncRoot.Call(
+		uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&table[0])),
+		0, uintptr(flags), uintptr(unsafe.Pointer(&key)))
+	return key, hresultError("CfConnectSyncRoot", r1)
+}
+
+func cfDisconnectSyncRoot(key int64) error {
+	r1, _, _ := procCfDisconnectSyncRoot.Call(uintptr(key))
+	return hresultError("CfDisconnectSyncRoot", r1)
+}
+
+func cfExecute(info *cfOperationInfo, params unsafe.Pointer) error {
+	r1, _, _ := procCfExecute.Call(
+		uintptr(unsafe.Pointer(info)), uintptr(params))
+	return hresultError("CfExecute", r1)
+}
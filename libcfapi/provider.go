@@ -0,0 +1,230 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package libcfapi serves KBFS through the Windows Cloud Filter API,
+// as an alternative to Dokan.  KBFS entries show up in a sync root
+// directory as placeholders, which Windows hydrates on demand by
+// asking for their data; Explorer shows their state in its native
+// status column.
+//
+// This first version is read-only: placeholders are created
+// read-only, and local changes aren't uploaded.
+package libcfapi
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// hydrateChunkSize is how much data is transferred to Windows
+	// at once while hydrating a file.  Transfers must be multiples of
+	// 4 KiB, except at the end of the file.
+	hydrateChunkSize = 1 << 20
+	// maxIdentityLength is the most bytes Windows keeps as the
+	// identity of a placeholder.
+	maxIdentityLength = 4 << 10
+	// rootIdentity is the identity of the sync root itself.
+	rootIdentity = "/"
+)
+
+// tlfTypes are the directories at the root of the sync root.
+var tlfTypes = []string{"private", "public", "team"}
+
+// fsSource provides the TLFs that are served.
+type fsSource interface {
+	// tlfNames returns the TLFs of type `tlfType` ("private",
+	// "public" or "team") that get a placeholder directory.
+	tlfNames(ctx context.Context, tlfType string) ([]string, error)
+	// tlfFS returns the filesystem of a TLF, for hydrating and
+	// uploading the files under its placeholder.
+	tlfFS(ctx context.Context, tlfType, tlfName string) (
+		billy.Filesystem, error)
+}
+
+// entry describes a placeholder to create.
+type entry struct {
+	// identity is the slash-separated path of the entry relative to
+	// the sync root, with a leading slash; Windows hands it back
+	// with every request for the placeholder.
+	identity string
+	name     string
+	isDir    bool
+	size     int64
+	mtime    time.Time
+}
+
+type entriesByName []entry
+
+func (l entriesByName) Len() int           { return len(l) }
+func (l entriesByName) Less(i, j int) bool { return l[i].name < l[j].name }
+func (l entriesByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// parseIdentity splits a placeholder identity into a TLF type, a TLF
+// name, and a path within the TLF, each of which may be empty.
+func parseIdentity(identity string) (tlfType, tlfName, p string, err error) {
+	if !strings.HasPrefix(identity, "/") ||
+		path.Clean(identity) != identity {
+		return "", "", "", errors.Errorf("Bad identity %q", identity)
+	}
+	if identity == rootIdentity {
+		return "", "", "", nil
+	}
+	parts := strings.SplitN(identity[1:], "/", 3)
+	tlfType = parts[0]
+	known := false
+	for _, t := range tlfTypes {
+		if t == tlfType {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return "", "", "", errors.Errorf("Bad identity %q", identity)
+	}
+	if len(parts) > 1 {
+		tlfName = parts[1]
+	}
+	if len(parts) > 2 {
+		p = parts[2]
+	}
+	return tlfType, tlfName, p, nil
+}
+
+// provider answers the Cloud Filter API's requests for placeholders
+// and data.  It doesn't depend on Windows, so that it can be tested
+// anywhere.
+type provider struct {
+	source fsSource
+}
+
+// list returns the entries in the directory with the given identity.
+func (p *provider) list(ctx context.Context, identity string) (
+	[]entry, error) {
+	tlfType, tlfName, dir, err := parseIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	switch {
+	case tlfType == "":
+		for _, t := range tlfTypes {
+			entries = append(entries, entry{
+				identity: "/" + t,
+				name:     t,
+				isDir:    true,
+			})
+		}
+	case tlfName == "":
+		names, err := p.source.tlfNames(ctx, tlfType)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			entries = append(entries, entry{
+				identity: path.Join(identity, name),
+				name:     name,
+				isDir:    true,
+			})
+		}
+	default:
+		fs, err := p.source.tlfFS(ctx, tlfType, tlfName)
+		if err != nil {
+			return nil, err
+		}
+		if dir == "" {
+			dir = "."
+		}
+		fis, err := fs.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			if fi.Mode()&os.ModeSymlink != 0 {
+				// Placeholders can't be symlinks.
+				continue
+			}
+			e := entry{
+				identity: path.Join(identity, fi.Name()),
+				name:     fi.Name(),
+				isDir:    fi.IsDir(),
+				mtime:    fi.ModTime(),
+			}
+			if !e.isDir {
+				e.size = fi.Size()
+			}
+			if len(e.identity) > maxIdentityLength {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.Sort(entriesByName(entries))
+	return entries, nil
+}
+
+// hydrate reads the data of the file with the given identity, from
+// `offset` through `offset+length` (or the end of the file), and
+// passes it to `transfer` in chunks.  Reading the file through KBFS
+// kicks off the block prefetcher for the rest of it, so later chunks
+// are usually already cached by the time they're needed.
+func (p *provider) hydrate(ctx context.Context, identity string,
+	offset, length int64,
+	transfer func(offset int64, data []byte) error) error {
+	tlfType, tlfName, filePath, err := parseIdentity(identity)
+	if err != nil {
+		return err
+	}
+	if filePath == "" {
+		return errors.Errorf("%s is not a file", identity)
+	}
+	fs, err := p.source.tlfFS(ctx, tlfType, tlfName)
+	if err != nil {
+		return err
+	}
+	f, err := fs.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := fs.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	end := offset + length
+	if end > fi.Size() {
+		end = fi.Size()
+	}
+
+	buf := make([]byte, hydrateChunkSize)
+	for off := offset; off < end; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := end - off
+		if n > int64(len(buf)) {
+			n = int64(len(buf))
+		}
+		read, err := f.ReadAt(buf[:n], off)
+		if err == io.EOF && int64(read) == n {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := transfer(off, buf[:read]); err != nil {
+			return err
+		}
+		off += int64(read)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libcfapi
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+// testSource serves TLFs out of local directories named
+// <root>/<type>/<name>.
+type testSource struct {
+	root string
+}
+
+func (s testSource) tlfNames(ctx context.Context, tlfType string) (
+	[]string, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(s.root, tlfType))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names, nil
+}
+
+func (s testSource) tlfFS(ctx context.Context, tlfType, tlfName string) (
+	billy.Filesystem, error) {
+	return osfs.New(filepath.Join(s.root, tlfType, tlfName)), nil
+}
+
+func TestParseIdentity(t *testing.T) {
+	for _, bad := range []string{
+		"", "private", "/foo", "/private/", "/private/../public",
+		"//private",
+	} {
+		_, _, _, err := parseIdentity(bad)
+		require.Error(t, err, bad)
+	}
+
+	tlfType, tlfName, p, err := parseIdentity("/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "", ""}, []string{tlfType, tlfName, p})
+
+	tlfType, tlfName, p, err = parseIdentity("/team/t1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"team", "t1", ""}, []string{tlfType, tlfName, p})
+
+	tlfType, tlfName, p, err = parseIdentity("/private/u1,u2/a/b")
+	require.NoError(t, err)
+	require.Equal(t,
+		[]string{"private", "u1,u2", "a/b"}, []string{tlfType, tlfName, p})
+}
+
+func TestList(t *testing.T) {
+	root, err := ioutil.TempDir("", "libcfapi")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	tlfDir := filepath.Join(root, "private", "jdoe")
+	require.NoError(t, os.MkdirAll(filepath.Join(tlfDir, "dir"), 0755))
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(tlfDir, "b"), []byte("hello"), 0644))
+	require.NoError(t, os.Symlink("b", filepath.Join(tlfDir, "link")))
+	p := &provider{source: testSource{root}}
+	ctx := context.Background()
+
+	entries, err := p.list(ctx, rootIdentity)
+	require.NoError(t, err)
+	require.Len(t, entries, len(tlfTypes))
+	require.Equal(t, "/private", entries[0].identity)
+	require.True(t, entries[0].isDir)
+
+	entries, err = p.list(ctx, "/private")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "/private/jdoe", entries[0].identity)
+
+	entries, err = p.list(ctx, "/public")
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+
+	t.Log("Symlinks are skipped")
+	entries, err = p.list(ctx, "/private/jdoe")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "/private/jdoe/b", entries[0].identity)
+	require.False(t, entries[0].isDir)
+	require.Equal(t, int64(5), entries[0].size)
+	require.Equal(t, "/private/jdoe/dir", entries[1].identity)
+	require.True(t, entries[1].isDir)
+
+	entries, err = p.list(ctx, "/private/jdoe/dir")
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+
+	_, err = p.list(ctx, "/private/jdoe/missing")
+	require.Error(t, err)
+}
+
+func TestHydrate(t *testing.T) {
+	root, err := ioutil.TempDir("", "libcfapi")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	tlfDir := filepath.Join(root, "private", "jdoe")
+	require.NoError(t, os.MkdirAll(tlfDir, 0755))
+	data := make([]byte, 2*hydrateChunkSize+123)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(tlfDir, "f"), data, 0644))
+	p := &provider{source: testSource{root}}
+	ctx := context.Background()
+
+	hydrate := func(offset, length int64) (got []byte, chunks int) {
+		next := offset
+		err := p.hydrate(ctx, "/private/jdoe/f", offset, length,
+			func(off int64, b []byte) error {
+				require.Equal(t, next, off)
+				next += int64(len(b))
+				got = append(got, b...)
+				chunks++
+				return nil
+			})
+		require.NoError(t, err)
+		return got, chunks
+	}
+
+	t.Log("The whole file, in chunks")
+	got, chunks := hydrate(0, int64(len(data)))
+	require.True(t, bytes.Equal(data, got))
+	require.Equal(t, 3, chunks)
+
+	t.Log("Ranges past the end of the file are clamped")
+	got, chunks = hydrate(hydrateChunkSize, 10*hydrateChunkSize)
+	require.True(t, bytes.Equal(data[hydrateChunkSize:], got))
+	require.Equal(t, 2, chunks)
+
+	got, chunks = hydrate(int64(len(data)), hydrateChunkSize)
+	require.Len(t, got, 0)
+	require.Equal(t, 0, chunks)
+
+	t.Log("Directories can't be hydrated")
+	err = p.hydrate(ctx, "/private/jdoe", 0, 1,
+		func(int64, []byte) error { return nil })
+	require.Error(t, err)
+
+	t.Log("Canceling stops hydration")
+	cancelCtx, cancel := context.WithCancel(ctx)
+	chunks = 0
+	err = p.hydrate(cancelCtx, "/private/jdoe/f", 0, int64(len(data)),
+		func(int64, []byte) error {
+			chunks++
+			cancel()
+			return nil
+		})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 1, chunks)
+}
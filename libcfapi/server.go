@@ -0,0 +1,123 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libcfapi
+
+import (
+	"context"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// Debug tag ID for a Cloud Filter API request.
+const ctxOpID = "CFAPI"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+// NTSTATUS values reported back to Windows for failed requests.
+const (
+	statusUnsuccessful       uint32 = 0xC0000001
+	statusAccessDenied       uint32 = 0xC0000022
+	statusObjectNameNotFound uint32 = 0xC0000034
+	statusCancelled          uint32 = 0xC0000120
+)
+
+// Server provides the TLFs served through the Cloud Filter API.
+type Server struct {
+	config   libkbfs.Config
+	log      logger.Logger
+	provider *provider
+	fs       *libfs.TlfFSCache
+}
+
+var _ fsSource = (*Server)(nil)
+
+// NewServer returns a new Server.
+func NewServer(config libkbfs.Config) *Server {
+	s := &Server{
+		config: config,
+		log:    config.MakeLogger("CFAPI"),
+		fs:     libfs.NewTlfFSCache(config),
+	}
+	s.provider = &provider{source: s}
+	return s
+}
+
+// errToNTStatus returns the NTSTATUS for KBFS errors.
+func errToNTStatus(err error) uint32 {
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError, libkbfs.NoSuchUserError,
+		libkbfs.NoSuchTeamError:
+		return statusObjectNameNotFound
+	case libkbfs.ReadAccessError, libkbfs.TlfAccessError:
+		return statusAccessDenied
+	}
+	switch errors.Cause(err) {
+	case context.Canceled, context.DeadlineExceeded:
+		return statusCancelled
+	}
+	return statusUnsuccessful
+}
+
+func (s *Server) newCtx(ctx context.Context) context.Context {
+	return libkbfs.CtxWithRandomIDReplayable(ctx, ctxIDKey, ctxOpID, s.log)
+}
+
+func (s *Server) tlfFS(ctx context.Context, tlfType, tlfName string) (
+	billy.Filesystem, error) {
+	t, err := tlf.ParseTlfTypeFromPath(tlfType)
+	if err != nil {
+		return nil, err
+	}
+	// The cached FS outlives this request, so it gets its own
+	// context.
+	fs, err := s.fs.Get(s.newCtx(context.Background()), t, tlfName)
+	if err != nil {
+		return nil, err
+	}
+	// Bind it to the callback's context, so that cancelling the
+	// callback also cancels whatever KBFS is doing for it.
+	return fs.WithContext(ctx), nil
+}
+
+func (s *Server) tlfNames(ctx context.Context, tlfType string) (
+	[]string, error) {
+	t, err := tlf.ParseTlfTypeFromPath(tlfType)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		// List nothing if we're not logged in.
+		return nil, nil
+	}
+	favs, err := s.config.KBFSOps().GetFavorites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fav := range favs {
+		if fav.Type != t {
+			continue
+		}
+		name, err := tlf.CanonicalToPreferredName(
+			session.Name, tlf.CanonicalName(fav.Name))
+		if err != nil {
+			s.log.CDebugf(ctx, "CanonicalToPreferredName: %q %v",
+				fav.Name, err)
+			continue
+		}
+		names = append(names, string(name))
+	}
+	return names, nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libcfapi
+
+import (
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// StartOptions are options for starting up.
+type StartOptions struct {
+	KbfsParams libkbfs.InitParams
+	// SyncRoot is the local directory to register as the sync root.
+	SyncRoot string
+}
+
+// Start starts KBFS and serves it in the sync root until interrupted.
+func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
+	if err := checkSupported(); err != nil {
+		return libfs.InitError(err.Error())
+	}
+
+	return libfs.StartServer(options.KbfsParams, kbCtx, func(
+		config libkbfs.Config, log logger.Logger) (libfs.Mounter, error) {
+		log.Info("Serving KBFS in sync root %s", options.SyncRoot)
+		return newSyncRootMounter(options.SyncRoot, NewServer(config)), nil
+	})
+}
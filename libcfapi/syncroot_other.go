@@ -0,0 +1,26 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package libcfapi
+
+import (
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+)
+
+func checkSupported() error {
+	return errors.New("The Cloud Filter API is only available on Windows")
+}
+
+func newSyncRootMounter(syncRoot string, s *Server) libfs.Mounter {
+	return nil
+}
+
+// Unregister unregisters the given sync root.
+func Unregister(syncRoot string) error {
+	return checkSupported()
+}
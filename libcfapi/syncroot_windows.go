@@ -0,0 +1,389 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package libcfapi
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+)
+
+const (
+	providerName    = "Keybase"
+	providerVersion = "1.0"
+)
+
+// Windows calls back into plain functions, which can't carry Go
+// pointers, so the connected sync root is kept here.  Only one can
+// be connected per process.
+var (
+	activeLock sync.Mutex
+	active     *syncRootMounter
+)
+
+func getActive() *syncRootMounter {
+	activeLock.Lock()
+	defer activeLock.Unlock()
+	return active
+}
+
+// callbackTable must stay alive while any sync root is connected.
+var callbackTable = []cfCallbackRegistration{
+	{cfCallbackTypeFetchData, syscall.NewCallback(onFetchData)},
+	{cfCallbackTypeCancelFetchData, syscall.NewCallback(onCancelFetchData)},
+	{cfCallbackTypeFetchPlaceholders,
+		syscall.NewCallback(onFetchPlaceholders)},
+	{cfCallbackTypeCancelFetchPlaceholders,
+		syscall.NewCallback(onCancelFetchData)},
+	{cfCallbackTypeNone, 0},
+}
+
+// checkSupported makes sure that this version of Windows has the
+// Cloud Filter API.
+func checkSupported() error {
+	if err := modcldapi.Load(); err != nil {
+		return errors.Wrap(
+			err, "The Cloud Filter API needs Windows 10 1709 or later")
+	}
+	return nil
+}
+
+// transfer tracks the requests for one transfer key, so that they
+// can all be canceled together.
+type transfer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	refs   int
+}
+
+// syncRootMounter lets a MountInterrupter connect and disconnect a
+// sync root as if it were a mount.
+type syncRootMounter struct {
+	path   string
+	server *Server
+
+	lock      sync.Mutex
+	key       int64
+	transfers map[int64]*transfer
+}
+
+var _ libfs.Mounter = (*syncRootMounter)(nil)
+
+func newSyncRootMounter(syncRoot string, s *Server) libfs.Mounter {
+	return &syncRootMounter{
+		path:      syncRoot,
+		server:    s,
+		transfers: make(map[int64]*transfer),
+	}
+}
+
+func (m *syncRootMounter) register() error {
+	name, err := syscall.UTF16PtrFromString(providerName)
+	if err != nil {
+		return err
+	}
+	version, err := syscall.UTF16PtrFromString(providerVersion)
+	if err != nil {
+		return err
+	}
+	identity := []byte(rootIdentity)
+	reg := cfSyncRegistration{
+		ProviderName:       name,
+		ProviderVersion:    version,
+		FileIdentity:       unsafe.Pointer(&identity[0]),
+		FileIdentityLength: uint32(len(identity)),
+	}
+	reg.StructSize = uint32(unsafe.Sizeof(reg))
+	policies := cfSyncPolicies{
+		HydrationPrimary:  cfHydrationPolicyProgressive,
+		HydrationModifier: cfHydrationPolicyModifierAutoDehydrate,
+		PopulationPrimary: cfPopulationPolicyPartial,
+		InSync:            cfInSyncPolicyTrackAll,
+		HardLink:          cfHardLinkPolicyNone,
+	}
+	policies.StructSize = uint32(unsafe.Sizeof(policies))
+	err = cfRegisterSyncRoot(m.path, &reg, &policies,
+		cfRegisterFlagUpdate|cfRegisterFlagMarkInSyncOnRoot)
+	runtime.KeepAlive(identity)
+	return err
+}
+
+func (m *syncRootMounter) Mount() error {
+	if err := checkSupported(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.path, 0755); err != nil {
+		return err
+	}
+	if err := m.register(); err != nil {
+		return err
+	}
+
+	activeLock.Lock()
+	defer activeLock.Unlock()
+	if active != nil {
+		return errors.New("Another sync root is already connected")
+	}
+	key, err := cfConnectSyncRoot(m.path, callbackTable, 0)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	m.key = key
+	m.lock.Unlock()
+	active = m
+	return nil
+}
+
+// Unmount disconnects the sync root, but leaves it registered, so
+// that hydrated files stay available while KBFS isn't running.
+func (m *syncRootMounter) Unmount() error {
+	activeLock.Lock()
+	if active == m {
+		active = nil
+	}
+	activeLock.Unlock()
+
+	m.lock.Lock()
+	for key, t := range m.transfers {
+		t.cancel()
+		delete(m.transfers, key)
+	}
+	key := m.key
+	m.lock.Unlock()
+	// Callbacks in progress need the lock, so don't hold it while
+	// disconnecting.
+	return cfDisconnectSyncRoot(key)
+}
+
+// Unregister unregisters the given sync root, which must not be
+// connected.  Its placeholders stop working, though hydrated files
+// are kept.
+func Unregister(syncRoot string) error {
+	if err := checkSupported(); err != nil {
+		return err
+	}
+	return cfUnregisterSyncRoot(syncRoot)
+}
+
+// startTransfer returns the context for a request with the given
+// transfer key; finishTransfer must be called when it's done.
+func (m *syncRootMounter) startTransfer(key int64) context.Context {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t, ok := m.transfers[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(
+			m.server.newCtx(context.Background()))
+		t = &transfer{ctx: ctx, cancel: cancel}
+		m.transfers[key] = t
+	}
+	t.refs++
+	return t.ctx
+}
+
+func (m *syncRootMounter) finishTransfer(key int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t, ok := m.transfers[key]
+	if !ok {
+		return
+	}
+	t.refs--
+	if t.refs == 0 {
+		t.cancel()
+		delete(m.transfers, key)
+	}
+}
+
+func (m *syncRootMounter) cancelTransfer(key int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if t, ok := m.transfers[key]; ok {
+		t.cancel()
+		delete(m.transfers, key)
+	}
+}
+
+// request holds what's needed from a callback after it returns,
+// since Windows only keeps the callback info alive until then.
+type request struct {
+	identity      string
+	connectionKey int64
+	transferKey   int64
+	requestKey    int64
+}
+
+func makeRequest(info *cfCallbackInfo) request {
+	n := int(info.FileIdentityLength)
+	if n > maxIdentityLength {
+		n = maxIdentityLength
+	}
+	var identity string
+	if n > 0 {
+		identity = string(
+			(*[maxIdentityLength]byte)(info.FileIdentity)[:n:n])
+	}
+	return request{
+		identity:      identity,
+		connectionKey: info.ConnectionKey,
+		transferKey:   info.TransferKey,
+		requestKey:    info.RequestKey,
+	}
+}
+
+func (r request) operation(opType uint32) cfOperationInfo {
+	op := cfOperationInfo{
+		Type:          opType,
+		ConnectionKey: r.connectionKey,
+		TransferKey:   r.transferKey,
+		RequestKey:    r.requestKey,
+	}
+	op.StructSize = uint32(unsafe.Sizeof(op))
+	return op
+}
+
+func onFetchPlaceholders(
+	info *cfCallbackInfo, _ *cfFetchDataParams) uintptr {
+	m := getActive()
+	if m == nil {
+		return 0
+	}
+	r := makeRequest(info)
+	ctx := m.startTransfer(r.transferKey)
+	go func() {
+		defer m.finishTransfer(r.transferKey)
+		m.fetchPlaceholders(ctx, r)
+	}()
+	return 0
+}
+
+// fetchPlaceholders creates placeholders for the entries of the
+// requested directory.  The directory isn't marked as fully
+// populated, so Windows asks again the next time it's listed, and
+// new entries show up.
+func (m *syncRootMounter) fetchPlaceholders(
+	ctx context.Context, r request) {
+	entries, err := m.server.provider.list(ctx, r.identity)
+	status := uint32(0)
+	if err != nil {
+		m.server.log.CDebugf(ctx, "Listing %s failed: %+v", r.identity, err)
+		status = errToNTStatus(err)
+		entries = nil
+	}
+
+	infos := make([]cfPlaceholderCreateInfo, 0, len(entries))
+	identities := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		name, err := syscall.UTF16PtrFromString(e.name)
+		if err != nil {
+			// Names with NULs can't be shown.
+			continue
+		}
+		identity := []byte(e.identity)
+		identities = append(identities, identity)
+		t := fileTime(e.mtime)
+		info := cfPlaceholderCreateInfo{
+			RelativeFileName:   name,
+			CreationTime:       t,
+			LastAccessTime:     t,
+			LastWriteTime:      t,
+			ChangeTime:         t,
+			FileIdentity:       unsafe.Pointer(&identity[0]),
+			FileIdentityLength: uint32(len(identity)),
+			Flags:              cfPlaceholderCreateFlagMarkInSync,
+		}
+		if e.isDir {
+			info.FileAttributes = fileAttributeDirectory
+		} else {
+			info.FileAttributes = fileAttributeReadonly
+			info.FileSize = e.size
+		}
+		infos = append(infos, info)
+	}
+
+	params := cfTransferPlaceholdersParams{
+		CompletionStatus:      status,
+		PlaceholderTotalCount: int64(len(infos)),
+		PlaceholderCount:      uint32(len(infos)),
+	}
+	params.ParamSize = uint32(unsafe.Sizeof(params))
+	if len(infos) > 0 {
+		params.PlaceholderArray = unsafe.Pointer(&infos[0])
+	}
+	op := r.operation(cfOperationTypeTransferPlaceholders)
+	err = cfExecute(&op, unsafe.Pointer(&params))
+	runtime.KeepAlive(infos)
+	runtime.KeepAlive(identities)
+	if err != nil {
+		// Entries that already exist fail individually; that's
+		// expected, since directories are listed again and again.
+		m.server.log.CDebugf(ctx, "Transferring placeholders for %s: %v",
+			r.identity, err)
+	}
+}
+
+func onFetchData(info *cfCallbackInfo, params *cfFetchDataParams) uintptr {
+	m := getActive()
+	if m == nil {
+		return 0
+	}
+	r := makeRequest(info)
+	offset, length := params.RequiredFileOffset, params.RequiredLength
+	ctx := m.startTransfer(r.transferKey)
+	go func() {
+		defer m.finishTransfer(r.transferKey)
+		m.fetchData(ctx, r, offset, length)
+	}()
+	return 0
+}
+
+// fetchData hydrates the requested range of a placeholder.
+func (m *syncRootMounter) fetchData(
+	ctx context.Context, r request, offset, length int64) {
+	op := r.operation(cfOperationTypeTransferData)
+	err := m.server.provider.hydrate(ctx, r.identity, offset, length,
+		func(off int64, data []byte) error {
+			params := cfTransferDataParams{
+				Buffer: unsafe.Pointer(&data[0]),
+				Offset: off,
+				Length: int64(len(data)),
+			}
+			params.ParamSize = uint32(unsafe.Sizeof(params))
+			return cfExecute(&op, unsafe.Pointer(&params))
+		})
+	if err == nil {
+		return
+	}
+
+	m.server.log.CDebugf(ctx, "Hydrating %s at %d+%d failed: %+v",
+		r.identity, offset, length, err)
+	params := cfTransferDataParams{
+		CompletionStatus: errToNTStatus(err),
+		Offset:           offset,
+		Length:           length,
+	}
+	params.ParamSize = uint32(unsafe.Sizeof(params))
+	if err := cfExecute(&op, unsafe.Pointer(&params)); err != nil {
+		m.server.log.CDebugf(ctx, "Failing the hydration of %s: %v",
+			r.identity, err)
+	}
+}
+
+func onCancelFetchData(info *cfCallbackInfo, _ *cfFetchDataParams) uintptr {
+	if m := getActive(); m != nil {
+		m.cancelTransfer(info.TransferKey)
+	}
+	return 0
+}
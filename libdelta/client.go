@@ -0,0 +1,440 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdelta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SyncStats counts what a sync did.
+type SyncStats struct {
+	// FilesSent is the number of files that were changed or created.
+	FilesSent int
+	// FilesSkipped is the number of files that were already up to
+	// date.
+	FilesSkipped int
+	// CopiedBytes is how much of the changed files was already at the
+	// destination, and didn't need to be sent.
+	CopiedBytes int64
+	// LiteralBytes is how much of the changed files was sent.
+	LiteralBytes int64
+}
+
+func (s *SyncStats) addFile(ds deltaStats) {
+	s.FilesSent++
+	s.CopiedBytes += ds.CopiedBytes
+	s.LiteralBytes += ds.LiteralBytes
+}
+
+// Client syncs local directory trees with a directory in KBFS served
+// by a Server, sending only the parts of files that changed, like
+// rsync.  It doesn't need KBFS or Keybase installed on its machine.
+//
+// Only regular files and directories are synced.  A file is taken to
+// be up to date if its size, modification time and executable bit
+// match.
+type Client struct {
+	// URL is where the server is, e.g. "http://127.0.0.1:16803".
+	URL string
+	// Token is the server's bearer token.
+	Token string
+	// HTTPClient is used for requests, or http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// BlockSize is the block size used to find changes, or
+	// DefaultBlockSize if zero.  Smaller blocks find more of the
+	// unchanged data, at the cost of bigger signatures.
+	BlockSize int
+	// Delete, if true, removes entries from the destination that
+	// aren't in the source.
+	Delete bool
+}
+
+func (c *Client) blockSize() int {
+	if c.BlockSize == 0 {
+		return DefaultBlockSize
+	}
+	return c.BlockSize
+}
+
+// remotePath converts a path like "/keybase/private/alice/backup" to
+// the form the server takes.
+func remotePath(p string) (string, error) {
+	p = path.Clean("/" + p)
+	if p == "/keybase" || strings.HasPrefix(p, "/keybase/") {
+		p = strings.TrimPrefix(p, "/keybase")
+	}
+	if _, err := parsePath(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func isNotFound(err error) bool {
+	e, ok := errors.Cause(err).(httpError)
+	return ok && e.status == http.StatusNotFound
+}
+
+func (c *Client) do(ctx context.Context, method, p string, query url.Values,
+	body io.Reader) (*http.Response, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, errors.WithStack(httpError{
+			resp.StatusCode,
+			method + " " + p + ": " + strings.TrimSpace(string(msg)),
+		})
+	}
+	return resp, nil
+}
+
+// doAndClose makes a request whose response body doesn't matter.
+func (c *Client) doAndClose(ctx context.Context, method, p string,
+	query url.Values, body io.Reader) error {
+	resp, err := c.do(ctx, method, p, query, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) list(ctx context.Context, p string) ([]Entry, error) {
+	resp, err := c.do(ctx, http.MethodGet, p, url.Values{"list": {""}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var entries []Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *Client) removeRemote(ctx context.Context, p string) error {
+	return c.doAndClose(
+		ctx, http.MethodDelete, p, url.Values{"recursive": {""}}, nil)
+}
+
+func isExecutable(fi os.FileInfo) bool {
+	return fi.Mode()&0100 != 0
+}
+
+func upToDate(e Entry, fi os.FileInfo) bool {
+	return e.Size == fi.Size() && e.Mtime == fi.ModTime().UnixNano() &&
+		e.Executable == isExecutable(fi)
+}
+
+// Push makes the directory at `remote` in KBFS (e.g.
+// "/keybase/private/alice/backup") match the local directory
+// `localDir`, creating it if needed.
+func (c *Client) Push(ctx context.Context, localDir, remote string) (
+	stats SyncStats, err error) {
+	rp, err := remotePath(remote)
+	if err != nil {
+		return SyncStats{}, err
+	}
+	if err := c.pushDir(ctx, localDir, rp, &stats); err != nil {
+		return SyncStats{}, err
+	}
+	return stats, nil
+}
+
+func (c *Client) pushDir(ctx context.Context, local, remote string,
+	stats *SyncStats) error {
+	fis, err := ioutil.ReadDir(local)
+	if err != nil {
+		return err
+	}
+	entries, err := c.list(ctx, remote)
+	if isNotFound(err) {
+		err = c.doAndClose(
+			ctx, http.MethodPost, remote, url.Values{"mkdir": {""}}, nil)
+	}
+	if err != nil {
+		return err
+	}
+	remoteEntries := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		remoteEntries[e.Name] = e
+	}
+
+	for _, fi := range fis {
+		lp := filepath.Join(local, fi.Name())
+		rp := path.Join(remote, fi.Name())
+		e, exists := remoteEntries[fi.Name()]
+		delete(remoteEntries, fi.Name())
+		switch {
+		case fi.IsDir():
+			if exists && e.Type != "dir" {
+				if err := c.removeRemote(ctx, rp); err != nil {
+					return err
+				}
+			}
+			if err := c.pushDir(ctx, lp, rp, stats); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if exists && e.Type != "file" {
+				if err := c.removeRemote(ctx, rp); err != nil {
+					return err
+				}
+				exists = false
+			}
+			if exists && upToDate(e, fi) {
+				stats.FilesSkipped++
+				continue
+			}
+			ds, err := c.pushFile(ctx, lp, rp, fi, exists)
+			if err != nil {
+				return err
+			}
+			stats.addFile(ds)
+		}
+	}
+
+	if c.Delete {
+		for _, e := range remoteEntries {
+			err := c.removeRemote(ctx, path.Join(remote, e.Name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) pushFile(ctx context.Context, local, remote string,
+	fi os.FileInfo, exists bool) (deltaStats, error) {
+	sig := &signature{blockSize: c.blockSize()}
+	if exists {
+		resp, err := c.do(ctx, http.MethodGet, remote, url.Values{
+			"signature":  {""},
+			"block_size": {strconv.Itoa(c.blockSize())},
+		}, nil)
+		if err != nil {
+			return deltaStats{}, err
+		}
+		sig, err = decodeSignature(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return deltaStats{}, err
+		}
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return deltaStats{}, err
+	}
+	defer f.Close()
+
+	// Stream the delta to the server as it's made.
+	type result struct {
+		stats deltaStats
+		err   error
+	}
+	pr, pw := io.Pipe()
+	resultCh := make(chan result, 1)
+	go func() {
+		stats, err := writeDelta(sig, f, pw)
+		pw.CloseWithError(err)
+		resultCh <- result{stats, err}
+	}()
+	exec := "0"
+	if isExecutable(fi) {
+		exec = "1"
+	}
+	err = c.doAndClose(ctx, http.MethodPost, remote, url.Values{
+		"patch": {""},
+		"exec":  {exec},
+		"mtime": {strconv.FormatInt(fi.ModTime().UnixNano(), 10)},
+	}, pr)
+	// Unblock the delta writer if the request ended early.
+	pr.Close()
+	res := <-resultCh
+	if err != nil {
+		return deltaStats{}, err
+	}
+	if res.err != nil {
+		return deltaStats{}, res.err
+	}
+	return res.stats, nil
+}
+
+// Pull makes the local directory `localDir` match the directory at
+// `remote` in KBFS (e.g. "/keybase/private/alice/backup"), creating
+// it if needed.
+func (c *Client) Pull(ctx context.Context, remote, localDir string) (
+	stats SyncStats, err error) {
+	rp, err := remotePath(remote)
+	if err != nil {
+		return SyncStats{}, err
+	}
+	if err := c.pullDir(ctx, rp, localDir, &stats); err != nil {
+		return SyncStats{}, err
+	}
+	return stats, nil
+}
+
+func (c *Client) pullDir(ctx context.Context, remote, local string,
+	stats *SyncStats) error {
+	entries, err := c.list(ctx, remote)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(local, 0755); err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(local)
+	if err != nil {
+		return err
+	}
+	localEntries := make(map[string]os.FileInfo, len(fis))
+	for _, fi := range fis {
+		localEntries[fi.Name()] = fi
+	}
+
+	for _, e := range entries {
+		lp := filepath.Join(local, e.Name)
+		rp := path.Join(remote, e.Name)
+		fi, exists := localEntries[e.Name]
+		delete(localEntries, e.Name)
+		switch e.Type {
+		case "dir":
+			if exists && !fi.IsDir() {
+				if err := os.RemoveAll(lp); err != nil {
+					return err
+				}
+			}
+			if err := c.pullDir(ctx, rp, lp, stats); err != nil {
+				return err
+			}
+		case "file":
+			if exists && !fi.Mode().IsRegular() {
+				if err := os.RemoveAll(lp); err != nil {
+					return err
+				}
+				exists = false
+			}
+			if exists && upToDate(e, fi) {
+				stats.FilesSkipped++
+				continue
+			}
+			ds, err := c.pullFile(ctx, rp, lp, exists)
+			if err != nil {
+				return err
+			}
+			stats.addFile(ds)
+		}
+	}
+
+	if c.Delete {
+		for name := range localEntries {
+			if err := os.RemoveAll(filepath.Join(local, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) pullFile(ctx context.Context, remote, local string,
+	exists bool) (stats deltaStats, err error) {
+	var base io.ReaderAt = emptyReaderAt{}
+	var baseSize int64
+	sig := &signature{blockSize: c.blockSize()}
+	if exists {
+		f, err := os.Open(local)
+		if err != nil {
+			return deltaStats{}, err
+		}
+		defer f.Close()
+		sig, err = computeSignature(f, c.blockSize())
+		if err != nil {
+			return deltaStats{}, err
+		}
+		base, baseSize = f, sig.size
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, remote,
+		url.Values{"delta": {""}}, bytes.NewReader(sig.bytes()))
+	if err != nil {
+		return deltaStats{}, err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := ioutil.TempFile(
+		filepath.Dir(local), "."+filepath.Base(local)+".kbfsdelta-")
+	if err != nil {
+		return deltaStats{}, err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	stats, err = applyDelta(base, baseSize, resp.Body, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return deltaStats{}, err
+	}
+
+	mode := os.FileMode(0644)
+	if resp.Header.Get(executableHeader) == "true" {
+		mode = 0755
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return deltaStats{}, err
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return deltaStats{}, err
+	}
+	if s := resp.Header.Get(mtimeHeader); s != "" {
+		ns, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return deltaStats{}, errors.Errorf("Bad mtime %q", s)
+		}
+		mtime := time.Unix(0, ns)
+		if err := os.Chtimes(local, mtime, mtime); err != nil {
+			return deltaStats{}, err
+		}
+	}
+	return stats, nil
+}
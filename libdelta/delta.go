@@ -0,0 +1,310 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdelta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// A delta turns one version of a file (the base) into another, as a
+// sequence of ops that either copy blocks of the base, or add literal
+// data.  It ends with the SHA-256 hash of the new version, so that a
+// delta made against a stale signature is caught when it's applied.
+const (
+	deltaMagic   = "KBDD"
+	deltaVersion = 1
+
+	opEnd     byte = 0
+	opCopy    byte = 1
+	opLiteral byte = 2
+
+	// maxLiteralLen bounds the size of a single literal op.
+	maxLiteralLen = 64 * 1024
+	// readChunkSize is how much of the new version is read at once
+	// while making a delta.
+	readChunkSize = 64 * 1024
+)
+
+var errBadDelta = errors.New("Malformed delta")
+
+// errDeltaMismatch is returned when applying a delta doesn't result
+// in the file it was made from, usually because the base changed.
+var errDeltaMismatch = errors.New(
+	"Delta doesn't match the file it's applied to")
+
+// deltaStats counts how much of a new version came from each source.
+type deltaStats struct {
+	CopiedBytes  int64
+	LiteralBytes int64
+}
+
+// deltaWriter encodes ops, merging runs of consecutive block copies.
+type deltaWriter struct {
+	w         *bufio.Writer
+	copyStart int
+	copyCount int
+	stats     deltaStats
+}
+
+func newDeltaWriter(w io.Writer, blockSize int) *deltaWriter {
+	dw := &deltaWriter{w: bufio.NewWriter(w)}
+	dw.w.WriteString(deltaMagic)
+	dw.w.WriteByte(deltaVersion)
+	dw.putUvarint(uint64(blockSize))
+	return dw
+}
+
+func (dw *deltaWriter) putUvarint(x uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	dw.w.Write(tmp[:binary.PutUvarint(tmp[:], x)])
+}
+
+func (dw *deltaWriter) flushCopy() {
+	if dw.copyCount == 0 {
+		return
+	}
+	dw.w.WriteByte(opCopy)
+	dw.putUvarint(uint64(dw.copyStart))
+	dw.putUvarint(uint64(dw.copyCount))
+	dw.copyCount = 0
+}
+
+func (dw *deltaWriter) copyBlock(i, n int) {
+	dw.stats.CopiedBytes += int64(n)
+	if dw.copyCount > 0 && dw.copyStart+dw.copyCount == i {
+		dw.copyCount++
+		return
+	}
+	dw.flushCopy()
+	dw.copyStart, dw.copyCount = i, 1
+}
+
+func (dw *deltaWriter) literal(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	dw.flushCopy()
+	dw.stats.LiteralBytes += int64(len(p))
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxLiteralLen {
+			n = maxLiteralLen
+		}
+		dw.w.WriteByte(opLiteral)
+		dw.putUvarint(uint64(n))
+		dw.w.Write(p[:n])
+		p = p[n:]
+	}
+}
+
+func (dw *deltaWriter) end(sum []byte) error {
+	dw.flushCopy()
+	dw.w.WriteByte(opEnd)
+	dw.w.Write(sum)
+	return dw.w.Flush()
+}
+
+// writeDelta writes the delta that turns the file described by `sig`
+// into the contents of `r`.
+func writeDelta(sig *signature, r io.Reader, w io.Writer) (
+	deltaStats, error) {
+	blockSize := sig.blockSize
+	index := make(map[uint32][]int, len(sig.blocks))
+	for i, b := range sig.blocks {
+		index[b.weak] = append(index[b.weak], i)
+	}
+	match := func(weak uint32, p []byte) (int, bool) {
+		candidates, ok := index[weak]
+		if !ok {
+			return 0, false
+		}
+		strong := makeStrongSum(p)
+		for _, i := range candidates {
+			if sig.blockLen(i) == len(p) && sig.blocks[i].strong == strong {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+
+	h := sha256.New()
+	r = io.TeeReader(r, h)
+	dw := newDeltaWriter(w, blockSize)
+
+	// buf[litStart:pos] is literal data not yet written, and the
+	// window being matched starts at pos.
+	var buf []byte
+	litStart, pos := 0, 0
+	eof := false
+	fill := func(need int) error {
+		for !eof && len(buf)-pos < need {
+			if cap(buf)-len(buf) < readChunkSize {
+				// Drop what's already been written before growing.
+				n := copy(buf, buf[litStart:])
+				buf = buf[:n]
+				pos -= litStart
+				litStart = 0
+				if cap(buf)-len(buf) < readChunkSize {
+					newBuf := make(
+						[]byte, len(buf), 2*cap(buf)+readChunkSize)
+					copy(newBuf, buf)
+					buf = newBuf
+				}
+			}
+			n, err := r.Read(buf[len(buf) : len(buf)+readChunkSize])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var rs rollsum
+	valid := false
+	for {
+		if err := fill(blockSize + 1); err != nil {
+			return deltaStats{}, err
+		}
+		n := len(buf) - pos
+		if n < blockSize {
+			// Only the short last block can match what's left.
+			if n > 0 {
+				rs.init(buf[pos:])
+				if i, ok := match(rs.digest(), buf[pos:]); ok {
+					dw.literal(buf[litStart:pos])
+					dw.copyBlock(i, n)
+					litStart = len(buf)
+				}
+			}
+			dw.literal(buf[litStart:])
+			break
+		}
+
+		if !valid {
+			rs.init(buf[pos : pos+blockSize])
+			valid = true
+		}
+		if i, ok := match(rs.digest(), buf[pos:pos+blockSize]); ok {
+			dw.literal(buf[litStart:pos])
+			dw.copyBlock(i, blockSize)
+			pos += blockSize
+			litStart = pos
+			valid = false
+			continue
+		}
+
+		if n > blockSize {
+			rs.roll(buf[pos], buf[pos+blockSize])
+		} else {
+			valid = false
+		}
+		pos++
+		if pos-litStart >= maxLiteralLen {
+			dw.literal(buf[litStart:pos])
+			litStart = pos
+		}
+	}
+	if err := dw.end(h.Sum(nil)); err != nil {
+		return deltaStats{}, err
+	}
+	return dw.stats, nil
+}
+
+// hashingWriter hashes everything written through it.
+type hashingWriter struct {
+	w io.Writer
+	h hash.Hash
+}
+
+func (hw hashingWriter) Write(p []byte) (int, error) {
+	hw.h.Write(p)
+	return hw.w.Write(p)
+}
+
+// applyDelta writes the result of applying the delta in `r` to the
+// `baseSize` bytes of `base` to `w`.  It returns errDeltaMismatch if
+// the result isn't the file the delta was made from.
+func applyDelta(base io.ReaderAt, baseSize int64, r io.Reader,
+	w io.Writer) (deltaStats, error) {
+	br := bufio.NewReader(r)
+	if err := readHeader(br, deltaMagic, deltaVersion); err != nil {
+		return deltaStats{}, errors.WithMessage(errBadDelta, err.Error())
+	}
+	blockSize, err := binary.ReadUvarint(br)
+	if err != nil {
+		return deltaStats{}, errBadDelta
+	}
+	if err := checkBlockSize(int(blockSize)); err != nil {
+		return deltaStats{}, errors.WithMessage(errBadDelta, err.Error())
+	}
+
+	hw := hashingWriter{w: w, h: sha256.New()}
+	var stats deltaStats
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return deltaStats{}, errBadDelta
+		}
+		switch op {
+		case opCopy:
+			start, err := binary.ReadUvarint(br)
+			if err != nil {
+				return deltaStats{}, errBadDelta
+			}
+			count, err := binary.ReadUvarint(br)
+			if err != nil {
+				return deltaStats{}, errBadDelta
+			}
+			off := start * blockSize
+			if start > uint64(baseSize) || count > uint64(baseSize) ||
+				off >= uint64(baseSize) {
+				return deltaStats{}, errDeltaMismatch
+			}
+			n := count * blockSize
+			if off+n > uint64(baseSize) {
+				n = uint64(baseSize) - off
+			}
+			copied, err := io.Copy(hw, io.NewSectionReader(
+				base, int64(off), int64(n)))
+			if err != nil {
+				return deltaStats{}, err
+			}
+			stats.CopiedBytes += copied
+		case opLiteral:
+			n, err := binary.ReadUvarint(br)
+			if err != nil || n > maxLiteralLen {
+				return deltaStats{}, errBadDelta
+			}
+			copied, err := io.CopyN(hw, br, int64(n))
+			if err == io.EOF {
+				return deltaStats{}, errBadDelta
+			} else if err != nil {
+				return deltaStats{}, err
+			}
+			stats.LiteralBytes += copied
+		case opEnd:
+			sum := make([]byte, sha256.Size)
+			if _, err := io.ReadFull(br, sum); err != nil {
+				return deltaStats{}, errBadDelta
+			}
+			if !bytes.Equal(sum, hw.h.Sum(nil)) {
+				return deltaStats{}, errDeltaMismatch
+			}
+			return stats, nil
+		default:
+			return deltaStats{}, errBadDelta
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdelta
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/testutil"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+func randomBytes(t *testing.T, r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	_, err := r.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func checkDelta(t *testing.T, base, target []byte, blockSize int) deltaStats {
+	sig, err := computeSignature(bytes.NewReader(base), blockSize)
+	require.NoError(t, err)
+	sig, err = decodeSignature(bytes.NewReader(sig.bytes()))
+	require.NoError(t, err)
+
+	var delta bytes.Buffer
+	stats, err := writeDelta(sig, bytes.NewReader(target), &delta)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(target)), stats.CopiedBytes+stats.LiteralBytes)
+
+	var out bytes.Buffer
+	applyStats, err := applyDelta(
+		bytes.NewReader(base), int64(len(base)), &delta, &out)
+	require.NoError(t, err)
+	require.Equal(t, stats, applyStats)
+	require.True(t, bytes.Equal(target, out.Bytes()))
+	return stats
+}
+
+func TestDelta(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const blockSize = 1024
+	base := randomBytes(t, r, 300*blockSize+123)
+
+	t.Log("Identical files only need copies")
+	stats := checkDelta(t, base, base, blockSize)
+	require.Equal(t, int64(0), stats.LiteralBytes)
+
+	t.Log("Inserting data in the middle shifts the rest")
+	var target []byte
+	target = append(target, base[:1000]...)
+	target = append(target, []byte("inserted")...)
+	target = append(target, base[1000:]...)
+	stats = checkDelta(t, base, target, blockSize)
+	require.True(t, stats.LiteralBytes < 2*blockSize, "%d", stats.LiteralBytes)
+
+	t.Log("Deleting data and appending to the end")
+	target = append([]byte(nil), base[:50000]...)
+	target = append(target, base[60000:]...)
+	target = append(target, randomBytes(t, r, 5000)...)
+	stats = checkDelta(t, base, target, blockSize)
+	require.True(t, stats.LiteralBytes < 5000+2*blockSize,
+		"%d", stats.LiteralBytes)
+
+	t.Log("Empty and unrelated files")
+	checkDelta(t, nil, base, blockSize)
+	checkDelta(t, base, nil, blockSize)
+	checkDelta(t, nil, nil, blockSize)
+	stats = checkDelta(t, base, randomBytes(t, r, 200000), blockSize)
+	require.Equal(t, int64(0), stats.CopiedBytes)
+
+	t.Log("Applying a delta to the wrong base fails")
+	sig, err := computeSignature(bytes.NewReader(base), blockSize)
+	require.NoError(t, err)
+	var delta bytes.Buffer
+	_, err = writeDelta(sig, bytes.NewReader(target), &delta)
+	require.NoError(t, err)
+	other := append([]byte(nil), base...)
+	other[10] ^= 0xff
+	_, err = applyDelta(bytes.NewReader(other), int64(len(other)),
+		&delta, ioutil.Discard)
+	require.Equal(t, errDeltaMismatch, err)
+}
+
+type testSource struct {
+	root string
+}
+
+func (s testSource) tlfFS(ctx context.Context, tlfType, tlfName string) (
+	billy.Filesystem, error) {
+	root := filepath.Join(s.root, tlfType, tlfName)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return testutil.NewChangeFS(root), nil
+}
+
+func writeTestFile(t *testing.T, p string, data []byte, mode os.FileMode) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, ioutil.WriteFile(p, data, mode))
+	require.NoError(t, os.Chmod(p, mode))
+}
+
+func readTestFile(t *testing.T, p string) []byte {
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	return data
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libdelta")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	remoteRoot := filepath.Join(dir, "remote")
+	local := filepath.Join(dir, "local")
+	ts := httptest.NewServer(newHandler(testSource{remoteRoot}, "secret", nil))
+	defer ts.Close()
+	ctx := context.Background()
+	c := &Client{URL: ts.URL, Token: "secret", BlockSize: 1024}
+
+	r := rand.New(rand.NewSource(1))
+	big := randomBytes(t, r, 100*1024)
+	writeTestFile(t, filepath.Join(local, "big"), big, 0644)
+	writeTestFile(t, filepath.Join(local, "a/b/run.sh"), []byte("#!/bin/sh"), 0755)
+	writeTestFile(t, filepath.Join(local, "a/old"), []byte("old"), 0644)
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "empty"), 0755))
+
+	t.Log("First push sends everything")
+	remote := filepath.Join(remoteRoot, "private", "alice", "backup")
+	stats, err := c.Push(ctx, local, "/keybase/private/alice/backup")
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.FilesSent)
+	require.Equal(t, int64(len(big)+len("#!/bin/sh")+len("old")),
+		stats.LiteralBytes)
+	require.Equal(t, big, readTestFile(t, filepath.Join(remote, "big")))
+	fi, err := os.Stat(filepath.Join(remote, "a/b/run.sh"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	fi, err = os.Stat(filepath.Join(remote, "empty"))
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+
+	t.Log("Pushing again sends nothing")
+	stats, err = c.Push(ctx, local, "private/alice/backup")
+	require.NoError(t, err)
+	require.Equal(t, SyncStats{FilesSkipped: 3}, stats)
+
+	t.Log("A small change sends little data, and deletes are optional")
+	big[50000] ^= 0xff
+	writeTestFile(t, filepath.Join(local, "big"), big, 0644)
+	require.NoError(t, os.Remove(filepath.Join(local, "a/old")))
+	stats, err = c.Push(ctx, local, "private/alice/backup")
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.FilesSent)
+	require.Equal(t, int64(1024), stats.LiteralBytes)
+	require.Equal(t, big, readTestFile(t, filepath.Join(remote, "big")))
+	_, err = os.Stat(filepath.Join(remote, "a/old"))
+	require.NoError(t, err)
+	c.Delete = true
+	_, err = c.Push(ctx, local, "private/alice/backup")
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(remote, "a/old"))
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Pull into a copy with a stale file")
+	copyDir := filepath.Join(dir, "copy")
+	oldBig := append([]byte(nil), big...)
+	oldBig[100] ^= 0xff
+	writeTestFile(t, filepath.Join(copyDir, "big"), oldBig, 0644)
+	writeTestFile(t, filepath.Join(copyDir, "extra"), []byte("x"), 0644)
+	stats, err = c.Pull(ctx, "/keybase/private/alice/backup", copyDir)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.FilesSent)
+	require.Equal(t, int64(1024+len("#!/bin/sh")), stats.LiteralBytes)
+	require.Equal(t, big, readTestFile(t, filepath.Join(copyDir, "big")))
+	fi, err = os.Stat(filepath.Join(copyDir, "a/b/run.sh"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	_, err = os.Stat(filepath.Join(copyDir, "extra"))
+	require.True(t, os.IsNotExist(err))
+	stats, err = c.Pull(ctx, "/keybase/private/alice/backup", copyDir)
+	require.NoError(t, err)
+	require.Equal(t, SyncStats{FilesSkipped: 2}, stats)
+
+	t.Log("Errors")
+	_, err = c.Pull(ctx, "/keybase/private/alice/missing", copyDir)
+	require.True(t, isNotFound(err), "%+v", err)
+	_, err = c.Push(ctx, local, "/keybase/bogus/alice")
+	require.Error(t, err)
+	bad := &Client{URL: ts.URL, Token: "wrong"}
+	_, err = bad.Pull(ctx, "/keybase/private/alice/backup", copyDir)
+	e, ok := errors.Cause(err).(httpError)
+	require.True(t, ok, "%+v", err)
+	require.Equal(t, http.StatusUnauthorized, e.status)
+}
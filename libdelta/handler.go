@@ -0,0 +1,454 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdelta
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	// signatureCacheSize is how many signatures are kept, so that
+	// repeated syncs of big files that haven't changed don't have to
+	// read them again.
+	signatureCacheSize = 1024
+	// maxSignatureSize bounds the signatures clients can upload;
+	// it's enough for files of several GB at the default block size.
+	maxSignatureSize = 64 * 1024 * 1024
+
+	// Headers describing the file behind a delta.
+	mtimeHeader      = "X-Kbfs-Mtime"
+	executableHeader = "X-Kbfs-Executable"
+)
+
+// fsSource gives the handler the filesystem for each TLF.
+type fsSource interface {
+	tlfFS(ctx context.Context, tlfType, tlfName string) (
+		billy.Filesystem, error)
+}
+
+// httpError is an error with the HTTP status to report it with.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e httpError) Error() string {
+	return e.msg
+}
+
+func newHTTPError(status int, format string, args ...interface{}) error {
+	return httpError{status, fmt.Sprintf(format, args...)}
+}
+
+// osErrToStatus returns the HTTP status for the errors a
+// billy.Filesystem returns.
+func osErrToStatus(err error) int {
+	err = errors.Cause(err)
+	if e, ok := err.(httpError); ok {
+		return e.status
+	}
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsExist(err):
+		return http.StatusConflict
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	case err == errBadSignature || err == errBadDelta:
+		return http.StatusBadRequest
+	case err == errDeltaMismatch:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Entry describes one entry of a directory listing.
+type Entry struct {
+	Name string `json:"name"`
+	// Type is "file", "dir", or "symlink".
+	Type       string `json:"type"`
+	Size       int64  `json:"size"`
+	Mtime      int64  `json:"mtime"`
+	Executable bool   `json:"executable,omitempty"`
+}
+
+type entriesByName []Entry
+
+func (e entriesByName) Len() int {
+	return len(e)
+}
+
+func (e entriesByName) Less(i, j int) bool {
+	return e[i].Name < e[j].Name
+}
+
+func (e entriesByName) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+}
+
+func makeEntry(fi os.FileInfo) Entry {
+	e := Entry{Name: fi.Name(), Mtime: fi.ModTime().UnixNano()}
+	switch {
+	case fi.IsDir():
+		e.Type = "dir"
+	case fi.Mode()&os.ModeSymlink != 0:
+		e.Type = "symlink"
+	default:
+		e.Type = "file"
+		e.Size = fi.Size()
+		e.Executable = fi.Mode()&0100 != 0
+	}
+	return e
+}
+
+type tlfPath struct {
+	tlfType string
+	tlfName string
+	// p is the path within the TLF, starting with "/".
+	p string
+}
+
+func parsePath(p string) (tlfPath, error) {
+	p = path.Clean("/" + p)
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(parts) < 2 {
+		return tlfPath{}, newHTTPError(
+			http.StatusBadRequest, "%q isn't in a TLF", p)
+	}
+	switch parts[0] {
+	case "private", "public", "team":
+	default:
+		return tlfPath{}, newHTTPError(
+			http.StatusBadRequest, "%q isn't a TLF type", parts[0])
+	}
+	tp := tlfPath{tlfType: parts[0], tlfName: parts[1], p: "/"}
+	if len(parts) == 3 {
+		tp.p = "/" + parts[2]
+	}
+	return tp, nil
+}
+
+type cachedSignature struct {
+	size  int64
+	mtime time.Time
+	sig   []byte
+}
+
+// handler serves the delta-transfer API:
+//
+//	GET    /<type>/<tlf>/<path>             the file's contents
+//	GET    /<type>/<tlf>/<dir>?list         a JSON array of Entries
+//	GET    /<type>/<tlf>/<path>?signature   the file's signature
+//	POST   /<type>/<tlf>/<path>?delta       the delta from the signature
+//	                                        in the body to the file
+//	POST   /<type>/<tlf>/<path>?patch       applies the delta in the body
+//	POST   /<type>/<tlf>/<dir>?mkdir        creates the directory
+//	DELETE /<type>/<tlf>/<path>[?recursive] removes the entry
+//
+// Signatures are made with the block size in the block_size query
+// parameter, or DefaultBlockSize.  A patch may set the file's
+// executable bit with exec=1, and its modification time with
+// mtime=<ns since the epoch>.
+type handler struct {
+	source      fsSource
+	token       string
+	errToStatus func(error) int
+	signatures  *lru.Cache
+}
+
+var _ http.Handler = (*handler)(nil)
+
+func newHandler(source fsSource, token string,
+	errToStatus func(error) int) *handler {
+	if errToStatus == nil {
+		errToStatus = osErrToStatus
+	}
+	// lru.New only fails for non-positive sizes.
+	signatures, _ := lru.New(signatureCacheSize)
+	return &handler{
+		source:      source,
+		token:       token,
+		errToStatus: errToStatus,
+		signatures:  signatures,
+	}
+}
+
+func (h *handler) checkToken(r *http.Request) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(h.token)) == 1
+}
+
+func (h *handler) list(w http.ResponseWriter, fs billy.Filesystem,
+	p string) error {
+	fis, err := fs.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	entries := make([]Entry, 0, len(fis))
+	for _, fi := range fis {
+		entries = append(entries, makeEntry(fi))
+	}
+	sort.Sort(entriesByName(entries))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(entries)
+}
+
+func (h *handler) openFile(fs billy.Filesystem, p string) (
+	billy.File, os.FileInfo, error) {
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		return nil, nil, newHTTPError(
+			http.StatusConflict, "%s is a directory", p)
+	}
+	f, err := fs.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+func (h *handler) getSignature(fs billy.Filesystem, tp tlfPath,
+	blockSize int) ([]byte, error) {
+	f, fi, err := h.openFile(fs, tp.p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	key := fmt.Sprintf("%s/%s%s:%d", tp.tlfType, tp.tlfName, tp.p, blockSize)
+	if v, ok := h.signatures.Get(key); ok {
+		cached := v.(cachedSignature)
+		if cached.size == fi.Size() && cached.mtime.Equal(fi.ModTime()) {
+			return cached.sig, nil
+		}
+	}
+	sig, err := computeSignature(f, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	b := sig.bytes()
+	h.signatures.Add(key, cachedSignature{fi.Size(), fi.ModTime(), b})
+	return b, nil
+}
+
+func (h *handler) delta(w http.ResponseWriter, r *http.Request,
+	fs billy.Filesystem, p string) error {
+	sig, err := decodeSignature(io.LimitReader(r.Body, maxSignatureSize))
+	if err != nil {
+		return err
+	}
+	f, fi, err := h.openFile(fs, p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(mtimeHeader, strconv.FormatInt(fi.ModTime().UnixNano(), 10))
+	w.Header().Set(executableHeader, strconv.FormatBool(fi.Mode()&0100 != 0))
+	// Once the delta starts, errors can only be reported by cutting
+	// it short, which the client notices.
+	_, err = writeDelta(sig, f, w)
+	return err
+}
+
+func (h *handler) patch(r *http.Request, fs billy.Filesystem,
+	p string) (err error) {
+	if p == "/" {
+		return newHTTPError(http.StatusConflict, "Can't patch a TLF root")
+	}
+	dir := path.Dir(p)
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var base io.ReaderAt = emptyReaderAt{}
+	var baseSize int64
+	f, fi, err := h.openFile(fs, p)
+	switch {
+	case err == nil:
+		defer f.Close()
+		base, baseSize = f, fi.Size()
+	case os.IsNotExist(errors.Cause(err)):
+	default:
+		return err
+	}
+
+	tmp, err := util.TempFile(fs, dir, "."+path.Base(p)+".kbfsdelta-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			fs.Remove(tmp.Name())
+		}
+	}()
+	_, err = applyDelta(base, baseSize, r.Body, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := fs.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+
+	if change, ok := fs.(billy.Change); ok {
+		mode := os.FileMode(0644)
+		if r.URL.Query().Get("exec") == "1" {
+			mode = 0755
+		}
+		if err := change.Chmod(p, mode); err != nil {
+			return err
+		}
+		if s := r.URL.Query().Get("mtime"); s != "" {
+			ns, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return newHTTPError(
+					http.StatusBadRequest, "Bad mtime %q", s)
+			}
+			mtime := time.Unix(0, ns)
+			if err := change.Chtimes(p, mtime, mtime); err != nil {
+				return err
+			}
+		}
+	}
+	return libfs.SyncFS(fs)
+}
+
+func (h *handler) remove(r *http.Request, fs billy.Filesystem,
+	p string) error {
+	if p == "/" {
+		return newHTTPError(http.StatusConflict, "Can't remove a TLF root")
+	}
+	if _, err := fs.Lstat(p); err != nil {
+		return err
+	}
+	var err error
+	if _, ok := r.URL.Query()["recursive"]; ok {
+		err = util.RemoveAll(fs, p)
+	} else {
+		err = fs.Remove(p)
+	}
+	if err != nil {
+		return err
+	}
+	return libfs.SyncFS(fs)
+}
+
+func (h *handler) serve(w http.ResponseWriter, r *http.Request) error {
+	tp, err := parsePath(r.URL.Path)
+	if err != nil {
+		return err
+	}
+	fs, err := h.source.tlfFS(r.Context(), tp.tlfType, tp.tlfName)
+	if err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	_, list := q["list"]
+	_, getSig := q["signature"]
+	_, delta := q["delta"]
+	_, patch := q["patch"]
+	_, mkdir := q["mkdir"]
+
+	switch {
+	case r.Method == http.MethodGet && list:
+		return h.list(w, fs, tp.p)
+	case r.Method == http.MethodGet && getSig:
+		blockSize := DefaultBlockSize
+		if s := q.Get("block_size"); s != "" {
+			blockSize, err = strconv.Atoi(s)
+			if err != nil {
+				return newHTTPError(
+					http.StatusBadRequest, "Bad block size %q", s)
+			}
+			if err := checkBlockSize(blockSize); err != nil {
+				return newHTTPError(http.StatusBadRequest, "%v", err)
+			}
+		}
+		sig, err := h.getSignature(fs, tp, blockSize)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, err = w.Write(sig)
+		return err
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		f, fi, err := h.openFile(fs, tp.p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		return nil
+	case r.Method == http.MethodPost && delta:
+		return h.delta(w, r, fs, tp.p)
+	case r.Method == http.MethodPost && patch:
+		if err := h.patch(r, fs, tp.p); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case r.Method == http.MethodPost && mkdir:
+		if err := fs.MkdirAll(tp.p, 0755); err != nil {
+			return err
+		}
+		if err := libfs.SyncFS(fs); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case r.Method == http.MethodDelete:
+		if err := h.remove(r, fs, tp.p); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		return newHTTPError(http.StatusMethodNotAllowed,
+			"%s isn't supported here", r.Method)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface for handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.checkToken(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if err := h.serve(w, r); err != nil {
+		http.Error(w, err.Error(), h.errToStatus(err))
+	}
+}
+
+// emptyReaderAt is the base for patches that create files.
+type emptyReaderAt struct{}
+
+func (emptyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return 0, io.EOF
+}
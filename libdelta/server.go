@@ -0,0 +1,103 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdelta
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// Debug tag ID for a delta-transfer request.
+const ctxOpID = "DELTA"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+// Server serves the delta-transfer API to clients that present its
+// token, so that Clients can sync trees into and out of KBFS while
+// sending only the data that changed.  See handler for the API.
+type Server struct {
+	config  libkbfs.Config
+	log     logger.Logger
+	handler *handler
+	fs      *libfs.TlfFSCache
+}
+
+var _ http.Handler = (*Server)(nil)
+var _ fsSource = (*Server)(nil)
+
+// NewServer returns a new Server that accepts requests carrying
+// `token`.
+func NewServer(config libkbfs.Config, token string) (*Server, error) {
+	if token == "" {
+		return nil, errors.New("No token given")
+	}
+	s := &Server{
+		config: config,
+		log:    config.MakeLogger("DELTA"),
+		fs:     libfs.NewTlfFSCache(config),
+	}
+	s.handler = newHandler(s, token, errToStatus)
+	return s, nil
+}
+
+// errToStatus returns the HTTP status for KBFS errors.
+func errToStatus(err error) int {
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError, libkbfs.NoSuchUserError,
+		libkbfs.NoSuchTeamError:
+		return http.StatusNotFound
+	case libkbfs.ReadAccessError, libkbfs.WriteAccessError,
+		libkbfs.TlfAccessError, libkbfs.WriteUnsupportedError,
+		libkbfs.WriteToReadonlyNodeError:
+		return http.StatusForbidden
+	case libkbfs.DirNotEmptyError, libkbfs.NotDirError,
+		libkbfs.NotFileError:
+		return http.StatusConflict
+	default:
+		return osErrToStatus(err)
+	}
+}
+
+func (s *Server) newCtx(ctx context.Context) context.Context {
+	return libkbfs.CtxWithRandomIDReplayable(ctx, ctxIDKey, ctxOpID, s.log)
+}
+
+// getFS returns the shared FS for the TLF named in a request URL.
+// An unknown TLF type in the URL is a bad request.
+func (s *Server) getFS(tlfType, tlfName string) (*libfs.FS, error) {
+	t, err := tlf.ParseTlfTypeFromPath(tlfType)
+	if err != nil {
+		return nil, newHTTPError(http.StatusBadRequest, "%v", err)
+	}
+	return s.fs.Get(s.newCtx(context.Background()), t, tlfName)
+}
+
+func (s *Server) tlfFS(ctx context.Context, tlfType, tlfName string) (
+	billy.Filesystem, error) {
+	fs, err := s.getFS(tlfType, tlfName)
+	if err != nil {
+		return nil, err
+	}
+	// Use the request's context, so that KBFS operations stop when
+	// the client goes away.
+	return fs.WithContext(s.newCtx(ctx)), nil
+}
+
+// ServeHTTP implements the http.Handler interface for Server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.log.Debug("%s %s", r.Method, r.URL)
+	s.handler.ServeHTTP(w, r)
+}
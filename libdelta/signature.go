@@ -0,0 +1,196 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdelta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultBlockSize is the block size used for signatures when
+	// the client doesn't ask for one.
+	DefaultBlockSize = 8 * 1024
+	minBlockSize     = 512
+	maxBlockSize     = 1024 * 1024
+
+	// strongSumLen is how much of each block's SHA-256 hash is kept
+	// in a signature.  Collisions are caught by the whole-file hash
+	// at the end of each delta, so this doesn't need to be long.
+	strongSumLen = 16
+
+	signatureMagic   = "KBDS"
+	signatureVersion = 1
+)
+
+var errBadSignature = errors.New("Malformed signature")
+
+// checkBlockSize makes sure a block size is one we're willing to use.
+func checkBlockSize(blockSize int) error {
+	if blockSize < minBlockSize || blockSize > maxBlockSize {
+		return errors.Errorf("Block size %d is not between %d and %d",
+			blockSize, minBlockSize, maxBlockSize)
+	}
+	return nil
+}
+
+// rollsum is the rolling checksum from rsync, which can be moved
+// along a buffer one byte at a time.
+type rollsum struct {
+	a, b uint32
+	n    uint32
+}
+
+func (r *rollsum) init(p []byte) {
+	r.a, r.b, r.n = 0, 0, uint32(len(p))
+	for i, x := range p {
+		r.a += uint32(x)
+		r.b += (r.n - uint32(i)) * uint32(x)
+	}
+}
+
+// roll moves the window forward by one byte, dropping `out` and
+// adding `in`.
+func (r *rollsum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r *rollsum) digest() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+type strongSum [strongSumLen]byte
+
+func makeStrongSum(p []byte) (s strongSum) {
+	h := sha256.Sum256(p)
+	copy(s[:], h[:])
+	return s
+}
+
+type blockSum struct {
+	weak   uint32
+	strong strongSum
+}
+
+// signature describes the blocks of a file, so that the other side
+// can work out which parts of its version of the file are already
+// there.
+type signature struct {
+	blockSize int
+	size      int64
+	blocks    []blockSum
+}
+
+// blockLen returns the length of block `i`; only the last one can be
+// short.
+func (s *signature) blockLen(i int) int {
+	if i == len(s.blocks)-1 {
+		if rem := int(s.size % int64(s.blockSize)); rem != 0 {
+			return rem
+		}
+	}
+	return s.blockSize
+}
+
+// computeSignature reads all of `r` and returns its signature.
+func computeSignature(r io.Reader, blockSize int) (*signature, error) {
+	if err := checkBlockSize(blockSize); err != nil {
+		return nil, err
+	}
+	sig := &signature{blockSize: blockSize}
+	buf := make([]byte, blockSize)
+	var rs rollsum
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			rs.init(buf[:n])
+			sig.blocks = append(sig.blocks, blockSum{
+				weak:   rs.digest(),
+				strong: makeStrongSum(buf[:n]),
+			})
+			sig.size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *signature) encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(signatureMagic)
+	bw.WriteByte(signatureVersion)
+	var tmp [binary.MaxVarintLen64]byte
+	bw.Write(tmp[:binary.PutUvarint(tmp[:], uint64(s.blockSize))])
+	bw.Write(tmp[:binary.PutUvarint(tmp[:], uint64(s.size))])
+	for _, b := range s.blocks {
+		binary.BigEndian.PutUint32(tmp[:4], b.weak)
+		bw.Write(tmp[:4])
+		bw.Write(b.strong[:])
+	}
+	return bw.Flush()
+}
+
+func (s *signature) bytes() []byte {
+	var buf bytes.Buffer
+	// Writes to a bytes.Buffer can't fail.
+	_ = s.encode(&buf)
+	return buf.Bytes()
+}
+
+// readHeader checks the magic and version at the start of a
+// signature or delta.
+func readHeader(br *bufio.Reader, magic string, version byte) error {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return err
+	}
+	if string(header[:len(magic)]) != magic {
+		return errors.New("Bad magic number")
+	}
+	if header[len(magic)] != version {
+		return errors.Errorf("Unsupported version %d", header[len(magic)])
+	}
+	return nil
+}
+
+func decodeSignature(r io.Reader) (*signature, error) {
+	br := bufio.NewReader(r)
+	if err := readHeader(br, signatureMagic, signatureVersion); err != nil {
+		return nil, errors.WithMessage(errBadSignature, err.Error())
+	}
+	blockSize, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, errBadSignature
+	}
+	if err := checkBlockSize(int(blockSize)); err != nil {
+		return nil, errors.WithMessage(errBadSignature, err.Error())
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil || int64(size) < 0 {
+		return nil, errBadSignature
+	}
+	sig := &signature{blockSize: int(blockSize), size: int64(size)}
+	numBlocks := (size + blockSize - 1) / blockSize
+	var tmp [4 + strongSumLen]byte
+	for i := uint64(0); i < numBlocks; i++ {
+		if _, err := io.ReadFull(br, tmp[:]); err != nil {
+			return nil, errBadSignature
+		}
+		var b blockSum
+		b.weak = binary.BigEndian.Uint32(tmp[:4])
+		copy(b.strong[:], tmp[4:])
+		sig.blocks = append(sig.blocks, b)
+	}
+	return sig, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdelta
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// StartOptions are options for starting up.
+type StartOptions struct {
+	KbfsParams libkbfs.InitParams
+	// ListenAddr is the loopback address to serve the API on.
+	ListenAddr string
+	// Token is the bearer token clients must present.
+	Token string
+}
+
+// Start starts KBFS and serves the delta-transfer API until
+// interrupted.
+func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
+	err := libfs.CheckLoopbackAddr(options.ListenAddr)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
+	return libfs.StartServer(options.KbfsParams, kbCtx, func(
+		config libkbfs.Config, log logger.Logger) (libfs.Mounter, error) {
+		s, err := NewServer(config, options.Token)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", options.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Serving delta transfers on http://%s/", listener.Addr())
+		return libfs.NewListenerMounter(&http.Server{Handler: s}, listener), nil
+	})
+}
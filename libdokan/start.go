@@ -14,6 +14,8 @@ import (
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/go-framed-msgpack-rpc/rpc"
 	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libarchive"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libgit"
 	"github.com/keybase/kbfs/libkbfs"
@@ -51,6 +53,12 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 		return keybase1.SimpleFSProtocol(
 			simplefs.NewSimpleFS(libkbfsCtx.GetGlobalContext(), config)), nil
 	}
+	// Hook the KBFS fs protocol in, for listing, completing and
+	// statting paths.
+	createFSRPC := func(
+		libkbfsCtx libkbfs.Context, config libkbfs.Config) (rpc.Protocol, error) {
+		return fsrpc.Protocol(fsrpc.NewFS(config, config.MakeLogger(""))), nil
+	}
 	// Hook git implementation in.
 	shutdownGit := func() {}
 	createGitHandler := func(
@@ -64,9 +72,9 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 		shutdownGit()
 	}()
 
-	// Patch the kbfsParams to inject two additional protocols.
+	// Patch the kbfsParams to inject three additional protocols.
 	options.KbfsParams.AdditionalProtocolCreators = []libkbfs.AdditionalProtocolCreator{
-		createSimpleFS, createGitHandler,
+		createSimpleFS, createFSRPC, createGitHandler,
 	}
 
 	log, err := libkbfs.InitLog(options.KbfsParams, kbCtx)
@@ -84,6 +92,13 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 
 	defer libkbfs.Shutdown()
 
+	if options.KbfsParams.BrowseArchives {
+		_, err = libarchive.Enable(config)
+		if err != nil {
+			return libfs.InitError(err.Error())
+		}
+	}
+
 	if options.RuntimeDir != "" {
 		info := libkb.NewServiceInfo(libkbfs.Version, libkbfs.PrereleaseBuild, options.Label, os.Getpid())
 		err = info.WriteFile(path.Join(options.RuntimeDir, "kbfs.info"), log)
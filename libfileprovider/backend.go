@@ -0,0 +1,443 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package libfileprovider implements the backend of a macOS
+// FileProvider extension for KBFS: enumerating items and changes to
+// them, materializing files on demand, and queueing local files for
+// upload.  The extension itself is expected to reach a Backend over
+// RPC (see the SimpleFSFileProvider* methods in simplefs), and to
+// hold on to the sync anchors and identifiers it returns.
+package libfileprovider
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// DefaultPageSize is the number of items Enumerate returns per
+	// page, unless told otherwise.
+	DefaultPageSize = 200
+	// maxSnapshots is the number of container snapshots kept around
+	// for sync anchors; older anchors expire.
+	maxSnapshots = 1000
+)
+
+// Source provides the TLFs a Backend serves.  `tlfType` is always
+// one of "private", "public" or "team".
+type Source interface {
+	// TLFNames returns the names of the TLFs of the given type to
+	// list (e.g., the user's favorites).
+	TLFNames(ctx context.Context, tlfType string) ([]string, error)
+	// TLFFS returns the filesystem of the given TLF.
+	TLFFS(ctx context.Context, tlfType, tlfName string) (
+		billy.Filesystem, error)
+	// UnflushedPaths returns the set of slash-separated paths, within
+	// the given TLF, that have changes that haven't reached the
+	// servers yet.
+	UnflushedPaths(ctx context.Context, tlfType, tlfName string) (
+		map[string]bool, error)
+	// WaitForUpload waits until all the changes made so far to the
+	// given TLF have reached the servers.
+	WaitForUpload(ctx context.Context, tlfType, tlfName string) error
+}
+
+type syncer interface {
+	SyncAll() error
+}
+
+func syncFS(fs billy.Filesystem) error {
+	if s, ok := fs.(syncer); ok {
+		return s.SyncAll()
+	}
+	return nil
+}
+
+// Page is one page of the items in a container.
+type Page struct {
+	Items []Item `codec:"items" json:"items"`
+	// NextPage is the page to ask for next, or empty if this is
+	// the last page.
+	NextPage string `codec:"nextPage" json:"nextPage,omitempty"`
+}
+
+// Changes are the changes to the items in a container since a sync
+// anchor.
+type Changes struct {
+	Updated []Item   `codec:"updated" json:"updated"`
+	Deleted []string `codec:"deleted" json:"deleted"`
+	// Anchor is the sync anchor to ask for the next changes with.
+	Anchor string `codec:"anchor" json:"anchor"`
+}
+
+// ErrSyncAnchorExpired is returned by Changes for anchors it no
+// longer (or never did) know about; the extension should enumerate
+// the container from scratch.
+var ErrSyncAnchorExpired = errors.New("sync anchor expired")
+
+// snapshot records the versions of a container's items at the time
+// a sync anchor was handed out.
+type snapshot struct {
+	container string
+	versions  map[string]string
+}
+
+// Options are the options for a Backend.
+type Options struct {
+	// OnUpload, if set, is called after every state change of an
+	// upload, outside of any Backend locks.
+	OnUpload func(Upload)
+}
+
+// Backend serves a FileProvider extension.
+type Backend struct {
+	source  Source
+	options Options
+
+	snapshots *lru.Cache // anchor string -> snapshot
+
+	lock       sync.Mutex
+	nextAnchor uint64
+	uploads    uploadQueue
+}
+
+// NewBackend returns a new Backend serving the TLFs in `source`.
+// Queued uploads are processed using a child of `ctx`.  Shutdown must
+// be called when it's no longer needed.
+func NewBackend(ctx context.Context, source Source, options Options) (
+	*Backend, error) {
+	snapshots, err := lru.New(maxSnapshots)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backend{
+		source:    source,
+		options:   options,
+		snapshots: snapshots,
+	}
+	b.uploads.init()
+	b.startUploads(ctx)
+	return b, nil
+}
+
+// Shutdown stops processing uploads.  Uploads still in the queue are
+// failed.
+func (b *Backend) Shutdown() {
+	b.shutdownUploads()
+}
+
+// unflushed returns the unflushed paths of the TLF of `id`.  If they
+// can't be determined, everything is reported as uploaded, since
+// that only affects the progress the extension shows.
+func (b *Backend) unflushed(ctx context.Context, id itemID) map[string]bool {
+	paths, err := b.source.UnflushedPaths(ctx, id.tlfType, id.tlfName)
+	if err != nil {
+		return nil
+	}
+	return paths
+}
+
+// item returns the item for `id`, given the unflushed paths of its
+// TLF (if it's in one).
+func (b *Backend) item(ctx context.Context, id itemID,
+	unflushed map[string]bool) (Item, error) {
+	if !id.inTLF() {
+		return containerItem(id), nil
+	}
+	fs, err := b.source.TLFFS(ctx, id.tlfType, id.tlfName)
+	if err != nil {
+		return Item{}, err
+	}
+	fi, err := fs.Lstat(id.fsPath())
+	if os.IsNotExist(errors.Cause(err)) {
+		return Item{}, errors.WithStack(ErrNoSuchItem)
+	} else if err != nil {
+		return Item{}, err
+	}
+	item := makeItem(id, fi, !unflushed[id.p])
+	if item.Type == ItemSymlink {
+		item.SymlinkTarget, err = fs.Readlink(id.fsPath())
+		if err != nil {
+			return Item{}, err
+		}
+	}
+	return item, nil
+}
+
+// Item returns the item with the given identifier.
+func (b *Backend) Item(ctx context.Context, identifier string) (
+	Item, error) {
+	id, err := parseID(identifier)
+	if err != nil {
+		return Item{}, err
+	}
+	var unflushed map[string]bool
+	if id.inTLF() {
+		unflushed = b.unflushed(ctx, id)
+	}
+	return b.item(ctx, id, unflushed)
+}
+
+type itemsByFilename []Item
+
+func (l itemsByFilename) Len() int           { return len(l) }
+func (l itemsByFilename) Less(i, j int) bool { return l[i].Filename < l[j].Filename }
+func (l itemsByFilename) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// children returns all the items in the container `id`, sorted by
+// filename.
+func (b *Backend) children(ctx context.Context, id itemID) (
+	[]Item, error) {
+	var items []Item
+	switch {
+	case id.tlfType == "":
+		for _, t := range tlfTypes {
+			items = append(items, containerItem(id.child(t)))
+		}
+	case !id.inTLF():
+		names, err := b.source.TLFNames(ctx, id.tlfType)
+		if err != nil {
+			return nil, err
+		}
+		// Don't look inside the TLFs just to list them, since that
+		// would initialize each one.
+		for _, name := range names {
+			items = append(items, containerItem(id.child(name)))
+		}
+	default:
+		fs, err := b.source.TLFFS(ctx, id.tlfType, id.tlfName)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := fs.Lstat(id.fsPath())
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil, errors.WithStack(ErrNoSuchItem)
+		} else if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, errors.WithStack(ErrNotAContainer)
+		}
+		fis, err := fs.ReadDir(id.fsPath())
+		if err != nil {
+			return nil, err
+		}
+		unflushed := b.unflushed(ctx, id)
+		for _, fi := range fis {
+			if strings.HasPrefix(fi.Name(), uploadTempPrefix) {
+				continue
+			}
+			childID := id.child(fi.Name())
+			item := makeItem(childID, fi, !unflushed[childID.p])
+			if item.Type == ItemSymlink {
+				item.SymlinkTarget, err = fs.Readlink(childID.fsPath())
+				if err != nil {
+					return nil, err
+				}
+			}
+			items = append(items, item)
+		}
+	}
+	sort.Sort(itemsByFilename(items))
+	return items, nil
+}
+
+// Enumerate returns a page of the items in the given container.
+// `page` is empty for the first page, and the previous page's
+// NextPage otherwise.  If `pageSize` isn't positive, DefaultPageSize
+// is used.
+func (b *Backend) Enumerate(ctx context.Context, container string,
+	page string, pageSize int) (Page, error) {
+	id, err := parseID(container)
+	if err != nil {
+		return Page{}, err
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	items, err := b.children(ctx, id)
+	if err != nil {
+		return Page{}, err
+	}
+	// Pages pick up after the last filename of the previous page,
+	// so that changes between pages don't shift items between them.
+	start := sort.Search(len(items), func(i int) bool {
+		return items[i].Filename > page
+	})
+	end := start + pageSize
+	if end >= len(items) {
+		return Page{Items: items[start:]}, nil
+	}
+	return Page{
+		Items:    items[start:end],
+		NextPage: items[end-1].Filename,
+	}, nil
+}
+
+// takeSnapshot records the current versions of `items` in
+// `container`, and returns the anchor for them.
+func (b *Backend) takeSnapshot(container string, items []Item) string {
+	s := snapshot{
+		container: container,
+		versions:  make(map[string]string, len(items)),
+	}
+	for _, item := range items {
+		s.versions[item.Identifier] = item.Version
+	}
+	b.lock.Lock()
+	b.nextAnchor++
+	anchor := strconv.FormatUint(b.nextAnchor, 10)
+	b.lock.Unlock()
+	b.snapshots.Add(anchor, s)
+	return anchor
+}
+
+// CurrentAnchor returns a sync anchor for the current state of the
+// given container, to pass to Changes later.
+func (b *Backend) CurrentAnchor(ctx context.Context, container string) (
+	string, error) {
+	id, err := parseID(container)
+	if err != nil {
+		return "", err
+	}
+	items, err := b.children(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return b.takeSnapshot(id.String(), items), nil
+}
+
+// Changes returns the changes to the items in the given container
+// since `anchor` was returned for it, along with a new anchor.
+func (b *Backend) Changes(ctx context.Context, container string,
+	anchor string) (Changes, error) {
+	id, err := parseID(container)
+	if err != nil {
+		return Changes{}, err
+	}
+	v, ok := b.snapshots.Get(anchor)
+	if !ok {
+		return Changes{}, errors.WithStack(ErrSyncAnchorExpired)
+	}
+	old := v.(snapshot)
+	if old.container != id.String() {
+		return Changes{}, errors.WithStack(ErrSyncAnchorExpired)
+	}
+
+	items, err := b.children(ctx, id)
+	if errors.Cause(err) == ErrNoSuchItem {
+		// The container itself is gone, so everything in it is too.
+		items = nil
+	} else if err != nil {
+		return Changes{}, err
+	}
+	changes := Changes{
+		Updated: []Item{},
+		Deleted: []string{},
+	}
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		seen[item.Identifier] = true
+		if version, ok := old.versions[item.Identifier]; !ok ||
+			version != item.Version {
+			changes.Updated = append(changes.Updated, item)
+		}
+	}
+	for identifier := range old.versions {
+		if !seen[identifier] {
+			changes.Deleted = append(changes.Deleted, identifier)
+		}
+	}
+	sort.Strings(changes.Deleted)
+	changes.Anchor = b.takeSnapshot(id.String(), items)
+	return changes, nil
+}
+
+// Materialize copies the contents of the given file into the local
+// file at `localPath`, replacing it atomically, and returns the item
+// as of the copy.
+func (b *Backend) Materialize(ctx context.Context, identifier string,
+	localPath string) (item Item, err error) {
+	id, err := parseID(identifier)
+	if err != nil {
+		return Item{}, err
+	}
+	if !id.inTLF() {
+		return Item{}, errors.Errorf("%s is not a file", identifier)
+	}
+	fs, err := b.source.TLFFS(ctx, id.tlfType, id.tlfName)
+	if err != nil {
+		return Item{}, err
+	}
+	item, err = b.item(ctx, id, b.unflushed(ctx, id))
+	if err != nil {
+		return Item{}, err
+	}
+	if item.Type != ItemFile {
+		return Item{}, errors.Errorf("%s is not a file", identifier)
+	}
+
+	src, err := fs.Open(id.fsPath())
+	if err != nil {
+		return Item{}, err
+	}
+	defer src.Close()
+
+	dst, err := ioutil.TempFile(
+		filepath.Dir(localPath), "."+filepath.Base(localPath))
+	if err != nil {
+		return Item{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = dst.Close()
+			_ = os.Remove(dst.Name())
+		}
+	}()
+	if _, err := io.Copy(dst, contextReader{ctx, src}); err != nil {
+		return Item{}, err
+	}
+	mode := os.FileMode(0644)
+	if item.Executable {
+		mode = 0755
+	}
+	if err := dst.Chmod(mode); err != nil {
+		return Item{}, err
+	}
+	if err := dst.Close(); err != nil {
+		return Item{}, err
+	}
+	if err := os.Chtimes(dst.Name(), item.Mtime, item.Mtime); err != nil {
+		return Item{}, err
+	}
+	if err := os.Rename(dst.Name(), localPath); err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// contextReader stops reading once its context is done, so that
+// large copies can be canceled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
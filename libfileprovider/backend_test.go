@@ -0,0 +1,282 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfileprovider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/keybase/kbfs/testutil"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// testSource serves private TLFs out of subdirectories of a local
+// directory.
+type testSource struct {
+	root string
+
+	lock      sync.Mutex
+	unflushed map[string]bool
+	uploadCh  chan struct{} // if set, WaitForUpload waits on it
+}
+
+var _ Source = (*testSource)(nil)
+
+func (s *testSource) TLFNames(ctx context.Context, tlfType string) (
+	[]string, error) {
+	if tlfType != "private" {
+		return nil, nil
+	}
+	fis, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names, nil
+}
+
+func (s *testSource) TLFFS(ctx context.Context, tlfType, tlfName string) (
+	billy.Filesystem, error) {
+	root := filepath.Join(s.root, tlfName)
+	if tlfType != "private" {
+		return nil, errors.New("no such TLF")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return testutil.NewChangeFS(root), nil
+}
+
+func (s *testSource) UnflushedPaths(
+	ctx context.Context, tlfType, tlfName string) (map[string]bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.unflushed, nil
+}
+
+func (s *testSource) WaitForUpload(
+	ctx context.Context, tlfType, tlfName string) error {
+	s.lock.Lock()
+	ch := s.uploadCh
+	s.lock.Unlock()
+	if ch == nil {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func writeTestFile(t *testing.T, p string, data string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, ioutil.WriteFile(p, []byte(data), 0644))
+}
+
+func filenames(items []Item) []string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.Filename)
+	}
+	return names
+}
+
+func TestItemIDs(t *testing.T) {
+	for _, id := range []string{
+		"/", "/private", "/team", "/private/alice",
+		"/private/alice/a", "/public/bob/a/b",
+	} {
+		parsed, err := parseID(id)
+		require.NoError(t, err, id)
+		require.Equal(t, id, parsed.String())
+	}
+	for _, id := range []string{
+		"", "private", "/secret/alice", "/private/", "/private/alice/../bob",
+	} {
+		_, err := parseID(id)
+		require.Equal(t, ErrNoSuchItem, errors.Cause(err), id)
+	}
+
+	id, err := parseID("/private/alice/a/b")
+	require.NoError(t, err)
+	require.Equal(t, "/private/alice/a", id.parent().String())
+	require.Equal(t, "/private/alice", id.parent().parent().String())
+	require.Equal(t, "/private", id.parent().parent().parent().String())
+	require.Equal(t, "/", id.parent().parent().parent().parent().String())
+	require.Equal(t, "b", id.name())
+	require.Equal(t, "/private/alice/a/b/c", id.child("c").String())
+}
+
+func TestEnumerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libfileprovider")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "tlfs")
+	writeTestFile(t, filepath.Join(root, "alice", "a"), "a")
+	writeTestFile(t, filepath.Join(root, "alice", "b"), "bb")
+	writeTestFile(t, filepath.Join(root, "alice", "c", "d"), "ddd")
+	require.NoError(t, os.Symlink("a", filepath.Join(root, "alice", "e")))
+	writeTestFile(t, filepath.Join(root, "bob", "x"), "x")
+	source := &testSource{root: root}
+
+	b, err := NewBackend(context.Background(), source, Options{})
+	require.NoError(t, err)
+	defer b.Shutdown()
+	ctx := context.Background()
+
+	page, err := b.Enumerate(ctx, RootIdentifier, "", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"private", "public", "team"},
+		filenames(page.Items))
+	require.Equal(t, "", page.NextPage)
+
+	page, err = b.Enumerate(ctx, "/private", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob"}, filenames(page.Items))
+
+	page, err = b.Enumerate(ctx, "/private/alice", "", 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, filenames(page.Items))
+	require.Equal(t, "c", page.NextPage)
+	require.Equal(t, ItemFile, page.Items[0].Type)
+	require.Equal(t, int64(2), page.Items[1].Size)
+	require.Equal(t, ItemFolder, page.Items[2].Type)
+	require.Equal(t, "/private/alice", page.Items[2].ParentIdentifier)
+	page, err = b.Enumerate(ctx, "/private/alice", page.NextPage, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"e"}, filenames(page.Items))
+	require.Equal(t, ItemSymlink, page.Items[0].Type)
+	require.Equal(t, "a", page.Items[0].SymlinkTarget)
+	require.Equal(t, "", page.NextPage)
+
+	_, err = b.Enumerate(ctx, "/private/alice/a", "", 0)
+	require.Equal(t, ErrNotAContainer, errors.Cause(err))
+	_, err = b.Item(ctx, "/private/alice/nope")
+	require.Equal(t, ErrNoSuchItem, errors.Cause(err))
+
+	t.Log("Unflushed paths aren't reported as uploaded")
+	source.unflushed = map[string]bool{"c/d": true}
+	item, err := b.Item(ctx, "/private/alice/c/d")
+	require.NoError(t, err)
+	require.False(t, item.Uploaded)
+	item, err = b.Item(ctx, "/private/alice/a")
+	require.NoError(t, err)
+	require.True(t, item.Uploaded)
+}
+
+func TestChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libfileprovider")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tlfDir := filepath.Join(dir, "alice")
+	writeTestFile(t, filepath.Join(tlfDir, "a"), "a")
+	writeTestFile(t, filepath.Join(tlfDir, "b"), "b")
+
+	b, err := NewBackend(
+		context.Background(), &testSource{root: dir}, Options{})
+	require.NoError(t, err)
+	defer b.Shutdown()
+	ctx := context.Background()
+
+	anchor, err := b.CurrentAnchor(ctx, "/private/alice")
+	require.NoError(t, err)
+	changes, err := b.Changes(ctx, "/private/alice", anchor)
+	require.NoError(t, err)
+	require.Len(t, changes.Updated, 0)
+	require.Len(t, changes.Deleted, 0)
+	require.NotEqual(t, anchor, changes.Anchor)
+
+	writeTestFile(t, filepath.Join(tlfDir, "a"), "changed")
+	require.NoError(t, os.Remove(filepath.Join(tlfDir, "b")))
+	writeTestFile(t, filepath.Join(tlfDir, "c"), "c")
+	changes, err = b.Changes(ctx, "/private/alice", changes.Anchor)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, filenames(changes.Updated))
+	require.Equal(t, []string{"/private/alice/b"}, changes.Deleted)
+
+	_, err = b.Changes(ctx, "/private/alice", "nope")
+	require.Equal(t, ErrSyncAnchorExpired, errors.Cause(err))
+	_, err = b.Changes(ctx, "/private", changes.Anchor)
+	require.Equal(t, ErrSyncAnchorExpired, errors.Cause(err))
+}
+
+func TestMaterializeAndUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libfileprovider")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "tlfs")
+	writeTestFile(t, filepath.Join(root, "alice", "a"), "hello")
+	local := filepath.Join(dir, "local")
+	require.NoError(t, os.MkdirAll(local, 0755))
+
+	uploadCh := make(chan struct{})
+	source := &testSource{root: root, uploadCh: uploadCh}
+	updates := make(chan Upload, 10)
+	b, err := NewBackend(context.Background(), source, Options{
+		OnUpload: func(u Upload) { updates <- u },
+	})
+	require.NoError(t, err)
+	defer b.Shutdown()
+	ctx := context.Background()
+
+	t.Log("Materialize a file")
+	item, err := b.Materialize(ctx, "/private/alice/a",
+		filepath.Join(local, "a"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), item.Size)
+	data, err := ioutil.ReadFile(filepath.Join(local, "a"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	_, err = b.Materialize(ctx, "/private/alice", filepath.Join(local, "x"))
+	require.Error(t, err)
+
+	t.Log("Upload a new file")
+	src := filepath.Join(local, "new")
+	writeTestFile(t, src, "new data")
+	require.NoError(t, os.Chmod(src, 0755))
+	u, err := b.QueueUpload(ctx, "/private/alice", "new", src)
+	require.NoError(t, err)
+	require.Equal(t, UploadQueued, u.State)
+	require.Equal(t, UploadImporting, (<-updates).State)
+	uploading := <-updates
+	require.Equal(t, UploadUploading, uploading.State)
+	require.NotNil(t, uploading.Item)
+	require.Equal(t, "/private/alice/new", uploading.Item.Identifier)
+	require.True(t, uploading.Item.Executable)
+	close(uploadCh)
+	done := <-updates
+	require.Equal(t, UploadDone, done.State)
+	require.True(t, done.Item.Uploaded)
+	data, err = ioutil.ReadFile(filepath.Join(root, "alice", "new"))
+	require.NoError(t, err)
+	require.Equal(t, "new data", string(data))
+	uploads := b.Uploads()
+	require.Len(t, uploads, 1)
+	require.Equal(t, UploadDone, uploads[0].State)
+
+	t.Log("Uploads into missing folders fail")
+	_, err = b.QueueUpload(ctx, "/private/alice/nope", "x", src)
+	require.NoError(t, err)
+	require.Equal(t, UploadImporting, (<-updates).State)
+	failed := <-updates
+	require.Equal(t, UploadFailed, failed.State)
+	require.NotEmpty(t, failed.Error)
+
+	_, err = b.QueueUpload(ctx, "/private", "x", src)
+	require.Error(t, err)
+	_, err = b.QueueUpload(ctx, "/private/alice", "a/b", src)
+	require.Error(t, err)
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfileprovider
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RootIdentifier is the identifier of the root container, the one a
+// FileProvider extension maps NSFileProviderRootContainerItemIdentifier
+// to.
+const RootIdentifier = "/"
+
+// tlfTypes are the names of the root container's children, in the
+// order they're listed.
+var tlfTypes = []string{"private", "public", "team"}
+
+// ItemType is the kind of an item.
+type ItemType string
+
+const (
+	// ItemFolder is a container: the root, a TLF type, a TLF or a
+	// directory within one.
+	ItemFolder ItemType = "folder"
+	// ItemFile is a regular file.
+	ItemFile ItemType = "file"
+	// ItemSymlink is a symbolic link.
+	ItemSymlink ItemType = "symlink"
+)
+
+// Item describes a file or folder, in the shape of an
+// NSFileProviderItem.
+//
+// Identifiers are the slash-separated KBFS paths of items, without
+// the "/keybase" prefix (e.g., "/private/alice/notes.txt").  KBFS
+// has no identifiers that survive renames, so a rename shows up in
+// change enumerations as the deletion of the old identifier plus a
+// new item.
+type Item struct {
+	Identifier       string    `codec:"identifier" json:"identifier"`
+	ParentIdentifier string    `codec:"parentIdentifier" json:"parentIdentifier"`
+	Filename         string    `codec:"filename" json:"filename"`
+	Type             ItemType  `codec:"type" json:"type"`
+	Size             int64     `codec:"size" json:"size"`
+	Mtime            time.Time `codec:"mtime" json:"mtime"`
+	Executable       bool      `codec:"executable" json:"executable"`
+	SymlinkTarget    string    `codec:"symlinkTarget" json:"symlinkTarget,omitempty"`
+	// Version changes whenever the item's contents or metadata do,
+	// so it can be used as the item's content and metadata versions.
+	Version string `codec:"version" json:"version"`
+	// Uploaded is false while the item has local changes that
+	// haven't reached the KBFS servers yet.
+	Uploaded bool `codec:"uploaded" json:"uploaded"`
+}
+
+// itemID is a parsed item identifier.
+type itemID struct {
+	tlfType string // empty for the root
+	tlfName string // empty for the root and TLF type containers
+	p       string // the path within the TLF; "" for its root
+}
+
+// ErrNoSuchItem is returned for identifiers that don't name an
+// existing item.
+var ErrNoSuchItem = errors.New("no such item")
+
+// ErrNotAContainer is returned when enumerating an item that isn't
+// a folder.
+var ErrNotAContainer = errors.New("item is not a container")
+
+func parseID(id string) (itemID, error) {
+	if !strings.HasPrefix(id, "/") {
+		return itemID{}, errors.WithStack(ErrNoSuchItem)
+	}
+	cleaned := path.Clean(id)
+	if cleaned != id {
+		return itemID{}, errors.WithStack(ErrNoSuchItem)
+	}
+	if id == RootIdentifier {
+		return itemID{}, nil
+	}
+	parts := strings.SplitN(id[1:], "/", 3)
+	var ret itemID
+	ret.tlfType = parts[0]
+	known := false
+	for _, t := range tlfTypes {
+		if t == ret.tlfType {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return itemID{}, errors.WithStack(ErrNoSuchItem)
+	}
+	if len(parts) > 1 {
+		ret.tlfName = parts[1]
+	}
+	if len(parts) > 2 {
+		ret.p = parts[2]
+	}
+	return ret, nil
+}
+
+func (id itemID) String() string {
+	switch {
+	case id.tlfType == "":
+		return RootIdentifier
+	case id.tlfName == "":
+		return "/" + id.tlfType
+	default:
+		return path.Join("/", id.tlfType, id.tlfName, id.p)
+	}
+}
+
+// child returns the identifier of the named child of `id`.
+func (id itemID) child(name string) itemID {
+	switch {
+	case id.tlfType == "":
+		return itemID{tlfType: name}
+	case id.tlfName == "":
+		return itemID{tlfType: id.tlfType, tlfName: name}
+	default:
+		return itemID{
+			tlfType: id.tlfType,
+			tlfName: id.tlfName,
+			p:       path.Join(id.p, name),
+		}
+	}
+}
+
+func (id itemID) parent() itemID {
+	switch {
+	case id.tlfType == "":
+		return id
+	case id.tlfName == "":
+		return itemID{}
+	case id.p == "":
+		return itemID{tlfType: id.tlfType}
+	default:
+		p := path.Dir(id.p)
+		if p == "." {
+			p = ""
+		}
+		return itemID{tlfType: id.tlfType, tlfName: id.tlfName, p: p}
+	}
+}
+
+func (id itemID) name() string {
+	switch {
+	case id.tlfType == "":
+		return ""
+	case id.tlfName == "":
+		return id.tlfType
+	case id.p == "":
+		return id.tlfName
+	default:
+		return path.Base(id.p)
+	}
+}
+
+// inTLF returns whether `id` names the root of a TLF or something
+// in one.
+func (id itemID) inTLF() bool {
+	return id.tlfName != ""
+}
+
+// fsPath returns the path of `id` within its TLF's filesystem.
+func (id itemID) fsPath() string {
+	if id.p == "" {
+		return "."
+	}
+	return id.p
+}
+
+// containerItem returns the item for a container that isn't looked
+// up in a TLF (the root, a TLF type, or a TLF as listed in its type).
+func containerItem(id itemID) Item {
+	return Item{
+		Identifier:       id.String(),
+		ParentIdentifier: id.parent().String(),
+		Filename:         id.name(),
+		Type:             ItemFolder,
+		Version:          "0",
+		Uploaded:         true,
+	}
+}
+
+// makeItem returns the item for the entry `fi` in a TLF.
+func makeItem(id itemID, fi os.FileInfo, uploaded bool) Item {
+	item := Item{
+		Identifier:       id.String(),
+		ParentIdentifier: id.parent().String(),
+		Filename:         id.name(),
+		Size:             fi.Size(),
+		Mtime:            fi.ModTime(),
+		Uploaded:         uploaded,
+	}
+	switch {
+	case fi.IsDir():
+		item.Type = ItemFolder
+		item.Size = 0
+	case fi.Mode()&os.ModeSymlink != 0:
+		item.Type = ItemSymlink
+	default:
+		item.Type = ItemFile
+		item.Executable = fi.Mode()&0100 != 0
+	}
+	item.Version = fmt.Sprintf("%d-%d-%t",
+		fi.ModTime().UnixNano(), item.Size, item.Executable)
+	return item
+}
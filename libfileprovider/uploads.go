@@ -0,0 +1,339 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfileprovider
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	// uploadTempPrefix starts the names of the files that uploads
+	// are written to before being renamed into place.  Enumerations
+	// skip them.  (KBFS doesn't allow names starting with ".kbfs".)
+	uploadTempPrefix = ".fpupload-"
+	// maxFinishedUploads is the number of done or failed uploads
+	// that Uploads keeps reporting.
+	maxFinishedUploads = 100
+)
+
+// UploadState is the state of a queued upload.
+type UploadState string
+
+const (
+	// UploadQueued means the upload is waiting for earlier ones.
+	UploadQueued UploadState = "queued"
+	// UploadImporting means the local file is being copied into
+	// KBFS.  After this, the local file is no longer needed.
+	UploadImporting UploadState = "importing"
+	// UploadUploading means the file is in KBFS, and waiting to
+	// reach the servers.
+	UploadUploading UploadState = "uploading"
+	// UploadDone means the file has reached the servers.
+	UploadDone UploadState = "done"
+	// UploadFailed means the upload failed; see Upload.Error.
+	UploadFailed UploadState = "failed"
+)
+
+// Upload is a local file queued to be written into KBFS.
+type Upload struct {
+	ID               string      `codec:"id" json:"id"`
+	ParentIdentifier string      `codec:"parentIdentifier" json:"parentIdentifier"`
+	Filename         string      `codec:"filename" json:"filename"`
+	LocalPath        string      `codec:"localPath" json:"localPath"`
+	State            UploadState `codec:"state" json:"state"`
+	Error            string      `codec:"error" json:"error,omitempty"`
+	// Item is the uploaded item, once it's been imported.
+	Item *Item `codec:"item" json:"item,omitempty"`
+}
+
+func (u Upload) finished() bool {
+	return u.State == UploadDone || u.State == UploadFailed
+}
+
+// uploadQueue is the state of a Backend's uploads, protected by
+// Backend.lock.
+type uploadQueue struct {
+	nextID   uint64
+	uploads  map[string]*Upload
+	order    []string // IDs, oldest first
+	wakeCh   chan struct{}
+	cancel   context.CancelFunc
+	doneCh   chan struct{}
+	shutdown bool
+}
+
+func (q *uploadQueue) init() {
+	q.uploads = make(map[string]*Upload)
+	q.wakeCh = make(chan struct{}, 1)
+	q.doneCh = make(chan struct{})
+}
+
+func (b *Backend) startUploads(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	b.uploads.cancel = cancel
+	go b.processUploads(ctx)
+}
+
+func (b *Backend) shutdownUploads() {
+	b.lock.Lock()
+	if b.uploads.shutdown {
+		b.lock.Unlock()
+		return
+	}
+	b.uploads.shutdown = true
+	b.lock.Unlock()
+	b.uploads.cancel()
+	<-b.uploads.doneCh
+}
+
+// setUploadState records the new state of an upload, and calls the
+// OnUpload hook.
+func (b *Backend) setUploadState(id string, state UploadState,
+	item *Item, uploadErr error) {
+	b.lock.Lock()
+	u, ok := b.uploads.uploads[id]
+	if !ok {
+		b.lock.Unlock()
+		return
+	}
+	u.State = state
+	if item != nil {
+		u.Item = item
+	}
+	if uploadErr != nil {
+		u.Error = uploadErr.Error()
+	}
+	copied := *u
+	if copied.finished() {
+		b.pruneFinishedUploadsLocked()
+	}
+	b.lock.Unlock()
+
+	if b.options.OnUpload != nil {
+		b.options.OnUpload(copied)
+	}
+}
+
+// pruneFinishedUploadsLocked forgets the oldest finished uploads
+// beyond maxFinishedUploads.
+func (b *Backend) pruneFinishedUploadsLocked() {
+	finished := 0
+	for _, id := range b.uploads.order {
+		if b.uploads.uploads[id].finished() {
+			finished++
+		}
+	}
+	if finished <= maxFinishedUploads {
+		return
+	}
+	order := b.uploads.order[:0]
+	for _, id := range b.uploads.order {
+		if finished > maxFinishedUploads &&
+			b.uploads.uploads[id].finished() {
+			delete(b.uploads.uploads, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	b.uploads.order = order
+}
+
+// QueueUpload queues the local file at `localPath` to be written
+// into KBFS, as `filename` in the given container, replacing
+// anything already there.  The local file must stay in place until
+// the upload has moved past UploadImporting.
+func (b *Backend) QueueUpload(ctx context.Context, parentIdentifier string,
+	filename string, localPath string) (Upload, error) {
+	id, err := parseID(parentIdentifier)
+	if err != nil {
+		return Upload{}, err
+	}
+	if !id.inTLF() {
+		return Upload{}, errors.Errorf(
+			"Can't upload into %s, which isn't in a TLF", parentIdentifier)
+	}
+	if filename == "" || filename == "." || filename == ".." ||
+		strings.Contains(filename, "/") {
+		return Upload{}, errors.Errorf("Invalid filename %q", filename)
+	}
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return Upload{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return Upload{}, errors.Errorf("%s is not a regular file", localPath)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.uploads.shutdown {
+		return Upload{}, errors.New("The backend has been shut down")
+	}
+	b.uploads.nextID++
+	u := &Upload{
+		ID:               strconv.FormatUint(b.uploads.nextID, 10),
+		ParentIdentifier: id.String(),
+		Filename:         filename,
+		LocalPath:        localPath,
+		State:            UploadQueued,
+	}
+	b.uploads.uploads[u.ID] = u
+	b.uploads.order = append(b.uploads.order, u.ID)
+	select {
+	case b.uploads.wakeCh <- struct{}{}:
+	default:
+	}
+	return *u, nil
+}
+
+// Uploads returns the queued, in-progress and recently finished
+// uploads, oldest first.
+func (b *Backend) Uploads() []Upload {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	uploads := make([]Upload, 0, len(b.uploads.order))
+	for _, id := range b.uploads.order {
+		uploads = append(uploads, *b.uploads.uploads[id])
+	}
+	return uploads
+}
+
+// nextUpload returns the oldest queued upload, if any.
+func (b *Backend) nextUpload() (Upload, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, id := range b.uploads.order {
+		if u := b.uploads.uploads[id]; u.State == UploadQueued {
+			return *u, true
+		}
+	}
+	return Upload{}, false
+}
+
+func (b *Backend) processUploads(ctx context.Context) {
+	defer close(b.uploads.doneCh)
+	for {
+		u, ok := b.nextUpload()
+		if !ok {
+			select {
+			case <-b.uploads.wakeCh:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			b.setUploadState(u.ID, UploadFailed, nil, ctx.Err())
+			continue
+		}
+		b.doUpload(ctx, u)
+	}
+}
+
+func (b *Backend) doUpload(ctx context.Context, u Upload) {
+	b.setUploadState(u.ID, UploadImporting, nil, nil)
+	parent, err := parseID(u.ParentIdentifier)
+	if err != nil {
+		b.setUploadState(u.ID, UploadFailed, nil, err)
+		return
+	}
+	item, err := b.importFile(ctx, parent, u.Filename, u.LocalPath)
+	if err != nil {
+		b.setUploadState(u.ID, UploadFailed, nil, err)
+		return
+	}
+	b.setUploadState(u.ID, UploadUploading, &item, nil)
+
+	err = b.source.WaitForUpload(ctx, parent.tlfType, parent.tlfName)
+	if err != nil {
+		b.setUploadState(u.ID, UploadFailed, nil, err)
+		return
+	}
+	item.Uploaded = true
+	b.setUploadState(u.ID, UploadDone, &item, nil)
+}
+
+// importFile copies the local file into KBFS, via a temporary file
+// so that readers never see a partial copy.
+func (b *Backend) importFile(ctx context.Context, parent itemID,
+	filename, localPath string) (item Item, err error) {
+	fs, err := b.source.TLFFS(ctx, parent.tlfType, parent.tlfName)
+	if err != nil {
+		return Item{}, err
+	}
+	fi, err := fs.Stat(parent.fsPath())
+	if os.IsNotExist(errors.Cause(err)) {
+		return Item{}, errors.WithStack(ErrNoSuchItem)
+	} else if err != nil {
+		return Item{}, err
+	}
+	if !fi.IsDir() {
+		return Item{}, errors.WithStack(ErrNotAContainer)
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return Item{}, err
+	}
+	defer src.Close()
+	srcFI, err := src.Stat()
+	if err != nil {
+		return Item{}, err
+	}
+
+	tmp, err := util.TempFile(fs, parent.fsPath(), uploadTempPrefix)
+	if err != nil {
+		return Item{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = fs.Remove(tmp.Name())
+		}
+	}()
+	if _, err := io.Copy(tmp, contextReader{ctx, src}); err != nil {
+		return Item{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Item{}, err
+	}
+	child := parent.child(filename)
+	if err := fs.Rename(tmp.Name(), child.fsPath()); err != nil {
+		return Item{}, err
+	}
+	if err := setAttrs(fs, child.fsPath(), srcFI); err != nil {
+		return Item{}, err
+	}
+	if err := syncFS(fs); err != nil {
+		return Item{}, err
+	}
+	return b.item(ctx, child, b.unflushed(ctx, child))
+}
+
+// setAttrs copies the executable bit and mtime of a local file onto
+// `p`, if `fs` supports changing them.
+func setAttrs(fs billy.Filesystem, p string, fi os.FileInfo) error {
+	c, ok := fs.(billy.Change)
+	if !ok {
+		return nil
+	}
+	mode := os.FileMode(0644)
+	if fi.Mode()&0100 != 0 {
+		mode = 0755
+	}
+	if err := c.Chmod(p, mode); err != nil {
+		return err
+	}
+	return c.Chtimes(p, fi.ModTime(), fi.ModTime())
+}
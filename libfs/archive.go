@@ -0,0 +1,530 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// ArchiveTarGzSuffix is the suffix of gzipped tarball archives
+	// under an ExportDirName directory.
+	ArchiveTarGzSuffix = ".tar.gz"
+	// ArchiveZipSuffix is the suffix of zip archives under an
+	// ExportDirName directory.
+	ArchiveZipSuffix = ".zip"
+)
+
+// ArchiveSuffixes lists the suffixes of all the supported archive
+// formats.
+var ArchiveSuffixes = []string{ArchiveTarGzSuffix, ArchiveZipSuffix}
+
+// ParseArchiveName splits `name`, the name of a file in an
+// ExportDirName directory, into the name of the directory to
+// archive and the suffix of the archive format.  It returns false if
+// `name` doesn't end in a supported suffix.
+func ParseArchiveName(name string) (dirName, suffix string, ok bool) {
+	for _, suffix := range ArchiveSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return strings.TrimSuffix(name, suffix), suffix, true
+		}
+	}
+	return "", "", false
+}
+
+// archiveWriter adds KBFS entries to an archive.
+type archiveWriter interface {
+	addDir(name string, ei libkbfs.EntryInfo) error
+	addFile(name string, ei libkbfs.EntryInfo, r io.Reader) error
+	addSymlink(name string, ei libkbfs.EntryInfo) error
+	Close() error
+}
+
+func archiveMode(ei libkbfs.EntryInfo) os.FileMode {
+	switch ei.Type {
+	case libkbfs.Dir:
+		return os.ModeDir | 0755
+	case libkbfs.Exec:
+		return 0755
+	case libkbfs.Sym:
+		return os.ModeSymlink | 0777
+	default:
+		return 0644
+	}
+}
+
+type tarGzArchiveWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzArchiveWriter(w io.Writer) *tarGzArchiveWriter {
+	gw := gzip.NewWriter(w)
+	return &tarGzArchiveWriter{gw, tar.NewWriter(gw)}
+}
+
+func (taw *tarGzArchiveWriter) writeHeader(
+	name string, ei libkbfs.EntryInfo, typeflag byte) error {
+	hdr := &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Linkname: ei.SymPath,
+		Mode:     int64(archiveMode(ei).Perm()),
+		ModTime:  time.Unix(0, ei.Mtime),
+	}
+	if typeflag == tar.TypeReg {
+		hdr.Size = int64(ei.Size)
+	}
+	return taw.tw.WriteHeader(hdr)
+}
+
+func (taw *tarGzArchiveWriter) addDir(
+	name string, ei libkbfs.EntryInfo) error {
+	return taw.writeHeader(name+"/", ei, tar.TypeDir)
+}
+
+func (taw *tarGzArchiveWriter) addFile(
+	name string, ei libkbfs.EntryInfo, r io.Reader) error {
+	err := taw.writeHeader(name, ei, tar.TypeReg)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(taw.tw, r, int64(ei.Size))
+	return err
+}
+
+func (taw *tarGzArchiveWriter) addSymlink(
+	name string, ei libkbfs.EntryInfo) error {
+	return taw.writeHeader(name, ei, tar.TypeSymlink)
+}
+
+func (taw *tarGzArchiveWriter) Close() error {
+	err := taw.tw.Close()
+	if err != nil {
+		return err
+	}
+	return taw.gw.Close()
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (zaw zipArchiveWriter) create(
+	name string, ei libkbfs.EntryInfo) (io.Writer, error) {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Unix(0, ei.Mtime),
+	}
+	hdr.SetMode(archiveMode(ei))
+	if ei.Type == libkbfs.Dir {
+		hdr.Method = zip.Store
+	}
+	return zaw.zw.CreateHeader(hdr)
+}
+
+func (zaw zipArchiveWriter) addDir(name string, ei libkbfs.EntryInfo) error {
+	_, err := zaw.create(name+"/", ei)
+	return err
+}
+
+func (zaw zipArchiveWriter) addFile(
+	name string, ei libkbfs.EntryInfo, r io.Reader) error {
+	w, err := zaw.create(name, ei)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, r, int64(ei.Size))
+	return err
+}
+
+func (zaw zipArchiveWriter) addSymlink(
+	name string, ei libkbfs.EntryInfo) error {
+	// Zip stores the target of a symlink as its contents.
+	w, err := zaw.create(name, ei)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, ei.SymPath)
+	return err
+}
+
+func (zaw zipArchiveWriter) Close() error {
+	return zaw.zw.Close()
+}
+
+// nodeReader reads a KBFS file sequentially.
+type nodeReader struct {
+	ctx    context.Context
+	config libkbfs.Config
+	node   libkbfs.Node
+	off    int64
+}
+
+func (nr *nodeReader) Read(p []byte) (n int, err error) {
+	read, err := nr.config.KBFSOps().Read(nr.ctx, nr.node, p, nr.off)
+	if err != nil {
+		return 0, err
+	}
+	if read == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	nr.off += read
+	return int(read), nil
+}
+
+func addDirToArchive(
+	ctx context.Context, config libkbfs.Config, dir libkbfs.Node,
+	dirPath string, aw archiveWriter) error {
+	children, err := config.KBFSOps().GetDirChildren(ctx, dir)
+	if err != nil {
+		return err
+	}
+	// Keep the output stable, so reads can restart the archive.
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		childPath := path.Join(dirPath, name)
+		n, ei, err := config.KBFSOps().Lookup(ctx, dir, name)
+		if err != nil {
+			return err
+		}
+		switch ei.Type {
+		case libkbfs.Dir:
+			err = aw.addDir(childPath, ei)
+			if err != nil {
+				return err
+			}
+			err = addDirToArchive(ctx, config, n, childPath, aw)
+		case libkbfs.Sym:
+			err = aw.addSymlink(childPath, ei)
+		default:
+			err = aw.addFile(childPath, ei, &nodeReader{ctx, config, n, 0})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteArchive writes an archive, in the format given by `suffix`
+// (one of ArchiveSuffixes), of everything under the directory `dir`
+// to `w`.  Entries are named relative to `dir`, and symlinks are
+// archived as links rather than followed.
+func WriteArchive(
+	ctx context.Context, config libkbfs.Config, dir libkbfs.Node,
+	suffix string, w io.Writer) error {
+	var aw archiveWriter
+	switch suffix {
+	case ArchiveTarGzSuffix:
+		aw = newTarGzArchiveWriter(w)
+	case ArchiveZipSuffix:
+		aw = zipArchiveWriter{zip.NewWriter(w)}
+	default:
+		return errors.Errorf("Unknown archive format: %s", suffix)
+	}
+	err := addDirToArchive(ctx, config, dir, "", aw)
+	if err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// ArchiveStream generates an archive of a KBFS directory as it is
+// read, without buffering the whole thing.  Reads are expected to be
+// sequential; a read at an earlier offset starts generating the
+// archive again from the beginning.
+type ArchiveStream struct {
+	ctx    context.Context
+	config libkbfs.Config
+	dir    libkbfs.Node
+	suffix string
+
+	lock sync.Mutex
+	r    *io.PipeReader
+	off  int64
+}
+
+// NewArchiveStream returns a new stream of an archive, in the format
+// given by `suffix`, of the directory `dir`.  The caller must close
+// it when done.
+func NewArchiveStream(
+	ctx context.Context, config libkbfs.Config, dir libkbfs.Node,
+	suffix string) *ArchiveStream {
+	return &ArchiveStream{
+		ctx:    ctx,
+		config: config,
+		dir:    dir,
+		suffix: suffix,
+	}
+}
+
+func (as *ArchiveStream) restartLocked() {
+	if as.r != nil {
+		as.r.Close()
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(WriteArchive(as.ctx, as.config, as.dir, as.suffix, w))
+	}()
+	as.r = r
+	as.off = 0
+}
+
+// ReadAt implements the io.ReaderAt interface for ArchiveStream.
+func (as *ArchiveStream) ReadAt(p []byte, off int64) (n int, err error) {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	if as.r == nil || off < as.off {
+		as.restartLocked()
+	}
+	if off > as.off {
+		skipped, err := io.CopyN(ioutil.Discard, as.r, off-as.off)
+		as.off += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = io.ReadFull(as.r, p)
+	as.off += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close stops generating the archive.
+func (as *ArchiveStream) Close() error {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	if as.r == nil {
+		return nil
+	}
+	err := as.r.Close()
+	as.r = nil
+	return err
+}
+
+// archiveFile is a read-only billy.File whose contents are an
+// archive of a directory, generated as it is read.
+type archiveFile struct {
+	name   string
+	stream *ArchiveStream
+
+	lock   sync.Mutex
+	offset int64
+}
+
+var _ billy.File = (*archiveFile)(nil)
+
+// Name implements the billy.File interface for archiveFile.
+func (af *archiveFile) Name() string {
+	return af.name
+}
+
+// Write implements the billy.File interface for archiveFile.
+func (af *archiveFile) Write(p []byte) (n int, err error) {
+	return 0, errors.New("Archive files are read-only")
+}
+
+// Read implements the billy.File interface for archiveFile.
+func (af *archiveFile) Read(p []byte) (n int, err error) {
+	af.lock.Lock()
+	defer af.lock.Unlock()
+	n, err = af.stream.ReadAt(p, af.offset)
+	af.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements the billy.File interface for archiveFile.
+func (af *archiveFile) ReadAt(p []byte, off int64) (n int, err error) {
+	return af.stream.ReadAt(p, off)
+}
+
+// Seek implements the billy.File interface for archiveFile.  The
+// size of the archive isn't known up front, so it can't seek
+// relative to the end.
+func (af *archiveFile) Seek(offset int64, whence int) (n int64, err error) {
+	af.lock.Lock()
+	defer af.lock.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += af.offset
+	default:
+		return 0, errors.Errorf("Cannot seek archive with whence=%d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.Errorf("Invalid seek offset %d", offset)
+	}
+	af.offset = offset
+	return offset, nil
+}
+
+// Close implements the billy.File interface for archiveFile.
+func (af *archiveFile) Close() error {
+	return af.stream.Close()
+}
+
+// Lock implements the billy.File interface for archiveFile.
+func (af *archiveFile) Lock() error {
+	return nil
+}
+
+// Unlock implements the billy.File interface for archiveFile.
+func (af *archiveFile) Unlock() error {
+	return nil
+}
+
+// Truncate implements the billy.File interface for archiveFile.
+func (af *archiveFile) Truncate(size int64) error {
+	return errors.New("Archive files are read-only")
+}
+
+// archiveFileInfo describes the virtual archive directory and the
+// archives in it.  The size of an archive isn't known until it has
+// been generated, so it's always reported as 0.
+type archiveFileInfo struct {
+	name  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+var _ os.FileInfo = archiveFileInfo{}
+
+func (afi archiveFileInfo) Name() string       { return afi.name }
+func (afi archiveFileInfo) Size() int64        { return 0 }
+func (afi archiveFileInfo) Mode() os.FileMode  { return afi.mode }
+func (afi archiveFileInfo) ModTime() time.Time { return afi.mtime }
+func (afi archiveFileInfo) IsDir() bool        { return afi.mode.IsDir() }
+func (afi archiveFileInfo) Sys() interface{}   { return nil }
+
+// splitArchivePath returns true if `filename` refers to an
+// ExportDirName directory, or to an archive within one.  In that
+// case it also returns the path of the directory containing the
+// ExportDirName directory, and the name of the archive, which is
+// empty for the ExportDirName directory itself.
+func splitArchivePath(filename string) (parent, name string, ok bool) {
+	p := path.Clean("/" + filename)
+	dir, base := path.Split(p)
+	dir = path.Clean(dir)
+	switch {
+	case base == ExportDirName:
+		return strings.TrimPrefix(dir, "/"), "", true
+	case path.Base(dir) == ExportDirName:
+		return strings.TrimPrefix(path.Dir(dir), "/"), base, true
+	default:
+		return "", "", false
+	}
+}
+
+// lookupArchive returns the directory to archive for `name` in the
+// ExportDirName directory under `parent`, along with the suffix of
+// the archive format.
+func (fs *FS) lookupArchive(parent, name string) (
+	dir libkbfs.Node, ei libkbfs.EntryInfo, suffix string, err error) {
+	dirName, suffix, ok := ParseArchiveName(name)
+	if !ok {
+		return nil, libkbfs.EntryInfo{}, "", os.ErrNotExist
+	}
+	dir, ei, err = fs.lookupOrCreateEntry(
+		path.Join(parent, dirName), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, libkbfs.EntryInfo{}, "", err
+	}
+	if ei.Type != libkbfs.Dir {
+		return nil, libkbfs.EntryInfo{}, "", os.ErrNotExist
+	}
+	return dir, ei, suffix, nil
+}
+
+func (fs *FS) openArchive(filename, parent, name string, flag int) (
+	billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	if name == "" {
+		return nil, errors.Errorf("%s is not a file", filename)
+	}
+	dir, _, suffix, err := fs.lookupArchive(parent, name)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveFile{
+		name:   filename,
+		stream: NewArchiveStream(fs.ctx, fs.config, dir, suffix),
+	}, nil
+}
+
+func (fs *FS) statArchive(parent, name string) (os.FileInfo, error) {
+	if name == "" {
+		_, ei, err := fs.lookupOrCreateEntry(parent, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		return archiveFileInfo{
+			ExportDirName, os.ModeDir | 0555, time.Unix(0, ei.Mtime),
+		}, nil
+	}
+	_, ei, _, err := fs.lookupArchive(parent, name)
+	if err != nil {
+		return nil, err
+	}
+	return archiveFileInfo{name, 0444, time.Unix(0, ei.Mtime)}, nil
+}
+
+func (fs *FS) readArchiveDir(parent string) ([]os.FileInfo, error) {
+	n, _, err := fs.lookupOrCreateEntry(parent, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	children, err := fs.config.KBFSOps().GetDirChildren(fs.ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	var fis []os.FileInfo
+	for name, ei := range children {
+		if ei.Type != libkbfs.Dir {
+			continue
+		}
+		for _, suffix := range ArchiveSuffixes {
+			fis = append(fis, archiveFileInfo{
+				name + suffix, 0444, time.Unix(0, ei.Mtime),
+			})
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// ConflictStatus is the content of the conflicts file of a TLF.
+type ConflictStatus struct {
+	// Staged is true if this device has local changes that haven't
+	// been merged with the server's view of the TLF yet.
+	Staged     bool
+	BranchID   string
+	HeadWriter libkb.NormalizedUsername
+	Revision   kbfsmd.Revision
+	// UnmergedPaths and MergedPaths list the paths changed on the
+	// local staged branch and on the server, respectively, since
+	// the branch diverged.
+	UnmergedPaths []string
+	MergedPaths   []string
+	PermanentErr  string `json:",omitempty"`
+}
+
+// GetEncodedConflictStatus returns serialized JSON containing the
+// conflict state of a folder.
+func GetEncodedConflictStatus(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	status, _, err := config.KBFSOps().FolderStatus(ctx, folderBranch)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	cs := ConflictStatus{
+		Staged:       status.Staged,
+		BranchID:     status.BranchID,
+		HeadWriter:   status.HeadWriter,
+		Revision:     status.Revision,
+		PermanentErr: status.PermanentErr,
+	}
+	for _, s := range status.Unmerged {
+		cs.UnmergedPaths = append(cs.UnmergedPaths, s.Path)
+	}
+	for _, s := range status.Merged {
+		cs.MergedPaths = append(cs.MergedPaths, s.Path)
+	}
+
+	data, err = PrettyJSON(cs)
+	return data, time.Time{}, err
+}
+
+// ConflictAction enumerates all the commands that can be written to
+// the conflicts file.
+type ConflictAction int
+
+const (
+	// ConflictResolve is to start a new conflict resolution attempt,
+	// even if one has already failed.  Written as "resolve".
+	ConflictResolve ConflictAction = iota
+	// ConflictClear is to throw away this device's unmerged changes
+	// and fast-forward to the server's view of the TLF, for when a
+	// conflict is stuck.  Written as "clear".
+	ConflictClear
+)
+
+func (a ConflictAction) String() string {
+	switch a {
+	case ConflictResolve:
+		return "resolve"
+	case ConflictClear:
+		return "clear"
+	}
+	return fmt.Sprintf("ConflictAction(%d)", int(a))
+}
+
+// ParseConflictAction parses a command written to the conflicts file,
+// ignoring surrounding whitespace.
+func ParseConflictAction(data []byte) (ConflictAction, error) {
+	cmd := string(bytes.TrimSpace(data))
+	for _, a := range []ConflictAction{ConflictResolve, ConflictClear} {
+		if cmd == a.String() {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown conflict command %q", cmd)
+}
+
+// Execute performs the action on the given folder branch.
+func (a ConflictAction) Execute(
+	ctx context.Context, c libkbfs.Config, fb libkbfs.FolderBranch) error {
+	if fb == (libkbfs.FolderBranch{}) {
+		panic("zero fb in ConflictAction.Execute")
+	}
+
+	switch a {
+	case ConflictResolve:
+		return c.KBFSOps().ForceConflictResolution(ctx, fb)
+	case ConflictClear:
+		return c.KBFSOps().UnstageForTesting(ctx, fb)
+	default:
+		return fmt.Errorf("Unknown action %s", a)
+	}
+}
+
+// HandleConflictsFileWrite parses and executes the command in `data`,
+// which was written to the conflicts file of the given folder branch.
+// Empty writes are ignored.
+func HandleConflictsFileWrite(ctx context.Context, config libkbfs.Config,
+	fb libkbfs.FolderBranch, data []byte) (int, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return len(data), nil
+	}
+	a, err := ParseConflictAction(data)
+	if err != nil {
+		return 0, err
+	}
+	err = a.Execute(ctx, config, fb)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
@@ -104,3 +104,17 @@ const EnableSyncFileName = ".kbfs_enable_sync"
 // DisableSyncFileName is the name of the file to disable the sync cache for a
 // TLF. It can be reached anywhere within a TLF.
 const DisableSyncFileName = ".kbfs_disable_sync"
+
+// ExportDirName is the name of the virtual directory of exported
+// archives -- it can be reached from any directory within a top-level
+// folder.  Reading "<dir>.tar.gz" or "<dir>.zip" from it streams an
+// archive of the subdirectory "<dir>", generated as it is read.  (Not
+// to be confused with libarchive's .kbfs_archive directories, which
+// browse the contents of archive files stored in KBFS.)
+const ExportDirName = ".kbfs_export"
+
+// ConflictsFileName is the name of the KBFS conflict control file --
+// it can be reached anywhere within a top-level folder.  Reading it
+// returns the TLF's conflict state, and writing a ConflictAction
+// command to it acts on that state.
+const ConflictsFileName = ".kbfs_conflicts"
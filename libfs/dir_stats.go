@@ -0,0 +1,177 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"os"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// dirStatsCacheCapacity is the number of directories whose stats are
+// remembered by each FS (and all the FSes chrooted from it).
+const dirStatsCacheCapacity = 10000
+
+// DirStats holds the recursive usage totals of a directory tree.
+type DirStats struct {
+	// LogicalSize is the sum of the sizes of all the files.
+	LogicalSize uint64
+	// EncryptedSize is the sum of the sizes of all the blocks stored
+	// on the server for the tree, including directory and indirect
+	// blocks.
+	EncryptedSize uint64
+	Files         int
+	// Dirs counts the directory itself as well as all of its
+	// subdirectories.
+	Dirs     int
+	Symlinks int
+}
+
+func (s *DirStats) add(other DirStats) {
+	s.LogicalSize += other.LogicalSize
+	s.EncryptedSize += other.EncryptedSize
+	s.Files += other.Files
+	s.Dirs += other.Dirs
+	s.Symlinks += other.Symlinks
+}
+
+// FileEncryptedSize returns the encrypted size of the file rooted at
+// the given block, including all of its indirect blocks.  Only
+// indirect blocks are fetched, so the file contents are never read.
+func FileEncryptedSize(
+	ctx context.Context, config libkbfs.Config, kmd libkbfs.KeyMetadata,
+	info libkbfs.BlockInfo) (uint64, error) {
+	size := uint64(info.EncodedSize)
+	if info.DirectType == libkbfs.DirectBlock {
+		return size, nil
+	}
+
+	var fileBlock libkbfs.FileBlock
+	err := config.BlockOps().Get(
+		ctx, kmd, info.BlockPointer, &fileBlock, libkbfs.TransientEntry)
+	if err != nil {
+		return 0, err
+	}
+	if !fileBlock.IsInd {
+		return size, nil
+	}
+	for _, iptr := range fileBlock.IPtrs {
+		childSize, err := FileEncryptedSize(ctx, config, kmd, iptr.BlockInfo)
+		if err != nil {
+			return 0, err
+		}
+		size += childSize
+	}
+	return size, nil
+}
+
+// addDirBlockStats adds the totals for all the entries stored in the
+// given directory block (and its indirect blocks, if any) to
+// `stats`.
+func (fs *FS) addDirBlockStats(
+	ctx context.Context, kmd libkbfs.KeyMetadata, info libkbfs.BlockInfo,
+	stats *DirStats) error {
+	stats.EncryptedSize += uint64(info.EncodedSize)
+
+	var dirBlock libkbfs.DirBlock
+	err := fs.config.BlockOps().Get(
+		ctx, kmd, info.BlockPointer, &dirBlock, libkbfs.TransientEntry)
+	if err != nil {
+		return err
+	}
+
+	if dirBlock.IsInd {
+		for _, iptr := range dirBlock.IPtrs {
+			err := fs.addDirBlockStats(ctx, kmd, iptr.BlockInfo, stats)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for name, entry := range dirBlock.Children {
+		switch entry.Type {
+		case libkbfs.File, libkbfs.Exec:
+			encSize, err := FileEncryptedSize(
+				ctx, fs.config, kmd, entry.BlockInfo)
+			if err != nil {
+				return err
+			}
+			stats.LogicalSize += entry.Size
+			stats.EncryptedSize += encSize
+			stats.Files++
+		case libkbfs.Dir:
+			childStats, err := fs.dirStatsForBlock(ctx, kmd, entry.BlockInfo)
+			if err != nil {
+				return err
+			}
+			stats.add(childStats)
+		case libkbfs.Sym:
+			// Symlinks are stored entirely in their parent's block.
+			stats.Symlinks++
+		default:
+			return errors.Errorf(
+				"Entry %s has unknown type %s", name, entry.Type)
+		}
+	}
+	return nil
+}
+
+// dirStatsForBlock returns the totals for the directory rooted at the
+// given block.  KBFS never modifies a block in place, so any update
+// within a directory gives it a new top block, and the cached totals
+// for the old block can never be stale.
+func (fs *FS) dirStatsForBlock(
+	ctx context.Context, kmd libkbfs.KeyMetadata, info libkbfs.BlockInfo) (
+	DirStats, error) {
+	if cached, ok := fs.dirStatsCache.Get(info.ID); ok {
+		return cached.(DirStats), nil
+	}
+
+	stats := DirStats{Dirs: 1}
+	err := fs.addDirBlockStats(ctx, kmd, info, &stats)
+	if err != nil {
+		return DirStats{}, err
+	}
+	fs.dirStatsCache.Add(info.ID, stats)
+	return stats, nil
+}
+
+// DirStats returns the recursive usage totals of the directory at
+// `p`.  It only looks at directory entries and indirect block
+// pointers, never at file contents, and it remembers the totals of
+// every directory it walks, so asking again after a small change
+// only re-walks the directories that changed.  Only changes that
+// have been synced are counted.
+func (fs *FS) DirStats(ctx context.Context, p string) (
+	stats DirStats, err error) {
+	fs.log.CDebugf(ctx, "DirStats %s", p)
+	defer func() {
+		fs.deferLog.CDebugf(ctx, "DirStats done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	n, ei, err := fs.lookupOrCreateEntry(p, os.O_RDONLY, 0)
+	if err != nil {
+		return DirStats{}, err
+	}
+	if ei.Type != libkbfs.Dir {
+		return DirStats{}, errors.Errorf("%s is not a directory", p)
+	}
+
+	md, err := fs.config.KBFSOps().GetNodeMetadata(ctx, n)
+	if err != nil {
+		return DirStats{}, err
+	}
+	kmd, err := fs.config.MDOps().GetForTLF(
+		ctx, n.GetFolderBranch().Tlf, nil)
+	if err != nil {
+		return DirStats{}, err
+	}
+	return fs.dirStatsForBlock(ctx, kmd, md.BlockInfo)
+}
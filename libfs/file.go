@@ -60,6 +60,11 @@ func (f *File) Write(p []byte) (n int, err error) {
 	}
 
 	f.updateOffset(origOffset, int64(len(p)))
+	f.fs.sendEvents(FSEvent{
+		EventType: FSEventWrite,
+		File:      f,
+		Bytes:     int64(len(p)),
+	})
 	return len(p), nil
 }
 
@@ -67,7 +72,7 @@ func (f *File) Write(p []byte) (n int, err error) {
 func (f *File) Read(p []byte) (n int, err error) {
 	origOffset := atomic.LoadInt64(&f.offset)
 	readBytes, err := f.fs.config.KBFSOps().Read(
-		f.fs.ctx, f.node, p, origOffset)
+		f.fs.ctxWithPrefetchHint(f.filename), f.node, p, origOffset)
 	if err != nil {
 		return 0, err
 	}
@@ -77,13 +82,19 @@ func (f *File) Read(p []byte) (n int, err error) {
 	}
 
 	f.updateOffset(origOffset, readBytes)
+	f.fs.sendEvents(FSEvent{
+		EventType: FSEventRead,
+		File:      f,
+		Bytes:     readBytes,
+	})
 	return int(readBytes), nil
 }
 
 // ReadAt implements the billy.File interface for File.
 func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
 	// ReadAt doesn't affect the underlying offset.
-	readBytes, err := f.fs.config.KBFSOps().Read(f.fs.ctx, f.node, p, off)
+	readBytes, err := f.fs.config.KBFSOps().Read(
+		f.fs.ctxWithPrefetchHint(f.filename), f.node, p, off)
 	if err != nil {
 		return 0, err
 	}
@@ -92,6 +103,11 @@ func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
 		return 0, errors.Errorf("Could only read %d bytes", readBytes)
 	}
 
+	f.fs.sendEvents(FSEvent{
+		EventType: FSEventRead,
+		File:      f,
+		Bytes:     readBytes,
+	})
 	return int(readBytes), nil
 }
 
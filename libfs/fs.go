@@ -12,10 +12,12 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/libkbfs"
@@ -32,6 +34,10 @@ const (
 	FSEventLock
 	// FSEventUnlock indicates Unlock method has been called.
 	FSEventUnlock
+	// FSEventRead indicates data has been read from a file.
+	FSEventRead
+	// FSEventWrite indicates data has been written to a file.
+	FSEventWrite
 )
 
 // FSEvent is the type for events sent into the events channel passed into
@@ -39,7 +45,12 @@ const (
 type FSEvent struct {
 	EventType FSEventType
 	File      *File
-	Done      <-chan struct{}
+	// Done is closed when a Lock or Unlock call finishes. It's nil for
+	// other events.
+	Done <-chan struct{}
+	// Bytes is the number of bytes read or written, for read and
+	// write events.
+	Bytes int64
 }
 
 type fsInner struct {
@@ -60,9 +71,19 @@ type fsInner struct {
 	// appended to the existing lockNamespace to form the new one. Note that
 	// this is a naive append without and path clean.
 	lockNamespace []byte
+	// dirStatsCache maps the ID of the top block of a directory to
+	// its DirStats.  It's shared with all the FSes chrooted from
+	// this one.
+	dirStatsCache *lru.Cache
+	// prefetchHints holds the prefetch hints set by SetPrefetchHint.
+	// Like dirStatsCache, it's shared with all chrooted FSes.
+	prefetchHints *prefetchHints
 
 	eventsLock sync.RWMutex
 	events     map[chan<- FSEvent]bool
+	// parent is the fsInner of the FS this one was chrooted from, if
+	// any. Events are sent to its subscribers as well.
+	parent *fsInner
 }
 
 // FS is a wrapper around a KBFS subdirectory that implements the
@@ -158,6 +179,11 @@ outer:
 		break
 	}
 
+	dirStatsCache, err := lru.New(dirStatsCacheCapacity)
+	if err != nil {
+		return nil, err
+	}
+
 	log := config.MakeLogger("")
 	log.CDebugf(ctx, "Made new FS for TLF=%s, subdir=%s",
 		tlfHandle.GetCanonicalName(), subdir)
@@ -179,6 +205,8 @@ outer:
 			deferLog:      log.CloneWithAddedDepth(1),
 			lockNamespace: []byte(unixFullPath),
 			priority:      priority,
+			dirStatsCache: dirStatsCache,
+			prefetchHints: newPrefetchHints(),
 			events:        make(map[chan<- FSEvent]bool),
 		},
 	}, nil
@@ -416,6 +444,10 @@ func (fs *FS) OpenFile(filename string, flag int, perm os.FileMode) (
 		err = translateErr(err)
 	}()
 
+	if parent, name, ok := splitArchivePath(filename); ok {
+		return fs.openArchive(filename, parent, name, flag)
+	}
+
 	err = fs.ensureParentDir(filename)
 	if err != nil {
 		return nil, err
@@ -473,6 +505,10 @@ func (fs *FS) Stat(filename string) (fi os.FileInfo, err error) {
 		err = translateErr(err)
 	}()
 
+	if parent, name, ok := splitArchivePath(filename); ok {
+		return fs.statArchive(parent, name)
+	}
+
 	n, ei, err := fs.lookupOrCreateEntry(filename, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
@@ -558,8 +594,10 @@ func (fs *FS) TempFile(dir, prefix string) (billy.File, error) {
 		os.O_CREATE|os.O_EXCL, 0600)
 }
 
-func (fs *FS) readDir(n libkbfs.Node) (fis []os.FileInfo, err error) {
-	children, err := fs.config.KBFSOps().GetDirChildren(fs.ctx, n)
+func (fs *FS) readDir(n libkbfs.Node, p string) (
+	fis []os.FileInfo, err error) {
+	children, err := fs.config.KBFSOps().GetDirChildren(
+		fs.ctxWithPrefetchHint(p), n)
 	if err != nil {
 		return nil, err
 	}
@@ -589,11 +627,18 @@ func (fs *FS) ReadDir(p string) (fis []os.FileInfo, err error) {
 		err = translateErr(err)
 	}()
 
+	if parent, name, ok := splitArchivePath(p); ok {
+		if name != "" {
+			return nil, errors.Errorf("%s is not a directory", p)
+		}
+		return fs.readArchiveDir(parent)
+	}
+
 	n, _, err := fs.lookupOrCreateEntry(p, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
 	}
-	return fs.readDir(n)
+	return fs.readDir(n, p)
 }
 
 // MkdirAll implements the billy.Filesystem interface for FS.
@@ -614,6 +659,21 @@ func (fs *FS) Lstat(filename string) (fi os.FileInfo, err error) {
 		err = translateErr(err)
 	}()
 
+	if parent, name, ok := splitArchivePath(filename); ok {
+		return fs.statArchive(parent, name)
+	}
+
+	// The root has no parent to look it up in, and can't be a
+	// symlink, so treat it just like Stat does.
+	if filename == "" || filename == "/" || filename == "." {
+		return &FileInfo{
+			fs:   fs,
+			ei:   fs.rootInfo,
+			node: fs.root,
+			name: fs.root.GetBasename(),
+		}, nil
+	}
+
 	n, _, base, err := fs.lookupParent(filename)
 	if err != nil {
 		return nil, err
@@ -727,6 +787,93 @@ func (fs *FS) Chtimes(name string, atime time.Time, mtime time.Time) (
 	return fs.config.KBFSOps().SetMtime(fs.ctx, n, &mtime)
 }
 
+func (fs *FS) getXattrs(name string) (map[string][]byte, error) {
+	n, _, err := fs.lookupOrCreateEntry(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	md, err := fs.config.KBFSOps().GetNodeMetadata(fs.ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return md.Xattrs, nil
+}
+
+// GetXattr returns the value of the extended attribute `attr` of the
+// file or directory `name`, or libkbfs.NoSuchXattrError if it isn't
+// set.
+func (fs *FS) GetXattr(name, attr string) (value []byte, err error) {
+	fs.log.CDebugf(fs.ctx, "GetXattr %s %s", name, attr)
+	defer func() {
+		fs.deferLog.CDebugf(fs.ctx, "GetXattr done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	xattrs, err := fs.getXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := xattrs[attr]
+	if !ok {
+		return nil, libkbfs.NoSuchXattrError{Name: attr}
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// ListXattrs returns the sorted names of all the extended attributes
+// of the file or directory `name`.
+func (fs *FS) ListXattrs(name string) (attrs []string, err error) {
+	fs.log.CDebugf(fs.ctx, "ListXattrs %s", name)
+	defer func() {
+		fs.deferLog.CDebugf(fs.ctx, "ListXattrs done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	xattrs, err := fs.getXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	attrs = make([]string, 0, len(xattrs))
+	for attr := range xattrs {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	return attrs, nil
+}
+
+// SetXattr sets the extended attribute `attr` of the file or
+// directory `name` to `value`.
+func (fs *FS) SetXattr(name, attr string, value []byte) (err error) {
+	fs.log.CDebugf(fs.ctx, "SetXattr %s %s", name, attr)
+	defer func() {
+		fs.deferLog.CDebugf(fs.ctx, "SetXattr done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	n, _, err := fs.lookupOrCreateEntry(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	return fs.config.KBFSOps().SetXattr(fs.ctx, n, attr, value)
+}
+
+// RemoveXattr removes the extended attribute `attr` of the file or
+// directory `name`, or returns libkbfs.NoSuchXattrError if it isn't
+// set.
+func (fs *FS) RemoveXattr(name, attr string) (err error) {
+	fs.log.CDebugf(fs.ctx, "RemoveXattr %s %s", name, attr)
+	defer func() {
+		fs.deferLog.CDebugf(fs.ctx, "RemoveXattr done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	n, _, err := fs.lookupOrCreateEntry(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	return fs.config.KBFSOps().RemoveXattr(fs.ctx, n, attr)
+}
+
 // ChrootAsLibFS returns a *FS whose root is p.
 func (fs *FS) ChrootAsLibFS(p string) (newFS *FS, err error) {
 	fs.log.CDebugf(fs.ctx, "Chroot %s", p)
@@ -758,8 +905,11 @@ func (fs *FS) ChrootAsLibFS(p string) (newFS *FS, err error) {
 			// Original lock namespace plus '/' plus the subdir.
 			lockNamespace: bytes.Join(
 				[][]byte{fs.lockNamespace, []byte(p)}, []byte{'/'}),
-			priority: fs.priority,
-			events:   make(map[chan<- FSEvent]bool),
+			priority:      fs.priority,
+			dirStatsCache: fs.dirStatsCache,
+			prefetchHints: fs.prefetchHints,
+			events:        make(map[chan<- FSEvent]bool),
+			parent:        fs.fsInner,
 		},
 	}, nil
 }
@@ -795,10 +945,11 @@ func (fs *FS) GetLockNamespace() (lockNamespace []byte) {
 	return fs.lockNamespace
 }
 
-// SubscribeToEvents causes *File objects constructed from this *FS to send
-// events to the channel at beginning of Lock and Unlock. The send is done
-// blockingly so caller needs to drain the channel properly or make it buffered
-// with enough size.
+// SubscribeToEvents causes *File objects constructed from this *FS, or from
+// any *FS chrooted from it, to send events to the channel at beginning of
+// Lock and Unlock, and after each successful read or write. The send is done
+// blockingly so caller needs to drain the channel properly or make it
+// buffered with enough size.
 func (fs *FS) SubscribeToEvents(ch chan<- FSEvent) {
 	fs.eventsLock.Lock()
 	defer fs.eventsLock.Unlock()
@@ -815,10 +966,12 @@ func (fs *FS) UnsubscribeToEvents(ch chan<- FSEvent) {
 }
 
 func (fs *FS) sendEvents(e FSEvent) {
-	fs.eventsLock.RLock()
-	defer fs.eventsLock.RUnlock()
-	for ch := range fs.events {
-		ch <- e
+	for inner := fs.fsInner; inner != nil; inner = inner.parent {
+		inner.eventsLock.RLock()
+		for ch := range inner.events {
+			ch <- e
+		}
+		inner.eventsLock.RUnlock()
 	}
 }
 
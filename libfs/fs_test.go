@@ -5,8 +5,13 @@
 package libfs
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"io"
 	"os"
 	"path"
 	"testing"
@@ -17,6 +22,7 @@ import (
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	billy "gopkg.in/src-d/go-billy.v4"
@@ -67,6 +73,34 @@ func makeFSWithJournal(t *testing.T, subdir string) (
 	return ctx, h, fs, shutdown
 }
 
+// makeFSWithNormalJournal is like makeFSWithJournal, except that the
+// journal isn't in single-op mode.
+func makeFSWithNormalJournal(t *testing.T) (
+	context.Context, *FS, func()) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "user1")
+
+	tempdir, err := ioutil.TempDir(os.TempDir(), "journal_server")
+	require.NoError(t, err)
+	err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	err = config.EnableJournaling(
+		ctx, tempdir, libkbfs.TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	shutdown := func() {
+		libkbfs.CheckConfigAndShutdown(ctx, t, config)
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	fs, err := NewFS(ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	return ctx, fs, shutdown
+}
+
 func testCreateFile(
 	t *testing.T, ctx context.Context, fs *FS, file string,
 	parent libkbfs.Node) {
@@ -261,6 +295,11 @@ func TestStat(t *testing.T) {
 	}
 	checkFile(fi, true)
 
+	// Lstat works on the root, too.
+	fi, err = fs.Lstat(".")
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+
 	// Try a read-only file.
 	config2 := libkbfs.ConfigAsUser(fs.config.(*libkbfs.ConfigLocal), "user2")
 	defer libkbfs.CheckConfigAndShutdown(ctx, t, config2)
@@ -669,3 +708,543 @@ func TestFileLockingExpiration(t *testing.T) {
 	// the journal is in a weird state.
 	fs.config.MDServer().Shutdown()
 }
+
+func TestFileEvents(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	events := make(chan FSEvent, 10)
+	fs.SubscribeToEvents(events)
+
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	e := <-events
+	require.Equal(t, FSEventWrite, e.EventType)
+	require.Equal(t, int64(5), e.Bytes)
+	require.Equal(t, f, e.File)
+
+	buf := make([]byte, 4)
+	_, err = f.ReadAt(buf, 1)
+	require.NoError(t, err)
+	e = <-events
+	require.Equal(t, FSEventRead, e.EventType)
+	require.Equal(t, int64(4), e.Bytes)
+	err = f.Close()
+	require.NoError(t, err)
+
+	f, err = fs.Open("foo")
+	require.NoError(t, err)
+	buf = make([]byte, 10)
+	n, err := f.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	e = <-events
+	require.Equal(t, FSEventRead, e.EventType)
+	require.Equal(t, int64(5), e.Bytes)
+
+	// Reads at the end of the file don't send events.
+	_, err = f.Read(buf)
+	require.Equal(t, io.EOF, err)
+	err = f.Close()
+	require.NoError(t, err)
+
+	t.Log("Files in chroots send events to the parent's subscribers")
+	err = fs.MkdirAll("dir", 0700)
+	require.NoError(t, err)
+	chrootFS, err := fs.Chroot("dir")
+	require.NoError(t, err)
+	f2, err := chrootFS.Create("bar")
+	require.NoError(t, err)
+	_, err = f2.Write([]byte("hi"))
+	require.NoError(t, err)
+	e = <-events
+	require.Equal(t, FSEventWrite, e.EventType)
+	require.Equal(t, int64(2), e.Bytes)
+	err = f2.Close()
+	require.NoError(t, err)
+
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	fs.UnsubscribeToEvents(events)
+	_, ok := <-events
+	require.False(t, ok)
+}
+
+func readArchive(t *testing.T, fs *FS, name string) []byte {
+	f, err := fs.Open(path.Join(ExportDirName, name))
+	require.NoError(t, err)
+	defer f.Close()
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, f)
+	require.NoError(t, err)
+
+	// Reading from an earlier offset regenerates the same bytes.
+	p := make([]byte, 10)
+	n, err := f.ReadAt(p, 0)
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes()[:n], p[:n])
+	return buf.Bytes()
+}
+
+func TestArchive(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	err := fs.MkdirAll("dir/sub", 0755)
+	require.NoError(t, err)
+	files := map[string]string{
+		"a":     "hello",
+		"sub/b": "world",
+	}
+	for name, data := range files {
+		f, err := fs.Create(path.Join("dir", name))
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+		err = f.Close()
+		require.NoError(t, err)
+	}
+	err = fs.Symlink("a", "dir/link")
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	fi, err := fs.Stat(ExportDirName)
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	fis, err := fs.ReadDir(ExportDirName)
+	require.NoError(t, err)
+	require.Len(t, fis, 2)
+	require.Equal(t, "dir"+ArchiveTarGzSuffix, fis[0].Name())
+	require.Equal(t, "dir"+ArchiveZipSuffix, fis[1].Name())
+
+	t.Log("Check the tarball")
+	gr, err := gzip.NewReader(
+		bytes.NewReader(readArchive(t, fs, "dir"+ArchiveTarGzSuffix)))
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			var buf bytes.Buffer
+			_, err = io.Copy(&buf, tr)
+			require.NoError(t, err)
+			require.Equal(t, files[hdr.Name], buf.String())
+		case tar.TypeSymlink:
+			require.Equal(t, "a", hdr.Linkname)
+		}
+	}
+	require.Equal(t, []string{"a", "link", "sub/", "sub/b"}, names)
+
+	t.Log("Check the zip file")
+	data := readArchive(t, fs, "dir"+ArchiveZipSuffix)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	names = nil
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+		if zf.Mode().IsRegular() {
+			r, err := zf.Open()
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			_, err = io.Copy(&buf, r)
+			require.NoError(t, err)
+			require.Equal(t, files[zf.Name], buf.String())
+		}
+	}
+	require.Equal(t, []string{"a", "link", "sub/", "sub/b"}, names)
+
+	t.Log("Archives can't be written, and only exist for directories")
+	_, err = fs.OpenFile(
+		path.Join(ExportDirName, "dir"+ArchiveZipSuffix), os.O_WRONLY, 0)
+	require.True(t, os.IsPermission(err))
+	_, err = fs.Stat(path.Join(ExportDirName, "missing"+ArchiveZipSuffix))
+	require.True(t, os.IsNotExist(err))
+	_, err = fs.Stat(path.Join(ExportDirName, "dir"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestReadonlyFSAtRevision(t *testing.T) {
+	ctx, h, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	// Use small blocks, to make sure reads span indirect blocks.
+	bsplit, err := libkbfs.NewBlockSplitterSimple(
+		20, 8*1024, fs.config.Codec())
+	require.NoError(t, err)
+	fs.config.SetBlockSplitter(bsplit)
+
+	writeFile := func(name, data string) {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		defer f.Close()
+		err = f.Truncate(0)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	oldData := "the original contents of this file, which span many blocks"
+	err = fs.MkdirAll("dir/sub", 0755)
+	require.NoError(t, err)
+	writeFile("dir/a", oldData)
+	err = fs.Symlink("dir/a", "link")
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+	md, err := fs.config.MDOps().GetForTLF(ctx, h.TlfID(), nil)
+	require.NoError(t, err)
+	oldRev := md.Revision()
+
+	t.Log("Change everything")
+	writeFile("dir/a", "new")
+	writeFile("b", "new file")
+	err = fs.Remove("dir/sub")
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	checkOldRev := func(rfs *RevisionFS) {
+		require.Equal(t, oldRev, rfs.Revision())
+		fis, err := rfs.ReadDir("")
+		require.NoError(t, err)
+		require.Len(t, fis, 2)
+		require.Equal(t, "dir", fis[0].Name())
+		require.True(t, fis[0].IsDir())
+		require.Equal(t, "link", fis[1].Name())
+		require.Equal(t, os.ModeSymlink, fis[1].Mode()&os.ModeType)
+
+		fi, err := rfs.Stat("link")
+		require.NoError(t, err)
+		require.Equal(t, int64(len(oldData)), fi.Size())
+		_, err = rfs.Stat("b")
+		require.True(t, os.IsNotExist(err))
+		fi, err = rfs.Stat("dir/sub")
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+
+		f, err := rfs.Open("link")
+		require.NoError(t, err)
+		defer f.Close()
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, f)
+		require.NoError(t, err)
+		require.Equal(t, oldData, buf.String())
+		p := make([]byte, 10)
+		n, err := f.ReadAt(p, 25)
+		require.NoError(t, err)
+		require.Equal(t, oldData[25:25+n], string(p[:n]))
+
+		_, err = rfs.OpenFile("dir/a", os.O_RDWR, 0)
+		require.Equal(t, billy.ErrReadOnly, err)
+		err = rfs.Remove("dir/a")
+		require.Equal(t, billy.ErrReadOnly, err)
+
+		sub, err := rfs.Chroot("dir")
+		require.NoError(t, err)
+		fi, err = sub.Stat("a")
+		require.NoError(t, err)
+		require.Equal(t, int64(len(oldData)), fi.Size())
+	}
+
+	t.Log("Read the old revision")
+	rfs, err := NewReadonlyFSAtRevision(ctx, fs.config, h, oldRev)
+	require.NoError(t, err)
+	checkOldRev(rfs)
+
+	t.Log("Find the old revision by its time")
+	rfs, err = NewReadonlyFSAtTime(ctx, fs.config, h, rfs.Time())
+	require.NoError(t, err)
+	checkOldRev(rfs)
+	_, err = NewReadonlyFSAtTime(
+		ctx, fs.config, h, rfs.Time().Add(-24*time.Hour))
+	require.Error(t, err)
+}
+
+func TestDirStats(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	// Use small blocks, so some files have indirect blocks.
+	bsplit, err := libkbfs.NewBlockSplitterSimple(
+		20, 8*1024, fs.config.Codec())
+	require.NoError(t, err)
+	fs.config.SetBlockSplitter(bsplit)
+
+	writeFile := func(name, data string) {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		defer f.Close()
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	err = fs.MkdirAll("a/b", 0755)
+	require.NoError(t, err)
+	err = fs.MkdirAll("c", 0755)
+	require.NoError(t, err)
+	writeFile("a/foo", "hello")
+	writeFile("a/b/bar", "a file that is bigger than a single block")
+	writeFile("c/baz", "baz")
+	err = fs.Symlink("foo", "a/link")
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	stats, err := fs.DirStats(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5+41), stats.LogicalSize)
+	require.Equal(t, 2, stats.Files)
+	require.Equal(t, 2, stats.Dirs)
+	require.Equal(t, 1, stats.Symlinks)
+	// The encrypted blocks are always bigger than their contents.
+	require.True(t, stats.EncryptedSize > stats.LogicalSize)
+
+	rootStats, err := fs.DirStats(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5+41+3), rootStats.LogicalSize)
+	require.Equal(t, 3, rootStats.Files)
+	require.Equal(t, 4, rootStats.Dirs)
+	require.True(t, rootStats.EncryptedSize > stats.EncryptedSize)
+
+	_, err = fs.DirStats(ctx, "a/foo")
+	require.Error(t, err)
+	_, err = fs.DirStats(ctx, "missing")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Updates are reflected in the stats")
+	writeFile("a/b/new", "new")
+	err = fs.SyncAll()
+	require.NoError(t, err)
+	chrootFS, err := fs.ChrootAsLibFS("a")
+	require.NoError(t, err)
+	stats, err = chrootFS.DirStats(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5+41+3), stats.LogicalSize)
+	require.Equal(t, 3, stats.Files)
+	rootStats, err = fs.DirStats(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, 4, rootStats.Files)
+}
+
+func TestXattrs(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	f, err := fs.Create("dir/foo")
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+	err = fs.Symlink("dir/foo", "link")
+	require.NoError(t, err)
+
+	attrs, err := fs.ListXattrs("dir/foo")
+	require.NoError(t, err)
+	require.Len(t, attrs, 0)
+	_, err = fs.GetXattr("dir/foo", "user.tag")
+	require.IsType(t, libkbfs.NoSuchXattrError{}, errors.Cause(err))
+
+	t.Log("Set attributes on a file and a directory")
+	err = fs.SetXattr("dir/foo", "user.tag", []byte("red"))
+	require.NoError(t, err)
+	err = fs.SetXattr("link", "security.selinux", []byte("ctx"))
+	require.NoError(t, err)
+	err = fs.SetXattr("dir", "user.tag", []byte("blue"))
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	attrs, err = fs.ListXattrs("dir/foo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"security.selinux", "user.tag"}, attrs)
+	value, err := fs.GetXattr("dir/foo", "user.tag")
+	require.NoError(t, err)
+	require.Equal(t, "red", string(value))
+	value, err = fs.GetXattr("dir", "user.tag")
+	require.NoError(t, err)
+	require.Equal(t, "blue", string(value))
+
+	t.Log("Overwrite and remove attributes")
+	err = fs.SetXattr("dir/foo", "user.tag", []byte("green"))
+	require.NoError(t, err)
+	err = fs.RemoveXattr("dir/foo", "security.selinux")
+	require.NoError(t, err)
+	err = fs.RemoveXattr("dir/foo", "security.selinux")
+	require.IsType(t, libkbfs.NoSuchXattrError{}, errors.Cause(err))
+	err = fs.SyncAll()
+	require.NoError(t, err)
+	attrs, err = fs.ListXattrs("dir/foo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"user.tag"}, attrs)
+	value, err = fs.GetXattr("dir/foo", "user.tag")
+	require.NoError(t, err)
+	require.Equal(t, "green", string(value))
+
+	t.Log("Attributes are limited in size, and persist in the entry")
+	err = fs.SetXattr("dir/foo", "user.big", make([]byte, 5000))
+	require.IsType(t, libkbfs.XattrTooBigError{}, errors.Cause(err))
+	attrs, err = fs.ListXattrs("dir/foo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"user.tag"}, attrs)
+}
+
+func TestTransaction(t *testing.T) {
+	ctx, fs, shutdown := makeFSWithNormalJournal(t)
+	defer shutdown()
+
+	readFile := func(name string) string {
+		f, err := fs.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+		return string(data)
+	}
+	jServer, err := libkbfs.GetJournalServer(fs.config)
+	require.NoError(t, err)
+	tlfID := fs.RootNode().GetFolderBranch().Tlf
+	serverRev := func() kbfsmd.Revision {
+		err := jServer.WaitForCompleteFlush(ctx, tlfID)
+		require.NoError(t, err)
+		rmds, err := fs.config.MDServer().GetForTLF(
+			ctx, tlfID, kbfsmd.NullBranchID, kbfsmd.Merged, nil)
+		require.NoError(t, err)
+		return rmds.MD.RevisionNumber()
+	}
+	err = fs.SyncAll()
+	require.NoError(t, err)
+	rev := serverRev()
+
+	txn := fs.BeginTransaction()
+	err = txn.WriteFile("a/foo", []byte("foo"), 0600)
+	require.NoError(t, err)
+	err = txn.WriteFile("bar", []byte("bar"), 0700)
+	require.NoError(t, err)
+	err = txn.Rename("bar", "a/b/bar")
+	require.NoError(t, err)
+	err = txn.Symlink("foo", "a/link")
+	require.NoError(t, err)
+
+	t.Log("Nothing is written before the commit")
+	_, err = fs.Stat("a")
+	require.True(t, os.IsNotExist(err))
+
+	err = txn.Commit()
+	require.NoError(t, err)
+	require.Equal(t, "foo", readFile("a/foo"))
+	require.Equal(t, "bar", readFile("a/b/bar"))
+	require.Equal(t, "foo", readFile("a/link"))
+	err = txn.Commit()
+	require.Error(t, err)
+
+	t.Log("All the changes went to the server in one revision")
+	require.Equal(t, rev+1, serverRev())
+
+	t.Log("After the commit, writes are flushed on their own again")
+	err = fs.MkdirAll("e", 0755)
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+	require.Equal(t, rev+2, serverRev())
+
+	t.Log("A failed op rolls back the earlier ones")
+	txn = fs.BeginTransaction()
+	err = txn.WriteFile("a/foo", []byte("new foo"), 0600)
+	require.NoError(t, err)
+	err = txn.Remove("a/b/bar")
+	require.NoError(t, err)
+	err = txn.MkdirAll("c/d", 0755)
+	require.NoError(t, err)
+	err = txn.Rename("missing", "a/moved")
+	require.NoError(t, err)
+	err = txn.Commit()
+	require.Error(t, err)
+	require.Equal(t, "foo", readFile("a/foo"))
+	require.Equal(t, "bar", readFile("a/b/bar"))
+	_, err = fs.Stat("c")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("An explicit rollback writes nothing")
+	txn = fs.BeginTransaction()
+	err = txn.Remove("a/foo")
+	require.NoError(t, err)
+	txn.Rollback()
+	err = txn.Commit()
+	require.Error(t, err)
+	require.Equal(t, "foo", readFile("a/foo"))
+}
+
+func TestTransactionNeedsJournal(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	txn := fs.BeginTransaction()
+	err := txn.MkdirAll("a", 0755)
+	require.NoError(t, err)
+	err = txn.Commit()
+	require.Error(t, err)
+	_, err = fs.Stat("a")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPrefetchHints(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	require.Equal(t, libkbfs.PrefetchHintDefault, fs.prefetchHint("a/b/c"))
+
+	fs.SetPrefetchHint("a", libkbfs.PrefetchHintSequential)
+	fs.SetPrefetchHint("a/b", libkbfs.PrefetchHintNone)
+	require.Equal(t, libkbfs.PrefetchHintSequential, fs.prefetchHint("a"))
+	require.Equal(t, libkbfs.PrefetchHintSequential, fs.prefetchHint("a/c"))
+	require.Equal(t, libkbfs.PrefetchHintNone, fs.prefetchHint("a/b/c"))
+	require.Equal(t, libkbfs.PrefetchHintDefault, fs.prefetchHint("ab"))
+
+	t.Log("Hints are shared with chrooted FSes, relative to the TLF")
+	err := fs.MkdirAll("a/b", 0755)
+	require.NoError(t, err)
+	chrootFS, err := fs.ChrootAsLibFS("a")
+	require.NoError(t, err)
+	require.Equal(t, libkbfs.PrefetchHintNone, chrootFS.prefetchHint("b/c"))
+	chrootFS.SetPrefetchHint("b", libkbfs.PrefetchHintDefault)
+	require.Equal(t,
+		libkbfs.PrefetchHintSequential, fs.prefetchHint("a/b/c"))
+}
+
+func TestConflictsFile(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	fb := fs.RootNode().GetFolderBranch()
+	data, _, err := GetEncodedConflictStatus(ctx, fs.config, fb)
+	require.NoError(t, err)
+	var status ConflictStatus
+	err = json.Unmarshal(data, &status)
+	require.NoError(t, err)
+	require.False(t, status.Staged)
+	require.Len(t, status.UnmergedPaths, 0)
+
+	a, err := ParseConflictAction([]byte("resolve\n"))
+	require.NoError(t, err)
+	require.Equal(t, ConflictResolve, a)
+	_, err = ParseConflictAction([]byte("bogus"))
+	require.Error(t, err)
+
+	t.Log("Commands are no-ops on a merged TLF")
+	n, err := HandleConflictsFileWrite(ctx, fs.config, fb, []byte("resolve"))
+	require.NoError(t, err)
+	require.Equal(t, 7, n)
+	n, err = HandleConflictsFileWrite(ctx, fs.config, fb, []byte("clear\n"))
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+	_, err = HandleConflictsFileWrite(ctx, fs.config, fb, []byte("bogus"))
+	require.Error(t, err)
+}
@@ -22,7 +22,7 @@ func (d *dir) Readdir(count int) (fis []os.FileInfo, err error) {
 		err = translateErr(err)
 	}()
 
-	return d.fs.readDir(d.node)
+	return d.fs.readDir(d.node, d.dirname)
 }
 
 // fileOrDir is a wrapper around billy FS types that satisfies http.File, which
@@ -0,0 +1,87 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"path"
+	"sync"
+
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// prefetchHints maps TLF-relative paths to the prefetch hint that
+// applies to the subtree rooted there.  It's shared with all the FSes
+// chrooted from the FS that made it.
+type prefetchHints struct {
+	lock  sync.RWMutex
+	hints map[string]libkbfs.PrefetchHint
+}
+
+func newPrefetchHints() *prefetchHints {
+	return &prefetchHints{hints: make(map[string]libkbfs.PrefetchHint)}
+}
+
+// tlfPath returns `p`, which is relative to the root of fs, as a
+// path relative to the root of the TLF.
+func (fs *FS) tlfPath(p string) string {
+	p = path.Clean(path.Join(fs.subdir, p))
+	if p == "." || p == "/" {
+		return ""
+	}
+	return p
+}
+
+// SetPrefetchHint sets the prefetch hint for everything under `p`
+// (including `p` itself), overriding any hint set for a parent of
+// `p`.  Use libkbfs.PrefetchHintSequential for trees that will be
+// read from start to end, and libkbfs.PrefetchHintNone for
+// random-access reads (like git packfiles) where prefetched blocks
+// would just be wasted bandwidth.  Setting libkbfs.PrefetchHintDefault
+// removes any hint set directly on `p`.
+func (fs *FS) SetPrefetchHint(p string, hint libkbfs.PrefetchHint) {
+	fs.log.CDebugf(fs.ctx, "SetPrefetchHint %s %s", p, hint)
+	p = fs.tlfPath(p)
+	fs.prefetchHints.lock.Lock()
+	defer fs.prefetchHints.lock.Unlock()
+	if hint == libkbfs.PrefetchHintDefault {
+		delete(fs.prefetchHints.hints, p)
+		return
+	}
+	fs.prefetchHints.hints[p] = hint
+}
+
+// prefetchHint returns the hint set on `p` or on its closest parent,
+// where `p` is relative to the root of fs.
+func (fs *FS) prefetchHint(p string) libkbfs.PrefetchHint {
+	p = fs.tlfPath(p)
+	fs.prefetchHints.lock.RLock()
+	defer fs.prefetchHints.lock.RUnlock()
+	if len(fs.prefetchHints.hints) == 0 {
+		return libkbfs.PrefetchHintDefault
+	}
+	for {
+		if hint, ok := fs.prefetchHints.hints[p]; ok {
+			return hint
+		}
+		if p == "" {
+			return libkbfs.PrefetchHintDefault
+		}
+		p = path.Dir(p)
+		if p == "." {
+			p = ""
+		}
+	}
+}
+
+// ctxWithPrefetchHint returns fs.ctx, with the prefetch hint for `p`
+// attached if there is one.
+func (fs *FS) ctxWithPrefetchHint(p string) context.Context {
+	hint := fs.prefetchHint(p)
+	if hint == libkbfs.PrefetchHintDefault {
+		return fs.ctx
+	}
+	return libkbfs.CtxWithPrefetchHint(fs.ctx, hint)
+}
@@ -0,0 +1,590 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// RevisionFS is a read-only view of a TLF as it existed at a past MD
+// revision, which implements the billy.Filesystem interface.  It
+// uses forward-slash separated paths.  It reads the directory and
+// file blocks of that revision directly, so it only works as long as
+// the server hasn't reclaimed them yet.  Every operation that would
+// modify the FS fails with billy.ErrReadOnly.
+type RevisionFS struct {
+	// Like in FS, the billy.Filesystem interface doesn't give us a
+	// way to accept ctxs any other way.
+	ctx      context.Context
+	config   libkbfs.Config
+	h        *libkbfs.TlfHandle
+	md       libkbfs.ImmutableRootMetadata
+	root     libkbfs.DirEntry
+	subdir   string
+	log      logger.Logger
+	deferLog logger.Logger
+}
+
+var _ billy.Filesystem = (*RevisionFS)(nil)
+
+func getMDForRevision(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	rev kbfsmd.Revision) (libkbfs.ImmutableRootMetadata, error) {
+	irmds, err := config.MDOps().GetRange(
+		ctx, tlfHandle.TlfID(), rev, rev, nil)
+	if err != nil {
+		return libkbfs.ImmutableRootMetadata{}, err
+	}
+	if len(irmds) != 1 {
+		return libkbfs.ImmutableRootMetadata{}, errors.Errorf(
+			"No metadata found for revision %d of %s",
+			rev, tlfHandle.GetCanonicalPath())
+	}
+	if !irmds[0].IsReadable() {
+		return libkbfs.ImmutableRootMetadata{}, errors.Errorf(
+			"Revision %d of %s isn't readable",
+			rev, tlfHandle.GetCanonicalPath())
+	}
+	return irmds[0], nil
+}
+
+// revisionForTime returns the latest merged revision of the TLF made
+// no later than `t`.
+func revisionForTime(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	t time.Time) (kbfsmd.Revision, error) {
+	head, err := config.MDOps().GetForTLF(ctx, tlfHandle.TlfID(), nil)
+	if err != nil {
+		return kbfsmd.RevisionUninitialized, err
+	}
+	if head == (libkbfs.ImmutableRootMetadata{}) {
+		return kbfsmd.RevisionUninitialized, errors.Errorf(
+			"%s has no history", tlfHandle.GetCanonicalPath())
+	}
+
+	// Binary search for the last revision not after `t`.
+	lo, hi := kbfsmd.RevisionInitial, head.Revision()
+	found := kbfsmd.RevisionUninitialized
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		irmd, err := getMDForRevision(ctx, config, tlfHandle, mid)
+		if err != nil {
+			return kbfsmd.RevisionUninitialized, err
+		}
+		if irmd.LocalTimestamp().After(t) {
+			hi = mid - 1
+		} else {
+			found = mid
+			lo = mid + 1
+		}
+	}
+
+	if found == kbfsmd.RevisionUninitialized {
+		return kbfsmd.RevisionUninitialized, errors.Errorf(
+			"%s didn't exist yet at %s", tlfHandle.GetCanonicalPath(), t)
+	}
+	return found, nil
+}
+
+// NewReadonlyFSAtRevision returns a new RevisionFS instance showing
+// the root of the given TLF as of the merged MD revision `rev`.
+func NewReadonlyFSAtRevision(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle, rev kbfsmd.Revision) (*RevisionFS, error) {
+	md, err := getMDForRevision(ctx, config, tlfHandle, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	log := config.MakeLogger("")
+	log.CDebugf(ctx, "Made new revision FS for TLF=%s, rev=%d",
+		tlfHandle.GetCanonicalName(), rev)
+	return &RevisionFS{
+		ctx:      ctx,
+		config:   config,
+		h:        tlfHandle,
+		md:       md,
+		root:     md.Data().Dir,
+		log:      log,
+		deferLog: log.CloneWithAddedDepth(1),
+	}, nil
+}
+
+// NewReadonlyFSAtTime returns a new RevisionFS instance showing the
+// root of the given TLF as of the latest merged MD revision made no
+// later than `t`.
+func NewReadonlyFSAtTime(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle, t time.Time) (*RevisionFS, error) {
+	rev, err := revisionForTime(ctx, config, tlfHandle, t)
+	if err != nil {
+		return nil, err
+	}
+	return NewReadonlyFSAtRevision(ctx, config, tlfHandle, rev)
+}
+
+// Revision returns the MD revision shown by this FS.
+func (rfs *RevisionFS) Revision() kbfsmd.Revision {
+	return rfs.md.Revision()
+}
+
+// Time returns the time at which the revision shown by this FS was
+// made, according to the clock of the device that made it.
+func (rfs *RevisionFS) Time() time.Time {
+	return rfs.md.LocalTimestamp()
+}
+
+// getChildren returns all the entries of the directory whose
+// top block is described by `info`.
+func (rfs *RevisionFS) getChildren(info libkbfs.BlockInfo) (
+	map[string]libkbfs.DirEntry, error) {
+	var dirBlock libkbfs.DirBlock
+	err := rfs.config.BlockOps().Get(
+		rfs.ctx, rfs.md, info.BlockPointer, &dirBlock,
+		libkbfs.TransientEntry)
+	if err != nil {
+		return nil, err
+	}
+	if !dirBlock.IsInd {
+		return dirBlock.Children, nil
+	}
+
+	children := make(map[string]libkbfs.DirEntry)
+	for _, iptr := range dirBlock.IPtrs {
+		iptrChildren, err := rfs.getChildren(iptr.BlockInfo)
+		if err != nil {
+			return nil, err
+		}
+		for name, de := range iptrChildren {
+			children[name] = de
+		}
+	}
+	return children, nil
+}
+
+// lookupWithDepth returns the entry for `filename`, following
+// symlinks in the path.  A symlink in the final path component is
+// only followed if `followLast` is true.
+func (rfs *RevisionFS) lookupWithDepth(
+	filename string, followLast bool, depth int) (libkbfs.DirEntry, error) {
+	filename = strings.TrimPrefix(path.Clean("/"+filename), "/")
+	de := rfs.root
+	if filename == "" {
+		return de, nil
+	}
+
+	parts := strings.Split(filename, "/")
+	for i, p := range parts {
+		if de.Type != libkbfs.Dir {
+			return libkbfs.DirEntry{}, errors.Errorf(
+				"%s is not a directory", path.Join(parts[:i]...))
+		}
+		children, err := rfs.getChildren(de.BlockInfo)
+		if err != nil {
+			return libkbfs.DirEntry{}, err
+		}
+		child, ok := children[p]
+		if !ok {
+			return libkbfs.DirEntry{}, libkbfs.NoSuchNameError{Name: p}
+		}
+		de = child
+
+		if de.Type != libkbfs.Sym || (i == len(parts)-1 && !followLast) {
+			continue
+		}
+		if depth == maxSymlinkLevels {
+			return libkbfs.DirEntry{}, errors.New(
+				"Too many levels of symlinks")
+		}
+		newPath, err := followSymlink(path.Join(parts[:i]...), de.SymPath)
+		if err != nil {
+			return libkbfs.DirEntry{}, err
+		}
+		newPathPlusRemainder := append([]string{newPath}, parts[i+1:]...)
+		return rfs.lookupWithDepth(
+			path.Join(newPathPlusRemainder...), followLast, depth+1)
+	}
+	return de, nil
+}
+
+func (rfs *RevisionFS) lookup(filename string, followLast bool) (
+	libkbfs.DirEntry, error) {
+	return rfs.lookupWithDepth(filename, followLast, 0)
+}
+
+// OpenFile implements the billy.Filesystem interface for RevisionFS.
+// Only read-only opens are allowed.
+func (rfs *RevisionFS) OpenFile(filename string, flag int, perm os.FileMode) (
+	f billy.File, err error) {
+	rfs.log.CDebugf(rfs.ctx, "OpenFile %s, flag=%d", filename, flag)
+	defer func() {
+		rfs.deferLog.CDebugf(rfs.ctx, "OpenFile done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, billy.ErrReadOnly
+	}
+
+	de, err := rfs.lookup(filename, true)
+	if err != nil {
+		return nil, err
+	}
+	if de.Type == libkbfs.Dir {
+		return nil, errors.Errorf("%s is a directory", filename)
+	}
+	return &revisionFile{rfs: rfs, name: filename, de: de}, nil
+}
+
+// Create implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+// Open implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Open(filename string) (billy.File, error) {
+	return rfs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// Stat implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Stat(filename string) (fi os.FileInfo, err error) {
+	rfs.log.CDebugf(rfs.ctx, "Stat %s", filename)
+	defer func() {
+		rfs.deferLog.CDebugf(rfs.ctx, "Stat done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	de, err := rfs.lookup(filename, true)
+	if err != nil {
+		return nil, err
+	}
+	return revisionFileInfo{path.Base(path.Join("/", filename)), de}, nil
+}
+
+// Lstat implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Lstat(filename string) (fi os.FileInfo, err error) {
+	rfs.log.CDebugf(rfs.ctx, "Lstat %s", filename)
+	defer func() {
+		rfs.deferLog.CDebugf(rfs.ctx, "Lstat done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	de, err := rfs.lookup(filename, false)
+	if err != nil {
+		return nil, err
+	}
+	return revisionFileInfo{path.Base(path.Join("/", filename)), de}, nil
+}
+
+// ReadDir implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) ReadDir(p string) (fis []os.FileInfo, err error) {
+	rfs.log.CDebugf(rfs.ctx, "ReadDir %s", p)
+	defer func() {
+		rfs.deferLog.CDebugf(rfs.ctx, "ReadDir done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	de, err := rfs.lookup(p, true)
+	if err != nil {
+		return nil, err
+	}
+	if de.Type != libkbfs.Dir {
+		return nil, errors.Errorf("%s is not a directory", p)
+	}
+	children, err := rfs.getChildren(de.BlockInfo)
+	if err != nil {
+		return nil, err
+	}
+	for name, childDE := range children {
+		fis = append(fis, revisionFileInfo{name, childDE})
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
+
+// Readlink implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Readlink(link string) (target string, err error) {
+	rfs.log.CDebugf(rfs.ctx, "Readlink %s", link)
+	defer func() {
+		rfs.deferLog.CDebugf(rfs.ctx, "Readlink done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	de, err := rfs.lookup(link, false)
+	if err != nil {
+		return "", err
+	}
+	if de.Type != libkbfs.Sym {
+		return "", errors.Errorf("%s is not a symlink", link)
+	}
+	return de.SymPath, nil
+}
+
+// Rename implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Rename(oldpath, newpath string) error {
+	return billy.ErrReadOnly
+}
+
+// Remove implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Remove(filename string) error {
+	return billy.ErrReadOnly
+}
+
+// Join implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Join(elem ...string) string {
+	return path.Clean(path.Join(elem...))
+}
+
+// TempFile implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+// MkdirAll implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) MkdirAll(filename string, perm os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+// Symlink implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Symlink(target, link string) error {
+	return billy.ErrReadOnly
+}
+
+// Chroot implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Chroot(p string) (newFS billy.Filesystem, err error) {
+	rfs.log.CDebugf(rfs.ctx, "Chroot %s", p)
+	defer func() {
+		rfs.deferLog.CDebugf(rfs.ctx, "Chroot done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	de, err := rfs.lookup(p, true)
+	if err != nil {
+		return nil, err
+	}
+	if de.Type != libkbfs.Dir {
+		return nil, errors.Errorf("%s is not a directory", p)
+	}
+	newRFS := *rfs
+	newRFS.root = de
+	newRFS.subdir = path.Clean(path.Join(rfs.subdir, p))
+	return &newRFS, nil
+}
+
+// Root implements the billy.Filesystem interface for RevisionFS.
+func (rfs *RevisionFS) Root() string {
+	return path.Join(rfs.h.GetCanonicalPath(), rfs.subdir)
+}
+
+// revisionFileInfo describes an entry of a RevisionFS.  Nothing in
+// an old revision can be written, so none of the modes include write
+// permissions.
+type revisionFileInfo struct {
+	name string
+	de   libkbfs.DirEntry
+}
+
+var _ os.FileInfo = revisionFileInfo{}
+
+// Name implements the os.FileInfo interface for revisionFileInfo.
+func (rfi revisionFileInfo) Name() string {
+	return rfi.name
+}
+
+// Size implements the os.FileInfo interface for revisionFileInfo.
+func (rfi revisionFileInfo) Size() int64 {
+	return int64(rfi.de.Size)
+}
+
+// Mode implements the os.FileInfo interface for revisionFileInfo.
+func (rfi revisionFileInfo) Mode() os.FileMode {
+	mode := os.FileMode(0400)
+	switch rfi.de.Type {
+	case libkbfs.Dir:
+		mode |= os.ModeDir | 0100
+	case libkbfs.Sym:
+		mode |= os.ModeSymlink
+	case libkbfs.Exec:
+		mode |= 0100
+	}
+	return mode
+}
+
+// ModTime implements the os.FileInfo interface for revisionFileInfo.
+func (rfi revisionFileInfo) ModTime() time.Time {
+	return time.Unix(0, rfi.de.Mtime)
+}
+
+// IsDir implements the os.FileInfo interface for revisionFileInfo.
+func (rfi revisionFileInfo) IsDir() bool {
+	return rfi.de.Type == libkbfs.Dir
+}
+
+// Sys implements the os.FileInfo interface for revisionFileInfo.
+func (rfi revisionFileInfo) Sys() interface{} {
+	return rfi.de.EntryInfo
+}
+
+// revisionFile is a read-only file in a RevisionFS.
+type revisionFile struct {
+	rfs  *RevisionFS
+	name string
+	de   libkbfs.DirEntry
+
+	lock   sync.Mutex
+	offset int64
+}
+
+var _ billy.File = (*revisionFile)(nil)
+
+// readBlocks copies the part of the file block tree rooted at `info`,
+// which starts at file offset `blockOff`, that overlaps with `dest`,
+// which starts at file offset `off`.  Holes are left untouched.
+func (rf *revisionFile) readBlocks(
+	info libkbfs.BlockInfo, blockOff int64, dest []byte, off int64) error {
+	var fileBlock libkbfs.FileBlock
+	err := rf.rfs.config.BlockOps().Get(
+		rf.rfs.ctx, rf.rfs.md, info.BlockPointer, &fileBlock,
+		libkbfs.TransientEntry)
+	if err != nil {
+		return err
+	}
+
+	if !fileBlock.IsInd {
+		destStart, srcStart := int64(0), int64(0)
+		if blockOff > off {
+			destStart = blockOff - off
+		} else {
+			srcStart = off - blockOff
+		}
+		if destStart < int64(len(dest)) &&
+			srcStart < int64(len(fileBlock.Contents)) {
+			copy(dest[destStart:], fileBlock.Contents[srcStart:])
+		}
+		return nil
+	}
+
+	end := off + int64(len(dest))
+	for i, iptr := range fileBlock.IPtrs {
+		nextOff := int64(math.MaxInt64)
+		if i+1 < len(fileBlock.IPtrs) {
+			nextOff = fileBlock.IPtrs[i+1].Off
+		}
+		if iptr.Off >= end || nextOff <= off {
+			continue
+		}
+		err := rf.readBlocks(iptr.BlockInfo, iptr.Off, dest, off)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name implements the billy.File interface for revisionFile.
+func (rf *revisionFile) Name() string {
+	return rf.name
+}
+
+// Write implements the billy.File interface for revisionFile.
+func (rf *revisionFile) Write(p []byte) (n int, err error) {
+	return 0, billy.ErrReadOnly
+}
+
+// ReadAt implements the billy.File interface for revisionFile.
+func (rf *revisionFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.Errorf("Invalid read offset %d", off)
+	}
+	size := int64(rf.de.Size)
+	if off >= size {
+		return 0, io.EOF
+	}
+	n = len(p)
+	if int64(n) > size-off {
+		n = int(size - off)
+	}
+	buf := p[:n]
+	for i := range buf {
+		buf[i] = 0
+	}
+	err = rf.readBlocks(rf.de.BlockInfo, 0, buf, off)
+	if err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements the billy.File interface for revisionFile.
+func (rf *revisionFile) Read(p []byte) (n int, err error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	n, err = rf.ReadAt(p, rf.offset)
+	rf.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements the billy.File interface for revisionFile.
+func (rf *revisionFile) Seek(offset int64, whence int) (n int64, err error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rf.offset
+	case io.SeekEnd:
+		offset += int64(rf.de.Size)
+	default:
+		return 0, errors.Errorf("Invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.Errorf("Invalid seek offset %d", offset)
+	}
+	rf.offset = offset
+	return offset, nil
+}
+
+// Close implements the billy.File interface for revisionFile.
+func (rf *revisionFile) Close() error {
+	return nil
+}
+
+// Lock implements the billy.File interface for revisionFile.  Old
+// revisions never change, so there's nothing to lock.
+func (rf *revisionFile) Lock() error {
+	return nil
+}
+
+// Unlock implements the billy.File interface for revisionFile.
+func (rf *revisionFile) Unlock() error {
+	return nil
+}
+
+// Truncate implements the billy.File interface for revisionFile.
+func (rf *revisionFile) Truncate(size int64) error {
+	return billy.ErrReadOnly
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// Syncer is implemented by filesystems (like FS) that buffer writes
+// until they're explicitly synced.
+type Syncer interface {
+	SyncAll() error
+}
+
+// SyncFS syncs `fs` if it's a Syncer, and does nothing otherwise.
+func SyncFS(fs billy.Filesystem) error {
+	if s, ok := fs.(Syncer); ok {
+		return s.SyncAll()
+	}
+	return nil
+}
+
+// CheckLoopbackAddr returns an error unless `addr` is a host:port
+// address whose host is "localhost" or a loopback IP.
+func CheckLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s is not a loopback address", addr)
+	}
+	return nil
+}
+
+// ListenerServer is a server that accepts connections from a
+// listener, like *http.Server.
+type ListenerServer interface {
+	Serve(l net.Listener) error
+}
+
+// ListenerMounter lets a MountInterrupter start and stop a
+// ListenerServer as if it were a mount.
+type ListenerMounter struct {
+	server   ListenerServer
+	listener net.Listener
+}
+
+var _ Mounter = (*ListenerMounter)(nil)
+
+// NewListenerMounter returns a new ListenerMounter that serves
+// `server` on `listener`.
+func NewListenerMounter(
+	server ListenerServer, listener net.Listener) *ListenerMounter {
+	return &ListenerMounter{server, listener}
+}
+
+// Mount implements the Mounter interface for ListenerMounter.
+func (m *ListenerMounter) Mount() error {
+	go m.server.Serve(m.listener)
+	return nil
+}
+
+// Unmount implements the Mounter interface for ListenerMounter.  An
+// *http.Server is closed along with all its connections; any other
+// server just stops getting new ones.
+func (m *ListenerMounter) Unmount() error {
+	if hs, ok := m.server.(*http.Server); ok {
+		err := hs.Close()
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	}
+	return m.listener.Close()
+}
+
+// StartServer starts KBFS, and then serves it with the Mounter
+// returned by `makeMounter` until interrupted.  It's for the
+// gateways that serve KBFS over some protocol, rather than mounting
+// it.
+func StartServer(params libkbfs.InitParams, kbCtx libkbfs.Context,
+	makeMounter func(libkbfs.Config, logger.Logger) (Mounter, error)) *Error {
+	log, err := libkbfs.InitLog(params, kbCtx)
+	if err != nil {
+		return InitError(err.Error())
+	}
+
+	log.Debug("Initializing")
+	mi := NewMountInterrupter(log)
+	ctx := context.Background()
+	config, err := libkbfs.Init(ctx, kbCtx, params, nil, mi.Done, log)
+	if err != nil {
+		return InitError(err.Error())
+	}
+	defer libkbfs.Shutdown()
+
+	mounter, err := makeMounter(config, log)
+	if err != nil {
+		return InitError(err.Error())
+	}
+	err = mi.MountAndSetUnmount(mounter)
+	if err != nil {
+		if lm, ok := mounter.(*ListenerMounter); ok {
+			lm.listener.Close()
+		}
+		return MountError(err.Error())
+	}
+
+	mi.Wait()
+	return nil
+}
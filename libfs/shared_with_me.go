@@ -0,0 +1,269 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// SharedWithMeDirName is the name of the KBFS "shared with me"
+// directory, which links to the folders other users share with the
+// logged-in user, and to the files they edited recently.  It can be
+// reached from the root of the KBFS mount.
+const SharedWithMeDirName = ".kbfs_shared_with_me"
+
+// SharedWithMeRecentDirName is the name of the subdirectory of the
+// "shared with me" directory that links to recently-edited files.
+const SharedWithMeRecentDirName = "recent"
+
+// maxSharedWithMeFiles is how many recently-edited files are shown.
+const maxSharedWithMeFiles = 50
+
+// SharedFile is a file recently edited by another user, in a folder
+// shared with the logged-in user.
+type SharedFile struct {
+	// Folder is the path of the folder, relative to the KBFS root,
+	// e.g. "private/alice,bob".
+	Folder string
+	// Path is the path of the file, relative to the folder.
+	Path   string
+	Writer libkb.NormalizedUsername
+	Mtime  time.Time
+}
+
+// SharedFolder is a favorite folder that the logged-in user shares
+// with others.
+type SharedFolder struct {
+	Name tlf.CanonicalName
+	Type tlf.Type
+	// LastActivity is the time of the latest edit by another user,
+	// and is zero if there are none in the folder's edit history.
+	LastActivity time.Time
+	// Writers are the other users with edits in the folder's edit
+	// history, most recent first.
+	Writers []libkb.NormalizedUsername
+}
+
+// Path returns the path of the folder, relative to the KBFS root.
+func (sf SharedFolder) Path() string {
+	return path.Join(TlfTypePathString(sf.Type), string(sf.Name))
+}
+
+// SharedWithMe is what's been shared with the logged-in user.
+type SharedWithMe struct {
+	// Folders are sorted by last activity, most recent first.
+	Folders []SharedFolder
+	// Files are sorted by mtime, most recent first.
+	Files []SharedFile
+}
+
+// SharedWithMeLink is an entry in the "shared with me" directory, or
+// in its recent subdirectory.
+type SharedWithMeLink struct {
+	Name string
+	// Target is relative to the directory containing the link.
+	Target string
+	Mtime  time.Time
+}
+
+// isSharedWithUser returns true if `h` names anyone besides `uid`.
+func isSharedWithUser(h *libkbfs.TlfHandle, uid keybase1.UID) bool {
+	if h.Type() == tlf.SingleTeam {
+		return true
+	}
+	if len(h.UnresolvedWriters()) > 0 || len(h.UnresolvedReaders()) > 0 {
+		return true
+	}
+	for u := range h.ResolvedUsersMap() {
+		if u != uid.AsUserOrTeam() {
+			return true
+		}
+	}
+	return false
+}
+
+// getSharedFiles returns the latest edit by another user to each
+// file in the edit history of the folder `h`, newest first.
+func getSharedFiles(ctx context.Context, config libkbfs.Config,
+	h *libkbfs.TlfHandle, uid keybase1.UID, folder string) (
+	[]SharedFile, error) {
+	rootNode, _, err := config.KBFSOps().GetRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	if err != nil {
+		return nil, err
+	}
+	if rootNode == nil {
+		// Nothing has been written to the folder yet.
+		return nil, nil
+	}
+	edits, err := config.KBFSOps().GetEditHistory(
+		ctx, rootNode.GetFolderBranch())
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]SharedFile)
+	for writer, list := range edits {
+		if writer == uid {
+			continue
+		}
+		name, err := config.KBPKI().GetNormalizedUsername(
+			ctx, writer.AsUserOrTeam())
+		if err != nil {
+			return nil, err
+		}
+		for _, edit := range list {
+			if edit.Type == libkbfs.FileDeleted {
+				continue
+			}
+			// Edit paths start with the name of the TLF root.
+			parts := strings.SplitN(edit.Filepath, "/", 2)
+			if len(parts) < 2 {
+				continue
+			}
+			p := parts[1]
+			if f, ok := latest[p]; ok && !edit.LocalTime.After(f.Mtime) {
+				continue
+			}
+			latest[p] = SharedFile{
+				Folder: folder,
+				Path:   p,
+				Writer: name,
+				Mtime:  edit.LocalTime,
+			}
+		}
+	}
+	files := make([]SharedFile, 0, len(latest))
+	for _, f := range latest {
+		files = append(files, f)
+	}
+	sortSharedFiles(files)
+	return files, nil
+}
+
+func sortSharedFiles(files []SharedFile) {
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].Mtime.Equal(files[j].Mtime) {
+			return files[i].Mtime.After(files[j].Mtime)
+		}
+		if files[i].Folder != files[j].Folder {
+			return files[i].Folder < files[j].Folder
+		}
+		return files[i].Path < files[j].Path
+	})
+}
+
+// GetSharedWithMe returns the logged-in user's favorite folders that
+// are shared with other users, and the files in them that other users
+// edited recently.  Folders that can't be read are left out.
+func GetSharedWithMe(ctx context.Context, config libkbfs.Config) (
+	sharedWithMe SharedWithMe, err error) {
+	log := config.MakeLogger("")
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return SharedWithMe{}, err
+	}
+	favs, err := config.KBFSOps().GetFavorites(ctx)
+	if err != nil {
+		return SharedWithMe{}, err
+	}
+
+	for _, fav := range favs {
+		h, err := libkbfs.GetHandleFromFolderNameAndType(
+			ctx, config.KBPKI(), config.MDOps(), fav.Name, fav.Type)
+		if err != nil {
+			log.CDebugf(ctx, "Skipping favorite %s/%s: %+v",
+				fav.Type, fav.Name, err)
+			continue
+		}
+		if !isSharedWithUser(h, session.UID) {
+			continue
+		}
+		folder := SharedFolder{Name: h.GetCanonicalName(), Type: h.Type()}
+		files, err := getSharedFiles(
+			ctx, config, h, session.UID, folder.Path())
+		if err != nil {
+			log.CDebugf(ctx, "Skipping favorite %s: %+v",
+				folder.Path(), err)
+			continue
+		}
+		seenWriters := make(map[libkb.NormalizedUsername]bool)
+		for _, f := range files {
+			if seenWriters[f.Writer] {
+				continue
+			}
+			seenWriters[f.Writer] = true
+			folder.Writers = append(folder.Writers, f.Writer)
+		}
+		if len(files) > 0 {
+			folder.LastActivity = files[0].Mtime
+		}
+		sharedWithMe.Folders = append(sharedWithMe.Folders, folder)
+		sharedWithMe.Files = append(sharedWithMe.Files, files...)
+	}
+
+	sort.Slice(sharedWithMe.Folders, func(i, j int) bool {
+		fi, fj := sharedWithMe.Folders[i], sharedWithMe.Folders[j]
+		if !fi.LastActivity.Equal(fj.LastActivity) {
+			return fi.LastActivity.After(fj.LastActivity)
+		}
+		return fi.Path() < fj.Path()
+	})
+	sortSharedFiles(sharedWithMe.Files)
+	if len(sharedWithMe.Files) > maxSharedWithMeFiles {
+		sharedWithMe.Files = sharedWithMe.Files[:maxSharedWithMeFiles]
+	}
+	return sharedWithMe, nil
+}
+
+// FolderLinks returns the links for the "shared with me" directory,
+// one for each shared folder.  Links are named after the folder and
+// its type, since e.g. a private and a public folder can have the
+// same name.
+func (s SharedWithMe) FolderLinks() []SharedWithMeLink {
+	links := make([]SharedWithMeLink, 0, len(s.Folders))
+	for _, f := range s.Folders {
+		links = append(links, SharedWithMeLink{
+			Name:   fmt.Sprintf("%s (%s)", f.Name, TlfTypePathString(f.Type)),
+			Target: path.Join("..", f.Path()),
+			Mtime:  f.LastActivity,
+		})
+	}
+	return links
+}
+
+// RecentLinks returns the links for the recent subdirectory of the
+// "shared with me" directory, one for each recently-edited file.
+// Links are named after the file, with a number added if several
+// files have the same name.
+func (s SharedWithMe) RecentLinks() []SharedWithMeLink {
+	links := make([]SharedWithMeLink, 0, len(s.Files))
+	used := make(map[string]bool, len(s.Files))
+	for _, f := range s.Files {
+		base := path.Base(f.Path)
+		name := base
+		for i := 2; used[name]; i++ {
+			ext := path.Ext(base)
+			name = fmt.Sprintf("%s (%d)%s", base[:len(base)-len(ext)], i, ext)
+		}
+		used[name] = true
+		links = append(links, SharedWithMeLink{
+			Name:   name,
+			Target: path.Join("..", "..", f.Folder, f.Path),
+			Mtime:  f.Mtime,
+		})
+	}
+	return links
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+)
+
+// ObsoleteTrackingFS is an FS, along with a way to tell when it has
+// become obsolete (e.g., because its TLF was reset), and needs to be
+// replaced by a new one.
+type ObsoleteTrackingFS struct {
+	FS *FS
+	ch <-chan struct{}
+}
+
+// NewObsoleteTrackingFS returns an ObsoleteTrackingFS for `fs`.
+func NewObsoleteTrackingFS(fs *FS) (ObsoleteTrackingFS, error) {
+	ch, err := fs.SubscribeToObsolete()
+	if err != nil {
+		return ObsoleteTrackingFS{}, err
+	}
+	return ObsoleteTrackingFS{FS: fs, ch: ch}, nil
+}
+
+// IsObsolete returns true if the FS has become obsolete.
+func (e ObsoleteTrackingFS) IsObsolete() bool {
+	select {
+	case <-e.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// TlfFSCache keeps an FS for the root of each TLF it's asked for,
+// and replaces it whenever it becomes obsolete.  It's for servers
+// that keep serving the same TLFs for a long time.
+type TlfFSCache struct {
+	config libkbfs.Config
+
+	lock sync.Mutex
+	fs   map[tlfFSCacheKey]ObsoleteTrackingFS
+}
+
+type tlfFSCacheKey struct {
+	tlfType tlf.Type
+	name    string
+}
+
+// NewTlfFSCache returns a new, empty TlfFSCache.
+func NewTlfFSCache(config libkbfs.Config) *TlfFSCache {
+	return &TlfFSCache{
+		config: config,
+		fs:     make(map[tlfFSCacheKey]ObsoleteTrackingFS),
+	}
+}
+
+func (c *TlfFSCache) getCached(key tlfFSCacheKey) *FS {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cached, ok := c.fs[key]; ok && !cached.IsObsolete() {
+		return cached.FS
+	}
+	return nil
+}
+
+// Get returns the FS for the root of the given TLF.  If a new FS has
+// to be made, it keeps `ctx` for its lifetime, so `ctx` shouldn't be
+// tied to a single request.
+func (c *TlfFSCache) Get(
+	ctx context.Context, tlfType tlf.Type, tlfName string) (*FS, error) {
+	key := tlfFSCacheKey{tlfType, tlfName}
+	if fs := c.getCached(key); fs != nil {
+		return fs, nil
+	}
+
+	// Making a new FS can mean fetching the TLF's MD from the
+	// server, so don't hold the lock while doing it, or requests for
+	// every other TLF would have to wait.
+	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, c.config.KBPKI(), c.config.MDOps(), tlfName, tlfType)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := NewFS(
+		ctx, c.config, tlfHandle, "", "", keybase1.MDPriorityNormal)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Someone else might have made one in the meantime; if so, use
+	// theirs and just drop this one.
+	if cached, ok := c.fs[key]; ok && !cached.IsObsolete() {
+		return cached.FS, nil
+	}
+	tracked, err := NewObsoleteTrackingFS(fs)
+	if err != nil {
+		return nil, err
+	}
+	c.fs[key] = tracked
+	return fs, nil
+}
+
+// SyncAll syncs the buffered writes of every FS in the cache.
+func (c *TlfFSCache) SyncAll() error {
+	c.lock.Lock()
+	fses := make([]*FS, 0, len(c.fs))
+	for _, cached := range c.fs {
+		fses = append(fses, cached.FS)
+	}
+	c.lock.Unlock()
+
+	for _, fs := range fses {
+		err := fs.SyncAll()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
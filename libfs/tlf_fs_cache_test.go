@@ -0,0 +1,56 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTlfFSCacheConcurrentGet(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "user1", "user2")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	c := NewTlfFSCache(config)
+
+	// Requests racing to make the same TLF's FS must all end up with
+	// the one that got cached.
+	const n = 10
+	type result struct {
+		fs  *FS
+		err error
+	}
+	results := make(chan result, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			fs, err := c.Get(ctx, tlf.Private, "user1")
+			results <- result{fs, err}
+		}()
+	}
+	var first *FS
+	for i := 0; i < n; i++ {
+		r := <-results
+		require.NoError(t, r.err)
+		if first == nil {
+			first = r.fs
+		}
+		require.True(t, first == r.fs)
+	}
+
+	fs, err := c.Get(ctx, tlf.Private, "user1")
+	require.NoError(t, err)
+	require.True(t, first == fs)
+
+	// A different TLF gets a different FS.
+	fs, err = c.Get(ctx, tlf.Public, "user1")
+	require.NoError(t, err)
+	require.True(t, first != fs)
+
+	require.NoError(t, c.SyncAll())
+}
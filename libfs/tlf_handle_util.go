@@ -6,6 +6,7 @@ package libfs
 
 import (
 	"context"
+	"strings"
 
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/tlf"
@@ -35,3 +36,27 @@ func ParseTlfHandlePreferredQuick(
 	kbpki = noImplicitTeamKBPKI{kbpki}
 	return libkbfs.ParseTlfHandlePreferred(ctx, kbpki, nil, name, ty)
 }
+
+// TlfTypePathString returns the path component under which TLFs of
+// the given type live, e.g. "team" for tlf.SingleTeam.
+func TlfTypePathString(t tlf.Type) string {
+	if t == tlf.SingleTeam {
+		return "team"
+	}
+	return t.String()
+}
+
+// ParseTlfPath parses a TLF given as "<type>/<name>", e.g.
+// "private/alice,bob" or "team/acme", into its type and name.
+func ParseTlfPath(p string) (tlfType tlf.Type, name string, err error) {
+	fields := strings.Split(strings.Trim(p, "/"), "/")
+	if len(fields) != 2 || fields[1] == "" {
+		return tlf.Unknown, "", errors.Errorf(
+			"%q is not of the form <type>/<name>", p)
+	}
+	tlfType, err = tlf.ParseTlfTypeFromPath(fields[0])
+	if err != nil {
+		return tlf.Unknown, "", err
+	}
+	return tlfType, fields[1], nil
+}
@@ -0,0 +1,405 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// txnOp is a single buffered change in a Transaction.  `apply` makes
+// the change, and returns a function that undoes it.
+type txnOp struct {
+	desc  string
+	apply func() (undo func() error, err error)
+}
+
+// txnBackup is an in-memory copy of an entry that a transaction is
+// about to overwrite or remove, so it can be put back on rollback.
+// Only files, symlinks and empty directories can be backed up.
+type txnBackup struct {
+	filename string
+	exists   bool
+	typ      libkbfs.EntryType
+	data     []byte
+	symPath  string
+}
+
+// Transaction batches a set of changes to an FS, and applies them in
+// order on Commit.  If any of the changes fails, the ones already
+// applied are undone, by making the opposite changes, before Commit
+// returns.
+//
+// A Transaction needs the TLF's journal to be enabled.  While Commit
+// runs, the journal is kept in single-op mode, so the changes (and
+// any undos) are squashed into a single MD revision, and other
+// readers of the TLF only ever see the state before the transaction
+// or the state after it.  It doesn't keep out concurrent writers,
+// though: other writes made through this device while Commit runs
+// end up in the same revision, and undoing a change puts back the
+// entry as it was when the change was made, overwriting anything
+// written there since.
+type Transaction struct {
+	fs *FS
+
+	lock sync.Mutex
+	ops  []txnOp
+	done bool
+}
+
+// BeginTransaction returns a new, empty Transaction for fs.  Nothing
+// is written to fs until the transaction is committed.
+func (fs *FS) BeginTransaction() *Transaction {
+	return &Transaction{fs: fs}
+}
+
+func (t *Transaction) addOp(desc string,
+	apply func() (undo func() error, err error)) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return errors.New("Transaction is already finished")
+	}
+	t.ops = append(t.ops, txnOp{desc, apply})
+	return nil
+}
+
+func (t *Transaction) backup(filename string) (b txnBackup, err error) {
+	b.filename = filename
+	fi, err := t.fs.Lstat(filename)
+	if os.IsNotExist(err) {
+		return b, nil
+	} else if err != nil {
+		return txnBackup{}, err
+	}
+	kbfsFI, ok := fi.(*FileInfo)
+	if !ok {
+		return txnBackup{}, errors.Errorf("Can't back up %s", filename)
+	}
+	b.exists = true
+	b.typ = kbfsFI.ei.Type
+	switch b.typ {
+	case libkbfs.Sym:
+		b.symPath, err = t.fs.Readlink(filename)
+		if err != nil {
+			return txnBackup{}, err
+		}
+	case libkbfs.Dir:
+		fis, err := t.fs.ReadDir(filename)
+		if err != nil {
+			return txnBackup{}, err
+		}
+		if len(fis) != 0 {
+			return txnBackup{}, errors.Errorf(
+				"Can't back up non-empty directory %s", filename)
+		}
+	default:
+		f, err := t.fs.Open(filename)
+		if err != nil {
+			return txnBackup{}, err
+		}
+		defer f.Close()
+		b.data, err = ioutil.ReadAll(f)
+		if err != nil {
+			return txnBackup{}, err
+		}
+	}
+	return b, nil
+}
+
+func (t *Transaction) restore(b txnBackup) error {
+	_, err := t.fs.Lstat(b.filename)
+	switch {
+	case err == nil:
+		err = t.fs.Remove(b.filename)
+		if err != nil {
+			return err
+		}
+	case os.IsNotExist(err):
+	default:
+		return err
+	}
+
+	if !b.exists {
+		return nil
+	}
+
+	switch b.typ {
+	case libkbfs.Sym:
+		return t.fs.Symlink(b.symPath, b.filename)
+	case libkbfs.Dir:
+		return t.fs.MkdirAll(b.filename, 0755)
+	default:
+		perm := os.FileMode(0600)
+		if b.typ == libkbfs.Exec {
+			perm = 0700
+		}
+		return t.writeFile(b.filename, b.data, perm)
+	}
+}
+
+func (t *Transaction) writeFile(
+	filename string, data []byte, perm os.FileMode) (err error) {
+	f, err := t.fs.OpenFile(
+		filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	return t.fs.Chmod(filename, perm)
+}
+
+// missingDirs returns the directories in `dir` (from the shallowest
+// to the deepest) that don't exist yet.
+func (t *Transaction) missingDirs(dir string) (missing []string, err error) {
+	dir = path.Clean(dir)
+	if dir == "." || dir == "/" {
+		return nil, nil
+	}
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		p := path.Join(parts[:i+1]...)
+		_, err := t.fs.Lstat(p)
+		if os.IsNotExist(err) {
+			missing = append(missing, p)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+func (t *Transaction) removeDirs(dirs []string) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err := t.fs.Remove(dirs[i])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFile adds a change to the transaction that creates or
+// overwrites `filename` with `data`, making any missing parent
+// directories.  Only the executable bit of `perm` is used.
+func (t *Transaction) WriteFile(
+	filename string, data []byte, perm os.FileMode) error {
+	return t.addOp("write "+filename, func() (func() error, error) {
+		b, err := t.backup(filename)
+		if err != nil {
+			return nil, err
+		}
+		dirs, err := t.missingDirs(path.Dir(filename))
+		if err != nil {
+			return nil, err
+		}
+		undo := func() error {
+			err := t.restore(b)
+			if err != nil {
+				return err
+			}
+			return t.removeDirs(dirs)
+		}
+		return undo, t.writeFile(filename, data, perm)
+	})
+}
+
+// MkdirAll adds a change to the transaction that creates `filename`
+// and any missing parent directories.
+func (t *Transaction) MkdirAll(filename string, perm os.FileMode) error {
+	return t.addOp("mkdir "+filename, func() (func() error, error) {
+		dirs, err := t.missingDirs(filename)
+		if err != nil {
+			return nil, err
+		}
+		undo := func() error {
+			return t.removeDirs(dirs)
+		}
+		return undo, t.fs.MkdirAll(filename, perm)
+	})
+}
+
+// Symlink adds a change to the transaction that creates a symlink
+// at `link` pointing to `target`.
+func (t *Transaction) Symlink(target, link string) error {
+	return t.addOp("symlink "+link, func() (func() error, error) {
+		dirs, err := t.missingDirs(path.Dir(link))
+		if err != nil {
+			return nil, err
+		}
+		err = t.fs.Symlink(target, link)
+		if err != nil {
+			return func() error { return t.removeDirs(dirs) }, err
+		}
+		undo := func() error {
+			err := t.fs.Remove(link)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return t.removeDirs(dirs)
+		}
+		return undo, nil
+	})
+}
+
+// Rename adds a change to the transaction that renames `oldpath` to
+// `newpath`, replacing anything already at `newpath`.
+func (t *Transaction) Rename(oldpath, newpath string) error {
+	return t.addOp("rename "+oldpath+" -> "+newpath,
+		func() (func() error, error) {
+			b, err := t.backup(newpath)
+			if err != nil {
+				return nil, err
+			}
+			dirs, err := t.missingDirs(path.Dir(newpath))
+			if err != nil {
+				return nil, err
+			}
+			err = t.fs.Rename(oldpath, newpath)
+			if err != nil {
+				return func() error { return t.removeDirs(dirs) }, err
+			}
+			undo := func() error {
+				err := t.fs.Rename(newpath, oldpath)
+				if err != nil {
+					return err
+				}
+				err = t.restore(b)
+				if err != nil {
+					return err
+				}
+				return t.removeDirs(dirs)
+			}
+			return undo, nil
+		})
+}
+
+// Remove adds a change to the transaction that removes `filename`,
+// which must be a file, a symlink, or an empty directory.
+func (t *Transaction) Remove(filename string) error {
+	return t.addOp("remove "+filename, func() (func() error, error) {
+		b, err := t.backup(filename)
+		if err != nil {
+			return nil, err
+		}
+		err = t.fs.Remove(filename)
+		if err != nil {
+			return nil, err
+		}
+		undo := func() error {
+			return t.restore(b)
+		}
+		return undo, nil
+	})
+}
+
+func (t *Transaction) finish() ([]txnOp, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return nil, errors.New("Transaction is already finished")
+	}
+	t.done = true
+	ops := t.ops
+	t.ops = nil
+	return ops, nil
+}
+
+func (t *Transaction) rollback(undos []func() error) (err error) {
+	for i := len(undos) - 1; i >= 0; i-- {
+		undoErr := undos[i]()
+		if undoErr == nil {
+			undoErr = t.fs.SyncAll()
+		}
+		if undoErr != nil && err == nil {
+			err = undoErr
+		}
+	}
+	return err
+}
+
+// Commit applies all the changes in the transaction, in the order
+// they were added, syncing each one to the journal.  If one of them
+// fails, the changes already applied are undone and the original
+// error is returned.  Either way, it then waits for the journal to
+// flush everything to the server as a single revision.
+func (t *Transaction) Commit() (err error) {
+	fs := t.fs
+	jServer, err := libkbfs.GetJournalServer(fs.config)
+	if err != nil {
+		return err
+	}
+	tlfID := fs.root.GetFolderBranch().Tlf
+	if _, err := jServer.JournalStatus(tlfID); err != nil {
+		return err
+	}
+
+	ops, err := t.finish()
+	if err != nil {
+		return err
+	}
+
+	fs.log.CDebugf(fs.ctx, "Committing transaction with %d ops", len(ops))
+	defer func() {
+		fs.deferLog.CDebugf(fs.ctx, "Commit transaction done: %+v", err)
+	}()
+
+	err = jServer.BeginSingleOp(fs.ctx, tlfID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flushErr := jServer.FinishSingleOp(fs.ctx, tlfID, nil, fs.priority)
+		if err == nil {
+			err = flushErr
+		}
+	}()
+
+	undos := make([]func() error, 0, len(ops))
+	for _, op := range ops {
+		undo, err := op.apply()
+		if undo != nil {
+			// Even a failed op may have partially applied.
+			undos = append(undos, undo)
+		}
+		if err == nil {
+			// Sync each op before the next one, so that no later op
+			// (or undo) removes a file with unsynced writes.  In
+			// single-op mode this only writes to the journal.
+			err = fs.SyncAll()
+		}
+		if err != nil {
+			fs.log.CDebugf(fs.ctx, "Transaction op %q failed: %+v; "+
+				"rolling back", op.desc, err)
+			if rollbackErr := t.rollback(undos); rollbackErr != nil {
+				fs.log.CWarningf(fs.ctx, "Couldn't roll back transaction: "+
+					"%+v", rollbackErr)
+			}
+			return errors.Wrapf(err, "Transaction op %q failed", op.desc)
+		}
+	}
+	return nil
+}
+
+// Rollback discards all the changes in the transaction without
+// applying any of them.
+func (t *Transaction) Rollback() {
+	_, _ = t.finish()
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"flag"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+const (
+	defaultAttrTimeout  = 1 * time.Minute
+	defaultEntryTimeout = 1 * time.Minute
+)
+
+// CacheParams controls how long the kernel may cache what KBFS tells
+// it, trading freshness for fewer FUSE round trips.  Changes made on
+// other devices can take up to these timeouts to show up locally.
+type CacheParams struct {
+	// AttrTimeout is how long the kernel may cache file and
+	// directory attributes.
+	AttrTimeout time.Duration
+	// EntryTimeout is how long the kernel may cache the results of
+	// looking up a name in a directory.
+	EntryTimeout time.Duration
+	// WritebackCache lets the kernel buffer writes and send them in
+	// larger batches.  Only Linux supports it.
+	WritebackCache bool
+}
+
+// DefaultCacheParams returns the CacheParams used when none are
+// given.
+func DefaultCacheParams() CacheParams {
+	return CacheParams{
+		AttrTimeout:  defaultAttrTimeout,
+		EntryTimeout: defaultEntryTimeout,
+	}
+}
+
+// GetCacheUsageString returns a string to be included in a usage
+// string corresponding to the flags added by AddCacheFlags.
+func GetCacheUsageString() string {
+	return "[-attr-timeout=duration] [-entry-timeout=duration] " +
+		"[-writeback-cache]\n    "
+}
+
+// AddCacheFlags adds kernel cache flags to the given FlagSet and
+// returns a CacheParams object that will be filled in when the given
+// FlagSet is parsed.
+func AddCacheFlags(flags *flag.FlagSet) *CacheParams {
+	params := DefaultCacheParams()
+	flags.DurationVar(&params.AttrTimeout, "attr-timeout",
+		params.AttrTimeout,
+		"How long the kernel may cache file attributes")
+	flags.DurationVar(&params.EntryTimeout, "entry-timeout",
+		params.EntryTimeout,
+		"How long the kernel may cache directory entries")
+	flags.BoolVar(&params.WritebackCache, "writeback-cache", false,
+		"Let the kernel buffer writes before sending them to KBFS "+
+			"(Linux only)")
+	return &params
+}
+
+func (c CacheParams) mountOptions() []fuse.MountOption {
+	if !c.WritebackCache {
+		return nil
+	}
+	return []fuse.MountOption{fuse.WritebackCache()}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// ConflictsFile is a special file that reports the conflict state
+// of a TLF when read, and accepts a libfs.ConflictAction command
+// when written.
+type ConflictsFile struct {
+	folder *Folder
+}
+
+var _ fs.Node = (*ConflictsFile)(nil)
+
+func (f *ConflictsFile) read(ctx context.Context) ([]byte, time.Time, error) {
+	return libfs.GetEncodedConflictStatus(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch())
+}
+
+// Attr implements the fs.Node interface for ConflictsFile.
+func (f *ConflictsFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	data, t, err := f.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Like SpecialReadFile, report the real (racy) size.
+	a.Valid = 1 * time.Second
+	a.Size = uint64(len(data))
+	a.Mtime = t
+	a.Ctime = t
+	a.Mode = 0666
+	return nil
+}
+
+var _ fs.NodeOpener = (*ConflictsFile)(nil)
+
+// Open implements the fs.NodeOpener interface for ConflictsFile.
+func (f *ConflictsFile) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	var data []byte
+	if !req.Flags.IsWriteOnly() {
+		var err error
+		data, _, err = f.read(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp.Flags |= fuse.OpenDirectIO
+	return &conflictsFileHandle{f, data}, nil
+}
+
+// conflictsFileHandle is an open ConflictsFile, holding the conflict
+// state as of the time it was opened.
+type conflictsFileHandle struct {
+	f    *ConflictsFile
+	data []byte
+}
+
+var _ fs.HandleReadAller = (*conflictsFileHandle)(nil)
+
+// ReadAll implements the fs.HandleReadAller interface for
+// conflictsFileHandle.
+func (h *conflictsFileHandle) ReadAll(ctx context.Context) ([]byte, error) {
+	return h.data, nil
+}
+
+var _ fs.HandleWriter = (*conflictsFileHandle)(nil)
+
+// Write implements the fs.HandleWriter interface for
+// conflictsFileHandle.
+func (h *conflictsFileHandle) Write(ctx context.Context,
+	req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
+	folder := h.f.folder
+	folder.fs.log.CDebugf(ctx, "ConflictsFile Write")
+	defer func() { err = folder.processError(ctx, libkbfs.WriteMode, err) }()
+	size, err := libfs.HandleConflictsFileWrite(
+		ctx, folder.fs.config, folder.getFolderBranch(), req.Data)
+	if err != nil {
+		return err
+	}
+	resp.Size = size
+	return nil
+}
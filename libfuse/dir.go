@@ -240,7 +240,8 @@ func (f *Folder) localChangeInvalidate(ctx context.Context, node libkbfs.Node,
 // BatchChanges is called for changes originating anywhere, including
 // other hosts.
 func (f *Folder) BatchChanges(
-	ctx context.Context, changes []libkbfs.NodeChange, _ []libkbfs.NodeID) {
+	ctx context.Context, changes []libkbfs.NodeChange,
+	affectedNodeIDs []libkbfs.NodeID) {
 	if !f.fs.conn.Protocol().HasInvalidate() {
 		// OSXFUSE 2.x does not support notifications
 		return
@@ -254,12 +255,16 @@ func (f *Folder) BatchChanges(
 
 	// Handle in the background because we shouldn't lock during the
 	// notification.
-	f.fs.queueNotification(func() { f.batchChangesInvalidate(ctx, changes) })
+	f.fs.queueNotification(func() {
+		f.batchChangesInvalidate(ctx, changes, affectedNodeIDs)
+	})
 }
 
 func (f *Folder) batchChangesInvalidate(ctx context.Context,
-	changes []libkbfs.NodeChange) {
+	changes []libkbfs.NodeChange, affectedNodeIDs []libkbfs.NodeID) {
+	invalidated := make(map[libkbfs.NodeID]bool, len(changes))
 	for _, v := range changes {
+		invalidated[v.Node.GetID()] = true
 		f.nodesMu.Lock()
 		n, ok := f.nodes[v.Node.GetID()]
 		f.nodesMu.Unlock()
@@ -301,6 +306,32 @@ func (f *Folder) batchChangesInvalidate(ctx context.Context,
 			}
 		}
 	}
+
+	// Some updates, like the result of conflict resolution, don't
+	// come with per-node changes, only with the list of nodes whose
+	// data changed.  Drop everything the kernel has cached for
+	// those, so open files and directory listings pick up the new
+	// contents.
+	for _, id := range affectedNodeIDs {
+		if invalidated[id] {
+			continue
+		}
+		invalidated[id] = true
+		f.nodesMu.Lock()
+		n, ok := f.nodes[id]
+		f.nodesMu.Unlock()
+		if !ok {
+			continue
+		}
+
+		if file, ok := n.(*File); ok {
+			file.eiCache.destroy()
+		}
+		if err := f.fs.fuse.InvalidateNodeData(n); err != nil && err != fuse.ErrNotCached {
+			// TODO we have no mechanism to do anything about this
+			f.fs.log.CErrorf(ctx, "FUSE invalidate error: %v", err)
+		}
+	}
 }
 
 // TlfHandleChange is called when the name of a folder changes.
@@ -364,14 +395,14 @@ func (f *Folder) writePermMode(ctx context.Context,
 func (f *Folder) fillAttrWithUIDAndWritePerm(
 	ctx context.Context, node libkbfs.Node, ei *libkbfs.EntryInfo,
 	a *fuse.Attr) (err error) {
-	a.Valid = 1 * time.Minute
+	a.Valid = f.fs.cache.AttrTimeout
 
 	a.Size = ei.Size
 	a.Blocks = getNumBlocksFromSize(ei.Size)
 	a.Mtime = time.Unix(0, ei.Mtime)
 	a.Ctime = time.Unix(0, ei.Ctime)
 
-	a.Uid = uint32(os.Getuid())
+	a.Uid = f.fs.ownership.uid()
 
 	if a.Mode, err = f.writePermMode(ctx, node, a.Mode); err != nil {
 		return err
@@ -380,6 +411,15 @@ func (f *Folder) fillAttrWithUIDAndWritePerm(
 	return nil
 }
 
+// fillAttrOwnership sets the owner and group of `a`, which must
+// already have its owner permissions set.
+func (f *Folder) fillAttrOwnership(a *fuse.Attr) {
+	f.handleMu.RLock()
+	isTeam := f.h.Type() == tlf.SingleTeam
+	f.handleMu.RUnlock()
+	f.fs.ownership.fillAttr(a, isTeam && a.Mode&0200 != 0)
+}
+
 func (f *Folder) isWriter(ctx context.Context) (bool, error) {
 	f.handleMu.RLock()
 	defer f.handleMu.RUnlock()
@@ -387,13 +427,7 @@ func (f *Folder) isWriter(ctx context.Context) (bool, error) {
 }
 
 func (f *Folder) access(ctx context.Context, r *fuse.AccessRequest) error {
-	if int(r.Uid) != os.Getuid() &&
-		// Finder likes to use UID 0 for some operations. osxfuse already allows
-		// ACCESS and GETXATTR requests from root to go through. This allows root
-		// in ACCESS handler. See KBFS-1733 for more details.
-		int(r.Uid) != 0 {
-		// short path: not accessible by anybody other than root or the user who
-		// executed the kbfsfuse process.
+	if !f.fs.ownership.allowsAccess(r) {
 		return fuse.EPERM
 	}
 
@@ -476,6 +510,10 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 	d.folder.fs.log.CDebugf(ctx, "Dir Attr")
 	defer func() { err = d.folder.processError(ctx, libkbfs.ReadMode, err) }()
 
+	if err = d.folder.checkCallerCanRead(ctx); err != nil {
+		return err
+	}
+
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
 		ctx, d.folder.fs.config.DelayedCancellationGracePeriod())
@@ -500,6 +538,7 @@ func (d *Dir) attr(ctx context.Context, a *fuse.Attr) (err error) {
 	}
 
 	a.Mode |= os.ModeDir | 0500
+	d.folder.fillAttrOwnership(a)
 	a.Inode = d.inode
 	return nil
 }
@@ -513,6 +552,10 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 	d.folder.fs.log.CDebugf(ctx, "Dir Lookup %s", req.Name)
 	defer func() { err = d.folder.processError(ctx, libkbfs.ReadMode, err) }()
 
+	if err = d.folder.checkCallerCanRead(ctx); err != nil {
+		return nil, err
+	}
+
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
 		ctx, d.folder.fs.config.DelayedCancellationGracePeriod())
@@ -520,6 +563,12 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 		return nil, err
 	}
 
+	resp.EntryValid = d.folder.fs.cache.EntryTimeout
+
+	if req.Name == libfs.ExportDirName {
+		return &ExportDir{folder: d.folder, node: d.node}, nil
+	}
+
 	specialNode := handleTLFSpecialFile(
 		req.Name, d.folder, &resp.EntryValid)
 	if specialNode != nil {
@@ -614,6 +663,10 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	d.folder.fs.log.CDebugf(ctx, "Dir Create %s", req.Name)
 	defer func() { err = d.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if err = d.folder.fs.checkCallerCanWrite(ctx); err != nil {
+		return nil, nil, err
+	}
+
 	isExec := (req.Mode.Perm() & 0100) != 0
 	excl := getEXCLFromCreateRequest(req)
 	newNode, ei, err := d.folder.fs.config.KBFSOps().CreateFile(
@@ -654,6 +707,10 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (
 	d.folder.fs.log.CDebugf(ctx, "Dir Mkdir %s", req.Name)
 	defer func() { err = d.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if err = d.folder.fs.checkCallerCanWrite(ctx); err != nil {
+		return nil, err
+	}
+
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
 		ctx, d.folder.fs.config.DelayedCancellationGracePeriod())
@@ -686,6 +743,10 @@ func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (
 		req.NewName, req.Target)
 	defer func() { err = d.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if err = d.folder.fs.checkCallerCanWrite(ctx); err != nil {
+		return nil, err
+	}
+
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
 		ctx, d.folder.fs.config.DelayedCancellationGracePeriod())
@@ -718,6 +779,10 @@ func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest,
 		req.OldName, req.NewName)
 	defer func() { err = d.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if err = d.folder.fs.checkCallerCanWrite(ctx); err != nil {
+		return err
+	}
+
 	var realNewDir *Dir
 	switch newDir := newDir.(type) {
 	case *Dir:
@@ -769,6 +834,10 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) (err error) {
 	d.folder.fs.log.CDebugf(ctx, "Dir Remove %s", req.Name)
 	defer func() { err = d.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if err = d.folder.fs.checkCallerCanWrite(ctx); err != nil {
+		return err
+	}
+
 	// This fits in situation 1 as described in libkbfs/delayed_cancellation.go
 	err = libkbfs.EnableDelayedCancellationWithGracePeriod(
 		ctx, d.folder.fs.config.DelayedCancellationGracePeriod())
@@ -800,6 +869,10 @@ func (d *Dir) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err error) {
 	d.folder.fs.log.CDebugf(ctx, "Dir ReadDirAll")
 	defer func() { err = d.folder.processError(ctx, libkbfs.ReadMode, err) }()
 
+	if err = d.folder.checkCallerCanRead(ctx); err != nil {
+		return nil, err
+	}
+
 	children, err := d.folder.fs.config.KBFSOps().GetDirChildren(ctx, d.node)
 	if err != nil {
 		return nil, err
@@ -844,6 +917,10 @@ func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.
 	d.folder.fs.log.CDebugf(ctx, "Dir SetAttr %s", valid)
 	defer func() { err = d.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if err = d.folder.fs.checkCallerCanWrite(ctx); err != nil {
+		return err
+	}
+
 	if valid.Mode() {
 		// You can't set the mode on KBFS directories, but we don't
 		// want to return EPERM because that unnecessarily fails some
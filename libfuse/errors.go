@@ -74,6 +74,10 @@ func filterError(err error) error {
 		return errorWithErrno{err, syscall.EXDEV}
 	case *libkbfs.ErrDiskLimitTimeout:
 		return errorWithErrno{err, syscall.ENOSPC}
+	case libkbfs.NoSuchXattrError:
+		return errorWithErrno{err, syscall.Errno(fuse.ErrNoXattr)}
+	case libkbfs.XattrTooBigError:
+		return errorWithErrno{err, syscall.E2BIG}
 	}
 	return err
 }
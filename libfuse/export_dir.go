@@ -0,0 +1,162 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// ExportDir is the virtual directory, named libfs.ExportDirName,
+// that holds an archive of each subdirectory of a directory.
+type ExportDir struct {
+	folder *Folder
+	node   libkbfs.Node
+}
+
+var _ fs.Node = (*ExportDir)(nil)
+
+// Attr implements the fs.Node interface for ExportDir.
+func (ed *ExportDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
+
+var _ fs.NodeRequestLookuper = (*ExportDir)(nil)
+
+// Lookup implements the fs.NodeRequestLookuper interface for
+// ExportDir.
+func (ed *ExportDir) Lookup(ctx context.Context, req *fuse.LookupRequest,
+	resp *fuse.LookupResponse) (node fs.Node, err error) {
+	ed.folder.fs.log.CDebugf(ctx, "ExportDir Lookup %s", req.Name)
+	defer func() { err = ed.folder.processError(ctx, libkbfs.ReadMode, err) }()
+
+	dirName, suffix, ok := libfs.ParseArchiveName(req.Name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	n, ei, err := ed.folder.fs.config.KBFSOps().Lookup(ctx, ed.node, dirName)
+	if _, ok := err.(libkbfs.NoSuchNameError); ok {
+		return nil, fuse.ENOENT
+	} else if err != nil {
+		return nil, err
+	}
+	if ei.Type != libkbfs.Dir {
+		return nil, fuse.ENOENT
+	}
+	// Don't cache the entry, in case the directory goes away.
+	resp.EntryValid = 0
+	return &ArchiveFile{
+		folder: ed.folder,
+		node:   n,
+		suffix: suffix,
+		mtime:  time.Unix(0, ei.Mtime),
+	}, nil
+}
+
+var _ fs.HandleReadDirAller = (*ExportDir)(nil)
+
+// ReadDirAll implements the fs.HandleReadDirAller interface for
+// ExportDir.
+func (ed *ExportDir) ReadDirAll(ctx context.Context) (
+	res []fuse.Dirent, err error) {
+	ed.folder.fs.log.CDebugf(ctx, "ExportDir ReadDirAll")
+	defer func() { err = ed.folder.processError(ctx, libkbfs.ReadMode, err) }()
+
+	children, err := ed.folder.fs.config.KBFSOps().GetDirChildren(
+		ctx, ed.node)
+	if err != nil {
+		return nil, err
+	}
+	for name, ei := range children {
+		if ei.Type != libkbfs.Dir {
+			continue
+		}
+		for _, suffix := range libfs.ArchiveSuffixes {
+			res = append(res, fuse.Dirent{
+				Type: fuse.DT_File,
+				Name: name + suffix,
+			})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// ArchiveFile is a read-only file whose contents are an archive of a
+// directory, generated as it is read.
+type ArchiveFile struct {
+	folder *Folder
+	node   libkbfs.Node
+	suffix string
+	mtime  time.Time
+}
+
+var _ fs.Node = (*ArchiveFile)(nil)
+
+// Attr implements the fs.Node interface for ArchiveFile.  The size
+// isn't known until the archive has been generated, so it's 0, as is
+// usual for pseudofiles.
+func (af *ArchiveFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Mtime = af.mtime
+	a.Ctime = af.mtime
+	return nil
+}
+
+var _ fs.NodeOpener = (*ArchiveFile)(nil)
+
+// Open implements the fs.NodeOpener interface for ArchiveFile.
+func (af *ArchiveFile) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.EPERM
+	}
+	// The archive is generated in the background across many read
+	// requests, so it can't use the context of this one.
+	streamCtx := libkbfs.BackgroundContextWithCancellationDelayer()
+	stream := libfs.NewArchiveStream(
+		streamCtx, af.folder.fs.config, af.node, af.suffix)
+	resp.Flags |= fuse.OpenDirectIO
+	return &archiveHandle{ctx: streamCtx, stream: stream}, nil
+}
+
+type archiveHandle struct {
+	ctx    context.Context
+	stream *libfs.ArchiveStream
+}
+
+var _ fs.HandleReader = (*archiveHandle)(nil)
+
+// Read implements the fs.HandleReader interface for archiveHandle.
+func (ah *archiveHandle) Read(ctx context.Context, req *fuse.ReadRequest,
+	resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+	n, err := ah.stream.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+var _ fs.HandleReleaser = (*archiveHandle)(nil)
+
+// Release implements the fs.HandleReleaser interface for
+// archiveHandle.
+func (ah *archiveHandle) Release(
+	ctx context.Context, req *fuse.ReleaseRequest) error {
+	err := ah.stream.Close()
+	libkbfs.CleanupCancellationDelayer(ah.ctx)
+	return err
+}
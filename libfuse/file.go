@@ -6,7 +6,6 @@ package libfuse
 
 import (
 	"fmt"
-	"os"
 	"sync"
 
 	"bazil.org/fuse"
@@ -74,6 +73,7 @@ func (f *File) fillAttrWithMode(
 	if ei.Type == libkbfs.Exec {
 		a.Mode |= 0100
 	}
+	f.folder.fillAttrOwnership(a)
 
 	a.Inode = f.inode
 	return nil
@@ -88,6 +88,10 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 	f.folder.fs.log.CDebugf(ctx, "File Attr")
 	defer func() { err = f.folder.processError(ctx, libkbfs.ReadMode, err) }()
 
+	if err = f.folder.checkCallerCanRead(ctx); err != nil {
+		return err
+	}
+
 	if reqID, ok := ctx.Value(CtxIDKey).(string); ok {
 		if ei := f.eiCache.getAndDestroyIfMatches(reqID); ei != nil {
 			return f.fillAttrWithMode(ctx, ei, a)
@@ -129,13 +133,7 @@ func (f *File) Access(ctx context.Context, r *fuse.AccessRequest) (err error) {
 		ctx, "File.Access", f.node.GetBasename())
 	defer func() { f.folder.fs.config.MaybeFinishTrace(ctx, err) }()
 
-	if int(r.Uid) != os.Getuid() &&
-		// Finder likes to use UID 0 for some operations. osxfuse already allows
-		// ACCESS and GETXATTR requests from root to go through. This allows root
-		// in ACCESS handler. See KBFS-1733 for more details.
-		int(r.Uid) != 0 {
-		// short path: not accessible by anybody other than root or the user who
-		// executed the kbfsfuse process.
+	if !f.folder.fs.ownership.allowsAccess(r) {
 		return fuse.EPERM
 	}
 
@@ -217,6 +215,10 @@ func (f *File) Read(ctx context.Context, req *fuse.ReadRequest,
 	f.folder.fs.log.CDebugf(ctx, "File Read off=%d sz=%d", off, sz)
 	defer func() { err = f.folder.processError(ctx, libkbfs.ReadMode, err) }()
 
+	if err = f.folder.checkCallerCanRead(ctx); err != nil {
+		return err
+	}
+
 	n, err := f.folder.fs.config.KBFSOps().Read(
 		ctx, f.node, resp.Data[:sz], off)
 	if err != nil {
@@ -239,6 +241,10 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest,
 	f.folder.fs.log.CDebugf(ctx, "File Write sz=%d ", sz)
 	defer func() { err = f.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if err = f.folder.fs.checkCallerCanWrite(ctx); err != nil {
+		return err
+	}
+
 	f.eiCache.destroy()
 	if err := f.folder.fs.config.KBFSOps().Write(
 		ctx, f.node, req.Data, req.Offset); err != nil {
@@ -261,6 +267,10 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest,
 	f.folder.fs.log.CDebugf(ctx, "File SetAttr %s", valid)
 	defer func() { err = f.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if err = f.folder.fs.checkCallerCanWrite(ctx); err != nil {
+		return err
+	}
+
 	f.eiCache.destroy()
 
 	if valid.Size() {
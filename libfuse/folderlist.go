@@ -40,14 +40,8 @@ type FolderList struct {
 var _ fs.NodeAccesser = (*FolderList)(nil)
 
 // Access implements fs.NodeAccesser interface for *FolderList.
-func (*FolderList) Access(ctx context.Context, r *fuse.AccessRequest) error {
-	if int(r.Uid) != os.Getuid() &&
-		// Finder likes to use UID 0 for some operations. osxfuse already allows
-		// ACCESS and GETXATTR requests from root to go through. This allows root
-		// in ACCESS handler. See KBFS-1733 for more details.
-		int(r.Uid) != 0 {
-		// short path: not accessible by anybody other than root or the user who
-		// executed the kbfsfuse process.
+func (fl *FolderList) Access(ctx context.Context, r *fuse.AccessRequest) error {
+	if !fl.fs.ownership.allowsAccess(r) {
 		return fuse.EPERM
 	}
 
@@ -63,7 +57,7 @@ var _ fs.Node = (*FolderList)(nil)
 // Attr implements the fs.Node interface.
 func (fl *FolderList) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Mode = os.ModeDir | 0500
-	a.Uid = uint32(os.Getuid())
+	fl.fs.ownership.fillAttr(a, false)
 	a.Inode = fl.inode
 	return nil
 }
@@ -170,6 +164,7 @@ func (fl *FolderList) Lookup(ctx context.Context, req *fuse.LookupRequest, resp
 	}()
 	fl.mu.Lock()
 	defer fl.mu.Unlock()
+	resp.EntryValid = fl.fs.cache.EntryTimeout
 
 	specialNode := handleNonTLFSpecialFile(
 		req.Name, fl.fs, &resp.EntryValid)
@@ -256,10 +251,12 @@ func (fl *FolderList) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err er
 	}
 
 	res = make([]fuse.Dirent, 0, len(favs))
+	favNames := make(map[tlf.CanonicalName]bool, len(favs))
 	for _, fav := range favs {
 		if fav.Type != fl.tlfType {
 			continue
 		}
+		favNames[tlf.CanonicalName(fav.Name)] = true
 		pname, err := tlf.CanonicalToPreferredName(
 			session.Name, tlf.CanonicalName(fav.Name))
 		if err != nil {
@@ -271,6 +268,20 @@ func (fl *FolderList) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err er
 			Name: string(pname),
 		})
 	}
+
+	// Show the user's aliases for favorited team folders as
+	// symlinks; looking one up resolves to an Alias node.
+	if fl.tlfType == tlf.SingleTeam {
+		for _, alias := range fl.fs.config.TlfAliases() {
+			if !favNames[alias.Target] {
+				continue
+			}
+			res = append(res, fuse.Dirent{
+				Type: fuse.DT_Link,
+				Name: alias.Name,
+			})
+		}
+	}
 	return res, nil
 }
 
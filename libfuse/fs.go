@@ -53,9 +53,20 @@ type FS struct {
 	execAfterDelay func(d time.Duration, f func())
 
 	root Root
+	// rootTLF, if set, is the only TLF shown by this FS, given as
+	// "<type>/<name>".  It's mounted in place of root.
+	rootTLF string
 
 	platformParams PlatformParams
 
+	// ownership controls the owner, group and permissions of
+	// files, and who may access them.
+	ownership OwnershipParams
+
+	// cache controls how long the kernel may cache attributes and
+	// directory entries.
+	cache CacheParams
+
 	quotaUsage *libkbfs.EventuallyConsistentQuotaUsage
 
 	inodeLock sync.Mutex
@@ -119,6 +130,7 @@ func NewFS(config libkbfs.Config, conn *fuse.Conn, debug bool, platformParams Pl
 		debugServer:    debugServer,
 		notifications:  libfs.NewFSNotifications(log),
 		platformParams: platformParams,
+		cache:          DefaultCacheParams(),
 		quotaUsage:     libkbfs.NewEventuallyConsistentQuotaUsage(config, "FS"),
 		nextInode:      2, // root is 1
 	}
@@ -318,8 +330,8 @@ func (f *FS) WithContext(ctx context.Context) context.Context {
 // Serve FS. Will block.
 func (f *FS) Serve(ctx context.Context) error {
 	srv := fs.New(f.conn, &fs.Config{
-		WithContext: func(ctx context.Context, _ fuse.Request) context.Context {
-			return f.WithContext(ctx)
+		WithContext: func(ctx context.Context, req fuse.Request) context.Context {
+			return ctxWithCaller(f.WithContext(ctx), req)
 		},
 	})
 	f.fuse = srv
@@ -363,6 +375,9 @@ func (f *FS) processError(ctx context.Context,
 
 // Root implements the fs.FS interface for FS.
 func (f *FS) Root() (fs.Node, error) {
+	if f.rootTLF != "" {
+		return f.rootTLFNode(f.WithContext(context.Background()))
+	}
 	return &f.root, nil
 }
 
@@ -419,18 +434,12 @@ type Root struct {
 var _ fs.NodeAccesser = (*FolderList)(nil)
 
 // Access implements fs.NodeAccesser interface for *Root.
-func (*Root) Access(ctx context.Context, r *fuse.AccessRequest) error {
-	if int(r.Uid) != os.Getuid() &&
-		// Finder likes to use UID 0 for some operations. osxfuse already allows
-		// ACCESS and GETXATTR requests from root to go through. This allows root
-		// in ACCESS handler. See KBFS-1733 for more details.
-		int(r.Uid) != 0 {
-		// short path: not accessible by anybody other than root or the user who
-		// executed the kbfsfuse process.
+func (r *Root) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	if !r.private.fs.ownership.allowsAccess(req) {
 		return fuse.EPERM
 	}
 
-	if r.Mask&02 != 0 {
+	if req.Mask&02 != 0 {
 		return fuse.EPERM
 	}
 
@@ -440,8 +449,9 @@ func (*Root) Access(ctx context.Context, r *fuse.AccessRequest) error {
 var _ fs.Node = (*Root)(nil)
 
 // Attr implements the fs.Node interface for Root.
-func (*Root) Attr(ctx context.Context, a *fuse.Attr) error {
+func (r *Root) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Mode = os.ModeDir | 0500
+	r.private.fs.ownership.fillAttr(a, false)
 	a.Inode = 1
 	return nil
 }
@@ -452,6 +462,7 @@ var _ fs.NodeRequestLookuper = (*Root)(nil)
 func (r *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (_ fs.Node, err error) {
 	r.log().CDebugf(ctx, "FS Lookup %s", req.Name)
 	defer func() { err = r.private.fs.processError(ctx, libkbfs.ReadMode, err) }()
+	resp.EntryValid = r.private.fs.cache.EntryTimeout
 
 	specialNode := handleNonTLFSpecialFile(
 		req.Name, r.private.fs, &resp.EntryValid)
@@ -464,15 +475,30 @@ func (r *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.L
 		return platformNode, err
 	}
 
+	var node fs.Node
+	var tlfType tlf.Type
 	switch req.Name {
+	case libfs.SharedWithMeDirName:
+		node, tlfType = &SharedWithMeDir{fs: r.private.fs}, tlf.Private
 	case PrivateName:
-		return r.private, nil
+		node, tlfType = r.private, tlf.Private
 	case PublicName:
-		return r.public, nil
+		node, tlfType = r.public, tlf.Public
+	case TeamName:
+		node, tlfType = r.team, tlf.SingleTeam
 	}
 
-	if req.Name == TeamName {
-		return r.team, nil
+	if node != nil {
+		err = r.private.fs.checkCallerCanRead(ctx, tlfType)
+		if err != nil {
+			return nil, err
+		}
+		if r.private.fs.ownership.MultiUser {
+			// Make the kernel look these up again on every path
+			// walk, so each caller's access gets checked.
+			resp.EntryValid = 0
+		}
+		return node, nil
 	}
 
 	// Don't want to pop up errors on special OS files.
@@ -521,6 +547,12 @@ var _ fs.HandleReadDirAller = (*Root)(nil)
 func (r *Root) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err error) {
 	r.log().CDebugf(ctx, "FS ReadDirAll")
 	defer func() { err = r.private.fs.processError(ctx, libkbfs.ReadMode, err) }()
+	switch r.private.fs.callerRole(ctx) {
+	case callerNone:
+		return nil, fuse.EPERM
+	case callerPublic:
+		return []fuse.Dirent{{Type: fuse.DT_Dir, Name: PublicName}}, nil
+	}
 	res = []fuse.Dirent{
 		{
 			Type: fuse.DT_Dir,
@@ -47,6 +47,7 @@ func makeFS(t testing.TB, ctx context.Context, config *libkbfs.ConfigLocal) (
 		errLog:        log,
 		notifications: libfs.NewFSNotifications(log),
 		quotaUsage:    libkbfs.NewEventuallyConsistentQuotaUsage(config, "FSTest"),
+		cache:         DefaultCacheParams(),
 	}
 	filesys.root.private = &FolderList{
 		fs:      filesys,
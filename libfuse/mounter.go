@@ -28,7 +28,8 @@ type mounter struct {
 // fuseMount tries to mount the mountpoint.
 // On a force mount then unmount, re-mount if unsuccessful
 func (m *mounter) Mount() (err error) {
-	m.c, err = fuseMountDir(m.options.MountPoint, m.options.PlatformParams)
+	m.c, err = fuseMountDir(m.options.MountPoint, m.options.PlatformParams,
+		m.options.Ownership, m.options.Cache)
 	// Exit if we were succesful or we are not a force mounting on error.
 	// Otherwise, try unmounting and mounting again.
 	if err == nil || !m.options.ForceMount {
@@ -43,13 +44,18 @@ func (m *mounter) Mount() (err error) {
 	// and try again as the last resort. This specifically fixes a situation
 	// where /keybase gets created and owned by root after Keybase app is
 	// started, and `kbfs` later fails to mount because of a permission error.
-	m.reinstallMountDirIfPossible()
-	m.c, err = fuseMountDir(m.options.MountPoint, m.options.PlatformParams)
+	// Single-TLF mounts live wherever the user put them, so leave those be.
+	if m.options.TLF == "" {
+		m.reinstallMountDirIfPossible()
+	}
+	m.c, err = fuseMountDir(m.options.MountPoint, m.options.PlatformParams,
+		m.options.Ownership, m.options.Cache)
 
 	return err
 }
 
-func fuseMountDir(dir string, platformParams PlatformParams) (*fuse.Conn, error) {
+func fuseMountDir(dir string, platformParams PlatformParams,
+	ownership OwnershipParams, cache CacheParams) (*fuse.Conn, error) {
 	fi, err := os.Stat(dir)
 	if err != nil {
 		return nil, err
@@ -61,6 +67,8 @@ func fuseMountDir(dir string, platformParams PlatformParams) (*fuse.Conn, error)
 	if err != nil {
 		return nil, err
 	}
+	options = append(options, ownership.mountOptions()...)
+	options = append(options, cache.mountOptions()...)
 	c, err := fuse.Mount(dir, options...)
 	if err != nil {
 		err = translatePlatformSpecificError(err, platformParams)
@@ -0,0 +1,151 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PublicUser can be given in place of a Keybase user name in a UID
+// mapping, to give that UID a read-only view of public folders.
+const PublicUser = "public"
+
+type ctxCallerKeyType int
+
+const (
+	// ctxCallerUIDKey is the context key for the local UID that
+	// made a FUSE request.
+	ctxCallerUIDKey ctxCallerKeyType = iota
+)
+
+// callerRole is what a local user may do in a multi-user mount.
+type callerRole int
+
+const (
+	// callerNone may not see anything under the mount root.
+	callerNone callerRole = iota
+	// callerPublic may read public folders, and nothing else.
+	callerPublic
+	// callerFull has the same access as the logged-in user.
+	callerFull
+)
+
+// uidUserFlag is a flag.Value that adds "<uid>:<user>" mappings to a
+// map, where <uid> can also be a local user name.
+type uidUserFlag struct {
+	users *map[uint32]string
+}
+
+func (f uidUserFlag) String() string {
+	if f.users == nil {
+		return ""
+	}
+	strs := make([]string, 0, len(*f.users))
+	for uid, user := range *f.users {
+		strs = append(strs, fmt.Sprintf("%d:%s", uid, user))
+	}
+	return strings.Join(strs, ",")
+}
+
+func (f uidUserFlag) Set(s string) error {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 || i == len(s)-1 {
+		return errors.Errorf("%q is not of the form <uid>:<user>", s)
+	}
+	var uid uint32
+	var mapped bool
+	err := idFlag{&uid, &mapped, lookupUID}.Set(s[:i])
+	if err != nil {
+		return err
+	}
+	if *f.users == nil {
+		*f.users = make(map[uint32]string)
+	}
+	(*f.users)[uid] = s[i+1:]
+	return nil
+}
+
+func ctxWithCaller(ctx context.Context, req fuse.Request) context.Context {
+	return context.WithValue(ctx, ctxCallerUIDKey, req.Hdr().Uid)
+}
+
+// callerRole returns what the local user making the request in `ctx`
+// may do.  Outside of multi-user mode, the kernel checks permissions
+// itself, and everybody who gets this far has full access.
+func (f *FS) callerRole(ctx context.Context) callerRole {
+	uid, ok := ctx.Value(ctxCallerUIDKey).(uint32)
+	if !ok || !f.ownership.MultiUser ||
+		int(uid) == os.Getuid() || uid == 0 {
+		return callerFull
+	}
+
+	user, ok := f.ownership.UIDUsers[uid]
+	switch {
+	case !ok:
+		return callerNone
+	case user == PublicUser:
+		return callerPublic
+	}
+
+	session, err := libkbfs.GetCurrentSessionIfPossible(
+		ctx, f.config.KBPKI(), true)
+	if err != nil {
+		f.log.CDebugf(ctx, "Couldn't get session for UID %d: %+v", uid, err)
+		return callerNone
+	}
+	if session.Name != libkb.NewNormalizedUsername(user) {
+		// Only the logged-in user's view is available; other
+		// users' UIDs get nothing until they're logged in here.
+		f.log.CDebugf(ctx, "UID %d is mapped to %s, but %s is logged in",
+			uid, user, session.Name)
+		return callerNone
+	}
+	return callerFull
+}
+
+// checkCallerCanRead returns an error if the local user making the
+// request in `ctx` may not read folders of type `t`.
+func (f *FS) checkCallerCanRead(ctx context.Context, t tlf.Type) error {
+	switch f.callerRole(ctx) {
+	case callerFull:
+		return nil
+	case callerPublic:
+		if t == tlf.Public {
+			return nil
+		}
+	}
+	return fuse.EPERM
+}
+
+// checkCallerCanRead returns an error if the local user making the
+// request in `ctx` may not read this folder.  Walking a path into a
+// folder already checks this, but a node inside one can outlive the
+// walk that found it (e.g., as an open file handed to another
+// process), so every operation that reads from it checks again.
+func (f *Folder) checkCallerCanRead(ctx context.Context) error {
+	return f.fs.checkCallerCanRead(ctx, f.list.tlfType)
+}
+
+// checkCallerCanWrite returns an error if the local user making the
+// request in `ctx` may not change anything.
+func (f *FS) checkCallerCanWrite(ctx context.Context) error {
+	switch f.callerRole(ctx) {
+	case callerFull:
+		return nil
+	case callerPublic:
+		return errors.WithStack(fuse.Errno(syscall.EROFS))
+	}
+	return fuse.EPERM
+}
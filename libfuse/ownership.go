@@ -0,0 +1,199 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"bazil.org/fuse"
+)
+
+// OwnershipParams controls which local user and group KBFS files
+// appear to be owned by, so that a mount can be shared with other
+// local users (e.g., a Samba server).  The zero value keeps the
+// default behavior: everything is owned by the user running KBFS,
+// and nobody else may access the mount.
+type OwnershipParams struct {
+	// UID, if MapUID is set, is the owner of all files, instead of
+	// the user running KBFS.
+	UID    uint32
+	MapUID bool
+	// GID, if MapGID is set, is the group of all files.  The group
+	// gets read access to everything the owner can read.
+	GID    uint32
+	MapGID bool
+	// TeamWriterGID, if MapTeamWriters is set, is the group of all
+	// files in team folders that the logged-in user can write to.
+	// The group gets the same access as the owner, including write
+	// access, so local users can be given write access to team
+	// folders by adding them to it.
+	TeamWriterGID  uint32
+	MapTeamWriters bool
+	// AllowOther lets users other than the one running KBFS access
+	// the mount.  The kernel then checks their access against the
+	// ownership and permission bits above.
+	AllowOther bool
+	// MultiUser lets other local users access the mount (as with
+	// AllowOther), but decides what each of them may do by UIDUsers
+	// instead of by permission bits.
+	MultiUser bool
+	// UIDUsers maps local UIDs to the Keybase user whose view of the
+	// mount they get in MultiUser mode.  UIDs mapped to the logged-in
+	// user get full access, UIDs mapped to PublicUser get read-only
+	// access to public folders, and all others get no access.
+	UIDUsers map[uint32]string
+}
+
+// idFlag is a flag.Value that accepts either a numeric ID or a name,
+// which it resolves to an ID with `lookup`.
+type idFlag struct {
+	id     *uint32
+	set    *bool
+	lookup func(name string) (string, error)
+}
+
+func (f idFlag) String() string {
+	if f.set == nil || !*f.set {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*f.id), 10)
+}
+
+func (f idFlag) Set(s string) error {
+	idStr := s
+	if _, err := strconv.ParseUint(s, 10, 32); err != nil {
+		idStr, err = f.lookup(s)
+		if err != nil {
+			return err
+		}
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return fmt.Errorf("%q doesn't have a numeric ID: %v", s, err)
+	}
+	*f.id = uint32(id)
+	*f.set = true
+	return nil
+}
+
+func lookupUID(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGID(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// GetOwnershipUsageString returns a string to be included in a usage
+// string corresponding to the flags added by AddOwnershipFlags.
+func GetOwnershipUsageString() string {
+	return "[-uid=user] [-gid=group] [-team-writer-gid=group] [-allow-other]\n    " +
+		"[-multiuser] [-map-uid=user:keybase-user ...]\n    "
+}
+
+// AddOwnershipFlags adds ownership-mapping flags to the given FlagSet
+// and returns an OwnershipParams object that will be filled in when
+// the given FlagSet is parsed.
+func AddOwnershipFlags(flags *flag.FlagSet) *OwnershipParams {
+	var params OwnershipParams
+	flags.Var(idFlag{&params.UID, &params.MapUID, lookupUID}, "uid",
+		"User name or ID to show as the owner of all files, instead of "+
+			"the user running KBFS")
+	flags.Var(idFlag{&params.GID, &params.MapGID, lookupGID}, "gid",
+		"Group name or ID to show as the group of all files; the group "+
+			"can read everything the owner can")
+	flags.Var(idFlag{&params.TeamWriterGID, &params.MapTeamWriters,
+		lookupGID}, "team-writer-gid",
+		"Group name or ID to show as the group of team folders you can "+
+			"write to; the group can also write to them")
+	flags.BoolVar(&params.AllowOther, "allow-other", false,
+		"Let other local users access the mount, subject to the ownership "+
+			"and permissions of its files")
+	flags.BoolVar(&params.MultiUser, "multiuser", false,
+		"Let other local users access the mount as the Keybase users "+
+			"their UIDs are mapped to with -map-uid")
+	flags.Var(uidUserFlag{&params.UIDUsers}, "map-uid",
+		"Map a local user name or ID to a Keybase user, given as "+
+			"<uid>:<user>; use \""+PublicUser+"\" for read-only access to "+
+			"public folders (may be repeated)")
+	return &params
+}
+
+func (o OwnershipParams) mountOptions() []fuse.MountOption {
+	if o.MultiUser {
+		// Every request is checked against the caller's UID
+		// mapping, so the kernel shouldn't check permission bits.
+		return []fuse.MountOption{fuse.AllowOther()}
+	}
+	if !o.AllowOther {
+		return nil
+	}
+	// Without default_permissions, the kernel would let other users
+	// do anything that the KBFS user can.
+	return []fuse.MountOption{fuse.AllowOther(), fuse.DefaultPermissions()}
+}
+
+func (o OwnershipParams) uid() uint32 {
+	if o.MapUID {
+		return o.UID
+	}
+	return uint32(os.Getuid())
+}
+
+// fillAttr sets the owner and group of `a`, and gives the group
+// access according to the owner's permissions, which must already be
+// set in `a.Mode`.  `teamWriter` is whether `a` belongs to a team
+// folder that the logged-in user can write to.
+func (o OwnershipParams) fillAttr(a *fuse.Attr, teamWriter bool) {
+	a.Uid = o.uid()
+	switch {
+	case teamWriter && o.MapTeamWriters:
+		a.Gid = o.TeamWriterGID
+		a.Mode |= (a.Mode & 0700) >> 3
+	case o.MapGID:
+		a.Gid = o.GID
+		a.Mode |= (a.Mode & 0500) >> 3
+	}
+}
+
+// allowsAccess returns whether an ACCESS request from `r` should be
+// checked any further, or denied outright.
+func (o OwnershipParams) allowsAccess(r *fuse.AccessRequest) bool {
+	switch {
+	case int(r.Uid) == os.Getuid():
+		return true
+	// Finder likes to use UID 0 for some operations. osxfuse already
+	// allows ACCESS and GETXATTR requests from root to go
+	// through. This allows root in ACCESS handler. See KBFS-1733 for
+	// more details.
+	case r.Uid == 0:
+		return true
+	case o.MapUID && r.Uid == o.UID:
+		return true
+	case o.MapGID && r.Gid == o.GID:
+		return true
+	case o.MapTeamWriters && r.Gid == o.TeamWriterGID:
+		return true
+	case o.MultiUser && o.UIDUsers[r.Uid] != "":
+		return true
+	default:
+		// Not accessible by anybody other than root, the user who
+		// executed the kbfsfuse process, or the configured owner and
+		// groups.
+		return false
+	}
+}
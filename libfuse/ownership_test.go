@@ -0,0 +1,119 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestOwnershipFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	params := AddOwnershipFlags(flags)
+	err := flags.Parse([]string{"-uid=1234", "-team-writer-gid=99"})
+	require.NoError(t, err)
+	require.Equal(t, OwnershipParams{
+		UID:            1234,
+		MapUID:         true,
+		TeamWriterGID:  99,
+		MapTeamWriters: true,
+	}, *params)
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	AddOwnershipFlags(flags)
+	err = flags.Parse([]string{"-gid=no-such-group-kbfs"})
+	require.Error(t, err)
+}
+
+func TestOwnershipFillAttr(t *testing.T) {
+	var o OwnershipParams
+	a := fuse.Attr{Mode: 0700}
+	o.fillAttr(&a, true)
+	require.Equal(t, uint32(os.Getuid()), a.Uid)
+	require.Equal(t, uint32(0), a.Gid)
+	require.Equal(t, os.FileMode(0700), a.Mode)
+
+	o = OwnershipParams{
+		UID:            1234,
+		MapUID:         true,
+		GID:            10,
+		MapGID:         true,
+		TeamWriterGID:  20,
+		MapTeamWriters: true,
+	}
+	a = fuse.Attr{Mode: 0700}
+	o.fillAttr(&a, false)
+	require.Equal(t, uint32(1234), a.Uid)
+	require.Equal(t, uint32(10), a.Gid)
+	require.Equal(t, os.FileMode(0750), a.Mode)
+
+	a = fuse.Attr{Mode: 0700}
+	o.fillAttr(&a, true)
+	require.Equal(t, uint32(20), a.Gid)
+	require.Equal(t, os.FileMode(0770), a.Mode)
+
+	require.True(t, o.allowsAccess(&fuse.AccessRequest{
+		Header: fuse.Header{Uid: 1234, Gid: 1}}))
+	require.True(t, o.allowsAccess(&fuse.AccessRequest{
+		Header: fuse.Header{Uid: 5678, Gid: 20}}))
+	require.False(t, o.allowsAccess(&fuse.AccessRequest{
+		Header: fuse.Header{Uid: 5678, Gid: 1}}))
+}
+
+func TestMultiUserFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	params := AddOwnershipFlags(flags)
+	err := flags.Parse([]string{
+		"-multiuser", "-map-uid=1234:alice", "-map-uid=1235:" + PublicUser})
+	require.NoError(t, err)
+	require.True(t, params.MultiUser)
+	require.Equal(t, map[uint32]string{
+		1234: "alice",
+		1235: PublicUser,
+	}, params.UIDUsers)
+	// Just allow_other, without default_permissions.
+	require.Len(t, params.mountOptions(), 1)
+
+	require.True(t, params.allowsAccess(
+		&fuse.AccessRequest{Header: fuse.Header{Uid: 1235, Gid: 1}}))
+	require.False(t, params.allowsAccess(
+		&fuse.AccessRequest{Header: fuse.Header{Uid: 1236, Gid: 1}}))
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	AddOwnershipFlags(flags)
+	err = flags.Parse([]string{"-map-uid=1234"})
+	require.Error(t, err)
+}
+
+func TestMultiUserFolderReadChecks(t *testing.T) {
+	fs := &FS{ownership: OwnershipParams{
+		MultiUser: true,
+		UIDUsers:  map[uint32]string{1235: PublicUser},
+	}}
+	public := &Folder{fs: fs, list: &FolderList{fs: fs, tlfType: tlf.Public}}
+	private := &Folder{fs: fs, list: &FolderList{fs: fs, tlfType: tlf.Private}}
+	ctxFor := func(uid uint32) context.Context {
+		return context.WithValue(context.Background(), ctxCallerUIDKey, uid)
+	}
+
+	// The user running KBFS can read everything.
+	self := ctxFor(uint32(os.Getuid()))
+	require.NoError(t, public.checkCallerCanRead(self))
+	require.NoError(t, private.checkCallerCanRead(self))
+
+	// A public-only user can only read public folders.
+	require.NoError(t, public.checkCallerCanRead(ctxFor(1235)))
+	require.Equal(t, fuse.EPERM, private.checkCallerCanRead(ctxFor(1235)))
+
+	// An unmapped user can't read anything.
+	require.Equal(t, fuse.EPERM, public.checkCallerCanRead(ctxFor(1236)))
+	require.Equal(t, fuse.EPERM, private.checkCallerCanRead(ctxFor(1236)))
+}
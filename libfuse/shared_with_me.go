@@ -0,0 +1,101 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"os"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SharedWithMeDir is a read-only directory of symlinks to the folders
+// shared with the logged-in user or, if recent is set, to the files
+// other users edited recently in those folders.  Its contents are
+// recomputed on every access.
+type SharedWithMeDir struct {
+	fs     *FS
+	recent bool
+}
+
+var _ fs.Node = (*SharedWithMeDir)(nil)
+
+// Attr implements the fs.Node interface for SharedWithMeDir.
+func (d *SharedWithMeDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
+
+func (d *SharedWithMeDir) links(ctx context.Context) (
+	[]libfs.SharedWithMeLink, error) {
+	s, err := libfs.GetSharedWithMe(ctx, d.fs.config)
+	if err != nil {
+		return nil, err
+	}
+	if d.recent {
+		return s.RecentLinks(), nil
+	}
+	return s.FolderLinks(), nil
+}
+
+var _ fs.NodeRequestLookuper = (*SharedWithMeDir)(nil)
+
+// Lookup implements the fs.NodeRequestLookuper interface for
+// SharedWithMeDir.
+func (d *SharedWithMeDir) Lookup(ctx context.Context,
+	req *fuse.LookupRequest, resp *fuse.LookupResponse) (
+	_ fs.Node, err error) {
+	d.fs.log.CDebugf(ctx, "SharedWithMeDir Lookup %s", req.Name)
+	defer func() { err = d.fs.processError(ctx, libkbfs.ReadMode, err) }()
+
+	// Shared folders come and go, so don't cache any entries.
+	resp.EntryValid = 0
+	if !d.recent && req.Name == libfs.SharedWithMeRecentDirName {
+		return &SharedWithMeDir{fs: d.fs, recent: true}, nil
+	}
+	links, err := d.links(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range links {
+		if l.Name == req.Name {
+			return &Alias{realPath: l.Target}, nil
+		}
+	}
+	return nil, fuse.ENOENT
+}
+
+var _ fs.Handle = (*SharedWithMeDir)(nil)
+
+var _ fs.HandleReadDirAller = (*SharedWithMeDir)(nil)
+
+// ReadDirAll implements the fs.HandleReadDirAller interface for
+// SharedWithMeDir.
+func (d *SharedWithMeDir) ReadDirAll(ctx context.Context) (
+	res []fuse.Dirent, err error) {
+	d.fs.log.CDebugf(ctx, "SharedWithMeDir ReadDirAll")
+	defer func() { err = d.fs.processError(ctx, libkbfs.ReadMode, err) }()
+
+	links, err := d.links(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !d.recent {
+		res = append(res, fuse.Dirent{
+			Type: fuse.DT_Dir,
+			Name: libfs.SharedWithMeRecentDirName,
+		})
+	}
+	for _, l := range links {
+		res = append(res, fuse.Dirent{
+			Type: fuse.DT_Link,
+			Name: l.Name,
+		})
+	}
+	return res, nil
+}
@@ -92,6 +92,12 @@ func handleTLFSpecialFile(
 			folder: folder,
 		}
 
+	case libfs.ConflictsFileName:
+		*entryValid = 0
+		return &ConflictsFile{
+			folder: folder,
+		}
+
 	case libfs.DisableUpdatesFileName:
 		return &UpdatesFile{
 			folder: folder,
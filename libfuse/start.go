@@ -13,6 +13,8 @@ import (
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/client/go/systemd"
 	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libarchive"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libgit"
 	"github.com/keybase/kbfs/libkbfs"
@@ -24,38 +26,39 @@ import (
 type StartOptions struct {
 	KbfsParams        libkbfs.InitParams
 	PlatformParams    PlatformParams
+	Ownership         OwnershipParams
+	Cache             CacheParams
 	RuntimeDir        string
 	Label             string
 	ForceMount        bool
 	MountErrorIsFatal bool
 	SkipMount         bool
 	MountPoint        string
+	// TLF, if set, is the only TLF mounted at MountPoint, given as
+	// "<type>/<name>", instead of the whole KBFS tree.
+	TLF string
+	// TLFMounts are additional single TLFs to mount, each at its own
+	// mount point, from the same process.
+	TLFMounts TLFMounts
 }
 
-func startMounting(ctx context.Context,
-	kbCtx libkbfs.Context, config libkbfs.Config, options StartOptions,
-	log logger.Logger, mi *libfs.MountInterrupter) error {
-	log.CDebugf(ctx, "Mounting: %q", options.MountPoint)
-
-	var mounter = &mounter{
-		options: options,
-		log:     log,
-		runMode: kbCtx.GetRunMode(),
-	}
-	err := mi.MountAndSetUnmount(mounter)
-	if err != nil {
-		return err
-	}
-
-	log.CDebugf(ctx, "Creating filesystem")
-	fs := NewFS(config, mounter.c, options.KbfsParams.Debug, options.PlatformParams)
+// serveMount serves a new FS over the connection made by `m`, until
+// it's unmounted.
+func serveMount(ctx context.Context, config libkbfs.Config,
+	m *mounter, log logger.Logger) error {
+	log.CDebugf(ctx, "Creating filesystem for %q", m.options.MountPoint)
+	fs := NewFS(config, m.c, m.options.KbfsParams.Debug,
+		m.options.PlatformParams)
+	fs.ownership = m.options.Ownership
+	fs.cache = m.options.Cache
+	fs.rootTLF = m.options.TLF
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	ctx = context.WithValue(ctx, libfs.CtxAppIDKey, fs)
 
 	go func() {
 		select {
-		case <-mounter.c.Ready:
+		case <-m.c.Ready:
 			// We wait for the mounter to finish asynchronously with
 			// calling fs.Serve() below, for the rare osxfuse case
 			// where `mount(2)` makes a blocking STATFS call before
@@ -63,7 +66,7 @@ func startMounting(ctx context.Context,
 			// when this happens, there will be a deadlock, and the
 			// mount will silently fail after two minutes.  See
 			// KBFS-2409.
-			err = mounter.c.MountError
+			err := m.c.MountError
 			if err != nil {
 				log.CWarningf(ctx, "Mount error: %+v", err)
 				cancel()
@@ -75,7 +78,7 @@ func startMounting(ctx context.Context,
 	}()
 
 	log.CDebugf(ctx, "Serving filesystem")
-	if err = fs.Serve(ctx); err != nil {
+	if err := fs.Serve(ctx); err != nil {
 		return err
 	}
 
@@ -83,6 +86,47 @@ func startMounting(ctx context.Context,
 	return nil
 }
 
+func startMounting(ctx context.Context,
+	kbCtx libkbfs.Context, config libkbfs.Config, options StartOptions,
+	log logger.Logger, mi *libfs.MountInterrupter) error {
+	log.CDebugf(ctx, "Mounting: %q", options.MountPoint)
+
+	mounters := multiMounter{{
+		options: options,
+		log:     log,
+		runMode: kbCtx.GetRunMode(),
+	}}
+	for _, tm := range options.TLFMounts {
+		log.CDebugf(ctx, "Mounting %s at %q", tm.TLF, tm.MountPoint)
+		tlfOptions := options
+		tlfOptions.MountPoint = tm.MountPoint
+		tlfOptions.TLF = tm.TLF
+		tlfOptions.TLFMounts = nil
+		mounters = append(mounters, &mounter{
+			options: tlfOptions,
+			log:     log,
+			runMode: kbCtx.GetRunMode(),
+		})
+	}
+	err := mi.MountAndSetUnmount(mounters)
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(mounters))
+	for _, m := range mounters {
+		go func(m *mounter) {
+			errs <- serveMount(ctx, config, m, log)
+		}(m)
+	}
+	for range mounters {
+		if serveErr := <-errs; serveErr != nil && err == nil {
+			err = serveErr
+		}
+	}
+	return err
+}
+
 // Start the filesystem
 func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 	// Hook simplefs implementation in.
@@ -91,6 +135,12 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 		return keybase1.SimpleFSProtocol(
 			simplefs.NewSimpleFS(libkbfsCtx.GetGlobalContext(), config)), nil
 	}
+	// Hook the KBFS fs protocol in, for listing, completing and
+	// statting paths.
+	createFSRPC := func(
+		libkbfsCtx libkbfs.Context, config libkbfs.Config) (rpc.Protocol, error) {
+		return fsrpc.Protocol(fsrpc.NewFS(config, config.MakeLogger(""))), nil
+	}
 	// Hook git implementation in.
 	shutdownGit := func() {}
 	createGitHandler := func(
@@ -104,9 +154,9 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 		shutdownGit()
 	}()
 
-	// Patch the kbfsParams to inject two additional protocols.
+	// Patch the kbfsParams to inject three additional protocols.
 	options.KbfsParams.AdditionalProtocolCreators = []libkbfs.AdditionalProtocolCreator{
-		createSimpleFS, createGitHandler,
+		createSimpleFS, createFSRPC, createGitHandler,
 	}
 
 	log, err := libkbfs.InitLog(options.KbfsParams, kbCtx)
@@ -132,6 +182,13 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 	}
 	defer libkbfs.Shutdown()
 
+	if options.KbfsParams.BrowseArchives {
+		_, err = libarchive.Enable(config)
+		if err != nil {
+			return libfs.InitError(err.Error())
+		}
+	}
+
 	// Report "startup successful" to the supervisor (currently just systemd on
 	// Linux). This isn't necessary for correctness, but it allows commands
 	// like "systemctl start kbfs.service" to report startup errors to the
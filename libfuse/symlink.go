@@ -34,6 +34,10 @@ func (s *Symlink) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 	s.parent.folder.fs.log.CDebugf(ctx, "Symlink Attr")
 	defer func() { err = s.parent.folder.processError(ctx, libkbfs.ReadMode, err) }()
 
+	if err = s.parent.folder.checkCallerCanRead(ctx); err != nil {
+		return err
+	}
+
 	_, de, err := s.parent.folder.fs.config.KBFSOps().Lookup(ctx, s.parent.node, s.name)
 	if err != nil {
 		if _, ok := err.(libkbfs.NoSuchNameError); ok {
@@ -44,6 +48,7 @@ func (s *Symlink) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 
 	s.parent.folder.fillAttrWithUIDAndWritePerm(ctx, s.parent.node, &de, a)
 	a.Mode = os.ModeSymlink | a.Mode | 0500
+	s.parent.folder.fillAttrOwnership(a)
 	a.Inode = s.inode
 	return nil
 }
@@ -55,6 +60,10 @@ func (s *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (link
 	s.parent.folder.fs.log.CDebugf(ctx, "Symlink Readlink")
 	defer func() { err = s.parent.folder.processError(ctx, libkbfs.ReadMode, err) }()
 
+	if err = s.parent.folder.checkCallerCanRead(ctx); err != nil {
+		return "", err
+	}
+
 	_, de, err := s.parent.folder.fs.config.KBFSOps().Lookup(ctx, s.parent.node, s.name)
 	if err != nil {
 		return "", err
@@ -148,7 +148,7 @@ func (tlf *TLF) Attr(ctx context.Context, a *fuse.Attr) error {
 		// dir.
 		a.Valid = 1 * time.Second
 		a.Mode = os.ModeDir | 0500
-		a.Uid = uint32(os.Getuid())
+		tlf.folder.fs.ownership.fillAttr(a, false)
 		return nil
 	}
 
@@ -168,8 +168,24 @@ var tlfLoadAvoidingLookupNames = map[string]bool{
 	".localized": true,
 }
 
+// checkCallerCanRead returns an error if the local user making the
+// request in `ctx` may not read this TLF.
+func (tlf *TLF) checkCallerCanRead(ctx context.Context) error {
+	return tlf.folder.checkCallerCanRead(ctx)
+}
+
 // Lookup implements the fs.NodeRequestLookuper interface for TLF.
 func (tlf *TLF) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	if err := tlf.checkCallerCanRead(ctx); err != nil {
+		return nil, err
+	}
+	if tlf.folder.fs.ownership.MultiUser && tlf.folder.fs.rootTLF != "" {
+		// When this TLF is the mount root, its entries are the
+		// only place left to check each caller's access on every
+		// path walk.
+		defer func() { resp.EntryValid = 0 }()
+	}
+
 	if tlfLoadAvoidingLookupNames[req.Name] {
 		dir := tlf.getStoredDir()
 		if dir == nil {
@@ -243,6 +259,9 @@ func (tlf *TLF) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 
 // ReadDirAll implements the fs.NodeReadDirAller interface for TLF.
 func (tlf *TLF) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	if err := tlf.checkCallerCanRead(ctx); err != nil {
+		return nil, err
+	}
 	dir, exitEarly, err := tlf.loadDirAllowNonexistent(ctx)
 	if err != nil || exitEarly {
 		return nil, err
@@ -288,6 +307,9 @@ func (tlf *TLF) Open(ctx context.Context, req *fuse.OpenRequest,
 	// Explicitly load the directory when a TLF is opened, because
 	// some OSX programs like ls have a bug that doesn't report errors
 	// on a ReadDirAll.
+	if err := tlf.checkCallerCanRead(ctx); err != nil {
+		return nil, err
+	}
 	_, _, err := tlf.loadDirAllowNonexistent(ctx)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,119 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// TLFMount is a single TLF, given as "<type>/<name>" (e.g.,
+// "team/acme"), to be mounted by itself at MountPoint.
+type TLFMount struct {
+	TLF        string
+	MountPoint string
+}
+
+// TLFMounts is a flag.Value that collects TLF mounts, each given as
+// "<type>/<name>=<mountpoint>".
+type TLFMounts []TLFMount
+
+func (m *TLFMounts) String() string {
+	strs := make([]string, 0, len(*m))
+	for _, tm := range *m {
+		strs = append(strs, tm.TLF+"="+tm.MountPoint)
+	}
+	return strings.Join(strs, ",")
+}
+
+// Set implements the flag.Value interface for TLFMounts.
+func (m *TLFMounts) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i < 0 || s[i+1:] == "" {
+		return errors.Errorf("%q is not of the form <type>/<name>=<dir>", s)
+	}
+	tm := TLFMount{TLF: s[:i], MountPoint: s[i+1:]}
+	if _, _, err := libfs.ParseTlfPath(tm.TLF); err != nil {
+		return err
+	}
+	*m = append(*m, tm)
+	return nil
+}
+
+// multiMounter mounts several mount points as a single libfs.Mounter,
+// so they can all be unmounted together by a MountInterrupter.
+type multiMounter []*mounter
+
+var _ libfs.Mounter = multiMounter(nil)
+
+func (mm multiMounter) Mount() error {
+	for i, m := range mm {
+		err := m.Mount()
+		if err != nil {
+			for _, mounted := range mm[:i] {
+				if unmountErr := mounted.Unmount(); unmountErr != nil {
+					m.log.Debug("Couldn't unmount %s: %v",
+						mounted.options.MountPoint, unmountErr)
+				}
+			}
+			return errors.Wrapf(err, "Couldn't mount %s", m.options.MountPoint)
+		}
+	}
+	return nil
+}
+
+func (mm multiMounter) Unmount() (err error) {
+	for _, m := range mm {
+		if unmountErr := m.Unmount(); unmountErr != nil && err == nil {
+			err = unmountErr
+		}
+	}
+	return err
+}
+
+// rootTLFNode returns the node for f.rootTLF, following its alias if
+// it isn't given by its canonical name.
+func (f *FS) rootTLFNode(ctx context.Context) (*TLF, error) {
+	tlfType, name, err := libfs.ParseTlfPath(f.rootTLF)
+	if err != nil {
+		return nil, err
+	}
+	var fl *FolderList
+	switch tlfType {
+	case tlf.Private:
+		fl = f.root.private
+	case tlf.Public:
+		fl = f.root.public
+	case tlf.SingleTeam:
+		fl = f.root.team
+	default:
+		return nil, errors.Errorf("Unsupported TLF type %s", tlfType)
+	}
+
+	// Follow at most one alias, to the canonical name.
+	for i := 0; i < 2; i++ {
+		var node fs.Node
+		node, err = fl.Lookup(
+			ctx, &fuse.LookupRequest{Name: name}, &fuse.LookupResponse{})
+		if err != nil {
+			return nil, err
+		}
+		switch n := node.(type) {
+		case *TLF:
+			return n, nil
+		case *Alias:
+			name = n.realPath
+		default:
+			return nil, errors.Errorf("%s is not a TLF", f.rootTLF)
+		}
+	}
+	return nil, errors.Errorf("Too many aliases for %s", f.rootTLF)
+}
@@ -0,0 +1,250 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	// finderInfoXattrName is the attribute where macOS Finder keeps
+	// its flags, type/creator codes and color labels.  It is always
+	// exactly finderInfoSize bytes, and an all-zero value means the
+	// same thing as no value at all.
+	finderInfoXattrName = "com.apple.FinderInfo"
+	finderInfoSize      = 32
+)
+
+func (f *Folder) getXattrs(ctx context.Context, node libkbfs.Node) (
+	map[string][]byte, error) {
+	md, err := f.fs.config.KBFSOps().GetNodeMetadata(ctx, node)
+	if err != nil {
+		if isNoSuchNameError(err) {
+			return nil, fuse.ESTALE
+		}
+		return nil, err
+	}
+	return md.Xattrs, nil
+}
+
+// getxattr implements the fs.NodeGetxattrer interface for the given
+// file or directory node.
+func (f *Folder) getxattr(ctx context.Context, node libkbfs.Node,
+	req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) (err error) {
+	f.fs.log.CDebugf(ctx, "Getxattr %s", req.Name)
+	defer func() { err = f.processError(ctx, libkbfs.ReadMode, err) }()
+
+	if err = f.checkCallerCanRead(ctx); err != nil {
+		return err
+	}
+
+	xattrs, err := f.getXattrs(ctx, node)
+	if err != nil {
+		return err
+	}
+	value, ok := xattrs[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	if uint64(req.Position) > uint64(len(value)) {
+		return errors.WithStack(fuse.ERANGE)
+	}
+	resp.Xattr = value[req.Position:]
+	return nil
+}
+
+// listxattr implements the fs.NodeListxattrer interface for the given
+// file or directory node.
+func (f *Folder) listxattr(ctx context.Context, node libkbfs.Node,
+	req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) (err error) {
+	f.fs.log.CDebugf(ctx, "Listxattr")
+	defer func() { err = f.processError(ctx, libkbfs.ReadMode, err) }()
+
+	if err = f.checkCallerCanRead(ctx); err != nil {
+		return err
+	}
+
+	xattrs, err := f.getXattrs(ctx, node)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp.Append(names...)
+	return nil
+}
+
+// setxattr implements the fs.NodeSetxattrer interface for the given
+// file or directory node.
+func (f *Folder) setxattr(ctx context.Context, node libkbfs.Node,
+	req *fuse.SetxattrRequest) (err error) {
+	f.fs.log.CDebugf(ctx, "Setxattr %s", req.Name)
+	defer func() { err = f.processError(ctx, libkbfs.WriteMode, err) }()
+
+	if err = f.fs.checkCallerCanWrite(ctx); err != nil {
+		return err
+	}
+
+	xattrs, err := f.getXattrs(ctx, node)
+	if err != nil {
+		return err
+	}
+	oldValue, exists := xattrs[req.Name]
+	switch {
+	case exists && req.Flags&xattrCreate != 0:
+		return fuse.EEXIST
+	case !exists && req.Flags&xattrReplace != 0:
+		return fuse.ErrNoXattr
+	}
+
+	value := req.Xattr
+	if req.Position != 0 {
+		// macOS writes resource forks (com.apple.ResourceFork) in
+		// chunks at increasing positions, so write this chunk over
+		// the existing value, like a file write.
+		if uint64(req.Position) > uint64(len(oldValue)) {
+			return errors.WithStack(fuse.ERANGE)
+		}
+		end := int(req.Position) + len(req.Xattr)
+		if end < len(oldValue) {
+			end = len(oldValue)
+		}
+		value = make([]byte, end)
+		copy(value, oldValue)
+		copy(value[req.Position:], req.Xattr)
+	}
+
+	if req.Name == finderInfoXattrName {
+		if len(value) != finderInfoSize {
+			return errors.WithStack(fuse.ERANGE)
+		}
+		if bytes.Equal(value, make([]byte, finderInfoSize)) {
+			// Finder clears its info by zeroing it out.
+			if !exists {
+				return nil
+			}
+			return f.fs.config.KBFSOps().RemoveXattr(ctx, node, req.Name)
+		}
+	}
+
+	return f.fs.config.KBFSOps().SetXattr(ctx, node, req.Name, value)
+}
+
+// removexattr implements the fs.NodeRemovexattrer interface for the
+// given file or directory node.
+func (f *Folder) removexattr(ctx context.Context, node libkbfs.Node,
+	req *fuse.RemovexattrRequest) (err error) {
+	f.fs.log.CDebugf(ctx, "Removexattr %s", req.Name)
+	defer func() { err = f.processError(ctx, libkbfs.WriteMode, err) }()
+
+	if err = f.fs.checkCallerCanWrite(ctx); err != nil {
+		return err
+	}
+
+	return f.fs.config.KBFSOps().RemoveXattr(ctx, node, req.Name)
+}
+
+var _ fs.NodeGetxattrer = (*Dir)(nil)
+var _ fs.NodeListxattrer = (*Dir)(nil)
+var _ fs.NodeSetxattrer = (*Dir)(nil)
+var _ fs.NodeRemovexattrer = (*Dir)(nil)
+
+// Getxattr implements the fs.NodeGetxattrer interface for Dir.
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) (err error) {
+	ctx = d.folder.fs.config.MaybeStartTrace(
+		ctx, "Dir.Getxattr", fmt.Sprintf("%s %s", d.node.GetBasename(), req.Name))
+	defer func() { d.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	return d.folder.getxattr(ctx, d.node, req, resp)
+}
+
+// Listxattr implements the fs.NodeListxattrer interface for Dir.
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) (err error) {
+	ctx = d.folder.fs.config.MaybeStartTrace(
+		ctx, "Dir.Listxattr", d.node.GetBasename())
+	defer func() { d.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	return d.folder.listxattr(ctx, d.node, req, resp)
+}
+
+// Setxattr implements the fs.NodeSetxattrer interface for Dir.
+func (d *Dir) Setxattr(ctx context.Context,
+	req *fuse.SetxattrRequest) (err error) {
+	ctx = d.folder.fs.config.MaybeStartTrace(
+		ctx, "Dir.Setxattr", fmt.Sprintf("%s %s", d.node.GetBasename(), req.Name))
+	defer func() { d.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	return d.folder.setxattr(ctx, d.node, req)
+}
+
+// Removexattr implements the fs.NodeRemovexattrer interface for Dir.
+func (d *Dir) Removexattr(ctx context.Context,
+	req *fuse.RemovexattrRequest) (err error) {
+	ctx = d.folder.fs.config.MaybeStartTrace(
+		ctx, "Dir.Removexattr", fmt.Sprintf("%s %s", d.node.GetBasename(), req.Name))
+	defer func() { d.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	return d.folder.removexattr(ctx, d.node, req)
+}
+
+var _ fs.NodeGetxattrer = (*File)(nil)
+var _ fs.NodeListxattrer = (*File)(nil)
+var _ fs.NodeSetxattrer = (*File)(nil)
+var _ fs.NodeRemovexattrer = (*File)(nil)
+
+// Getxattr implements the fs.NodeGetxattrer interface for File.
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) (err error) {
+	ctx = f.folder.fs.config.MaybeStartTrace(
+		ctx, "File.Getxattr", fmt.Sprintf("%s %s", f.node.GetBasename(), req.Name))
+	defer func() { f.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	return f.folder.getxattr(ctx, f.node, req, resp)
+}
+
+// Listxattr implements the fs.NodeListxattrer interface for File.
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) (err error) {
+	ctx = f.folder.fs.config.MaybeStartTrace(
+		ctx, "File.Listxattr", f.node.GetBasename())
+	defer func() { f.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	return f.folder.listxattr(ctx, f.node, req, resp)
+}
+
+// Setxattr implements the fs.NodeSetxattrer interface for File.
+func (f *File) Setxattr(ctx context.Context,
+	req *fuse.SetxattrRequest) (err error) {
+	ctx = f.folder.fs.config.MaybeStartTrace(
+		ctx, "File.Setxattr", fmt.Sprintf("%s %s", f.node.GetBasename(), req.Name))
+	defer func() { f.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	f.eiCache.destroy()
+	return f.folder.setxattr(ctx, f.node, req)
+}
+
+// Removexattr implements the fs.NodeRemovexattrer interface for File.
+func (f *File) Removexattr(ctx context.Context,
+	req *fuse.RemovexattrRequest) (err error) {
+	ctx = f.folder.fs.config.MaybeStartTrace(
+		ctx, "File.Removexattr", fmt.Sprintf("%s %s", f.node.GetBasename(), req.Name))
+	defer func() { f.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	f.eiCache.destroy()
+	return f.folder.removexattr(ctx, f.node, req)
+}
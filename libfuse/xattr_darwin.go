@@ -0,0 +1,11 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+// Setxattr flags, from <sys/xattr.h>.
+const (
+	xattrCreate  = 0x2
+	xattrReplace = 0x4
+)
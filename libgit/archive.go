@@ -0,0 +1,230 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+// ArchiveFormat is the file format of a repo archive.
+type ArchiveFormat int
+
+const (
+	// ArchiveTarGz is a gzipped tarball.
+	ArchiveTarGz ArchiveFormat = iota
+	// ArchiveZip is a zip file.
+	ArchiveZip
+)
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case ArchiveTarGz:
+		return "tar.gz"
+	case ArchiveZip:
+		return "zip"
+	default:
+		return "<unknown archive format>"
+	}
+}
+
+// ParseArchiveFormat returns the format with the given name, which is
+// also the usual file extension for it.
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch strings.ToLower(s) {
+	case "tar.gz", "tgz":
+		return ArchiveTarGz, nil
+	case "zip":
+		return ArchiveZip, nil
+	default:
+		return 0, errors.Errorf("Unknown archive format: %s", s)
+	}
+}
+
+// archiveWriter adds files to an archive in a particular format.
+type archiveWriter interface {
+	addFile(f *object.File, mtime time.Time) error
+	Close() error
+}
+
+type tarGzArchiveWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzArchiveWriter(w io.Writer) *tarGzArchiveWriter {
+	gz := gzip.NewWriter(w)
+	return &tarGzArchiveWriter{gz, tar.NewWriter(gz)}
+}
+
+func (taw *tarGzArchiveWriter) addFile(
+	f *object.File, mtime time.Time) error {
+	hdr := &tar.Header{
+		Name:    f.Name,
+		ModTime: mtime,
+	}
+	if f.Mode == filemode.Symlink {
+		target, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = target
+		hdr.Mode = 0777
+		return taw.tw.WriteHeader(hdr)
+	}
+
+	hdr.Typeflag = tar.TypeReg
+	hdr.Mode = 0644
+	if f.Mode == filemode.Executable {
+		hdr.Mode = 0755
+	}
+	hdr.Size = f.Size
+	err := taw.tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(taw.tw, r)
+	return err
+}
+
+func (taw *tarGzArchiveWriter) Close() error {
+	err := taw.tw.Close()
+	if err != nil {
+		return err
+	}
+	return taw.gz.Close()
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (zaw *zipArchiveWriter) addFile(f *object.File, mtime time.Time) error {
+	hdr := &zip.FileHeader{
+		Name:   f.Name,
+		Method: zip.Deflate,
+	}
+	hdr.SetModTime(mtime)
+	switch f.Mode {
+	case filemode.Symlink:
+		hdr.SetMode(os.ModeSymlink | 0777)
+	case filemode.Executable:
+		hdr.SetMode(0755)
+	default:
+		hdr.SetMode(0644)
+	}
+	w, err := zaw.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	// A zip symlink's contents are its target, just like a git blob.
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (zaw *zipArchiveWriter) Close() error {
+	return zaw.zw.Close()
+}
+
+// resolveArchiveRef resolves `ref`, which is a full ref name, a
+// commit hash, or a branch or tag name, in that order of preference.
+func resolveArchiveRef(s *OnDemandStorer, ref string) (plumbing.Hash, error) {
+	h, err := resolveCommit(s, autogitTarget(ref))
+	if errors.Cause(err) != plumbing.ErrReferenceNotFound ||
+		strings.HasPrefix(ref, "refs/") {
+		return h, err
+	}
+	return resolveCommit(s, "refs/tags/"+ref)
+}
+
+// Archive writes an archive of the tree at `ref` in the repo in
+// `repoFS` to `w`, in the given format.  `ref` can be a full ref
+// name, a commit hash, or a branch or tag name.  Every file in the
+// archive has the commit time of the commit.  Submodules are left
+// out.
+func Archive(
+	ctx context.Context, repoFS billy.Filesystem, ref string,
+	format ArchiveFormat, w io.Writer) (err error) {
+	repoStorer, err := filesystem.NewStorage(repoFS)
+	if err != nil {
+		return err
+	}
+	storage, err := NewOnDemandStorer(repoStorer)
+	if err != nil {
+		return err
+	}
+	h, err := resolveArchiveRef(storage, ref)
+	if err != nil {
+		return err
+	}
+	commit, err := object.GetCommit(storage, h)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	var aw archiveWriter
+	switch format {
+	case ArchiveTarGz:
+		aw = newTarGzArchiveWriter(w)
+	case ArchiveZip:
+		aw = &zipArchiveWriter{zip.NewWriter(w)}
+	default:
+		return errors.Errorf("Unknown archive format: %d", format)
+	}
+	defer func() {
+		closeErr := aw.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	files := tree.Files()
+	defer files.Close()
+	for {
+		f, err := files.Next()
+		if errors.Cause(err) == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		err = aw.addFile(f, commit.Committer.When)
+		if err != nil {
+			return err
+		}
+	}
+}
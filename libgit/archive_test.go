@@ -0,0 +1,128 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func readTarGzArchive(t *testing.T, buf []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(buf))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(data)
+	}
+}
+
+func readZipArchive(t *testing.T, buf []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+		files[f.Name] = string(data)
+	}
+	return files
+}
+
+func TestArchive(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+
+	addFileToWorktree(t, repo, worktreeFS, "foo", "hello")
+	head1, err := repo.Head()
+	require.NoError(t, err)
+	err = dotgitStorage.SetReference(plumbing.NewHashReference(
+		plumbing.ReferenceName("refs/tags/v1"), head1.Hash()))
+	require.NoError(t, err)
+	err = worktreeFS.MkdirAll("dir", 0600)
+	require.NoError(t, err)
+	addFileToWorktree(t, repo, worktreeFS, "dir/foo2", "hello2")
+	commitWorktree(t, ctx, config, h, worktreeFS)
+
+	expectedHead := map[string]string{"foo": "hello", "dir/foo2": "hello2"}
+	expectedV1 := map[string]string{"foo": "hello"}
+	for _, tc := range []struct {
+		format   ArchiveFormat
+		read     func(*testing.T, []byte) map[string]string
+		ref      string
+		expected map[string]string
+	}{
+		{ArchiveTarGz, readTarGzArchive, "master", expectedHead},
+		{ArchiveZip, readZipArchive, "refs/heads/master", expectedHead},
+		{ArchiveTarGz, readTarGzArchive, "v1", expectedV1},
+		{ArchiveZip, readZipArchive, head1.Hash().String(), expectedV1},
+	} {
+		t.Logf("Archive %s as %s", tc.ref, tc.format)
+		var buf bytes.Buffer
+		err = Archive(ctx, dotgitFS, tc.ref, tc.format, &buf)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, tc.read(t, buf.Bytes()))
+	}
+
+	var buf bytes.Buffer
+	err = Archive(ctx, dotgitFS, "nope", ArchiveZip, &buf)
+	require.Error(t, err)
+
+	format, err := ParseArchiveFormat("TGZ")
+	require.NoError(t, err)
+	require.Equal(t, ArchiveTarGz, format)
+	_, err = ParseArchiveFormat("rar")
+	require.Error(t, err)
+
+	t.Log("Canceled contexts stop the archive")
+	canceledCtx, cancel2 := context.WithCancel(ctx)
+	cancel2()
+	err = Archive(canceledCtx, dotgitFS, "master", ArchiveTarGz, &buf)
+	require.Equal(t, context.Canceled, err)
+}
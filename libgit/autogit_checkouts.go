@@ -0,0 +1,155 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// AutogitCheckout describes one registered destination checkout of
+// a source repo.
+type AutogitCheckout struct {
+	Ref    string
+	DstTLF *libkbfs.TlfHandle
+	DstDir string
+}
+
+func checkoutSourceID(srcTLF *libkbfs.TlfHandle, srcRepo string) string {
+	return path.Join(srcTLF.GetCanonicalPath(), normalizeRepoName(srcRepo))
+}
+
+func (c AutogitCheckout) id(srcRepo string) string {
+	return path.Join(c.DstTLF.GetCanonicalPath(), c.DstDir, srcRepo)
+}
+
+// AddCheckout clones `ref` of the `srcRepo` repo from the TLF
+// `srcTLF` into `dstDir/srcRepo` in the TLF `dstTLF`, exactly like
+// Clone, and also registers the destination as a checkout of the
+// source repo, so that PullCheckouts updates it along with all the
+// other checkouts of the same source.
+//
+// The same source can have any number of checkouts, at different
+// refs, as long as each one has its own `dstDir`.  Like a `git
+// worktree`, every checkout reads objects straight out of the one
+// source repo, so only the checked-out files and a tiny bit of
+// per-checkout metadata (the HEAD and path filter) are stored for
+// each destination.
+func (am *AutogitManager) AddCheckout(
+	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo, ref string,
+	dstTLF *libkbfs.TlfHandle, dstDir, pathFilter string) (
+	doneCh <-chan struct{}, err error) {
+	srcID := checkoutSourceID(srcTLF, srcRepo)
+	checkout := AutogitCheckout{ref, dstTLF, dstDir}
+	dstID := checkout.id(srcRepo)
+	am.log.CDebugf(ctx, "Adding autogit checkout of %s:%s at %s",
+		srcID, ref, dstID)
+
+	err = func() error {
+		am.checkoutsLock.Lock()
+		defer am.checkoutsLock.Unlock()
+		if am.checkouts == nil {
+			return errors.New("autogit manager is shut down")
+		}
+		for otherSrcID, checkouts := range am.checkouts {
+			if _, ok := checkouts[dstID]; ok && otherSrcID != srcID {
+				return errors.Errorf(
+					"%s is already a checkout of %s", dstID, otherSrcID)
+			}
+		}
+		if am.checkouts[srcID] == nil {
+			am.checkouts[srcID] = make(map[string]AutogitCheckout)
+		}
+		am.checkouts[srcID][dstID] = checkout
+		return nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	doneCh, err = am.Clone(
+		ctx, srcTLF, srcRepo, ref, dstTLF, dstDir, pathFilter)
+	if err != nil {
+		am.RemoveCheckout(ctx, srcTLF, srcRepo, dstTLF, dstDir)
+		return nil, err
+	}
+	return doneCh, nil
+}
+
+// RemoveCheckout unregisters the checkout of `srcRepo` from the TLF
+// `srcTLF` at `dstDir/srcRepo` in the TLF `dstTLF`, if there is one.
+// The checked-out files are left in place; use Delete to remove
+// them.
+func (am *AutogitManager) RemoveCheckout(
+	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo string,
+	dstTLF *libkbfs.TlfHandle, dstDir string) {
+	srcID := checkoutSourceID(srcTLF, srcRepo)
+	dstID := AutogitCheckout{DstTLF: dstTLF, DstDir: dstDir}.id(srcRepo)
+	am.log.CDebugf(ctx, "Removing autogit checkout of %s at %s",
+		srcID, dstID)
+
+	am.checkoutsLock.Lock()
+	defer am.checkoutsLock.Unlock()
+	checkouts := am.checkouts[srcID]
+	delete(checkouts, dstID)
+	if len(checkouts) == 0 {
+		delete(am.checkouts, srcID)
+	}
+}
+
+// ListCheckouts returns all the registered checkouts of `srcRepo` in
+// the TLF `srcTLF`, sorted by destination.
+func (am *AutogitManager) ListCheckouts(
+	srcTLF *libkbfs.TlfHandle, srcRepo string) []AutogitCheckout {
+	am.checkoutsLock.Lock()
+	defer am.checkoutsLock.Unlock()
+	checkouts := am.checkouts[checkoutSourceID(srcTLF, srcRepo)]
+	dstIDs := make([]string, 0, len(checkouts))
+	for dstID := range checkouts {
+		dstIDs = append(dstIDs, dstID)
+	}
+	sort.Strings(dstIDs)
+	list := make([]AutogitCheckout, 0, len(dstIDs))
+	for _, dstID := range dstIDs {
+		list = append(list, checkouts[dstID])
+	}
+	return list
+}
+
+// PullCheckouts queues a pull into every registered checkout of
+// `srcRepo` in the TLF `srcTLF`, each at its own ref.  It returns a
+// channel that is closed once all of the pulls have finished (though
+// not necessarily successfully); PollProgress reports on each
+// checkout individually in the meantime.
+func (am *AutogitManager) PullCheckouts(
+	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo string) (
+	doneCh <-chan struct{}, err error) {
+	checkouts := am.ListCheckouts(srcTLF, srcRepo)
+	am.log.CDebugf(ctx, "Pulling %d autogit checkouts of %s",
+		len(checkouts), checkoutSourceID(srcTLF, srcRepo))
+
+	doneChs := make([]<-chan struct{}, 0, len(checkouts))
+	for _, c := range checkouts {
+		doneCh, err := am.Pull(
+			ctx, srcTLF, srcRepo, c.Ref, c.DstTLF, c.DstDir)
+		if err != nil {
+			return nil, err
+		}
+		doneChs = append(doneChs, doneCh)
+	}
+
+	allDoneCh := make(chan struct{})
+	go func() {
+		defer close(allDoneCh)
+		for _, doneCh := range doneChs {
+			<-doneCh
+		}
+	}()
+	return allDoneCh, nil
+}
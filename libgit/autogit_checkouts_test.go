@@ -0,0 +1,115 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestAutogitManagerCheckouts(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	t.Log("Init a new repo with two branches directly into KBFS.")
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktree(t, repo, worktreeFS, "foo", "hello")
+	setDevToHead := func() {
+		head, err := repo.Head()
+		require.NoError(t, err)
+		err = repo.Storer.SetReference(plumbing.NewHashReference(
+			"refs/heads/dev", head.Hash()))
+		require.NoError(t, err)
+		commitWorktree(t, ctx, config, h, worktreeFS)
+	}
+	setDevToHead()
+
+	kbCtx := env.NewContext()
+	kbfsInitParams := libkbfs.DefaultInitParams(kbCtx)
+	am := NewAutogitManager(config, kbCtx, &kbfsInitParams, 2)
+	defer am.Shutdown()
+	nc := &newConfigger{config: config, user: "user1"}
+	defer nc.shutdown(t, ctx)
+	am.getNewConfig = nc.getNewConfigForTest
+	wait := func(doneCh <-chan struct{}) {
+		select {
+		case <-doneCh:
+		case <-ctx.Done():
+			t.Fatal(ctx.Err().Error())
+		}
+	}
+
+	t.Log("Check out each branch into its own directory.")
+	for _, dir := range []string{"master", "dev", "other"} {
+		err = rootFS.MkdirAll(dir, 0600)
+		require.NoError(t, err)
+	}
+	doneCh, err := am.AddCheckout(
+		ctx, h, "test", "master", h, "master", "")
+	require.NoError(t, err)
+	wait(doneCh)
+	doneCh, err = am.AddCheckout(ctx, h, "test", "dev", h, "dev", "")
+	require.NoError(t, err)
+	wait(doneCh)
+	doneCh, err = am.AddCheckout(ctx, h, "test", "dev", h, "other", "")
+	require.NoError(t, err)
+	wait(doneCh)
+	require.Equal(t, []AutogitCheckout{
+		{"dev", h, "dev"},
+		{"master", h, "master"},
+		{"dev", h, "other"},
+	}, am.ListCheckouts(h, "Test"))
+	for _, dir := range []string{"master", "dev", "other"} {
+		checkFileInRootFS(
+			t, ctx, config, h, rootFS, dir+"/test/foo", "hello")
+	}
+
+	t.Log("A single pull updates each checkout to its own branch.")
+	am.RemoveCheckout(ctx, h, "test", h, "other")
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo2", "hello2")
+	doneCh, err = am.PullCheckouts(ctx, h, "test")
+	require.NoError(t, err)
+	wait(doneCh)
+	checkFileInRootFS(
+		t, ctx, config, h, rootFS, "master/test/foo2", "hello2")
+	_, err = rootFS.Stat("dev/test/foo2")
+	require.True(t, os.IsNotExist(err))
+
+	setDevToHead()
+	doneCh, err = am.PullCheckouts(ctx, h, "test")
+	require.NoError(t, err)
+	wait(doneCh)
+	checkFileInRootFS(t, ctx, config, h, rootFS, "dev/test/foo2", "hello2")
+	_, err = rootFS.Stat("other/test/foo2")
+	require.True(t, os.IsNotExist(err))
+}
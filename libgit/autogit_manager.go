@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,22 +21,43 @@ import (
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	billy "gopkg.in/src-d/go-billy.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 type resetReq struct {
-	srcTLF     *libkbfs.TlfHandle
-	srcRepo    string
-	branchName string
-	dstTLF     *libkbfs.TlfHandle
-	dstDir     string
+	srcTLF  *libkbfs.TlfHandle
+	srcRepo string
+	// ref is what to check out; see autogitTarget.
+	ref    string
+	dstTLF *libkbfs.TlfHandle
+	dstDir string
+	// pathFilter, if non-nil, is the only subdirectory of the repo
+	// to check out (see CleanPathFilter).  If nil, the checkout
+	// keeps the filter it already has.
+	pathFilter *string
 	doneCh     chan struct{}
+	// errCh, if non-nil, gets the result of the reset before doneCh
+	// is closed.  It must be buffered.
+	errCh chan error
+	// retries is how many times this request has been requeued
+	// because another worker was working on the repo.
+	retries int
+	opts    AutogitPullOptions
 }
 
 func (r resetReq) id() string {
 	return path.Join(r.dstTLF.GetCanonicalPath(), r.dstDir, r.srcRepo)
 }
 
+// rollsUpInto returns true if `queued`, a request for the same
+// destination, will do everything `r` would have done.
+func (r resetReq) rollsUpInto(queued resetReq) bool {
+	if r.pathFilter != nil && (queued.pathFilter == nil ||
+		*queued.pathFilter != *r.pathFilter) {
+		return false
+	}
+	return r.opts == (AutogitPullOptions{}) || r.opts == queued.opts
+}
+
 type deleteReq struct {
 	dstTLF     *libkbfs.TlfHandle
 	dstDir     string
@@ -44,13 +66,19 @@ type deleteReq struct {
 	doneCh     chan struct{}
 }
 
+func (r deleteReq) id() string {
+	return path.Join(r.dstTLF.GetCanonicalPath(), r.dstDir, r.repo)
+}
+
 const (
 	cloneFileName = "CLONING"
 
 	// Debug tag ID for an individual autogit operation
 	ctxOpID = "AGM"
 
-	workTimeLimit = 1 * time.Hour
+	// shutdownTimeout is how long Shutdown waits for outstanding
+	// resets and updates before giving up on them.
+	shutdownTimeout = 10 * time.Second
 )
 
 type ctxTagKey int
@@ -71,6 +99,17 @@ func autogitLastErrName(srcRepo string) string {
 	return fmt.Sprintf(".autogit_%s.lasterr", srcRepo)
 }
 
+// autogitTarget returns what Reset should check out for the
+// user-supplied `ref`: full reference names (e.g., "refs/tags/v1.0")
+// and full commit hashes are used as-is, and anything else is taken
+// to be a branch name.
+func autogitTarget(ref string) string {
+	if strings.HasPrefix(ref, "refs/") || isCommitHash(ref) {
+		return ref
+	}
+	return fmt.Sprintf("refs/heads/%s", ref)
+}
+
 type getNewConfigFn func(context.Context) (
 	context.Context, libkbfs.Config, string, error)
 
@@ -92,16 +131,40 @@ type AutogitManager struct {
 	updatingWG     kbfssync.RepeatedWaitGroup
 	deleteQueue    channels.Channel
 	deleteDoneCh   chan struct{}
-
-	lock             sync.Mutex
-	resetsInQueue    map[string]resetReq // key: resetReq.id()
-	resetsInProgress map[string]resetReq // key: resetReq.id()
+	// minPullInterval can be overridden by tests.
+	minPullInterval time.Duration
+
+	lock              sync.Mutex
+	resetsInQueue     map[string]resetReq       // key: resetReq.id()
+	resetsInProgress  map[string]resetReq       // key: resetReq.id()
+	progress          map[string]*resetProgress // key: resetReq.id()
+	lastErrs          map[string]autogitLastErr // key: resetReq.id()
+	numWorkers        int
+	busyWorkers       int
+	pendingRetries    int
+	deletesInQueue    map[string]deleteReq // key: deleteReq.id()
+	deletesInProgress map[string]deleteReq // key: deleteReq.id()
 
 	registryLock           sync.RWMutex
 	registeredFBs          map[libkbfs.FolderBranch]bool
 	repoNodesForWatchedIDs map[libkbfs.NodeID]*repoNode
 	watchedNodes           []libkbfs.Node // preventing GC on the watched nodes
 	populatedRepos         map[libkbfs.NodeID]bool
+
+	retryPolicy   WorkLockRetryPolicy // protected by lock
+	workTimeLimit time.Duration       // protected by lock
+	shutdown      bool                // protected by lock
+	shutdownCh    chan struct{}
+	retriesWG     sync.WaitGroup
+
+	schedulesLock sync.Mutex
+	schedules     map[string]*scheduledPull // key: resetReq.id()
+	mirrors       map[string]*teamMirror    // key: teamMirror.id()
+	schedulesWG   sync.WaitGroup
+
+	checkoutsLock sync.Mutex
+	// key: source repo path, then resetReq.id() of the checkout.
+	checkouts map[string]map[string]AutogitCheckout
 }
 
 // NewAutogitManager constructs a new AutogitManager instance, and
@@ -122,9 +185,21 @@ func NewAutogitManager(
 		deleteDoneCh:           make(chan struct{}),
 		resetsInQueue:          make(map[string]resetReq),
 		resetsInProgress:       make(map[string]resetReq),
+		progress:               make(map[string]*resetProgress),
+		lastErrs:               make(map[string]autogitLastErr),
+		numWorkers:             numWorkers,
+		deletesInQueue:         make(map[string]deleteReq),
+		deletesInProgress:      make(map[string]deleteReq),
 		registeredFBs:          make(map[libkbfs.FolderBranch]bool),
 		repoNodesForWatchedIDs: make(map[libkbfs.NodeID]*repoNode),
 		populatedRepos:         make(map[libkbfs.NodeID]bool),
+		minPullInterval:        minPullInterval,
+		schedules:              make(map[string]*scheduledPull),
+		mirrors:                make(map[string]*teamMirror),
+		checkouts:              make(map[string]map[string]AutogitCheckout),
+		retryPolicy:            DefaultWorkLockRetryPolicy(),
+		workTimeLimit:          defaultWorkTimeLimit,
+		shutdownCh:             make(chan struct{}),
 	}
 	am.getNewConfig = am.getNewConfigDefault
 	go am.resetLoop(numWorkers)
@@ -134,10 +209,38 @@ func NewAutogitManager(
 
 // Shutdown shuts down this manager.
 func (am *AutogitManager) Shutdown() {
+	// Scheduled pulls queue resets, so stop them first.
+	am.stopSchedules()
+	func() {
+		am.checkoutsLock.Lock()
+		defer am.checkoutsLock.Unlock()
+		am.checkouts = nil
+	}()
+	// Pending retries also queue resets.
+	am.stopRetries()
 	am.resetQueue.Close()
 	am.deleteQueue.Close()
-	<-am.queueDoneCh
-	<-am.deleteDoneCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, wg := range []*kbfssync.RepeatedWaitGroup{
+		&am.resetsWG, &am.updatingWG} {
+		err := wg.WaitWithContext(ctx)
+		if err != nil {
+			am.log.CWarningf(ctx, "Giving up on outstanding autogit work "+
+				"during shutdown: %+v", err)
+			return
+		}
+	}
+	for _, doneCh := range []chan struct{}{am.queueDoneCh, am.deleteDoneCh} {
+		select {
+		case <-doneCh:
+		case <-ctx.Done():
+			am.log.CWarningf(ctx, "Giving up waiting for autogit workers "+
+				"during shutdown: %+v", ctx.Err())
+			return
+		}
+	}
 }
 
 func (am *AutogitManager) getNewConfigDefault(ctx context.Context) (
@@ -148,18 +251,27 @@ func (am *AutogitManager) getNewConfigDefault(ctx context.Context) (
 // commonTime computes the current time according to our estimate of
 // the mdserver's time.  It's a very crude way of normalizing the
 // local clock.
-func (am *AutogitManager) commonTime(ctx context.Context) time.Time {
-	offset, haveOffset := am.config.MDServer().OffsetFromServerTime()
+// commonTime returns the current time according to the server, if
+// the offset from it is known, so that the times written by different
+// devices can be compared.
+func commonTime(
+	ctx context.Context, config libkbfs.Config, log logger.Logger) time.Time {
+	offset, haveOffset := config.MDServer().OffsetFromServerTime()
 	if !haveOffset {
-		am.log.CDebugf(ctx, "No offset, cannot use common time; "+
+		log.CDebugf(ctx, "No offset, cannot use common time; "+
 			"falling back to local time")
-		return am.config.Clock().Now()
+		return config.Clock().Now()
 	}
-	return am.config.Clock().Now().Add(-offset)
+	return config.Clock().Now().Add(-offset)
+}
+
+func (am *AutogitManager) commonTime(ctx context.Context) time.Time {
+	return commonTime(ctx, am.config, am.log)
 }
 
 func (am *AutogitManager) canWorkOnRepo(
-	ctx context.Context, dstFS *libfs.FS, repo string) (
+	ctx context.Context, dstFS *libfs.FS, repo string,
+	workTimeLimit time.Duration, forceTakeover bool) (
 	canWork bool, err error) {
 	am.log.CDebugf(ctx, "Checking if we can work on %s", repo)
 	defer func() {
@@ -207,7 +319,20 @@ func (am *AutogitManager) canWorkOnRepo(
 		}
 	} else { // err == nil
 		modCommonTime := fi.ModTime()
-		if modCommonTime.Add(workTimeLimit).After(currCommonTime) {
+		stillWorking := modCommonTime.Add(workTimeLimit).After(currCommonTime)
+		if stillWorking && forceTakeover {
+			flushed, err := am.journalFlushed(ctx, dstFS)
+			if err != nil {
+				return false, err
+			}
+			if flushed {
+				am.log.CDebugf(ctx, "Forcing takeover of work on %s; "+
+					"modCommonTime=%s, currCommonTime=%s",
+					repo, modCommonTime, currCommonTime)
+				stillWorking = false
+			}
+		}
+		if stillWorking {
 			am.log.CDebugf(ctx, "Other worker is still working on %s; "+
 				"modCommonTime=%s, currCommonTime=%s, workTimeLimit=%s",
 				repo, modCommonTime, currCommonTime, workTimeLimit)
@@ -235,7 +360,16 @@ func (am *AutogitManager) workDoneOnRepo(
 	defer func() {
 		am.deferLog.CDebugf(ctx, "Work done completed: %+v", err)
 	}()
+	return am.updateLastErr(ctx, dstFS, repo, true, workErr)
+}
 
+// updateLastErr replaces the lasterr file for `repo` with `workErr`,
+// or just removes it if `workErr` is nil.  If `workDone` is true,
+// it also removes the working file, releasing the repo to other
+// workers.
+func (am *AutogitManager) updateLastErr(
+	ctx context.Context, dstFS *libfs.FS, repo string, workDone bool,
+	workErr error) (err error) {
 	// Take the lock for the dst repo while checking the work time.
 	lockFile, err := dstFS.Create(autogitLockName(repo))
 	if err != nil {
@@ -258,9 +392,11 @@ func (am *AutogitManager) workDoneOnRepo(
 		return err
 	}
 
-	err = dstFS.Remove(autogitWorkingName(repo))
-	if err != nil {
-		return err
+	if workDone {
+		err = dstFS.Remove(autogitWorkingName(repo))
+		if err != nil {
+			return err
+		}
 	}
 
 	// Remove the old lasterr file if it exists.  TODO: check if we
@@ -287,8 +423,8 @@ func (am *AutogitManager) workDoneOnRepo(
 	return nil
 }
 
-func (am *AutogitManager) doReset(ctx context.Context, req resetReq) (
-	err error) {
+func (am *AutogitManager) doReset(
+	ctx context.Context, req resetReq, rp *resetProgress) (err error) {
 	am.log.CDebugf(ctx, "Processing reset request from %s/%s to %s/%s",
 		req.srcTLF.GetCanonicalPath(), req.srcRepo,
 		req.dstTLF.GetCanonicalPath(), req.dstDir)
@@ -332,18 +468,36 @@ func (am *AutogitManager) doReset(ctx context.Context, req resetReq) (
 		return err
 	}
 
-	canWork, err := am.canWorkOnRepo(ctx, dstFS, req.srcRepo)
+	canWork, err := am.canWorkOnRepo(
+		ctx, dstFS, req.srcRepo, am.workTimeLimitFor(req),
+		req.opts.ForceTakeover)
 	if err != nil {
 		return err
 	}
 	if !canWork {
+		policy := am.getWorkLockRetryPolicy()
+		if req.retries < policy.MaxRetries {
+			am.log.CDebugf(ctx,
+				"Another worker is currently in charge; will retry")
+			return errAutogitWorkLockHeld
+		}
 		am.log.CDebugf(ctx,
-			"Another worker is currently in charge; skipping reset")
-		// TODO: retry in a little while?
-		return nil
+			"Another worker is currently in charge; giving up")
+		err = fmt.Errorf("%v; gave up after %d retries",
+			errAutogitWorkLockHeld, req.retries)
+		lastErrErr := am.updateLastErr(ctx, dstFS, req.srcRepo, false, err)
+		if lastErrErr != nil {
+			am.log.CDebugf(ctx, "Couldn't write lasterr: %+v", lastErrErr)
+		}
+		return err
 	}
 	defer func() {
-		workDoneErr := am.workDoneOnRepo(ctx, dstFS, req.srcRepo, err)
+		workErr := err
+		if workErr != nil && req.retries > 0 {
+			workErr = fmt.Errorf("%v (after %d retries waiting for "+
+				"another worker)", err, req.retries)
+		}
+		workDoneErr := am.workDoneOnRepo(ctx, dstFS, req.srcRepo, workErr)
 		if err == nil {
 			err = workDoneErr
 		}
@@ -354,11 +508,18 @@ func (am *AutogitManager) doReset(ctx context.Context, req resetReq) (
 		return err
 	}
 
-	// For now, assume the branch name refers to a ref head.
-	branch := plumbing.ReferenceName(
-		fmt.Sprintf("refs/heads/%s", req.branchName))
-	am.log.CDebugf(ctx, "Starting the reset")
-	return Reset(ctx, srcRepoFS, dstRepoFS, branch)
+	target := autogitTarget(req.ref)
+	am.log.CDebugf(ctx, "Starting the reset to %s", target)
+	err = resetWithProgress(
+		ctx, srcRepoFS, dstRepoFS, target, req.pathFilter, rp)
+	if err != nil {
+		return err
+	}
+	if am.kbfsInitParams == nil || !am.kbfsInitParams.AutogitSubmodules {
+		return nil
+	}
+	return am.resetSubmodules(
+		ctx, gitConfig, uniqID, srcRepoFS, dstRepoFS, target, 0)
 }
 
 func (am *AutogitManager) markResetReqInProgress(req resetReq) (
@@ -378,13 +539,14 @@ func (am *AutogitManager) clearFromInProgress(req resetReq) {
 	am.lock.Lock()
 	defer am.lock.Unlock()
 	delete(am.resetsInProgress, req.id())
-	am.resetsWG.Done()
+	am.resetsWG.DoneLabeled(req.id())
 }
 
 func (am *AutogitManager) resetWorker(wg *sync.WaitGroup) {
 	defer wg.Done()
 	for reqInt := range am.resetQueue.Out() {
 		req := reqInt.(resetReq)
+		am.setWorkerBusy(true)
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 		ctx = libkbfs.CtxWithRandomIDReplayable(
@@ -404,7 +566,20 @@ func (am *AutogitManager) resetWorker(wg *sync.WaitGroup) {
 			am.log.CDebugf(ctx, "Done waiting")
 		}
 
-		_ = am.doReset(ctx, req)
+		rp := am.startProgress(req.id())
+		err := am.doReset(ctx, req, rp)
+		if err == errAutogitWorkLockHeld && am.retryLater(ctx, req) {
+			// The retry takes over `req.doneCh`.
+			rp.setPhase(AutogitPhaseQueued)
+			am.clearFromInProgress(req)
+			am.setWorkerBusy(false)
+			continue
+		}
+		rp.finish(err)
+		am.recordResult(req, err)
+		if req.errCh != nil {
+			req.errCh <- err
+		}
 
 		// We can clear from in-progress or close in any order.  If
 		// there's a race in between, the only thinga affected in the
@@ -412,6 +587,7 @@ func (am *AutogitManager) resetWorker(wg *sync.WaitGroup) {
 		// iteration before the in-progress channel is closed.
 		am.clearFromInProgress(req)
 		close(req.doneCh)
+		am.setWorkerBusy(false)
 	}
 }
 
@@ -431,16 +607,19 @@ func (am *AutogitManager) queueReset(ctx context.Context, req resetReq) (
 	doneCh = func() chan struct{} {
 		am.lock.Lock()
 		defer am.lock.Unlock()
-		if req, ok := am.resetsInQueue[id]; ok {
-			return req.doneCh
+		if queued, ok := am.resetsInQueue[id]; ok && req.rollsUpInto(queued) {
+			// The queued request will do everything this one
+			// would have done.
+			return queued.doneCh
 		}
-		am.resetsWG.Add(1)
+		am.resetsWG.AddLabeled(id)
 		am.resetsInQueue[id] = req
 		return nil
 	}()
 	if doneCh != nil {
 		return doneCh, nil
 	}
+	am.queuedProgress(id)
 	select {
 	case am.resetQueue.In() <- req:
 		am.log.CDebugf(ctx, "Queued new reset request for %s", id)
@@ -513,7 +692,8 @@ func (am *AutogitManager) doDelete(req deleteReq) (err error) {
 		return err
 	}
 
-	canWork, err := am.canWorkOnRepo(ctx, dstFS, req.repo)
+	canWork, err := am.canWorkOnRepo(
+		ctx, dstFS, req.repo, am.getWorkTimeLimit(), false)
 	if err != nil {
 		return err
 	}
@@ -544,10 +724,25 @@ func (am *AutogitManager) doDelete(req deleteReq) (err error) {
 	return recursiveDelete(ctx, dstFS, fi)
 }
 
+func (am *AutogitManager) markDeleteReqInProgress(req deleteReq) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	delete(am.deletesInQueue, req.id())
+	am.deletesInProgress[req.id()] = req
+}
+
+func (am *AutogitManager) clearDeleteFromInProgress(req deleteReq) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	delete(am.deletesInProgress, req.id())
+}
+
 func (am *AutogitManager) deleteLoop() {
 	for reqInt := range am.deleteQueue.Out() {
 		req := reqInt.(deleteReq)
+		am.markDeleteReqInProgress(req)
 		_ = am.doDelete(req)
+		am.clearDeleteFromInProgress(req)
 		close(req.doneCh)
 	}
 	close(am.deleteDoneCh)
@@ -555,7 +750,7 @@ func (am *AutogitManager) deleteLoop() {
 
 func (am *AutogitManager) makeCloningFile(
 	ctx context.Context, dstRepoFS billy.Filesystem, srcTLF *libkbfs.TlfHandle,
-	srcRepo, branchName string) error {
+	srcRepo, ref string) error {
 	am.log.CDebugf(ctx, "Making CLONING file")
 	cloneFile, err := dstRepoFS.Create(cloneFileName)
 	if err != nil {
@@ -563,22 +758,30 @@ func (am *AutogitManager) makeCloningFile(
 	}
 	defer cloneFile.Close()
 	_, err = io.WriteString(cloneFile,
-		fmt.Sprintf("%s/%s:%s", srcTLF.GetCanonicalPath(), srcRepo, branchName))
+		fmt.Sprintf("%s/%s:%s", srcTLF.GetCanonicalPath(), srcRepo, ref))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// Clone queues a request to clone the `branchName` branch of the
-// `srcRepo` repo from the TLF `srcTLF`, into a subdirectory named
-// `dstDir/srcRepo` in the TLF `dstTLF`. `dstDir` must already exist
-// in `dstTLF`.
+// Clone queues a request to clone `ref` of the `srcRepo` repo from
+// the TLF `srcTLF`, into a subdirectory named `dstDir/srcRepo` in the
+// TLF `dstTLF`. `dstDir` must already exist in `dstTLF`.  `ref` is
+// usually a branch name, but it can also be a full reference name
+// like "refs/tags/v1.0", or a full commit hash, to pin the checkout
+// to a particular release.
+//
+// If `pathFilter` is non-empty, only that subdirectory of the repo
+// (e.g., "docs/") is checked out, at the same path under
+// `dstDir/srcRepo`; later pulls into the same destination keep using
+// it.  This makes it practical to autogit parts of large repos.
 //
 // It returns a channel that, when closed, indicates the clone request
 // has finished (though not necessarily successfully).  The caller may
 // have to sync from the server to ensure they are see the changes,
-// however.
+// however.  PollProgress reports how far along the clone is in the
+// meantime.
 //
 // If the cloned directory doesn't exist yet, this function creates a
 // placeholder "CLONING" file to let users know the clone is happening
@@ -589,20 +792,25 @@ func (am *AutogitManager) makeCloningFile(
 // clone from the same repo/branch as the existing repo that might
 // have already been there.
 //
-// If the caller specifies a non-master `branchName`, they should make
-// sure `dstDir` is unique for that branch; i.e., the branch name
-// should appear in the path somewhere.
+// If the caller specifies a `ref` other than master, they should make
+// sure `dstDir` is unique for that ref; i.e., the branch name, tag or
+// commit should appear in the path somewhere.
 func (am *AutogitManager) Clone(
-	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo, branchName string,
-	dstTLF *libkbfs.TlfHandle, dstDir string) (
+	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo, ref string,
+	dstTLF *libkbfs.TlfHandle, dstDir, pathFilter string) (
 	doneCh <-chan struct{}, err error) {
-	am.log.CDebugf(ctx, "Autogit clone request from %s/%s:%s to %s/%s",
-		srcTLF.GetCanonicalPath(), srcRepo, branchName,
+	am.log.CDebugf(ctx, "Autogit clone request from %s/%s:%s (%q) to %s/%s",
+		srcTLF.GetCanonicalPath(), srcRepo, ref, pathFilter,
 		dstTLF.GetCanonicalPath(), dstDir)
 	defer func() {
 		am.deferLog.CDebugf(ctx, "Clone request processed: %+v", err)
 	}()
 
+	pathFilter, err = CleanPathFilter(pathFilter)
+	if err != nil {
+		return nil, err
+	}
+
 	dstFS, err := libfs.NewFS(
 		ctx, am.config, dstTLF, dstDir, "", keybase1.MDPriorityNormal)
 	if err != nil {
@@ -640,7 +848,7 @@ func (am *AutogitManager) Clone(
 		return nil, err
 	}
 	if len(fis) == 0 {
-		err = am.makeCloningFile(ctx, dstRepoFS, srcTLF, srcRepo, branchName)
+		err = am.makeCloningFile(ctx, dstRepoFS, srcTLF, srcRepo, ref)
 		if err != nil {
 			return nil, err
 		}
@@ -652,53 +860,49 @@ func (am *AutogitManager) Clone(
 	}
 
 	req := resetReq{
-		srcTLF, srcRepo, branchName, dstTLF, dstDir, make(chan struct{}),
+		srcTLF, srcRepo, ref, dstTLF, dstDir, &pathFilter,
+		make(chan struct{}), nil, 0, AutogitPullOptions{},
 	}
 	return am.queueReset(ctx, req)
 }
 
-// Pull queues a request to pull the `branchName` branch of the
-// `srcRepo` repo from the TLF `srcTLF`, into a subdirectory named
-// `dstDir/srcRepo` in the TLF `dstTLF`. `dstDir/srcRepo` must already
-// exist in `dstTLF`.
+// Pull queues a request to pull `ref` of the `srcRepo` repo from the
+// TLF `srcTLF`, into a subdirectory named `dstDir/srcRepo` in the TLF
+// `dstTLF`. `dstDir/srcRepo` must already exist in `dstTLF`.  `ref`
+// is interpreted the same way as in Clone.
 //
 // It returns a channel that, when closed, indicates the pull request
 // has finished (though not necessarily successfully).  The caller may
 // have to sync from the server to ensure they are see the changes,
-// however.
+// however.  PollProgress reports how far along the pull is in the
+// meantime.
 //
 // Note that this tramples any data that was previously in
 // `dstDir/srcRepo`, so the caller must ensure that they are
 // requesting a pull from the correct repo/branch as the existing repo
 // that was already there.
 //
-// If the caller specifies a non-master `branchName`, they should make
-// sure `dstDir` is unique for that branch; i.e., the branch name
-// should appear in the path somewhere.
+// If the caller specifies a `ref` other than master, they should make
+// sure `dstDir` is unique for that ref; i.e., the branch name, tag or
+// commit should appear in the path somewhere.
 func (am *AutogitManager) Pull(
-	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo, branchName string,
+	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo, ref string,
 	dstTLF *libkbfs.TlfHandle, dstDir string) (
 	doneCh <-chan struct{}, err error) {
-	am.log.CDebugf(ctx, "Autogit pull request from %s/%s:%s to %s/%s",
-		srcTLF.GetCanonicalPath(), srcRepo, branchName,
-		dstTLF.GetCanonicalPath(), dstDir)
-	defer func() {
-		am.deferLog.CDebugf(ctx, "Pull request processed: %+v", err)
-	}()
-
-	req := resetReq{
-		srcTLF, srcRepo, branchName, dstTLF, dstDir, make(chan struct{}),
-	}
-	return am.queueReset(ctx, req)
+	return am.PullWithOptions(
+		ctx, srcTLF, srcRepo, ref, dstTLF, dstDir, AutogitPullOptions{})
 }
 
 // Delete queues a request to delete an autogit destination subdir
 // named `dstDir/srcRepo` in the TLF `dstTLF`.
 //
-// It returns a channel that, when closed, indicates the pull request
+// It returns a channel that, when closed, indicates the delete request
 // has finished (though not necessarily successfully).  The caller may
 // have to sync from the server to ensure they are see the changes,
-// however.
+// however.  If a delete for the same subdir is already queued, this
+// returns that request's channel instead of queueing another one.  A
+// delete that's already in progress doesn't count, since the subdir
+// may have been recreated since it started.
 func (am *AutogitManager) Delete(
 	ctx context.Context, dstTLF *libkbfs.TlfHandle, dstDir string,
 	repo, branchName string) (doneCh <-chan struct{}, err error) {
@@ -711,10 +915,27 @@ func (am *AutogitManager) Delete(
 	req := deleteReq{
 		dstTLF, dstDir, repo, branchName, make(chan struct{}),
 	}
+	id := req.id()
+	queuedDoneCh := func() chan struct{} {
+		am.lock.Lock()
+		defer am.lock.Unlock()
+		if queued, ok := am.deletesInQueue[id]; ok {
+			return queued.doneCh
+		}
+		am.deletesInQueue[id] = req
+		return nil
+	}()
+	if queuedDoneCh != nil {
+		am.log.CDebugf(ctx, "Delete request for %s is already queued", id)
+		return queuedDoneCh, nil
+	}
 
 	select {
 	case am.deleteQueue.In() <- req:
 	case <-ctx.Done():
+		// We've already promised to queue this, and may have turned
+		// away other requests for it already, so we better queue it.
+		go func() { am.deleteQueue.In() <- req }()
 		return nil, ctx.Err()
 	}
 	return req.doneCh, nil
@@ -763,9 +984,11 @@ func (am *AutogitManager) notifyNodeLocked(
 		return
 	}
 
-	am.updatingWG.Add(1)
+	label := path.Join(
+		rn.srcRepoHandle.GetCanonicalPath(), rn.repoName)
+	am.updatingWG.AddLabeled(label)
 	go func() {
-		defer am.updatingWG.Done()
+		defer am.updatingWG.DoneLabeled(label)
 		ctx := libkbfs.BackgroundContextWithCancellationDelayer()
 		ctx = libkbfs.CtxWithRandomIDReplayable(
 			ctx, ctxIDKey, ctxOpID, am.log)
@@ -212,7 +212,9 @@ func TestAutogitManager(t *testing.T) {
 
 	err = rootFS.MkdirAll("checkout", 0600)
 
-	doneCh, err := am.Clone(ctx, h, "test", "master", h, "checkout")
+	_, ok := am.PollProgress(h, "checkout", "test")
+	require.False(t, ok)
+	doneCh, err := am.Clone(ctx, h, "test", "master", h, "checkout", "")
 	require.NoError(t, err)
 	select {
 	case <-doneCh:
@@ -221,6 +223,14 @@ func TestAutogitManager(t *testing.T) {
 	}
 
 	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo", "hello")
+	progress, ok := am.PollProgress(h, "checkout", "test")
+	require.True(t, ok)
+	require.Equal(t, AutogitProgress{
+		Phase:           AutogitPhaseDone,
+		FilesTotal:      1,
+		FilesCheckedOut: 1,
+		BytesWritten:    int64(len("hello")),
+	}, progress)
 
 	t.Log("Add a new file and try a pull.")
 	addFileToWorktreeAndCommit(
@@ -234,16 +244,102 @@ func TestAutogitManager(t *testing.T) {
 	}
 
 	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo2", "hello2")
+	progress, ok = am.PollProgress(h, "checkout", "test")
+	require.True(t, ok)
+	require.Equal(t, AutogitPhaseDone, progress.Phase)
+	require.NoError(t, progress.Err)
+	require.Equal(t, int64(2), progress.FilesTotal)
+	require.True(t, progress.FilesCheckedOut >= 1)
 
-	t.Log("Deleting repo")
+	t.Log("Deleting repo, while more deletes for it pile up")
+	startedCh := make(chan struct{}, 1)
+	unblockCh := make(chan struct{})
+	am.getNewConfig = func(ctx context.Context) (
+		context.Context, libkbfs.Config, string, error) {
+		select {
+		case startedCh <- struct{}{}:
+		default:
+		}
+		<-unblockCh
+		return nc.getNewConfigForTest(ctx)
+	}
 	doneCh, err = am.Delete(ctx, h, "checkout", "test", "master")
 	require.NoError(t, err)
 	select {
-	case <-doneCh:
+	case <-startedCh:
 	case <-ctx.Done():
 		t.Fatal(ctx.Err().Error())
 	}
+	doneCh2, err := am.Delete(ctx, h, "checkout", "test", "master")
+	require.NoError(t, err)
+	require.NotEqual(t, doneCh, doneCh2)
+	doneCh3, err := am.Delete(ctx, h, "checkout", "test", "master")
+	require.NoError(t, err)
+	require.Equal(t, doneCh2, doneCh3)
+	close(unblockCh)
+	for _, ch := range []<-chan struct{}{doneCh, doneCh2} {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			t.Fatal(ctx.Err().Error())
+		}
+	}
 
 	_, err = rootFS.Stat("checkout/test")
 	require.True(t, os.IsNotExist(err))
 }
+
+func TestAutogitManagerSparseClone(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	t.Log("Init a new repo with a subdirectory directly into KBFS.")
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree/docs", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktree(t, repo, worktreeFS, "foo", "hello")
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "docs/foo2", "hello2")
+
+	kbCtx := env.NewContext()
+	kbfsInitParams := libkbfs.DefaultInitParams(kbCtx)
+	am := NewAutogitManager(config, kbCtx, &kbfsInitParams, 1)
+	defer am.Shutdown()
+	nc := &newConfigger{config: config, user: "user1"}
+	defer nc.shutdown(t, ctx)
+	am.getNewConfig = nc.getNewConfigForTest
+
+	err = rootFS.MkdirAll("checkout", 0600)
+	require.NoError(t, err)
+	doneCh, err := am.Clone(
+		ctx, h, "test", "master", h, "checkout", "docs/")
+	require.NoError(t, err)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err().Error())
+	}
+
+	checkFileInRootFS(
+		t, ctx, config, h, rootFS, "checkout/test/docs/foo2", "hello2")
+	_, err = rootFS.Stat("checkout/test/foo")
+	require.True(t, os.IsNotExist(err))
+	_, err = rootFS.Stat("checkout/test/" + cloneFileName)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,285 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// teamMirror keeps checkouts of every repo in a team TLF.
+type teamMirror struct {
+	srcTLF   *libkbfs.TlfHandle
+	ref      string
+	dstTLF   *libkbfs.TlfHandle
+	dstDir   string
+	interval time.Duration
+	stopCh   chan struct{}
+	doneCh   chan struct{} // closed when mirrorLoop returns
+}
+
+func (tm *teamMirror) id() string {
+	return path.Join(tm.dstTLF.GetCanonicalPath(), tm.dstDir)
+}
+
+// listRepos returns the normalized names of the repos in the TLF
+// `h`, sorted.
+func listRepos(
+	ctx context.Context, config libkbfs.Config, h *libkbfs.TlfHandle) (
+	repos []string, err error) {
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	if err != nil {
+		return nil, err
+	}
+	err = kbfsOps.SyncFromServer(ctx, rootNode.GetFolderBranch(), nil)
+	if err != nil {
+		return nil, err
+	}
+	repoDir, _, err := kbfsOps.Lookup(ctx, rootNode, kbfsRepoDir)
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError:
+		return nil, nil
+	case nil:
+	default:
+		return nil, err
+	}
+	children, err := kbfsOps.GetDirChildren(ctx, repoDir)
+	if err != nil {
+		return nil, err
+	}
+	for name, ei := range children {
+		// Skip the symlinks left behind by renames, and any
+		// bookkeeping directories.
+		if ei.Type != libkbfs.Dir || !repoNameRE.MatchString(name) {
+			continue
+		}
+		repos = append(repos, name)
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
+
+// mirroredRepos returns the names of the autogit checkouts under
+// `dstFS`, i.e. the subdirectories that autogit has locked at some
+// point.
+func mirroredRepos(dstFS *libfs.FS) (map[string]bool, error) {
+	fis, err := dstFS.ReadDir("")
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(fis))
+	for _, fi := range fis {
+		names[fi.Name()] = fi.IsDir()
+	}
+	repos := make(map[string]bool)
+	for name, isDir := range names {
+		if isDir {
+			if _, locked := names[autogitLockName(name)]; locked {
+				repos[name] = true
+			}
+		}
+	}
+	return repos, nil
+}
+
+// syncMirror deletes the checkouts of repos that are gone from the
+// team, then clones repos that are new in the team and pulls the
+// ones it already had.  It waits for all the requests to finish,
+// unless `tm` is stopped.
+func (am *AutogitManager) syncMirror(
+	ctx context.Context, tm *teamMirror) (err error) {
+	am.log.CDebugf(ctx, "Syncing team mirror from %s to %s",
+		tm.srcTLF.GetCanonicalPath(), tm.id())
+	defer func() {
+		am.deferLog.CDebugf(ctx, "Team mirror sync done: %+v", err)
+	}()
+
+	srcRepos, err := listRepos(ctx, am.config, tm.srcTLF)
+	if err != nil {
+		return err
+	}
+	dstFS, err := libfs.NewFS(
+		ctx, am.config, tm.dstTLF, tm.dstDir, "", keybase1.MDPriorityNormal)
+	if err != nil {
+		return err
+	}
+	// Earlier clones wrote their lock files with `am.config`, and
+	// updates can't be applied while those writes are dirty.
+	err = dstFS.SyncAll()
+	if err != nil {
+		return err
+	}
+	err = am.config.KBFSOps().SyncFromServer(
+		ctx, dstFS.RootNode().GetFolderBranch(), nil)
+	if err != nil {
+		return err
+	}
+	dstRepos, err := mirroredRepos(dstFS)
+	if err != nil {
+		return err
+	}
+
+	// Deletes are processed separately from resets, so finish them
+	// first rather than have them write to the destination TLF at the
+	// same time as the clones and pulls.
+	srcRepoSet := make(map[string]bool, len(srcRepos))
+	for _, repo := range srcRepos {
+		srcRepoSet[repo] = true
+	}
+	var doneChs []<-chan struct{}
+	for repo := range dstRepos {
+		if srcRepoSet[repo] {
+			continue
+		}
+		am.log.CDebugf(ctx, "Repo %s is gone from %s",
+			repo, tm.srcTLF.GetCanonicalPath())
+		doneCh, err := am.Delete(ctx, tm.dstTLF, tm.dstDir, repo, tm.ref)
+		if err != nil {
+			return err
+		}
+		doneChs = append(doneChs, doneCh)
+	}
+	if !waitForMirrorRequests(tm, doneChs) {
+		return nil
+	}
+
+	doneChs = nil
+	for _, repo := range srcRepos {
+		var doneCh <-chan struct{}
+		if dstRepos[repo] {
+			doneCh, err = am.Pull(
+				ctx, tm.srcTLF, repo, tm.ref, tm.dstTLF, tm.dstDir)
+		} else {
+			am.log.CDebugf(ctx, "New repo %s in %s",
+				repo, tm.srcTLF.GetCanonicalPath())
+			doneCh, err = am.Clone(
+				ctx, tm.srcTLF, repo, tm.ref, tm.dstTLF, tm.dstDir, "")
+		}
+		if err != nil {
+			return err
+		}
+		doneChs = append(doneChs, doneCh)
+	}
+	waitForMirrorRequests(tm, doneChs)
+	return nil
+}
+
+// waitForMirrorRequests waits for all of `doneChs` to be closed, and
+// returns true, unless `tm` is stopped first.
+func waitForMirrorRequests(
+	tm *teamMirror, doneChs []<-chan struct{}) bool {
+	for _, doneCh := range doneChs {
+		select {
+		case <-doneCh:
+		case <-tm.stopCh:
+			return false
+		}
+	}
+	return true
+}
+
+// mirrorLoop syncs `tm` right away, and then once per interval,
+// until it's stopped or the manager shuts down.
+func (am *AutogitManager) mirrorLoop(tm *teamMirror) {
+	defer am.schedulesWG.Done()
+	defer close(tm.doneCh)
+	// Clone writes into the destination with the main config.
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	ctx = libkbfs.CtxWithRandomIDReplayable(ctx, ctxIDKey, ctxOpID, am.log)
+
+	for {
+		err := am.syncMirror(ctx, tm)
+		if err != nil {
+			am.log.CDebugf(ctx, "Couldn't sync team mirror to %s: %+v",
+				tm.id(), err)
+		}
+		select {
+		case <-time.After(tm.interval):
+		case <-tm.stopCh:
+			return
+		}
+	}
+}
+
+// MirrorTeam keeps a checkout of `ref` of every repo in the team TLF
+// `srcTeam` under `dstDir` in the TLF `dstTLF`, which must already
+// exist.  Each repo is checked out into `dstDir/<repo>`, as if by
+// Clone.  The team is checked for new and deleted repos right away,
+// and then once every `interval`, at which point every existing
+// checkout is also pulled.  Checkouts of repos that have been
+// deleted from the team are deleted, so `dstDir` should only be used
+// for this mirror.  Any existing mirror into `dstDir` is replaced.
+// Mirroring continues until UnmirrorTeam is called, or the manager
+// shuts down.
+func (am *AutogitManager) MirrorTeam(
+	ctx context.Context, srcTeam *libkbfs.TlfHandle, ref string,
+	dstTLF *libkbfs.TlfHandle, dstDir string, interval time.Duration) error {
+	am.log.CDebugf(ctx, "Autogit mirror request from %s:%s to %s/%s "+
+		"every %s", srcTeam.GetCanonicalPath(), ref,
+		dstTLF.GetCanonicalPath(), dstDir, interval)
+	if srcTeam.Type() != tlf.SingleTeam {
+		return errors.New("only team TLFs can be mirrored")
+	}
+	if interval < am.minPullInterval {
+		return errors.New("mirror interval is too short")
+	}
+
+	tm := &teamMirror{
+		srcTLF:   srcTeam,
+		ref:      ref,
+		dstTLF:   dstTLF,
+		dstDir:   dstDir,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	id := tm.id()
+
+	am.schedulesLock.Lock()
+	defer am.schedulesLock.Unlock()
+	if am.mirrors == nil {
+		return errors.New("autogit manager is shut down")
+	}
+	if old, ok := am.mirrors[id]; ok {
+		close(old.stopCh)
+	}
+	am.mirrors[id] = tm
+	am.schedulesWG.Add(1)
+	go am.mirrorLoop(tm)
+	return nil
+}
+
+// UnmirrorTeam stops mirroring a team into `dstDir` in the TLF
+// `dstTLF`, if it is being mirrored there.  The existing checkouts
+// are left in place.
+func (am *AutogitManager) UnmirrorTeam(
+	ctx context.Context, dstTLF *libkbfs.TlfHandle, dstDir string) {
+	id := (&teamMirror{dstTLF: dstTLF, dstDir: dstDir}).id()
+	am.log.CDebugf(ctx, "Autogit unmirror request for %s", id)
+	tm := func() *teamMirror {
+		am.schedulesLock.Lock()
+		defer am.schedulesLock.Unlock()
+		tm, ok := am.mirrors[id]
+		if !ok {
+			return nil
+		}
+		close(tm.stopCh)
+		delete(am.mirrors, id)
+		return tm
+	}()
+	if tm != nil {
+		<-tm.doneCh
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+func TestAutogitManagerMirrorTeam(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	teamInfos := libkbfs.AddEmptyTeamsForTestOrBust(t, config, "t1")
+	libkbfs.AddTeamWriterForTestOrBust(
+		t, config, teamInfos[0].TID, session.UID)
+	teamH, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+	teamFS, err := libfs.NewFS(
+		ctx, config, teamH, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	makeRepo := func(name string) {
+		dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, teamH, name, "")
+		require.NoError(t, err)
+		err = teamFS.MkdirAll("worktree-"+name, 0600)
+		require.NoError(t, err)
+		worktreeFS, err := teamFS.Chroot("worktree-" + name)
+		require.NoError(t, err)
+		dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+		require.NoError(t, err)
+		repo, err := gogit.Init(dotgitStorage, worktreeFS)
+		require.NoError(t, err)
+		addFileToWorktreeAndCommit(
+			t, ctx, config, teamH, repo, worktreeFS, "foo", "hello "+name)
+	}
+	waitFor := func(check func() bool) {
+		rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+			ctx, h, libkbfs.MasterBranch)
+		require.NoError(t, err)
+		for {
+			// The mirror writes lock files with `config` too, so
+			// the TLF may be dirty, or its journal may still be
+			// resolving a conflict with one of the checkouts; just
+			// try again later if so.
+			err = config.KBFSOps().SyncFromServer(
+				ctx, rootNode.GetFolderBranch(), nil)
+			if err != nil {
+				t.Logf("Couldn't sync yet: %+v", err)
+			}
+			if check() {
+				return
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				t.Fatal(ctx.Err().Error())
+			}
+		}
+	}
+	exists := func(p string) bool {
+		_, err := rootFS.Stat(p)
+		if os.IsNotExist(err) {
+			return false
+		}
+		require.NoError(t, err)
+		return true
+	}
+
+	t.Log("Make two repos in the team.")
+	makeRepo("a")
+	makeRepo("b")
+
+	kbCtx := env.NewContext()
+	kbfsInitParams := libkbfs.DefaultInitParams(kbCtx)
+	am := NewAutogitManager(config, kbCtx, &kbfsInitParams, 1)
+	defer am.Shutdown()
+	nc := &newConfigger{config: config, user: "user1"}
+	defer nc.shutdown(t, ctx)
+	am.getNewConfig = func(ctx context.Context) (
+		context.Context, libkbfs.Config, string, error) {
+		ctx, gitConfig, tempDir, err := nc.getNewConfigForTest(ctx)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		// Each new config has its own local keybase daemon, which
+		// needs to know about the team too.
+		_, err = libkbfs.AddEmptyTeamsForTest(nc.newConfig, "t1")
+		if err != nil {
+			return nil, nil, "", err
+		}
+		err = libkbfs.AddTeamWriterForTest(
+			nc.newConfig, teamInfos[0].TID, session.UID)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return ctx, gitConfig, tempDir, nil
+	}
+	am.minPullInterval = 0
+
+	err = am.MirrorTeam(ctx, h, "master", h, "mirror", time.Second)
+	require.Error(t, err)
+	err = rootFS.MkdirAll("mirror", 0600)
+	require.NoError(t, err)
+	// Each MirrorTeam call syncs right away, which is all this test
+	// needs, so don't keep pulling in the background.
+	err = am.MirrorTeam(ctx, teamH, "master", h, "mirror", time.Hour)
+	require.NoError(t, err)
+
+	t.Log("Both repos get checked out.")
+	waitFor(func() bool {
+		return exists("mirror/a/foo") && exists("mirror/b/foo")
+	})
+	checkFileInRootFS(t, ctx, config, h, rootFS, "mirror/a/foo", "hello a")
+	checkFileInRootFS(t, ctx, config, h, rootFS, "mirror/b/foo", "hello b")
+
+	t.Log("A new repo shows up, and a deleted one goes away.")
+	// Stop mirroring while the new repo is made, so it's not seen
+	// before its first commit.
+	am.UnmirrorTeam(ctx, h, "mirror")
+	makeRepo("c")
+	err = DeleteRepo(ctx, config, teamH, "b")
+	require.NoError(t, err)
+	err = am.MirrorTeam(ctx, teamH, "master", h, "mirror", time.Hour)
+	require.NoError(t, err)
+	waitFor(func() bool {
+		return exists("mirror/c/foo") && !exists("mirror/b")
+	})
+	checkFileInRootFS(t, ctx, config, h, rootFS, "mirror/c/foo", "hello c")
+	require.True(t, exists("mirror/a/foo"))
+
+	t.Log("Unmirroring leaves the checkouts alone.")
+	am.UnmirrorTeam(ctx, h, "mirror")
+	err = DeleteRepo(ctx, config, teamH, "a")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	waitFor(func() bool { return true })
+	require.True(t, exists("mirror/a/foo"))
+}
@@ -161,7 +161,7 @@ func (rn *repoNode) populate(ctx context.Context) bool {
 	branch := "master"
 	if cloneNeeded {
 		doneCh, err = rn.am.Clone(
-			ctx, rn.srcRepoHandle, rn.repoName, branch, h, rn.dstDir())
+			ctx, rn.srcRepoHandle, rn.repoName, branch, h, rn.dstDir(), "")
 	} else {
 		doneCh, err = rn.am.Pull(
 			ctx, rn.srcRepoHandle, rn.repoName, branch, h, rn.dstDir())
@@ -0,0 +1,247 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/keybase/kbfs/libkbfs"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// AutogitPhase is the stage that an autogit clone or pull is in.
+type AutogitPhase int
+
+const (
+	// AutogitPhaseQueued means the request is waiting for a worker.
+	AutogitPhaseQueued AutogitPhase = iota
+	// AutogitPhasePreparing means a worker is opening the source
+	// repo and locking the destination.
+	AutogitPhasePreparing
+	// AutogitPhaseCheckingOut means files are being written into the
+	// destination.
+	AutogitPhaseCheckingOut
+	// AutogitPhaseDone means the request has finished, successfully
+	// or not.
+	AutogitPhaseDone
+)
+
+func (p AutogitPhase) String() string {
+	switch p {
+	case AutogitPhaseQueued:
+		return "queued"
+	case AutogitPhasePreparing:
+		return "preparing"
+	case AutogitPhaseCheckingOut:
+		return "checking out"
+	case AutogitPhaseDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// AutogitProgress describes how far along an autogit clone or pull
+// is.
+type AutogitProgress struct {
+	Phase AutogitPhase
+	// FilesTotal is the number of files in the branch being checked
+	// out, or 0 if it isn't known yet.  A pull only writes the files
+	// that changed, so it may finish before FilesCheckedOut reaches
+	// FilesTotal.
+	FilesTotal      int64
+	FilesCheckedOut int64
+	BytesWritten    int64
+	// Err is the result of the request, once Phase is
+	// AutogitPhaseDone.
+	Err error
+}
+
+// resetProgress tracks the progress of one reset request.  All its
+// methods are safe to call on a nil receiver, in which case they do
+// nothing.
+type resetProgress struct {
+	lock     sync.Mutex
+	progress AutogitProgress
+}
+
+func (rp *resetProgress) get() AutogitProgress {
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	return rp.progress
+}
+
+func (rp *resetProgress) setPhase(phase AutogitPhase) {
+	if rp == nil {
+		return
+	}
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	rp.progress.Phase = phase
+}
+
+func (rp *resetProgress) setFilesTotal(n int64) {
+	if rp == nil {
+		return
+	}
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	rp.progress.FilesTotal = n
+}
+
+func (rp *resetProgress) fileCheckedOut() {
+	if rp == nil {
+		return
+	}
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	rp.progress.FilesCheckedOut++
+}
+
+func (rp *resetProgress) addBytes(n int) {
+	if rp == nil {
+		return
+	}
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	rp.progress.BytesWritten += int64(n)
+}
+
+func (rp *resetProgress) finish(err error) {
+	if rp == nil {
+		return
+	}
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	rp.progress.Phase = AutogitPhaseDone
+	rp.progress.Err = err
+}
+
+// countFiles sets the total number of files in `tree`.
+func (rp *resetProgress) countFiles(
+	ctx context.Context, tree *object.Tree) error {
+	if rp == nil {
+		return nil
+	}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	var n int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		_, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if entry.Mode != filemode.Dir && entry.Mode != filemode.Submodule {
+			n++
+		}
+	}
+	rp.setFilesTotal(n)
+	return nil
+}
+
+// progressFile counts the bytes written to a checked-out file.
+type progressFile struct {
+	billy.File
+	rp *resetProgress
+}
+
+func (pf progressFile) Write(p []byte) (int, error) {
+	n, err := pf.File.Write(p)
+	pf.rp.addBytes(n)
+	return n, err
+}
+
+// progressFS wraps a worktree filesystem to count the files and
+// bytes that a checkout writes into it.
+type progressFS struct {
+	billy.Filesystem
+	rp *resetProgress
+}
+
+var _ billy.Filesystem = progressFS{}
+
+func (pfs progressFS) OpenFile(
+	filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := pfs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE == 0 {
+		return f, nil
+	}
+	pfs.rp.fileCheckedOut()
+	return progressFile{f, pfs.rp}, nil
+}
+
+func (pfs progressFS) Create(filename string) (billy.File, error) {
+	return pfs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (pfs progressFS) Symlink(target, link string) error {
+	err := pfs.Filesystem.Symlink(target, link)
+	if err != nil {
+		return err
+	}
+	pfs.rp.fileCheckedOut()
+	return nil
+}
+
+// startProgress begins tracking a reset for `id` that a worker has
+// just picked up, replacing any earlier progress for it.
+func (am *AutogitManager) startProgress(id string) *resetProgress {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	rp := &resetProgress{
+		progress: AutogitProgress{Phase: AutogitPhasePreparing},
+	}
+	am.progress[id] = rp
+	return rp
+}
+
+// queuedProgress marks `id` as queued, unless a reset for it is
+// already underway, in which case that one's progress is left in
+// place until the queued one starts.
+func (am *AutogitManager) queuedProgress(id string) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	if rp, ok := am.progress[id]; ok &&
+		rp.get().Phase != AutogitPhaseDone {
+		return
+	}
+	am.progress[id] = &resetProgress{
+		progress: AutogitProgress{Phase: AutogitPhaseQueued},
+	}
+}
+
+// PollProgress returns the progress of the latest clone or pull into
+// `dstDir/srcRepo` in the TLF `dstTLF`.  It returns false if there
+// hasn't been one since this manager started.  The progress of a
+// finished request stays available until the next request for the
+// same destination is queued.
+func (am *AutogitManager) PollProgress(
+	dstTLF *libkbfs.TlfHandle, dstDir, srcRepo string) (
+	AutogitProgress, bool) {
+	id := resetReq{srcRepo: srcRepo, dstTLF: dstTLF, dstDir: dstDir}.id()
+	am.lock.Lock()
+	rp, ok := am.progress[id]
+	am.lock.Unlock()
+	if !ok {
+		return AutogitProgress{}, false
+	}
+	return rp.get(), true
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+const (
+	defaultWorkLockRetryDelay = 1 * time.Minute
+	defaultWorkLockMaxRetries = 10
+)
+
+// errAutogitWorkLockHeld is returned by doReset when another worker
+// is already working on the destination repo, and the request should
+// be retried later.
+var errAutogitWorkLockHeld = errors.New(
+	"another worker is working on this repo")
+
+// WorkLockRetryPolicy controls what happens to a clone or pull
+// request when another worker (possibly on another device) is
+// already working on the same destination repo.
+type WorkLockRetryPolicy struct {
+	// Delay is how long to wait before requeueing the request.
+	Delay time.Duration
+	// MaxRetries is how many times to requeue the request before
+	// giving up on it.  If zero, the request is dropped right away.
+	MaxRetries int
+}
+
+// DefaultWorkLockRetryPolicy returns the retry policy used by new
+// autogit managers.
+func DefaultWorkLockRetryPolicy() WorkLockRetryPolicy {
+	return WorkLockRetryPolicy{
+		Delay:      defaultWorkLockRetryDelay,
+		MaxRetries: defaultWorkLockMaxRetries,
+	}
+}
+
+// SetWorkLockRetryPolicy changes the retry policy for requests that
+// find another worker in charge of their destination repo.  It
+// applies to requests that haven't been retried yet.
+func (am *AutogitManager) SetWorkLockRetryPolicy(policy WorkLockRetryPolicy) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	am.retryPolicy = policy
+}
+
+func (am *AutogitManager) getWorkLockRetryPolicy() WorkLockRetryPolicy {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	return am.retryPolicy
+}
+
+// retryLater requeues `req` after the policy's delay, and returns
+// true, unless the manager is shutting down.  The caller must not
+// close `req.doneCh` if this returns true; it will be closed when
+// the retried request finishes.
+func (am *AutogitManager) retryLater(
+	ctx context.Context, req resetReq) bool {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	if am.shutdown {
+		return false
+	}
+	req.retries++
+	am.log.CDebugf(ctx, "Retrying reset of %s in %s (%d of %d)",
+		req.id(), am.retryPolicy.Delay, req.retries,
+		am.retryPolicy.MaxRetries)
+	am.pendingRetries++
+	am.retriesWG.Add(1)
+	go am.retryReset(req, am.retryPolicy.Delay)
+	return true
+}
+
+func (am *AutogitManager) retryReset(req resetReq, delay time.Duration) {
+	defer am.retriesWG.Done()
+	ctx := libkbfs.CtxWithRandomIDReplayable(
+		context.Background(), ctxIDKey, ctxOpID, am.log)
+
+	finish := func(err error) {
+		if req.errCh != nil {
+			req.errCh <- err
+		}
+		close(req.doneCh)
+	}
+	doneWaiting := func() {
+		am.lock.Lock()
+		defer am.lock.Unlock()
+		am.pendingRetries--
+	}
+	select {
+	case <-time.After(delay):
+	case <-am.shutdownCh:
+		doneWaiting()
+		finish(errAutogitWorkLockHeld)
+		return
+	}
+
+	// Only stop counting this as a pending retry once it's queued, so
+	// that it's never missing from a status snapshot.
+	doneCh, err := am.queueReset(ctx, req)
+	doneWaiting()
+	if err != nil {
+		finish(err)
+		return
+	}
+	if doneCh == req.doneCh {
+		// A worker will close it.
+		return
+	}
+
+	// Another request for the same repo was already queued, and will
+	// do the work for us.
+	select {
+	case <-doneCh:
+		finish(nil)
+	case <-am.shutdownCh:
+		finish(errAutogitWorkLockHeld)
+	}
+}
+
+// stopRetries cancels any pending retries, and waits for them to
+// stop.  No more retries can be started afterward.
+func (am *AutogitManager) stopRetries() {
+	func() {
+		am.lock.Lock()
+		defer am.lock.Unlock()
+		am.shutdown = true
+		close(am.shutdownCh)
+	}()
+	am.retriesWG.Wait()
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+func TestAutogitManagerWorkLockRetry(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	t.Log("Init a new repo directly into KBFS.")
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
+
+	kbCtx := env.NewContext()
+	kbfsInitParams := libkbfs.DefaultInitParams(kbCtx)
+	am := NewAutogitManager(config, kbCtx, &kbfsInitParams, 1)
+	defer am.Shutdown()
+	nc := &newConfigger{config: config, user: "user1"}
+	defer nc.shutdown(t, ctx)
+	var attempts int32
+	am.getNewConfig = func(ctx context.Context) (
+		context.Context, libkbfs.Config, string, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nc.getNewConfigForTest(ctx)
+	}
+
+	err = rootFS.MkdirAll("checkout", 0600)
+	require.NoError(t, err)
+	doneCh, err := am.Clone(ctx, h, "test", "master", h, "checkout", "")
+	require.NoError(t, err)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err().Error())
+	}
+	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo", "hello")
+
+	t.Log("Pretend another worker is working on the repo.")
+	workingName := "checkout/" + autogitWorkingName("test")
+	f, err := rootFS.Create(workingName)
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+	commitWorktree(t, ctx, config, h, rootFS)
+
+	t.Log("A pull gives up after running out of retries.")
+	am.SetWorkLockRetryPolicy(WorkLockRetryPolicy{
+		Delay:      10 * time.Millisecond,
+		MaxRetries: 1,
+	})
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo2", "hello2")
+	doneCh, err = am.Pull(ctx, h, "test", "master", h, "checkout")
+	require.NoError(t, err)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err().Error())
+	}
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	err = config.KBFSOps().SyncFromServer(
+		ctx, rootNode.GetFolderBranch(), nil)
+	require.NoError(t, err)
+	lastErrFile, err := rootFS.Open("checkout/" + autogitLastErrName("test"))
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(lastErrFile)
+	require.NoError(t, err)
+	lastErrFile.Close()
+	require.True(t, strings.Contains(
+		string(data), "gave up after 1 retries"), string(data))
+	_, err = rootFS.Stat("checkout/test/foo2")
+	require.True(t, os.IsNotExist(err))
+	status := am.Status(ctx)
+	require.Len(t, status.Queued, 0)
+	require.Len(t, status.InProgress, 0)
+	require.Len(t, status.Failures, 1)
+	require.Equal(t, h.GetCanonicalPath()+"/checkout/test",
+		status.Failures[0].Dst)
+	require.True(t, strings.Contains(
+		status.Failures[0].Err, errAutogitWorkLockHeld.Error()),
+		status.Failures[0].Err)
+	require.Equal(t, 1, status.NumWorkers)
+	require.Equal(t, 0, status.BusyWorkers)
+
+	t.Log("A pull succeeds once the other worker is done.")
+	am.SetWorkLockRetryPolicy(WorkLockRetryPolicy{
+		Delay:      10 * time.Millisecond,
+		MaxRetries: 1000,
+	})
+	start := atomic.LoadInt32(&attempts)
+	doneCh, err = am.Pull(ctx, h, "test", "master", h, "checkout")
+	require.NoError(t, err)
+	for atomic.LoadInt32(&attempts) < start+2 {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal(ctx.Err().Error())
+		}
+	}
+	status = am.Status(ctx)
+	require.True(t, 1 <=
+		len(status.Queued)+len(status.InProgress)+status.PendingRetries)
+	err = rootFS.Remove(workingName)
+	require.NoError(t, err)
+	commitWorktree(t, ctx, config, h, rootFS)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err().Error())
+	}
+	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo2", "hello2")
+	_, err = rootFS.Stat("checkout/" + autogitLastErrName("test"))
+	require.True(t, os.IsNotExist(err))
+	status = am.Status(ctx)
+	require.Len(t, status.Failures, 0)
+}
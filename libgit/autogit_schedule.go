@@ -0,0 +1,203 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+const (
+	// minPullInterval is the shortest interval allowed between
+	// scheduled pulls, to avoid hammering the servers.
+	minPullInterval = 1 * time.Minute
+	// defaultMaxPullBackoffFactor limits how far a repo's pulls back
+	// off after failures, as a multiple of its interval, if the
+	// schedule doesn't set its own limit.
+	defaultMaxPullBackoffFactor = 16
+)
+
+// PullSchedule says how often a scheduled autogit pull runs.
+type PullSchedule struct {
+	// Interval is how long to wait between pulls.
+	Interval time.Duration
+	// Jitter is the most time that may be randomly added to each
+	// interval, so that many scheduled repos don't all pull at once.
+	Jitter time.Duration
+	// MaxBackoff limits the interval after failed pulls, which
+	// doubles with each consecutive failure.  If zero, it's
+	// `defaultMaxPullBackoffFactor` times the interval.
+	MaxBackoff time.Duration
+}
+
+// nextDelay returns how long to wait before the next pull, given the
+// number of consecutive failures so far.  `randInt63n` is used for
+// the jitter.
+func (s PullSchedule) nextDelay(
+	failures int, randInt63n func(int64) int64) time.Duration {
+	maxBackoff := s.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxPullBackoffFactor * s.Interval
+	}
+	delay := s.Interval
+	for i := 0; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if failures > 0 && delay > maxBackoff {
+		delay = maxBackoff
+	}
+	if s.Jitter > 0 {
+		delay += time.Duration(randInt63n(int64(s.Jitter)))
+	}
+	return delay
+}
+
+// scheduledPull is a repo that's pulled on a schedule.
+type scheduledPull struct {
+	req      resetReq
+	schedule PullSchedule
+	stopCh   chan struct{}
+	doneCh   chan struct{} // closed when pullOnSchedule returns
+}
+
+// pullOnSchedule pulls `sp` repeatedly until it's unscheduled or the
+// manager shuts down.
+func (am *AutogitManager) pullOnSchedule(sp *scheduledPull) {
+	defer am.schedulesWG.Done()
+	defer close(sp.doneCh)
+	ctx := libkbfs.CtxWithRandomIDReplayable(
+		context.Background(), ctxIDKey, ctxOpID, am.log)
+	failures := 0
+	timer := time.NewTimer(sp.schedule.nextDelay(0, rand.Int63n))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-sp.stopCh:
+			return
+		}
+
+		req := sp.req
+		req.doneCh = make(chan struct{})
+		req.errCh = make(chan error, 1)
+		doneCh, err := am.queueReset(ctx, req)
+		if err == nil {
+			select {
+			case <-doneCh:
+			case <-sp.stopCh:
+				return
+			}
+			select {
+			case err = <-req.errCh:
+			default:
+				// Our request was rolled up into one that was
+				// already queued, whose result we don't get.
+			}
+		}
+		if err != nil {
+			failures++
+			am.log.CDebugf(ctx, "Scheduled pull of %s failed "+
+				"(%d in a row): %+v", req.id(), failures, err)
+		} else {
+			failures = 0
+		}
+		timer.Reset(sp.schedule.nextDelay(failures, rand.Int63n))
+	}
+}
+
+// SchedulePull registers `ref` of the `srcRepo` repo from the TLF
+// `srcTLF` to be pulled according to `schedule`, into a subdirectory
+// named `dstDir/srcRepo` in the TLF `dstTLF`, which must already
+// exist (see Clone).  The first pull happens one
+// interval from now.  Any existing schedule for the same destination
+// is replaced.  Pulls continue until UnschedulePull is called, or the
+// manager shuts down.
+func (am *AutogitManager) SchedulePull(
+	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo, ref string,
+	dstTLF *libkbfs.TlfHandle, dstDir string, schedule PullSchedule) error {
+	am.log.CDebugf(ctx, "Autogit schedule request from %s/%s:%s to %s/%s "+
+		"every %s", srcTLF.GetCanonicalPath(), srcRepo, ref,
+		dstTLF.GetCanonicalPath(), dstDir, schedule.Interval)
+	if schedule.Interval < am.minPullInterval {
+		return errors.New("pull interval is too short")
+	}
+	if schedule.Jitter < 0 || schedule.MaxBackoff < 0 {
+		return errors.New("pull jitter and max backoff can't be negative")
+	}
+
+	sp := &scheduledPull{
+		req: resetReq{
+			srcTLF:  srcTLF,
+			srcRepo: srcRepo,
+			ref:     ref,
+			dstTLF:  dstTLF,
+			dstDir:  dstDir,
+		},
+		schedule: schedule,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	id := sp.req.id()
+
+	am.schedulesLock.Lock()
+	defer am.schedulesLock.Unlock()
+	if am.schedules == nil {
+		return errors.New("autogit manager is shut down")
+	}
+	if old, ok := am.schedules[id]; ok {
+		close(old.stopCh)
+	}
+	am.schedules[id] = sp
+	am.schedulesWG.Add(1)
+	go am.pullOnSchedule(sp)
+	return nil
+}
+
+// UnschedulePull stops the scheduled pulls into `dstDir/srcRepo` in
+// the TLF `dstTLF`, if there are any.  No more pulls are queued for
+// it once this returns, but one that's already queued or in progress
+// isn't canceled.
+func (am *AutogitManager) UnschedulePull(
+	ctx context.Context, dstTLF *libkbfs.TlfHandle, dstDir, srcRepo string) {
+	id := resetReq{srcRepo: srcRepo, dstTLF: dstTLF, dstDir: dstDir}.id()
+	am.log.CDebugf(ctx, "Autogit unschedule request for %s", id)
+	sp := func() *scheduledPull {
+		am.schedulesLock.Lock()
+		defer am.schedulesLock.Unlock()
+		sp, ok := am.schedules[id]
+		if !ok {
+			return nil
+		}
+		close(sp.stopCh)
+		delete(am.schedules, id)
+		return sp
+	}()
+	if sp != nil {
+		<-sp.doneCh
+	}
+}
+
+// stopSchedules stops all scheduled pulls and team mirrors, and waits
+// for their goroutines to exit.  No more pulls or mirrors can be
+// scheduled afterward.
+func (am *AutogitManager) stopSchedules() {
+	func() {
+		am.schedulesLock.Lock()
+		defer am.schedulesLock.Unlock()
+		for _, sp := range am.schedules {
+			close(sp.stopCh)
+		}
+		am.schedules = nil
+		for _, tm := range am.mirrors {
+			close(tm.stopCh)
+		}
+		am.mirrors = nil
+	}()
+	am.schedulesWG.Wait()
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+func TestPullScheduleNextDelay(t *testing.T) {
+	noJitter := func(int64) int64 { return 0 }
+	s := PullSchedule{Interval: time.Minute}
+	require.Equal(t, time.Minute, s.nextDelay(0, noJitter))
+	require.Equal(t, 2*time.Minute, s.nextDelay(1, noJitter))
+	require.Equal(t, 8*time.Minute, s.nextDelay(3, noJitter))
+	require.Equal(t, 16*time.Minute, s.nextDelay(100, noJitter))
+
+	s.MaxBackoff = 3 * time.Minute
+	require.Equal(t, 2*time.Minute, s.nextDelay(1, noJitter))
+	require.Equal(t, 3*time.Minute, s.nextDelay(2, noJitter))
+
+	s.Jitter = 10 * time.Second
+	maxJitter := func(n int64) int64 { return n - 1 }
+	require.Equal(t, time.Minute+10*time.Second-1, s.nextDelay(0, maxJitter))
+}
+
+func TestAutogitManagerSchedulePull(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	t.Log("Init a new repo directly into KBFS.")
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
+
+	kbCtx := env.NewContext()
+	kbfsInitParams := libkbfs.DefaultInitParams(kbCtx)
+	am := NewAutogitManager(config, kbCtx, &kbfsInitParams, 1)
+	defer am.Shutdown()
+	nc := &newConfigger{config: config, user: "user1"}
+	defer nc.shutdown(t, ctx)
+	am.getNewConfig = nc.getNewConfigForTest
+
+	err = rootFS.MkdirAll("checkout", 0600)
+	require.NoError(t, err)
+	doneCh, err := am.Clone(ctx, h, "test", "master", h, "checkout", "")
+	require.NoError(t, err)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err().Error())
+	}
+	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo", "hello")
+
+	err = am.SchedulePull(
+		ctx, h, "test", "master", h, "checkout",
+		PullSchedule{Interval: 10 * time.Millisecond})
+	require.Error(t, err)
+	am.minPullInterval = 0
+	err = am.SchedulePull(
+		ctx, h, "test", "master", h, "checkout",
+		PullSchedule{Interval: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	t.Log("Add a new file and wait for a scheduled pull to get it.")
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo2", "hello2")
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	for {
+		err = config.KBFSOps().SyncFromServer(
+			ctx, rootNode.GetFolderBranch(), nil)
+		require.NoError(t, err)
+		f, err := rootFS.Open("checkout/test/foo2")
+		if err == nil {
+			data, err := ioutil.ReadAll(f)
+			require.NoError(t, err)
+			f.Close()
+			require.Equal(t, "hello2", string(data))
+			break
+		}
+		require.True(t, os.IsNotExist(err))
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal(ctx.Err().Error())
+		}
+	}
+
+	t.Log("Unschedule, and let any in-flight pull finish.")
+	am.UnschedulePull(ctx, h, "checkout", "test")
+	err = am.resetsWG.Wait(ctx)
+	require.NoError(t, err)
+	err = config.KBFSOps().SyncFromServer(
+		ctx, rootNode.GetFolderBranch(), nil)
+	require.NoError(t, err)
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// AutogitRequestStatus describes one queued or in-progress clone or
+// pull.
+type AutogitRequestStatus struct {
+	SrcTLF  string
+	SrcRepo string
+	Ref     string
+	DstTLF  string
+	DstDir  string
+	// Retries is how many times the request has been requeued
+	// because another worker was working on the same repo.
+	Retries  int
+	Progress AutogitProgress
+}
+
+// AutogitFailure is the most recent failed clone or pull into a
+// destination repo, if it hasn't succeeded since.
+type AutogitFailure struct {
+	// Dst is the full path of the destination repo.
+	Dst  string
+	Err  string
+	Time time.Time
+}
+
+// AutogitStatus is a snapshot of the work an AutogitManager is doing.
+type AutogitStatus struct {
+	Queued     []AutogitRequestStatus
+	InProgress []AutogitRequestStatus
+	// Failures only covers requests processed by this manager since
+	// it started; see the .lasterr files in each destination for
+	// failures from other workers.
+	Failures []AutogitFailure
+	// PendingRetries is the number of requests waiting to be requeued
+	// because another worker was working on their repos.
+	PendingRetries int
+	NumWorkers     int
+	BusyWorkers    int
+	ScheduledPulls int
+}
+
+// autogitLastErr is the error from the last reset of a destination
+// repo.
+type autogitLastErr struct {
+	err  string
+	time time.Time
+}
+
+// recordResult remembers the result of a reset for Status.
+func (am *AutogitManager) recordResult(req resetReq, err error) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	if err == nil {
+		delete(am.lastErrs, req.id())
+		return
+	}
+	am.lastErrs[req.id()] = autogitLastErr{
+		err:  err.Error(),
+		time: am.config.Clock().Now(),
+	}
+}
+
+func (am *AutogitManager) setWorkerBusy(busy bool) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	if busy {
+		am.busyWorkers++
+	} else {
+		am.busyWorkers--
+	}
+}
+
+func (am *AutogitManager) requestStatusLocked(
+	req resetReq) AutogitRequestStatus {
+	s := AutogitRequestStatus{
+		SrcTLF:  req.srcTLF.GetCanonicalPath(),
+		SrcRepo: req.srcRepo,
+		Ref:     req.ref,
+		DstTLF:  req.dstTLF.GetCanonicalPath(),
+		DstDir:  req.dstDir,
+		Retries: req.retries,
+	}
+	if rp, ok := am.progress[req.id()]; ok {
+		s.Progress = rp.get()
+	}
+	return s
+}
+
+func sortRequestStatuses(statuses []AutogitRequestStatus) {
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].DstTLF != statuses[j].DstTLF {
+			return statuses[i].DstTLF < statuses[j].DstTLF
+		}
+		if statuses[i].DstDir != statuses[j].DstDir {
+			return statuses[i].DstDir < statuses[j].DstDir
+		}
+		return statuses[i].SrcRepo < statuses[j].SrcRepo
+	})
+}
+
+// Status returns a snapshot of the clones and pulls that this
+// manager has queued, is working on, or has recently failed, so that
+// users can see why an autogit checkout might be out of date.
+func (am *AutogitManager) Status(ctx context.Context) AutogitStatus {
+	var status AutogitStatus
+	func() {
+		am.lock.Lock()
+		defer am.lock.Unlock()
+		for _, req := range am.resetsInQueue {
+			status.Queued = append(
+				status.Queued, am.requestStatusLocked(req))
+		}
+		for _, req := range am.resetsInProgress {
+			status.InProgress = append(
+				status.InProgress, am.requestStatusLocked(req))
+		}
+		for id, lastErr := range am.lastErrs {
+			status.Failures = append(status.Failures, AutogitFailure{
+				Dst:  id,
+				Err:  lastErr.err,
+				Time: lastErr.time,
+			})
+		}
+		status.PendingRetries = am.pendingRetries
+		status.NumWorkers = am.numWorkers
+		status.BusyWorkers = am.busyWorkers
+	}()
+	func() {
+		am.schedulesLock.Lock()
+		defer am.schedulesLock.Unlock()
+		status.ScheduledPulls = len(am.schedules)
+	}()
+
+	sortRequestStatuses(status.Queued)
+	sortRequestStatuses(status.InProgress)
+	sort.Slice(status.Failures, func(i, j int) bool {
+		return status.Failures[i].Dst < status.Failures[j].Dst
+	})
+	am.log.CDebugf(ctx, "Autogit status: %d queued, %d in progress, "+
+		"%d failed, %d/%d workers busy", len(status.Queued),
+		len(status.InProgress), len(status.Failures), status.BusyWorkers,
+		status.NumWorkers)
+	return status
+}
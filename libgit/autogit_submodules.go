@@ -0,0 +1,205 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+const (
+	kbfsRepoURLPrefix = "keybase://"
+
+	// maxSubmoduleDepth limits how deeply autogit follows
+	// submodules of submodules.
+	maxSubmoduleDepth = 4
+)
+
+// kbfsSubmodule is a submodule of a repo that is itself a repo
+// hosted in KBFS.
+type kbfsSubmodule struct {
+	// path is relative to the top of the parent repo's worktree.
+	path    string
+	tlfType tlf.Type
+	tlfName string
+	repo    string
+	commit  plumbing.Hash
+}
+
+// parseKBFSRepoURL splits a URL of the form
+// "keybase://<tlf type>/<tlf name>/<repo>" into its parts.  It
+// returns false if `url` doesn't refer to a KBFS repo.
+func parseKBFSRepoURL(url string) (
+	tlfType tlf.Type, tlfName, repo string, ok bool) {
+	if !strings.HasPrefix(url, kbfsRepoURLPrefix) {
+		return tlf.Unknown, "", "", false
+	}
+	parts := strings.Split(
+		strings.TrimSuffix(strings.TrimPrefix(url, kbfsRepoURLPrefix), "/"),
+		"/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return tlf.Unknown, "", "", false
+	}
+	switch parts[0] {
+	case public:
+		tlfType = tlf.Public
+	case private:
+		tlfType = tlf.Private
+	case team:
+		tlfType = tlf.SingleTeam
+	default:
+		return tlf.Unknown, "", "", false
+	}
+	return tlfType, parts[1], parts[2], true
+}
+
+// getKBFSSubmodules returns the submodules hosted in KBFS that are
+// used by the commit `target` refers to in the repo in `repoFS`.
+// Only those under `pathFilter` are returned, if it's non-empty.
+func getKBFSSubmodules(
+	repoFS billy.Filesystem, target, pathFilter string) (
+	subs []kbfsSubmodule, err error) {
+	repoStorer, err := filesystem.NewStorage(repoFS)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := NewOnDemandStorer(repoStorer)
+	if err != nil {
+		return nil, err
+	}
+	h, err := resolveCommit(storage, target)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := object.GetCommit(storage, h)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := tree.File(".gitmodules")
+	if err == object.ErrFileNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	modules := config.NewModules()
+	err = modules.Unmarshal([]byte(contents))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range modules.Submodules {
+		tlfType, tlfName, repo, ok := parseKBFSRepoURL(m.URL)
+		if !ok {
+			continue
+		}
+		p := path.Clean(m.Path)
+		if pathFilter != "" && !strings.HasPrefix(p, pathFilter+"/") {
+			continue
+		}
+		entry, err := tree.FindEntry(p)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode != filemode.Submodule {
+			return nil, fmt.Errorf("submodule path %s isn't a submodule", p)
+		}
+		subs = append(subs, kbfsSubmodule{
+			path:    p,
+			tlfType: tlfType,
+			tlfName: tlfName,
+			repo:    repo,
+			commit:  entry.Hash,
+		})
+	}
+	return subs, nil
+}
+
+// resetSubmodules checks out, under `dstRepoFS`, the submodules that
+// are hosted in KBFS and used by the commit `target` refers to in
+// the repo in `srcRepoFS`.  It recurses into their submodules too,
+// down to `maxSubmoduleDepth`.  Submodules hosted elsewhere are left
+// as empty directories.
+func (am *AutogitManager) resetSubmodules(
+	ctx context.Context, gitConfig libkbfs.Config, uniqID string,
+	srcRepoFS, dstRepoFS billy.Filesystem, target string, depth int) error {
+	if depth >= maxSubmoduleDepth {
+		am.log.CDebugf(ctx, "Not following submodules past depth %d", depth)
+		return nil
+	}
+	pathFilter, err := readPathFilter(dstRepoFS)
+	if err != nil {
+		return err
+	}
+	subs, err := getKBFSSubmodules(srcRepoFS, target, pathFilter)
+	if err != nil {
+		return err
+	}
+
+	// Keep going after a failed submodule, so one bad URL doesn't
+	// hold up the rest, but report the first error.
+	var firstErr error
+	for _, s := range subs {
+		err := am.resetSubmodule(ctx, gitConfig, uniqID, dstRepoFS, s, depth)
+		if err != nil {
+			am.log.CDebugf(ctx, "Couldn't check out submodule %s: %+v",
+				s.path, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (am *AutogitManager) resetSubmodule(
+	ctx context.Context, gitConfig libkbfs.Config, uniqID string,
+	dstRepoFS billy.Filesystem, s kbfsSubmodule, depth int) error {
+	am.log.CDebugf(ctx, "Checking out submodule %s from %s/%s/%s at %s",
+		s.path, s.tlfType, s.tlfName, s.repo, s.commit)
+	h, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, gitConfig.KBPKI(), gitConfig.MDOps(), s.tlfName, s.tlfType)
+	if err != nil {
+		return err
+	}
+	subRepoFS, _, err := GetRepoAndID(ctx, gitConfig, h, s.repo, uniqID)
+	if err != nil {
+		return err
+	}
+	err = dstRepoFS.MkdirAll(s.path, 0600)
+	if err != nil {
+		return err
+	}
+	subDstFS, err := dstRepoFS.Chroot(s.path)
+	if err != nil {
+		return err
+	}
+	target := s.commit.String()
+	err = resetWithProgress(ctx, subRepoFS, subDstFS, target, nil, nil)
+	if err != nil {
+		return err
+	}
+	return am.resetSubmodules(
+		ctx, gitConfig, uniqID, subRepoFS, subDstFS, target, depth+1)
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+)
+
+func TestParseKBFSRepoURL(t *testing.T) {
+	tlfType, tlfName, repo, ok := parseKBFSRepoURL(
+		"keybase://team/acme.eng/widgets")
+	require.True(t, ok)
+	require.Equal(t, tlf.SingleTeam, tlfType)
+	require.Equal(t, "acme.eng", tlfName)
+	require.Equal(t, "widgets", repo)
+
+	for _, url := range []string{
+		"https://github.com/keybase/kbfs",
+		"keybase://private/user1",
+		"keybase://shared/user1/repo",
+		"keybase://private/user1/repo/extra",
+	} {
+		_, _, _, ok := parseKBFSRepoURL(url)
+		require.False(t, ok, url)
+	}
+}
+
+// setSubmoduleCommit points the submodule at `path` in `repo`'s index
+// to `commit`, so the next commit records it.
+func setSubmoduleCommit(
+	t *testing.T, repo *gogit.Repository, path string, commit plumbing.Hash) {
+	idx, err := repo.Storer.Index()
+	require.NoError(t, err)
+	e, err := idx.Entry(path)
+	if err == index.ErrEntryNotFound {
+		e = &index.Entry{Name: path, Mode: filemode.Submodule}
+		idx.Entries = append(idx.Entries, e)
+	} else {
+		require.NoError(t, err)
+	}
+	e.Hash = commit
+	err = repo.Storer.SetIndex(idx)
+	require.NoError(t, err)
+}
+
+func TestAutogitManagerSubmodules(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	t.Log("Make a repo to be used as a submodule.")
+	subDotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "sub", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("subworktree", 0600)
+	require.NoError(t, err)
+	subWorktreeFS, err := rootFS.Chroot("subworktree")
+	require.NoError(t, err)
+	subStorage, err := NewGitConfigWithoutRemotesStorer(subDotgitFS)
+	require.NoError(t, err)
+	subRepo, err := gogit.Init(subStorage, subWorktreeFS)
+	require.NoError(t, err)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, subRepo, subWorktreeFS, "subfoo", "subhello")
+	subHead, err := subRepo.Head()
+	require.NoError(t, err)
+
+	t.Log("Make a repo that uses it, plus one from outside KBFS.")
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	setSubmoduleCommit(t, repo, "lib", subHead.Hash())
+	setSubmoduleCommit(t, repo, "ext", subHead.Hash())
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, ".gitmodules",
+		"[submodule \"lib\"]\n"+
+			"\tpath = lib\n"+
+			"\turl = keybase://private/user1/sub\n"+
+			"[submodule \"ext\"]\n"+
+			"\tpath = ext\n"+
+			"\turl = https://github.com/keybase/kbfs\n")
+
+	kbCtx := env.NewContext()
+	kbfsInitParams := libkbfs.DefaultInitParams(kbCtx)
+	kbfsInitParams.AutogitSubmodules = true
+	am := NewAutogitManager(config, kbCtx, &kbfsInitParams, 1)
+	defer am.Shutdown()
+	nc := &newConfigger{config: config, user: "user1"}
+	defer nc.shutdown(t, ctx)
+	am.getNewConfig = nc.getNewConfigForTest
+
+	err = rootFS.MkdirAll("checkout", 0600)
+	require.NoError(t, err)
+	doneCh, err := am.Clone(ctx, h, "test", "master", h, "checkout", "")
+	require.NoError(t, err)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err().Error())
+	}
+	checkFileInRootFS(
+		t, ctx, config, h, rootFS, "checkout/test/lib/subfoo", "subhello")
+	fis, err := rootFS.ReadDir("checkout/test/ext")
+	require.NoError(t, err)
+	require.Len(t, fis, 0)
+
+	t.Log("Update the submodule and pull.")
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, subRepo, subWorktreeFS, "subfoo2", "subhello2")
+	subHead, err = subRepo.Head()
+	require.NoError(t, err)
+	setSubmoduleCommit(t, repo, "lib", subHead.Hash())
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
+	doneCh, err = am.Pull(ctx, h, "test", "master", h, "checkout")
+	require.NoError(t, err)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err().Error())
+	}
+	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo", "hello")
+	checkFileInRootFS(
+		t, ctx, config, h, rootFS, "checkout/test/lib/subfoo2", "subhello2")
+}
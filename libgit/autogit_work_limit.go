@@ -0,0 +1,108 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// defaultWorkTimeLimit is how long a worker's claim on a destination
+// repo lasts, by default, before other workers may take it over.
+const defaultWorkTimeLimit = 1 * time.Hour
+
+// AutogitPullOptions changes how a single pull request deals with
+// other workers that might be working on the same destination repo.
+type AutogitPullOptions struct {
+	// WorkTimeLimit, if non-zero, overrides the manager's work time
+	// limit (see SetWorkTimeLimit) for this request.
+	WorkTimeLimit time.Duration
+	// ForceTakeover breaks any other worker's claim on the
+	// destination repo right away, regardless of how old it is, as
+	// long as no writes to the destination TLF are still waiting to
+	// be flushed from this device's journal.  Workers on other
+	// devices can't be checked, but any updates they have already
+	// flushed are seen before the claim is broken.
+	ForceTakeover bool
+}
+
+// SetWorkTimeLimit changes how long another worker's claim on a
+// destination repo is honored before this manager takes it over.  It
+// applies to requests that haven't started yet.
+func (am *AutogitManager) SetWorkTimeLimit(limit time.Duration) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	am.workTimeLimit = limit
+}
+
+func (am *AutogitManager) getWorkTimeLimit() time.Duration {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	return am.workTimeLimit
+}
+
+// workTimeLimitFor returns the work time limit that applies to `req`.
+func (am *AutogitManager) workTimeLimitFor(req resetReq) time.Duration {
+	if req.opts.WorkTimeLimit != 0 {
+		return req.opts.WorkTimeLimit
+	}
+	return am.getWorkTimeLimit()
+}
+
+// journalFlushed returns true if this device's journal has no
+// unflushed writes for the TLF of `dstFS`, so that breaking another
+// worker's claim can't race with their updates reaching the server.
+// It must be called while holding the repo's lock file, which has
+// already synced `dstFS` from the server.
+func (am *AutogitManager) journalFlushed(
+	ctx context.Context, dstFS *libfs.FS) (bool, error) {
+	jServer, err := libkbfs.GetJournalServer(am.config)
+	if err != nil {
+		// No journal, so nothing can be waiting in it.
+		return true, nil
+	}
+	tlfID := dstFS.RootNode().GetFolderBranch().Tlf
+	if !libkbfs.TLFJournalEnabled(am.config, tlfID) {
+		return true, nil
+	}
+	status, err := jServer.JournalStatus(tlfID)
+	if err != nil {
+		return false, err
+	}
+	if status.RevisionStart != kbfsmd.RevisionUninitialized ||
+		status.UnflushedBytes != 0 {
+		am.log.CDebugf(ctx, "Journal for %s still has unflushed revisions "+
+			"%s-%s (%d bytes)", tlfID, status.RevisionStart,
+			status.RevisionEnd, status.UnflushedBytes)
+		return false, nil
+	}
+	return true, nil
+}
+
+// PullWithOptions is like Pull, but lets the caller override how
+// other workers' claims on the destination repo are handled.
+func (am *AutogitManager) PullWithOptions(
+	ctx context.Context, srcTLF *libkbfs.TlfHandle, srcRepo, ref string,
+	dstTLF *libkbfs.TlfHandle, dstDir string, opts AutogitPullOptions) (
+	doneCh <-chan struct{}, err error) {
+	am.log.CDebugf(ctx, "Autogit pull request from %s/%s:%s to %s/%s "+
+		"(workTimeLimit=%s, forceTakeover=%t)",
+		srcTLF.GetCanonicalPath(), srcRepo, ref,
+		dstTLF.GetCanonicalPath(), dstDir, opts.WorkTimeLimit,
+		opts.ForceTakeover)
+	defer func() {
+		am.deferLog.CDebugf(ctx, "Pull request processed: %+v", err)
+	}()
+
+	req := resetReq{
+		srcTLF, srcRepo, ref, dstTLF, dstDir, nil, make(chan struct{}), nil,
+		0, opts,
+	}
+	return am.queueReset(ctx, req)
+}
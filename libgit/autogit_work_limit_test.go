@@ -0,0 +1,131 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+func TestAutogitManagerWorkTimeLimitAndTakeover(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	t.Log("Init a new repo directly into KBFS.")
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
+
+	kbCtx := env.NewContext()
+	kbfsInitParams := libkbfs.DefaultInitParams(kbCtx)
+	am := NewAutogitManager(config, kbCtx, &kbfsInitParams, 1)
+	defer am.Shutdown()
+	nc := &newConfigger{config: config, user: "user1"}
+	defer nc.shutdown(t, ctx)
+	am.getNewConfig = nc.getNewConfigForTest
+	am.SetWorkLockRetryPolicy(WorkLockRetryPolicy{})
+
+	err = rootFS.MkdirAll("checkout", 0600)
+	require.NoError(t, err)
+	doneCh, err := am.Clone(ctx, h, "test", "master", h, "checkout", "")
+	require.NoError(t, err)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err().Error())
+	}
+	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo", "hello")
+
+	pull := func(opts AutogitPullOptions) {
+		doneCh, err := am.PullWithOptions(
+			ctx, h, "test", "master", h, "checkout", opts)
+		require.NoError(t, err)
+		select {
+		case <-doneCh:
+		case <-ctx.Done():
+			t.Fatal(ctx.Err().Error())
+		}
+	}
+	claim := func(age time.Duration) {
+		workingName := "checkout/" + autogitWorkingName("test")
+		f, err := rootFS.Create(workingName)
+		require.NoError(t, err)
+		err = f.Close()
+		require.NoError(t, err)
+		err = rootFS.Chtimes(
+			workingName, time.Time{}, am.commonTime(ctx).Add(-age))
+		require.NoError(t, err)
+		commitWorktree(t, ctx, config, h, rootFS)
+	}
+	checkNotPulled := func(name string) {
+		rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+			ctx, h, libkbfs.MasterBranch)
+		require.NoError(t, err)
+		err = config.KBFSOps().SyncFromServer(
+			ctx, rootNode.GetFolderBranch(), nil)
+		require.NoError(t, err)
+		_, err = rootFS.Stat("checkout/test/" + name)
+		require.True(t, os.IsNotExist(err))
+	}
+
+	t.Log("A 10-minute-old claim blocks pulls under the default limit.")
+	claim(10 * time.Minute)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo2", "hello2")
+	pull(AutogitPullOptions{})
+	checkNotPulled("foo2")
+
+	t.Log("But not under a shorter per-request limit.")
+	pull(AutogitPullOptions{WorkTimeLimit: 5 * time.Minute})
+	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo2", "hello2")
+
+	t.Log("Or a shorter manager-wide limit.")
+	claim(10 * time.Minute)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo3", "hello3")
+	pull(AutogitPullOptions{WorkTimeLimit: time.Hour})
+	checkNotPulled("foo3")
+	am.SetWorkTimeLimit(5 * time.Minute)
+	pull(AutogitPullOptions{})
+	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo3", "hello3")
+
+	t.Log("A brand new claim can be forcibly taken over.")
+	claim(0)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo4", "hello4")
+	pull(AutogitPullOptions{})
+	checkNotPulled("foo4")
+	pull(AutogitPullOptions{ForceTakeover: true})
+	checkFileInRootFS(t, ctx, config, h, rootFS, "checkout/test/foo4", "hello4")
+	_, err = rootFS.Stat("checkout/" + autogitWorkingName("test"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,435 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+// maxBrowserSymlinkFollows limits how many symlinks are followed
+// before giving up, in case of loops.
+const maxBrowserSymlinkFollows = 40
+
+// Browser is a read-only billy.Filesystem that shows the worktree of
+// a git repo at a given commit, read directly from the repo's object
+// store.  Nothing is checked out, so it's available right away and
+// uses no extra storage; but each opened file is read fully into
+// memory.  All the methods that would modify the filesystem return
+// billy.ErrReadOnly.
+type Browser struct {
+	tree  *object.Tree
+	root  string
+	mtime time.Time
+}
+
+var _ billy.Filesystem = (*Browser)(nil)
+
+// NewBrowser returns a Browser for the commit that `ref` refers to in
+// the bare repo in `repoFS`.  `ref` is interpreted the same way as in
+// AutogitManager.Clone.
+func NewBrowser(repoFS billy.Filesystem, ref string) (*Browser, error) {
+	repoStorer, err := filesystem.NewStorage(repoFS)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := NewOnDemandStorer(repoStorer)
+	if err != nil {
+		return nil, err
+	}
+	h, err := resolveCommit(storage, autogitTarget(ref))
+	if err != nil {
+		return nil, err
+	}
+	commit, err := object.GetCommit(storage, h)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return &Browser{
+		tree:  tree,
+		mtime: commit.Committer.When,
+	}, nil
+}
+
+// NewBrowserForRepo returns a Browser for `ref` of the repo named
+// `repoName` in the TLF `h`.
+func NewBrowserForRepo(
+	ctx context.Context, config libkbfs.Config, h *libkbfs.TlfHandle,
+	repoName, ref string) (*Browser, error) {
+	repoFS, _, err := GetRepoAndID(ctx, config, h, repoName, "")
+	if err != nil {
+		return nil, err
+	}
+	return NewBrowser(repoFS, ref)
+}
+
+// fullPath returns the path of `filename` relative to the top of the
+// repo, which can't be outside of the browser's root.
+func (b *Browser) fullPath(filename string) string {
+	p := path.Join(b.root, path.Clean("/" + filename)[1:])
+	if p == "." {
+		return ""
+	}
+	return p
+}
+
+func notExist(op, filename string) error {
+	return &os.PathError{Op: op, Path: filename, Err: os.ErrNotExist}
+}
+
+// browserFileInfo is the os.FileInfo for an entry in a Browser.
+type browserFileInfo struct {
+	name  string
+	size  int64
+	mode  os.FileMode
+	mtime time.Time
+}
+
+var _ os.FileInfo = browserFileInfo{}
+
+func (fi browserFileInfo) Name() string       { return fi.name }
+func (fi browserFileInfo) Size() int64        { return fi.size }
+func (fi browserFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi browserFileInfo) ModTime() time.Time { return fi.mtime }
+func (fi browserFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi browserFileInfo) Sys() interface{}   { return nil }
+
+func (b *Browser) entryInfo(
+	name string, entry *object.TreeEntry) (os.FileInfo, error) {
+	mode, err := entry.Mode.ToOSFileMode()
+	if err != nil {
+		return nil, err
+	}
+	fi := browserFileInfo{
+		name: name,
+		// Nothing can be written.
+		mode:  mode &^ 0222,
+		mtime: b.mtime,
+	}
+	if entry.Mode.IsFile() {
+		f, err := b.tree.TreeEntryFile(entry)
+		if err != nil {
+			return nil, err
+		}
+		fi.size = f.Size
+	}
+	return fi, nil
+}
+
+// findEntry returns the entry at `p`, relative to the top of the
+// repo, without following symlinks.
+func (b *Browser) findEntry(op, p string) (*object.TreeEntry, error) {
+	tree := b.tree
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		var entry *object.TreeEntry
+		for j := range tree.Entries {
+			if tree.Entries[j].Name == part {
+				entry = &tree.Entries[j]
+				break
+			}
+		}
+		if entry == nil {
+			return nil, notExist(op, p)
+		}
+		if i == len(parts)-1 {
+			return entry, nil
+		}
+		if entry.Mode != filemode.Dir {
+			return nil, notExist(op, p)
+		}
+		var err error
+		tree, err = tree.Tree(part)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, notExist(op, p)
+}
+
+// lstat returns the info for the entry at `p`, relative to the top
+// of the repo, without following symlinks.
+func (b *Browser) lstat(op, p string) (os.FileInfo, error) {
+	if p == "" {
+		return browserFileInfo{
+			name:  path.Base("/" + b.root),
+			mode:  os.ModeDir | 0555,
+			mtime: b.mtime,
+		}, nil
+	}
+	entry, err := b.findEntry(op, p)
+	if err != nil {
+		return nil, err
+	}
+	return b.entryInfo(path.Base(p), entry)
+}
+
+func (b *Browser) readlink(p string) (string, error) {
+	entry, err := b.findEntry("readlink", p)
+	if err != nil {
+		return "", err
+	}
+	if entry.Mode != filemode.Symlink {
+		return "", errors.Errorf("%s is not a symlink", p)
+	}
+	f, err := b.tree.TreeEntryFile(entry)
+	if err != nil {
+		return "", err
+	}
+	return f.Contents()
+}
+
+// resolve follows `filename`, and any symlinks along the way, to the
+// path of an entry that isn't a symlink, relative to the top of the
+// repo.  Symlinks may only point to somewhere else in the repo.
+func (b *Browser) resolve(op, filename string) (
+	p string, fi os.FileInfo, err error) {
+	p = b.fullPath(filename)
+	follows := 0
+outer:
+	for {
+		if p == "" {
+			fi, err := b.lstat(op, p)
+			return p, fi, err
+		}
+		parts := strings.Split(p, "/")
+		resolved := ""
+		for i, part := range parts {
+			cur := path.Join(resolved, part)
+			fi, err := b.lstat(op, cur)
+			if err != nil {
+				return "", nil, err
+			}
+			if fi.Mode()&os.ModeSymlink == 0 {
+				if i == len(parts)-1 {
+					return cur, fi, nil
+				}
+				resolved = cur
+				continue
+			}
+
+			follows++
+			if follows > maxBrowserSymlinkFollows {
+				return "", nil, errors.Errorf(
+					"too many levels of symlinks in %s", filename)
+			}
+			target, err := b.readlink(cur)
+			if err != nil {
+				return "", nil, err
+			}
+			next := path.Join(
+				append([]string{resolved, target}, parts[i+1:]...)...)
+			if path.IsAbs(target) || next == ".." ||
+				strings.HasPrefix(next, "../") {
+				return "", nil, errors.Errorf(
+					"%s links outside of the repo to %s", filename, target)
+			}
+			p = next
+			if p == "." {
+				p = ""
+			}
+			continue outer
+		}
+	}
+}
+
+// Lstat implements the billy.Filesystem interface for Browser.
+func (b *Browser) Lstat(filename string) (os.FileInfo, error) {
+	return b.lstat("lstat", b.fullPath(filename))
+}
+
+// Stat implements the billy.Filesystem interface for Browser.
+func (b *Browser) Stat(filename string) (os.FileInfo, error) {
+	_, fi, err := b.resolve("stat", filename)
+	return fi, err
+}
+
+// Readlink implements the billy.Filesystem interface for Browser.
+func (b *Browser) Readlink(link string) (string, error) {
+	return b.readlink(b.fullPath(link))
+}
+
+// ReadDir implements the billy.Filesystem interface for Browser.
+// Submodules are shown as empty directories.
+func (b *Browser) ReadDir(dirname string) ([]os.FileInfo, error) {
+	p, fi, err := b.resolve("readdir", dirname)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", dirname)
+	}
+	dir := b.tree
+	if p != "" {
+		entry, err := b.findEntry("readdir", p)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode == filemode.Submodule {
+			return nil, nil
+		}
+		dir, err = b.tree.Tree(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fis := make([]os.FileInfo, 0, len(dir.Entries))
+	for i := range dir.Entries {
+		entry := &dir.Entries[i]
+		fi, err := b.entryInfo(entry.Name, entry)
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+// Open implements the billy.Filesystem interface for Browser.
+func (b *Browser) Open(filename string) (billy.File, error) {
+	return b.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile implements the billy.Filesystem interface for Browser.
+// Only read-only opens are allowed.
+func (b *Browser) OpenFile(
+	filename string, flag int, _ os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, billy.ErrReadOnly
+	}
+	p, fi, err := b.resolve("open", filename)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, errors.Errorf("%s is a directory", filename)
+	}
+	entry, err := b.findEntry("open", p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := b.tree.TreeEntryFile(entry)
+	if err != nil {
+		return nil, err
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &browserFile{name: filename, Reader: bytes.NewReader(data)}, nil
+}
+
+// Create implements the billy.Filesystem interface for Browser.
+func (b *Browser) Create(_ string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+// Rename implements the billy.Filesystem interface for Browser.
+func (b *Browser) Rename(_, _ string) error {
+	return billy.ErrReadOnly
+}
+
+// Remove implements the billy.Filesystem interface for Browser.
+func (b *Browser) Remove(_ string) error {
+	return billy.ErrReadOnly
+}
+
+// TempFile implements the billy.Filesystem interface for Browser.
+func (b *Browser) TempFile(_, _ string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+// MkdirAll implements the billy.Filesystem interface for Browser.
+func (b *Browser) MkdirAll(_ string, _ os.FileMode) error {
+	return billy.ErrReadOnly
+}
+
+// Symlink implements the billy.Filesystem interface for Browser.
+func (b *Browser) Symlink(_, _ string) error {
+	return billy.ErrReadOnly
+}
+
+// Join implements the billy.Filesystem interface for Browser.
+func (b *Browser) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Chroot implements the billy.Filesystem interface for Browser.
+func (b *Browser) Chroot(dirname string) (billy.Filesystem, error) {
+	p, fi, err := b.resolve("chroot", dirname)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", dirname)
+	}
+	return &Browser{tree: b.tree, root: p, mtime: b.mtime}, nil
+}
+
+// Root implements the billy.Filesystem interface for Browser.
+func (b *Browser) Root() string {
+	return b.root
+}
+
+// browserFile is a file opened from a Browser.
+type browserFile struct {
+	name string
+	*bytes.Reader
+}
+
+var _ billy.File = (*browserFile)(nil)
+
+// Name implements the billy.File interface for browserFile.
+func (f *browserFile) Name() string {
+	return f.name
+}
+
+// Write implements the billy.File interface for browserFile.
+func (f *browserFile) Write(_ []byte) (int, error) {
+	return 0, billy.ErrReadOnly
+}
+
+// Close implements the billy.File interface for browserFile.
+func (f *browserFile) Close() error {
+	return nil
+}
+
+// Lock implements the billy.File interface for browserFile.  Nothing
+// can change the file, so there's nothing to lock out.
+func (f *browserFile) Lock() error {
+	return nil
+}
+
+// Unlock implements the billy.File interface for browserFile.
+func (f *browserFile) Unlock() error {
+	return nil
+}
+
+// Truncate implements the billy.File interface for browserFile.
+func (f *browserFile) Truncate(_ int64) error {
+	return billy.ErrReadOnly
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+func TestBrowser(t *testing.T) {
+	git1, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git1)
+
+	makeLocalRepoWithOneFile(t, git1, "foo", "hello", "")
+	dotgit1 := filepath.Join(git1, ".git")
+	out, err := exec.Command(
+		"git", "--git-dir", dotgit1, "rev-parse", "HEAD").Output()
+	require.NoError(t, err)
+	v1Hash := strings.TrimSpace(string(out))
+	err = os.Mkdir(filepath.Join(git1, "dir"), 0700)
+	require.NoError(t, err)
+	addOneFileToRepo(t, git1, "dir/bar", "hello bar")
+	err = os.Symlink("dir", filepath.Join(git1, "link"))
+	require.NoError(t, err)
+	gitExec(t, dotgit1, git1, "add", "link")
+	gitExec(t, dotgit1, git1, "-c", "user.name=Foo",
+		"-c", "user.email=foo@foo.com", "commit", "-a", "-m", "link")
+
+	dotgit1FS := osfs.New(dotgit1)
+
+	t.Log("Browse the head of the branch")
+	b, err := NewBrowser(dotgit1FS, "master")
+	require.NoError(t, err)
+	testCheckFile(t, b, "foo", "hello")
+	testCheckFile(t, b, "dir/bar", "hello bar")
+	testCheckFile(t, b, "link/bar", "hello bar")
+
+	fi, err := b.Stat("foo")
+	require.NoError(t, err)
+	require.Equal(t, "foo", fi.Name())
+	require.Equal(t, int64(len("hello")), fi.Size())
+	require.False(t, fi.IsDir())
+	require.Zero(t, fi.Mode()&0222)
+	fi, err = b.Stat("link")
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	fi, err = b.Lstat("link")
+	require.NoError(t, err)
+	require.NotZero(t, fi.Mode()&os.ModeSymlink)
+	target, err := b.Readlink("link")
+	require.NoError(t, err)
+	require.Equal(t, "dir", target)
+
+	fis, err := b.ReadDir("")
+	require.NoError(t, err)
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{"dir", "foo", "link"}, names)
+	fis, err = b.ReadDir("link")
+	require.NoError(t, err)
+	require.Len(t, fis, 1)
+	require.Equal(t, "bar", fis[0].Name())
+
+	_, err = b.Stat("nope")
+	require.True(t, os.IsNotExist(err))
+	_, err = b.Open("foo/nope")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Nothing can be written")
+	_, err = b.Create("new")
+	require.Equal(t, billy.ErrReadOnly, err)
+	_, err = b.OpenFile("foo", os.O_WRONLY, 0600)
+	require.Equal(t, billy.ErrReadOnly, err)
+	err = b.Remove("foo")
+	require.Equal(t, billy.ErrReadOnly, err)
+	err = b.MkdirAll("newdir", 0700)
+	require.Equal(t, billy.ErrReadOnly, err)
+
+	t.Log("Chroot into a subdirectory")
+	sub, err := b.Chroot("dir")
+	require.NoError(t, err)
+	testCheckFile(t, sub, "bar", "hello bar")
+	_, err = sub.Stat("../foo")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Browse an older commit by its hash")
+	b, err = NewBrowser(dotgit1FS, v1Hash)
+	require.NoError(t, err)
+	testCheckFile(t, b, "foo", "hello")
+	_, err = b.Stat("dir")
+	require.True(t, os.IsNotExist(err))
+
+	_, err = NewBrowser(dotgit1FS, "refs/heads/nope")
+	require.Error(t, err)
+}
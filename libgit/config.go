@@ -12,6 +12,15 @@ type Config struct {
 	Name       string // the original user-supplied format of the name
 	CreatorUID string
 	Ctime      int64 // create time in unix nanoseconds, by creator's clock
+	// Quota limits how big pushes can make the repo; nil means no
+	// repo-specific limit.
+	Quota *RepoQuota `json:",omitempty"`
+	// ProtectedBranches lists the branches, by name, that only team
+	// admins can delete or force-push.  It's enforced by the git
+	// remote helper, not by the KBFS servers.
+	ProtectedBranches []string `json:",omitempty"`
+	// PostReceiveHooks lists the hooks notified after each push.
+	PostReceiveHooks []PostReceiveHookConfig `json:",omitempty"`
 }
 
 func configFromBytes(buf []byte) (*Config, error) {
@@ -0,0 +1,521 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/eapache/channels"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// repoGCWorkingFileName marks that some device is
+	// garbage-collecting a repo.  Its mtime is the common time at
+	// which that device started.
+	repoGCWorkingFileName = ".gc_working"
+	// defaultGCWorkTimeLimit is how long a device can hold on to the
+	// GC of a repo before another device can take it over.
+	defaultGCWorkTimeLimit = 1 * time.Hour
+	// minAutoGCInterval is the shortest time between automatic GCs
+	// of the same repo.
+	minAutoGCInterval = 24 * time.Hour
+
+	// The automatic GC thresholds; see AutoGCOptions.
+	autoGCMaxLooseRefs        = 50
+	autoGCPruneExpireAge      = 14 * 24 * time.Hour
+	autoGCMinLooseObjects     = 256
+	autoGCMaxLooseObjects     = 4096
+	autoGCBytesPerLooseObject = 1 << 20 // 1 MiB
+	autoGCMinObjectPacks      = 10
+	autoGCMaxObjectPacks      = 50
+	autoGCBytesPerObjectPack  = 100 << 20 // 100 MiB
+)
+
+var errGCLeaseHeld = errors.New(
+	"another device is already garbage-collecting this repo")
+
+// AutoGCOptions returns the GC thresholds to use for a repo whose
+// objects take up `repoSize` bytes, as of `now`.  Bigger repos put up
+// with more loose objects and object packs before they're collected,
+// since pruning and re-packing them is more expensive.  Like git,
+// only unreachable objects that are at least two weeks old are
+// pruned.
+func AutoGCOptions(repoSize int64, now time.Time) GCOptions {
+	looseObjects := autoGCMinLooseObjects +
+		int(repoSize/autoGCBytesPerLooseObject)
+	if looseObjects > autoGCMaxLooseObjects {
+		looseObjects = autoGCMaxLooseObjects
+	}
+	packs := autoGCMinObjectPacks + int(repoSize/autoGCBytesPerObjectPack)
+	if packs > autoGCMaxObjectPacks {
+		packs = autoGCMaxObjectPacks
+	}
+	return GCOptions{
+		MaxLooseRefs:         autoGCMaxLooseRefs,
+		PruneMinLooseObjects: looseObjects,
+		PruneExpireTime:      now.Add(-autoGCPruneExpireAge),
+		MaxObjectPacks:       packs,
+	}
+}
+
+// dirSize returns the total size of the files under `dir` in `fs`.
+func dirSize(fs billy.Filesystem, dir string) (size int64, err error) {
+	fis, err := fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			size += fi.Size()
+			continue
+		}
+		subSize, err := dirSize(fs, path.Join(dir, fi.Name()))
+		if err != nil {
+			return 0, err
+		}
+		size += subSize
+	}
+	return size, nil
+}
+
+// takeGCLease claims the GC of the repo in `fs` for this device,
+// following the same protocol autogit uses for its checkouts: while
+// holding the repo's GC lock, compare the common time in the mtime of
+// the working file against `workTimeLimit`.  It returns false if
+// another device already holds the lease.
+func takeGCLease(
+	ctx context.Context, config libkbfs.Config, fs *libfs.FS,
+	workTimeLimit time.Duration, log logger.Logger) (ok bool, err error) {
+	// Don't truncate the lock file, since its mtime is the last GC
+	// time.
+	lockFile, err := fs.OpenFile(
+		repoGCLockFileName, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		// Because we took the lock, this Close will sync/flush the
+		// whole journal.
+		closeErr := lockFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	err = lockFile.Lock()
+	if err != nil {
+		return false, err
+	}
+
+	currCommonTime := commonTime(ctx, config, log)
+	fi, err := fs.Stat(repoGCWorkingFileName)
+	switch {
+	case os.IsNotExist(err):
+		f, err := fs.Create(repoGCWorkingFileName)
+		if err != nil {
+			return false, err
+		}
+		err = f.Close()
+		if err != nil {
+			return false, err
+		}
+	case err != nil:
+		return false, err
+	case fi.ModTime().Add(workTimeLimit).After(currCommonTime):
+		log.CDebugf(ctx, "Another device is still garbage-collecting; "+
+			"modCommonTime=%s, currCommonTime=%s, workTimeLimit=%s",
+			fi.ModTime(), currCommonTime, workTimeLimit)
+		return false, nil
+	default:
+		log.CDebugf(ctx, "Other GC expired; modCommonTime=%s, "+
+			"currCommonTime=%s, workTimeLimit=%s",
+			fi.ModTime(), currCommonTime, workTimeLimit)
+	}
+
+	err = fs.Chtimes(repoGCWorkingFileName, time.Time{}, currCommonTime)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseGCLease gives up this device's GC lease on the repo in `fs`.
+func releaseGCLease(fs *libfs.FS) (err error) {
+	lockFile, err := fs.OpenFile(
+		repoGCLockFileName, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := lockFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	err = lockFile.Lock()
+	if err != nil {
+		return err
+	}
+	err = fs.Remove(repoGCWorkingFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GCRepoWithLease runs garbage collection on the specified repo, like
+// GCRepo, but only after taking the repo's GC lease, so that no two
+// devices collect the same repo at once.  If `options` is nil, the
+// thresholds come from AutoGCOptions, and the repo is skipped if it
+// was collected recently.  It returns false if nothing was done
+// because of that.  If another device holds the lease for less than
+// `workTimeLimit`, an error is returned.
+func GCRepoWithLease(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string, options *GCOptions, workTimeLimit time.Duration) (
+	didGC bool, err error) {
+	log := config.MakeLogger("")
+	uniqID, err := makeUniqueID(ctx, config)
+	if err != nil {
+		return false, err
+	}
+	fs, _, err := getOrCreateRepoAndID(
+		ctx, config, tlfHandle, repoName, uniqID, getOnly)
+	if err != nil {
+		return false, err
+	}
+
+	if options == nil {
+		lastGCTime, err := LastGCTime(ctx, fs)
+		if err != nil {
+			return false, err
+		}
+		now := config.Clock().Now()
+		if now.Sub(lastGCTime) < minAutoGCInterval {
+			log.CDebugf(ctx, "Last GC happened at %s; skipping", lastGCTime)
+			return false, nil
+		}
+		size, err := dirSize(fs, "objects")
+		if err != nil {
+			return false, err
+		}
+		autoOptions := AutoGCOptions(size, now)
+		log.CDebugf(ctx, "Using automatic GC options for %d bytes of "+
+			"objects: %+v", size, autoOptions)
+		options = &autoOptions
+	}
+
+	ok, err := takeGCLease(ctx, config, fs, workTimeLimit, log)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, errGCLeaseHeld
+	}
+	defer func() {
+		releaseErr := releaseGCLease(fs)
+		if err == nil {
+			err = releaseErr
+		}
+	}()
+
+	err = GCRepo(ctx, config, tlfHandle, repoName, *options)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// gcReq is a request to garbage-collect one repo.
+type gcReq struct {
+	h       *libkbfs.TlfHandle
+	repo    string
+	options *GCOptions // nil for the automatic thresholds
+	doneCh  chan struct{}
+	err     error // set before doneCh is closed
+}
+
+func (req *gcReq) id() string {
+	return path.Join(req.h.GetCanonicalPath(), normalizeRepoName(req.repo))
+}
+
+func sameGCOptions(a, b *GCOptions) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.MaxLooseRefs == b.MaxLooseRefs &&
+		a.PruneMinLooseObjects == b.PruneMinLooseObjects &&
+		a.PruneExpireTime.Equal(b.PruneExpireTime) &&
+		a.MaxObjectPacks == b.MaxObjectPacks
+}
+
+// GCManager garbage-collects bare repos in KBFS in the background.
+// Each request is processed with its own single-op config, and
+// flushed as a single revision once it's done.  Requests for a repo
+// that's already waiting to be collected with the same options are
+// rolled into the waiting request.
+type GCManager struct {
+	config         libkbfs.Config
+	kbCtx          libkbfs.Context
+	kbfsInitParams *libkbfs.InitParams
+	log            logger.Logger
+	deferLog       logger.Logger
+
+	// getNewConfig can be overridden by tests.
+	getNewConfig  getNewConfigFn
+	workTimeLimit time.Duration
+
+	queue      channels.Channel
+	workersWG  sync.WaitGroup
+	shutdownCh chan struct{}
+
+	lock       sync.Mutex
+	inQueue    map[string][]*gcReq
+	inProgress map[string]*gcReq
+}
+
+// NewGCManager constructs a new GCManager instance, with
+// `numWorkers` repos being collected at a time.
+func NewGCManager(
+	config libkbfs.Config, kbCtx libkbfs.Context,
+	kbfsInitParams *libkbfs.InitParams, numWorkers int) *GCManager {
+	log := config.MakeLogger("")
+	gm := &GCManager{
+		config:         config,
+		kbCtx:          kbCtx,
+		kbfsInitParams: kbfsInitParams,
+		log:            log,
+		deferLog:       log.CloneWithAddedDepth(1),
+		workTimeLimit:  defaultGCWorkTimeLimit,
+		queue:          libkbfs.NewInfiniteChannelWrapper(),
+		shutdownCh:     make(chan struct{}),
+		inQueue:        make(map[string][]*gcReq),
+		inProgress:     make(map[string]*gcReq),
+	}
+	gm.getNewConfig = gm.getNewConfigDefault
+	for i := 0; i < numWorkers; i++ {
+		gm.workersWG.Add(1)
+		go gm.worker()
+	}
+	return gm
+}
+
+func (gm *GCManager) getNewConfigDefault(ctx context.Context) (
+	context.Context, libkbfs.Config, string, error) {
+	return getNewConfig(ctx, gm.config, gm.kbCtx, gm.kbfsInitParams, gm.log)
+}
+
+// Shutdown shuts down this manager.  Requests that haven't started
+// yet fail, but it waits for the ones in progress to finish.
+func (gm *GCManager) Shutdown() {
+	close(gm.shutdownCh)
+	gm.queue.Close()
+	gm.workersWG.Wait()
+}
+
+func (gm *GCManager) markInProgress(req *gcReq) (waitCh <-chan struct{}) {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+	id := req.id()
+	if inProgress, ok := gm.inProgress[id]; ok {
+		return inProgress.doneCh
+	}
+	queued := gm.inQueue[id]
+	for i, q := range queued {
+		if q == req {
+			queued = append(queued[:i], queued[i+1:]...)
+			break
+		}
+	}
+	if len(queued) == 0 {
+		delete(gm.inQueue, id)
+	} else {
+		gm.inQueue[id] = queued
+	}
+	gm.inProgress[id] = req
+	return nil
+}
+
+func (gm *GCManager) clearFromInProgress(req *gcReq) {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+	delete(gm.inProgress, req.id())
+}
+
+func (gm *GCManager) doGC(ctx context.Context, req *gcReq) (err error) {
+	gm.log.CDebugf(ctx, "Processing GC request for %s", req.id())
+	defer func() {
+		gm.deferLog.CDebugf(ctx, "GC request completed: %+v", err)
+	}()
+
+	// Make a new single-op config for processing this request.
+	ctx, gitConfig, tempDir, err := gm.getNewConfig(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		gitConfig.Shutdown(ctx)
+		rmErr := os.RemoveAll(tempDir)
+		if rmErr != nil {
+			gm.log.CWarningf(
+				ctx, "Error cleaning storage dir %s: %+v\n", tempDir, rmErr)
+		}
+	}()
+
+	didGC, err := GCRepoWithLease(
+		ctx, gitConfig, req.h, req.repo, req.options, gm.workTimeLimit)
+	if err == errGCLeaseHeld && req.options == nil {
+		// Someone else is already taking care of it.
+		gm.log.CDebugf(ctx, "Skipping automatic GC: %v", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !didGC {
+		return nil
+	}
+	return waitForJournal(ctx, gitConfig, req.h, gm.log)
+}
+
+func (gm *GCManager) worker() {
+	defer gm.workersWG.Done()
+	for reqInt := range gm.queue.Out() {
+		req := reqInt.(*gcReq)
+		ctx := libkbfs.CtxWithRandomIDReplayable(
+			context.Background(), ctxIDKey, ctxOpID, gm.log)
+
+		select {
+		case <-gm.shutdownCh:
+			req.err = errors.New("GC manager is shut down")
+			gm.markInProgress(req)
+			gm.clearFromInProgress(req)
+			close(req.doneCh)
+			continue
+		default:
+		}
+
+		for {
+			waitCh := gm.markInProgress(req)
+			if waitCh == nil {
+				break
+			}
+			// Wait for any in-progress GC of the same repo, since the
+			// File.Lock is taken per-instance, not per-goroutine.
+			gm.log.CDebugf(ctx, "Waiting to GC %s", req.id())
+			<-waitCh
+		}
+
+		req.err = gm.doGC(ctx, req)
+		gm.clearFromInProgress(req)
+		close(req.doneCh)
+	}
+}
+
+// queueGC queues a GC of `repoName` in `h`, or returns a matching
+// request that's already queued.
+func (gm *GCManager) queueGC(
+	ctx context.Context, h *libkbfs.TlfHandle, repoName string,
+	options *GCOptions) (*gcReq, error) {
+	req := &gcReq{
+		h:       h,
+		repo:    repoName,
+		options: options,
+		doneCh:  make(chan struct{}),
+	}
+	id := req.id()
+	queued := func() *gcReq {
+		gm.lock.Lock()
+		defer gm.lock.Unlock()
+		for _, q := range gm.inQueue[id] {
+			if sameGCOptions(q.options, options) {
+				return q
+			}
+		}
+		gm.inQueue[id] = append(gm.inQueue[id], req)
+		return nil
+	}()
+	if queued != nil {
+		gm.log.CDebugf(ctx, "GC request for %s is already queued", id)
+		return queued, nil
+	}
+
+	select {
+	case gm.queue.In() <- req:
+	case <-ctx.Done():
+		// We've already promised to queue this, and may have turned
+		// away other requests for it already, so we better queue it.
+		go func() { gm.queue.In() <- req }()
+		return nil, ctx.Err()
+	}
+	return req, nil
+}
+
+func (gm *GCManager) waitForGC(ctx context.Context, req *gcReq) error {
+	select {
+	case <-req.doneCh:
+		return req.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GC garbage-collects the repo named `repoName` in `h`, with the
+// given thresholds, and waits for it to finish.  If `options` is nil,
+// the thresholds are picked automatically based on the size of the
+// repo (see AutoGCOptions), and the repo is skipped if it was
+// collected recently or is being collected by another device.
+func (gm *GCManager) GC(
+	ctx context.Context, h *libkbfs.TlfHandle, repoName string,
+	options *GCOptions) error {
+	gm.log.CDebugf(ctx, "GC request for %s/%s, options=%+v",
+		h.GetCanonicalPath(), repoName, options)
+	req, err := gm.queueGC(ctx, h, repoName, options)
+	if err != nil {
+		return err
+	}
+	return gm.waitForGC(ctx, req)
+}
+
+// GCAll garbage-collects every repo in `h`, as in GC, and waits for
+// them all to finish.  It returns the first error encountered, after
+// all the repos have been tried.
+func (gm *GCManager) GCAll(
+	ctx context.Context, h *libkbfs.TlfHandle, options *GCOptions) error {
+	repos, err := listRepos(ctx, gm.config, h)
+	if err != nil {
+		return err
+	}
+	gm.log.CDebugf(ctx, "GC request for all %d repos in %s, options=%+v",
+		len(repos), h.GetCanonicalPath(), options)
+	reqs := make([]*gcReq, 0, len(repos))
+	for _, repo := range repos {
+		req, err := gm.queueGC(ctx, h, repo, options)
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+	}
+	var firstErr error
+	for _, req := range reqs {
+		err := gm.waitForGC(ctx, req)
+		if err != nil {
+			gm.log.CDebugf(ctx, "Couldn't GC %s: %+v", req.id(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
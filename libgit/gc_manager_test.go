@@ -0,0 +1,138 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestAutoGCOptions(t *testing.T) {
+	now := time.Now()
+	small := AutoGCOptions(0, now)
+	require.Equal(t, autoGCMinLooseObjects, small.PruneMinLooseObjects)
+	require.Equal(t, autoGCMinObjectPacks, small.MaxObjectPacks)
+	require.Equal(t, now.Add(-autoGCPruneExpireAge), small.PruneExpireTime)
+
+	big := AutoGCOptions(1<<30, now)
+	require.True(t, big.PruneMinLooseObjects > small.PruneMinLooseObjects)
+	require.True(t, big.MaxObjectPacks > small.MaxObjectPacks)
+
+	huge := AutoGCOptions(1<<50, now)
+	require.Equal(t, autoGCMaxLooseObjects, huge.PruneMinLooseObjects)
+	require.Equal(t, autoGCMaxObjectPacks, huge.MaxObjectPacks)
+}
+
+func TestGCManager(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
+
+	addBranch := func(name string) {
+		head, err := repo.Head()
+		require.NoError(t, err)
+		err = dotgitStorage.SetReference(plumbing.NewHashReference(
+			plumbing.ReferenceName("refs/heads/"+name), head.Hash()))
+		require.NoError(t, err)
+		commitWorktree(t, ctx, config, h, dotgitFS)
+	}
+	countLooseRefs := func() int {
+		err := config.KBFSOps().SyncFromServer(
+			ctx, rootFS.RootNode().GetFolderBranch(), nil)
+		require.NoError(t, err)
+		fs, _, err := GetRepoAndID(ctx, config, h, "test", "")
+		require.NoError(t, err)
+		storage, err := NewGitConfigWithoutRemotesStorer(fs)
+		require.NoError(t, err)
+		n, err := storage.CountLooseRefs()
+		require.NoError(t, err)
+		return n
+	}
+	addBranch("b1")
+	require.Equal(t, 2, countLooseRefs())
+
+	kbCtx := env.NewContext()
+	kbfsInitParams := libkbfs.DefaultInitParams(kbCtx)
+	gm := NewGCManager(config, kbCtx, &kbfsInitParams, 1)
+	defer gm.Shutdown()
+	nc := &newConfigger{config: config, user: "user1"}
+	defer nc.shutdown(t, ctx)
+	gm.getNewConfig = nc.getNewConfigForTest
+
+	packRefs := &GCOptions{
+		MaxLooseRefs:         0,
+		PruneMinLooseObjects: -1,
+		MaxObjectPacks:       -1,
+	}
+
+	t.Log("Pack the loose refs")
+	err = gm.GC(ctx, h, "test", packRefs)
+	require.NoError(t, err)
+	require.Equal(t, 0, countLooseRefs())
+	fs, _, err := GetRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	lastGCTime, err := LastGCTime(ctx, fs)
+	require.NoError(t, err)
+	require.False(t, lastGCTime.IsZero())
+	_, err = fs.Stat(repoGCWorkingFileName)
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("An automatic GC skips the recently-collected repo")
+	addBranch("b2")
+	err = gm.GCAll(ctx, h, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, countLooseRefs())
+
+	t.Log("Another device holding the lease blocks the GC")
+	f, err := fs.Create(repoGCWorkingFileName)
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+	err = fs.Chtimes(repoGCWorkingFileName, time.Time{},
+		commonTime(ctx, config, gm.log))
+	require.NoError(t, err)
+	commitWorktree(t, ctx, config, h, fs)
+	err = gm.GC(ctx, h, "test", packRefs)
+	require.Equal(t, errGCLeaseHeld, errors.Cause(err))
+	require.Equal(t, 1, countLooseRefs())
+
+	t.Log("An expired lease can be taken over")
+	gm.workTimeLimit = 0
+	err = gm.GC(ctx, h, "test", packRefs)
+	require.NoError(t, err)
+	require.Equal(t, 0, countLooseRefs())
+}
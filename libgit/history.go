@@ -0,0 +1,188 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+const (
+	defaultCommitHistoryPageSize = 50
+	// keybaseEmailDomain marks commit authors who identify themselves
+	// by Keybase username.
+	keybaseEmailDomain = "@keybase.io"
+)
+
+func commitToGitCommit(c *object.Commit) keybase1.GitCommit {
+	return keybase1.GitCommit{
+		CommitHash:  hex.EncodeToString(c.Hash[:]),
+		Message:     c.Message,
+		AuthorName:  c.Author.Name,
+		AuthorEmail: c.Author.Email,
+		Ctime:       keybase1.Time(c.Author.When.Unix()),
+	}
+}
+
+// CommitHistoryEntry is a single commit in a page of repo history.
+type CommitHistoryEntry struct {
+	Commit keybase1.GitCommit
+	// Username is the Keybase user who authored the commit, or empty
+	// if the author couldn't be matched to one.
+	Username string
+}
+
+// CommitHistoryPage is one page of the commit history of a branch.
+type CommitHistoryPage struct {
+	Commits []CommitHistoryEntry
+	// Next is the hash of the commit that starts the next page, or
+	// empty if this is the last page.
+	Next string
+}
+
+// authorResolver matches commit authors to Keybase usernames.  An
+// author matches if their name, or the local part of their email
+// address, is the username of a user in the TLF's handle; or if
+// their email address is at keybase.io and its local part resolves
+// to a Keybase user.
+type authorResolver struct {
+	config  libkbfs.Config
+	inTLF   map[string]string
+	keybase map[string]string
+}
+
+func newAuthorResolver(
+	config libkbfs.Config, h *libkbfs.TlfHandle) *authorResolver {
+	inTLF := make(map[string]string)
+	for id, name := range h.ResolvedUsersMap() {
+		if id.IsUser() {
+			inTLF[strings.ToLower(name.String())] = name.String()
+		}
+	}
+	return &authorResolver{
+		config:  config,
+		inTLF:   inTLF,
+		keybase: make(map[string]string),
+	}
+}
+
+func (ar *authorResolver) resolve(
+	ctx context.Context, author object.Signature) string {
+	email := strings.ToLower(author.Email)
+	local := email
+	if i := strings.Index(email, "@"); i >= 0 {
+		local = email[:i]
+	}
+
+	if username, ok := ar.inTLF[strings.ToLower(author.Name)]; ok {
+		return username
+	}
+	if username, ok := ar.inTLF[local]; ok {
+		return username
+	}
+	if !strings.HasSuffix(email, keybaseEmailDomain) || local == "" {
+		return ""
+	}
+
+	if username, ok := ar.keybase[local]; ok {
+		return username
+	}
+	name, id, err := ar.config.KBPKI().Resolve(ctx, local)
+	username := ""
+	if err == nil && id.IsUser() {
+		username = name.String()
+	} else if err != nil {
+		ar.config.MakeLogger("").CDebugf(
+			ctx, "Couldn't resolve commit author %s: %+v", author.Email, err)
+	}
+	ar.keybase[local] = username
+	return username
+}
+
+// GetCommitHistory returns a page of the history of `branch` in the
+// repo named `repoName` in the TLF `h`, newest first.  The page
+// starts at the commit with hash `start`, or at the head of the
+// branch if `start` is empty, and holds at most `limit` commits (or a
+// default number, if `limit` isn't positive).  Pass the returned
+// `Next` hash as `start` to get the following page.
+func GetCommitHistory(
+	ctx context.Context, config libkbfs.Config, h *libkbfs.TlfHandle,
+	repoName, branch, start string, limit int) (
+	page CommitHistoryPage, err error) {
+	if limit <= 0 {
+		limit = defaultCommitHistoryPageSize
+	}
+	if start != "" && !isCommitHash(start) {
+		return CommitHistoryPage{}, errors.Errorf(
+			"Invalid commit hash: %s", start)
+	}
+
+	repoFS, _, err := GetRepoAndID(ctx, config, h, repoName, "")
+	if err != nil {
+		return CommitHistoryPage{}, err
+	}
+	repoStorer, err := filesystem.NewStorage(repoFS)
+	if err != nil {
+		return CommitHistoryPage{}, err
+	}
+	storage, err := NewOnDemandStorer(repoStorer)
+	if err != nil {
+		return CommitHistoryPage{}, err
+	}
+	head, err := resolveCommit(storage, autogitTarget(branch))
+	if err != nil {
+		return CommitHistoryPage{}, err
+	}
+	headCommit, err := object.GetCommit(storage, head)
+	if err != nil {
+		return CommitHistoryPage{}, err
+	}
+
+	// Always walk from the head of the branch, so that a page
+	// boundary inside a merge doesn't lose the other side of the
+	// merge.
+	iter := object.NewCommitPreorderIter(headCommit, nil, nil)
+	defer iter.Close()
+	startHash := plumbing.NewHash(start)
+	started := start == ""
+	resolver := newAuthorResolver(config, h)
+	for {
+		c, err := iter.Next()
+		if errors.Cause(err) == io.EOF {
+			break
+		} else if err != nil {
+			return CommitHistoryPage{}, err
+		}
+
+		if !started {
+			if c.Hash != startHash {
+				continue
+			}
+			started = true
+		}
+		if len(page.Commits) == limit {
+			page.Next = c.Hash.String()
+			break
+		}
+		page.Commits = append(page.Commits, CommitHistoryEntry{
+			Commit:   commitToGitCommit(c),
+			Username: resolver.resolve(ctx, c.Author),
+		})
+	}
+	if !started {
+		return CommitHistoryPage{}, errors.Errorf(
+			"Commit %s isn't in the history of %s", start, branch)
+	}
+	return page, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestGetCommitHistory(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	authors := []object.Signature{
+		{Name: "me", Email: "me@keyba.se"},
+		{Name: "User1", Email: "someone@example.com"},
+		{Name: "Someone", Email: "user2@keybase.io"},
+		{Name: "Nobody", Email: "nobody@keybase.io"},
+		{Name: "Someone Else", Email: "user1@example.com"},
+	}
+	start := time.Now().Add(-time.Hour)
+	var hashes []string
+	for i, author := range authors {
+		name := fmt.Sprintf("foo%d", i)
+		f, err := worktreeFS.Create(name)
+		require.NoError(t, err)
+		_, err = io.WriteString(f, name)
+		require.NoError(t, err)
+		err = f.Close()
+		require.NoError(t, err)
+		_, err = wt.Add(name)
+		require.NoError(t, err)
+		author.When = start.Add(time.Duration(i) * time.Minute)
+		hash, err := wt.Commit(fmt.Sprintf("commit %d", i),
+			&gogit.CommitOptions{Author: &author})
+		require.NoError(t, err)
+		hashes = append(hashes, hash.String())
+	}
+	commitWorktree(t, ctx, config, h, worktreeFS)
+
+	t.Log("Page through the history, newest first")
+	page, err := GetCommitHistory(ctx, config, h, "test", "master", "", 2)
+	require.NoError(t, err)
+	require.Len(t, page.Commits, 2)
+	require.Equal(t, hashes[4], page.Commits[0].Commit.CommitHash)
+	require.Equal(t, "commit 4", page.Commits[0].Commit.Message)
+	require.Equal(t, "Someone Else", page.Commits[0].Commit.AuthorName)
+	require.Equal(t, keybase1.Time(start.Add(4*time.Minute).Unix()),
+		page.Commits[0].Commit.Ctime)
+	require.Equal(t, "user1", page.Commits[0].Username)
+	require.Equal(t, hashes[3], page.Commits[1].Commit.CommitHash)
+	require.Equal(t, "", page.Commits[1].Username)
+	require.Equal(t, hashes[2], page.Next)
+
+	page, err = GetCommitHistory(
+		ctx, config, h, "test", "master", page.Next, 2)
+	require.NoError(t, err)
+	require.Len(t, page.Commits, 2)
+	require.Equal(t, hashes[2], page.Commits[0].Commit.CommitHash)
+	require.Equal(t, "user2", page.Commits[0].Username)
+	require.Equal(t, hashes[1], page.Commits[1].Commit.CommitHash)
+	require.Equal(t, "user1", page.Commits[1].Username)
+	require.Equal(t, hashes[0], page.Next)
+
+	page, err = GetCommitHistory(
+		ctx, config, h, "test", "master", page.Next, 2)
+	require.NoError(t, err)
+	require.Len(t, page.Commits, 1)
+	require.Equal(t, hashes[0], page.Commits[0].Commit.CommitHash)
+	require.Equal(t, "", page.Commits[0].Username)
+	require.Equal(t, "", page.Next)
+
+	t.Log("The default page size covers everything")
+	page, err = GetCommitHistory(ctx, config, h, "test", "master", "", 0)
+	require.NoError(t, err)
+	require.Len(t, page.Commits, len(authors))
+
+	t.Log("Bad starting points are rejected")
+	_, err = GetCommitHistory(ctx, config, h, "test", "master", "nope", 2)
+	require.Error(t, err)
+	_, err = GetCommitHistory(
+		ctx, config, h, "test", "master", strings.Repeat("a", 40), 2)
+	require.Error(t, err)
+	_, err = GetCommitHistory(ctx, config, h, "test", "nope", "", 2)
+	require.Error(t, err)
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// lfsObjectsDir is where LFS objects are stored, relative to the
+	// top of a bare repo.  It's the same layout git-lfs uses in a
+	// local repo.
+	lfsObjectsDir = "lfs/objects"
+	// lfsTmpDir holds LFS objects while they're being written.
+	lfsTmpDir = "lfs/tmp"
+)
+
+// lfsOIDRE matches a valid LFS object ID, i.e. the lower-case hex
+// SHA-256 hash of the object's contents.
+var lfsOIDRE = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// LFSStore stores git-lfs objects inside a bare repo in KBFS.  The
+// objects are content-addressed, so each one is only stored once per
+// repo no matter how many times it's pushed.
+type LFSStore struct {
+	fs billy.Filesystem
+}
+
+// NewLFSStore returns an LFSStore for the bare repo in `repoFS`.
+func NewLFSStore(repoFS billy.Filesystem) *LFSStore {
+	return &LFSStore{fs: repoFS}
+}
+
+func checkLFSOID(oid string) error {
+	if !lfsOIDRE.MatchString(oid) {
+		return errors.Errorf("invalid LFS object ID: %q", oid)
+	}
+	return nil
+}
+
+func lfsObjectPath(oid string) string {
+	return path.Join(lfsObjectsDir, oid[0:2], oid[2:4], oid)
+}
+
+// Size returns the size of the object `oid`, and whether it's stored
+// at all.
+func (s *LFSStore) Size(oid string) (size int64, exists bool, err error) {
+	err = checkLFSOID(oid)
+	if err != nil {
+		return 0, false, err
+	}
+	fi, err := s.fs.Stat(lfsObjectPath(oid))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return fi.Size(), true, nil
+}
+
+// Open opens the object `oid` for reading.
+func (s *LFSStore) Open(oid string) (billy.File, error) {
+	err := checkLFSOID(oid)
+	if err != nil {
+		return nil, err
+	}
+	return s.fs.Open(lfsObjectPath(oid))
+}
+
+// Put stores the `size` bytes read from `r` as the object `oid`,
+// unless it's already stored.  The data is checked against `oid`
+// before it's made visible under that name.  It returns true if the
+// object was written, and false if it was already there.
+func (s *LFSStore) Put(oid string, size int64, r io.Reader) (
+	written bool, err error) {
+	existingSize, exists, err := s.Size(oid)
+	if err != nil {
+		return false, err
+	}
+	if exists && existingSize == size {
+		return false, nil
+	}
+
+	err = s.fs.MkdirAll(lfsTmpDir, 0700)
+	if err != nil {
+		return false, err
+	}
+	f, err := s.fs.TempFile(lfsTmpDir, oid+"-")
+	if err != nil {
+		return false, err
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			_ = s.fs.Remove(tmpPath)
+		}
+	}()
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hasher), r)
+	closeErr := f.Close()
+	if err != nil {
+		return false, err
+	}
+	if closeErr != nil {
+		return false, closeErr
+	}
+	if n != size {
+		return false, errors.Errorf(
+			"LFS object %s has %d bytes, not %d", oid, n, size)
+	}
+	if sum := hex.EncodeToString(hasher.Sum(nil)); sum != oid {
+		return false, errors.Errorf(
+			"LFS object %s has the wrong hash %s", oid, sum)
+	}
+
+	objPath := lfsObjectPath(oid)
+	err = s.fs.MkdirAll(path.Dir(objPath), 0700)
+	if err != nil {
+		return false, err
+	}
+	err = s.fs.Rename(tmpPath, objPath)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
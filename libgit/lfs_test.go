@@ -0,0 +1,189 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func lfsOID(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLFSStore(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	repoFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	s := NewLFSStore(repoFS)
+
+	data := "large object"
+	oid := lfsOID(data)
+	_, exists, err := s.Size(oid)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	t.Log("Store an object, and read it back")
+	written, err := s.Put(oid, int64(len(data)), strings.NewReader(data))
+	require.NoError(t, err)
+	require.True(t, written)
+	size, exists, err := s.Size(oid)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, int64(len(data)), size)
+	testCheckFile(t, repoFS, lfsObjectPath(oid), data)
+
+	t.Log("Storing it again is a no-op")
+	written, err = s.Put(oid, int64(len(data)), strings.NewReader(data))
+	require.NoError(t, err)
+	require.False(t, written)
+
+	t.Log("Bad data is rejected, and leaves nothing behind")
+	bad := "not the object"
+	badOID := lfsOID("something else")
+	_, err = s.Put(badOID, int64(len(bad)), strings.NewReader(bad))
+	require.Error(t, err)
+	_, err = s.Put(lfsOID(bad), int64(len(bad))+1, strings.NewReader(bad))
+	require.Error(t, err)
+	_, exists, err = s.Size(badOID)
+	require.NoError(t, err)
+	require.False(t, exists)
+	fis, err := repoFS.ReadDir(lfsTmpDir)
+	require.NoError(t, err)
+	require.Len(t, fis, 0)
+
+	_, err = s.Open("../../config")
+	require.Error(t, err)
+
+	err = repoFS.SyncAll()
+	require.NoError(t, err)
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(config)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx, rootNode.GetFolderBranch().Tlf,
+		nil, keybase1.MDPriorityGit)
+	require.NoError(t, err)
+}
+
+func TestServeLFSTransfer(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	repoFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+
+	localDir, err := ioutil.TempDir(os.TempDir(), "kbfslfstest")
+	require.NoError(t, err)
+	defer os.RemoveAll(localDir)
+	data := "large object"
+	oid := lfsOID(data)
+	localPath := filepath.Join(localDir, "obj")
+	err = ioutil.WriteFile(localPath, []byte(data), 0600)
+	require.NoError(t, err)
+
+	log := logger.NewTestLogger(t)
+	var opened []bool
+	openStore := func(_ context.Context, upload bool) (*LFSStore, error) {
+		opened = append(opened, upload)
+		return NewLFSStore(repoFS), nil
+	}
+	serve := func(reqs ...lfsRequest) []lfsResponse {
+		var input bytes.Buffer
+		enc := json.NewEncoder(&input)
+		for _, req := range reqs {
+			require.NoError(t, enc.Encode(req))
+		}
+		var output bytes.Buffer
+		err := ServeLFSTransfer(
+			ctx, log, &input, &output, openStore, localDir)
+		require.NoError(t, err)
+		var resps []lfsResponse
+		scanner := bufio.NewScanner(&output)
+		for scanner.Scan() {
+			var resp lfsResponse
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &resp))
+			resps = append(resps, resp)
+		}
+		return resps
+	}
+
+	t.Log("Upload an object")
+	resps := serve(
+		lfsRequest{Event: lfsEventInit, Operation: lfsEventUpload},
+		lfsRequest{Event: lfsEventUpload, Oid: oid,
+			Size: int64(len(data)), Path: localPath},
+		lfsRequest{Event: lfsEventTerminate})
+	require.Equal(t, []bool{true}, opened)
+	require.Len(t, resps, 2)
+	require.Nil(t, resps[0].Error)
+	require.Equal(t, lfsEventComplete, resps[1].Event)
+	require.Equal(t, oid, resps[1].Oid)
+	require.Nil(t, resps[1].Error)
+	testCheckFile(t, repoFS, lfsObjectPath(oid), data)
+
+	t.Log("Download it, and fail to download a missing object")
+	missingOID := lfsOID("missing")
+	resps = serve(
+		lfsRequest{Event: lfsEventInit, Operation: lfsEventDownload},
+		lfsRequest{Event: lfsEventDownload, Oid: oid, Size: int64(len(data))},
+		lfsRequest{Event: lfsEventDownload, Oid: missingOID, Size: 1},
+		lfsRequest{Event: lfsEventTerminate})
+	require.Equal(t, []bool{true, false}, opened)
+	require.Len(t, resps, 3)
+	require.Nil(t, resps[1].Error)
+	require.Equal(t, oid, resps[1].Oid)
+	downloaded, err := ioutil.ReadFile(resps[1].Path)
+	require.NoError(t, err)
+	require.Equal(t, data, string(downloaded))
+	require.Equal(t, missingOID, resps[2].Oid)
+	require.NotNil(t, resps[2].Error)
+	require.Equal(t, "", resps[2].Path)
+
+	t.Log("An unknown operation is reported back")
+	resps = serve(lfsRequest{Event: lfsEventInit, Operation: "nope"})
+	require.Len(t, resps, 1)
+	require.NotNil(t, resps[0].Error)
+
+	err = repoFS.SyncAll()
+	require.NoError(t, err)
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(config)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx, rootNode.GetFolderBranch().Tlf,
+		nil, keybase1.MDPriorityGit)
+	require.NoError(t, err)
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/pkg/errors"
+)
+
+// The git-lfs custom transfer protocol is described at
+// https://github.com/git-lfs/git-lfs/blob/master/docs/custom-transfers.md.
+const (
+	lfsEventInit      = "init"
+	lfsEventUpload    = "upload"
+	lfsEventDownload  = "download"
+	lfsEventTerminate = "terminate"
+	lfsEventComplete  = "complete"
+
+	// lfsErrorCode is the code reported to git-lfs for any failed
+	// transfer; git-lfs doesn't interpret it.
+	lfsErrorCode = 1
+)
+
+type lfsRequest struct {
+	Event     string `json:"event"`
+	Operation string `json:"operation,omitempty"`
+	Oid       string `json:"oid,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Path      string `json:"path,omitempty"`
+}
+
+type lfsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lfsResponse struct {
+	Event string    `json:"event,omitempty"`
+	Oid   string    `json:"oid,omitempty"`
+	Path  string    `json:"path,omitempty"`
+	Error *lfsError `json:"error,omitempty"`
+}
+
+func makeLFSError(err error) *lfsError {
+	return &lfsError{Code: lfsErrorCode, Message: err.Error()}
+}
+
+// LFSOpenStoreFunc returns the store for a git-lfs transfer session.
+// `upload` is true if objects will be written to it.
+type LFSOpenStoreFunc func(
+	ctx context.Context, upload bool) (*LFSStore, error)
+
+// ServeLFSTransfer acts as a git-lfs standalone custom transfer
+// agent, reading requests from `input` and writing responses to
+// `output`, until git-lfs asks it to terminate.  The store is opened
+// with `openStore` once git-lfs says which direction the objects are
+// going.  Downloaded objects are written to new files in `tmpDir` (or
+// the default temp directory, if it's empty), which git-lfs then
+// moves into its local object store.  Uploaded objects are written
+// to the store as they arrive, but it's up to the caller to make sure
+// they're flushed once this returns.
+func ServeLFSTransfer(
+	ctx context.Context, log logger.Logger, input io.Reader,
+	output io.Writer, openStore LFSOpenStoreFunc, tmpDir string) error {
+	dec := json.NewDecoder(bufio.NewReader(input))
+	enc := json.NewEncoder(output)
+
+	var store *LFSStore
+	var upload bool
+	for {
+		var req lfsRequest
+		err := dec.Decode(&req)
+		if errors.Cause(err) == io.EOF {
+			log.CDebugf(ctx, "LFS input closed")
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var resp lfsResponse
+		switch req.Event {
+		case lfsEventInit:
+			log.CDebugf(ctx, "LFS init for %s", req.Operation)
+			switch req.Operation {
+			case lfsEventUpload, lfsEventDownload:
+				upload = req.Operation == lfsEventUpload
+				store, err = openStore(ctx, upload)
+				if err != nil {
+					resp.Error = makeLFSError(err)
+				}
+			default:
+				resp.Error = makeLFSError(errors.Errorf(
+					"unknown LFS operation: %s", req.Operation))
+			}
+		case lfsEventUpload, lfsEventDownload:
+			if store == nil {
+				return errors.Errorf("LFS %s before init", req.Event)
+			}
+			if (req.Event == lfsEventUpload) != upload {
+				return errors.Errorf(
+					"LFS %s in a session that isn't for that", req.Event)
+			}
+			resp.Event = lfsEventComplete
+			resp.Oid = req.Oid
+			if upload {
+				err = lfsUpload(ctx, log, store, req)
+			} else {
+				resp.Path, err = lfsDownload(ctx, log, store, req, tmpDir)
+			}
+			if err != nil {
+				log.CDebugf(ctx, "LFS %s of %s failed: %+v",
+					req.Event, req.Oid, err)
+				resp.Error = makeLFSError(err)
+			}
+		case lfsEventTerminate:
+			log.CDebugf(ctx, "LFS terminate")
+			return nil
+		default:
+			return errors.Errorf("unknown LFS event: %s", req.Event)
+		}
+
+		err = enc.Encode(resp)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func lfsUpload(ctx context.Context, log logger.Logger, store *LFSStore,
+	req lfsRequest) error {
+	f, err := os.Open(req.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	written, err := store.Put(req.Oid, req.Size, f)
+	if err != nil {
+		return err
+	}
+	if !written {
+		log.CDebugf(ctx, "LFS object %s is already stored", req.Oid)
+	}
+	return nil
+}
+
+func lfsDownload(ctx context.Context, log logger.Logger, store *LFSStore,
+	req lfsRequest, tmpDir string) (p string, err error) {
+	size, exists, err := store.Size(req.Oid)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", errors.Errorf("LFS object %s isn't stored", req.Oid)
+	}
+	if size != req.Size {
+		return "", errors.Errorf("LFS object %s has %d bytes, not %d",
+			req.Oid, size, req.Size)
+	}
+
+	src, err := store.Open(req.Oid)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := ioutil.TempFile(tmpDir, "kbfs-lfs-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		closeErr := dst.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(dst.Name())
+			p = ""
+		}
+	}()
+	log.CDebugf(ctx, "Downloading LFS object %s to %s", req.Oid, dst.Name())
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return "", err
+	}
+	return dst.Name(), nil
+}
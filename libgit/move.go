@@ -0,0 +1,365 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// kbfsMovedRepoName is the marker file left in place of a repo that
+// was moved to another TLF.
+const kbfsMovedRepoName = "kbfs_moved_to"
+
+// RepoMovedError is returned when looking up a repo that has been
+// moved to a different TLF.
+type RepoMovedError struct {
+	Name string
+	// NewURL is the keybase:// remote URL of the repo's new home.
+	NewURL string
+}
+
+// Error implements the error interface for RepoMovedError.
+func (e RepoMovedError) Error() string {
+	return fmt.Sprintf("The repo %s has moved to %s; update your remote "+
+		"with `git remote set-url <remote> %s`", e.Name, e.NewURL, e.NewURL)
+}
+
+// movedRepoMarker is the contents of kbfsMovedRepoName.
+type movedRepoMarker struct {
+	NewURL string
+}
+
+// repoURL is the inverse of `parseKBFSRepoURL`.
+func repoURL(tlfHandle *libkbfs.TlfHandle, repoName string) string {
+	var tlfType string
+	switch tlfHandle.Type() {
+	case tlf.Public:
+		tlfType = public
+	case tlf.Private:
+		tlfType = private
+	case tlf.SingleTeam:
+		tlfType = team
+	}
+	return kbfsRepoURLPrefix + path.Join(
+		tlfType, string(tlfHandle.GetCanonicalName()), repoName)
+}
+
+// readMovedRepoMarker returns the marker in `repoFS`, or nil if the
+// repo there hasn't been moved.
+func readMovedRepoMarker(repoFS billy.Filesystem) (*movedRepoMarker, error) {
+	f, err := repoFS.Open(kbfsMovedRepoName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var marker movedRepoMarker
+	err = json.Unmarshal(buf, &marker)
+	if err != nil {
+		return nil, err
+	}
+	return &marker, nil
+}
+
+func writeMovedRepoMarker(
+	repoFS billy.Filesystem, marker movedRepoMarker) (err error) {
+	buf, err := json.MarshalIndent(marker, "", " ")
+	if err != nil {
+		return err
+	}
+	f, err := repoFS.Create(kbfsMovedRepoName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = f.Write(buf)
+	return err
+}
+
+// copyRepoFiles copies every file in the repo rooted at `from` into
+// `to`, except for the config file and its lock file.
+func copyRepoFiles(from, to billy.Filesystem, isRoot bool) error {
+	fileInfos, err := from.ReadDir("")
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fileInfos {
+		name := fi.Name()
+		if name == "." || (isRoot &&
+			(name == kbfsConfigName || name == kbfsConfigNameTemp)) {
+			continue
+		}
+		if fi.IsDir() {
+			err := to.MkdirAll(name, 0775)
+			if err != nil {
+				return err
+			}
+			chrootFrom, err := from.Chroot(name)
+			if err != nil {
+				return err
+			}
+			chrootTo, err := to.Chroot(name)
+			if err != nil {
+				return err
+			}
+			err = copyRepoFiles(chrootFrom, chrootTo, false)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := func() error {
+			f, err := from.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			toF, err := to.Create(name)
+			if err != nil {
+				return err
+			}
+			defer toF.Close()
+			_, err = io.Copy(toF, f)
+			return err
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeRepoDirForMove makes an empty directory for the repo
+// `repoName` in the given TLF, replacing any symlink or moved-repo
+// marker that's already there.  It returns an FS for the TLF's repo
+// directory.
+func makeRepoDirForMove(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string) (*libfs.FS, error) {
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(
+		ctx, tlfHandle, libkbfs.MasterBranch)
+	if err != nil {
+		return nil, err
+	}
+	repoDir, err := lookupOrCreateDir(ctx, config, rootNode, kbfsRepoDir)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, kbfsRepoDir, "", keybase1.MDPriorityGit)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizedRepoName := normalizeRepoName(repoName)
+	_, ei, err := kbfsOps.Lookup(ctx, repoDir, normalizedRepoName)
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError:
+	case nil:
+		if ei.Type == libkbfs.Sym {
+			err = kbfsOps.RemoveEntry(ctx, repoDir, normalizedRepoName)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		repoFS, err := fs.Chroot(normalizedRepoName)
+		if err != nil {
+			return nil, err
+		}
+		marker, err := readMovedRepoMarker(repoFS)
+		if err != nil {
+			return nil, err
+		}
+		if marker == nil {
+			return nil, makeExistingRepoError(ctx, config, repoFS, repoName)
+		}
+		config.MakeLogger("").CDebugf(
+			ctx, "Overwriting moved repo %s with a new repo",
+			normalizedRepoName)
+		fi, err := fs.Stat(normalizedRepoName)
+		if err != nil {
+			return nil, err
+		}
+		err = recursiveDelete(ctx, fs, fi)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = fs.MkdirAll(normalizedRepoName, 0777)
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// MoveRepo moves the repo `repoName` in the TLF `srcHandle` into the
+// team TLF `dstHandle`, under the name `newRepoName`.  The repo keeps
+// its ID.  A marker is left behind in the old TLF, so that old
+// remotes get a `RepoMovedError` pointing to the new location; it
+// is overwritten if a new repo is created there under the same name.
+// If both handles are for the same TLF, this is just a rename.
+//
+// KBFS can't rename across TLFs, so the repo is copied to the new TLF
+// before the old one is removed.  The caller is responsible for
+// syncing and flushing the journals of both TLFs, in that order, so
+// the copy is complete before the old repo disappears.
+func MoveRepo(
+	ctx context.Context, config libkbfs.Config, srcHandle *libkbfs.TlfHandle,
+	repoName string, dstHandle *libkbfs.TlfHandle, newRepoName string) (
+	err error) {
+	if !checkValidRepoName(newRepoName, config) {
+		return errors.WithStack(libkb.InvalidRepoNameError{Name: newRepoName})
+	}
+	if dstHandle.Type() != tlf.SingleTeam {
+		return errors.Errorf("Repos can only be moved into team folders, "+
+			"not %s", dstHandle.GetCanonicalPath())
+	}
+	if srcHandle.GetCanonicalPath() == dstHandle.GetCanonicalPath() {
+		return RenameRepo(ctx, config, srcHandle, repoName, newRepoName)
+	}
+
+	// Resolve any renames, so we move the real repo directory.
+	repoFS, _, err := GetRepoAndID(ctx, config, srcHandle, repoName, "")
+	if err != nil {
+		return err
+	}
+	c, err := readConfigFile(repoFS)
+	if err != nil {
+		return err
+	}
+	srcName := normalizeRepoName(c.Name)
+
+	// Take the creation lock in the old repo, so it can't be renamed
+	// or recreated while we copy it.
+	srcFS, err := libfs.NewFS(
+		ctx, config, srcHandle, kbfsRepoDir, "", keybase1.MDPriorityGit)
+	if err != nil {
+		return err
+	}
+	srcRepoFS, err := srcFS.Chroot(srcName)
+	if err != nil {
+		return err
+	}
+	srcLockFile, err := takeConfigLock(
+		srcRepoFS.(*libfs.FS), srcHandle, c.Name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := srcLockFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	dstFS, err := makeRepoDirForMove(ctx, config, dstHandle, newRepoName)
+	if err != nil {
+		return err
+	}
+	dstRepoFS, err := dstFS.Chroot(normalizeRepoName(newRepoName))
+	if err != nil {
+		return err
+	}
+	dstLockFile, err := takeConfigLock(
+		dstRepoFS.(*libfs.FS), dstHandle, newRepoName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := dstLockFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = dstRepoFS.Stat(kbfsConfigName)
+	if err == nil {
+		// Someone else created the repo since we checked.
+		return makeExistingRepoError(ctx, config, dstRepoFS, newRepoName)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	config.MakeLogger("").CDebugf(ctx, "Moving repo %s (id=%s) from %s to %s",
+		c.Name, c.ID, srcHandle.GetCanonicalPath(),
+		dstHandle.GetCanonicalPath())
+
+	// Write the config file last, so a partial copy never looks
+	// like a usable repo.
+	err = copyRepoFiles(srcRepoFS, dstRepoFS, true)
+	if err != nil {
+		return err
+	}
+	c.Name = newRepoName
+	buf, err := c.toBytes()
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		f, err := dstRepoFS.Create(kbfsConfigName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write(buf)
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+	err = UpdateRepoMD(ctx, config, dstHandle, dstRepoFS,
+		keybase1.GitPushType_CREATEREPO, "", nil)
+	if err != nil {
+		return err
+	}
+
+	// Retire the old repo the same way deletes do, and leave the
+	// marker in its place.
+	err = DeleteRepo(ctx, config, srcHandle, srcName)
+	if err != nil {
+		return err
+	}
+	err = srcFS.MkdirAll(srcName, 0777)
+	if err != nil {
+		return err
+	}
+	markerFS, err := srcFS.Chroot(srcName)
+	if err != nil {
+		return err
+	}
+	return writeMovedRepoMarker(markerFS, movedRepoMarker{
+		NewURL: repoURL(dstHandle, newRepoName),
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+func TestMoveRepo(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	teamInfos := libkbfs.AddEmptyTeamsForTestOrBust(t, config, "t1")
+	libkbfs.AddTeamWriterForTestOrBust(
+		t, config, teamInfos[0].TID, session.UID)
+	teamH, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	dotgitFS, id, err := GetOrCreateRepoAndID(ctx, config, h, "Test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
+	head, err := repo.Head()
+	require.NoError(t, err)
+
+	t.Log("Repos can only be moved into teams")
+	err = MoveRepo(ctx, config, h, "test", h, "test2")
+	require.Error(t, err)
+	_, _, err = GetOrCreateRepoAndID(ctx, config, teamH, "taken", "")
+	require.NoError(t, err)
+	err = MoveRepo(ctx, config, h, "test", teamH, "taken")
+	require.IsType(t, libkb.RepoAlreadyExistsError{}, errors.Cause(err))
+
+	t.Log("Move the repo into the team")
+	err = MoveRepo(ctx, config, h, "test", teamH, "Moved")
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(config)
+	require.NoError(t, err)
+	for _, h := range []*libkbfs.TlfHandle{teamH, h} {
+		rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+			ctx, h, libkbfs.MasterBranch)
+		require.NoError(t, err)
+		err = config.KBFSOps().SyncAll(ctx, rootNode.GetFolderBranch())
+		require.NoError(t, err)
+		err = jServer.FinishSingleOp(ctx,
+			rootNode.GetFolderBranch().Tlf, nil, keybase1.MDPriorityGit)
+		require.NoError(t, err)
+	}
+
+	movedFS, movedID, err := GetRepoAndID(ctx, config, teamH, "moved", "")
+	require.NoError(t, err)
+	require.Equal(t, id, movedID)
+	c, err := readConfigFile(movedFS)
+	require.NoError(t, err)
+	require.Equal(t, "Moved", c.Name)
+	movedStorer, err := filesystem.NewStorage(movedFS)
+	require.NoError(t, err)
+	ref, err := movedStorer.Reference(plumbing.Master)
+	require.NoError(t, err)
+	require.Equal(t, head.Hash(), ref.Hash())
+
+	t.Log("The old name points to the new location")
+	_, _, err = GetRepoAndID(ctx, config, h, "test", "")
+	require.Equal(t, RepoMovedError{
+		Name:   "test",
+		NewURL: "keybase://team/t1/Moved",
+	}, errors.Cause(err))
+	_, _, err = GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.IsType(t, RepoMovedError{}, errors.Cause(err))
+
+	t.Log("A new repo can take the old name")
+	newID, err := CreateRepoAndID(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.NotEqual(t, id, newID)
+	_, gotID, err := GetRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	require.Equal(t, newID, gotID)
+}
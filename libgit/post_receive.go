@@ -0,0 +1,236 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+const (
+	// PostReceiveHookTypeChat is the type of a post-receive hook that
+	// posts a summary of each push to a chat channel of the repo's
+	// TLF.
+	PostReceiveHookTypeChat = "chat"
+
+	defaultPostReceiveChatChannel = "general"
+	// maxPostReceiveCommitsPerRef limits how many commits are listed
+	// for each ref in a push summary.
+	maxPostReceiveCommitsPerRef = 5
+)
+
+// PostReceiveHookConfig configures one post-receive hook of a repo.
+type PostReceiveHookConfig struct {
+	// Type selects the kind of hook, e.g. PostReceiveHookTypeChat.
+	Type string
+	// Channel is the chat channel a chat hook posts to; the empty
+	// string means the "general" channel.
+	Channel string `json:",omitempty"`
+}
+
+// PostReceiveUpdate describes the refs updated by a successful push.
+type PostReceiveUpdate struct {
+	TlfHandle *libkbfs.TlfHandle
+	// RepoName is the user-formatted name of the repo.
+	RepoName string
+	// Pusher is the name of the user who pushed.
+	Pusher string
+	Refs   RefDataByName
+}
+
+// PostReceiveHook is notified after a push has updated refs in a
+// KBFS git repo.  Hook errors are logged, but never fail the push.
+type PostReceiveHook interface {
+	PostReceive(ctx context.Context, config libkbfs.Config,
+		update PostReceiveUpdate) error
+}
+
+// PostReceiveHookFactory makes a hook from its repo configuration, or
+// returns an error if the configuration is invalid.
+type PostReceiveHookFactory func(
+	hookConfig PostReceiveHookConfig) (PostReceiveHook, error)
+
+var (
+	postReceiveHookTypesLock sync.RWMutex
+	postReceiveHookTypes     = map[string]PostReceiveHookFactory{
+		PostReceiveHookTypeChat: newChatPostReceiveHook,
+	}
+)
+
+// RegisterPostReceiveHookType makes a new type of post-receive hook
+// available to repo configs, replacing any existing factory for the
+// same type.
+func RegisterPostReceiveHookType(
+	hookType string, factory PostReceiveHookFactory) {
+	postReceiveHookTypesLock.Lock()
+	defer postReceiveHookTypesLock.Unlock()
+	postReceiveHookTypes[hookType] = factory
+}
+
+func makePostReceiveHook(
+	hookConfig PostReceiveHookConfig) (PostReceiveHook, error) {
+	postReceiveHookTypesLock.RLock()
+	factory, ok := postReceiveHookTypes[hookConfig.Type]
+	postReceiveHookTypesLock.RUnlock()
+	if !ok {
+		return nil, errors.Errorf(
+			"Unknown post-receive hook type: %q", hookConfig.Type)
+	}
+	return factory(hookConfig)
+}
+
+// GetPostReceiveHooks returns the configured post-receive hooks of
+// the given repo.
+func GetPostReceiveHooks(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string) ([]PostReceiveHookConfig, error) {
+	fs, _, err := GetRepoAndID(ctx, config, tlfHandle, repoName, "")
+	if err != nil {
+		return nil, err
+	}
+	c, err := readConfigFile(fs)
+	if err != nil {
+		return nil, err
+	}
+	return c.PostReceiveHooks, nil
+}
+
+// SetPostReceiveHooks replaces the post-receive hooks of the given
+// repo, after checking that each of them is valid.  The caller is
+// responsible for syncing the FS and flushing the journal, if
+// desired.
+func SetPostReceiveHooks(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string, hooks []PostReceiveHookConfig) error {
+	for _, hookConfig := range hooks {
+		_, err := makePostReceiveHook(hookConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	return updateRepoConfig(ctx, config, tlfHandle, repoName,
+		func(c *Config) {
+			c.PostReceiveHooks = hooks
+		})
+}
+
+// RunPostReceiveHooks runs every post-receive hook configured for
+// the repo in `repoFS`, to notify them about the refs updated by a
+// push.  It should only be called once the push has been reported to
+// git, and since a failed hook shouldn't fail the push, any errors
+// (including ones reading the hook config) are just logged.
+func RunPostReceiveHooks(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoFS billy.Filesystem, refs RefDataByName) {
+	log := config.MakeLogger("")
+	c, err := readConfigFile(repoFS)
+	if err != nil {
+		log.CDebugf(ctx, "Couldn't read post-receive hooks: %+v", err)
+		return
+	}
+	if len(c.PostReceiveHooks) == 0 {
+		return
+	}
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		log.CDebugf(ctx, "Couldn't get the pusher for post-receive "+
+			"hooks: %+v", err)
+		return
+	}
+	update := PostReceiveUpdate{
+		TlfHandle: tlfHandle,
+		RepoName:  c.Name,
+		Pusher:    session.Name.String(),
+		Refs:      refs,
+	}
+
+	for _, hookConfig := range c.PostReceiveHooks {
+		hook, err := makePostReceiveHook(hookConfig)
+		if err == nil {
+			err = hook.PostReceive(ctx, config, update)
+		}
+		if err != nil {
+			log.CDebugf(ctx, "Post-receive hook %s failed: %+v",
+				hookConfig.Type, err)
+		}
+	}
+}
+
+// chatPostReceiveHook posts a summary of each push to a chat channel.
+type chatPostReceiveHook struct {
+	channel string
+}
+
+func newChatPostReceiveHook(
+	hookConfig PostReceiveHookConfig) (PostReceiveHook, error) {
+	channel := hookConfig.Channel
+	if channel == "" {
+		channel = defaultPostReceiveChatChannel
+	}
+	if strings.ContainsAny(channel, " \t\n#") {
+		return nil, errors.Errorf("Invalid chat channel name: %q", channel)
+	}
+	return chatPostReceiveHook{channel}, nil
+}
+
+// PostReceive implements the PostReceiveHook interface for
+// chatPostReceiveHook.
+func (h chatPostReceiveHook) PostReceive(
+	ctx context.Context, config libkbfs.Config,
+	update PostReceiveUpdate) error {
+	tlfName := update.TlfHandle.GetCanonicalName()
+	tlfType := update.TlfHandle.Type()
+	convID, err := config.Chat().GetConversationID(
+		ctx, tlfName, tlfType, h.channel, chat1.TopicType_CHAT)
+	if err != nil {
+		return err
+	}
+	return config.Chat().SendTextMessage(
+		ctx, tlfName, tlfType, convID, formatPostReceiveSummary(update))
+}
+
+// formatPostReceiveSummary returns a human-readable description of
+// the refs updated by a push, listing a few commits for each ref.
+func formatPostReceiveSummary(update PostReceiveUpdate) string {
+	refNames := make([]string, 0, len(update.Refs))
+	for refName := range update.Refs {
+		refNames = append(refNames, string(refName))
+	}
+	sort.Strings(refNames)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s pushed to %s:", update.Pusher, update.RepoName)
+	for _, refName := range refNames {
+		refData := update.Refs[plumbing.ReferenceName(refName)]
+		shortName := plumbing.ReferenceName(refName).Short()
+		if refData.IsDelete {
+			fmt.Fprintf(&buf, "\n%s: deleted", shortName)
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%s:", shortName)
+		for i, c := range refData.Commits {
+			if c == CommitSentinelValue || i == maxPostReceiveCommitsPerRef {
+				buf.WriteString("\n  ...")
+				break
+			}
+			subject := strings.SplitN(
+				strings.TrimSpace(c.Message), "\n", 2)[0]
+			fmt.Fprintf(&buf, "\n  %s %s", c.Hash.String()[:7], subject)
+		}
+	}
+	return buf.String()
+}
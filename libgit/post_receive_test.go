@@ -0,0 +1,156 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// testPostReceiveChat records the chat messages sent by post-receive
+// hooks, ignoring other kinds of messages like edit notifications.
+type testPostReceiveChat struct {
+	libkbfs.Chat
+
+	lock     sync.Mutex
+	channels []string
+	messages []string
+}
+
+func (c *testPostReceiveChat) GetConversationID(
+	_ context.Context, _ tlf.CanonicalName, _ tlf.Type,
+	channelName string, chatType chat1.TopicType) (
+	chat1.ConversationID, error) {
+	if chatType != chat1.TopicType_CHAT {
+		return nil, nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.channels = append(c.channels, channelName)
+	return chat1.ConversationID(channelName), nil
+}
+
+func (c *testPostReceiveChat) SendTextMessage(
+	_ context.Context, _ tlf.CanonicalName, _ tlf.Type,
+	convID chat1.ConversationID, body string) error {
+	if convID == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.messages = append(c.messages, body)
+	return nil
+}
+
+type testPostReceiveHook struct {
+	updates chan<- PostReceiveUpdate
+	err     error
+}
+
+func (h testPostReceiveHook) PostReceive(
+	_ context.Context, _ libkbfs.Config, update PostReceiveUpdate) error {
+	if h.err != nil {
+		return h.err
+	}
+	h.updates <- update
+	return nil
+}
+
+func TestPostReceiveHooks(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	chat := &testPostReceiveChat{Chat: config.Chat()}
+	config.SetChat(chat)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	repoFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "Test", "")
+	require.NoError(t, err)
+
+	refs := RefDataByName{
+		"refs/heads/master": &RefData{
+			Commits: []*object.Commit{
+				{
+					Hash: plumbing.NewHash(
+						"2222222222222222222222222222222222222222"),
+					Message: "second\n\nmore details",
+				},
+				{
+					Hash: plumbing.NewHash(
+						"1111111111111111111111111111111111111111"),
+					Message: "first",
+				},
+				CommitSentinelValue,
+			},
+		},
+		"refs/heads/old": &RefData{IsDelete: true},
+	}
+
+	t.Log("No hooks configured")
+	RunPostReceiveHooks(ctx, config, h, repoFS, refs)
+	require.Len(t, chat.messages, 0)
+
+	t.Log("Unknown hook types are rejected")
+	err = SetPostReceiveHooks(ctx, config, h, "test",
+		[]PostReceiveHookConfig{{Type: "unknown"}})
+	require.Error(t, err)
+	err = SetPostReceiveHooks(ctx, config, h, "test",
+		[]PostReceiveHookConfig{{
+			Type:    PostReceiveHookTypeChat,
+			Channel: "#git",
+		}})
+	require.Error(t, err)
+
+	t.Log("Configure a failing hook, a chat hook and a custom one")
+	updates := make(chan PostReceiveUpdate, 1)
+	RegisterPostReceiveHookType("test", func(
+		PostReceiveHookConfig) (PostReceiveHook, error) {
+		return testPostReceiveHook{updates: updates}, nil
+	})
+	RegisterPostReceiveHookType("failing", func(
+		PostReceiveHookConfig) (PostReceiveHook, error) {
+		return testPostReceiveHook{err: errors.New("hook failed")}, nil
+	})
+	hooks := []PostReceiveHookConfig{
+		{Type: "failing"},
+		{Type: PostReceiveHookTypeChat},
+		{Type: PostReceiveHookTypeChat, Channel: "git"},
+		{Type: "test"},
+	}
+	err = SetPostReceiveHooks(ctx, config, h, "test", hooks)
+	require.NoError(t, err)
+	gotHooks, err := GetPostReceiveHooks(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.Equal(t, hooks, gotHooks)
+
+	// The failing hook doesn't stop the others from running.
+	RunPostReceiveHooks(ctx, config, h, repoFS, refs)
+	require.Equal(t, []string{"general", "git"}, chat.channels)
+	expectedMsg := "user1 pushed to Test:\n" +
+		"master:\n" +
+		"  2222222 second\n" +
+		"  1111111 first\n" +
+		"  ...\n" +
+		"old: deleted"
+	require.Equal(t, []string{expectedMsg, expectedMsg}, chat.messages)
+	update := <-updates
+	require.Equal(t, "Test", update.RepoName)
+	require.Equal(t, "user1", update.Pusher)
+	require.Equal(t, refs, update.Refs)
+}
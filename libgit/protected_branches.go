@@ -0,0 +1,200 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+const branchRefPrefix = "refs/heads/"
+
+// ProtectedBranchError is returned for a push that tries to delete,
+// or rewrite the history of, a protected branch.
+type ProtectedBranchError struct {
+	Branch   string
+	IsDelete bool
+}
+
+// Error implements the error interface for ProtectedBranchError.
+func (e ProtectedBranchError) Error() string {
+	if e.IsDelete {
+		return fmt.Sprintf(
+			"branch %s is protected and can only be deleted by a team admin",
+			e.Branch)
+	}
+	return fmt.Sprintf(
+		"branch %s is protected and can only be force-pushed by a team admin",
+		e.Branch)
+}
+
+// ProtectedBranchesChangeError is returned when someone other than a
+// team admin tries to change the protected branches of a repo in a
+// team TLF.
+type ProtectedBranchesChangeError struct {
+	RepoName string
+}
+
+// Error implements the error interface for ProtectedBranchesChangeError.
+func (e ProtectedBranchesChangeError) Error() string {
+	return fmt.Sprintf(
+		"only a team admin can change the protected branches of repo %s",
+		e.RepoName)
+}
+
+// RefUpdate describes a change that a push makes to an existing ref.
+type RefUpdate struct {
+	Name plumbing.ReferenceName
+	// IsDelete is true if the ref is being deleted.
+	IsDelete bool
+	// IsForce is true if the ref's new commit doesn't descend from
+	// its old one.
+	IsForce bool
+}
+
+// GetProtectedBranches returns the names of the protected branches of
+// the given repo, sorted.
+func GetProtectedBranches(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string) ([]string, error) {
+	fs, _, err := GetRepoAndID(ctx, config, tlfHandle, repoName, "")
+	if err != nil {
+		return nil, err
+	}
+	c, err := readConfigFile(fs)
+	if err != nil {
+		return nil, err
+	}
+	return c.ProtectedBranches, nil
+}
+
+// SetProtectedBranches replaces the set of protected branches of the
+// given repo.  Branches can be given either by name, or as full
+// "refs/heads/" ref names.  In a team TLF, only team admins can
+// change the set, whether they're adding branches to it or removing
+// them; in any other TLF, every writer can.  The caller is
+// responsible for syncing the FS and flushing the journal, if
+// desired.
+func SetProtectedBranches(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string, branches []string) error {
+	if tlfHandle.Type() == tlf.SingleTeam {
+		isAdmin, err := canRewriteProtectedBranches(ctx, config, tlfHandle)
+		if err != nil {
+			return err
+		}
+		if !isAdmin {
+			return errors.WithStack(
+				ProtectedBranchesChangeError{RepoName: repoName})
+		}
+	}
+
+	set := make(map[string]bool, len(branches))
+	for _, branch := range branches {
+		branch = strings.TrimPrefix(branch, branchRefPrefix)
+		if branch == "" {
+			return errors.New("Empty protected branch name")
+		}
+		set[branch] = true
+	}
+	var sorted []string
+	for branch := range set {
+		sorted = append(sorted, branch)
+	}
+	sort.Strings(sorted)
+
+	return updateRepoConfig(ctx, config, tlfHandle, repoName,
+		func(c *Config) {
+			c.ProtectedBranches = sorted
+		})
+}
+
+// canRewriteProtectedBranches returns true if the current user is
+// allowed to delete and force-push protected branches in the given
+// TLF.  Only admins of a team TLF can; in any other kind of TLF, the
+// branches have to be unprotected first.
+func canRewriteProtectedBranches(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle) (bool, error) {
+	if tlfHandle.Type() != tlf.SingleTeam {
+		return false, nil
+	}
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return false, err
+	}
+	tid, err := tlfHandle.FirstResolvedWriter().AsTeam()
+	if err != nil {
+		return false, err
+	}
+	return config.KBPKI().IsTeamAdmin(ctx, tid, session.UID)
+}
+
+// CheckProtectedBranches checks `updates` against the protected
+// branches of the repo in `repoFS`, and returns an error for each
+// update that the current user isn't allowed to make.  Updates that
+// aren't in the returned map can go ahead.
+//
+// Note that the KBFS servers never see refs, so this is only enforced
+// by the git remote helper on the pushing client: it keeps honest
+// team members from accidentally rewriting history, but a writer
+// with a modified client can still change any branch.
+func CheckProtectedBranches(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoFS billy.Filesystem, updates []RefUpdate) (
+	map[plumbing.ReferenceName]error, error) {
+	c, err := readConfigFile(repoFS)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.ProtectedBranches) == 0 {
+		return nil, nil
+	}
+	protected := make(map[string]bool, len(c.ProtectedBranches))
+	for _, branch := range c.ProtectedBranches {
+		protected[branch] = true
+	}
+
+	rejected := make(map[plumbing.ReferenceName]error)
+	for _, update := range updates {
+		if !update.IsDelete && !update.IsForce {
+			continue
+		}
+		if !update.Name.IsBranch() {
+			continue
+		}
+		branch := strings.TrimPrefix(update.Name.String(), branchRefPrefix)
+		if !protected[branch] {
+			continue
+		}
+		rejected[update.Name] = errors.WithStack(ProtectedBranchError{
+			Branch:   branch,
+			IsDelete: update.IsDelete,
+		})
+	}
+	if len(rejected) == 0 {
+		return nil, nil
+	}
+
+	isAdmin, err := canRewriteProtectedBranches(ctx, config, tlfHandle)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin {
+		config.MakeLogger("").CDebugf(ctx,
+			"Allowing an admin to rewrite %d protected branches in %s",
+			len(rejected), c.Name)
+		return nil, nil
+	}
+	return rejected, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestProtectedBranches(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	teamInfos := libkbfs.AddEmptyTeamsForTestOrBust(t, config, "t1")
+	libkbfs.AddTeamWriterForTestOrBust(
+		t, config, teamInfos[0].TID, session.UID)
+
+	updates := []RefUpdate{
+		{Name: "refs/heads/master", IsDelete: true},
+		{Name: "refs/heads/release", IsForce: true},
+		{Name: "refs/heads/release"},
+		{Name: "refs/heads/feature", IsForce: true},
+		{Name: "refs/tags/master", IsDelete: true},
+	}
+	check := func(h *libkbfs.TlfHandle, expectRejected bool) {
+		repoFS, _, err := GetRepoAndID(ctx, config, h, "test", "")
+		require.NoError(t, err)
+		rejected, err := CheckProtectedBranches(
+			ctx, config, h, repoFS, updates)
+		require.NoError(t, err)
+		if !expectRejected {
+			require.Len(t, rejected, 0)
+			return
+		}
+		require.Len(t, rejected, 2)
+		require.Equal(t, ProtectedBranchError{
+			Branch:   "master",
+			IsDelete: true,
+		}, errors.Cause(rejected["refs/heads/master"]))
+		require.Equal(t, ProtectedBranchError{
+			Branch: "release",
+		}, errors.Cause(rejected["refs/heads/release"]))
+	}
+
+	t.Log("Protect branches in a private TLF")
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	_, _, err = GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	check(h, false)
+	err = SetProtectedBranches(ctx, config, h, "test",
+		[]string{"release", "refs/heads/master", "master"})
+	require.NoError(t, err)
+	branches, err := GetProtectedBranches(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.Equal(t, []string{"master", "release"}, branches)
+	check(h, true)
+
+	t.Log("Only team admins can protect branches in a team TLF")
+	teamH, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+	_, _, err = GetOrCreateRepoAndID(ctx, config, teamH, "test", "")
+	require.NoError(t, err)
+	err = SetProtectedBranches(ctx, config, teamH, "test", []string{"master"})
+	require.Equal(t, ProtectedBranchesChangeError{RepoName: "test"},
+		errors.Cause(err))
+	libkbfs.AddTeamAdminForTestOrBust(
+		t, config, teamInfos[0].TID, session.UID)
+	err = SetProtectedBranches(ctx, config, teamH, "test",
+		[]string{"release", "refs/heads/master", "master"})
+	require.NoError(t, err)
+	branches, err = GetProtectedBranches(ctx, config, teamH, "test")
+	require.NoError(t, err)
+	require.Equal(t, []string{"master", "release"}, branches)
+
+	t.Log("Team admins can rewrite protected branches")
+	check(teamH, false)
+
+	t.Log("Other team writers can't rewrite or unprotect them")
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, teamH, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	err = config.KBFSOps().SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(config)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx,
+		rootNode.GetFolderBranch().Tlf, nil, keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	config2 := libkbfs.ConfigAsUser(config, "user2")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config2)
+	session2, err := config2.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	_ = libkbfs.AddEmptyTeamsForTestOrBust(t, config2, "t1")
+	libkbfs.AddTeamAdminForTestOrBust(
+		t, config2, teamInfos[0].TID, session.UID)
+	libkbfs.AddTeamWriterForTestOrBust(
+		t, config2, teamInfos[0].TID, session2.UID)
+	teamH2, err := libkbfs.ParseTlfHandle(
+		ctx, config2.KBPKI(), config2.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+	check2 := func() {
+		repoFS, _, err := GetRepoAndID(ctx, config2, teamH2, "test", "")
+		require.NoError(t, err)
+		rejected, err := CheckProtectedBranches(
+			ctx, config2, teamH2, repoFS, updates)
+		require.NoError(t, err)
+		require.Len(t, rejected, 2)
+	}
+	check2()
+	err = SetProtectedBranches(ctx, config2, teamH2, "test", nil)
+	require.Equal(t, ProtectedBranchesChangeError{RepoName: "test"},
+		errors.Cause(err))
+	branches, err = GetProtectedBranches(ctx, config2, teamH2, "test")
+	require.NoError(t, err)
+	require.Equal(t, []string{"master", "release"}, branches)
+	check2()
+
+	t.Log("Unprotect the branches")
+	err = SetProtectedBranches(ctx, config, teamH, "test", nil)
+	require.NoError(t, err)
+	branches, err = GetProtectedBranches(ctx, config, teamH, "test")
+	require.NoError(t, err)
+	require.Len(t, branches, 0)
+
+	err = SetProtectedBranches(ctx, config, teamH, "test", []string{""})
+	require.Error(t, err)
+	_, err = GetProtectedBranches(ctx, config, teamH, "nope")
+	require.Error(t, err)
+
+	err = SetProtectedBranches(ctx, config, teamH, "test",
+		[]string{plumbing.Master.String()})
+	require.NoError(t, err)
+	err = config.KBFSOps().SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+}
@@ -0,0 +1,402 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// folderQuotaFileName holds the quota shared by all the repos in
+	// a TLF, relative to kbfsRepoDir.  It can't collide with a repo
+	// name, since those can't start with a dot.
+	folderQuotaFileName = ".quota"
+
+	packIdxFanoutLen = 256 * 4
+)
+
+// packIdxV2Magic starts every version 2 pack index file.  Version 1
+// files start directly with the fanout table.
+var packIdxV2Magic = []byte{0xff, 't', 'O', 'c'}
+
+// RepoQuota limits the size of a repo, or of all the repos in a TLF.
+// A zero field means there's no limit of that kind.
+type RepoQuota struct {
+	MaxBytes   int64 `json:",omitempty"`
+	MaxObjects int64 `json:",omitempty"`
+}
+
+func (q *RepoQuota) isSet() bool {
+	return q != nil && (q.MaxBytes > 0 || q.MaxObjects > 0)
+}
+
+// RepoSizeInfo describes how much space the git objects in a repo
+// use.
+type RepoSizeInfo struct {
+	Bytes      int64
+	NumObjects int64
+}
+
+func (rsi RepoSizeInfo) add(other RepoSizeInfo) RepoSizeInfo {
+	return RepoSizeInfo{
+		Bytes:      rsi.Bytes + other.Bytes,
+		NumObjects: rsi.NumObjects + other.NumObjects,
+	}
+}
+
+// QuotaScope says which quota a push ran into.
+type QuotaScope string
+
+const (
+	// QuotaScopeRepo is the quota of a single repo.
+	QuotaScopeRepo QuotaScope = "repo"
+	// QuotaScopeFolder is the quota shared by all the repos in a TLF
+	// (e.g., a team).
+	QuotaScopeFolder QuotaScope = "folder"
+)
+
+// QuotaExceededError is returned when a push would take a repo, or
+// the set of repos in a TLF, over its quota.
+type QuotaExceededError struct {
+	Scope QuotaScope
+	// Unit is either "bytes" or "objects".
+	Unit     string
+	Used     int64
+	Incoming int64
+	Max      int64
+}
+
+// Error implements the error interface for QuotaExceededError.  The
+// message is a single line, so the git remote helper can hand it
+// straight back to git.
+func (e QuotaExceededError) Error() string {
+	return fmt.Sprintf(
+		"push exceeds the %s git quota: %d %s used + %d %s pushed > %d %s",
+		e.Scope, e.Used, e.Unit, e.Incoming, e.Unit, e.Max, e.Unit)
+}
+
+func countLooseObjects(fs billy.Filesystem) (n int64, err error) {
+	fis, err := fs.ReadDir("objects")
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	for _, fi := range fis {
+		// Loose objects live in directories named after the first
+		// byte of their hash.
+		if !fi.IsDir() || len(fi.Name()) != 2 {
+			continue
+		}
+		objFIs, err := fs.ReadDir(path.Join("objects", fi.Name()))
+		if err != nil {
+			return 0, err
+		}
+		for _, objFI := range objFIs {
+			if !objFI.IsDir() && len(objFI.Name()) == 38 {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// countPackIdxObjects returns the number of objects in a pack, which
+// is the last entry of the fanout table in its index file.
+func countPackIdxObjects(fs billy.Filesystem, p string) (int64, error) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	buf := make([]byte, len(packIdxV2Magic)+4+packIdxFanoutLen)
+	_, err = io.ReadFull(f, buf)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading pack index %s", p)
+	}
+	fanout := buf[:packIdxFanoutLen]
+	if bytes.Equal(buf[:len(packIdxV2Magic)], packIdxV2Magic) {
+		fanout = buf[len(packIdxV2Magic)+4:]
+	}
+	return int64(binary.BigEndian.Uint32(fanout[packIdxFanoutLen-4:])), nil
+}
+
+// SizeInfo returns the current size of the git objects stored in the
+// bare repo in `fs`, both loose and packed.
+func SizeInfo(fs billy.Filesystem) (info RepoSizeInfo, err error) {
+	info.Bytes, err = dirSize(fs, "objects")
+	if err != nil {
+		return RepoSizeInfo{}, err
+	}
+	info.NumObjects, err = countLooseObjects(fs)
+	if err != nil {
+		return RepoSizeInfo{}, err
+	}
+
+	packDir := path.Join("objects", "pack")
+	fis, err := fs.ReadDir(packDir)
+	if os.IsNotExist(err) {
+		return info, nil
+	} else if err != nil {
+		return RepoSizeInfo{}, err
+	}
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".idx") {
+			continue
+		}
+		n, err := countPackIdxObjects(fs, path.Join(packDir, fi.Name()))
+		if err != nil {
+			return RepoSizeInfo{}, err
+		}
+		info.NumObjects += n
+	}
+	return info, nil
+}
+
+// GetRepoSizeInfo returns the current size of the git objects in the
+// given repo.
+func GetRepoSizeInfo(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string) (RepoSizeInfo, error) {
+	fs, _, err := GetRepoAndID(ctx, config, tlfHandle, repoName, "")
+	if err != nil {
+		return RepoSizeInfo{}, err
+	}
+	return SizeInfo(fs)
+}
+
+func getFolderSizeInfo(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle) (info RepoSizeInfo, err error) {
+	repos, err := listRepos(ctx, config, tlfHandle)
+	if err != nil {
+		return RepoSizeInfo{}, err
+	}
+	for _, repoName := range repos {
+		repoInfo, err := GetRepoSizeInfo(ctx, config, tlfHandle, repoName)
+		if err != nil {
+			return RepoSizeInfo{}, err
+		}
+		info = info.add(repoInfo)
+	}
+	return info, nil
+}
+
+func readConfigFile(repoFS billy.Filesystem) (*Config, error) {
+	f, err := repoFS.Open(kbfsConfigName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return configFromBytes(buf)
+}
+
+// GetRepoQuota returns the quota of the given repo, or nil if it
+// doesn't have one.
+func GetRepoQuota(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string) (*RepoQuota, error) {
+	fs, _, err := GetRepoAndID(ctx, config, tlfHandle, repoName, "")
+	if err != nil {
+		return nil, err
+	}
+	c, err := readConfigFile(fs)
+	if err != nil {
+		return nil, err
+	}
+	return c.Quota, nil
+}
+
+// SetRepoQuota sets the quota of the given repo.  A nil `quota`
+// removes it.  The caller is responsible for syncing the FS and
+// flushing the journal, if desired.
+func SetRepoQuota(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string, quota *RepoQuota) error {
+	if !quota.isSet() {
+		quota = nil
+	}
+	return updateRepoConfig(ctx, config, tlfHandle, repoName,
+		func(c *Config) {
+			c.Quota = quota
+		})
+}
+
+// GetFolderRepoQuota returns the quota shared by all the repos in
+// the given TLF, or nil if there isn't one.
+func GetFolderRepoQuota(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle) (*RepoQuota, error) {
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, kbfsRepoDir, "", keybase1.MDPriorityGit)
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError:
+		return nil, nil
+	case nil:
+	default:
+		return nil, err
+	}
+	f, err := fs.Open(folderQuotaFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var quota RepoQuota
+	err = json.Unmarshal(buf, &quota)
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// SetFolderRepoQuota sets the quota shared by all the repos in the
+// given TLF.  A nil `quota` removes it.  The caller is responsible
+// for syncing the FS and flushing the journal, if desired.
+func SetFolderRepoQuota(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	quota *RepoQuota) (err error) {
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, tlfHandle, libkbfs.MasterBranch)
+	if err != nil {
+		return err
+	}
+	ctx = context.WithValue(ctx, libkbfs.CtxAllowNameKey, kbfsRepoDir)
+	_, err = lookupOrCreateDir(ctx, config, rootNode, kbfsRepoDir)
+	if err != nil {
+		return err
+	}
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, kbfsRepoDir, "", keybase1.MDPriorityGit)
+	if err != nil {
+		return err
+	}
+
+	if !quota.isSet() {
+		err = fs.Remove(folderQuotaFileName)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	buf, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+	f, err := fs.Create(folderQuotaFileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = f.Write(buf)
+	return err
+}
+
+func checkQuota(scope QuotaScope, quota *RepoQuota,
+	used, incoming RepoSizeInfo) error {
+	if quota.MaxBytes > 0 && used.Bytes+incoming.Bytes > quota.MaxBytes {
+		return errors.WithStack(QuotaExceededError{
+			Scope:    scope,
+			Unit:     "bytes",
+			Used:     used.Bytes,
+			Incoming: incoming.Bytes,
+			Max:      quota.MaxBytes,
+		})
+	}
+	if quota.MaxObjects > 0 &&
+		used.NumObjects+incoming.NumObjects > quota.MaxObjects {
+		return errors.WithStack(QuotaExceededError{
+			Scope:    scope,
+			Unit:     "objects",
+			Used:     used.NumObjects,
+			Incoming: incoming.NumObjects,
+			Max:      quota.MaxObjects,
+		})
+	}
+	return nil
+}
+
+// PushSizeFunc returns the size of the objects a push will add to a
+// repo.  It's only called if the repo or its TLF has a quota.
+type PushSizeFunc func() (RepoSizeInfo, error)
+
+// CheckPushQuota returns a QuotaExceededError if adding the objects
+// described by `pushSize` to the repo in `repoFS` would exceed the
+// quota of either the repo or its TLF.  It must be called before the
+// push writes anything, since a locked write flushes the journal.
+func CheckPushQuota(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoFS billy.Filesystem, pushSize PushSizeFunc) error {
+	c, err := readConfigFile(repoFS)
+	if err != nil {
+		return err
+	}
+	folderQuota, err := GetFolderRepoQuota(ctx, config, tlfHandle)
+	if err != nil {
+		return err
+	}
+	if !c.Quota.isSet() && !folderQuota.isSet() {
+		return nil
+	}
+
+	incoming, err := pushSize()
+	if err != nil {
+		return err
+	}
+	config.MakeLogger("").CDebugf(ctx,
+		"Checking the quota for a push of %d bytes and %d objects into %s",
+		incoming.Bytes, incoming.NumObjects, c.Name)
+
+	if c.Quota.isSet() {
+		used, err := SizeInfo(repoFS)
+		if err != nil {
+			return err
+		}
+		err = checkQuota(QuotaScopeRepo, c.Quota, used, incoming)
+		if err != nil {
+			return err
+		}
+	}
+	if folderQuota.isSet() {
+		used, err := getFolderSizeInfo(ctx, config, tlfHandle)
+		if err != nil {
+			return err
+		}
+		err = checkQuota(QuotaScopeFolder, folderQuota, used, incoming)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
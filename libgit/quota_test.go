@@ -0,0 +1,153 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	gogit "gopkg.in/src-d/go-git.v4"
+)
+
+func TestSizeInfoPacked(t *testing.T) {
+	git, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git)
+
+	makeLocalRepoWithOneFile(t, git, "foo", "hello", "")
+	dotgit := filepath.Join(git, ".git")
+	dotgitFS := osfs.New(dotgit)
+	loose, err := SizeInfo(dotgitFS)
+	require.NoError(t, err)
+	// A commit, a tree and a blob.
+	require.Equal(t, int64(3), loose.NumObjects)
+	require.True(t, loose.Bytes > 0)
+
+	gitExec(t, dotgit, git, "gc", "--prune=now")
+	packed, err := SizeInfo(dotgitFS)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), packed.NumObjects)
+	require.True(t, packed.Bytes > 0)
+}
+
+func TestCheckPushQuota(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	info, err := SizeInfo(dotgitFS)
+	require.NoError(t, err)
+	require.Equal(t, RepoSizeInfo{}, info)
+
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
+	used, err := GetRepoSizeInfo(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), used.NumObjects)
+	require.True(t, used.Bytes > 0)
+
+	sizeCalls := 0
+	pushSize := func(incoming RepoSizeInfo) PushSizeFunc {
+		return func() (RepoSizeInfo, error) {
+			sizeCalls++
+			return incoming, nil
+		}
+	}
+	check := func(incoming RepoSizeInfo) error {
+		fs, _, err := GetRepoAndID(ctx, config, h, "test", "")
+		require.NoError(t, err)
+		return CheckPushQuota(ctx, config, h, fs, pushSize(incoming))
+	}
+
+	t.Log("Without a quota, the push isn't even sized")
+	err = check(RepoSizeInfo{NumObjects: 100})
+	require.NoError(t, err)
+	require.Equal(t, 0, sizeCalls)
+
+	t.Log("A repo quota limits the number of objects")
+	quota, err := GetRepoQuota(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.Nil(t, quota)
+	err = SetRepoQuota(ctx, config, h, "test", &RepoQuota{MaxObjects: 4})
+	require.NoError(t, err)
+	quota, err = GetRepoQuota(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.Equal(t, &RepoQuota{MaxObjects: 4}, quota)
+	err = check(RepoSizeInfo{NumObjects: 1})
+	require.NoError(t, err)
+	err = check(RepoSizeInfo{NumObjects: 2})
+	require.Equal(t, QuotaExceededError{
+		Scope:    QuotaScopeRepo,
+		Unit:     "objects",
+		Used:     3,
+		Incoming: 2,
+		Max:      4,
+	}, errors.Cause(err))
+	require.Equal(t, 2, sizeCalls)
+
+	t.Log("A folder quota limits the bytes of all the repos")
+	err = SetRepoQuota(ctx, config, h, "test", nil)
+	require.NoError(t, err)
+	quota, err = GetRepoQuota(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.Nil(t, quota)
+	_, _, err = GetOrCreateRepoAndID(ctx, config, h, "test2", "")
+	require.NoError(t, err)
+	err = SetFolderRepoQuota(ctx, config, h, &RepoQuota{MaxBytes: used.Bytes})
+	require.NoError(t, err)
+	quota, err = GetFolderRepoQuota(ctx, config, h)
+	require.NoError(t, err)
+	require.Equal(t, &RepoQuota{MaxBytes: used.Bytes}, quota)
+	err = check(RepoSizeInfo{})
+	require.NoError(t, err)
+	err = check(RepoSizeInfo{Bytes: 1})
+	qErr, ok := errors.Cause(err).(QuotaExceededError)
+	require.True(t, ok)
+	require.Equal(t, QuotaScopeFolder, qErr.Scope)
+	require.Equal(t, "bytes", qErr.Unit)
+
+	err = SetFolderRepoQuota(ctx, config, h, nil)
+	require.NoError(t, err)
+	quota, err = GetFolderRepoQuota(ctx, config, h)
+	require.NoError(t, err)
+	require.Nil(t, quota)
+	err = check(RepoSizeInfo{Bytes: 1})
+	require.NoError(t, err)
+
+	err = rootFS.SyncAll()
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(config)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx, rootFS.RootNode().GetFolderBranch().Tlf,
+		nil, keybase1.MDPriorityGit)
+	require.NoError(t, err)
+}
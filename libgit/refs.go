@@ -0,0 +1,215 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+const (
+	// kbfsRefMetadataName holds metadata about the refs in a repo
+	// that git itself doesn't track.
+	kbfsRefMetadataName = "kbfs_ref_metadata"
+	// recentForcePushAge is how long a force-push is considered
+	// recent, for the purposes of warning users about it.
+	recentForcePushAge = 7 * 24 * time.Hour
+)
+
+// refMetadata is the per-ref data stored in kbfsRefMetadataName.
+type refMetadata struct {
+	// LastForcePush is the unix nanosecond time of the most recent
+	// non-fast-forward push to the ref, by the pusher's clock.
+	LastForcePush int64 `json:",omitempty"`
+}
+
+func readRefMetadata(repoFS billy.Filesystem) (
+	map[plumbing.ReferenceName]refMetadata, error) {
+	f, err := repoFS.Open(kbfsRefMetadataName)
+	if os.IsNotExist(err) {
+		return make(map[plumbing.ReferenceName]refMetadata), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	md := make(map[plumbing.ReferenceName]refMetadata)
+	err = json.Unmarshal(buf, &md)
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// RecordForcePushes notes that each of `refs` in the repo in `repoFS`
+// was just force-pushed, at time `now`.  The caller is responsible
+// for syncing the FS and flushing the journal, if desired.
+func RecordForcePushes(repoFS billy.Filesystem,
+	refs []plumbing.ReferenceName, now time.Time) (err error) {
+	if len(refs) == 0 {
+		return nil
+	}
+	md, err := readRefMetadata(repoFS)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		refMD := md[ref]
+		refMD.LastForcePush = now.UnixNano()
+		md[ref] = refMD
+	}
+	buf, err := json.MarshalIndent(md, "", " ")
+	if err != nil {
+		return err
+	}
+	f, err := repoFS.Create(kbfsRefMetadataName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = f.Write(buf)
+	return err
+}
+
+// IsFastForward returns true if the commit `newHash` descends from
+// the commit `oldHash`, i.e. if moving a ref from `oldHash` to
+// `newHash` doesn't rewrite any history.  Both commits are looked up
+// in `s`; if `oldHash` isn't there, it can't be an ancestor.
+func IsFastForward(s storer.EncodedObjectStorer,
+	oldHash, newHash plumbing.Hash) (bool, error) {
+	if oldHash == newHash {
+		return true, nil
+	}
+	err := s.HasEncodedObject(oldHash)
+	if errors.Cause(err) == plumbing.ErrObjectNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	newCommit, err := object.GetCommit(s, newHash)
+	if err != nil {
+		return false, err
+	}
+	iter := object.NewCommitPreorderIter(newCommit, nil, nil)
+	defer iter.Close()
+	for {
+		c, err := iter.Next()
+		if errors.Cause(err) == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if c.Hash == oldHash {
+			return true, nil
+		}
+	}
+}
+
+// RefInfo describes one ref in a repo.
+type RefInfo struct {
+	Name plumbing.ReferenceName
+	// Hash is the object the ref points to.
+	Hash string
+	// LastForcePush is the time of the most recent non-fast-forward
+	// push to the ref, or zero if there hasn't been one.
+	LastForcePush time.Time
+	// RecentlyForcePushed is true if LastForcePush is recent enough
+	// that clients should warn about it.
+	RecentlyForcePushed bool
+}
+
+// IsBranch returns true if the ref is a branch.
+func (ri RefInfo) IsBranch() bool {
+	return ri.Name.IsBranch()
+}
+
+// IsTag returns true if the ref is a tag.
+func (ri RefInfo) IsTag() bool {
+	return ri.Name.IsTag()
+}
+
+// RefListing lists the refs in a repo.
+type RefListing struct {
+	// Head is the name of the ref that HEAD points to, or empty if
+	// the repo doesn't have a symbolic HEAD.
+	Head plumbing.ReferenceName
+	// Refs holds every ref other than HEAD, sorted by name.
+	Refs []RefInfo
+}
+
+// ListRefs returns all of the refs in the repo named `repoName` in the
+// TLF `h`.
+func ListRefs(
+	ctx context.Context, config libkbfs.Config, h *libkbfs.TlfHandle,
+	repoName string) (listing RefListing, err error) {
+	repoFS, _, err := GetRepoAndID(ctx, config, h, repoName, "")
+	if err != nil {
+		return RefListing{}, err
+	}
+	repoStorer, err := filesystem.NewStorage(repoFS)
+	if err != nil {
+		return RefListing{}, err
+	}
+	md, err := readRefMetadata(repoFS)
+	if err != nil {
+		return RefListing{}, err
+	}
+
+	refs, err := repoStorer.IterReferences()
+	if err != nil {
+		return RefListing{}, err
+	}
+	now := config.Clock().Now()
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() == plumbing.HEAD {
+			if ref.Type() == plumbing.SymbolicReference {
+				listing.Head = ref.Target()
+			}
+			return nil
+		}
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		info := RefInfo{
+			Name: ref.Name(),
+			Hash: ref.Hash().String(),
+		}
+		if t := md[ref.Name()].LastForcePush; t != 0 {
+			info.LastForcePush = time.Unix(0, t)
+			info.RecentlyForcePushed =
+				now.Sub(info.LastForcePush) < recentForcePushAge
+		}
+		listing.Refs = append(listing.Refs, info)
+		return nil
+	})
+	if err != nil {
+		return RefListing{}, err
+	}
+	sort.Slice(listing.Refs, func(i, j int) bool {
+		return listing.Refs[i].Name < listing.Refs[j].Name
+	})
+	return listing, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestListRefs(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+
+	addFileToWorktree(t, repo, worktreeFS, "foo", "hello")
+	head1, err := repo.Head()
+	require.NoError(t, err)
+	addFileToWorktree(t, repo, worktreeFS, "foo2", "hello2")
+	head2, err := repo.Head()
+	require.NoError(t, err)
+
+	t.Log("Check fast-forwards")
+	ff, err := IsFastForward(dotgitStorage, head1.Hash(), head2.Hash())
+	require.NoError(t, err)
+	require.True(t, ff)
+	ff, err = IsFastForward(dotgitStorage, head2.Hash(), head1.Hash())
+	require.NoError(t, err)
+	require.False(t, ff)
+	ff, err = IsFastForward(
+		dotgitStorage, plumbing.NewHash("abcd"), head1.Hash())
+	require.NoError(t, err)
+	require.False(t, ff)
+
+	err = dotgitStorage.SetReference(plumbing.NewHashReference(
+		plumbing.ReferenceName("refs/heads/b1"), head1.Hash()))
+	require.NoError(t, err)
+	err = dotgitStorage.SetReference(plumbing.NewHashReference(
+		plumbing.ReferenceName("refs/tags/v1"), head1.Hash()))
+	require.NoError(t, err)
+	now := config.Clock().Now()
+	err = RecordForcePushes(dotgitFS, []plumbing.ReferenceName{
+		"refs/heads/b1"}, now)
+	require.NoError(t, err)
+	err = RecordForcePushes(dotgitFS, []plumbing.ReferenceName{
+		"refs/tags/v1"}, now.Add(-2*recentForcePushAge))
+	require.NoError(t, err)
+	commitWorktree(t, ctx, config, h, worktreeFS)
+
+	t.Log("List the refs")
+	listing, err := ListRefs(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.Equal(t, plumbing.ReferenceName("refs/heads/master"), listing.Head)
+	require.Len(t, listing.Refs, 3)
+
+	b1 := listing.Refs[0]
+	require.Equal(t, plumbing.ReferenceName("refs/heads/b1"), b1.Name)
+	require.Equal(t, head1.Hash().String(), b1.Hash)
+	require.True(t, b1.IsBranch())
+	require.Equal(t, now.UnixNano(), b1.LastForcePush.UnixNano())
+	require.True(t, b1.RecentlyForcePushed)
+
+	master := listing.Refs[1]
+	require.Equal(t, plumbing.ReferenceName("refs/heads/master"), master.Name)
+	require.Equal(t, head2.Hash().String(), master.Hash)
+	require.True(t, master.IsBranch())
+	require.True(t, master.LastForcePush.IsZero())
+	require.False(t, master.RecentlyForcePushed)
+
+	v1 := listing.Refs[2]
+	require.Equal(t, plumbing.ReferenceName("refs/tags/v1"), v1.Name)
+	require.True(t, v1.IsTag())
+	require.False(t, v1.IsBranch())
+	require.False(t, v1.LastForcePush.IsZero())
+	require.False(t, v1.RecentlyForcePushed)
+}
@@ -7,7 +7,6 @@ package libgit
 import (
 	"bytes"
 	"context"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -232,13 +231,7 @@ func UpdateRepoMD(ctx context.Context, config libkbfs.Config,
 				hasMoreCommits = true
 				break
 			}
-			kbCommits = append(kbCommits, keybase1.GitCommit{
-				CommitHash:  hex.EncodeToString(c.Hash[:]),
-				Message:     c.Message,
-				AuthorName:  c.Author.Name,
-				AuthorEmail: c.Author.Email,
-				Ctime:       keybase1.Time(c.Author.When.Unix()),
-			})
+			kbCommits = append(kbCommits, commitToGitCommit(c))
 		}
 		gitRefMetadata = append(gitRefMetadata, keybase1.GitRefMetadata{
 			RefName:              string(refName),
@@ -497,11 +490,36 @@ func getOrCreateRepoAndID(
 	if err != nil {
 		return nil, NullID, err
 	}
+	// go-git reads packfiles at random offsets, so prefetching their
+	// blocks would mostly waste bandwidth.
+	fs.SetPrefetchHint("objects/pack", libkbfs.PrefetchHintNone)
 
 	f, err := fs.Open(kbfsConfigName)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, NullID, err
 	} else if os.IsNotExist(err) {
+		// If the repo was moved to another TLF, point the user
+		// there, unless they're explicitly creating a new repo in
+		// its place.
+		marker, err := readMovedRepoMarker(fs)
+		if err != nil {
+			return nil, NullID, err
+		}
+		if marker != nil && op != createOnly {
+			return nil, NullID, errors.WithStack(RepoMovedError{
+				Name:   repoName,
+				NewURL: marker.NewURL,
+			})
+		} else if marker != nil {
+			config.MakeLogger("").CDebugf(
+				ctx, "Overwriting moved repo %s with a new repo",
+				normalizedRepoName)
+			err = fs.Remove(kbfsMovedRepoName)
+			if err != nil {
+				return nil, NullID, err
+			}
+		}
+
 		if op == getOnly {
 			return nil, NullID, errors.WithStack(libkb.RepoDoesntExistError{Name: repoName})
 		}
@@ -651,10 +669,10 @@ func DeleteRepo(
 		normalizedRepoName+dirSuffix)
 }
 
-func renameRepoInConfigFile(
-	ctx context.Context, repoFS billy.Filesystem, newRepoName string) error {
-	// Assume lock file is already taken for both the old repo and the
-	// new one.
+// updateConfigFile rewrites the config file in `repoFS` after passing
+// its contents through `update`.  The caller must hold the config
+// lock for the repo.
+func updateConfigFile(repoFS billy.Filesystem, update func(c *Config)) error {
 	f, err := repoFS.OpenFile(kbfsConfigName, os.O_RDWR, 0600)
 	if err != nil {
 		return err
@@ -668,7 +686,7 @@ func renameRepoInConfigFile(
 	if err != nil {
 		return err
 	}
-	c.Name = newRepoName
+	update(c)
 	buf, err = c.toBytes()
 	if err != nil {
 		return err
@@ -688,6 +706,50 @@ func renameRepoInConfigFile(
 	return nil
 }
 
+// updateRepoConfig passes the config of the given repo through
+// `update`, while holding the repo's config lock.
+func updateRepoConfig(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string, update func(c *Config)) (err error) {
+	// Make sure the repo exists first.
+	_, _, err = GetRepoAndID(ctx, config, tlfHandle, repoName, "")
+	if err != nil {
+		return err
+	}
+
+	// Take the same lock as repo creation and renames, which is
+	// namespaced by the repo's path rather than its ID.
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, kbfsRepoDir, "", keybase1.MDPriorityGit)
+	if err != nil {
+		return err
+	}
+	repoFS, err := fs.Chroot(normalizeRepoName(repoName))
+	if err != nil {
+		return err
+	}
+	lockFile, err := takeConfigLock(repoFS.(*libfs.FS), tlfHandle, repoName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := lockFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	return updateConfigFile(repoFS, update)
+}
+
+func renameRepoInConfigFile(
+	ctx context.Context, repoFS billy.Filesystem, newRepoName string) error {
+	// Assume lock file is already taken for both the old repo and the
+	// new one.
+	return updateConfigFile(repoFS, func(c *Config) {
+		c.Name = newRepoName
+	})
+}
+
 // RenameRepo renames the repo from an old name to a new name.  It
 // leaves a symlink behind so that old remotes will continue to work.
 // The caller is responsible for syncing the FS and flushing the
@@ -782,8 +844,28 @@ func RenameRepo(
 			if err != nil {
 				return err
 			}
-			// Someone else already created and initialized the repo.
-			return makeExistingRepoError(ctx, config, newRepoFS, newRepoName)
+			marker, err := readMovedRepoMarker(newRepoFS)
+			if err != nil {
+				return err
+			}
+			if marker == nil {
+				// Someone else already created and initialized the repo.
+				return makeExistingRepoError(
+					ctx, config, newRepoFS, newRepoName)
+			}
+			// The repo that used to be here was moved to another
+			// TLF, so it's fine to replace its marker.
+			config.MakeLogger("").CDebugf(
+				ctx, "Overwriting moved repo %s with a renamed repo",
+				normalizedNewRepoName)
+			fi, err := fs.Stat(normalizedNewRepoName)
+			if err != nil {
+				return err
+			}
+			err = recursiveDelete(ctx, fs, fi)
+			if err != nil {
+				return err
+			}
 		}
 	default:
 		return err
@@ -5,6 +5,7 @@
 package libgit
 
 import (
+	"io"
 	"os"
 	"time"
 
@@ -23,25 +24,35 @@ type RPCHandler struct {
 	config         libkbfs.Config
 	kbfsInitParams *libkbfs.InitParams
 	log            logger.Logger
+	gcManager      *GCManager
 }
 
 // NewRPCHandlerWithCtx returns a new instance of a Git RPC handler.
 func NewRPCHandlerWithCtx(kbCtx libkbfs.Context, config libkbfs.Config,
 	kbfsInitParams *libkbfs.InitParams) (*RPCHandler, func()) {
-	shutdown := StartAutogit(kbCtx, config, kbfsInitParams, 10)
+	shutdownAutogit := StartAutogit(kbCtx, config, kbfsInitParams, 10)
+	gm := NewGCManager(config, kbCtx, kbfsInitParams, 1)
+	shutdown := func() {
+		shutdownAutogit()
+		gm.Shutdown()
+	}
 	return &RPCHandler{
 		kbCtx:          kbCtx,
 		config:         config,
 		kbfsInitParams: kbfsInitParams,
 		log:            config.MakeLogger(""),
+		gcManager:      gm,
 	}, shutdown
 }
 
 var _ keybase1.KBFSGitInterface = (*RPCHandler)(nil)
 
-func (rh *RPCHandler) waitForJournal(
+// waitForJournal flushes everything written to the journal of `h`
+// in `gitConfig` as a single revision, and waits for it to reach the
+// server.
+func waitForJournal(
 	ctx context.Context, gitConfig libkbfs.Config,
-	h *libkbfs.TlfHandle) error {
+	h *libkbfs.TlfHandle, log logger.Logger) error {
 	err := CleanOldDeletedReposTimeLimited(ctx, gitConfig, h)
 	if err != nil {
 		return err
@@ -60,13 +71,13 @@ func (rh *RPCHandler) waitForJournal(
 
 	jServer, err := libkbfs.GetJournalServer(gitConfig)
 	if err != nil {
-		rh.log.CDebugf(ctx, "No journal server: %+v", err)
+		log.CDebugf(ctx, "No journal server: %+v", err)
 		return nil
 	}
 
 	_, err = jServer.JournalStatus(rootNode.GetFolderBranch().Tlf)
 	if err != nil {
-		rh.log.CDebugf(ctx, "No journal: %+v", err)
+		log.CDebugf(ctx, "No journal: %+v", err)
 		return nil
 	}
 
@@ -87,7 +98,7 @@ func (rh *RPCHandler) waitForJournal(
 	}
 
 	if status.RevisionStart != kbfsmd.RevisionUninitialized {
-		rh.log.CDebugf(ctx, "Journal status: %+v", status)
+		log.CDebugf(ctx, "Journal status: %+v", status)
 		return errors.New("Journal is non-empty after a wait")
 	}
 	return nil
@@ -158,7 +169,7 @@ func (rh *RPCHandler) CreateRepo(
 		return "", err
 	}
 
-	err = rh.waitForJournal(ctx, gitConfig, tlfHandle)
+	err = waitForJournal(ctx, gitConfig, tlfHandle, rh.log)
 	if err != nil {
 		return "", err
 	}
@@ -199,7 +210,7 @@ func (rh *RPCHandler) scheduleCleaning(folder keybase1.Folder) {
 			return
 		}
 
-		err = rh.waitForJournal(ctx, gitConfig, tlfHandle)
+		err = waitForJournal(ctx, gitConfig, tlfHandle, rh.log)
 		if err != nil {
 			log.CDebugf(ctx, "Error waiting for journal after cleaning "+
 				"folder %s: %+v", tlfHandle.GetCanonicalPath(), err)
@@ -238,7 +249,7 @@ func (rh *RPCHandler) DeleteRepo(
 		return err
 	}
 
-	err = rh.waitForJournal(ctx, gitConfig, tlfHandle)
+	err = waitForJournal(ctx, gitConfig, tlfHandle, rh.log)
 	if err != nil {
 		return err
 	}
@@ -249,17 +260,85 @@ func (rh *RPCHandler) DeleteRepo(
 
 // Gc implements keybase1.KBFSGitInterface for KeybaseServiceBase.
 func (rh *RPCHandler) Gc(
-	ctx context.Context, arg keybase1.GcArg) (err error) {
-	rh.log.CDebugf(ctx, "Garbage-collecting repo %s from folder %s/%s",
-		arg.Name, arg.Folder.FolderType, arg.Folder.Name)
+	ctx context.Context, arg keybase1.GcArg) error {
+	gco := GCOptions{
+		MaxLooseRefs:         arg.Options.MaxLooseRefs,
+		PruneMinLooseObjects: arg.Options.PruneMinLooseObjects,
+		PruneExpireTime:      keybase1.FromTime(arg.Options.PruneExpireTime),
+		MaxObjectPacks:       -1, // Turn off re-packing for now
+	}
+	return rh.GCRepos(ctx, arg.Folder, string(arg.Name), &gco)
+}
+
+// GCRepos garbage-collects the repo named `repoName` in `folder`, or
+// every repo in `folder` if `repoName` is empty, using the given
+// thresholds.  If `options` is nil, the thresholds are picked based
+// on the size of each repo, and repos that were collected recently
+// are skipped.  Only one device collects a repo at a time.
+//
+// TODO: Hook this up to an RPC.
+func (rh *RPCHandler) GCRepos(ctx context.Context,
+	folder keybase1.Folder, repoName string, options *GCOptions) (
+	err error) {
+	rh.log.CDebugf(ctx, "Garbage-collecting repo %q from folder %s/%s",
+		repoName, folder.FolderType, folder.Name)
+	defer func() {
+		rh.log.CDebugf(ctx, "Done garbage-collecting repos: %+v", err)
+	}()
+
+	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, rh.config.KBPKI(), rh.config.MDOps(), folder.Name,
+		tlf.TypeFromFolderType(folder.FolderType))
+	if err != nil {
+		return err
+	}
+	if repoName == "" {
+		return rh.gcManager.GCAll(ctx, tlfHandle, options)
+	}
+	return rh.gcManager.GC(ctx, tlfHandle, repoName, options)
+}
+
+// ArchiveRepo writes an archive of the tree at `ref` in the given
+// repo to `w`.  See `Archive` for the details.
+//
+// TODO: Hook this up to an RPC.
+func (rh *RPCHandler) ArchiveRepo(ctx context.Context,
+	folder keybase1.Folder, repoName, ref string, format ArchiveFormat,
+	w io.Writer) (err error) {
+	rh.log.CDebugf(ctx, "Archiving %s of repo %s from folder %s/%s as %s",
+		ref, repoName, folder.FolderType, folder.Name, format)
 	defer func() {
-		rh.log.CDebugf(ctx, "Done garbage-collecting repo: %+v", err)
+		rh.log.CDebugf(ctx, "Done archiving repo: %+v", err)
+	}()
+
+	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, rh.config.KBPKI(), rh.config.MDOps(), folder.Name,
+		tlf.TypeFromFolderType(folder.FolderType))
+	if err != nil {
+		return err
+	}
+	ctx = context.WithValue(ctx, libkbfs.CtxAllowNameKey, kbfsRepoDir)
+	repoFS, _, err := GetRepoAndID(ctx, rh.config, tlfHandle, repoName, "")
+	if err != nil {
+		return err
+	}
+	return Archive(ctx, repoFS, ref, format, w)
+}
+
+// RenameRepo renames an existing git repository.
+//
+// TODO: Hook this up to an RPC.
+func (rh *RPCHandler) RenameRepo(ctx context.Context,
+	folder keybase1.Folder, oldName, newName string) (err error) {
+	rh.log.CDebugf(ctx, "Renaming repo %s to %s", oldName, newName)
+	defer func() {
+		rh.log.CDebugf(ctx, "Done renaming repo: %+v", err)
 	}()
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	ctx, gitConfig, tlfHandle, tempDir, err := rh.getHandleAndConfig(
-		ctx, arg.Folder)
+		ctx, folder)
 	if err != nil {
 		return err
 	}
@@ -272,28 +351,29 @@ func (rh *RPCHandler) Gc(
 	}()
 	defer gitConfig.Shutdown(ctx)
 
-	gco := GCOptions{
-		MaxLooseRefs:         arg.Options.MaxLooseRefs,
-		PruneMinLooseObjects: arg.Options.PruneMinLooseObjects,
-		PruneExpireTime:      keybase1.FromTime(arg.Options.PruneExpireTime),
-		MaxObjectPacks:       -1, // Turn off re-packing for now
+	err = RenameRepo(ctx, gitConfig, tlfHandle, oldName, newName)
+	if err != nil {
+		return err
 	}
-	err = GCRepo(ctx, gitConfig, tlfHandle, string(arg.Name), gco)
+
+	err = waitForJournal(ctx, gitConfig, tlfHandle, rh.log)
 	if err != nil {
 		return err
 	}
 
-	return rh.waitForJournal(ctx, gitConfig, tlfHandle)
+	return nil
 }
 
-// RenameRepo renames an existing git repository.
+// MoveRepo moves an existing git repository into a team folder.
 //
 // TODO: Hook this up to an RPC.
-func (rh *RPCHandler) RenameRepo(ctx context.Context,
-	folder keybase1.Folder, oldName, newName string) (err error) {
-	rh.log.CDebugf(ctx, "Renaming repo %s to %s", oldName, newName)
+func (rh *RPCHandler) MoveRepo(ctx context.Context,
+	folder keybase1.Folder, oldName string, newFolder keybase1.Folder,
+	newName string) (err error) {
+	rh.log.CDebugf(ctx, "Moving repo %s/%s to %s/%s",
+		folder.Name, oldName, newFolder.Name, newName)
 	defer func() {
-		rh.log.CDebugf(ctx, "Done renaming repo: %+v", err)
+		rh.log.CDebugf(ctx, "Done moving repo: %+v", err)
 	}()
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -312,15 +392,64 @@ func (rh *RPCHandler) RenameRepo(ctx context.Context,
 	}()
 	defer gitConfig.Shutdown(ctx)
 
-	err = RenameRepo(ctx, gitConfig, tlfHandle, oldName, newName)
+	newTlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, gitConfig.KBPKI(), gitConfig.MDOps(), newFolder.Name,
+		tlf.TypeFromFolderType(newFolder.FolderType))
+	if err != nil {
+		return err
+	}
+
+	err = MoveRepo(ctx, gitConfig, tlfHandle, oldName, newTlfHandle, newName)
 	if err != nil {
 		return err
 	}
 
-	err = rh.waitForJournal(ctx, gitConfig, tlfHandle)
+	// Flush the new copy before the removal of the old one.
+	err = waitForJournal(ctx, gitConfig, newTlfHandle, rh.log)
+	if err != nil {
+		return err
+	}
+	err = waitForJournal(ctx, gitConfig, tlfHandle, rh.log)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// SetProtectedBranches replaces the set of protected branches of an
+// existing git repository.  See `SetProtectedBranches` for who is
+// allowed to do that.
+//
+// TODO: Hook this up to an RPC.
+func (rh *RPCHandler) SetProtectedBranches(ctx context.Context,
+	folder keybase1.Folder, repoName string, branches []string) (err error) {
+	rh.log.CDebugf(ctx, "Setting the protected branches of repo %s to %v",
+		repoName, branches)
+	defer func() {
+		rh.log.CDebugf(ctx, "Done setting protected branches: %+v", err)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx, gitConfig, tlfHandle, tempDir, err := rh.getHandleAndConfig(
+		ctx, folder)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		rmErr := os.RemoveAll(tempDir)
+		if rmErr != nil {
+			rh.log.CDebugf(
+				ctx, "Error cleaning storage dir %s: %+v\n", tempDir, rmErr)
+		}
+	}()
+	defer gitConfig.Shutdown(ctx)
+
+	err = SetProtectedBranches(ctx, gitConfig, tlfHandle, repoName, branches)
+	if err != nil {
+		return err
+	}
+
+	return waitForJournal(ctx, gitConfig, tlfHandle, rh.log)
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+// ShallowFetchPlan lists what a depth-limited fetch needs to send.
+type ShallowFetchPlan struct {
+	// Objects are the objects the destination repo is missing.
+	Objects []plumbing.Hash
+	// Shallow is the destination's complete new list of shallow
+	// commits, i.e. the commits whose parents it won't have.
+	Shallow []plumbing.Hash
+}
+
+type shallowFetchPlanner struct {
+	src  storer.EncodedObjectStorer
+	dst  storer.EncodedObjectStorer
+	seen map[plumbing.Hash]bool
+	plan ShallowFetchPlan
+}
+
+// add records that the object `h` needs to be sent, if the
+// destination doesn't have it already.  It returns false if the
+// object was already seen or the destination has it.
+func (sfp *shallowFetchPlanner) add(h plumbing.Hash) (bool, error) {
+	if sfp.seen[h] {
+		return false, nil
+	}
+	sfp.seen[h] = true
+	err := sfp.dst.HasEncodedObject(h)
+	switch errors.Cause(err) {
+	case nil:
+		return false, nil
+	case plumbing.ErrObjectNotFound:
+		sfp.plan.Objects = append(sfp.plan.Objects, h)
+		return true, nil
+	default:
+		return false, err
+	}
+}
+
+// addTree adds the tree `h` and everything in it.  A tree the
+// destination already has is assumed to be complete there.
+func (sfp *shallowFetchPlanner) addTree(h plumbing.Hash) error {
+	added, err := sfp.add(h)
+	if err != nil || !added {
+		return err
+	}
+	tree, err := object.GetTree(sfp.src, h)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries {
+		switch entry.Mode {
+		case filemode.Submodule:
+			// Submodule commits live in other repos.
+		case filemode.Dir:
+			err = sfp.addTree(entry.Hash)
+		default:
+			_, err = sfp.add(entry.Hash)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveWant adds any annotated tags on the way from `h` to the
+// commit it refers to, and returns that commit.
+func (sfp *shallowFetchPlanner) resolveWant(h plumbing.Hash) (
+	*object.Commit, error) {
+	for {
+		obj, err := sfp.src.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return nil, err
+		}
+		if obj.Type() != plumbing.TagObject {
+			return object.DecodeCommit(sfp.src, obj)
+		}
+		_, err = sfp.add(h)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := object.DecodeTag(sfp.src, obj)
+		if err != nil {
+			return nil, err
+		}
+		h = tag.Target
+	}
+}
+
+// PlanShallowFetch figures out what needs to be sent from `src` to
+// `dst` so that `dst` has the last `depth` commits of history leading
+// up to each of `wants`, along with their trees.  Objects that `dst`
+// already has are left out.  Commits that were shallow in `dst` but
+// are now within `depth` of a want get their history filled in,
+// which is how a shallow repo is deepened.
+func PlanShallowFetch(
+	src storer.EncodedObjectStorer, dst storage.Storer,
+	wants []plumbing.Hash, depth int) (ShallowFetchPlan, error) {
+	if depth <= 0 {
+		return ShallowFetchPlan{}, errors.Errorf("Invalid depth: %d", depth)
+	}
+	oldShallow, err := dst.Shallow()
+	if err != nil {
+		return ShallowFetchPlan{}, err
+	}
+	sfp := &shallowFetchPlanner{
+		src:  src,
+		dst:  dst,
+		seen: make(map[plumbing.Hash]bool),
+	}
+
+	// Walk breadth-first, so each commit is reached at its smallest
+	// depth.
+	levels := make(map[plumbing.Hash]int)
+	var queue []*object.Commit
+	for _, want := range wants {
+		c, err := sfp.resolveWant(want)
+		if err != nil {
+			return ShallowFetchPlan{}, err
+		}
+		if _, ok := levels[c.Hash]; ok {
+			continue
+		}
+		levels[c.Hash] = 1
+		queue = append(queue, c)
+	}
+
+	complete := make(map[plumbing.Hash]bool)
+	var boundary []plumbing.Hash
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		newCommit, err := sfp.add(c.Hash)
+		if err != nil {
+			return ShallowFetchPlan{}, err
+		}
+		err = sfp.addTree(c.TreeHash)
+		if err != nil {
+			return ShallowFetchPlan{}, err
+		}
+
+		level := levels[c.Hash]
+		if level >= depth {
+			// If `dst` already has this commit and its history,
+			// don't cut that history off.
+			if c.NumParents() > 0 && newCommit {
+				boundary = append(boundary, c.Hash)
+			}
+			continue
+		}
+		isComplete := true
+		for _, p := range c.ParentHashes {
+			if _, ok := levels[p]; ok {
+				continue
+			}
+			parent, err := object.GetCommit(src, p)
+			if errors.Cause(err) == plumbing.ErrObjectNotFound {
+				// `src` is shallow itself.
+				isComplete = false
+				continue
+			} else if err != nil {
+				return ShallowFetchPlan{}, err
+			}
+			levels[p] = level + 1
+			queue = append(queue, parent)
+		}
+		if isComplete {
+			complete[c.Hash] = true
+		} else {
+			boundary = append(boundary, c.Hash)
+		}
+	}
+
+	for _, h := range oldShallow {
+		if !complete[h] {
+			sfp.plan.Shallow = append(sfp.plan.Shallow, h)
+		}
+	}
+	sfp.plan.Shallow = append(sfp.plan.Shallow, boundary...)
+	return sfp.plan, nil
+}
+
+// SendObjects packs up the objects `hashes` from `src`, and stores
+// the pack in `dst`.
+func SendObjects(
+	src storage.Storer, dst storer.EncodedObjectStorer,
+	hashes []plumbing.Hash, statusChan plumbing.StatusChan) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	config, err := src.Config()
+	if err != nil {
+		return err
+	}
+
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		e := packfile.NewEncoder(w, src, false)
+		_, err := e.Encode(hashes, config.Pack.Window, statusChan)
+		done <- err
+		w.CloseWithError(err)
+	}()
+
+	err = packfile.UpdateObjectStorage(dst, r, statusChan)
+	// Unblock the encoder, in case the storage stopped reading early.
+	r.CloseWithError(err)
+	encodeErr := <-done
+	if err != nil {
+		return err
+	}
+	return encodeErr
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+func TestShallowFetch(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+
+	var commits []plumbing.Hash
+	for i := 0; i < 3; i++ {
+		addFileToWorktree(t, repo, worktreeFS,
+			fmt.Sprintf("foo%d", i), fmt.Sprintf("hello%d", i))
+		head, err := repo.Head()
+		require.NoError(t, err)
+		commits = append(commits, head.Hash())
+	}
+	commitWorktree(t, ctx, config, h, worktreeFS)
+	wants := []plumbing.Hash{commits[2]}
+
+	dst := memory.NewStorage()
+	fetch := func(depth int, expectedObjects int, expectedShallow []plumbing.Hash) {
+		plan, err := PlanShallowFetch(dotgitStorage, dst, wants, depth)
+		require.NoError(t, err)
+		require.Len(t, plan.Objects, expectedObjects)
+		require.Equal(t, expectedShallow, plan.Shallow)
+		err = SendObjects(dotgitStorage, dst, plan.Objects, nil)
+		require.NoError(t, err)
+		err = dst.SetShallow(plan.Shallow)
+		require.NoError(t, err)
+	}
+
+	t.Log("Depth 1 gets the head commit, its tree and its three files")
+	fetch(1, 5, []plumbing.Hash{commits[2]})
+	_, err = object.GetCommit(dst, commits[2])
+	require.NoError(t, err)
+	_, err = object.GetCommit(dst, commits[1])
+	require.Equal(t, plumbing.ErrObjectNotFound, err)
+
+	t.Log("Fetching again sends nothing new")
+	fetch(1, 0, []plumbing.Hash{commits[2]})
+
+	t.Log("Deepening only sends the older commits and their trees")
+	fetch(2, 2, []plumbing.Hash{commits[1]})
+	fetch(5, 2, nil)
+	for _, c := range commits {
+		_, err = object.GetCommit(dst, c)
+		require.NoError(t, err)
+	}
+
+	_, err = PlanShallowFetch(dotgitStorage, dst, wants, 0)
+	require.Error(t, err)
+}
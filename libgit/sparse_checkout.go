@@ -0,0 +1,297 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	billy "gopkg.in/src-d/go-billy.v4"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// pathFilterFile records, in the worktree's .git directory, which
+// subdirectory of the repo a sparse checkout contains.  It's absent
+// for full checkouts.
+const pathFilterFile = ".git/kbfs_path_filter"
+
+// CleanPathFilter returns the canonical form of a sparse checkout
+// path filter, which names a subdirectory of a repo, e.g. "docs/".
+// The empty string means the whole repo.
+func CleanPathFilter(filter string) (string, error) {
+	filter = path.Clean("/" + filter)[1:]
+	for _, part := range strings.Split(filter, "/") {
+		if part == ".git" {
+			return "", errors.New("path filter can't include .git")
+		}
+	}
+	return filter, nil
+}
+
+func readPathFilter(worktreeFS billy.Filesystem) (string, error) {
+	f, err := worktreeFS.Open(pathFilterFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func writePathFilter(worktreeFS billy.Filesystem, filter string) error {
+	if filter == "" {
+		err := worktreeFS.Remove(pathFilterFile)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	f, err := worktreeFS.Create(pathFilterFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.WriteString(f, filter)
+	return err
+}
+
+// filteredTree returns the subtree at `filter` of the tree of commit
+// `h`, or nil if `h` is the zero hash.
+func filteredTree(
+	repo *gogit.Repository, h plumbing.Hash, filter string) (
+	*object.Tree, error) {
+	if h == plumbing.ZeroHash {
+		return nil, nil
+	}
+	commit, err := repo.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if filter == "" {
+		return tree, nil
+	}
+	return tree.Tree(filter)
+}
+
+// removeEmptyParents removes the parent directories of `p` that have
+// become empty.
+func removeEmptyParents(worktreeFS billy.Filesystem, p string) error {
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		fis, err := worktreeFS.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if len(fis) > 0 {
+			return nil
+		}
+		err = worktreeFS.Remove(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkoutSparseFile(
+	worktreeFS billy.Filesystem, p string, f *object.File) (err error) {
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	err = worktreeFS.MkdirAll(path.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if f.Mode == filemode.Symlink {
+		target, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		err = worktreeFS.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return worktreeFS.Symlink(string(target), p)
+	}
+
+	// Replace any symlink that used to be here, rather than writing
+	// through it.
+	if fi, err := worktreeFS.Lstat(p); err == nil &&
+		fi.Mode()&os.ModeSymlink != 0 {
+		err = worktreeFS.Remove(p)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := worktreeFS.OpenFile(
+		p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := w.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// applyChanges makes the changes between two trees in `worktreeFS`,
+// where the trees are rooted at `prefix`.  Deletions are done first,
+// so that a file can be replaced by a directory of the same name.
+func applyChanges(ctx context.Context, worktreeFS billy.Filesystem,
+	changes object.Changes, prefix string) error {
+	var writes []*object.Change
+	for _, c := range changes {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		from, to, err := c.Files()
+		if err != nil {
+			return err
+		}
+		if to != nil {
+			writes = append(writes, c)
+			continue
+		}
+		if from == nil {
+			// Not a file, e.g. a submodule.
+			continue
+		}
+		p := path.Join(prefix, c.From.Name)
+		err = worktreeFS.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		err = removeEmptyParents(worktreeFS, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range writes {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		_, to, err := c.Files()
+		if err != nil {
+			return err
+		}
+		err = checkoutSparseFile(worktreeFS, path.Join(prefix, c.To.Name), to)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sparseReset checks out only the `newFilter` subdirectory of commit
+// `repoHead` into `worktreeFS`, which currently has the `oldFilter`
+// subdirectory of commit `worktreeHead` checked out (either of which
+// may be the empty string, for a full checkout).  Only the filtered
+// subtrees are walked, and only files that changed are written.
+func sparseReset(ctx context.Context, repo *gogit.Repository,
+	worktreeFS billy.Filesystem, worktreeHead, repoHead plumbing.Hash,
+	oldFilter, newFilter string, rp *resetProgress) error {
+	newTree, err := filteredTree(repo, repoHead, newFilter)
+	if err == object.ErrDirectoryNotFound {
+		return fmt.Errorf("%s is not a directory in %s", newFilter, repoHead)
+	} else if err != nil {
+		return err
+	}
+	oldTree, err := filteredTree(repo, worktreeHead, oldFilter)
+	if err == object.ErrDirectoryNotFound {
+		// The old commit didn't have the directory, so nothing from
+		// it was checked out.
+		oldTree = nil
+	} else if err != nil {
+		return err
+	}
+	err = rp.countFiles(ctx, newTree)
+	if err != nil {
+		return err
+	}
+
+	if oldFilter == newFilter {
+		changes, err := object.DiffTree(oldTree, newTree)
+		if err != nil {
+			return err
+		}
+		err = applyChanges(ctx, worktreeFS, changes, newFilter)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Remove everything that was checked out under the old
+		// filter, and write everything under the new one.
+		removals, err := object.DiffTree(oldTree, nil)
+		if err != nil {
+			return err
+		}
+		err = applyChanges(ctx, worktreeFS, removals, oldFilter)
+		if err != nil {
+			return err
+		}
+		additions, err := object.DiffTree(nil, newTree)
+		if err != nil {
+			return err
+		}
+		err = applyChanges(ctx, worktreeFS, additions, newFilter)
+		if err != nil {
+			return err
+		}
+		// The index from a full checkout no longer matches.
+		err = worktreeFS.Remove(".git/index")
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if worktreeHead == plumbing.ZeroHash && newFilter != "" {
+		// Clone's placeholder file isn't part of a sparse checkout,
+		// so go-git won't clean it up for us.
+		err = worktreeFS.Remove(cloneFileName)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return repo.Storer.SetReference(
+		plumbing.NewHashReference(plumbing.HEAD, repoHead))
+}
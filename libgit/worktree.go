@@ -6,12 +6,15 @@ package libgit
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 
 	billy "gopkg.in/src-d/go-billy.v4"
 	gogit "gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 )
 
@@ -25,13 +28,13 @@ import (
 // calls.
 //
 // For the convenience of the caller, it also returns `repoHead`,
-// which is the current commit at the HEAD of `branch`, according to
-// the bare repo represented by `repoFS`.  In addition, it returns
+// which is the commit that `target` refers to (see resolveCommit),
+// according to the bare repo represented by `repoFS`.  In addition, it returns
 // `worktreeHead`, which is the current commit that the worktree has or
 // `plumbing.ZeroHash` if the worktree is uninitialized.
 func repoFromStorageAndWorktree(
 	repoFS billy.Filesystem, worktreeFS billy.Filesystem,
-	branch plumbing.ReferenceName) (
+	target string) (
 	repo *gogit.Repository, repoHead, worktreeHead plumbing.Hash, err error) {
 	repoStorer, err := filesystem.NewStorage(repoFS)
 	if err != nil {
@@ -45,7 +48,7 @@ func repoFromStorageAndWorktree(
 		return nil, plumbing.ZeroHash, plumbing.ZeroHash, err
 	}
 
-	repoHeadRef, err := storer.ResolveReference(storage, branch)
+	repoHead, err = resolveCommit(storage, target)
 	if err != nil {
 		return nil, plumbing.ZeroHash, plumbing.ZeroHash, err
 	}
@@ -77,7 +80,55 @@ func repoFromStorageAndWorktree(
 	if err != nil {
 		return nil, plumbing.ZeroHash, plumbing.ZeroHash, err
 	}
-	return repo, repoHeadRef.Hash(), worktreeHead, nil
+	return repo, repoHead, worktreeHead, nil
+}
+
+// isCommitHash returns whether `s` is a full, hex-encoded commit
+// hash.
+func isCommitHash(s string) bool {
+	if len(s) != 2*len(plumbing.ZeroHash) {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// resolveCommit returns the hash of the commit that `target` refers
+// to in `s`.  `target` is either a full reference name, like
+// "refs/heads/master" or "refs/tags/v1.0", or a full commit hash.
+// Annotated tags are followed to the commit they point to.
+func resolveCommit(s storage.Storer, target string) (plumbing.Hash, error) {
+	var h plumbing.Hash
+	if isCommitHash(target) {
+		h = plumbing.NewHash(target)
+	} else {
+		ref, err := storer.ResolveReference(
+			s, plumbing.ReferenceName(target))
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		h = ref.Hash()
+	}
+
+	for {
+		obj, err := s.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			return h, nil
+		case plumbing.TagObject:
+			tag, err := object.DecodeTag(s, obj)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			h = tag.Target
+		default:
+			return plumbing.ZeroHash, fmt.Errorf(
+				"%s refers to a %s, not a commit", target, obj.Type())
+		}
+	}
 }
 
 // Reset checks out a repo from a billy filesystem, into another billy
@@ -103,30 +154,77 @@ func repoFromStorageAndWorktree(
 func Reset(
 	ctx context.Context, repoFS billy.Filesystem, worktreeFS billy.Filesystem,
 	branch plumbing.ReferenceName) error {
-	repo, repoHead, worktreeHead, err := repoFromStorageAndWorktree(
-		repoFS, worktreeFS, branch)
+	return resetWithProgress(
+		ctx, repoFS, worktreeFS, string(branch), nil, nil)
+}
+
+// resetWithProgress is like Reset, but checks out `target`, which may
+// also be a commit hash (see resolveCommit), and reports its progress
+// to `rp`, if it's non-nil.  If `pathFilter` is non-nil, only that
+// subdirectory of the repo is checked out (or the whole repo, if it's
+// empty); otherwise the worktree keeps whatever filter it was last
+// checked out with.
+func resetWithProgress(
+	ctx context.Context, repoFS billy.Filesystem, worktreeFS billy.Filesystem,
+	target string, pathFilter *string, rp *resetProgress) (err error) {
+	oldFilter, err := readPathFilter(worktreeFS)
 	if err != nil {
 		return err
 	}
-
-	// Quickly check if the repo is already up-to-date.
-	if worktreeHead == repoHead {
-		return nil
+	newFilter := oldFilter
+	if pathFilter != nil {
+		newFilter = *pathFilter
 	}
 
-	wt, err := repo.Worktree()
+	dotgitFS := worktreeFS
+	if rp != nil {
+		worktreeFS = progressFS{worktreeFS, rp}
+	}
+	repo, repoHead, worktreeHead, err := repoFromStorageAndWorktree(
+		repoFS, worktreeFS, target)
 	if err != nil {
 		return err
 	}
 
+	// Quickly check if the repo is already up-to-date.
+	if worktreeHead == repoHead && oldFilter == newFilter {
+		return nil
+	}
+
 	defer func() {
 		if err != nil {
 			// Clean up the worktree HEAD so the next update attempt
 			// won't get optimized away.
-			_ = worktreeFS.Remove(fmt.Sprintf(".git/%s", plumbing.HEAD))
+			_ = dotgitFS.Remove(fmt.Sprintf(".git/%s", plumbing.HEAD))
 		}
 	}()
 
+	rp.setPhase(AutogitPhaseCheckingOut)
+	if oldFilter != "" || newFilter != "" {
+		err = sparseReset(ctx, repo, worktreeFS, worktreeHead, repoHead,
+			oldFilter, newFilter, rp)
+		if err != nil {
+			return err
+		}
+		return writePathFilter(dotgitFS, newFilter)
+	}
+
+	if rp != nil {
+		tree, err := filteredTree(repo, repoHead, "")
+		if err != nil {
+			return err
+		}
+		err = rp.countFiles(ctx, tree)
+		if err != nil {
+			return err
+		}
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+
 	return wt.Reset(&gogit.ResetOptions{
 		Commit: repoHead,
 		Mode:   gogit.HardReset,
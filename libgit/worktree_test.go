@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -153,3 +154,132 @@ func TestWorktreeResetFromBranch(t *testing.T) {
 	testCheckFile(t, git2FS, "foo", "hello")
 	testCheckFile(t, git2FS, "foo2", "hello2")
 }
+
+func TestWorktreeResetToTagOrCommit(t *testing.T) {
+	git1, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git1)
+
+	makeLocalRepoWithOneFile(t, git1, "foo", "hello", "")
+	dotgit1 := filepath.Join(git1, ".git")
+	gitExec(t, dotgit1, git1, "tag", "v1")
+	addOneFileToRepo(t, git1, "foo2", "hello2")
+	gitExec(t, dotgit1, git1, "-c", "user.name=Foo",
+		"-c", "user.email=foo@foo.com", "tag", "-a", "-m", "v2", "v2")
+	out, err := exec.Command(
+		"git", "--git-dir", dotgit1, "rev-parse", "v1").Output()
+	require.NoError(t, err)
+	v1Hash := strings.TrimSpace(string(out))
+	addOneFileToRepo(t, git1, "foo3", "hello3")
+
+	git2, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git2)
+
+	dotgit1FS := osfs.New(dotgit1)
+	git2FS := osfs.New(git2)
+
+	ctx := context.Background()
+	t.Log("Check out an annotated tag")
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, autogitTarget("refs/tags/v2"), nil, nil)
+	require.NoError(t, err)
+	testCheckFile(t, git2FS, "foo", "hello")
+	testCheckFile(t, git2FS, "foo2", "hello2")
+	_, err = git2FS.Stat("foo3")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Check out an older commit by its hash")
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, autogitTarget(v1Hash), nil, nil)
+	require.NoError(t, err)
+	testCheckFile(t, git2FS, "foo", "hello")
+	_, err = git2FS.Stat("foo2")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Move back to the branch head")
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, autogitTarget("master"), nil, nil)
+	require.NoError(t, err)
+	testCheckFile(t, git2FS, "foo3", "hello3")
+
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, autogitTarget("refs/tags/nope"), nil, nil)
+	require.Error(t, err)
+}
+
+func TestWorktreeResetSparse(t *testing.T) {
+	git1, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git1)
+
+	makeLocalRepoWithOneFile(t, git1, "foo", "hello", "")
+	dotgit1 := filepath.Join(git1, ".git")
+	err = os.MkdirAll(filepath.Join(git1, "docs", "sub"), 0700)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(git1, "src"), 0700)
+	require.NoError(t, err)
+	addOneFileToRepo(t, git1, "docs/a", "a1")
+	addOneFileToRepo(t, git1, "docs/sub/b", "b1")
+	addOneFileToRepo(t, git1, "src/c", "c1")
+
+	git2, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git2)
+
+	dotgit1FS := osfs.New(dotgit1)
+	git2FS := osfs.New(git2)
+	checkMissing := func(name string) {
+		_, err := git2FS.Lstat(name)
+		require.True(t, os.IsNotExist(err), name)
+	}
+
+	ctx := context.Background()
+	t.Log("Check out only docs/")
+	docs, err := CleanPathFilter("docs/")
+	require.NoError(t, err)
+	require.Equal(t, "docs", docs)
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, "refs/heads/master", &docs, nil)
+	require.NoError(t, err)
+	testCheckFile(t, git2FS, "docs/a", "a1")
+	testCheckFile(t, git2FS, "docs/sub/b", "b1")
+	checkMissing("foo")
+	checkMissing("src")
+
+	t.Log("Pull changes, keeping the filter")
+	addOneFileToRepo(t, git1, "docs/a", "a2")
+	gitExec(t, dotgit1, git1, "rm", "-f", "docs/sub/b")
+	addOneFileToRepo(t, git1, "src/d", "d1")
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, "refs/heads/master", nil, nil)
+	require.NoError(t, err)
+	testCheckFile(t, git2FS, "docs/a", "a2")
+	checkMissing("docs/sub")
+	checkMissing("src")
+
+	t.Log("Switch to a full checkout")
+	full := ""
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, "refs/heads/master", &full, nil)
+	require.NoError(t, err)
+	testCheckFile(t, git2FS, "foo", "hello")
+	testCheckFile(t, git2FS, "docs/a", "a2")
+	testCheckFile(t, git2FS, "src/c", "c1")
+	testCheckFile(t, git2FS, "src/d", "d1")
+
+	t.Log("Switch to a different subdirectory")
+	src := "src"
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, "refs/heads/master", &src, nil)
+	require.NoError(t, err)
+	testCheckFile(t, git2FS, "src/c", "c1")
+	testCheckFile(t, git2FS, "src/d", "d1")
+	checkMissing("foo")
+	checkMissing("docs")
+
+	missing := "nope"
+	err = resetWithProgress(
+		ctx, dotgit1FS, git2FS, "refs/heads/master", &missing, nil)
+	require.Error(t, err)
+}
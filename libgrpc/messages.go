@@ -0,0 +1,189 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgrpc
+
+// The messages of kbfs.proto.  Requests are only decoded, and
+// responses only encoded.
+
+type fileType uint64
+
+const (
+	fileTypeFile      fileType = 1
+	fileTypeDirectory fileType = 2
+	fileTypeSymlink   fileType = 3
+)
+
+type watchEventType uint64
+
+const (
+	watchEventEntryChanged watchEventType = 1
+	watchEventFileWritten  watchEventType = 2
+)
+
+type empty struct{}
+
+func (empty) marshal(b *protoBuffer) {}
+
+type fileInfo struct {
+	name          string
+	fileType      fileType
+	size          uint64
+	mtimeUnixNano int64
+	executable    bool
+	symlinkTarget string
+}
+
+func (m fileInfo) marshal(b *protoBuffer) {
+	b.stringField(1, m.name)
+	b.uint64Field(2, uint64(m.fileType))
+	b.uint64Field(3, m.size)
+	b.int64Field(4, m.mtimeUnixNano)
+	b.boolField(5, m.executable)
+	b.stringField(6, m.symlinkTarget)
+}
+
+type listResponse struct {
+	entries []fileInfo
+}
+
+func (m listResponse) marshal(b *protoBuffer) {
+	for _, e := range m.entries {
+		b.messageField(1, e)
+	}
+}
+
+type readResponse struct {
+	data   []byte
+	offset uint64
+}
+
+func (m readResponse) marshal(b *protoBuffer) {
+	b.bytesField(1, m.data)
+	b.uint64Field(2, m.offset)
+}
+
+type writeResponse struct {
+	size uint64
+}
+
+func (m writeResponse) marshal(b *protoBuffer) {
+	b.uint64Field(1, m.size)
+}
+
+type watchEvent struct {
+	eventType watchEventType
+	path      string
+	offset    uint64
+	length    uint64
+}
+
+func (m watchEvent) marshal(b *protoBuffer) {
+	b.uint64Field(1, uint64(m.eventType))
+	b.stringField(2, m.path)
+	b.uint64Field(3, m.offset)
+	b.uint64Field(4, m.length)
+}
+
+// pathRequest is StatRequest, ListRequest or WatchRequest.
+type pathRequest struct {
+	path string
+}
+
+func (m *pathRequest) unmarshal(data []byte) (err error) {
+	return parseProto(data, func(f protoField) error {
+		if f.num == 1 {
+			m.path, err = f.string()
+		}
+		return err
+	})
+}
+
+type readRequest struct {
+	path   string
+	offset uint64
+	length uint64
+}
+
+func (m *readRequest) unmarshal(data []byte) (err error) {
+	return parseProto(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.path, err = f.string()
+		case 2:
+			m.offset, err = f.uint64()
+		case 3:
+			m.length, err = f.uint64()
+		}
+		return err
+	})
+}
+
+type writeRequest struct {
+	path      string
+	offset    uint64
+	data      []byte
+	create    bool
+	exclusive bool
+	truncate  bool
+	append    bool
+}
+
+func (m *writeRequest) unmarshal(data []byte) (err error) {
+	return parseProto(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.path, err = f.string()
+		case 2:
+			m.offset, err = f.uint64()
+		case 3:
+			m.data, err = f.byteSlice()
+		case 4:
+			m.create, err = f.bool()
+		case 5:
+			m.exclusive, err = f.bool()
+		case 6:
+			m.truncate, err = f.bool()
+		case 7:
+			m.append, err = f.bool()
+		}
+		return err
+	})
+}
+
+// flagRequest is MkdirRequest or RemoveRequest, which both have a
+// path and a single flag.
+type flagRequest struct {
+	path string
+	flag bool
+}
+
+func (m *flagRequest) unmarshal(data []byte) (err error) {
+	return parseProto(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.path, err = f.string()
+		case 2:
+			m.flag, err = f.bool()
+		}
+		return err
+	})
+}
+
+type renameRequest struct {
+	oldPath string
+	newPath string
+}
+
+func (m *renameRequest) unmarshal(data []byte) (err error) {
+	return parseProto(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.oldPath, err = f.string()
+		case 2:
+			m.newPath, err = f.string()
+		}
+		return err
+	})
+}
@@ -0,0 +1,223 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgrpc
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// Debug tag ID for a gRPC call.
+const ctxOpID = "GRPC"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+// Server serves the KBFS file API described in kbfs.proto over gRPC,
+// to clients that present its token.
+type Server struct {
+	config  libkbfs.Config
+	log     logger.Logger
+	handler *grpcHandler
+	fs      *libfs.TlfFSCache
+}
+
+var _ http.Handler = (*Server)(nil)
+var _ fsSource = (*Server)(nil)
+
+// NewServer returns a new Server that accepts calls carrying `token`.
+func NewServer(config libkbfs.Config, token string) (*Server, error) {
+	if token == "" {
+		return nil, errors.New("No token given")
+	}
+	s := &Server{
+		config: config,
+		log:    config.MakeLogger("GRPC"),
+		fs:     libfs.NewTlfFSCache(config),
+	}
+	s.handler = newServiceHandler(s, token, errToStatus)
+	return s, nil
+}
+
+// errToStatus converts KBFS errors into statuses.
+func errToStatus(err error) *statusError {
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError, libkbfs.NoSuchUserError,
+		libkbfs.NoSuchTeamError:
+		return newStatusError(codeNotFound, "%v", err)
+	case libkbfs.ReadAccessError, libkbfs.WriteAccessError,
+		libkbfs.TlfAccessError, libkbfs.WriteUnsupportedError,
+		libkbfs.WriteToReadonlyNodeError:
+		return newStatusError(codePermissionDenied, "%v", err)
+	case libkbfs.DirNotEmptyError, libkbfs.NotDirError,
+		libkbfs.NotFileError:
+		return newStatusError(codeFailedPrecondition, "%v", err)
+	default:
+		return osErrToStatus(err)
+	}
+}
+
+func (s *Server) newCtx(ctx context.Context) context.Context {
+	return libkbfs.CtxWithRandomIDReplayable(ctx, ctxIDKey, ctxOpID, s.log)
+}
+
+// getFS returns the shared FS for a TLF named in a request.  A bad
+// TLF type is the caller's mistake, so it's an InvalidArgument
+// status.
+func (s *Server) getFS(tlfType, tlfName string) (*libfs.FS, error) {
+	t, err := tlf.ParseTlfTypeFromPath(tlfType)
+	if err != nil {
+		return nil, newStatusError(codeInvalidArgument, "%v", err)
+	}
+	return s.fs.Get(s.newCtx(context.Background()), t, tlfName)
+}
+
+func (s *Server) tlfFS(ctx context.Context, tlfType, tlfName string) (
+	billy.Filesystem, error) {
+	fs, err := s.getFS(tlfType, tlfName)
+	if err != nil {
+		return nil, err
+	}
+	// Use the call's context, so that cancellations and deadlines
+	// apply to the KBFS operations it makes.
+	return fs.WithContext(s.newCtx(ctx)), nil
+}
+
+// watchObserver queues up the changes KBFS reports for one node, so
+// they can be sent without blocking the notification callbacks.
+type watchObserver struct {
+	id       libkbfs.NodeID
+	p        string
+	notifyCh chan struct{}
+
+	lock   sync.Mutex
+	events []fsEvent
+}
+
+var _ libkbfs.Observer = (*watchObserver)(nil)
+
+// LocalChange implements the libkbfs.Observer interface for
+// watchObserver.
+func (wo *watchObserver) LocalChange(
+	ctx context.Context, node libkbfs.Node, write libkbfs.WriteRange) {
+	// Unsynced local changes aren't reported.
+}
+
+// BatchChanges implements the libkbfs.Observer interface for
+// watchObserver.
+func (wo *watchObserver) BatchChanges(
+	ctx context.Context, changes []libkbfs.NodeChange,
+	_ []libkbfs.NodeID) {
+	wo.lock.Lock()
+	defer wo.lock.Unlock()
+	for _, change := range changes {
+		if change.Node.GetID() != wo.id {
+			continue
+		}
+		for _, name := range change.DirUpdated {
+			wo.events = append(wo.events, fsEvent{
+				eventType: watchEventEntryChanged,
+				path:      path.Join(wo.p, name),
+			})
+		}
+		for _, w := range change.FileUpdated {
+			wo.events = append(wo.events, fsEvent{
+				eventType: watchEventFileWritten,
+				path:      wo.p,
+				offset:    w.Off,
+				length:    w.Len,
+			})
+		}
+	}
+	select {
+	case wo.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// TlfHandleChange implements the libkbfs.Observer interface for
+// watchObserver.
+func (wo *watchObserver) TlfHandleChange(
+	ctx context.Context, newHandle *libkbfs.TlfHandle) {
+	// The watched node stays the same.
+}
+
+func (wo *watchObserver) takeQueued() []fsEvent {
+	wo.lock.Lock()
+	defer wo.lock.Unlock()
+	events := wo.events
+	wo.events = nil
+	return events
+}
+
+func (s *Server) watch(ctx context.Context, tlfType, tlfName, p string,
+	emit func(fsEvent) error) error {
+	fs, err := s.getFS(tlfType, tlfName)
+	if err != nil {
+		return err
+	}
+	ctx = s.newCtx(ctx)
+	// Holding on to the node keeps its ID stable while it's watched.
+	node := fs.RootNode()
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		node, _, err = s.config.KBFSOps().Lookup(ctx, node, name)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return newStatusError(
+				codeFailedPrecondition, "Can't watch through a symlink")
+		}
+	}
+
+	wo := &watchObserver{
+		id:       node.GetID(),
+		p:        p,
+		notifyCh: make(chan struct{}, 1),
+	}
+	fbs := []libkbfs.FolderBranch{node.GetFolderBranch()}
+	err = s.config.Notifier().RegisterForChanges(fbs, wo)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.config.Notifier().UnregisterFromChanges(fbs, wo)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wo.notifyCh:
+		}
+		for _, e := range wo.takeQueued() {
+			if err := emit(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ServeHTTP implements the http.Handler interface for Server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.log.Debug("%s %s", r.Method, r.URL.Path)
+	s.handler.ServeHTTP(w, r)
+}
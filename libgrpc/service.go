@@ -0,0 +1,453 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgrpc
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// serviceName is the full name of the service in kbfs.proto.
+const serviceName = "kbfs.v1.KBFS"
+
+// readChunkSize is the most data sent in one ReadResponse.
+const readChunkSize = 64 * 1024
+
+// fsEvent is a change reported by fsSource.watch.
+type fsEvent struct {
+	eventType watchEventType
+	// path is the changed path within the TLF.
+	path   string
+	offset uint64
+	length uint64
+}
+
+// fsSource provides the filesystems of TLFs.
+type fsSource interface {
+	// tlfFS returns the filesystem of the given TLF, where `tlfType`
+	// is "private", "public" or "team".
+	tlfFS(ctx context.Context, tlfType, tlfName string) (
+		billy.Filesystem, error)
+	// watch calls `emit` for each change to the directory or file at
+	// `p` in the given TLF, until `ctx` is done or `emit` returns
+	// an error.
+	watch(ctx context.Context, tlfType, tlfName, p string,
+		emit func(fsEvent) error) error
+}
+
+// service implements the methods of kbfs.proto.
+type service struct {
+	source fsSource
+}
+
+func (s *service) methods() map[string]method {
+	return map[string]method{
+		"Stat":   {unary: s.stat},
+		"List":   {unary: s.list},
+		"Read":   {stream: s.read},
+		"Write":  {unary: s.write},
+		"Mkdir":  {unary: s.mkdir},
+		"Remove": {unary: s.remove},
+		"Rename": {unary: s.rename},
+		"Watch":  {stream: s.watch},
+	}
+}
+
+// osErrToStatus converts the errors a billy.Filesystem returns into
+// statuses.
+func osErrToStatus(err error) *statusError {
+	err = errors.Cause(err)
+	if s, ok := err.(*statusError); ok {
+		return s
+	}
+	switch {
+	case os.IsNotExist(err):
+		return newStatusError(codeNotFound, "%v", err)
+	case os.IsExist(err):
+		return newStatusError(codeAlreadyExists, "%v", err)
+	case os.IsPermission(err):
+		return newStatusError(codePermissionDenied, "%v", err)
+	default:
+		return newStatusError(codeUnknown, "%v", err)
+	}
+}
+
+// tlfPath is a KBFS path split into its TLF and the path within it.
+type tlfPath struct {
+	tlfType string
+	tlfName string
+	// p is the absolute path within the TLF.
+	p string
+}
+
+// full returns the KBFS path for `p` in the same TLF.
+func (tp tlfPath) full(p string) string {
+	return path.Join("/", tp.tlfType, tp.tlfName, p)
+}
+
+func parsePath(p string) (tlfPath, error) {
+	if !strings.HasPrefix(p, "/") {
+		return tlfPath{}, newStatusError(
+			codeInvalidArgument, "%q isn't an absolute path", p)
+	}
+	p = path.Clean(p)
+	if p == "/keybase" || strings.HasPrefix(p, "/keybase/") {
+		p = strings.TrimPrefix(p, "/keybase")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(parts) < 2 {
+		return tlfPath{}, newStatusError(
+			codeInvalidArgument, "%q isn't in a TLF", p)
+	}
+	switch parts[0] {
+	case "private", "public", "team":
+	default:
+		return tlfPath{}, newStatusError(
+			codeInvalidArgument, "%q isn't a TLF type", parts[0])
+	}
+	tp := tlfPath{tlfType: parts[0], tlfName: parts[1], p: "/"}
+	if len(parts) == 3 {
+		tp.p = "/" + parts[2]
+	}
+	return tp, nil
+}
+
+func (s *service) resolve(ctx context.Context, p string) (
+	billy.Filesystem, tlfPath, error) {
+	tp, err := parsePath(p)
+	if err != nil {
+		return nil, tlfPath{}, err
+	}
+	fs, err := s.source.tlfFS(ctx, tp.tlfType, tp.tlfName)
+	if err != nil {
+		return nil, tlfPath{}, err
+	}
+	return fs, tp, nil
+}
+
+func makeFileInfo(fs billy.Filesystem, p string, fi os.FileInfo) (
+	fileInfo, error) {
+	info := fileInfo{
+		name:          fi.Name(),
+		fileType:      fileTypeFile,
+		mtimeUnixNano: fi.ModTime().UnixNano(),
+		executable:    fi.Mode()&0100 != 0,
+	}
+	switch {
+	case fi.IsDir():
+		info.fileType = fileTypeDirectory
+	case fi.Mode()&os.ModeSymlink != 0:
+		info.fileType = fileTypeSymlink
+		target, err := fs.Readlink(p)
+		if err != nil {
+			return fileInfo{}, err
+		}
+		info.symlinkTarget = target
+	default:
+		info.size = uint64(fi.Size())
+	}
+	return info, nil
+}
+
+func (s *service) stat(ctx context.Context, req []byte) (
+	protoMessage, error) {
+	var r pathRequest
+	if err := r.unmarshal(req); err != nil {
+		return nil, err
+	}
+	fs, tp, err := s.resolve(ctx, r.path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Lstat(tp.p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := makeFileInfo(fs, tp.p, fi)
+	if err != nil {
+		return nil, err
+	}
+	if tp.p == "/" {
+		info.name = tp.tlfName
+	}
+	return info, nil
+}
+
+type fileInfosByName []fileInfo
+
+func (f fileInfosByName) Len() int {
+	return len(f)
+}
+
+func (f fileInfosByName) Less(i, j int) bool {
+	return f[i].name < f[j].name
+}
+
+func (f fileInfosByName) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+func (s *service) list(ctx context.Context, req []byte) (
+	protoMessage, error) {
+	var r pathRequest
+	if err := r.unmarshal(req); err != nil {
+		return nil, err
+	}
+	fs, tp, err := s.resolve(ctx, r.path)
+	if err != nil {
+		return nil, err
+	}
+	children, err := fs.ReadDir(tp.p)
+	if err != nil {
+		return nil, err
+	}
+	var resp listResponse
+	for _, fi := range children {
+		info, err := makeFileInfo(fs, path.Join(tp.p, fi.Name()), fi)
+		if err != nil {
+			return nil, err
+		}
+		resp.entries = append(resp.entries, info)
+	}
+	sort.Sort(fileInfosByName(resp.entries))
+	return resp, nil
+}
+
+func (s *service) read(ctx context.Context, req []byte,
+	send func(protoMessage) error) error {
+	var r readRequest
+	if err := r.unmarshal(req); err != nil {
+		return err
+	}
+	fs, tp, err := s.resolve(ctx, r.path)
+	if err != nil {
+		return err
+	}
+	f, err := fs.Open(tp.p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fi, err := fs.Stat(tp.p); err != nil {
+		return err
+	} else if fi.IsDir() {
+		return newStatusError(
+			codeFailedPrecondition, "%s is a directory", r.path)
+	}
+	if _, err := f.Seek(int64(r.offset), io.SeekStart); err != nil {
+		return err
+	}
+
+	var src io.Reader = f
+	if r.length > 0 {
+		src = io.LimitReader(f, int64(r.length))
+	}
+	buf := make([]byte, readChunkSize)
+	offset := r.offset
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			sendErr := send(readResponse{data: buf[:n], offset: offset})
+			if sendErr != nil {
+				return sendErr
+			}
+			offset += uint64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *service) write(ctx context.Context, req []byte) (
+	protoMessage, error) {
+	var r writeRequest
+	if err := r.unmarshal(req); err != nil {
+		return nil, err
+	}
+	fs, tp, err := s.resolve(ctx, r.path)
+	if err != nil {
+		return nil, err
+	}
+	flags := os.O_WRONLY
+	if r.create {
+		flags |= os.O_CREATE
+	}
+	if r.exclusive {
+		flags |= os.O_EXCL
+	}
+	if r.truncate {
+		flags |= os.O_TRUNC
+	}
+	if r.append {
+		flags |= os.O_APPEND
+	}
+	f, err := fs.OpenFile(tp.p, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if !r.append {
+		_, err = f.Seek(int64(r.offset), io.SeekStart)
+	}
+	if err == nil {
+		_, err = f.Write(r.data)
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = libfs.SyncFS(fs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Stat(tp.p)
+	if err != nil {
+		return nil, err
+	}
+	return writeResponse{size: uint64(fi.Size())}, nil
+}
+
+func (s *service) mkdir(ctx context.Context, req []byte) (
+	protoMessage, error) {
+	var r flagRequest
+	if err := r.unmarshal(req); err != nil {
+		return nil, err
+	}
+	fs, tp, err := s.resolve(ctx, r.path)
+	if err != nil {
+		return nil, err
+	}
+	if !r.flag {
+		// billy only has MkdirAll, so check what Mkdir would.
+		if _, err := fs.Lstat(tp.p); err == nil {
+			return nil, os.ErrExist
+		}
+		if fi, err := fs.Stat(path.Dir(tp.p)); err != nil {
+			return nil, err
+		} else if !fi.IsDir() {
+			return nil, newStatusError(codeFailedPrecondition,
+				"%s isn't a directory", tp.full(path.Dir(tp.p)))
+		}
+	}
+	if err := fs.MkdirAll(tp.p, 0755); err != nil {
+		return nil, err
+	}
+	return empty{}, libfs.SyncFS(fs)
+}
+
+func (s *service) remove(ctx context.Context, req []byte) (
+	protoMessage, error) {
+	var r flagRequest
+	if err := r.unmarshal(req); err != nil {
+		return nil, err
+	}
+	fs, tp, err := s.resolve(ctx, r.path)
+	if err != nil {
+		return nil, err
+	}
+	if tp.p == "/" {
+		return nil, newStatusError(
+			codeInvalidArgument, "Can't remove a TLF")
+	}
+	if r.flag {
+		if _, err := fs.Lstat(tp.p); err != nil {
+			return nil, err
+		}
+		err = util.RemoveAll(fs, tp.p)
+	} else {
+		err = fs.Remove(tp.p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return empty{}, libfs.SyncFS(fs)
+}
+
+func (s *service) rename(ctx context.Context, req []byte) (
+	protoMessage, error) {
+	var r renameRequest
+	if err := r.unmarshal(req); err != nil {
+		return nil, err
+	}
+	fs, oldTP, err := s.resolve(ctx, r.oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newTP, err := parsePath(r.newPath)
+	if err != nil {
+		return nil, err
+	}
+	if oldTP.tlfType != newTP.tlfType || oldTP.tlfName != newTP.tlfName {
+		return nil, newStatusError(
+			codeInvalidArgument, "Can't rename between TLFs")
+	}
+	if oldTP.p == "/" || newTP.p == "/" {
+		return nil, newStatusError(
+			codeInvalidArgument, "Can't rename a TLF")
+	}
+	if err := fs.Rename(oldTP.p, newTP.p); err != nil {
+		return nil, err
+	}
+	return empty{}, libfs.SyncFS(fs)
+}
+
+func (s *service) watch(ctx context.Context, req []byte,
+	send func(protoMessage) error) error {
+	var r pathRequest
+	if err := r.unmarshal(req); err != nil {
+		return err
+	}
+	fs, tp, err := s.resolve(ctx, r.path)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.Lstat(tp.p); err != nil {
+		return err
+	}
+	err = s.source.watch(ctx, tp.tlfType, tp.tlfName, tp.p,
+		func(e fsEvent) error {
+			return send(watchEvent{
+				eventType: e.eventType,
+				path:      tp.full(e.path),
+				offset:    e.offset,
+				length:    e.length,
+			})
+		})
+	if err == context.Canceled && ctx.Err() == context.Canceled {
+		// The client hung up, which is how watches end.
+		return nil
+	}
+	return err
+}
+
+// newServiceHandler returns a handler serving kbfs.proto for the
+// TLFs from `source`, to clients with `token`.  `toStatus` converts
+// errors the methods return; it defaults to osErrToStatus.
+func newServiceHandler(source fsSource, token string,
+	toStatus func(err error) *statusError) *grpcHandler {
+	s := &service{source: source}
+	if toStatus == nil {
+		toStatus = osErrToStatus
+	}
+	return &grpcHandler{
+		service:  serviceName,
+		methods:  s.methods(),
+		token:    token,
+		toStatus: toStatus,
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+type testSource struct {
+	dir     string
+	watches chan chan fsEvent
+}
+
+func (s testSource) tlfFS(ctx context.Context, tlfType, tlfName string) (
+	billy.Filesystem, error) {
+	if tlfType != "private" || tlfName != "jdoe" {
+		return nil, os.ErrNotExist
+	}
+	return osfs.New(s.dir), nil
+}
+
+func (s testSource) watch(ctx context.Context, tlfType, tlfName, p string,
+	emit func(fsEvent) error) error {
+	ch := make(chan fsEvent)
+	s.watches <- ch
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-ch:
+			if err := emit(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// testRequest is a request message built by the test.
+type testRequest func(b *protoBuffer)
+
+func (r testRequest) marshal(b *protoBuffer) {
+	r(b)
+}
+
+type testClient struct {
+	t      *testing.T
+	url    string
+	token  string
+	client *http.Client
+}
+
+// start starts a call, and returns the response.
+func (c *testClient) start(ctx context.Context, method string,
+	req testRequest) *http.Response {
+	data := marshalProto(req)
+	body := make([]byte, messageHeaderLen, messageHeaderLen+len(data))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(data)))
+	body = append(body, data...)
+	r, err := http.NewRequest(http.MethodPost,
+		c.url+"/"+serviceName+"/"+method, bytes.NewReader(body))
+	require.NoError(c.t, err)
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", grpcContentType)
+	r.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(r)
+	require.NoError(c.t, err)
+	require.Equal(c.t, 2, resp.ProtoMajor)
+	require.Equal(c.t, http.StatusOK, resp.StatusCode)
+	return resp
+}
+
+// call makes a call, and returns its responses and status.
+func (c *testClient) call(method string, req testRequest) (
+	resps [][]byte, status code, msg string) {
+	resp := c.start(context.Background(), method, req)
+	defer resp.Body.Close()
+	for {
+		m, err := readMessage(resp.Body)
+		if err != nil {
+			break
+		}
+		resps = append(resps, m)
+	}
+	s, err := strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	require.NoError(c.t, err)
+	return resps, code(s), resp.Trailer.Get("Grpc-Message")
+}
+
+func (c *testClient) mustCall(method string, req testRequest) [][]byte {
+	resps, status, msg := c.call(method, req)
+	require.Equal(c.t, codeOK, status, msg)
+	return resps
+}
+
+func (c *testClient) requireStatus(expected code, method string,
+	req testRequest) {
+	_, status, msg := c.call(method, req)
+	require.Equal(c.t, expected, status, msg)
+}
+
+func pathReq(p string) testRequest {
+	return func(b *protoBuffer) { b.stringField(1, p) }
+}
+
+// decodeFields returns the last value of each field in `data`.
+func decodeFields(t *testing.T, data []byte) map[int]protoField {
+	fields := make(map[int]protoField)
+	require.NoError(t, parseProto(data, func(f protoField) error {
+		fields[f.num] = f
+		return nil
+	}))
+	return fields
+}
+
+func decodeFileInfo(t *testing.T, data []byte) fileInfo {
+	f := decodeFields(t, data)
+	return fileInfo{
+		name:          string(f[1].bytes),
+		fileType:      fileType(f[2].value),
+		size:          f[3].value,
+		mtimeUnixNano: int64(f[4].value),
+		executable:    f[5].value != 0,
+		symlinkTarget: string(f[6].bytes),
+	}
+}
+
+func TestWire(t *testing.T) {
+	var b protoBuffer
+	b.stringField(1, "a")
+	b.uint64Field(2, 300)
+	b.boolField(3, false)
+	b.int64Field(4, -1)
+	b.messageField(5, empty{})
+	require.Equal(t, []byte{
+		0x0a, 1, 'a',
+		0x10, 0xac, 0x02,
+		0x20, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x2a, 0,
+	}, b.buf)
+
+	// Unknown fields of every wire type are skipped.
+	data := append([]byte{
+		0x31, 1, 2, 3, 4, 5, 6, 7, 8,
+		0x3d, 1, 2, 3, 4,
+	}, b.buf...)
+	var r readRequest
+	require.NoError(t, r.unmarshal(data))
+	require.Equal(t, readRequest{path: "a", offset: 300}, r)
+	require.Equal(t, errBadProto, r.unmarshal([]byte{0x0a, 5, 'a'}))
+	require.Equal(t, errBadProto, r.unmarshal([]byte{0x10, 0x80}))
+	require.Equal(t, errBadProto, r.unmarshal([]byte{0x12, 0}))
+}
+
+func TestService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libgrpc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	source := testSource{dir: dir, watches: make(chan chan fsEvent)}
+	server := httptest.NewUnstartedServer(
+		newServiceHandler(source, "secret", nil))
+	server.Config = newHTTPServer(server.Config.Handler)
+	server.Start()
+	defer server.Close()
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	c := &testClient{t, server.URL, "secret", client}
+
+	t.Log("Bad tokens, methods and paths are rejected")
+	bad := &testClient{t, server.URL, "wrong", client}
+	bad.requireStatus(codeUnauthenticated, "Stat", pathReq("/private/jdoe"))
+	c.requireStatus(codeUnimplemented, "Chmod", pathReq("/private/jdoe"))
+	c.requireStatus(codeInvalidArgument, "Stat", pathReq("private/jdoe"))
+	c.requireStatus(codeInvalidArgument, "Stat", pathReq("/private"))
+	c.requireStatus(codeInvalidArgument, "Stat", pathReq("/other/jdoe"))
+	c.requireStatus(codeNotFound, "Stat", pathReq("/private/other"))
+	c.requireStatus(codeInvalidArgument, "Stat",
+		func(b *protoBuffer) { b.uint64Field(1, 1) })
+
+	t.Log("Write and stat a file")
+	data := bytes.Repeat([]byte("0123456789"), readChunkSize/4)
+	write := func(p string, offset uint64, data []byte,
+		flags ...int) testRequest {
+		return func(b *protoBuffer) {
+			b.stringField(1, p)
+			b.uint64Field(2, offset)
+			b.bytesField(3, data)
+			for _, f := range flags {
+				b.boolField(f, true)
+			}
+		}
+	}
+	c.requireStatus(codeNotFound, "Write",
+		write("/private/jdoe/a.txt", 0, data))
+	resps := c.mustCall("Write", write("/private/jdoe/a.txt", 0, data, 4))
+	require.Len(t, resps, 1)
+	require.Equal(t, uint64(len(data)), decodeFields(t, resps[0])[1].value)
+	c.requireStatus(codeAlreadyExists, "Write",
+		write("/private/jdoe/a.txt", 0, data, 4, 5))
+	resps = c.mustCall("Write",
+		write("/keybase/private/jdoe/a.txt", 0, []byte("!!"), 7))
+	require.Equal(t, uint64(len(data)+2), decodeFields(t, resps[0])[1].value)
+	data = append(data, "!!"...)
+	resps = c.mustCall("Stat", pathReq("/private/jdoe/a.txt"))
+	info := decodeFileInfo(t, resps[0])
+	require.Equal(t, "a.txt", info.name)
+	require.Equal(t, fileTypeFile, info.fileType)
+	require.Equal(t, uint64(len(data)), info.size)
+
+	t.Log("Read the file in chunks")
+	resps = c.mustCall("Read", pathReq("/private/jdoe/a.txt"))
+	require.Len(t, resps, 3)
+	var read []byte
+	for _, resp := range resps {
+		f := decodeFields(t, resp)
+		require.Equal(t, uint64(len(read)), f[2].value)
+		read = append(read, f[1].bytes...)
+	}
+	require.Equal(t, data, read)
+	resps = c.mustCall("Read", func(b *protoBuffer) {
+		b.stringField(1, "/private/jdoe/a.txt")
+		b.uint64Field(2, 5)
+		b.uint64Field(3, 10)
+	})
+	require.Len(t, resps, 1)
+	require.Equal(t, data[5:15], decodeFields(t, resps[0])[1].bytes)
+	c.requireStatus(codeFailedPrecondition, "Read", pathReq("/private/jdoe"))
+
+	t.Log("Make directories and list them")
+	mkdir := func(p string, parents bool) testRequest {
+		return func(b *protoBuffer) {
+			b.stringField(1, p)
+			b.boolField(2, parents)
+		}
+	}
+	c.requireStatus(codeNotFound, "Mkdir",
+		mkdir("/private/jdoe/d/e", false))
+	c.mustCall("Mkdir", mkdir("/private/jdoe/d/e", true))
+	c.mustCall("Mkdir", mkdir("/private/jdoe/d/e", true))
+	c.requireStatus(codeAlreadyExists, "Mkdir",
+		mkdir("/private/jdoe/d", false))
+	c.mustCall("Mkdir", mkdir("/private/jdoe/d/f", false))
+	require.NoError(t, os.Symlink("a.txt", dir+"/link"))
+	resps = c.mustCall("List", pathReq("/private/jdoe"))
+	require.Len(t, resps, 1)
+	var entries []fileInfo
+	require.NoError(t, parseProto(resps[0], func(f protoField) error {
+		entries = append(entries, decodeFileInfo(t, f.bytes))
+		return nil
+	}))
+	require.Len(t, entries, 3)
+	require.Equal(t, "a.txt", entries[0].name)
+	require.Equal(t, "d", entries[1].name)
+	require.Equal(t, fileTypeDirectory, entries[1].fileType)
+	require.Equal(t, "link", entries[2].name)
+	require.Equal(t, fileTypeSymlink, entries[2].fileType)
+	require.Equal(t, "a.txt", entries[2].symlinkTarget)
+
+	t.Log("Rename and remove")
+	rename := func(from, to string) testRequest {
+		return func(b *protoBuffer) {
+			b.stringField(1, from)
+			b.stringField(2, to)
+		}
+	}
+	c.mustCall("Rename", rename("/private/jdoe/a.txt", "/private/jdoe/d/b.txt"))
+	c.requireStatus(codeInvalidArgument, "Rename",
+		rename("/private/jdoe/d/b.txt", "/private/other/b.txt"))
+	_, err = os.Stat(dir + "/d/b.txt")
+	require.NoError(t, err)
+	c.requireStatus(codeInvalidArgument, "Remove", pathReq("/private/jdoe"))
+	c.mustCall("Remove", pathReq("/private/jdoe/link"))
+	c.requireStatus(codeNotFound, "Remove", mkdir("/private/jdoe/x", true))
+	c.mustCall("Remove", mkdir("/private/jdoe/d", true))
+	_, err = os.Stat(dir + "/d")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Watch a directory until cancelled")
+	c.requireStatus(codeNotFound, "Watch", pathReq("/private/jdoe/d"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp := c.start(ctx, "Watch", pathReq("/private/jdoe"))
+	defer resp.Body.Close()
+	ch := <-source.watches
+	ch <- fsEvent{eventType: watchEventEntryChanged, path: "/new"}
+	ch <- fsEvent{eventType: watchEventFileWritten, path: "/new",
+		offset: 3, length: 4}
+	m, err := readMessage(resp.Body)
+	require.NoError(t, err)
+	f := decodeFields(t, m)
+	require.Equal(t, uint64(watchEventEntryChanged), f[1].value)
+	require.Equal(t, "/private/jdoe/new", string(f[2].bytes))
+	m, err = readMessage(resp.Body)
+	require.NoError(t, err)
+	f = decodeFields(t, m)
+	require.Equal(t, uint64(watchEventFileWritten), f[1].value)
+	require.Equal(t, uint64(3), f[3].value)
+	require.Equal(t, uint64(4), f[4].value)
+}
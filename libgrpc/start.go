@@ -0,0 +1,54 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgrpc
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// StartOptions are options for starting up.
+type StartOptions struct {
+	KbfsParams libkbfs.InitParams
+	// ListenAddr is the loopback address to serve gRPC on.
+	ListenAddr string
+	// Token is the bearer token clients must present.
+	Token string
+}
+
+// newHTTPServer returns a server for `handler` that speaks HTTP/2
+// without TLS ("h2c"), as gRPC clients expect of plaintext servers.
+func newHTTPServer(handler http.Handler) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{Handler: handler, Protocols: &protocols}
+}
+
+// Start starts KBFS and serves the gRPC file API until interrupted.
+func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
+	err := libfs.CheckLoopbackAddr(options.ListenAddr)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
+	return libfs.StartServer(options.KbfsParams, kbCtx, func(
+		config libkbfs.Config, log logger.Logger) (libfs.Mounter, error) {
+		s, err := NewServer(config, options.Token)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", options.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Serving gRPC on %s", listener.Addr())
+		return libfs.NewListenerMounter(newHTTPServer(s), listener), nil
+	})
+}
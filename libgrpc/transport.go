@@ -0,0 +1,271 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgrpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements the server side of gRPC over HTTP/2
+// (https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md),
+// for unary and server-streaming methods, without compression.
+
+const (
+	grpcContentType = "application/grpc"
+	// maxMessageSize is the largest request message accepted, which
+	// matches the default limit of gRPC clients.
+	maxMessageSize = 4 << 20
+	// messageHeaderLen is the length of the compressed flag and
+	// length that precede each message.
+	messageHeaderLen = 5
+)
+
+// code is a gRPC status code.
+type code uint32
+
+const (
+	codeOK                 code = 0
+	codeCanceled           code = 1
+	codeUnknown            code = 2
+	codeInvalidArgument    code = 3
+	codeDeadlineExceeded   code = 4
+	codeNotFound           code = 5
+	codeAlreadyExists      code = 6
+	codePermissionDenied   code = 7
+	codeResourceExhausted  code = 8
+	codeFailedPrecondition code = 9
+	codeUnimplemented      code = 12
+	codeInternal           code = 13
+	codeUnauthenticated    code = 16
+)
+
+// statusError is an error with a gRPC status code, to be returned to
+// the client.
+type statusError struct {
+	code    code
+	message string
+}
+
+func newStatusError(c code, format string, args ...interface{}) *statusError {
+	return &statusError{code: c, message: fmt.Sprintf(format, args...)}
+}
+
+// Error implements the error interface for statusError.
+func (e *statusError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.code, e.message)
+}
+
+// unaryMethod handles a call with a single request and response.
+type unaryMethod func(ctx context.Context, req []byte) (protoMessage, error)
+
+// streamMethod handles a call with a single request and a stream of
+// responses, which it sends with `send`.
+type streamMethod func(ctx context.Context, req []byte,
+	send func(protoMessage) error) error
+
+// method is a method of a service; exactly one of its fields is set.
+type method struct {
+	unary  unaryMethod
+	stream streamMethod
+}
+
+// grpcHandler serves a single gRPC service, authenticating clients
+// with a bearer token.
+type grpcHandler struct {
+	service string
+	methods map[string]method
+	token   string
+	// toStatus converts an error from a method into a status.
+	toStatus func(err error) *statusError
+}
+
+var _ http.Handler = (*grpcHandler)(nil)
+
+// encodeGrpcMessage percent-encodes a status message, as
+// grpc-message requires.
+func encodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseTimeout parses a grpc-timeout header value.
+func parseTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// readMessage reads a single length-prefixed message.
+func readMessage(r io.Reader) ([]byte, error) {
+	var header [messageHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, newStatusError(codeInvalidArgument, "No request message")
+	}
+	if header[0] != 0 {
+		return nil, newStatusError(
+			codeUnimplemented, "Compressed messages aren't supported")
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > maxMessageSize {
+		return nil, newStatusError(codeResourceExhausted,
+			"Request message is larger than %d bytes", maxMessageSize)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, newStatusError(codeInvalidArgument, "Short request message")
+	}
+	return buf, nil
+}
+
+func writeMessage(w http.ResponseWriter, m protoMessage) error {
+	data := marshalProto(m)
+	buf := make([]byte, messageHeaderLen, messageHeaderLen+len(data))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(data)))
+	if _, err := w.Write(append(buf, data...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (h *grpcHandler) checkToken(r *http.Request) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(h.token)) == 1
+}
+
+// call runs the method named by `r`, writing any responses to `w`.
+func (h *grpcHandler) call(w http.ResponseWriter, r *http.Request) error {
+	if !h.checkToken(r) {
+		return newStatusError(codeUnauthenticated, "Invalid token")
+	}
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		return newStatusError(
+			codeUnimplemented, "Encoding %s isn't supported", enc)
+	}
+	prefix := "/" + h.service + "/"
+	m, ok := h.methods[strings.TrimPrefix(r.URL.Path, prefix)]
+	if !strings.HasPrefix(r.URL.Path, prefix) || !ok {
+		return newStatusError(
+			codeUnimplemented, "Unknown method %s", r.URL.Path)
+	}
+
+	ctx := r.Context()
+	if s := r.Header.Get("Grpc-Timeout"); s != "" {
+		timeout, ok := parseTimeout(s)
+		if !ok {
+			return newStatusError(codeInvalidArgument, "Bad timeout %q", s)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	if m.unary != nil {
+		resp, err := m.unary(ctx, req)
+		if err != nil {
+			return err
+		}
+		return writeMessage(w, resp)
+	}
+	return m.stream(ctx, req, func(resp protoMessage) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return writeMessage(w, resp)
+	})
+}
+
+func (h *grpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "gRPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2",
+			http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType) {
+		http.Error(w, "Not a gRPC request",
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", grpcContentType)
+	w.Header().Add("Trailer", "Grpc-Status")
+	w.Header().Add("Trailer", "Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	// Send the headers now, so clients of long-lived streams like
+	// Watch know the call started.
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	err := h.call(w, r)
+	s := &statusError{code: codeOK}
+	switch e := err.(type) {
+	case nil:
+	case *statusError:
+		s = e
+	default:
+		switch err {
+		case context.DeadlineExceeded:
+			s = newStatusError(codeDeadlineExceeded, "%v", err)
+		case context.Canceled:
+			s = newStatusError(codeCanceled, "%v", err)
+		default:
+			s = h.toStatus(err)
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(s.code)))
+	if s.message != "" {
+		w.Header().Set("Grpc-Message", encodeGrpcMessage(s.message))
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgrpc
+
+import (
+	"encoding/binary"
+)
+
+// This file implements the parts of the protobuf wire format
+// (https://developers.google.com/protocol-buffers/docs/encoding)
+// needed by the messages in kbfs.proto.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoMessage is a message that can be encoded.
+type protoMessage interface {
+	marshal(b *protoBuffer)
+}
+
+// protoBuffer encodes a message.  As in proto3, fields holding their
+// default value aren't encoded.
+type protoBuffer struct {
+	buf []byte
+}
+
+func marshalProto(m protoMessage) []byte {
+	var b protoBuffer
+	m.marshal(&b)
+	return b.buf
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	b.buf = append(b.buf, buf[:n]...)
+}
+
+func (b *protoBuffer) tag(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64Field(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, wireVarint)
+	b.varint(v)
+}
+
+func (b *protoBuffer) int64Field(field int, v int64) {
+	b.uint64Field(field, uint64(v))
+}
+
+func (b *protoBuffer) boolField(field int, v bool) {
+	if v {
+		b.uint64Field(field, 1)
+	}
+}
+
+func (b *protoBuffer) bytesField(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *protoBuffer) stringField(field int, v string) {
+	b.bytesField(field, []byte(v))
+}
+
+// messageField encodes `m` even if it's empty, so that it can be
+// used for repeated fields.
+func (b *protoBuffer) messageField(field int, m protoMessage) {
+	var inner protoBuffer
+	m.marshal(&inner)
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(inner.buf)))
+	b.buf = append(b.buf, inner.buf...)
+}
+
+var errBadProto = newStatusError(
+	codeInvalidArgument, "Malformed protobuf message")
+
+// protoField is a single decoded field.
+type protoField struct {
+	num      int
+	wireType int
+	// value holds the value of varint and fixed fields.
+	value uint64
+	// bytes holds the value of length-delimited fields.
+	bytes []byte
+}
+
+func (f protoField) uint64() (uint64, error) {
+	if f.wireType != wireVarint {
+		return 0, errBadProto
+	}
+	return f.value, nil
+}
+
+func (f protoField) bool() (bool, error) {
+	v, err := f.uint64()
+	return v != 0, err
+}
+
+func (f protoField) string() (string, error) {
+	if f.wireType != wireBytes {
+		return "", errBadProto
+	}
+	return string(f.bytes), nil
+}
+
+func (f protoField) byteSlice() ([]byte, error) {
+	if f.wireType != wireBytes {
+		return nil, errBadProto
+	}
+	return f.bytes, nil
+}
+
+// parseProto calls `fn` for each field in `data`, in order.  Fields
+// that `fn` doesn't know about should be ignored, so that older
+// servers can talk to newer clients.
+func parseProto(data []byte, fn func(f protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 {
+			return errBadProto
+		}
+		data = data[n:]
+		f := protoField{num: int(key >> 3), wireType: int(key & 7)}
+		switch f.wireType {
+		case wireVarint:
+			f.value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errBadProto
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errBadProto
+			}
+			f.value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errBadProto
+			}
+			f.value = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return errBadProto
+			}
+			f.bytes = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return errBadProto
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
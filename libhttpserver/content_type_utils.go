@@ -40,6 +40,9 @@ func (w *contentTypeOverridingResponseWriter) override() {
 		w.original.Header().Set("Content-Type", w.overrideMimeType(t))
 	}
 	w.original.Header().Set("X-Content-Type-Options", "nosniff")
+	// The token is in the URL, so don't leak it to any links that
+	// get followed.
+	w.original.Header().Set("Referrer-Policy", "no-referrer")
 }
 
 func (w *contentTypeOverridingResponseWriter) Header() http.Header {
@@ -8,7 +8,6 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"errors"
 	"io"
 	"net/http"
 	"path"
@@ -23,6 +22,7 @@ import (
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/libmime"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 )
 
 const tokenCacheSize = 64
@@ -71,21 +71,21 @@ func (s *Server) handleInvalidToken(w http.ResponseWriter) {
     `)
 }
 
-func (s *Server) handleBadRequest(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusBadRequest)
+func (s *Server) handleError(w http.ResponseWriter, status int) {
+	w.WriteHeader(status)
 }
 
-type obsoleteTrackingFS struct {
-	fs *libfs.FS
-	ch <-chan struct{}
-}
-
-func (e obsoleteTrackingFS) isObsolete() bool {
-	select {
-	case <-e.ch:
-		return true
+// errToStatus returns the HTTP status code to send when looking up
+// the TLF named in a request fails with `err`.
+func errToStatus(err error) int {
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError, libkbfs.NoSuchUserError,
+		libkbfs.NoSuchTeamError:
+		return http.StatusNotFound
+	case libkbfs.ReadAccessError:
+		return http.StatusForbidden
 	default:
-		return false
+		return http.StatusBadRequest
 	}
 }
 
@@ -104,9 +104,9 @@ func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
 	toStrip = path.Join(fields[0], fields[1])
 
 	if fsCached, ok := s.fs.Get(toStrip); ok {
-		if fsCachedTyped, ok := fsCached.(obsoleteTrackingFS); ok {
-			if !fsCachedTyped.isObsolete() {
-				return toStrip, fsCachedTyped.fs.ToHTTPFileSystem(ctx), nil
+		if fsCachedTyped, ok := fsCached.(libfs.ObsoleteTrackingFS); ok {
+			if !fsCachedTyped.IsObsolete() {
+				return toStrip, fsCachedTyped.FS.ToHTTPFileSystem(ctx), nil
 			}
 		}
 	}
@@ -123,12 +123,12 @@ func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
 		return "", nil, err
 	}
 
-	fsLifeCh, err := tlfFS.SubscribeToObsolete()
+	tracked, err := libfs.NewObsoleteTrackingFS(tlfFS)
 	if err != nil {
 		return "", nil, err
 	}
 
-	s.fs.Add(toStrip, obsoleteTrackingFS{fs: tlfFS, ch: fsLifeCh})
+	s.fs.Add(toStrip, tracked)
 
 	return toStrip, tlfFS.ToHTTPFileSystem(ctx), nil
 }
@@ -136,8 +136,14 @@ func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
 // serve accepts "/<fs path>?token=<token>"
 // For example:
 //     /team/keybase/file.txt?token=1234567890abcdef1234567890abcdef
+// Range requests are supported, so media can be streamed.
 func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
 	s.logger.Debug("Incoming request from %q: %s", req.UserAgent(), req.URL)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.handleError(w, http.StatusMethodNotAllowed)
+		return
+	}
 	token := req.URL.Query().Get("token")
 	if len(token) == 0 || !s.tokens.Contains(token) {
 		s.logger.Info("Invalid token %q", token)
@@ -147,7 +153,7 @@ func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
 	toStrip, fs, err := s.getHTTPFileSystem(req.Context(), req.URL.Path)
 	if err != nil {
 		s.logger.Warning("Bad request; error=%v", err)
-		s.handleBadRequest(w)
+		s.handleError(w, errToStatus(err))
 		return
 	}
 	http.StripPrefix(toStrip, http.FileServer(fs)).ServeHTTP(
@@ -85,6 +85,14 @@ func TestServerDefault(t *testing.T) {
 		"http://%s/files/private/alice,bob/test.txt?token=%s", addr, token))
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+	require.Equal(t, "no-referrer", resp.Header.Get("Referrer-Policy"))
+
+	resp, err = http.Post(fmt.Sprintf(
+		"http://%s/files/private/alice,bob/test.txt?token=%s", addr, token),
+		"text/plain", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
 
 	resp, err = http.Get(fmt.Sprintf(
 		"http://%s/files/blah/alice,bob/non-existent?token=%s", addr, token))
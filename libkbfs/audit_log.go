@@ -0,0 +1,354 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// AuditEventType is the type of an access-relevant event in a TLF.
+type AuditEventType string
+
+const (
+	// AuditEventCreate is a file or directory being created.
+	AuditEventCreate AuditEventType = "create"
+	// AuditEventModify is a file being written to.
+	AuditEventModify AuditEventType = "modify"
+	// AuditEventRename is a file or directory being renamed.
+	AuditEventRename AuditEventType = "rename"
+	// AuditEventDelete is a file or directory being deleted.
+	AuditEventDelete AuditEventType = "delete"
+	// AuditEventRekey is a device being given access to the TLF.
+	AuditEventRekey AuditEventType = "rekey"
+	// AuditEventKeyRotation is a new key generation, usually after a
+	// device or member was removed.
+	AuditEventKeyRotation AuditEventType = "key-rotation"
+	// AuditEventHandleChange is the TLF's name changing, e.g. when an
+	// assertion is resolved or a team is renamed.
+	AuditEventHandleChange AuditEventType = "handle-change"
+)
+
+// AuditEvent is a single access-relevant event in a merged revision
+// of a TLF.
+type AuditEvent struct {
+	Revision kbfsmd.Revision `codec:"r" json:"revision"`
+	// Time is the server's time for the revision.
+	Time   keybase1.Time           `codec:"t" json:"time"`
+	Type   AuditEventType          `codec:"y" json:"type"`
+	Writer keybase1.UID            `codec:"w" json:"writer"`
+	Device kbfscrypto.VerifyingKey `codec:"d" json:"device"`
+	// Path is set for edits, relative to the root of the TLF.
+	Path string `codec:"p,omitempty" json:"path,omitempty"`
+	// OldPath is set for renames.
+	OldPath string `codec:"o,omitempty" json:"oldPath,omitempty"`
+	// KeyGen is set for key rotations.
+	KeyGen kbfsmd.KeyGen `codec:"k,omitempty" json:"keyGen,omitempty"`
+	// Name is set for handle changes.
+	Name tlf.CanonicalName `codec:"n,omitempty" json:"name,omitempty"`
+}
+
+// AuditLogEntry is an entry of a TLF's audit log.  Each entry
+// includes the hash of the previous one, and is signed by the device
+// that appended it, so that entries can't be changed, removed or
+// reordered without it being noticed.
+type AuditLogEntry struct {
+	Seqno uint64 `codec:"s" json:"seqno"`
+	// PrevHash is empty for the first entry.
+	PrevHash []byte     `codec:"h,omitempty" json:"prevHash,omitempty"`
+	Event    AuditEvent `codec:"e" json:"event"`
+	// Signature covers the entry with an empty signature.
+	Signature kbfscrypto.SignatureInfo `codec:"sig" json:"signature"`
+}
+
+func (e AuditLogEntry) signedBytes(codec kbfscodec.Codec) ([]byte, error) {
+	e.Signature = kbfscrypto.SignatureInfo{}
+	return codec.Encode(e)
+}
+
+func (e AuditLogEntry) hash(codec kbfscodec.Codec) ([]byte, error) {
+	buf, err := codec.Encode(e)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(buf)
+	return h[:], nil
+}
+
+// VerifyAuditLog checks that `entries` form an unbroken chain
+// starting from the first entry of a log (with seqno 1), and that
+// each of them is validly signed.  It doesn't check who signed them.
+func VerifyAuditLog(
+	codec kbfscodec.Codec, entries []AuditLogEntry) error {
+	var prevHash []byte
+	for i, e := range entries {
+		seqno := uint64(firstValidJournalOrdinal) + uint64(i)
+		if e.Seqno != seqno {
+			return errors.Errorf(
+				"Expected seqno %d, got %d", seqno, e.Seqno)
+		}
+		if !bytes.Equal(e.PrevHash, prevHash) {
+			return errors.Errorf(
+				"Entry %d doesn't follow the previous entry", seqno)
+		}
+		buf, err := e.signedBytes(codec)
+		if err != nil {
+			return err
+		}
+		err = kbfscrypto.Verify(buf, e.Signature)
+		if err != nil {
+			return errors.WithMessage(
+				err, fmt.Sprintf("Bad signature for entry %d", seqno))
+		}
+		prevHash, err = e.hash(codec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tlfAuditLogInfo is persisted next to the entries of a TLF's audit
+// log.
+type tlfAuditLogInfo struct {
+	// LastRevision is the last revision checked for events, which
+	// may be later than the revision of the last entry.
+	LastRevision kbfsmd.Revision `codec:"r"`
+}
+
+// tlfAuditLog is the audit log of a single TLF.  It's not
+// goroutine-safe.
+type tlfAuditLog struct {
+	codec    kbfscodec.Codec
+	dir      string
+	j        *diskJournal
+	info     tlfAuditLogInfo
+	lastHash []byte
+}
+
+func openTlfAuditLog(codec kbfscodec.Codec, dir string) (
+	*tlfAuditLog, error) {
+	j, err := makeDiskJournal(
+		codec, filepath.Join(dir, "entries"), reflect.TypeOf(AuditLogEntry{}))
+	if err != nil {
+		return nil, err
+	}
+	l := &tlfAuditLog{codec: codec, dir: dir, j: j}
+	err = kbfscodec.DeserializeFromFile(codec, l.infoPath(), &l.info)
+	if ioutil.IsNotExist(err) {
+		// Nothing has been checked yet.
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !j.empty() {
+		latest, err := j.readLatestOrdinal()
+		if err != nil {
+			return nil, err
+		}
+		e, err := j.readJournalEntry(latest)
+		if err != nil {
+			return nil, err
+		}
+		l.lastHash, err = e.(AuditLogEntry).hash(codec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *tlfAuditLog) infoPath() string {
+	return filepath.Join(l.dir, "info")
+}
+
+func (l *tlfAuditLog) append(ctx context.Context, signer kbfscrypto.Signer,
+	events []AuditEvent, lastRev kbfsmd.Revision) error {
+	for _, event := range events {
+		e := AuditLogEntry{
+			Seqno:    uint64(firstValidJournalOrdinal) + l.j.length(),
+			PrevHash: l.lastHash,
+			Event:    event,
+		}
+		buf, err := e.signedBytes(l.codec)
+		if err != nil {
+			return err
+		}
+		e.Signature, err = signer.SignForKBFS(ctx, buf)
+		if err != nil {
+			return err
+		}
+		o := journalOrdinal(e.Seqno)
+		_, err = l.j.appendJournalEntry(&o, e)
+		if err != nil {
+			return err
+		}
+		l.lastHash, err = e.hash(l.codec)
+		if err != nil {
+			return err
+		}
+	}
+	l.info.LastRevision = lastRev
+	return kbfscodec.SerializeToFile(l.codec, l.info, l.infoPath())
+}
+
+func (l *tlfAuditLog) entries() ([]AuditLogEntry, error) {
+	entries := make([]AuditLogEntry, 0, l.j.length())
+	if l.j.empty() {
+		return entries, nil
+	}
+	earliest, err := l.j.readEarliestOrdinal()
+	if err != nil {
+		return nil, err
+	}
+	latest, err := l.j.readLatestOrdinal()
+	if err != nil {
+		return nil, err
+	}
+	for o := earliest; o <= latest; o++ {
+		e, err := l.j.readJournalEntry(o)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e.(AuditLogEntry))
+	}
+	return entries, nil
+}
+
+// AuditLogger keeps an append-only, signed audit log for each team
+// TLF it's asked about.  A log is brought up to date with the TLF's
+// merged history, which includes the changes made on other devices,
+// whenever it's updated or exported.
+type AuditLogger struct {
+	config Config
+	log    logger.Logger
+	dir    string
+
+	lock sync.Mutex
+	logs map[tlf.ID]*tlfAuditLog
+}
+
+// NewAuditLogger returns a new AuditLogger that keeps its logs under
+// `dir`.
+func NewAuditLogger(config Config, dir string) *AuditLogger {
+	return &AuditLogger{
+		config: config,
+		log:    config.MakeLogger("AL"),
+		dir:    dir,
+		logs:   make(map[tlf.ID]*tlfAuditLog),
+	}
+}
+
+func (al *AuditLogger) getLogLocked(tlfID tlf.ID) (*tlfAuditLog, error) {
+	if l, ok := al.logs[tlfID]; ok {
+		return l, nil
+	}
+	l, err := openTlfAuditLog(
+		al.config.Codec(), filepath.Join(al.dir, tlfID.String()))
+	if err != nil {
+		return nil, err
+	}
+	al.logs[tlfID] = l
+	return l, nil
+}
+
+func (al *AuditLogger) updateLocked(
+	ctx context.Context, tlfID tlf.ID) (*tlfAuditLog, error) {
+	if tlfID.Type() != tlf.SingleTeam {
+		return nil, errors.Errorf(
+			"Audit logs are only kept for team TLFs, not %s", tlfID)
+	}
+	l, err := al.getLogLocked(tlfID)
+	if err != nil {
+		return nil, err
+	}
+	events, lastRev, err := al.config.KBFSOps().GetAuditEvents(ctx,
+		FolderBranch{Tlf: tlfID, Branch: MasterBranch},
+		l.info.LastRevision+1)
+	if err != nil {
+		return nil, err
+	}
+	if lastRev <= l.info.LastRevision {
+		return l, nil
+	}
+	al.log.CDebugf(ctx, "Appending %d events for revisions %d-%d of %s",
+		len(events), l.info.LastRevision+1, lastRev, tlfID)
+	err = l.append(ctx, al.config.Crypto(), events, lastRev)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Update appends the events of any new revisions of the given team
+// TLF to its audit log.
+func (al *AuditLogger) Update(ctx context.Context, tlfID tlf.ID) error {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	_, err := al.updateLocked(ctx, tlfID)
+	return err
+}
+
+// Export brings the audit log of the given team TLF up to date, and
+// writes all of its entries to `w` as a JSON array, which can be
+// checked later with ReadAuditLogExport.
+func (al *AuditLogger) Export(
+	ctx context.Context, tlfID tlf.ID, w io.Writer) error {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	l, err := al.updateLocked(ctx, tlfID)
+	if err != nil {
+		return err
+	}
+	entries, err := l.entries()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.WithStack(enc.Encode(entries))
+}
+
+// ReadAuditLogExport reads an audit log written by Export, and
+// verifies it with VerifyAuditLog.
+func ReadAuditLogExport(codec kbfscodec.Codec, r io.Reader) (
+	[]AuditLogEntry, error) {
+	var entries []AuditLogEntry
+	err := json.NewDecoder(r).Decode(&entries)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = VerifyAuditLog(codec, entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetAuditLogger returns the audit logger started for the given
+// config, if there is one.
+func GetAuditLogger(config Config) (*AuditLogger, error) {
+	c, ok := config.(*ConfigLocal)
+	if !ok || c.auditLogger == nil {
+		return nil, errors.New("Audit logging not enabled")
+	}
+	return c.auditLogger, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, uid, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsConcurTestShutdown(t, config, ctx, cancel)
+
+	tempdir, err := ioutil.TempDir(os.TempDir(), "audit_log")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+
+	teamInfos := AddEmptyTeamsForTestOrBust(t, config, "t1")
+	AddTeamWriterForTestOrBust(t, config, teamInfos[0].TID, uid)
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	fb := rootNode.GetFolderBranch()
+
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps.Rename(ctx, rootNode, "a", rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	al := NewAuditLogger(config, tempdir)
+	var buf bytes.Buffer
+	err = al.Export(ctx, fb.Tlf, &buf)
+	require.NoError(t, err)
+	entries, err := ReadAuditLogExport(config.Codec(), bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	type typeAndPath struct {
+		typ  AuditEventType
+		path string
+	}
+	getEvents := func(entries []AuditLogEntry) (events []typeAndPath) {
+		for _, e := range entries {
+			require.Equal(t, uid, e.Event.Writer)
+			events = append(events, typeAndPath{e.Event.Type, e.Event.Path})
+		}
+		return events
+	}
+	// Like edit notifications, renames show up as a delete and a
+	// create.
+	require.Equal(t, []typeAndPath{
+		{AuditEventCreate, "t1/a"},
+		{AuditEventModify, "t1/a"},
+		{AuditEventDelete, "t1/a"},
+		{AuditEventCreate, "t1/b"},
+	}, getEvents(entries))
+
+	t.Log("Changed entries don't verify")
+	tampered := append([]AuditLogEntry(nil), entries...)
+	tampered[1].Event.Path = "t1/c"
+	err = VerifyAuditLog(config.Codec(), tampered)
+	require.Error(t, err)
+	err = VerifyAuditLog(config.Codec(), entries[1:])
+	require.Error(t, err)
+
+	t.Log("A reopened log keeps the chain going")
+	err = kbfsOps.RemoveEntry(ctx, rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	al = NewAuditLogger(config, tempdir)
+	buf.Reset()
+	err = al.Export(ctx, fb.Tlf, &buf)
+	require.NoError(t, err)
+	entries, err = ReadAuditLogExport(config.Codec(), &buf)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	require.Equal(t,
+		typeAndPath{AuditEventDelete, "t1/b"}, getEvents(entries)[4])
+
+	t.Log("Only team TLFs have audit logs")
+	privateHandle, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), string(u1), tlf.Private)
+	require.NoError(t, err)
+	privateRoot, _, err := kbfsOps.GetOrCreateRootNode(
+		ctx, privateHandle, MasterBranch)
+	require.NoError(t, err)
+	err = al.Update(ctx, privateRoot.GetFolderBranch().Tlf)
+	require.Error(t, err)
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "time"
+
+// Tuning for backup targets, which mostly see big files written
+// once and rarely read back: Time Machine sparse bundles, whose
+// "bands" directories hold thousands of large band files written at
+// random offsets, and restic-style repositories of pack files.
+const (
+	// Batch many file writes into each revision, since nobody is
+	// waiting to see them on another device.
+	backupTargetBGFlushPeriod         = 30 * time.Second
+	backupTargetBGFlushDirOpBatchSize = 1000
+	// Keep unreferenced blocks around for a day, so that an
+	// accidental prune or thinning of a backup can still be undone
+	// from the TLF's history.
+	backupTargetQRUnrefAge = 24 * time.Hour
+)
+
+// BackupTargetInitParams returns the default init params, tuned for
+// using KBFS as a backup target.
+func BackupTargetInitParams(ctx Context) InitParams {
+	params := DefaultInitParams(ctx)
+	params.BackupTarget = true
+	return params
+}
+
+// backupTargetTuning returns `params`, tuned for a backup target if
+// params.BackupTarget is set.  Settings changed from their defaults
+// are kept, so that explicit flags win over the tuning.
+//
+// Data blocks are already as big as the servers allow, so their size
+// isn't changed.  Holes in sparse files aren't stored, so band files
+// cost only what's been written to them.
+func backupTargetTuning(params InitParams) InitParams {
+	if !params.BackupTarget {
+		return params
+	}
+	if params.BGFlushPeriod == bgFlushPeriodDefault {
+		params.BGFlushPeriod = backupTargetBGFlushPeriod
+	}
+	if params.BGFlushDirOpBatchSize == bgFlushDirOpBatchSizeDefault {
+		params.BGFlushDirOpBatchSize = backupTargetBGFlushDirOpBatchSize
+	}
+	// Streams of band and pack files would otherwise evict the
+	// directory blocks listing them from the clean cache.
+	params.BlockCacheTinyLFU = true
+	return params
+}
@@ -0,0 +1,34 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupTargetTuning(t *testing.T) {
+	params := InitParams{
+		BGFlushPeriod:         bgFlushPeriodDefault,
+		BGFlushDirOpBatchSize: bgFlushDirOpBatchSizeDefault,
+	}
+	require.Equal(t, params, backupTargetTuning(params))
+
+	params.BackupTarget = true
+	tuned := backupTargetTuning(params)
+	require.Equal(t, backupTargetBGFlushPeriod, tuned.BGFlushPeriod)
+	require.Equal(t, backupTargetBGFlushDirOpBatchSize,
+		tuned.BGFlushDirOpBatchSize)
+	require.True(t, tuned.BlockCacheTinyLFU)
+
+	t.Log("Explicit settings win")
+	params.BGFlushPeriod = 5 * time.Second
+	params.BGFlushDirOpBatchSize = 10
+	tuned = backupTargetTuning(params)
+	require.Equal(t, 5*time.Second, tuned.BGFlushPeriod)
+	require.Equal(t, 10, tuned.BGFlushDirOpBatchSize)
+}
@@ -6,10 +6,13 @@ package libkbfs
 
 import (
 	"fmt"
+	"hash/fnv"
 	"sync"
 	"sync/atomic"
 
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/keybase/kbfs/cache"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfshash"
 	"github.com/keybase/kbfs/tlf"
@@ -35,7 +38,14 @@ type BlockCacheStandard struct {
 
 	ids *lru.Cache
 
-	cleanTransient *lru.Cache
+	// transientLock protects cleanTransient, and must be taken
+	// before bytesLock if both are needed.
+	transientLock  sync.Mutex
+	cleanTransient *simplelru.LRU
+
+	// admission, if non-nil, decides whether a new transient block
+	// may evict the least-recently-used one to make room.
+	admission *cache.TinyLFU
 
 	cleanLock      sync.RWMutex
 	cleanPermanent map[kbfsblock.ID]Block
@@ -65,7 +75,8 @@ func NewBlockCacheStandard(transientCapacity int,
 			return nil
 		}
 
-		b.cleanTransient, err = lru.NewWithEvict(transientCapacity, b.onEvict)
+		b.cleanTransient, err = simplelru.NewLRU(
+			transientCapacity, b.onEvict)
 		if err != nil {
 			return nil
 		}
@@ -73,11 +84,41 @@ func NewBlockCacheStandard(transientCapacity int,
 	return b
 }
 
+// NewBlockCacheStandardWithTinyLFU is like NewBlockCacheStandard,
+// but once the cache is full, a new transient block only evicts the
+// least-recently-used one if it has been accessed more often
+// recently, according to a TinyLFU admission policy.  This keeps
+// large scans from flushing out the hot working set.
+func NewBlockCacheStandardWithTinyLFU(transientCapacity int,
+	cleanBytesCapacity uint64) *BlockCacheStandard {
+	b := NewBlockCacheStandard(transientCapacity, cleanBytesCapacity)
+	if b != nil && transientCapacity > 0 {
+		b.admission = cache.NewTinyLFU(transientCapacity)
+	}
+	return b
+}
+
+func blockIDHash(id kbfsblock.ID) uint64 {
+	h := fnv.New64a()
+	h.Write(id.Bytes())
+	return h.Sum64()
+}
+
+func (b *BlockCacheStandard) recordAccess(id kbfsblock.ID) {
+	if b.admission != nil {
+		b.admission.Increment(blockIDHash(id))
+	}
+}
+
 // GetWithPrefetch implements the BlockCache interface for BlockCacheStandard.
 func (b *BlockCacheStandard) GetWithPrefetch(ptr BlockPointer) (
 	Block, PrefetchStatus, BlockCacheLifetime, error) {
+	b.recordAccess(ptr.ID)
 	if b.cleanTransient != nil {
-		if tmp, ok := b.cleanTransient.Get(ptr.ID); ok {
+		b.transientLock.Lock()
+		tmp, ok := b.cleanTransient.Get(ptr.ID)
+		b.transientLock.Unlock()
+		if ok {
 			bc, ok := tmp.(blockContainer)
 			if !ok {
 				return nil, NoPrefetch, NoCacheEntry, BadDataError{ptr.ID}
@@ -180,45 +221,44 @@ func (b *BlockCacheStandard) GetCleanBytesCapacity() (capacity uint64) {
 	return atomic.LoadUint64(&b.cleanBytesCapacity)
 }
 
-func (b *BlockCacheStandard) makeRoomForSize(size uint64, lifetime BlockCacheLifetime) bool {
+func (b *BlockCacheStandard) makeRoomForSize(
+	id kbfsblock.ID, size uint64, lifetime BlockCacheLifetime) bool {
 	if b.cleanTransient == nil {
 		return false
 	}
 
-	oldLen := b.cleanTransient.Len() + 1
-	doUnlock := true
-	b.bytesLock.Lock()
-	defer func() {
-		if doUnlock {
-			b.bytesLock.Unlock()
-		}
-	}()
-
 	cleanBytesCapacity := b.GetCleanBytesCapacity()
 
+	b.transientLock.Lock()
+	defer b.transientLock.Unlock()
+
 	// Evict items from the cache until the bytes capacity is lower
-	// than the total capacity (or until no items are removed).
-	for b.cleanTotalBytes+size > cleanBytesCapacity {
-		// Unlock while removing, since onEvict needs the lock and
-		// cleanTransient.Len() takes the LRU mutex (which could lead
-		// to a deadlock with onEvict).  TODO: either change
-		// `cleanTransient` into an `lru.SimpleLRU` and protect it
-		// with our own lock, or build our own LRU that can evict
-		// based on total bytes.  See #250 and KBFS-1404 for a longer
-		// discussion.
+	// than the total capacity (or until no items are left).
+	for {
+		b.bytesLock.Lock()
+		full := b.cleanTotalBytes+size > cleanBytesCapacity
 		b.bytesLock.Unlock()
-		doUnlock = false
-		if oldLen == b.cleanTransient.Len() {
-			doUnlock = true
-			b.bytesLock.Lock()
+		if !full {
+			break
+		}
+
+		oldest, _, ok := b.cleanTransient.GetOldest()
+		if !ok {
 			break
 		}
-		oldLen = b.cleanTransient.Len()
+		if lifetime == TransientEntry && b.admission != nil &&
+			!b.admission.Admit(
+				blockIDHash(id), blockIDHash(oldest.(kbfsblock.ID))) {
+			// The block we'd evict is more popular than the new
+			// one, so leave it be.
+			return false
+		}
+		// onEvict takes bytesLock, so it must not be held here.
 		b.cleanTransient.RemoveOldest()
-		doUnlock = true
-		b.bytesLock.Lock()
 	}
 
+	b.bytesLock.Lock()
+	defer b.bytesLock.Unlock()
 	if b.cleanTotalBytes+size > cleanBytesCapacity {
 		// There must be too many permanent clean blocks, so we
 		// couldn't make room.
@@ -261,6 +301,8 @@ func (b *BlockCacheStandard) PutWithPrefetch(
 		return errors.Errorf("attempted to Put an unknown block type %T", block)
 	}
 
+	b.recordAccess(ptr.ID)
+
 	var wasInCache bool
 
 	switch lifetime {
@@ -281,7 +323,9 @@ func (b *BlockCacheStandard) PutWithPrefetch(
 		// the LRU time. By using `Get`, we make it less likely that another
 		// goroutine will evict this block before we can `Put` it again.
 		var bc interface{}
+		b.transientLock.Lock()
 		bc, wasInCache = b.cleanTransient.Get(ptr.ID)
+		b.transientLock.Unlock()
 		if wasInCache {
 			oldPrefetchStatus := bc.(blockContainer).prefetchStatus
 			// If the cache believes our prefetch status is greater than the
@@ -313,12 +357,14 @@ func (b *BlockCacheStandard) PutWithPrefetch(
 	// goroutine inserts this block, we double-count it.
 	if !wasInCache {
 		size := uint64(getCachedBlockSize(block))
-		transientCacheHasRoom = b.makeRoomForSize(size, lifetime)
+		transientCacheHasRoom = b.makeRoomForSize(ptr.ID, size, lifetime)
 	}
 	if lifetime == TransientEntry {
 		if !transientCacheHasRoom {
 			return cachePutCacheFullError{ptr.ID}
 		}
+		b.transientLock.Lock()
+		defer b.transientLock.Unlock()
 		b.cleanTransient.Add(ptr.ID, blockContainer{block, prefetchStatus})
 	}
 
@@ -353,6 +399,9 @@ func (b *BlockCacheStandard) DeleteTransient(
 		return nil
 	}
 
+	b.transientLock.Lock()
+	defer b.transientLock.Unlock()
+
 	// If the block is cached and a file block, delete the known
 	// pointer as well.
 	if tmp, ok := b.cleanTransient.Get(ptr.ID); ok {
@@ -248,6 +248,49 @@ func TestBlockCacheEvictOnBytes(t *testing.T) {
 	}
 }
 
+func TestBlockCacheTinyLFUResistsScan(t *testing.T) {
+	ctx := context.Background()
+	// Make a cache that can only handle 5 bytes.
+	config := MakeTestConfigOrBust(t, "test")
+	config.SetBlockCache(NewBlockCacheStandardWithTinyLFU(1000, 5))
+	defer config.Shutdown(ctx)
+
+	bcache := config.BlockCache()
+
+	tlf := tlf.FakeID(1, tlf.Private)
+	put := func(i byte) error {
+		block := &FileBlock{
+			Contents: make([]byte, 1),
+		}
+		return bcache.Put(BlockPointer{ID: kbfsblock.FakeID(i)}, tlf,
+			block, TransientEntry)
+	}
+
+	// Fill the cache with a frequently-used working set.
+	for i := byte(0); i < 5; i++ {
+		require.NoError(t, put(i))
+		for j := 0; j < 3; j++ {
+			_, err := bcache.Get(BlockPointer{ID: kbfsblock.FakeID(i)})
+			require.NoError(t, err)
+		}
+	}
+
+	// A scan of blocks that are each only used once shouldn't be
+	// able to evict any of them.
+	for i := byte(10); i < 50; i++ {
+		err := put(i)
+		require.IsType(t, cachePutCacheFullError{}, err)
+	}
+
+	for i := byte(0); i < 5; i++ {
+		_, err := bcache.Get(BlockPointer{ID: kbfsblock.FakeID(i)})
+		require.NoError(t, err)
+	}
+	for i := byte(10); i < 50; i++ {
+		testExpectedMissing(t, kbfsblock.FakeID(i), bcache)
+	}
+}
+
 func TestBlockCacheEvictIncludesPermanentSize(t *testing.T) {
 	ctx := context.Background()
 	// Make a cache that can only handle 5 bytes
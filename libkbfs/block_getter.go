@@ -19,6 +19,19 @@ type blockGetter interface {
 		kbfscrypto.BlockCryptKeyServerHalf) error
 }
 
+// batchBlockGetter is implemented by blockGetters that can obtain
+// several blocks of the same TLF in a single round trip.
+type batchBlockGetter interface {
+	blockGetter
+	// maxBatchSize returns the most blocks that can currently be
+	// requested at once; 1 means batching isn't possible.
+	maxBatchSize() int
+	// getBlocks fills in each of `blocks` with the corresponding
+	// block in `ptrs`, and returns the error for each.
+	getBlocks(ctx context.Context, kmds []KeyMetadata, ptrs []BlockPointer,
+		blocks []Block) []error
+}
+
 // realBlockGetter obtains real blocks using the APIs available in Config.
 type realBlockGetter struct {
 	config blockOpsConfig
@@ -51,3 +64,51 @@ func (bg *realBlockGetter) assembleBlock(ctx context.Context,
 	return assembleBlock(ctx, bg.config.keyGetter(), bg.config.Codec(),
 		bg.config.cryptoPure(), kmd, ptr, block, buf, serverHalf)
 }
+
+// maxBatchSize implements the batchBlockGetter interface for
+// realBlockGetter.
+func (bg *realBlockGetter) maxBatchSize() int {
+	batcher, ok := bg.config.BlockServer().(blockServerBatcher)
+	if !ok || !batcher.BatchSupported() {
+		return 1
+	}
+	return kbfsblock.MaxBlocksPerBatch
+}
+
+// getBlocks implements the batchBlockGetter interface for
+// realBlockGetter.  All the blocks must belong to the same TLF.
+func (bg *realBlockGetter) getBlocks(ctx context.Context,
+	kmds []KeyMetadata, ptrs []BlockPointer, blocks []Block) []error {
+	gets := make([]kbfsblock.BatchRef, len(ptrs))
+	for i, ptr := range ptrs {
+		gets[i] = kbfsblock.BatchRef{ID: ptr.ID, Context: ptr.Context}
+	}
+	var results []kbfsblock.BatchGetResult
+	var err error
+	batcher, ok := bg.config.BlockServer().(blockServerBatcher)
+	if ok {
+		results, err = batcher.GetBatch(ctx, kmds[0].TlfID(), gets)
+	}
+
+	errs := make([]error, len(ptrs))
+	if !ok || kbfsblock.IsBatchUnsupportedError(err) {
+		// Batching was turned off since the caller checked, so
+		// fall back to getting the blocks one by one.
+		for i, ptr := range ptrs {
+			errs[i] = bg.getBlock(ctx, kmds[i], ptr, blocks[i])
+		}
+		return errs
+	}
+	for i, ptr := range ptrs {
+		switch {
+		case err != nil:
+			errs[i] = err
+		case results[i].Err != nil:
+			errs[i] = results[i].Err
+		default:
+			errs[i] = bg.assembleBlock(ctx, kmds[i], ptr, blocks[i],
+				results[i].Buf, results[i].ServerHalf)
+		}
+	}
+	return errs
+}
@@ -777,12 +777,14 @@ func flushBlockEntries(ctx context.Context, log, deferLog traceLogger,
 		return nil
 	}
 
-	// Do all the put state stuff first, in parallel.  We need to do
-	// the puts strictly before the addRefs, since the latter might
-	// reference the former.
+	// Do all the put state stuff first, in parallel (and batched, if
+	// the server supports it).  We need to do the puts strictly
+	// before the addRefs, since the latter might reference the
+	// former.
 	log.CDebugf(ctx, "Putting %d blocks", len(entries.puts.blockStates))
-	blocksToRemove, err := doBlockPuts(ctx, bserver, bcache, reporter,
-		log, deferLog, tlfID, tlfName, *entries.puts)
+	puts := skipExistingBlockPuts(ctx, log, bserver, tlfID, *entries.puts)
+	blocksToRemove, err := doBatchBlockPuts(ctx, bserver, bcache, reporter,
+		log, deferLog, tlfID, tlfName, puts)
 	if err != nil {
 		if isRecoverableBlockError(err) {
 			log.CWarningf(ctx,
@@ -795,8 +797,9 @@ func flushBlockEntries(ctx context.Context, log, deferLog traceLogger,
 	// Next, do the addrefs.
 	log.CDebugf(ctx, "Adding %d block references",
 		len(entries.adds.blockStates))
+	adds := skipExistingBlockPuts(ctx, log, bserver, tlfID, *entries.adds)
 	blocksToRemove, err = doBlockPuts(ctx, bserver, bcache, reporter,
-		log, deferLog, tlfID, tlfName, *entries.adds)
+		log, deferLog, tlfID, tlfName, adds)
 	if err != nil {
 		if isRecoverableBlockError(err) {
 			log.CWarningf(ctx,
@@ -7,6 +7,7 @@ package libkbfs
 import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfshash"
 	"github.com/keybase/kbfs/tlf"
 	"golang.org/x/net/context"
 )
@@ -22,6 +23,7 @@ type blockOpsConfig interface {
 	diskBlockCacheGetter
 	syncedTlfGetterSetter
 	initModeGetter
+	blockHashTypeGetter
 }
 
 // BlockOpsStandard implements the BlockOps interface by relaying
@@ -161,7 +163,14 @@ func (b *BlockOpsStandard) Ready(ctx context.Context, kmd KeyMetadata,
 		return
 	}
 
-	id, err = kbfsblock.MakePermanentID(buf)
+	// Only direct file blocks, which hold the bulk of the data, use
+	// the configured hash type, so that directories and indirect
+	// blocks stay readable by clients that don't know about it.
+	hashType := kbfshash.DefaultHashType
+	if fBlock, ok := block.(*FileBlock); ok && !fBlock.IsInd {
+		hashType = b.config.BlockHashType()
+	}
+	id, err = kbfsblock.MakePermanentIDWithHashType(buf, hashType)
 	if err != nil {
 		return
 	}
@@ -87,6 +87,7 @@ type testBlockOpsConfig struct {
 	diskBlockCacheGetter
 	*testSyncedTlfGetterSetter
 	initModeGetter
+	blockHashType kbfshash.HashType
 }
 
 var _ blockOpsConfig = (*testBlockOpsConfig)(nil)
@@ -99,6 +100,10 @@ func (config testBlockOpsConfig) cryptoPure() cryptoPure {
 	return config.cp
 }
 
+func (config testBlockOpsConfig) BlockHashType() kbfshash.HashType {
+	return config.blockHashType
+}
+
 func (config testBlockOpsConfig) keyGetter() blockKeyGetter {
 	return fakeBlockKeyGetter{}
 }
@@ -120,7 +125,7 @@ func makeTestBlockOpsConfig(t *testing.T) testBlockOpsConfig {
 	dbcg := newTestDiskBlockCacheGetter(t, nil)
 	stgs := newTestSyncedTlfGetterSetter()
 	return testBlockOpsConfig{codecGetter, lm, bserver, crypto, cache, dbcg,
-		stgs, testInitModeGetter{InitDefault}}
+		stgs, testInitModeGetter{InitDefault}, kbfshash.DefaultHashType}
 }
 
 // TestBlockOpsReadySuccess checks that BlockOpsStandard.Ready()
@@ -167,6 +172,55 @@ func TestBlockOpsReadySuccess(t *testing.T) {
 	require.Equal(t, block, decryptedBlock)
 }
 
+// TestConfigDataVersion checks that the config only reports the
+// data versions of the block features it has enabled.
+func TestConfigDataVersion(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "u1")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+
+	require.Equal(t, AtLeastTwoLevelsOfChildrenDataVer, config.DataVersion())
+	config.SetCompactDirEntries(true)
+	require.Equal(t, CompactDirEntriesDataVer, config.DataVersion())
+	config.SetBlockHashType(kbfshash.BLAKE2b256Hash)
+	require.Equal(t, BLAKE2bHashDataVer, config.DataVersion())
+}
+
+// TestBlockOpsReadyBLAKE2b checks that BlockOpsStandard.Ready() uses
+// the configured hash type for direct file blocks only.
+func TestBlockOpsReadyBLAKE2b(t *testing.T) {
+	config := makeTestBlockOpsConfig(t)
+	config.blockHashType = kbfshash.BLAKE2b256Hash
+	bops := NewBlockOpsStandard(config, testBlockRetrievalWorkerQueueSize,
+		testPrefetchWorkerQueueSize)
+	defer bops.Shutdown()
+
+	tlfID := tlf.FakeID(0, tlf.Private)
+	kmd := makeFakeKeyMetadata(tlfID, 5)
+	ctx := context.Background()
+
+	fBlock := &FileBlock{
+		Contents: []byte{1, 2, 3, 4, 5},
+	}
+	id, _, readyBlockData, err := bops.Ready(ctx, kmd, fBlock)
+	require.NoError(t, err)
+	require.Equal(t, kbfshash.BLAKE2b256Hash, id.HashType())
+	err = kbfsblock.VerifyID(readyBlockData.buf, id)
+	require.NoError(t, err)
+
+	// Indirect file blocks and directories keep the default type.
+	iBlock := NewFileBlock().(*FileBlock)
+	iBlock.IsInd = true
+	id, _, readyBlockData, err = bops.Ready(ctx, kmd, iBlock)
+	require.NoError(t, err)
+	require.Equal(t, kbfshash.DefaultHashType, id.HashType())
+	err = kbfsblock.VerifyID(readyBlockData.buf, id)
+	require.NoError(t, err)
+
+	id, _, _, err = bops.Ready(ctx, kmd, NewDirBlock())
+	require.NoError(t, err)
+	require.Equal(t, kbfshash.DefaultHashType, id.HashType())
+}
+
 // TestBlockOpsReadyFailKeyGet checks that BlockOpsStandard.Ready()
 // fails properly if we fail to retrieve the key.
 func TestBlockOpsReadyFailKeyGet(t *testing.T) {
@@ -0,0 +1,216 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// BlockRefAuditResult is the result of cross-checking the block
+// references recorded in a TLF's MD chain against the block server.
+type BlockRefAuditResult struct {
+	// Revision is the latest merged revision that was audited.
+	Revision kbfsmd.Revision
+	// GCRevision is the latest revision that has been garbage
+	// collected, according to the MD chain.
+	GCRevision kbfsmd.Revision
+	// NumLive is the number of block references that the MD chain
+	// says should still be live.
+	NumLive int
+	// NumDeleted is the number of block references that the MD
+	// chain says should have already been deleted.
+	NumDeleted int
+	// Missing are the references that should be live, but that the
+	// block server doesn't have.
+	Missing []BlockPointer
+	// Orphaned are the references that should have been deleted by
+	// garbage collection, but that the block server still has.
+	// These count against the TLF's quota for no reason.
+	Orphaned []BlockPointer
+}
+
+// blockRefAuditor builds up the set of block references that should
+// be live, and the set that should have been deleted, from a
+// sequence of MD revisions.
+type blockRefAuditor struct {
+	gcRevision kbfsmd.Revision
+	live       map[BlockPointer]bool
+	deleted    map[BlockPointer]bool
+}
+
+func newBlockRefAuditor(gcRevision kbfsmd.Revision) *blockRefAuditor {
+	return &blockRefAuditor{
+		gcRevision: gcRevision,
+		live:       make(map[BlockPointer]bool),
+		deleted:    make(map[BlockPointer]bool),
+	}
+}
+
+func (bra *blockRefAuditor) unref(rev kbfsmd.Revision, ptr BlockPointer,
+	refedInSameOp bool) {
+	delete(bra.live, ptr)
+	if ptr == zeroPtr {
+		return
+	}
+	// If the revision has been garbage-collected, or if the pointer
+	// has been referenced and unreferenced within the same op (which
+	// indicates a failed and retried sync), the corresponding block
+	// should already be cleaned up.  Otherwise it's just archived,
+	// and the server may or may not still have it.
+	if rev <= bra.gcRevision || refedInSameOp {
+		bra.deleted[ptr] = true
+	}
+}
+
+func (bra *blockRefAuditor) ref(ptr BlockPointer) {
+	if ptr == zeroPtr {
+		return
+	}
+	bra.live[ptr] = true
+	delete(bra.deleted, ptr)
+}
+
+// addOps processes the ops of the given revision.  (Like the
+// StateChecker, this assumes that GC ops don't reference anything.)
+func (bra *blockRefAuditor) addOps(rev kbfsmd.Revision, ops opsList) {
+	for _, op := range ops {
+		opRefs := make(map[BlockPointer]bool)
+		for _, ptr := range op.Refs() {
+			bra.ref(ptr)
+			opRefs[ptr] = true
+		}
+		if _, isGCOp := op.(*GCOp); !isGCOp {
+			for _, ptr := range op.Unrefs() {
+				bra.unref(rev, ptr, opRefs[ptr])
+			}
+		}
+		for _, update := range op.allUpdates() {
+			if update.Ref == update.Unref {
+				continue
+			}
+			bra.unref(rev, update.Unref, false)
+			bra.ref(update.Ref)
+		}
+	}
+}
+
+// hasBlocker is the part of blockServerBatcher needed for an audit.
+type hasBlocker interface {
+	HasBlocks(ctx context.Context, tlfID tlf.ID, refs []kbfsblock.BatchRef) (
+		[]bool, error)
+}
+
+// checkBlockRefs asks the server whether it has each of the given
+// references, in chunks, and returns the ones for which the answer
+// is `want`.
+func checkBlockRefs(ctx context.Context, hb hasBlocker, tlfID tlf.ID,
+	ptrs map[BlockPointer]bool, want bool) ([]BlockPointer, error) {
+	all := make([]BlockPointer, 0, len(ptrs))
+	for ptr := range ptrs {
+		all = append(all, ptr)
+	}
+
+	var found []BlockPointer
+	for start := 0; start < len(all); start += kbfsblock.MaxRefsPerHasBlocks {
+		end := start + kbfsblock.MaxRefsPerHasBlocks
+		if end > len(all) {
+			end = len(all)
+		}
+		chunk := all[start:end]
+
+		refs := make([]kbfsblock.BatchRef, len(chunk))
+		for i, ptr := range chunk {
+			refs[i] = kbfsblock.BatchRef{ID: ptr.ID, Context: ptr.Context}
+		}
+		exists, err := hb.HasBlocks(ctx, tlfID, refs)
+		if err != nil {
+			return nil, err
+		}
+		for i, ptr := range chunk {
+			if exists[i] == want {
+				found = append(found, ptr)
+			}
+		}
+	}
+	return found, nil
+}
+
+// AuditBlockRefs walks the whole merged MD chain of the given TLF to
+// find which block references should be live on the block server,
+// and which should have been deleted by garbage collection, and then
+// asks the block server (using batched existence checks) which of
+// those it actually has.  It returns an error if the block server
+// doesn't support batched existence checks.
+//
+// Like the StateChecker, this loads the whole MD chain into memory,
+// so it's only meant for debugging.  Only the top-level pointers of
+// unembedded block changes are checked, not their indirect blocks.
+func AuditBlockRefs(ctx context.Context, config Config, tlfID tlf.ID) (
+	BlockRefAuditResult, error) {
+	batcher, ok := config.BlockServer().(blockServerBatcher)
+	if !ok || !batcher.BatchSupported() {
+		return BlockRefAuditResult{}, errors.New(
+			"The block server doesn't support batched existence checks")
+	}
+
+	rmds, err := getMergedMDUpdates(ctx, config, tlfID,
+		kbfsmd.RevisionInitial, nil)
+	if err != nil {
+		return BlockRefAuditResult{}, err
+	}
+	if len(rmds) == 0 {
+		return BlockRefAuditResult{}, nil
+	}
+
+	// See what the last GC op revision is.  All unref'd pointers
+	// from that revision or earlier should be deleted from the
+	// block server.
+	gcRevision := kbfsmd.RevisionUninitialized
+	for _, rmd := range rmds {
+		if rmd.IsWriterMetadataCopiedSet() {
+			continue
+		}
+		for _, op := range rmd.data.Changes.Ops {
+			if gcOp, ok := op.(*GCOp); ok {
+				gcRevision = gcOp.LatestRev
+			}
+		}
+	}
+
+	bra := newBlockRefAuditor(gcRevision)
+	for _, rmd := range rmds {
+		// Don't process copies.
+		if rmd.IsWriterMetadataCopiedSet() {
+			continue
+		}
+		if !rmd.IsReadable() {
+			return BlockRefAuditResult{}, errors.Errorf(
+				"Revision %d of TLF %s isn't readable", rmd.Revision(), tlfID)
+		}
+		bra.ref(rmd.data.cachedChanges.Info.BlockPointer)
+		bra.addOps(rmd.Revision(), rmd.data.Changes.Ops)
+	}
+
+	missing, err := checkBlockRefs(ctx, batcher, tlfID, bra.live, false)
+	if err != nil {
+		return BlockRefAuditResult{}, err
+	}
+	orphaned, err := checkBlockRefs(ctx, batcher, tlfID, bra.deleted, true)
+	if err != nil {
+		return BlockRefAuditResult{}, err
+	}
+	return BlockRefAuditResult{
+		Revision:   rmds[len(rmds)-1].Revision(),
+		GCRevision: gcRevision,
+		NumLive:    len(bra.live),
+		NumDeleted: len(bra.deleted),
+		Missing:    missing,
+		Orphaned:   orphaned,
+	}, nil
+}
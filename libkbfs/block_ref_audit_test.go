@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testHasBlocker map[kbfsblock.ID]bool
+
+func (hb testHasBlocker) HasBlocks(_ context.Context, _ tlf.ID,
+	refs []kbfsblock.BatchRef) ([]bool, error) {
+	exists := make([]bool, len(refs))
+	for i, ref := range refs {
+		exists[i] = hb[ref.ID]
+	}
+	return exists, nil
+}
+
+func makeTestAuditSyncOp(
+	t *testing.T, oldFile, newFile BlockPointer) *syncOp {
+	so, err := newSyncOp(oldFile)
+	require.NoError(t, err)
+	err = so.File.setRef(newFile)
+	require.NoError(t, err)
+	return so
+}
+
+func TestBlockRefAudit(t *testing.T) {
+	file0 := makeRandomBlockPointer(t)
+	file1 := makeRandomBlockPointer(t)
+	file2 := makeRandomBlockPointer(t)
+	file3 := makeRandomBlockPointer(t)
+	a := makeRandomBlockPointer(t)
+	b := makeRandomBlockPointer(t)
+	c := makeRandomBlockPointer(t)
+
+	// Revisions 1 and 2 have been garbage-collected.
+	bra := newBlockRefAuditor(2)
+
+	so1 := makeTestAuditSyncOp(t, file0, file1)
+	so1.AddRefBlock(a)
+	so1.AddRefBlock(b)
+	bra.addOps(1, opsList{so1})
+
+	// `c` is referenced and unreferenced in the same op, so it should
+	// be gone even though it wasn't garbage-collected.
+	so2 := makeTestAuditSyncOp(t, file1, file2)
+	so2.AddUnrefBlock(a)
+	so2.AddRefBlock(c)
+	so2.AddUnrefBlock(c)
+	bra.addOps(2, opsList{so2})
+
+	// file2 and `b` are unreferenced after the last GC, so they're
+	// archived and might or might not still be on the server.
+	so3 := makeTestAuditSyncOp(t, file2, file3)
+	so3.AddUnrefBlock(b)
+	bra.addOps(3, opsList{so3, newGCOp(2)})
+
+	require.Equal(t, map[BlockPointer]bool{file3: true}, bra.live)
+	require.Equal(t, map[BlockPointer]bool{
+		file0: true,
+		file1: true,
+		a:     true,
+		c:     true,
+	}, bra.deleted)
+
+	ctx := context.Background()
+	tlfID := tlf.FakeID(1, tlf.Private)
+	hb := testHasBlocker{a.ID: true, b.ID: true, file2.ID: true}
+	missing, err := checkBlockRefs(ctx, hb, tlfID, bra.live, false)
+	require.NoError(t, err)
+	require.Equal(t, []BlockPointer{file3}, missing)
+	orphaned, err := checkBlockRefs(ctx, hb, tlfID, bra.deleted, true)
+	require.NoError(t, err)
+	require.Equal(t, []BlockPointer{a}, orphaned)
+}
@@ -71,13 +71,16 @@ type blockRetrieval struct {
 	// cancel function for the context
 	cancelFunc context.CancelFunc
 
-	// protects requests, cacheLifetime, and the prefetch channels
+	// protects requests, cacheLifetime, prefetchHint, and the prefetch
+	// channels
 	reqMtx sync.RWMutex
 	// the individual requests for this block pointer: they must be notified
 	// once the block is returned
 	requests []*blockRetrievalRequest
 	// the cache lifetime for the retrieval
 	cacheLifetime BlockCacheLifetime
+	// the prefetch hint to apply once the block is retrieved
+	prefetchHint PrefetchHint
 
 	//// Queueing Metadata
 	// the index of the retrieval in the heap
@@ -171,6 +174,24 @@ func (brq *blockRetrievalQueue) popIfNotEmpty() *blockRetrieval {
 	return nil
 }
 
+// popPrefetchBatchIfNotEmpty pops up to `max` more prefetch
+// retrievals for the given TLF, stopping at the first queued
+// retrieval that isn't one.
+func (brq *blockRetrievalQueue) popPrefetchBatchIfNotEmpty(
+	tlfID tlf.ID, max int) (retrievals []*blockRetrieval) {
+	brq.mtx.Lock()
+	defer brq.mtx.Unlock()
+	for len(retrievals) < max && brq.heap.Len() > 0 {
+		next := (*brq.heap)[0]
+		if next.priority >= defaultOnDemandRequestPriority ||
+			next.kmd.TlfID() != tlfID {
+			break
+		}
+		retrievals = append(retrievals, heap.Pop(brq.heap).(*blockRetrieval))
+	}
+	return retrievals
+}
+
 func (brq *blockRetrievalQueue) shutdownRetrieval() {
 	retrieval := brq.popIfNotEmpty()
 	if retrieval != nil {
@@ -315,7 +336,7 @@ func (brq *blockRetrievalQueue) request(ctx context.Context,
 		ch <- nil
 		return ch
 	}
-	err = checkDataVersion(brq.config, path{}, ptr)
+	err = checkDataVersion(path{}, ptr)
 	if err != nil {
 		if doPrefetch {
 			brq.Prefetcher().CancelPrefetch(ptr.ID)
@@ -345,6 +366,7 @@ func (brq *blockRetrievalQueue) request(ctx context.Context,
 				priority:       priority,
 				insertionOrder: brq.insertionCount,
 				cacheLifetime:  lifetime,
+				prefetchHint:   prefetchHintFromCtx(ctx),
 			}
 			br.ctx, br.cancelFunc = NewCoalescingContext(ctx)
 			brq.insertionCount++
@@ -371,6 +393,10 @@ func (brq *blockRetrievalQueue) request(ctx context.Context,
 	if lifetime > br.cacheLifetime {
 		br.cacheLifetime = lifetime
 	}
+	if prefetchHintFromCtx(ctx) == PrefetchHintSequential {
+		// Reading ahead for one requester wins over the others.
+		br.prefetchHint = PrefetchHintSequential
+	}
 	oldPriority := br.priority
 	if priority > oldPriority {
 		br.priority = priority
@@ -440,7 +466,11 @@ func (brq *blockRetrievalQueue) FinalizeRequest(
 		// only way to get here is if the request wasn't already cached.
 		// Need to call with context.Background() because the retrieval's
 		// context will be canceled as soon as this method returns.
-		brq.Prefetcher().ProcessBlockForPrefetch(context.Background(),
+		ctx := context.Background()
+		if retrieval.prefetchHint != PrefetchHintDefault {
+			ctx = CtxWithPrefetchHint(ctx, retrieval.prefetchHint)
+		}
+		brq.Prefetcher().ProcessBlockForPrefetch(ctx,
 			retrieval.blockPtr, block, retrieval.kmd, retrieval.priority,
 			retrieval.cacheLifetime, NoPrefetch)
 	} else {
@@ -6,6 +6,8 @@ package libkbfs
 
 import (
 	"io"
+
+	"golang.org/x/net/context"
 )
 
 // blockRetrievalWorker processes blockRetrievalQueue requests
@@ -59,6 +61,21 @@ func (brw *blockRetrievalWorker) HandleRequest() (err error) {
 		return io.EOF
 	}
 
+	// Prefetches can be fetched many at a time, to cut down on round
+	// trips when prefetching big directories or files.
+	if bbg, ok := brw.blockGetter.(batchBlockGetter); ok &&
+		retrieval.priority < defaultOnDemandRequestPriority {
+		if max := bbg.maxBatchSize(); max > 1 {
+			rest := brw.queue.popPrefetchBatchIfNotEmpty(
+				retrieval.kmd.TlfID(), max-1)
+			if len(rest) > 0 {
+				brw.handleBatch(
+					bbg, append([]*blockRetrieval{retrieval}, rest...))
+				return nil
+			}
+		}
+	}
+
 	var block Block
 	defer func() {
 		brw.queue.FinalizeRequest(retrieval, block, err)
@@ -77,7 +94,61 @@ func (brw *blockRetrievalWorker) HandleRequest() (err error) {
 		block = retrieval.requests[0].block.NewEmpty()
 	}()
 
-	return brw.getBlock(retrieval.ctx, retrieval.kmd, retrieval.blockPtr, block)
+	ctx := context.Context(retrieval.ctx)
+	if retrieval.priority < defaultOnDemandRequestPriority {
+		// Nobody is waiting on prefetches, so they yield block
+		// server bandwidth to everything else.
+		ctx = ctxWithBackgroundBServerPriority(ctx)
+	}
+	return brw.getBlock(ctx, retrieval.kmd, retrieval.blockPtr, block)
+}
+
+// handleBatch retrieves the blocks for all the given retrievals at
+// once, and responds to each of their subscribed requestors.
+func (brw *blockRetrievalWorker) handleBatch(
+	bbg batchBlockGetter, retrievals []*blockRetrieval) {
+	var kmds []KeyMetadata
+	var ptrs []BlockPointer
+	var blocks []Block
+	var toGet []*blockRetrieval
+	for _, retrieval := range retrievals {
+		// Handle canceled contexts.
+		select {
+		case <-retrieval.ctx.Done():
+			brw.queue.FinalizeRequest(retrieval, nil, retrieval.ctx.Err())
+			continue
+		default:
+		}
+
+		toGet = append(toGet, retrieval)
+		kmds = append(kmds, retrieval.kmd)
+		ptrs = append(ptrs, retrieval.blockPtr)
+		func() {
+			retrieval.reqMtx.RLock()
+			defer retrieval.reqMtx.RUnlock()
+			blocks = append(blocks, retrieval.requests[0].block.NewEmpty())
+		}()
+	}
+	if len(toGet) == 0 {
+		return
+	}
+
+	// The batch should only be canceled once all of its retrievals
+	// have been.
+	ctx, cancel := NewCoalescingContext(toGet[0].ctx)
+	defer cancel()
+	for _, retrieval := range toGet[1:] {
+		// An error just means `ctx` is already canceled, which the
+		// get will notice.
+		_ = ctx.AddContext(retrieval.ctx)
+	}
+
+	// Only prefetches are batched, so they're all background traffic.
+	errs := bbg.getBlocks(
+		ctxWithBackgroundBServerPriority(ctx), kmds, ptrs, blocks)
+	for i, retrieval := range toGet {
+		brw.queue.FinalizeRequest(retrieval, blocks[i], errs[i])
+	}
 }
 
 // Shutdown shuts down the blockRetrievalWorker once its current work is done.
@@ -115,6 +115,13 @@ type DirBlock struct {
 	Children map[string]DirEntry `codec:"c,omitempty"`
 	// if indirect, contains the indirect pointers to the next level of blocks
 	IPtrs []IndirectDirPtr `codec:"i,omitempty"`
+	// if compact, holds the encoded form of Children instead; it's
+	// only ever set while encoding or decoding.
+	CompactChildren *compactDirChildren `codec:"cc,omitempty"`
+
+	// compact indicates this block is (or will be) encoded using
+	// CompactDirEntriesDataVer.
+	compact bool
 }
 
 // NewDirBlock creates a new, empty DirBlock.
@@ -134,15 +141,53 @@ func (db *DirBlock) ToCommonBlock() *CommonBlock {
 	return &db.CommonBlock
 }
 
+// DataVersion returns data version for this block.
+func (db *DirBlock) DataVersion() DataVer {
+	for _, de := range db.Children {
+		if len(de.Xattrs) > 0 {
+			return XattrsDataVer
+		}
+	}
+	if db.compact {
+		return CompactDirEntriesDataVer
+	}
+	return FirstValidDataVer
+}
+
 // Set implements the Block interface for DirBlock
 func (db *DirBlock) Set(other Block) {
 	otherDb := other.(*DirBlock)
 	dbCopy := otherDb.DeepCopy()
 	db.Children = dbCopy.Children
 	db.IPtrs = dbCopy.IPtrs
+	db.compact = dbCopy.compact
 	db.ToCommonBlock().Set(dbCopy.ToCommonBlock())
 }
 
+// makeCompactCopy returns a copy of this block that encodes its
+// children in compact form.  It shares the children with `db`, so it
+// must only be used for encoding.
+func (db *DirBlock) makeCompactCopy() *DirBlock {
+	return &DirBlock{
+		CommonBlock:     db.CommonBlock.DeepCopy(),
+		IPtrs:           db.IPtrs,
+		CompactChildren: makeCompactDirChildren(db.Children),
+		compact:         true,
+	}
+}
+
+// expandCompactChildren converts freshly-decoded compact children
+// back into Children, and marks the block as compact so that it
+// stays that way when re-encoded.
+func (db *DirBlock) expandCompactChildren() {
+	if db.CompactChildren == nil {
+		return
+	}
+	db.Children = db.CompactChildren.toDirEntries()
+	db.CompactChildren = nil
+	db.compact = true
+}
+
 // DeepCopy makes a complete copy of a DirBlock
 func (db *DirBlock) DeepCopy() *DirBlock {
 	childrenCopy := make(map[string]DirEntry, len(db.Children))
@@ -155,6 +200,7 @@ func (db *DirBlock) DeepCopy() *DirBlock {
 		CommonBlock: db.CommonBlock.DeepCopy(),
 		Children:    childrenCopy,
 		IPtrs:       db.IPtrs,
+		compact:     db.compact,
 	}
 }
 
@@ -161,6 +161,8 @@ func makeFakeDirBlockFuture(t *testing.T) *dirBlockFuture {
 			},
 			nil,
 			nil,
+			nil,
+			false,
 		},
 		map[string]dirEntryFuture{
 			"child1": makeFakeDirEntryFuture(t),
@@ -46,6 +46,13 @@ func PutBlockCheckLimitErrs(ctx context.Context, bserv BlockServer,
 	reporter Reporter, tlfID tlf.ID, blockPtr BlockPointer,
 	readyBlockData ReadyBlockData, tlfName tlf.CanonicalName) error {
 	err := putBlockToServer(ctx, bserv, tlfID, blockPtr, readyBlockData)
+	return checkBlockPutLimitErr(ctx, reporter, tlfID, tlfName, err)
+}
+
+// checkBlockPutLimitErr reports any quota or disk limit error from a
+// block put, and returns the error the caller should act on.
+func checkBlockPutLimitErr(ctx context.Context, reporter Reporter,
+	tlfID tlf.ID, tlfName tlf.CanonicalName, err error) error {
 	switch typedErr := errors.Cause(err).(type) {
 	case kbfsblock.ServerErrorOverQuota:
 		if !typedErr.Throttled {
@@ -74,6 +81,14 @@ func doOneBlockPut(ctx context.Context, bserv BlockServer, reporter Reporter,
 	blocksToRemoveChan chan *FileBlock) error {
 	err := PutBlockCheckLimitErrs(ctx, bserv, reporter, tlfID, blockState.blockPtr,
 		blockState.readyBlockData, tlfName)
+	return finishOneBlockPut(blockState, err, blocksToRemoveChan)
+}
+
+// finishOneBlockPut runs the synced callback for a block that was put
+// successfully, and queues a file block for removal if the put
+// failed with a recoverable error.
+func finishOneBlockPut(blockState blockState, err error,
+	blocksToRemoveChan chan *FileBlock) error {
 	if err == nil && blockState.syncedCb != nil {
 		err = blockState.syncedCb()
 	}
@@ -138,32 +153,205 @@ func doBlockPuts(ctx context.Context, bserv BlockServer, bcache BlockCache,
 	err = eg.Wait()
 	close(blocksToRemoveChan)
 	if isRecoverableBlockError(err) {
-		// Wait for all the outstanding puts to finish, to amortize
-		// the work of re-doing the put.
-		for fblock := range blocksToRemoveChan {
-			for i, bs := range bps.blockStates {
-				if bs.block == fblock {
-					// Let the caller know which blocks shouldn't be
-					// retried.
-					blocksToRemove = append(blocksToRemove,
-						bps.blockStates[i].blockPtr)
-				}
-			}
+		blocksToRemove = removeBlocksAfterPutErr(
+			ctx, bcache, log, tlfID, bps, blocksToRemoveChan)
+	}
+	return blocksToRemove, err
+}
 
-			// Remove each problematic block from the cache so the
-			// redo can just make a new block instead.
-			if err := bcache.DeleteKnownPtr(tlfID, fblock); err != nil {
-				log.CWarningf(ctx, "Couldn't delete ptr for a block: %v", err)
+// removeBlocksAfterPutErr removes the file blocks that failed to put
+// with a recoverable error from the cache, and returns their
+// pointers.
+func removeBlocksAfterPutErr(ctx context.Context, bcache BlockCache,
+	log traceLogger, tlfID tlf.ID, bps blockPutState,
+	blocksToRemoveChan <-chan *FileBlock) (blocksToRemove []BlockPointer) {
+	// Wait for all the outstanding puts to finish, to amortize
+	// the work of re-doing the put.
+	for fblock := range blocksToRemoveChan {
+		for i, bs := range bps.blockStates {
+			if bs.block == fblock {
+				// Let the caller know which blocks shouldn't be
+				// retried.
+				blocksToRemove = append(blocksToRemove,
+					bps.blockStates[i].blockPtr)
 			}
-			if err := bcache.DeleteTransient(
-				blocksToRemove[len(blocksToRemove)-1], tlfID); err != nil {
-				log.CWarningf(ctx, "Couldn't delete block: %v", err)
+		}
+
+		// Remove each problematic block from the cache so the
+		// redo can just make a new block instead.
+		if err := bcache.DeleteKnownPtr(tlfID, fblock); err != nil {
+			log.CWarningf(ctx, "Couldn't delete ptr for a block: %v", err)
+		}
+		if err := bcache.DeleteTransient(
+			blocksToRemove[len(blocksToRemove)-1], tlfID); err != nil {
+			log.CWarningf(ctx, "Couldn't delete block: %v", err)
+		}
+	}
+	return blocksToRemove
+}
+
+// doOneBatchBlockPut puts the given new blocks to the server in a
+// single round trip, and handles each result like doOneBlockPut
+// does.  If the server turns out not to support batching, it puts
+// the blocks one at a time instead.  It returns the first per-block
+// error, after handling all of them.
+func doOneBatchBlockPut(ctx context.Context, batcher blockServerBatcher,
+	reporter Reporter, tlfID tlf.ID, tlfName tlf.CanonicalName,
+	batch []blockState, blocksToRemoveChan chan *FileBlock) error {
+	puts := make([]kbfsblock.BatchPut, len(batch))
+	for i, blockState := range batch {
+		puts[i] = kbfsblock.BatchPut{
+			ID:         blockState.blockPtr.ID,
+			Context:    blockState.blockPtr.Context,
+			Buf:        blockState.readyBlockData.buf,
+			ServerHalf: blockState.readyBlockData.serverHalf,
+		}
+	}
+	errs, err := batcher.PutBatch(ctx, tlfID, puts)
+	batchUnsupported := kbfsblock.IsBatchUnsupportedError(err)
+	if err != nil && !batchUnsupported {
+		return err
+	}
+
+	var firstErr error
+	for i, blockState := range batch {
+		if batchUnsupported {
+			// Batching was turned off since the caller checked,
+			// so fall back to putting the blocks one by one.
+			err = doOneBlockPut(ctx, batcher, reporter, tlfID, tlfName,
+				blockState, blocksToRemoveChan)
+		} else {
+			err = checkBlockPutLimitErr(
+				ctx, reporter, tlfID, tlfName, errs[i])
+			err = finishOneBlockPut(blockState, err, blocksToRemoveChan)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// doBatchBlockPuts is like doBlockPuts, except that it puts new
+// blocks to the server in batches of up to
+// kbfsblock.MaxBlocksPerBatch, which saves a lot of round trips when
+// there are many small blocks.  If the server doesn't support
+// batching, or `bps` contains new references to existing blocks, it
+// just calls doBlockPuts.
+func doBatchBlockPuts(ctx context.Context, bserv BlockServer, bcache BlockCache,
+	reporter Reporter, log, deferLog traceLogger, tlfID tlf.ID, tlfName tlf.CanonicalName,
+	bps blockPutState) (blocksToRemove []BlockPointer, err error) {
+	batcher, ok := bserv.(blockServerBatcher)
+	if !ok || !batcher.BatchSupported() {
+		return doBlockPuts(ctx, bserv, bcache, reporter, log, deferLog,
+			tlfID, tlfName, bps)
+	}
+	for _, blockState := range bps.blockStates {
+		if blockState.blockPtr.RefNonce != kbfsblock.ZeroRefNonce {
+			return doBlockPuts(ctx, bserv, bcache, reporter, log, deferLog,
+				tlfID, tlfName, bps)
+		}
+	}
+
+	blockCount := len(bps.blockStates)
+	log.LazyTrace(ctx, "doBatchBlockPuts with %d blocks", blockCount)
+	defer func() {
+		deferLog.LazyTrace(ctx, "doBatchBlockPuts with %d blocks (err=%v)", blockCount, err)
+	}()
+
+	eg, groupCtx := errgroup.WithContext(ctx)
+
+	var batches [][]blockState
+	for start := 0; start < blockCount; start += kbfsblock.MaxBlocksPerBatch {
+		end := start + kbfsblock.MaxBlocksPerBatch
+		if end > blockCount {
+			end = blockCount
+		}
+		batches = append(batches, bps.blockStates[start:end])
+	}
+	batchCh := make(chan []blockState, len(batches))
+
+	numWorkers := len(batches)
+	if numWorkers > maxParallelBlockBatchPuts {
+		numWorkers = maxParallelBlockBatchPuts
+	}
+	// Every block in a batch can fail, so make room for all of them.
+	blocksToRemoveChan := make(chan *FileBlock, blockCount)
+
+	worker := func() error {
+		for batch := range batchCh {
+			err := doOneBatchBlockPut(groupCtx, batcher, reporter, tlfID,
+				tlfName, batch, blocksToRemoveChan)
+			if err != nil {
+				return err
 			}
 		}
+		return nil
+	}
+	for i := 0; i < numWorkers; i++ {
+		eg.Go(worker)
+	}
+
+	for _, batch := range batches {
+		batchCh <- batch
+	}
+	close(batchCh)
+
+	err = eg.Wait()
+	close(blocksToRemoveChan)
+	if isRecoverableBlockError(err) {
+		blocksToRemove = removeBlocksAfterPutErr(
+			ctx, bcache, log, tlfID, bps, blocksToRemoveChan)
 	}
 	return blocksToRemove, err
 }
 
+// skipExistingBlockPuts returns the subset of `bps` whose blocks (or
+// block references) aren't on the server yet, if the server can tell
+// us that cheaply.  This avoids re-uploading blocks whose puts
+// succeeded before an earlier flush was interrupted.  Since it's just
+// an optimization, any error leaves `bps` as-is.
+func skipExistingBlockPuts(ctx context.Context, log traceLogger,
+	bserv BlockServer, tlfID tlf.ID, bps blockPutState) blockPutState {
+	batcher, ok := bserv.(blockServerBatcher)
+	if !ok || !batcher.BatchSupported() || len(bps.blockStates) == 0 {
+		return bps
+	}
+
+	newBps := newBlockPutState(len(bps.blockStates))
+	for start := 0; start < len(bps.blockStates); start += kbfsblock.MaxRefsPerHasBlocks {
+		end := start + kbfsblock.MaxRefsPerHasBlocks
+		if end > len(bps.blockStates) {
+			end = len(bps.blockStates)
+		}
+		chunk := bps.blockStates[start:end]
+
+		refs := make([]kbfsblock.BatchRef, len(chunk))
+		for i, blockState := range chunk {
+			refs[i] = kbfsblock.BatchRef{
+				ID:      blockState.blockPtr.ID,
+				Context: blockState.blockPtr.Context,
+			}
+		}
+		exists, err := batcher.HasBlocks(ctx, tlfID, refs)
+		if err != nil {
+			log.CDebugf(ctx, "Couldn't check for existing blocks: %+v", err)
+			return bps
+		}
+		for i, blockState := range chunk {
+			if !exists[i] {
+				newBps.blockStates = append(newBps.blockStates, blockState)
+			}
+		}
+	}
+
+	if skipped := len(bps.blockStates) - len(newBps.blockStates); skipped > 0 {
+		log.CDebugf(ctx, "Skipping %d blocks that are already on the server",
+			skipped)
+	}
+	return *newBps
+}
+
 func assembleBlock(ctx context.Context, keyGetter blockKeyGetter,
 	codec kbfscodec.Codec, cryptoPure cryptoPure, kmd KeyMetadata,
 	blockPtr BlockPointer, block Block, buf []byte,
@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/stretchr/testify/require"
@@ -99,3 +101,61 @@ func TestBlockUtilPutFail(t *testing.T) {
 	err := putBlockToServer(ctx, bserver, tlfID, blockPtr, readyBlockData)
 	require.Equal(t, expectedErr, err)
 }
+
+// unsupportedBatcher claims to support batching, but fails every
+// batch put like a server that doesn't know about the batch RPCs.
+type unsupportedBatcher struct {
+	BlockServer
+}
+
+var _ blockServerBatcher = unsupportedBatcher{}
+
+func (unsupportedBatcher) BatchSupported() bool {
+	return true
+}
+
+func (unsupportedBatcher) GetBatch(
+	_ context.Context, _ tlf.ID, _ []kbfsblock.BatchRef) (
+	[]kbfsblock.BatchGetResult, error) {
+	return nil, rpc.MethodNotFoundError{}
+}
+
+func (unsupportedBatcher) PutBatch(
+	_ context.Context, _ tlf.ID, _ []kbfsblock.BatchPut) ([]error, error) {
+	return nil, rpc.MethodNotFoundError{}
+}
+
+func (unsupportedBatcher) HasBlocks(
+	_ context.Context, _ tlf.ID, _ []kbfsblock.BatchRef) ([]bool, error) {
+	return nil, rpc.MethodNotFoundError{}
+}
+
+func TestBlockUtilBatchPutFallsBackWhenUnsupported(t *testing.T) {
+	mockCtrl, ctr, bserver, ctx := blockUtilInit(t)
+	defer blockUtilShutdown(mockCtrl, ctr)
+
+	tlfID := tlf.FakeID(1, tlf.Private)
+	bps := newBlockPutState(3)
+	synced := 0
+	for i := byte(1); i <= 3; i++ {
+		blockPtr := BlockPointer{ID: kbfsblock.FakeID(i)}
+		readyBlockData := ReadyBlockData{buf: []byte{i, 2, 3, 4}}
+		bps.addNewBlock(blockPtr, NewFileBlock(), readyBlockData,
+			func() error {
+				synced++
+				return nil
+			})
+		// Each block is put on its own once the batch fails.
+		bserver.EXPECT().Put(gomock.Any(), tlfID, blockPtr.ID,
+			blockPtr.Context, readyBlockData.buf,
+			readyBlockData.serverHalf).Return(nil)
+	}
+
+	log := traceLogger{logger.NewTestLogger(t)}
+	blocksToRemove, err := doBatchBlockPuts(
+		ctx, unsupportedBatcher{bserver}, nil, nil, log, log, tlfID,
+		"fake", *bps)
+	require.NoError(t, err)
+	require.Len(t, blocksToRemove, 0)
+	require.Equal(t, 3, synced)
+}
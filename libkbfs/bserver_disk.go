@@ -434,6 +434,18 @@ func (b *BlockServerDisk) GetUserQuotaInfo(ctx context.Context) (info *kbfsblock
 	return &kbfsblock.QuotaInfo{Limit: math.MaxInt64}, nil
 }
 
+// ConnectionStatus implements the BlockServer interface for
+// BlockServerDisk.
+func (b *BlockServerDisk) ConnectionStatus() ServerConnectionStatus {
+	return ServerConnectionStatus{IsConnected: true}
+}
+
+// ForceReconnect implements the BlockServer interface for
+// BlockServerDisk.
+func (b *BlockServerDisk) ForceReconnect(ctx context.Context) error {
+	return nil
+}
+
 // GetTeamQuotaInfo implements the BlockServer interface for BlockServerDisk.
 func (b *BlockServerDisk) GetTeamQuotaInfo(
 	ctx context.Context, _ keybase1.TeamID) (
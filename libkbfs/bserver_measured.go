@@ -9,6 +9,7 @@ import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	metrics "github.com/rcrowley/go-metrics"
 	"golang.org/x/net/context"
 )
@@ -24,9 +25,12 @@ type BlockServerMeasured struct {
 	removeBlockReferencesTimer  metrics.Timer
 	archiveBlockReferencesTimer metrics.Timer
 	isUnflushedTimer            metrics.Timer
+	getBatchTimer               metrics.Timer
+	putBatchTimer               metrics.Timer
+	hasBlocksTimer              metrics.Timer
 }
 
-var _ BlockServer = BlockServerMeasured{}
+var _ blockServerBatcher = BlockServerMeasured{}
 
 // NewBlockServerMeasured creates and returns a new
 // BlockServerMeasured instance with the given delegate and registry.
@@ -37,6 +41,9 @@ func NewBlockServerMeasured(delegate BlockServer, r metrics.Registry) BlockServe
 	removeBlockReferencesTimer := metrics.GetOrRegisterTimer("BlockServer.RemoveBlockReferences", r)
 	archiveBlockReferencesTimer := metrics.GetOrRegisterTimer("BlockServer.ArchiveBlockReferences", r)
 	isUnflushedTimer := metrics.GetOrRegisterTimer("BlockServer.IsUnflushed", r)
+	getBatchTimer := metrics.GetOrRegisterTimer("BlockServer.GetBatch", r)
+	putBatchTimer := metrics.GetOrRegisterTimer("BlockServer.PutBatch", r)
+	hasBlocksTimer := metrics.GetOrRegisterTimer("BlockServer.HasBlocks", r)
 	return BlockServerMeasured{
 		delegate:                    delegate,
 		getTimer:                    getTimer,
@@ -45,6 +52,9 @@ func NewBlockServerMeasured(delegate BlockServer, r metrics.Registry) BlockServe
 		removeBlockReferencesTimer:  removeBlockReferencesTimer,
 		archiveBlockReferencesTimer: archiveBlockReferencesTimer,
 		isUnflushedTimer:            isUnflushedTimer,
+		getBatchTimer:               getBatchTimer,
+		putBatchTimer:               putBatchTimer,
+		hasBlocksTimer:              hasBlocksTimer,
 	}
 }
 
@@ -68,6 +78,56 @@ func (b BlockServerMeasured) Put(ctx context.Context, tlfID tlf.ID, id kbfsblock
 	return err
 }
 
+// BatchSupported implements the blockServerBatcher interface for
+// BlockServerMeasured.
+func (b BlockServerMeasured) BatchSupported() bool {
+	batcher, ok := b.delegate.(blockServerBatcher)
+	return ok && batcher.BatchSupported()
+}
+
+// GetBatch implements the blockServerBatcher interface for
+// BlockServerMeasured.
+func (b BlockServerMeasured) GetBatch(ctx context.Context, tlfID tlf.ID,
+	gets []kbfsblock.BatchRef) (
+	results []kbfsblock.BatchGetResult, err error) {
+	batcher, ok := b.delegate.(blockServerBatcher)
+	if !ok {
+		return nil, errors.New("Delegate block server can't batch")
+	}
+	b.getBatchTimer.Time(func() {
+		results, err = batcher.GetBatch(ctx, tlfID, gets)
+	})
+	return results, err
+}
+
+// PutBatch implements the blockServerBatcher interface for
+// BlockServerMeasured.
+func (b BlockServerMeasured) PutBatch(ctx context.Context, tlfID tlf.ID,
+	puts []kbfsblock.BatchPut) (errs []error, err error) {
+	batcher, ok := b.delegate.(blockServerBatcher)
+	if !ok {
+		return nil, errors.New("Delegate block server can't batch")
+	}
+	b.putBatchTimer.Time(func() {
+		errs, err = batcher.PutBatch(ctx, tlfID, puts)
+	})
+	return errs, err
+}
+
+// HasBlocks implements the blockServerBatcher interface for
+// BlockServerMeasured.
+func (b BlockServerMeasured) HasBlocks(ctx context.Context, tlfID tlf.ID,
+	refs []kbfsblock.BatchRef) (exists []bool, err error) {
+	batcher, ok := b.delegate.(blockServerBatcher)
+	if !ok {
+		return nil, errors.New("Delegate block server can't batch")
+	}
+	b.hasBlocksTimer.Time(func() {
+		exists, err = batcher.HasBlocks(ctx, tlfID, refs)
+	})
+	return exists, err
+}
+
 // PutAgain implements the BlockServer interface for BlockServerMeasured.
 func (b BlockServerMeasured) PutAgain(ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
 	context kbfsblock.Context, buf []byte,
@@ -143,3 +203,15 @@ func (b BlockServerMeasured) GetTeamQuotaInfo(
 	info *kbfsblock.QuotaInfo, err error) {
 	return b.delegate.GetTeamQuotaInfo(ctx, tid)
 }
+
+// ConnectionStatus implements the BlockServer interface for
+// BlockServerMeasured.
+func (b BlockServerMeasured) ConnectionStatus() ServerConnectionStatus {
+	return b.delegate.ConnectionStatus()
+}
+
+// ForceReconnect implements the BlockServer interface for
+// BlockServerMeasured.
+func (b BlockServerMeasured) ForceReconnect(ctx context.Context) error {
+	return b.delegate.ForceReconnect(ctx)
+}
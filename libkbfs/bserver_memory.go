@@ -418,6 +418,18 @@ func (b *BlockServerMemory) GetUserQuotaInfo(ctx context.Context) (info *kbfsblo
 	return &kbfsblock.QuotaInfo{Limit: math.MaxInt64}, nil
 }
 
+// ConnectionStatus implements the BlockServer interface for
+// BlockServerMemory.
+func (b *BlockServerMemory) ConnectionStatus() ServerConnectionStatus {
+	return ServerConnectionStatus{IsConnected: true}
+}
+
+// ForceReconnect implements the BlockServer interface for
+// BlockServerMemory.
+func (b *BlockServerMemory) ForceReconnect(ctx context.Context) error {
+	return nil
+}
+
 // GetTeamQuotaInfo implements the BlockServer interface for BlockServerMemory.
 func (b *BlockServerMemory) GetTeamQuotaInfo(
 	ctx context.Context, _ keybase1.TeamID) (
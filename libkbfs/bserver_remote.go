@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/keybase/backoff"
@@ -15,6 +16,7 @@ import (
 	"github.com/keybase/go-framed-msgpack-rpc/rpc"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfssync"
 	"github.com/keybase/kbfs/tlf"
 	"golang.org/x/net/context"
 )
@@ -28,6 +30,29 @@ const (
 	BServerPingTimeout = 30 * time.Second
 )
 
+type ctxBServerPriorityKeyType int
+
+const (
+	ctxBServerPriorityKey ctxBServerPriorityKeyType = iota
+)
+
+// ctxWithBackgroundBServerPriority returns a context whose block
+// server requests yield bandwidth to interactive ones.
+func ctxWithBackgroundBServerPriority(
+	ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxBServerPriorityKey,
+		kbfssync.SemaphorePriorityBackground)
+}
+
+func bserverPriorityFromCtx(
+	ctx context.Context) kbfssync.SemaphorePriority {
+	p, ok := ctx.Value(ctxBServerPriorityKey).(kbfssync.SemaphorePriority)
+	if ok {
+		return p
+	}
+	return kbfssync.SemaphorePriorityInteractive
+}
+
 // blockServerRemoteAuthTokenRefresher is a helper struct for
 // refreshing auth tokens and managing connections.
 type blockServerRemoteClientHandler struct {
@@ -40,10 +65,12 @@ type blockServerRemoteClientHandler struct {
 	connOpts      rpc.ConnectionOpts
 	rpcLogFactory rpc.LogFactory
 	pinger        pinger
+	connTracker   serverConnectionTracker
 
-	connMu sync.RWMutex
-	conn   *rpc.Connection
-	client keybase1.BlockInterface
+	connMu      sync.RWMutex
+	conn        *rpc.Connection
+	client      keybase1.BlockInterface
+	batchClient kbfsblock.BatchInterface
 }
 
 func newBlockServerRemoteClientHandler(name string, log logger.Logger,
@@ -57,6 +84,7 @@ func newBlockServerRemoteClientHandler(name string, log logger.Logger,
 		csg:           csg,
 		srvRemote:     srvRemote,
 		rpcLogFactory: rpcLogFactory,
+		connTracker:   serverConnectionTracker{clock: wallClock{}},
 	}
 
 	b.pinger = pinger{
@@ -97,6 +125,7 @@ func (b *blockServerRemoteClientHandler) initNewConnection() {
 		kbfsblock.ServerErrorUnwrapper{}, b, b.rpcLogFactory,
 		logger.LogOutputWithDepthAdder{Logger: b.log}, b.connOpts)
 	b.client = keybase1.BlockClient{Cli: b.conn.GetClient()}
+	b.batchClient = kbfsblock.BatchClient{Cli: b.conn.GetClient()}
 }
 
 func (b *blockServerRemoteClientHandler) reconnect() error {
@@ -115,6 +144,29 @@ func (b *blockServerRemoteClientHandler) reconnect() error {
 
 }
 
+// forceReconnect replaces a failing connection with a new one, and
+// waits for it to connect, skipping any pending reconnect backoff.
+func (b *blockServerRemoteClientHandler) forceReconnect(
+	ctx context.Context) error {
+	conn := b.getConn()
+	if conn == nil || conn.IsConnected() {
+		return nil
+	}
+	if status := b.connectionStatus(); status.LastError == nil {
+		// We connect on-demand, so there's nothing to retry.
+		return nil
+	}
+
+	b.log.CDebugf(ctx, "%s: forcing a new connection attempt", b.name)
+	b.initNewConnection()
+	return b.getConn().ForceReconnect(ctx)
+}
+
+func (b *blockServerRemoteClientHandler) connectionStatus() ServerConnectionStatus {
+	conn := b.getConn()
+	return b.connTracker.status(conn != nil && conn.IsConnected())
+}
+
 func (b *blockServerRemoteClientHandler) shutdown() {
 	if b.authToken != nil {
 		b.authToken.Shutdown()
@@ -143,6 +195,12 @@ func (b *blockServerRemoteClientHandler) getClient() keybase1.BlockInterface {
 	return b.client
 }
 
+func (b *blockServerRemoteClientHandler) getBatchClient() kbfsblock.BatchInterface {
+	b.connMu.RLock()
+	defer b.connMu.RUnlock()
+	return b.batchClient
+}
+
 type ctxBServerResetKeyType int
 
 const (
@@ -215,6 +273,8 @@ func (b *blockServerRemoteClientHandler) OnConnect(ctx context.Context,
 		return err
 	}
 
+	b.connTracker.onConnect()
+
 	// Start pinging.
 	b.pinger.resetTicker(BServerDefaultPingIntervalSeconds)
 	return nil
@@ -227,6 +287,7 @@ func (b *blockServerRemoteClientHandler) OnConnectError(err error, wait time.Dur
 		b.authToken.Shutdown()
 	}
 	b.pinger.cancelTicker()
+	b.connTracker.onConnectError(err, wait)
 	// TODO: it might make sense to show something to the user if this is
 	// due to authentication, for example.
 }
@@ -301,11 +362,21 @@ type BlockServerRemote struct {
 
 	putConn *blockServerRemoteClientHandler
 	getConn *blockServerRemoteClientHandler
+
+	// batchUnsupported is set to 1 once the server has rejected a
+	// batched RPC as unknown.  Accessed atomically.
+	batchUnsupported int32
+
+	// bwSemaphore, if non-nil, limits the block bytes in flight,
+	// letting interactive requests go ahead of background ones.
+	bwSemaphore *kbfssync.PrioritySemaphore
 }
 
 // Test that BlockServerRemote fully implements the BlockServer interface.
 var _ BlockServer = (*BlockServerRemote)(nil)
 
+var _ blockServerBatcher = (*BlockServerRemote)(nil)
+
 // NewBlockServerRemote constructs a new BlockServerRemote for the
 // given address.
 func NewBlockServerRemote(config blockServerRemoteConfig,
@@ -341,21 +412,28 @@ func newBlockServerRemoteWithClient(config blockServerRemoteConfig,
 	client keybase1.BlockInterface) *BlockServerRemote {
 	log := config.MakeLogger("BSR")
 	deferLog := log.CloneWithAddedDepth(1)
+	// Only use batched RPCs if the test client supports them.
+	batchClient, _ := client.(kbfsblock.BatchInterface)
 	bs := &BlockServerRemote{
 		config:   config,
 		log:      traceLogger{log},
 		deferLog: traceLogger{deferLog},
 		putConn: &blockServerRemoteClientHandler{
-			log:      log,
-			deferLog: deferLog,
-			client:   client,
+			log:         log,
+			deferLog:    deferLog,
+			client:      client,
+			batchClient: batchClient,
 		},
 		getConn: &blockServerRemoteClientHandler{
-			log:      log,
-			deferLog: deferLog,
-			client:   client,
+			log:         log,
+			deferLog:    deferLog,
+			client:      client,
+			batchClient: batchClient,
 		},
 	}
+	if batchClient == nil {
+		bs.batchUnsupported = 1
+	}
 	return bs
 }
 
@@ -370,6 +448,75 @@ func (b *BlockServerRemote) RefreshAuthToken(ctx context.Context) {
 	b.getConn.RefreshAuthToken(ctx)
 }
 
+// ConnectionStatus implements the BlockServer interface for
+// BlockServerRemote.
+func (b *BlockServerRemote) ConnectionStatus() ServerConnectionStatus {
+	// Report the error from whichever connection failed most
+	// recently.  Note that both connections are made on-demand, so
+	// not being connected without an error just means the block
+	// server hasn't been needed yet.
+	putStatus := b.putConn.connectionStatus()
+	getStatus := b.getConn.connectionStatus()
+	status := getStatus
+	if putStatus.LastErrorTime.After(getStatus.LastErrorTime) {
+		status = putStatus
+	}
+	status.IsConnected = putStatus.IsConnected || getStatus.IsConnected
+	return status
+}
+
+// ForceReconnect implements the BlockServer interface for
+// BlockServerRemote.
+func (b *BlockServerRemote) ForceReconnect(ctx context.Context) error {
+	putErr := b.putConn.forceReconnect(ctx)
+	getErr := b.getConn.forceReconnect(ctx)
+	if putErr != nil {
+		return putErr
+	}
+	return getErr
+}
+
+// limitBytesInFlight caps the block bytes that may be in flight to
+// or from the block server at once.  It must be called before the
+// block server is used.
+func (b *BlockServerRemote) limitBytesInFlight(bytes int64) {
+	b.bwSemaphore = kbfssync.NewPrioritySemaphore(bytes)
+}
+
+// acquireBandwidth waits until `size` block bytes may be sent or
+// received, at the priority given by `ctx`, and returns a function
+// that gives them back.
+func (b *BlockServerRemote) acquireBandwidth(
+	ctx context.Context, size int) (release func(), err error) {
+	if b.bwSemaphore == nil {
+		return func() {}, nil
+	}
+	n := int64(size)
+	if n > b.bwSemaphore.Size() {
+		n = b.bwSemaphore.Size()
+	} else if n <= 0 {
+		n = 1
+	}
+	err = b.bwSemaphore.Acquire(ctx, n, bserverPriorityFromCtx(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return func() { b.bwSemaphore.Release(n) }, nil
+}
+
+// acquireGetBandwidth is like acquireBandwidth, but for fetching
+// `numBlocks` blocks.  Their sizes aren't known until they arrive, so
+// each one counts as a maximum-sized block.  Only background fetches
+// (i.e., prefetches) are throttled, since a user is waiting on the
+// interactive ones.
+func (b *BlockServerRemote) acquireGetBandwidth(
+	ctx context.Context, numBlocks int) (release func(), err error) {
+	if bserverPriorityFromCtx(ctx) != kbfssync.SemaphorePriorityBackground {
+		return func() {}, nil
+	}
+	return b.acquireBandwidth(ctx, numBlocks*MaxBlockSizeBytesDefault)
+}
+
 // Get implements the BlockServer interface for BlockServerRemote.
 func (b *BlockServerRemote) Get(ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
 	context kbfsblock.Context) (
@@ -396,6 +543,12 @@ func (b *BlockServerRemote) Get(ctx context.Context, tlfID tlf.ID, id kbfsblock.
 		}
 	}()
 
+	release, err := b.acquireGetBandwidth(ctx, 1)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	defer release()
+
 	arg := kbfsblock.MakeGetBlockArg(tlfID, id, context)
 	res, err := b.getConn.getClient().GetBlock(ctx, arg)
 	return kbfsblock.ParseGetBlockRes(res, err)
@@ -425,11 +578,157 @@ func (b *BlockServerRemote) Put(ctx context.Context, tlfID tlf.ID, id kbfsblock.
 		}
 	}()
 
+	release, err := b.acquireBandwidth(ctx, size)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	arg := kbfsblock.MakePutBlockArg(tlfID, id, bContext, buf, serverHalf)
 	// Handle OverQuota errors at the caller
 	return b.putConn.getClient().PutBlock(ctx, arg)
 }
 
+// BatchSupported implements the blockServerBatcher interface for
+// BlockServerRemote.
+func (b *BlockServerRemote) BatchSupported() bool {
+	return atomic.LoadInt32(&b.batchUnsupported) == 0
+}
+
+// checkBatchErr turns off batching for good if `err` says the server
+// doesn't support it.
+func (b *BlockServerRemote) checkBatchErr(ctx context.Context, err error) {
+	if kbfsblock.IsBatchUnsupportedError(err) {
+		b.log.CDebugf(ctx, "Block server doesn't support batching: %+v", err)
+		atomic.StoreInt32(&b.batchUnsupported, 1)
+	}
+}
+
+// GetBatch implements the blockServerBatcher interface for
+// BlockServerRemote.
+func (b *BlockServerRemote) GetBatch(ctx context.Context, tlfID tlf.ID,
+	gets []kbfsblock.BatchRef) (
+	results []kbfsblock.BatchGetResult, err error) {
+	ctx = rpc.WithFireNow(ctx)
+	b.log.LazyTrace(ctx, "BServer: GetBatch %d blocks", len(gets))
+	defer func() {
+		b.log.LazyTrace(ctx, "BServer: GetBatch %d blocks done (err=%v)",
+			len(gets), err)
+		if err != nil {
+			b.checkBatchErr(ctx, err)
+			b.deferLog.CWarningf(
+				ctx, "GetBatch tlf=%s n=%d err=%v", tlfID, len(gets), err)
+			return
+		}
+		b.deferLog.CDebugf(ctx, "GetBatch tlf=%s n=%d", tlfID, len(gets))
+		dbc := b.config.DiskBlockCache()
+		for i, res := range results {
+			if res.Err != nil {
+				b.deferLog.CWarningf(
+					ctx, "GetBatch id=%s tlf=%s context=%s err=%v",
+					gets[i].ID, tlfID, gets[i].Context, res.Err)
+			} else if dbc != nil {
+				// Cache synchronously, like Get does, so
+				// prefetch operations can work correctly.
+				dbc.Put(ctx, tlfID, gets[i].ID, res.Buf, res.ServerHalf)
+			}
+		}
+	}()
+
+	release, err := b.acquireGetBandwidth(ctx, len(gets))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	arg := kbfsblock.MakeGetBlocksArg(tlfID, gets)
+	res, err := b.getConn.getBatchClient().GetBlocks(ctx, arg)
+	return kbfsblock.ParseGetBlocksRes(res, len(gets), err)
+}
+
+// PutBatch implements the blockServerBatcher interface for
+// BlockServerRemote.
+func (b *BlockServerRemote) PutBatch(ctx context.Context, tlfID tlf.ID,
+	puts []kbfsblock.BatchPut) (errs []error, err error) {
+	ctx = rpc.WithFireNow(ctx)
+	size := 0
+	for _, put := range puts {
+		size += len(put.Buf)
+	}
+	b.log.LazyTrace(ctx, "BServer: PutBatch %d blocks", len(puts))
+	defer func() {
+		b.log.LazyTrace(ctx, "BServer: PutBatch %d blocks done (err=%v)",
+			len(puts), err)
+		if err != nil {
+			b.checkBatchErr(ctx, err)
+			b.deferLog.CWarningf(ctx, "PutBatch tlf=%s n=%d sz=%d err=%v",
+				tlfID, len(puts), size, err)
+			return
+		}
+		b.deferLog.CDebugf(
+			ctx, "PutBatch tlf=%s n=%d sz=%d", tlfID, len(puts), size)
+		for i, putErr := range errs {
+			if putErr != nil {
+				b.deferLog.CWarningf(
+					ctx, "PutBatch id=%s tlf=%s context=%s err=%v",
+					puts[i].ID, tlfID, puts[i].Context, putErr)
+			}
+		}
+	}()
+
+	release, err := b.acquireBandwidth(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Only cache the blocks once we know the server accepted them.
+	// If the server doesn't support batching at all, the caller
+	// falls back to Put, which caches them itself.
+	arg := kbfsblock.MakePutBlocksArg(tlfID, puts)
+	// Handle OverQuota errors at the caller
+	res, err := b.putConn.getBatchClient().PutBlocks(ctx, arg)
+	errs, err = kbfsblock.ParsePutBlocksRes(res, len(puts), err)
+	if err != nil {
+		return nil, err
+	}
+	if dbc := b.config.DiskBlockCache(); dbc != nil {
+		for i, put := range puts {
+			if errs[i] != nil {
+				continue
+			}
+			dbc.Put(ctx, tlfID, put.ID, put.Buf, put.ServerHalf)
+		}
+	}
+	return errs, nil
+}
+
+// HasBlocks implements the blockServerBatcher interface for
+// BlockServerRemote.
+func (b *BlockServerRemote) HasBlocks(ctx context.Context, tlfID tlf.ID,
+	refs []kbfsblock.BatchRef) (exists []bool, err error) {
+	ctx = rpc.WithFireNow(ctx)
+	b.log.LazyTrace(ctx, "BServer: HasBlocks %d refs", len(refs))
+	defer func() {
+		b.log.LazyTrace(ctx, "BServer: HasBlocks %d refs done (err=%v)",
+			len(refs), err)
+		if err != nil {
+			b.checkBatchErr(ctx, err)
+			b.deferLog.CWarningf(
+				ctx, "HasBlocks tlf=%s n=%d err=%v", tlfID, len(refs), err)
+		} else {
+			b.deferLog.CDebugf(
+				ctx, "HasBlocks tlf=%s n=%d", tlfID, len(refs))
+		}
+	}()
+
+	// This is only used by background flushes, so use the put
+	// connection to stay out of the way of reads.
+	arg := kbfsblock.MakeHasBlocksArg(tlfID, refs)
+	res, err := b.putConn.getBatchClient().HasBlocks(ctx, arg)
+	return kbfsblock.ParseHasBlocksRes(res, len(refs), err)
+}
+
 // PutAgain implements the BlockServer interface for BlockServerRemote
 func (b *BlockServerRemote) PutAgain(ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
 	bContext kbfsblock.Context, buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf) (err error) {
@@ -6,12 +6,15 @@ package libkbfs
 
 import (
 	"testing"
+	"time"
 
+	"github.com/golang/mock/gomock"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/go-framed-msgpack-rpc/rpc"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
@@ -26,6 +29,8 @@ type fakeBlockEntry struct {
 type fakeBServerClient struct {
 	keybase1.BlockInterface
 	entries map[keybase1.BlockIdCombo]fakeBlockEntry
+	// rejected lists blocks whose batched puts should fail.
+	rejected map[keybase1.BlockIdCombo]bool
 }
 
 func (fc *fakeBServerClient) PutBlock(
@@ -65,6 +70,58 @@ func (fc *fakeBServerClient) AddReference(ctx context.Context, arg keybase1.AddR
 	return nil
 }
 
+func (fc *fakeBServerClient) GetBlocks(ctx context.Context,
+	arg kbfsblock.GetBlocksArg) (kbfsblock.GetBlocksRes, error) {
+	var res kbfsblock.GetBlocksRes
+	for _, bid := range arg.Bids {
+		getRes, err := fc.GetBlock(ctx, keybase1.GetBlockArg{
+			Bid:    bid,
+			Folder: arg.Folder,
+		})
+		var s keybase1.Status
+		if err != nil {
+			s = err.(kbfsblock.ServerErrorBlockNonExistent).ToStatus()
+		}
+		res.Blocks = append(res.Blocks, kbfsblock.GetBlocksResEntry{
+			Res:    getRes,
+			Status: s,
+		})
+	}
+	return res, nil
+}
+
+func (fc *fakeBServerClient) PutBlocks(ctx context.Context,
+	arg kbfsblock.PutBlocksArg) (kbfsblock.PutBlocksRes, error) {
+	var res kbfsblock.PutBlocksRes
+	for _, block := range arg.Blocks {
+		if fc.rejected[block.Bid] {
+			res.Statuses = append(res.Statuses,
+				kbfsblock.ServerErrorNoPermission{}.ToStatus())
+			continue
+		}
+		err := fc.PutBlock(ctx, block)
+		if err != nil {
+			return kbfsblock.PutBlocksRes{}, err
+		}
+		res.Statuses = append(res.Statuses, keybase1.Status{})
+	}
+	return res, nil
+}
+
+func (fc *fakeBServerClient) HasBlocks(ctx context.Context,
+	arg kbfsblock.HasBlocksArg) (kbfsblock.HasBlocksRes, error) {
+	var res kbfsblock.HasBlocksRes
+	for _, ref := range arg.Refs {
+		e, ok := fc.entries[ref.Bid]
+		exists := false
+		if ok {
+			_, exists = e.refs[ref.Nonce]
+		}
+		res.Exists = append(res.Exists, exists)
+	}
+	return res, nil
+}
+
 type testBlockServerRemoteConfig struct {
 	codecGetter
 	logMaker
@@ -132,6 +189,202 @@ func TestBServerRemotePutAndGet(t *testing.T) {
 	require.Equal(t, serverHalf, sh)
 }
 
+// Test that putting blocks in a batch, and getting them back in a
+// batch, works.
+func TestBServerRemoteBatchPutAndGet(t *testing.T) {
+	currentUID := keybase1.MakeTestUID(1)
+	fc := fakeBServerClient{
+		entries: make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+	}
+	config := testBlockServerRemoteConfig{newTestCodecGetter(),
+		newTestLogMaker(t), nil, nil, nil}
+	b := newBlockServerRemoteWithClient(config, &fc)
+	require.True(t, b.BatchSupported())
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	bCtx := kbfsblock.MakeFirstContext(
+		currentUID.AsUserOrTeam(), keybase1.BlockType_DATA)
+	var puts []kbfsblock.BatchPut
+	for i := byte(1); i <= 3; i++ {
+		data := []byte{i, 2, 3, 4}
+		bID, err := kbfsblock.MakePermanentID(data)
+		require.NoError(t, err)
+		serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+		require.NoError(t, err)
+		puts = append(puts, kbfsblock.BatchPut{
+			ID:         bID,
+			Context:    bCtx,
+			Buf:        data,
+			ServerHalf: serverHalf,
+		})
+	}
+	ctx := context.Background()
+	errs, err := b.PutBatch(ctx, tlfID, puts)
+	require.NoError(t, err)
+	require.Equal(t, []error{nil, nil, nil}, errs)
+
+	// Get them back, along with one that doesn't exist.
+	gets := []kbfsblock.BatchRef{
+		{ID: puts[2].ID, Context: bCtx},
+		{ID: kbfsblock.FakeID(1), Context: bCtx},
+		{ID: puts[0].ID, Context: bCtx},
+	}
+	results, err := b.GetBatch(ctx, tlfID, gets)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, puts[2].Buf, results[0].Buf)
+	require.Equal(t, puts[2].ServerHalf, results[0].ServerHalf)
+	require.IsType(t, kbfsblock.ServerErrorBlockNonExistent{},
+		results[1].Err)
+	require.NoError(t, results[2].Err)
+	require.Equal(t, puts[0].Buf, results[2].Buf)
+	require.Equal(t, puts[0].ServerHalf, results[2].ServerHalf)
+}
+
+// Test that a batch put only caches the blocks the server accepted.
+func TestBServerRemoteBatchPutCachesAcceptedBlocks(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	dbc := NewMockDiskBlockCache(mockCtrl)
+
+	currentUID := keybase1.MakeTestUID(1)
+	fc := fakeBServerClient{
+		entries:  make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+		rejected: make(map[keybase1.BlockIdCombo]bool),
+	}
+	config := testBlockServerRemoteConfig{newTestCodecGetter(),
+		newTestLogMaker(t), nil, nil, dbc}
+	b := newBlockServerRemoteWithClient(config, &fc)
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	bCtx := kbfsblock.MakeFirstContext(
+		currentUID.AsUserOrTeam(), keybase1.BlockType_DATA)
+	var puts []kbfsblock.BatchPut
+	for i := byte(1); i <= 3; i++ {
+		data := []byte{i, 2, 3, 4}
+		bID, err := kbfsblock.MakePermanentID(data)
+		require.NoError(t, err)
+		serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+		require.NoError(t, err)
+		puts = append(puts, kbfsblock.BatchPut{
+			ID:         bID,
+			Context:    bCtx,
+			Buf:        data,
+			ServerHalf: serverHalf,
+		})
+	}
+	fc.rejected[keybase1.BlockIdCombo{
+		BlockHash: puts[1].ID.String(),
+		ChargedTo: currentUID.AsUserOrTeam(),
+		BlockType: keybase1.BlockType_DATA,
+	}] = true
+
+	ctx := context.Background()
+	for _, i := range []int{0, 2} {
+		dbc.EXPECT().Put(gomock.Any(), tlfID, puts[i].ID, puts[i].Buf,
+			puts[i].ServerHalf).Return(nil)
+	}
+	errs, err := b.PutBatch(ctx, tlfID, puts)
+	require.NoError(t, err)
+	require.Len(t, errs, 3)
+	require.NoError(t, errs[0])
+	require.IsType(t, kbfsblock.ServerErrorNoPermission{}, errs[1])
+	require.NoError(t, errs[2])
+}
+
+// Test that HasBlocks reports exactly the references that exist.
+func TestBServerRemoteHasBlocks(t *testing.T) {
+	currentUID := keybase1.MakeTestUID(1)
+	fc := fakeBServerClient{
+		entries: make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+	}
+	config := testBlockServerRemoteConfig{newTestCodecGetter(),
+		newTestLogMaker(t), nil, nil, nil}
+	b := newBlockServerRemoteWithClient(config, &fc)
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	bCtx := kbfsblock.MakeFirstContext(
+		currentUID.AsUserOrTeam(), keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	ctx := context.Background()
+	err = b.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	nonce, err := kbfsblock.MakeRefNonce()
+	require.NoError(t, err)
+	bCtx2 := kbfsblock.MakeContext(
+		currentUID.AsUserOrTeam(), keybase1.MakeTestUID(2).AsUserOrTeam(),
+		nonce, keybase1.BlockType_DATA)
+
+	exists, err := b.HasBlocks(ctx, tlfID, []kbfsblock.BatchRef{
+		{ID: bID, Context: bCtx},
+		{ID: bID, Context: bCtx2},
+		{ID: kbfsblock.FakeID(1), Context: bCtx},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, false}, exists)
+
+	err = b.AddBlockReference(ctx, tlfID, bID, bCtx2)
+	require.NoError(t, err)
+	exists, err = b.HasBlocks(ctx, tlfID, []kbfsblock.BatchRef{
+		{ID: bID, Context: bCtx2},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true}, exists)
+}
+
+// Test that batching is turned off if the client doesn't support it.
+func TestBServerRemoteBatchUnsupported(t *testing.T) {
+	fc := fakeBServerClient{
+		entries: make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+	}
+	config := testBlockServerRemoteConfig{newTestCodecGetter(),
+		newTestLogMaker(t), nil, nil, nil}
+	// Hide the batch methods of the fake client.
+	client := struct{ keybase1.BlockInterface }{&fc}
+	b := newBlockServerRemoteWithClient(config, client)
+	require.False(t, b.BatchSupported())
+}
+
+// Test that only background fetches are throttled, and only once a
+// limit is set.
+func TestBServerRemoteGetBandwidth(t *testing.T) {
+	fc := fakeBServerClient{
+		entries: make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+	}
+	config := testBlockServerRemoteConfig{newTestCodecGetter(),
+		newTestLogMaker(t), nil, nil, nil}
+	b := newBlockServerRemoteWithClient(config, &fc)
+	ctx := context.Background()
+	bgCtx := ctxWithBackgroundBServerPriority(ctx)
+
+	// No limit by default.
+	release, err := b.acquireBandwidth(bgCtx, MaxBlockSizeBytesDefault)
+	require.NoError(t, err)
+	release()
+
+	b.limitBytesInFlight(MaxBlockSizeBytesDefault)
+	release, err = b.acquireBandwidth(bgCtx, MaxBlockSizeBytesDefault)
+	require.NoError(t, err)
+	defer release()
+
+	// An interactive fetch doesn't wait for the bandwidth.
+	getRelease, err := b.acquireGetBandwidth(ctx, 1)
+	require.NoError(t, err)
+	getRelease()
+
+	// A background one does.
+	timeoutCtx, cancel := context.WithTimeout(bgCtx, 10*time.Millisecond)
+	defer cancel()
+	_, err = b.acquireGetBandwidth(timeoutCtx, 1)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+}
+
 // If we cancel the RPC before the RPC returns, the call should error quickly.
 func TestBServerRemotePutCanceled(t *testing.T) {
 	currentUID := keybase1.MakeTestUID(1)
@@ -177,6 +177,9 @@ func (c *ChatRPC) GetConversationID(
 		MembersType:      membersTypeFromTlfType(tlfType),
 		IdentifyBehavior: keybase1.TLFIdentifyBehavior_KBFS_CHAT,
 	}
+	if channelName != "" {
+		arg.TopicName = &channelName
+	}
 
 	// Try creating the conversation to get back the ID -- if the
 	// conversation already exists, this just returns the existing
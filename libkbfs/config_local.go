@@ -19,7 +19,9 @@ import (
 	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/kbfscodec"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfshash"
 	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/metricsutil"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	metrics "github.com/rcrowley/go-metrics"
@@ -55,12 +57,16 @@ const (
 	// directory operations should be batched together in a single
 	// background flush.
 	bgFlushDirOpBatchSizeDefault = 100
+	// metricsFlushPeriodDefault is the default for how often metrics
+	// are sent to external sinks.
+	metricsFlushPeriodDefault = 10 * time.Second
 	// bgFlushPeriodDefault is the default for how long to wait for a
 	// batch to fill up before syncing a set of changes to the servers.
 	bgFlushPeriodDefault         = 1 * time.Second
 	keyBundlesCacheCapacityBytes = 10 * cache.MB
 	// folder name for persisted config parameters.
 	syncedTlfConfigFolderName = "synced_tlf_config"
+	tlfAliasConfigFolderName  = "tlf_alias_config"
 
 	// By default, this will be the block type given to all blocks
 	// that aren't explicitly some other type.
@@ -95,6 +101,7 @@ type ConfigLocal struct {
 	clock            Clock
 	kbpki            KBPKI
 	renamer          ConflictRenamer
+	conflictPolicy   ConflictPolicy
 	registry         metrics.Registry
 	loggerFn         func(prefix string) logger.Logger
 	noBGFlush        bool // logic opposite so the default value is the common setting
@@ -103,9 +110,19 @@ type ConfigLocal struct {
 	syncedTlfs       map[tlf.ID]bool
 	defaultBlockType keybase1.BlockType
 	kbfsService      *KBFSService
+	metricsFlusher   *metricsutil.Flusher
+	activityBridge   *FolderActivityBridge
+	auditLogger      *AuditLogger
 	kbCtx            Context
 	rootNodeWrappers []func(Node) Node
 
+	compactDirEntries bool
+	blockHashType     kbfshash.HashType
+
+	tlfAliases *tlf.AliasTable
+
+	blockCacheTinyLFU bool
+
 	maxNameBytes  uint32
 	maxDirBytes   uint64
 	rekeyQueue    RekeyQueue
@@ -383,6 +400,7 @@ func NewConfigLocal(mode InitMode,
 	if diskCacheMode == DiskCacheModeLocal {
 		config.loadSyncedTlfsLocked()
 	}
+	config.loadTlfAliases()
 	config.SetClock(wallClock{})
 	config.SetReporter(NewReporterSimple(config.Clock(), 10))
 	config.SetConflictRenamer(WriterDeviceDateConflictRenamer{config})
@@ -412,6 +430,7 @@ func NewConfigLocal(mode InitMode,
 	config.bgFlushPeriod = bgFlushPeriodDefault
 	config.metadataVersion = defaultClientMetadataVer
 	config.defaultBlockType = defaultBlockTypeDefault
+	config.blockHashType = kbfshash.DefaultHashType
 	config.quotaUsage =
 		make(map[keybase1.UserOrTeamID]*EventuallyConsistentQuotaUsage)
 	config.rekeyFSMLimiter = NewOngoingWorkLimiter(config.Mode().RekeyWorkers())
@@ -784,6 +803,20 @@ func (c *ConfigLocal) SetConflictRenamer(cr ConflictRenamer) {
 	c.renamer = cr
 }
 
+// ConflictPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) ConflictPolicy() ConflictPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.conflictPolicy
+}
+
+// SetConflictPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetConflictPolicy(p ConflictPolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.conflictPolicy = p
+}
+
 // MetadataVersion implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) MetadataVersion() kbfsmd.MetadataVer {
 	c.lock.RLock()
@@ -798,9 +831,20 @@ func (c *ConfigLocal) SetMetadataVersion(mdVer kbfsmd.MetadataVer) {
 	c.metadataVersion = mdVer
 }
 
-// DataVersion implements the Config interface for ConfigLocal.
+// DataVersion implements the Config interface for ConfigLocal.  It
+// returns the highest data version that the configured block
+// features can produce.
 func (c *ConfigLocal) DataVersion() DataVer {
-	return AtLeastTwoLevelsOfChildrenDataVer
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	switch {
+	case c.blockHashType != kbfshash.DefaultHashType:
+		return BLAKE2bHashDataVer
+	case c.compactDirEntries:
+		return CompactDirEntriesDataVer
+	default:
+		return AtLeastTwoLevelsOfChildrenDataVer
+	}
 }
 
 // DefaultBlockType implements the Config interface for ConfigLocal.
@@ -817,6 +861,54 @@ func (c *ConfigLocal) SetDefaultBlockType(blockType keybase1.BlockType) {
 	c.defaultBlockType = blockType
 }
 
+// CompactDirEntries implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) CompactDirEntries() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.compactDirEntries
+}
+
+// SetCompactDirEntries implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetCompactDirEntries(compact bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.compactDirEntries = compact
+}
+
+func (c *ConfigLocal) makeBlockCacheLocked(
+	capacity uint64) *BlockCacheStandard {
+	if c.blockCacheTinyLFU {
+		return NewBlockCacheStandardWithTinyLFU(10000, capacity)
+	}
+	return NewBlockCacheStandard(10000, capacity)
+}
+
+// SetBlockCacheTinyLFU sets whether the clean block cache uses a
+// TinyLFU admission policy instead of plain LRU eviction, and
+// replaces the current block cache (keeping its capacity, but not
+// its contents) to match.  It should be called before journaling is
+// enabled, since the journal wraps the block cache.
+func (c *ConfigLocal) SetBlockCacheTinyLFU(enabled bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blockCacheTinyLFU = enabled
+	c.bcache = c.makeBlockCacheLocked(c.bcache.GetCleanBytesCapacity())
+}
+
+// BlockHashType implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) BlockHashType() kbfshash.HashType {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.blockHashType
+}
+
+// SetBlockHashType implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetBlockHashType(hashType kbfshash.HashType) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blockHashType = hashType
+}
+
 // DoBackgroundFlushes implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) DoBackgroundFlushes() bool {
 	if !c.Mode().BackgroundFlushesEnabled() {
@@ -924,7 +1016,7 @@ func (c *ConfigLocal) resetCachesWithoutShutdown() DirtyBlockCache {
 		log.Debug("setting clean block cache capacity based on existing value %d",
 			capacity)
 	}
-	c.bcache = NewBlockCacheStandard(10000, capacity)
+	c.bcache = c.makeBlockCacheLocked(capacity)
 
 	if !c.Mode().DirtyBlockCacheEnabled() {
 		return nil
@@ -1152,6 +1244,12 @@ func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 	if kbfsServ != nil {
 		kbfsServ.Shutdown()
 	}
+	if c.metricsFlusher != nil {
+		c.metricsFlusher.Stop()
+	}
+	if c.activityBridge != nil {
+		c.activityBridge.Shutdown()
+	}
 
 	if len(errorList) == 1 {
 		return errorList[0]
@@ -1422,6 +1520,81 @@ func (c *ConfigLocal) SetTlfSyncState(tlfID tlf.ID, isSynced bool) error {
 	return nil
 }
 
+func (c *ConfigLocal) loadTlfAliases() (err error) {
+	c.tlfAliases = tlf.NewAliasTable()
+	if c.IsTestMode() || c.storageRoot == "" {
+		return nil
+	}
+	ldb, err := c.openConfigLevelDB(tlfAliasConfigFolderName)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	iter := ldb.NewIterator(nil, nil)
+	defer iter.Release()
+
+	log := c.MakeLogger("")
+	// If there are any invalid aliases, delete them.
+	deleteBatch := new(leveldb.Batch)
+	for iter.Next() {
+		alias := string(iter.Key())
+		err := c.tlfAliases.Set(alias, tlf.CanonicalName(iter.Value()))
+		if err != nil {
+			log.Debug("deleting invalid TLF alias %s: %+v", alias, err)
+			deleteBatch.Delete(iter.Key())
+		}
+	}
+	return ldb.Write(deleteBatch, nil)
+}
+
+// LookupTlfAlias implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) LookupTlfAlias(alias string) (tlf.CanonicalName, bool) {
+	return c.tlfAliases.Lookup(alias)
+}
+
+// TlfAliases implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) TlfAliases() []tlf.Alias {
+	return c.tlfAliases.Aliases()
+}
+
+// SetTlfAlias implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetTlfAlias(
+	alias string, target tlf.CanonicalName) error {
+	err := tlf.CheckAlias(alias, target)
+	if err != nil {
+		return err
+	}
+	if !c.IsTestMode() && c.storageRoot != "" {
+		ldb, err := c.openConfigLevelDB(tlfAliasConfigFolderName)
+		if err != nil {
+			return err
+		}
+		defer ldb.Close()
+		err = ldb.Put([]byte(alias), []byte(target), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return c.tlfAliases.Set(alias, target)
+}
+
+// RemoveTlfAlias implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) RemoveTlfAlias(alias string) error {
+	if !c.IsTestMode() && c.storageRoot != "" {
+		ldb, err := c.openConfigLevelDB(tlfAliasConfigFolderName)
+		if err != nil {
+			return err
+		}
+		defer ldb.Close()
+		err = ldb.Delete([]byte(alias), nil)
+		if err != nil {
+			return err
+		}
+	}
+	c.tlfAliases.Remove(alias)
+	return nil
+}
+
 // PrefetchStatus implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) PrefetchStatus(ctx context.Context, tlfID tlf.ID,
 	ptr BlockPointer) PrefetchStatus {
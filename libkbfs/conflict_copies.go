@@ -0,0 +1,192 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+
+	"golang.org/x/net/context"
+)
+
+// conflictCopy is a copy of a conflicting entry, made by conflict
+// resolution.
+type conflictCopy struct {
+	// dir holds the names of the directories leading to the copy,
+	// starting below the TLF root.
+	dir      []string
+	original string
+	name     string
+}
+
+// getConflictCopies returns the conflict copies that the given
+// actions will make, if they need to be moved afterward according to
+// the conflict policy.
+func (cr *ConflictResolver) getConflictCopies(
+	actionMap map[BlockPointer]crActionList,
+	mergedPaths map[BlockPointer]path) (copies []conflictCopy) {
+	policy := cr.config.ConflictPolicy()
+	if policy.Subdir == "" {
+		return nil
+	}
+
+	// By now every action is keyed by the merged directory it
+	// applies to.
+	dirPaths := make(map[BlockPointer]path, len(mergedPaths))
+	for _, p := range mergedPaths {
+		dirPaths[p.tailPointer()] = p
+	}
+	for ptr, actions := range actionMap {
+		p, ok := dirPaths[ptr]
+		if !ok {
+			continue
+		}
+		var dir []string
+		for _, pn := range p.path[1:] {
+			dir = append(dir, pn.Name)
+		}
+		if len(dir) > 0 && dir[len(dir)-1] == policy.Subdir {
+			// Already in the right place.
+			continue
+		}
+		for _, action := range actions {
+			var fromName, toName string
+			switch a := action.(type) {
+			case *renameUnmergedAction:
+				fromName, toName = a.fromName, a.toName
+			case *renameMergedAction:
+				fromName, toName = a.fromName, a.toName
+			case *copyUnmergedEntryAction:
+				if !a.unique {
+					continue
+				}
+				fromName, toName = a.fromName, a.toName
+			default:
+				continue
+			}
+			if fromName == toName {
+				continue
+			}
+			copies = append(copies, conflictCopy{
+				dir:      dir,
+				original: fromName,
+				name:     toName,
+			})
+		}
+	}
+	return copies
+}
+
+// conflictCopiesToPrune returns the names of the entries in
+// `children` that are conflict copies of `original` and need to be
+// removed so that only `maxCopies` of them are left.  `newest`, the
+// copy that was just made, is always kept; the others are removed
+// oldest (by ctime) first.  Directories are never pruned.
+func conflictCopiesToPrune(policy ConflictPolicy, original, newest string,
+	children map[string]EntryInfo, maxCopies int) ([]string, error) {
+	re, err := policy.conflictNameRegexp(original)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, ei := range children {
+		if name != newest && ei.Type != Dir && re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	keep := maxCopies - 1
+	if _, ok := children[newest]; !ok {
+		keep = maxCopies
+	}
+	if len(names) <= keep {
+		return nil, nil
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ci, cj := children[names[i]].Ctime, children[names[j]].Ctime
+		if ci != cj {
+			return ci > cj
+		}
+		return names[i] > names[j]
+	})
+	return names[keep:], nil
+}
+
+// moveConflictCopy moves one conflict copy into the policy's
+// subdirectory, creating it if needed, and prunes old copies there.
+func (cr *ConflictResolver) moveConflictCopy(ctx context.Context,
+	policy ConflictPolicy, rootNode Node, c conflictCopy) error {
+	kbfsOps := cr.config.KBFSOps()
+	dirNode := rootNode
+	for _, name := range c.dir {
+		n, _, err := kbfsOps.Lookup(ctx, dirNode, name)
+		if err != nil {
+			return err
+		}
+		dirNode = n
+	}
+	subdirNode, _, err := kbfsOps.Lookup(ctx, dirNode, policy.Subdir)
+	if _, ok := err.(NoSuchNameError); ok {
+		subdirNode, _, err = kbfsOps.CreateDir(ctx, dirNode, policy.Subdir)
+	}
+	if err != nil {
+		return err
+	}
+	err = kbfsOps.Rename(ctx, dirNode, c.name, subdirNode, c.name)
+	if err != nil {
+		return err
+	}
+
+	if policy.MaxCopies <= 0 {
+		return nil
+	}
+	children, err := kbfsOps.GetDirChildren(ctx, subdirNode)
+	if err != nil {
+		return err
+	}
+	toPrune, err := conflictCopiesToPrune(
+		policy, c.original, c.name, children, policy.MaxCopies)
+	if err != nil {
+		return err
+	}
+	for _, name := range toPrune {
+		cr.log.CDebugf(ctx, "Removing old conflict copy %s", name)
+		err = kbfsOps.RemoveEntry(ctx, subdirNode, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveConflictCopies moves the conflict copies made by a completed
+// resolution into the subdirectory named by the conflict policy.
+// Failures are only logged, since the copies are still safe where
+// they are.
+func (cr *ConflictResolver) moveConflictCopies(
+	ctx context.Context, copies []conflictCopy) {
+	policy := cr.config.ConflictPolicy()
+	err := cr.fbo.runUnlessShutdown(func(ctx context.Context) error {
+		// Local writes cancel any ongoing resolution, so these moves
+		// can't use its context.  They still need a CR ID, though, so
+		// that their sync doesn't try to resolve again.
+		ctx = CtxWithRandomIDReplayable(ctx, CtxCRIDKey, CtxCROpID, cr.log)
+		rootNode, _, _, err := cr.fbo.getRootNode(ctx)
+		if err != nil {
+			return err
+		}
+		for _, c := range copies {
+			cr.log.CDebugf(ctx, "Moving conflict copy %s into %s",
+				c.name, policy.Subdir)
+			err := cr.moveConflictCopy(ctx, policy, rootNode, c)
+			if err != nil {
+				cr.log.CDebugf(ctx, "Couldn't move conflict copy %s: %+v",
+					c.name, err)
+			}
+		}
+		return cr.config.KBFSOps().SyncAll(ctx, cr.fbo.folderBranch)
+	})
+	if err != nil {
+		cr.log.CDebugf(ctx, "Couldn't move conflict copies: %+v", err)
+	}
+}
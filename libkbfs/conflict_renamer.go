@@ -5,7 +5,10 @@
 package libkbfs
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/keybase/client/go/protocol/keybase1"
@@ -14,6 +17,75 @@ import (
 	"golang.org/x/net/context"
 )
 
+// DefaultConflictSuffixFormat is the suffix format that gives conflict
+// copies names like "foo.conflicted (alice's laptop copy
+// 2018-01-02).txt".
+const DefaultConflictSuffixFormat = ".conflicted ({user}'s {device} copy {date})"
+
+// The placeholders that can be used in a conflict suffix format.
+const (
+	conflictSuffixUser   = "{user}"
+	conflictSuffixDevice = "{device}"
+	conflictSuffixDate   = "{date}"
+	conflictSuffixTime   = "{time}"
+)
+
+// ConflictPolicy controls how conflict resolution names the copies it
+// makes of conflicting directory entries, and where it keeps them.
+type ConflictPolicy struct {
+	// SuffixFormat is added to the name of a conflicting entry,
+	// before its extension, to make the name of its conflict copy.
+	// The placeholders {user}, {device}, {date} and {time} are
+	// replaced with the writer of the copy, their device, and the
+	// date (YYYY-MM-DD) and time (HHMMSS) of the resolution.  If
+	// empty, DefaultConflictSuffixFormat is used.
+	SuffixFormat string
+	// Subdir, if non-empty, is the name of a subdirectory that
+	// conflict copies are moved into, in the directory where the
+	// conflict happened, after each resolution.
+	Subdir string
+	// MaxCopies, if positive, is how many conflict copies of each
+	// entry are kept in Subdir.  The oldest ones are removed first.
+	MaxCopies int
+}
+
+// Validate returns an error if the policy would make invalid names.
+func (p ConflictPolicy) Validate() error {
+	if strings.ContainsAny(p.SuffixFormat, "/\\") {
+		return errors.New("conflict suffix format can't contain slashes")
+	}
+	if strings.ContainsAny(p.Subdir, "/\\") || p.Subdir == "." ||
+		p.Subdir == ".." {
+		return fmt.Errorf("invalid conflict subdirectory %q", p.Subdir)
+	}
+	if p.MaxCopies < 0 {
+		return fmt.Errorf("invalid max conflict copies %d", p.MaxCopies)
+	}
+	return nil
+}
+
+func (p ConflictPolicy) suffixFormat() string {
+	if p.SuffixFormat == "" {
+		return DefaultConflictSuffixFormat
+	}
+	return p.SuffixFormat
+}
+
+// conflictNameRegexp returns a regexp matching the names of all the
+// conflict copies of `original` made with this policy.
+func (p ConflictPolicy) conflictNameRegexp(original string) (
+	*regexp.Regexp, error) {
+	base, ext := splitExtension(original)
+	r := strings.NewReplacer(
+		regexp.QuoteMeta(conflictSuffixUser), ".*",
+		regexp.QuoteMeta(conflictSuffixDevice), ".*",
+		regexp.QuoteMeta(conflictSuffixDate), ".*",
+		regexp.QuoteMeta(conflictSuffixTime), ".*")
+	suffix := r.Replace(regexp.QuoteMeta(p.suffixFormat()))
+	return regexp.Compile(
+		"^" + regexp.QuoteMeta(base) + suffix + regexp.QuoteMeta(ext) + "$")
+}
+
 // WriterDeviceDateConflictRenamer renames a file using
 // a username, device name, and date.
 type WriterDeviceDateConflictRenamer struct {
@@ -37,14 +109,21 @@ func (cr WriterDeviceDateConflictRenamer) ConflictRename(
 
 // ConflictRenameHelper is a helper for ConflictRename especially useful from
 // tests.
-func (WriterDeviceDateConflictRenamer) ConflictRenameHelper(t time.Time, user, device, original string) string {
+func (cr WriterDeviceDateConflictRenamer) ConflictRenameHelper(t time.Time, user, device, original string) string {
+	var policy ConflictPolicy
+	if cr.config != nil {
+		policy = cr.config.ConflictPolicy()
+	}
 	if device == "" {
 		device = "unknown"
 	}
 	base, ext := splitExtension(original)
-	date := t.Format("2006-01-02")
-	return fmt.Sprintf("%s.conflicted (%s's %s copy %s)%s",
-		base, user, device, date, ext)
+	r := strings.NewReplacer(
+		conflictSuffixUser, user,
+		conflictSuffixDevice, device,
+		conflictSuffixDate, t.Format("2006-01-02"),
+		conflictSuffixTime, t.Format("150405"))
+	return base + r.Replace(policy.suffixFormat()) + ext
 }
 
 // splitExtension splits filename into a base name and the extension.
@@ -6,6 +6,8 @@ package libkbfs
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func testSplitExtension(t *testing.T, s, base, ext string) {
@@ -30,3 +32,36 @@ func TestSplitExtension(t *testing.T) {
 	testSplitExtension(t, "weird. is this?", "weird. is this?", "")
 	testSplitExtension(t, "", "", "")
 }
+
+func TestConflictPolicy(t *testing.T) {
+	require.NoError(t, ConflictPolicy{}.Validate())
+	require.Error(t, ConflictPolicy{SuffixFormat: "a/b"}.Validate())
+	require.Error(t, ConflictPolicy{Subdir: ".."}.Validate())
+	require.Error(t, ConflictPolicy{MaxCopies: -1}.Validate())
+
+	policy := ConflictPolicy{SuffixFormat: " ({user} {date})"}
+	children := map[string]EntryInfo{
+		"f (alice 2018-01-01).txt": {Type: File, Ctime: 1},
+		"f (bob 2018-01-03).txt":   {Type: File, Ctime: 3},
+		"f (bob 2018-01-02).txt":   {Type: File, Ctime: 2},
+		"f (dir 2018-01-04).txt":   {Type: Dir, Ctime: 4},
+		"f.txt":                    {Type: File, Ctime: 5},
+		"g (bob 2018-01-05).txt":   {Type: File, Ctime: 5},
+	}
+	toPrune, err := conflictCopiesToPrune(
+		policy, "f.txt", "f (bob 2018-01-03).txt", children, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"f (bob 2018-01-02).txt",
+		"f (alice 2018-01-01).txt",
+	}, toPrune)
+	// The newest copy is kept even if its ctime is older.
+	toPrune, err = conflictCopiesToPrune(
+		policy, "f.txt", "f (alice 2018-01-01).txt", children, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"f (bob 2018-01-02).txt"}, toPrune)
+	toPrune, err = conflictCopiesToPrune(
+		policy, "f.txt", "f (bob 2018-01-03).txt", children, 3)
+	require.NoError(t, err)
+	require.Len(t, toPrune, 0)
+}
@@ -2065,8 +2065,9 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 
 		fileActions := actionMap[p.tailPointer()]
 
-		// If this is a directory with setAttr(mtime)-related actions,
-		// just those action should be collapsed into the parent.
+		// If this is a directory with setAttr(mtime or xattr)-related
+		// actions, just those action should be collapsed into the
+		// parent.
 		if !chain.isFile() {
 			var parentActions crActionList
 			var otherDirActions crActionList
@@ -2074,7 +2075,9 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 				moved := false
 				switch realAction := action.(type) {
 				case *copyUnmergedAttrAction:
-					if realAction.attr[0] == mtimeAttr && !realAction.moved {
+					attr := realAction.attr[0]
+					if (attr == mtimeAttr || attr == xattrAttr) &&
+						!realAction.moved {
 						realAction.moved = true
 						parentActions = append(parentActions, realAction)
 						moved = true
@@ -2092,7 +2095,7 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 				}
 			}
 			if len(parentActions) == 0 {
-				// A directory with no mtime actions, so treat it
+				// A directory with no mtime or xattr actions, so treat it
 				// normally.
 				continue
 			}
@@ -3202,6 +3205,16 @@ func (cr *ConflictResolver) doResolve(ctx context.Context, ci conflictInput) {
 		}
 	}()
 
+	// Move any conflict copies this resolution makes only after it
+	// releases its locks, since that takes ordinary writes.
+	var conflictCopies []conflictCopy
+	logCtx := ctx
+	defer func() {
+		if err == nil && len(conflictCopies) > 0 {
+			cr.moveConflictCopies(logCtx, conflictCopies)
+		}
+	}()
+
 	// Check if we need to deploy the nuclear option and completely
 	// block unmerged writes while we try to resolve.
 	doLock := func() bool {
@@ -3341,6 +3354,7 @@ func (cr *ConflictResolver) doResolve(ctx context.Context, ci conflictInput) {
 	}
 
 	cr.log.CDebugf(ctx, "Action map: %v", actionMap)
+	conflictCopies = cr.getConflictCopies(actionMap, mergedPaths)
 
 	// Step 3: Apply the actions by looking up the corresponding
 	// unmerged dir entry and copying it to a copy of the
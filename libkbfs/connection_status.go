@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"sync"
+	"time"
 )
 
 // Service names used in ConnectionStatus.
@@ -78,3 +79,61 @@ func (kcs *kbfsCurrentStatus) PushStatusChange() {
 	close(kcs.invalidateChan)
 	kcs.invalidateChan = make(chan StatusUpdate)
 }
+
+// ServerConnectionStatus describes the state of the connection to a
+// remote server, as last observed by this client.
+type ServerConnectionStatus struct {
+	IsConnected bool
+	// LastError is the most recent connection error, or nil if the
+	// last connection attempt succeeded.
+	LastError     error
+	LastErrorTime time.Time
+	// NextRetry is when the next reconnection attempt is scheduled,
+	// if the connection is currently failing.  It is zero otherwise.
+	NextRetry time.Time
+}
+
+// serverConnectionTracker keeps track of the connection errors seen
+// by an RPC connection handler, so they can be reported in a
+// ServerConnectionStatus.
+type serverConnectionTracker struct {
+	clock Clock
+
+	lock        sync.Mutex
+	lastErr     error
+	lastErrTime time.Time
+	nextRetry   time.Time
+}
+
+func (sct *serverConnectionTracker) onConnect() {
+	sct.lock.Lock()
+	defer sct.lock.Unlock()
+	sct.lastErr = nil
+	sct.lastErrTime = time.Time{}
+	sct.nextRetry = time.Time{}
+}
+
+func (sct *serverConnectionTracker) onConnectError(
+	err error, wait time.Duration) {
+	sct.lock.Lock()
+	defer sct.lock.Unlock()
+	now := sct.clock.Now()
+	sct.lastErr = err
+	sct.lastErrTime = now
+	sct.nextRetry = now.Add(wait)
+}
+
+func (sct *serverConnectionTracker) status(
+	isConnected bool) ServerConnectionStatus {
+	sct.lock.Lock()
+	defer sct.lock.Unlock()
+	status := ServerConnectionStatus{
+		IsConnected:   isConnected,
+		LastError:     sct.lastErr,
+		LastErrorTime: sct.lastErrTime,
+	}
+	if !isConnected {
+		status.NextRetry = sct.nextRetry
+	}
+	return status
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerConnectionTracker(t *testing.T) {
+	clock := newTestClockNow()
+	sct := serverConnectionTracker{clock: clock}
+
+	status := sct.status(false)
+	require.Equal(t, ServerConnectionStatus{}, status)
+
+	t.Log("A connection error schedules a retry")
+	connErr := errors.New("connection refused")
+	sct.onConnectError(connErr, 10*time.Second)
+	status = sct.status(false)
+	require.False(t, status.IsConnected)
+	require.Equal(t, connErr, status.LastError)
+	require.Equal(t, clock.Now(), status.LastErrorTime)
+	require.Equal(t, clock.Now().Add(10*time.Second), status.NextRetry)
+
+	t.Log("No retry is reported once connected")
+	status = sct.status(true)
+	require.True(t, status.IsConnected)
+	require.True(t, status.NextRetry.IsZero())
+
+	t.Log("A successful connection clears the error")
+	sct.onConnect()
+	status = sct.status(true)
+	require.Equal(t, ServerConnectionStatus{IsConnected: true}, status)
+}
@@ -180,6 +180,9 @@ func (c CryptoCommon) depadBlock(paddedBlock []byte) ([]byte, error) {
 // EncryptBlock implements the Crypto interface for CryptoCommon.
 func (c CryptoCommon) EncryptBlock(block Block, key kbfscrypto.BlockCryptKey) (
 	plainSize int, encryptedBlock kbfscrypto.EncryptedBlock, err error) {
+	if dblock, ok := block.(*DirBlock); ok && dblock.compact {
+		block = dblock.makeCompactCopy()
+	}
 	encodedBlock, err := c.codec.Encode(block)
 	if err != nil {
 		return -1, kbfscrypto.EncryptedBlock{}, err
@@ -218,5 +221,8 @@ func (c CryptoCommon) DecryptBlock(
 	if err != nil {
 		return errors.WithStack(BlockDecodeError{err})
 	}
+	if dblock, ok := block.(*DirBlock); ok {
+		dblock.expandCompactChildren()
+	}
 	return nil
 }
@@ -21,11 +21,11 @@ type perTeamKeyPair struct {
 type perTeamKeyPairs map[keybase1.PerTeamKeyGeneration]perTeamKeyPair
 
 // CryptoLocal implements the Crypto interface by using a local
-// signing key and a local crypt private key.
+// signer and decryptor for the device keys.
 type CryptoLocal struct {
 	CryptoCommon
-	kbfscrypto.SigningKeySigner
-	cryptPrivateKey kbfscrypto.CryptPrivateKey
+	kbfscrypto.Signer
+	decryptor       kbfscrypto.Decryptor
 	teamPrivateKeys map[keybase1.TeamID]perTeamKeyPairs
 }
 
@@ -36,10 +36,21 @@ var _ Crypto = (*CryptoLocal)(nil)
 func NewCryptoLocal(codec kbfscodec.Codec,
 	signingKey kbfscrypto.SigningKey,
 	cryptPrivateKey kbfscrypto.CryptPrivateKey) *CryptoLocal {
+	return NewCryptoLocalWithDeviceKeys(codec,
+		kbfscrypto.SigningKeySigner{Key: signingKey},
+		kbfscrypto.CryptPrivateKeyDecryptor{Key: cryptPrivateKey})
+}
+
+// NewCryptoLocalWithDeviceKeys constructs a new CryptoLocal instance
+// that does all its device key operations through the given signer
+// and decryptor, e.g. ones backed by a platform keystore.
+func NewCryptoLocalWithDeviceKeys(codec kbfscodec.Codec,
+	signer kbfscrypto.Signer,
+	decryptor kbfscrypto.Decryptor) *CryptoLocal {
 	return &CryptoLocal{
 		MakeCryptoCommon(codec),
-		kbfscrypto.SigningKeySigner{Key: signingKey},
-		cryptPrivateKey,
+		signer,
+		decryptor,
 		make(map[keybase1.TeamID]perTeamKeyPairs),
 	}
 }
@@ -50,8 +61,8 @@ func (c *CryptoLocal) DecryptTLFCryptKeyClientHalf(ctx context.Context,
 	publicKey kbfscrypto.TLFEphemeralPublicKey,
 	encryptedClientHalf kbfscrypto.EncryptedTLFCryptKeyClientHalf) (
 	kbfscrypto.TLFCryptKeyClientHalf, error) {
-	return kbfscrypto.DecryptTLFCryptKeyClientHalf(
-		c.cryptPrivateKey, publicKey, encryptedClientHalf)
+	return c.decryptor.DecryptTLFCryptKeyClientHalf(
+		ctx, publicKey, encryptedClientHalf)
 }
 
 // DecryptTLFCryptKeyClientHalfAny implements the Crypto interface for
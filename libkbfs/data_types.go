@@ -156,8 +156,26 @@ const (
 	// blocks that have multiple levels of indirection below them
 	// (i.e., indirect blocks that point to other indirect blocks).
 	AtLeastTwoLevelsOfChildrenDataVer DataVer = 3
+	// CompactDirEntriesDataVer is the data version for directory
+	// blocks whose entries use the compact encoding (see
+	// compactDirEntry).
+	CompactDirEntriesDataVer DataVer = 4
+	// BLAKE2bHashDataVer is the data version for blocks whose IDs
+	// are BLAKE2b hashes (kbfshash.BLAKE2b256Hash) rather than
+	// SHA-256 ones.
+	BLAKE2bHashDataVer DataVer = 5
+	// XattrsDataVer is the data version for directory blocks with
+	// at least one child that has extended attributes.  Older
+	// clients would drop the attributes whenever they rewrote such
+	// a child's entry, so they mustn't be able to read the block.
+	XattrsDataVer DataVer = 6
 )
 
+// maxReadableDataVer is the highest data version this client knows
+// how to read.  It can be higher than `Config.DataVersion()`, which
+// only reflects the features this client is configured to write.
+const maxReadableDataVer = XattrsDataVer
+
 // BlockRef is a block ID/ref nonce pair, which defines a unique
 // reference to a block.
 type BlockRef struct {
@@ -485,6 +503,10 @@ const (
 	Dir
 	// Sym is a symbolic link.
 	Sym
+	// FakeDir is a directory that isn't stored in the TLF, whose
+	// Node provides its contents via GetFS.  It's only returned by
+	// Node.ShouldCreateMissedLookup, and shows up as a Dir.
+	FakeDir
 )
 
 // String implements the fmt.Stringer interface for EntryType
@@ -498,6 +520,8 @@ func (et EntryType) String() string {
 		return "DIR"
 	case Sym:
 		return "SYM"
+	case FakeDir:
+		return "FAKEDIR"
 	}
 	return "<invalid EntryType>"
 }
@@ -629,6 +653,8 @@ type NodeMetadata struct {
 	LastWriterUnverified libkb.NormalizedUsername
 	BlockInfo            BlockInfo
 	PrefetchStatus       string
+	// Xattrs holds the extended attributes of the node, by name.
+	Xattrs map[string][]byte
 }
 
 // FavoritesOp defines an operation related to favorites.
@@ -10,6 +10,11 @@ import "github.com/keybase/go-codec/codec"
 type DirEntry struct {
 	BlockInfo
 	EntryInfo
+	// Xattrs holds the extended attributes of the entry, by name.
+	// It's replaced as a whole whenever an attribute changes, so
+	// copies of a DirEntry can safely share it.  It's kept out of
+	// EntryInfo so that EntryInfo stays comparable.
+	Xattrs map[string][]byte `codec:"x,omitempty"`
 
 	codec.UnknownFieldSetHandler
 }
@@ -0,0 +1,162 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfsmd"
+)
+
+// compactDirEntry is the encoding of a DirEntry used by dir blocks
+// of data version CompactDirEntriesDataVer.  Compared to DirEntry, it
+// uses one-letter field names throughout, omits zero-valued fields,
+// and stores the ctime relative to the mtime (they are usually
+// equal).  The creator and key generation are omitted when they match
+// the per-block defaults in compactDirChildren.
+//
+// NOTE: Don't add or modify anything in this struct without
+// considering how old clients will handle them.
+type compactDirEntry struct {
+	ID          kbfsblock.ID          `codec:"i"`
+	KeyGen      kbfsmd.KeyGen         `codec:"k,omitempty"`
+	DataVer     DataVer               `codec:"d,omitempty"`
+	DirectType  BlockDirectType       `codec:"t,omitempty"`
+	Creator     keybase1.UserOrTeamID `codec:"c,omitempty"`
+	Writer      keybase1.UserOrTeamID `codec:"w,omitempty"`
+	RefNonce    kbfsblock.RefNonce    `codec:"r,omitempty"`
+	BlockType   keybase1.BlockType    `codec:"b,omitempty"`
+	EncodedSize uint32                `codec:"e,omitempty"`
+
+	Type       EntryType    `codec:"y,omitempty"`
+	Size       uint64       `codec:"s,omitempty"`
+	SymPath    string       `codec:"p,omitempty"`
+	Mtime      int64        `codec:"m,omitempty"`
+	CtimeDelta int64        `codec:"n,omitempty"` // Ctime - Mtime
+	TeamWriter keybase1.UID `codec:"tw,omitempty"`
+
+	Xattrs map[string][]byte `codec:"x,omitempty"`
+
+	codec.UnknownFieldSetHandler
+}
+
+// compactDirChildren holds the children of a compact dir block,
+// along with the creator and key generation shared by (most of)
+// them.
+type compactDirChildren struct {
+	Creator keybase1.UserOrTeamID      `codec:"c,omitempty"`
+	KeyGen  kbfsmd.KeyGen              `codec:"k,omitempty"`
+	Entries map[string]compactDirEntry `codec:"e,omitempty"`
+
+	codec.UnknownFieldSetHandler
+}
+
+// makeCompactDirChildren converts the given children into their
+// compact form.
+func makeCompactDirChildren(children map[string]DirEntry) *compactDirChildren {
+	// Pick the most common creator and key generation as the
+	// defaults.  Zero values can't be distinguished from "use the
+	// default", so if any entry has one, that field gets no
+	// default.  Ties are broken by value, to keep the encoding
+	// deterministic.
+	creators := make(map[keybase1.UserOrTeamID]int)
+	keyGens := make(map[kbfsmd.KeyGen]int)
+	for _, de := range children {
+		creators[de.Creator]++
+		keyGens[de.KeyGen]++
+	}
+	cc := &compactDirChildren{
+		Entries: make(map[string]compactDirEntry, len(children)),
+	}
+	if creators[""] == 0 {
+		for creator, n := range creators {
+			best := creators[cc.Creator]
+			if n > best || (n == best && creator < cc.Creator) {
+				cc.Creator = creator
+			}
+		}
+	}
+	if keyGens[0] == 0 {
+		for keyGen, n := range keyGens {
+			best := keyGens[cc.KeyGen]
+			if n > best || (n == best && keyGen < cc.KeyGen) {
+				cc.KeyGen = keyGen
+			}
+		}
+	}
+
+	for name, de := range children {
+		cde := compactDirEntry{
+			ID:          de.ID,
+			DataVer:     de.DataVer,
+			DirectType:  de.DirectType,
+			Writer:      de.Writer,
+			RefNonce:    de.RefNonce,
+			BlockType:   de.BlockType,
+			EncodedSize: de.EncodedSize,
+			Type:        de.Type,
+			Size:        de.Size,
+			SymPath:     de.SymPath,
+			Mtime:       de.Mtime,
+			CtimeDelta:  de.Ctime - de.Mtime,
+			TeamWriter:  de.TeamWriter,
+			Xattrs:      de.Xattrs,
+			// No need to deep-copy, since it's immutable.
+			UnknownFieldSetHandler: de.UnknownFieldSetHandler,
+		}
+		if de.Creator != cc.Creator {
+			cde.Creator = de.Creator
+		}
+		if de.KeyGen != cc.KeyGen {
+			cde.KeyGen = de.KeyGen
+		}
+		cc.Entries[name] = cde
+	}
+	return cc
+}
+
+// toDirEntries converts compact children back into regular
+// DirEntries.
+func (cc *compactDirChildren) toDirEntries() map[string]DirEntry {
+	children := make(map[string]DirEntry, len(cc.Entries))
+	for name, cde := range cc.Entries {
+		de := DirEntry{
+			BlockInfo: BlockInfo{
+				BlockPointer: BlockPointer{
+					ID:         cde.ID,
+					KeyGen:     cde.KeyGen,
+					DataVer:    cde.DataVer,
+					DirectType: cde.DirectType,
+					Context: kbfsblock.Context{
+						Creator:   cde.Creator,
+						Writer:    cde.Writer,
+						RefNonce:  cde.RefNonce,
+						BlockType: cde.BlockType,
+					},
+				},
+				EncodedSize: cde.EncodedSize,
+			},
+			EntryInfo: EntryInfo{
+				Type:       cde.Type,
+				Size:       cde.Size,
+				SymPath:    cde.SymPath,
+				Mtime:      cde.Mtime,
+				Ctime:      cde.Mtime + cde.CtimeDelta,
+				TeamWriter: cde.TeamWriter,
+			},
+			Xattrs:                 cde.Xattrs,
+			UnknownFieldSetHandler: cde.UnknownFieldSetHandler,
+		}
+		if de.Creator == "" {
+			de.Creator = cc.Creator
+		}
+		if de.KeyGen == 0 {
+			de.KeyGen = cc.KeyGen
+		}
+		children[name] = de
+	}
+	return children
+}
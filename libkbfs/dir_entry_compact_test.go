@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/stretchr/testify/require"
+)
+
+func makeDirBlockForCompactTest(t *testing.T) *DirBlock {
+	db := NewDirBlock().(*DirBlock)
+	for i := 0; i < 20; i++ {
+		de := makeFakeDirEntry(t, File, uint64(i))
+		de.Ctime = de.Mtime
+		db.Children[fmt.Sprintf("file%d", i)] = de
+	}
+	// An entry with a non-default creator and key generation, and
+	// a ctime that differs from its mtime.
+	de := makeFakeDirEntry(t, Dir, 0)
+	de.Creator = keybase1.MakeTestUID(2).AsUserOrTeam()
+	de.KeyGen++
+	db.Children["dir"] = de
+	return db
+}
+
+func TestCompactDirBlockEncryptDecrypt(t *testing.T) {
+	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
+	key := kbfscrypto.BlockCryptKey{}
+
+	db := makeDirBlockForCompactTest(t)
+	require.Equal(t, FirstValidDataVer, db.DataVersion())
+	plainSize, _, err := c.EncryptBlock(db, key)
+	require.NoError(t, err)
+
+	db.compact = true
+	require.Equal(t, CompactDirEntriesDataVer, db.DataVersion())
+	compactPlainSize, encryptedBlock, err := c.EncryptBlock(db, key)
+	require.NoError(t, err)
+	require.True(t, compactPlainSize < plainSize,
+		"compact=%d, regular=%d", compactPlainSize, plainSize)
+	// Encoding shouldn't have changed the original block.
+	require.Nil(t, db.CompactChildren)
+
+	decryptedBlock := NewDirBlock().(*DirBlock)
+	err = c.DecryptBlock(encryptedBlock, key, decryptedBlock)
+	require.NoError(t, err)
+	require.Equal(t, db.Children, decryptedBlock.Children)
+	require.Nil(t, decryptedBlock.CompactChildren)
+	require.Equal(t, CompactDirEntriesDataVer, decryptedBlock.DataVersion())
+	require.Equal(t, CompactDirEntriesDataVer,
+		decryptedBlock.DeepCopy().DataVersion())
+}
+
+func TestCompactDirBlockXattrs(t *testing.T) {
+	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
+	key := kbfscrypto.BlockCryptKey{}
+
+	db := makeDirBlockForCompactTest(t)
+	db.compact = true
+	de := db.Children["file0"]
+	de.Xattrs = map[string][]byte{
+		"user.tag":         []byte("red"),
+		"security.selinux": []byte("ctx"),
+	}
+	db.Children["file0"] = de
+	// Extended attributes need a newer data version than the
+	// compact encoding alone.
+	require.Equal(t, XattrsDataVer, db.DataVersion())
+
+	_, encryptedBlock, err := c.EncryptBlock(db, key)
+	require.NoError(t, err)
+	decryptedBlock := NewDirBlock().(*DirBlock)
+	err = c.DecryptBlock(encryptedBlock, key, decryptedBlock)
+	require.NoError(t, err)
+	require.Equal(t, db.Children, decryptedBlock.Children)
+	require.Equal(t, XattrsDataVer, decryptedBlock.DataVersion())
+}
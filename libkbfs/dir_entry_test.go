@@ -35,6 +35,7 @@ func makeFakeDirEntry(t *testing.T, typ EntryType, size uint64) DirEntry {
 			102,
 			"",
 		},
+		nil,
 		codec.UnknownFieldSetHandler{},
 	}
 }
@@ -68,6 +68,10 @@ type DiskBlockCacheLocal struct {
 	evictSizeMeter   *CountMeter
 	deleteCountMeter *CountMeter
 	deleteSizeMeter  *CountMeter
+	// Track how many blocks the scrubber has verified, and how many
+	// of those failed verification and were removed.
+	scrubCountMeter   *CountMeter
+	corruptCountMeter *CountMeter
 	// Protect the disk caches from being shutdown while they're being
 	// accessed.
 	lock    sync.RWMutex
@@ -116,6 +120,8 @@ type DiskBlockCacheStatus struct {
 	SizeEvicted     MeterStatus
 	NumDeleted      MeterStatus
 	SizeDeleted     MeterStatus
+	NumScrubbed     MeterStatus
+	NumCorrupted    MeterStatus
 }
 
 // newDiskBlockCacheStandardFromStorage creates a new *DiskBlockCacheStandard
@@ -169,27 +175,29 @@ func newDiskBlockCacheStandardFromStorage(
 	startedCh := make(chan struct{})
 	startErrCh := make(chan struct{})
 	cache = &DiskBlockCacheLocal{
-		config:           config,
-		maxBlockID:       maxBlockID.Bytes(),
-		cacheType:        cacheType,
-		tlfCounts:        map[tlf.ID]int{},
-		tlfSizes:         map[tlf.ID]uint64{},
-		hitMeter:         NewCountMeter(),
-		missMeter:        NewCountMeter(),
-		putMeter:         NewCountMeter(),
-		updateMeter:      NewCountMeter(),
-		evictCountMeter:  NewCountMeter(),
-		evictSizeMeter:   NewCountMeter(),
-		deleteCountMeter: NewCountMeter(),
-		deleteSizeMeter:  NewCountMeter(),
-		log:              log,
-		blockDb:          blockDb,
-		metaDb:           metaDb,
-		tlfDb:            tlfDb,
-		startedCh:        startedCh,
-		startErrCh:       startErrCh,
-		shutdownCh:       make(chan struct{}),
-		closer:           closer,
+		config:            config,
+		maxBlockID:        maxBlockID.Bytes(),
+		cacheType:         cacheType,
+		tlfCounts:         map[tlf.ID]int{},
+		tlfSizes:          map[tlf.ID]uint64{},
+		hitMeter:          NewCountMeter(),
+		missMeter:         NewCountMeter(),
+		putMeter:          NewCountMeter(),
+		updateMeter:       NewCountMeter(),
+		evictCountMeter:   NewCountMeter(),
+		evictSizeMeter:    NewCountMeter(),
+		deleteCountMeter:  NewCountMeter(),
+		deleteSizeMeter:   NewCountMeter(),
+		scrubCountMeter:   NewCountMeter(),
+		corruptCountMeter: NewCountMeter(),
+		log:               log,
+		blockDb:           blockDb,
+		metaDb:            metaDb,
+		tlfDb:             tlfDb,
+		startedCh:         startedCh,
+		startErrCh:        startErrCh,
+		shutdownCh:        make(chan struct{}),
+		closer:            closer,
 	}
 	// Sync the block counts asynchronously so syncing doesn't block init.
 	// Since this method blocks, any Get or Put requests to the disk block
@@ -213,6 +221,9 @@ func newDiskBlockCacheStandardFromStorage(
 				cache.cacheType, int64(cache.currBytes))
 		}
 		close(startedCh)
+		if !cache.config.IsTestMode() {
+			go cache.scrubLoop()
+		}
 	}()
 	return cache, nil
 }
@@ -954,6 +965,8 @@ func (cache *DiskBlockCacheLocal) Status(
 			SizeEvicted:     rateMeterToStatus(cache.evictSizeMeter),
 			NumDeleted:      rateMeterToStatus(cache.deleteCountMeter),
 			SizeDeleted:     rateMeterToStatus(cache.deleteSizeMeter),
+			NumScrubbed:     rateMeterToStatus(cache.scrubCountMeter),
+			NumCorrupted:    rateMeterToStatus(cache.corruptCountMeter),
 		},
 	}
 }
@@ -992,4 +1005,6 @@ func (cache *DiskBlockCacheLocal) Shutdown(ctx context.Context) {
 	cache.evictSizeMeter.Shutdown()
 	cache.deleteCountMeter.Shutdown()
 	cache.deleteSizeMeter.Shutdown()
+	cache.scrubCountMeter.Shutdown()
+	cache.corruptCountMeter.Shutdown()
 }
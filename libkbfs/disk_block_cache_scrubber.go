@@ -0,0 +1,156 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"golang.org/x/net/context"
+)
+
+const (
+	// diskCacheScrubPeriod is how long the scrubber waits between
+	// passes over a disk cache.  The first pass starts one period
+	// after the cache does, so it doesn't compete with startup.
+	diskCacheScrubPeriod = 24 * time.Hour
+	// diskCacheScrubBatchSize is how many blocks are verified under a
+	// single hold of the cache's read lock.
+	diskCacheScrubBatchSize = 64
+	// diskCacheScrubBatchPause is how long the scrubber sleeps between
+	// batches, to keep its disk and CPU use low.
+	diskCacheScrubBatchPause = 500 * time.Millisecond
+)
+
+type ctxScrubberTagKey int
+
+const (
+	ctxScrubberIDKey ctxScrubberTagKey = iota
+)
+
+const ctxScrubberID = "DCSID"
+
+// scrubBatch verifies the hashes of up to `maxBlocks` blocks in the
+// cache, starting with the block whose key is `startKey` (or the
+// first one, if `startKey` is nil), and deletes any block that fails
+// verification.  Deleted blocks are refetched from the server the
+// next time they're needed.  It returns the key to start the next
+// batch at, or nil if there are no more blocks.
+func (cache *DiskBlockCacheLocal) scrubBatch(ctx context.Context,
+	startKey []byte, maxBlocks int) (nextKey []byte, err error) {
+	var corrupted []kbfsblock.ID
+	checked := 0
+	func() {
+		cache.lock.RLock()
+		defer cache.lock.RUnlock()
+		err = cache.checkCacheLocked("scrubBatch")
+		if err != nil {
+			return
+		}
+
+		// Don't push the blocks the user is actually reading out of
+		// leveldb's own cache.
+		iter := cache.blockDb.NewIterator(
+			&util.Range{Start: startKey}, &opt.ReadOptions{DontFillCache: true})
+		defer iter.Release()
+		for checked < maxBlocks && iter.Next() {
+			checked++
+			// The iterator reuses its key buffer.
+			key := append([]byte(nil), iter.Key()...)
+			nextKey = append(key, 0)
+			blockID, err := kbfsblock.IDFromBytes(key)
+			if err != nil {
+				cache.log.CWarningf(ctx, "Couldn't decode block ID %x "+
+					"while scrubbing: %+v", key, err)
+				continue
+			}
+			buf, _, err := cache.decodeBlockCacheEntry(iter.Value())
+			if err == nil {
+				err = kbfsblock.VerifyID(buf, blockID)
+			}
+			if err != nil {
+				cache.log.CWarningf(ctx, "Block %s is corrupted: %+v",
+					blockID, err)
+				corrupted = append(corrupted, blockID)
+			}
+		}
+		if checked < maxBlocks {
+			nextKey = nil
+		}
+		err = iter.Error()
+	}()
+	if err != nil {
+		return nil, err
+	}
+	cache.scrubCountMeter.Mark(int64(checked))
+	if len(corrupted) == 0 {
+		return nextKey, nil
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	err = cache.checkCacheLocked("scrubBatch")
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = cache.deleteLocked(ctx, corrupted)
+	if err != nil {
+		return nil, err
+	}
+	cache.corruptCountMeter.Mark(int64(len(corrupted)))
+	return nextKey, nil
+}
+
+// scrubOnce makes one full pass over the cache, pausing for
+// `batchPause` between batches.  It returns early if the cache is
+// shut down.
+func (cache *DiskBlockCacheLocal) scrubOnce(
+	ctx context.Context, batchPause time.Duration) error {
+	startCorrupted := cache.corruptCountMeter.Count()
+	cache.log.CDebugf(ctx, "Starting a scrub pass")
+	var startKey []byte
+	for {
+		nextKey, err := cache.scrubBatch(
+			ctx, startKey, diskCacheScrubBatchSize)
+		if err != nil {
+			return err
+		}
+		if nextKey == nil {
+			break
+		}
+		startKey = nextKey
+		select {
+		case <-time.After(batchPause):
+		case <-cache.shutdownCh:
+			return nil
+		}
+	}
+	cache.log.CDebugf(ctx, "Finished a scrub pass; removed %d corrupted "+
+		"blocks", cache.corruptCountMeter.Count()-startCorrupted)
+	return nil
+}
+
+// scrubLoop scrubs the cache every `diskCacheScrubPeriod`, until the
+// cache is shut down.
+func (cache *DiskBlockCacheLocal) scrubLoop() {
+	ctx := CtxWithRandomIDReplayable(context.Background(),
+		ctxScrubberIDKey, ctxScrubberID, cache.log)
+	timer := time.NewTimer(diskCacheScrubPeriod)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-cache.shutdownCh:
+			return
+		}
+		err := cache.scrubOnce(ctx, diskCacheScrubBatchPause)
+		if err != nil {
+			cache.log.CDebugf(ctx, "Scrub pass stopped: %+v", err)
+		}
+		timer.Reset(diskCacheScrubPeriod)
+	}
+}
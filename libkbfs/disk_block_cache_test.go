@@ -237,6 +237,69 @@ func TestDiskBlockCacheDelete(t *testing.T) {
 	require.EqualError(t, err, errors.ErrNotFound.Error())
 }
 
+func TestDiskBlockCacheScrub(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that scrubbing removes exactly the corrupted blocks.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+	standardCache := cache.workingSetCache
+	ctx := context.Background()
+
+	t.Log("Put some blocks with valid IDs into the cache.")
+	tlf1 := tlf.FakeID(0, tlf.Private)
+	var ids []kbfsblock.ID
+	for i := 0; i < 5; i++ {
+		_, _, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		id, err := kbfsblock.MakePermanentID(blockEncoded)
+		require.NoError(t, err)
+		err = standardCache.Put(ctx, tlf1, id, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	t.Log("Flip a bit in two of the blocks.")
+	corrupted := map[kbfsblock.ID]bool{ids[1]: true, ids[3]: true}
+	for id := range corrupted {
+		buf, serverHalf, _, err := standardCache.Get(ctx, tlf1, id)
+		require.NoError(t, err)
+		buf[0] ^= 1
+		entry, err := standardCache.encodeBlockCacheEntry(buf, serverHalf)
+		require.NoError(t, err)
+		err = standardCache.blockDb.Put(id.Bytes(), entry, nil)
+		require.NoError(t, err)
+	}
+
+	t.Log("Scrub the cache a couple of blocks at a time.")
+	var startKey []byte
+	for {
+		nextKey, err := standardCache.scrubBatch(ctx, startKey, 2)
+		require.NoError(t, err)
+		if nextKey == nil {
+			break
+		}
+		startKey = nextKey
+	}
+	require.Equal(t, int64(len(ids)), standardCache.scrubCountMeter.Count())
+	require.Equal(t, int64(2), standardCache.corruptCountMeter.Count())
+	require.Equal(t, len(ids)-2, standardCache.numBlocks)
+
+	t.Log("Verify that only the uncorrupted blocks are left.")
+	for _, id := range ids {
+		_, _, _, err := standardCache.Get(ctx, tlf1, id)
+		if corrupted[id] {
+			require.EqualError(t, err, NoSuchBlockError{id}.Error())
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	t.Log("Another pass finds nothing new.")
+	err := standardCache.scrubOnce(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*len(ids)-2), standardCache.scrubCountMeter.Count())
+	require.Equal(t, int64(2), standardCache.corruptCountMeter.Count())
+}
+
 func TestDiskBlockCacheEvictFromTLF(t *testing.T) {
 	t.Parallel()
 	t.Log("Test that disk cache eviction works for a single TLF.")
@@ -53,6 +53,33 @@ func (e NoSuchNameError) Error() string {
 	return fmt.Sprintf("%s doesn't exist", e.Name)
 }
 
+// NoSuchXattrError indicates that the user tried to access an
+// extended attribute that a directory entry doesn't have.
+type NoSuchXattrError struct {
+	Name string
+}
+
+// Error implements the error interface for NoSuchXattrError
+func (e NoSuchXattrError) Error() string {
+	return fmt.Sprintf("Extended attribute %s doesn't exist", e.Name)
+}
+
+// XattrTooBigError indicates that the user tried to set an extended
+// attribute that would make the extended attributes of an entry
+// bigger than KBFS's supported size.
+type XattrTooBigError struct {
+	Name            string
+	Size            int
+	MaxAllowedBytes int
+}
+
+// Error implements the error interface for XattrTooBigError
+func (e XattrTooBigError) Error() string {
+	return fmt.Sprintf("Setting extended attribute %s would make the "+
+		"extended attributes %d bytes, more than the maximum allowed "+
+		"number of bytes (%d)", e.Name, e.Size, e.MaxAllowedBytes)
+}
+
 // NoSuchUserError indicates that the given user couldn't be resolved.
 type NoSuchUserError struct {
 	Input string
@@ -1191,3 +1218,19 @@ func (e MDWrittenAfterRevokeError) Error() string {
 		"last valid revision would have been %d",
 		e.revBad, e.tlfID, e.verifyingKey, e.revLimit)
 }
+
+// MDRollbackError indicates that the mdserver returned a head
+// revision for a TLF that is older than a revision the global Keybase
+// merkle tree has already been verified to contain.
+type MDRollbackError struct {
+	tlfID     tlf.ID
+	headRev   kbfsmd.Revision
+	merkleRev kbfsmd.Revision
+}
+
+// Error implements the Error interface for MDRollbackError.
+func (e MDRollbackError) Error() string {
+	return fmt.Sprintf("The server returned revision %d as the head of "+
+		"folder %s, but the merkle tree contains revision %d",
+		e.headRev, e.tlfID, e.merkleRev)
+}
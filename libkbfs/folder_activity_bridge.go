@@ -0,0 +1,476 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsedits"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"golang.org/x/net/context"
+)
+
+const (
+	folderActivityBridgeConfigFolderName = "folder_activity_bridge_config"
+	// Post at most one summary per folder per period, so a busy
+	// folder doesn't flood its channel.
+	folderActivityBridgePeriodDefault = 15 * time.Minute
+	// How many file names to list per writer and edit type, before
+	// just counting the rest.
+	folderActivityMaxFilesPerLine = 5
+	// How long to remember the activity summaries, for Activity.
+	folderActivityRetention = 7 * 24 * time.Hour
+)
+
+// CtxFABTagKey is the type used for unique context tags within
+// FolderActivityBridge.
+type CtxFABTagKey int
+
+const (
+	// CtxFABIDKey is the type of the tag for unique operation IDs
+	// within FolderActivityBridge.
+	CtxFABIDKey CtxFABTagKey = iota
+)
+
+// CtxFABOpID is the display name for the unique operation
+// FolderActivityBridge ID tag.
+const CtxFABOpID = "FABID"
+
+// BridgedFolder describes a team folder whose activity is posted to
+// a chat channel.
+type BridgedFolder struct {
+	ID      tlf.ID            `json:"-"`
+	Name    tlf.CanonicalName `json:"name"`
+	Channel string            `json:"channel"`
+}
+
+type bridgedFolderState struct {
+	BridgedFolder
+	// since is the start of the range of edits not posted yet.
+	since time.Time
+	// lastRev is the head revision when the folder was last checked.
+	lastRev kbfsmd.Revision
+}
+
+// FolderActivityBridge periodically posts a summary of the recent
+// edits in opted-in team folders to a channel of the team's chat.
+// Each writer is mentioned at most once per aggregation window (one
+// period long), so a writer making a long burst of edits only shows
+// up once; all the edits can still be queried via Activity.
+type FolderActivityBridge struct {
+	config     Config
+	log        logger.Logger
+	dbPath     string
+	period     time.Duration
+	aggregator *kbfsedits.Aggregator
+
+	lock    sync.Mutex
+	folders map[tlf.ID]*bridgedFolderState
+
+	shutdownOnce sync.Once
+	shutdownCh   chan struct{}
+	doneCh       chan struct{}
+}
+
+// NewFolderActivityBridge returns a new bridge that checks for new
+// activity every `period`.  The opted-in folders are persisted in a
+// database at `dbPath`, unless it is empty.  Call Start to begin
+// posting.
+func NewFolderActivityBridge(
+	config Config, dbPath string, period time.Duration) (
+	*FolderActivityBridge, error) {
+	b := &FolderActivityBridge{
+		config:     config,
+		log:        config.MakeLogger("FAB"),
+		dbPath:     dbPath,
+		period:     period,
+		folders:    make(map[tlf.ID]*bridgedFolderState),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	b.aggregator = kbfsedits.NewAggregator(
+		kbfsedits.AggregationThresholds{Window: period, MinEdits: 1})
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *FolderActivityBridge) openDB() (*levelDb, error) {
+	stor, err := storage.OpenFile(b.dbPath, false)
+	if err != nil {
+		return nil, err
+	}
+	return openLevelDB(stor)
+}
+
+func (b *FolderActivityBridge) load() error {
+	if b.dbPath == "" {
+		return nil
+	}
+	ldb, err := b.openDB()
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	iter := ldb.NewIterator(nil, nil)
+	defer iter.Release()
+
+	// Only post edits made after startup; anything earlier was
+	// either posted already, or happened while KBFS wasn't running.
+	now := b.config.Clock().Now()
+	for iter.Next() {
+		var tlfID tlf.ID
+		err := tlfID.UnmarshalText(iter.Key())
+		if err != nil {
+			b.log.Debug("Skipping bridged folder with bad ID %q: %+v",
+				iter.Key(), err)
+			continue
+		}
+		var f BridgedFolder
+		err = json.Unmarshal(iter.Value(), &f)
+		if err != nil {
+			b.log.Debug("Skipping bridged folder %s: %+v", tlfID, err)
+			continue
+		}
+		f.ID = tlfID
+		b.folders[tlfID] = &bridgedFolderState{BridgedFolder: f, since: now}
+	}
+	return iter.Error()
+}
+
+// Start begins checking the opted-in folders for activity.
+func (b *FolderActivityBridge) Start() {
+	go b.loop()
+}
+
+func (b *FolderActivityBridge) loop() {
+	defer close(b.doneCh)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-b.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(b.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.checkAll(CtxWithRandomIDReplayable(
+				ctx, CtxFABIDKey, CtxFABOpID, b.log))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Shutdown stops the bridge, waiting for any check in progress.  It
+// must only be called after Start.
+func (b *FolderActivityBridge) Shutdown() {
+	b.shutdownOnce.Do(func() {
+		close(b.shutdownCh)
+		<-b.doneCh
+	})
+}
+
+// Enable starts posting the activity of the given team folder to
+// `channel` in the team's chat, or to the default channel if
+// `channel` is empty.
+func (b *FolderActivityBridge) Enable(
+	ctx context.Context, handle *TlfHandle, channel string) error {
+	if handle.Type() != tlf.SingleTeam {
+		return errors.Errorf(
+			"Only team folders can be bridged to chat, not %s",
+			handle.GetCanonicalPath())
+	}
+	rootNode, _, err := b.config.KBFSOps().GetOrCreateRootNode(
+		ctx, handle, MasterBranch)
+	if err != nil {
+		return err
+	}
+	f := BridgedFolder{
+		ID:      rootNode.GetFolderBranch().Tlf,
+		Name:    handle.GetCanonicalName(),
+		Channel: channel,
+	}
+
+	if b.dbPath != "" {
+		buf, err := json.Marshal(f)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		ldb, err := b.openDB()
+		if err != nil {
+			return err
+		}
+		defer ldb.Close()
+		err = ldb.Put([]byte(f.ID.String()), buf, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if state, ok := b.folders[f.ID]; ok {
+		// Just switch channels, without losing any unposted edits.
+		state.BridgedFolder = f
+		return nil
+	}
+	b.folders[f.ID] = &bridgedFolderState{
+		BridgedFolder: f,
+		since:         b.config.Clock().Now(),
+	}
+	return nil
+}
+
+// Disable stops posting the activity of the given folder.
+func (b *FolderActivityBridge) Disable(tlfID tlf.ID) error {
+	if b.dbPath != "" {
+		ldb, err := b.openDB()
+		if err != nil {
+			return err
+		}
+		defer ldb.Close()
+		err = ldb.Delete([]byte(tlfID.String()), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.folders, tlfID)
+	return nil
+}
+
+// Folders returns the folders being bridged, sorted by name.
+func (b *FolderActivityBridge) Folders() []BridgedFolder {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	folders := make([]BridgedFolder, 0, len(b.folders))
+	for _, state := range b.folders {
+		folders = append(folders, state.BridgedFolder)
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Name < folders[j].Name
+	})
+	return folders
+}
+
+func (b *FolderActivityBridge) checkAll(ctx context.Context) {
+	for _, f := range b.Folders() {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		err := b.check(ctx, f.ID)
+		if err != nil {
+			b.log.CDebugf(ctx, "Couldn't post the activity of %s: %+v",
+				f.Name, err)
+		}
+	}
+}
+
+// check posts a summary of the edits made to the folder since the
+// last successful check, if there were any.
+func (b *FolderActivityBridge) check(ctx context.Context, tlfID tlf.ID) error {
+	b.lock.Lock()
+	state, ok := b.folders[tlfID]
+	if !ok {
+		b.lock.Unlock()
+		return nil
+	}
+	f, since, lastRev := state.BridgedFolder, state.since, state.lastRev
+	b.lock.Unlock()
+
+	// Skip folders that haven't changed, so quiet folders don't cost
+	// any MD fetches.
+	fb := FolderBranch{Tlf: tlfID, Branch: MasterBranch}
+	status, _, err := b.config.KBFSOps().FolderStatus(ctx, fb)
+	if err != nil {
+		return err
+	}
+	if status.Revision != kbfsmd.RevisionUninitialized &&
+		status.Revision == lastRev {
+		return nil
+	}
+
+	end := b.config.Clock().Now()
+	if end.Before(since) {
+		// Checked already, with the clock not having moved since.
+		return nil
+	}
+	edits, err := b.config.KBFSOps().GetEditHistoryRange(ctx, fb, since, end)
+	if err != nil {
+		return err
+	}
+	due := b.aggregator.AddNotifications(
+		tlfEditsToNotifications(tlfID, edits))
+	b.aggregator.Prune(end.Add(-folderActivityRetention))
+	records, err := MakeTlfEditRecords(
+		ctx, b.config.KBPKI(), dueTlfEdits(edits, due))
+	if err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		convID, err := b.config.Chat().GetConversationID(
+			ctx, f.Name, tlf.SingleTeam, f.Channel, chat1.TopicType_CHAT)
+		if err != nil {
+			return err
+		}
+		err = b.config.Chat().SendTextMessage(
+			ctx, f.Name, tlf.SingleTeam, convID,
+			summarizeFolderActivity(f.Name, records))
+		if err != nil {
+			return err
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if state, ok := b.folders[tlfID]; ok {
+		// The range is inclusive, so start just after it next time.
+		state.since = end.Add(time.Nanosecond)
+		state.lastRev = status.Revision
+	}
+	return nil
+}
+
+// Activity returns the summaries of the edits made to the given
+// folder since `since`, grouped by writer and aggregation window,
+// including the edits that weren't posted because their writer had
+// already been mentioned during that window.
+func (b *FolderActivityBridge) Activity(
+	tlfID tlf.ID, since time.Time) []kbfsedits.WriterActivity {
+	return b.aggregator.Activity(tlfID, since)
+}
+
+var tlfEditNotificationOpTypes = map[TlfEditNotificationType]kbfsedits.NotificationOpType{
+	FileCreated:  kbfsedits.NotificationCreate,
+	FileModified: kbfsedits.NotificationModify,
+	FileDeleted:  kbfsedits.NotificationDelete,
+}
+
+// tlfEditsToNotifications converts `edits` into the edit
+// notifications understood by kbfsedits.Aggregator.
+func tlfEditsToNotifications(
+	tlfID tlf.ID, edits TlfWriterEdits) []kbfsedits.NotificationMessage {
+	var notifications []kbfsedits.NotificationMessage
+	for writer, list := range edits {
+		for _, edit := range list {
+			notifications = append(notifications,
+				kbfsedits.NotificationMessage{
+					Filename: edit.Filepath,
+					Type:     tlfEditNotificationOpTypes[edit.Type],
+					Time:     edit.LocalTime,
+					Revision: edit.Revision,
+					UID:      writer,
+					FolderID: tlfID,
+				})
+		}
+	}
+	return notifications
+}
+
+// dueTlfEdits returns the subset of `edits` that fall into the
+// windows in `due`.
+func dueTlfEdits(
+	edits TlfWriterEdits, due []kbfsedits.WriterActivity) TlfWriterEdits {
+	windows := make(map[keybase1.UID][]kbfsedits.WriterActivity)
+	for _, wa := range due {
+		windows[wa.UID] = append(windows[wa.UID], wa)
+	}
+	dueEdits := make(TlfWriterEdits)
+	for writer, list := range edits {
+		for _, edit := range list {
+			for _, wa := range windows[writer] {
+				if !edit.LocalTime.Before(wa.Start) &&
+					!edit.LocalTime.After(wa.Last) {
+					dueEdits[writer] = append(dueEdits[writer], edit)
+					break
+				}
+			}
+		}
+	}
+	return dueEdits
+}
+
+// summarizeFolderActivity returns a chat message summarizing
+// `records`, with one line per writer and type of edit.
+func summarizeFolderActivity(
+	name tlf.CanonicalName, records []TlfEditRecord) string {
+	type key struct {
+		writer, typ string
+	}
+	var keys []key
+	paths := make(map[key][]string)
+	seen := make(map[key]map[string]bool)
+	for _, r := range records {
+		k := key{r.Writer, r.Type}
+		if seen[k] == nil {
+			keys = append(keys, k)
+			seen[k] = make(map[string]bool)
+		}
+		// The header already names the folder.
+		p := strings.TrimPrefix(r.Path, string(name)+"/")
+		if seen[k][p] {
+			continue
+		}
+		seen[k][p] = true
+		paths[k] = append(paths[k], p)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].writer < keys[j].writer
+	})
+
+	lines := []string{fmt.Sprintf("Recent activity in %s:",
+		buildCanonicalPathForTlfType(tlf.SingleTeam, string(name)))}
+	for _, k := range keys {
+		p := paths[k]
+		noun := "files"
+		if len(p) == 1 {
+			noun = "file"
+		}
+		shown := p
+		if len(shown) > folderActivityMaxFilesPerLine {
+			shown = shown[:folderActivityMaxFilesPerLine]
+		}
+		line := fmt.Sprintf("%s %s %d %s: %s", k.writer, k.typ, len(p),
+			noun, strings.Join(shown, ", "))
+		if len(p) > len(shown) {
+			line += fmt.Sprintf(" and %d more", len(p)-len(shown))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GetFolderActivityBridge returns the bridge started for the given
+// config, if there is one.
+func GetFolderActivityBridge(config Config) (*FolderActivityBridge, error) {
+	c, ok := config.(*ConfigLocal)
+	if !ok || c.activityBridge == nil {
+		return nil, errors.New("Folder activity bridge not enabled")
+	}
+	return c.activityBridge, nil
+}
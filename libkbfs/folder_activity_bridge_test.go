@@ -0,0 +1,185 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestSummarizeFolderActivity(t *testing.T) {
+	records := []TlfEditRecord{
+		{Writer: "bob", Type: "deleted", Path: "old"},
+		{Writer: "alice", Type: "modified", Path: "a"},
+		{Writer: "alice", Type: "modified", Path: "a"},
+		{Writer: "alice", Type: "created", Path: "acme/b"},
+	}
+	for i := 0; i < folderActivityMaxFilesPerLine+2; i++ {
+		records = append(records, TlfEditRecord{
+			Writer: "bob", Type: "created", Path: fmt.Sprintf("f%d", i)})
+	}
+	require.Equal(t,
+		"Recent activity in /keybase/team/acme:\n"+
+			"alice modified 1 file: a\n"+
+			"alice created 1 file: b\n"+
+			"bob deleted 1 file: old\n"+
+			"bob created 7 files: f0, f1, f2, f3, f4 and 2 more",
+		summarizeFolderActivity("acme", records))
+}
+
+type testActivityChat struct {
+	*ChatLocal
+
+	lock     sync.Mutex
+	channels []string
+	messages []string
+}
+
+// GetConversationID implements the Chat interface for
+// testActivityChat.  The test-mode edit notifications sent by
+// folderBranchOps are left to ChatLocal.
+func (c *testActivityChat) GetConversationID(
+	ctx context.Context, tlfName tlf.CanonicalName, tlfType tlf.Type,
+	channelName string, chatType chat1.TopicType) (
+	chat1.ConversationID, error) {
+	if chatType != chat1.TopicType_CHAT {
+		return c.ChatLocal.GetConversationID(
+			ctx, tlfName, tlfType, channelName, chatType)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.channels = append(c.channels, channelName)
+	return chat1.ConversationID("chat:" + channelName), nil
+}
+
+// SendTextMessage implements the Chat interface for testActivityChat.
+func (c *testActivityChat) SendTextMessage(
+	ctx context.Context, tlfName tlf.CanonicalName, tlfType tlf.Type,
+	convID chat1.ConversationID, body string) error {
+	if !strings.HasPrefix(string(convID), "chat:") {
+		return c.ChatLocal.SendTextMessage(
+			ctx, tlfName, tlfType, convID, body)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.messages = append(c.messages, body)
+	return nil
+}
+
+func (c *testActivityChat) getMessages() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string(nil), c.messages...)
+}
+
+func TestFolderActivityBridge(t *testing.T) {
+	var u1 libkb.NormalizedUsername = "u1"
+	config, uid, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsConcurTestShutdown(t, config, ctx, cancel)
+	clock, _ := newTestClockAndTimeNow()
+	config.SetClock(clock)
+	chat := &testActivityChat{ChatLocal: NewChatLocal(config)}
+	config.SetChat(chat)
+
+	teamInfos := AddEmptyTeamsForTestOrBust(t, config, "t1")
+	AddTeamWriterForTestOrBust(t, config, teamInfos[0].TID, uid)
+
+	b, err := NewFolderActivityBridge(config, "", time.Hour)
+	require.NoError(t, err)
+
+	t.Log("Only team folders can be bridged")
+	privateHandle, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), string(u1), tlf.Private)
+	require.NoError(t, err)
+	err = b.Enable(ctx, privateHandle, "")
+	require.Error(t, err)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+	err = b.Enable(ctx, h, "kbfs")
+	require.NoError(t, err)
+	folders := b.Folders()
+	require.Len(t, folders, 1)
+	require.Equal(t, tlf.CanonicalName("t1"), folders[0].Name)
+	require.Equal(t, "kbfs", folders[0].Channel)
+	tlfID := folders[0].ID
+
+	t.Log("Nothing is posted before anything is written")
+	err = b.check(ctx, tlfID)
+	require.NoError(t, err)
+	require.Len(t, chat.getMessages(), 0)
+
+	clock.Add(time.Minute)
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	err = b.check(ctx, tlfID)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"Recent activity in /keybase/team/t1:\nu1 created 1 file: a",
+	}, chat.getMessages())
+	require.Equal(t, []string{"kbfs"}, chat.channels)
+
+	t.Log("The same edits aren't posted twice")
+	clock.Add(time.Minute)
+	err = b.check(ctx, tlfID)
+	require.NoError(t, err)
+	require.Len(t, chat.getMessages(), 1)
+
+	t.Log("A writer is only mentioned once per window")
+	clock.Add(time.Minute)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "c", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	err = b.check(ctx, tlfID)
+	require.NoError(t, err)
+	require.Len(t, chat.getMessages(), 1)
+	activity := b.Activity(tlfID, time.Time{})
+	require.Len(t, activity, 1)
+	require.Equal(t, uid, activity[0].UID)
+	require.Equal(t, 2, activity[0].Count)
+
+	t.Log("Edits in a later window are posted")
+	clock.Add(time.Hour)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "d", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	err = b.check(ctx, tlfID)
+	require.NoError(t, err)
+	require.Equal(t,
+		"Recent activity in /keybase/team/t1:\nu1 created 1 file: d",
+		chat.getMessages()[1])
+	require.Len(t, b.Activity(tlfID, time.Time{}), 2)
+	require.Len(t, b.Activity(tlfID, clock.Now()), 1)
+
+	t.Log("Disabled folders aren't checked")
+	err = b.Disable(tlfID)
+	require.NoError(t, err)
+	require.Len(t, b.Folders(), 0)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	err = b.check(ctx, tlfID)
+	require.NoError(t, err)
+	require.Len(t, chat.getMessages(), 2)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfshash"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
@@ -326,7 +327,7 @@ func (fbo *folderBlockOps) getCleanEncodedBlockSizeLocked(ctx context.Context,
 		return block.GetEncodedSize(), nil
 	}
 
-	if err := checkDataVersion(fbo.config, path{}, ptr); err != nil {
+	if err := checkDataVersion(path{}, ptr); err != nil {
 		return 0, err
 	}
 
@@ -396,7 +397,7 @@ func (fbo *folderBlockOps) getBlockHelperLocked(ctx context.Context,
 		return block, nil
 	}
 
-	if err := checkDataVersion(fbo.config, notifyPath, ptr); err != nil {
+	if err := checkDataVersion(notifyPath, ptr); err != nil {
 		return nil, err
 	}
 
@@ -1116,6 +1117,8 @@ func (fbo *folderBlockOps) setCachedAttrLocked(
 		fileEntry.dirEntry.Type = realEntry.Type
 	case mtimeAttr:
 		fileEntry.dirEntry.Mtime = realEntry.Mtime
+	case xattrAttr:
+		fileEntry.dirEntry.Xattrs = realEntry.Xattrs
 	}
 	fileEntry.dirEntry.Ctime = realEntry.Ctime
 	fbo.deCache[ref] = fileEntry
@@ -2447,10 +2450,15 @@ func ReadyBlock(ctx context.Context, bcache BlockCache, bops BlockOps,
 		// In case we're deduping an old pointer with an unknown block type.
 		ptr.DirectType = directType
 	} else {
+		dataVer := block.DataVersion()
+		if bid.HashType() != kbfshash.DefaultHashType &&
+			dataVer < BLAKE2bHashDataVer {
+			dataVer = BLAKE2bHashDataVer
+		}
 		ptr = BlockPointer{
 			ID:         bid,
 			KeyGen:     kmd.LatestKeyGeneration(),
-			DataVer:    block.DataVersion(),
+			DataVer:    dataVer,
 			DirectType: directType,
 			Context:    kbfsblock.MakeFirstContext(chargedTo, bType),
 		}
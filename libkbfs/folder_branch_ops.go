@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"strings"
@@ -78,6 +79,8 @@ const (
 	MaxBlockSizeBytesDefault = 512 << 10
 	// Maximum number of blocks that can be sent in parallel
 	maxParallelBlockPuts = 100
+	// Maximum number of block batches that can be sent in parallel
+	maxParallelBlockBatchPuts = 4
 	// Maximum number of blocks that can be fetched in parallel
 	maxParallelBlockGets = 10
 	// Max response size for a single DynamoDB query is 1MB.
@@ -94,6 +97,10 @@ const (
 	// If there are more than this many new revisions, fast forward
 	// rather than downloading them all.
 	fastForwardRevThresh = 50
+	// Maximum total size of the names and values of all the
+	// extended attributes of one entry, which are stored in its
+	// parent directory's block.  Same as ext4 with 4K blocks.
+	maxXattrBytes = 4096
 )
 
 type fboMutexLevel mutexLevel
@@ -1388,6 +1395,7 @@ func (fbo *folderBranchOps) maybeUnembedAndPutBlocks(ctx context.Context,
 func ResetRootBlock(ctx context.Context, config Config,
 	rmd *RootMetadata) (Block, BlockInfo, ReadyBlockData, error) {
 	newDblock := NewDirBlock()
+	newDblock.(*DirBlock).compact = config.CompactDirEntries()
 	chargedTo, err := chargedToForTLF(
 		ctx, config.KBPKI(), config.KBPKI(), rmd.GetTlfHandle())
 	if err != nil {
@@ -1833,6 +1841,10 @@ func (fbo *folderBranchOps) pathFromNodeForMDWriteLocked(
 
 func (fbo *folderBranchOps) getDirChildren(ctx context.Context, dir Node) (
 	children map[string]EntryInfo, err error) {
+	if fs := dir.GetFS(ctx); fs != nil {
+		return getDirChildrenUsingFS(fs)
+	}
+
 	lState := makeFBOLockState()
 
 	dirPath, err := fbo.pathFromNodeForRead(dir)
@@ -1897,6 +1909,150 @@ func (fbo *folderBranchOps) GetDirChildren(ctx context.Context, dir Node) (
 	return retChildren, nil
 }
 
+// makeFakeEntryPtr returns a block pointer for the entry `name` of
+// the directory `dir`, for entries that aren't stored in the TLF but
+// still need a Node.  The pointer is derived from the directory and
+// the name, so repeated lookups of an entry return the same Node.
+func (fbo *folderBranchOps) makeFakeEntryPtr(dir Node, name string) (
+	BlockPointer, error) {
+	dirPath, err := fbo.pathFromNodeForRead(dir)
+	if err != nil {
+		return BlockPointer{}, err
+	}
+	dirID := dirPath.tailPointer().ID.Bytes()
+	buf := make([]byte, 0, len(dirID)+len(name))
+	buf = append(append(buf, dirID...), name...)
+	id, err := kbfsblock.MakePermanentID(buf)
+	if err != nil {
+		return BlockPointer{}, err
+	}
+	return BlockPointer{
+		ID:         id,
+		DataVer:    FirstValidDataVer,
+		DirectType: DirectBlock,
+	}, nil
+}
+
+// entryInfoFromFileInfo makes a read-only EntryInfo for an entry of
+// a file system backing a Node.
+func entryInfoFromFileInfo(fs NodeFSReadOnly, name string,
+	fi os.FileInfo) (ei EntryInfo, err error) {
+	switch {
+	case fi.IsDir():
+		ei.Type = Dir
+	case fi.Mode()&os.ModeSymlink != 0:
+		ei.Type = Sym
+		ei.SymPath, err = fs.Readlink(name)
+		if err != nil {
+			return EntryInfo{}, err
+		}
+	case fi.Mode()&0100 != 0:
+		ei.Type = Exec
+	default:
+		ei.Type = File
+	}
+	if ei.Type != Dir {
+		ei.Size = uint64(fi.Size())
+	}
+	ei.Mtime = fi.ModTime().UnixNano()
+	ei.Ctime = ei.Mtime
+	return ei, nil
+}
+
+// lookupUsingFS looks up `name` in `dir`, whose contents come from
+// `fs` instead of the TLF's blocks.
+func (fbo *folderBranchOps) lookupUsingFS(
+	ctx context.Context, dir Node, fs NodeFSReadOnly, name string) (
+	node Node, ei EntryInfo, err error) {
+	fi, err := fs.Lstat(name)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, EntryInfo{}, NoSuchNameError{name}
+	} else if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	ei, err = entryInfoFromFileInfo(fs, name, fi)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	if ei.Type == Sym {
+		// Symlinks don't have Nodes.
+		return nil, ei, nil
+	}
+	ptr, err := fbo.makeFakeEntryPtr(dir, name)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	node, err = fbo.nodeCache.GetOrCreate(ptr, name, dir)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	return node, ei, nil
+}
+
+// getDirChildrenUsingFS lists `dir`, whose contents come from `fs`.
+func getDirChildrenUsingFS(fs NodeFSReadOnly) (
+	children map[string]EntryInfo, err error) {
+	fis, err := fs.ReadDir("")
+	if err != nil {
+		return nil, err
+	}
+	children = make(map[string]EntryInfo, len(fis))
+	for _, fi := range fis {
+		ei, err := entryInfoFromFileInfo(fs, fi.Name(), fi)
+		if err != nil {
+			return nil, err
+		}
+		children[fi.Name()] = ei
+	}
+	return children, nil
+}
+
+// statUsingFS returns the entry for `node` if it's backed by a file
+// system instead of the TLF's blocks, or false otherwise.
+// Directories are described by their own file systems, and files by
+// their parents'.
+func (fbo *folderBranchOps) statUsingFS(ctx context.Context, node Node) (
+	de DirEntry, ok bool, err error) {
+	if fs := node.GetFS(ctx); fs != nil {
+		fi, err := fs.Lstat("")
+		if err != nil {
+			return DirEntry{}, false, err
+		}
+		de.EntryInfo, err = entryInfoFromFileInfo(fs, "", fi)
+		if err != nil {
+			return DirEntry{}, false, err
+		}
+		return de, true, nil
+	}
+	if node.GetFile(ctx) == nil {
+		return DirEntry{}, false, nil
+	}
+	nodePath, err := fbo.pathFromNodeForRead(node)
+	if err != nil {
+		return DirEntry{}, false, err
+	}
+	parent := fbo.nodeCache.Get(nodePath.parentPath().tailRef())
+	if parent == nil {
+		return DirEntry{}, false, errors.Errorf(
+			"No parent node for %s", nodePath)
+	}
+	fs := parent.GetFS(ctx)
+	if fs == nil {
+		return DirEntry{}, false, errors.Errorf(
+			"Parent of %s has no file system", nodePath)
+	}
+	name := node.GetBasename()
+	fi, err := fs.Lstat(name)
+	if err != nil {
+		return DirEntry{}, false, err
+	}
+	de.EntryInfo, err = entryInfoFromFileInfo(fs, name, fi)
+	if err != nil {
+		return DirEntry{}, false, err
+	}
+	return de, true, nil
+}
+
 func (fbo *folderBranchOps) processMissedLookup(
 	ctx context.Context, dir Node, name string, missErr error) (
 	node Node, ei EntryInfo, err error) {
@@ -1923,6 +2079,23 @@ func (fbo *folderBranchOps) processMissedLookup(
 	case Sym:
 		ei, err := fbo.CreateLink(ctx, dir, name, sympath)
 		return nil, ei, err
+	case FakeDir:
+		ptr, err := fbo.makeFakeEntryPtr(dir, name)
+		if err != nil {
+			return nil, EntryInfo{}, err
+		}
+		node, err := fbo.nodeCache.GetOrCreate(ptr, name, dir)
+		if err != nil {
+			return nil, EntryInfo{}, err
+		}
+		de, ok, err := fbo.statUsingFS(ctx, node)
+		if err != nil {
+			return nil, EntryInfo{}, err
+		} else if !ok {
+			return nil, EntryInfo{}, errors.Errorf(
+				"Fake directory %s has no file system", name)
+		}
+		return node, de.EntryInfo, nil
 	default:
 		return nil, EntryInfo{}, errors.Errorf("Unknown entry type %s", et)
 	}
@@ -1930,6 +2103,11 @@ func (fbo *folderBranchOps) processMissedLookup(
 
 func (fbo *folderBranchOps) lookup(ctx context.Context, dir Node, name string) (
 	node Node, de DirEntry, err error) {
+	if fs := dir.GetFS(ctx); fs != nil {
+		node, de.EntryInfo, err = fbo.lookupUsingFS(ctx, dir, fs, name)
+		return node, de, err
+	}
+
 	if fbo.nodeCache.IsUnlinked(dir) {
 		fbo.log.CDebugf(ctx, "Refusing a lookup for unlinked directory %v",
 			fbo.nodeCache.PathFromNode(dir).tailPointer())
@@ -2013,6 +2191,13 @@ func (fbo *folderBranchOps) statEntry(ctx context.Context, node Node) (
 		return DirEntry{}, err
 	}
 
+	de, ok, err := fbo.statUsingFS(ctx, node)
+	if err != nil {
+		return DirEntry{}, err
+	} else if ok {
+		return de, nil
+	}
+
 	lState := makeFBOLockState()
 
 	nodePath, err := fbo.pathFromNodeForRead(node)
@@ -2093,6 +2278,7 @@ func (fbo *folderBranchOps) GetNodeMetadata(ctx context.Context, node Node) (
 		return res, err
 	}
 	res.BlockInfo = de.BlockInfo
+	res.Xattrs = de.Xattrs
 
 	id := de.TeamWriter.AsUserOrTeam()
 	if id.IsNil() {
@@ -2287,6 +2473,95 @@ func (fbo *folderBranchOps) sendEditNotifications(
 func (fbo *folderBranchOps) makeEditNotifications(
 	ctx context.Context, rmd ImmutableRootMetadata) (
 	edits []kbfsedits.NotificationMessage, err error) {
+	// If journaling is enabled, this MD is coming from the journal,
+	// and the final paths will not be set on the ops.
+	return fbo.makeEditNotificationsWithPaths(
+		ctx, rmd, TLFJournalEnabled(fbo.config, fbo.id()), false)
+}
+
+// joinChainRenames returns `ops`, except that each rename that
+// `chains` split into an rmOp and a createOp is joined back into a
+// single renameOp, so that it's reported as a rename rather than as
+// a delete and a create.
+func joinChainRenames(chains *crChains, ops []op) []op {
+	joined := make(map[op]bool)
+	var renames []op
+	for _, ri := range chains.renamedOriginals {
+		var rop *rmOp
+		if chain, ok := chains.byOriginal[ri.originalOldParent]; ok {
+			for _, op := range chain.ops {
+				if o, ok := op.(*rmOp); ok && o.OldName == ri.oldName {
+					rop = o
+					break
+				}
+			}
+		}
+		var cop *createOp
+		if chain, ok := chains.byOriginal[ri.originalNewParent]; ok {
+			for _, op := range chain.ops {
+				if o, ok := op.(*createOp); ok && o.renamed &&
+					o.NewName == ri.newName {
+					cop = o
+					break
+				}
+			}
+		}
+		if rop == nil || cop == nil {
+			continue
+		}
+		ro := &renameOp{
+			OldName:      ri.oldName,
+			NewName:      ri.newName,
+			RenamedType:  cop.Type,
+			oldFinalPath: rop.getFinalPath(),
+		}
+		ro.setFinalPath(cop.getFinalPath())
+		joined[rop] = true
+		joined[cop] = true
+		renames = append(renames, ro)
+	}
+	if len(renames) == 0 {
+		return ops
+	}
+
+	res := make([]op, 0, len(ops))
+	for _, op := range ops {
+		if !joined[op] {
+			res = append(res, op)
+		}
+	}
+	return append(res, renames...)
+}
+
+// chainsInOpOrder returns the chains in `chains`, in the order of
+// the first op in `ops` to touch each one, so that edits made from
+// them come out in a stable order.  `chains` must have been made from
+// `ops`.
+func chainsInOpOrder(chains *crChains, ops []op) []*crChain {
+	seen := make(map[*crChain]bool, len(chains.byOriginal))
+	res := make([]*crChain, 0, len(chains.byOriginal))
+	for _, op := range ops {
+		for _, update := range op.allUpdates() {
+			chain, ok := chains.byOriginal[update.Unref]
+			if !ok || seen[chain] {
+				continue
+			}
+			seen[chain] = true
+			res = append(res, chain)
+		}
+	}
+	return res
+}
+
+// makeEditNotificationsWithPaths makes the edit notifications for
+// `rmd`, using crChains to set the final paths of its ops first if
+// `populatePaths` is true.  In that case, if `joinRenames` is also
+// true, renames are reported as renames rather than as the delete and
+// create that crChains splits them into.
+func (fbo *folderBranchOps) makeEditNotificationsWithPaths(
+	ctx context.Context, rmd ImmutableRootMetadata,
+	populatePaths, joinRenames bool) (
+	edits []kbfsedits.NotificationMessage, err error) {
 	if rmd.IsWriterMetadataCopiedSet() {
 		return nil, nil
 	}
@@ -2295,11 +2570,8 @@ func (fbo *folderBranchOps) makeEditNotifications(
 		return nil, nil
 	}
 
-	// If journaling is enabled, this MD is coming from the journal,
-	// and the final paths will not be set on the ops.  Use crChains
-	// to set them.
 	ops := rmd.data.Changes.Ops
-	if TLFJournalEnabled(fbo.config, fbo.id()) {
+	if populatePaths {
 		chains, err := newCRChainsForIRMDs(
 			ctx, fbo.config.Codec(), []ImmutableRootMetadata{rmd},
 			&fbo.blocks, true)
@@ -2310,9 +2582,13 @@ func (fbo *folderBranchOps) makeEditNotifications(
 		if err != nil {
 			return nil, err
 		}
-		ops = make([]op, 0, len(ops))
-		for _, chain := range chains.byMostRecent {
-			ops = append(ops, chain.ops...)
+		chainOps := make([]op, 0, len(ops))
+		for _, chain := range chainsInOpOrder(chains, ops) {
+			chainOps = append(chainOps, chain.ops...)
+		}
+		ops = chainOps
+		if joinRenames {
+			ops = joinChainRenames(chains, ops)
 		}
 	}
 
@@ -3763,6 +4039,24 @@ func (fbo *folderBranchOps) Read(
 		return 0, err
 	}
 
+	if f := file.GetFile(ctx); f != nil {
+		// Don't let the goroutine below write directly to the return
+		// variable; see below.
+		var bytesRead int
+		err = runUnlessCanceled(ctx, func() error {
+			var err error
+			bytesRead, err = f.ReadAt(dest, off)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		return int64(bytesRead), nil
+	}
+
 	{
 		filePath, err := fbo.pathFromNodeForRead(file)
 		if err != nil {
@@ -4049,6 +4343,132 @@ func (fbo *folderBranchOps) SetMtime(
 		})
 }
 
+func (fbo *folderBranchOps) setXattrLocked(
+	ctx context.Context, lState *lockState, file Node, name string,
+	value []byte, remove bool) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, file)
+	if err != nil {
+		return err
+	}
+	if !filePath.hasValidParent() {
+		// The root entry isn't stored in any directory block.
+		return errors.Errorf(
+			"Can't set extended attributes on the root of %s",
+			filePath.Tlf)
+	}
+
+	// Verify we have permission to write (no need to make a successor yet).
+	md, err := fbo.getMDForWriteLockedForFilename(ctx, lState, "")
+	if err != nil {
+		return err
+	}
+
+	de, err := fbo.blocks.GetDirtyEntryEvenIfDeleted(
+		ctx, lState, md.ReadOnly(), filePath)
+	if err != nil {
+		return err
+	}
+	if _, ok := de.Xattrs[name]; remove && !ok {
+		return NoSuchXattrError{name}
+	}
+
+	// Never modify the existing map, since other copies of this
+	// entry may share it.
+	xattrs := make(map[string][]byte, len(de.Xattrs)+1)
+	size := 0
+	for n, v := range de.Xattrs {
+		if n == name {
+			continue
+		}
+		xattrs[n] = v
+		size += len(n) + len(v)
+	}
+	if !remove {
+		size += len(name) + len(value)
+		if size > maxXattrBytes {
+			return XattrTooBigError{name, size, maxXattrBytes}
+		}
+		xattrs[name] = append([]byte(nil), value...)
+	}
+	if len(xattrs) == 0 {
+		xattrs = nil
+	}
+	de.Xattrs = xattrs
+	// Changing an attribute counts as changing the file MD, so
+	// update the ctime.
+	de.Ctime = fbo.nowUnixNano()
+
+	parentPtr := filePath.parentPath().tailPointer()
+	sao, err := newSetAttrOp(filePath.tailName(), parentPtr,
+		xattrAttr, filePath.tailPointer())
+	if err != nil {
+		return err
+	}
+	sao.AddSelfUpdate(parentPtr)
+
+	// If the node has been unlinked, we can safely ignore this
+	// change.
+	if fbo.nodeCache.IsUnlinked(file) {
+		fbo.log.CDebugf(ctx, "Skipping setxattr for a removed file %v",
+			filePath.tailPointer())
+		fbo.blocks.UpdateCachedEntryAttributesOnRemovedFile(
+			ctx, lState, sao, de)
+		return nil
+	}
+
+	sao.setFinalPath(filePath)
+
+	dirCacheUndoFn := fbo.blocks.SetAttrInDirEntryInCache(
+		lState, filePath, de, sao.Attr)
+	return fbo.notifyAndSyncOrSignal(
+		ctx, lState, dirCacheUndoFn, []Node{file}, sao, md.ReadOnly())
+}
+
+func (fbo *folderBranchOps) SetXattr(
+	ctx context.Context, file Node, name string, value []byte) (err error) {
+	fbo.log.CDebugf(ctx, "SetXattr %s %s (%d bytes)",
+		getNodeIDStr(file), name, len(value))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetXattr %s %s done: %+v",
+			getNodeIDStr(file), name, err)
+	}()
+
+	if name == "" {
+		return errors.New("Extended attribute names can't be empty")
+	}
+
+	err = fbo.checkNodeForWrite(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setXattrLocked(ctx, lState, file, name, value, false)
+		})
+}
+
+func (fbo *folderBranchOps) RemoveXattr(
+	ctx context.Context, file Node, name string) (err error) {
+	fbo.log.CDebugf(ctx, "RemoveXattr %s %s", getNodeIDStr(file), name)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "RemoveXattr %s %s done: %+v",
+			getNodeIDStr(file), name, err)
+	}()
+
+	err = fbo.checkNodeForWrite(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.setXattrLocked(ctx, lState, file, name, nil, true)
+		})
+}
+
 type cleanupFn func(context.Context, *lockState, []BlockPointer, error)
 
 // startSyncLocked readies the blocks and other state needed to sync a
@@ -5204,17 +5624,52 @@ func (fbo *folderBranchOps) setLatestMergedRevisionLocked(ctx context.Context, l
 func (fbo *folderBranchOps) getAndApplyMDUpdates(ctx context.Context,
 	lState *lockState, lockBeforeGet *keybase1.LockID,
 	applyFunc applyMDUpdatesFunc) error {
-	// first look up all MD revisions newer than my current head
+	// first look up all MD revisions newer than my current head,
+	// applying them a chunk at a time as they arrive, so that a long
+	// catch-up doesn't need to hold every revision in memory.
+	//
+	// If we turn out to be on an unmerged branch, applyFunc kicks
+	// off conflict resolution instead of applying anything.  CR
+	// should see the whole range, so in that case just read the rest
+	// of it, and hand applyFunc the last chunk once more at the end;
+	// the newer resolution supersedes the one for the first chunk.
 	start := fbo.getLatestMergedRevision(lState) + 1
-	rmds, err := getMergedMDUpdates(ctx,
-		fbo.config, fbo.id(), start, lockBeforeGet)
+	applied := false
+	var unmergedRmds []ImmutableRootMetadata
+	unmergedStale := false
+	err := forEachMergedMDUpdateChunk(ctx, fbo.config, fbo.id(), start,
+		lockBeforeGet, func(rmds []ImmutableRootMetadata) error {
+			applied = true
+			if unmergedRmds != nil {
+				unmergedRmds = rmds
+				unmergedStale = true
+				return nil
+			}
+			err := applyFunc(ctx, lState, rmds)
+			if _, ok := err.(UnmergedError); ok {
+				unmergedRmds = rmds
+				return nil
+			}
+			return err
+		})
 	if err != nil {
 		return err
 	}
 
-	err = applyFunc(ctx, lState, rmds)
-	if err != nil {
-		return err
+	if unmergedRmds != nil {
+		if unmergedStale {
+			return applyFunc(ctx, lState, unmergedRmds)
+		}
+		return UnmergedError{}
+	}
+
+	if !applied {
+		// Still give applyFunc a chance to react to there being no
+		// updates (e.g., by kicking off conflict resolution).
+		err = applyFunc(ctx, lState, nil)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -5448,6 +5903,38 @@ func (fbo *folderBranchOps) UnstageForTesting(
 	})
 }
 
+func (fbo *folderBranchOps) ForceConflictResolution(
+	ctx context.Context, folderBranch FolderBranch) (err error) {
+	fbo.log.CDebugf(ctx, "ForceConflictResolution")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "ForceConflictResolution done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	lState := makeFBOLockState()
+	if fbo.isMasterBranch(lState) {
+		// no-op
+		return nil
+	}
+
+	if err := fbo.syncAllUnlocked(ctx, lState); err != nil {
+		return err
+	}
+
+	unmergedRev := kbfsmd.RevisionUninitialized
+	if head, _ := fbo.getHead(lState); head != (ImmutableRootMetadata{}) {
+		unmergedRev = head.Revision()
+	}
+	// Forget about the previous input, so CR doesn't ignore a
+	// request for the same revisions it already failed on.
+	fbo.cr.BeginNewBranch()
+	fbo.cr.Resolve(ctx, unmergedRev, fbo.getLatestMergedRevision(lState))
+	return fbo.cr.Wait(ctx)
+}
+
 // mdWriterLock must be taken by the caller.
 func (fbo *folderBranchOps) rekeyLocked(ctx context.Context,
 	lState *lockState, promptPaper bool) (res RekeyResult, err error) {
@@ -6741,6 +7228,186 @@ func (fbo *folderBranchOps) GetEditHistory(ctx context.Context,
 	return fbo.editHistory.GetComplete(ctx, head)
 }
 
+// GetEditHistoryRange implements the KBFSOps interface for
+// folderBranchOps
+func (fbo *folderBranchOps) GetEditHistoryRange(ctx context.Context,
+	folderBranch FolderBranch, start, end time.Time) (
+	edits TlfWriterEdits, err error) {
+	fbo.log.CDebugf(ctx, "GetEditHistoryRange %s-%s", start, end)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetEditHistoryRange done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return nil, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	// Make sure the caller is allowed to read the folder.
+	lState := makeFBOLockState()
+	_, err = fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, err
+	}
+
+	return fbo.editHistory.GetRange(ctx, fbo.id(),
+		fbo.getLatestMergedRevision(lState), start, end)
+}
+
+// GetFileEditHistory implements the KBFSOps interface for
+// folderBranchOps
+func (fbo *folderBranchOps) GetFileEditHistory(ctx context.Context,
+	file Node) (edits []kbfsedits.NotificationMessage, err error) {
+	fbo.log.CDebugf(ctx, "GetFileEditHistory %s", getNodeIDStr(file))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetFileEditHistory %s done: %+v",
+			getNodeIDStr(file), err)
+	}()
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the caller is allowed to read the folder.
+	lState := makeFBOLockState()
+	_, err = fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, err
+	}
+
+	filePath, err := fbo.pathFromNodeForRead(file)
+	if err != nil {
+		return nil, err
+	}
+	return fbo.editHistory.GetFileHistory(filePath.String()), nil
+}
+
+// makeAuditEvents returns the audit events for `rmd`, given the
+// revision before it, which is empty for the first revision.
+func (fbo *folderBranchOps) makeAuditEvents(ctx context.Context,
+	prev, rmd ImmutableRootMetadata) ([]AuditEvent, error) {
+	// We want the server's view of the time.
+	revTime := rmd.localTimestamp
+	if offset, ok := fbo.config.MDServer().OffsetFromServerTime(); ok {
+		revTime = revTime.Add(-offset)
+	}
+	base := AuditEvent{
+		Revision: rmd.Revision(),
+		Time:     keybase1.ToTime(revTime),
+		Writer:   rmd.LastModifyingWriter(),
+		Device:   rmd.LastModifyingWriterVerifyingKey(),
+	}
+
+	var events []AuditEvent
+	if prev != (ImmutableRootMetadata{}) {
+		name := rmd.GetTlfHandle().GetCanonicalName()
+		if name != prev.GetTlfHandle().GetCanonicalName() {
+			e := base
+			e.Type = AuditEventHandleChange
+			e.Name = name
+			events = append(events, e)
+		}
+		keyGen := rmd.LatestKeyGeneration()
+		if keyGen > prev.LatestKeyGeneration() {
+			e := base
+			e.Type = AuditEventKeyRotation
+			e.KeyGen = keyGen
+			events = append(events, e)
+		}
+	}
+	for _, op := range rmd.data.Changes.Ops {
+		if _, ok := op.(*rekeyOp); ok {
+			e := base
+			e.Type = AuditEventRekey
+			events = append(events, e)
+			break
+		}
+	}
+
+	// MDs fetched from the server don't have final paths on their
+	// ops, so always compute them.
+	edits, err := fbo.makeEditNotificationsWithPaths(
+		ctx, rmd, true, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, edit := range edits {
+		e := base
+		e.Type = AuditEventType(edit.Type)
+		e.Path = edit.Filename
+		if edit.Params != nil {
+			e.OldPath = edit.Params.OldFilename
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// GetAuditEvents implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetAuditEvents(ctx context.Context,
+	folderBranch FolderBranch, start kbfsmd.Revision) (
+	events []AuditEvent, end kbfsmd.Revision, err error) {
+	fbo.log.CDebugf(ctx, "GetAuditEvents %d", start)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetAuditEvents done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return nil, kbfsmd.RevisionUninitialized,
+			WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	if start < kbfsmd.RevisionInitial {
+		start = kbfsmd.RevisionInitial
+	}
+
+	// Make sure the caller is allowed to read the folder.
+	lState := makeFBOLockState()
+	_, err = fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, kbfsmd.RevisionUninitialized, err
+	}
+	head := fbo.getLatestMergedRevision(lState)
+	if start > head {
+		return nil, head, nil
+	}
+
+	var prev ImmutableRootMetadata
+	if start > kbfsmd.RevisionInitial {
+		prev, err = getSingleMD(ctx, fbo.config, fbo.id(),
+			kbfsmd.NullBranchID, start-1, kbfsmd.Merged, nil)
+		if err != nil {
+			return nil, kbfsmd.RevisionUninitialized, err
+		}
+	}
+	// Fetch the range in batches, to bound the number of MDs held in
+	// memory at once.
+	for batchStart := start; batchStart <= head; {
+		batchEnd := batchStart + maxMDsAtATime - 1
+		if batchEnd > head {
+			batchEnd = head
+		}
+		rmds, err := getMergedMDUpdatesWithEnd(
+			ctx, fbo.config, fbo.id(), batchStart, batchEnd, nil)
+		if err != nil {
+			return nil, kbfsmd.RevisionUninitialized, err
+		}
+		if len(rmds) == 0 {
+			break
+		}
+		for _, rmd := range rmds {
+			revEvents, err := fbo.makeAuditEvents(ctx, prev, rmd)
+			if err != nil {
+				return nil, kbfsmd.RevisionUninitialized, err
+			}
+			events = append(events, revEvents...)
+			prev = rmd
+		}
+		batchStart = rmds[len(rmds)-1].Revision() + 1
+	}
+	return events, prev.Revision(), nil
+}
+
 // PushStatusChange forces a new status be fetched by status listeners.
 func (fbo *folderBranchOps) PushStatusChange() {
 	fbo.config.KBFSOps().PushStatusChange()
@@ -35,6 +35,12 @@ type FolderBranchStatus struct {
 	LimitBytes          int64
 	GitUsageBytes       int64
 	GitLimitBytes       int64
+	// The bytes charged for this TLF, split into live blocks and
+	// archived blocks that haven't been reclaimed yet.
+	LiveBytes        int64
+	ArchivedBytes    int64
+	LiveGitBytes     int64
+	ArchivedGitBytes int64
 
 	// DirtyPaths are files that have been written, but not flushed.
 	// They do not represent unstaged changes in your local instance.
@@ -244,6 +250,16 @@ func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 		fbs.LimitBytes = limitBytes
 		fbs.GitUsageBytes = gitUsageBytes
 		fbs.GitLimitBytes = gitLimitBytes
+		if quErr == nil {
+			// GetAllTypes just refreshed the cache, so there's no
+			// need for another round trip.
+			breakdown := fbsk.quotaUsage.getCached().tlfBreakdown(
+				fbsk.md.TlfID())
+			fbs.LiveBytes = breakdown.LiveBytes
+			fbs.ArchivedBytes = breakdown.ArchivedBytes
+			fbs.LiveGitBytes = breakdown.LiveGitBytes
+			fbs.ArchivedGitBytes = breakdown.ArchivedGitBytes
+		}
 	}
 
 	fbs.DirtyPaths = fbsk.convertNodesToPathsLocked(fbsk.dirtyNodes)
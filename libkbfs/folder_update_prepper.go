@@ -46,6 +46,11 @@ func (fup *folderUpdatePrepper) readyBlockMultiple(ctx context.Context,
 	kmd KeyMetadata, currBlock Block, chargedTo keybase1.UserOrTeamID,
 	bps *blockPutState, bType keybase1.BlockType) (
 	info BlockInfo, plainSize int, err error) {
+	if dblock, ok := currBlock.(*DirBlock); ok && fup.config.CompactDirEntries() {
+		// Once compact, a directory stays compact even if the
+		// setting is later turned off.
+		dblock.compact = true
+	}
 	info, plainSize, readyBlockData, err :=
 		ReadyBlock(ctx, fup.config.BlockCache(), fup.config.BlockOps(),
 			fup.config.cryptoPure(), kmd, currBlock, chargedTo, bType)
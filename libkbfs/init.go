@@ -19,7 +19,9 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/keybase/kbfs/kbfshash"
 	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/metricsutil"
 )
 
 const (
@@ -52,6 +54,13 @@ type InitParams struct {
 	// "dir:/path/to/dir" for an on-disk test server.
 	BServerAddr string
 
+	// If non-zero, the most block bytes that may be in flight to or
+	// from a remote block server at once.  Background traffic, like
+	// journal flushes and prefetches, yields to interactive traffic,
+	// and interactive fetches are never throttled.  If zero, nothing
+	// is throttled.
+	BServerBytesInFlight int64
+
 	// If non-empty the host:port of the metadata server. If
 	// empty, a default value is used depending on the run mode.
 	// Can also be "memory" for an in-memory test server or
@@ -62,6 +71,11 @@ type InitParams struct {
 	// zero, the capacity is set using getDefaultBlockCacheCapacity().
 	CleanBlockCacheCapacity uint64
 
+	// BlockCacheTinyLFU, if true, makes the clean block cache
+	// admit new blocks with a TinyLFU policy, so that scans don't
+	// evict frequently-used blocks.
+	BlockCacheTinyLFU bool
+
 	// Fake local user name.
 	LocalUser string
 
@@ -78,6 +92,21 @@ type InitParams struct {
 	// when creating new metadata.
 	MetadataVersion kbfsmd.MetadataVer
 
+	// CompactDirEntries, if true, writes new directory blocks
+	// using the compact entry encoding.  Clients older than this
+	// one won't be able to read directories written this way.
+	CompactDirEntries bool
+
+	// BLAKE2bBlockIDs, if true, uses BLAKE2b-256 rather than
+	// SHA-256 hashes for the IDs of new file data blocks.  Clients
+	// older than this one won't be able to read files written this
+	// way.
+	BLAKE2bBlockIDs bool
+
+	// ConflictPolicy controls how conflict resolution names and
+	// places the copies it makes of conflicting entries.
+	ConflictPolicy ConflictPolicy
+
 	// LogToFile if true, logs to a default file location.
 	LogToFile bool
 
@@ -114,6 +143,45 @@ type InitParams struct {
 
 	// Mode describes how KBFS should initialize itself.
 	Mode string
+
+	// BackupTarget, if true, tunes KBFS for write-once workloads like
+	// Time Machine or restic targets: writes are batched into fewer
+	// revisions, nothing is prefetched, and unreferenced blocks are
+	// kept longer before being reclaimed.
+	BackupTarget bool
+
+	// MetricsStatsdAddr, if non-empty, is the host:port of a statsd
+	// server to send metrics to.
+	MetricsStatsdAddr string
+
+	// MetricsInfluxDBURL, if non-empty, is an InfluxDB write URL
+	// (e.g., "http://localhost:8086/write?db=kbfs") to post metrics
+	// to.
+	MetricsInfluxDBURL string
+
+	// MetricsSinks are more sinks to send metrics to, for programs
+	// embedding KBFS that want to handle metrics themselves.
+	MetricsSinks []metricsutil.Sink
+
+	// MetricsFlushPeriod is how often metrics are sent to the sinks.
+	MetricsFlushPeriod time.Duration
+
+	// FolderActivityBridge, if true, posts summaries of the edits in
+	// opted-in team folders to the teams' chats.
+	FolderActivityBridge bool
+
+	// AuditLog, if true, keeps signed audit logs of team folders
+	// under StorageRoot, which can be exported for compliance.
+	AuditLog bool
+
+	// BrowseArchives, if true, lets frontends expose the contents of
+	// .zip and .tar files as read-only directories.
+	BrowseArchives bool
+
+	// AutogitSubmodules, if true, makes autogit also check out the
+	// submodules of a repo that are themselves hosted in KBFS (i.e.,
+	// that have keybase:// URLs).
+	AutogitSubmodules bool
 }
 
 // defaultBServer returns the default value for the -bserver flag.
@@ -193,6 +261,10 @@ func DefaultInitParams(ctx Context) InitParams {
 		EnableJournal:                  BoolForString(journalEnv),
 		DiskCacheMode:                  DiskCacheModeLocal,
 		Mode:                           InitDefaultString,
+		MetricsFlushPeriod:             metricsFlushPeriodDefault,
+		ConflictPolicy: ConflictPolicy{
+			SuffixFormat: DefaultConflictSuffixFormat,
+		},
 	}
 }
 
@@ -208,6 +280,11 @@ func AddFlagsWithDefaults(
 
 	flags.StringVar(&params.BServerAddr, "bserver", defaultParams.BServerAddr,
 		"host:port of the block server, 'memory', or 'dir:/path/to/dir'")
+	params.BServerBytesInFlight = defaultParams.BServerBytesInFlight
+	flags.Var(SizeFlag{&params.BServerBytesInFlight},
+		"bserver-bytes-in-flight", "If non-zero, limit the block bytes in "+
+			"flight to the block server, with background traffic yielding "+
+			"to interactive traffic")
 	flags.StringVar(&params.MDServerAddr, "mdserver",
 		defaultParams.MDServerAddr,
 		"host:port of the metadata server, 'memory', or 'dir:/path/to/dir'")
@@ -238,6 +315,10 @@ func AddFlagsWithDefaults(
 		defaultParams.CleanBlockCacheCapacity,
 		"If non-zero, specify the capacity of clean block cache. If zero, "+
 			"the capacity is set based on system RAM.")
+	flags.BoolVar(&params.BlockCacheTinyLFU, "bcache-tinylfu",
+		defaultParams.BlockCacheTinyLFU,
+		"Use a TinyLFU admission policy for the clean block cache, "+
+			"instead of plain LRU")
 	flags.StringVar(&params.StorageRoot, "storage-root",
 		defaultParams.StorageRoot, "Specifies where Keybase will store its "+
 			"local databases for the journal and disk cache.")
@@ -267,6 +348,55 @@ func AddFlagsWithDefaults(
 	flags.IntVar((*int)(&params.MetadataVersion), "md-version",
 		int(defaultParams.MetadataVersion),
 		"Metadata version to use when creating new metadata")
+	flags.BoolVar(&params.CompactDirEntries, "compact-dir-entries",
+		defaultParams.CompactDirEntries,
+		"Write new directory blocks using the compact entry encoding, "+
+			"which older clients can't read")
+	flags.BoolVar(&params.BLAKE2bBlockIDs, "blake2b-block-ids",
+		defaultParams.BLAKE2bBlockIDs,
+		"Use BLAKE2b hashes for the IDs of new file data blocks, "+
+			"which older clients can't read")
+	flags.StringVar(&params.ConflictPolicy.SuffixFormat, "conflict-suffix",
+		defaultParams.ConflictPolicy.SuffixFormat,
+		"Suffix added to the names of conflict copies, before the "+
+			"extension; may use {user}, {device}, {date} and {time}")
+	flags.StringVar(&params.ConflictPolicy.Subdir, "conflict-dir",
+		defaultParams.ConflictPolicy.Subdir,
+		"If set, move conflict copies into a subdirectory with this "+
+			"name, next to the conflicting entry")
+	flags.IntVar(&params.ConflictPolicy.MaxCopies, "conflict-max-copies",
+		defaultParams.ConflictPolicy.MaxCopies,
+		"If positive, and -conflict-dir is set, how many conflict copies "+
+			"of each entry to keep")
+	flags.BoolVar(&params.BackupTarget, "backup-target",
+		defaultParams.BackupTarget,
+		"Tune for write-once workloads like Time Machine or restic "+
+			"targets; -sync-batch-period and -sync-batch-size still "+
+			"take precedence")
+	flags.StringVar(&params.MetricsStatsdAddr, "metrics-statsd",
+		defaultParams.MetricsStatsdAddr,
+		"host:port of a statsd server to send metrics to")
+	flags.StringVar(&params.MetricsInfluxDBURL, "metrics-influxdb",
+		defaultParams.MetricsInfluxDBURL,
+		"InfluxDB write URL to post metrics to, e.g. "+
+			"http://localhost:8086/write?db=kbfs")
+	flags.DurationVar(&params.MetricsFlushPeriod, "metrics-flush-period",
+		defaultParams.MetricsFlushPeriod,
+		"How often to send metrics to -metrics-statsd and "+
+			"-metrics-influxdb")
+	flags.BoolVar(&params.FolderActivityBridge, "folder-activity-bridge",
+		defaultParams.FolderActivityBridge,
+		"Post summaries of the edits in opted-in team folders to the "+
+			"teams' chats")
+	flags.BoolVar(&params.AuditLog, "audit-log", defaultParams.AuditLog,
+		"Keep signed audit logs of team folders, which can be exported")
+	flags.BoolVar(&params.BrowseArchives, "browse-archives",
+		defaultParams.BrowseArchives,
+		"Show the contents of .zip and .tar files in read-only "+
+			".kbfs_archive directories")
+	flags.BoolVar(&params.AutogitSubmodules, "autogit-submodules",
+		defaultParams.AutogitSubmodules,
+		"Also check out the keybase:// submodules of autogit repos")
 	flags.StringVar(&params.Mode, "mode", defaultParams.Mode,
 		fmt.Sprintf("Overall initialization mode for KBFS, indicating how "+
 			"heavy-weight it can be (%s, %s, %s or %s)", InitDefaultString,
@@ -388,7 +518,7 @@ func makeKeyServer(config Config, keyserverAddr string,
 }
 
 func makeBlockServer(config Config, bserverAddr string,
-	rpcLogFactory rpc.LogFactory,
+	bytesInFlight int64, rpcLogFactory rpc.LogFactory,
 	log logger.Logger) (BlockServer, error) {
 	if bserverAddr == memoryAddr {
 		log.Debug("Using in-memory bserver")
@@ -415,7 +545,12 @@ func makeBlockServer(config Config, bserverAddr string,
 		return nil, err
 	}
 	log.Debug("Using remote bserver %s", remote)
-	return NewBlockServerRemote(config, remote, rpcLogFactory), nil
+	bserv := NewBlockServerRemote(config, remote, rpcLogFactory)
+	if bytesInFlight > 0 {
+		log.Debug("Limiting block bytes in flight to %d", bytesInFlight)
+		bserv.limitBytesInFlight(bytesInFlight)
+	}
+	return bserv, nil
 }
 
 // InitLogWithPrefix sets up logging switching to a log file if
@@ -529,6 +664,17 @@ func InitWithLogPrefix(
 	}
 }
 
+// InitWithoutSignalHandling initializes a config and returns it,
+// like InitWithLogPrefix, except that it leaves interrupt signals
+// alone.  It's for programs that embed KBFS and handle signals
+// themselves.
+func InitWithoutSignalHandling(
+	ctx context.Context, kbCtx Context, params InitParams,
+	keybaseServiceCn KeybaseServiceCn, log logger.Logger,
+	logPrefix string) (Config, error) {
+	return doInit(ctx, kbCtx, params, keybaseServiceCn, log, logPrefix)
+}
+
 // Init initializes a config and returns it.
 //
 // onInterruptFn is called whenever an interrupt signal is received
@@ -553,6 +699,11 @@ func doInit(
 	ctx context.Context, kbCtx Context, params InitParams,
 	keybaseServiceCn KeybaseServiceCn, log logger.Logger,
 	logPrefix string) (Config, error) {
+	params = backupTargetTuning(params)
+	if params.BackupTarget {
+		log.CDebugf(ctx, "Tuning for a backup target")
+	}
+
 	mode := InitDefault
 	switch params.Mode {
 	case InitDefaultString:
@@ -588,6 +739,11 @@ func doInit(
 			return lg
 		}, params.StorageRoot, params.DiskCacheMode, kbCtx)
 
+	if params.BlockCacheTinyLFU {
+		log.CDebugf(ctx, "using TinyLFU admission for the clean block cache")
+		config.SetBlockCacheTinyLFU(true)
+	}
+
 	if params.CleanBlockCacheCapacity > 0 {
 		log.CDebugf(
 			ctx, "overriding default clean block cache capacity from %d to %d",
@@ -599,6 +755,12 @@ func doInit(
 
 	workers := config.Mode().BlockWorkers()
 	prefetchWorkers := config.Mode().PrefetchWorkers()
+	if params.BackupTarget {
+		// Backups are rarely read, so prefetching would mostly
+		// fetch blocks nobody wants.
+		prefetchWorkers = 0
+		config.qrUnrefAge = backupTargetQRUnrefAge
+	}
 	config.SetBlockOps(NewBlockOpsStandard(config, workers, prefetchWorkers))
 
 	bsplitter, err := NewBlockSplitterSimple(MaxBlockSizeBytesDefault, 8*1024,
@@ -619,6 +781,14 @@ func doInit(
 	}
 
 	config.SetMetadataVersion(kbfsmd.MetadataVer(params.MetadataVersion))
+	config.SetCompactDirEntries(params.CompactDirEntries)
+	if err := params.ConflictPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	config.SetConflictPolicy(params.ConflictPolicy)
+	if params.BLAKE2bBlockIDs {
+		config.SetBlockHashType(kbfshash.BLAKE2b256Hash)
+	}
 	config.SetTLFValidDuration(params.TLFValidDuration)
 	config.SetBGFlushPeriod(params.BGFlushPeriod)
 
@@ -684,8 +854,8 @@ func doInit(
 	config.SetKeyServer(keyServer)
 
 	// Initialize BlockServer connection.
-	bserv, err := makeBlockServer(
-		config, params.BServerAddr, kbCtx.NewRPCLogFactory(), log)
+	bserv, err := makeBlockServer(config, params.BServerAddr,
+		params.BServerBytesInFlight, kbCtx.NewRPCLogFactory(), log)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open block database: %+v", err)
 	}
@@ -749,9 +919,76 @@ func doInit(
 		params.BGFlushDirOpBatchSize)
 	config.SetBGFlushDirOpBatchSize(params.BGFlushDirOpBatchSize)
 
+	startMetricsFlusher(ctx, config, params, log)
+
+	if params.FolderActivityBridge {
+		err = startFolderActivityBridge(config)
+		if err != nil {
+			log.CWarningf(ctx,
+				"Could not start the folder activity bridge: %+v", err)
+		}
+	}
+
+	if params.AuditLog {
+		config.auditLogger = NewAuditLogger(
+			config, filepath.Join(params.StorageRoot, "kbfs_audit"))
+	}
+
 	return config, nil
 }
 
+// startFolderActivityBridge starts posting the activity of opted-in
+// team folders to chat.
+func startFolderActivityBridge(config *ConfigLocal) error {
+	var dbPath string
+	if !config.IsTestMode() && config.storageRoot != "" {
+		dbPath = filepath.Join(
+			config.storageRoot, folderActivityBridgeConfigFolderName)
+	}
+	bridge, err := NewFolderActivityBridge(
+		config, dbPath, folderActivityBridgePeriodDefault)
+	if err != nil {
+		return err
+	}
+	bridge.Start()
+	config.activityBridge = bridge
+	return nil
+}
+
+// startMetricsFlusher starts sending metrics to the sinks given in
+// `params`, if there are any; ConfigLocal.Shutdown stops it.
+func startMetricsFlusher(ctx context.Context, config *ConfigLocal,
+	params InitParams, log logger.Logger) {
+	sinks := params.MetricsSinks
+	if params.MetricsStatsdAddr != "" {
+		sinks = append(sinks,
+			metricsutil.NewStatsdSink(params.MetricsStatsdAddr, "kbfs"))
+	}
+	if params.MetricsInfluxDBURL != "" {
+		sinks = append(sinks,
+			metricsutil.NewInfluxDBSink(params.MetricsInfluxDBURL, "kbfs"))
+	}
+	if len(sinks) == 0 {
+		return
+	}
+	registry := config.MetricsRegistry()
+	if registry == nil {
+		log.CWarningf(ctx, "Metrics sinks given, but metrics are disabled")
+		return
+	}
+	period := params.MetricsFlushPeriod
+	if period <= 0 {
+		period = metricsFlushPeriodDefault
+	}
+	log.CDebugf(ctx, "Sending metrics to %d sink(s) every %s",
+		len(sinks), period)
+	config.metricsFlusher = metricsutil.StartFlusher(registry, period,
+		func(sink metricsutil.Sink, err error) {
+			log.CDebugf(context.Background(),
+				"Couldn't send metrics to %T: %+v", sink, err)
+		}, sinks...)
+}
+
 // Shutdown does any necessary shutdown tasks for libkbfs. Shutdown
 // should be called at the end of main.
 func Shutdown() {}
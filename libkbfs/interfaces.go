@@ -5,6 +5,8 @@
 package libkbfs
 
 import (
+	"io"
+	"os"
 	"time"
 
 	"github.com/keybase/client/go/libkb"
@@ -14,6 +16,8 @@ import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscodec"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsedits"
+	"github.com/keybase/kbfs/kbfshash"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
 	metrics "github.com/rcrowley/go-metrics"
@@ -69,6 +73,12 @@ type diskBlockCacheGetter interface {
 	DiskBlockCache() DiskBlockCache
 }
 
+type blockHashTypeGetter interface {
+	// BlockHashType returns the hash type to use for the IDs of new
+	// direct file blocks.
+	BlockHashType() kbfshash.HashType
+}
+
 type diskBlockCacheSetter interface {
 	MakeDiskBlockCacheIfNotExists() error
 }
@@ -86,6 +96,24 @@ type syncedTlfGetterSetter interface {
 	SetTlfSyncState(tlfID tlf.ID, isSynced bool) error
 }
 
+type tlfAliasGetter interface {
+	// LookupTlfAlias returns the canonical name of the team folder
+	// that the given user-defined alias refers to, if any.
+	LookupTlfAlias(alias string) (tlf.CanonicalName, bool)
+}
+
+type tlfAliasGetterSetter interface {
+	tlfAliasGetter
+	// TlfAliases returns all the user-defined team folder aliases,
+	// sorted by name.
+	TlfAliases() []tlf.Alias
+	// SetTlfAlias makes `alias` refer to the team folder `target`.
+	SetTlfAlias(alias string, target tlf.CanonicalName) error
+	// RemoveTlfAlias removes the given team folder alias, if it
+	// exists.
+	RemoveTlfAlias(alias string) error
+}
+
 type blockRetrieverGetter interface {
 	BlockRetriever() BlockRetriever
 }
@@ -171,6 +199,32 @@ type Node interface {
 	// Unwrap returns the initial, unwrapped Node that was used to
 	// create this Node.
 	Unwrap() Node
+	// GetFS returns a file system that, if non-nil, should be used
+	// instead of the TLF's blocks for any directory-related calls on
+	// this Node.  This lets a wrapper expose read-only contents that
+	// aren't stored in the TLF, like the files inside an archive.
+	// An implementation that wraps another `Node` (`inner`) must
+	// return `inner.GetFS()` if it decides not to return its own.
+	GetFS(ctx context.Context) NodeFSReadOnly
+	// GetFile returns the data that, if non-nil, should be used
+	// instead of the TLF's blocks for reads of this file Node; see
+	// GetFS.  An implementation that wraps another `Node` (`inner`)
+	// must return `inner.GetFile()` if it decides not to return its
+	// own.
+	GetFile(ctx context.Context) io.ReaderAt
+}
+
+// NodeFSReadOnly is the read-only part of a file system backing the
+// contents of a directory Node (see Node.GetFS).  Names are relative
+// to the directory, and "" means the directory itself.
+type NodeFSReadOnly interface {
+	// Lstat returns the info for `name`, without following symlinks.
+	Lstat(name string) (os.FileInfo, error)
+	// ReadDir returns the infos for the children of the directory
+	// `name`.
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Readlink returns the target of the symlink `name`.
+	Readlink(name string) (string, error)
 }
 
 // KBFSOps handles all file system operations.  Expands all indirect
@@ -345,6 +399,18 @@ type KBFSOps interface {
 	// the top-level folder.  If mtime is nil, it is a noop.  This is
 	// a remote-sync operation.
 	SetMtime(ctx context.Context, file Node, mtime *time.Time) error
+	// SetXattr sets the named extended attribute of the file or
+	// directory represented by a given node, if the logged-in user
+	// has write permissions to the top-level folder.  Extended
+	// attributes are stored in the entry's parent directory, and are
+	// reported in NodeMetadata.Xattrs; the root of a folder can't have
+	// any.  This is a remote-sync operation.
+	SetXattr(ctx context.Context, file Node, name string, value []byte) error
+	// RemoveXattr removes the named extended attribute of the file
+	// or directory represented by a given node, returning
+	// NoSuchXattrError if it isn't set.  This is a remote-sync
+	// operation.
+	RemoveXattr(ctx context.Context, file Node, name string) error
 	// SyncAll flushes all outstanding writes and truncates for any
 	// dirty files to the KBFS servers within the given folder, if the
 	// logged-in user has write permissions to the top-level folder.
@@ -369,6 +435,13 @@ type KBFSOps interface {
 	// any, and fast-forwards to the current head of this
 	// folder-branch.
 	UnstageForTesting(ctx context.Context, folderBranch FolderBranch) error
+	// ForceConflictResolution kicks off a new conflict resolution
+	// attempt for this folder-branch if it is currently staged, even
+	// if CR has already failed for the current revisions, and waits
+	// for the attempt to finish.  It is a no-op on a merged
+	// folder-branch.
+	ForceConflictResolution(
+		ctx context.Context, folderBranch FolderBranch) error
 	// RequestRekey requests to rekey this folder. Note that this asynchronously
 	// requests a rekey, so canceling ctx doesn't cancel the rekey.
 	RequestRekey(ctx context.Context, id tlf.ID)
@@ -395,6 +468,28 @@ type KBFSOps interface {
 	// for the folder.
 	GetEditHistory(ctx context.Context, folderBranch FolderBranch) (
 		edits TlfWriterEdits, err error)
+	// GetEditHistoryRange returns all the file edits (including
+	// deletions) by each writer of the given folder, made in merged
+	// revisions with local timestamps between start and end
+	// (inclusive).  Unlike GetEditHistory, this fetches the full
+	// range from the server on every call, so it's meant for
+	// infrequent uses like audit reports.
+	GetEditHistoryRange(ctx context.Context, folderBranch FolderBranch,
+		start, end time.Time) (edits TlfWriterEdits, err error)
+	// GetAuditEvents returns the access-relevant events in the
+	// merged revisions of the given folder from `start` to the latest
+	// one, along with the latest revision.  Like GetEditHistoryRange,
+	// this fetches the full range from the server on every call.
+	GetAuditEvents(ctx context.Context, folderBranch FolderBranch,
+		start kbfsmd.Revision) (
+		events []AuditEvent, end kbfsmd.Revision, err error)
+	// GetFileEditHistory returns the edit notifications for the
+	// file or directory represented by the given node (including
+	// those from before it was renamed), most recent first.  Only
+	// the revisions this instance has seen since it started
+	// following the folder are included.
+	GetFileEditHistory(ctx context.Context, file Node) (
+		edits []kbfsedits.NotificationMessage, err error)
 
 	// GetNodeMetadata gets metadata associated with a Node.
 	GetNodeMetadata(ctx context.Context, node Node) (NodeMetadata, error)
@@ -501,6 +596,11 @@ type KeybaseService interface {
 	GetTeamSettings(ctx context.Context, teamID keybase1.TeamID) (
 		keybase1.KBFSTeamSettings, error)
 
+	// IsTeamAdmin returns true if the given user is an admin or an
+	// owner of the given team.
+	IsTeamAdmin(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (
+		bool, error)
+
 	// LoadUserPlusKeys returns a UserInfo struct for a
 	// user with the specified UID.
 	// If you have the UID for a user and don't require Identify to
@@ -656,6 +756,10 @@ type teamMembershipChecker interface {
 	// and readers.
 	ListResolvedTeamMembers(ctx context.Context, tid keybase1.TeamID) (
 		writers, readers []keybase1.UID, err error)
+	// IsTeamAdmin returns true if the given user is an admin or an
+	// owner of the given team.
+	IsTeamAdmin(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (
+		bool, error)
 }
 
 type teamKeysGetter interface {
@@ -1553,6 +1657,16 @@ type MDServer interface {
 	// essentially a no-op.
 	FastForwardBackoff()
 
+	// ConnectionStatus returns the current state of the connection
+	// to the MD server.
+	ConnectionStatus() ServerConnectionStatus
+
+	// ForceReconnect immediately starts a new connection attempt if
+	// the MD server is currently disconnected, skipping any pending
+	// reconnect backoff.  It does not wait for the new connection to
+	// be established.
+	ForceReconnect(ctx context.Context) error
+
 	// FindNextMD finds the serialized (and possibly encrypted) root
 	// metadata object from the leaf node of the second KBFS merkle
 	// tree to be produced after a given Keybase global merkle tree
@@ -1668,6 +1782,15 @@ type BlockServer interface {
 	// GetTeamQuotaInfo returns the quota for a team.
 	GetTeamQuotaInfo(ctx context.Context, tid keybase1.TeamID) (
 		info *kbfsblock.QuotaInfo, err error)
+
+	// ConnectionStatus returns the current state of the connection
+	// to the block server.
+	ConnectionStatus() ServerConnectionStatus
+
+	// ForceReconnect retries any failing connection to the block
+	// server immediately, skipping any pending reconnect backoff,
+	// and waits for the new connection to be established.
+	ForceReconnect(ctx context.Context) error
 }
 
 // blockServerLocal is the interface for BlockServer implementations
@@ -1680,6 +1803,35 @@ type blockServerLocal interface {
 		map[kbfsblock.ID]blockRefMap, error)
 }
 
+// blockServerBatcher is the interface for BlockServer
+// implementations that can get or put many blocks in a single round
+// trip.  It's kept separate from BlockServer so that wrappers which
+// override Get or Put aren't bypassed by callers that batch.
+type blockServerBatcher interface {
+	BlockServer
+	// BatchSupported returns whether GetBatch and PutBatch can
+	// currently be used; if not, callers should fall back to Get and
+	// Put.
+	BatchSupported() bool
+	// GetBatch is like Get, but for several blocks of the same
+	// TLF.  The returned error is non-nil only if the whole batch
+	// failed; otherwise, there is one result per requested block,
+	// in order, each with its own error.
+	GetBatch(ctx context.Context, tlfID tlf.ID, gets []kbfsblock.BatchRef) (
+		[]kbfsblock.BatchGetResult, error)
+	// PutBatch is like Put, but for several blocks of the same
+	// TLF.  The returned error is non-nil only if the whole batch
+	// failed; otherwise, there is one error per block put, in
+	// order.
+	PutBatch(ctx context.Context, tlfID tlf.ID, puts []kbfsblock.BatchPut) (
+		[]error, error)
+	// HasBlocks returns, for each given block reference of the TLF,
+	// whether the server already has the block with a live
+	// reference for that exact context.
+	HasBlocks(ctx context.Context, tlfID tlf.ID, refs []kbfsblock.BatchRef) (
+		[]bool, error)
+}
+
 // BlockSplitter decides when a file or directory block needs to be split
 type BlockSplitter interface {
 	// CopyUntilSplit copies data into the block until we reach the
@@ -1856,6 +2008,9 @@ type InitMode interface {
 	// ClientType indicates the type we should advertise to the
 	// Keybase service.
 	ClientType() keybase1.ClientType
+	// LocalHTTPServerEnabled indicates whether we should run a local
+	// HTTP server for serving KBFS content to the GUI and browsers.
+	LocalHTTPServerEnabled() bool
 }
 
 type initModeGetter interface {
@@ -1886,6 +2041,7 @@ type Config interface {
 	clockGetter
 	diskLimiterGetter
 	syncedTlfGetterSetter
+	tlfAliasGetterSetter
 	initModeGetter
 	Tracer
 	KBFSOps() KBFSOps
@@ -1928,10 +2084,25 @@ type Config interface {
 	SetClock(Clock)
 	ConflictRenamer() ConflictRenamer
 	SetConflictRenamer(ConflictRenamer)
+	// ConflictPolicy controls how conflict copies are named and
+	// where they are kept.
+	ConflictPolicy() ConflictPolicy
+	SetConflictPolicy(ConflictPolicy)
 	MetadataVersion() kbfsmd.MetadataVer
 	SetMetadataVersion(kbfsmd.MetadataVer)
 	DefaultBlockType() keybase1.BlockType
 	SetDefaultBlockType(blockType keybase1.BlockType)
+	// CompactDirEntries indicates whether newly-written directory
+	// blocks should use the compact entry encoding
+	// (CompactDirEntriesDataVer), which older clients can't read.
+	CompactDirEntries() bool
+	SetCompactDirEntries(bool)
+	blockHashTypeGetter
+	// SetBlockHashType sets the hash type for the IDs of new direct
+	// file blocks.  Any type other than the default one bumps those
+	// blocks' pointers to BLAKE2bHashDataVer, which older clients
+	// can't read.
+	SetBlockHashType(kbfshash.HashType)
 	RekeyQueue() RekeyQueue
 	SetRekeyQueue(RekeyQueue)
 	// ReqsBufSize indicates the number of read or write operations
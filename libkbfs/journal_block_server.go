@@ -18,7 +18,7 @@ type journalBlockServer struct {
 	enableAddBlockReference bool
 }
 
-var _ BlockServer = journalBlockServer{}
+var _ blockServerBatcher = journalBlockServer{}
 
 func (j journalBlockServer) getBlockFromJournal(
 	tlfID tlf.ID, id kbfsblock.ID) (
@@ -117,6 +117,96 @@ func (j journalBlockServer) Put(
 	return j.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
 }
 
+// BatchSupported implements the blockServerBatcher interface for
+// journalBlockServer.
+func (j journalBlockServer) BatchSupported() bool {
+	batcher, ok := j.BlockServer.(blockServerBatcher)
+	return ok && batcher.BatchSupported()
+}
+
+// GetBatch implements the blockServerBatcher interface for
+// journalBlockServer.  Blocks found in the journal are returned
+// directly, and only the rest are requested from the server.
+func (j journalBlockServer) GetBatch(
+	ctx context.Context, tlfID tlf.ID, gets []kbfsblock.BatchRef) (
+	results []kbfsblock.BatchGetResult, err error) {
+	j.jServer.log.LazyTrace(ctx, "jBServer: GetBatch %d blocks", len(gets))
+	defer func() {
+		j.jServer.deferLog.LazyTrace(ctx,
+			"jBServer: GetBatch %d blocks done (err=%v)", len(gets), err)
+	}()
+
+	batcher, ok := j.BlockServer.(blockServerBatcher)
+	if !ok {
+		return nil, errors.New("Delegate block server can't batch")
+	}
+
+	results = make([]kbfsblock.BatchGetResult, len(gets))
+	var serverGets []kbfsblock.BatchRef
+	var serverIndices []int
+	for i, get := range gets {
+		data, serverHalf, found, err := j.getBlockFromJournal(tlfID, get.ID)
+		switch {
+		case err != nil:
+			results[i].Err = err
+		case found:
+			results[i].Buf = data
+			results[i].ServerHalf = serverHalf
+		default:
+			serverGets = append(serverGets, get)
+			serverIndices = append(serverIndices, i)
+		}
+	}
+	if len(serverGets) == 0 {
+		return results, nil
+	}
+
+	serverResults, err := batcher.GetBatch(ctx, tlfID, serverGets)
+	if err != nil {
+		return nil, err
+	}
+	for i, res := range serverResults {
+		results[serverIndices[i]] = res
+	}
+	return results, nil
+}
+
+// PutBatch implements the blockServerBatcher interface for
+// journalBlockServer.  If the TLF is journaled, the blocks are put
+// into the journal one at a time, since that doesn't involve any
+// round trips.
+func (j journalBlockServer) PutBatch(
+	ctx context.Context, tlfID tlf.ID, puts []kbfsblock.BatchPut) (
+	errs []error, err error) {
+	if _, ok := j.jServer.getTLFJournal(tlfID, nil); ok {
+		errs = make([]error, len(puts))
+		for i, put := range puts {
+			errs[i] = j.Put(
+				ctx, tlfID, put.ID, put.Context, put.Buf, put.ServerHalf)
+		}
+		return errs, nil
+	}
+
+	batcher, ok := j.BlockServer.(blockServerBatcher)
+	if !ok {
+		return nil, errors.New("Delegate block server can't batch")
+	}
+	return batcher.PutBatch(ctx, tlfID, puts)
+}
+
+// HasBlocks implements the blockServerBatcher interface for
+// journalBlockServer.  It only reports blocks that have been flushed
+// to the server, not ones still waiting in the journal.
+func (j journalBlockServer) HasBlocks(
+	ctx context.Context, tlfID tlf.ID, refs []kbfsblock.BatchRef) (
+	exists []bool, err error) {
+	batcher, ok := j.BlockServer.(blockServerBatcher)
+	if !ok {
+		return nil, errors.New("Delegate block server can't batch")
+	}
+	return batcher.HasBlocks(ctx, tlfID, refs)
+}
+
 func (j journalBlockServer) AddBlockReference(
 	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
 	context kbfsblock.Context) (err error) {
@@ -140,6 +140,7 @@ type JournalServer struct {
 	dirtyOps            map[tlf.ID]uint
 	dirtyOpsDone        *sync.Cond
 	serverConfig        journalServerConfig
+	flushBatchPeriod    time.Duration
 }
 
 func makeJournalServer(
@@ -537,10 +538,32 @@ func (j *JournalServer) enableLocked(
 	if err != nil {
 		return nil, err
 	}
+	tj.setFlushBatchPeriod(j.flushBatchPeriod)
 
 	return tj, nil
 }
 
+// SetFlushBatchPeriod sets how long each TLF journal waits for more
+// writes before it starts flushing to the server, so that bursts of
+// writes are uploaded together.  Zero flushes right away.
+func (j *JournalServer) SetFlushBatchPeriod(
+	ctx context.Context, period time.Duration) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.log.CDebugf(ctx, "Setting the journal flush batch period to %s", period)
+	j.flushBatchPeriod = period
+	for _, tlfJournal := range j.tlfJournals {
+		tlfJournal.setFlushBatchPeriod(period)
+	}
+}
+
+// FlushBatchPeriod returns the period set by SetFlushBatchPeriod.
+func (j *JournalServer) FlushBatchPeriod() time.Duration {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.flushBatchPeriod
+}
+
 // Enable turns on the write journal for the given TLF.  If h is nil,
 // it will be attempted to be fetched from the remote MD server.
 func (j *JournalServer) Enable(ctx context.Context, tlfID tlf.ID,
@@ -699,6 +722,22 @@ func (j *JournalServer) WaitForCompleteFlush(
 	return nil
 }
 
+// BeginSingleOp puts the write journal for the given TLF into
+// single-op mode until the next call to FinishSingleOp, if it isn't
+// in that mode already, so that all the MDs written until then are
+// squashed into one before they're flushed.  It returns an error if
+// the journal isn't enabled for the TLF.
+func (j *JournalServer) BeginSingleOp(
+	ctx context.Context, tlfID tlf.ID) error {
+	j.log.CDebugf(ctx, "Beginning single op for %s", tlfID)
+	tlfJournal, ok := j.getTLFJournal(tlfID, nil)
+	if !ok {
+		return errors.Errorf("Journal not enabled for %s", tlfID)
+	}
+	tlfJournal.beginSingleOp(ctx)
+	return nil
+}
+
 // FinishSingleOp lets the write journal know that the application has
 // finished a single op, and then blocks until the write journal has
 // finished flushing everything.
@@ -770,6 +770,102 @@ func TestBasicCRFileConflict(t *testing.T) {
 	require.Equal(t, children1, children2)
 }
 
+// Tests that conflict copies are named and placed according to the
+// conflict policy.
+func TestCRFileConflictWithPolicy(t *testing.T) {
+	// simulate two users
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	policy := ConflictPolicy{
+		SuffixFormat: "-{user}-{date}",
+		Subdir:       ".conflicts",
+		MaxCopies:    1,
+	}
+	config2.SetConflictPolicy(policy)
+
+	clock, now := newTestClockAndTimeNow()
+	config2.SetClock(clock)
+
+	name := userName1.String() + "," + userName2.String()
+
+	// user1 creates a file in a shared dir, along with an old
+	// conflict copy of it.
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, tlf.Private)
+
+	kbfsOps1 := config1.KBFSOps()
+	dirA1, _, err := kbfsOps1.CreateDir(ctx, rootNode1, "a")
+	require.NoError(t, err)
+	fileB1, _, err := kbfsOps1.CreateFile(ctx, dirA1, "b.txt", false, NoExcl)
+	require.NoError(t, err)
+	conflicts1, _, err := kbfsOps1.CreateDir(ctx, dirA1, ".conflicts")
+	require.NoError(t, err)
+	_, _, err = kbfsOps1.CreateFile(
+		ctx, conflicts1, "b-u1-2000-01-01.txt", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	// look it up on user2
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, tlf.Private)
+
+	kbfsOps2 := config2.KBFSOps()
+	dirA2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	fileB2, _, err := kbfsOps2.Lookup(ctx, dirA2, "b.txt")
+	require.NoError(t, err)
+
+	// disable updates on user 2
+	c, err := DisableUpdatesForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = DisableCRForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// User 1 writes the file
+	err = kbfsOps1.Write(ctx, fileB1, []byte{1, 2, 3, 4, 5}, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, fileB1.GetFolderBranch())
+	require.NoError(t, err)
+
+	// User 2 makes a new different file
+	err = kbfsOps2.Write(ctx, fileB2, []byte{5, 4, 3, 2, 1}, 0)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, fileB2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// re-enable updates, and wait for CR to complete
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config2,
+		rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServer(ctx,
+		rootNode2.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	err = kbfsOps1.SyncFromServer(ctx,
+		rootNode1.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	// The conflict copy was moved into the subdirectory, replacing
+	// the older one there.
+	copyName := "b-u2-" + now.Format("2006-01-02") + ".txt"
+	require.Equal(t, copyName, WriterDeviceDateConflictRenamer{config2}.
+		ConflictRenameHelper(now, "u2", "dev1", "b.txt"))
+	children1, err := kbfsOps1.GetDirChildren(ctx, dirA1)
+	require.NoError(t, err)
+	require.Len(t, children1, 2)
+	require.Contains(t, children1, "b.txt")
+	require.Contains(t, children1, ".conflicts")
+	conflictChildren1, err := kbfsOps1.GetDirChildren(ctx, conflicts1)
+	require.NoError(t, err)
+	require.Len(t, conflictChildren1, 1)
+	require.Contains(t, conflictChildren1, copyName)
+}
+
 // Tests that two users can create the same file simultaneously, and
 // the unmerged user can write to it, and they will be merged into a
 // single file.
@@ -1769,3 +1865,103 @@ func TestUnmergedPutAfterCanceledUnmergedPut(t *testing.T) {
 		assert.True(t, ok)
 	}
 }
+
+// Tests that conflicting extended attribute changes on a file and a
+// directory are resolved in favor of the unmerged branch, without
+// making conflict copies.
+func TestCRXattrConflict(t *testing.T) {
+	// simulate two users
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := userName1.String() + "," + userName2.String()
+
+	// user1 creates a file in a shared dir
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, tlf.Private)
+
+	kbfsOps1 := config1.KBFSOps()
+	dirA1, _, err := kbfsOps1.CreateDir(ctx, rootNode1, "a")
+	require.NoError(t, err)
+	fileB1, _, err := kbfsOps1.CreateFile(ctx, dirA1, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.SetXattr(ctx, fileB1, "user.old", []byte("old"))
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	// look it up on user2
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, tlf.Private)
+
+	kbfsOps2 := config2.KBFSOps()
+	dirA2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	fileB2, _, err := kbfsOps2.Lookup(ctx, dirA2, "b")
+	require.NoError(t, err)
+
+	// disable updates on user 2
+	c, err := DisableUpdatesForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = DisableCRForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// Both users set the same attributes.
+	err = kbfsOps1.SetXattr(ctx, fileB1, "user.tag", []byte("merged"))
+	require.NoError(t, err)
+	err = kbfsOps1.SetXattr(ctx, dirA1, "user.tag", []byte("merged"))
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	err = kbfsOps2.SetXattr(ctx, fileB2, "user.tag", []byte("unmerged"))
+	require.NoError(t, err)
+	err = kbfsOps2.RemoveXattr(ctx, fileB2, "user.old")
+	require.NoError(t, err)
+	err = kbfsOps2.SetXattr(ctx, dirA2, "user.tag", []byte("unmerged"))
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// re-enable updates, and wait for CR to complete
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config2,
+		rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServer(ctx,
+		rootNode2.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	err = kbfsOps1.SyncFromServer(ctx,
+		rootNode1.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	// No conflict copies, and the unmerged attributes win.
+	expectedXattrs := map[string][]byte{"user.tag": []byte("unmerged")}
+	for _, kbfsOps := range []KBFSOps{kbfsOps1, kbfsOps2} {
+		rootNode := rootNode1
+		if kbfsOps == kbfsOps2 {
+			rootNode = rootNode2
+		}
+		rootChildren, err := kbfsOps.GetDirChildren(ctx, rootNode)
+		require.NoError(t, err)
+		require.Len(t, rootChildren, 1)
+		dirA, _, err := kbfsOps.Lookup(ctx, rootNode, "a")
+		require.NoError(t, err)
+		md, err := kbfsOps.GetNodeMetadata(ctx, dirA)
+		require.NoError(t, err)
+		require.Equal(t, expectedXattrs, md.Xattrs)
+
+		children, err := kbfsOps.GetDirChildren(ctx, dirA)
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+		fileB, _, err := kbfsOps.Lookup(ctx, dirA, "b")
+		require.NoError(t, err)
+		md, err = kbfsOps.GetNodeMetadata(ctx, fileB)
+		require.NoError(t, err)
+		require.Equal(t, expectedXattrs, md.Xattrs)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/keybase/client/go/protocol/chat1"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsedits"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
@@ -810,6 +811,26 @@ func (fs *KBFSOpsStandard) SetMtime(
 	return ops.SetMtime(ctx, file, mtime)
 }
 
+// SetXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) SetXattr(
+	ctx context.Context, file Node, name string, value []byte) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.SetXattr(ctx, file, name, value)
+}
+
+// RemoveXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RemoveXattr(
+	ctx context.Context, file Node, name string) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.RemoveXattr(ctx, file, name)
+}
+
 // SyncAll implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) SyncAll(
 	ctx context.Context, folderBranch FolderBranch) error {
@@ -903,6 +924,17 @@ func (fs *KBFSOpsStandard) UnstageForTesting(
 	return ops.UnstageForTesting(ctx, folderBranch)
 }
 
+// ForceConflictResolution implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) ForceConflictResolution(
+	ctx context.Context, folderBranch FolderBranch) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.ForceConflictResolution(ctx, folderBranch)
+}
+
 // RequestRekey implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) RequestRekey(ctx context.Context, id tlf.ID) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
@@ -944,6 +976,40 @@ func (fs *KBFSOpsStandard) GetEditHistory(ctx context.Context,
 	return ops.GetEditHistory(ctx, folderBranch)
 }
 
+// GetEditHistoryRange implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetEditHistoryRange(ctx context.Context,
+	folderBranch FolderBranch, start, end time.Time) (
+	edits TlfWriterEdits, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
+	return ops.GetEditHistoryRange(ctx, folderBranch, start, end)
+}
+
+// GetAuditEvents implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetAuditEvents(ctx context.Context,
+	folderBranch FolderBranch, start kbfsmd.Revision) (
+	events []AuditEvent, end kbfsmd.Revision, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
+	return ops.GetAuditEvents(ctx, folderBranch, start)
+}
+
+// GetFileEditHistory implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetFileEditHistory(ctx context.Context,
+	file Node) (edits []kbfsedits.NotificationMessage, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.GetFileEditHistory(ctx, file)
+}
+
 // GetNodeMetadata implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) GetNodeMetadata(ctx context.Context, node Node) (
 	NodeMetadata, error) {
@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/keybase/client/go/libkb"
@@ -24,9 +25,17 @@ type keybaseServiceOwner interface {
 	KeybaseService() KeybaseService
 }
 
+// kbpkiClientOwner is what a KBPKIClient needs from its
+// Config: the KeybaseService, plus the user's local team folder
+// aliases.
+type kbpkiClientOwner interface {
+	keybaseServiceOwner
+	tlfAliasGetter
+}
+
 // KBPKIClient uses a KeybaseService.
 type KBPKIClient struct {
-	serviceOwner keybaseServiceOwner
+	serviceOwner kbpkiClientOwner
 	log          logger.Logger
 }
 
@@ -34,7 +43,7 @@ var _ KBPKI = (*KBPKIClient)(nil)
 
 // NewKBPKIClient returns a new KBPKIClient with the given service.
 func NewKBPKIClient(
-	serviceOwner keybaseServiceOwner, log logger.Logger) *KBPKIClient {
+	serviceOwner kbpkiClientOwner, log logger.Logger) *KBPKIClient {
 	return &KBPKIClient{serviceOwner, log}
 }
 
@@ -48,6 +57,17 @@ func (k *KBPKIClient) GetCurrentSession(ctx context.Context) (
 // Resolve implements the KBPKI interface for KBPKIClient.
 func (k *KBPKIClient) Resolve(ctx context.Context, assertion string) (
 	libkb.NormalizedUsername, keybase1.UserOrTeamID, error) {
+	// Team folder aliases are resolved locally.  The resolved name
+	// won't match the alias, so callers will treat the alias as a
+	// non-canonical name for the real team folder.
+	const teamPrefix = "team:"
+	if strings.HasPrefix(assertion, teamPrefix) {
+		target, ok := k.serviceOwner.LookupTlfAlias(
+			strings.TrimPrefix(assertion, teamPrefix))
+		if ok {
+			assertion = teamPrefix + string(target)
+		}
+	}
 	return k.serviceOwner.KeybaseService().Resolve(ctx, assertion)
 }
 
@@ -326,6 +346,16 @@ func (k *KBPKIClient) ListResolvedTeamMembers(
 	return writers, readers, nil
 }
 
+// IsTeamAdmin implements the KBPKI interface for KBPKIClient.
+func (k *KBPKIClient) IsTeamAdmin(
+	ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (
+	bool, error) {
+	if uid.IsNil() {
+		return false, nil
+	}
+	return k.serviceOwner.KeybaseService().IsTeamAdmin(ctx, tid, uid)
+}
+
 // GetTeamRootID implements the KBPKI interface for KBPKIClient.
 func (k *KBPKIClient) GetTeamRootID(ctx context.Context, tid keybase1.TeamID) (
 	keybase1.TeamID, error) {
@@ -16,7 +16,9 @@ import (
 	"github.com/keybase/kbfs/kbfscodec"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
 
@@ -28,6 +30,11 @@ func (o keybaseServiceSelfOwner) KeybaseService() KeybaseService {
 	return o.service
 }
 
+func (o keybaseServiceSelfOwner) LookupTlfAlias(alias string) (
+	tlf.CanonicalName, bool) {
+	return "", false
+}
+
 func makeTestKBPKIClient(t *testing.T) (
 	client *KBPKIClient, currentUID keybase1.UID, users []LocalUser,
 	teams []TeamInfo) {
@@ -88,6 +95,38 @@ func TestKBPKIClientGetNormalizedUsername(t *testing.T) {
 	}
 }
 
+type keybaseServiceAliasOwner struct {
+	keybaseServiceSelfOwner
+	aliases *tlf.AliasTable
+}
+
+func (o keybaseServiceAliasOwner) LookupTlfAlias(alias string) (
+	tlf.CanonicalName, bool) {
+	return o.aliases.Lookup(alias)
+}
+
+func TestKBPKIClientResolveTeamAlias(t *testing.T) {
+	currentUID := keybase1.MakeTestUID(1)
+	users := MakeLocalUsers([]libkb.NormalizedUsername{"test_name1"})
+	teams := MakeLocalTeams([]libkb.NormalizedUsername{"test_team1"})
+	daemon := NewKeybaseDaemonMemory(
+		currentUID, users, teams, kbfscodec.NewMsgpack())
+	aliases := tlf.NewAliasTable()
+	err := aliases.Set("work", "test_team1")
+	require.NoError(t, err)
+	c := NewKBPKIClient(keybaseServiceAliasOwner{
+		keybaseServiceSelfOwner{daemon}, aliases}, logger.NewTestLogger(t))
+
+	name, id, err := c.Resolve(context.Background(), "team:work")
+	require.NoError(t, err)
+	require.Equal(t, libkb.NormalizedUsername("test_team1"), name)
+	require.Equal(t, teams[0].TID.AsUserOrTeam(), id)
+
+	// Aliases only apply to teams.
+	_, _, err = c.Resolve(context.Background(), "work")
+	require.Error(t, err)
+}
+
 func TestKBPKIClientHasVerifyingKey(t *testing.T) {
 	c, _, localUsers, _ := makeTestKBPKIClient(t)
 
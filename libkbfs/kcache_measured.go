@@ -14,10 +14,11 @@ import (
 // KeyCacheMeasured delegates to another KeyCache instance but
 // also keeps track of stats.
 type KeyCacheMeasured struct {
-	delegate      KeyCache
-	getTimer      metrics.Timer
-	putTimer      metrics.Timer
-	hitCountMeter metrics.Meter
+	delegate       KeyCache
+	getTimer       metrics.Timer
+	putTimer       metrics.Timer
+	hitCountMeter  metrics.Meter
+	missCountMeter metrics.Meter
 }
 
 var _ KeyCache = KeyCacheMeasured{}
@@ -27,18 +28,31 @@ var _ KeyCache = KeyCacheMeasured{}
 func NewKeyCacheMeasured(delegate KeyCache, r metrics.Registry) KeyCacheMeasured {
 	getTimer := metrics.GetOrRegisterTimer("KeyCache.GetTLFCryptKey", r)
 	putTimer := metrics.GetOrRegisterTimer("KeyCache.PutTLFCryptKey", r)
-	// TODO: Implement RatioGauge (
-	// http://metrics.dropwizard.io/3.1.0/manual/core/#ratio-gauges
-	// ) so we can actually display a hit ratio.
 	hitCountMeter := metrics.GetOrRegisterMeter("KeyCache.HitCount", r)
+	missCountMeter := metrics.GetOrRegisterMeter("KeyCache.MissCount", r)
+	metrics.NewRegisteredFunctionalGaugeFloat64("KeyCache.HitRate", r,
+		func() float64 {
+			return keyCacheHitRate(
+				hitCountMeter.Count(), missCountMeter.Count())
+		})
 	return KeyCacheMeasured{
-		delegate:      delegate,
-		getTimer:      getTimer,
-		putTimer:      putTimer,
-		hitCountMeter: hitCountMeter,
+		delegate:       delegate,
+		getTimer:       getTimer,
+		putTimer:       putTimer,
+		hitCountMeter:  hitCountMeter,
+		missCountMeter: missCountMeter,
 	}
 }
 
+// keyCacheHitRate returns the fraction of lookups that were hits, or
+// 0 if there haven't been any lookups.
+func keyCacheHitRate(hits, misses int64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
 // GetTLFCryptKey implements the KeyCache interface for
 // KeyCacheMeasured.
 func (b KeyCacheMeasured) GetTLFCryptKey(
@@ -46,8 +60,11 @@ func (b KeyCacheMeasured) GetTLFCryptKey(
 	b.getTimer.Time(func() {
 		key, err = b.delegate.GetTLFCryptKey(tlfID, keyGen)
 	})
-	if err == nil {
+	switch err.(type) {
+	case nil:
 		b.hitCountMeter.Mark(1)
+	case KeyCacheMissError:
+		b.missCountMeter.Mark(1)
 	}
 	return key, err
 }
@@ -179,7 +179,15 @@ func (km *KeyManagerStandard) getTLFCryptKey(ctx context.Context,
 			if err2 != nil {
 				return kbfscrypto.TLFCryptKey{}, err2
 			}
-			break
+			// Cache the latest key too, so that reading blocks
+			// from other historic generations doesn't have to
+			// unmask it (and fetch its server half) again.
+			if flags&getTLFCryptKeyDoCache != 0 {
+				err2 = kcache.PutTLFCryptKey(tlfID, currKeyGen, latestKey)
+				if err2 != nil {
+					return kbfscrypto.TLFCryptKey{}, err2
+				}
+			}
 		default:
 			return kbfscrypto.TLFCryptKey{}, err
 		}
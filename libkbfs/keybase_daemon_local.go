@@ -44,6 +44,8 @@ func (m localTeamMap) getLocalTeam(tid keybase1.TeamID) (TeamInfo, error) {
 
 type localTeamSettingsMap map[keybase1.TeamID]keybase1.KBFSTeamSettings
 
+type localTeamAdminMap map[keybase1.TeamID]map[keybase1.UID]bool
+
 type localImplicitTeamMap map[keybase1.TeamID]ImplicitTeamInfo
 
 func (m localImplicitTeamMap) getLocalImplicitTeam(
@@ -159,6 +161,7 @@ type KeybaseDaemonLocal struct {
 	localUsers         localUserMap
 	localTeams         localTeamMap
 	localTeamSettings  localTeamSettingsMap
+	localTeamAdmins    localTeamAdminMap
 	localImplicitTeams localImplicitTeamMap
 	currentUID         keybase1.UID
 	asserts            map[string]keybase1.UserOrTeamID
@@ -492,6 +495,23 @@ func (k *KeybaseDaemonLocal) GetTeamSettings(
 	return k.localTeamSettings[teamID], nil
 }
 
+// IsTeamAdmin implements the KeybaseService interface for
+// KeybaseDaemonLocal.
+func (k *KeybaseDaemonLocal) IsTeamAdmin(
+	ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (
+	bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if _, err := k.localTeams.getLocalTeam(tid); err != nil {
+		return false, err
+	}
+	return k.localTeamAdmins[tid][uid], nil
+}
+
 // GetCurrentMerkleRoot implements the KeybaseService interface for
 // KeybaseDaemonLocal.
 func (k *KeybaseDaemonLocal) GetCurrentMerkleRoot(ctx context.Context) (
@@ -734,6 +754,23 @@ func (k *KeybaseDaemonLocal) addTeamWriterForTest(
 	return nil
 }
 
+func (k *KeybaseDaemonLocal) addTeamAdminForTest(
+	tid keybase1.TeamID, uid keybase1.UID) error {
+	// Being an admin implies being a writer.
+	err := k.addTeamWriterForTest(tid, uid)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.localTeamAdmins[tid] == nil {
+		k.localTeamAdmins[tid] = make(map[keybase1.UID]bool)
+	}
+	k.localTeamAdmins[tid][uid] = true
+	return nil
+}
+
 func (k *KeybaseDaemonLocal) addTeamReaderForTest(
 	tid keybase1.TeamID, uid keybase1.UID) error {
 	k.lock.Lock()
@@ -920,6 +957,7 @@ func newKeybaseDaemonLocal(codec kbfscodec.Codec,
 		localUsers:         localUserMap,
 		localTeams:         make(localTeamMap),
 		localTeamSettings:  make(localTeamSettingsMap),
+		localTeamAdmins:    make(localTeamAdminMap),
 		localImplicitTeams: make(localImplicitTeamMap),
 		asserts:            asserts,
 		implicitAsserts:    make(map[string]keybase1.TeamID),
@@ -810,6 +810,36 @@ func (k *KeybaseServiceBase) GetTeamSettings(
 	return k.kbfsClient.GetKBFSTeamSettings(ctx, teamID)
 }
 
+// IsTeamAdmin implements the KeybaseService interface for
+// KeybaseServiceBase.
+func (k *KeybaseServiceBase) IsTeamAdmin(
+	ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (
+	bool, error) {
+	// Roles aren't part of the cached team info, so always ask the
+	// service for the current membership.
+	info, err := k.LoadTeamPlusKeys(
+		ctx, tid, kbfsmd.UnspecifiedKeyGen, keybase1.UserVersion{},
+		keybase1.TeamRole_NONE)
+	if err != nil {
+		return false, err
+	}
+	details, err := k.teamsClient.TeamGet(ctx, keybase1.TeamGetArg{
+		Name: string(info.Name),
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, members := range [][]keybase1.TeamMemberDetails{
+		details.Members.Owners, details.Members.Admins} {
+		for _, member := range members {
+			if member.Uv.Uid == uid {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 func (k *KeybaseServiceBase) getCurrentMerkleRoot(ctx context.Context) (
 	keybase1.MerkleRootV2, error) {
 	const merkleFreshnessMs = int(time.Second * 60 / time.Millisecond)
@@ -1108,6 +1138,8 @@ func (k *KeybaseServiceBase) FSEditListRequest(ctx context.Context,
 				nType = keybase1.FSNotificationType_FILE_CREATED
 			case FileModified:
 				nType = keybase1.FSNotificationType_FILE_MODIFIED
+			case FileDeleted:
+				nType = keybase1.FSNotificationType_FILE_DELETED
 			default:
 				k.log.CDebugf(ctx, "Bad notification type in edit history: %v",
 					edit.Type)
@@ -1120,6 +1152,13 @@ func (k *KeybaseServiceBase) FSEditListRequest(ctx context.Context,
 				WriterUid:        writer,
 				LocalTime:        keybase1.ToTime(edit.LocalTime),
 			}
+			if edit.Type == FileDeleted {
+				// Lets the GUI link to the file as of the revision
+				// before it was deleted.
+				n.Params = map[string]string{
+					errorParamDeletedRevision: edit.Revision.String(),
+				}
+			}
 			resp.Edits = append(resp.Edits, n)
 		}
 	}
@@ -25,6 +25,7 @@ type KeybaseServiceMeasured struct {
 	loadTeamPlusKeysTimer            metrics.Timer
 	createTeamTLFTimer               metrics.Timer
 	getTeamSettingsTimer             metrics.Timer
+	isTeamAdminTimer                 metrics.Timer
 	getCurrentMerkleRootTimer        metrics.Timer
 	verifyMerkleRootTimer            metrics.Timer
 	currentSessionTimer              metrics.Timer
@@ -50,6 +51,7 @@ func NewKeybaseServiceMeasured(delegate KeybaseService, r metrics.Registry) Keyb
 	loadTeamPlusKeysTimer := metrics.GetOrRegisterTimer("KeybaseService.LoadTeamPlusKeys", r)
 	createTeamTLFTimer := metrics.GetOrRegisterTimer("KeybaseService.CreateTeamTLF", r)
 	getTeamSettingsTimer := metrics.GetOrRegisterTimer("KeybaseService.GetTeamSettings", r)
+	isTeamAdminTimer := metrics.GetOrRegisterTimer("KeybaseService.IsTeamAdmin", r)
 	getCurrentMerkleRootTimer := metrics.GetOrRegisterTimer("KeybaseService.GetCurrentMerkleRoot", r)
 	verifyMerkleRootTimer := metrics.GetOrRegisterTimer("KeybaseService.VerifyMerkleRoot", r)
 	currentSessionTimer := metrics.GetOrRegisterTimer("KeybaseService.CurrentSession", r)
@@ -69,6 +71,7 @@ func NewKeybaseServiceMeasured(delegate KeybaseService, r metrics.Registry) Keyb
 		loadTeamPlusKeysTimer:            loadTeamPlusKeysTimer,
 		createTeamTLFTimer:               createTeamTLFTimer,
 		getTeamSettingsTimer:             getTeamSettingsTimer,
+		isTeamAdminTimer:                 isTeamAdminTimer,
 		getCurrentMerkleRootTimer:        getCurrentMerkleRootTimer,
 		verifyMerkleRootTimer:            verifyMerkleRootTimer,
 		currentSessionTimer:              currentSessionTimer,
@@ -161,6 +164,17 @@ func (k KeybaseServiceMeasured) GetTeamSettings(
 	return settings, err
 }
 
+// IsTeamAdmin implements the KeybaseService interface for
+// KeybaseServiceMeasured.
+func (k KeybaseServiceMeasured) IsTeamAdmin(
+	ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (
+	isAdmin bool, err error) {
+	k.isTeamAdminTimer.Time(func() {
+		isAdmin, err = k.delegate.IsTeamAdmin(ctx, tid, uid)
+	})
+	return isAdmin, err
+}
+
 // GetCurrentMerkleRoot implements the KeybaseService interface for
 // KeybaseServiceMeasured.
 func (k KeybaseServiceMeasured) GetCurrentMerkleRoot(ctx context.Context) (
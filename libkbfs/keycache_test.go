@@ -11,6 +11,8 @@ import (
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
 )
 
 func TestKeyCacheBasic(t *testing.T) {
@@ -57,3 +59,26 @@ func TestKeyCacheBasic(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyCacheMeasuredHitRate(t *testing.T) {
+	r := metrics.NewRegistry()
+	cache := NewKeyCacheMeasured(NewKeyCacheStandard(10), r)
+	hitRate := r.Get("KeyCache.HitRate").(metrics.GaugeFloat64)
+	require.Equal(t, float64(0), hitRate.Value())
+
+	id := tlf.FakeID(1, tlf.Private)
+	keyGen := kbfsmd.FirstValidKeyGen
+	_, err := cache.GetTLFCryptKey(id, keyGen)
+	require.IsType(t, KeyCacheMissError{}, err)
+	err = cache.PutTLFCryptKey(
+		id, keyGen, kbfscrypto.MakeTLFCryptKey([32]byte{0x1}))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = cache.GetTLFCryptKey(id, keyGen)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, int64(3), cache.hitCountMeter.Count())
+	require.Equal(t, int64(1), cache.missCountMeter.Count())
+	require.Equal(t, 0.75, hitRate.Value())
+}
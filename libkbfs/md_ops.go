@@ -26,11 +26,18 @@ import (
 type MDOpsStandard struct {
 	config Config
 	log    logger.Logger
+
+	merkleHeadsLock sync.Mutex
+	merkleHeads     map[tlf.ID]verifiedMerkleHead
 }
 
 // NewMDOpsStandard returns a new MDOpsStandard
 func NewMDOpsStandard(config Config) *MDOpsStandard {
-	return &MDOpsStandard{config, config.MakeLogger("")}
+	return &MDOpsStandard{
+		config:      config,
+		log:         config.MakeLogger(""),
+		merkleHeads: make(map[tlf.ID]verifiedMerkleHead),
+	}
 }
 
 // convertVerifyingKeyError gives a better error when the TLF was
@@ -335,6 +342,125 @@ func (md *MDOpsStandard) verifyKey(
 	return true, nil
 }
 
+// verifiedMerkleHead remembers the newest KBFS merkle leaf we've
+// verified for a TLF, so later head checks can pick up from there.
+type verifiedMerkleHead struct {
+	// rootSeqno is the global merkle root that included the leaf.
+	rootSeqno keybase1.Seqno
+	// leafRev is the revision found in the leaf.
+	leafRev kbfsmd.Revision
+	// checkedRev is the last head revision checked against the
+	// merkle tree.
+	checkedRev kbfsmd.Revision
+}
+
+func (md *MDOpsStandard) getVerifiedMerkleHead(
+	id tlf.ID) (verifiedMerkleHead, bool) {
+	md.merkleHeadsLock.Lock()
+	defer md.merkleHeadsLock.Unlock()
+	head, ok := md.merkleHeads[id]
+	return head, ok
+}
+
+func (md *MDOpsStandard) setVerifiedMerkleHead(
+	id tlf.ID, head verifiedMerkleHead) {
+	md.merkleHeadsLock.Lock()
+	defer md.merkleHeadsLock.Unlock()
+	// Never move backwards, in case of concurrent checks.
+	if old, ok := md.merkleHeads[id]; ok && old.rootSeqno > head.rootSeqno {
+		return
+	}
+	md.merkleHeads[id] = head
+}
+
+// checkMerkleHead makes sure the server isn't rolling back the given
+// merged head revision, by checking it against the next KBFS merkle
+// root published in the global Keybase merkle tree after the last one
+// we verified for this TLF (or, the first time, after the one the
+// head itself was written against).  If that merkle leaf contains a
+// newer revision than `irmd`, the server is hiding revisions from us.
+//
+// The server can only delay things for one merkle tree publishing
+// period this way, since each check starts where the last one left
+// off.
+func (md *MDOpsStandard) checkMerkleHead(
+	ctx context.Context, irmd ImmutableRootMetadata) error {
+	if irmd == (ImmutableRootMetadata{}) ||
+		ctx.Value(ctxMDOpsSkipKeyVerification) != nil {
+		return nil
+	}
+	id := irmd.TlfID()
+
+	cached, ok := md.getVerifiedMerkleHead(id)
+	if ok {
+		if irmd.Revision() < cached.leafRev {
+			return MDRollbackError{id, irmd.Revision(), cached.leafRev}
+		}
+		if irmd.Revision() == cached.checkedRev {
+			// Already checked this head.
+			return nil
+		}
+	}
+
+	rootSeqno := irmd.MerkleRoot().Seqno
+	if cached.rootSeqno > rootSeqno {
+		rootSeqno = cached.rootSeqno
+	}
+	if rootSeqno <= 0 {
+		md.log.CDebugf(ctx, "No merkle root seqno to check head %d of %s "+
+			"against", irmd.Revision(), id)
+		return nil
+	}
+	if id.Type() != tlf.Public && !irmd.IsReadable() {
+		// We won't be able to decrypt the leaf yet.
+		md.log.CDebugf(ctx, "Can't check unreadable head %d of %s against "+
+			"the merkle tree", irmd.Revision(), id)
+		return nil
+	}
+
+	ctx = context.WithValue(ctx, ctxMDOpsSkipKeyVerification, struct{}{})
+	kbfsRoot, merkleNodes, nextRootSeqno, err :=
+		md.config.MDServer().FindNextMD(ctx, id, rootSeqno)
+	if err != nil {
+		return err
+	}
+	if len(merkleNodes) == 0 {
+		// No KBFS merkle trees have been published since the last
+		// one we checked, so there's nothing newer to compare to.
+		md.log.CDebugf(ctx, "No merkle roots published for %s after "+
+			"global seqno %d", id, rootSeqno)
+		cached.checkedRev = irmd.Revision()
+		md.setVerifiedMerkleHead(id, cached)
+		return nil
+	}
+
+	// The FindNextMD call already validated the global root, and
+	// the fact that it contained the given KBFS root.
+	err = verifyMerkleNodes(ctx, kbfsRoot, merkleNodes, id)
+	if err != nil {
+		return err
+	}
+	leaf, err := md.makeMerkleLeaf(
+		ctx, irmd.ReadOnlyRootMetadata, kbfsRoot,
+		merkleNodes[len(merkleNodes)-1])
+	if err != nil {
+		return err
+	}
+
+	md.log.CDebugf(ctx, "Merkle root at global seqno %d contains revision "+
+		"%d of %s; server head is %d",
+		nextRootSeqno, leaf.Revision, id, irmd.Revision())
+	if irmd.Revision() < leaf.Revision {
+		return MDRollbackError{id, irmd.Revision(), leaf.Revision}
+	}
+	md.setVerifiedMerkleHead(id, verifiedMerkleHead{
+		rootSeqno:  nextRootSeqno,
+		leafRev:    leaf.Revision,
+		checkedRev: irmd.Revision(),
+	})
+	return nil
+}
+
 func (md *MDOpsStandard) verifyWriterKey(ctx context.Context,
 	rmds *RootMetadataSigned, irmd ImmutableRootMetadata, handle *TlfHandle,
 	getRangeLock *sync.Mutex) error {
@@ -654,6 +780,13 @@ func (md *MDOpsStandard) getForHandle(ctx context.Context, handle *TlfHandle,
 		return tlf.ID{}, ImmutableRootMetadata{}, err
 	}
 
+	if mStatus == kbfsmd.Merged {
+		err = md.checkMerkleHead(ctx, rmd)
+		if err != nil {
+			return tlf.ID{}, ImmutableRootMetadata{}, err
+		}
+	}
+
 	return id, rmd, nil
 }
 
@@ -748,6 +881,12 @@ func (md *MDOpsStandard) getForTLF(ctx context.Context, id tlf.ID,
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
+	if mStatus == kbfsmd.Merged {
+		err = md.checkMerkleHead(ctx, rmd)
+		if err != nil {
+			return ImmutableRootMetadata{}, err
+		}
+	}
 	return rmd, nil
 }
 
@@ -511,6 +511,33 @@ func testMDOpsGetSuccess(t *testing.T, ver kbfsmd.MetadataVer) {
 	require.Equal(t, expectedMD, rmd2.bareMd)
 }
 
+func testMDOpsGetMerkleRollbackFailure(
+	t *testing.T, ver kbfsmd.MetadataVer) {
+	mockCtrl, config, ctx := mdOpsInit(t, ver)
+	defer mdOpsShutdown(mockCtrl, config)
+
+	id := tlf.FakeID(1, tlf.Private)
+	h := parseTlfHandleOrBust(t, config, "alice,bob", tlf.Private, id)
+	rmds, extra := newRMDS(t, config, h)
+
+	verifyMDForPrivate(config, rmds)
+
+	config.mockMdserv.EXPECT().GetForTLF(ctx, rmds.MD.TlfID(), kbfsmd.NullBranchID,
+		kbfsmd.Merged, nil).Return(rmds, nil)
+	expectGetKeyBundles(ctx, config, extra)
+
+	// Pretend we've already verified that the merkle tree contains
+	// a newer revision than the one the server is returning.
+	mdOps := config.MDOps().(*MDOpsStandard)
+	mdOps.setVerifiedMerkleHead(id, verifiedMerkleHead{
+		rootSeqno: 10,
+		leafRev:   rmds.MD.RevisionNumber() + 1,
+	})
+
+	_, err := config.MDOps().GetForTLF(ctx, rmds.MD.TlfID(), nil)
+	require.IsType(t, MDRollbackError{}, err)
+}
+
 func testMDOpsGetBlankSigFailure(t *testing.T, ver kbfsmd.MetadataVer) {
 	mockCtrl, config, ctx := mdOpsInit(t, ver)
 	defer mdOpsShutdown(mockCtrl, config)
@@ -1291,6 +1318,7 @@ func TestMDOps(t *testing.T) {
 		testMDOpsGetIDForHandleFailGet,
 		testMDOpsGetIDForHandleFailHandleCheck,
 		testMDOpsGetSuccess,
+		testMDOpsGetMerkleRollbackFailure,
 		testMDOpsGetBlankSigFailure,
 		testMDOpsGetFailGet,
 		testMDOpsGetFailIDCheck,
@@ -0,0 +1,195 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// maxBufferedMDRangeChunks is the number of fetched chunks (of up to
+// maxMDsAtATime revisions each) that an mdRangeStream will hold
+// while waiting for the consumer, before it stops fetching more.
+const maxBufferedMDRangeChunks = 2
+
+type mdRangeChunk struct {
+	rmds []ImmutableRootMetadata
+	err  error
+}
+
+// mdRangeStream fetches a range of merged MD revisions from the
+// server in the background, and delivers them incrementally in
+// chunks of at most maxMDsAtATime revisions.  Fetching is throttled
+// by the consumer: at most maxBufferedMDRangeChunks chunks are ever
+// waiting to be read, so catching up on a long range of revisions
+// doesn't require holding all of them in memory at once.
+//
+// Unlike getMergedMDUpdatesWithEnd, the stream doesn't try to make
+// unreadable MDs readable; callers should check that themselves.
+type mdRangeStream struct {
+	chunks chan mdRangeChunk
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newMDRangeStream starts fetching the merged MDs for `id` between
+// `startRev` and `endRev` (inclusive), or until the end of the
+// merged history if `endRev` is kbfsmd.RevisionUninitialized.  The
+// caller must call `Close` on the returned stream once it's done
+// with it.
+func newMDRangeStream(ctx context.Context, config Config, id tlf.ID,
+	startRev, endRev kbfsmd.Revision,
+	lockBeforeGet *keybase1.LockID) *mdRangeStream {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &mdRangeStream{
+		chunks: make(chan mdRangeChunk, maxBufferedMDRangeChunks),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go s.fetch(ctx, config, id, startRev, endRev, lockBeforeGet)
+	return s
+}
+
+func (s *mdRangeStream) fetch(ctx context.Context, config Config,
+	id tlf.ID, startRev, endRev kbfsmd.Revision,
+	lockBeforeGet *keybase1.LockID) {
+	defer close(s.done)
+	defer close(s.chunks)
+
+	// We don't yet know about any revisions yet, so there's no range
+	// to get.
+	if startRev < kbfsmd.RevisionInitial {
+		return
+	}
+
+	var lastRmd ImmutableRootMetadata
+	start := startRev
+	for {
+		end := start + maxMDsAtATime - 1 // range is inclusive
+		if endRev != kbfsmd.RevisionUninitialized && end > endRev {
+			end = endRev
+		}
+		if end < start {
+			return
+		}
+		rmds, err := getMDRange(ctx, config, id, kbfsmd.NullBranchID,
+			start, end, kbfsmd.Merged, lockBeforeGet)
+		if err == nil && lastRmd != (ImmutableRootMetadata{}) &&
+			len(rmds) > 0 {
+			// Make sure the first new one is a valid successor of the
+			// last one.
+			err = lastRmd.CheckValidSuccessor(
+				lastRmd.mdID, rmds[0].ReadOnlyRootMetadata)
+		}
+		if err != nil {
+			select {
+			case s.chunks <- mdRangeChunk{err: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		if len(rmds) == 0 {
+			return
+		}
+
+		// This blocks once the consumer falls behind, which keeps
+		// us from fetching too far ahead.
+		select {
+		case s.chunks <- mdRangeChunk{rmds: rmds}:
+		case <-ctx.Done():
+			return
+		}
+
+		if len(rmds) < maxMDsAtATime {
+			return
+		}
+		lastRmd = rmds[len(rmds)-1]
+		start = end + 1
+	}
+}
+
+// Next returns the next chunk of MDs in the range, in revision
+// order.  It returns io.EOF once the whole range has been delivered.
+func (s *mdRangeStream) Next(ctx context.Context) (
+	[]ImmutableRootMetadata, error) {
+	select {
+	case chunk, ok := <-s.chunks:
+		if !ok {
+			return nil, io.EOF
+		}
+		return chunk.rmds, chunk.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops any outstanding fetches, and waits for the background
+// goroutine to exit.
+func (s *mdRangeStream) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// forEachMergedMDUpdateChunk is like getMergedMDUpdates, but calls
+// `fn` on each successive chunk of merged MDs as it arrives from the
+// server, rather than returning them all at once.  Fetching of later
+// chunks overlaps with `fn` processing earlier ones.  If `fn` returns
+// an error, no further chunks are processed.
+//
+// Because the key for an unreadable MD might only appear in a later
+// revision (after a rekey), as soon as one chunk contains an
+// unreadable MD, the remainder of the range is fetched in full via
+// getMergedMDUpdates, which knows how to decrypt them using the
+// latest revision.
+func forEachMergedMDUpdateChunk(ctx context.Context, config Config,
+	id tlf.ID, startRev kbfsmd.Revision, lockBeforeGet *keybase1.LockID,
+	fn func([]ImmutableRootMetadata) error) error {
+	s := newMDRangeStream(
+		ctx, config, id, startRev, kbfsmd.RevisionUninitialized,
+		lockBeforeGet)
+	defer s.Close()
+
+	var lastRmd ImmutableRootMetadata
+	for {
+		rmds, err := s.Next(ctx)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		for _, rmd := range rmds {
+			if isReadableOrError(ctx, config.KBPKI(), rmd.ReadOnly()) == nil {
+				continue
+			}
+			s.Close()
+			rmds, err = getMergedMDUpdates(
+				ctx, config, id, rmds[0].Revision(), lockBeforeGet)
+			if err != nil {
+				return err
+			}
+			// The stream already checked this chunk against the
+			// previous one, but these are fresh copies.
+			if lastRmd != (ImmutableRootMetadata{}) && len(rmds) > 0 {
+				err = lastRmd.CheckValidSuccessor(
+					lastRmd.mdID, rmds[0].ReadOnlyRootMetadata)
+				if err != nil {
+					return err
+				}
+			}
+			return fn(rmds)
+		}
+
+		err = fn(rmds)
+		if err != nil {
+			return err
+		}
+		lastRmd = rmds[len(rmds)-1]
+	}
+}
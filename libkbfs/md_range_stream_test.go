@@ -0,0 +1,102 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestMDRangeStream(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	// Make enough revisions to span several chunks.
+	numFiles := 2*maxMDsAtATime + 3
+	for i := 0; i < numFiles; i++ {
+		_, _, err := kbfsOps.CreateFile(
+			ctx, rootNode, fmt.Sprintf("f%d", i), false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		require.NoError(t, err)
+	}
+	tlfID := rootNode.GetFolderBranch().Tlf
+	config.ResetCaches()
+
+	s := newMDRangeStream(ctx, config, tlfID, kbfsmd.RevisionInitial,
+		kbfsmd.RevisionUninitialized, nil)
+	defer s.Close()
+	expectedRev := kbfsmd.RevisionInitial
+	for {
+		rmds, err := s.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.True(t, len(rmds) <= maxMDsAtATime)
+		for _, rmd := range rmds {
+			require.Equal(t, expectedRev, rmd.Revision())
+			expectedRev++
+		}
+	}
+	// The initial revision, plus one per file.
+	require.Equal(t, kbfsmd.RevisionInitial+kbfsmd.Revision(numFiles+1),
+		expectedRev)
+
+	// Closing a stream before it's drained shouldn't block.
+	s2 := newMDRangeStream(ctx, config, tlfID, kbfsmd.RevisionInitial,
+		kbfsmd.RevisionUninitialized, nil)
+	rmds, err := s2.Next(ctx)
+	require.NoError(t, err)
+	require.Len(t, rmds, maxMDsAtATime)
+	s2.Close()
+}
+
+func TestGetAndApplyMDUpdatesUnmergedWholeRange(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	numFiles := 2*maxMDsAtATime + 3
+	for i := 0; i < numFiles; i++ {
+		_, _, err := kbfsOps.CreateFile(
+			ctx, rootNode, fmt.Sprintf("f%d", i), false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		require.NoError(t, err)
+	}
+	headRev := kbfsmd.RevisionInitial + kbfsmd.Revision(numFiles)
+
+	// Pretend we've only seen the first revision, and are on an
+	// unmerged branch, so every chunk gets refused.
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	lState := makeFBOLockState()
+	func() {
+		ops.headLock.Lock(lState)
+		defer ops.headLock.Unlock(lState)
+		ops.latestMergedRevision = kbfsmd.RevisionInitial
+	}()
+	var lastRevs []kbfsmd.Revision
+	err := ops.getAndApplyMDUpdates(ctx, lState, nil,
+		func(_ context.Context, _ *lockState,
+			rmds []ImmutableRootMetadata) error {
+			lastRevs = append(lastRevs, rmds[len(rmds)-1].Revision())
+			return UnmergedError{}
+		})
+	require.IsType(t, UnmergedError{}, err)
+	// The first chunk, and then the last one once the whole range
+	// has been read.
+	require.Equal(t, []kbfsmd.Revision{
+		kbfsmd.RevisionInitial + maxMDsAtATime, headRev}, lastRevs)
+}
@@ -741,6 +741,16 @@ func (md *MDServerDisk) CheckReachability(ctx context.Context) {}
 // FastForwardBackoff implements the MDServer interface for MDServerDisk.
 func (md *MDServerDisk) FastForwardBackoff() {}
 
+// ConnectionStatus implements the MDServer interface for MDServerDisk.
+func (md *MDServerDisk) ConnectionStatus() ServerConnectionStatus {
+	return ServerConnectionStatus{IsConnected: md.IsConnected()}
+}
+
+// ForceReconnect implements the MDServer interface for MDServerDisk.
+func (md *MDServerDisk) ForceReconnect(ctx context.Context) error {
+	return nil
+}
+
 // FindNextMD implements the MDServer interface for MDServerDisk.
 func (md *MDServerDisk) FindNextMD(
 	ctx context.Context, tlfID tlf.ID, rootSeqno keybase1.Seqno) (
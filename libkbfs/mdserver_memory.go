@@ -1092,6 +1092,16 @@ func (md *MDServerMemory) CheckReachability(ctx context.Context) {}
 // FastForwardBackoff implements the MDServer interface for MDServerMemory.
 func (md *MDServerMemory) FastForwardBackoff() {}
 
+// ConnectionStatus implements the MDServer interface for MDServerMemory.
+func (md *MDServerMemory) ConnectionStatus() ServerConnectionStatus {
+	return ServerConnectionStatus{IsConnected: md.IsConnected()}
+}
+
+// ForceReconnect implements the MDServer interface for MDServerMemory.
+func (md *MDServerMemory) ForceReconnect(ctx context.Context) error {
+	return nil
+}
+
 // FindNextMD implements the MDServer interface for MDServerMemory.
 func (md *MDServerMemory) FindNextMD(
 	ctx context.Context, tlfID tlf.ID, rootSeqno keybase1.Seqno) (
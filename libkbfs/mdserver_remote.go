@@ -51,6 +51,8 @@ type MDServerRemote struct {
 	authenticatedMtx sync.RWMutex
 	isAuthenticated  bool
 
+	connTracker serverConnectionTracker
+
 	connMu sync.RWMutex
 	conn   *rpc.Connection
 	client keybase1.MetadataClient
@@ -66,6 +68,9 @@ type MDServerRemote struct {
 	rekeyCancel context.CancelFunc
 	rekeyTimer  *time.Timer
 
+	rekeyPeriodMu sync.Mutex // protects rekeyPeriod
+	rekeyPeriod   time.Duration
+
 	serverOffsetMu    sync.RWMutex
 	serverOffsetKnown bool
 	serverOffset      time.Duration
@@ -95,7 +100,9 @@ func NewMDServerRemote(config Config, srvRemote rpc.Remote,
 		deferLog:      traceLogger{deferLog},
 		mdSrvRemote:   srvRemote,
 		rpcLogFactory: rpcLogFactory,
-		rekeyTimer:    time.NewTimer(nextRekeyTime()),
+		rekeyTimer:    time.NewTimer(nextRekeyTime(MdServerBackgroundRekeyPeriod)),
+		rekeyPeriod:   MdServerBackgroundRekeyPeriod,
+		connTracker:   serverConnectionTracker{clock: config.Clock()},
 	}
 
 	mdServer.pinger = pinger{
@@ -218,6 +225,7 @@ func (md *MDServerRemote) OnConnect(ctx context.Context,
 		return err
 	}
 
+	md.connTracker.onConnect()
 	md.config.KBFSOps().PushConnectionStatusChange(MDServiceName, nil)
 
 	// start pinging
@@ -383,6 +391,7 @@ func (md *MDServerRemote) OnConnectError(err error, wait time.Duration) {
 		md.authToken.Shutdown()
 	}
 
+	md.connTracker.onConnectError(err, wait)
 	md.config.KBFSOps().PushConnectionStatusChange(MDServiceName, err)
 }
 
@@ -1234,6 +1243,23 @@ func (md *MDServerRemote) FastForwardBackoff() {
 	md.conn.FastForwardInitialBackoffTimer()
 }
 
+// ConnectionStatus implements the MDServer interface for MDServerRemote.
+func (md *MDServerRemote) ConnectionStatus() ServerConnectionStatus {
+	return md.connTracker.status(md.IsConnected())
+}
+
+// ForceReconnect implements the MDServer interface for MDServerRemote.
+func (md *MDServerRemote) ForceReconnect(ctx context.Context) error {
+	if md.IsConnected() {
+		return nil
+	}
+	md.log.CDebugf(ctx, "MDServerRemote: forcing a new connection attempt")
+	// Replacing the connection cancels any reconnect loop that is
+	// waiting out a backoff, and immediately starts a new attempt.
+	md.initNewConnection()
+	return nil
+}
+
 // FindNextMD implements the MDServer interface for MDServerRemote.
 func (md *MDServerRemote) FindNextMD(
 	ctx context.Context, tlfID tlf.ID, rootSeqno keybase1.Seqno) (
@@ -1296,22 +1322,37 @@ func (md *MDServerRemote) FindNextMD(
 }
 
 func (md *MDServerRemote) resetRekeyTimer() {
-	md.rekeyTimer.Reset(nextRekeyTime())
+	md.rekeyPeriodMu.Lock()
+	period := md.rekeyPeriod
+	md.rekeyPeriodMu.Unlock()
+	md.rekeyTimer.Reset(nextRekeyTime(period))
+}
+
+// setBackgroundRekeyPeriod changes how long the background rekey
+// checker waits between runs on average, starting with the current
+// wait.
+func (md *MDServerRemote) setBackgroundRekeyPeriod(period time.Duration) {
+	md.rekeyPeriodMu.Lock()
+	md.rekeyPeriod = period
+	md.rekeyPeriodMu.Unlock()
+	if md.squelchRekey {
+		return
+	}
+	md.resetRekeyTimer()
 }
 
 // nextRekeyTime returns the time remaining to the next rekey.
 // The time returned is random with the formula:
-// MdServerBackgroundRekeyPeriod/2 + (k * (MdServerBackgroundRekeyPeriod/n))
-// average: MdServerBackgroundRekeyPeriod
-// minimum: MdServerBackgroundRekeyPeriod/2
-// maximum: MdServerBackgroundRekeyPeriod*1.5
+// period/2 + (k * (period/n))
+// average: period
+// minimum: period/2
+// maximum: period*1.5
 // k=0..n, random uniformly distributed.
-func nextRekeyTime() time.Duration {
+func nextRekeyTime(period time.Duration) time.Duration {
 	var buf [1]byte
 	err := kbfscrypto.RandRead(buf[:])
 	if err != nil {
 		panic("nextRekeyTime: Random source broken!")
 	}
-	return (MdServerBackgroundRekeyPeriod / 2) +
-		(time.Duration(buf[0]) * (MdServerBackgroundRekeyPeriod / 0xFF))
+	return (period / 2) + (time.Duration(buf[0]) * (period / 0xFF))
 }
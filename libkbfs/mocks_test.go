@@ -13,10 +13,14 @@ import (
 	kbfsblock "github.com/keybase/kbfs/kbfsblock"
 	kbfscodec "github.com/keybase/kbfs/kbfscodec"
 	kbfscrypto "github.com/keybase/kbfs/kbfscrypto"
+	kbfsedits "github.com/keybase/kbfs/kbfsedits"
+	kbfshash "github.com/keybase/kbfs/kbfshash"
 	kbfsmd "github.com/keybase/kbfs/kbfsmd"
 	tlf "github.com/keybase/kbfs/tlf"
 	go_metrics "github.com/rcrowley/go-metrics"
 	context "golang.org/x/net/context"
+	io "io"
+	os "os"
 	reflect "reflect"
 	time "time"
 )
@@ -441,6 +445,41 @@ func (mr *MockdiskBlockCacheGetterMockRecorder) DiskBlockCache() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DiskBlockCache", reflect.TypeOf((*MockdiskBlockCacheGetter)(nil).DiskBlockCache))
 }
 
+// MockblockHashTypeGetter is a mock of blockHashTypeGetter interface
+type MockblockHashTypeGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockblockHashTypeGetterMockRecorder
+}
+
+// MockblockHashTypeGetterMockRecorder is the mock recorder for MockblockHashTypeGetter
+type MockblockHashTypeGetterMockRecorder struct {
+	mock *MockblockHashTypeGetter
+}
+
+// NewMockblockHashTypeGetter creates a new mock instance
+func NewMockblockHashTypeGetter(ctrl *gomock.Controller) *MockblockHashTypeGetter {
+	mock := &MockblockHashTypeGetter{ctrl: ctrl}
+	mock.recorder = &MockblockHashTypeGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockblockHashTypeGetter) EXPECT() *MockblockHashTypeGetterMockRecorder {
+	return m.recorder
+}
+
+// BlockHashType mocks base method
+func (m *MockblockHashTypeGetter) BlockHashType() kbfshash.HashType {
+	ret := m.ctrl.Call(m, "BlockHashType")
+	ret0, _ := ret[0].(kbfshash.HashType)
+	return ret0
+}
+
+// BlockHashType indicates an expected call of BlockHashType
+func (mr *MockblockHashTypeGetterMockRecorder) BlockHashType() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockHashType", reflect.TypeOf((*MockblockHashTypeGetter)(nil).BlockHashType))
+}
+
 // MockdiskBlockCacheSetter is a mock of diskBlockCacheSetter interface
 type MockdiskBlockCacheSetter struct {
 	ctrl     *gomock.Controller
@@ -889,6 +928,92 @@ func (mr *MockNodeMockRecorder) Unwrap() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unwrap", reflect.TypeOf((*MockNode)(nil).Unwrap))
 }
 
+// GetFS mocks base method
+func (m *MockNode) GetFS(ctx context.Context) NodeFSReadOnly {
+	ret := m.ctrl.Call(m, "GetFS", ctx)
+	ret0, _ := ret[0].(NodeFSReadOnly)
+	return ret0
+}
+
+// GetFS indicates an expected call of GetFS
+func (mr *MockNodeMockRecorder) GetFS(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFS", reflect.TypeOf((*MockNode)(nil).GetFS), ctx)
+}
+
+// GetFile mocks base method
+func (m *MockNode) GetFile(ctx context.Context) io.ReaderAt {
+	ret := m.ctrl.Call(m, "GetFile", ctx)
+	ret0, _ := ret[0].(io.ReaderAt)
+	return ret0
+}
+
+// GetFile indicates an expected call of GetFile
+func (mr *MockNodeMockRecorder) GetFile(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFile", reflect.TypeOf((*MockNode)(nil).GetFile), ctx)
+}
+
+// MockNodeFSReadOnly is a mock of NodeFSReadOnly interface
+type MockNodeFSReadOnly struct {
+	ctrl     *gomock.Controller
+	recorder *MockNodeFSReadOnlyMockRecorder
+}
+
+// MockNodeFSReadOnlyMockRecorder is the mock recorder for MockNodeFSReadOnly
+type MockNodeFSReadOnlyMockRecorder struct {
+	mock *MockNodeFSReadOnly
+}
+
+// NewMockNodeFSReadOnly creates a new mock instance
+func NewMockNodeFSReadOnly(ctrl *gomock.Controller) *MockNodeFSReadOnly {
+	mock := &MockNodeFSReadOnly{ctrl: ctrl}
+	mock.recorder = &MockNodeFSReadOnlyMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockNodeFSReadOnly) EXPECT() *MockNodeFSReadOnlyMockRecorder {
+	return m.recorder
+}
+
+// Lstat mocks base method
+func (m *MockNodeFSReadOnly) Lstat(name string) (os.FileInfo, error) {
+	ret := m.ctrl.Call(m, "Lstat", name)
+	ret0, _ := ret[0].(os.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Lstat indicates an expected call of Lstat
+func (mr *MockNodeFSReadOnlyMockRecorder) Lstat(name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lstat", reflect.TypeOf((*MockNodeFSReadOnly)(nil).Lstat), name)
+}
+
+// ReadDir mocks base method
+func (m *MockNodeFSReadOnly) ReadDir(name string) ([]os.FileInfo, error) {
+	ret := m.ctrl.Call(m, "ReadDir", name)
+	ret0, _ := ret[0].([]os.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadDir indicates an expected call of ReadDir
+func (mr *MockNodeFSReadOnlyMockRecorder) ReadDir(name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadDir", reflect.TypeOf((*MockNodeFSReadOnly)(nil).ReadDir), name)
+}
+
+// Readlink mocks base method
+func (m *MockNodeFSReadOnly) Readlink(name string) (string, error) {
+	ret := m.ctrl.Call(m, "Readlink", name)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Readlink indicates an expected call of Readlink
+func (mr *MockNodeFSReadOnlyMockRecorder) Readlink(name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Readlink", reflect.TypeOf((*MockNodeFSReadOnly)(nil).Readlink), name)
+}
+
 // MockKBFSOps is a mock of KBFSOps interface
 type MockKBFSOps struct {
 	ctrl     *gomock.Controller
@@ -1205,6 +1330,30 @@ func (mr *MockKBFSOpsMockRecorder) SetMtime(ctx, file, mtime interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMtime", reflect.TypeOf((*MockKBFSOps)(nil).SetMtime), ctx, file, mtime)
 }
 
+// SetXattr mocks base method
+func (m *MockKBFSOps) SetXattr(ctx context.Context, file Node, name string, value []byte) error {
+	ret := m.ctrl.Call(m, "SetXattr", ctx, file, name, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetXattr indicates an expected call of SetXattr
+func (mr *MockKBFSOpsMockRecorder) SetXattr(ctx, file, name, value interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetXattr", reflect.TypeOf((*MockKBFSOps)(nil).SetXattr), ctx, file, name, value)
+}
+
+// RemoveXattr mocks base method
+func (m *MockKBFSOps) RemoveXattr(ctx context.Context, file Node, name string) error {
+	ret := m.ctrl.Call(m, "RemoveXattr", ctx, file, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveXattr indicates an expected call of RemoveXattr
+func (mr *MockKBFSOpsMockRecorder) RemoveXattr(ctx, file, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveXattr", reflect.TypeOf((*MockKBFSOps)(nil).RemoveXattr), ctx, file, name)
+}
+
 // SyncAll mocks base method
 func (m *MockKBFSOps) SyncAll(ctx context.Context, folderBranch FolderBranch) error {
 	ret := m.ctrl.Call(m, "SyncAll", ctx, folderBranch)
@@ -1257,6 +1406,18 @@ func (mr *MockKBFSOpsMockRecorder) UnstageForTesting(ctx, folderBranch interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnstageForTesting", reflect.TypeOf((*MockKBFSOps)(nil).UnstageForTesting), ctx, folderBranch)
 }
 
+// ForceConflictResolution mocks base method
+func (m *MockKBFSOps) ForceConflictResolution(ctx context.Context, folderBranch FolderBranch) error {
+	ret := m.ctrl.Call(m, "ForceConflictResolution", ctx, folderBranch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceConflictResolution indicates an expected call of ForceConflictResolution
+func (mr *MockKBFSOpsMockRecorder) ForceConflictResolution(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceConflictResolution", reflect.TypeOf((*MockKBFSOps)(nil).ForceConflictResolution), ctx, folderBranch)
+}
+
 // RequestRekey mocks base method
 func (m *MockKBFSOps) RequestRekey(ctx context.Context, id tlf.ID) {
 	m.ctrl.Call(m, "RequestRekey", ctx, id)
@@ -1305,6 +1466,46 @@ func (mr *MockKBFSOpsMockRecorder) GetEditHistory(ctx, folderBranch interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEditHistory", reflect.TypeOf((*MockKBFSOps)(nil).GetEditHistory), ctx, folderBranch)
 }
 
+// GetEditHistoryRange mocks base method
+func (m *MockKBFSOps) GetEditHistoryRange(ctx context.Context, folderBranch FolderBranch, start time.Time, end time.Time) (TlfWriterEdits, error) {
+	ret := m.ctrl.Call(m, "GetEditHistoryRange", ctx, folderBranch, start, end)
+	ret0, _ := ret[0].(TlfWriterEdits)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEditHistoryRange indicates an expected call of GetEditHistoryRange
+func (mr *MockKBFSOpsMockRecorder) GetEditHistoryRange(ctx, folderBranch, start, end interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEditHistoryRange", reflect.TypeOf((*MockKBFSOps)(nil).GetEditHistoryRange), ctx, folderBranch, start, end)
+}
+
+// GetAuditEvents mocks base method
+func (m *MockKBFSOps) GetAuditEvents(ctx context.Context, folderBranch FolderBranch, start kbfsmd.Revision) ([]AuditEvent, kbfsmd.Revision, error) {
+	ret := m.ctrl.Call(m, "GetAuditEvents", ctx, folderBranch, start)
+	ret0, _ := ret[0].([]AuditEvent)
+	ret1, _ := ret[1].(kbfsmd.Revision)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetAuditEvents indicates an expected call of GetAuditEvents
+func (mr *MockKBFSOpsMockRecorder) GetAuditEvents(ctx, folderBranch, start interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuditEvents", reflect.TypeOf((*MockKBFSOps)(nil).GetAuditEvents), ctx, folderBranch, start)
+}
+
+// GetFileEditHistory mocks base method
+func (m *MockKBFSOps) GetFileEditHistory(ctx context.Context, file Node) ([]kbfsedits.NotificationMessage, error) {
+	ret := m.ctrl.Call(m, "GetFileEditHistory", ctx, file)
+	ret0, _ := ret[0].([]kbfsedits.NotificationMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFileEditHistory indicates an expected call of GetFileEditHistory
+func (mr *MockKBFSOpsMockRecorder) GetFileEditHistory(ctx, file interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFileEditHistory", reflect.TypeOf((*MockKBFSOps)(nil).GetFileEditHistory), ctx, file)
+}
+
 // GetNodeMetadata mocks base method
 func (m *MockKBFSOps) GetNodeMetadata(ctx context.Context, node Node) (NodeMetadata, error) {
 	ret := m.ctrl.Call(m, "GetNodeMetadata", ctx, node)
@@ -1646,6 +1847,19 @@ func (mr *MockKeybaseServiceMockRecorder) GetTeamSettings(ctx, teamID interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTeamSettings", reflect.TypeOf((*MockKeybaseService)(nil).GetTeamSettings), ctx, teamID)
 }
 
+// IsTeamAdmin mocks base method
+func (m *MockKeybaseService) IsTeamAdmin(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (bool, error) {
+	ret := m.ctrl.Call(m, "IsTeamAdmin", ctx, tid, uid)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTeamAdmin indicates an expected call of IsTeamAdmin
+func (mr *MockKeybaseServiceMockRecorder) IsTeamAdmin(ctx, tid, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTeamAdmin", reflect.TypeOf((*MockKeybaseService)(nil).IsTeamAdmin), ctx, tid, uid)
+}
+
 // LoadUserPlusKeys mocks base method
 func (m *MockKeybaseService) LoadUserPlusKeys(ctx context.Context, uid keybase1.UID, pollForKID keybase1.KID) (UserInfo, error) {
 	ret := m.ctrl.Call(m, "LoadUserPlusKeys", ctx, uid, pollForKID)
@@ -2102,6 +2316,19 @@ func (mr *MockteamMembershipCheckerMockRecorder) ListResolvedTeamMembers(ctx, ti
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListResolvedTeamMembers", reflect.TypeOf((*MockteamMembershipChecker)(nil).ListResolvedTeamMembers), ctx, tid)
 }
 
+// IsTeamAdmin mocks base method
+func (m *MockteamMembershipChecker) IsTeamAdmin(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (bool, error) {
+	ret := m.ctrl.Call(m, "IsTeamAdmin", ctx, tid, uid)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTeamAdmin indicates an expected call of IsTeamAdmin
+func (mr *MockteamMembershipCheckerMockRecorder) IsTeamAdmin(ctx, tid, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTeamAdmin", reflect.TypeOf((*MockteamMembershipChecker)(nil).IsTeamAdmin), ctx, tid, uid)
+}
+
 // MockteamKeysGetter is a mock of teamKeysGetter interface
 type MockteamKeysGetter struct {
 	ctrl     *gomock.Controller
@@ -2369,6 +2596,19 @@ func (mr *MockKBPKIMockRecorder) ListResolvedTeamMembers(ctx, tid interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListResolvedTeamMembers", reflect.TypeOf((*MockKBPKI)(nil).ListResolvedTeamMembers), ctx, tid)
 }
 
+// IsTeamAdmin mocks base method
+func (m *MockKBPKI) IsTeamAdmin(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (bool, error) {
+	ret := m.ctrl.Call(m, "IsTeamAdmin", ctx, tid, uid)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTeamAdmin indicates an expected call of IsTeamAdmin
+func (mr *MockKBPKIMockRecorder) IsTeamAdmin(ctx, tid, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTeamAdmin", reflect.TypeOf((*MockKBPKI)(nil).IsTeamAdmin), ctx, tid, uid)
+}
+
 // GetTeamTLFCryptKeys mocks base method
 func (m *MockKBPKI) GetTeamTLFCryptKeys(ctx context.Context, tid keybase1.TeamID, desiredKeyGen kbfsmd.KeyGen) (map[kbfsmd.KeyGen]kbfscrypto.TLFCryptKey, kbfsmd.KeyGen, error) {
 	ret := m.ctrl.Call(m, "GetTeamTLFCryptKeys", ctx, tid, desiredKeyGen)
@@ -4752,6 +4992,30 @@ func (mr *MockMDServerMockRecorder) FastForwardBackoff() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FastForwardBackoff", reflect.TypeOf((*MockMDServer)(nil).FastForwardBackoff))
 }
 
+// ConnectionStatus mocks base method
+func (m *MockMDServer) ConnectionStatus() ServerConnectionStatus {
+	ret := m.ctrl.Call(m, "ConnectionStatus")
+	ret0, _ := ret[0].(ServerConnectionStatus)
+	return ret0
+}
+
+// ConnectionStatus indicates an expected call of ConnectionStatus
+func (mr *MockMDServerMockRecorder) ConnectionStatus() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionStatus", reflect.TypeOf((*MockMDServer)(nil).ConnectionStatus))
+}
+
+// ForceReconnect mocks base method
+func (m *MockMDServer) ForceReconnect(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "ForceReconnect", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceReconnect indicates an expected call of ForceReconnect
+func (mr *MockMDServerMockRecorder) ForceReconnect(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceReconnect", reflect.TypeOf((*MockMDServer)(nil).ForceReconnect), ctx)
+}
+
 // FindNextMD mocks base method
 func (m *MockMDServer) FindNextMD(ctx context.Context, tlfID tlf.ID, rootSeqno keybase1.Seqno) (*kbfsmd.MerkleRoot, [][]byte, keybase1.Seqno, error) {
 	ret := m.ctrl.Call(m, "FindNextMD", ctx, tlfID, rootSeqno)
@@ -5053,6 +5317,30 @@ func (mr *MockmdServerLocalMockRecorder) FastForwardBackoff() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FastForwardBackoff", reflect.TypeOf((*MockmdServerLocal)(nil).FastForwardBackoff))
 }
 
+// ConnectionStatus mocks base method
+func (m *MockmdServerLocal) ConnectionStatus() ServerConnectionStatus {
+	ret := m.ctrl.Call(m, "ConnectionStatus")
+	ret0, _ := ret[0].(ServerConnectionStatus)
+	return ret0
+}
+
+// ConnectionStatus indicates an expected call of ConnectionStatus
+func (mr *MockmdServerLocalMockRecorder) ConnectionStatus() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionStatus", reflect.TypeOf((*MockmdServerLocal)(nil).ConnectionStatus))
+}
+
+// ForceReconnect mocks base method
+func (m *MockmdServerLocal) ForceReconnect(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "ForceReconnect", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceReconnect indicates an expected call of ForceReconnect
+func (mr *MockmdServerLocalMockRecorder) ForceReconnect(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceReconnect", reflect.TypeOf((*MockmdServerLocal)(nil).ForceReconnect), ctx)
+}
+
 // FindNextMD mocks base method
 func (m *MockmdServerLocal) FindNextMD(ctx context.Context, tlfID tlf.ID, rootSeqno keybase1.Seqno) (*kbfsmd.MerkleRoot, [][]byte, keybase1.Seqno, error) {
 	ret := m.ctrl.Call(m, "FindNextMD", ctx, tlfID, rootSeqno)
@@ -5284,6 +5572,30 @@ func (mr *MockBlockServerMockRecorder) GetTeamQuotaInfo(ctx, tid interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTeamQuotaInfo", reflect.TypeOf((*MockBlockServer)(nil).GetTeamQuotaInfo), ctx, tid)
 }
 
+// ConnectionStatus mocks base method
+func (m *MockBlockServer) ConnectionStatus() ServerConnectionStatus {
+	ret := m.ctrl.Call(m, "ConnectionStatus")
+	ret0, _ := ret[0].(ServerConnectionStatus)
+	return ret0
+}
+
+// ConnectionStatus indicates an expected call of ConnectionStatus
+func (mr *MockBlockServerMockRecorder) ConnectionStatus() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionStatus", reflect.TypeOf((*MockBlockServer)(nil).ConnectionStatus))
+}
+
+// ForceReconnect mocks base method
+func (m *MockBlockServer) ForceReconnect(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "ForceReconnect", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceReconnect indicates an expected call of ForceReconnect
+func (mr *MockBlockServerMockRecorder) ForceReconnect(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceReconnect", reflect.TypeOf((*MockBlockServer)(nil).ForceReconnect), ctx)
+}
+
 // MockblockServerLocal is a mock of blockServerLocal interface
 type MockblockServerLocal struct {
 	ctrl     *gomock.Controller
@@ -5441,6 +5753,30 @@ func (mr *MockblockServerLocalMockRecorder) GetTeamQuotaInfo(ctx, tid interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTeamQuotaInfo", reflect.TypeOf((*MockblockServerLocal)(nil).GetTeamQuotaInfo), ctx, tid)
 }
 
+// ConnectionStatus mocks base method
+func (m *MockblockServerLocal) ConnectionStatus() ServerConnectionStatus {
+	ret := m.ctrl.Call(m, "ConnectionStatus")
+	ret0, _ := ret[0].(ServerConnectionStatus)
+	return ret0
+}
+
+// ConnectionStatus indicates an expected call of ConnectionStatus
+func (mr *MockblockServerLocalMockRecorder) ConnectionStatus() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionStatus", reflect.TypeOf((*MockblockServerLocal)(nil).ConnectionStatus))
+}
+
+// ForceReconnect mocks base method
+func (m *MockblockServerLocal) ForceReconnect(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "ForceReconnect", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceReconnect indicates an expected call of ForceReconnect
+func (mr *MockblockServerLocalMockRecorder) ForceReconnect(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceReconnect", reflect.TypeOf((*MockblockServerLocal)(nil).ForceReconnect), ctx)
+}
+
 // getAllRefsForTest mocks base method
 func (m *MockblockServerLocal) getAllRefsForTest(ctx context.Context, tlfID tlf.ID) (map[kbfsblock.ID]blockRefMap, error) {
 	ret := m.ctrl.Call(m, "getAllRefsForTest", ctx, tlfID)
@@ -6062,6 +6398,18 @@ func (mr *MockInitModeMockRecorder) ClientType() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClientType", reflect.TypeOf((*MockInitMode)(nil).ClientType))
 }
 
+// LocalHTTPServerEnabled mocks base method
+func (m *MockInitMode) LocalHTTPServerEnabled() bool {
+	ret := m.ctrl.Call(m, "LocalHTTPServerEnabled")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// LocalHTTPServerEnabled indicates an expected call of LocalHTTPServerEnabled
+func (mr *MockInitModeMockRecorder) LocalHTTPServerEnabled() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LocalHTTPServerEnabled", reflect.TypeOf((*MockInitMode)(nil).LocalHTTPServerEnabled))
+}
+
 // MockinitModeGetter is a mock of initModeGetter interface
 type MockinitModeGetter struct {
 	ctrl     *gomock.Controller
@@ -6336,6 +6684,55 @@ func (mr *MockConfigMockRecorder) SetTlfSyncState(tlfID, isSynced interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTlfSyncState", reflect.TypeOf((*MockConfig)(nil).SetTlfSyncState), tlfID, isSynced)
 }
 
+// LookupTlfAlias mocks base method
+func (m *MockConfig) LookupTlfAlias(alias string) (tlf.CanonicalName, bool) {
+	ret := m.ctrl.Call(m, "LookupTlfAlias", alias)
+	ret0, _ := ret[0].(tlf.CanonicalName)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// LookupTlfAlias indicates an expected call of LookupTlfAlias
+func (mr *MockConfigMockRecorder) LookupTlfAlias(alias interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupTlfAlias", reflect.TypeOf((*MockConfig)(nil).LookupTlfAlias), alias)
+}
+
+// TlfAliases mocks base method
+func (m *MockConfig) TlfAliases() []tlf.Alias {
+	ret := m.ctrl.Call(m, "TlfAliases")
+	ret0, _ := ret[0].([]tlf.Alias)
+	return ret0
+}
+
+// TlfAliases indicates an expected call of TlfAliases
+func (mr *MockConfigMockRecorder) TlfAliases() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TlfAliases", reflect.TypeOf((*MockConfig)(nil).TlfAliases))
+}
+
+// SetTlfAlias mocks base method
+func (m *MockConfig) SetTlfAlias(alias string, target tlf.CanonicalName) error {
+	ret := m.ctrl.Call(m, "SetTlfAlias", alias, target)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTlfAlias indicates an expected call of SetTlfAlias
+func (mr *MockConfigMockRecorder) SetTlfAlias(alias, target interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTlfAlias", reflect.TypeOf((*MockConfig)(nil).SetTlfAlias), alias, target)
+}
+
+// RemoveTlfAlias mocks base method
+func (m *MockConfig) RemoveTlfAlias(alias string) error {
+	ret := m.ctrl.Call(m, "RemoveTlfAlias", alias)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveTlfAlias indicates an expected call of RemoveTlfAlias
+func (mr *MockConfigMockRecorder) RemoveTlfAlias(alias interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTlfAlias", reflect.TypeOf((*MockConfig)(nil).RemoveTlfAlias), alias)
+}
+
 // Mode mocks base method
 func (m *MockConfig) Mode() InitMode {
 	ret := m.ctrl.Call(m, "Mode")
@@ -6816,6 +7213,28 @@ func (mr *MockConfigMockRecorder) SetConflictRenamer(arg0 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetConflictRenamer", reflect.TypeOf((*MockConfig)(nil).SetConflictRenamer), arg0)
 }
 
+// ConflictPolicy mocks base method
+func (m *MockConfig) ConflictPolicy() ConflictPolicy {
+	ret := m.ctrl.Call(m, "ConflictPolicy")
+	ret0, _ := ret[0].(ConflictPolicy)
+	return ret0
+}
+
+// ConflictPolicy indicates an expected call of ConflictPolicy
+func (mr *MockConfigMockRecorder) ConflictPolicy() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConflictPolicy", reflect.TypeOf((*MockConfig)(nil).ConflictPolicy))
+}
+
+// SetConflictPolicy mocks base method
+func (m *MockConfig) SetConflictPolicy(arg0 ConflictPolicy) {
+	m.ctrl.Call(m, "SetConflictPolicy", arg0)
+}
+
+// SetConflictPolicy indicates an expected call of SetConflictPolicy
+func (mr *MockConfigMockRecorder) SetConflictPolicy(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetConflictPolicy", reflect.TypeOf((*MockConfig)(nil).SetConflictPolicy), arg0)
+}
+
 // MetadataVersion mocks base method
 func (m *MockConfig) MetadataVersion() kbfsmd.MetadataVer {
 	ret := m.ctrl.Call(m, "MetadataVersion")
@@ -6860,6 +7279,50 @@ func (mr *MockConfigMockRecorder) SetDefaultBlockType(blockType interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDefaultBlockType", reflect.TypeOf((*MockConfig)(nil).SetDefaultBlockType), blockType)
 }
 
+// CompactDirEntries mocks base method
+func (m *MockConfig) CompactDirEntries() bool {
+	ret := m.ctrl.Call(m, "CompactDirEntries")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// CompactDirEntries indicates an expected call of CompactDirEntries
+func (mr *MockConfigMockRecorder) CompactDirEntries() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompactDirEntries", reflect.TypeOf((*MockConfig)(nil).CompactDirEntries))
+}
+
+// SetCompactDirEntries mocks base method
+func (m *MockConfig) SetCompactDirEntries(arg0 bool) {
+	m.ctrl.Call(m, "SetCompactDirEntries", arg0)
+}
+
+// SetCompactDirEntries indicates an expected call of SetCompactDirEntries
+func (mr *MockConfigMockRecorder) SetCompactDirEntries(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetCompactDirEntries", reflect.TypeOf((*MockConfig)(nil).SetCompactDirEntries), arg0)
+}
+
+// BlockHashType mocks base method
+func (m *MockConfig) BlockHashType() kbfshash.HashType {
+	ret := m.ctrl.Call(m, "BlockHashType")
+	ret0, _ := ret[0].(kbfshash.HashType)
+	return ret0
+}
+
+// BlockHashType indicates an expected call of BlockHashType
+func (mr *MockConfigMockRecorder) BlockHashType() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockHashType", reflect.TypeOf((*MockConfig)(nil).BlockHashType))
+}
+
+// SetBlockHashType mocks base method
+func (m *MockConfig) SetBlockHashType(arg0 kbfshash.HashType) {
+	m.ctrl.Call(m, "SetBlockHashType", arg0)
+}
+
+// SetBlockHashType indicates an expected call of SetBlockHashType
+func (mr *MockConfigMockRecorder) SetBlockHashType(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBlockHashType", reflect.TypeOf((*MockConfig)(nil).SetBlockHashType), arg0)
+}
+
 // RekeyQueue mocks base method
 func (m *MockConfig) RekeyQueue() RekeyQueue {
 	ret := m.ctrl.Call(m, "RekeyQueue")
@@ -7,6 +7,7 @@ package libkbfs
 import (
 	"context"
 	"fmt"
+	"io"
 	"runtime"
 )
 
@@ -103,3 +104,11 @@ func (n *nodeStandard) WrapChild(child Node) Node {
 func (n *nodeStandard) Unwrap() Node {
 	return n
 }
+
+func (n *nodeStandard) GetFS(_ context.Context) NodeFSReadOnly {
+	return nil
+}
+
+func (n *nodeStandard) GetFile(_ context.Context) io.ReaderAt {
+	return nil
+}
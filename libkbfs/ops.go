@@ -1121,6 +1121,7 @@ const (
 	exAttr attrChange = iota
 	mtimeAttr
 	sizeAttr // only used during conflict resolution
+	xattrAttr
 )
 
 func (ac attrChange) String() string {
@@ -1131,6 +1132,8 @@ func (ac attrChange) String() string {
 		return "mtime"
 	case sizeAttr:
 		return "size"
+	case xattrAttr:
+		return "xattr"
 	}
 	return "<invalid attrChange>"
 }
@@ -1223,7 +1226,9 @@ func (sao *setAttrOp) checkConflict(
 	isFile bool) (crAction, error) {
 	switch realMergedOp := mergedOp.(type) {
 	case *setAttrOp:
-		if realMergedOp.Attr == sao.Attr {
+		// Extended attributes are replaced as a set, so the
+		// unmerged set simply wins (see `getDefaultAction`).
+		if realMergedOp.Attr == sao.Attr && sao.Attr != xattrAttr {
 			var symPath string
 			var causedByAttr attrChange
 			if !isFile {
@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"errors"
+	"fmt"
 	"sort"
 	"sync"
 	"time"
@@ -42,6 +43,7 @@ type prefetchRequest struct {
 	lifetime       BlockCacheLifetime
 	prefetchStatus PrefetchStatus
 	isDeepSync     bool
+	isSequential   bool
 }
 
 type ctxPrefetcherTagKey int
@@ -49,11 +51,57 @@ type ctxPrefetcherTagKey int
 const (
 	ctxPrefetcherIDKey ctxPrefetcherTagKey = iota
 	ctxPrefetchIDKey
+	ctxPrefetchHintKey
 
 	ctxPrefetcherID = "PREID"
 	ctxPrefetchID   = "PFID"
 )
 
+// PrefetchHint tells the prefetcher how the blocks fetched under a
+// given context are expected to be accessed.
+type PrefetchHint int
+
+const (
+	// PrefetchHintDefault leaves prefetching decisions to the
+	// prefetcher.
+	PrefetchHintDefault PrefetchHint = iota
+	// PrefetchHintSequential means the blocks will probably be read
+	// in order, so the prefetcher should read ahead as if the blocks
+	// were in a synced TLF.
+	PrefetchHintSequential
+	// PrefetchHintNone means the blocks will be accessed randomly, so
+	// fetching them shouldn't trigger any prefetches.
+	PrefetchHintNone
+)
+
+func (ph PrefetchHint) String() string {
+	switch ph {
+	case PrefetchHintDefault:
+		return "default"
+	case PrefetchHintSequential:
+		return "sequential"
+	case PrefetchHintNone:
+		return "none"
+	default:
+		return fmt.Sprintf("PrefetchHint(%d)", int(ph))
+	}
+}
+
+// CtxWithPrefetchHint returns a context that applies the given
+// prefetch hint to all the blocks fetched under it.
+func CtxWithPrefetchHint(
+	ctx context.Context, hint PrefetchHint) context.Context {
+	return context.WithValue(ctx, ctxPrefetchHintKey, hint)
+}
+
+func prefetchHintFromCtx(ctx context.Context) PrefetchHint {
+	hint, ok := ctx.Value(ctxPrefetchHintKey).(PrefetchHint)
+	if ok {
+		return hint
+	}
+	return PrefetchHintDefault
+}
+
 type prefetch struct {
 	subtreeBlockCount int
 	subtreeTriggered  bool
@@ -132,6 +180,11 @@ func (p *blockPrefetcher) newPrefetch(count int, triggered bool,
 	ctx, cancel := context.WithTimeout(p.ctx, prefetchTimeout)
 	ctx = CtxWithRandomIDReplayable(
 		ctx, ctxPrefetchIDKey, ctxPrefetchID, p.log)
+	if req.isSequential {
+		// Pass the hint along to the block retriever, so the
+		// children of this block keep reading ahead.
+		ctx = CtxWithPrefetchHint(ctx, PrefetchHintSequential)
+	}
 	return &prefetch{
 		subtreeBlockCount: count,
 		subtreeTriggered:  triggered,
@@ -255,10 +308,11 @@ top:
 }
 
 // calculatePriority returns either a base priority for an unsynced TLF or a
-// high priority for a synced TLF.
-func (p *blockPrefetcher) calculatePriority(basePriority int,
-	tlfID tlf.ID) int {
-	if p.config.IsSyncedTlf(tlfID) {
+// high priority for a synced TLF, or for a sequential read.
+func (p *blockPrefetcher) calculatePriority(ctx context.Context,
+	basePriority int, tlfID tlf.ID) int {
+	if p.config.IsSyncedTlf(tlfID) ||
+		prefetchHintFromCtx(ctx) == PrefetchHintSequential {
 		return defaultOnDemandRequestPriority - 1
 	}
 	return basePriority
@@ -277,7 +331,8 @@ func (p *blockPrefetcher) request(ctx context.Context, priority int,
 		// If the block isn't in the tree, we add it with a block count of 1 (a
 		// later TriggerPrefetch will come in and decrement it).
 		req := &prefetchRequest{ptr, block, kmd, priority, lifetime,
-			NoPrefetch, isDeepSync}
+			NoPrefetch, isDeepSync,
+			prefetchHintFromCtx(ctx) == PrefetchHintSequential}
 		pre = p.newPrefetch(1, false, req)
 		p.prefetches[ptr.ID] = pre
 		ch := p.retriever.Request(pre.ctx, priority, kmd, ptr, block, lifetime)
@@ -311,7 +366,8 @@ func (p *blockPrefetcher) prefetchIndirectFileBlock(ctx context.Context,
 	isTail bool) {
 	// Prefetch indirect block pointers.
 	startingPriority :=
-		p.calculatePriority(fileIndirectBlockPrefetchPriority, kmd.TlfID())
+		p.calculatePriority(
+			ctx, fileIndirectBlockPrefetchPriority, kmd.TlfID())
 	for i, ptr := range b.IPtrs {
 		numBlocks += p.request(ctx, startingPriority-i, kmd,
 			ptr.BlockPointer, b.NewEmpty(), lifetime,
@@ -326,7 +382,8 @@ func (p *blockPrefetcher) prefetchIndirectDirBlock(ctx context.Context,
 	isTail bool) {
 	// Prefetch indirect block pointers.
 	startingPriority :=
-		p.calculatePriority(fileIndirectBlockPrefetchPriority, kmd.TlfID())
+		p.calculatePriority(
+			ctx, fileIndirectBlockPrefetchPriority, kmd.TlfID())
 	for i, ptr := range b.IPtrs {
 		numBlocks += p.request(ctx, startingPriority-i, kmd,
 			ptr.BlockPointer, b.NewEmpty(), lifetime,
@@ -343,7 +400,8 @@ func (p *blockPrefetcher) prefetchDirectDirBlock(ctx context.Context,
 	dirEntries := dirEntriesBySizeAsc{dirEntryMapToDirEntries(b.Children)}
 	sort.Sort(dirEntries)
 	startingPriority :=
-		p.calculatePriority(dirEntryPrefetchPriority, kmd.TlfID())
+		p.calculatePriority(
+			ctx, dirEntryPrefetchPriority, kmd.TlfID())
 	totalChildEntries := 0
 	for i, entry := range dirEntries.dirEntries {
 		// Prioritize small files
@@ -691,12 +749,19 @@ func (p *blockPrefetcher) cacheOrCancelPrefetch(ctx context.Context,
 func (p *blockPrefetcher) ProcessBlockForPrefetch(ctx context.Context,
 	ptr BlockPointer, block Block, kmd KeyMetadata, priority int,
 	lifetime BlockCacheLifetime, prefetchStatus PrefetchStatus) {
+	hint := prefetchHintFromCtx(ctx)
 	isDeepSync := p.config.IsSyncedTlf(kmd.TlfID())
 	req := &prefetchRequest{ptr, block.NewEmpty(), kmd, priority, lifetime,
-		prefetchStatus, isDeepSync}
+		prefetchStatus, isDeepSync, hint == PrefetchHintSequential}
 	if prefetchStatus == FinishedPrefetch {
 		// Finished prefetches can always be short circuited.
 		// If we're here, then FinishedPrefetch is already cached.
+	} else if hint == PrefetchHintNone {
+		// The caller doesn't want any prefetching for this block, so
+		// just cache it without triggering anything.
+		p.retriever.PutInCaches(ctx, ptr, kmd.TlfID(), block, lifetime,
+			prefetchStatus)
+		return
 	} else if priority < lowestTriggerPrefetchPriority {
 		// Only high priority requests can trigger prefetches. Leave the
 		// prefetchStatus unchanged, but cache anyway.
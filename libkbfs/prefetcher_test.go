@@ -33,6 +33,7 @@ func makeRandomDirEntry(
 			102,
 			"",
 		},
+		nil,
 		codec.UnknownFieldSetHandler{},
 	}
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
@@ -31,6 +32,14 @@ type cachedQuotaUsage struct {
 	limitBytes    int64
 	gitUsageBytes int64
 	gitLimitBytes int64
+	// tlfBreakdowns maps TLF ID strings to the live vs. archived
+	// breakdown of each TLF's usage.
+	tlfBreakdowns map[string]kbfsblock.UsageBreakdown
+}
+
+func (c cachedQuotaUsage) tlfBreakdown(
+	tlfID tlf.ID) kbfsblock.UsageBreakdown {
+	return c.tlfBreakdowns[tlfID.String()]
 }
 
 // EventuallyConsistentQuotaUsage keeps tracks of quota usage, in a way user of
@@ -93,6 +102,13 @@ func (q *EventuallyConsistentQuotaUsage) getAndCache(
 	} else {
 		q.cached.usageBytes = 0
 	}
+	// The block server keys per-folder usage by TLF ID.
+	q.cached.tlfBreakdowns =
+		make(map[string]kbfsblock.UsageBreakdown, len(quotaInfo.Folders))
+	for folder := range quotaInfo.Folders {
+		breakdown, _ := quotaInfo.FolderBreakdown(folder)
+		q.cached.tlfBreakdowns[folder] = breakdown
+	}
 	q.cached.timestamp = q.config.Clock().Now()
 
 	return nil
@@ -155,3 +171,24 @@ func (q *EventuallyConsistentQuotaUsage) GetAllTypes(
 	return c.timestamp,
 		c.usageBytes, c.limitBytes, c.gitUsageBytes, c.gitLimitBytes, nil
 }
+
+// GetTlfBreakdown returns how many of the bytes charged for the given
+// TLF are for blocks that are still live, and how many are for
+// blocks that have been archived but not yet reclaimed.  (Archived
+// blocks still count against quota until quota reclamation runs,
+// which is why usage doesn't drop right after files are deleted.)
+// The tolerances work the same way as for Get.  If the block server
+// didn't report any usage for the TLF, the breakdown is all zeroes.
+func (q *EventuallyConsistentQuotaUsage) GetTlfBreakdown(
+	ctx context.Context, tlfID tlf.ID,
+	bgTolerance, blockTolerance time.Duration) (
+	timestamp time.Time, breakdown kbfsblock.UsageBreakdown, err error) {
+	c := q.getCached()
+	err = q.fetcher.Do(ctx, bgTolerance, blockTolerance, c.timestamp)
+	if err != nil {
+		return time.Time{}, kbfsblock.UsageBreakdown{}, err
+	}
+
+	c = q.getCached()
+	return c.timestamp, c.tlfBreakdown(tlfID), nil
+}
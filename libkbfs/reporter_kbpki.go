@@ -31,6 +31,7 @@ const (
 	errorParamUsageFiles          = "usageFiles"
 	errorParamLimitFiles          = "limitFiles"
 	errorParamRenameOldFilename   = "oldFilename"
+	errorParamDeletedRevision     = "revision"
 	errorParamFoldersCreated      = "foldersCreated"
 	errorParamFolderLimit         = "folderLimit"
 	errorParamApplicationExecPath = "applicationExecPath"
@@ -333,9 +334,10 @@ func fileModifyNotification(file path, writer keybase1.UID,
 // fileDeleteNotification creates FSNotifications from paths for file
 // delete events.
 func fileDeleteNotification(file path, writer keybase1.UID,
-	localTime time.Time) *keybase1.FSNotification {
+	localTime time.Time, rev kbfsmd.Revision) *keybase1.FSNotification {
 	n := baseFileEditNotification(file, writer, localTime)
 	n.NotificationType = keybase1.FSNotificationType_FILE_DELETED
+	n.Params = map[string]string{errorParamDeletedRevision: rev.String()}
 	return n
 }
 
@@ -0,0 +1,180 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"golang.org/x/net/context"
+)
+
+// HostPowerState is what the host embedding KBFS, like a mobile app,
+// reports about the device's power and network.
+type HostPowerState struct {
+	// OnBattery is true when the device isn't plugged in.
+	OnBattery bool
+	// Metered is true when the network charges by the byte, like
+	// most cellular connections.
+	Metered bool
+}
+
+// RuntimeProfile is a group of settings that can be switched while
+// KBFS is running.
+type RuntimeProfile int
+
+const (
+	// RuntimeProfileDefault uses the settings KBFS was started with.
+	RuntimeProfileDefault RuntimeProfile = iota
+	// RuntimeProfileSaver saves battery and bandwidth, at the cost of
+	// slower reads and of writes reaching other devices later.
+	RuntimeProfileSaver
+)
+
+func (p RuntimeProfile) String() string {
+	switch p {
+	case RuntimeProfileDefault:
+		return "default"
+	case RuntimeProfileSaver:
+		return "saver"
+	default:
+		return "unknown"
+	}
+}
+
+// RuntimeProfileForHost returns the profile to use for the given
+// host state.
+func RuntimeProfileForHost(state HostPowerState) RuntimeProfile {
+	if state.OnBattery || state.Metered {
+		return RuntimeProfileSaver
+	}
+	return RuntimeProfileDefault
+}
+
+const (
+	// Check for folders needing rekey a few times a day, rather than
+	// hourly; FolderNeedsRekey notifications still come right away.
+	saverRekeyCheckPeriod = 6 * time.Hour
+	// Let journals collect a minute of writes before uploading them,
+	// so the radio can be woken up less often.
+	saverJournalFlushBatchPeriod = 1 * time.Minute
+	// Shrink the clean block cache to a quarter of its size, so the
+	// OS is less likely to kill the app in the background.
+	saverCleanBlockCacheDivisor = 4
+)
+
+// rekeyPeriodSetter is implemented by MD servers that check for
+// folders needing rekey in the background.
+type rekeyPeriodSetter interface {
+	setBackgroundRekeyPeriod(period time.Duration)
+}
+
+// shutdownChecker is implemented by prefetchers that can tell
+// whether they've been shut down, i.e. whether prefetching is off.
+type shutdownChecker interface {
+	isShutdown() bool
+}
+
+// RuntimeProfiler switches a Config between runtime profiles, and
+// remembers the settings the saver profile changes, so that they can
+// be restored.
+type RuntimeProfiler struct {
+	config Config
+	log    logger.Logger
+
+	lock    sync.Mutex
+	state   HostPowerState
+	profile RuntimeProfile
+	// The settings in effect before the saver profile was applied.
+	savedCleanBytesCapacity uint64
+	savedFlushBatchPeriod   time.Duration
+	savedPrefetchEnabled    bool
+}
+
+// NewRuntimeProfiler returns a new RuntimeProfiler for the given
+// config, which starts out using RuntimeProfileDefault.
+func NewRuntimeProfiler(config Config) *RuntimeProfiler {
+	return &RuntimeProfiler{
+		config: config,
+		log:    config.MakeLogger("RP"),
+	}
+}
+
+// SetHostPowerState records the state reported by the host, and
+// applies the matching profile.  It returns the profile in effect.
+func (rp *RuntimeProfiler) SetHostPowerState(
+	ctx context.Context, state HostPowerState) RuntimeProfile {
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	rp.state = state
+	profile := RuntimeProfileForHost(state)
+	if profile == rp.profile {
+		return profile
+	}
+
+	rp.log.CDebugf(ctx, "Switching from the %s profile to the %s profile "+
+		"(on battery=%t, metered=%t)",
+		rp.profile, profile, state.OnBattery, state.Metered)
+	switch profile {
+	case RuntimeProfileSaver:
+		rp.applySaverLocked(ctx)
+	default:
+		rp.restoreLocked(ctx)
+	}
+	rp.profile = profile
+	return profile
+}
+
+// Status returns the last state reported by the host, and the
+// profile in effect.
+func (rp *RuntimeProfiler) Status() (HostPowerState, RuntimeProfile) {
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	return rp.state, rp.profile
+}
+
+func (rp *RuntimeProfiler) applySaverLocked(ctx context.Context) {
+	if s, ok := rp.config.MDServer().(rekeyPeriodSetter); ok {
+		s.setBackgroundRekeyPeriod(saverRekeyCheckPeriod)
+	}
+
+	// Prefetching fetches blocks nobody has asked for yet, which is
+	// exactly what to avoid on a metered connection.  It might
+	// already be off, in which case it should stay off later.
+	rp.savedPrefetchEnabled = true
+	if p, ok := rp.config.BlockOps().Prefetcher().(shutdownChecker); ok {
+		rp.savedPrefetchEnabled = !p.isShutdown()
+	}
+	if rp.savedPrefetchEnabled {
+		<-rp.config.BlockOps().TogglePrefetcher(false)
+	}
+
+	if jServer, err := GetJournalServer(rp.config); err == nil {
+		rp.savedFlushBatchPeriod = jServer.FlushBatchPeriod()
+		jServer.SetFlushBatchPeriod(ctx, saverJournalFlushBatchPeriod)
+	}
+
+	bcache := rp.config.BlockCache()
+	rp.savedCleanBytesCapacity = bcache.GetCleanBytesCapacity()
+	bcache.SetCleanBytesCapacity(
+		rp.savedCleanBytesCapacity / saverCleanBlockCacheDivisor)
+}
+
+func (rp *RuntimeProfiler) restoreLocked(ctx context.Context) {
+	if s, ok := rp.config.MDServer().(rekeyPeriodSetter); ok {
+		s.setBackgroundRekeyPeriod(MdServerBackgroundRekeyPeriod)
+	}
+
+	if rp.savedPrefetchEnabled {
+		<-rp.config.BlockOps().TogglePrefetcher(true)
+	}
+
+	if jServer, err := GetJournalServer(rp.config); err == nil {
+		jServer.SetFlushBatchPeriod(ctx, rp.savedFlushBatchPeriod)
+	}
+
+	rp.config.BlockCache().SetCleanBytesCapacity(rp.savedCleanBytesCapacity)
+}
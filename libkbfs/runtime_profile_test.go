@@ -0,0 +1,81 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeProfileForHost(t *testing.T) {
+	require.Equal(t, RuntimeProfileDefault,
+		RuntimeProfileForHost(HostPowerState{}))
+	require.Equal(t, RuntimeProfileSaver,
+		RuntimeProfileForHost(HostPowerState{OnBattery: true}))
+	require.Equal(t, RuntimeProfileSaver,
+		RuntimeProfileForHost(HostPowerState{Metered: true}))
+}
+
+func TestRuntimeProfiler(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	capacity := config.BlockCache().GetCleanBytesCapacity()
+	rp := NewRuntimeProfiler(config)
+	state, profile := rp.Status()
+	require.Equal(t, HostPowerState{}, state)
+	require.Equal(t, RuntimeProfileDefault, profile)
+
+	t.Log("Going on battery applies the saver profile")
+	onBattery := HostPowerState{OnBattery: true}
+	profile = rp.SetHostPowerState(ctx, onBattery)
+	require.Equal(t, RuntimeProfileSaver, profile)
+	require.Equal(t, saverJournalFlushBatchPeriod, jServer.FlushBatchPeriod())
+	require.Equal(t, capacity/saverCleanBlockCacheDivisor,
+		config.BlockCache().GetCleanBytesCapacity())
+
+	t.Log("Also being metered keeps the same settings")
+	profile = rp.SetHostPowerState(
+		ctx, HostPowerState{OnBattery: true, Metered: true})
+	require.Equal(t, RuntimeProfileSaver, profile)
+	require.Equal(t, capacity/saverCleanBlockCacheDivisor,
+		config.BlockCache().GetCleanBytesCapacity())
+
+	t.Log("Plugging back in restores the original settings")
+	profile = rp.SetHostPowerState(ctx, HostPowerState{})
+	require.Equal(t, RuntimeProfileDefault, profile)
+	require.Equal(t, time.Duration(0), jServer.FlushBatchPeriod())
+	require.Equal(t, capacity, config.BlockCache().GetCleanBytesCapacity())
+	state, profile = rp.Status()
+	require.Equal(t, HostPowerState{}, state)
+	require.Equal(t, RuntimeProfileDefault, profile)
+}
+
+func prefetcherIsShutdown(config Config) bool {
+	return config.BlockOps().Prefetcher().(shutdownChecker).isShutdown()
+}
+
+func TestRuntimeProfilerRestoresPrefetch(t *testing.T) {
+	tempdir, ctx, cancel, config, _, _ := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	rp := NewRuntimeProfiler(config)
+	require.False(t, prefetcherIsShutdown(config))
+
+	t.Log("The saver profile turns prefetching off, and back on after")
+	rp.SetHostPowerState(ctx, HostPowerState{Metered: true})
+	require.True(t, prefetcherIsShutdown(config))
+	rp.SetHostPowerState(ctx, HostPowerState{})
+	require.False(t, prefetcherIsShutdown(config))
+
+	t.Log("Prefetching that was already off stays off")
+	<-config.BlockOps().TogglePrefetcher(false)
+	rp.SetHostPowerState(ctx, HostPowerState{Metered: true})
+	require.True(t, prefetcherIsShutdown(config))
+	rp.SetHostPowerState(ctx, HostPowerState{})
+	require.True(t, prefetcherIsShutdown(config))
+}
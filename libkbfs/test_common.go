@@ -486,6 +486,27 @@ func AddTeamWriterForTestOrBust(t logger.TestLogBackend, config Config,
 	}
 }
 
+// AddTeamAdminForTest makes the given user a team admin.
+func AddTeamAdminForTest(
+	config Config, tid keybase1.TeamID, uid keybase1.UID) error {
+	kbd, ok := config.KeybaseService().(*KeybaseDaemonLocal)
+	if !ok {
+		return errors.New("Bad keybase daemon")
+	}
+
+	return kbd.addTeamAdminForTest(tid, uid)
+}
+
+// AddTeamAdminForTestOrBust is like AddTeamAdminForTest, but dies
+// if there's an error.
+func AddTeamAdminForTestOrBust(t logger.TestLogBackend, config Config,
+	tid keybase1.TeamID, uid keybase1.UID) {
+	err := AddTeamAdminForTest(config, tid, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 // AddTeamReaderForTest makes the given user a team reader.
 func AddTeamReaderForTest(
 	config Config, tid keybase1.TeamID, uid keybase1.UID) error {
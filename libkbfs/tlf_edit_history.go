@@ -12,8 +12,11 @@ import (
 
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsedits"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/kbfssync"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
@@ -26,14 +29,18 @@ const (
 	FileCreated TlfEditNotificationType = iota
 	// FileModified indicates an existing file that was written to.
 	FileModified
+	// FileDeleted indicates a file that was removed.
+	FileDeleted
 )
 
 // TlfEdit represents an individual update about a file edit within a
-// TLF.
+// TLF.  Revision is only set for FileDeleted edits, and is the
+// revision that removed the file.
 type TlfEdit struct {
 	Filepath  string // relative to the TLF root
 	Type      TlfEditNotificationType
 	LocalTime time.Time // reflects difference between server and local clock
+	Revision  kbfsmd.Revision
 	cachedOp  op
 }
 
@@ -41,6 +48,9 @@ const (
 	// How many edits per writer we want to return in the complete history?
 	desiredEditsPerWriter = 20
 
+	// How many edit notifications to keep for each file.
+	desiredEditsPerFile = 20
+
 	// How far back we're willing to go to get the complete history.
 	maxMDsToInspect = 1000
 )
@@ -161,8 +171,13 @@ type TlfEditHistory struct {
 
 	lock     sync.Mutex
 	edits    TlfWriterEdits
+	deletes  TlfWriterEdits
 	shutdown bool
 	sends    sync.WaitGroup
+
+	// files indexes the edit notifications of every revision
+	// processed so far by path.
+	files *kbfsedits.FileHistory
 }
 
 // NewTlfEditHistory makes a new TLF edit history.
@@ -175,6 +190,8 @@ func NewTlfEditHistory(config Config, fbo *folderBranchOps,
 		log:      log,
 		rmdsChan: make(chan []ImmutableRootMetadata, 100),
 		cancel:   cancel,
+		deletes:  make(TlfWriterEdits),
+		files:    kbfsedits.NewFileHistory(desiredEditsPerFile),
 	}
 	if config.Mode().TLFEditHistoryEnabled() {
 		go teh.process(processCtx)
@@ -223,6 +240,40 @@ func (teh *TlfEditHistory) getEditsCopy() TlfWriterEdits {
 	return teh.getEditsCopyLocked()
 }
 
+// withDeletes merges copies of the most recent deletions into
+// `edits`, keeping each writer's list sorted by timestamp.
+func (teh *TlfEditHistory) withDeletes(edits TlfWriterEdits) TlfWriterEdits {
+	teh.lock.Lock()
+	defer teh.lock.Unlock()
+	for writer, deletes := range teh.deletes {
+		list := make(TlfEditList, 0, len(edits[writer])+len(deletes))
+		list = append(list, edits[writer]...)
+		list = append(list, deletes...)
+		sort.Stable(list)
+		edits[writer] = list
+	}
+	return edits
+}
+
+// updateDeletes forgets any deletions of paths in `removed` (which
+// have either been deleted again, or now hold a different file), and
+// then records the new deletions, keeping only the most recent ones
+// per writer.
+func (teh *TlfEditHistory) updateDeletes(removed map[string]bool,
+	newDeletes TlfWriterEdits) {
+	teh.lock.Lock()
+	defer teh.lock.Unlock()
+	teh.deletes.updateOldEdits(removed, nil)
+	teh.deletes.addNewEdits(newDeletes)
+	for w, list := range teh.deletes {
+		sort.Stable(list)
+		if len(list) > desiredEditsPerWriter {
+			list = list[len(list)-desiredEditsPerWriter:]
+		}
+		teh.deletes[w] = list
+	}
+}
+
 func (teh *TlfEditHistory) updateRmds(rmds []ImmutableRootMetadata,
 	olderRmds []ImmutableRootMetadata) []ImmutableRootMetadata {
 	// Avoid hidden sharing with olderRmds by making a copy.
@@ -249,8 +300,12 @@ func (teh *TlfEditHistory) calculateEditCounts(ctx context.Context,
 
 	edits := make(TlfWriterEdits)
 	for _, writer := range rmds[len(rmds)-1].GetTlfHandle().ResolvedWriters() {
-		// TODO: if this is a single-team TLF, we'll need to look up
-		// the set of users from the team ID.
+		// The writer of a single-team TLF is the team; its members
+		// only show up here once they've made an edit.  TODO: look
+		// up the set of users from the team ID.
+		if !writer.IsUser() {
+			continue
+		}
 		edits[writer.AsUserOrBust()] = nil
 	}
 
@@ -367,7 +422,7 @@ func (teh *TlfEditHistory) GetComplete(ctx context.Context,
 	head ImmutableRootMetadata) (TlfWriterEdits, error) {
 	currEdits := teh.getEditsCopy()
 	if currEdits != nil {
-		return currEdits, nil
+		return teh.withDeletes(currEdits), nil
 	}
 
 	// We have no history -- fetch from the server until we have a
@@ -454,7 +509,121 @@ func (teh *TlfEditHistory) GetComplete(ctx context.Context,
 	}
 
 	teh.setEdits(ctx, currEdits, rmds)
-	return teh.getEditsCopyLocked(), nil
+	return teh.withDeletes(teh.getEditsCopy()), nil
+}
+
+// GetFileHistory returns the edit notifications for the given path
+// (relative to the root of the TLF's parent, like the paths in
+// TlfEdit), most recent first.  It only knows about the revisions
+// processed since the complete history was first loaded.
+func (teh *TlfEditHistory) GetFileHistory(
+	filename string) []kbfsedits.NotificationMessage {
+	return teh.files.History(filename)
+}
+
+// rangeDeletes returns the deletions of files found in `chains`,
+// ignoring the removes that were really the first half of a rename.
+func rangeDeletes(chains *crChains) TlfWriterEdits {
+	deletes := make(TlfWriterEdits)
+	for _, chain := range chains.byOriginal {
+		for _, op := range chain.ops {
+			rop, ok := op.(*rmOp)
+			if !ok || rop.RemovedType == Dir || rop.RemovedType == Sym {
+				continue
+			}
+			renamed := false
+			for _, ri := range chains.renamedOriginals {
+				if ri.originalOldParent == chain.original &&
+					ri.oldName == rop.OldName {
+					renamed = true
+					break
+				}
+			}
+			if renamed {
+				continue
+			}
+			writerInfo := rop.getWriterInfo()
+			path := rop.getFinalPath().ChildPathNoPtr(rop.OldName)
+			deletes[writerInfo.uid] = append(deletes[writerInfo.uid], TlfEdit{
+				Filepath:  path.String(),
+				Type:      FileDeleted,
+				LocalTime: rop.getLocalTimestamp(),
+				Revision:  writerInfo.revision,
+			})
+		}
+	}
+	return deletes
+}
+
+// GetRange returns all the file edits (including deletions) made in
+// the merged revisions up to and including `latestRev` whose local
+// timestamps fall between `start` and `end`, inclusive.  Unlike
+// GetComplete, the number of edits per writer isn't limited, and
+// nothing is cached.  As with GetComplete, edits are clustered: a
+// file edited several times in the range only shows up once, and a
+// file that was both created and deleted in the range doesn't show
+// up at all.
+func (teh *TlfEditHistory) GetRange(ctx context.Context, tlfID tlf.ID,
+	latestRev kbfsmd.Revision, start, end time.Time) (
+	TlfWriterEdits, error) {
+	if end.Before(start) {
+		return nil, errors.Errorf("end %s is before start %s", end, start)
+	}
+
+	// Work backwards from the latest revision until we pass the
+	// start of the range.
+	var rmds []ImmutableRootMetadata
+	endRev := latestRev
+	for endRev >= kbfsmd.RevisionInitial {
+		startRev := endRev - maxMDsAtATime + 1
+		if startRev < kbfsmd.RevisionInitial {
+			startRev = kbfsmd.RevisionInitial
+		}
+		olderRmds, err := getMDRange(ctx, teh.config, tlfID,
+			kbfsmd.NullBranchID, startRev, endRev, kbfsmd.Merged, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(olderRmds) == 0 {
+			break
+		}
+
+		// Keep only the revisions in the range.
+		var inRange []ImmutableRootMetadata
+		for _, rmd := range olderRmds {
+			ts := rmd.LocalTimestamp()
+			if !ts.Before(start) && !ts.After(end) {
+				inRange = append(inRange, rmd)
+			}
+		}
+		rmds = teh.updateRmds(rmds, inRange)
+
+		if olderRmds[0].LocalTimestamp().Before(start) {
+			break
+		}
+		endRev = olderRmds[0].Revision() - 1
+	}
+
+	if len(rmds) == 0 {
+		return make(TlfWriterEdits), nil
+	}
+	teh.log.CDebugf(ctx, "Calculating edits for %d revisions, from "+
+		"revision %d to %d", len(rmds), rmds[0].Revision(),
+		rmds[len(rmds)-1].Revision())
+
+	edits, chains, err := teh.calculateEditCounts(ctx, rmds)
+	if err != nil {
+		return nil, err
+	}
+	edits.addNewEdits(rangeDeletes(chains))
+	for w, list := range edits {
+		sort.Stable(list)
+		for i := range list {
+			list[i].cachedOp = nil
+		}
+		edits[w] = list
+	}
+	return edits, nil
 }
 
 func (teh *TlfEditHistory) updateHistory(ctx context.Context,
@@ -472,6 +641,15 @@ func (teh *TlfEditHistory) updateHistory(ctx context.Context,
 		return nil
 	}
 
+	for _, rmd := range rmds {
+		edits, err := teh.fbo.makeEditNotificationsWithPaths(
+			ctx, rmd, true, true)
+		if err != nil {
+			return err
+		}
+		teh.files.AddNotifications(edits)
+	}
+
 	wasComplete := currEdits.isComplete()
 
 	newEdits, chains, err := teh.calculateEditCounts(ctx, rmds)
@@ -482,6 +660,7 @@ func (teh *TlfEditHistory) updateHistory(ctx context.Context,
 	// Which paths have been removed?
 	removed := make(map[string]bool)
 	removeNotifications := make(map[string]*keybase1.FSNotification)
+	var deletes TlfEditList
 	// TODO: can I used chains.deletedOriginals instead?  It's hard to
 	// get the full path that way.
 	for _, chain := range chains.byOriginal {
@@ -494,8 +673,20 @@ func (teh *TlfEditHistory) updateHistory(ctx context.Context,
 			// A rename op might show later that this was only renamed.
 			removed[path.String()] = true
 			// Add notification.
+			writerInfo := rop.getWriterInfo()
 			removeNotifications[path.String()] = fileDeleteNotification(
-				path, rop.getWriterInfo().uid, rop.getLocalTimestamp())
+				path, writerInfo.uid, rop.getLocalTimestamp(),
+				writerInfo.revision)
+			if rop.RemovedType == Dir || rop.RemovedType == Sym {
+				continue
+			}
+			deletes = append(deletes, TlfEdit{
+				Filepath:  path.String(),
+				Type:      FileDeleted,
+				LocalTime: rop.getLocalTimestamp(),
+				Revision:  writerInfo.revision,
+				cachedOp:  rop,
+			})
 		}
 	}
 
@@ -557,6 +748,19 @@ func (teh *TlfEditHistory) updateHistory(ctx context.Context,
 		}
 	}
 
+	// Record the deletions that weren't really the first half of a
+	// rename, in op order.
+	newDeletes := make(TlfWriterEdits)
+	for _, edit := range deletes {
+		if _, ok := removeNotifications[edit.Filepath]; !ok {
+			continue
+		}
+		writer := edit.cachedOp.getWriterInfo().uid
+		edit.cachedOp = nil
+		newDeletes[writer] = append(newDeletes[writer], edit)
+	}
+	teh.updateDeletes(removed, newDeletes)
+
 	// Remove and rename old edits as needed.
 	if len(removed)+len(renamed) > 0 {
 		teh.log.CDebugf(ctx, "Removed paths: %v, renamed paths: %v",
@@ -0,0 +1,169 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+func (t TlfEditNotificationType) String() string {
+	switch t {
+	case FileCreated:
+		return "created"
+	case FileModified:
+		return "modified"
+	case FileDeleted:
+		return "deleted"
+	default:
+		return fmt.Sprintf("TlfEditNotificationType(%d)", int(t))
+	}
+}
+
+// TlfEditExportFormat is a file format that an edit history can be
+// exported to.
+type TlfEditExportFormat int
+
+const (
+	// TlfEditExportJSON exports an edit history as a JSON array of
+	// records.
+	TlfEditExportJSON TlfEditExportFormat = iota
+	// TlfEditExportCSV exports an edit history as CSV, with a header
+	// row.
+	TlfEditExportCSV
+)
+
+func (f TlfEditExportFormat) String() string {
+	switch f {
+	case TlfEditExportJSON:
+		return "json"
+	case TlfEditExportCSV:
+		return "csv"
+	default:
+		return fmt.Sprintf("TlfEditExportFormat(%d)", int(f))
+	}
+}
+
+// ParseTlfEditExportFormat returns the TlfEditExportFormat with the
+// given name, as returned by its String method.
+func ParseTlfEditExportFormat(s string) (TlfEditExportFormat, error) {
+	switch s {
+	case "json":
+		return TlfEditExportJSON, nil
+	case "csv":
+		return TlfEditExportCSV, nil
+	default:
+		return 0, errors.Errorf("unknown edit export format %q", s)
+	}
+}
+
+// TlfEditRecord is a single exported edit history entry.
+type TlfEditRecord struct {
+	Time   time.Time `json:"time"`
+	Writer string    `json:"writer"`
+	Type   string    `json:"type"`
+	Path   string    `json:"path"`
+	// Revision is only set for deletions.
+	Revision kbfsmd.Revision `json:"revision,omitempty"`
+}
+
+type tlfEditRecordList []TlfEditRecord
+
+func (l tlfEditRecordList) Len() int {
+	return len(l)
+}
+
+func (l tlfEditRecordList) Less(i, j int) bool {
+	if !l[i].Time.Equal(l[j].Time) {
+		return l[i].Time.Before(l[j].Time)
+	}
+	if l[i].Writer != l[j].Writer {
+		return l[i].Writer < l[j].Writer
+	}
+	return l[i].Path < l[j].Path
+}
+
+func (l tlfEditRecordList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+// MakeTlfEditRecords flattens `edits` into a list of records sorted
+// by time, looking up the name of each writer.
+func MakeTlfEditRecords(ctx context.Context,
+	nug normalizedUsernameGetter, edits TlfWriterEdits) (
+	[]TlfEditRecord, error) {
+	records := make([]TlfEditRecord, 0)
+	for writer, list := range edits {
+		if len(list) == 0 {
+			continue
+		}
+		name, err := nug.GetNormalizedUsername(ctx, writer.AsUserOrTeam())
+		if err != nil {
+			return nil, err
+		}
+		for _, edit := range list {
+			records = append(records, TlfEditRecord{
+				Time:     edit.LocalTime,
+				Writer:   name.String(),
+				Type:     edit.Type.String(),
+				Path:     edit.Filepath,
+				Revision: edit.Revision,
+			})
+		}
+	}
+	sort.Sort(tlfEditRecordList(records))
+	return records, nil
+}
+
+var tlfEditCSVHeader = []string{"time", "writer", "type", "path", "revision"}
+
+// ExportTlfEditRecords writes `records` to `w` in the given format.
+// Times are written in RFC 3339 format, in UTC.
+func ExportTlfEditRecords(w io.Writer, records []TlfEditRecord,
+	format TlfEditExportFormat) error {
+	switch format {
+	case TlfEditExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		utcRecords := make([]TlfEditRecord, len(records))
+		for i, r := range records {
+			r.Time = r.Time.UTC()
+			utcRecords[i] = r
+		}
+		return errors.WithStack(enc.Encode(utcRecords))
+	case TlfEditExportCSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write(tlfEditCSVHeader)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, r := range records {
+			rev := ""
+			if r.Revision != kbfsmd.RevisionUninitialized {
+				rev = strconv.FormatInt(int64(r.Revision), 10)
+			}
+			err := cw.Write([]string{
+				r.Time.UTC().Format(time.RFC3339), r.Writer, r.Type, r.Path,
+				rev,
+			})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		cw.Flush()
+		return errors.WithStack(cw.Error())
+	default:
+		return errors.Errorf("unknown edit export format %s", format)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testEditExportNames map[keybase1.UserOrTeamID]libkb.NormalizedUsername
+
+func (n testEditExportNames) GetNormalizedUsername(
+	_ context.Context, id keybase1.UserOrTeamID) (
+	libkb.NormalizedUsername, error) {
+	return n[id], nil
+}
+
+func makeTestTlfEditRecords(t *testing.T) []TlfEditRecord {
+	uid1 := keybase1.MakeTestUID(1)
+	uid2 := keybase1.MakeTestUID(2)
+	names := testEditExportNames{
+		uid1.AsUserOrTeam(): "u1",
+		uid2.AsUserOrTeam(): "u2",
+	}
+	now := time.Date(2018, 1, 2, 15, 4, 5, 0, time.UTC)
+	edits := TlfWriterEdits{
+		uid1: TlfEditList{{
+			Filepath:  "u1,u2/a",
+			Type:      FileCreated,
+			LocalTime: now,
+		}, {
+			Filepath:  "u1,u2/b",
+			Type:      FileDeleted,
+			LocalTime: now.Add(2 * time.Minute),
+			Revision:  10,
+		}},
+		uid2: TlfEditList{{
+			Filepath:  "u1,u2/c, d",
+			Type:      FileModified,
+			LocalTime: now.Add(1 * time.Minute),
+		}},
+	}
+	records, err := MakeTlfEditRecords(context.Background(), names, edits)
+	require.NoError(t, err)
+	return records
+}
+
+func TestExportTlfEditRecordsCSV(t *testing.T) {
+	records := makeTestTlfEditRecords(t)
+
+	var buf bytes.Buffer
+	err := ExportTlfEditRecords(&buf, records, TlfEditExportCSV)
+	require.NoError(t, err)
+	require.Equal(t, `time,writer,type,path,revision
+2018-01-02T15:04:05Z,u1,created,"u1,u2/a",
+2018-01-02T15:05:05Z,u2,modified,"u1,u2/c, d",
+2018-01-02T15:06:05Z,u1,deleted,"u1,u2/b",10
+`, buf.String())
+}
+
+func TestExportTlfEditRecordsJSON(t *testing.T) {
+	records := makeTestTlfEditRecords(t)
+
+	var buf bytes.Buffer
+	err := ExportTlfEditRecords(&buf, records, TlfEditExportJSON)
+	require.NoError(t, err)
+
+	var decoded []TlfEditRecord
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	require.NoError(t, err)
+	require.Len(t, decoded, len(records))
+	for i := range records {
+		require.True(t, records[i].Time.Equal(decoded[i].Time))
+		decoded[i].Time = records[i].Time
+	}
+	require.Equal(t, records, decoded)
+}
+
+func TestParseTlfEditExportFormat(t *testing.T) {
+	for _, f := range []TlfEditExportFormat{
+		TlfEditExportJSON, TlfEditExportCSV} {
+		parsed, err := ParseTlfEditExportFormat(f.String())
+		require.NoError(t, err)
+		require.Equal(t, f, parsed)
+	}
+	_, err := ParseTlfEditExportFormat("xml")
+	require.Error(t, err)
+}
@@ -12,6 +12,8 @@ import (
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsedits"
+	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
@@ -34,6 +36,26 @@ func truncateTLFWriterEditsTimestamps(edits TlfWriterEdits) TlfWriterEdits {
 	return roundedEdits
 }
 
+// clearTLFWriterEditsRevisions is a helper function that checks that
+// every deletion has a revision set, and then clears it, since the
+// exact revision numbers depend on how many MDs the test made.
+func clearTLFWriterEditsRevisions(
+	t *testing.T, edits TlfWriterEdits) TlfWriterEdits {
+	clearedEdits := make(TlfWriterEdits)
+	for k, editList := range edits {
+		clearedEditList := make(TlfEditList, len(editList))
+		for i, edit := range editList {
+			if edit.Type == FileDeleted {
+				require.True(t, edit.Revision > kbfsmd.RevisionInitial)
+				edit.Revision = kbfsmd.RevisionUninitialized
+			}
+			clearedEditList[i] = edit
+		}
+		clearedEdits[k] = clearedEditList
+	}
+	return clearedEdits
+}
+
 func TestBasicTlfEditHistory(t *testing.T) {
 	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
 	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
@@ -106,6 +128,72 @@ func TestBasicTlfEditHistory(t *testing.T) {
 		"User2 has unexpected edit history")
 }
 
+func TestTlfEditHistoryFileHistory(t *testing.T) {
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := userName1.String() + "," + userName2.String()
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, tlf.Private)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, tlf.Private)
+
+	// Files are only indexed once the complete history is loaded.
+	kbfsOps1 := config1.KBFSOps()
+	_, err := kbfsOps1.GetEditHistory(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+	kbfsOps2 := config2.KBFSOps()
+	_, err = kbfsOps2.GetEditHistory(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// user 1 creates and writes a file, and then renames it.
+	fileNode, _, err := kbfsOps1.CreateFile(
+		ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, fileNode, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps1.Rename(ctx, rootNode1, "a", rootNode1, "b")
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	err = kbfsOps2.SyncFromServer(ctx, rootNode2.GetFolderBranch(), nil)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncFromServer(ctx, rootNode1.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	// Both users see the whole history under the new name.
+	for _, kbfsOps := range []KBFSOps{kbfsOps1, kbfsOps2} {
+		rootNode := rootNode1
+		if kbfsOps == kbfsOps2 {
+			rootNode = rootNode2
+		}
+		n, _, err := kbfsOps.Lookup(ctx, rootNode, "b")
+		require.NoError(t, err)
+		edits, err := kbfsOps.GetFileEditHistory(ctx, n)
+		require.NoError(t, err)
+		// Most recent first, and the history from before the rename
+		// is kept.
+		require.True(t, len(edits) >= 3, "%d edits", len(edits))
+		require.Equal(t, kbfsedits.NotificationRename, edits[0].Type)
+		require.Equal(t, name+"/b", edits[0].Filename)
+		require.Equal(t, name+"/a", edits[0].Params.OldFilename)
+		require.Equal(t, kbfsedits.NotificationModify, edits[1].Type)
+		types := make(map[kbfsedits.NotificationOpType]bool)
+		for _, e := range edits {
+			types[e.Type] = true
+		}
+		require.True(t, types[kbfsedits.NotificationCreate])
+	}
+}
+
 func testDoTlfEdit(t *testing.T, ctx context.Context, tlfName string,
 	kbfsOps KBFSOps, rootNode Node, i int, uid keybase1.UID, now time.Time,
 	createRemainders map[keybase1.UID]int, edits TlfWriterEdits) {
@@ -273,6 +361,17 @@ func TestLongTlfEditHistory(t *testing.T) {
 		expectedEdits[uid2][editIndex+1:]...)
 	expectedEdits[uid2] = append(expectedEdits[uid2], newEdit)
 
+	// The removes are tracked as deletions by user 1.
+	expectedEdits[uid1] = append(expectedEdits[uid1], TlfEdit{
+		Filepath:  name + "/" + rmFile1,
+		Type:      FileDeleted,
+		LocalTime: clock.Now(),
+	}, TlfEdit{
+		Filepath:  name + "/" + rmFile2,
+		Type:      FileDeleted,
+		LocalTime: clock.Now(),
+	})
+
 	// Two older edits are now on the fronts of the lists.
 	oldNow := expectedEdits[uid1][0].LocalTime.Add(-1 * time.Minute)
 	expectedEdits[uid1] = append([]TlfEdit{{
@@ -318,10 +417,12 @@ func TestLongTlfEditHistory(t *testing.T) {
 
 	require.Equal(t,
 		truncateTLFWriterEditsTimestamps(expectedEdits),
-		truncateTLFWriterEditsTimestamps(edits1),
+		truncateTLFWriterEditsTimestamps(
+			clearTLFWriterEditsRevisions(t, edits1)),
 		"User1 has unexpected edit history")
 	require.Equal(t,
 		truncateTLFWriterEditsTimestamps(expectedEdits),
-		truncateTLFWriterEditsTimestamps(edits2),
+		truncateTLFWriterEditsTimestamps(
+			clearTLFWriterEditsRevisions(t, edits2)),
 		"User2 has unexpected edit history")
 }
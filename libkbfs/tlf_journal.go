@@ -264,16 +264,25 @@ type tlfJournal struct {
 	pauseLock sync.Mutex
 	pauseType tlfJournalPauseType
 
+	// Protects flushBatchPeriod, which is how long background work
+	// waits for more writes before flushing.
+	flushBatchLock   sync.Mutex
+	flushBatchPeriod time.Duration
+
 	// This channel is closed when background work shuts down.
 	backgroundShutdownCh chan struct{}
 
-	// Serializes all flushes, and protects `lastServerMDCheck` and
-	// `singleOpMode`.
+	// Serializes all flushes, and protects `lastServerMDCheck`,
+	// `singleOpMode` and `singleOpTemporary`.
 	flushLock            sync.Mutex
 	lastServerMDCheck    time.Time
 	singleOpMode         singleOpMode
 	finishSingleOpCh     chan flushContext
 	singleOpFlushContext flushContext
+	// singleOpTemporary is true if single op mode was turned on by
+	// `beginSingleOp`, and should be turned off again once the op
+	// has been flushed.
+	singleOpTemporary bool
 
 	// Tracks background work.
 	wg kbfssync.RepeatedWaitGroup
@@ -700,12 +709,45 @@ func (j *tlfJournal) doBackgroundWork(ctx context.Context) <-chan error {
 	// TODO: Handle panics.
 	go func() {
 		defer j.wg.Done()
-		errCh <- j.flush(ctx)
+		err := j.waitForFlushBatch(ctx)
+		if err == nil {
+			err = j.flush(ctx)
+		}
+		errCh <- err
 		close(errCh)
 	}()
 	return errCh
 }
 
+func (j *tlfJournal) setFlushBatchPeriod(period time.Duration) {
+	j.flushBatchLock.Lock()
+	defer j.flushBatchLock.Unlock()
+	j.flushBatchPeriod = period
+}
+
+// waitForFlushBatch waits out the flush batch period, if any, so
+// that writes made in the meantime are flushed along with the ones
+// that triggered the flush.
+func (j *tlfJournal) waitForFlushBatch(ctx context.Context) error {
+	j.flushBatchLock.Lock()
+	period := j.flushBatchPeriod
+	j.flushBatchLock.Unlock()
+	if period <= 0 {
+		return nil
+	}
+
+	j.log.CDebugf(ctx, "Waiting %s for more writes to %s before flushing",
+		period, j.tlfID)
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // We don't guarantee that background pause/resume requests will be
 // processed in strict FIFO order. In particular, multiple pause
 // requests are collapsed into one (also multiple resume requests), so
@@ -880,8 +922,14 @@ func (j *tlfJournal) flush(ctx context.Context) (err error) {
 				j.singleOpMode == singleOpRunning) {
 			j.log.CDebugf(ctx, "Nothing else to flush")
 			if j.singleOpMode == singleOpFinished {
-				j.log.CDebugf(ctx, "Resetting single op mode")
-				j.singleOpMode = singleOpRunning
+				if j.singleOpTemporary {
+					j.log.CDebugf(ctx, "Turning off single op mode")
+					j.singleOpMode = singleOpDisabled
+					j.singleOpTemporary = false
+				} else {
+					j.log.CDebugf(ctx, "Resetting single op mode")
+					j.singleOpMode = singleOpRunning
+				}
 				j.singleOpFlushContext = defaultFlushContext()
 			}
 			break
@@ -1070,6 +1118,9 @@ func (j *tlfJournal) flushBlockEntries(
 	ctx context.Context, end journalOrdinal) (
 	numFlushed int, maxMDRevToFlush kbfsmd.Revision,
 	converted bool, err error) {
+	// Don't let flushes hog the block server connection while the
+	// user is waiting on reads.
+	ctx = ctxWithBackgroundBServerPriority(ctx)
 	entries, maxMDRevToFlush, err := j.getNextBlockEntriesToFlush(ctx, end)
 	if err != nil {
 		return 0, kbfsmd.RevisionUninitialized, false, err
@@ -2440,6 +2491,22 @@ func (j *tlfJournal) waitForCompleteFlush(ctx context.Context) error {
 	}
 }
 
+// beginSingleOp puts the journal into single op mode, if it isn't in
+// it already, until the next `finishSingleOp` has been flushed.  It
+// waits for any flush in progress to finish first, so MDs put before
+// this call may still be flushed on their own.
+func (j *tlfJournal) beginSingleOp(ctx context.Context) {
+	j.flushLock.Lock()
+	defer j.flushLock.Unlock()
+	if j.singleOpMode != singleOpDisabled {
+		j.log.CDebugf(ctx, "Already in single op mode")
+		return
+	}
+	j.log.CDebugf(ctx, "Beginning single op")
+	j.singleOpMode = singleOpRunning
+	j.singleOpTemporary = true
+}
+
 func (j *tlfJournal) finishSingleOp(ctx context.Context,
 	lc *keybase1.LockContext, priority keybase1.MDPriority) error {
 	j.log.CDebugf(ctx, "Finishing single op")
@@ -108,13 +108,13 @@ func CtxWithRandomIDReplayable(ctx context.Context, tagKey interface{},
 	})
 }
 
-// checkDataVersion validates that the data version for a
-// block pointer is valid for the given version validator
-func checkDataVersion(versioner dataVersioner, p path, ptr BlockPointer) error {
+// checkDataVersion validates that the data version for a block
+// pointer is one that this client can read.
+func checkDataVersion(p path, ptr BlockPointer) error {
 	if ptr.DataVer < FirstValidDataVer {
 		return InvalidDataVersionError{ptr.DataVer}
 	}
-	if versioner != nil && ptr.DataVer > versioner.DataVersion() {
+	if ptr.DataVer > maxReadableDataVer {
 		return NewDataVersionError{p, ptr.DataVer}
 	}
 	return nil
@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libmirror
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ignorePattern is one parsed ignore pattern.
+type ignorePattern struct {
+	glob string
+	// anchored patterns match the whole path from the mirror root,
+	// rather than just a base name.
+	anchored bool
+	dirOnly  bool
+}
+
+// ignoreMatcher decides which paths aren't mirrored.  Patterns work
+// like those in .gitignore, except that negation ("!") and "**" are
+// not supported:
+//
+//   - "*.tmp" matches any entry with a name ending in ".tmp";
+//   - "build/" matches only directories named "build";
+//   - "/cache" or "docs/*.pdf" match paths relative to the root.
+//
+// Blank lines and lines starting with "#" are skipped.
+type ignoreMatcher struct {
+	patterns []ignorePattern
+}
+
+func newIgnoreMatcher(patterns []string) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		if strings.HasPrefix(p, "!") || strings.Contains(p, "**") {
+			return nil, errors.Errorf(
+				"Ignore pattern %q uses unsupported syntax", p)
+		}
+		var ip ignorePattern
+		if strings.HasSuffix(p, "/") {
+			ip.dirOnly = true
+			p = strings.TrimSuffix(p, "/")
+		}
+		if strings.Contains(p, "/") {
+			ip.anchored = true
+			p = strings.TrimPrefix(p, "/")
+		}
+		// Check the syntax now, so matching can't fail later.
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, errors.Errorf("Bad ignore pattern %q: %v", p, err)
+		}
+		ip.glob = p
+		m.patterns = append(m.patterns, ip)
+	}
+	return m, nil
+}
+
+// ignored returns whether the entry at `p`, a slash-separated path
+// relative to the mirror root, is ignored.  Entries within ignored
+// directories are never checked, since they're never walked.
+func (m *ignoreMatcher) ignored(p string, isDir bool) bool {
+	name := path.Base(p)
+	for _, ip := range m.patterns {
+		if ip.dirOnly && !isDir {
+			continue
+		}
+		target := name
+		if ip.anchored {
+			target = p
+		}
+		if ok, _ := path.Match(ip.glob, target); ok {
+			return true
+		}
+	}
+	return false
+}
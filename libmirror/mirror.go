@@ -0,0 +1,557 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package libmirror one-way mirrors a local directory into KBFS (or
+// any other billy.Filesystem).
+package libmirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	// DefaultInterval is how often Run rescans the source, unless
+	// told otherwise.
+	DefaultInterval = 10 * time.Second
+	// tempPrefix starts the names of the files that copies are
+	// written to before being renamed into place.  It mustn't start
+	// with ".kbfs", since KBFS doesn't allow creating such names.
+	tempPrefix = ".kbmirror-"
+	// KBFSReservedPattern matches the names KBFS reserves for
+	// itself (like its trash), which mirrors into KBFS should
+	// ignore, so that they're never removed.
+	KBFSReservedPattern = ".kbfs_*"
+)
+
+// ActionType is the kind of change a pass makes to the destination.
+type ActionType string
+
+const (
+	// ActionMkdir creates a directory.
+	ActionMkdir ActionType = "mkdir"
+	// ActionCopy copies a new or changed file.
+	ActionCopy ActionType = "copy"
+	// ActionRename moves a file whose contents match a new file in
+	// the source, instead of copying it again.
+	ActionRename ActionType = "rename"
+	// ActionSymlink creates or replaces a symlink.
+	ActionSymlink ActionType = "symlink"
+	// ActionRemove removes an entry, and everything in it, that's no
+	// longer in the source.
+	ActionRemove ActionType = "remove"
+)
+
+// Action is one change to the destination.  Paths are slash-separated
+// and relative to the roots of the mirror.
+type Action struct {
+	Type ActionType `json:"type"`
+	Path string     `json:"path"`
+	// OldPath is set for renames.
+	OldPath string `json:"oldPath,omitempty"`
+	// Size is set for copies and renames.
+	Size int64 `json:"size,omitempty"`
+}
+
+// Result describes one pass of a mirror.
+type Result struct {
+	// Actions lists the changes made, or with Options.DryRun, the
+	// changes that would have been made, in the order they're done.
+	Actions []Action `json:"actions"`
+	// Unchanged counts the files that were already up to date.
+	Unchanged int  `json:"unchanged"`
+	DryRun    bool `json:"dryRun"`
+}
+
+// Options configure an Engine.
+type Options struct {
+	// Ignore lists patterns for source entries that aren't
+	// mirrored; see ignoreMatcher for the syntax.  Matching entries
+	// in the destination are left alone.
+	Ignore []string
+	// DryRun, if true, only works out what each pass would do.
+	DryRun bool
+	// Interval is how often Run rescans the source, or
+	// DefaultInterval if zero.
+	Interval time.Duration
+}
+
+type syncer interface {
+	SyncAll() error
+}
+
+func syncFS(fs billy.Filesystem) error {
+	if s, ok := fs.(syncer); ok {
+		return s.SyncAll()
+	}
+	return nil
+}
+
+// entry is what a pass needs to know about a file, directory or
+// symlink on either side.
+type entry struct {
+	mode   os.FileMode
+	size   int64
+	mtime  time.Time
+	target string
+}
+
+func makeEntry(fi os.FileInfo) entry {
+	return entry{mode: fi.Mode(), size: fi.Size(), mtime: fi.ModTime()}
+}
+
+func (e entry) typ() os.FileMode {
+	return e.mode & os.ModeType
+}
+
+func (e entry) isExec() bool {
+	return e.mode&0100 != 0
+}
+
+type hashKey struct {
+	path  string
+	size  int64
+	mtime int64
+}
+
+type contentHash [sha256.Size]byte
+
+// Engine mirrors a local directory into a destination filesystem.
+// Each pass compares the two trees, and changes the destination to
+// match the source.  Files are taken to be unchanged if their size,
+// modification time and executable bit match; new files whose
+// contents match a file that's gone from the source are renamed
+// rather than copied.
+type Engine struct {
+	source  string
+	dest    billy.Filesystem
+	options Options
+	ignore  *ignoreMatcher
+
+	// lock makes passes run one at a time, and protects destHashes.
+	lock sync.Mutex
+	// destHashes caches the hashes of destination files, so that
+	// rename detection doesn't read them again on later passes.
+	destHashes map[hashKey]contentHash
+}
+
+// NewEngine returns an Engine that mirrors the local directory
+// `source` into `dest`.
+func NewEngine(source string, dest billy.Filesystem, options Options) (
+	*Engine, error) {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", source)
+	}
+	ignore, err := newIgnoreMatcher(options.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	if options.Interval <= 0 {
+		options.Interval = DefaultInterval
+	}
+	return &Engine{
+		source:     source,
+		dest:       dest,
+		options:    options,
+		ignore:     ignore,
+		destHashes: make(map[hashKey]contentHash),
+	}, nil
+}
+
+func (e *Engine) localPath(p string) string {
+	return filepath.Join(e.source, filepath.FromSlash(p))
+}
+
+func (e *Engine) scanLocal() (map[string]entry, error) {
+	entries := make(map[string]entry)
+	err := filepath.Walk(e.source, func(
+		p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// It went away during the walk; the next pass will
+			// catch up.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(e.source, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if e.ignore.ignored(rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ent := makeEntry(fi)
+		switch {
+		case fi.IsDir(), fi.Mode().IsRegular():
+		case fi.Mode()&os.ModeSymlink != 0:
+			ent.target, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		default:
+			// Sockets, devices and the like can't be mirrored.
+			return nil
+		}
+		entries[rel] = ent
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (e *Engine) scanDestDir(dir string, entries map[string]entry) error {
+	fis, err := e.dest.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		p := path.Join(dir, fi.Name())
+		if e.ignore.ignored(p, fi.IsDir()) {
+			continue
+		}
+		ent := makeEntry(fi)
+		switch {
+		case fi.IsDir():
+			if err := e.scanDestDir(p, entries); err != nil {
+				return err
+			}
+		case fi.Mode()&os.ModeSymlink != 0:
+			ent.target, err = e.dest.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+		entries[p] = ent
+	}
+	return nil
+}
+
+func (e *Engine) scanDest() (map[string]entry, error) {
+	entries := make(map[string]entry)
+	if err := e.scanDestDir("", entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func hashReader(r io.Reader) (h contentHash, err error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return contentHash{}, err
+	}
+	copy(h[:], hasher.Sum(nil))
+	return h, nil
+}
+
+func (e *Engine) hashLocal(p string) (contentHash, error) {
+	f, err := os.Open(e.localPath(p))
+	if err != nil {
+		return contentHash{}, err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func (e *Engine) hashDest(p string, ent entry) (contentHash, error) {
+	key := hashKey{p, ent.size, ent.mtime.UnixNano()}
+	if h, ok := e.destHashes[key]; ok {
+		return h, nil
+	}
+	f, err := e.dest.Open(p)
+	if err != nil {
+		return contentHash{}, err
+	}
+	defer f.Close()
+	h, err := hashReader(f)
+	if err != nil {
+		return contentHash{}, err
+	}
+	e.destHashes[key] = h
+	return h, nil
+}
+
+func sortedPaths(entries map[string]entry) []string {
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// removeTree drops `p` and everything under it from `entries`.
+func removeTree(entries map[string]entry, p string) {
+	delete(entries, p)
+	for q := range entries {
+		if strings.HasPrefix(q, p+"/") {
+			delete(entries, q)
+		}
+	}
+}
+
+// hasAncestorIn returns whether any parent directory of `p` is in
+// `set`.
+func hasAncestorIn(p string, set map[string]bool) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if set[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// plan works out the actions that make `dest` match `local`.
+func (e *Engine) plan(local, dest map[string]entry) (Result, error) {
+	var conflicts, mkdirs, renames, copies, links, removes []Action
+	var res Result
+	localPaths := sortedPaths(local)
+
+	// Entries that are a different type in the source have to go
+	// before anything else happens.
+	for _, p := range localPaths {
+		if d, ok := dest[p]; ok && d.typ() != local[p].typ() {
+			conflicts = append(conflicts, Action{Type: ActionRemove, Path: p})
+			removeTree(dest, p)
+		}
+	}
+
+	var newFiles []string
+	for _, p := range localPaths {
+		l := local[p]
+		d, exists := dest[p]
+		switch {
+		case l.mode.IsDir():
+			if !exists {
+				mkdirs = append(mkdirs, Action{Type: ActionMkdir, Path: p})
+			}
+		case l.mode&os.ModeSymlink != 0:
+			if !exists || d.target != l.target {
+				links = append(links, Action{Type: ActionSymlink, Path: p})
+			}
+		case !exists:
+			newFiles = append(newFiles, p)
+		case d.size == l.size && d.mtime.Equal(l.mtime) &&
+			d.isExec() == l.isExec():
+			res.Unchanged++
+		default:
+			copies = append(copies,
+				Action{Type: ActionCopy, Path: p, Size: l.size})
+		}
+	}
+
+	var gone []string
+	goneBySize := make(map[int64][]string)
+	for _, p := range sortedPaths(dest) {
+		if _, ok := local[p]; ok {
+			continue
+		}
+		gone = append(gone, p)
+		if d := dest[p]; d.mode.IsRegular() && d.size > 0 {
+			goneBySize[d.size] = append(goneBySize[d.size], p)
+		}
+	}
+
+	renamed := make(map[string]bool)
+	for _, p := range newFiles {
+		l := local[p]
+		action := Action{Type: ActionCopy, Path: p, Size: l.size}
+		if candidates := goneBySize[l.size]; len(candidates) > 0 {
+			h, err := e.hashLocal(p)
+			if err != nil {
+				return Result{}, err
+			}
+			for i, c := range candidates {
+				dh, err := e.hashDest(c, dest[c])
+				if err != nil {
+					return Result{}, err
+				}
+				if dh == h {
+					action.Type, action.OldPath = ActionRename, c
+					renamed[c] = true
+					goneBySize[l.size] = append(
+						candidates[:i:i], candidates[i+1:]...)
+					break
+				}
+			}
+		}
+		if action.Type == ActionRename {
+			renames = append(renames, action)
+		} else {
+			copies = append(copies, action)
+		}
+	}
+
+	removedDirs := make(map[string]bool)
+	for _, p := range gone {
+		if renamed[p] || hasAncestorIn(p, removedDirs) {
+			continue
+		}
+		if dest[p].mode.IsDir() {
+			removedDirs[p] = true
+		}
+		removes = append(removes, Action{Type: ActionRemove, Path: p})
+	}
+
+	for _, actions := range [][]Action{
+		conflicts, mkdirs, renames, copies, links, removes} {
+		res.Actions = append(res.Actions, actions...)
+	}
+	return res, nil
+}
+
+// setAttrs makes the executable bit and modification time of `p` in
+// the destination match the source, where the destination supports
+// it.
+func (e *Engine) setAttrs(p string, l entry) error {
+	change, ok := e.dest.(billy.Change)
+	if !ok {
+		return nil
+	}
+	mode := os.FileMode(0644)
+	if l.isExec() {
+		mode = 0755
+	}
+	if err := change.Chmod(p, mode); err != nil {
+		return err
+	}
+	return change.Chtimes(p, l.mtime, l.mtime)
+}
+
+func (e *Engine) copyFile(p string, l entry) (err error) {
+	f, err := os.Open(e.localPath(p))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp, err := util.TempFile(e.dest, path.Dir(p), tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			e.dest.Remove(tmp.Name())
+		}
+	}()
+	_, err = io.Copy(tmp, f)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := e.dest.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+	return e.setAttrs(p, l)
+}
+
+func (e *Engine) apply(ctx context.Context, actions []Action,
+	local map[string]entry) error {
+	for _, a := range actions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		switch a.Type {
+		case ActionMkdir:
+			err = e.dest.MkdirAll(a.Path, 0755)
+		case ActionCopy:
+			err = e.copyFile(a.Path, local[a.Path])
+		case ActionRename:
+			err = e.dest.Rename(a.OldPath, a.Path)
+			if err == nil {
+				err = e.setAttrs(a.Path, local[a.Path])
+			}
+		case ActionSymlink:
+			err = util.RemoveAll(e.dest, a.Path)
+			if err == nil {
+				err = e.dest.Symlink(local[a.Path].target, a.Path)
+			}
+		case ActionRemove:
+			err = util.RemoveAll(e.dest, a.Path)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't %s %s", a.Type, a.Path)
+		}
+	}
+	return syncFS(e.dest)
+}
+
+// Sync runs one pass of the mirror.
+func (e *Engine) Sync(ctx context.Context) (Result, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	local, err := e.scanLocal()
+	if err != nil {
+		return Result{}, err
+	}
+	dest, err := e.scanDest()
+	if err != nil {
+		return Result{}, err
+	}
+	res, err := e.plan(local, dest)
+	if err != nil {
+		return Result{}, err
+	}
+	res.DryRun = e.options.DryRun
+	if e.options.DryRun {
+		return res, nil
+	}
+	if err := e.apply(ctx, res.Actions, local); err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// Run runs a pass of the mirror every Options.Interval, calling
+// `onPass` after each one, until `ctx` is done.  Errors don't stop
+// it, since the next pass may well succeed.
+func (e *Engine) Run(ctx context.Context, onPass func(Result, error)) error {
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+	for {
+		res, err := e.Sync(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if onPass != nil {
+			onPass(res, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libmirror
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, p string, data string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, ioutil.WriteFile(p, []byte(data), 0644))
+}
+
+func readTestFile(t *testing.T, p string) string {
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func requireActions(t *testing.T, expected []Action, res Result) {
+	require.Equal(t, expected, res.Actions)
+}
+
+func TestIgnore(t *testing.T) {
+	m, err := newIgnoreMatcher([]string{
+		"# comment", "", "*.tmp", "build/", "/cache", "docs/*.pdf"})
+	require.NoError(t, err)
+	require.True(t, m.ignored("a/b/x.tmp", false))
+	require.True(t, m.ignored("src/build", true))
+	require.False(t, m.ignored("src/build", false))
+	require.True(t, m.ignored("cache", true))
+	require.False(t, m.ignored("a/cache", true))
+	require.True(t, m.ignored("docs/x.pdf", false))
+	require.False(t, m.ignored("docs/sub/x.pdf", false))
+	require.False(t, m.ignored("x.go", false))
+
+	_, err = newIgnoreMatcher([]string{"!keep"})
+	require.Error(t, err)
+	_, err = newIgnoreMatcher([]string{"a/**/b"})
+	require.Error(t, err)
+	_, err = newIgnoreMatcher([]string{"[x"})
+	require.Error(t, err)
+}
+
+func TestMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libmirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dstRoot := filepath.Join(dir, "dst")
+	require.NoError(t, os.MkdirAll(dstRoot, 0755))
+	dst := testutil.NewChangeFS(dstRoot)
+	ctx := context.Background()
+
+	writeTestFile(t, filepath.Join(src, "a/one.txt"), "one")
+	writeTestFile(t, filepath.Join(src, "a/b/two.txt"), "two")
+	writeTestFile(t, filepath.Join(src, "skip.tmp"), "tmp")
+	require.NoError(t, os.Symlink("a/one.txt", filepath.Join(src, "link")))
+	require.NoError(t, os.Chmod(filepath.Join(src, "a/b/two.txt"), 0755))
+
+	t.Log("A dry run plans without changing anything")
+	dry, err := NewEngine(src, dst, Options{
+		Ignore: []string{"*.tmp"}, DryRun: true})
+	require.NoError(t, err)
+	res, err := dry.Sync(ctx)
+	require.NoError(t, err)
+	require.True(t, res.DryRun)
+	requireActions(t, []Action{
+		{Type: ActionMkdir, Path: "a"},
+		{Type: ActionMkdir, Path: "a/b"},
+		{Type: ActionCopy, Path: "a/b/two.txt", Size: 3},
+		{Type: ActionCopy, Path: "a/one.txt", Size: 3},
+		{Type: ActionSymlink, Path: "link"},
+	}, res)
+	fis, err := ioutil.ReadDir(dstRoot)
+	require.NoError(t, err)
+	require.Len(t, fis, 0)
+
+	t.Log("The first pass copies everything but ignored files")
+	e, err := NewEngine(src, dst, Options{Ignore: []string{"*.tmp"}})
+	require.NoError(t, err)
+	res, err = e.Sync(ctx)
+	require.NoError(t, err)
+	require.Len(t, res.Actions, 5)
+	require.Equal(t, "two", readTestFile(t, filepath.Join(dstRoot, "a/b/two.txt")))
+	fi, err := os.Stat(filepath.Join(dstRoot, "a/b/two.txt"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	target, err := os.Readlink(filepath.Join(dstRoot, "link"))
+	require.NoError(t, err)
+	require.Equal(t, "a/one.txt", target)
+	_, err = os.Stat(filepath.Join(dstRoot, "skip.tmp"))
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Nothing changes on the next pass")
+	res, err = e.Sync(ctx)
+	require.NoError(t, err)
+	requireActions(t, nil, res)
+	require.Equal(t, 2, res.Unchanged)
+
+	t.Log("Renames are detected, changes copied and removals mirrored")
+	require.NoError(t, os.Rename(
+		filepath.Join(src, "a/b/two.txt"), filepath.Join(src, "moved.txt")))
+	require.NoError(t, os.RemoveAll(filepath.Join(src, "a/b")))
+	writeTestFile(t, filepath.Join(src, "a/one.txt"), "ONE!")
+	writeTestFile(t, filepath.Join(dstRoot, "dst-only.tmp"), "keep")
+	res, err = e.Sync(ctx)
+	require.NoError(t, err)
+	requireActions(t, []Action{
+		{Type: ActionRename, Path: "moved.txt", OldPath: "a/b/two.txt",
+			Size: 3},
+		{Type: ActionCopy, Path: "a/one.txt", Size: 4},
+		{Type: ActionRemove, Path: "a/b"},
+	}, res)
+	require.Equal(t, "two", readTestFile(t, filepath.Join(dstRoot, "moved.txt")))
+	require.Equal(t, "ONE!", readTestFile(t, filepath.Join(dstRoot, "a/one.txt")))
+	_, err = os.Stat(filepath.Join(dstRoot, "a/b"))
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, "keep",
+		readTestFile(t, filepath.Join(dstRoot, "dst-only.tmp")))
+
+	t.Log("A file replaced by a directory")
+	require.NoError(t, os.Remove(filepath.Join(src, "moved.txt")))
+	writeTestFile(t, filepath.Join(src, "moved.txt/inner"), "x")
+	res, err = e.Sync(ctx)
+	require.NoError(t, err)
+	requireActions(t, []Action{
+		{Type: ActionRemove, Path: "moved.txt"},
+		{Type: ActionMkdir, Path: "moved.txt"},
+		{Type: ActionCopy, Path: "moved.txt/inner", Size: 1},
+	}, res)
+	require.Equal(t, "x",
+		readTestFile(t, filepath.Join(dstRoot, "moved.txt/inner")))
+
+	t.Log("Run keeps passing until canceled")
+	runCtx, cancel := context.WithCancel(ctx)
+	e.options.Interval = time.Millisecond
+	passes := 0
+	err = e.Run(runCtx, func(res Result, err error) {
+		require.NoError(t, err)
+		passes++
+		if passes == 3 {
+			cancel()
+		}
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 3, passes)
+}
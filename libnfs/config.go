@@ -0,0 +1,119 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// DefaultAttrCacheTTL is how long attributes are cached for an export
+// that doesn't say otherwise.
+const DefaultAttrCacheTTL = time.Second
+
+// ExportConfig configures a single exported TLF.
+type ExportConfig struct {
+	// TLF is the TLF to export, given as "<type>/<name>"; clients
+	// mount it at "/<type>/<name>".
+	TLF string `json:"tlf"`
+	// ReadOnly rejects all changes made through the export.
+	ReadOnly bool `json:"read_only"`
+	// Clients lists the networks, in CIDR notation, allowed to
+	// access the export.  If empty, any client that can reach the
+	// server can.
+	Clients []string `json:"clients"`
+	// AttrCacheTTL is how long file attributes are cached by the
+	// server, e.g. "500ms".  Changes made by other devices can take
+	// this long to show up, on top of any client-side caching.  "0"
+	// disables the cache; if unset, DefaultAttrCacheTTL is used.
+	AttrCacheTTL string `json:"attr_cache_ttl"`
+}
+
+// Config configures the NFS server.
+type Config struct {
+	Exports []ExportConfig `json:"exports"`
+	// OwnerUID and OwnerGID are reported to clients as the owner of
+	// every file, since KBFS has no notion of local users.
+	OwnerUID uint32 `json:"owner_uid"`
+	OwnerGID uint32 `json:"owner_gid"`
+}
+
+// ParseConfig reads a JSON NFS config from `reader`, and validates it.
+func ParseConfig(reader io.Reader) (config Config, err error) {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&config); err != nil {
+		return Config{}, err
+	}
+	if err = config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Validate checks that the config is usable.
+func (c Config) Validate() error {
+	if len(c.Exports) == 0 {
+		return errors.New("No exports configured")
+	}
+	seen := make(map[string]bool, len(c.Exports))
+	for _, e := range c.Exports {
+		parsed, err := e.parse()
+		if err != nil {
+			return err
+		}
+		if seen[parsed.dirPath] {
+			return errors.Errorf("%s is exported more than once", e.TLF)
+		}
+		seen[parsed.dirPath] = true
+	}
+	return nil
+}
+
+// parsedExportConfig is an ExportConfig with its fields parsed.
+type parsedExportConfig struct {
+	tlfType      tlf.Type
+	name         string
+	dirPath      string
+	readOnly     bool
+	clients      []*net.IPNet
+	attrCacheTTL time.Duration
+}
+
+func (e ExportConfig) parse() (parsedExportConfig, error) {
+	tlfType, name, err := libfs.ParseTlfPath(e.TLF)
+	if err != nil {
+		return parsedExportConfig{}, err
+	}
+	parsed := parsedExportConfig{
+		tlfType:      tlfType,
+		name:         name,
+		dirPath:      "/" + libfs.TlfTypePathString(tlfType) + "/" + name,
+		readOnly:     e.ReadOnly,
+		attrCacheTTL: DefaultAttrCacheTTL,
+	}
+	for _, c := range e.Clients {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return parsedExportConfig{}, errors.Wrapf(
+				err, "Bad client network for %s", e.TLF)
+		}
+		parsed.clients = append(parsed.clients, n)
+	}
+	if e.AttrCacheTTL != "" {
+		parsed.attrCacheTTL, err = time.ParseDuration(e.AttrCacheTTL)
+		if err != nil {
+			return parsedExportConfig{}, errors.Wrapf(
+				err, "Bad attribute cache TTL for %s", e.TLF)
+		}
+	}
+	return parsed, nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// nfsFS is the subset of billy.Filesystem that an export needs.
+// SETATTR applies modes and times through billy.Change (which tlfFS
+// implements); on an export without it, only sizes can be set.
+type nfsFS interface {
+	billy.Basic
+	billy.Dir
+	billy.Symlink
+}
+
+// export is a single filesystem exported over NFS.
+type export struct {
+	// dirPath is the path clients mount, e.g. "/private/alice".
+	dirPath  string
+	fsid     uint64
+	fs       nfsFS
+	readOnly bool
+	// clients lists the networks allowed to mount the export; if
+	// empty, anyone can.
+	clients []*net.IPNet
+	attrs   *attrCache
+}
+
+func (e *export) allows(addr net.Addr) bool {
+	if len(e.clients) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range e.clients {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *export) sync() error {
+	if s, ok := e.fs.(libfs.Syncer); ok {
+		return s.SyncAll()
+	}
+	return nil
+}
+
+// stat returns the attributes of `p`, from the attribute cache if
+// possible.  Like Lstat, it doesn't follow a final symlink.
+func (e *export) stat(p string) (os.FileInfo, error) {
+	if fi, ok := e.attrs.get(p); ok {
+		return fi, nil
+	}
+	fi, err := e.fs.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	e.attrs.put(p, fi)
+	return fi, nil
+}
+
+// changed must be called after `p` (and thus its parent directory)
+// has been modified through this server.
+func (e *export) changed(p string) {
+	e.attrs.invalidate(p)
+	e.attrs.invalidate(path.Dir(p))
+}
+
+// attrCache caches file attributes for a short time, so that the
+// stream of GETATTR and LOOKUP calls that NFS clients send to
+// revalidate their own caches doesn't hit KBFS every time.  Changes
+// made through this server invalidate the cache right away; changes
+// made elsewhere show up once the TTL expires.
+type attrCache struct {
+	ttl time.Duration
+
+	lock    sync.Mutex
+	entries map[string]cachedAttr
+}
+
+type cachedAttr struct {
+	fi      os.FileInfo
+	expires time.Time
+}
+
+// maxAttrCacheEntries bounds the size of an attrCache; once it's
+// full, expired entries are dropped, and if that's not enough, the
+// whole cache is.
+const maxAttrCacheEntries = 10000
+
+func newAttrCache(ttl time.Duration) *attrCache {
+	return &attrCache{ttl: ttl, entries: make(map[string]cachedAttr)}
+}
+
+func (c *attrCache) get(p string) (os.FileInfo, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[p]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.fi, true
+}
+
+func (c *attrCache) put(p string, fi os.FileInfo) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxAttrCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxAttrCacheEntries {
+			c.entries = make(map[string]cachedAttr)
+		}
+	}
+	c.entries[p] = cachedAttr{fi, now.Add(c.ttl)}
+}
+
+// invalidate drops `p`, and everything under it, from the cache.
+func (c *attrCache) invalidate(p string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, p)
+	prefix := p + "/"
+	if p == "/" {
+		prefix = "/"
+	}
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// handleLen is the length of the file handles we hand out.
+const handleLen = 8
+
+type handleEntry struct {
+	exp  *export
+	path string
+}
+
+// handleTable maps NFS file handles to paths.  Handles are just
+// sequence numbers, and only live as long as the server does;
+// clients that hold on to handles across a restart get
+// NFS3ERR_STALE, and have to remount.  A handle's number also serves
+// as its file's fileid.  Handles follow their files across renames
+// made through this server.
+type handleTable struct {
+	lock   sync.Mutex
+	next   uint64
+	byID   map[uint64]handleEntry
+	byPath map[handleEntry]uint64
+}
+
+func newHandleTable() *handleTable {
+	return &handleTable{
+		next:   1,
+		byID:   make(map[uint64]handleEntry),
+		byPath: make(map[handleEntry]uint64),
+	}
+}
+
+// get returns the handle ID for `p` in `exp`, making a new one if
+// needed.
+func (t *handleTable) get(exp *export, p string) uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entry := handleEntry{exp, p}
+	if id, ok := t.byPath[entry]; ok {
+		return id
+	}
+	id := t.next
+	t.next++
+	t.byID[id] = entry
+	t.byPath[entry] = id
+	return id
+}
+
+func (t *handleTable) lookup(id uint64) (handleEntry, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entry, ok := t.byID[id]
+	return entry, ok
+}
+
+// rename moves all handles for `oldPath`, and everything under it,
+// to the corresponding paths under `newPath`.  Any handle already at
+// the new path is dropped.
+func (t *handleTable) rename(exp *export, oldPath, newPath string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if id, ok := t.byPath[handleEntry{exp, newPath}]; ok {
+		delete(t.byID, id)
+		delete(t.byPath, handleEntry{exp, newPath})
+	}
+	prefix := oldPath + "/"
+	moved := make(map[uint64]handleEntry)
+	for entry, id := range t.byPath {
+		if entry.exp != exp {
+			continue
+		}
+		switch {
+		case entry.path == oldPath:
+			moved[id] = handleEntry{exp, newPath}
+		case strings.HasPrefix(entry.path, prefix):
+			moved[id] = handleEntry{exp,
+				newPath + "/" + strings.TrimPrefix(entry.path, prefix)}
+		}
+	}
+	for id, newEntry := range moved {
+		delete(t.byPath, t.byID[id])
+		t.byPath[newEntry] = id
+		t.byID[id] = newEntry
+	}
+}
+
+func encodeHandle(id uint64) []byte {
+	var b [handleLen]byte
+	binary.BigEndian.PutUint64(b[:], id)
+	return b[:]
+}
+
+func decodeHandle(b []byte) (uint64, bool) {
+	if len(b) != handleLen {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(b), true
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"path"
+)
+
+// This file implements version 3 of the MOUNT protocol (RFC 1813,
+// appendix I).
+
+const (
+	mountProgram = 100005
+	mountVersion = 3
+
+	mountProcNull    = 0
+	mountProcMnt     = 1
+	mountProcDump    = 2
+	mountProcUmnt    = 3
+	mountProcUmntAll = 4
+	mountProcExport  = 5
+
+	mountOK     = 0
+	mountNoEnt  = 2
+	mountAccess = 13
+
+	// maxMountPathLen is MNTPATHLEN.
+	maxMountPathLen = 1024
+)
+
+type mountProgramV3 struct {
+	s *nfsServer
+}
+
+var _ rpcProgram = mountProgramV3{}
+
+func (m mountProgramV3) handle(call *rpcCall) (*xdrWriter, acceptStat) {
+	w := &xdrWriter{}
+	switch call.proc {
+	case mountProcNull:
+		return w, acceptSuccess
+	case mountProcMnt:
+		dirPath := call.args.string(maxMountPathLen)
+		if call.args.err != nil {
+			return nil, acceptGarbageArgs
+		}
+		exp, ok := m.s.exportsByDir[path.Clean("/"+dirPath)]
+		if !ok {
+			return w.uint32(mountNoEnt), acceptSuccess
+		}
+		if !exp.allows(call.remoteAddr) {
+			return w.uint32(mountAccess), acceptSuccess
+		}
+		id := m.s.handles.get(exp, "/")
+		// We ignore credentials, so any flavor the client likes is
+		// fine; offer AUTH_SYS since that's what clients expect.
+		return w.uint32(mountOK).opaque(encodeHandle(id)).
+			uint32(1).uint32(authSys), acceptSuccess
+	case mountProcDump:
+		// We don't keep track of mounts.
+		return w.bool(false), acceptSuccess
+	case mountProcUmnt:
+		call.args.string(maxMountPathLen)
+		return w, acceptSuccess
+	case mountProcUmntAll:
+		return w, acceptSuccess
+	case mountProcExport:
+		for _, exp := range m.s.exports {
+			// No groups are listed, which means any client that
+			// passes the export's address restrictions.
+			w.bool(true).string(exp.dirPath).bool(false)
+		}
+		return w.bool(false), acceptSuccess
+	default:
+		return nil, acceptProcUnavail
+	}
+}
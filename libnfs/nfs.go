@@ -0,0 +1,944 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// This file implements version 3 of the NFS protocol (RFC 1813).
+
+const (
+	nfsProgram = 100003
+	nfsVersion = 3
+)
+
+const (
+	nfsProcNull        = 0
+	nfsProcGetattr     = 1
+	nfsProcSetattr     = 2
+	nfsProcLookup      = 3
+	nfsProcAccess      = 4
+	nfsProcReadlink    = 5
+	nfsProcRead        = 6
+	nfsProcWrite       = 7
+	nfsProcCreate      = 8
+	nfsProcMkdir       = 9
+	nfsProcSymlink     = 10
+	nfsProcMknod       = 11
+	nfsProcRemove      = 12
+	nfsProcRmdir       = 13
+	nfsProcRename      = 14
+	nfsProcLink        = 15
+	nfsProcReaddir     = 16
+	nfsProcReaddirplus = 17
+	nfsProcFsstat      = 18
+	nfsProcFsinfo      = 19
+	nfsProcPathconf    = 20
+	nfsProcCommit      = 21
+)
+
+type nfsStat uint32
+
+const (
+	nfsOK          nfsStat = 0
+	nfsErrNoEnt    nfsStat = 2
+	nfsErrIO       nfsStat = 5
+	nfsErrAcces    nfsStat = 13
+	nfsErrExist    nfsStat = 17
+	nfsErrXDev     nfsStat = 18
+	nfsErrNotDir   nfsStat = 20
+	nfsErrIsDir    nfsStat = 21
+	nfsErrInval    nfsStat = 22
+	nfsErrROFS     nfsStat = 30
+	nfsErrNameLong nfsStat = 63
+	nfsErrNotEmpty nfsStat = 66
+	nfsErrStale    nfsStat = 70
+	nfsErrBadHandl nfsStat = 10001
+	nfsErrNotSupp  nfsStat = 10004
+)
+
+const (
+	nfsTypeReg = 1
+	nfsTypeDir = 2
+	nfsTypeLnk = 5
+)
+
+// Bits for ACCESS.
+const (
+	accessRead    = 0x1
+	accessLookup  = 0x2
+	accessModify  = 0x4
+	accessExtend  = 0x8
+	accessDelete  = 0x10
+	accessExecute = 0x20
+)
+
+// How CREATE should behave if the file exists.
+const (
+	createUnchecked = 0
+	createGuarded   = 1
+	createExclusive = 2
+)
+
+// How WRITE data should be committed.
+const (
+	writeUnstable = 0
+	writeFileSync = 2
+)
+
+// How SETATTR should set times.
+const (
+	timeDontChange = 0
+	timeServer     = 1
+	timeClient     = 2
+)
+
+const (
+	// maxIOSize is the largest READ or WRITE we support, and what
+	// we tell clients to use.
+	maxIOSize = 256 * 1024
+	// maxNameLen is the longest file name we accept.
+	maxNameLen = 255
+	// maxPathLen is the longest symlink target we accept.
+	maxPathLen = 4096
+	// maxFileHandleLen is NFS3_FHSIZE.
+	maxFileHandleLen = 64
+)
+
+// nfsServer holds the state shared by the MOUNT and NFS programs.
+type nfsServer struct {
+	exports      []*export
+	exportsByDir map[string]*export
+	handles      *handleTable
+	// writeVerf changes whenever the server restarts, so clients know
+	// to resend unstable writes that may have been lost.
+	writeVerf [8]byte
+	// ownerUID and ownerGID are reported as the owner of every file.
+	ownerUID uint32
+	ownerGID uint32
+}
+
+func newNFSServer(exports []*export, ownerUID, ownerGID uint32) *nfsServer {
+	s := &nfsServer{
+		exports:      exports,
+		exportsByDir: make(map[string]*export, len(exports)),
+		handles:      newHandleTable(),
+		ownerUID:     ownerUID,
+		ownerGID:     ownerGID,
+	}
+	for i, exp := range exports {
+		exp.fsid = uint64(i + 1)
+		s.exportsByDir[exp.dirPath] = exp
+	}
+	binary.BigEndian.PutUint64(s.writeVerf[:], uint64(time.Now().UnixNano()))
+	return s
+}
+
+// serveConn serves both the MOUNT and NFS programs on `conn`, so
+// that clients only need one port, and no portmapper.
+func (s *nfsServer) serveConn(conn net.Conn) error {
+	return serveRPCConn(conn, map[rpcProgramKey]rpcProgram{
+		{mountProgram, mountVersion}: mountProgramV3{s},
+		{nfsProgram, nfsVersion}:     nfsProgramV3{s},
+	})
+}
+
+func errToNFSStat(err error) nfsStat {
+	cause := errors.Cause(err)
+	switch {
+	case os.IsNotExist(cause):
+		return nfsErrNoEnt
+	case os.IsPermission(cause):
+		return nfsErrAcces
+	case os.IsExist(cause):
+		return nfsErrExist
+	default:
+		return nfsErrIO
+	}
+}
+
+func checkName(name string) nfsStat {
+	switch {
+	case len(name) > maxNameLen:
+		return nfsErrNameLong
+	case name == "" || name == "." || name == ".." ||
+		strings.Contains(name, "/"):
+		return nfsErrInval
+	}
+	return nfsOK
+}
+
+func (s *nfsServer) writeTime(w *xdrWriter, t time.Time) {
+	w.uint32(uint32(t.Unix())).uint32(uint32(t.Nanosecond()))
+}
+
+func (s *nfsServer) writeFattr(
+	w *xdrWriter, exp *export, p string, fi os.FileInfo) {
+	nfsType, nlink := uint32(nfsTypeReg), uint32(1)
+	switch {
+	case fi.IsDir():
+		nfsType, nlink = nfsTypeDir, 2
+	case fi.Mode()&os.ModeSymlink != 0:
+		nfsType = nfsTypeLnk
+	}
+	size := uint64(fi.Size())
+	w.uint32(nfsType).uint32(uint32(fi.Mode().Perm())).uint32(nlink).
+		uint32(s.ownerUID).uint32(s.ownerGID).uint64(size).uint64(size).
+		uint32(0).uint32(0).uint64(exp.fsid).
+		uint64(s.handles.get(exp, p))
+	// KBFS only tracks modification times, so use them for the
+	// access and change times too.
+	for i := 0; i < 3; i++ {
+		s.writeTime(w, fi.ModTime())
+	}
+}
+
+// writePostOpAttr writes post_op_attr for `p`.
+func (s *nfsServer) writePostOpAttr(w *xdrWriter, exp *export, p string) {
+	if exp == nil {
+		w.bool(false)
+		return
+	}
+	fi, err := exp.stat(p)
+	if err != nil {
+		w.bool(false)
+		return
+	}
+	w.bool(true)
+	s.writeFattr(w, exp, p, fi)
+}
+
+// writeWcc writes wcc_data for `p`.  We don't report pre-operation
+// attributes, which just means clients can't tell whether anyone
+// else changed the file at the same time.
+func (s *nfsServer) writeWcc(w *xdrWriter, exp *export, p string) {
+	w.bool(false)
+	s.writePostOpAttr(w, exp, p)
+}
+
+func (s *nfsServer) writePostOpFH(w *xdrWriter, exp *export, p string) {
+	w.bool(true).opaque(encodeHandle(s.handles.get(exp, p)))
+}
+
+type nfsProgramV3 struct {
+	s *nfsServer
+}
+
+var _ rpcProgram = nfsProgramV3{}
+
+// resolve returns the export and path for a file handle.
+func (n nfsProgramV3) resolve(call *rpcCall) (*export, string, nfsStat) {
+	fh := call.args.opaque(maxFileHandleLen)
+	if call.args.err != nil {
+		return nil, "", nfsErrBadHandl
+	}
+	id, ok := decodeHandle(fh)
+	if !ok {
+		return nil, "", nfsErrBadHandl
+	}
+	entry, ok := n.s.handles.lookup(id)
+	if !ok {
+		return nil, "", nfsErrStale
+	}
+	if !entry.exp.allows(call.remoteAddr) {
+		return nil, "", nfsErrAcces
+	}
+	return entry.exp, entry.path, nfsOK
+}
+
+// setAttrs holds the attributes a client asked to set.
+type setAttrs struct {
+	setMode bool
+	mode    uint32
+	setSize bool
+	size    uint64
+	atime   timeSetting
+	mtime   timeSetting
+}
+
+type timeSetting struct {
+	how uint32
+	t   time.Time
+}
+
+func readTimeSetting(r *xdrReader) timeSetting {
+	ts := timeSetting{how: r.uint32()}
+	if ts.how == timeClient {
+		sec := r.uint32()
+		nsec := r.uint32()
+		ts.t = time.Unix(int64(sec), int64(nsec))
+	} else if ts.how == timeServer {
+		ts.t = time.Now()
+	}
+	return ts
+}
+
+func readSetAttrs(r *xdrReader) setAttrs {
+	var sa setAttrs
+	if sa.setMode = r.bool(); sa.setMode {
+		sa.mode = r.uint32()
+	}
+	// Skip the uid and gid; KBFS files have no owner to change.
+	if r.bool() {
+		r.uint32()
+	}
+	if r.bool() {
+		r.uint32()
+	}
+	if sa.setSize = r.bool(); sa.setSize {
+		sa.size = r.uint64()
+	}
+	sa.atime = readTimeSetting(r)
+	sa.mtime = readTimeSetting(r)
+	return sa
+}
+
+func (n nfsProgramV3) applySetAttrs(
+	exp *export, p string, sa setAttrs) error {
+	defer exp.changed(p)
+	if sa.setSize {
+		f, err := exp.fs.OpenFile(p, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(int64(sa.size))
+		closeErr := f.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+	change, ok := exp.fs.(billy.Change)
+	if !ok {
+		return exp.sync()
+	}
+	if sa.setMode {
+		err := change.Chmod(p, os.FileMode(sa.mode).Perm())
+		if err != nil {
+			return err
+		}
+	}
+	if sa.atime.how != timeDontChange || sa.mtime.how != timeDontChange {
+		fi, err := exp.fs.Stat(p)
+		if err != nil {
+			return err
+		}
+		atime, mtime := fi.ModTime(), fi.ModTime()
+		if sa.atime.how != timeDontChange {
+			atime = sa.atime.t
+		}
+		if sa.mtime.how != timeDontChange {
+			mtime = sa.mtime.t
+		}
+		err = change.Chtimes(p, atime, mtime)
+		if err != nil {
+			return err
+		}
+	}
+	return exp.sync()
+}
+
+func (n nfsProgramV3) handle(call *rpcCall) (*xdrWriter, acceptStat) {
+	var w *xdrWriter
+	switch call.proc {
+	case nfsProcNull:
+		w = &xdrWriter{}
+	case nfsProcGetattr:
+		w = n.getattr(call)
+	case nfsProcSetattr:
+		w = n.setattr(call)
+	case nfsProcLookup:
+		w = n.lookup(call)
+	case nfsProcAccess:
+		w = n.access(call)
+	case nfsProcReadlink:
+		w = n.readlink(call)
+	case nfsProcRead:
+		w = n.read(call)
+	case nfsProcWrite:
+		w = n.write(call)
+	case nfsProcCreate, nfsProcMkdir, nfsProcSymlink:
+		w = n.create(call)
+	case nfsProcMknod:
+		_, _, _ = n.resolve(call)
+		w = (&xdrWriter{}).uint32(uint32(nfsErrNotSupp))
+		n.s.writeWcc(w, nil, "")
+	case nfsProcRemove, nfsProcRmdir:
+		w = n.remove(call)
+	case nfsProcRename:
+		w = n.rename(call)
+	case nfsProcLink:
+		w = (&xdrWriter{}).uint32(uint32(nfsErrNotSupp))
+		n.s.writePostOpAttr(w, nil, "")
+		n.s.writeWcc(w, nil, "")
+	case nfsProcReaddir, nfsProcReaddirplus:
+		w = n.readdir(call, call.proc == nfsProcReaddirplus)
+	case nfsProcFsstat:
+		w = n.fsstat(call)
+	case nfsProcFsinfo:
+		w = n.fsinfo(call)
+	case nfsProcPathconf:
+		w = n.pathconf(call)
+	case nfsProcCommit:
+		w = n.commit(call)
+	default:
+		return nil, acceptProcUnavail
+	}
+	if call.args.err != nil {
+		return nil, acceptGarbageArgs
+	}
+	return w, acceptSuccess
+}
+
+func (n nfsProgramV3) getattr(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	if status != nfsOK {
+		return w.uint32(uint32(status))
+	}
+	fi, err := exp.stat(p)
+	if err != nil {
+		return w.uint32(uint32(errToNFSStat(err)))
+	}
+	w.uint32(uint32(nfsOK))
+	n.s.writeFattr(w, exp, p, fi)
+	return w
+}
+
+func (n nfsProgramV3) setattr(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	sa := readSetAttrs(call.args)
+	// We don't support the ctime guard, since we don't track ctimes.
+	if call.args.bool() {
+		call.args.uint32()
+		call.args.uint32()
+	}
+	switch {
+	case status != nfsOK:
+	case exp.readOnly:
+		status = nfsErrROFS
+	default:
+		if err := n.applySetAttrs(exp, p, sa); err != nil {
+			status = errToNFSStat(err)
+		}
+	}
+	w.uint32(uint32(status))
+	n.s.writeWcc(w, exp, p)
+	return w
+}
+
+func (n nfsProgramV3) lookup(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, dir, status := n.resolve(call)
+	name := call.args.string(maxPathLen)
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+
+	var p string
+	switch name {
+	case ".":
+		p = dir
+	case "..":
+		p = path.Dir(dir)
+	default:
+		if status = checkName(name); status != nfsOK {
+			w.uint32(uint32(status))
+			n.s.writePostOpAttr(w, exp, dir)
+			return w
+		}
+		p = path.Join(dir, name)
+	}
+	if _, err := exp.stat(p); err != nil {
+		w.uint32(uint32(errToNFSStat(err)))
+		n.s.writePostOpAttr(w, exp, dir)
+		return w
+	}
+	w.uint32(uint32(nfsOK)).opaque(encodeHandle(n.s.handles.get(exp, p)))
+	n.s.writePostOpAttr(w, exp, p)
+	n.s.writePostOpAttr(w, exp, dir)
+	return w
+}
+
+func (n nfsProgramV3) access(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	requested := call.args.uint32()
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+	if _, err := exp.stat(p); err != nil {
+		w.uint32(uint32(errToNFSStat(err)))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+	// KBFS checks permissions itself when the operation is
+	// actually attempted, so just rule out writes to read-only
+	// exports here.
+	allowed := uint32(accessRead | accessLookup | accessModify |
+		accessExtend | accessDelete | accessExecute)
+	if exp.readOnly {
+		allowed &^= accessModify | accessExtend | accessDelete
+	}
+	w.uint32(uint32(nfsOK))
+	n.s.writePostOpAttr(w, exp, p)
+	return w.uint32(requested & allowed)
+}
+
+func (n nfsProgramV3) readlink(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+	target, err := exp.fs.Readlink(p)
+	if err != nil {
+		w.uint32(uint32(errToNFSStat(err)))
+		n.s.writePostOpAttr(w, exp, p)
+		return w
+	}
+	w.uint32(uint32(nfsOK))
+	n.s.writePostOpAttr(w, exp, p)
+	return w.string(target)
+}
+
+func (n nfsProgramV3) read(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	offset := call.args.uint64()
+	count := call.args.uint32()
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+	if count > maxIOSize {
+		count = maxIOSize
+	}
+
+	buf, eof, err := func() ([]byte, bool, error) {
+		f, err := exp.fs.Open(p)
+		if err != nil {
+			return nil, false, err
+		}
+		defer f.Close()
+		buf := make([]byte, count)
+		nRead, err := f.ReadAt(buf, int64(offset))
+		if err == io.EOF {
+			return buf[:nRead], true, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		return buf[:nRead], false, nil
+	}()
+	if err != nil {
+		w.uint32(uint32(errToNFSStat(err)))
+		n.s.writePostOpAttr(w, exp, p)
+		return w
+	}
+	if !eof {
+		if fi, err := exp.fs.Stat(p); err == nil {
+			eof = offset+uint64(len(buf)) >= uint64(fi.Size())
+		}
+	}
+	w.uint32(uint32(nfsOK))
+	n.s.writePostOpAttr(w, exp, p)
+	return w.uint32(uint32(len(buf))).bool(eof).opaque(buf)
+}
+
+func (n nfsProgramV3) write(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	offset := call.args.uint64()
+	call.args.uint32() // count, which must match the data length
+	stable := call.args.uint32()
+	data := call.args.opaque(maxIOSize)
+	if status == nfsOK && exp.readOnly {
+		status = nfsErrROFS
+	}
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writeWcc(w, exp, p)
+		return w
+	}
+
+	err := func() error {
+		defer exp.changed(p)
+		f, err := exp.fs.OpenFile(p, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = f.Seek(int64(offset), io.SeekStart)
+		if err == nil {
+			_, err = f.Write(data)
+		}
+		closeErr := f.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+	}()
+	committed := uint32(writeUnstable)
+	if err == nil && stable != writeUnstable {
+		err = exp.sync()
+		committed = writeFileSync
+	}
+	if err != nil {
+		w.uint32(uint32(errToNFSStat(err)))
+		n.s.writeWcc(w, exp, p)
+		return w
+	}
+	w.uint32(uint32(nfsOK))
+	n.s.writeWcc(w, exp, p)
+	return w.uint32(uint32(len(data))).uint32(committed).
+		fixedOpaque(n.s.writeVerf[:])
+}
+
+// create handles CREATE, MKDIR and SYMLINK, which all make a new
+// entry in a directory and have the same results.
+func (n nfsProgramV3) create(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, dir, status := n.resolve(call)
+	name := call.args.string(maxPathLen)
+
+	var sa setAttrs
+	var how uint32
+	var target string
+	switch call.proc {
+	case nfsProcCreate:
+		how = call.args.uint32()
+		if how == createExclusive {
+			call.args.fixedOpaque(8)
+		} else {
+			sa = readSetAttrs(call.args)
+		}
+	case nfsProcMkdir:
+		sa = readSetAttrs(call.args)
+	case nfsProcSymlink:
+		sa = readSetAttrs(call.args)
+		target = call.args.string(maxPathLen)
+	}
+
+	if status == nfsOK {
+		status = checkName(name)
+	}
+	if status == nfsOK && exp.readOnly {
+		status = nfsErrROFS
+	}
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writeWcc(w, exp, dir)
+		return w
+	}
+
+	p := path.Join(dir, name)
+	err := func() error {
+		defer exp.changed(p)
+		switch call.proc {
+		case nfsProcCreate:
+			flag := os.O_WRONLY | os.O_CREATE
+			// We don't store the verifier for exclusive creates, so
+			// treat them as guarded, which is only wrong if a client
+			// retransmits one.
+			if how != createUnchecked {
+				flag |= os.O_EXCL
+			}
+			perm := os.FileMode(0644)
+			if sa.setMode {
+				perm = os.FileMode(sa.mode).Perm()
+			}
+			f, err := exp.fs.OpenFile(p, flag, perm)
+			if err != nil {
+				return err
+			}
+			err = f.Close()
+			if err != nil {
+				return err
+			}
+			sa.setMode = false
+			return n.applySetAttrs(exp, p, sa)
+		case nfsProcMkdir:
+			if _, err := exp.fs.Lstat(p); err == nil {
+				return os.ErrExist
+			}
+			perm := os.FileMode(0755)
+			if sa.setMode {
+				perm = os.FileMode(sa.mode).Perm()
+			}
+			err := exp.fs.MkdirAll(p, perm)
+			if err != nil {
+				return err
+			}
+			return exp.sync()
+		default:
+			err := exp.fs.Symlink(target, p)
+			if err != nil {
+				return err
+			}
+			return exp.sync()
+		}
+	}()
+	if err != nil {
+		w.uint32(uint32(errToNFSStat(err)))
+		n.s.writeWcc(w, exp, dir)
+		return w
+	}
+	w.uint32(uint32(nfsOK))
+	n.s.writePostOpFH(w, exp, p)
+	n.s.writePostOpAttr(w, exp, p)
+	n.s.writeWcc(w, exp, dir)
+	return w
+}
+
+// remove handles REMOVE and RMDIR.
+func (n nfsProgramV3) remove(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, dir, status := n.resolve(call)
+	name := call.args.string(maxPathLen)
+	if status == nfsOK {
+		status = checkName(name)
+	}
+	if status == nfsOK && exp.readOnly {
+		status = nfsErrROFS
+	}
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writeWcc(w, exp, dir)
+		return w
+	}
+
+	p := path.Join(dir, name)
+	status = func() nfsStat {
+		defer exp.changed(p)
+		fi, err := exp.fs.Lstat(p)
+		if err != nil {
+			return errToNFSStat(err)
+		}
+		if call.proc == nfsProcRmdir {
+			if !fi.IsDir() {
+				return nfsErrNotDir
+			}
+			children, err := exp.fs.ReadDir(p)
+			if err != nil {
+				return errToNFSStat(err)
+			}
+			if len(children) > 0 {
+				return nfsErrNotEmpty
+			}
+		} else if fi.IsDir() {
+			return nfsErrIsDir
+		}
+		err = exp.fs.Remove(p)
+		if err == nil {
+			err = exp.sync()
+		}
+		if err != nil {
+			return errToNFSStat(err)
+		}
+		return nfsOK
+	}()
+	w.uint32(uint32(status))
+	n.s.writeWcc(w, exp, dir)
+	return w
+}
+
+func (n nfsProgramV3) rename(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	fromExp, fromDir, status := n.resolve(call)
+	fromName := call.args.string(maxPathLen)
+	toExp, toDir, toStatus := n.resolve(call)
+	toName := call.args.string(maxPathLen)
+	for _, s := range []nfsStat{
+		toStatus, checkName(fromName), checkName(toName)} {
+		if status == nfsOK {
+			status = s
+		}
+	}
+	if status == nfsOK && fromExp != toExp {
+		status = nfsErrXDev
+	}
+	if status == nfsOK && fromExp.readOnly {
+		status = nfsErrROFS
+	}
+
+	if status == nfsOK {
+		fromPath := path.Join(fromDir, fromName)
+		toPath := path.Join(toDir, toName)
+		err := fromExp.fs.Rename(fromPath, toPath)
+		if err == nil {
+			n.s.handles.rename(fromExp, fromPath, toPath)
+			err = fromExp.sync()
+		}
+		fromExp.changed(fromPath)
+		fromExp.changed(toPath)
+		if err != nil {
+			status = errToNFSStat(err)
+		}
+	}
+	w.uint32(uint32(status))
+	n.s.writeWcc(w, fromExp, fromDir)
+	n.s.writeWcc(w, toExp, toDir)
+	return w
+}
+
+type fileInfosByName []os.FileInfo
+
+func (f fileInfosByName) Len() int {
+	return len(f)
+}
+
+func (f fileInfosByName) Less(i, j int) bool {
+	return f[i].Name() < f[j].Name()
+}
+
+func (f fileInfosByName) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+// readdir handles READDIR and READDIRPLUS.  Cookies are just
+// positions in the sorted directory listing, so entries may be
+// skipped or repeated if the directory changes between calls.
+func (n nfsProgramV3) readdir(call *rpcCall, plus bool) *xdrWriter {
+	w := &xdrWriter{}
+	exp, dir, status := n.resolve(call)
+	cookie := call.args.uint64()
+	call.args.fixedOpaque(8) // cookie verifier
+	maxCount := call.args.uint32()
+	if plus {
+		// The first count only limits the names and cookies; the
+		// second limits the whole reply, which is what we care
+		// about.
+		maxCount = call.args.uint32()
+	}
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+
+	children, err := exp.fs.ReadDir(dir)
+	if err != nil {
+		w.uint32(uint32(errToNFSStat(err)))
+		n.s.writePostOpAttr(w, exp, dir)
+		return w
+	}
+	sort.Sort(fileInfosByName(children))
+
+	w.uint32(uint32(nfsOK))
+	n.s.writePostOpAttr(w, exp, dir)
+	w.fixedOpaque(make([]byte, 8))
+	// Leave room for the trailing list terminator and EOF flag.
+	limit := len(w.buf) + int(maxCount) - 8
+	i := int(cookie)
+	for ; i < len(children); i++ {
+		fi := children[i]
+		p := path.Join(dir, fi.Name())
+		entry := &xdrWriter{}
+		entry.bool(true).uint64(n.s.handles.get(exp, p)).
+			string(fi.Name()).uint64(uint64(i + 1))
+		if plus {
+			exp.attrs.put(p, fi)
+			entry.bool(true)
+			n.s.writeFattr(entry, exp, p, fi)
+			n.s.writePostOpFH(entry, exp, p)
+		}
+		if len(w.buf)+len(entry.buf) > limit {
+			break
+		}
+		w.buf = append(w.buf, entry.buf...)
+	}
+	return w.bool(false).bool(i >= len(children))
+}
+
+func (n nfsProgramV3) fsstat(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+	// Quotas aren't per-TLF, so just report plenty of room; writes
+	// past the quota fail on their own.
+	const plenty = 1 << 50
+	w.uint32(uint32(nfsOK))
+	n.s.writePostOpAttr(w, exp, p)
+	return w.uint64(plenty).uint64(plenty).uint64(plenty).
+		uint64(plenty).uint64(plenty).uint64(plenty).uint32(0)
+}
+
+func (n nfsProgramV3) fsinfo(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+	const (
+		fsfSymlink     = 0x2
+		fsfHomogeneous = 0x8
+		fsfCanSetTime  = 0x10
+	)
+	w.uint32(uint32(nfsOK))
+	n.s.writePostOpAttr(w, exp, p)
+	return w.uint32(maxIOSize).uint32(maxIOSize).uint32(4096).
+		uint32(maxIOSize).uint32(maxIOSize).uint32(4096).
+		uint32(maxIOSize).uint64(1<<63 - 1).
+		uint32(0).uint32(1).
+		uint32(fsfSymlink | fsfHomogeneous | fsfCanSetTime)
+}
+
+func (n nfsProgramV3) pathconf(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	if status != nfsOK {
+		w.uint32(uint32(status))
+		n.s.writePostOpAttr(w, nil, "")
+		return w
+	}
+	w.uint32(uint32(nfsOK))
+	n.s.writePostOpAttr(w, exp, p)
+	return w.uint32(1).uint32(maxNameLen).bool(true).bool(true).
+		bool(false).bool(true)
+}
+
+func (n nfsProgramV3) commit(call *rpcCall) *xdrWriter {
+	w := &xdrWriter{}
+	exp, p, status := n.resolve(call)
+	call.args.uint64() // offset
+	call.args.uint32() // count
+	if status == nfsOK {
+		if err := exp.sync(); err != nil {
+			status = errToNFSStat(err)
+		}
+	}
+	w.uint32(uint32(status))
+	n.s.writeWcc(w, exp, p)
+	if status != nfsOK {
+		return w
+	}
+	return w.fixedOpaque(n.s.writeVerf[:])
+}
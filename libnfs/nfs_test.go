@@ -0,0 +1,339 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+type testNFSClient struct {
+	t    *testing.T
+	conn net.Conn
+	xid  uint32
+}
+
+func (c *testNFSClient) callWithStat(prog, vers, proc uint32,
+	args *xdrWriter) (*xdrReader, acceptStat) {
+	c.xid++
+	w := &xdrWriter{}
+	w.uint32(c.xid).uint32(rpcMsgCall).uint32(rpcVersion).
+		uint32(prog).uint32(vers).uint32(proc).
+		uint32(authNone).opaque(nil).uint32(authNone).opaque(nil)
+	if args != nil {
+		w.buf = append(w.buf, args.buf...)
+	}
+	require.NoError(c.t, writeRecord(c.conn, w.buf))
+	record, err := readRecord(c.conn)
+	require.NoError(c.t, err)
+
+	r := &xdrReader{buf: record}
+	require.Equal(c.t, c.xid, r.uint32())
+	require.Equal(c.t, uint32(rpcMsgReply), r.uint32())
+	require.Equal(c.t, uint32(rpcMsgAccepted), r.uint32())
+	r.uint32()
+	r.opaque(maxAuthLen)
+	stat := acceptStat(r.uint32())
+	require.NoError(c.t, r.err)
+	return r, stat
+}
+
+func (c *testNFSClient) call(proc uint32, args *xdrWriter) *xdrReader {
+	r, stat := c.callWithStat(nfsProgram, nfsVersion, proc, args)
+	require.Equal(c.t, acceptSuccess, stat)
+	return r
+}
+
+type testFattr struct {
+	nfsType uint32
+	size    uint64
+	fileid  uint64
+}
+
+func readTestFattr(r *xdrReader) testFattr {
+	var fa testFattr
+	fa.nfsType = r.uint32()
+	r.fixedOpaque(16) // mode, nlink, uid, gid
+	fa.size = r.uint64()
+	r.fixedOpaque(24) // used, rdev, fsid
+	fa.fileid = r.uint64()
+	r.fixedOpaque(24) // times
+	return fa
+}
+
+func readTestPostOpAttr(r *xdrReader) (testFattr, bool) {
+	if !r.bool() {
+		return testFattr{}, false
+	}
+	return readTestFattr(r), true
+}
+
+func readTestWcc(r *xdrReader) {
+	if r.bool() {
+		r.fixedOpaque(24)
+	}
+	readTestPostOpAttr(r)
+}
+
+func emptySetAttrs(w *xdrWriter) *xdrWriter {
+	return w.bool(false).bool(false).bool(false).bool(false).
+		uint32(timeDontChange).uint32(timeDontChange)
+}
+
+// create makes a file (or directory) and returns its handle.
+func (c *testNFSClient) create(
+	proc uint32, dir []byte, name string) []byte {
+	w := (&xdrWriter{}).opaque(dir).string(name)
+	if proc == nfsProcCreate {
+		w.uint32(createGuarded)
+	}
+	r := c.call(proc, emptySetAttrs(w))
+	require.Equal(c.t, uint32(nfsOK), r.uint32())
+	require.True(c.t, r.bool())
+	fh := r.opaque(maxFileHandleLen)
+	require.NoError(c.t, r.err)
+	return fh
+}
+
+func (c *testNFSClient) lookup(dir []byte, name string) ([]byte, nfsStat) {
+	r := c.call(nfsProcLookup, (&xdrWriter{}).opaque(dir).string(name))
+	status := nfsStat(r.uint32())
+	if status != nfsOK {
+		return nil, status
+	}
+	return r.opaque(maxFileHandleLen), status
+}
+
+func (c *testNFSClient) getattr(fh []byte) (testFattr, nfsStat) {
+	r := c.call(nfsProcGetattr, (&xdrWriter{}).opaque(fh))
+	status := nfsStat(r.uint32())
+	if status != nfsOK {
+		return testFattr{}, status
+	}
+	fa := readTestFattr(r)
+	require.NoError(c.t, r.err)
+	return fa, status
+}
+
+func (c *testNFSClient) read(fh []byte, offset uint64, count uint32) (
+	data []byte, eof bool) {
+	r := c.call(nfsProcRead,
+		(&xdrWriter{}).opaque(fh).uint64(offset).uint32(count))
+	require.Equal(c.t, uint32(nfsOK), r.uint32())
+	readTestPostOpAttr(r)
+	n := r.uint32()
+	eof = r.bool()
+	data = r.opaque(maxIOSize)
+	require.NoError(c.t, r.err)
+	require.Len(c.t, data, int(n))
+	return data, eof
+}
+
+func (c *testNFSClient) readdirplus(fh []byte) (names []string) {
+	cookie := uint64(0)
+	for {
+		// A small count forces several calls.
+		r := c.call(nfsProcReaddirplus, (&xdrWriter{}).opaque(fh).
+			uint64(cookie).fixedOpaque(make([]byte, 8)).
+			uint32(200).uint32(300))
+		require.Equal(c.t, uint32(nfsOK), r.uint32())
+		readTestPostOpAttr(r)
+		r.fixedOpaque(8)
+		for r.bool() {
+			r.uint64()
+			names = append(names, r.string(maxNameLen))
+			cookie = r.uint64()
+			_, ok := readTestPostOpAttr(r)
+			require.True(c.t, ok)
+			require.True(c.t, r.bool())
+			r.opaque(maxFileHandleLen)
+		}
+		eof := r.bool()
+		require.NoError(c.t, r.err)
+		if eof {
+			return names
+		}
+	}
+}
+
+func TestNFSServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libnfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	exp := &export{
+		dirPath: "/private/jdoe",
+		fs:      osfs.New(dir),
+		attrs:   newAttrCache(time.Minute),
+	}
+	s := newNFSServer([]*export{exp}, 1000, 1000)
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.serveConn(serverConn)
+	c := &testNFSClient{t: t, conn: clientConn}
+
+	t.Log("Unsupported versions are reported")
+	r, stat := c.callWithStat(nfsProgram, 2, nfsProcNull, nil)
+	require.Equal(t, acceptProgMismatch, stat)
+	require.Equal(t, uint32(nfsVersion), r.uint32())
+	require.Equal(t, uint32(nfsVersion), r.uint32())
+	_, stat = c.callWithStat(12345, 1, 0, nil)
+	require.Equal(t, acceptProgUnavail, stat)
+
+	t.Log("Mount the export")
+	r, stat = c.callWithStat(mountProgram, mountVersion, mountProcMnt,
+		(&xdrWriter{}).string("/private/jdoe/"))
+	require.Equal(t, acceptSuccess, stat)
+	require.Equal(t, uint32(mountOK), r.uint32())
+	root := r.opaque(maxFileHandleLen)
+	require.NoError(t, r.err)
+	r, _ = c.callWithStat(mountProgram, mountVersion, mountProcMnt,
+		(&xdrWriter{}).string("/private/other"))
+	require.Equal(t, uint32(mountNoEnt), r.uint32())
+
+	t.Log("Create and write a file")
+	file := c.create(nfsProcCreate, root, "a.txt")
+	data := []byte("hello world")
+	r = c.call(nfsProcWrite, (&xdrWriter{}).opaque(file).uint64(0).
+		uint32(uint32(len(data))).uint32(writeFileSync).opaque(data))
+	require.Equal(t, uint32(nfsOK), r.uint32())
+	readTestWcc(r)
+	require.Equal(t, uint32(len(data)), r.uint32())
+	require.Equal(t, uint32(writeFileSync), r.uint32())
+	require.Equal(t, s.writeVerf[:], r.fixedOpaque(8))
+
+	buf, err := ioutil.ReadFile(dir + "/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+
+	t.Log("A second guarded create fails")
+	w := (&xdrWriter{}).opaque(root).string("a.txt").uint32(createGuarded)
+	r = c.call(nfsProcCreate, emptySetAttrs(w))
+	require.Equal(t, uint32(nfsErrExist), r.uint32())
+
+	t.Log("Look up, stat and read the file")
+	fh, status := c.lookup(root, "a.txt")
+	require.Equal(t, nfsOK, status)
+	require.Equal(t, file, fh)
+	fa, status := c.getattr(file)
+	require.Equal(t, nfsOK, status)
+	require.Equal(t, uint32(nfsTypeReg), fa.nfsType)
+	require.Equal(t, uint64(len(data)), fa.size)
+	fileid := fa.fileid
+	got, eof := c.read(file, 0, 5)
+	require.Equal(t, data[:5], got)
+	require.False(t, eof)
+	got, eof = c.read(file, 6, 100)
+	require.Equal(t, data[6:], got)
+	require.True(t, eof)
+	_, status = c.lookup(root, "missing")
+	require.Equal(t, nfsErrNoEnt, status)
+
+	t.Log("Truncate the file")
+	w = (&xdrWriter{}).opaque(file).bool(false).bool(false).bool(false).
+		bool(true).uint64(5).uint32(timeDontChange).uint32(timeDontChange).
+		bool(false)
+	r = c.call(nfsProcSetattr, w)
+	require.Equal(t, uint32(nfsOK), r.uint32())
+	readTestWcc(r)
+	fa, _ = c.getattr(file)
+	require.Equal(t, uint64(5), fa.size)
+
+	t.Log("Rename the file into a new directory; its handle follows it")
+	subdir := c.create(nfsProcMkdir, root, "d")
+	r = c.call(nfsProcRename, (&xdrWriter{}).opaque(root).string("a.txt").
+		opaque(subdir).string("b.txt"))
+	require.Equal(t, uint32(nfsOK), r.uint32())
+	readTestWcc(r)
+	readTestWcc(r)
+	fa, status = c.getattr(file)
+	require.Equal(t, nfsOK, status)
+	require.Equal(t, fileid, fa.fileid)
+	got, _ = c.read(file, 0, 100)
+	require.Equal(t, data[:5], got)
+	fh, status = c.lookup(subdir, "b.txt")
+	require.Equal(t, nfsOK, status)
+	require.Equal(t, file, fh)
+	_, status = c.lookup(root, "a.txt")
+	require.Equal(t, nfsErrNoEnt, status)
+
+	t.Log("List directories")
+	for i := 0; i < 5; i++ {
+		c.create(nfsProcCreate, subdir, string(rune('m'+i)))
+	}
+	require.Equal(t, []string{"d"}, c.readdirplus(root))
+	require.Equal(t, []string{"b.txt", "m", "n", "o", "p", "q"},
+		c.readdirplus(subdir))
+
+	t.Log("Non-empty directories can't be removed")
+	r = c.call(nfsProcRmdir, (&xdrWriter{}).opaque(root).string("d"))
+	require.Equal(t, uint32(nfsErrNotEmpty), r.uint32())
+	r = c.call(nfsProcRemove, (&xdrWriter{}).opaque(root).string("d"))
+	require.Equal(t, uint32(nfsErrIsDir), r.uint32())
+	for _, name := range []string{"b.txt", "m", "n", "o", "p", "q"} {
+		r = c.call(nfsProcRemove, (&xdrWriter{}).opaque(subdir).string(name))
+		require.Equal(t, uint32(nfsOK), r.uint32())
+	}
+	r = c.call(nfsProcRmdir, (&xdrWriter{}).opaque(root).string("d"))
+	require.Equal(t, uint32(nfsOK), r.uint32())
+	_, err = os.Stat(dir + "/d")
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Bad names and handles are rejected")
+	w = (&xdrWriter{}).opaque(root).string("../x").uint32(createGuarded)
+	r = c.call(nfsProcCreate, emptySetAttrs(w))
+	require.Equal(t, uint32(nfsErrInval), r.uint32())
+	_, status = c.getattr(encodeHandle(12345))
+	require.Equal(t, nfsErrStale, status)
+	_, status = c.getattr([]byte{1, 2, 3})
+	require.Equal(t, nfsErrBadHandl, status)
+
+	t.Log("Read-only exports reject changes")
+	exp.readOnly = true
+	w = (&xdrWriter{}).opaque(root).string("c.txt").uint32(createGuarded)
+	r = c.call(nfsProcCreate, emptySetAttrs(w))
+	require.Equal(t, uint32(nfsErrROFS), r.uint32())
+	r = c.call(nfsProcAccess, (&xdrWriter{}).opaque(root).uint32(
+		accessRead|accessLookup|accessModify))
+	require.Equal(t, uint32(nfsOK), r.uint32())
+	readTestPostOpAttr(r)
+	require.Equal(t, uint32(accessRead|accessLookup), r.uint32())
+}
+
+func TestAttrCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libnfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(dir+"/d", 0755))
+	require.NoError(t, ioutil.WriteFile(dir+"/d/f", []byte("a"), 0644))
+
+	exp := &export{fs: osfs.New(dir), attrs: newAttrCache(time.Minute)}
+	fi, err := exp.stat("/d/f")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), fi.Size())
+
+	t.Log("Outside changes aren't seen until the entry is invalidated")
+	require.NoError(t, ioutil.WriteFile(dir+"/d/f", []byte("abc"), 0644))
+	fi, err = exp.stat("/d/f")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), fi.Size())
+	exp.changed("/d")
+	fi, err = exp.stat("/d/f")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), fi.Size())
+
+	t.Log("A zero TTL disables the cache")
+	exp.attrs = newAttrCache(0)
+	require.NoError(t, ioutil.WriteFile(dir+"/d/f", []byte("abcd"), 0644))
+	fi, err = exp.stat("/d/f")
+	require.NoError(t, err)
+	require.Equal(t, int64(4), fi.Size())
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the server side of ONC RPC version 2 (RFC
+// 5531) over TCP.
+
+const (
+	rpcVersion = 2
+
+	rpcMsgCall  = 0
+	rpcMsgReply = 1
+
+	rpcMsgAccepted = 0
+	rpcMsgDenied   = 1
+
+	rpcMismatch = 0
+
+	authNone = 0
+	authSys  = 1
+
+	// maxAuthLen is the maximum length of credentials and verifiers.
+	maxAuthLen = 400
+)
+
+type acceptStat uint32
+
+const (
+	acceptSuccess      acceptStat = 0
+	acceptProgUnavail  acceptStat = 1
+	acceptProgMismatch acceptStat = 2
+	acceptProcUnavail  acceptStat = 3
+	acceptGarbageArgs  acceptStat = 4
+)
+
+// maxRecordLen bounds the size of an incoming RPC record, which
+// must have room for a maximum-size WRITE.
+const maxRecordLen = maxIOSize + 4096
+
+// rpcCall is a parsed RPC call.
+type rpcCall struct {
+	xid        uint32
+	prog       uint32
+	vers       uint32
+	proc       uint32
+	args       *xdrReader
+	remoteAddr net.Addr
+}
+
+// rpcProgram handles the calls for one version of one RPC program.
+// If handle returns acceptSuccess, the reply is sent back with the
+// given results; otherwise the results are ignored.
+type rpcProgram interface {
+	handle(call *rpcCall) (results *xdrWriter, stat acceptStat)
+}
+
+type rpcProgramKey struct {
+	prog uint32
+	vers uint32
+}
+
+// readRecord reads a single record, made up of one or more
+// fragments, from r.
+func readRecord(r io.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var header [4]byte
+		_, err := io.ReadFull(r, header[:])
+		if err != nil {
+			return nil, err
+		}
+		h := binary.BigEndian.Uint32(header[:])
+		last := h&0x80000000 != 0
+		length := h & 0x7fffffff
+		if uint32(len(record))+length > maxRecordLen {
+			return nil, errors.Errorf("RPC record too long")
+		}
+		start := len(record)
+		record = append(record, make([]byte, length)...)
+		_, err = io.ReadFull(r, record[start:])
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes `b` as a single-fragment record.
+func writeRecord(w io.Writer, b []byte) error {
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b))|0x80000000)
+	copy(buf[4:], b)
+	_, err := w.Write(buf)
+	return err
+}
+
+func acceptedReply(xid uint32, stat acceptStat) *xdrWriter {
+	w := &xdrWriter{}
+	return w.uint32(xid).uint32(rpcMsgReply).uint32(rpcMsgAccepted).
+		uint32(authNone).uint32(0).uint32(uint32(stat))
+}
+
+// serveRPCConn serves RPC calls on `conn` until it's closed, one
+// call at a time.
+func serveRPCConn(conn net.Conn, programs map[rpcProgramKey]rpcProgram) error {
+	for {
+		record, err := readRecord(conn)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		reply := handleRPCRecord(conn.RemoteAddr(), record, programs)
+		if reply == nil {
+			continue
+		}
+		err = writeRecord(conn, reply.buf)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleRPCRecord returns the reply to the call in `record`, or nil
+// if it's so malformed that no reply can be sent.
+func handleRPCRecord(remoteAddr net.Addr, record []byte,
+	programs map[rpcProgramKey]rpcProgram) *xdrWriter {
+	r := &xdrReader{buf: record}
+	xid := r.uint32()
+	msgType := r.uint32()
+	if r.err != nil || msgType != rpcMsgCall {
+		return nil
+	}
+	if r.uint32() != rpcVersion {
+		w := &xdrWriter{}
+		return w.uint32(xid).uint32(rpcMsgReply).uint32(rpcMsgDenied).
+			uint32(rpcMismatch).uint32(rpcVersion).uint32(rpcVersion)
+	}
+	call := &rpcCall{
+		xid:        xid,
+		prog:       r.uint32(),
+		vers:       r.uint32(),
+		proc:       r.uint32(),
+		remoteAddr: remoteAddr,
+	}
+	// Credentials and verifier.  We don't use them: all access
+	// happens as the logged-in KBFS user, and clients are
+	// restricted by address instead.
+	r.uint32()
+	r.opaque(maxAuthLen)
+	r.uint32()
+	r.opaque(maxAuthLen)
+	if r.err != nil {
+		return acceptedReply(xid, acceptGarbageArgs)
+	}
+	call.args = r
+
+	program, ok := programs[rpcProgramKey{call.prog, call.vers}]
+	if !ok {
+		// Report which versions we do support, if any.
+		low, high := ^uint32(0), uint32(0)
+		for key := range programs {
+			if key.prog != call.prog {
+				continue
+			}
+			if key.vers < low {
+				low = key.vers
+			}
+			if key.vers > high {
+				high = key.vers
+			}
+		}
+		if high == 0 {
+			return acceptedReply(xid, acceptProgUnavail)
+		}
+		return acceptedReply(xid, acceptProgMismatch).uint32(low).uint32(high)
+	}
+
+	results, stat := program.handle(call)
+	reply := acceptedReply(xid, stat)
+	if stat == acceptSuccess && results != nil {
+		reply.buf = append(reply.buf, results.buf...)
+	}
+	return reply
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"net"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// Server serves TLFs over NFSv3.  The MOUNT and NFS programs share a
+// single TCP port, and there's no portmapper or lock manager, so
+// clients must be told the port and mount with "nolock".
+type Server struct {
+	log logger.Logger
+	nfs *nfsServer
+}
+
+func newServer(log logger.Logger, nfs *nfsServer) *Server {
+	return &Server{log: log, nfs: nfs}
+}
+
+// NewServer returns a new Server for the exports in `nfsConfig`,
+// which must be valid.
+func NewServer(config libkbfs.Config, nfsConfig Config) (*Server, error) {
+	if err := nfsConfig.Validate(); err != nil {
+		return nil, err
+	}
+	log := config.MakeLogger("NFS")
+	exports := make([]*export, 0, len(nfsConfig.Exports))
+	for _, e := range nfsConfig.Exports {
+		parsed, err := e.parse()
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, &export{
+			dirPath:  parsed.dirPath,
+			fs:       newTlfFS(config, log, parsed.tlfType, parsed.name),
+			readOnly: parsed.readOnly,
+			clients:  parsed.clients,
+			attrs:    newAttrCache(parsed.attrCacheTTL),
+		})
+	}
+	return newServer(log, newNFSServer(
+		exports, nfsConfig.OwnerUID, nfsConfig.OwnerGID)), nil
+}
+
+// Serve accepts connections on `l` until it's closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	s.log.Debug("NFS connection from %s", conn.RemoteAddr())
+	err := s.nfs.serveConn(conn)
+	if err != nil {
+		s.log.Debug("NFS connection from %s failed: %+v",
+			conn.RemoteAddr(), err)
+	}
+}
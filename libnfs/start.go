@@ -0,0 +1,54 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"net"
+	"os"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// StartOptions are options for starting up.
+type StartOptions struct {
+	KbfsParams libkbfs.InitParams
+	// ListenAddr is the address to serve NFS (and MOUNT) on.
+	ListenAddr string
+	// ExportsFile is the path to a JSON config file; see Config.
+	ExportsFile string
+}
+
+// Start starts KBFS and serves the configured exports over NFS until
+// interrupted.
+func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
+	f, err := os.Open(options.ExportsFile)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+	nfsConfig, err := ParseConfig(f)
+	f.Close()
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
+	return libfs.StartServer(options.KbfsParams, kbCtx, func(
+		config libkbfs.Config, log logger.Logger) (libfs.Mounter, error) {
+		s, err := NewServer(config, nfsConfig)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", options.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Serving NFS on %s", listener.Addr())
+		for _, exp := range s.nfs.exports {
+			log.Info("Exporting %s", exp.dirPath)
+		}
+		return libfs.NewListenerMounter(s, listener), nil
+	})
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"context"
+	"os"
+	"path"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// Debug tag ID for an NFS FS.
+const ctxOpID = "NFS"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+// tlfFS is the filesystem for a single exported TLF.  It makes a new
+// libfs.FS whenever the old one becomes obsolete, so an export stays
+// usable for as long as the server runs.
+type tlfFS struct {
+	config  libkbfs.Config
+	log     logger.Logger
+	tlfType tlf.Type
+	name    string
+	fs      *libfs.TlfFSCache
+}
+
+var _ nfsFS = (*tlfFS)(nil)
+var _ billy.Change = (*tlfFS)(nil)
+var _ libfs.Syncer = (*tlfFS)(nil)
+
+func newTlfFS(config libkbfs.Config, log logger.Logger,
+	tlfType tlf.Type, name string) *tlfFS {
+	return &tlfFS{
+		config:  config,
+		log:     log,
+		tlfType: tlfType,
+		name:    name,
+		fs:      libfs.NewTlfFSCache(config),
+	}
+}
+
+func (tf *tlfFS) getFS() (*libfs.FS, error) {
+	ctx := libkbfs.CtxWithRandomIDReplayable(
+		context.Background(), ctxIDKey, ctxOpID, tf.log)
+	return tf.fs.Get(ctx, tf.tlfType, tf.name)
+}
+
+// Create implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Create(filename string) (billy.File, error) {
+	fs, err := tf.getFS()
+	if err != nil {
+		return nil, err
+	}
+	return fs.Create(filename)
+}
+
+// Open implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Open(filename string) (billy.File, error) {
+	fs, err := tf.getFS()
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(filename)
+}
+
+// OpenFile implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) OpenFile(
+	filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs, err := tf.getFS()
+	if err != nil {
+		return nil, err
+	}
+	return fs.OpenFile(filename, flag, perm)
+}
+
+// Stat implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Stat(filename string) (os.FileInfo, error) {
+	fs, err := tf.getFS()
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(filename)
+}
+
+// Rename implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Rename(oldpath, newpath string) error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.Rename(oldpath, newpath)
+}
+
+// Remove implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Remove(filename string) error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.Remove(filename)
+}
+
+// Join implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// ReadDir implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) ReadDir(p string) ([]os.FileInfo, error) {
+	fs, err := tf.getFS()
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(p)
+}
+
+// MkdirAll implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) MkdirAll(filename string, perm os.FileMode) error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.MkdirAll(filename, perm)
+}
+
+// Lstat implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Lstat(filename string) (os.FileInfo, error) {
+	fs, err := tf.getFS()
+	if err != nil {
+		return nil, err
+	}
+	return fs.Lstat(filename)
+}
+
+// Symlink implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Symlink(target, link string) error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.Symlink(target, link)
+}
+
+// Readlink implements the billy.Filesystem interface for tlfFS.
+func (tf *tlfFS) Readlink(link string) (string, error) {
+	fs, err := tf.getFS()
+	if err != nil {
+		return "", err
+	}
+	return fs.Readlink(link)
+}
+
+// Chmod implements the billy.Change interface for tlfFS.
+func (tf *tlfFS) Chmod(name string, mode os.FileMode) error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.Chmod(name, mode)
+}
+
+// Lchown implements the billy.Change interface for tlfFS.
+func (tf *tlfFS) Lchown(name string, uid, gid int) error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.Lchown(name, uid, gid)
+}
+
+// Chown implements the billy.Change interface for tlfFS.
+func (tf *tlfFS) Chown(name string, uid, gid int) error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.Chown(name, uid, gid)
+}
+
+// Chtimes implements the billy.Change interface for tlfFS.
+func (tf *tlfFS) Chtimes(name string, atime, mtime time.Time) error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.Chtimes(name, atime, mtime)
+}
+
+// SyncAll syncs the underlying libfs.FS.
+func (tf *tlfFS) SyncAll() error {
+	fs, err := tf.getFS()
+	if err != nil {
+		return err
+	}
+	return fs.SyncAll()
+}
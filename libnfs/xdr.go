@@ -0,0 +1,116 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libnfs
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the parts of XDR (RFC 4506) that we need.
+
+var errShortXDR = errors.New("Short XDR data")
+
+// xdrWriter builds XDR-encoded data.
+type xdrWriter struct {
+	buf []byte
+}
+
+func (w *xdrWriter) uint32(v uint32) *xdrWriter {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+	return w
+}
+
+func (w *xdrWriter) uint64(v uint64) *xdrWriter {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+	return w
+}
+
+func (w *xdrWriter) bool(v bool) *xdrWriter {
+	if v {
+		return w.uint32(1)
+	}
+	return w.uint32(0)
+}
+
+// fixedOpaque writes `b` without a length, padded to a multiple of
+// four bytes.
+func (w *xdrWriter) fixedOpaque(b []byte) *xdrWriter {
+	w.buf = append(w.buf, b...)
+	for len(w.buf)%4 != 0 {
+		w.buf = append(w.buf, 0)
+	}
+	return w
+}
+
+func (w *xdrWriter) opaque(b []byte) *xdrWriter {
+	w.uint32(uint32(len(b)))
+	return w.fixedOpaque(b)
+}
+
+func (w *xdrWriter) string(s string) *xdrWriter {
+	return w.opaque([]byte(s))
+}
+
+// xdrReader parses XDR-encoded data.  Once a read fails, all
+// further reads return zero values, and err is set.
+type xdrReader struct {
+	buf []byte
+	err error
+}
+
+func (r *xdrReader) uint32() uint32 {
+	if r.err != nil || len(r.buf) < 4 {
+		r.err = errShortXDR
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *xdrReader) uint64() uint64 {
+	if r.err != nil || len(r.buf) < 8 {
+		r.err = errShortXDR
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return v
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+func (r *xdrReader) fixedOpaque(n uint32) []byte {
+	padded := (n + 3) &^ 3
+	if r.err != nil || uint32(len(r.buf)) < padded {
+		r.err = errShortXDR
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[padded:]
+	return b
+}
+
+// opaque reads variable-length opaque data of at most `max` bytes.
+func (r *xdrReader) opaque(max uint32) []byte {
+	n := r.uint32()
+	if r.err == nil && n > max {
+		r.err = errors.Errorf("XDR opaque too long: %d > %d", n, max)
+		return nil
+	}
+	return r.fixedOpaque(n)
+}
+
+func (r *xdrReader) string(max uint32) string {
+	return string(r.opaque(max))
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libs3
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// s3Error is an error in the form S3 reports them.
+type s3Error struct {
+	code    string
+	message string
+	status  int
+}
+
+func (e *s3Error) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errAccessDenied = &s3Error{
+		"AccessDenied", "Access Denied", http.StatusForbidden}
+	errAuthorizationHeaderMalformed = &s3Error{
+		"AuthorizationHeaderMalformed",
+		"The authorization header is malformed", http.StatusBadRequest}
+	errBadDigest = &s3Error{
+		"BadDigest", "The Content-MD5 you specified did not match what " +
+			"we received", http.StatusBadRequest}
+	errContentSHA256Mismatch = &s3Error{
+		"XAmzContentSHA256Mismatch", "The provided " +
+			"x-amz-content-sha256 header does not match what was computed",
+		http.StatusBadRequest}
+	errEntityTooLarge = &s3Error{
+		"EntityTooLarge", "Your proposed upload exceeds the maximum " +
+			"allowed object size", http.StatusBadRequest}
+	errInternalError = &s3Error{
+		"InternalError", "We encountered an internal error. Please " +
+			"try again.", http.StatusInternalServerError}
+	errInvalidAccessKeyID = &s3Error{
+		"InvalidAccessKeyId", "The access key ID you provided does not " +
+			"exist in our records", http.StatusForbidden}
+	errInvalidArgument = &s3Error{
+		"InvalidArgument", "Invalid argument", http.StatusBadRequest}
+	errInvalidPart = &s3Error{
+		"InvalidPart", "One or more of the specified parts could not " +
+			"be found", http.StatusBadRequest}
+	errInvalidPartOrder = &s3Error{
+		"InvalidPartOrder", "The list of parts was not in ascending " +
+			"order", http.StatusBadRequest}
+	errMalformedXML = &s3Error{
+		"MalformedXML", "The XML you provided was not well-formed",
+		http.StatusBadRequest}
+	errMethodNotAllowed = &s3Error{
+		"MethodNotAllowed", "The specified method is not allowed " +
+			"against this resource", http.StatusMethodNotAllowed}
+	errNoSuchBucket = &s3Error{
+		"NoSuchBucket", "The specified bucket does not exist",
+		http.StatusNotFound}
+	errNoSuchKey = &s3Error{
+		"NoSuchKey", "The specified key does not exist",
+		http.StatusNotFound}
+	errNoSuchUpload = &s3Error{
+		"NoSuchUpload", "The specified multipart upload does not exist",
+		http.StatusNotFound}
+	errNotImplemented = &s3Error{
+		"NotImplemented", "A header or query you provided implies " +
+			"functionality that is not implemented", http.StatusNotImplemented}
+	errRequestTimeTooSkewed = &s3Error{
+		"RequestTimeTooSkewed", "The difference between the request " +
+			"time and the server's time is too large", http.StatusForbidden}
+	errSignatureDoesNotMatch = &s3Error{
+		"SignatureDoesNotMatch", "The request signature we calculated " +
+			"does not match the signature you provided",
+		http.StatusForbidden}
+)
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, e *s3Error) {
+	writeXML(w, e.status, errorResponse{
+		Code:     e.code,
+		Message:  e.message,
+		Resource: r.URL.Path,
+	})
+}
+
+// writeXML writes `v` as the XML body of a response.
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
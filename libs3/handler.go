@@ -0,0 +1,632 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libs3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+const (
+	// metaDir is the hidden directory, in the root of each bucket,
+	// holding in-progress uploads.  It doesn't show up in listings.
+	metaDir = ".kbfs_s3"
+	// maxObjectSize is the largest object (or part) that can be
+	// uploaded in one request, as in S3.
+	maxObjectSize = 5 << 30
+	// maxKeyLen is the longest object key S3 allows.
+	maxKeyLen = 1024
+	// maxXMLBodyLen bounds the size of XML request bodies.
+	maxXMLBodyLen = 1 << 20
+
+	s3TimeFormat   = "2006-01-02T15:04:05.000Z"
+	ownerID        = "kbfs"
+	ownerName      = "kbfs"
+	defaultMaxKeys = 1000
+)
+
+// bucketSource provides the filesystems that back buckets.
+type bucketSource interface {
+	// bucketNames returns the names of all buckets, sorted.
+	bucketNames() []string
+	// bucketFS returns the filesystem for the named bucket, or
+	// errNoSuchBucket.
+	bucketFS(name string) (billy.Filesystem, error)
+}
+
+// handler serves the S3 API, using path-style requests
+// ("/<bucket>/<key>").  Objects are files, and the "/"-separated
+// parts of their keys are directories; so, unlike in S3, a key can't
+// be both an object and a prefix of another object.  Object metadata
+// isn't stored, and ETags are derived from the size and modification
+// time rather than the content.
+type handler struct {
+	source    bucketSource
+	creds     Credentials
+	now       func() time.Time
+	startTime time.Time
+}
+
+var _ http.Handler = (*handler)(nil)
+
+func newHandler(source bucketSource, creds Credentials) *handler {
+	return &handler{
+		source:    source,
+		creds:     creds,
+		now:       time.Now,
+		startTime: time.Now(),
+	}
+}
+
+// toS3Error converts a filesystem error into an S3 error.
+func toS3Error(err error) *s3Error {
+	err = errors.Cause(err)
+	if e, ok := err.(*s3Error); ok {
+		return e
+	}
+	switch {
+	case os.IsNotExist(err):
+		return errNoSuchKey
+	case os.IsPermission(err):
+		return errAccessDenied
+	default:
+		return errInternalError
+	}
+}
+
+// keyToPath returns the path within a bucket for `key`, and whether
+// the key names a directory (i.e., ends with "/").
+func keyToPath(key string) (p string, isDir bool, ok bool) {
+	if key == "" || len(key) > maxKeyLen {
+		return "", false, false
+	}
+	isDir = strings.HasSuffix(key, "/")
+	trimmed := strings.TrimSuffix(key, "/")
+	for i, part := range strings.Split(trimmed, "/") {
+		if part == "" || part == "." || part == ".." ||
+			(i == 0 && part == metaDir) {
+			return "", false, false
+		}
+	}
+	return "/" + trimmed, isDir, true
+}
+
+func etag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+func newRandomID() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e := verifyRequest(r, h.creds, h.now()); e != nil {
+		writeS3Error(w, r, e)
+		return
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") == streamingPayload {
+		writeS3Error(w, r, errNotImplemented)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			writeS3Error(w, r, errMethodNotAllowed)
+			return
+		}
+		h.listBuckets(w, r)
+		return
+	}
+	fs, err := h.source.bucketFS(bucket)
+	if err != nil {
+		writeS3Error(w, r, toS3Error(err))
+		return
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		h.serveBucket(w, r, bucket, fs)
+		return
+	}
+	key := parts[1]
+	p, isDir, ok := keyToPath(key)
+	if !ok {
+		writeS3Error(w, r, errInvalidArgument)
+		return
+	}
+	h.serveObject(w, r, bucket, fs, key, p, isDir)
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketXML struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name    `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner       `xml:"Owner"`
+	Buckets []bucketXML `xml:"Buckets>Bucket"`
+}
+
+func (h *handler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	result := listAllMyBucketsResult{Owner: owner{ownerID, ownerName}}
+	for _, name := range h.source.bucketNames() {
+		result.Buckets = append(result.Buckets, bucketXML{
+			Name:         name,
+			CreationDate: h.startTime.UTC().Format(s3TimeFormat),
+		})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+}
+
+func (h *handler) serveBucket(w http.ResponseWriter, r *http.Request,
+	bucket string, fs billy.Filesystem) {
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case hasQuery(q, "location"):
+			// An empty constraint means us-east-1.
+			writeXML(w, http.StatusOK, locationConstraint{})
+		case len(q) == 0 || hasAnyQuery(q,
+			"list-type", "prefix", "delimiter", "marker",
+			"max-keys", "continuation-token", "start-after",
+			"encoding-type", "fetch-owner"):
+			h.listObjects(w, r, bucket, fs)
+		default:
+			writeS3Error(w, r, errNotImplemented)
+		}
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPut:
+		// Buckets are configured on the server, so "creating" one
+		// that exists is all that can succeed.
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPost:
+		if !hasQuery(q, "delete") {
+			writeS3Error(w, r, errNotImplemented)
+			return
+		}
+		h.deleteObjects(w, r, fs)
+	default:
+		writeS3Error(w, r, errMethodNotAllowed)
+	}
+}
+
+func hasQuery(q url.Values, name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+func hasAnyQuery(q url.Values, names ...string) bool {
+	for _, name := range names {
+		if hasQuery(q, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) serveObject(w http.ResponseWriter, r *http.Request,
+	bucket string, fs billy.Filesystem, key, p string, isDir bool) {
+	q := r.URL.Query()
+	var e *s3Error
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if hasQuery(q, "uploadId") || hasQuery(q, "acl") ||
+			hasQuery(q, "tagging") {
+			e = errNotImplemented
+			break
+		}
+		e = h.getObject(w, r, fs, p, isDir)
+	case http.MethodPut:
+		switch {
+		case hasQuery(q, "uploadId"):
+			e = h.uploadPart(w, r, fs, key)
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			e = h.copyObject(w, r, fs, p, isDir)
+		default:
+			e = h.putObject(w, r, fs, p, isDir)
+		}
+	case http.MethodPost:
+		switch {
+		case hasQuery(q, "uploads"):
+			e = h.createMultipartUpload(w, r, bucket, fs, key, isDir)
+		case hasQuery(q, "uploadId"):
+			e = h.completeMultipartUpload(w, r, bucket, fs, key, p)
+		default:
+			e = errNotImplemented
+		}
+	case http.MethodDelete:
+		if hasQuery(q, "uploadId") {
+			e = h.abortMultipartUpload(w, r, fs, key)
+		} else {
+			e = h.deleteObject(w, fs, p, isDir)
+		}
+	default:
+		e = errMethodNotAllowed
+	}
+	if e != nil {
+		writeS3Error(w, r, e)
+	}
+}
+
+func (h *handler) getObject(w http.ResponseWriter, r *http.Request,
+	fs billy.Filesystem, p string, isDir bool) *s3Error {
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return toS3Error(err)
+	}
+	if fi.IsDir() != isDir {
+		return errNoSuchKey
+	}
+	w.Header().Set("ETag", etag(fi))
+	if isDir {
+		w.Header().Set("Content-Type", "application/x-directory")
+		http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(nil))
+		return nil
+	}
+
+	f, err := fs.Open(p)
+	if err != nil {
+		return toS3Error(err)
+	}
+	defer f.Close()
+	contentType := mime.TypeByExtension(path.Ext(p))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+	return nil
+}
+
+// payloadChecker verifies a request body against its Content-MD5
+// and x-amz-content-sha256 headers as it's read.
+type payloadChecker struct {
+	wantMD5    []byte
+	wantSHA256 []byte
+	md5        hash.Hash
+	sha256     hash.Hash
+}
+
+func newPayloadChecker(r *http.Request) (*payloadChecker, *s3Error) {
+	c := &payloadChecker{md5: md5.New(), sha256: sha256.New()}
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		var err error
+		c.wantMD5, err = base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(c.wantMD5) != md5.Size {
+			return nil, errInvalidArgument
+		}
+	}
+	if payloadHash := r.Header.Get("X-Amz-Content-Sha256"); payloadHash != unsignedPayload {
+		var err error
+		c.wantSHA256, err = hex.DecodeString(payloadHash)
+		if err != nil || len(c.wantSHA256) != sha256.Size {
+			return nil, errContentSHA256Mismatch
+		}
+	}
+	return c, nil
+}
+
+// reader returns a reader for the body of `r`, which fails if the
+// body is too long.
+func (c *payloadChecker) reader(r *http.Request) io.Reader {
+	return io.TeeReader(io.LimitReader(r.Body, maxObjectSize+1),
+		io.MultiWriter(c.md5, c.sha256))
+}
+
+// check checks what was read so far against the headers.
+func (c *payloadChecker) check() *s3Error {
+	if c.wantMD5 != nil && !bytes.Equal(c.wantMD5, c.md5.Sum(nil)) {
+		return errBadDigest
+	}
+	if c.wantSHA256 != nil && !bytes.Equal(c.wantSHA256, c.sha256.Sum(nil)) {
+		return errContentSHA256Mismatch
+	}
+	return nil
+}
+
+// readXMLBody reads and checks a (small) XML request body into `v`.
+func readXMLBody(r *http.Request, v interface{}) *s3Error {
+	checker, e := newPayloadChecker(r)
+	if e != nil {
+		return e
+	}
+	body, err := ioutil.ReadAll(
+		io.LimitReader(checker.reader(r), maxXMLBodyLen+1))
+	if err != nil {
+		return errInternalError
+	}
+	if len(body) > maxXMLBodyLen {
+		return errMalformedXML
+	}
+	if e := checker.check(); e != nil {
+		return e
+	}
+	if err := xml.Unmarshal(body, v); err != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+// writeTemp copies `src` into a new temporary file in the bucket's
+// metadata directory, and returns its path.
+func writeTemp(fs billy.Filesystem, src io.Reader) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+	tmpPath := path.Join("/", metaDir, "tmp", id)
+	if err := fs.MkdirAll(path.Dir(tmpPath), 0755); err != nil {
+		return "", err
+	}
+	f, err := fs.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, src)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxObjectSize {
+		err = errEntityTooLarge
+	}
+	if err != nil {
+		_ = fs.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// moveIntoPlace renames the temporary file `tmpPath` to `p`, and
+// syncs.
+func moveIntoPlace(fs billy.Filesystem, tmpPath, p string) error {
+	if err := fs.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	if fi, err := fs.Stat(p); err == nil && fi.IsDir() {
+		_ = fs.Remove(tmpPath)
+		return errInvalidArgument
+	}
+	if err := fs.Rename(tmpPath, p); err != nil {
+		_ = fs.Remove(tmpPath)
+		return err
+	}
+	return libfs.SyncFS(fs)
+}
+
+func (h *handler) putObject(w http.ResponseWriter, r *http.Request,
+	fs billy.Filesystem, p string, isDir bool) *s3Error {
+	if isDir {
+		// Directory markers can't have any content.
+		if r.ContentLength > 0 {
+			return errNotImplemented
+		}
+		if err := fs.MkdirAll(p, 0755); err != nil {
+			return toS3Error(err)
+		}
+		if err := libfs.SyncFS(fs); err != nil {
+			return toS3Error(err)
+		}
+		fi, err := fs.Stat(p)
+		if err != nil {
+			return toS3Error(err)
+		}
+		w.Header().Set("ETag", etag(fi))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	checker, e := newPayloadChecker(r)
+	if e != nil {
+		return e
+	}
+	tmpPath, err := writeTemp(fs, checker.reader(r))
+	if err != nil {
+		return toS3Error(err)
+	}
+	if e := checker.check(); e != nil {
+		_ = fs.Remove(tmpPath)
+		return e
+	}
+	if err := moveIntoPlace(fs, tmpPath, p); err != nil {
+		return toS3Error(err)
+	}
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return toS3Error(err)
+	}
+	w.Header().Set("ETag", etag(fi))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+func (h *handler) copyObject(w http.ResponseWriter, r *http.Request,
+	fs billy.Filesystem, p string, isDir bool) *s3Error {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil || isDir || strings.Contains(source, "?") {
+		return errInvalidArgument
+	}
+	parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+	if len(parts) != 2 {
+		return errInvalidArgument
+	}
+	srcFS, err := h.source.bucketFS(parts[0])
+	if err != nil {
+		return toS3Error(err)
+	}
+	srcPath, srcIsDir, ok := keyToPath(parts[1])
+	if !ok || srcIsDir {
+		return errInvalidArgument
+	}
+
+	src, err := srcFS.Open(srcPath)
+	if err != nil {
+		return toS3Error(err)
+	}
+	tmpPath, err := writeTemp(fs, src)
+	src.Close()
+	if err != nil {
+		return toS3Error(err)
+	}
+	if err := moveIntoPlace(fs, tmpPath, p); err != nil {
+		return toS3Error(err)
+	}
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return toS3Error(err)
+	}
+	writeXML(w, http.StatusOK, copyObjectResult{
+		LastModified: fi.ModTime().UTC().Format(s3TimeFormat),
+		ETag:         etag(fi),
+	})
+	return nil
+}
+
+// removeObject removes the object at `p`, and any directories above
+// it that are left empty.  Removing a missing object succeeds, as in
+// S3.
+func removeObject(fs billy.Filesystem, p string, isDir bool) error {
+	fi, err := fs.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.IsDir() != isDir {
+		return nil
+	}
+	if isDir {
+		children, err := fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			// Only the marker goes away; the directory is still
+			// needed for the objects in it.
+			return nil
+		}
+	}
+	if err := fs.Remove(p); err != nil {
+		return err
+	}
+	for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		children, err := fs.ReadDir(dir)
+		if err != nil || len(children) > 0 {
+			break
+		}
+		if err := fs.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (h *handler) deleteObject(
+	w http.ResponseWriter, fs billy.Filesystem, p string, isDir bool) *s3Error {
+	if err := removeObject(fs, p, isDir); err != nil {
+		return toS3Error(err)
+	}
+	if err := libfs.SyncFS(fs); err != nil {
+		return toS3Error(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+func (h *handler) deleteObjects(
+	w http.ResponseWriter, r *http.Request, fs billy.Filesystem) {
+	var req deleteRequest
+	if e := readXMLBody(r, &req); e != nil {
+		writeS3Error(w, r, e)
+		return
+	}
+	var result deleteResult
+	for _, o := range req.Objects {
+		p, isDir, ok := keyToPath(o.Key)
+		e := errInvalidArgument
+		if ok {
+			e = nil
+			if err := removeObject(fs, p, isDir); err != nil {
+				e = toS3Error(err)
+			}
+		}
+		if e != nil {
+			result.Errors = append(result.Errors,
+				deleteError{o.Key, e.code, e.message})
+		} else if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{o.Key})
+		}
+	}
+	if err := libfs.SyncFS(fs); err != nil {
+		writeS3Error(w, r, toS3Error(err))
+		return
+	}
+	writeXML(w, http.StatusOK, result)
+}
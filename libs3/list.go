@@ -0,0 +1,276 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libs3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// sortName is the name that orders a directory entry among its
+// siblings the way its keys order among theirs.
+func sortName(fi os.FileInfo) string {
+	if fi.IsDir() {
+		return fi.Name() + "/"
+	}
+	return fi.Name()
+}
+
+type fileInfosByKey []os.FileInfo
+
+func (f fileInfosByKey) Len() int {
+	return len(f)
+}
+
+func (f fileInfosByKey) Less(i, j int) bool {
+	return sortName(f[i]) < sortName(f[j])
+}
+
+func (f fileInfosByKey) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+// keyWalker visits the keys of a bucket in order.
+type keyWalker struct {
+	fs        billy.Filesystem
+	prefix    string
+	delimiter string
+	marker    string
+	// visit is called for each key; it returns false to stop the
+	// walk.  Whole directories that would be rolled up into a
+	// common prefix are visited as a single key, ending in "/".
+	visit func(key string, fi os.FileInfo) bool
+}
+
+// walk visits the keys under `dir`, whose keys start with
+// `keyPrefix`.  It returns whether the walk should go on, and
+// whether `dir` was empty.
+func (kw *keyWalker) walk(dir, keyPrefix string) (
+	cont bool, empty bool, err error) {
+	children, err := kw.fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return true, true, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	sort.Sort(fileInfosByKey(children))
+	for _, fi := range children {
+		if dir == "/" && fi.Name() == metaDir {
+			continue
+		}
+		key := keyPrefix + fi.Name()
+		if !fi.IsDir() {
+			if strings.HasPrefix(key, kw.prefix) && key > kw.marker {
+				if !kw.visit(key, fi) {
+					return false, false, nil
+				}
+			}
+			continue
+		}
+
+		dirKey := key + "/"
+		if !strings.HasPrefix(dirKey, kw.prefix) &&
+			!strings.HasPrefix(kw.prefix, dirKey) {
+			continue
+		}
+		if dirKey < kw.marker && !strings.HasPrefix(kw.marker, dirKey) {
+			// Everything in here comes before the marker.
+			continue
+		}
+		if kw.delimiter == "/" && len(dirKey) > len(kw.prefix) {
+			if !kw.visit(dirKey, fi) {
+				return false, false, nil
+			}
+			continue
+		}
+		cont, childEmpty, err := kw.walk(path.Join(dir, fi.Name()), dirKey)
+		if err != nil || !cont {
+			return cont, false, err
+		}
+		if childEmpty && strings.HasPrefix(dirKey, kw.prefix) &&
+			dirKey > kw.marker {
+			// An empty directory is a directory marker object.
+			if !kw.visit(dirKey, fi) {
+				return false, false, nil
+			}
+		}
+	}
+	return true, len(children) == 0, nil
+}
+
+type objectXML struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+	Owner        *owner `xml:"Owner,omitempty"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Marker                *string        `xml:"Marker"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	KeyCount              *int           `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []objectXML    `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// listObjects handles both versions of ListObjects.
+func (h *handler) listObjects(w http.ResponseWriter, r *http.Request,
+	bucket string, fs billy.Filesystem) {
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	encodingType := q.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		writeS3Error(w, r, errInvalidArgument)
+		return
+	}
+	maxKeys := defaultMaxKeys
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, errInvalidArgument)
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	var marker string
+	if v2 {
+		marker = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			decoded, err := base64.URLEncoding.DecodeString(token)
+			if err != nil {
+				writeS3Error(w, r, errInvalidArgument)
+				return
+			}
+			marker = string(decoded)
+		}
+	} else {
+		marker = q.Get("marker")
+	}
+
+	encode := func(s string) string {
+		if encodingType == "url" {
+			return url.QueryEscape(s)
+		}
+		return s
+	}
+	result := listBucketResult{
+		Name:         bucket,
+		Prefix:       encode(prefix),
+		MaxKeys:      maxKeys,
+		Delimiter:    encode(delimiter),
+		EncodingType: encodingType,
+	}
+
+	var last, lastPrefix string
+	count := 0
+	visit := func(key string, fi os.FileInfo) bool {
+		entry := key
+		isPrefix := false
+		if delimiter != "" {
+			rest := key[len(prefix):]
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				entry = prefix + rest[:i+len(delimiter)]
+				isPrefix = true
+			}
+		}
+		if entry <= marker || (isPrefix && entry == lastPrefix) {
+			return true
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			return false
+		}
+		count++
+		last = entry
+		if isPrefix {
+			lastPrefix = entry
+			result.CommonPrefixes = append(result.CommonPrefixes,
+				commonPrefix{encode(entry)})
+			return true
+		}
+		o := objectXML{
+			Key:          encode(key),
+			LastModified: fi.ModTime().UTC().Format(s3TimeFormat),
+			ETag:         etag(fi),
+			StorageClass: "STANDARD",
+		}
+		if !fi.IsDir() {
+			o.Size = fi.Size()
+		}
+		if !v2 || q.Get("fetch-owner") == "true" {
+			o.Owner = &owner{ownerID, ownerName}
+		}
+		result.Contents = append(result.Contents, o)
+		return true
+	}
+
+	// Start from the deepest directory that all matching keys are in.
+	startKey := prefix[:strings.LastIndex(prefix, "/")+1]
+	kw := &keyWalker{
+		fs:        fs,
+		prefix:    prefix,
+		delimiter: delimiter,
+		marker:    marker,
+		visit:     visit,
+	}
+	dir := "/" + strings.TrimSuffix(startKey, "/")
+	if _, _, ok := keyToPath(startKey); startKey != "" && !ok {
+		// No keys can match an invalid prefix.
+		dir = ""
+	}
+	if dir != "" {
+		if _, _, err := kw.walk(path.Clean(dir), startKey); err != nil {
+			writeS3Error(w, r, toS3Error(err))
+			return
+		}
+	}
+
+	if v2 {
+		result.KeyCount = &count
+		result.StartAfter = encode(q.Get("start-after"))
+		result.ContinuationToken = q.Get("continuation-token")
+		if result.IsTruncated {
+			result.NextContinuationToken =
+				base64.URLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		encodedMarker := encode(marker)
+		result.Marker = &encodedMarker
+		if result.IsTruncated {
+			result.NextMarker = encode(last)
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
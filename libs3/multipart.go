@@ -0,0 +1,218 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libs3
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/keybase/kbfs/libfs"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// Multipart uploads are kept in "<metaDir>/uploads/<upload ID>/",
+// which holds a "key" file naming the object being uploaded, and a
+// file for each part.  Completing the upload concatenates the parts
+// into the object.
+
+const maxPartNumber = 10000
+
+func uploadDir(uploadID string) (string, bool) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", false
+	}
+	return path.Join("/", metaDir, "uploads", uploadID), true
+}
+
+func partPath(dir string, partNumber int) string {
+	return path.Join(dir, fmt.Sprintf("part-%05d", partNumber))
+}
+
+// checkUpload returns the directory of the given upload, if it
+// exists and is for `key`.
+func checkUpload(fs billy.Filesystem, uploadID, key string) (
+	string, *s3Error) {
+	dir, ok := uploadDir(uploadID)
+	if !ok {
+		return "", errNoSuchUpload
+	}
+	f, err := fs.Open(path.Join(dir, "key"))
+	if err != nil {
+		return "", errNoSuchUpload
+	}
+	defer f.Close()
+	uploadKey, err := ioutil.ReadAll(f)
+	if err != nil || string(uploadKey) != key {
+		return "", errNoSuchUpload
+	}
+	return dir, nil
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (h *handler) createMultipartUpload(w http.ResponseWriter,
+	r *http.Request, bucket string, fs billy.Filesystem, key string,
+	isDir bool) *s3Error {
+	if isDir {
+		return errInvalidArgument
+	}
+	uploadID, err := newRandomID()
+	if err != nil {
+		return errInternalError
+	}
+	dir, _ := uploadDir(uploadID)
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return toS3Error(err)
+	}
+	err = util.WriteFile(fs, path.Join(dir, "key"), []byte(key), 0644)
+	if err == nil {
+		err = libfs.SyncFS(fs)
+	}
+	if err != nil {
+		return toS3Error(err)
+	}
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+	return nil
+}
+
+func (h *handler) uploadPart(w http.ResponseWriter, r *http.Request,
+	fs billy.Filesystem, key string) *s3Error {
+	q := r.URL.Query()
+	partNumber, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return errInvalidArgument
+	}
+	dir, e := checkUpload(fs, q.Get("uploadId"), key)
+	if e != nil {
+		return e
+	}
+
+	checker, e := newPayloadChecker(r)
+	if e != nil {
+		return e
+	}
+	tmpPath, err := writeTemp(fs, checker.reader(r))
+	if err != nil {
+		return toS3Error(err)
+	}
+	if e := checker.check(); e != nil {
+		_ = fs.Remove(tmpPath)
+		return e
+	}
+	if err := moveIntoPlace(fs, tmpPath, partPath(dir, partNumber)); err != nil {
+		return toS3Error(err)
+	}
+	// Parts, unlike whole objects, get their MD5 as their ETag,
+	// since some clients check it.
+	w.Header().Set("ETag", `"`+hex.EncodeToString(checker.md5.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func (h *handler) completeMultipartUpload(w http.ResponseWriter,
+	r *http.Request, bucket string, fs billy.Filesystem, key, p string) *s3Error {
+	dir, e := checkUpload(fs, r.URL.Query().Get("uploadId"), key)
+	if e != nil {
+		return e
+	}
+	var req completeMultipartUpload
+	if e := readXMLBody(r, &req); e != nil {
+		return e
+	}
+	if len(req.Parts) == 0 {
+		return errMalformedXML
+	}
+	var readers []io.Reader
+	var closers []io.Closer
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+	for i, part := range req.Parts {
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+		f, err := fs.Open(partPath(dir, part.PartNumber))
+		if err != nil {
+			return errInvalidPart
+		}
+		readers = append(readers, f)
+		closers = append(closers, f)
+	}
+
+	tmpPath, err := writeTemp(fs, io.MultiReader(readers...))
+	if err != nil {
+		return toS3Error(err)
+	}
+	if err := moveIntoPlace(fs, tmpPath, p); err != nil {
+		return toS3Error(err)
+	}
+	if err := util.RemoveAll(fs, dir); err != nil {
+		return toS3Error(err)
+	}
+	if err := libfs.SyncFS(fs); err != nil {
+		return toS3Error(err)
+	}
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return toS3Error(err)
+	}
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag(fi),
+	})
+	return nil
+}
+
+func (h *handler) abortMultipartUpload(w http.ResponseWriter,
+	r *http.Request, fs billy.Filesystem, key string) *s3Error {
+	dir, e := checkUpload(fs, r.URL.Query().Get("uploadId"), key)
+	if e != nil {
+		return e
+	}
+	err := util.RemoveAll(fs, dir)
+	if err == nil {
+		err = libfs.SyncFS(fs)
+	}
+	if err != nil {
+		return toS3Error(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
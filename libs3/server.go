@@ -0,0 +1,153 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libs3
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// Debug tag ID for an S3 gateway FS.
+const ctxOpID = "S3"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+// BucketConfig maps a bucket to a directory in a TLF.
+type BucketConfig struct {
+	Name    string
+	TlfType tlf.Type
+	TlfName string
+	// Subdir is the directory within the TLF holding the bucket's
+	// objects, or "" for the whole TLF.
+	Subdir string
+}
+
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// ParseBucketSpec parses a bucket given as
+// "<bucket>=<type>/<name>[/<subdir>]", e.g.
+// "backups=private/alice/backups".
+func ParseBucketSpec(spec string) (BucketConfig, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return BucketConfig{}, errors.Errorf(
+			"%q is not of the form <bucket>=<type>/<name>[/<subdir>]", spec)
+	}
+	if !bucketNameRegexp.MatchString(parts[0]) {
+		return BucketConfig{}, errors.Errorf(
+			"%q is not a valid bucket name", parts[0])
+	}
+	pathParts := strings.SplitN(strings.Trim(parts[1], "/"), "/", 3)
+	if len(pathParts) < 2 {
+		return BucketConfig{}, errors.Errorf(
+			"%q doesn't name a TLF", parts[1])
+	}
+	tlfType, tlfName, err := libfs.ParseTlfPath(
+		pathParts[0] + "/" + pathParts[1])
+	if err != nil {
+		return BucketConfig{}, err
+	}
+	bc := BucketConfig{Name: parts[0], TlfType: tlfType, TlfName: tlfName}
+	if len(pathParts) == 3 {
+		bc.Subdir = strings.Trim(pathParts[2], "/")
+	}
+	return bc, nil
+}
+
+// Server serves the S3 API for a fixed set of buckets, each backed by
+// a directory in a TLF.  Only path-style requests
+// ("http://host/<bucket>/<key>"), signed with AWS Signature Version 4
+// using a single access key, are supported.
+type Server struct {
+	config  libkbfs.Config
+	log     logger.Logger
+	buckets map[string]BucketConfig
+	handler *handler
+	fs      *libfs.TlfFSCache
+}
+
+var _ http.Handler = (*Server)(nil)
+var _ bucketSource = (*Server)(nil)
+
+// NewServer returns a new Server for the given buckets, which only
+// accepts requests signed with `creds`.
+func NewServer(config libkbfs.Config, buckets []BucketConfig,
+	creds Credentials) (*Server, error) {
+	if len(buckets) == 0 {
+		return nil, errors.New("No buckets to serve")
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("No credentials given")
+	}
+	s := &Server{
+		config:  config,
+		log:     config.MakeLogger("S3"),
+		buckets: make(map[string]BucketConfig, len(buckets)),
+		fs:      libfs.NewTlfFSCache(config),
+	}
+	for _, b := range buckets {
+		if _, ok := s.buckets[b.Name]; ok {
+			return nil, errors.Errorf("Bucket %s given twice", b.Name)
+		}
+		s.buckets[b.Name] = b
+	}
+	s.handler = newHandler(s, creds)
+	return s, nil
+}
+
+func (s *Server) bucketNames() []string {
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getTlfFS returns the (possibly cached) FS for the root of the TLF
+// backing `bc`.
+func (s *Server) getTlfFS(bc BucketConfig) (*libfs.FS, error) {
+	ctx := libkbfs.CtxWithRandomIDReplayable(
+		context.Background(), ctxIDKey, ctxOpID, s.log)
+	return s.fs.Get(ctx, bc.TlfType, bc.TlfName)
+}
+
+func (s *Server) bucketFS(name string) (billy.Filesystem, error) {
+	bc, ok := s.buckets[name]
+	if !ok {
+		return nil, errNoSuchBucket
+	}
+	fs, err := s.getTlfFS(bc)
+	if err != nil {
+		return nil, err
+	}
+	if bc.Subdir == "" {
+		return fs, nil
+	}
+	if err := fs.MkdirAll(bc.Subdir, 0755); err != nil {
+		return nil, err
+	}
+	return fs.ChrootAsLibFS(bc.Subdir)
+}
+
+// ServeHTTP implements the http.Handler interface for Server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.log.Debug("%s %s", r.Method, r.URL)
+	s.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,223 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libs3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// This file implements verification of AWS Signature Version 4
+// (https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html),
+// for requests signed with an Authorization header.  Presigned URLs
+// and chunked ("STREAMING-AWS4-HMAC-SHA256-PAYLOAD") uploads aren't
+// supported.
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat  = "20060102T150405Z"
+	// unsignedPayload is the x-amz-content-sha256 value for requests
+	// whose body isn't covered by the signature.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// streamingPayload is the x-amz-content-sha256 value for
+	// chunked uploads.
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// maxClockSkew is how far a request's date can be from ours.
+	maxClockSkew = 15 * time.Minute
+)
+
+// Credentials are the single access key that may use the gateway.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// awsURIEncode encodes `s` the way SigV4 canonical requests need:
+// everything but unreserved characters is percent-encoded, and
+// slashes are too unless `path` is set.
+func awsURIEncode(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z',
+			'0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && path:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts,
+				awsURIEncode(k, false)+"="+awsURIEncode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func headerValue(r *http.Request, name string) string {
+	if name == "host" {
+		return r.Host
+	}
+	var values []string
+	for _, v := range r.Header[http.CanonicalHeaderKey(name)] {
+		values = append(values, strings.Join(strings.Fields(v), " "))
+	}
+	return strings.Join(values, ",")
+}
+
+// canonicalRequest returns the SigV4 canonical request for `r`.
+func canonicalRequest(
+	r *http.Request, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, h := range signedHeaders {
+		headers.WriteString(h + ":" + headerValue(r, h) + "\n")
+	}
+	uri := r.URL.Path
+	if uri == "" {
+		uri = "/"
+	}
+	return strings.Join([]string{
+		r.Method,
+		awsURIEncode(uri, true),
+		canonicalQuery(r.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signature returns the hex SigV4 signature of `canonical`.  `scope`
+// is "<date>/<region>/<service>/aws4_request".
+func signature(secret, amzDate, scope, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm, amzDate, scope, hex.EncodeToString(sum[:]),
+	}, "\n")
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// parsedAuth is a parsed SigV4 Authorization header.
+type parsedAuth struct {
+	accessKeyID   string
+	scope         string
+	signedHeaders []string
+	signature     string
+}
+
+func parseAuthorization(header string) (parsedAuth, bool) {
+	if !strings.HasPrefix(header, sigV4Algorithm+" ") {
+		return parsedAuth{}, false
+	}
+	var auth parsedAuth
+	for _, field := range strings.Split(
+		strings.TrimPrefix(header, sigV4Algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return parsedAuth{}, false
+		}
+		switch kv[0] {
+		case "Credential":
+			parts := strings.SplitN(kv[1], "/", 2)
+			if len(parts) != 2 {
+				return parsedAuth{}, false
+			}
+			auth.accessKeyID, auth.scope = parts[0], parts[1]
+		case "SignedHeaders":
+			auth.signedHeaders = strings.Split(kv[1], ";")
+		case "Signature":
+			auth.signature = kv[1]
+		}
+	}
+	if auth.accessKeyID == "" || len(auth.signedHeaders) == 0 ||
+		auth.signature == "" {
+		return parsedAuth{}, false
+	}
+	return auth, true
+}
+
+// verifyRequest checks the SigV4 signature of `r`, returning the S3
+// error to send if it's not valid.  It doesn't check that the
+// payload matches x-amz-content-sha256; that's up to whoever reads
+// the body.
+func verifyRequest(r *http.Request, creds Credentials, now time.Time) *s3Error {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return errAccessDenied
+	}
+	auth, ok := parseAuthorization(header)
+	if !ok {
+		return errAuthorizationHeaderMalformed
+	}
+	if subtle.ConstantTimeCompare(
+		[]byte(auth.accessKeyID), []byte(creds.AccessKeyID)) != 1 {
+		return errInvalidAccessKeyID
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return errAccessDenied
+	}
+	if d := now.Sub(t); d > maxClockSkew || d < -maxClockSkew {
+		return errRequestTimeTooSkewed
+	}
+	scopeParts := strings.Split(auth.scope, "/")
+	if len(scopeParts) != 4 || scopeParts[0] != t.Format("20060102") ||
+		scopeParts[3] != "aws4_request" {
+		return errAuthorizationHeaderMalformed
+	}
+	hasHost := false
+	for _, h := range auth.signedHeaders {
+		if h == "host" {
+			hasHost = true
+		}
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if !hasHost || payloadHash == "" {
+		return errAccessDenied
+	}
+
+	expected := signature(creds.SecretAccessKey, amzDate, auth.scope,
+		canonicalRequest(r, auth.signedHeaders, payloadHash))
+	if subtle.ConstantTimeCompare(
+		[]byte(expected), []byte(auth.signature)) != 1 {
+		return errSignatureDoesNotMatch
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsftp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the parts of the wire format of version 3 of
+// the SFTP protocol
+// (https://tools.ietf.org/html/draft-ietf-secsh-filexfer-02) that
+// we need.
+
+const sftpProtocolVersion = 3
+
+// maxPacketLen bounds the length of incoming packets, so that a
+// client can't make us allocate arbitrary amounts of memory.
+// OpenSSH's sftp-server uses the same limit.
+const maxPacketLen = 256 * 1024
+
+// maxReadLen bounds the amount of data returned by a single read.
+const maxReadLen = 64 * 1024
+
+type packetType byte
+
+const (
+	packetInit     packetType = 1
+	packetVersion  packetType = 2
+	packetOpen     packetType = 3
+	packetClose    packetType = 4
+	packetRead     packetType = 5
+	packetWrite    packetType = 6
+	packetLstat    packetType = 7
+	packetFstat    packetType = 8
+	packetSetstat  packetType = 9
+	packetFsetstat packetType = 10
+	packetOpendir  packetType = 11
+	packetReaddir  packetType = 12
+	packetRemove   packetType = 13
+	packetMkdir    packetType = 14
+	packetRmdir    packetType = 15
+	packetRealpath packetType = 16
+	packetStat     packetType = 17
+	packetRename   packetType = 18
+	packetReadlink packetType = 19
+	packetSymlink  packetType = 20
+
+	packetStatus packetType = 101
+	packetHandle packetType = 102
+	packetData   packetType = 103
+	packetName   packetType = 104
+	packetAttrs  packetType = 105
+)
+
+type statusCode uint32
+
+const (
+	statusOK               statusCode = 0
+	statusEOF              statusCode = 1
+	statusNoSuchFile       statusCode = 2
+	statusPermissionDenied statusCode = 3
+	statusFailure          statusCode = 4
+	statusBadMessage       statusCode = 5
+	statusOpUnsupported    statusCode = 8
+)
+
+// Flags for packetOpen.
+const (
+	openRead   = 0x1
+	openWrite  = 0x2
+	openAppend = 0x4
+	openCreat  = 0x8
+	openTrunc  = 0x10
+	openExcl   = 0x20
+)
+
+// Flags for file attributes.
+const (
+	attrSize        = 0x1
+	attrUIDGID      = 0x2
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+	attrExtended    = 0x80000000
+)
+
+// Unix file type bits, as used in the permissions attribute.
+const (
+	modeDir     = 0040000
+	modeRegular = 0100000
+	modeSymlink = 0120000
+)
+
+var errShortPacket = errors.New("Short SFTP packet")
+
+// readPacket reads a single packet from r.
+func readPacket(r io.Reader) (packetType, []byte, error) {
+	var lenBuf [4]byte
+	_, err := io.ReadFull(r, lenBuf[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(lenBuf[:])
+	if length < 1 || length > maxPacketLen {
+		return 0, nil, errors.Errorf("Bad SFTP packet length %d", length)
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	return packetType(buf[0]), buf[1:], nil
+}
+
+// packetBuilder builds an outgoing packet.
+type packetBuilder struct {
+	buf []byte
+}
+
+func newPacket(t packetType) *packetBuilder {
+	// Leave room for the length.
+	return &packetBuilder{buf: []byte{0, 0, 0, 0, byte(t)}}
+}
+
+func (p *packetBuilder) uint32(v uint32) *packetBuilder {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+	return p
+}
+
+func (p *packetBuilder) uint64(v uint64) *packetBuilder {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+	return p
+}
+
+func (p *packetBuilder) bytes(b []byte) *packetBuilder {
+	p.uint32(uint32(len(b)))
+	p.buf = append(p.buf, b...)
+	return p
+}
+
+func (p *packetBuilder) string(s string) *packetBuilder {
+	return p.bytes([]byte(s))
+}
+
+func (p *packetBuilder) attrs(fi os.FileInfo) *packetBuilder {
+	mtime := uint32(fi.ModTime().Unix())
+	return p.uint32(attrSize | attrPermissions | attrACModTime).
+		uint64(uint64(fi.Size())).
+		uint32(fileModeToUnix(fi.Mode())).
+		uint32(mtime).
+		uint32(mtime)
+}
+
+// finish fills in the length and returns the packet's bytes.
+func (p *packetBuilder) finish() []byte {
+	binary.BigEndian.PutUint32(p.buf, uint32(len(p.buf)-4))
+	return p.buf
+}
+
+// packetReader parses an incoming packet.
+type packetReader struct {
+	buf []byte
+	err error
+}
+
+func (p *packetReader) uint32() uint32 {
+	if p.err != nil || len(p.buf) < 4 {
+		p.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(p.buf)
+	p.buf = p.buf[4:]
+	return v
+}
+
+func (p *packetReader) uint64() uint64 {
+	if p.err != nil || len(p.buf) < 8 {
+		p.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint64(p.buf)
+	p.buf = p.buf[8:]
+	return v
+}
+
+func (p *packetReader) bytes() []byte {
+	n := p.uint32()
+	if p.err != nil || uint32(len(p.buf)) < n {
+		p.err = errShortPacket
+		return nil
+	}
+	b := p.buf[:n]
+	p.buf = p.buf[n:]
+	return b
+}
+
+func (p *packetReader) string() string {
+	return string(p.bytes())
+}
+
+// fileAttrs holds the attributes a client asked to set.
+type fileAttrs struct {
+	flags uint32
+	size  uint64
+	perm  uint32
+	atime time.Time
+	mtime time.Time
+}
+
+func (p *packetReader) attrs() fileAttrs {
+	var a fileAttrs
+	a.flags = p.uint32()
+	if a.flags&attrSize != 0 {
+		a.size = p.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		// Ownership can't be changed in KBFS.
+		p.uint32()
+		p.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.perm = p.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		a.atime = time.Unix(int64(p.uint32()), 0)
+		a.mtime = time.Unix(int64(p.uint32()), 0)
+	}
+	if a.flags&attrExtended != 0 {
+		n := p.uint32()
+		for i := uint32(0); i < n && p.err == nil; i++ {
+			p.string()
+			p.string()
+		}
+	}
+	return a
+}
+
+func fileModeToUnix(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= modeDir
+	case mode&os.ModeSymlink != 0:
+		m |= modeSymlink
+	default:
+		m |= modeRegular
+	}
+	return m
+}
+
+// longName returns an `ls -l`-style description of a file, which
+// some clients display as-is.
+func longName(fi os.FileInfo) string {
+	return fmt.Sprintf("%s 1 kbfs kbfs %8d %s %s", fi.Mode(), fi.Size(),
+		fi.ModTime().Format("Jan _2 15:04"), fi.Name())
+}
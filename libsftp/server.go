@@ -0,0 +1,189 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// hostKeyMessage is signed by the device key to derive the host key.
+const hostKeyMessage = "Keybase KBFS SFTP host key v1"
+
+// DeriveHostKey returns an SSH host key derived from the device key
+// behind `signer`.  Since ed25519 signatures are deterministic, the
+// same device always gets the same host key, so clients don't see
+// the host key change across restarts; but the host key reveals
+// nothing about the device key, and can't be used to sign anything
+// on its behalf.
+func DeriveHostKey(ctx context.Context, signer kbfscrypto.Signer) (
+	ssh.Signer, error) {
+	sigInfo, err := signer.Sign(ctx, []byte(hostKeyMessage))
+	if err != nil {
+		return nil, err
+	}
+	seed := sha256.Sum256(sigInfo.Signature)
+	_, privateKey, err := ed25519.GenerateKey(bytes.NewReader(seed[:]))
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(privateKey)
+}
+
+// Server serves a fixed set of TLFs over SFTP to clients holding one
+// of a set of authorized keys.  Each TLF is at "/<type>/<name>"; see
+// libfs.ParseTlfPath.
+type Server struct {
+	log       logger.Logger
+	fs        sftpFS
+	hostKey   ssh.Signer
+	sshConfig *ssh.ServerConfig
+}
+
+func newServer(log logger.Logger, fs sftpFS, hostKey ssh.Signer,
+	authorizedKeys []ssh.PublicKey) *Server {
+	authorized := make(map[string]bool, len(authorizedKeys))
+	for _, key := range authorizedKeys {
+		authorized[string(key.Marshal())] = true
+	}
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(
+			conn ssh.ConnMetadata, key ssh.PublicKey) (
+			*ssh.Permissions, error) {
+			if !authorized[string(key.Marshal())] {
+				return nil, errors.Errorf(
+					"Unauthorized key for %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+	return &Server{
+		log:       log,
+		fs:        fs,
+		hostKey:   hostKey,
+		sshConfig: sshConfig,
+	}
+}
+
+// NewServer returns a new Server for the given TLFs, using a host key
+// derived from the current device key (see DeriveHostKey).
+func NewServer(ctx context.Context, config libkbfs.Config,
+	tlfPaths []string, authorizedKeys []ssh.PublicKey) (*Server, error) {
+	if len(authorizedKeys) == 0 {
+		return nil, errors.New("No authorized keys")
+	}
+	fs, err := newTlfFS(config, tlfPaths)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := DeriveHostKey(ctx, config.Crypto())
+	if err != nil {
+		return nil, err
+	}
+	return newServer(fs.log, fs, hostKey, authorizedKeys), nil
+}
+
+// HostKey returns the server's public host key.
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// Serve accepts connections on `l` until it's closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
+	if err != nil {
+		s.log.Debug("SSH handshake with %s failed: %+v",
+			conn.RemoteAddr(), err)
+		return
+	}
+	defer sshConn.Close()
+	s.log.Debug("SSH connection from %s (%s)",
+		sshConn.RemoteAddr(), sshConn.ClientVersion())
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			s.log.Debug("Couldn't accept channel: %+v", err)
+			continue
+		}
+		go s.serveChannel(ch, chReqs)
+	}
+}
+
+// subsystemName parses the payload of a "subsystem" request.
+func subsystemName(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	n := binary.BigEndian.Uint32(payload)
+	if uint32(len(payload)-4) < n {
+		return ""
+	}
+	return string(payload[4 : 4+n])
+}
+
+// serveChannel waits for an "sftp" subsystem request on the channel
+// and serves it; everything else (shells, commands, etc.) is
+// refused.
+func (s *Server) serveChannel(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "subsystem" || subsystemName(req.Payload) != "sftp" {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		if req.WantReply {
+			req.Reply(true, nil)
+		}
+		go ssh.DiscardRequests(reqs)
+
+		err := newSession(s.fs, ch).serve()
+		status := uint32(0)
+		if err != nil {
+			s.log.Debug("SFTP session failed: %+v", err)
+			status = 1
+		}
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct {
+			Status uint32
+		}{status}))
+		return
+	}
+}
+
+// HostKeyFingerprint returns the SHA256 fingerprint of `key`, in the
+// same format as ssh-keygen -l.
+func HostKeyFingerprint(key ssh.PublicKey) string {
+	return fmt.Sprintf("%s %s", key.Type(), ssh.FingerprintSHA256(key))
+}
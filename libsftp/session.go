@@ -0,0 +1,519 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsftp
+
+import (
+	"io"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// readDirBatch is the number of entries returned per readdir request.
+const readDirBatch = 100
+
+// sftpFS is the subset of billy.Filesystem that a session needs.
+// SETSTAT and FSETSTAT use billy.Change for permissions and times,
+// so without it, setstat only truncates.
+type sftpFS interface {
+	billy.Basic
+	billy.Dir
+	billy.Symlink
+}
+
+type openHandle struct {
+	path string
+	// Exactly one of file and dirEntries is set.
+	file       billy.File
+	dirEntries []os.FileInfo
+	isDir      bool
+}
+
+// session serves a single SFTP session, e.g. a single "sftp"
+// subsystem request on an SSH channel.  Requests are processed one
+// at a time, in order.
+type session struct {
+	fs sftpFS
+	rw io.ReadWriter
+
+	handles    map[string]*openHandle
+	nextHandle uint64
+}
+
+func newSession(fs sftpFS, rw io.ReadWriter) *session {
+	return &session{
+		fs:      fs,
+		rw:      rw,
+		handles: make(map[string]*openHandle),
+	}
+}
+
+// cleanPath makes a client-supplied path absolute, relative to the
+// root (which is also the home directory), and gets rid of any "..".
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func (s *session) send(p *packetBuilder) error {
+	_, err := s.rw.Write(p.finish())
+	return err
+}
+
+func (s *session) sendStatus(id uint32, code statusCode, msg string) error {
+	return s.send(newPacket(packetStatus).uint32(id).uint32(uint32(code)).
+		string(msg).string(""))
+}
+
+func (s *session) sendError(id uint32, err error) error {
+	cause := errors.Cause(err)
+	switch {
+	case cause == io.EOF:
+		return s.sendStatus(id, statusEOF, "EOF")
+	case os.IsNotExist(cause):
+		return s.sendStatus(id, statusNoSuchFile, "No such file")
+	case os.IsPermission(cause):
+		return s.sendStatus(id, statusPermissionDenied, "Permission denied")
+	default:
+		return s.sendStatus(id, statusFailure, err.Error())
+	}
+}
+
+func (s *session) sendResult(id uint32, err error) error {
+	if err != nil {
+		return s.sendError(id, err)
+	}
+	return s.sendStatus(id, statusOK, "OK")
+}
+
+func (s *session) sync() error {
+	if sy, ok := s.fs.(libfs.Syncer); ok {
+		return sy.SyncAll()
+	}
+	return nil
+}
+
+func (s *session) closeAll() {
+	for _, h := range s.handles {
+		if h.file != nil {
+			h.file.Close()
+		}
+	}
+	s.handles = nil
+	s.sync()
+}
+
+// serve processes requests until the client goes away, or sends
+// something that isn't SFTP.
+func (s *session) serve() error {
+	defer s.closeAll()
+
+	t, _, err := readPacket(s.rw)
+	if err != nil {
+		return err
+	}
+	if t != packetInit {
+		return errors.Errorf("Expected SFTP init, got packet type %d", t)
+	}
+	err = s.send(newPacket(packetVersion).uint32(sftpProtocolVersion))
+	if err != nil {
+		return err
+	}
+
+	for {
+		t, payload, err := readPacket(s.rw)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = s.handle(t, &packetReader{buf: payload})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle processes a single request.  It only returns an error if
+// the response couldn't be sent.
+func (s *session) handle(t packetType, r *packetReader) error {
+	id := r.uint32()
+	if r.err != nil {
+		return s.sendStatus(0, statusBadMessage, "Missing request ID")
+	}
+
+	var err error
+	switch t {
+	case packetOpen:
+		p, pflags, attrs := r.string(), r.uint32(), r.attrs()
+		if r.err == nil {
+			return s.open(id, cleanPath(p), pflags, attrs)
+		}
+	case packetClose:
+		handle := r.string()
+		if r.err == nil {
+			return s.sendResult(id, s.close(handle))
+		}
+	case packetRead:
+		handle, offset, length := r.string(), r.uint64(), r.uint32()
+		if r.err == nil {
+			return s.read(id, handle, offset, length)
+		}
+	case packetWrite:
+		handle, offset, data := r.string(), r.uint64(), r.bytes()
+		if r.err == nil {
+			return s.sendResult(id, s.write(handle, offset, data))
+		}
+	case packetLstat, packetStat:
+		p := r.string()
+		if r.err == nil {
+			return s.stat(id, cleanPath(p), t == packetLstat)
+		}
+	case packetFstat:
+		handle := r.string()
+		if r.err == nil {
+			h, ok := s.handles[handle]
+			if !ok {
+				return s.sendStatus(id, statusFailure, "Bad handle")
+			}
+			return s.stat(id, h.path, false)
+		}
+	case packetSetstat:
+		p, attrs := r.string(), r.attrs()
+		if r.err == nil {
+			return s.sendResult(id, s.setstat(cleanPath(p), nil, attrs))
+		}
+	case packetFsetstat:
+		handle, attrs := r.string(), r.attrs()
+		if r.err == nil {
+			h, ok := s.handles[handle]
+			if !ok {
+				return s.sendStatus(id, statusFailure, "Bad handle")
+			}
+			return s.sendResult(id, s.setstat(h.path, h.file, attrs))
+		}
+	case packetOpendir:
+		p := r.string()
+		if r.err == nil {
+			return s.opendir(id, cleanPath(p))
+		}
+	case packetReaddir:
+		handle := r.string()
+		if r.err == nil {
+			return s.readdir(id, handle)
+		}
+	case packetRemove:
+		p := r.string()
+		if r.err == nil {
+			return s.sendResult(id, s.remove(cleanPath(p)))
+		}
+	case packetMkdir:
+		p, attrs := r.string(), r.attrs()
+		if r.err == nil {
+			return s.sendResult(id, s.mkdir(cleanPath(p), attrs))
+		}
+	case packetRmdir:
+		p := r.string()
+		if r.err == nil {
+			return s.sendResult(id, s.rmdir(cleanPath(p)))
+		}
+	case packetRealpath:
+		p := r.string()
+		if r.err == nil {
+			p = cleanPath(p)
+			return s.send(newPacket(packetName).uint32(id).uint32(1).
+				string(p).string(p).uint32(0))
+		}
+	case packetRename:
+		oldPath, newPath := r.string(), r.string()
+		if r.err == nil {
+			return s.sendResult(
+				id, s.rename(cleanPath(oldPath), cleanPath(newPath)))
+		}
+	case packetReadlink:
+		p := r.string()
+		if r.err == nil {
+			target, err := s.fs.Readlink(cleanPath(p))
+			if err != nil {
+				return s.sendError(id, err)
+			}
+			return s.send(newPacket(packetName).uint32(id).uint32(1).
+				string(target).string(target).uint32(0))
+		}
+	case packetSymlink:
+		// OpenSSH sends the target first, contrary to the draft
+		// spec, and every other client follows suit.
+		target, link := r.string(), r.string()
+		if r.err == nil {
+			err = s.fs.Symlink(target, cleanPath(link))
+			if err == nil {
+				err = s.sync()
+			}
+			return s.sendResult(id, err)
+		}
+	default:
+		return s.sendStatus(id, statusOpUnsupported, "Unsupported request")
+	}
+	return s.sendStatus(id, statusBadMessage, r.err.Error())
+}
+
+func (s *session) newHandle(h *openHandle) string {
+	handle := strconv.FormatUint(s.nextHandle, 10)
+	s.nextHandle++
+	s.handles[handle] = h
+	return handle
+}
+
+func (s *session) open(
+	id uint32, p string, pflags uint32, attrs fileAttrs) error {
+	var flag int
+	switch {
+	case pflags&openRead != 0 && pflags&openWrite != 0:
+		flag = os.O_RDWR
+	case pflags&openWrite != 0:
+		flag = os.O_WRONLY
+	default:
+		flag = os.O_RDONLY
+	}
+	if pflags&openAppend != 0 {
+		flag |= os.O_APPEND
+	}
+	if pflags&openCreat != 0 {
+		flag |= os.O_CREATE
+	}
+	if pflags&openTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+	if pflags&openExcl != 0 {
+		flag |= os.O_EXCL
+	}
+	perm := os.FileMode(0644)
+	if attrs.flags&attrPermissions != 0 {
+		perm = os.FileMode(attrs.perm).Perm()
+	}
+
+	if fi, err := s.fs.Stat(p); err == nil && fi.IsDir() {
+		return s.sendStatus(id, statusFailure, "Is a directory")
+	}
+	f, err := s.fs.OpenFile(p, flag, perm)
+	if err != nil {
+		return s.sendError(id, err)
+	}
+	handle := s.newHandle(&openHandle{path: p, file: f})
+	return s.send(newPacket(packetHandle).uint32(id).string(handle))
+}
+
+func (s *session) close(handle string) error {
+	h, ok := s.handles[handle]
+	if !ok {
+		return errors.New("Bad handle")
+	}
+	delete(s.handles, handle)
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	if err != nil {
+		return err
+	}
+	return s.sync()
+}
+
+func (s *session) read(
+	id uint32, handle string, offset uint64, length uint32) error {
+	h, ok := s.handles[handle]
+	if !ok || h.file == nil {
+		return s.sendStatus(id, statusFailure, "Bad handle")
+	}
+	if length > maxReadLen {
+		length = maxReadLen
+	}
+	buf := make([]byte, length)
+	n, err := h.file.ReadAt(buf, int64(offset))
+	if n == 0 && err == nil {
+		err = io.EOF
+	}
+	if n == 0 {
+		return s.sendError(id, err)
+	}
+	return s.send(newPacket(packetData).uint32(id).bytes(buf[:n]))
+}
+
+func (s *session) write(handle string, offset uint64, data []byte) error {
+	h, ok := s.handles[handle]
+	if !ok || h.file == nil {
+		return errors.New("Bad handle")
+	}
+	_, err := h.file.Seek(int64(offset), io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = h.file.Write(data)
+	return err
+}
+
+func (s *session) stat(id uint32, p string, lstat bool) error {
+	var fi os.FileInfo
+	var err error
+	if lstat {
+		fi, err = s.fs.Lstat(p)
+	} else {
+		fi, err = s.fs.Stat(p)
+	}
+	if err != nil {
+		return s.sendError(id, err)
+	}
+	return s.send(newPacket(packetAttrs).uint32(id).attrs(fi))
+}
+
+// setstat applies the given attributes to `p`, using `f` to change
+// the size if it's non-nil.
+func (s *session) setstat(p string, f billy.File, attrs fileAttrs) error {
+	if attrs.flags&attrSize != 0 {
+		if f == nil {
+			var err error
+			f, err = s.fs.OpenFile(p, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+		}
+		err := f.Truncate(int64(attrs.size))
+		if err != nil {
+			return err
+		}
+	}
+	if change, ok := s.fs.(billy.Change); ok {
+		if attrs.flags&attrPermissions != 0 {
+			err := change.Chmod(p, os.FileMode(attrs.perm).Perm())
+			if err != nil {
+				return err
+			}
+		}
+		if attrs.flags&attrACModTime != 0 {
+			err := change.Chtimes(p, attrs.atime, attrs.mtime)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return s.sync()
+}
+
+func (s *session) opendir(id uint32, p string) error {
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		return s.sendError(id, err)
+	}
+	if !fi.IsDir() {
+		return s.sendStatus(id, statusFailure, "Not a directory")
+	}
+	entries, err := s.fs.ReadDir(p)
+	if err != nil {
+		return s.sendError(id, err)
+	}
+	handle := s.newHandle(
+		&openHandle{path: p, dirEntries: entries, isDir: true})
+	return s.send(newPacket(packetHandle).uint32(id).string(handle))
+}
+
+func (s *session) readdir(id uint32, handle string) error {
+	h, ok := s.handles[handle]
+	if !ok || !h.isDir {
+		return s.sendStatus(id, statusFailure, "Bad handle")
+	}
+	if len(h.dirEntries) == 0 {
+		return s.sendStatus(id, statusEOF, "EOF")
+	}
+	batch := h.dirEntries
+	if len(batch) > readDirBatch {
+		batch = batch[:readDirBatch]
+	}
+	h.dirEntries = h.dirEntries[len(batch):]
+
+	p := newPacket(packetName).uint32(id).uint32(uint32(len(batch)))
+	for _, fi := range batch {
+		p.string(fi.Name()).string(longName(fi)).attrs(fi)
+	}
+	return s.send(p)
+}
+
+func (s *session) remove(p string) error {
+	fi, err := s.fs.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return errors.New("Is a directory")
+	}
+	err = s.fs.Remove(p)
+	if err != nil {
+		return err
+	}
+	return s.sync()
+}
+
+func (s *session) mkdir(p string, attrs fileAttrs) error {
+	if _, err := s.fs.Lstat(p); err == nil {
+		return errors.New("File exists")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	parent, err := s.fs.Stat(path.Dir(p))
+	if err != nil {
+		return err
+	}
+	if !parent.IsDir() {
+		return errors.New("Not a directory")
+	}
+	perm := os.FileMode(0755)
+	if attrs.flags&attrPermissions != 0 {
+		perm = os.FileMode(attrs.perm).Perm()
+	}
+	err = s.fs.MkdirAll(p, perm)
+	if err != nil {
+		return err
+	}
+	return s.sync()
+}
+
+func (s *session) rmdir(p string) error {
+	fi, err := s.fs.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("Not a directory")
+	}
+	entries, err := s.fs.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return errors.New("Directory not empty")
+	}
+	err = s.fs.Remove(p)
+	if err != nil {
+		return err
+	}
+	return s.sync()
+}
+
+func (s *session) rename(oldPath, newPath string) error {
+	// Version 3 of the protocol doesn't allow overwriting.
+	if _, err := s.fs.Lstat(newPath); err == nil {
+		return errors.New("File exists")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	err := s.fs.Rename(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	return s.sync()
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsftp
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+// testClient speaks just enough SFTP to exercise a session.
+type testClient struct {
+	t      *testing.T
+	rw     io.ReadWriter
+	nextID uint32
+}
+
+func (c *testClient) request(t packetType, build func(*packetBuilder)) (
+	packetType, *packetReader) {
+	c.nextID++
+	p := newPacket(t).uint32(c.nextID)
+	build(p)
+	_, err := c.rw.Write(p.finish())
+	require.NoError(c.t, err)
+	respType, payload, err := readPacket(c.rw)
+	require.NoError(c.t, err)
+	r := &packetReader{buf: payload}
+	require.Equal(c.t, c.nextID, r.uint32())
+	return respType, r
+}
+
+func (c *testClient) requireStatus(
+	code statusCode, t packetType, build func(*packetBuilder)) {
+	respType, r := c.request(t, build)
+	require.Equal(c.t, packetStatus, respType)
+	require.Equal(c.t, code, statusCode(r.uint32()))
+}
+
+func (c *testClient) handle(t packetType, build func(*packetBuilder)) string {
+	respType, r := c.request(t, build)
+	require.Equal(c.t, packetHandle, respType)
+	return r.string()
+}
+
+func startTestSession(t *testing.T, fs sftpFS) (*testClient, func()) {
+	clientConn, serverConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- newSession(fs, serverConn).serve()
+	}()
+
+	_, err := clientConn.Write(
+		newPacket(packetInit).uint32(sftpProtocolVersion).finish())
+	require.NoError(t, err)
+	respType, payload, err := readPacket(clientConn)
+	require.NoError(t, err)
+	require.Equal(t, packetVersion, respType)
+	r := &packetReader{buf: payload}
+	require.Equal(t, uint32(sftpProtocolVersion), r.uint32())
+
+	return &testClient{t: t, rw: clientConn}, func() {
+		clientConn.Close()
+		require.NoError(t, <-done)
+	}
+}
+
+func testSession(t *testing.T, c *testClient) {
+	str := func(s string) func(*packetBuilder) {
+		return func(p *packetBuilder) { p.string(s) }
+	}
+
+	c.requireStatus(statusOK, packetMkdir, func(p *packetBuilder) {
+		p.string("/d").uint32(0)
+	})
+	c.requireStatus(statusFailure, packetMkdir, func(p *packetBuilder) {
+		p.string("/d").uint32(0)
+	})
+	c.requireStatus(statusNoSuchFile, packetMkdir, func(p *packetBuilder) {
+		p.string("/x/y").uint32(0)
+	})
+
+	// Write a file, in two chunks.
+	h := c.handle(packetOpen, func(p *packetBuilder) {
+		p.string("d/a.txt").uint32(openWrite | openCreat | openTrunc).
+			uint32(0)
+	})
+	c.requireStatus(statusOK, packetWrite, func(p *packetBuilder) {
+		p.string(h).uint64(0).string("hello ")
+	})
+	c.requireStatus(statusOK, packetWrite, func(p *packetBuilder) {
+		p.string(h).uint64(6).string("world")
+	})
+	c.requireStatus(statusOK, packetClose, str(h))
+	c.requireStatus(statusFailure, packetClose, str(h))
+
+	respType, r := c.request(packetStat, str("/d/../d/a.txt"))
+	require.Equal(t, packetAttrs, respType)
+	flags := r.uint32()
+	require.NotZero(t, flags&attrSize)
+	require.Equal(t, uint64(11), r.uint64())
+	require.Equal(t, uint32(modeRegular), r.uint32()&modeRegular)
+
+	// Read it back, past the end.
+	h = c.handle(packetOpen, func(p *packetBuilder) {
+		p.string("/d/a.txt").uint32(openRead).uint32(0)
+	})
+	respType, r = c.request(packetRead, func(p *packetBuilder) {
+		p.string(h).uint64(6).uint32(100)
+	})
+	require.Equal(t, packetData, respType)
+	require.Equal(t, "world", r.string())
+	c.requireStatus(statusEOF, packetRead, func(p *packetBuilder) {
+		p.string(h).uint64(11).uint32(100)
+	})
+	c.requireStatus(statusOK, packetClose, str(h))
+
+	// List the directory.
+	h = c.handle(packetOpendir, str("/d"))
+	respType, r = c.request(packetReaddir, str(h))
+	require.Equal(t, packetName, respType)
+	require.Equal(t, uint32(1), r.uint32())
+	require.Equal(t, "a.txt", r.string())
+	c.requireStatus(statusEOF, packetReaddir, str(h))
+	c.requireStatus(statusOK, packetClose, str(h))
+
+	respType, r = c.request(packetRealpath, str("../d/./a.txt"))
+	require.Equal(t, packetName, respType)
+	require.Equal(t, uint32(1), r.uint32())
+	require.Equal(t, "/d/a.txt", r.string())
+
+	// Renames don't overwrite.
+	c.requireStatus(statusOK, packetRename, func(p *packetBuilder) {
+		p.string("/d/a.txt").string("/b.txt")
+	})
+	c.requireStatus(statusOK, packetMkdir, func(p *packetBuilder) {
+		p.string("/e").uint32(0)
+	})
+	c.requireStatus(statusFailure, packetRename, func(p *packetBuilder) {
+		p.string("/b.txt").string("/e")
+	})
+
+	c.requireStatus(statusFailure, packetRmdir, str("/b.txt"))
+	c.requireStatus(statusFailure, packetRemove, str("/d"))
+	c.requireStatus(statusOK, packetRemove, str("/b.txt"))
+	c.requireStatus(statusOK, packetRmdir, str("/d"))
+	c.requireStatus(statusNoSuchFile, packetLstat, str("/d"))
+
+	c.requireStatus(statusOpUnsupported, 200, str("foo@example.com"))
+}
+
+func TestSession(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "libsftp_session")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, shutdown := startTestSession(t, osfs.New(dir))
+	defer shutdown()
+	testSession(t, c)
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "libsftp_server")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientKey, err := ssh.NewSignerFromKey(clientPriv)
+	require.NoError(t, err)
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ssh.NewSignerFromKey(otherPriv)
+	require.NoError(t, err)
+
+	s := newServer(logger.NewTestLogger(t), osfs.New(dir), hostKey,
+		[]ssh.PublicKey{clientKey.PublicKey()})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go s.Serve(l)
+
+	dial := func(key ssh.Signer) (*ssh.Client, error) {
+		return ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+			User:            "alice",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+			HostKeyCallback: ssh.FixedHostKey(s.HostKey()),
+		})
+	}
+
+	_, err = dial(otherKey)
+	require.Error(t, err)
+
+	client, err := dial(clientKey)
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Shells aren't allowed.
+	sshSession, err := client.NewSession()
+	require.NoError(t, err)
+	require.Error(t, sshSession.Shell())
+	sshSession.Close()
+
+	sshSession, err = client.NewSession()
+	require.NoError(t, err)
+	defer sshSession.Close()
+	stdin, err := sshSession.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := sshSession.StdoutPipe()
+	require.NoError(t, err)
+	err = sshSession.RequestSubsystem("sftp")
+	require.NoError(t, err)
+
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{stdout, stdin}
+	_, err = rw.Write(
+		newPacket(packetInit).uint32(sftpProtocolVersion).finish())
+	require.NoError(t, err)
+	respType, _, err := readPacket(rw)
+	require.NoError(t, err)
+	require.Equal(t, packetVersion, respType)
+	testSession(t, &testClient{t: t, rw: rw})
+}
+
+func TestDeriveHostKey(t *testing.T) {
+	ctx := context.Background()
+	signer := kbfscrypto.SigningKeySigner{
+		Key: kbfscrypto.MakeFakeSigningKeyOrBust("device"),
+	}
+	key1, err := DeriveHostKey(ctx, signer)
+	require.NoError(t, err)
+	key2, err := DeriveHostKey(ctx, signer)
+	require.NoError(t, err)
+	require.Equal(t,
+		key1.PublicKey().Marshal(), key2.PublicKey().Marshal())
+
+	otherSigner := kbfscrypto.SigningKeySigner{
+		Key: kbfscrypto.MakeFakeSigningKeyOrBust("other device"),
+	}
+	key3, err := DeriveHostKey(ctx, otherSigner)
+	require.NoError(t, err)
+	require.NotEqual(t,
+		key1.PublicKey().Marshal(), key3.PublicKey().Marshal())
+}
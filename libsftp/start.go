@@ -0,0 +1,78 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsftp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// StartOptions are options for starting up.
+type StartOptions struct {
+	KbfsParams libkbfs.InitParams
+	// ListenAddr is the address to serve SFTP on.
+	ListenAddr string
+	// AuthorizedKeysFile is the path to a file of client public
+	// keys, in OpenSSH authorized_keys format.
+	AuthorizedKeysFile string
+	// TLFs lists the TLFs to serve, each given as "<type>/<name>".
+	TLFs []string
+}
+
+// ReadAuthorizedKeys parses the given OpenSSH authorized_keys file.
+// Key options are ignored.
+func ReadAuthorizedKeys(filename string) ([]ssh.PublicKey, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.PublicKey
+	for len(buf) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(buf)
+		if err != nil {
+			// ParseAuthorizedKey skips blank lines and comments, so
+			// this means there are no keys left.
+			if len(keys) == 0 {
+				return nil, errors.Wrapf(err, "Couldn't parse %s", filename)
+			}
+			break
+		}
+		keys = append(keys, key)
+		buf = rest
+	}
+	return keys, nil
+}
+
+// Start starts KBFS and serves the given TLFs over SFTP until
+// interrupted.
+func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
+	authorizedKeys, err := ReadAuthorizedKeys(options.AuthorizedKeysFile)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
+	return libfs.StartServer(options.KbfsParams, kbCtx, func(
+		config libkbfs.Config, log logger.Logger) (libfs.Mounter, error) {
+		s, err := NewServer(
+			context.Background(), config, options.TLFs, authorizedKeys)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", options.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Serving SFTP on %s with host key %s",
+			listener.Addr(), HostKeyFingerprint(s.HostKey()))
+		return libfs.NewListenerMounter(s, listener), nil
+	})
+}
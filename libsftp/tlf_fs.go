@@ -0,0 +1,323 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libsftp
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// Debug tag ID for an SFTP FS.
+const ctxOpID = "SFTP"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+type tlfToServe struct {
+	tlfType tlf.Type
+	name    string
+}
+
+// virtualDirInfo describes one of the read-only directories above
+// the TLFs, i.e. "/" and "/<type>".
+type virtualDirInfo struct {
+	name string
+}
+
+var _ os.FileInfo = virtualDirInfo{}
+
+func (vdi virtualDirInfo) Name() string       { return vdi.name }
+func (vdi virtualDirInfo) Size() int64        { return 0 }
+func (vdi virtualDirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (vdi virtualDirInfo) ModTime() time.Time { return time.Time{} }
+func (vdi virtualDirInfo) IsDir() bool        { return true }
+func (vdi virtualDirInfo) Sys() interface{}   { return nil }
+
+type virtualDirInfosByName []os.FileInfo
+
+func (v virtualDirInfosByName) Len() int {
+	return len(v)
+}
+
+func (v virtualDirInfosByName) Less(i, j int) bool {
+	return v[i].Name() < v[j].Name()
+}
+
+func (v virtualDirInfosByName) Swap(i, j int) {
+	v[i], v[j] = v[j], v[i]
+}
+
+// tlfFS presents a fixed set of TLFs as a single filesystem, with
+// each TLF at "/<type>/<name>".  Everything else is hidden, and the
+// directories above the TLFs are read-only.
+type tlfFS struct {
+	config libkbfs.Config
+	log    logger.Logger
+	tlfs   map[string]tlfToServe
+	fs     *libfs.TlfFSCache
+}
+
+var _ sftpFS = (*tlfFS)(nil)
+var _ billy.Change = (*tlfFS)(nil)
+
+func newTlfFS(config libkbfs.Config, tlfPaths []string) (*tlfFS, error) {
+	if len(tlfPaths) == 0 {
+		return nil, errors.New("No TLFs to serve")
+	}
+	tlfs := make(map[string]tlfToServe, len(tlfPaths))
+	for _, p := range tlfPaths {
+		tlfType, name, err := libfs.ParseTlfPath(p)
+		if err != nil {
+			return nil, err
+		}
+		tlfs[libfs.TlfTypePathString(tlfType)+"/"+name] =
+			tlfToServe{tlfType, name}
+	}
+	return &tlfFS{
+		config: config,
+		log:    config.MakeLogger("SFTP"),
+		tlfs:   tlfs,
+		fs:     libfs.NewTlfFSCache(config),
+	}, nil
+}
+
+// getFS returns the (possibly cached) FS for the TLF under `key`.
+func (tf *tlfFS) getFS(key string) (*libfs.FS, error) {
+	ctx := libkbfs.CtxWithRandomIDReplayable(
+		context.Background(), ctxIDKey, ctxOpID, tf.log)
+	toServe := tf.tlfs[key]
+	return tf.fs.Get(ctx, toServe.tlfType, toServe.name)
+}
+
+// virtualChildren returns the entries of the virtual directory at
+// `parts` (which has at most one element), or nil if there's no such
+// directory.
+func (tf *tlfFS) virtualChildren(parts []string) []os.FileInfo {
+	seen := make(map[string]bool)
+	var children []os.FileInfo
+	for key := range tf.tlfs {
+		keyParts := strings.Split(key, "/")
+		var name string
+		if len(parts) == 0 {
+			name = keyParts[0]
+		} else if keyParts[0] == parts[0] {
+			name = keyParts[1]
+		} else {
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			children = append(children, virtualDirInfo{name})
+		}
+	}
+	sort.Sort(virtualDirInfosByName(children))
+	return children
+}
+
+// resolve returns the FS and path within it for `p`.  If `p` is a
+// virtual directory, it returns a nil FS and the directory's
+// entries instead.
+func (tf *tlfFS) resolve(p string) (
+	fs *libfs.FS, rel string, children []os.FileInfo, err error) {
+	p = strings.Trim(cleanPath(p), "/")
+	var parts []string
+	if p != "" {
+		parts = strings.SplitN(p, "/", 3)
+	}
+	if len(parts) < 2 {
+		children := tf.virtualChildren(parts)
+		if children == nil {
+			return nil, "", nil, os.ErrNotExist
+		}
+		return nil, "", children, nil
+	}
+
+	key := parts[0] + "/" + parts[1]
+	if _, ok := tf.tlfs[key]; !ok {
+		return nil, "", nil, os.ErrNotExist
+	}
+	fs, err = tf.getFS(key)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if len(parts) == 3 {
+		rel = parts[2]
+	}
+	return fs, rel, nil, nil
+}
+
+// resolveWritable is like resolve, but fails for virtual
+// directories and TLF roots, which can't be changed.
+func (tf *tlfFS) resolveWritable(p string) (*libfs.FS, string, error) {
+	fs, rel, _, err := tf.resolve(p)
+	if err != nil {
+		return nil, "", err
+	}
+	if fs == nil || rel == "" {
+		return nil, "", os.ErrPermission
+	}
+	return fs, rel, nil
+}
+
+// Create implements the billy.Basic interface for tlfFS.
+func (tf *tlfFS) Create(filename string) (billy.File, error) {
+	return tf.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Open implements the billy.Basic interface for tlfFS.
+func (tf *tlfFS) Open(filename string) (billy.File, error) {
+	return tf.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile implements the billy.Basic interface for tlfFS.
+func (tf *tlfFS) OpenFile(filename string, flag int, perm os.FileMode) (
+	billy.File, error) {
+	fs, rel, err := tf.resolveWritable(filename)
+	if err != nil {
+		return nil, err
+	}
+	return fs.OpenFile(rel, flag, perm)
+}
+
+// Stat implements the billy.Basic interface for tlfFS.
+func (tf *tlfFS) Stat(filename string) (os.FileInfo, error) {
+	fs, rel, _, err := tf.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return virtualDirInfo{path.Base(cleanPath(filename))}, nil
+	}
+	return fs.Stat(rel)
+}
+
+// Rename implements the billy.Basic interface for tlfFS.
+func (tf *tlfFS) Rename(oldpath, newpath string) error {
+	oldFS, oldRel, err := tf.resolveWritable(oldpath)
+	if err != nil {
+		return err
+	}
+	newFS, newRel, err := tf.resolveWritable(newpath)
+	if err != nil {
+		return err
+	}
+	if oldFS != newFS {
+		return errors.New("Can't rename across TLFs")
+	}
+	return oldFS.Rename(oldRel, newRel)
+}
+
+// Remove implements the billy.Basic interface for tlfFS.
+func (tf *tlfFS) Remove(filename string) error {
+	fs, rel, err := tf.resolveWritable(filename)
+	if err != nil {
+		return err
+	}
+	return fs.Remove(rel)
+}
+
+// Join implements the billy.Basic interface for tlfFS.
+func (tf *tlfFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// ReadDir implements the billy.Dir interface for tlfFS.
+func (tf *tlfFS) ReadDir(p string) ([]os.FileInfo, error) {
+	fs, rel, children, err := tf.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return children, nil
+	}
+	return fs.ReadDir(rel)
+}
+
+// MkdirAll implements the billy.Dir interface for tlfFS.
+func (tf *tlfFS) MkdirAll(filename string, perm os.FileMode) error {
+	fs, rel, err := tf.resolveWritable(filename)
+	if err != nil {
+		return err
+	}
+	return fs.MkdirAll(rel, perm)
+}
+
+// Lstat implements the billy.Symlink interface for tlfFS.
+func (tf *tlfFS) Lstat(filename string) (os.FileInfo, error) {
+	fs, rel, _, err := tf.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return virtualDirInfo{path.Base(cleanPath(filename))}, nil
+	}
+	return fs.Lstat(rel)
+}
+
+// Symlink implements the billy.Symlink interface for tlfFS.
+func (tf *tlfFS) Symlink(target, link string) error {
+	fs, rel, err := tf.resolveWritable(link)
+	if err != nil {
+		return err
+	}
+	return fs.Symlink(target, rel)
+}
+
+// Readlink implements the billy.Symlink interface for tlfFS.
+func (tf *tlfFS) Readlink(link string) (string, error) {
+	fs, rel, err := tf.resolveWritable(link)
+	if err != nil {
+		return "", err
+	}
+	return fs.Readlink(rel)
+}
+
+// Chmod implements the billy.Change interface for tlfFS.
+func (tf *tlfFS) Chmod(name string, mode os.FileMode) error {
+	fs, rel, err := tf.resolveWritable(name)
+	if err != nil {
+		return err
+	}
+	return fs.Chmod(rel, mode)
+}
+
+// Lchown implements the billy.Change interface for tlfFS.
+func (tf *tlfFS) Lchown(name string, uid, gid int) error {
+	return os.ErrPermission
+}
+
+// Chown implements the billy.Change interface for tlfFS.
+func (tf *tlfFS) Chown(name string, uid, gid int) error {
+	return os.ErrPermission
+}
+
+// Chtimes implements the billy.Change interface for tlfFS.
+func (tf *tlfFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	fs, rel, err := tf.resolveWritable(name)
+	if err != nil {
+		return err
+	}
+	return fs.Chtimes(rel, atime, mtime)
+}
+
+// SyncAll syncs the buffered writes of all the TLFs that have been
+// accessed.
+func (tf *tlfFS) SyncAll() error {
+	return tf.fs.SyncAll()
+}
@@ -0,0 +1,25 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libvolume
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// osBinder uses Linux bind mounts.  The provider needs CAP_SYS_ADMIN
+// for them, which a Docker plugin or CSI node container has.
+type osBinder struct{}
+
+func (osBinder) bind(source, target string) error {
+	err := syscall.Mount(source, target, "", syscall.MS_BIND, "")
+	return errors.Wrapf(err, "bind mounting %s at %s", source, target)
+}
+
+func (osBinder) unbind(target string) error {
+	err := syscall.Unmount(target, 0)
+	return errors.Wrapf(err, "unmounting %s", target)
+}
@@ -0,0 +1,22 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package libvolume
+
+import "github.com/pkg/errors"
+
+// osBinder is only implemented on Linux, where container runtimes
+// use volume providers.
+type osBinder struct{}
+
+func (osBinder) bind(source, target string) error {
+	return errors.New("Volume mounts are only supported on Linux")
+}
+
+func (osBinder) unbind(target string) error {
+	return errors.New("Volume mounts are only supported on Linux")
+}
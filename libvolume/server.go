@@ -0,0 +1,249 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libvolume
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// maxRequestSize bounds the JSON bodies clients can send.
+const maxRequestSize = 64 * 1024
+
+// statusSource reports the status of KBFS, like libkbfs.KBFSOps.
+type statusSource interface {
+	Status(ctx context.Context) (
+		libkbfs.KBFSStatus, <-chan libkbfs.StatusUpdate, error)
+}
+
+// CreateRequest is the body of a request to create a volume.
+type CreateRequest struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// MountRequest is the body of a request to mount a volume.
+type MountRequest struct {
+	// ID identifies the mount to the caller, e.g. a container ID
+	// or a CSI volume and target path.
+	ID     string `json:"id"`
+	Target string `json:"target"`
+}
+
+// Health is the body of a response to a health check.
+type Health struct {
+	Healthy         bool              `json:"healthy"`
+	Connected       bool              `json:"connected"`
+	CurrentUser     string            `json:"currentUser,omitempty"`
+	FailingServices map[string]string `json:"failingServices,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Volumes         int               `json:"volumes"`
+}
+
+// Server serves the control API of a volume provider, so that a
+// Docker volume plugin or a Kubernetes CSI driver can manage volumes
+// with it:
+//
+//	GET    /health                      -> Health, 503 if unhealthy
+//	GET    /volumes                     -> []Volume
+//	POST   /volumes                     <- CreateRequest
+//	GET    /volumes/<name>              -> Volume
+//	DELETE /volumes/<name>
+//	POST   /volumes/<name>/mounts       <- MountRequest
+//	DELETE /volumes/<name>/mounts/<id>
+//
+// It has no authentication of its own, and should only be served on
+// a unix socket that only the container runtime can access.
+type Server struct {
+	log      logger.Logger
+	provider *Provider
+	status   statusSource
+}
+
+var _ http.Handler = (*Server)(nil)
+
+// NewServer returns a new Server for the given provider, which
+// reports on the health of the KBFS instance behind `config`.
+func NewServer(config libkbfs.Config, provider *Provider) *Server {
+	return newServer(config.MakeLogger("VOL"), provider, config.KBFSOps())
+}
+
+func newServer(log logger.Logger, provider *Provider,
+	status statusSource) *Server {
+	return &Server{log: log, provider: provider, status: status}
+}
+
+// methodNotAllowedError is returned for methods a path doesn't
+// support.
+type methodNotAllowedError struct {
+	method string
+}
+
+func (e methodNotAllowedError) Error() string {
+	return e.method + " isn't supported here"
+}
+
+// errToStatus returns the HTTP status for provider errors.
+func errToStatus(err error) int {
+	switch errors.Cause(err).(type) {
+	case NoSuchVolumeError:
+		return http.StatusNotFound
+	case VolumeConflictError:
+		return http.StatusConflict
+	case InvalidVolumeArgError, *json.SyntaxError,
+		*json.UnmarshalTypeError:
+		return http.StatusBadRequest
+	case methodNotAllowedError:
+		return http.StatusMethodNotAllowed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	return json.NewDecoder(
+		http.MaxBytesReader(nil, r.Body, maxRequestSize)).Decode(v)
+}
+
+func (s *Server) health(ctx context.Context) Health {
+	h := Health{Volumes: len(s.provider.List())}
+	status, _, err := s.status.Status(ctx)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	h.Connected = status.IsConnected
+	h.CurrentUser = status.CurrentUser
+	for name, err := range status.FailingServices {
+		if h.FailingServices == nil {
+			h.FailingServices = make(map[string]string)
+		}
+		h.FailingServices[name] = err.Error()
+	}
+	if err := s.provider.checkRoot(); err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	h.Healthy = h.Connected && len(h.FailingServices) == 0
+	return h
+}
+
+func (s *Server) serveVolume(w http.ResponseWriter, r *http.Request,
+	name string, rest []string) error {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		v, err := s.provider.Get(name)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, v)
+	case len(rest) == 0 && r.Method == http.MethodDelete:
+		err := s.provider.Remove(name)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case len(rest) == 1 && rest[0] == "mounts" &&
+		r.Method == http.MethodPost:
+		var req MountRequest
+		err := readJSON(r, &req)
+		if err != nil {
+			return err
+		}
+		err = s.provider.Mount(name, req.ID, req.Target)
+		if err != nil {
+			return err
+		}
+		v, err := s.provider.Get(name)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, v)
+	case len(rest) == 2 && rest[0] == "mounts" &&
+		r.Method == http.MethodDelete:
+		err := s.provider.Unmount(name, rest[1])
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case len(rest) > 2 || (len(rest) > 0 && rest[0] != "mounts"):
+		http.NotFound(w, r)
+		return nil
+	case len(rest) == 0:
+		w.Header().Set("Allow", "GET, DELETE")
+	case len(rest) == 1:
+		w.Header().Set("Allow", "POST")
+	default:
+		w.Header().Set("Allow", "DELETE")
+	}
+	return methodNotAllowedError{r.Method}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "health" &&
+		r.Method == http.MethodGet:
+		h := s.health(r.Context())
+		status := http.StatusOK
+		if !h.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		return writeJSON(w, status, h)
+	case len(parts) == 1 && parts[0] == "volumes" &&
+		r.Method == http.MethodGet:
+		return writeJSON(w, http.StatusOK, s.provider.List())
+	case len(parts) == 1 && parts[0] == "volumes" &&
+		r.Method == http.MethodPost:
+		var req CreateRequest
+		err := readJSON(r, &req)
+		if err != nil {
+			return err
+		}
+		err = s.provider.Create(req.Name, req.Path)
+		if err != nil {
+			return err
+		}
+		v, err := s.provider.Get(req.Name)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusCreated, v)
+	case len(parts) > 1 && parts[0] == "volumes":
+		return s.serveVolume(w, r, parts[1], parts[2:])
+	case len(parts) == 1 && parts[0] == "health":
+		w.Header().Set("Allow", "GET")
+		return methodNotAllowedError{r.Method}
+	case len(parts) == 1 && parts[0] == "volumes":
+		w.Header().Set("Allow", "GET, POST")
+		return methodNotAllowedError{r.Method}
+	default:
+		http.NotFound(w, r)
+		return nil
+	}
+}
+
+// ServeHTTP implements the http.Handler interface for Server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.log.Debug("%s %s", r.Method, r.URL)
+	if err := s.serve(w, r); err != nil {
+		s.log.Debug("%s %s failed: %+v", r.Method, r.URL, err)
+		http.Error(w, err.Error(), errToStatus(err))
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libvolume
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"bazil.org/fuse"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libfuse"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// StartOptions are options for starting up.
+type StartOptions struct {
+	KbfsParams libkbfs.InitParams
+	// MountPoint is where the whole of KBFS is mounted, for volumes
+	// to be bound from.  It should be private to the provider.
+	MountPoint string
+	// SocketPath is the unix socket to serve the control API on.
+	SocketPath string
+	// StatePath is the file that volumes and their mounts are kept
+	// in, so that they're restored on restart.
+	StatePath string
+}
+
+// volumeMounter lets a MountInterrupter mount KBFS, restore the
+// volume mounts, and serve the control API as if they were a single
+// mount.
+type volumeMounter struct {
+	options StartOptions
+	config  libkbfs.Config
+	log     logger.Logger
+	cancel  context.CancelFunc
+
+	conn     *fuse.Conn
+	provider *Provider
+	server   *http.Server
+}
+
+var _ libfs.Mounter = (*volumeMounter)(nil)
+
+func (m *volumeMounter) Mount() (err error) {
+	defer func() {
+		if err != nil {
+			m.Unmount()
+		}
+	}()
+
+	err = ioutil.MkdirAll(m.options.MountPoint, 0700)
+	if err != nil {
+		return err
+	}
+	m.conn, err = fuse.Mount(m.options.MountPoint, fuse.FSName("kbfs"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fs := libfuse.NewFS(m.config, m.conn, m.options.KbfsParams.Debug,
+		libfuse.PlatformParams{})
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	ctx = context.WithValue(ctx, libfs.CtxAppIDKey, fs)
+	go func() {
+		if err := fs.Serve(ctx); err != nil {
+			m.log.Warning("Serving the filesystem failed: %+v", err)
+		}
+	}()
+	<-m.conn.Ready
+	if m.conn.MountError != nil {
+		return errors.WithStack(m.conn.MountError)
+	}
+
+	m.provider, err = NewProvider(
+		m.log, m.options.MountPoint, m.options.StatePath)
+	if err != nil {
+		return err
+	}
+	err = m.provider.Remount()
+	if err != nil {
+		return err
+	}
+
+	// Clear out the socket of a previous run.
+	err = ioutil.Remove(m.options.SocketPath)
+	if err != nil && !ioutil.IsNotExist(err) {
+		return err
+	}
+	err = ioutil.MkdirAll(filepath.Dir(m.options.SocketPath), 0700)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", m.options.SocketPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = os.Chmod(m.options.SocketPath, 0600)
+	if err != nil {
+		listener.Close()
+		return errors.WithStack(err)
+	}
+	m.server = &http.Server{Handler: NewServer(m.config, m.provider)}
+	go m.server.Serve(listener)
+	return nil
+}
+
+func (m *volumeMounter) Unmount() error {
+	var firstErr error
+	if m.server != nil {
+		err := m.server.Close()
+		if err != nil && err != http.ErrServerClosed {
+			firstErr = err
+		}
+	}
+	if m.provider != nil {
+		// The mounts stay in the state file, so they're restored
+		// the next time the provider starts.
+		err := m.provider.UnmountAll()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if m.conn != nil {
+		err := fuse.Unmount(m.options.MountPoint)
+		if err != nil && firstErr == nil {
+			firstErr = errors.WithStack(err)
+		}
+		m.conn.Close()
+		m.conn = nil
+	}
+	if m.cancel != nil {
+		m.cancel()
+	}
+	return firstErr
+}
+
+// Start starts KBFS, mounts it privately, and serves the
+// volume-provider control API until interrupted.
+func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
+	if options.MountPoint == "" || options.SocketPath == "" {
+		return libfs.InitError("No mount point or socket given")
+	}
+
+	log, err := libkbfs.InitLog(options.KbfsParams, kbCtx)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
+	log.Debug("Initializing")
+	mi := libfs.NewMountInterrupter(log)
+	ctx := context.Background()
+	config, err := libkbfs.Init(
+		ctx, kbCtx, options.KbfsParams, nil, mi.Done, log)
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+	defer libkbfs.Shutdown()
+
+	err = mi.MountAndSetUnmount(&volumeMounter{
+		options: options,
+		config:  config,
+		log:     log,
+	})
+	if err != nil {
+		return libfs.MountError(err.Error())
+	}
+	log.Info("Serving volumes from %s on %s",
+		options.MountPoint, options.SocketPath)
+
+	mi.Wait()
+	return nil
+}
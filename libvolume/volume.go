@@ -0,0 +1,357 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libvolume
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
+)
+
+// Volume is a named subpath of a TLF that can be mounted at any
+// number of target directories, e.g. one per container using it.
+type Volume struct {
+	Name string `json:"name"`
+	// Path is the KBFS path of the root of the volume, like
+	// /keybase/team/acme/data.
+	Path string `json:"path"`
+	// Mounts maps the ID of each mount of the volume to its target
+	// directory.
+	Mounts map[string]string `json:"mounts,omitempty"`
+}
+
+// binder exposes a directory at another place in the local
+// filesystem.
+type binder interface {
+	bind(source, target string) error
+	unbind(target string) error
+}
+
+// NoSuchVolumeError is returned for volumes or mounts that don't
+// exist.
+type NoSuchVolumeError struct {
+	Name string
+	// MountID is set if the volume exists, but not the mount.
+	MountID string
+}
+
+// Error implements the error interface for NoSuchVolumeError.
+func (e NoSuchVolumeError) Error() string {
+	if e.MountID != "" {
+		return fmt.Sprintf("Volume %s has no mount %s", e.Name, e.MountID)
+	}
+	return fmt.Sprintf("No such volume %s", e.Name)
+}
+
+// VolumeConflictError is returned when a request conflicts with the
+// current state of a volume, e.g. removing a volume that's mounted.
+type VolumeConflictError struct {
+	Msg string
+}
+
+// Error implements the error interface for VolumeConflictError.
+func (e VolumeConflictError) Error() string {
+	return e.Msg
+}
+
+// InvalidVolumeArgError is returned for malformed volume names, paths
+// and targets.
+type InvalidVolumeArgError struct {
+	Msg string
+}
+
+// Error implements the error interface for InvalidVolumeArgError.
+func (e InvalidVolumeArgError) Error() string {
+	return e.Msg
+}
+
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// checkVolumePath makes sure that `p` is a clean path inside a TLF.
+func checkVolumePath(p string) error {
+	if path.Clean(p) != p {
+		return InvalidVolumeArgError{fmt.Sprintf("%s is not a clean path", p)}
+	}
+	parts := strings.Split(p, "/")
+	if len(parts) < 4 || parts[0] != "" || parts[1] != "keybase" {
+		return InvalidVolumeArgError{
+			fmt.Sprintf("%s is not a path inside a folder", p)}
+	}
+	switch parts[2] {
+	case "private", "public", "team":
+	default:
+		return InvalidVolumeArgError{
+			fmt.Sprintf("Unknown folder type %q in %s", parts[2], p)}
+	}
+	return nil
+}
+
+// Provider keeps track of volumes, and mounts them by binding their
+// paths under a local KBFS mount to the requested targets.  Volume
+// definitions and mounts are persisted to a state file, so that they
+// survive restarts of the provider.
+type Provider struct {
+	log       logger.Logger
+	kbfsRoot  string
+	statePath string
+	binder    binder
+
+	lock    sync.Mutex
+	volumes map[string]*Volume
+}
+
+func newProvider(log logger.Logger, kbfsRoot, statePath string,
+	b binder) (*Provider, error) {
+	p := &Provider{
+		log:       log,
+		kbfsRoot:  kbfsRoot,
+		statePath: statePath,
+		binder:    b,
+		volumes:   make(map[string]*Volume),
+	}
+	if statePath == "" {
+		return p, nil
+	}
+	var volumes []*Volume
+	err := ioutil.DeserializeFromJSONFile(statePath, &volumes)
+	if ioutil.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, v := range volumes {
+		p.volumes[v.Name] = v
+	}
+	return p, nil
+}
+
+// NewProvider returns a new Provider for the KBFS mount at
+// `kbfsRoot`, which keeps its state in `statePath` unless it's empty.
+func NewProvider(
+	log logger.Logger, kbfsRoot, statePath string) (*Provider, error) {
+	return newProvider(log, kbfsRoot, statePath, osBinder{})
+}
+
+func (p *Provider) saveLocked() error {
+	if p.statePath == "" {
+		return nil
+	}
+	return ioutil.SerializeToJSONFile(p.listLocked(), p.statePath)
+}
+
+func (p *Provider) sourceDir(v *Volume) string {
+	return filepath.Join(
+		p.kbfsRoot, filepath.FromSlash(strings.TrimPrefix(v.Path, "/keybase")))
+}
+
+func copyVolume(v *Volume) Volume {
+	c := *v
+	c.Mounts = make(map[string]string, len(v.Mounts))
+	for id, target := range v.Mounts {
+		c.Mounts[id] = target
+	}
+	return c
+}
+
+func (p *Provider) listLocked() []Volume {
+	volumes := make([]Volume, 0, len(p.volumes))
+	for _, v := range p.volumes {
+		volumes = append(volumes, copyVolume(v))
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	return volumes
+}
+
+// Create adds a volume called `name` for the KBFS path `p`,
+// creating the directory if it doesn't exist yet.  Creating an
+// existing volume with the same path is a no-op.
+func (p *Provider) Create(name, kbfsPath string) error {
+	if !volumeNameRegexp.MatchString(name) {
+		return InvalidVolumeArgError{
+			fmt.Sprintf("Invalid volume name %q", name)}
+	}
+	err := checkVolumePath(kbfsPath)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if v, ok := p.volumes[name]; ok {
+		if v.Path != kbfsPath {
+			return VolumeConflictError{fmt.Sprintf(
+				"Volume %s already exists for %s", name, v.Path)}
+		}
+		return nil
+	}
+	v := &Volume{Name: name, Path: kbfsPath}
+	err = ioutil.MkdirAll(p.sourceDir(v), 0700)
+	if err != nil {
+		return err
+	}
+	p.log.Debug("Created volume %s for %s", name, kbfsPath)
+	p.volumes[name] = v
+	return p.saveLocked()
+}
+
+// Remove forgets about the volume called `name`.  The volume must
+// not be mounted anywhere, and its data is left alone.
+func (p *Provider) Remove(name string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, ok := p.volumes[name]
+	if !ok {
+		return NoSuchVolumeError{Name: name}
+	}
+	if len(v.Mounts) > 0 {
+		return VolumeConflictError{fmt.Sprintf(
+			"Volume %s is still mounted %d times", name, len(v.Mounts))}
+	}
+	delete(p.volumes, name)
+	return p.saveLocked()
+}
+
+// Get returns the volume called `name`.
+func (p *Provider) Get(name string) (Volume, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, ok := p.volumes[name]
+	if !ok {
+		return Volume{}, NoSuchVolumeError{Name: name}
+	}
+	return copyVolume(v), nil
+}
+
+// List returns all volumes, sorted by name.
+func (p *Provider) List() []Volume {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.listLocked()
+}
+
+// Mount makes the volume called `name` available at `target`, for
+// the mount `id`.  Mounting the same ID at the same target again is
+// a no-op.
+func (p *Provider) Mount(name, id, target string) error {
+	if id == "" {
+		return InvalidVolumeArgError{"No mount ID given"}
+	}
+	if !filepath.IsAbs(target) {
+		return InvalidVolumeArgError{
+			fmt.Sprintf("%s is not an absolute path", target)}
+	}
+	target = filepath.Clean(target)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, ok := p.volumes[name]
+	if !ok {
+		return NoSuchVolumeError{Name: name}
+	}
+	if t, ok := v.Mounts[id]; ok {
+		if t != target {
+			return VolumeConflictError{fmt.Sprintf(
+				"Mount %s of volume %s is already at %s", id, name, t)}
+		}
+		return nil
+	}
+	err := ioutil.MkdirAll(target, 0755)
+	if err != nil {
+		return err
+	}
+	err = p.binder.bind(p.sourceDir(v), target)
+	if err != nil {
+		return err
+	}
+	p.log.Debug("Mounted volume %s at %s (%s)", name, target, id)
+	if v.Mounts == nil {
+		v.Mounts = make(map[string]string)
+	}
+	v.Mounts[id] = target
+	return p.saveLocked()
+}
+
+// Unmount removes the mount `id` of the volume called `name`.
+func (p *Provider) Unmount(name, id string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, ok := p.volumes[name]
+	if !ok {
+		return NoSuchVolumeError{Name: name}
+	}
+	target, ok := v.Mounts[id]
+	if !ok {
+		return NoSuchVolumeError{Name: name, MountID: id}
+	}
+	err := p.binder.unbind(target)
+	if err != nil {
+		return err
+	}
+	p.log.Debug("Unmounted volume %s from %s (%s)", name, target, id)
+	delete(v.Mounts, id)
+	return p.saveLocked()
+}
+
+// Remount binds all persisted mounts again, e.g. after the provider
+// is restarted.  Mounts that can't be restored are dropped.
+func (p *Provider) Remount() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, v := range p.volumes {
+		for id, target := range v.Mounts {
+			// Clear out any stale bind left behind by a previous run.
+			_ = p.binder.unbind(target)
+			err := p.binder.bind(p.sourceDir(v), target)
+			if err != nil {
+				p.log.Warning("Couldn't remount volume %s at %s: %+v",
+					v.Name, target, err)
+				delete(v.Mounts, id)
+			}
+		}
+	}
+	return p.saveLocked()
+}
+
+// UnmountAll unbinds every mount, but keeps them in the state file so
+// that Remount can restore them.
+func (p *Provider) UnmountAll() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var errs []string
+	for _, v := range p.volumes {
+		for _, target := range v.Mounts {
+			err := p.binder.unbind(target)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", target, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Errorf("Couldn't unmount: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// checkRoot makes sure the KBFS mount is still usable.
+func (p *Provider) checkRoot() error {
+	fi, err := ioutil.Stat(filepath.Join(p.kbfsRoot, "private"))
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("%s is not a KBFS mount", p.kbfsRoot)
+	}
+	return nil
+}
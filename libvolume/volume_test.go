@@ -0,0 +1,224 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libvolume
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+// testBinder records binds instead of mounting anything.
+type testBinder struct {
+	binds   map[string]string
+	failFor string
+}
+
+func (b *testBinder) bind(source, target string) error {
+	if source == b.failFor {
+		return errors.New("bind failed")
+	}
+	b.binds[target] = source
+	return nil
+}
+
+func (b *testBinder) unbind(target string) error {
+	if _, ok := b.binds[target]; !ok {
+		return errors.New("not mounted")
+	}
+	delete(b.binds, target)
+	return nil
+}
+
+type testStatus struct {
+	status libkbfs.KBFSStatus
+}
+
+func (s testStatus) Status(ctx context.Context) (
+	libkbfs.KBFSStatus, <-chan libkbfs.StatusUpdate, error) {
+	return s.status, nil, nil
+}
+
+func makeTestProvider(t *testing.T) (
+	p *Provider, b *testBinder, tempdir string) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "libvolume")
+	require.NoError(t, err)
+	root := filepath.Join(tempdir, "kbfs")
+	err = ioutil.MkdirAll(filepath.Join(root, "private"), 0700)
+	require.NoError(t, err)
+	b = &testBinder{binds: make(map[string]string)}
+	p, err = newProvider(logger.NewTestLogger(t), root,
+		filepath.Join(tempdir, "state.json"), b)
+	require.NoError(t, err)
+	return p, b, tempdir
+}
+
+func TestProvider(t *testing.T) {
+	p, b, tempdir := makeTestProvider(t)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+
+	t.Log("Bad names and paths are rejected")
+	for _, args := range [][2]string{
+		{"", "/keybase/team/acme/data"},
+		{"../x", "/keybase/team/acme/data"},
+		{"data", "/keybase/team"},
+		{"data", "/keybase/team/acme/../x"},
+		{"data", "/keybase/other/acme"},
+		{"data", "/tmp/acme"},
+	} {
+		err := p.Create(args[0], args[1])
+		require.IsType(t, InvalidVolumeArgError{}, err, "%v", args)
+	}
+
+	err := p.Create("data", "/keybase/team/acme/data")
+	require.NoError(t, err)
+	source := filepath.Join(tempdir, "kbfs", "team", "acme", "data")
+	fi, err := os.Stat(source)
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	err = p.Create("data", "/keybase/team/acme/data")
+	require.NoError(t, err)
+	err = p.Create("data", "/keybase/team/acme/other")
+	require.IsType(t, VolumeConflictError{}, err)
+
+	target := filepath.Join(tempdir, "containers", "c1")
+	err = p.Mount("data", "c1", target)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{target: source}, b.binds)
+	err = p.Mount("data", "c1", target)
+	require.NoError(t, err)
+	err = p.Mount("data", "c1", target+"x")
+	require.IsType(t, VolumeConflictError{}, err)
+	err = p.Mount("nope", "c1", target)
+	require.IsType(t, NoSuchVolumeError{}, err)
+	err = p.Remove("data")
+	require.IsType(t, VolumeConflictError{}, err)
+
+	t.Log("Volumes and mounts survive restarts")
+	err = p.UnmountAll()
+	require.NoError(t, err)
+	require.Len(t, b.binds, 0)
+	p, err = newProvider(
+		p.log, p.kbfsRoot, p.statePath, b)
+	require.NoError(t, err)
+	err = p.Remount()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{target: source}, b.binds)
+	v, err := p.Get("data")
+	require.NoError(t, err)
+	require.Equal(t, Volume{
+		Name:   "data",
+		Path:   "/keybase/team/acme/data",
+		Mounts: map[string]string{"c1": target},
+	}, v)
+
+	err = p.Unmount("data", "c2")
+	require.IsType(t, NoSuchVolumeError{}, err)
+	err = p.Unmount("data", "c1")
+	require.NoError(t, err)
+	require.Len(t, b.binds, 0)
+	err = p.Remove("data")
+	require.NoError(t, err)
+	require.Len(t, p.List(), 0)
+
+	t.Log("Mounts that can't be restored are dropped")
+	err = p.Create("data", "/keybase/team/acme/data")
+	require.NoError(t, err)
+	err = p.Mount("data", "c1", target)
+	require.NoError(t, err)
+	b.failFor = source
+	err = p.Remount()
+	require.NoError(t, err)
+	v, err = p.Get("data")
+	require.NoError(t, err)
+	require.Len(t, v.Mounts, 0)
+}
+
+func TestServer(t *testing.T) {
+	p, b, tempdir := makeTestProvider(t)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+	status := &testStatus{libkbfs.KBFSStatus{
+		CurrentUser: "alice", IsConnected: true}}
+	s := httptest.NewServer(newServer(p.log, p, status))
+	defer s.Close()
+
+	do := func(method, path string, body interface{}, out interface{}) int {
+		var buf bytes.Buffer
+		if body != nil {
+			err := json.NewEncoder(&buf).Encode(body)
+			require.NoError(t, err)
+		}
+		req, err := http.NewRequest(method, s.URL+path, &buf)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if out != nil && resp.StatusCode < 300 {
+			err = json.NewDecoder(resp.Body).Decode(out)
+			require.NoError(t, err)
+		}
+		return resp.StatusCode
+	}
+
+	var h Health
+	require.Equal(t, http.StatusOK, do("GET", "/health", nil, &h))
+	require.Equal(t, Health{
+		Healthy: true, Connected: true, CurrentUser: "alice"}, h)
+
+	var v Volume
+	require.Equal(t, http.StatusCreated, do("POST", "/volumes",
+		CreateRequest{"data", "/keybase/private/alice/data"}, &v))
+	require.Equal(t, "data", v.Name)
+	require.Equal(t, http.StatusBadRequest, do("POST", "/volumes",
+		CreateRequest{"data", "data"}, nil))
+	require.Equal(t, http.StatusBadRequest, do("POST", "/volumes",
+		"not a request", nil))
+
+	target := filepath.Join(tempdir, "target")
+	require.Equal(t, http.StatusOK, do("POST", "/volumes/data/mounts",
+		MountRequest{"c1", target}, &v))
+	require.Equal(t, map[string]string{"c1": target}, v.Mounts)
+	require.Len(t, b.binds, 1)
+	require.Equal(t, http.StatusConflict,
+		do("DELETE", "/volumes/data", nil, nil))
+
+	var volumes []Volume
+	require.Equal(t, http.StatusOK, do("GET", "/volumes", nil, &volumes))
+	require.Equal(t, []Volume{v}, volumes)
+
+	require.Equal(t, http.StatusNotFound,
+		do("DELETE", "/volumes/data/mounts/c2", nil, nil))
+	require.Equal(t, http.StatusNoContent,
+		do("DELETE", "/volumes/data/mounts/c1", nil, nil))
+	require.Equal(t, http.StatusNoContent,
+		do("DELETE", "/volumes/data", nil, nil))
+	require.Equal(t, http.StatusNotFound,
+		do("GET", "/volumes/data", nil, nil))
+	require.Equal(t, http.StatusMethodNotAllowed,
+		do("PUT", "/volumes", nil, nil))
+	require.Equal(t, http.StatusNotFound, do("GET", "/other", nil, nil))
+
+	t.Log("Health checks fail when KBFS is disconnected")
+	status.status.IsConnected = false
+	require.Equal(t, http.StatusServiceUnavailable,
+		do("GET", "/health", nil, nil))
+}
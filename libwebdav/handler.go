@@ -0,0 +1,492 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libwebdav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// allowedMethods lists the methods supported by Handler.  This is
+// WebDAV class 1 only: there's no LOCK or UNLOCK, so clients that
+// insist on locking (e.g., the macOS Finder) will mount read-only.
+const allowedMethods = "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, " +
+	"COPY, MOVE, PROPFIND"
+
+// Handler serves a billy.Filesystem over WebDAV.
+type Handler struct {
+	fs billy.Filesystem
+	// prefix is the URL path under which fs is served, without a
+	// trailing slash.
+	prefix string
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler returns a new Handler serving `fs` under the URL path
+// `prefix`.
+func NewHandler(fs billy.Filesystem, prefix string) *Handler {
+	return &Handler{
+		fs:     fs,
+		prefix: strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+// fsPath converts a URL path to a path within h.fs, or returns false
+// if it's not under h.prefix.  The root of h.fs is ".".
+func (h *Handler) fsPath(urlPath string) (string, bool) {
+	if !strings.HasPrefix(urlPath, h.prefix) {
+		return "", false
+	}
+	p := strings.TrimPrefix(urlPath, h.prefix)
+	if p != "" && !strings.HasPrefix(p, "/") {
+		return "", false
+	}
+	// Cleaning a rooted path gets rid of any "..".
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return ".", true
+	}
+	return p, true
+}
+
+// href returns the escaped URL path for a path within h.fs.
+func (h *Handler) href(p string, isDir bool) string {
+	u := h.prefix + "/"
+	if p != "." {
+		u += p
+		if isDir {
+			u += "/"
+		}
+	}
+	return (&url.URL{Path: u}).EscapedPath()
+}
+
+func errorStatus(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	case os.IsExist(err):
+		return http.StatusMethodNotAllowed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(errors.Cause(err))
+	http.Error(w, http.StatusText(status), status)
+}
+
+func writeStatus(w http.ResponseWriter, status int) {
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (h *Handler) sync() error {
+	if s, ok := h.fs.(libfs.Syncer); ok {
+		return s.SyncAll()
+	}
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface for Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.fsPath(r.URL.Path)
+	if !ok {
+		writeStatus(w, http.StatusNotFound)
+		return
+	}
+
+	var status int
+	var err error
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Allow", allowedMethods)
+		w.Header().Set("DAV", "1")
+		w.Header().Set("MS-Author-Via", "DAV")
+		status = http.StatusOK
+	case "GET", "HEAD":
+		status, err = h.serveGet(w, r, p)
+	case "PUT":
+		status, err = h.servePut(r, p)
+	case "DELETE":
+		status, err = h.serveDelete(p)
+	case "MKCOL":
+		status, err = h.serveMkcol(r, p)
+	case "COPY", "MOVE":
+		status, err = h.serveCopyMove(r, p)
+	case "PROPFIND":
+		status, err = h.servePropfind(w, r, p)
+	default:
+		w.Header().Set("Allow", allowedMethods)
+		status = http.StatusMethodNotAllowed
+	}
+
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case status != 0:
+		writeStatus(w, status)
+	}
+}
+
+func (h *Handler) serveGet(
+	w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	fi, err := h.fs.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	if fi.IsDir() {
+		return http.StatusMethodNotAllowed, nil
+	}
+	f, err := h.fs.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w.Header().Set("ETag", etag(fi))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	return 0, nil
+}
+
+// checkParent returns StatusConflict if the parent of `p` isn't an
+// existing directory, as required for PUT, MKCOL, COPY and MOVE.
+func (h *Handler) checkParent(p string) (int, error) {
+	if p == "." {
+		return http.StatusMethodNotAllowed, nil
+	}
+	fi, err := h.fs.Stat(path.Dir(p))
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusConflict, nil
+	case err != nil:
+		return 0, err
+	case !fi.IsDir():
+		return http.StatusConflict, nil
+	}
+	return 0, nil
+}
+
+func (h *Handler) servePut(r *http.Request, p string) (int, error) {
+	if status, err := h.checkParent(p); status != 0 || err != nil {
+		return status, err
+	}
+	status := http.StatusCreated
+	fi, err := h.fs.Stat(p)
+	switch {
+	case err == nil && fi.IsDir():
+		return http.StatusMethodNotAllowed, nil
+	case err == nil:
+		status = http.StatusNoContent
+	case !os.IsNotExist(err):
+		return 0, err
+	}
+
+	f, err := h.fs.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	_, err = io.Copy(f, r.Body)
+	closeErr := f.Close()
+	if err != nil {
+		return 0, err
+	}
+	if closeErr != nil {
+		return 0, closeErr
+	}
+	return status, h.sync()
+}
+
+// removeAll removes `p` and, if it's a directory, everything under
+// it.
+func (h *Handler) removeAll(p string) error {
+	fi, err := h.fs.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		children, err := h.fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			err = h.removeAll(path.Join(p, child.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return h.fs.Remove(p)
+}
+
+func (h *Handler) serveDelete(p string) (int, error) {
+	if p == "." {
+		return http.StatusMethodNotAllowed, nil
+	}
+	err := h.removeAll(p)
+	if err != nil {
+		return 0, err
+	}
+	return http.StatusNoContent, h.sync()
+}
+
+func (h *Handler) serveMkcol(r *http.Request, p string) (int, error) {
+	if r.ContentLength > 0 {
+		return http.StatusUnsupportedMediaType, nil
+	}
+	if status, err := h.checkParent(p); status != 0 || err != nil {
+		return status, err
+	}
+	_, err := h.fs.Stat(p)
+	switch {
+	case err == nil:
+		return http.StatusMethodNotAllowed, nil
+	case !os.IsNotExist(err):
+		return 0, err
+	}
+	err = h.fs.MkdirAll(p, 0755)
+	if err != nil {
+		return 0, err
+	}
+	return http.StatusCreated, h.sync()
+}
+
+// copyAll copies `from` to `to`, recursively if `recurse` is true.
+// `to` must not exist.
+func (h *Handler) copyAll(from, to string, recurse bool) error {
+	fi, err := h.fs.Stat(from)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		err = h.fs.MkdirAll(to, fi.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if !recurse {
+			return nil
+		}
+		children, err := h.fs.ReadDir(from)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			err = h.copyAll(path.Join(from, child.Name()),
+				path.Join(to, child.Name()), true)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	src, err := h.fs.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := h.fs.OpenFile(
+		to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	closeErr := dst.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (h *Handler) serveCopyMove(r *http.Request, p string) (int, error) {
+	if p == "." {
+		return http.StatusForbidden, nil
+	}
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || dest.Path == "" {
+		return http.StatusBadRequest, nil
+	}
+	if dest.Host != "" && dest.Host != r.Host {
+		return http.StatusBadGateway, nil
+	}
+	destPath, ok := h.fsPath(dest.Path)
+	if !ok {
+		// Copying or moving between TLFs isn't supported.
+		return http.StatusBadGateway, nil
+	}
+	if destPath == p || strings.HasPrefix(destPath, p+"/") {
+		return http.StatusForbidden, nil
+	}
+
+	recurse := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		if r.Method == "MOVE" {
+			return http.StatusBadRequest, nil
+		}
+		recurse = false
+	default:
+		return http.StatusBadRequest, nil
+	}
+
+	if _, err := h.fs.Stat(p); err != nil {
+		return 0, err
+	}
+	if status, err := h.checkParent(destPath); status != 0 || err != nil {
+		return status, err
+	}
+
+	status := http.StatusCreated
+	_, err = h.fs.Stat(destPath)
+	switch {
+	case err == nil:
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, nil
+		}
+		err = h.removeAll(destPath)
+		if err != nil {
+			return 0, err
+		}
+		status = http.StatusNoContent
+	case !os.IsNotExist(err):
+		return 0, err
+	}
+
+	if r.Method == "MOVE" {
+		err = h.fs.Rename(p, destPath)
+	} else {
+		err = h.copyAll(p, destPath, recurse)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return status, h.sync()
+}
+
+type davCollection struct{}
+
+type davResourceType struct {
+	Collection *davCollection `xml:"D:collection,omitempty"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified"`
+	ETag          string          `xml:"D:getetag,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XMLNS     string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+func etag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+func (h *Handler) propfindResponse(p string, fi os.FileInfo) davResponse {
+	prop := davProp{
+		DisplayName:  fi.Name(),
+		LastModified: fi.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if p == "." {
+		prop.DisplayName = path.Base(h.prefix)
+	}
+	if fi.IsDir() {
+		prop.ResourceType.Collection = &davCollection{}
+	} else {
+		size := fi.Size()
+		prop.ContentLength = &size
+		prop.ContentType = mime.TypeByExtension(path.Ext(fi.Name()))
+		prop.ETag = etag(fi)
+	}
+	return davResponse{
+		Href: h.href(p, fi.IsDir()),
+		Propstat: davPropstat{
+			Prop:   prop,
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+// servePropfind answers every PROPFIND as if it were an allprop
+// request for the live properties we support; clients asking for
+// specific properties just get some extra ones.
+func (h *Handler) servePropfind(
+	w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	var depth int
+	switch r.Header.Get("Depth") {
+	case "0":
+		depth = 0
+	case "1":
+		depth = 1
+	default:
+		// A missing Depth means infinity, which we don't support.
+		return http.StatusForbidden, nil
+	}
+	// Drain the request body, if any, so the connection can be
+	// reused.
+	if _, err := io.Copy(ioutil.Discard, r.Body); err != nil {
+		return http.StatusBadRequest, nil
+	}
+
+	fi, err := h.fs.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	ms := davMultistatus{
+		XMLNS:     "DAV:",
+		Responses: []davResponse{h.propfindResponse(p, fi)},
+	}
+	if depth == 1 && fi.IsDir() {
+		children, err := h.fs.ReadDir(p)
+		if err != nil {
+			return 0, err
+		}
+		for _, child := range children {
+			ms.Responses = append(ms.Responses,
+				h.propfindResponse(path.Join(p, child.Name()), child))
+		}
+	}
+
+	buf, err := xml.Marshal(ms)
+	if err != nil {
+		return 0, err
+	}
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	w.Write(buf)
+	return 0, nil
+}
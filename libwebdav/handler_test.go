@@ -0,0 +1,115 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libwebdav
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+func doRequest(t *testing.T, h http.Handler, method, p, body string,
+	headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, p, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+type testMultistatus struct {
+	Responses []struct {
+		Href       string    `xml:"href"`
+		Collection *struct{} `xml:"propstat>prop>resourcetype>collection"`
+		Length     string    `xml:"propstat>prop>getcontentlength"`
+	} `xml:"response"`
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "libwebdav_handler")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	h := NewHandler(osfs.New(dir), "/private/alice")
+
+	w := doRequest(t, h, "OPTIONS", "/private/alice/", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "1", w.Header().Get("DAV"))
+
+	// PUT needs an existing parent.
+	w = doRequest(t, h, "PUT", "/private/alice/d/a.txt", "hello", nil)
+	require.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(t, h, "MKCOL", "/private/alice/d", "", nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, h, "MKCOL", "/private/alice/d", "", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = doRequest(t, h, "PUT", "/private/alice/d/a.txt", "hello", nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, h, "PUT", "/private/alice/d/a.txt", "hello!", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = doRequest(t, h, "GET", "/private/alice/d/a.txt", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "hello!", w.Body.String())
+
+	// Paths can't escape the root of the FS.
+	w = doRequest(t, h, "GET", "/private/alice/../../../d/a.txt", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "hello!", w.Body.String())
+
+	w = doRequest(t, h, "PROPFIND", "/private/alice/", "", nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = doRequest(t, h, "PROPFIND", "/private/alice/d", "",
+		map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	var ms testMultistatus
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &ms))
+	require.Len(t, ms.Responses, 2)
+	require.Equal(t, "/private/alice/d/", ms.Responses[0].Href)
+	require.NotNil(t, ms.Responses[0].Collection)
+	require.Equal(t, "/private/alice/d/a.txt", ms.Responses[1].Href)
+	require.Nil(t, ms.Responses[1].Collection)
+	require.Equal(t, "6", ms.Responses[1].Length)
+
+	// COPY the whole directory, then MOVE a file without overwriting.
+	w = doRequest(t, h, "COPY", "/private/alice/d", "",
+		map[string]string{"Destination": "http://example.com/private/alice/e"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, h, "MOVE", "/private/alice/e/a.txt", "",
+		map[string]string{
+			"Destination": "/private/alice/d/a.txt",
+			"Overwrite":   "F",
+		})
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = doRequest(t, h, "MOVE", "/private/alice/e/a.txt", "",
+		map[string]string{"Destination": "/private/alice/b.txt"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, h, "GET", "/private/alice/b.txt", "", nil)
+	require.Equal(t, "hello!", w.Body.String())
+	w = doRequest(t, h, "GET", "/private/alice/e/a.txt", "", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// Moving to another TLF isn't supported.
+	w = doRequest(t, h, "MOVE", "/private/alice/b.txt", "",
+		map[string]string{"Destination": "/private/bob/b.txt"})
+	require.Equal(t, http.StatusBadGateway, w.Code)
+
+	w = doRequest(t, h, "DELETE", "/private/alice/d", "", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = doRequest(t, h, "PROPFIND", "/private/alice/d", "",
+		map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doRequest(t, h, "LOCK", "/private/alice/b.txt", "", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libwebdav
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// Debug tag ID for an individual WebDAV request.
+const ctxOpID = "DAV"
+
+type ctxTagKey int
+
+const (
+	ctxIDKey ctxTagKey = iota
+)
+
+type tlfToServe struct {
+	tlfType tlf.Type
+	name    string
+}
+
+// Server serves a fixed set of TLFs over WebDAV.  Each TLF is served
+// under "/<type>/<name>/", e.g. "/private/alice/" or "/team/acme/".
+type Server struct {
+	config libkbfs.Config
+	log    logger.Logger
+	token  string
+	tlfs   map[string]tlfToServe
+	fs     *libfs.TlfFSCache
+}
+
+var _ http.Handler = (*Server)(nil)
+
+// NewServer returns a new Server for the given TLFs, each given as
+// "<type>/<name>" (see libfs.ParseTlfPath).  If `token` is non-empty,
+// every request must present it, either as the password of HTTP
+// basic auth (with any user name), or as a bearer token.
+func NewServer(config libkbfs.Config, tlfPaths []string, token string) (
+	*Server, error) {
+	if len(tlfPaths) == 0 {
+		return nil, errors.New("No TLFs to serve")
+	}
+	tlfs := make(map[string]tlfToServe, len(tlfPaths))
+	for _, p := range tlfPaths {
+		tlfType, name, err := libfs.ParseTlfPath(p)
+		if err != nil {
+			return nil, err
+		}
+		tlfs[libfs.TlfTypePathString(tlfType)+"/"+name] = tlfToServe{tlfType, name}
+	}
+	return &Server{
+		config: config,
+		log:    config.MakeLogger("DAV"),
+		token:  token,
+		tlfs:   tlfs,
+		fs:     libfs.NewTlfFSCache(config),
+	}, nil
+}
+
+// Paths returns the URL paths of all the TLFs served by s.
+func (s *Server) Paths() []string {
+	paths := make([]string, 0, len(s.tlfs))
+	for key := range s.tlfs {
+		paths = append(paths, "/"+key+"/")
+	}
+	return paths
+}
+
+func (s *Server) isAuthorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	var presented string
+	if _, password, ok := r.BasicAuth(); ok {
+		presented = password
+	} else if auth := r.Header.Get("Authorization"); strings.HasPrefix(
+		auth, "Bearer ") {
+		presented = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare(
+		[]byte(presented), []byte(s.token)) == 1
+}
+
+// getFS returns the (possibly cached) FS for the TLF served under
+// `key`.
+func (s *Server) getFS(key string) (*libfs.FS, error) {
+	toServe := s.tlfs[key]
+	// The FS outlives this request, so don't tie it to the request's
+	// context.
+	fsCtx := libkbfs.CtxWithRandomIDReplayable(
+		context.Background(), ctxIDKey, ctxOpID, s.log)
+	return s.fs.Get(fsCtx, toServe.tlfType, toServe.name)
+}
+
+// ServeHTTP implements the http.Handler interface for Server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.isAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="KBFS"`)
+		writeStatus(w, http.StatusUnauthorized)
+		return
+	}
+
+	fields := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(fields) < 2 {
+		writeStatus(w, http.StatusNotFound)
+		return
+	}
+	key := fields[0] + "/" + fields[1]
+	if _, ok := s.tlfs[key]; !ok {
+		writeStatus(w, http.StatusNotFound)
+		return
+	}
+
+	ctx := libkbfs.CtxWithRandomIDReplayable(
+		r.Context(), ctxIDKey, ctxOpID, s.log)
+	s.log.CDebugf(ctx, "%s %s", r.Method, r.URL.Path)
+	tlfFS, err := s.getFS(key)
+	if err != nil {
+		s.log.CDebugf(ctx, "Couldn't get FS for %s: %+v", key, err)
+		writeError(w, err)
+		return
+	}
+	NewHandler(tlfFS.WithContext(ctx), "/"+key).ServeHTTP(w, r)
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libwebdav
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// StartOptions are options for starting up.
+type StartOptions struct {
+	KbfsParams libkbfs.InitParams
+	// ListenAddr is the address to serve WebDAV on.
+	ListenAddr string
+	// AllowLAN must be set for ListenAddr to be anything other than
+	// a loopback address.
+	AllowLAN bool
+	// Token, if non-empty, must be presented by every client; see
+	// NewServer.  It's required if AllowLAN is set.
+	Token string
+	// TLFs lists the TLFs to serve, each given as "<type>/<name>".
+	TLFs []string
+}
+
+// Start starts KBFS and serves the given TLFs over WebDAV until
+// interrupted.
+func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
+	if options.AllowLAN && options.Token == "" {
+		return libfs.InitError("A token is required when serving to the LAN")
+	}
+	if !options.AllowLAN {
+		err := libfs.CheckLoopbackAddr(options.ListenAddr)
+		if err != nil {
+			return libfs.InitError(err.Error() + "; use -lan to allow it")
+		}
+	}
+
+	return libfs.StartServer(options.KbfsParams, kbCtx, func(
+		config libkbfs.Config, log logger.Logger) (libfs.Mounter, error) {
+		s, err := NewServer(config, options.TLFs, options.Token)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", options.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range s.Paths() {
+			log.Info("Serving http://%s%s", listener.Addr(), p)
+		}
+		return libfs.NewListenerMounter(&http.Server{Handler: s}, listener), nil
+	})
+}
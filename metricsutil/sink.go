@@ -0,0 +1,324 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package metricsutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Snapshot holds the values of all the metrics in a registry, keyed
+// by metric name and then by field (e.g., "count" or "mean").  Values
+// are int64s, float64s, strings, or nil.
+type Snapshot map[string]map[string]interface{}
+
+// TakeSnapshot returns a Snapshot of the given registry.
+func TakeSnapshot(r metrics.Registry) Snapshot {
+	return registryToMap(r)
+}
+
+// Sink receives snapshots of a metrics registry, e.g. to send them
+// to an external monitoring system.
+type Sink interface {
+	// Send sends a snapshot taken at time `t`.
+	Send(t time.Time, snapshot Snapshot) error
+}
+
+// SinkFunc lets an ordinary function be used as a Sink.
+type SinkFunc func(t time.Time, snapshot Snapshot) error
+
+// Send implements the Sink interface for SinkFunc.
+func (f SinkFunc) Send(t time.Time, snapshot Snapshot) error {
+	return f(t, snapshot)
+}
+
+// sortedNames returns the metric names in a snapshot, sorted, so that
+// sinks send them in a stable order.
+func (s Snapshot) sortedNames() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedFields(values map[string]interface{}) []string {
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// numericValue returns `v` formatted as a number, and false if it
+// isn't one.
+func numericValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+// statsdMaxPacketSize keeps packets within a typical Ethernet MTU,
+// so that they aren't fragmented.
+const statsdMaxPacketSize = 1432
+
+// StatsdSink sends each numeric field of each metric to a statsd
+// server as a gauge, named <prefix>.<metric>.<field>.
+type StatsdSink struct {
+	addr   string
+	prefix string
+}
+
+var _ Sink = (*StatsdSink)(nil)
+
+// NewStatsdSink returns a sink that sends metrics to the statsd
+// server at the given host:port over UDP.
+func NewStatsdSink(addr, prefix string) *StatsdSink {
+	return &StatsdSink{addr: addr, prefix: prefix}
+}
+
+// statsdName replaces the characters that statsd or Graphite treat
+// specially.
+func statsdName(s string) string {
+	s = strings.Replace(s, "%", "pct", -1)
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
+			r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// lines returns the statsd lines for a snapshot.
+func (s *StatsdSink) lines(snapshot Snapshot) []string {
+	var lines []string
+	for _, name := range snapshot.sortedNames() {
+		values := snapshot[name]
+		var parts []string
+		if s.prefix != "" {
+			parts = append(parts, statsdName(s.prefix))
+		}
+		// Keep the dots in metric names, which statsd uses for its
+		// hierarchy.
+		for _, p := range strings.Split(name, ".") {
+			parts = append(parts, statsdName(p))
+		}
+		metric := strings.Join(parts, ".")
+		for _, field := range sortedFields(values) {
+			v, ok := numericValue(values[field])
+			if !ok {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf(
+				"%s.%s:%s|g", metric, statsdName(field), v))
+		}
+	}
+	return lines
+}
+
+// Send implements the Sink interface for StatsdSink.
+func (s *StatsdSink) Send(_ time.Time, snapshot Snapshot) error {
+	conn, err := net.Dial("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, line := range s.lines(snapshot) {
+		if packet.Len() > 0 &&
+			packet.Len()+1+len(line) > statsdMaxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
+
+// InfluxDBSink posts metrics in the InfluxDB line protocol, with one
+// point per metric named <prefix>.<metric>, and one field per value.
+type InfluxDBSink struct {
+	url    string
+	prefix string
+	client *http.Client
+}
+
+var _ Sink = (*InfluxDBSink)(nil)
+
+// NewInfluxDBSink returns a sink that posts metrics to the given
+// write URL, e.g. "http://localhost:8086/write?db=kbfs".
+func NewInfluxDBSink(url, prefix string) *InfluxDBSink {
+	return &InfluxDBSink{
+		url:    url,
+		prefix: prefix,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(
+		`,`, `\,`, ` `, `\ `)
+	influxKeyEscaper = strings.NewReplacer(
+		`,`, `\,`, `=`, `\=`, ` `, `\ `)
+	influxStringEscaper = strings.NewReplacer(
+		`"`, `\"`, `\`, `\\`)
+)
+
+// writeLines writes the line protocol for a snapshot taken at `t`.
+func (s *InfluxDBSink) writeLines(
+	w io.Writer, t time.Time, snapshot Snapshot) {
+	for _, name := range snapshot.sortedNames() {
+		values := snapshot[name]
+		var fields []string
+		for _, field := range sortedFields(values) {
+			key := influxKeyEscaper.Replace(field)
+			switch v := values[field].(type) {
+			case int64:
+				fields = append(fields, fmt.Sprintf("%s=%di", key, v))
+			case string:
+				fields = append(fields, fmt.Sprintf(
+					`%s="%s"`, key, influxStringEscaper.Replace(v)))
+			default:
+				if n, ok := numericValue(v); ok {
+					fields = append(fields, key+"="+n)
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		measurement := name
+		if s.prefix != "" {
+			measurement = s.prefix + "." + name
+		}
+		fmt.Fprintf(w, "%s %s %d\n",
+			influxMeasurementEscaper.Replace(measurement),
+			strings.Join(fields, ","), t.UnixNano())
+	}
+}
+
+// Send implements the Sink interface for InfluxDBSink.
+func (s *InfluxDBSink) Send(t time.Time, snapshot Snapshot) error {
+	var body bytes.Buffer
+	s.writeLines(&body, t, snapshot)
+	if body.Len() == 0 {
+		return nil
+	}
+	resp, err := s.client.Post(s.url, "text/plain; charset=utf-8", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB write failed: %s: %s",
+			resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Flusher sends snapshots of a registry to a set of sinks
+// periodically.
+type Flusher struct {
+	registry metrics.Registry
+	sinks    []Sink
+	onError  func(Sink, error)
+
+	flushLock sync.Mutex
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+}
+
+// StartFlusher starts sending snapshots of `r` to `sinks` every
+// `period`, until Stop is called.  `onError`, if non-nil, is called
+// with any errors returned by sinks.
+func StartFlusher(r metrics.Registry, period time.Duration,
+	onError func(Sink, error), sinks ...Sink) *Flusher {
+	f := &Flusher{
+		registry: r,
+		sinks:    sinks,
+		onError:  onError,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	go f.loop(period)
+	return f
+}
+
+func (f *Flusher) loop(period time.Duration) {
+	defer close(f.doneCh)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.Flush()
+		case <-f.stopCh:
+			return
+		}
+	}
+}
+
+// Flush sends a snapshot to all the sinks right away.
+func (f *Flusher) Flush() {
+	f.flushLock.Lock()
+	defer f.flushLock.Unlock()
+	t := time.Now()
+	snapshot := TakeSnapshot(f.registry)
+	for _, sink := range f.sinks {
+		if err := sink.Send(t, snapshot); err != nil && f.onError != nil {
+			f.onError(sink, err)
+		}
+	}
+}
+
+// Stop stops the periodic flushes, and then flushes one last time, so
+// that the final values of the metrics aren't lost.
+func (f *Flusher) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stopCh)
+		<-f.doneCh
+		f.Flush()
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package metricsutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s := NewStatsdSink(conn.LocalAddr().String(), "kbfs")
+	snapshot := Snapshot{
+		"KeyCache.Get hit": {"count": int64(3)},
+		"BlockServer.Put": {
+			"99.9%":  float64(1.5),
+			"median": float64(2),
+		},
+		"Health": {"error": "oops"},
+	}
+	require.Equal(t, []string{
+		"kbfs.BlockServer.Put.99_9pct:1.5|g",
+		"kbfs.BlockServer.Put.median:2|g",
+		"kbfs.KeyCache.Get_hit.count:3|g",
+	}, s.lines(snapshot))
+
+	err = s.Send(time.Now(), snapshot)
+	require.NoError(t, err)
+	buf := make([]byte, statsdMaxPacketSize)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, strings.Join(s.lines(snapshot), "\n"), string(buf[:n]))
+
+	t.Log("Big snapshots are split into several packets")
+	big := Snapshot{}
+	for i := 0; i < 100; i++ {
+		big[strings.Repeat("x", 50)+string(rune('A'+i%26))+
+			string(rune('a'+i/26))] = map[string]interface{}{
+			"count": int64(i),
+		}
+	}
+	err = s.Send(time.Now(), big)
+	require.NoError(t, err)
+	var got []string
+	for len(got) < 100 {
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.True(t, n <= statsdMaxPacketSize)
+		got = append(got, strings.Split(string(buf[:n]), "\n")...)
+	}
+	require.Equal(t, s.lines(big), got)
+}
+
+func TestInfluxDBSink(t *testing.T) {
+	ts := time.Unix(1500000000, 5)
+	snapshot := Snapshot{
+		"BlockServer.Put": {
+			"count": int64(7),
+			"mean":  float64(0.25),
+		},
+		"Health check": {"error": `bad "thing"`},
+		"Empty":        {"error": nil},
+	}
+
+	var lock sync.Mutex
+	var body string
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			lock.Lock()
+			defer lock.Unlock()
+			body = string(b)
+			w.WriteHeader(status)
+		}))
+	defer server.Close()
+
+	s := NewInfluxDBSink(server.URL+"/write?db=kbfs", "kbfs")
+	err := s.Send(ts, snapshot)
+	require.NoError(t, err)
+	lock.Lock()
+	require.Equal(t,
+		"kbfs.BlockServer.Put count=7i,mean=0.25 1500000000000000005\n"+
+			`kbfs.Health\ check error="bad \"thing\"" 1500000000000000005`+
+			"\n", body)
+	status = http.StatusBadRequest
+	lock.Unlock()
+
+	err = s.Send(ts, snapshot)
+	require.Error(t, err)
+}
+
+func TestFlusher(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := metrics.NewCounter()
+	require.NoError(t, r.Register("count", c))
+
+	var lock sync.Mutex
+	var counts []int64
+	sink := SinkFunc(func(_ time.Time, snapshot Snapshot) error {
+		lock.Lock()
+		defer lock.Unlock()
+		counts = append(counts, snapshot["count"]["count"].(int64))
+		return nil
+	})
+	var errs bytes.Buffer
+	failing := SinkFunc(func(time.Time, Snapshot) error {
+		return net.UnknownNetworkError("nope")
+	})
+	f := StartFlusher(r, time.Hour, func(_ Sink, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		errs.WriteString(err.Error())
+	}, sink, failing)
+
+	c.Inc(1)
+	f.Flush()
+	c.Inc(1)
+
+	t.Log("Stopping flushes one last time")
+	f.Stop()
+	f.Stop()
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, []int64{1, 2}, counts)
+	require.Equal(t, "unknown network nopeunknown network nope", errs.String())
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+)
+
+// SimpleFSExportAuditLogArg holds the arguments for
+// SimpleFSExportAuditLog.
+type SimpleFSExportAuditLogArg struct {
+	// Path is the root of a team folder, like /team/acme.
+	Path keybase1.Path `codec:"path" json:"path"`
+	// Dest is the local or KBFS file to write the JSON export to.
+	Dest keybase1.Path `codec:"dest" json:"dest"`
+}
+
+// SimpleFSExportAuditLog - Bring the signed audit log of a team
+// folder up to date, and export it as JSON.  KBFS must have been
+// started with audit logging on.
+func (k *SimpleFS) SimpleFSExportAuditLog(
+	ctx context.Context, arg SimpleFSExportAuditLogArg) (err error) {
+	ctx, err = k.startSyncOp(ctx, "ExportAuditLog", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	al, err := libkbfs.GetAuditLogger(k.config)
+	if err != nil {
+		return err
+	}
+	t, tlfName, _, _, err := remoteTlfAndPath(arg.Path)
+	if err != nil {
+		return err
+	}
+	if t != tlf.SingleTeam {
+		return simpleFSError{"Only team folders have audit logs"}
+	}
+	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, k.config.KBPKI(), k.config.MDOps(), tlfName, t)
+	if err != nil {
+		return err
+	}
+	rootNode, _, err := k.config.KBFSOps().GetOrCreateRootNode(
+		ctx, tlfHandle, libkbfs.MasterBranch)
+	if err != nil {
+		return err
+	}
+
+	destFS, finalElem, err := k.getFS(ctx, arg.Dest)
+	if err != nil {
+		return err
+	}
+	f, err := destFS.OpenFile(
+		finalElem, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	return al.Export(ctx, rootNode.GetFolderBranch().Tlf, f)
+}
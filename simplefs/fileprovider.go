@@ -0,0 +1,300 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	stdpath "path"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfileprovider"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// SimpleFSFileProviderEnumerateArg holds the arguments for
+// SimpleFSFileProviderEnumerate.
+type SimpleFSFileProviderEnumerateArg struct {
+	Container string `codec:"container" json:"container"`
+	// Page is empty for the first page, and the NextPage of the
+	// previous one otherwise.
+	Page string `codec:"page" json:"page"`
+	// PageSize is libfileprovider.DefaultPageSize if zero.
+	PageSize int `codec:"pageSize" json:"pageSize"`
+}
+
+// SimpleFSFileProviderChangesArg holds the arguments for
+// SimpleFSFileProviderChanges.
+type SimpleFSFileProviderChangesArg struct {
+	Container string `codec:"container" json:"container"`
+	Anchor    string `codec:"anchor" json:"anchor"`
+}
+
+// SimpleFSFileProviderMaterializeArg holds the arguments for
+// SimpleFSFileProviderMaterialize.
+type SimpleFSFileProviderMaterializeArg struct {
+	Identifier string `codec:"identifier" json:"identifier"`
+	// LocalPath is where the extension wants the file's contents.
+	LocalPath string `codec:"localPath" json:"localPath"`
+}
+
+// SimpleFSFileProviderQueueUploadArg holds the arguments for
+// SimpleFSFileProviderQueueUpload.
+type SimpleFSFileProviderQueueUploadArg struct {
+	ParentIdentifier string `codec:"parentIdentifier" json:"parentIdentifier"`
+	Filename         string `codec:"filename" json:"filename"`
+	LocalPath        string `codec:"localPath" json:"localPath"`
+}
+
+// fileProviderSource serves the TLFs of a SimpleFS to a
+// libfileprovider.Backend.
+type fileProviderSource struct {
+	k *SimpleFS
+}
+
+var _ libfileprovider.Source = fileProviderSource{}
+
+func (s fileProviderSource) TLFNames(
+	ctx context.Context, tlfType string) ([]string, error) {
+	var t tlf.Type
+	switch tlfType {
+	case "private":
+		t = tlf.Private
+	case "public":
+		t = tlf.Public
+	case "team":
+		t = tlf.SingleTeam
+	default:
+		return nil, errInvalidRemotePath
+	}
+	favs, err := s.k.favoriteList(
+		ctx, keybase1.NewPathWithKbfs("/"+tlfType), t)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(favs))
+	for _, fav := range favs {
+		names = append(names, fav.Name)
+	}
+	return names, nil
+}
+
+func (s fileProviderSource) TLFFS(
+	ctx context.Context, tlfType, tlfName string) (billy.Filesystem, error) {
+	fs, _, _, err := s.k.getTLFRootFS(
+		ctx, keybase1.NewPathWithKbfs(stdpath.Join("/", tlfType, tlfName)))
+	return fs, err
+}
+
+type rootNoder interface {
+	RootNode() libkbfs.Node
+}
+
+// folderBranch returns the folder-branch of the given TLF, if its
+// filesystem is backed by KBFS.
+func (s fileProviderSource) folderBranch(
+	ctx context.Context, tlfType, tlfName string) (
+	libkbfs.FolderBranch, bool, error) {
+	fs, err := s.TLFFS(ctx, tlfType, tlfName)
+	if err != nil {
+		return libkbfs.FolderBranch{}, false, err
+	}
+	rn, ok := fs.(rootNoder)
+	if !ok {
+		return libkbfs.FolderBranch{}, false, nil
+	}
+	return rn.RootNode().GetFolderBranch(), true, nil
+}
+
+func (s fileProviderSource) UnflushedPaths(
+	ctx context.Context, tlfType, tlfName string) (map[string]bool, error) {
+	fb, ok, err := s.folderBranch(ctx, tlfType, tlfName)
+	if err != nil || !ok {
+		return nil, err
+	}
+	status, _, err := s.k.config.KBFSOps().FolderStatus(ctx, fb)
+	if err != nil {
+		return nil, err
+	}
+	if status.Journal == nil {
+		return nil, nil
+	}
+	paths := make(map[string]bool, len(status.Journal.UnflushedPaths))
+	for _, p := range status.Journal.UnflushedPaths {
+		// Strip the "/keybase/<type>/<name>/" prefix of the
+		// canonical path.
+		parts := strings.SplitN(p, "/", 5)
+		if len(parts) < 5 {
+			continue
+		}
+		paths[parts[4]] = true
+	}
+	return paths, nil
+}
+
+func (s fileProviderSource) WaitForUpload(
+	ctx context.Context, tlfType, tlfName string) error {
+	fb, ok, err := s.folderBranch(ctx, tlfType, tlfName)
+	if err != nil || !ok {
+		return err
+	}
+	return libkbfs.WaitForTLFJournal(ctx, s.k.config, fb.Tlf, s.k.log)
+}
+
+// getFileProvider returns the FileProvider backend, starting it if
+// needed.
+func (k *SimpleFS) getFileProvider() (*libfileprovider.Backend, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.fileProvider != nil {
+		return k.fileProvider, nil
+	}
+	// Uploads outlive the call that queued them, so they need their
+	// own context.
+	ctx, err := k.startOpWrapContext(k.makeContext(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+	b, err := libfileprovider.NewBackend(
+		ctx, fileProviderSource{k}, libfileprovider.Options{
+			OnUpload: func(u libfileprovider.Upload) {
+				k.log.Debug("FileProvider upload %s of %s/%s: %s %s",
+					u.ID, u.ParentIdentifier, u.Filename, u.State, u.Error)
+			},
+		})
+	if err != nil {
+		libkbfs.CleanupCancellationDelayer(ctx)
+		return nil, err
+	}
+	k.fileProvider = b
+	return b, nil
+}
+
+// SimpleFSFileProviderItem - Get the FileProvider item with the given
+// identifier.
+func (k *SimpleFS) SimpleFSFileProviderItem(
+	ctx context.Context, identifier string) (
+	item libfileprovider.Item, err error) {
+	ctx, err = k.startSyncOp(ctx, "FileProviderItem", identifier)
+	if err != nil {
+		return libfileprovider.Item{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	b, err := k.getFileProvider()
+	if err != nil {
+		return libfileprovider.Item{}, err
+	}
+	return b.Item(ctx, identifier)
+}
+
+// SimpleFSFileProviderEnumerate - Get a page of the items in a
+// FileProvider container.
+func (k *SimpleFS) SimpleFSFileProviderEnumerate(
+	ctx context.Context, arg SimpleFSFileProviderEnumerateArg) (
+	page libfileprovider.Page, err error) {
+	ctx, err = k.startSyncOp(ctx, "FileProviderEnumerate", arg)
+	if err != nil {
+		return libfileprovider.Page{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	b, err := k.getFileProvider()
+	if err != nil {
+		return libfileprovider.Page{}, err
+	}
+	return b.Enumerate(ctx, arg.Container, arg.Page, arg.PageSize)
+}
+
+// SimpleFSFileProviderCurrentAnchor - Get a sync anchor for the
+// current state of a FileProvider container.
+func (k *SimpleFS) SimpleFSFileProviderCurrentAnchor(
+	ctx context.Context, container string) (anchor string, err error) {
+	ctx, err = k.startSyncOp(ctx, "FileProviderCurrentAnchor", container)
+	if err != nil {
+		return "", err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	b, err := k.getFileProvider()
+	if err != nil {
+		return "", err
+	}
+	return b.CurrentAnchor(ctx, container)
+}
+
+// SimpleFSFileProviderChanges - Get the changes to a FileProvider
+// container since a sync anchor.
+func (k *SimpleFS) SimpleFSFileProviderChanges(
+	ctx context.Context, arg SimpleFSFileProviderChangesArg) (
+	changes libfileprovider.Changes, err error) {
+	ctx, err = k.startSyncOp(ctx, "FileProviderChanges", arg)
+	if err != nil {
+		return libfileprovider.Changes{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	b, err := k.getFileProvider()
+	if err != nil {
+		return libfileprovider.Changes{}, err
+	}
+	return b.Changes(ctx, arg.Container, arg.Anchor)
+}
+
+// SimpleFSFileProviderMaterialize - Copy the contents of a KBFS file
+// to a local path, for the FileProvider extension to hand out.
+func (k *SimpleFS) SimpleFSFileProviderMaterialize(
+	ctx context.Context, arg SimpleFSFileProviderMaterializeArg) (
+	item libfileprovider.Item, err error) {
+	ctx, err = k.startSyncOp(ctx, "FileProviderMaterialize", arg)
+	if err != nil {
+		return libfileprovider.Item{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	b, err := k.getFileProvider()
+	if err != nil {
+		return libfileprovider.Item{}, err
+	}
+	return b.Materialize(ctx, arg.Identifier, arg.LocalPath)
+}
+
+// SimpleFSFileProviderQueueUpload - Queue a local file to be written
+// into KBFS and uploaded.
+func (k *SimpleFS) SimpleFSFileProviderQueueUpload(
+	ctx context.Context, arg SimpleFSFileProviderQueueUploadArg) (
+	u libfileprovider.Upload, err error) {
+	ctx, err = k.startSyncOp(ctx, "FileProviderQueueUpload", arg)
+	if err != nil {
+		return libfileprovider.Upload{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	b, err := k.getFileProvider()
+	if err != nil {
+		return libfileprovider.Upload{}, err
+	}
+	return b.QueueUpload(ctx, arg.ParentIdentifier, arg.Filename, arg.LocalPath)
+}
+
+// SimpleFSFileProviderUploads - Get the state of queued, in-progress
+// and recently finished FileProvider uploads.
+func (k *SimpleFS) SimpleFSFileProviderUploads(
+	ctx context.Context) (uploads []libfileprovider.Upload, err error) {
+	ctx, err = k.startSyncOp(ctx, "FileProviderUploads", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	b, err := k.getFileProvider()
+	if err != nil {
+		return nil, err
+	}
+	return b.Uploads(), nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+)
+
+// SimpleFSEnableFolderActivityBridgeArg holds the arguments for
+// SimpleFSEnableFolderActivityBridge.
+type SimpleFSEnableFolderActivityBridgeArg struct {
+	// Path is the root of a team folder, like /team/acme.
+	Path keybase1.Path `codec:"path" json:"path"`
+	// Channel is the team chat channel to post to; empty means the
+	// default channel.
+	Channel string `codec:"channel" json:"channel"`
+}
+
+// SimpleFSDisableFolderActivityBridgeArg holds the arguments for
+// SimpleFSDisableFolderActivityBridge.
+type SimpleFSDisableFolderActivityBridgeArg struct {
+	Path keybase1.Path `codec:"path" json:"path"`
+}
+
+// SimpleFSGetFolderActivityArg holds the arguments for
+// SimpleFSGetFolderActivity.
+type SimpleFSGetFolderActivityArg struct {
+	Path keybase1.Path `codec:"path" json:"path"`
+	// Since leaves out the activity that ended before this time.
+	Since keybase1.Time `codec:"since" json:"since"`
+}
+
+// FolderWriterActivity summarizes the edits made by one writer in a
+// bridged folder during one aggregation window.
+type FolderWriterActivity struct {
+	Writer    string        `codec:"writer" json:"writer"`
+	Start     keybase1.Time `codec:"start" json:"start"`
+	Last      keybase1.Time `codec:"last" json:"last"`
+	Count     int           `codec:"count" json:"count"`
+	Filenames []string      `codec:"filenames" json:"filenames"`
+}
+
+// BridgedFolderInfo describes a team folder whose activity is posted
+// to chat.
+type BridgedFolderInfo struct {
+	Path    string `codec:"path" json:"path"`
+	Channel string `codec:"channel" json:"channel"`
+}
+
+func (k *SimpleFS) getBridgedTlfHandle(
+	ctx context.Context, path keybase1.Path) (*libkbfs.TlfHandle, error) {
+	t, tlfName, _, _, err := remoteTlfAndPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if t != tlf.SingleTeam {
+		return nil, simpleFSError{"Only team folders can be bridged to chat"}
+	}
+	return libkbfs.GetHandleFromFolderNameAndType(
+		ctx, k.config.KBPKI(), k.config.MDOps(), tlfName, t)
+}
+
+// SimpleFSEnableFolderActivityBridge - Start posting summaries of
+// the edits in a team folder to one of the team's chat channels.
+// KBFS must have been started with the folder activity bridge on.
+func (k *SimpleFS) SimpleFSEnableFolderActivityBridge(
+	ctx context.Context, arg SimpleFSEnableFolderActivityBridgeArg) (
+	err error) {
+	ctx, err = k.startSyncOp(ctx, "EnableFolderActivityBridge", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	bridge, err := libkbfs.GetFolderActivityBridge(k.config)
+	if err != nil {
+		return err
+	}
+	handle, err := k.getBridgedTlfHandle(ctx, arg.Path)
+	if err != nil {
+		return err
+	}
+	return bridge.Enable(ctx, handle, arg.Channel)
+}
+
+// SimpleFSDisableFolderActivityBridge - Stop posting the edits in a
+// team folder to chat.
+func (k *SimpleFS) SimpleFSDisableFolderActivityBridge(
+	ctx context.Context, arg SimpleFSDisableFolderActivityBridgeArg) (
+	err error) {
+	ctx, err = k.startSyncOp(ctx, "DisableFolderActivityBridge", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	bridge, err := libkbfs.GetFolderActivityBridge(k.config)
+	if err != nil {
+		return err
+	}
+	handle, err := k.getBridgedTlfHandle(ctx, arg.Path)
+	if err != nil {
+		return err
+	}
+	for _, f := range bridge.Folders() {
+		if f.Name == handle.GetCanonicalName() {
+			return bridge.Disable(f.ID)
+		}
+	}
+	return nil
+}
+
+// SimpleFSListBridgedFolders - List the team folders whose activity
+// is posted to chat.
+func (k *SimpleFS) SimpleFSListBridgedFolders(ctx context.Context) (
+	folders []BridgedFolderInfo, err error) {
+	ctx, err = k.startSyncOp(ctx, "ListBridgedFolders", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	bridge, err := libkbfs.GetFolderActivityBridge(k.config)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range bridge.Folders() {
+		folders = append(folders, BridgedFolderInfo{
+			Path:    "/team/" + string(f.Name),
+			Channel: f.Channel,
+		})
+	}
+	return folders, nil
+}
+
+// SimpleFSGetFolderActivity - Get the edits made in a bridged team
+// folder, grouped by writer and aggregation window, including the
+// ones that weren't posted to chat.
+func (k *SimpleFS) SimpleFSGetFolderActivity(
+	ctx context.Context, arg SimpleFSGetFolderActivityArg) (
+	activity []FolderWriterActivity, err error) {
+	ctx, err = k.startSyncOp(ctx, "GetFolderActivity", arg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	bridge, err := libkbfs.GetFolderActivityBridge(k.config)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := k.getBridgedTlfHandle(ctx, arg.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range bridge.Folders() {
+		if f.Name != handle.GetCanonicalName() {
+			continue
+		}
+		for _, wa := range bridge.Activity(f.ID, arg.Since.Time()) {
+			writer, err := k.config.KBPKI().GetNormalizedUsername(
+				ctx, wa.UID.AsUserOrTeam())
+			if err != nil {
+				return nil, err
+			}
+			activity = append(activity, FolderWriterActivity{
+				Writer:    writer.String(),
+				Start:     keybase1.ToTime(wa.Start),
+				Last:      keybase1.ToTime(wa.Last),
+				Count:     wa.Count,
+				Filenames: wa.Filenames,
+			})
+		}
+	}
+	return activity, nil
+}
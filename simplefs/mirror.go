@@ -0,0 +1,233 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/libmirror"
+)
+
+var errNoSuchMirror = simpleFSError{"No such mirror"}
+
+// SimpleFSMirrorArg holds the arguments for SimpleFSMirrorOnce and
+// SimpleFSStartMirror.
+type SimpleFSMirrorArg struct {
+	// Source is the local directory to mirror.
+	Source string `codec:"source" json:"source"`
+	// Dest is the KBFS directory to mirror into, which is created if
+	// needed.
+	Dest keybase1.Path `codec:"dest" json:"dest"`
+	// Ignore lists .gitignore-style patterns for entries that
+	// aren't mirrored.
+	Ignore []string `codec:"ignore" json:"ignore"`
+	DryRun bool     `codec:"dryRun" json:"dryRun"`
+	// Interval is how often a started mirror rescans Source, or
+	// libmirror.DefaultInterval if zero.
+	Interval time.Duration `codec:"interval" json:"interval"`
+}
+
+// MirrorStatus describes a mirror started by SimpleFSStartMirror.
+type MirrorStatus struct {
+	ID     string        `codec:"id" json:"id"`
+	Source string        `codec:"source" json:"source"`
+	Dest   keybase1.Path `codec:"dest" json:"dest"`
+	DryRun bool          `codec:"dryRun" json:"dryRun"`
+	Passes int           `codec:"passes" json:"passes"`
+	// LastPass is zero until the first pass is done.
+	LastPass   keybase1.Time    `codec:"lastPass" json:"lastPass"`
+	LastResult libmirror.Result `codec:"lastResult" json:"lastResult"`
+	// LastError is empty if the last pass succeeded.
+	LastError string `codec:"lastError" json:"lastError"`
+}
+
+// mirror is a running mirror.
+type mirror struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	lock   sync.Mutex
+	status MirrorStatus
+}
+
+func (m *mirror) getStatus() MirrorStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.status
+}
+
+func (k *SimpleFS) newMirrorEngine(
+	ctx context.Context, arg SimpleFSMirrorArg) (*libmirror.Engine, error) {
+	fs, _, pathInTlf, err := k.getTLFRootFS(ctx, arg.Dest)
+	if err != nil {
+		return nil, err
+	}
+	if pathInTlf != "" && pathInTlf != "." {
+		if err := fs.MkdirAll(pathInTlf, 0755); err != nil {
+			return nil, err
+		}
+		fs, err = fs.Chroot(pathInTlf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return libmirror.NewEngine(arg.Source, fs, libmirror.Options{
+		Ignore:   append([]string{libmirror.KBFSReservedPattern}, arg.Ignore...),
+		DryRun:   arg.DryRun,
+		Interval: arg.Interval,
+	})
+}
+
+// SimpleFSMirrorOnce - One-way sync a local directory into KBFS, and
+// report what changed (or, for a dry run, what would change).
+func (k *SimpleFS) SimpleFSMirrorOnce(
+	ctx context.Context, arg SimpleFSMirrorArg) (
+	res libmirror.Result, err error) {
+	ctx, err = k.startSyncOp(ctx, "MirrorOnce", arg)
+	if err != nil {
+		return libmirror.Result{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	engine, err := k.newMirrorEngine(ctx, arg)
+	if err != nil {
+		return libmirror.Result{}, err
+	}
+	return engine.Sync(ctx)
+}
+
+// SimpleFSStartMirror - Start continuously one-way syncing a local
+// directory into KBFS, until SimpleFSStopMirror is called with the
+// returned ID.
+func (k *SimpleFS) SimpleFSStartMirror(
+	ctx context.Context, arg SimpleFSMirrorArg) (id string, err error) {
+	ctx, err = k.startSyncOp(ctx, "StartMirror", arg)
+	if err != nil {
+		return "", err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	// The mirror outlives this call, so it needs its own context.
+	opCtx, err := k.startOpWrapContext(k.makeContext(context.Background()))
+	if err != nil {
+		return "", err
+	}
+	mirrorCtx, cancel := context.WithCancel(opCtx)
+	engine, err := k.newMirrorEngine(mirrorCtx, arg)
+	if err != nil {
+		cancel()
+		libkbfs.CleanupCancellationDelayer(opCtx)
+		return "", err
+	}
+	var buf [8]byte
+	if err := kbfscrypto.RandRead(buf[:]); err != nil {
+		cancel()
+		libkbfs.CleanupCancellationDelayer(opCtx)
+		return "", err
+	}
+	id = hex.EncodeToString(buf[:])
+
+	m := &mirror{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: MirrorStatus{
+			ID:     id,
+			Source: arg.Source,
+			Dest:   arg.Dest,
+			DryRun: arg.DryRun,
+		},
+	}
+	k.lock.Lock()
+	k.mirrors[id] = m
+	k.lock.Unlock()
+
+	go func() {
+		defer close(m.done)
+		defer libkbfs.CleanupCancellationDelayer(opCtx)
+		_ = engine.Run(mirrorCtx, func(res libmirror.Result, err error) {
+			if err != nil {
+				k.log.CDebugf(mirrorCtx, "Mirror %s failed: %+v", id, err)
+			}
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			m.status.Passes++
+			m.status.LastPass = keybase1.ToTime(k.config.Clock().Now())
+			m.status.LastResult = res
+			m.status.LastError = ""
+			if err != nil {
+				m.status.LastError = err.Error()
+			}
+		})
+	}()
+	return id, nil
+}
+
+// SimpleFSStopMirror - Stop a mirror started by SimpleFSStartMirror,
+// waiting for any pass in progress to be canceled.
+func (k *SimpleFS) SimpleFSStopMirror(
+	ctx context.Context, id string) (err error) {
+	ctx, err = k.startSyncOp(ctx, "StopMirror", id)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	k.lock.Lock()
+	m, ok := k.mirrors[id]
+	delete(k.mirrors, id)
+	k.lock.Unlock()
+	if !ok {
+		return errNoSuchMirror
+	}
+	m.cancel()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type mirrorStatusesByID []MirrorStatus
+
+func (m mirrorStatusesByID) Len() int {
+	return len(m)
+}
+
+func (m mirrorStatusesByID) Less(i, j int) bool {
+	return m[i].ID < m[j].ID
+}
+
+func (m mirrorStatusesByID) Swap(i, j int) {
+	m[i], m[j] = m[j], m[i]
+}
+
+// SimpleFSListMirrors - Get the status of all the mirrors started by
+// SimpleFSStartMirror.
+func (k *SimpleFS) SimpleFSListMirrors(
+	ctx context.Context) (res []MirrorStatus, err error) {
+	ctx, err = k.startSyncOp(ctx, "ListMirrors", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	res = make([]MirrorStatus, 0, len(k.mirrors))
+	for _, m := range k.mirrors {
+		res = append(res, m.getStatus())
+	}
+	sort.Sort(mirrorStatusesByID(res))
+	return res, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// ServerOnlineStatus describes the connectivity of KBFS to one of
+// its remote servers.
+type ServerOnlineStatus struct {
+	Connected bool `codec:"connected" json:"connected"`
+	// LastError is empty if the last connection attempt succeeded.
+	LastError     string        `codec:"lastError" json:"lastError"`
+	LastErrorTime keybase1.Time `codec:"lastErrorTime" json:"lastErrorTime"`
+	// NextRetry is zero unless the connection is currently failing.
+	NextRetry keybase1.Time `codec:"nextRetry" json:"nextRetry"`
+}
+
+// OnlineStatus describes the connectivity of KBFS to the mdserver
+// and bserver.
+type OnlineStatus struct {
+	MDServer ServerOnlineStatus `codec:"mdServer" json:"mdServer"`
+	BServer  ServerOnlineStatus `codec:"bServer" json:"bServer"`
+}
+
+func toServerOnlineStatus(
+	status libkbfs.ServerConnectionStatus) ServerOnlineStatus {
+	res := ServerOnlineStatus{Connected: status.IsConnected}
+	if status.LastError != nil {
+		res.LastError = status.LastError.Error()
+		res.LastErrorTime = keybase1.ToTime(status.LastErrorTime)
+	}
+	if !status.NextRetry.IsZero() {
+		res.NextRetry = keybase1.ToTime(status.NextRetry)
+	}
+	return res
+}
+
+// SimpleFSGetOnlineStatus - Get the current connectivity of KBFS to
+// the mdserver and bserver.
+func (k *SimpleFS) SimpleFSGetOnlineStatus(ctx context.Context) (
+	status OnlineStatus, err error) {
+	ctx, err = k.startSyncOp(ctx, "GetOnlineStatus", nil)
+	if err != nil {
+		return OnlineStatus{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	return OnlineStatus{
+		MDServer: toServerOnlineStatus(
+			k.config.MDServer().ConnectionStatus()),
+		BServer: toServerOnlineStatus(
+			k.config.BlockServer().ConnectionStatus()),
+	}, nil
+}
+
+// SimpleFSForceReconnect - Retry any failing server connections
+// right away, rather than waiting for the next scheduled attempt.
+func (k *SimpleFS) SimpleFSForceReconnect(ctx context.Context) (err error) {
+	ctx, err = k.startSyncOp(ctx, "ForceReconnect", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	// Let status listeners know to refresh, whatever the outcome.
+	defer k.config.KBFSOps().PushStatusChange()
+
+	err = k.config.MDServer().ForceReconnect(ctx)
+	if err != nil {
+		return err
+	}
+	return k.config.BlockServer().ForceReconnect(ctx)
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// SimpleFSSetHostPowerStateArg holds the arguments for
+// SimpleFSSetHostPowerState.
+type SimpleFSSetHostPowerStateArg struct {
+	// OnBattery is true when the device isn't plugged in.
+	OnBattery bool `codec:"onBattery" json:"onBattery"`
+	// Metered is true when the network charges by the byte.
+	Metered bool `codec:"metered" json:"metered"`
+}
+
+// RuntimeProfileStatus describes the runtime profile in effect, and
+// the host state that it was chosen for.
+type RuntimeProfileStatus struct {
+	// Profile is "default" or "saver".
+	Profile   string `codec:"profile" json:"profile"`
+	OnBattery bool   `codec:"onBattery" json:"onBattery"`
+	Metered   bool   `codec:"metered" json:"metered"`
+}
+
+func toRuntimeProfileStatus(
+	state libkbfs.HostPowerState,
+	profile libkbfs.RuntimeProfile) RuntimeProfileStatus {
+	return RuntimeProfileStatus{
+		Profile:   profile.String(),
+		OnBattery: state.OnBattery,
+		Metered:   state.Metered,
+	}
+}
+
+// SimpleFSSetHostPowerState - Tell KBFS whether the device is on
+// battery or a metered network, so that it can save battery and
+// bandwidth by polling less, not prefetching, batching uploads and
+// using smaller caches.
+func (k *SimpleFS) SimpleFSSetHostPowerState(
+	ctx context.Context, arg SimpleFSSetHostPowerStateArg) (
+	status RuntimeProfileStatus, err error) {
+	ctx, err = k.startSyncOp(ctx, "SetHostPowerState", arg)
+	if err != nil {
+		return RuntimeProfileStatus{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	state := libkbfs.HostPowerState{
+		OnBattery: arg.OnBattery,
+		Metered:   arg.Metered,
+	}
+	profile := k.runtimeProfiler.SetHostPowerState(ctx, state)
+	return toRuntimeProfileStatus(state, profile), nil
+}
+
+// SimpleFSGetRuntimeProfile - Get the runtime profile in effect.
+func (k *SimpleFS) SimpleFSGetRuntimeProfile(ctx context.Context) (
+	status RuntimeProfileStatus, err error) {
+	ctx, err = k.startSyncOp(ctx, "GetRuntimeProfile", nil)
+	if err != nil {
+		return RuntimeProfileStatus{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	return toRuntimeProfileStatus(k.runtimeProfiler.Status()), nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	stdpath "path"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+)
+
+// SharedFolderEntry is a folder shared with the logged-in user.
+type SharedFolderEntry struct {
+	Path keybase1.Path `codec:"path" json:"path"`
+	// LastActivity is the time of the latest edit by another user,
+	// or zero if there's none in the folder's edit history.
+	LastActivity keybase1.Time `codec:"lastActivity" json:"lastActivity"`
+	// Writers are the other users who edited the folder recently,
+	// most recent first.
+	Writers []string `codec:"writers" json:"writers"`
+}
+
+// SharedFileEntry is a file another user edited recently, in a
+// folder shared with the logged-in user.
+type SharedFileEntry struct {
+	Path   keybase1.Path `codec:"path" json:"path"`
+	Writer string        `codec:"writer" json:"writer"`
+	Mtime  keybase1.Time `codec:"mtime" json:"mtime"`
+}
+
+// SimpleFSSharedWithMeResult is the result of SimpleFSListSharedWithMe.
+type SimpleFSSharedWithMeResult struct {
+	// Folders are sorted by last activity, most recent first.
+	Folders []SharedFolderEntry `codec:"folders" json:"folders"`
+	// Files are sorted by mtime, most recent first.
+	Files []SharedFileEntry `codec:"files" json:"files"`
+}
+
+// SimpleFSListSharedWithMe - List the favorite folders the logged-in
+// user shares with others, and the files in them that others edited
+// recently, so they can be found without knowing the folder names.
+func (k *SimpleFS) SimpleFSListSharedWithMe(ctx context.Context) (
+	res SimpleFSSharedWithMeResult, err error) {
+	ctx, err = k.startSyncOp(ctx, "ListSharedWithMe", nil)
+	if err != nil {
+		return SimpleFSSharedWithMeResult{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	s, err := libfs.GetSharedWithMe(ctx, k.config)
+	if err != nil {
+		return SimpleFSSharedWithMeResult{}, err
+	}
+	res.Folders = make([]SharedFolderEntry, 0, len(s.Folders))
+	for _, f := range s.Folders {
+		entry := SharedFolderEntry{
+			Path: keybase1.NewPathWithKbfs("/" + f.Path()),
+		}
+		if !f.LastActivity.IsZero() {
+			entry.LastActivity = keybase1.ToTime(f.LastActivity)
+		}
+		for _, w := range f.Writers {
+			entry.Writers = append(entry.Writers, w.String())
+		}
+		res.Folders = append(res.Folders, entry)
+	}
+	res.Files = make([]SharedFileEntry, 0, len(s.Files))
+	for _, f := range s.Files {
+		res.Files = append(res.Files, SharedFileEntry{
+			Path: keybase1.NewPathWithKbfs(
+				stdpath.Join("/", f.Folder, f.Path)),
+			Writer: f.Writer.String(),
+			Mtime:  keybase1.ToTime(f.Mtime),
+		})
+	}
+	return res, nil
+}
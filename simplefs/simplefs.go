@@ -6,6 +6,7 @@ package simplefs
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -20,6 +21,7 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/libfileprovider"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libhttpserver"
 	"github.com/keybase/kbfs/libkbfs"
@@ -84,8 +86,12 @@ type SimpleFS struct {
 	newFS newFSFunc
 	// For dumping debug info to the logs.
 	idd *libkbfs.ImpatientDebugDumper
+	// Switches KBFS between runtime profiles, as the host reports
+	// its power state - constant, does its own locking.
+	runtimeProfiler *libkbfs.RuntimeProfiler
 
-	// lock protects handles and inProgress
+	// lock protects handles, inProgress, trashRetention, mirrors
+	// and fileProvider
 	lock sync.RWMutex
 	// handles contains handles opened by SimpleFSOpen,
 	// closed by SimpleFSClose (or SimpleFSCancel) and used
@@ -94,6 +100,16 @@ type SimpleFS struct {
 	// inProgress is for keeping state of operations in progress,
 	// values are removed by SimpleFSWait (or SimpleFSCancel).
 	inProgress map[keybase1.OpID]*inprogress
+	// trashRetention is how long removed KBFS entries are kept in
+	// the trash.  If zero, trash mode is disabled and removes are
+	// permanent.
+	trashRetention time.Duration
+	// mirrors holds the mirrors started by SimpleFSStartMirror, by
+	// ID.
+	mirrors map[string]*mirror
+	// fileProvider serves the FileProvider extension on macOS; it's
+	// started by the first SimpleFSFileProvider* call.
+	fileProvider *libfileprovider.Backend
 
 	localHTTPServer *libhttpserver.Server
 }
@@ -117,17 +133,23 @@ var _ keybase1.SimpleFSInterface = (*SimpleFS)(nil)
 
 func newSimpleFS(g *libkb.GlobalContext, config libkbfs.Config) *SimpleFS {
 	log := config.MakeLogger("simplefs")
-	localHTTPServer, err := libhttpserver.New(g, config)
-	if err != nil {
-		log.Fatalf("initializing localHTTPServer error: %v", err)
+	var localHTTPServer *libhttpserver.Server
+	if config.Mode().LocalHTTPServerEnabled() {
+		var err error
+		localHTTPServer, err = libhttpserver.New(g, config)
+		if err != nil {
+			log.Fatalf("initializing localHTTPServer error: %v", err)
+		}
 	}
 	return &SimpleFS{
 		config:          config,
 		handles:         map[keybase1.OpID]*handle{},
 		inProgress:      map[keybase1.OpID]*inprogress{},
+		mirrors:         map[string]*mirror{},
 		log:             log,
 		newFS:           defaultNewFS,
 		idd:             libkbfs.NewImpatientDebugDumperForForcedDumps(config),
+		runtimeProfiler: libkbfs.NewRuntimeProfiler(config),
 		localHTTPServer: localHTTPServer,
 	}
 }
@@ -1138,6 +1160,14 @@ func (k *SimpleFS) SimpleFSRemove(ctx context.Context,
 				OpID: arg.OpID, Path: arg.Path,
 			}),
 		func(ctx context.Context) (err error) {
+			pt, err := arg.Path.PathType()
+			if err != nil {
+				return err
+			}
+			retention := k.getTrashRetention()
+			if retention > 0 && pt == keybase1.PathType_KBFS {
+				return k.moveToTrash(ctx, arg.Path, retention)
+			}
 			return k.doRemove(ctx, arg.Path)
 		})
 }
@@ -1316,6 +1346,10 @@ func (k *SimpleFS) SimpleFSSyncStatus(ctx context.Context) (keybase1.FSSyncStatu
 // local KBFS http server.
 func (k *SimpleFS) SimpleFSGetHTTPAddressAndToken(ctx context.Context) (
 	resp keybase1.SimpleFSGetHTTPAddressAndTokenResponse, err error) {
+	if k.localHTTPServer == nil {
+		return keybase1.SimpleFSGetHTTPAddressAndTokenResponse{},
+			errors.New("the local HTTP server is disabled in this mode")
+	}
 	if resp.Token, err = k.localHTTPServer.NewToken(); err != nil {
 		return keybase1.SimpleFSGetHTTPAddressAndTokenResponse{}, err
 	}
@@ -18,8 +18,11 @@ import (
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/libfileprovider"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/libmirror"
+	"github.com/keybase/kbfs/tlf"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	billy "gopkg.in/src-d/go-billy.v4"
@@ -646,3 +649,346 @@ func TestCopyProgress(t *testing.T) {
 	err = sfs.SimpleFSWait(ctx, opid)
 	require.NoError(t, err)
 }
+
+func TestOnlineStatus(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(
+		libkb.NewGlobalContext().Init(),
+		libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	status, err := sfs.SimpleFSGetOnlineStatus(ctx)
+	require.NoError(t, err)
+	require.True(t, status.MDServer.Connected)
+	require.True(t, status.BServer.Connected)
+	require.Equal(t, "", status.MDServer.LastError)
+	require.True(t, status.MDServer.NextRetry.IsZero())
+
+	// Reconnecting while already connected is a no-op.
+	err = sfs.SimpleFSForceReconnect(ctx)
+	require.NoError(t, err)
+}
+
+func TestRuntimeProfile(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(
+		libkb.NewGlobalContext().Init(),
+		libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	status, err := sfs.SimpleFSGetRuntimeProfile(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "default", status.Profile)
+
+	status, err = sfs.SimpleFSSetHostPowerState(
+		ctx, SimpleFSSetHostPowerStateArg{Metered: true})
+	require.NoError(t, err)
+	require.Equal(t, RuntimeProfileStatus{
+		Profile: "saver",
+		Metered: true,
+	}, status)
+
+	status, err = sfs.SimpleFSSetHostPowerState(
+		ctx, SimpleFSSetHostPowerStateArg{})
+	require.NoError(t, err)
+	require.Equal(t, "default", status.Profile)
+}
+
+func removeRemote(
+	ctx context.Context, t *testing.T, sfs *SimpleFS, path keybase1.Path) {
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSRemove(ctx, keybase1.SimpleFSRemoveArg{
+		OpID: opid,
+		Path: path,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	clock := &libkbfs.TestClock{}
+	clock.Set(time.Now())
+	config.SetClock(clock)
+	sfs := newSimpleFS(libkb.NewGlobalContext().Init(), config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	tlfPath := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	path1 := pathAppend(tlfPath, "test1.txt")
+	path2 := pathAppend(tlfPath, "test2.txt")
+	writeRemoteFile(ctx, t, sfs, path1, []byte("foo"))
+	writeRemoteFile(ctx, t, sfs, path2, []byte("bar"))
+
+	err := sfs.SimpleFSSetTrashMode(ctx, SimpleFSSetTrashModeArg{
+		Enabled:   true,
+		Retention: time.Hour,
+	})
+	require.NoError(t, err)
+
+	t.Log("Removing a file moves it to the trash")
+	removeRemote(ctx, t, sfs, path1)
+	_, err = sfs.SimpleFSStat(ctx, path1)
+	require.Error(t, err)
+	entries, err := sfs.SimpleFSListTrash(ctx, tlfPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, path1, entries[0].Path)
+	require.Equal(t, 3, entries[0].Size)
+
+	t.Log("Restore the file")
+	err = sfs.SimpleFSRestoreTrash(ctx, SimpleFSRestoreTrashArg{
+		Path: tlfPath,
+		ID:   entries[0].ID,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(readRemoteFile(ctx, t, sfs, path1)))
+	entries, err = sfs.SimpleFSListTrash(ctx, tlfPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+
+	t.Log("Purge a trashed file")
+	removeRemote(ctx, t, sfs, path1)
+	err = sfs.SimpleFSPurgeTrash(ctx, SimpleFSPurgeTrashArg{Path: tlfPath})
+	require.NoError(t, err)
+	entries, err = sfs.SimpleFSListTrash(ctx, tlfPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+
+	t.Log("Expired entries are dropped when another entry is trashed")
+	removeRemote(ctx, t, sfs, path2)
+	clock.Add(2 * time.Hour)
+	writeRemoteFile(ctx, t, sfs, path1, []byte("foo"))
+	removeRemote(ctx, t, sfs, path1)
+	fs, _, _, err := sfs.getTrashFS(ctx, tlfPath)
+	require.NoError(t, err)
+	fis, err := fs.ReadDir(trashDirName)
+	require.NoError(t, err)
+	// Just path1's entry and its info file.
+	require.Len(t, fis, 2)
+	entries, err = sfs.SimpleFSListTrash(ctx, tlfPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, path1, entries[0].Path)
+	err = sfs.SimpleFSPurgeTrash(ctx, SimpleFSPurgeTrashArg{Path: tlfPath})
+	require.NoError(t, err)
+
+	t.Log("Removes are permanent once trash mode is disabled")
+	err = sfs.SimpleFSSetTrashMode(ctx, SimpleFSSetTrashModeArg{})
+	require.NoError(t, err)
+	writeRemoteFile(ctx, t, sfs, path1, []byte("foo"))
+	removeRemote(ctx, t, sfs, path1)
+	entries, err = sfs.SimpleFSListTrash(ctx, tlfPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+}
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(libkb.NewGlobalContext().Init(), config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	src, err := ioutil.TempDir("", "simplefs_mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+	err = ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("foo"), 0644)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(src, "b.tmp"), []byte("tmp"), 0644)
+	require.NoError(t, err)
+
+	dest := keybase1.NewPathWithKbfs(`/private/jdoe/mirror`)
+	arg := SimpleFSMirrorArg{
+		Source:   src,
+		Dest:     dest,
+		Ignore:   []string{"*.tmp"},
+		DryRun:   true,
+		Interval: 10 * time.Millisecond,
+	}
+
+	t.Log("A dry run doesn't write anything")
+	res, err := sfs.SimpleFSMirrorOnce(ctx, arg)
+	require.NoError(t, err)
+	require.True(t, res.DryRun)
+	require.Equal(t, []libmirror.Action{
+		{Type: libmirror.ActionCopy, Path: "a.txt", Size: 3},
+	}, res.Actions)
+	_, err = sfs.SimpleFSStat(ctx, pathAppend(dest, "a.txt"))
+	require.Error(t, err)
+
+	t.Log("A real pass copies the file")
+	arg.DryRun = false
+	res, err = sfs.SimpleFSMirrorOnce(ctx, arg)
+	require.NoError(t, err)
+	require.Len(t, res.Actions, 1)
+	require.Equal(t, "foo",
+		string(readRemoteFile(ctx, t, sfs, pathAppend(dest, "a.txt"))))
+
+	t.Log("A started mirror picks up new files")
+	id, err := sfs.SimpleFSStartMirror(ctx, arg)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(src, "c.txt"), []byte("bar"), 0644)
+	require.NoError(t, err)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		statuses, err := sfs.SimpleFSListMirrors(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		require.Equal(t, id, statuses[0].ID)
+		require.Empty(t, statuses[0].LastError)
+		_, err = sfs.SimpleFSStat(ctx, pathAppend(dest, "c.txt"))
+		if err == nil {
+			break
+		}
+		require.True(t, time.Now().Before(deadline),
+			"Mirror didn't copy the new file")
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, "bar",
+		string(readRemoteFile(ctx, t, sfs, pathAppend(dest, "c.txt"))))
+
+	err = sfs.SimpleFSStopMirror(ctx, id)
+	require.NoError(t, err)
+	statuses, err := sfs.SimpleFSListMirrors(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 0)
+	err = sfs.SimpleFSStopMirror(ctx, id)
+	require.Equal(t, errNoSuchMirror, err)
+}
+
+func TestFileProvider(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(libkb.NewGlobalContext().Init(), config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	writeRemoteFile(
+		ctx, t, sfs, keybase1.NewPathWithKbfs(`/private/jdoe/a.txt`),
+		[]byte("foo"))
+
+	t.Log("Enumerate the TLF")
+	page, err := sfs.SimpleFSFileProviderEnumerate(
+		ctx, SimpleFSFileProviderEnumerateArg{Container: "/private/jdoe"})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, "/private/jdoe/a.txt", page.Items[0].Identifier)
+	require.Equal(t, libfileprovider.ItemFile, page.Items[0].Type)
+	require.Equal(t, int64(3), page.Items[0].Size)
+	anchor, err := sfs.SimpleFSFileProviderCurrentAnchor(ctx, "/private/jdoe")
+	require.NoError(t, err)
+
+	t.Log("Materialize the file")
+	local, err := ioutil.TempDir("", "simplefs_fileprovider")
+	require.NoError(t, err)
+	defer os.RemoveAll(local)
+	item, err := sfs.SimpleFSFileProviderMaterialize(
+		ctx, SimpleFSFileProviderMaterializeArg{
+			Identifier: "/private/jdoe/a.txt",
+			LocalPath:  filepath.Join(local, "a.txt"),
+		})
+	require.NoError(t, err)
+	require.Equal(t, "a.txt", item.Filename)
+	data, err := ioutil.ReadFile(filepath.Join(local, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(data))
+
+	t.Log("Upload a new file")
+	err = ioutil.WriteFile(filepath.Join(local, "b.txt"), []byte("bar"), 0644)
+	require.NoError(t, err)
+	u, err := sfs.SimpleFSFileProviderQueueUpload(
+		ctx, SimpleFSFileProviderQueueUploadArg{
+			ParentIdentifier: "/private/jdoe",
+			Filename:         "b.txt",
+			LocalPath:        filepath.Join(local, "b.txt"),
+		})
+	require.NoError(t, err)
+	defer sfs.fileProvider.Shutdown()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		uploads, err := sfs.SimpleFSFileProviderUploads(ctx)
+		require.NoError(t, err)
+		require.Len(t, uploads, 1)
+		require.Equal(t, u.ID, uploads[0].ID)
+		require.NotEqual(t, libfileprovider.UploadFailed, uploads[0].State,
+			uploads[0].Error)
+		if uploads[0].State == libfileprovider.UploadDone {
+			break
+		}
+		require.True(t, time.Now().Before(deadline),
+			"Upload didn't finish")
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, "bar", string(readRemoteFile(
+		ctx, t, sfs, keybase1.NewPathWithKbfs(`/private/jdoe/b.txt`))))
+
+	t.Log("The upload shows up as a change")
+	changes, err := sfs.SimpleFSFileProviderChanges(
+		ctx, SimpleFSFileProviderChangesArg{
+			Container: "/private/jdoe",
+			Anchor:    anchor,
+		})
+	require.NoError(t, err)
+	require.Len(t, changes.Updated, 1)
+	require.Equal(t, "/private/jdoe/b.txt", changes.Updated[0].Identifier)
+	require.Len(t, changes.Deleted, 0)
+}
+
+func TestSharedWithMe(t *testing.T) {
+	ctx := context.Background()
+	kbfsCtx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe", "alice")
+	config2 := libkbfs.ConfigAsUser(config, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config2)
+	sfs := newSimpleFS(libkb.NewGlobalContext().Init(), config)
+	defer closeSimpleFS(ctx, t, sfs)
+	sfs2 := newSimpleFS(libkb.NewGlobalContext().Init(), config2)
+
+	getRoot := func(config libkbfs.Config) libkbfs.Node {
+		h, err := libkbfs.ParseTlfHandle(
+			kbfsCtx, config.KBPKI(), config.MDOps(), "alice,jdoe", tlf.Private)
+		require.NoError(t, err)
+		rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+			kbfsCtx, h, libkbfs.MasterBranch)
+		require.NoError(t, err)
+		return rootNode
+	}
+
+	t.Log("Only folders with other users count")
+	writeRemoteFile(
+		ctx, t, sfs, keybase1.NewPathWithKbfs(`/private/jdoe/mine.txt`),
+		[]byte("foo"))
+	writeRemoteFile(
+		ctx, t, sfs, keybase1.NewPathWithKbfs(`/private/alice,jdoe/a.txt`),
+		[]byte("foo"))
+	err := config.KBFSOps().SyncAll(kbfsCtx, getRoot(config).GetFolderBranch())
+	require.NoError(t, err)
+	res, err := sfs.SimpleFSListSharedWithMe(ctx)
+	require.NoError(t, err)
+	require.Len(t, res.Folders, 1)
+	require.Equal(t, keybase1.NewPathWithKbfs(`/private/alice,jdoe`),
+		res.Folders[0].Path)
+	require.Len(t, res.Folders[0].Writers, 0)
+	require.Len(t, res.Files, 0)
+
+	t.Log("Edits by other users show up")
+	writeRemoteFile(
+		ctx, t, sfs2, keybase1.NewPathWithKbfs(`/private/alice,jdoe/b.txt`),
+		[]byte("bar"))
+	err = config2.KBFSOps().SyncAll(kbfsCtx, getRoot(config2).GetFolderBranch())
+	require.NoError(t, err)
+	err = config.KBFSOps().SyncFromServer(
+		kbfsCtx, getRoot(config).GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	res, err = sfs.SimpleFSListSharedWithMe(ctx)
+	require.NoError(t, err)
+	require.Len(t, res.Folders, 1)
+	require.Equal(t, []string{"alice"}, res.Folders[0].Writers)
+	require.NotZero(t, res.Folders[0].LastActivity)
+	require.Len(t, res.Files, 1)
+	require.Equal(t, keybase1.NewPathWithKbfs(`/private/alice,jdoe/b.txt`),
+		res.Files[0].Path)
+	require.Equal(t, "alice", res.Files[0].Writer)
+}
@@ -0,0 +1,394 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	stdpath "path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	// trashDirName is the name of the directory, at the root of each
+	// TLF, that holds removed entries while trash mode is enabled.
+	trashDirName = ".kbfs_trash"
+	// trashInfoSuffix is appended to a trash entry ID to get the name
+	// of the file describing that entry.
+	trashInfoSuffix = ".info"
+)
+
+var errNoSuchTrashEntry = simpleFSError{"No such trash entry"}
+
+// SimpleFSSetTrashModeArg holds the arguments for SimpleFSSetTrashMode.
+type SimpleFSSetTrashModeArg struct {
+	Enabled bool `codec:"enabled" json:"enabled"`
+	// Retention is how long removed entries are kept in the trash
+	// before being permanently deleted.  If zero,
+	// defaultTrashRetention is used.
+	Retention time.Duration `codec:"retention" json:"retention"`
+}
+
+// SimpleFSRestoreTrashArg holds the arguments for SimpleFSRestoreTrash.
+type SimpleFSRestoreTrashArg struct {
+	// Path is any path within the TLF that owns the trash entry.
+	Path keybase1.Path `codec:"path" json:"path"`
+	ID   string        `codec:"id" json:"id"`
+}
+
+// SimpleFSPurgeTrashArg holds the arguments for SimpleFSPurgeTrash.
+type SimpleFSPurgeTrashArg struct {
+	// Path is any path within the TLF that owns the trash.
+	Path keybase1.Path `codec:"path" json:"path"`
+	// IDs lists the trash entries to purge.  If empty, the entire
+	// trash is purged.
+	IDs []string `codec:"ids" json:"ids"`
+}
+
+// TrashEntry describes an entry that was removed while trash mode
+// was enabled.
+type TrashEntry struct {
+	ID string `codec:"id" json:"id"`
+	// Path is where the entry lived before it was removed.
+	Path       keybase1.Path       `codec:"path" json:"path"`
+	DirentType keybase1.DirentType `codec:"direntType" json:"direntType"`
+	Size       int                 `codec:"size" json:"size"`
+	Deleted    keybase1.Time       `codec:"deleted" json:"deleted"`
+	Expires    keybase1.Time       `codec:"expires" json:"expires"`
+}
+
+// trashInfo is stored, JSON-encoded, next to each trash entry.
+type trashInfo struct {
+	Path    string
+	Deleted time.Time
+	Expires time.Time
+}
+
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+func (k *SimpleFS) getTrashRetention() time.Duration {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.trashRetention
+}
+
+// SimpleFSSetTrashMode - Enable or disable trash mode.  While
+// enabled, SimpleFSRemove moves KBFS entries into the trash of their
+// TLF instead of deleting them.
+func (k *SimpleFS) SimpleFSSetTrashMode(
+	ctx context.Context, arg SimpleFSSetTrashModeArg) (err error) {
+	ctx, err = k.startSyncOp(ctx, "SetTrashMode", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	retention := time.Duration(0)
+	if arg.Enabled {
+		retention = arg.Retention
+		if retention <= 0 {
+			retention = defaultTrashRetention
+		}
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.trashRetention = retention
+	return nil
+}
+
+// getTLFRootFS returns a file system rooted at the TLF of the given
+// remote path, along with the path of the entry within that TLF.
+func (k *SimpleFS) getTLFRootFS(ctx context.Context, path keybase1.Path) (
+	fs billy.Filesystem, tlfPrefix, pathInTlf string, err error) {
+	t, tlfName, restOfPath, finalElem, err := remoteTlfAndPath(path)
+	if err != nil {
+		return nil, "", "", err
+	}
+	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, k.config.KBPKI(), k.config.MDOps(), tlfName, t)
+	if err != nil {
+		return nil, "", "", err
+	}
+	fs, err = k.newFS(ctx, k.config, tlfHandle, "")
+	if err != nil {
+		if exitEarly, _ := libfs.FilterTLFEarlyExitError(
+			ctx, err, k.log, tlfHandle.GetCanonicalName()); exitEarly {
+			return nil, "", "", libfs.TlfDoesNotExist{}
+		}
+		return nil, "", "", err
+	}
+	// Report paths using the same TLF name the caller used.
+	ps := strings.SplitN(strings.TrimPrefix(stdpath.Clean(path.Kbfs()), "/"), "/", 3)
+	tlfPrefix = "/" + stdpath.Join(ps[0], ps[1])
+	return fs, tlfPrefix, stdpath.Join(restOfPath, finalElem), nil
+}
+
+// getTrashFS is like getTLFRootFS, but the returned file system is
+// allowed to access the trash directory.  KBFS reserves names
+// starting with ".kbfs", and libfs creates parent directories even
+// when just opening a file, so every trash access needs an explicit
+// exception.
+func (k *SimpleFS) getTrashFS(ctx context.Context, path keybase1.Path) (
+	fs billy.Filesystem, tlfPrefix, pathInTlf string, err error) {
+	ctx = context.WithValue(ctx, libkbfs.CtxAllowNameKey, trashDirName)
+	return k.getTLFRootFS(ctx, path)
+}
+
+func makeTrashID(now time.Time) (string, error) {
+	var buf [4]byte
+	err := kbfscrypto.RandRead(buf[:])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", now.UnixNano(), hex.EncodeToString(buf[:])), nil
+}
+
+func (k *SimpleFS) moveToTrash(
+	ctx context.Context, path keybase1.Path, retention time.Duration) error {
+	fs, _, pathInTlf, err := k.getTrashFS(ctx, path)
+	if err != nil {
+		return err
+	}
+	if pathInTlf == "" || pathInTlf == "." {
+		return errInvalidRemotePath
+	}
+	if pathInTlf == trashDirName ||
+		strings.HasPrefix(pathInTlf, trashDirName+"/") {
+		// Things already in the trash are deleted for real.
+		return util.RemoveAll(fs, pathInTlf)
+	}
+	// Make sure the entry exists before recording it.
+	if _, err := fs.Lstat(pathInTlf); err != nil {
+		return err
+	}
+	// Listing the trash purges its expired entries; do that here
+	// too, so the trash doesn't keep growing in TLFs where nobody
+	// ever lists it.
+	if _, err := k.listTrash(ctx, fs, ""); err != nil {
+		return err
+	}
+
+	now := k.config.Clock().Now()
+	id, err := makeTrashID(now)
+	if err != nil {
+		return err
+	}
+	info := trashInfo{
+		Path:    pathInTlf,
+		Deleted: now,
+		Expires: now.Add(retention),
+	}
+	infoBytes, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	err = fs.MkdirAll(trashDirName, 0755)
+	if err != nil {
+		return err
+	}
+	err = util.WriteFile(
+		fs, stdpath.Join(trashDirName, id+trashInfoSuffix), infoBytes, 0600)
+	if err != nil {
+		return err
+	}
+	k.log.CDebugf(ctx, "Moving %s to trash as %s", pathInTlf, id)
+	return fs.Rename(pathInTlf, stdpath.Join(trashDirName, id))
+}
+
+func readTrashInfo(fs billy.Filesystem, id string) (info trashInfo, err error) {
+	f, err := fs.Open(stdpath.Join(trashDirName, id+trashInfoSuffix))
+	if err != nil {
+		return trashInfo{}, err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return trashInfo{}, err
+	}
+	err = json.Unmarshal(buf, &info)
+	return info, err
+}
+
+func removeTrashEntry(fs billy.Filesystem, id string) error {
+	err := util.RemoveAll(fs, stdpath.Join(trashDirName, id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = fs.Remove(stdpath.Join(trashDirName, id+trashInfoSuffix))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// listTrash returns all the unexpired trash entries of the TLF
+// rooted at `fs`, permanently deleting any expired ones it finds
+// along the way.
+func (k *SimpleFS) listTrash(
+	ctx context.Context, fs billy.Filesystem, tlfPrefix string) (
+	[]TrashEntry, error) {
+	fis, err := fs.ReadDir(trashDirName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := k.config.Clock().Now()
+	var res []TrashEntry
+	for _, fi := range fis {
+		if strings.HasSuffix(fi.Name(), trashInfoSuffix) {
+			continue
+		}
+		id := fi.Name()
+		info, err := readTrashInfo(fs, id)
+		if err != nil {
+			k.log.CDebugf(ctx, "Couldn't read trash info for %s: %+v", id, err)
+			continue
+		}
+		if !now.Before(info.Expires) {
+			k.log.CDebugf(ctx, "Purging expired trash entry %s", id)
+			err = removeTrashEntry(fs, id)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var de keybase1.Dirent
+		err = setStat(&de, fi)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, TrashEntry{
+			ID: id,
+			Path: keybase1.NewPathWithKbfs(
+				stdpath.Join(tlfPrefix, info.Path)),
+			DirentType: de.DirentType,
+			Size:       de.Size,
+			Deleted:    keybase1.ToTime(info.Deleted),
+			Expires:    keybase1.ToTime(info.Expires),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Deleted < res[j].Deleted
+	})
+	return res, nil
+}
+
+// SimpleFSListTrash - List the trash entries of the TLF containing
+// the given path.  Expired entries are permanently deleted, here and
+// whenever another entry in the same TLF is moved to the trash.
+func (k *SimpleFS) SimpleFSListTrash(
+	ctx context.Context, path keybase1.Path) (res []TrashEntry, err error) {
+	ctx, err = k.startSyncOp(ctx, "ListTrash", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fs, tlfPrefix, _, err := k.getTrashFS(ctx, path)
+	switch err.(type) {
+	case nil:
+	case libfs.TlfDoesNotExist:
+		return nil, nil
+	default:
+		return nil, err
+	}
+	return k.listTrash(ctx, fs, tlfPrefix)
+}
+
+// SimpleFSRestoreTrash - Move a trash entry back to where it was
+// removed from.  Fails if something new exists at that path.
+func (k *SimpleFS) SimpleFSRestoreTrash(
+	ctx context.Context, arg SimpleFSRestoreTrashArg) (err error) {
+	ctx, err = k.startSyncOp(ctx, "RestoreTrash", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fs, _, _, err := k.getTrashFS(ctx, arg.Path)
+	if err != nil {
+		return err
+	}
+	if arg.ID == "" || strings.Contains(arg.ID, "/") {
+		return errNoSuchTrashEntry
+	}
+	info, err := readTrashInfo(fs, arg.ID)
+	if os.IsNotExist(err) {
+		return errNoSuchTrashEntry
+	} else if err != nil {
+		return err
+	}
+	_, err = fs.Lstat(info.Path)
+	switch {
+	case err == nil:
+		return os.ErrExist
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	k.log.CDebugf(ctx, "Restoring trash entry %s to %s", arg.ID, info.Path)
+	err = fs.Rename(stdpath.Join(trashDirName, arg.ID), info.Path)
+	if err != nil {
+		return err
+	}
+	return fs.Remove(stdpath.Join(trashDirName, arg.ID+trashInfoSuffix))
+}
+
+// SimpleFSPurgeTrash - Permanently delete entries from the trash of
+// the TLF containing the given path.
+func (k *SimpleFS) SimpleFSPurgeTrash(
+	ctx context.Context, arg SimpleFSPurgeTrashArg) (err error) {
+	ctx, err = k.startSyncOp(ctx, "PurgeTrash", arg)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fs, _, _, err := k.getTrashFS(ctx, arg.Path)
+	switch err.(type) {
+	case nil:
+	case libfs.TlfDoesNotExist:
+		return nil
+	default:
+		return err
+	}
+
+	if len(arg.IDs) == 0 {
+		err = util.RemoveAll(fs, trashDirName)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, id := range arg.IDs {
+		if id == "" || strings.Contains(id, "/") {
+			return errNoSuchTrashEntry
+		}
+		err = removeTrashEntry(fs, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package testutil has test helpers that are shared by packages which
+// can't depend on each other, like the sync engines that work on any
+// billy.Filesystem.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+// ChangeFS is an osfs that also implements billy.Change, so that it
+// keeps modes and times like a KBFS filesystem does.  Ownership
+// changes are ignored, as they are in KBFS.
+type ChangeFS struct {
+	billy.Filesystem
+	root string
+}
+
+var _ billy.Change = ChangeFS{}
+
+// NewChangeFS returns a ChangeFS rooted at the local directory `root`.
+func NewChangeFS(root string) ChangeFS {
+	return ChangeFS{osfs.New(root), root}
+}
+
+// Chmod implements the billy.Change interface for ChangeFS.
+func (fs ChangeFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(filepath.Join(fs.root, name), mode)
+}
+
+// Lchown implements the billy.Change interface for ChangeFS.
+func (fs ChangeFS) Lchown(name string, uid, gid int) error {
+	return nil
+}
+
+// Chown implements the billy.Change interface for ChangeFS.
+func (fs ChangeFS) Chown(name string, uid, gid int) error {
+	return nil
+}
+
+// Chtimes implements the billy.Change interface for ChangeFS.
+func (fs ChangeFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(filepath.Join(fs.root, name), atime, mtime)
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package tlf
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// aliasRegex matches valid alias names.  Aliases can't contain dots,
+// so they never look like subteam names.
+var aliasRegex = regexp.MustCompile("^[a-z0-9_-]+$")
+
+// Alias is a user-defined short name for a team folder.
+type Alias struct {
+	Name   string
+	Target CanonicalName
+}
+
+type aliasList []Alias
+
+func (l aliasList) Len() int {
+	return len(l)
+}
+
+func (l aliasList) Less(i, j int) bool {
+	return l[i].Name < l[j].Name
+}
+
+func (l aliasList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+// AliasTable holds the user-defined aliases for team folders on this
+// device, e.g. "work" for the team folder "acme.eng".  Aliases are
+// resolved locally, and never sent to the server.
+type AliasTable struct {
+	lock    sync.RWMutex
+	aliases map[string]CanonicalName
+}
+
+// NewAliasTable returns a new, empty AliasTable.
+func NewAliasTable() *AliasTable {
+	return &AliasTable{aliases: make(map[string]CanonicalName)}
+}
+
+// CheckAlias returns an error if `alias` can't be used as an alias
+// for the team folder `target`.
+func CheckAlias(alias string, target CanonicalName) error {
+	if !aliasRegex.MatchString(alias) {
+		return errors.WithStack(BadAliasError{alias})
+	}
+	if target == "" || string(target) == alias {
+		return errors.WithStack(BadNameError{string(target)})
+	}
+	return nil
+}
+
+// Lookup returns the team folder the given alias refers to, if any.
+func (t *AliasTable) Lookup(alias string) (CanonicalName, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	target, ok := t.aliases[alias]
+	return target, ok
+}
+
+// Set makes `alias` refer to the team folder `target`, replacing any
+// existing alias with that name.
+func (t *AliasTable) Set(alias string, target CanonicalName) error {
+	err := CheckAlias(alias, target)
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.aliases[alias] = target
+	return nil
+}
+
+// Remove deletes the given alias, if it exists.
+func (t *AliasTable) Remove(alias string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.aliases, alias)
+}
+
+// Aliases returns all the aliases in the table, sorted by name.
+func (t *AliasTable) Aliases() []Alias {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	aliases := make([]Alias, 0, len(t.aliases))
+	for name, target := range t.aliases {
+		aliases = append(aliases, Alias{name, target})
+	}
+	sort.Sort(aliasList(aliases))
+	return aliases
+}
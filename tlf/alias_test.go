@@ -0,0 +1,38 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package tlf
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliasTable(t *testing.T) {
+	at := NewAliasTable()
+	require.NoError(t, at.Set("work", "acme.eng"))
+	require.NoError(t, at.Set("home", "family"))
+
+	target, ok := at.Lookup("work")
+	require.True(t, ok)
+	require.Equal(t, CanonicalName("acme.eng"), target)
+	require.Equal(t, []Alias{{"home", "family"}, {"work", "acme.eng"}},
+		at.Aliases())
+
+	at.Remove("work")
+	_, ok = at.Lookup("work")
+	require.False(t, ok)
+
+	for _, alias := range []string{"", "Work", "acme.eng", "a,b", "a b"} {
+		err := at.Set(alias, "acme.eng")
+		_, ok := errors.Cause(err).(BadAliasError)
+		require.True(t, ok, "alias=%q, err=%+v", alias, err)
+	}
+	err := at.Set("work", "")
+	require.IsType(t, BadNameError{}, errors.Cause(err))
+	err = at.Set("work", "work")
+	require.IsType(t, BadNameError{}, errors.Cause(err))
+}
@@ -40,3 +40,14 @@ type BadNameError struct {
 func (e BadNameError) Error() string {
 	return fmt.Sprintf("TLF name %s is in an incorrect format", e.Name)
 }
+
+// BadAliasError indicates that a folder alias name has an incorrect
+// format.
+type BadAliasError struct {
+	Alias string
+}
+
+// Error implements the error interface for BadAliasError.
+func (e BadAliasError) Error() string {
+	return fmt.Sprintf("Folder alias %q is in an incorrect format", e.Alias)
+}
@@ -34,6 +34,8 @@ const (
 	handleExtensionConflictString = "conflicted copy"
 	// HandleExtensionFinalizedString is the format string identifying a finalized extension.
 	handleExtensionFinalizedString = "files before %saccount reset"
+	// HandleExtensionSnapshotString is the format string identifying a snapshot extension.
+	handleExtensionSnapshotString = "snapshot of revision %s"
 	// HandleExtensionFormat is the formate string for a HandleExtension.
 	handleExtensionFormat = "(%s %s%s)"
 	// HandleExtensionStaticTestDate is a static date used for tests (2016-03-14).
@@ -50,6 +52,9 @@ const (
 	// HandleExtensionFinalized means the folder ended up with no more valid writers as
 	// a result of an account reset.
 	HandleExtensionFinalized
+	// HandleExtensionSnapshot means the handle names a read-only view
+	// of the folder as of a particular revision.
+	HandleExtensionSnapshot
 	// HandleExtensionUnknown means the type is unknown.
 	HandleExtensionUnknown
 )
@@ -59,8 +64,15 @@ var handleExtensionFinalizedStringRegex = fmt.Sprintf(
 	handleExtensionFinalizedString, "(?:"+handleExtensionUsernameRegex+"[\\s]+)*",
 )
 
+// HandleExtensionSnapshotStringRegex is the regex identifying a snapshot extension string.
+var handleExtensionSnapshotStringRegex = fmt.Sprintf(
+	handleExtensionSnapshotString, handleExtensionNumberRegex,
+)
+
 // HandleExtensionTypeRegex is the regular expression matching the HandleExtension string.
-var handleExtensionTypeRegex = handleExtensionConflictString + "|" + handleExtensionFinalizedStringRegex
+var handleExtensionTypeRegex = handleExtensionConflictString + "|" +
+	handleExtensionFinalizedStringRegex + "|" +
+	handleExtensionSnapshotStringRegex
 
 // HandleExtensionFinalizedRegex is the compiled regular expression matching a finalized
 // handle extension.
@@ -68,6 +80,13 @@ var handleExtensionFinalizedRegex = regexp.MustCompile(
 	fmt.Sprintf(handleExtensionFinalizedString, "(?:("+handleExtensionUsernameRegex+")[\\s]+)*"),
 )
 
+// HandleExtensionSnapshotRegex is the compiled regular expression
+// matching a snapshot handle extension.
+var handleExtensionSnapshotRegex = regexp.MustCompile(
+	"^" + fmt.Sprintf(handleExtensionSnapshotString,
+		"("+handleExtensionNumberRegex+")") + "$",
+)
+
 // String implements the fmt.Stringer interface for HandleExtensionType
 func (et HandleExtensionType) String(username libkb.NormalizedUsername) string {
 	switch et {
@@ -82,16 +101,29 @@ func (et HandleExtensionType) String(username libkb.NormalizedUsername) string {
 	return "<unknown extension type>"
 }
 
-// parseHandleExtensionString parses an extension type and optional username from a string.
-func parseHandleExtensionString(s string) (HandleExtensionType, libkb.NormalizedUsername) {
+// parseHandleExtensionString parses an extension type, and an
+// optional username or snapshot revision, from a string.
+func parseHandleExtensionString(s string) (
+	HandleExtensionType, libkb.NormalizedUsername, int64, error) {
 	if handleExtensionConflictString == s {
-		return HandleExtensionConflict, ""
+		return HandleExtensionConflict, "", 0, nil
+	}
+	if m := handleExtensionSnapshotRegex.FindStringSubmatch(s); len(m) == 2 {
+		rev, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return HandleExtensionUnknown, "", 0, err
+		}
+		if rev < 1 {
+			return HandleExtensionUnknown, "", 0,
+				errHandleExtensionInvalidRevision
+		}
+		return HandleExtensionSnapshot, "", rev, nil
 	}
 	m := handleExtensionFinalizedRegex.FindStringSubmatch(s)
 	if len(m) < 2 {
-		return HandleExtensionUnknown, ""
+		return HandleExtensionUnknown, "", 0, nil
 	}
-	return HandleExtensionFinalized, libkb.NewNormalizedUsername(m[1])
+	return HandleExtensionFinalized, libkb.NewNormalizedUsername(m[1]), 0, nil
 }
 
 // ErrHandleExtensionInvalidString is returned when a given string is not parsable as a
@@ -103,6 +135,10 @@ var errHandleExtensionInvalidString = errors.New("Invalid TLF handle extension s
 // passed to NewHandleExtension must be >0.
 var errHandleExtensionInvalidNumber = errors.New("Invalid TLF handle extension number")
 
+// ErrHandleExtensionInvalidRevision is returned when a snapshot
+// extension names a revision < 1.
+var errHandleExtensionInvalidRevision = errors.New("Invalid TLF handle extension revision")
+
 // HandleExtensionRegex is the compiled regular expression matching a valid combination
 // of TLF handle extensions in string form.
 var handleExtensionRegex = regexp.MustCompile(
@@ -118,9 +154,21 @@ type HandleExtension struct {
 	Number   uint16                   `codec:"num"`
 	Type     HandleExtensionType      `codec:"type"`
 	Username libkb.NormalizedUsername `codec:"un,omitempty"`
+	// Revision is the revision a snapshot extension refers to.
+	Revision int64 `codec:"rev,omitempty"`
 	codec.UnknownFieldSetHandler
 }
 
+// typeString returns the string identifying the type of this
+// extension, including any username or revision it carries.
+func (e HandleExtension) typeString() string {
+	if e.Type == HandleExtensionSnapshot {
+		return fmt.Sprintf(handleExtensionSnapshotString,
+			strconv.FormatInt(e.Revision, 10))
+	}
+	return e.Type.String(e.Username)
+}
+
 // String implements the fmt.Stringer interface for HandleExtension.
 // Ex: "(conflicted copy 2016-05-09 #2)"
 func (e HandleExtension) string(isBackedByTeam bool) string {
@@ -132,11 +180,12 @@ func (e HandleExtension) string(isBackedByTeam bool) string {
 		// use the "#1" suffix, unlike for older TLFs.
 		minNumberSuffixToShow = 1
 	}
-	if e.Number >= minNumberSuffixToShow {
+	// Snapshots are already uniquely identified by their revision.
+	if e.Number >= minNumberSuffixToShow && e.Type != HandleExtensionSnapshot {
 		num = " #"
 		num += strconv.FormatUint(uint64(e.Number), 10)
 	}
-	return fmt.Sprintf(handleExtensionFormat, e.Type.String(e.Username), date, num)
+	return fmt.Sprintf(handleExtensionFormat, e.typeString(), date, num)
 }
 
 // String implements the fmt.Stringer interface for HandleExtension.
@@ -152,6 +201,22 @@ func NewHandleExtension(extType HandleExtensionType, num uint16, un libkb.Normal
 	return newHandleExtension(extType, num, un, now)
 }
 
+// NewSnapshotHandleExtension returns a new HandleExtension struct
+// naming a read-only snapshot of a folder at the given revision,
+// which was made at the given time.
+func NewSnapshotHandleExtension(rev int64, revTime time.Time) (
+	*HandleExtension, error) {
+	if rev < 1 {
+		return nil, errHandleExtensionInvalidRevision
+	}
+	e, err := newHandleExtension(HandleExtensionSnapshot, 1, "", revTime)
+	if err != nil {
+		return nil, err
+	}
+	e.Revision = rev
+	return e, nil
+}
+
 // NewTestHandleExtensionStaticTime returns a new HandleExtension struct populated with
 // a static date for testing.
 func NewTestHandleExtensionStaticTime(extType HandleExtensionType, num uint16, un libkb.NormalizedUsername) (
@@ -185,7 +250,10 @@ func parseHandleExtension(fields []string) (*HandleExtension, error) {
 	if len(fields) != 4 {
 		return nil, errHandleExtensionInvalidString
 	}
-	extType, un := parseHandleExtensionString(fields[1])
+	extType, un, rev, err := parseHandleExtensionString(fields[1])
+	if err != nil {
+		return nil, err
+	}
 	if extType == HandleExtensionUnknown {
 		return nil, errHandleExtensionInvalidString
 	}
@@ -208,13 +276,14 @@ func parseHandleExtension(fields []string) (*HandleExtension, error) {
 		Number:   uint16(num),
 		Type:     extType,
 		Username: un,
+		Revision: rev,
 	}, nil
 }
 
 // ParseHandleExtensionSuffix parses a TLF handle extension suffix string.
 func ParseHandleExtensionSuffix(s string) ([]HandleExtension, error) {
-	exts := handleExtensionRegex.FindAllStringSubmatch(s, 2)
-	if len(exts) < 1 || len(exts) > 2 {
+	exts := handleExtensionRegex.FindAllStringSubmatch(s, 3)
+	if len(exts) < 1 || len(exts) > 3 {
 		return nil, errHandleExtensionInvalidString
 	}
 	extMap := make(map[HandleExtensionType]bool)
@@ -274,6 +343,18 @@ func (l HandleExtensionList) Splat() (ci, fi *HandleExtension) {
 	return ci, fi
 }
 
+// Snapshot returns the snapshot extension in the list, or nil if
+// there isn't one.
+func (l HandleExtensionList) Snapshot() *HandleExtension {
+	for _, extension := range l {
+		if extension.Type == HandleExtensionSnapshot {
+			tmp := extension
+			return &tmp
+		}
+	}
+	return nil
+}
+
 // Suffix outputs a suffix string for this extension list.
 func (l HandleExtensionList) Suffix() string {
 	return newHandleExtensionSuffix(l, false)
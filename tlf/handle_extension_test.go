@@ -5,6 +5,7 @@
 package tlf
 
 import (
+	"sort"
 	"testing"
 	"time"
 
@@ -124,6 +125,19 @@ func TestHandleExtensionKnownTime(t *testing.T) {
 	if e3.String() != expect {
 		t.Fatalf("Expected %s, got: %s", expect, e3)
 	}
+	e4 := &HandleExtension{
+		Date:     1462838400,
+		Number:   1,
+		Type:     HandleExtensionSnapshot,
+		Revision: 1234,
+	}
+	expect = "(snapshot of revision 1234 2016-05-10)"
+	if e4.String() != expect {
+		t.Fatalf("Expected %s, got: %s", expect, e4)
+	}
+	if e4.string(true) != expect {
+		t.Fatalf("Expected %s, got: %s", expect, e4.string(true))
+	}
 }
 
 func TestHandleExtensionErrors(t *testing.T) {
@@ -147,6 +161,14 @@ func TestHandleExtensionErrors(t *testing.T) {
 	if err != errHandleExtensionInvalidString {
 		t.Fatalf("Expected errHandleExtensionInvalidString, got: %v", err)
 	}
+	_, err = NewSnapshotHandleExtension(0, time.Now())
+	if err != errHandleExtensionInvalidRevision {
+		t.Fatalf("Expected errHandleExtensionInvalidRevision, got: %v", err)
+	}
+	_, err = ParseHandleExtensionSuffix("(snapshot of revision 0 2016-05-10)")
+	if err != errHandleExtensionInvalidRevision {
+		t.Fatalf("Expected errHandleExtensionInvalidRevision, got: %v", err)
+	}
 }
 
 type tlfHandleExtensionFuture struct {
@@ -170,6 +192,7 @@ func TestHandleExtensionUnknownFields(t *testing.T) {
 				2,
 				HandleExtensionFinalized,
 				"",
+				0,
 				codec.UnknownFieldSetHandler{},
 			},
 			kbfscodec.MakeExtraOrBust("HandleExtension", t),
@@ -251,3 +274,48 @@ func TestHandleExtensionMultipleSingleUser(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleExtensionSnapshot(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+	e, err := NewSnapshotHandleExtension(
+		42, time.Unix(HandleExtensionStaticTestDate, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := NewTestHandleExtensionStaticTime(HandleExtensionConflict, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exts := HandleExtensionList{*e, *e2}
+	sort.Sort(exts)
+	suffix := exts.Suffix()
+	expectSuffix := " (conflicted copy 2016-03-14 #2) (snapshot of revision 42 2016-03-14)"
+	if suffix != expectSuffix {
+		t.Fatalf("Expected suffix '%s', got: '%s'", expectSuffix, suffix)
+	}
+	exts2, err := ParseHandleExtensionSuffix(suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := HandleExtensionList(exts2).Snapshot()
+	if snapshot == nil {
+		t.Fatal("Expected a snapshot extension")
+	}
+	if snapshot.Revision != 42 {
+		t.Fatalf("Expected revision 42, got: %d", snapshot.Revision)
+	}
+
+	// Check that the revision survives encoding/decoding.
+	buf, err := codec.Encode(*snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e3 HandleExtension
+	err = codec.Decode(buf, &e3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e3.String() != e.String() {
+		t.Fatalf("Expected %s, got: %s", e, e3)
+	}
+}